@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// programName is the name of the binary in usage messages, server in the
+// Docker image
+var programName = filepath.Base(os.Args[0])
+
+// Exit codes of administration commands
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// errUsage is returned by commands called with invalid arguments; the usage
+// of the command is printed
+var errUsage = errors.New("invalid arguments")
+
+// command is an administration task run from the command line, e.g. from cron
+// or CI, instead of the web UI. Commands open the database in DATA_DIR, or
+// DATABASE_URL. Exclusive commands replace or restructure the SQLite database
+// and refuse to run while the server uses the data directory; they apply
+// pending migrations first. The others can run next to the server and open the
+// database as it is, read-only unless they write to it.
+type command struct {
+	name      string
+	usage     string
+	summary   string
+	run       func(env *commandEnv, args []string) error
+	exclusive bool
+	readOnly  bool
+}
+
+// commandEnv is what commands work with
+type commandEnv struct {
+	dataDir   string
+	dbService *services.DBService
+	logger    *services.Logger
+	stdout    io.Writer
+}
+
+var commands = []command{
+	{"backup", "backup", "Create a backup in DATA_DIR/backups and print its filename", runBackup, false, true},
+	{"restore", "restore <file>", "Restore a backup by filename or path; stop the server first", runRestore, true, false},
+	{"export", "export [--format=csv|datev|saft] [--from=<date>] [--to=<date>] [--output=<file>]", "Export the invoices of a period, all by default, to stdout by default", runExport, false, true},
+	{"user", "user create --username=<name> [--subject=<id>] [--source=proxy|oidc] [--email=<email>] [--name=<name>]", "Create a user ahead of their first sign-in", runUser, false, false},
+	{"migrate", "migrate", "Apply pending database migrations and exit; stop the server first", runMigrate, true, false},
+}
+
+// usage prints the server flags and the administration commands
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags]            start the server\n", programName)
+	fmt.Fprintf(out, "       %s <command> [args]   run an administration command\n\nFlags:\n", programName)
+	flag.PrintDefaults()
+	fmt.Fprintln(out, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %s\n        %s\n", cmd.usage, cmd.summary)
+	}
+}
+
+// runCommand runs the administration command named by args[0] and returns the
+// process exit code
+func runCommand(args []string, dataDir string, logger *services.Logger) int {
+	return runCommandTo(args, dataDir, logger, os.Stdout, os.Stderr)
+}
+
+func runCommandTo(args []string, dataDir string, logger *services.Logger, stdout, stderr io.Writer) int {
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == args[0] {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "Unknown command %q\n\n", args[0])
+		flag.CommandLine.SetOutput(stderr)
+		usage()
+		return exitUsage
+	}
+
+	if cmd.exclusive {
+		lock, err := services.AcquireInstanceLock(dataDir)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v; stop the server first\n", cmd.name, err)
+			return exitError
+		}
+		defer lock.Release()
+	}
+
+	var dbService *services.DBService
+	var err error
+	if cmd.exclusive {
+		dbService, err = services.NewDBService(dataDir, logger)
+	} else {
+		dbService, err = services.OpenDBService(dataDir, logger, cmd.readOnly)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: failed to open the database: %v\n", cmd.name, err)
+		return exitError
+	}
+	defer dbService.Close()
+
+	env := &commandEnv{dataDir: dataDir, dbService: dbService, logger: logger, stdout: stdout}
+	err = cmd.run(env, args[1:])
+	switch {
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(stderr, "%s: %v\nUsage: %s %s\n", cmd.name, err, programName, cmd.usage)
+		return exitUsage
+	case err != nil:
+		fmt.Fprintf(stderr, "%s: %v\n", cmd.name, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// newFlagSet returns a flag set for a command that reports errors instead of
+// exiting
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	return flags
+}
+
+// parseFlags parses a command's flags and rejects more than maxArgs positional arguments
+func parseFlags(flags *flag.FlagSet, args []string, maxArgs int) error {
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if flags.NArg() > maxArgs {
+		return fmt.Errorf("%w: unexpected argument %q", errUsage, flags.Arg(maxArgs))
+	}
+	return nil
+}
+
+func runBackup(env *commandEnv, args []string) error {
+	if err := parseFlags(newFlagSet("backup"), args, 0); err != nil {
+		return err
+	}
+
+	backupService, err := services.NewBackupService(env.dbService.GetDB(), env.dataDir, env.logger)
+	if err != nil {
+		return err
+	}
+	filename, err := backupService.CreateBackup()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(env.stdout, filename)
+	return nil
+}
+
+func runRestore(env *commandEnv, args []string) error {
+	flags := newFlagSet("restore")
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("%w: the backup file is required", errUsage)
+	}
+
+	backupService, err := services.NewBackupService(env.dbService.GetDB(), env.dataDir, env.logger)
+	if err != nil {
+		return err
+	}
+
+	// Backups in the backup directory can be named by filename, other files
+	// are copied there first
+	filename := flags.Arg(0)
+	if _, err := os.Stat(filename); err == nil {
+		if filename, err = backupService.AddBackup(filename); err != nil {
+			return err
+		}
+	}
+
+	if err := backupService.RestoreBackup(filename); err != nil {
+		return err
+	}
+	// Reopening applies migrations to a backup of an older version
+	if err := env.dbService.ReopenConnection(); err != nil {
+		return fmt.Errorf("backup restored but failed to reopen the database: %w", err)
+	}
+	backupService.SetReopened()
+
+	fmt.Fprintf(env.stdout, "Restored %s\n", filename)
+	return nil
+}
+
+func runExport(env *commandEnv, args []string) error {
+	flags := newFlagSet("export")
+	format := flags.String("format", services.ExportFormatCSV, "Export format")
+	from := flags.String("from", "", "First issue or payment date to export, YYYY-MM-DD")
+	to := flags.String("to", "", "Last issue or payment date to export, YYYY-MM-DD")
+	output := flags.String("output", "", "File to write instead of stdout")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	if !slices.Contains(services.ExportFormats, *format) {
+		return fmt.Errorf("%w: unsupported format %q, expected %s", errUsage, *format, strings.Join(services.ExportFormats, ", "))
+	}
+	var period services.ExportPeriod
+	for _, date := range []struct {
+		value string
+		t     *time.Time
+	}{{*from, &period.From}, {*to, &period.To}} {
+		if date.value == "" {
+			continue
+		}
+		var err error
+		if *date.t, err = time.Parse("2006-01-02", date.value); err != nil {
+			return fmt.Errorf("%w: invalid date %q, expected YYYY-MM-DD", errUsage, date.value)
+		}
+	}
+	if err := services.ValidateExportPeriod(*format, period); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	exportService := services.NewExportService(env.dbService, services.NewSettingsService(env.dbService, env.logger), env.logger)
+	if *output == "" {
+		_, err := exportService.ExportInvoices(env.stdout, *format, period)
+		return err
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", *output, err)
+	}
+	count, err := exportService.ExportInvoices(file, *format, period)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write %s: %w", *output, closeErr)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.stdout, "Exported %d invoices to %s\n", count, *output)
+	return nil
+}
+
+func runUser(env *commandEnv, args []string) error {
+	if len(args) == 0 || args[0] != "create" {
+		return fmt.Errorf("%w: expected user create", errUsage)
+	}
+
+	flags := newFlagSet("user create")
+	username := flags.String("username", "", "Username shown in the application")
+	subject := flags.String("subject", "", "Remote-User header value or OIDC sub claim, the username by default for proxy users")
+	source := flags.String("source", "", "proxy or oidc, AUTH_MODE by default")
+	email := flags.String("email", "", "Email address")
+	name := flags.String("name", "", "Full name")
+	if err := parseFlags(flags, args[1:], 0); err != nil {
+		return err
+	}
+
+	if *source == "" {
+		*source = strings.ToLower(strings.TrimSpace(os.Getenv("AUTH_MODE")))
+		if *source != services.AuthModeOIDC {
+			*source = services.AuthModeProxy
+		}
+	}
+	if *subject == "" && *source == services.AuthModeProxy {
+		*subject = *username
+	}
+	if *username == "" {
+		return fmt.Errorf("%w: --username is required", errUsage)
+	}
+	if *subject == "" {
+		return fmt.Errorf("%w: --subject is required for OIDC users", errUsage)
+	}
+
+	authService, err := services.NewAuthService(env.dbService, env.logger)
+	if err != nil {
+		return err
+	}
+	user, err := authService.CreateUser(*source, *subject, *username, *email, *name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.stdout, "Created %s user %s (ID %d)\n", user.AuthSource, user.Username, user.ID)
+	return nil
+}
+
+func runMigrate(env *commandEnv, args []string) error {
+	if err := parseFlags(newFlagSet("migrate"), args, 0); err != nil {
+		return err
+	}
+	// Opening the database applied the migrations
+	fmt.Fprintln(env.stdout, "Database is up to date")
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+func TestRunCommand(t *testing.T) {
+	dataDir := t.TempDir()
+	logger := services.NewLogger(services.ERROR)
+
+	run := func(args ...string) (int, string, string) {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		code := runCommandTo(args, dataDir, logger, &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	if code, out, _ := run("migrate"); code != exitOK || !strings.Contains(out, "up to date") {
+		t.Fatalf("migrate exited with %d: %s", code, out)
+	}
+
+	code, out, _ := run("backup")
+	backup := strings.TrimSpace(out)
+	if code != exitOK {
+		t.Fatalf("backup exited with %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "backups", backup)); err != nil {
+		t.Errorf("Expected backup %q: %v", backup, err)
+	}
+
+	if code, out, errOut := run("user", "create", "--username=jane", "--email=jane@example.com"); code != exitOK || !strings.Contains(out, "proxy user jane") {
+		t.Errorf("user create exited with %d: %s%s", code, out, errOut)
+	}
+	if code, _, errOut := run("user", "create", "--username=jane"); code != exitError || !strings.Contains(errOut, "already exists") {
+		t.Errorf("Expected creating jane again to fail, got %d: %s", code, errOut)
+	}
+
+	if code, out, _ := run("export", "--format=csv"); code != exitOK || !strings.HasPrefix(out, "invoice_number,") {
+		t.Errorf("export exited with %d: %s", code, out)
+	}
+	if code, _, _ := run("export", "--format=xml"); code != exitUsage {
+		t.Errorf("Expected an unsupported format to be a usage error, got %d", code)
+	}
+	if code, out, _ := run("export", "--format=datev", "--from=2024-01-01", "--to=2024-12-31"); code != exitOK || !strings.HasPrefix(out, `"EXTF";700;`) {
+		t.Errorf("DATEV export exited with %d: %s", code, out)
+	}
+	if code, _, _ := run("export", "--format=saft"); code != exitUsage {
+		t.Errorf("Expected a SAF-T export without a period to be a usage error, got %d", code)
+	}
+
+	if code, out, errOut := run("restore", backup); code != exitOK || !strings.Contains(out, backup) {
+		t.Errorf("restore exited with %d: %s%s", code, out, errOut)
+	}
+	if code, _, _ := run("restore"); code != exitUsage {
+		t.Errorf("Expected restore without a file to be a usage error, got %d", code)
+	}
+
+	// While the server holds the data directory, commands that replace the
+	// database refuse to run and the others still work
+	lock, err := services.AcquireInstanceLock(dataDir)
+	if err != nil {
+		t.Fatalf("AcquireInstanceLock failed: %v", err)
+	}
+	if code, _, errOut := run("restore", backup); code != exitError || !strings.Contains(errOut, "stop the server first") {
+		t.Errorf("Expected restore to fail while the data directory is locked, got %d: %s", code, errOut)
+	}
+	if code, _, _ := run("export", "--format=csv"); code != exitOK {
+		t.Errorf("Expected export to work while the data directory is locked, got %d", code)
+	}
+	lock.Release()
+
+	// Commands next to the server leave the database files alone
+	journal := filepath.Join(dataDir, "database.db-wal")
+	if err := os.WriteFile(journal, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, _ := run("backup"); code != exitOK {
+		t.Errorf("backup exited with %d", code)
+	}
+	if _, err := os.Stat(journal); err != nil {
+		t.Errorf("Expected backup to keep the WAL file: %v", err)
+	}
+	os.Remove(journal)
+
+	var stderr bytes.Buffer
+	if code := runCommandTo([]string{"export"}, t.TempDir(), logger, io.Discard, &stderr); code != exitError || !strings.Contains(stderr.String(), "run migrate first") {
+		t.Errorf("Expected export without a database to fail, got %d: %s", code, stderr.String())
+	}
+
+	if code, _, errOut := run("serve"); code != exitUsage || !strings.Contains(errOut, "Unknown command") {
+		t.Errorf("Expected an unknown command to be a usage error, got %d: %s", code, errOut)
+	}
+}
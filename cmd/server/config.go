@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// printConfig prints the effective value of every option and where it came
+// from, and returns exitError if any value is invalid. config is nil when no
+// config file is used.
+func printConfig(config *services.Config, stdout, stderr io.Writer) int {
+	values, err := config.Effective()
+
+	if config != nil {
+		fmt.Fprintf(stdout, "Config file: %s\n\n", config.Path)
+	}
+	table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "OPTION\tENVIRONMENT\tVALUE\tSOURCE")
+	for _, value := range values {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", value.Key, value.EnvVar, value.Value, value.Source)
+	}
+	table.Flush()
+	fmt.Fprintln(stdout, "\nSettings saved on the settings page take precedence over these values.")
+
+	if err != nil {
+		fmt.Fprintf(stderr, "Invalid configuration: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
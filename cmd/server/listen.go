@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// listen opens a listener for a listen address: host:port or :port for TCP,
+// or unix:/path/to/socket for a Unix domain socket. A socket left behind by a
+// previous run is replaced unless a server still accepts on it; the socket file is removed when the listener is
+// closed. Its permissions are set with LISTEN_SOCKET_MODE, 0660 by default,
+// so a reverse proxy in the same group can connect.
+func listen(addr string, logger *services.Logger) (net.Listener, error) {
+	network, address, err := services.ParseListenAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+
+	mode := os.FileMode(0660)
+	if value := os.Getenv("LISTEN_SOCKET_MODE"); value != "" {
+		if mode, err = services.ParseSocketMode(value); err != nil {
+			return nil, err
+		}
+	}
+
+	if info, err := os.Lstat(address); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", address)
+		}
+		if conn, err := net.Dial(network, address); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", address)
+		}
+		logger.Info("Removing stale socket %s", address)
+		if err := os.Remove(address); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(address, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return listener, nil
+}
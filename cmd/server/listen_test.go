@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+	logger := services.NewLogger(services.ERROR)
+	t.Setenv("LISTEN_SOCKET_MODE", "0600")
+	path := filepath.Join(t.TempDir(), "simple-invoice.sock")
+
+	listener, err := listen("unix:"+path, logger)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected a socket with mode 0600, got %v (%v)", info, err)
+	}
+	if _, err := listen("unix:"+path, logger); err == nil {
+		t.Error("Expected a socket in use to be refused")
+	}
+	listener.Close()
+
+	// A socket left behind by a crashed server is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to create socket: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	listener, err = listen("unix:"+path, logger)
+	if err != nil {
+		t.Fatalf("Expected the stale socket to be replaced: %v", err)
+	}
+	listener.Close()
+
+	file := filepath.Join(t.TempDir(), "data.db")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := listen("unix:"+file, logger); err == nil {
+		t.Error("Expected a file that is not a socket to be kept")
+	}
+}
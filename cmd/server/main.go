@@ -4,15 +4,18 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/0dragosh/simple-invoice/internal/handlers"
 	"github.com/0dragosh/simple-invoice/internal/services"
+	"google.golang.org/grpc"
 )
 
 var Version string
@@ -20,8 +23,32 @@ var Version string
 func main() {
 	// Parse command-line flags
 	resetDB := flag.Bool("reset-db", false, "Reset the database before starting")
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file; environment variables take precedence")
+	listenAddr := flag.String("listen", "", "Address to listen on, host:port or unix:/path/to/socket (default LISTEN_ADDR, or :PORT)")
+	pidFile := flag.String("pid-file", "", "File to write the process ID to (default PID_FILE)")
+	devMode := flag.Bool("dev", false, "Development mode: reload templates when they change and log every request")
+	checkConfig := flag.Bool("check-config", false, "Validate the configuration, print the effective values and exit")
+	flag.Usage = usage
 	flag.Parse()
 
+	// Options from the config file are set as environment variables that are
+	// not set already
+	var config *services.Config
+	if *configPath != "" {
+		var err error
+		if config, err = services.LoadConfigFile(*configPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+			os.Exit(exitError)
+		}
+		if err := config.Apply(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to apply configuration: %v\n", err)
+			os.Exit(exitError)
+		}
+	}
+	if *checkConfig {
+		os.Exit(printConfig(config, os.Stdout, os.Stderr))
+	}
+
 	// Get configuration from environment variables
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -33,27 +60,29 @@ func main() {
 		dataDir = "./data"
 	}
 
-	// Set up logging
-	logLevelStr := os.Getenv("LOG_LEVEL")
-	var logLevel services.LogLevel
-	switch strings.ToUpper(logLevelStr) {
-	case "DEBUG":
-		logLevel = services.DEBUG
-	case "INFO":
-		logLevel = services.INFO
-	case "WARN":
-		logLevel = services.WARN
-	case "ERROR":
-		logLevel = services.ERROR
-	case "FATAL":
-		logLevel = services.FATAL
-	default:
-		// Use DEBUG level by default for better diagnostics
-		logLevel = services.DEBUG
+	// Administration commands run instead of the server. They log warnings
+	// and errors to stderr by default, so their output can be piped.
+	if args := flag.Args(); len(args) > 0 {
+		logger := services.NewLogger(logLevelFromEnv(services.WARN))
+		logger.SetOutput(os.Stderr)
+		os.Exit(runCommand(args, dataDir, logger))
 	}
 
-	logger := services.NewLogger(logLevel)
-	logger.Info("Starting application with log level: %s", logLevelStr)
+	// Set up logging. The level saved on the settings page replaces LOG_LEVEL
+	// once the database is open.
+	logger := services.NewLogger(logLevelFromEnv(services.INFO))
+	logFile, err := services.OpenLogFileFromEnvironment()
+	if err != nil {
+		logger.Fatal("Failed to open log file: %v", err)
+	}
+	if logFile != nil {
+		logger.SetOutput(io.MultiWriter(os.Stdout, logFile))
+		defer logFile.Close()
+	}
+	logger.Info("Starting application with log level: %s", logger.Level())
+	if logFile != nil {
+		logger.Info("Writing the log to %s", logFile.Path())
+	}
 
 	// Set default version if not set during build
 	if Version == "" {
@@ -66,8 +95,19 @@ func main() {
 		logger.Fatal("Failed to create data directory: %v", err)
 	}
 
+	// Only one server may use the data directory; a second one fails here
+	// before it touches the database. PDFs and logos stay in the data
+	// directory with PostgreSQL too, which takes a lock of its own.
+	instanceLock, err := services.AcquireInstanceLock(dataDir)
+	if err != nil {
+		logger.Fatal("Cannot use data directory %s: %v", dataDir, err)
+	}
+	defer instanceLock.Release()
+
 	// Reset database if requested
-	if *resetDB {
+	if *resetDB && services.DatabaseURL() != "" {
+		logger.Warn("-reset-db only removes the SQLite database, the PostgreSQL database is left as it is")
+	} else if *resetDB {
 		logger.Warn("Database reset requested via command-line flag")
 		if err := services.RemoveDatabase(dataDir, logger); err != nil {
 			logger.Error("Failed to reset database: %v", err)
@@ -91,29 +131,140 @@ func main() {
 		}
 	}()
 
+	// Use the socket passed by systemd socket activation, otherwise listen on
+	// all interfaces on PORT unless an address or a Unix socket is given
+	listener, err := systemdListener(logger)
+	if err != nil {
+		logger.Fatal("%v", err)
+	}
+	if listener != nil {
+		*listenAddr = listener.Addr().String()
+		logger.Info("Using the socket passed by systemd")
+	} else {
+		if *listenAddr == "" {
+			*listenAddr = os.Getenv("LISTEN_ADDR")
+		}
+		if *listenAddr == "" {
+			*listenAddr = fmt.Sprintf(":%s", port)
+		}
+		if listener, err = listen(*listenAddr, logger); err != nil {
+			logger.Fatal("Failed to listen on %s: %v", *listenAddr, err)
+		}
+	}
+
+	if *pidFile == "" {
+		*pidFile = os.Getenv("PID_FILE")
+	}
+	if *pidFile != "" {
+		if err := writePIDFile(*pidFile); err != nil {
+			logger.Fatal("Failed to write PID file: %v", err)
+		}
+		defer os.Remove(*pidFile)
+	}
+
+	handler := appHandler.RecoverPanics(appHandler.LimitRequestBody(appHandler.RequireAuth(mux)))
+	accessLog, _ := strconv.ParseBool(os.Getenv("ACCESS_LOG"))
+	if *devMode {
+		logger.Warn("Development mode: templates are reloaded when they change and every request is logged")
+		appHandler.WatchTemplates()
+		accessLog = true
+	}
+	if accessLog {
+		handler = appHandler.LogRequests(handler)
+	}
+
 	// Create server with timeout settings
 	server := &http.Server{
-		Addr:         fmt.Sprintf(":%s", port),
-		Handler:      mux,
+		Addr:         *listenAddr,
+		Handler:      handler,
+		ConnContext:  services.UnixSocketConnContext,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Serve HTTPS with a certificate from files or Let's Encrypt if configured
+	tlsConfig, challengeHandler, err := newTLSConfig(dataDir, logger)
+	if err != nil {
+		logger.Fatal("Failed to set up TLS: %v", err)
+	}
+	server.TLSConfig = tlsConfig
+
+	// Event streams of open tabs would keep Shutdown waiting
+	server.RegisterOnShutdown(appHandler.CloseEventStreams)
+
+	// Let's Encrypt HTTP challenges and redirects to HTTPS
+	var challengeServer *http.Server
+	if addr := os.Getenv("TLS_HTTP_ADDR"); addr != "" {
+		challengeServer = &http.Server{
+			Addr:         addr,
+			Handler:      challengeHandler,
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+		}
+		go func() {
+			logger.Info("Answering HTTP challenges on %s", addr)
+			if err := challengeServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Fatal("HTTP challenge server error: %v", err)
+			}
+		}()
+	}
+
 	// Start the server in a goroutine
 	go func() {
 		logger.Info("Starting server on %s", server.Addr)
 		logger.Info("Data directory: %s", dataDir)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if tlsConfig != nil {
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Fatal("Server error: %v", err)
 		}
 	}()
 
+	// Serve the gRPC API on its own listener if configured
+	var grpcServer *grpc.Server
+	if addr := os.Getenv("GRPC_LISTEN_ADDR"); addr != "" {
+		if err := services.CheckGRPCOptions(); err != nil {
+			logger.Fatal("%v", err)
+		}
+		grpcListener, err := listen(addr, logger)
+		if err != nil {
+			logger.Fatal("Failed to listen on %s: %v", addr, err)
+		}
+		grpcServer = appHandler.NewGRPCServer(os.Getenv("GRPC_TOKEN"))
+		go func() {
+			logger.Info("Serving the gRPC API on %s", addr)
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				logger.Fatal("gRPC server error: %v", err)
+			}
+		}()
+	}
+
+	// Tell systemd the server is ready; the listener accepts connections
+	// already, so requests are not lost while Serve starts
+	if err := sdNotify("READY=1"); err != nil {
+		logger.Warn("%v", err)
+	}
+	if interval := watchdogInterval(); interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				if err := sdNotify("WATCHDOG=1"); err != nil {
+					logger.Warn("%v", err)
+				}
+			}
+		}()
+	}
+
 	// Set up graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	logger.Info("Shutting down server...")
+	sdNotify("STOPPING=1")
 
 	// Create a deadline for shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -123,10 +274,44 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		logger.Error("Server forced to shutdown: %v", err)
 	}
+	if challengeServer != nil {
+		challengeServer.Shutdown(ctx)
+	}
+	if grpcServer != nil {
+		// Streams still running at the deadline are cut off
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcServer.Stop()
+		}
+	}
 
 	logger.Info("Server exited gracefully")
 }
 
+// logLevelFromEnv returns the log level set with LOG_LEVEL, or defaultLevel
+func logLevelFromEnv(defaultLevel services.LogLevel) services.LogLevel {
+	switch strings.ToUpper(os.Getenv("LOG_LEVEL")) {
+	case "DEBUG":
+		return services.DEBUG
+	case "INFO":
+		return services.INFO
+	case "WARN":
+		return services.WARN
+	case "ERROR":
+		return services.ERROR
+	case "FATAL":
+		return services.FATAL
+	default:
+		return defaultLevel
+	}
+}
+
 func ensureDir(dirName string, logger *services.Logger) error {
 	if _, err := os.Stat(dirName); os.IsNotExist(err) {
 		err = os.MkdirAll(dirName, 0755)
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket activation
+const listenFDsStart = 3
+
+// systemdListener returns the socket passed by systemd socket activation, or
+// nil when the process was not socket activated. Only the first socket of a
+// .socket unit is used.
+func systemdListener(logger *services.Logger) (net.Listener, error) {
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	fds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	// The variables are meant for this process only, not for child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid != os.Getpid() || fds < 1 {
+		return nil, nil
+	}
+	if fds > 1 {
+		logger.Warn("systemd passed %d sockets, only the first one is used", fds)
+	}
+
+	syscall.CloseOnExec(listenFDsStart)
+	file := os.NewFile(listenFDsStart, "systemd-socket")
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use the socket passed by systemd: %w", err)
+	}
+	return listener, nil
+}
+
+// sdNotify sends a state such as READY=1 to the service manager when it
+// set NOTIFY_SOCKET, i.e. for Type=notify units. It does nothing otherwise.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading @ names a socket in the abstract namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to the notify socket: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return nil
+}
+
+// watchdogInterval returns how often to send WATCHDOG=1, half the WatchdogSec
+// of the unit, or 0 when the watchdog is not enabled for this process
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// writePIDFile writes the process ID to path, for init systems and
+// monitoring tools that track the server by PID file
+func writePIDFile(path string) error {
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("Expected no notification without NOTIFY_SOCKET, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to create notify socket: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatalf("sdNotify failed: %v", err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Errorf("Expected READY=1, got %q (%v)", buf[:n], err)
+	}
+}
+
+func TestSystemdListenerNotActivated(t *testing.T) {
+	// Variables meant for another process are ignored and cleared
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	listener, err := systemdListener(services.NewLogger(services.ERROR))
+	if listener != nil || err != nil {
+		t.Errorf("Expected no listener, got %v (%v)", listener, err)
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Error("Expected LISTEN_FDS to be cleared")
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "")
+	if interval := watchdogInterval(); interval != 15*time.Second {
+		t.Errorf("Expected 15s, got %v", interval)
+	}
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if interval := watchdogInterval(); interval != 0 {
+		t.Errorf("Expected no watchdog for another process, got %v", interval)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certCheckInterval limits how often the certificate files are checked for changes
+const certCheckInterval = time.Minute
+
+// newTLSConfig returns the TLS configuration for serving HTTPS, or nil when
+// neither TLS_CERT_FILE and TLS_KEY_FILE nor TLS_DOMAINS are set. With
+// TLS_DOMAINS, certificates are requested from Let's Encrypt and cached in
+// DATA_DIR/acme; the returned handler answers HTTP challenges and redirects
+// everything else to HTTPS.
+func newTLSConfig(dataDir string, logger *services.Logger) (*tls.Config, http.Handler, error) {
+	if err := services.CheckTLSOptions(); err != nil {
+		return nil, nil, err
+	}
+
+	if certFile := os.Getenv("TLS_CERT_FILE"); certFile != "" {
+		reloader := &certReloader{certFile: certFile, keyFile: os.Getenv("TLS_KEY_FILE"), logger: logger}
+		if _, err := reloader.GetCertificate(nil); err != nil {
+			return nil, nil, err
+		}
+		logger.Info("Serving HTTPS with the certificate in %s", certFile)
+		return &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: reloader.GetCertificate}, nil, nil
+	}
+
+	domains := os.Getenv("TLS_DOMAINS")
+	if domains == "" {
+		return nil, nil, nil
+	}
+	var hosts []string
+	for _, domain := range strings.Split(domains, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			hosts = append(hosts, domain)
+		}
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(filepath.Join(dataDir, "acme")),
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Email:      os.Getenv("TLS_EMAIL"),
+	}
+	logger.Info("Serving HTTPS with Let's Encrypt certificates for %s", strings.Join(hosts, ", "))
+	config := manager.TLSConfig()
+	config.MinVersion = tls.VersionTLS12
+	return config, manager.HTTPHandler(nil), nil
+}
+
+// certReloader serves a certificate from PEM files and loads it again when
+// the files change, e.g. after a renewal by certbot
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *services.Logger
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// GetCertificate implements tls.Config.GetCertificate. If a changed file
+// cannot be loaded, the previous certificate is kept.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cert != nil && time.Since(r.checkedAt) < certCheckInterval {
+		return r.cert, nil
+	}
+	r.checkedAt = time.Now()
+
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err == nil && r.cert != nil && !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+	if err == nil {
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err == nil {
+			if r.cert != nil {
+				r.logger.Info("Reloaded the TLS certificate from %s", r.certFile)
+			}
+			r.cert, r.modTime = &cert, modTime
+			return r.cert, nil
+		}
+	}
+
+	if r.cert == nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	r.logger.Error("Failed to reload the TLS certificate, keeping the previous one: %v", err)
+	return r.cert, nil
+}
+
+// latestModTime returns the most recent modification time of the files
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
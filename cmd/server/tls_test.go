@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// writeTestCertificate writes a self-signed certificate and its key for the host
+func writeTestCertificate(t *testing.T, dir, host string, modTime time.Time) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	for path, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set modification time: %v", err)
+		}
+	}
+	return certFile, keyFile
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	logger := services.NewLogger(services.ERROR)
+	certFile, keyFile := writeTestCertificate(t, dir, "old.example.com", time.Now().Add(-time.Hour))
+
+	t.Setenv("TLS_CERT_FILE", certFile)
+	t.Setenv("TLS_KEY_FILE", "")
+	if _, _, err := newTLSConfig(dir, logger); err == nil {
+		t.Error("Expected a certificate without a key to be rejected")
+	}
+
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	cert, err := reloader.GetCertificate(nil)
+	if err != nil || cert.Leaf.Subject.CommonName != "old.example.com" {
+		t.Fatalf("Expected the old certificate, got %v", err)
+	}
+
+	// A renewed certificate is picked up once the check interval has passed
+	writeTestCertificate(t, dir, "new.example.com", time.Now())
+	if cert, _ := reloader.GetCertificate(nil); cert.Leaf.Subject.CommonName != "old.example.com" {
+		t.Error("Expected the certificate to be cached until the next check")
+	}
+	reloader.checkedAt = time.Time{}
+	if cert, _ := reloader.GetCertificate(nil); cert.Leaf.Subject.CommonName != "new.example.com" {
+		t.Errorf("Expected the renewed certificate, got %s", cert.Leaf.Subject.CommonName)
+	}
+
+	// A broken renewal keeps the previous certificate
+	if err := os.WriteFile(keyFile, []byte("broken"), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	reloader.checkedAt = time.Time{}
+	if cert, err := reloader.GetCertificate(nil); err != nil || cert.Leaf.Subject.CommonName != "new.example.com" {
+		t.Errorf("Expected the previous certificate to be kept, got %v", err)
+	}
+}
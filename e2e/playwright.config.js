@@ -50,7 +50,7 @@ module.exports = defineConfig({
   webServer: process.env.CI ? {
     command: process.env.CI 
       ? '../app' 
-      : 'cd .. && go run cmd/server/main.go',
+      : 'cd .. && go run ./cmd/server',
     port: 8080,
     reuseExistingServer: true,
     timeout: 120 * 1000,
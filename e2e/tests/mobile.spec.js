@@ -0,0 +1,33 @@
+const { test, expect } = require('@playwright/test');
+
+// Test the pages on a phone-sized screen
+test.describe('Mobile Tests', () => {
+  test.use({ viewport: { width: 390, height: 844 }, hasTouch: true });
+
+  for (const path of ['/', '/business', '/clients', '/invoices', '/invoices/create', '/settings']) {
+    test(`${path} fits the screen`, async ({ page }) => {
+      await page.goto(path);
+      // Wide tables scroll inside their container instead of the page
+      const overflow = await page.evaluate(() => document.documentElement.scrollWidth - window.innerWidth);
+      expect(overflow).toBeLessThanOrEqual(0);
+    });
+  }
+
+  test('navigation collapses into a menu', async ({ page }) => {
+    await page.goto('/');
+    await expect(page.locator('#navbarNav').getByRole('link', { name: 'Invoices', exact: true })).toBeHidden();
+    await page.getByRole('button', { name: 'Toggle navigation' }).click();
+    await expect(page.locator('#navbarNav').getByRole('link', { name: 'Invoices', exact: true })).toBeVisible();
+  });
+
+  test('can be installed on the home screen', async ({ page, request }) => {
+    await page.goto('/');
+    const manifest = await request.get(await page.locator('link[rel="manifest"]').getAttribute('href'));
+    expect(manifest.ok()).toBeTruthy();
+    const data = await manifest.json();
+    expect(data.display).toBe('standalone');
+    expect(data.icons.length).toBeGreaterThan(0);
+    const worker = await request.get('/service-worker.js');
+    expect(worker.ok()).toBeTruthy();
+  });
+});
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// requestIDHeader carries the ID of a request, taken from the reverse proxy
+// when it sets one, so log lines can be matched with those of the proxy
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID limits request IDs from clients to what is safe to log
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// redactedQueryParams are query parameters holding tokens, which are logged
+// without their values
+var redactedQueryParams = []string{"token", "code", "state"}
+
+const accessLogContextKey contextKey = "access_log"
+
+// accessLogEntry collects what the handlers learn about a request for its
+// access log line
+type accessLogEntry struct {
+	user *models.User
+}
+
+// LogRequests logs every request as one line of key=value pairs with its
+// status, response size, duration, client address and user. Each request
+// gets an ID, sent back in the X-Request-ID header.
+func (h *AppHandler) LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		entry := &accessLogEntry{}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), accessLogContextKey, entry)))
+
+		user := "-"
+		if entry.user != nil {
+			user = entry.user.Username
+			if user == "" {
+				user = entry.user.Subject
+			}
+		}
+		h.logger.Info("access request_id=%s method=%s path=%q status=%d bytes=%d duration=%s remote=%s user=%q user_agent=%q",
+			requestID, r.Method, redactedRequestURI(r.URL), recorder.status, recorder.size,
+			time.Since(start).Round(time.Microsecond), h.authService.ClientIP(r), user, r.UserAgent())
+	})
+}
+
+// setAccessLogUser records the authenticated user of a request for its access log line
+func setAccessLogUser(r *http.Request, user *models.User) {
+	if entry, ok := r.Context().Value(accessLogContextKey).(*accessLogEntry); ok {
+		entry.user = user
+	}
+}
+
+// newRequestID returns a random request ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// redactedRequestURI returns the path and query of a request with the values
+// of token parameters hidden
+func redactedRequestURI(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.EscapedPath()
+	}
+	query := u.Query()
+	for _, name := range redactedQueryParams {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
+		}
+	}
+	return u.EscapedPath() + "?" + query.Encode()
+}
+
+// statusRecorder records the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
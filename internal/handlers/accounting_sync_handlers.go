@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// accountingCallbackPath is the redirect URI of the OAuth app, where the
+// accounting software sends the user back after access was granted
+const accountingCallbackPath = "/accounting/callback"
+
+// accountingConnectLifetime is how long the user has to grant access
+const accountingConnectLifetime = 15 * time.Minute
+
+// accountingStateName binds the OAuth state to the accounting software it was
+// issued for
+func accountingStateName(provider string) string {
+	return "accounting/" + provider
+}
+
+// accountingSyncStatus is the response of GET /api/accounting-sync
+type accountingSyncStatus struct {
+	Provider   string                       `json:"provider"` // xero, quickbooks, or empty when disabled
+	Configured bool                         `json:"configured"`
+	Connection *models.AccountingConnection `json:"connection"` // null until an organization is connected
+}
+
+// accountingConnectResponse is the response of POST /api/accounting-sync/connection
+type accountingConnectResponse struct {
+	AuthorizeURL string `json:"authorize_url"`
+}
+
+// writeAccountingSyncError reports a failed call to the accounting software
+func (h *AppHandler) writeAccountingSyncError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrAccountingNotConfigured) || errors.Is(err, services.ErrAccountingNotConnected) {
+		h.writeError(w, http.StatusServiceUnavailable, errCodeAccountingSyncFailed, err.Error(), nil)
+		return
+	}
+	h.logger.Error("Accounting sync failed: %v", err)
+	h.writeError(w, http.StatusBadGateway, errCodeAccountingSyncFailed, err.Error(), nil)
+}
+
+// AccountingSyncHandler shows the connected accounting software, or pushes
+// the new and changed invoices now instead of waiting for the periodic sync
+// Routes: GET, POST /api/accounting-sync
+func (h *AppHandler) AccountingSyncHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		connection, err := h.accountingSyncService.Connection()
+		if err != nil {
+			h.writeInternalError(w, "Failed to load accounting connection", err)
+			return
+		}
+		json.NewEncoder(w).Encode(accountingSyncStatus{
+			Provider:   h.accountingSyncService.Provider(),
+			Configured: h.accountingSyncService.Configured(),
+			Connection: connection,
+		})
+
+	case http.MethodPost:
+		result, err := h.accountingSyncService.Sync()
+		if err != nil {
+			h.writeAccountingSyncError(w, err)
+			return
+		}
+		h.logger.Info("Pushed %d invoices and %d payments to the accounting software, %d failed", result.Invoices, result.Payments, result.Failed)
+		json.NewEncoder(w).Encode(result)
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// AccountingConnectionHandler starts connecting the accounting software, or
+// disconnects it
+// Routes: POST, DELETE /api/accounting-sync/connection
+func (h *AppHandler) AccountingConnectionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		state := h.authService.SignLink(accountingStateName(h.accountingSyncService.Provider()), time.Now().Add(accountingConnectLifetime))
+		authorizeURL, err := h.accountingSyncService.AuthorizeURL(absoluteURL(r, accountingCallbackPath), state)
+		if err != nil {
+			h.writeAccountingSyncError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(accountingConnectResponse{AuthorizeURL: authorizeURL})
+
+	case http.MethodDelete:
+		if err := h.accountingSyncService.Disconnect(); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, "No accounting software is connected", nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to disconnect the accounting software", err)
+			return
+		}
+		h.logger.Info("Disconnected the accounting software")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Accounting software disconnected successfully"})
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// AccountingCallbackHandler completes connecting the accounting software when
+// the provider sends the user back with an authorization code
+// Route: GET /accounting/callback
+func (h *AppHandler) AccountingCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		h.logger.Warn("Connecting the accounting software failed: %s %s", errCode, query.Get("error_description"))
+		http.Error(w, "Connecting the accounting software failed: "+errCode, http.StatusBadRequest)
+		return
+	}
+	if !h.authService.VerifyLink(accountingStateName(h.accountingSyncService.Provider()), query.Get("state")) {
+		http.Error(w, "Connecting the accounting software expired, please try again", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.accountingSyncService.Connect(query.Get("code"), query.Get("realmId"), absoluteURL(r, accountingCallbackPath)); err != nil {
+		h.logger.Error("Failed to connect the accounting software: %v", err)
+		http.Error(w, "Failed to connect the accounting software: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	http.Redirect(w, r, "/settings?accounting=connected", http.StatusFound)
+}
+
+// invoiceAccountingHandler handles GET /api/invoices/{id}/accounting, which
+// tells whether the invoice and its payment were pushed to the accounting
+// software
+func (h *AppHandler) invoiceAccountingHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	invoice, items, err := h.invoices.GetInvoice(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", id), nil)
+			return
+		}
+		h.writeInternalError(w, "Failed to load invoice", err)
+		return
+	}
+	status, err := h.accountingSyncService.InvoiceStatus(invoice, items)
+	if err != nil {
+		h.writeInternalError(w, "Failed to load the accounting sync status", err)
+		return
+	}
+	if status == nil {
+		h.writeAccountingSyncError(w, services.ErrAccountingNotConnected)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,871 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// e2eServer serves the whole application over HTTP, wired like cmd/server,
+// against a new SQLite database in a temporary data directory. Requests are
+// checked against the operations documented in apiEndpoints, which are
+// recorded so a test can check that every one of them was exercised.
+type e2eServer struct {
+	t          *testing.T
+	handler    *AppHandler
+	url        string
+	operations []apiOperation
+	requested  map[string]bool // Method and path template of the requested operations
+}
+
+// e2eUpload is a multipart/form-data request body with one file
+type e2eUpload struct {
+	field, filename string
+	content         []byte
+	values          map[string]string
+}
+
+// newE2EServer starts the application signed in through a trusted proxy, with
+// stand-ins for the Nominatim, Nager.Date, GoCardless, PayPal and Xero servers. Email is configured but
+// the job worker is stopped, so queued emails stay pending.
+func newE2EServer(t *testing.T) *e2eServer {
+	t.Helper()
+	t.Chdir("../..")
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/search":
+			fmt.Fprint(w, `[{"display_name": "10 Downing Street, London, SW1A 2AA, United Kingdom",
+				"address": {"house_number": "10", "road": "Downing Street", "city": "London", "postcode": "SW1A 2AA", "country_code": "gb"}}]`)
+		case strings.HasPrefix(r.URL.Path, "/api/v3/PublicHolidays/"):
+			year := path.Base(path.Dir(r.URL.Path))
+			fmt.Fprintf(w, `[{"date": "%s-12-25", "localName": "1. Weihnachtstag", "name": "Christmas Day", "global": true, "types": ["Public"]}]`, year)
+		case r.URL.Path == "/cgi-bin/webscr":
+			body, _ := io.ReadAll(r.Body)
+			if !strings.HasPrefix(string(body), "cmd=_notify-validate&") || strings.Contains(string(body), "forged") {
+				fmt.Fprint(w, "INVALID")
+				return
+			}
+			fmt.Fprint(w, "VERIFIED")
+		case r.URL.Path == "/api/v2/token/new/":
+			fmt.Fprint(w, `{"access": "e2e-token", "access_expires": 86400}`)
+		case r.URL.Path == "/api/v2/institutions/":
+			fmt.Fprint(w, `[{"id": "SANDBOXFINANCE_SFIN0000", "name": "Sandbox Finance", "bic": "SFIN0000"}]`)
+		case r.URL.Path == "/api/v2/institutions/SANDBOXFINANCE_SFIN0000/":
+			fmt.Fprint(w, `{"id": "SANDBOXFINANCE_SFIN0000", "name": "Sandbox Finance", "bic": "SFIN0000"}`)
+		case r.URL.Path == "/api/v2/requisitions/" && r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"id": "e2e-requisition", "status": "CR", "accounts": [], "link": "https://bank.invalid/grant"}`)
+		case r.URL.Path == "/api/v2/requisitions/e2e-requisition/":
+			if r.Method == http.MethodDelete {
+				fmt.Fprint(w, `{"summary": "Requisition deleted"}`)
+				return
+			}
+			fmt.Fprint(w, `{"id": "e2e-requisition", "status": "LN", "accounts": ["e2e-account"]}`)
+		case r.URL.Path == "/api/v2/accounts/e2e-account/transactions/":
+			fmt.Fprintf(w, `{"transactions": {"booked": [
+				{"transactionId": "tx-1", "bookingDate": "%[1]s", "transactionAmount": {"amount": "471.20", "currency": "EUR"}, "debtorName": "Acme GmbH"},
+				{"transactionId": "tx-2", "bookingDate": "%[1]s", "transactionAmount": {"amount": "-12.00", "currency": "EUR"}, "creditorName": "Bank"}
+			], "pending": []}}`, time.Now().Format("2006-01-02"))
+		case r.URL.Path == "/connect/token":
+			fmt.Fprint(w, `{"access_token": "e2e-access", "refresh_token": "e2e-refresh", "expires_in": 1800}`)
+		case r.URL.Path == "/connections":
+			fmt.Fprint(w, `[{"tenantId": "e2e-tenant", "tenantName": "E2E Ltd", "tenantType": "ORGANISATION"}]`)
+		case r.URL.Path == "/api.xro/2.0/Contacts" && r.Method == http.MethodGet:
+			fmt.Fprint(w, `{"Contacts": []}`)
+		case r.URL.Path == "/api.xro/2.0/Contacts":
+			fmt.Fprint(w, `{"Contacts": [{"ContactID": "e2e-contact"}]}`)
+		case r.URL.Path == "/api.xro/2.0/Invoices" && r.Method == http.MethodGet:
+			fmt.Fprint(w, `{"Invoices": []}`)
+		case r.URL.Path == "/api.xro/2.0/Invoices":
+			var request struct {
+				Invoices []struct{ InvoiceNumber string }
+			}
+			json.NewDecoder(r.Body).Decode(&request)
+			fmt.Fprintf(w, `{"Invoices": [{"InvoiceID": "e2e-%s"}]}`, request.Invoices[0].InvoiceNumber)
+		case r.URL.Path == "/api.xro/2.0/CreditNotes" && r.Method == http.MethodGet:
+			fmt.Fprint(w, `{"CreditNotes": []}`)
+		case r.URL.Path == "/api.xro/2.0/CreditNotes":
+			var request struct {
+				CreditNotes []struct{ Type, CreditNoteNumber string }
+			}
+			json.NewDecoder(r.Body).Decode(&request)
+			if request.CreditNotes[0].Type != "ACCRECCREDIT" {
+				http.Error(w, `{"Message": "Type is invalid"}`, http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, `{"CreditNotes": [{"CreditNoteID": "e2e-%s"}]}`, request.CreditNotes[0].CreditNoteNumber)
+		case r.URL.Path == "/api.xro/2.0/Payments" && r.Method == http.MethodPut:
+			fmt.Fprint(w, `{"Payments": [{"PaymentID": "e2e-payment"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	t.Setenv("AUTH_MODE", services.AuthModeProxy)
+	t.Setenv("AUTH_TRUSTED_PROXIES", "127.0.0.0/8,::1/128")
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("NOMINATIM_URL", upstream.URL)
+	t.Setenv("HOLIDAYS_API_URL", upstream.URL)
+	t.Setenv("COMPANIES_HOUSE_API_KEY", "")
+	t.Setenv("GOCARDLESS_SECRET_ID", "e2e-id")
+	t.Setenv("GOCARDLESS_SECRET_KEY", "e2e-key")
+	t.Setenv("GOCARDLESS_API_URL", upstream.URL)
+	t.Setenv("PAYPAL_EMAIL", "payments@example.com")
+	t.Setenv("PAYPAL_URL", upstream.URL)
+	t.Setenv("ACCOUNTING_PROVIDER", services.AccountingProviderXero)
+	t.Setenv("ACCOUNTING_CLIENT_ID", "e2e-client")
+	t.Setenv("ACCOUNTING_CLIENT_SECRET", "e2e-secret")
+	t.Setenv("ACCOUNTING_API_URL", upstream.URL)
+	t.Setenv("ACCOUNTING_TOKEN_URL", upstream.URL+"/connect/token")
+	t.Setenv("SMTP_HOST", "smtp.invalid")
+
+	dataDir := t.TempDir()
+	os.MkdirAll(filepath.Join(dataDir, "images"), 0755)
+	os.MkdirAll(filepath.Join(dataDir, "pdfs"), 0755)
+
+	mux := http.NewServeMux()
+	handler, err := RegisterHandlers(mux, dataDir, services.NewLogger(services.FATAL), "test-version")
+	if err != nil {
+		t.Fatalf("Failed to register handlers: %v", err)
+	}
+	t.Cleanup(func() { handler.Cleanup() })
+	handler.jobService.Stop()
+
+	server := httptest.NewServer(handler.RecoverPanics(handler.LimitRequestBody(handler.RequireAuth(mux))))
+	t.Cleanup(server.Close)
+
+	s := &e2eServer{t: t, handler: handler, url: server.URL, requested: map[string]bool{}}
+	for _, endpoint := range handler.apiEndpoints() {
+		s.operations = append(s.operations, endpoint.Operations...)
+	}
+	return s
+}
+
+// operation returns the documented operation of a request path, preferring
+// literal segments such as /api/invoices/tags over /api/invoices/{id}
+func (s *e2eServer) operation(method, requestPath string) (apiOperation, bool) {
+	segments := strings.Split(requestPath, "/")
+	var match apiOperation
+	found, matchParams := false, 0
+	for _, op := range s.operations {
+		templateSegments := strings.Split(op.Path, "/")
+		if op.Method != method || len(templateSegments) != len(segments) {
+			continue
+		}
+		params, ok := 0, true
+		for i, segment := range templateSegments {
+			switch {
+			case strings.HasPrefix(segment, "{"):
+				params++
+			case segment != segments[i]:
+				ok = false
+			}
+		}
+		if ok && (!found || params < matchParams) {
+			match, found, matchParams = op, true, params
+		}
+	}
+	return match, found
+}
+
+// do sends a request as the signed-in user and checks that the status is want
+// and documented. Successful JSON responses must decode into the documented
+// response type without unknown fields; they are also decoded into out if it
+// is not nil. body is sent as JSON unless it is a string of JSON or an
+// e2eUpload.
+func (s *e2eServer) do(method, target string, body interface{}, want int, out interface{}) *http.Response {
+	s.t.Helper()
+
+	var reader io.Reader
+	contentType := "application/json"
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+	case e2eUpload:
+		var buf bytes.Buffer
+		writer := multipart.NewWriter(&buf)
+		for name, value := range b.values {
+			writer.WriteField(name, value)
+		}
+		part, _ := writer.CreateFormFile(b.field, b.filename)
+		part.Write(b.content)
+		writer.Close()
+		reader, contentType = &buf, writer.FormDataContentType()
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			s.t.Fatalf("Failed to encode %s %s body: %v", method, target, err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, s.url+target, reader)
+	if err != nil {
+		s.t.Fatalf("Failed to create request %s %s: %v", method, target, err)
+	}
+	if reader != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Remote-User", "e2e")
+	req.Header.Set("Remote-Email", "e2e@example.com")
+	req.Header.Set("Remote-Name", "End To End")
+
+	op, ok := s.operation(method, req.URL.Path)
+	if !ok {
+		s.t.Fatalf("%s %s is not a documented API operation", method, req.URL.Path)
+	}
+	s.requested[op.Method+" "+op.Path] = true
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatalf("%s %s failed: %v", method, target, err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		s.t.Fatalf("Failed to read %s %s response: %v", method, target, err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	if resp.StatusCode != want {
+		s.t.Fatalf("%s %s = %d, want %d: %s", method, target, resp.StatusCode, want, data)
+	}
+	success := slices.Contains(successStatuses(op), resp.StatusCode)
+	if !success && !slices.Contains(op.Errors, resp.StatusCode) {
+		s.t.Errorf("%s %s answered %d, which is not documented for %s %s", method, target, resp.StatusCode, op.Method, op.Path)
+	}
+
+	if !success {
+		var apiErr apiError
+		if err := json.Unmarshal(data, &apiErr); err != nil || apiErr.Code == "" {
+			s.t.Errorf("%s %s answered %d without an error envelope: %s", method, target, resp.StatusCode, data)
+		}
+		return resp
+	}
+	if op.ResponseType != "" {
+		if got := resp.Header.Get("Content-Type"); !strings.HasPrefix(got, op.ResponseType) {
+			s.t.Errorf("%s %s Content-Type = %q, want %s", method, target, got, op.ResponseType)
+		}
+		return resp
+	}
+	if got := resp.Header.Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+		s.t.Errorf("%s %s Content-Type = %q, want application/json", method, target, got)
+	}
+	var documented interface{} = messageResponse{}
+	if op.Response != nil {
+		documented = op.Response
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(reflect.New(reflect.TypeOf(documented)).Interface()); err != nil {
+		s.t.Errorf("%s %s response does not match %T: %v\n%s", method, target, documented, err, data)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			s.t.Fatalf("Failed to decode %s %s response: %v", method, target, err)
+		}
+	}
+	return resp
+}
+
+// get fetches a link from a response, such as a signed PDF link, outside the API
+func (s *e2eServer) get(link string) *http.Response {
+	s.t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, s.url+link, nil)
+	req.Header.Set("Remote-User", "e2e")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatalf("GET %s failed: %v", link, err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+// checkCoverage reports the documented operations that were not requested
+func (s *e2eServer) checkCoverage() {
+	s.t.Helper()
+	for _, op := range s.operations {
+		if !s.requested[op.Method+" "+op.Path] {
+			s.t.Errorf("%s %s was not exercised", op.Method, op.Path)
+		}
+	}
+}
+
+// e2eLogo returns a small PNG image
+func e2eLogo(t *testing.T) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.RGBA{R: 200, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode logo: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestAPIEndToEnd walks through the bookkeeping of a year over HTTP and
+// requests every documented API operation, checking the status codes and the
+// JSON contracts of the responses
+func TestAPIEndToEnd(t *testing.T) {
+	s := newE2EServer(t)
+
+	// Business, its working calendar and logo
+	s.do(http.MethodGet, "/api/business", nil, http.StatusOK, nil)
+	var business models.Business
+	s.do(http.MethodPost, "/api/business", map[string]interface{}{
+		"name": "Example Consulting", "address": "Hauptstraße 1", "city": "Berlin", "postal_code": "10115", "country": "DE",
+		"vat_id": "DE123456789", "email": "billing@example.com", "iban": "DE89370400440532013000", "bic": "COBADEFFXXX",
+		"currency": "EUR", "holiday_country": "DE", "crypto_address": "0x71C7656EC7ab88b098defB751B7401B5f6d8976F", "crypto_network": "Base",
+	}, http.StatusOK, &business)
+	if business.ID == 0 || business.Version == 0 {
+		t.Fatalf("Saved business has no ID or version: %+v", business)
+	}
+	stale := business
+	stale.Name = "Stale Consulting"
+	stale.Version = business.Version + 1
+	s.do(http.MethodPost, "/api/business", stale, http.StatusConflict, nil)
+
+	var month models.WorkMonth
+	s.do(http.MethodGet, "/api/business/work-calendar?month=2024-12", nil, http.StatusOK, &month)
+	// 22 weekdays in December 2024 without Christmas Day from the holiday server
+	if month.TotalHours != 21*models.DefaultWorkHoursPerDay {
+		t.Errorf("December 2024 has %v working hours, want %v", month.TotalHours, 21*models.DefaultWorkHoursPerDay)
+	}
+	s.do(http.MethodGet, "/api/business/work-calendar?month=December", nil, http.StatusBadRequest, nil)
+	var period models.WorkPeriod
+	s.do(http.MethodGet, "/api/business/work-period?from=2024-12-16&to=2024-12-31", nil, http.StatusOK, &period)
+	if period.TotalHours != 11*models.DefaultWorkHoursPerDay {
+		t.Errorf("The second half of December 2024 has %v working hours, want %v", period.TotalHours, 11*models.DefaultWorkHoursPerDay)
+	}
+	s.do(http.MethodGet, "/api/business/work-period?from=2024-12-31&to=2024-12-16", nil, http.StatusBadRequest, nil)
+	var holidays []models.PublicHoliday
+	s.do(http.MethodGet, "/api/holidays?country=DE&year=2024", nil, http.StatusOK, &holidays)
+	if len(holidays) != 1 {
+		t.Errorf("Expected Christmas Day as the only holiday, got %+v", holidays)
+	}
+	s.do(http.MethodPost, "/api/holidays?country=DE&year=2024", nil, http.StatusOK, nil)
+	s.do(http.MethodGet, "/api/holidays?country=Germany", nil, http.StatusBadRequest, nil)
+
+	s.do(http.MethodPost, "/api/upload/logo", e2eUpload{field: "logo", filename: "logo.png", content: e2eLogo(t)}, http.StatusOK, nil)
+	s.do(http.MethodPost, "/api/upload/logo", e2eUpload{field: "logo", filename: "logo.png", content: []byte("not an image")}, http.StatusUnsupportedMediaType, nil)
+	s.do(http.MethodDelete, "/api/upload/logo", nil, http.StatusOK, nil)
+
+	// Clients and their lookups
+	s.do(http.MethodGet, "/api/reference-data?date=2024-12-01", nil, http.StatusOK, nil)
+	s.do(http.MethodGet, "/api/clients/vat-lookup", nil, http.StatusBadRequest, nil)
+	s.do(http.MethodGet, "/api/clients/uk-company-lookup?name=Acme", nil, http.StatusBadRequest, nil)
+	var suggestions []models.AddressSuggestion
+	s.do(http.MethodGet, "/api/clients/address-lookup?q=10+Downing+Street&country=GB", nil, http.StatusOK, &suggestions)
+	if len(suggestions) != 1 || suggestions[0].City != "London" {
+		t.Errorf("Unexpected address suggestions: %+v", suggestions)
+	}
+
+	var client models.Client
+	s.do(http.MethodPost, "/api/clients", map[string]interface{}{
+		"name": "Acme GmbH", "address": "Marienplatz 1", "city": "München", "postal_code": "80331", "country": "DE",
+		"vat_id": "DE987654321", "email": "ap@acme.example", "language": "de",
+	}, http.StatusOK, &client)
+	client.RiskNotes = "Pays late in December"
+	s.do(http.MethodPost, "/api/clients", client, http.StatusOK, &client)
+	s.do(http.MethodPost, "/api/clients", client, http.StatusOK, nil)
+	s.do(http.MethodPost, "/api/clients", client, http.StatusConflict, nil)
+	s.do(http.MethodPost, "/api/clients", `{"name": "Broken", "email": "not an address"}`, http.StatusBadRequest, nil)
+	s.do(http.MethodPost, "/api/clients", `{"name": "Broken", "country": "IT", "sdi_code": "ABC"}`, http.StatusBadRequest, nil)
+	s.do(http.MethodPost, "/api/clients", `{"name": "Broken", "date_format": "YYYY-MM-DD"}`, http.StatusBadRequest, nil)
+	s.do(http.MethodGet, fmt.Sprintf("/api/clients/%d", client.ID), nil, http.StatusOK, &client)
+	s.do(http.MethodGet, "/api/clients/9999", nil, http.StatusNotFound, nil)
+
+	var other models.Client
+	s.do(http.MethodPost, "/api/clients", map[string]interface{}{"name": "Gamma SRL", "city": "Bucharest", "country": "RO"}, http.StatusOK, &other)
+	resp := s.do(http.MethodGet, "/api/clients?limit=1", nil, http.StatusOK, nil)
+	if got := resp.Header.Get(totalCountHeader); got != "2" {
+		t.Errorf("%s = %q, want 2", totalCountHeader, got)
+	}
+	s.do(http.MethodGet, "/api/clients?limit=0", nil, http.StatusBadRequest, nil)
+	s.do(http.MethodDelete, fmt.Sprintf("/api/clients/%d", other.ID), nil, http.StatusOK, nil)
+	s.do(http.MethodPost, fmt.Sprintf("/api/clients/%d/restore", other.ID), nil, http.StatusOK, nil)
+	s.do(http.MethodPost, fmt.Sprintf("/api/clients/%d/anonymize", other.ID), nil, http.StatusOK, nil)
+
+	var imported services.ImportResult
+	s.do(http.MethodPost, "/api/clients/import", e2eUpload{field: "file", filename: "clients.csv",
+		content: []byte("Name,Country,VAT ID\nBeta Ltd,GB,GB123456789\nAcme GmbH,DE,DE987654321\n"), values: map[string]string{"dry_run": "true"}},
+		http.StatusOK, &imported)
+	if !imported.DryRun || imported.Created != 1 || imported.Duplicates != 1 {
+		t.Errorf("Unexpected client import preview: %+v", imported)
+	}
+
+	s.do(http.MethodPost, fmt.Sprintf("/api/clients/%d/notes", client.ID), map[string]string{"body": "New contact in accounts payable"}, http.StatusCreated, nil)
+	s.do(http.MethodPost, fmt.Sprintf("/api/clients/%d/notes", client.ID), map[string]string{"body": " "}, http.StatusBadRequest, nil)
+	s.do(http.MethodGet, fmt.Sprintf("/api/clients/%d/notes", client.ID), nil, http.StatusOK, nil)
+	s.do(http.MethodPost, fmt.Sprintf("/api/clients/%d/credits", client.ID), map[string]string{"amount": "100.00", "description": "Retainer"}, http.StatusCreated, nil)
+	var credits clientCreditsResponse
+	s.do(http.MethodGet, fmt.Sprintf("/api/clients/%d/credits", client.ID), nil, http.StatusOK, &credits)
+	if len(credits.Balances) != 1 || credits.Balances[0].Currency != "EUR" {
+		t.Errorf("Unexpected credit balances: %+v", credits.Balances)
+	}
+
+	// A project with time to bill
+	var project models.Project
+	s.do(http.MethodPost, "/api/projects", map[string]interface{}{"client_id": client.ID, "name": "Website", "hourly_rate": "80.00"}, http.StatusCreated, &project)
+	s.do(http.MethodPost, "/api/projects", project, http.StatusOK, nil)
+	s.do(http.MethodGet, "/api/projects", nil, http.StatusOK, nil)
+	s.do(http.MethodGet, fmt.Sprintf("/api/projects/%d", project.ID), nil, http.StatusOK, nil)
+	s.do(http.MethodPost, fmt.Sprintf("/api/projects/%d/time-entries", project.ID), timeEntryRequest{Date: "2024-12-02", Hours: 6, Description: "Design"}, http.StatusCreated, nil)
+	var mistake models.TimeEntry
+	s.do(http.MethodPost, fmt.Sprintf("/api/projects/%d/time-entries", project.ID), timeEntryRequest{Date: "2024-12-03", Hours: 2}, http.StatusCreated, &mistake)
+	s.do(http.MethodPost, fmt.Sprintf("/api/projects/%d/time-entries", project.ID), timeEntryRequest{Date: "2024-12-03", Hours: 60}, http.StatusBadRequest, nil)
+	s.do(http.MethodDelete, fmt.Sprintf("/api/projects/%d/time-entries/%d", project.ID, mistake.ID), nil, http.StatusOK, nil)
+	s.do(http.MethodGet, fmt.Sprintf("/api/projects/%d/time-entries", project.ID), nil, http.StatusOK, nil)
+
+	// An invoice through its life: sent, emailed, tagged, credited
+	invoiceBody := func(issueDate, invoiceType string) map[string]interface{} {
+		return map[string]interface{}{
+			"invoice": map[string]interface{}{
+				"id": 0, "invoice_number": "", "business_id": business.ID, "client_id": client.ID, "project_id": project.ID,
+				"hours_worked": 6, "vat_rate": 19, "reverse_charge_vat": false, "currency": "EUR", "notes": "", "status": "draft",
+				"type": invoiceType, "issue_date": issueDate, "due_date": issueDate, "total_amount": "571.20", "vat_amount": "91.20",
+			},
+			"items": []map[string]interface{}{{"description": "Design", "quantity": 6, "unit_price": "80.00", "amount": "480.00"}},
+		}
+	}
+	var invoice models.Invoice
+	s.do(http.MethodPost, "/api/invoices", invoiceBody("2024-12-02", models.InvoiceTypeInvoice), http.StatusOK, &invoice)
+	if invoice.InvoiceNumber == "" || invoice.TotalAmount != models.NewMoney(571.20) {
+		t.Fatalf("Unexpected invoice: %+v", invoice)
+	}
+	s.do(http.MethodGet, "/api/invoices?status=draft", nil, http.StatusOK, nil)
+	s.do(http.MethodGet, "/api/invoices?client_id=acme", nil, http.StatusBadRequest, nil)
+	s.do(http.MethodPatch, fmt.Sprintf("/api/invoices/%d", invoice.ID), invoiceStatusRequest{Status: "sent"}, http.StatusOK, nil)
+	s.do(http.MethodPatch, fmt.Sprintf("/api/invoices/%d", invoice.ID), invoiceStatusRequest{Status: "lost"}, http.StatusBadRequest, nil)
+
+	var pdf pdfResponse
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/generate-pdf/%d", invoice.ID), nil, http.StatusOK, &pdf)
+	if resp := s.get(pdf.URL); resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/pdf" {
+		t.Errorf("GET %s = %d %s, want a PDF", pdf.URL, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	s.do(http.MethodGet, "/api/invoices/generate-pdf/9999", nil, http.StatusNotFound, nil)
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/%d/pdfs", invoice.ID), nil, http.StatusOK, nil)
+	var preview previewResponse
+	s.do(http.MethodPost, "/api/invoices/preview-pdf", map[string]interface{}{
+		"invoice":  invoiceBody("2024-12-02", models.InvoiceTypeInvoice)["invoice"],
+		"items":    []models.InvoiceItem{{Description: "Design", Quantity: 6, UnitPrice: 8000, Amount: 48000}},
+		"business": business,
+		"client":   client,
+	}, http.StatusOK, &preview)
+	if resp := s.get(preview.URL); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET %s = %d, want the preview", preview.URL, resp.StatusCode)
+	}
+
+	var email emailResponse
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/%d/email", invoice.ID), nil, http.StatusOK, &email)
+	if !strings.Contains(email.Subject, invoice.InvoiceNumber) {
+		t.Errorf("Unexpected invoice email: %+v", email)
+	}
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/%d/email?kind=poem", invoice.ID), nil, http.StatusBadRequest, nil)
+
+	// Disputed invoices need a reason and get no reminders
+	s.do(http.MethodPatch, fmt.Sprintf("/api/invoices/%d", invoice.ID), invoiceStatusRequest{Status: "disputed"}, http.StatusBadRequest, nil)
+	s.do(http.MethodPatch, fmt.Sprintf("/api/invoices/%d", invoice.ID), invoiceStatusRequest{Status: "disputed", Reason: "Hours not agreed"}, http.StatusOK, nil)
+	var disputed []models.Invoice
+	s.do(http.MethodGet, "/api/invoices?status=disputed", nil, http.StatusOK, &disputed)
+	if len(disputed) != 1 || disputed[0].StatusReason != "Hours not agreed" {
+		t.Errorf("Expected the disputed invoice, got %+v", disputed)
+	}
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/%d/email?kind=reminder", invoice.ID), nil, http.StatusBadRequest, nil)
+	s.do(http.MethodPatch, fmt.Sprintf("/api/invoices/%d", invoice.ID), invoiceStatusRequest{Status: "sent"}, http.StatusOK, nil)
+	var scheduled sendInvoiceResponse
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/send", invoice.ID), sendInvoiceRequest{SendAt: time.Now().Add(24 * time.Hour)}, http.StatusOK, &scheduled)
+	if scheduled.To != client.Email || scheduled.SendAt == nil {
+		t.Errorf("Unexpected scheduled email: %+v", scheduled)
+	}
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/%d/scheduled-email", invoice.ID), nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, fmt.Sprintf("/api/invoices/%d/scheduled-email", invoice.ID), nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, fmt.Sprintf("/api/invoices/%d/scheduled-email", invoice.ID), nil, http.StatusNotFound, nil)
+	var sent sendInvoiceResponse
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/send", invoice.ID), sendInvoiceRequest{To: "accounts@acme.example"}, http.StatusOK, &sent)
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/send", invoice.ID), sendInvoiceRequest{To: "accounts"}, http.StatusBadRequest, nil)
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/%d/emails", invoice.ID), nil, http.StatusOK, nil)
+
+	// The email job stays queued, so it can be failed, retried and removed
+	var jobs []models.Job
+	s.do(http.MethodGet, "/api/jobs", nil, http.StatusOK, &jobs)
+	if !slices.ContainsFunc(jobs, func(job models.Job) bool { return job.ID == sent.JobID }) {
+		t.Errorf("The email job %d is not listed: %+v", sent.JobID, jobs)
+	}
+	s.do(http.MethodGet, fmt.Sprintf("/api/jobs/%d", sent.JobID), nil, http.StatusOK, nil)
+	s.do(http.MethodPost, fmt.Sprintf("/api/jobs/%d/retry", sent.JobID), nil, http.StatusBadRequest, nil)
+	if _, err := s.handler.dbService.GetDB().Exec(`UPDATE jobs SET status = ? WHERE id = ?`, services.JobStatusFailed, sent.JobID); err != nil {
+		t.Fatalf("Failed to fail the job: %v", err)
+	}
+	s.do(http.MethodPost, fmt.Sprintf("/api/jobs/%d/retry", sent.JobID), nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, fmt.Sprintf("/api/jobs/%d", sent.JobID), nil, http.StatusOK, nil)
+	s.do(http.MethodGet, fmt.Sprintf("/api/jobs/%d", sent.JobID), nil, http.StatusNotFound, nil)
+
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/%d/time-entries", invoice.ID), nil, http.StatusOK, nil)
+	var billed billTimeResponse
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/time-entries", invoice.ID), nil, http.StatusOK, &billed)
+	if billed.Hours != 6 {
+		t.Errorf("Billed %v hours, want 6", billed.Hours)
+	}
+
+	var tags invoiceTagsRequest
+	s.do(http.MethodPut, fmt.Sprintf("/api/invoices/%d/tags", invoice.ID), invoiceTagsRequest{Tags: []string{"web", " 2024-Q4", "WEB"}}, http.StatusOK, &tags)
+	if !slices.Equal(tags.Tags, []string{"2024-Q4", "web"}) {
+		t.Errorf("Tags = %v, want [2024-Q4 web]", tags.Tags)
+	}
+	s.do(http.MethodPut, fmt.Sprintf("/api/invoices/%d/tags", invoice.ID), invoiceTagsRequest{Tags: []string{"a,b"}}, http.StatusBadRequest, nil)
+	s.do(http.MethodGet, "/api/invoices/tags", nil, http.StatusOK, nil)
+	var filter models.SavedFilter
+	s.do(http.MethodPost, "/api/filters", savedFilterRequest{Name: "Q4", Query: "tag=2024-Q4"}, http.StatusCreated, &filter)
+	s.do(http.MethodPost, "/api/filters", savedFilterRequest{Name: "q4", Query: "status=sent"}, http.StatusConflict, nil)
+	s.do(http.MethodGet, "/api/filters", nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, fmt.Sprintf("/api/filters/%d", filter.ID), nil, http.StatusOK, nil)
+
+	var note models.Note
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/notes", invoice.ID), map[string]string{"body": "Client promised payment Friday"}, http.StatusCreated, &note)
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/%d/notes", invoice.ID), nil, http.StatusOK, nil)
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/%d/timeline", invoice.ID), nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, fmt.Sprintf("/api/notes/%d", note.ID), nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, fmt.Sprintf("/api/notes/%d", note.ID), nil, http.StatusNotFound, nil)
+
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/credit", invoice.ID), applyCreditRequest{}, http.StatusOK, &invoice)
+	if invoice.CreditApplied != models.NewMoney(100) {
+		t.Errorf("Credit applied = %s, want 100.00", invoice.CreditApplied)
+	}
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/credit", invoice.ID), applyCreditRequest{}, http.StatusConflict, nil)
+	s.do(http.MethodGet, fmt.Sprintf("/api/clients/%d/timeline", client.ID), nil, http.StatusOK, nil)
+	s.do(http.MethodGet, fmt.Sprintf("/api/clients/%d/payment-stats", client.ID), nil, http.StatusOK, nil)
+
+	var similar []models.Invoice
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/similar?client_id=%d&total_amount=571.20&issue_date=2024-12-10", client.ID), nil, http.StatusOK, &similar)
+	if len(similar) != 1 || similar[0].ID != invoice.ID {
+		t.Errorf("Expected invoice %d to be similar, got %+v", invoice.ID, similar)
+	}
+
+	// A pro-forma invoice converted into a draft, which is paid and then kept
+	// by the gapless numbering of the German compliance profile
+	var proforma, converted models.Invoice
+	s.do(http.MethodPost, "/api/invoices", invoiceBody("2024-12-16", models.InvoiceTypeProforma), http.StatusOK, &proforma)
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/convert", proforma.ID), convertProformaRequest{IssueDate: "2024-12-20"}, http.StatusOK, &converted)
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/convert", proforma.ID), convertProformaRequest{}, http.StatusConflict, nil)
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/crypto-payment", proforma.ID), cryptoPaymentRequest{Amount: models.NewMoney(100), Rate: 0.9}, http.StatusBadRequest, nil)
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/crypto-payment", converted.ID), cryptoPaymentRequest{Amount: models.NewMoney(620), Rate: 0.92, PaidDate: "2024-12-27"},
+		http.StatusOK, &converted)
+	if converted.Status != "paid" || converted.CryptoAmount != models.NewMoney(620) || converted.CryptoFiatAmount != models.NewMoney(570.40) {
+		t.Errorf("Unexpected invoice paid in USDC: %+v", converted)
+	}
+	s.do(http.MethodDelete, fmt.Sprintf("/api/invoices/%d", converted.ID), nil, http.StatusConflict, nil)
+
+	// Which is why it is reversed by a cancellation invoice instead
+	var storno models.Invoice
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/cancel", proforma.ID), nil, http.StatusBadRequest, nil)
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/cancel", converted.ID), cancelInvoiceRequest{IssueDate: "2024-12-30"}, http.StatusOK, &storno)
+	if storno.CancelsInvoiceID != converted.ID || storno.TotalAmount != -converted.TotalAmount || storno.Status != "paid" {
+		t.Errorf("Unexpected cancellation invoice: %+v", storno)
+	}
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/cancel", converted.ID), nil, http.StatusConflict, nil)
+
+	s.do(http.MethodPost, "/api/invoices/import", e2eUpload{field: "file", filename: "invoices.csv",
+		content: []byte("Invoice Number,Client,Issue Date,Total,Status\nOLD-1,Acme GmbH,2024-11-01,100.00,paid\n"), values: map[string]string{"dry_run": "true"}},
+		http.StatusOK, nil)
+	s.do(http.MethodPost, "/api/invoices/batch", e2eUpload{field: "file", filename: "hours.csv",
+		content: []byte(fmt.Sprintf("client,hours,rate,description,period\n%d,10,80,Consulting,2024-11\n", client.ID)), values: map[string]string{"dry_run": "true"}},
+		http.StatusOK, nil)
+
+	// A quick invoice of hours, emailed to the client
+	var quick quickInvoiceResponse
+	s.do(http.MethodPost, "/api/invoices/quick", quickInvoiceRequest{ClientID: client.ID, Hours: 10, Rate: models.NewMoney(80), Period: "2024-11", IssueDate: "2024-12-02", Send: true},
+		http.StatusCreated, &quick)
+	if quick.Invoice.InvoiceNumber == "" || quick.Invoice.TotalAmount != models.NewMoney(952) || quick.Email == nil || quick.Email.JobID == 0 {
+		t.Errorf("Unexpected quick invoice: %+v", quick)
+	}
+	s.do(http.MethodPost, "/api/invoices/quick", quickInvoiceRequest{ClientID: client.ID, Hours: 10, Rate: models.NewMoney(80), Send: true, To: "accounts"}, http.StatusBadRequest, nil)
+
+	// The quick invoice paid through PayPal checkout
+	ipn := url.Values{
+		"receiver_email": {"Payments@example.com"}, "payment_status": {"Pending"}, "txn_id": {"61E67681CH3238416"},
+		"custom": {strconv.Itoa(quick.Invoice.ID)}, "invoice": {quick.Invoice.InvoiceNumber}, "mc_gross": {"952.00"}, "mc_currency": {"EUR"},
+	}
+	var notified paypalIPNResponse
+	s.do(http.MethodPost, "/api/paypal/ipn", ipn.Encode(), http.StatusOK, &notified)
+	if notified.Paid {
+		t.Errorf("Expected a pending PayPal payment not to mark the invoice paid, got %+v", notified)
+	}
+	ipn.Set("payment_status", "Completed")
+	s.do(http.MethodPost, "/api/paypal/ipn", ipn.Encode()+"&forged=1", http.StatusBadRequest, nil)
+	s.do(http.MethodPost, "/api/paypal/ipn", ipn.Encode(), http.StatusOK, &notified)
+	if !notified.Paid || notified.InvoiceID != quick.Invoice.ID {
+		t.Errorf("Expected the PayPal payment to mark the quick invoice paid, got %+v", notified)
+	}
+	s.do(http.MethodDelete, fmt.Sprintf("/api/invoices/%d", quick.Invoice.ID), nil, http.StatusConflict, nil)
+
+	// A bank statement paying the invoice, reconciled on the payment date
+	var matches services.StatementMatchResult
+	s.do(http.MethodPost, "/api/bank-statements/import", e2eUpload{field: "file", filename: "statement.csv",
+		content: []byte(fmt.Sprintf("Date;Amount;Currency;Payer;Reference\n2024-12-20;471,20;EUR;Acme GmbH;Invoice %s\n2024-12-21;-12,00;EUR;Bank;Fees\n", invoice.InvoiceNumber))},
+		http.StatusOK, &matches)
+	if matches.Matched != 1 || matches.Debits != 1 || matches.Matches[0].Invoice.InvoiceID != invoice.ID {
+		t.Fatalf("Unexpected statement matches: %+v", matches)
+	}
+	s.do(http.MethodPost, "/api/bank-statements/import", e2eUpload{field: "file", filename: "statement.csv", content: []byte("Payer,Reference\nAcme,x\n")}, http.StatusBadRequest, nil)
+
+	// The same payment synced from a connected bank
+	var institutions []services.BankInstitution
+	s.do(http.MethodGet, "/api/bank-sync/institutions?country=DE", nil, http.StatusOK, &institutions)
+	if len(institutions) != 1 {
+		t.Fatalf("Unexpected institutions: %+v", institutions)
+	}
+	s.do(http.MethodGet, "/api/bank-sync/institutions", nil, http.StatusBadRequest, nil)
+	var connection models.BankConnection
+	s.do(http.MethodPost, "/api/bank-sync/connections", bankConnectRequest{InstitutionID: institutions[0].ID}, http.StatusCreated, &connection)
+	if connection.Status != models.BankConnectionPending || connection.Link == "" || connection.InstitutionName != "Sandbox Finance" {
+		t.Errorf("Unexpected bank connection: %+v", connection)
+	}
+	var synced services.BankSyncResult
+	s.do(http.MethodPost, "/api/bank-sync", nil, http.StatusOK, &synced)
+	if synced.Connections != 1 || synced.New != 1 || synced.Failed != 0 {
+		t.Errorf("Unexpected bank sync: %+v", synced)
+	}
+	var status bankSyncStatus
+	s.do(http.MethodGet, "/api/bank-sync", nil, http.StatusOK, &status)
+	if !status.Configured || len(status.Connections) != 1 || status.Connections[0].Status != models.BankConnectionLinked {
+		t.Errorf("Unexpected bank sync status: %+v", status)
+	}
+	s.do(http.MethodGet, "/api/bank-sync/matches", nil, http.StatusOK, &matches)
+	if matches.Matched != 1 || matches.Matches[0].Invoice.InvoiceID != invoice.ID || matches.Matches[0].Transaction.ID == 0 {
+		t.Fatalf("Unexpected synced matches: %+v", matches)
+	}
+
+	var reconciled reconcileResponse
+	s.do(http.MethodPost, "/api/bank-statements/reconcile", reconcileRequest{Payments: []reconcilePayment{
+		{InvoiceID: invoice.ID, PaidDate: "2024-12-20", BankTransactionID: matches.Matches[0].Transaction.ID},
+	}}, http.StatusOK, &reconciled)
+	if reconciled.Paid != 1 || reconciled.Invoices[0].Status != "paid" || reconciled.Invoices[0].PaidDate.Format("2006-01-02") != "2024-12-20" {
+		t.Errorf("Unexpected reconciliation: %+v", reconciled)
+	}
+	s.do(http.MethodPost, "/api/bank-statements/reconcile", reconcileRequest{Payments: []reconcilePayment{{InvoiceID: 9999}}}, http.StatusBadRequest, nil)
+	s.do(http.MethodGet, "/api/bank-sync/matches", nil, http.StatusOK, &matches)
+	if matches.Total != 0 {
+		t.Errorf("Expected the reconciled payment not to be proposed again, got %+v", matches)
+	}
+	s.do(http.MethodDelete, fmt.Sprintf("/api/bank-sync/connections/%d", connection.ID), nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, fmt.Sprintf("/api/bank-sync/connections/%d", connection.ID), nil, http.StatusNotFound, nil)
+
+	// The finalized invoices and the payment pushed to Xero
+	var accounting accountingSyncStatus
+	s.do(http.MethodGet, "/api/accounting-sync", nil, http.StatusOK, &accounting)
+	if accounting.Provider != services.AccountingProviderXero || !accounting.Configured || accounting.Connection != nil {
+		t.Errorf("Unexpected accounting sync status: %+v", accounting)
+	}
+	s.do(http.MethodPost, "/api/accounting-sync", nil, http.StatusServiceUnavailable, nil)
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/%d/accounting", invoice.ID), nil, http.StatusServiceUnavailable, nil)
+	var authorize accountingConnectResponse
+	s.do(http.MethodPost, "/api/accounting-sync/connection", nil, http.StatusOK, &authorize)
+	authorizeURL, err := url.Parse(authorize.AuthorizeURL)
+	if err != nil || authorizeURL.Host != "login.xero.com" || authorizeURL.Query().Get("client_id") != "e2e-client" {
+		t.Fatalf("Unexpected authorize URL %s", authorize.AuthorizeURL)
+	}
+	if resp := s.get(accountingCallbackPath + "?code=e2e-code&state=forged"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a forged state to be rejected, got %d", resp.StatusCode)
+	}
+	if resp := s.get(accountingCallbackPath + "?code=e2e-code&state=" + url.QueryEscape(authorizeURL.Query().Get("state"))); resp.StatusCode != http.StatusOK ||
+		resp.Request.URL.Path != "/settings" {
+		t.Errorf("Expected connecting Xero to return to the settings, got %d at %s", resp.StatusCode, resp.Request.URL)
+	}
+	var pushed services.AccountingSyncResult
+	s.do(http.MethodPost, "/api/accounting-sync", nil, http.StatusOK, &pushed)
+	if pushed.Contacts != 1 || pushed.Invoices == 0 || pushed.Payments == 0 || pushed.Failed != 0 {
+		t.Errorf("Unexpected accounting sync: %+v", pushed)
+	}
+	s.do(http.MethodPost, "/api/accounting-sync", nil, http.StatusOK, &pushed)
+	if pushed.Invoices != 0 || pushed.Payments != 0 {
+		t.Errorf("Expected nothing to be pushed again, got %+v", pushed)
+	}
+	var invoiceSync models.AccountingSyncStatus
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/%d/accounting", invoice.ID), nil, http.StatusOK, &invoiceSync)
+	if invoiceSync.Status != models.AccountingSyncSynced || invoiceSync.RemoteID != "e2e-"+invoice.InvoiceNumber || invoiceSync.PaymentRemoteID != "e2e-payment" {
+		t.Errorf("Unexpected invoice sync status: %+v", invoiceSync)
+	}
+	s.do(http.MethodGet, "/api/invoices/9999/accounting", nil, http.StatusNotFound, nil)
+	s.do(http.MethodDelete, "/api/accounting-sync/connection", nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, "/api/accounting-sync/connection", nil, http.StatusNotFound, nil)
+
+	// FatturaPA is only issued by Italian businesses and sent by PEC
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/%d/fatturapa", invoice.ID), nil, http.StatusUnprocessableEntity, nil)
+	s.do(http.MethodGet, "/api/invoices/9999/fatturapa", nil, http.StatusNotFound, nil)
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/fatturapa", invoice.ID), nil, http.StatusServiceUnavailable, nil)
+
+	// Only invoices queued for NAV have a report; reporting needs a technical user
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/%d/nav", invoice.ID), nil, http.StatusNotFound, nil)
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/nav", invoice.ID), nil, http.StatusServiceUnavailable, nil)
+
+	// Only invoices of Spanish businesses get Verifactu records, once it is enabled
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/%d/verifactu", invoice.ID), nil, http.StatusNotFound, nil)
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/verifactu", invoice.ID), nil, http.StatusServiceUnavailable, nil)
+
+	// Only invoices of Polish businesses are sent to KSeF, which needs a token
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/%d/ksef", invoice.ID), nil, http.StatusNotFound, nil)
+	s.do(http.MethodGet, fmt.Sprintf("/api/invoices/%d/ksef-xml", invoice.ID), nil, http.StatusUnprocessableEntity, nil)
+	s.do(http.MethodPost, fmt.Sprintf("/api/invoices/%d/ksef", invoice.ID), nil, http.StatusServiceUnavailable, nil)
+
+	// Retainer contracts
+	var contract models.Contract
+	s.do(http.MethodPost, "/api/contracts", map[string]interface{}{
+		"client_id": client.ID, "name": "Support retainer", "monthly_amount": "1000.00", "vat_rate": 19, "active": true,
+		"start_date": "2024-01-01", "end_date": "2024-03-31",
+	}, http.StatusCreated, &contract)
+	s.do(http.MethodPost, "/api/contracts", map[string]interface{}{"client_id": client.ID, "name": "No amount", "start_date": "2024-01-01"}, http.StatusBadRequest, nil)
+	var generated generateContractInvoicesResponse
+	s.do(http.MethodPost, "/api/contracts/generate", nil, http.StatusOK, &generated)
+	s.do(http.MethodGet, "/api/contracts", nil, http.StatusOK, nil)
+	s.do(http.MethodGet, fmt.Sprintf("/api/contracts/%d", contract.ID), nil, http.StatusOK, nil)
+	var contractInvoices []models.ContractInvoice
+	s.do(http.MethodGet, fmt.Sprintf("/api/contracts/%d/invoices", contract.ID), nil, http.StatusOK, &contractInvoices)
+	if len(contractInvoices) != 3 {
+		t.Errorf("Expected 3 monthly invoices for the contract, got %+v", contractInvoices)
+	}
+	s.do(http.MethodDelete, fmt.Sprintf("/api/contracts/%d", contract.ID), nil, http.StatusOK, nil)
+	s.do(http.MethodGet, fmt.Sprintf("/api/contracts/%d", contract.ID), nil, http.StatusNotFound, nil)
+
+	// Deleting the project keeps its invoice
+	s.do(http.MethodDelete, fmt.Sprintf("/api/projects/%d", project.ID), nil, http.StatusOK, nil)
+	s.do(http.MethodGet, fmt.Sprintf("/api/projects/%d", project.ID), nil, http.StatusNotFound, nil)
+
+	// Reports and diagnostics
+	s.do(http.MethodGet, "/api/reports?year=2024", nil, http.StatusOK, nil)
+	s.do(http.MethodGet, "/api/reports?basis=barter", nil, http.StatusBadRequest, nil)
+	s.do(http.MethodGet, fmt.Sprintf("/api/audit-log?entity_type=client&entity_id=%d", client.ID), nil, http.StatusOK, nil)
+	s.do(http.MethodGet, "/api/diagnostics/numbering?year=2024", nil, http.StatusOK, nil)
+	s.do(http.MethodGet, "/api/closings/2024/check", nil, http.StatusOK, nil)
+	if resp := s.do(http.MethodGet, "/api/export?format=datev&from=2024-01-01&to=2024-12-31", nil, http.StatusOK, nil); !strings.Contains(resp.Header.Get("Content-Disposition"), "EXTF_") {
+		t.Errorf("Expected a DATEV file name, got %q", resp.Header.Get("Content-Disposition"))
+	}
+	s.do(http.MethodGet, "/api/export", nil, http.StatusOK, nil)
+	s.do(http.MethodGet, "/api/export?format=saft", nil, http.StatusBadRequest, nil)
+	s.do(http.MethodGet, "/api/export?format=datev&from=2024-12-01&to=2025-01-31", nil, http.StatusBadRequest, nil)
+	s.do(http.MethodGet, "/api/export?format=csv&from=yesterday", nil, http.StatusBadRequest, nil)
+
+	// Settings, templates and clauses
+	s.do(http.MethodGet, "/api/settings", nil, http.StatusOK, nil)
+	s.do(http.MethodPost, "/api/settings", map[string]string{services.SettingReportBasis: "cash"}, http.StatusOK, nil)
+	s.do(http.MethodPost, "/api/settings", map[string]string{"no.such_setting": "1"}, http.StatusBadRequest, nil)
+	s.do(http.MethodGet, "/api/email-templates", nil, http.StatusOK, nil)
+	s.do(http.MethodPost, "/api/email-templates", models.EmailTemplate{Kind: "invoice", Language: "de", Subject: "Rechnung {{invoice_number}}", Body: "Guten Tag"}, http.StatusOK, nil)
+	s.do(http.MethodDelete, "/api/email-templates?kind=invoice&language=de", nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, "/api/email-templates?kind=invoice&language=de", nil, http.StatusNotFound, nil)
+	s.do(http.MethodGet, "/api/reverse-charge-clauses", nil, http.StatusOK, nil)
+	s.do(http.MethodPost, "/api/reverse-charge-clauses", models.ReverseChargeClause{Country: "DE", Text: "Steuerschuldnerschaft des Leistungsempfängers"}, http.StatusOK, nil)
+	s.do(http.MethodDelete, "/api/reverse-charge-clauses?country=DE", nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, "/api/reverse-charge-clauses?country=DE", nil, http.StatusNotFound, nil)
+
+	var profiles []models.ComplianceProfile
+	s.do(http.MethodGet, "/api/compliance-profiles", nil, http.StatusOK, &profiles)
+	if len(profiles) != 7 {
+		t.Errorf("Expected the built-in compliance profiles, got %+v", profiles)
+	}
+	s.do(http.MethodPost, "/api/compliance-profiles", models.ComplianceProfile{Country: "AT", Name: "Austria", RequiredFields: []string{"invoice.due_date"}}, http.StatusBadRequest, nil)
+	s.do(http.MethodPost, "/api/compliance-profiles", models.ComplianceProfile{Country: "AT", Name: "Austria",
+		RequiredFields: []string{models.ComplianceBusinessVatID}, GaplessNumbering: true}, http.StatusOK, nil)
+	s.do(http.MethodDelete, "/api/compliance-profiles?country=AT", nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, "/api/compliance-profiles?country=AT", nil, http.StatusNotFound, nil)
+
+	var expenseRates []models.ExpenseRates
+	s.do(http.MethodGet, "/api/expense-rates", nil, http.StatusOK, &expenseRates)
+	if len(expenseRates) != 4 {
+		t.Errorf("Expected the built-in expense rates, got %+v", expenseRates)
+	}
+	s.do(http.MethodPost, "/api/expense-rates", models.ExpenseRates{Country: "AT", Currency: "XXX"}, http.StatusBadRequest, nil)
+	s.do(http.MethodPost, "/api/expense-rates", models.ExpenseRates{Country: "AT", Currency: "EUR", MileageRate: 42, PerDiem: 3000}, http.StatusOK, nil)
+	s.do(http.MethodDelete, "/api/expense-rates?country=AT", nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, "/api/expense-rates?country=AT", nil, http.StatusNotFound, nil)
+
+	// The log
+	s.do(http.MethodGet, "/api/logs?level=WARN&limit=10", nil, http.StatusOK, nil)
+	s.do(http.MethodGet, "/api/logs?level=LOUD", nil, http.StatusBadRequest, nil)
+	var level logLevelRequest
+	s.do(http.MethodGet, "/api/logs/level", nil, http.StatusOK, &level)
+	s.do(http.MethodPut, "/api/logs/level", level, http.StatusOK, nil)
+	s.do(http.MethodPut, "/api/logs/level", logLevelRequest{Level: "LOUD"}, http.StatusBadRequest, nil)
+
+	// Users, sessions and API tokens
+	var me currentUserResponse
+	s.do(http.MethodGet, "/api/auth/me", nil, http.StatusOK, &me)
+	if me.Mode != services.AuthModeProxy || me.User == nil || me.User.Username != "e2e" {
+		t.Errorf("Unexpected current user: %+v", me)
+	}
+	s.do(http.MethodGet, "/api/auth/sessions", nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, "/api/auth/sessions/0123456789abcdef", nil, http.StatusNotFound, nil)
+	var token createTokenResponse
+	s.do(http.MethodPost, "/api/tokens", createTokenRequest{Name: "Reporting", Scopes: []string{models.TokenScopeRead}}, http.StatusCreated, &token)
+	s.do(http.MethodPost, "/api/tokens", createTokenRequest{Name: "Everything", Scopes: []string{"admin"}}, http.StatusBadRequest, nil)
+	s.do(http.MethodGet, "/api/tokens", nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, fmt.Sprintf("/api/tokens/%d", token.ID), nil, http.StatusOK, nil)
+
+	// The event stream and the API description
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, s.url+"/api/events", nil)
+	req.Header.Set("Remote-User", "e2e")
+	events, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /api/events failed: %v", err)
+	}
+	if events.StatusCode != http.StatusOK || !strings.HasPrefix(events.Header.Get("Content-Type"), "text/event-stream") {
+		t.Errorf("GET /api/events = %d %s, want an event stream", events.StatusCode, events.Header.Get("Content-Type"))
+	}
+	cancel()
+	events.Body.Close()
+	s.requested[http.MethodGet+" /api/events"] = true
+	s.do(http.MethodGet, "/api/openapi.json", nil, http.StatusOK, nil)
+
+	// A backup restored over the running database
+	var backup backupCreatedResponse
+	s.do(http.MethodPost, "/api/backups", nil, http.StatusOK, &backup)
+	var backups []services.BackupInfo
+	s.do(http.MethodGet, "/api/backups", nil, http.StatusOK, &backups)
+	if len(backups) != 1 || backups[0].Filename != backup.Filename {
+		t.Fatalf("Expected backup %s to be listed, got %+v", backup.Filename, backups)
+	}
+	s.do(http.MethodDelete, fmt.Sprintf("/api/clients/%d", other.ID), nil, http.StatusOK, nil)
+	s.do(http.MethodPost, "/api/backups/restore?filename="+backup.Filename, nil, http.StatusOK, nil)
+	s.do(http.MethodPost, "/api/backups/restore?filename=../invoices.db", nil, http.StatusBadRequest, nil)
+	s.do(http.MethodGet, fmt.Sprintf("/api/clients/%d", other.ID), nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, "/api/backups?filename="+backup.Filename, nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, "/api/backups?filename="+backup.Filename, nil, http.StatusNotFound, nil)
+
+	// Closing 2024 locks its invoices
+	s.do(http.MethodPost, "/api/closings", closeYearRequest{Year: 2024, AcknowledgeGaps: true}, http.StatusOK, nil)
+	s.do(http.MethodPost, "/api/closings", closeYearRequest{Year: 2024, AcknowledgeGaps: true}, http.StatusConflict, nil)
+	s.do(http.MethodGet, "/api/closings", nil, http.StatusOK, nil)
+	s.do(http.MethodGet, "/api/closings/2024/pdf", nil, http.StatusOK, nil)
+	s.do(http.MethodGet, "/api/closings/2023/pdf", nil, http.StatusNotFound, nil)
+	late := invoiceBody("2024-12-30", models.InvoiceTypeInvoice)
+	delete(late["invoice"].(map[string]interface{}), "project_id")
+	s.do(http.MethodPost, "/api/invoices", late, http.StatusConflict, nil)
+
+	s.checkCoverage()
+}
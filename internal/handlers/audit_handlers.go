@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// AuditLogAPIHandler returns audit log entries
+// Query parameters: entity_type, entity_id, limit
+func (h *AppHandler) AuditLogAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	query := r.URL.Query()
+	entityID := 0
+	if raw := query.Get("entity_id"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid entity ID: %s", raw), nil)
+			return
+		}
+		entityID = id
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	entries, err := h.dbService.GetAuditLog(query.Get("entity_type"), entityID, limit)
+	if err != nil {
+		h.writeInternalError(w, "Failed to read audit log", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+const (
+	sessionCookieName   = "simple_invoice_session"
+	oidcStateCookieName = "simple_invoice_oidc_state"
+	// deviceCookieName holds a random ID that recognizes the browser at the next sign-in
+	deviceCookieName = "simple_invoice_device"
+	deviceCookieAge  = 365 * 24 * time.Hour
+)
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+// currentUser returns the signed-in user, or nil when authentication is disabled
+func currentUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(userContextKey).(*models.User)
+	return user
+}
+
+// RequireAuth wraps the application so that every request, apart from the
+// sign-in endpoints, static files, shared PDF links and PayPal payment
+// notifications, needs an authenticated user. Scripts authenticate with an API
+// token limited to its scopes.
+func (h *AppHandler) RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mode := h.authService.Mode()
+		if mode == services.AuthModeNone || strings.HasPrefix(r.URL.Path, "/auth/") || strings.HasPrefix(r.URL.Path, "/static/") || isPWAAsset(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// PayPal payment notifications are verified with PayPal by their handler
+		if r.URL.Path == paypalIPNPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Signed PDF links carry their own token, which the PDF handlers verify
+		isPDF := strings.HasPrefix(r.URL.Path, "/invoices/pdf/") || strings.HasPrefix(r.URL.Path, "/data/pdfs/") ||
+			strings.HasPrefix(r.URL.Path, "/data/previews/")
+		if isPDF && r.URL.Query().Has("token") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var user *models.User
+		var token *models.APIToken
+		var err error
+		if secret, ok := bearerToken(r); ok {
+			user, token, err = h.authService.TokenUser(secret)
+		} else if mode == services.AuthModeProxy {
+			user, err = h.authService.ProxyUser(r)
+		} else if cookie, cookieErr := r.Cookie(sessionCookieName); cookieErr == nil {
+			user, err = h.authService.SessionUser(cookie.Value, h.authService.ClientIP(r))
+		}
+		isAPI := strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/graphql"
+		if err != nil {
+			if isAPI {
+				h.writeInternalError(w, "Authentication failed", err)
+				return
+			}
+			h.logger.Error("Failed to authenticate request: %v", err)
+			http.Error(w, "Authentication failed", http.StatusInternalServerError)
+			return
+		}
+
+		if user == nil {
+			if isAPI {
+				h.writeError(w, http.StatusUnauthorized, errCodeUnauthorized, "Authentication required", nil)
+				return
+			}
+			if mode == services.AuthModeOIDC && r.Method == http.MethodGet {
+				http.Redirect(w, r, "/auth/login?next="+base64.RawURLEncoding.EncodeToString([]byte(r.URL.RequestURI())), http.StatusFound)
+				return
+			}
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		if token != nil {
+			scope := requiredTokenScope(r)
+			if scope == "" {
+				h.writeError(w, http.StatusForbidden, errCodeForbidden, "API tokens cannot be used for this request, sign in instead", nil)
+				return
+			}
+			if !token.Allows(scope) {
+				h.writeError(w, http.StatusForbidden, errCodeForbidden, fmt.Sprintf("The API token %s does not have the %s scope", token.Name, scope), nil)
+				return
+			}
+		}
+
+		setAccessLogUser(r, user)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
+	})
+}
+
+// bearerToken returns the token of an Authorization: Bearer header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// requiredTokenScope returns the scope an API token needs for a request, or
+// "" if tokens cannot make it at all: API tokens, sessions and settings,
+// which choose the hooks that are run, are only managed by signed-in users,
+// and only they can read the log, change its level and see the runtime
+// metrics, which include the command line of the process
+func requiredTokenScope(r *http.Request) string {
+	path := r.URL.Path
+	readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+	switch {
+	case path == "/api/tokens" || strings.HasPrefix(path, "/api/tokens/"):
+		return ""
+	case path == "/api/auth/sessions" || strings.HasPrefix(path, "/api/auth/sessions/"):
+		return ""
+	case path == "/api/settings" && !readOnly:
+		return ""
+	case path == "/api/logs" || strings.HasPrefix(path, "/api/logs/") || path == "/debug/vars":
+		return ""
+	case path == "/api/backups" || strings.HasPrefix(path, "/api/backups/"):
+		return models.TokenScopeBackupsAdmin
+	case readOnly || path == "/graphql":
+		// GraphQL only answers queries
+		return models.TokenScopeRead
+	default:
+		return models.TokenScopeInvoicesWrite
+	}
+}
+
+// LoginHandler redirects the browser to the OIDC provider
+func (h *AppHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider := h.authService.OIDC()
+	if provider == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	state, err := services.RandomToken()
+	nonce, nonceErr := services.RandomToken()
+	verifier, verifierErr := services.RandomToken()
+	if err != nil || nonceErr != nil || verifierErr != nil {
+		h.logger.Error("Failed to generate OIDC state")
+		http.Error(w, "Failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		h.logger.Error("Failed to start OIDC sign-in: %v", err)
+		http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	// The state cookie ties the callback to this browser; next is already base64url encoded
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    strings.Join([]string{state, nonce, verifier, r.URL.Query().Get("next")}, "."),
+		Path:     "/auth/",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   h.authService.SecureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// CallbackHandler completes the OIDC sign-in and starts a session
+func (h *AppHandler) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider := h.authService.OIDC()
+	if provider == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		h.logger.Warn("OIDC sign-in failed: %s %s", errCode, query.Get("error_description"))
+		http.Error(w, "Sign-in failed: "+errCode, http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookieName)
+	if err != nil {
+		http.Error(w, "Sign-in expired, please try again", http.StatusBadRequest)
+		return
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 4 || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(query.Get("state"))) != 1 {
+		http.Error(w, "Invalid sign-in state", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookieName, Path: "/auth/", MaxAge: -1})
+
+	claims, err := provider.Exchange(r.Context(), query.Get("code"), parts[2], parts[1])
+	if err != nil {
+		h.logger.Error("Failed to complete OIDC sign-in: %v", err)
+		http.Error(w, "Sign-in failed", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.authService.OIDCUser(claims)
+	if err != nil {
+		h.logger.Error("Failed to provision user: %v", err)
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	ipAddress, userAgent := h.authService.ClientIP(r), r.UserAgent()
+	token, expiresAt, err := h.authService.CreateSession(user.ID, ipAddress, userAgent)
+	if err != nil {
+		h.logger.Error("Failed to create session: %v", err)
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+	h.recordLogin(w, r, user, ipAddress, userAgent)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   h.authService.SecureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	h.logger.Info("User %s signed in", user.Username)
+	http.Redirect(w, r, safeRedirectPath(parts[3]), http.StatusFound)
+}
+
+// recordLogin records a sign-in with the device it came from and notifies
+// about sign-ins from devices the user has not used before. The device is
+// recognized by a cookie, which is set on its first sign-in.
+func (h *AppHandler) recordLogin(w http.ResponseWriter, r *http.Request, user *models.User, ipAddress, userAgent string) {
+	deviceID := ""
+	if cookie, err := r.Cookie(deviceCookieName); err == nil && len(cookie.Value) <= 64 {
+		deviceID = cookie.Value
+	}
+	if deviceID == "" {
+		var err error
+		if deviceID, err = services.RandomToken(); err != nil {
+			h.logger.Error("Failed to generate device ID: %v", err)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     deviceCookieName,
+		Value:    deviceID,
+		Path:     "/auth/",
+		MaxAge:   int(deviceCookieAge.Seconds()),
+		HttpOnly: true,
+		Secure:   h.authService.SecureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	newDevice, err := h.authService.RecordLogin(user.ID, deviceID, ipAddress, userAgent)
+	if err != nil {
+		h.logger.Error("Failed to record sign-in of %s: %v", user.Username, err)
+		return
+	}
+	if newDevice {
+		h.logger.Warn("User %s signed in from a new device at %s", user.Username, ipAddress)
+		h.notificationService.Notify(services.Notification{
+			Event:   services.EventLoginNewDevice,
+			Title:   fmt.Sprintf("New sign-in for %s", user.Username),
+			Message: fmt.Sprintf("%s signed in from %s with %s, a device not used before. If this was not them, revoke the session on the Settings page.", user.Username, ipAddress, models.DescribeUserAgent(userAgent)),
+		})
+	}
+}
+
+// SessionsAPIHandler handles /api/auth/sessions and /api/auth/sessions/{id}.
+// GET lists the sessions of the signed-in user, DELETE revokes one.
+func (h *AppHandler) SessionsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user := currentUser(r)
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/auth/sessions"), "/")
+	if id != "" {
+		if r.Method != http.MethodDelete {
+			h.writeMethodNotAllowed(w)
+			return
+		}
+		if user == nil {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Session not found with ID: %s", id), nil)
+			return
+		}
+		if err := h.authService.RevokeSession(user.ID, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Session not found with ID: %s", id), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to revoke session", err)
+			return
+		}
+		json.NewEncoder(w).Encode(messageResponse{Message: "Session revoked"})
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+	// Only OIDC sign-ins have sessions; the proxy signs users in itself
+	sessions := []models.Session{}
+	if user != nil {
+		current := ""
+		if cookie, err := r.Cookie(sessionCookieName); err == nil {
+			current = cookie.Value
+		}
+		var err error
+		if sessions, err = h.authService.Sessions(user.ID, current); err != nil {
+			h.writeInternalError(w, "Failed to load sessions", err)
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// LogoutHandler ends the OIDC session. Only POST is accepted, so a link or
+// image on another site cannot sign the user out.
+func (h *AppHandler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if err := h.authService.DeleteSession(cookie.Value); err != nil {
+			h.logger.Error("Failed to delete session: %v", err)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// CurrentUserAPIHandler returns the authentication mode and the signed-in user
+func (h *AppHandler) CurrentUserAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"mode": h.authService.Mode(),
+		"user": currentUser(r),
+	})
+}
+
+// safeRedirectPath decodes the path to return to after sign-in, allowing only
+// local paths so the callback cannot be used as an open redirect
+func safeRedirectPath(encoded string) string {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	path := string(decoded)
+	if err != nil || !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	return path
+}
@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
 	"os"
 	"path/filepath"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
 )
 
 // BackupsHandler handles the backups page
@@ -46,8 +48,7 @@ func (h *AppHandler) BackupsAPIHandler(w http.ResponseWriter, r *http.Request) {
 		// List backups
 		backups, err := h.backupService.ListBackups()
 		if err != nil {
-			h.logger.Error("Failed to list backups: %v", err)
-			http.Error(w, fmt.Sprintf("Failed to list backups: %v", err), http.StatusInternalServerError)
+			h.writeInternalError(w, "Failed to list backups", err)
 			return
 		}
 
@@ -56,38 +57,28 @@ func (h *AppHandler) BackupsAPIHandler(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPost:
 		// Create backup
 		h.logger.Info("Creating backup")
-		if err := h.backupService.CreateBackup(); err != nil {
-			h.logger.Error("Failed to create backup: %v", err)
-			http.Error(w, fmt.Sprintf("Failed to create backup: %v", err), http.StatusInternalServerError)
+		filename, err := h.backupService.CreateBackup()
+		if err != nil {
+			h.writeBackupError(w, "Failed to create backup", err)
 			return
 		}
 
 		h.logger.Info("Backup created successfully")
-		json.NewEncoder(w).Encode(map[string]string{"message": "Backup created successfully"})
+		h.events.Publish(services.LiveEventBackupCreated, backupCreatedEvent{Filename: filename})
+		json.NewEncoder(w).Encode(map[string]string{"message": "Backup created successfully", "filename": filename})
 
 	case http.MethodDelete:
 		// Delete backup
 		filename := r.URL.Query().Get("filename")
 		if filename == "" {
 			h.logger.Warn("No filename provided for backup deletion")
-			http.Error(w, "Filename is required", http.StatusBadRequest)
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Filename is required", nil)
 			return
 		}
 
 		h.logger.Info("Deleting backup: %s", filename)
-		backupPath := filepath.Join(h.dataDir, "backups", filename)
-
-		// Check if file exists
-		if _, err := os.Stat(backupPath); os.IsNotExist(err) {
-			h.logger.Warn("Backup file not found: %s", backupPath)
-			http.Error(w, "Backup file not found", http.StatusNotFound)
-			return
-		}
-
-		// Delete file
-		if err := os.Remove(backupPath); err != nil {
-			h.logger.Error("Failed to delete backup: %v", err)
-			http.Error(w, fmt.Sprintf("Failed to delete backup: %v", err), http.StatusInternalServerError)
+		if err := h.backupService.DeleteBackup(filename); err != nil {
+			h.writeBackupError(w, "Failed to delete backup", err)
 			return
 		}
 
@@ -96,7 +87,7 @@ func (h *AppHandler) BackupsAPIHandler(w http.ResponseWriter, r *http.Request) {
 
 	default:
 		h.logger.Warn("Method not allowed: %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		h.writeMethodNotAllowed(w)
 	}
 }
 
@@ -106,21 +97,20 @@ func (h *AppHandler) RestoreBackupHandler(w http.ResponseWriter, r *http.Request
 
 	if r.Method != http.MethodPost {
 		h.logger.Warn("Method not allowed: %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		h.writeMethodNotAllowed(w)
 		return
 	}
 
 	filename := r.URL.Query().Get("filename")
 	if filename == "" {
 		h.logger.Warn("No filename provided for backup restoration")
-		http.Error(w, "Filename is required", http.StatusBadRequest)
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Filename is required", nil)
 		return
 	}
 
 	h.logger.Info("Restoring backup: %s", filename)
 	if err := h.backupService.RestoreBackup(filename); err != nil {
-		h.logger.Error("Failed to restore backup: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to restore backup: %v", err), http.StatusInternalServerError)
+		h.writeBackupError(w, "Failed to restore backup", err)
 		return
 	}
 
@@ -130,8 +120,7 @@ func (h *AppHandler) RestoreBackupHandler(w http.ResponseWriter, r *http.Request
 
 		// Reopen the database connection
 		if err := h.dbService.ReopenConnection(); err != nil {
-			h.logger.Error("Failed to reopen database connection: %v", err)
-			http.Error(w, fmt.Sprintf("Backup restored but failed to reopen database connection: %v", err), http.StatusInternalServerError)
+			h.writeInternalError(w, "Backup restored but failed to reopen database connection", err)
 			return
 		}
 
@@ -144,3 +133,21 @@ func (h *AppHandler) RestoreBackupHandler(w http.ResponseWriter, r *http.Request
 	h.logger.Info("Backup restored successfully: %s", filename)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Backup restored successfully"})
 }
+
+// writeBackupError responds to a failed backup operation, distinguishing
+// invalid and missing backup files from internal errors
+func (h *AppHandler) writeBackupError(w http.ResponseWriter, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidBackupName):
+		h.logger.Warn("%s: %v", message, err)
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "Invalid backup filename", nil)
+	case errors.Is(err, services.ErrBackupNotFound):
+		h.logger.Warn("%s: %v", message, err)
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, "Backup file not found", nil)
+	case errors.Is(err, services.ErrBackupUnsupported):
+		h.logger.Warn("%s: %v", message, err)
+		h.writeError(w, http.StatusNotImplemented, errCodeBackupUnsupported, "Back up the PostgreSQL database with pg_dump instead", nil)
+	default:
+		h.writeInternalError(w, message, err)
+	}
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// bankSyncStatus is the response of GET /api/bank-sync
+type bankSyncStatus struct {
+	Configured  bool                    `json:"configured"`
+	Connections []models.BankConnection `json:"connections"`
+}
+
+// bankConnectRequest is the body of POST /api/bank-sync/connections
+type bankConnectRequest struct {
+	InstitutionID string `json:"institution_id"`
+}
+
+// writeBankSyncError reports a failed call to GoCardless
+func (h *AppHandler) writeBankSyncError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrBankSyncNotConfigured) {
+		h.writeError(w, http.StatusServiceUnavailable, errCodeBankSyncFailed, err.Error(), nil)
+		return
+	}
+	h.logger.Error("Bank sync failed: %v", err)
+	h.writeError(w, http.StatusBadGateway, errCodeBankSyncFailed, err.Error(), nil)
+}
+
+// BankSyncHandler lists the connected banks, or syncs their incoming payments
+// now instead of waiting for the next periodic sync
+// Routes: GET, POST /api/bank-sync
+func (h *AppHandler) BankSyncHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		connections, err := h.bankSyncService.Connections()
+		if err != nil {
+			h.writeInternalError(w, "Failed to load bank connections", err)
+			return
+		}
+		json.NewEncoder(w).Encode(bankSyncStatus{Configured: h.bankSyncService.Configured(), Connections: connections})
+
+	case http.MethodPost:
+		result, err := h.bankSyncService.Sync()
+		if err != nil {
+			h.writeBankSyncError(w, err)
+			return
+		}
+		h.logger.Info("Synced %d new incoming payments from %d bank connections", result.New, result.Connections)
+		json.NewEncoder(w).Encode(result)
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// BankInstitutionsHandler lists the banks of a country that can be connected
+// Route: GET /api/bank-sync/institutions?country=
+func (h *AppHandler) BankInstitutionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+	country := strings.TrimSpace(r.URL.Query().Get("country"))
+	if len(country) != 2 {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "country must be an ISO 3166-1 alpha-2 country code", nil)
+		return
+	}
+
+	institutions, err := h.bankSyncService.Institutions(country)
+	if err != nil {
+		h.writeBankSyncError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(institutions)
+}
+
+// BankConnectionsHandler connects a bank, or disconnects one by ID
+// Routes: POST /api/bank-sync/connections, DELETE /api/bank-sync/connections/{id}
+func (h *AppHandler) BankConnectionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if idStr := strings.TrimPrefix(r.URL.Path, "/api/bank-sync/connections/"); idStr != r.URL.Path && idStr != "" {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid bank connection ID: %s", idStr), nil)
+			return
+		}
+		if r.Method != http.MethodDelete {
+			h.writeMethodNotAllowed(w)
+			return
+		}
+		if err := h.bankSyncService.Disconnect(id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Bank connection not found with ID: %d", id), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to disconnect bank", err)
+			return
+		}
+		h.logger.Info("Disconnected bank connection %d", id)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Bank disconnected successfully"})
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+	var req bankConnectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeBodyError(w, fmt.Sprintf("Invalid request body: %v", err), err)
+		return
+	}
+	if strings.TrimSpace(req.InstitutionID) == "" {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "institution_id is required", nil)
+		return
+	}
+
+	// The bank sends the account holder back to the reconciliation screen
+	connection, err := h.bankSyncService.Connect(strings.TrimSpace(req.InstitutionID), absoluteURL(r, "/invoices/reconcile?bank=linked"))
+	if err != nil {
+		h.writeBankSyncError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(connection)
+}
+
+// BankSyncMatchesHandler proposes the open invoices paid by the synced
+// incoming payments that were not reconciled yet, like an uploaded statement
+// Route: GET /api/bank-sync/matches
+func (h *AppHandler) BankSyncMatchesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+	transactions, err := h.bankSyncService.UnreconciledTransactions()
+	if err != nil {
+		h.writeInternalError(w, "Failed to load synced payments", err)
+		return
+	}
+	result, err := h.importService.MatchTransactions(services.BankSyncSource, transactions)
+	if err != nil {
+		h.writeInternalError(w, "Failed to match synced payments", err)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
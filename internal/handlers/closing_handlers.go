@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// closeYearRequest is the body of POST /api/closings
+type closeYearRequest struct {
+	Year int `json:"year"`
+	// AcknowledgeGaps closes the year even though invoice numbers are missing;
+	// they are listed in the closing summary
+	AcknowledgeGaps bool `json:"acknowledge_gaps,omitempty"`
+}
+
+// ClosingsAPIHandler handles /api/closings, the closed fiscal years, and
+// /api/closings/{year}/check and /api/closings/{year}/pdf
+func (h *AppHandler) ClosingsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/closings"), "/")
+	if rest != "" {
+		h.closingHandler(w, r, rest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		closings, err := h.closingService.List()
+		if err != nil {
+			h.writeInternalError(w, "Failed to list closed years", err)
+			return
+		}
+		json.NewEncoder(w).Encode(closings)
+
+	case http.MethodPost:
+		var request closeYearRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			h.writeBodyError(w, fmt.Sprintf("Invalid request body: %v", err), err)
+			return
+		}
+
+		closing, err := h.closingService.Close(request.Year, request.AcknowledgeGaps)
+		switch {
+		case errors.Is(err, services.ErrYearNotOver):
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Only past years can be closed, not %d", request.Year), nil)
+			return
+		case errors.Is(err, services.ErrYearAlreadyClosed):
+			h.writeError(w, http.StatusConflict, errCodeYearClosed, fmt.Sprintf("The year %d is already closed", request.Year), nil)
+			return
+		case errors.Is(err, services.ErrSequenceGaps):
+			check, checkErr := h.closingService.CheckSequence(request.Year)
+			if checkErr != nil {
+				h.writeInternalError(w, "Failed to check the invoice numbering", checkErr)
+				return
+			}
+			h.writeError(w, http.StatusConflict, errCodeSequenceGaps,
+				fmt.Sprintf("Invoice numbers of %d are missing: %s. Close the year with acknowledge_gaps to list them in the closing summary.",
+					request.Year, strings.Join(check.GapList(), ", ")), check)
+			return
+		case err != nil:
+			h.writeInternalError(w, "Failed to close year", err)
+			return
+		}
+		json.NewEncoder(w).Encode(closing)
+
+	default:
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// closingHandler handles GET /api/closings/{year}/check and /api/closings/{year}/pdf
+func (h *AppHandler) closingHandler(w http.ResponseWriter, r *http.Request, rest string) {
+	yearStr, subresource, _ := strings.Cut(rest, "/")
+	year, err := strconv.Atoi(yearStr)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid year: %s", yearStr), nil)
+		return
+	}
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	switch subresource {
+	case "check":
+		check, err := h.closingService.CheckSequence(year)
+		if err != nil {
+			h.writeInternalError(w, "Failed to check the invoice numbering", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(check)
+
+	case "pdf":
+		closing, err := h.closingService.Get(year)
+		if errors.Is(err, sql.ErrNoRows) {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("The year %d is not closed", year), nil)
+			return
+		}
+		if err != nil {
+			h.writeInternalError(w, "Failed to load the closing", err)
+			return
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", closing.SummaryFilename))
+		h.serveDataFile(w, r, "pdfs", closing.SummaryFilename, []string{".pdf"})
+
+	default:
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, "Not found", nil)
+	}
+}
+
+// NumberingDiagnosticsAPIHandler handles GET /api/diagnostics/numbering, the
+// gaps and duplicates in the invoice numbering of every year or of ?year=
+func (h *AppHandler) NumberingDiagnosticsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	if yearStr := r.URL.Query().Get("year"); yearStr != "" {
+		year, err := strconv.Atoi(yearStr)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid year: %s", yearStr), nil)
+			return
+		}
+		check, err := h.closingService.CheckSequence(year)
+		if err != nil {
+			h.writeInternalError(w, "Failed to check the invoice numbering", err)
+			return
+		}
+		json.NewEncoder(w).Encode([]models.SequenceCheck{*check})
+		return
+	}
+
+	checks, err := h.closingService.CheckAllSequences()
+	if err != nil {
+		h.writeInternalError(w, "Failed to check the invoice numbering", err)
+		return
+	}
+	json.NewEncoder(w).Encode(checks)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// ComplianceProfilesAPIHandler handles compliance profile API requests
+// GET lists the profiles, POST saves one for a country, DELETE ?country= removes it
+func (h *AppHandler) ComplianceProfilesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		profiles, err := h.complianceService.List()
+		if err != nil {
+			h.writeInternalError(w, "Failed to list compliance profiles", err)
+			return
+		}
+		json.NewEncoder(w).Encode(profiles)
+
+	case http.MethodPost:
+		var profile models.ComplianceProfile
+		if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+			h.logger.Error("Failed to decode compliance profile: %v", err)
+			h.writeBodyError(w, fmt.Sprintf("Invalid request body: %v", err), err)
+			return
+		}
+
+		if err := h.complianceService.Save(&profile); err != nil {
+			h.logger.Error("Failed to save compliance profile: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, err.Error(), nil)
+			return
+		}
+		json.NewEncoder(w).Encode(profile)
+
+	case http.MethodDelete:
+		country := r.URL.Query().Get("country")
+		if err := h.complianceService.Delete(country); err != nil {
+			if errors.Is(err, services.ErrComplianceProfileNotFound) {
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("No customized compliance profile for country %s", country), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to delete compliance profile", err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"message": "Compliance profile deleted successfully"})
+
+	default:
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// checkCompliance checks an invoice that is being finalized against the
+// compliance profile of its business. Drafts and pro-forma invoices are not
+// checked.
+func (h *AppHandler) checkCompliance(invoice *models.Invoice) error {
+	if invoice.Status == models.InvoiceStatusDraft || invoice.IsProforma() {
+		return nil
+	}
+	business, err := h.businesses.GetBusiness(invoice.BusinessID)
+	if err != nil {
+		return fmt.Errorf("failed to load business: %w", err)
+	}
+	client, err := h.clients.GetClient(invoice.ClientID)
+	if err != nil {
+		return fmt.Errorf("failed to load client: %w", err)
+	}
+	return h.complianceService.Check(invoice, business, client)
+}
+
+// writeComplianceError responds to a change the compliance profile of the
+// business does not allow with the given status and the problems as details
+func (h *AppHandler) writeComplianceError(w http.ResponseWriter, status int, err error) {
+	var violation *services.ComplianceError
+	if errors.As(err, &violation) {
+		h.writeError(w, status, errCodeComplianceFailed, err.Error(),
+			map[string]interface{}{"country": violation.Country, "problems": violation.Problems})
+		return
+	}
+	h.writeInternalError(w, "Failed to check the compliance profile", err)
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/refdata"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// contractRequest is the body of POST /api/contracts. Dates are YYYY-MM-DD;
+// end_date and invoiced_through may be empty.
+type contractRequest struct {
+	models.Contract
+	StartDate       string `json:"start_date"`
+	EndDate         string `json:"end_date"`
+	InvoicedThrough string `json:"invoiced_through"`
+}
+
+// contractView is a contract with the name of its client, for the contracts page
+type contractView struct {
+	models.Contract
+	ClientName string
+}
+
+// generateContractInvoicesResponse is the response of POST /api/contracts/generate
+type generateContractInvoicesResponse struct {
+	Invoices []models.Invoice `json:"invoices"` // The draft invoices created
+	Errors   []string         `json:"errors"`   // Contracts that could not be invoiced
+}
+
+// ContractsHandler handles the contracts page
+func (h *AppHandler) ContractsHandler(w http.ResponseWriter, r *http.Request) {
+	contracts, err := h.contractService.List()
+	if err != nil {
+		h.writeInternalError(w, "Failed to load contracts", err)
+		return
+	}
+	clients, err := h.clients.GetClients()
+	if err != nil {
+		h.writeInternalError(w, "Failed to load clients", err)
+		return
+	}
+
+	clientNames := make(map[int]string, len(clients))
+	for _, client := range clients {
+		clientNames[client.ID] = client.Name
+	}
+	views := make([]contractView, len(contracts))
+	for i, contract := range contracts {
+		views[i] = contractView{Contract: contract, ClientName: clientNames[contract.ClientID]}
+	}
+
+	data := map[string]interface{}{
+		"Title":       "Contracts",
+		"Contracts":   views,
+		"Clients":     clients,
+		"Currencies":  invoiceCurrencies(),
+		"Today":       time.Now().Format("2006-01-02"),
+		"CurrentYear": time.Now().Year(),
+	}
+
+	h.renderTemplate(w, "contracts", data)
+}
+
+// ContractsAPIHandler handles /api/contracts, /api/contracts/generate and
+// /api/contracts/{id} with its invoices
+func (h *AppHandler) ContractsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/contracts"), "/")
+	if rest == "" {
+		h.contractsHandler(w, r)
+		return
+	}
+	if rest == "generate" {
+		h.generateContractInvoicesHandler(w, r)
+		return
+	}
+
+	idStr, subresource, _ := strings.Cut(rest, "/")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid contract ID format: %s", idStr), nil)
+		return
+	}
+	contract, err := h.contractService.Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Contract not found with ID: %d", id), nil)
+		return
+	}
+	if err != nil {
+		h.writeInternalError(w, "Failed to load contract", err)
+		return
+	}
+
+	if subresource == "invoices" {
+		if r.Method != http.MethodGet {
+			h.writeMethodNotAllowed(w)
+			return
+		}
+		invoices, err := h.contractService.Invoices(id)
+		if err != nil {
+			h.writeInternalError(w, "Failed to load contract invoices", err)
+			return
+		}
+		json.NewEncoder(w).Encode(invoices)
+		return
+	}
+	if subresource != "" {
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, "Not found", nil)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(contract)
+
+	case http.MethodDelete:
+		if err := h.contractService.Delete(id); err != nil {
+			h.writeInternalError(w, "Failed to delete contract", err)
+			return
+		}
+		json.NewEncoder(w).Encode(messageResponse{Message: "Contract deleted successfully"})
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// contractsHandler handles GET and POST /api/contracts. GET lists the
+// contracts, POST creates or updates one.
+func (h *AppHandler) contractsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		contracts, err := h.contractService.List()
+		if err != nil {
+			h.writeInternalError(w, "Failed to load contracts", err)
+			return
+		}
+		if contracts == nil {
+			contracts = []models.Contract{}
+		}
+		page, err := paginate(w, r, contracts)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error(), nil)
+			return
+		}
+		json.NewEncoder(w).Encode(page)
+
+	case http.MethodPost:
+		var req contractRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeBodyError(w, "Invalid request body", err)
+			return
+		}
+		contract := req.Contract
+		if err := h.prepareContract(&contract, req); err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid contract: %v", err), nil)
+			return
+		}
+
+		created := contract.ID == 0
+		if !created {
+			existing, err := h.contractService.Get(contract.ID)
+			if err != nil {
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Contract not found with ID: %d", contract.ID), nil)
+				return
+			}
+			contract.CreatedAt = existing.CreatedAt
+		}
+		if err := h.contractService.Save(&contract); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Contract not found with ID: %d", contract.ID), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to save contract", err)
+			return
+		}
+
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(contract)
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// prepareContract parses the dates of a contract request, fills in the
+// business and currency when they are not given and checks the contract
+func (h *AppHandler) prepareContract(contract *models.Contract, req contractRequest) error {
+	dates := []struct {
+		value    string
+		target   *time.Time
+		name     string
+		required bool
+	}{
+		{req.StartDate, &contract.StartDate, "start date", true},
+		{req.EndDate, &contract.EndDate, "end date", false},
+		{req.InvoicedThrough, &contract.InvoicedThrough, "invoiced through date", false},
+	}
+	for _, date := range dates {
+		*date.target = time.Time{}
+		value := strings.TrimSpace(date.value)
+		if value == "" {
+			if date.required {
+				return fmt.Errorf("%s is required", date.name)
+			}
+			continue
+		}
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return fmt.Errorf("%s %q is not a date like 2024-05-01", date.name, value)
+		}
+		*date.target = parsed
+	}
+
+	client, err := h.clients.GetClient(contract.ClientID)
+	if err != nil {
+		return fmt.Errorf("client not found with ID: %d", contract.ClientID)
+	}
+	if contract.BusinessID == 0 {
+		businesses, err := h.businesses.GetBusinesses()
+		if err != nil || len(businesses) == 0 {
+			return fmt.Errorf("set up the business first")
+		}
+		contract.BusinessID = businesses[0].ID
+	}
+	business, err := h.businesses.GetBusiness(contract.BusinessID)
+	if err != nil {
+		return fmt.Errorf("business not found with ID: %d", contract.BusinessID)
+	}
+	if err := checkVatExemption(business, &models.Invoice{VatRate: contract.VatRate, ReverseChargeVat: contract.ReverseChargeVat}); err != nil {
+		return err
+	}
+
+	contract.Currency = strings.ToUpper(strings.TrimSpace(contract.Currency))
+	if contract.Currency == "" {
+		contract.Currency = services.GetCurrencyForCountry(client.Country)
+	}
+	if !refdata.IsCurrencyCode(contract.Currency) {
+		return fmt.Errorf("%q is not a currency code like EUR", contract.Currency)
+	}
+	contract.MonthlyAmount = contract.MonthlyAmount.Round(contract.Currency)
+	return contract.Validate()
+}
+
+// generateContractInvoicesHandler creates the contract invoices that are due
+// today without waiting for the hourly check.
+// Route: POST /api/contracts/generate
+func (h *AppHandler) generateContractInvoicesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	invoices, err := h.contractService.GenerateInvoices(time.Now(), h.lookupExchangeRate)
+	response := generateContractInvoicesResponse{Invoices: invoices, Errors: []string{}}
+	if response.Invoices == nil {
+		response.Invoices = []models.Invoice{}
+	}
+	if err != nil {
+		h.logger.Error("Failed to generate contract invoices: %v", err)
+		response.Errors = strings.Split(err.Error(), "\n")
+	}
+	json.NewEncoder(w).Encode(response)
+}
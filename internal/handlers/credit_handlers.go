@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// clientCreditsResponse is the response of GET /api/clients/{id}/credits
+type clientCreditsResponse struct {
+	Balances []models.CreditBalance `json:"balances"` // Remaining credit per currency
+	Credits  []models.ClientCredit  `json:"credits"`  // Ledger entries, newest first
+}
+
+// addCreditRequest is the body of POST /api/clients/{id}/credits
+type addCreditRequest struct {
+	Amount      models.Money `json:"amount"`
+	Currency    string       `json:"currency,omitempty"` // Defaults to the currency of the client's country
+	Description string       `json:"description,omitempty"`
+}
+
+// applyCreditRequest is the body of POST /api/invoices/{id}/credit
+type applyCreditRequest struct {
+	Amount models.Money `json:"amount,omitempty"` // 0 applies as much credit as possible
+}
+
+// clientCreditsHandler handles GET and POST /api/clients/{id}/credits, the
+// credit ledger of a client. POST records a prepayment or retainer.
+func (h *AppHandler) clientCreditsHandler(w http.ResponseWriter, r *http.Request, clientID int) {
+	client, err := h.clients.GetClient(clientID)
+	if err != nil {
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Client not found with ID: %d", clientID), nil)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		credits, err := h.dbService.GetClientCredits(clientID)
+		if err != nil {
+			h.writeInternalError(w, "Failed to load client credits", err)
+			return
+		}
+		balances, err := h.dbService.GetCreditBalances()
+		if err != nil {
+			h.writeInternalError(w, "Failed to load credit balances", err)
+			return
+		}
+
+		response := clientCreditsResponse{Balances: balances[clientID], Credits: credits}
+		if response.Balances == nil {
+			response.Balances = []models.CreditBalance{}
+		}
+		if response.Credits == nil {
+			response.Credits = []models.ClientCredit{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+
+	case http.MethodPost:
+		var request addCreditRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			h.writeBodyError(w, "Invalid request body", err)
+			return
+		}
+		if request.Amount <= 0 {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "The credit amount must be positive", nil)
+			return
+		}
+		currency := strings.ToUpper(strings.TrimSpace(request.Currency))
+		if currency == "" {
+			currency = services.GetCurrencyForCountry(client.Country)
+		}
+		if len(currency) != 3 || strings.Trim(currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("%q is not a currency code like EUR", request.Currency), nil)
+			return
+		}
+
+		credit := models.ClientCredit{
+			ClientID:    clientID,
+			Amount:      request.Amount.Round(currency),
+			Currency:    currency,
+			Description: strings.TrimSpace(request.Description),
+		}
+		if credit.Description == "" {
+			credit.Description = "Prepayment"
+		}
+		if err := h.dbService.AddClientCredit(&credit); err != nil {
+			h.writeInternalError(w, "Failed to record credit", err)
+			return
+		}
+
+		h.logger.Info("Recorded %s %s credit for client %d", credit.Amount, credit.Currency, clientID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(credit)
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// applyCreditHandler handles POST /api/invoices/{id}/credit, which deducts
+// client credit from the amount due of an invoice
+func (h *AppHandler) applyCreditHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodPost {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	var request applyCreditRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			h.writeBodyError(w, "Invalid request body", err)
+			return
+		}
+	}
+	if request.Amount < 0 {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "The credit amount must not be negative", nil)
+		return
+	}
+
+	invoice, err := h.dbService.ApplyCredit(id, request.Amount)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", id), nil)
+		return
+	case errors.Is(err, services.ErrInsufficientCredit):
+		h.writeError(w, http.StatusConflict, errCodeInsufficientCredit, err.Error(), nil)
+		return
+	case errors.Is(err, services.ErrCreditNotApplicable):
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "Credit can only be applied to invoices, not to pro forma invoices", nil)
+		return
+	case errors.Is(err, services.ErrYearClosed):
+		h.writeError(w, http.StatusConflict, errCodeYearClosed, fmt.Sprintf("Invoices of a closed fiscal year cannot be changed (%v)", err), nil)
+		return
+	case err != nil:
+		h.writeInternalError(w, "Failed to apply credit", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invoice)
+}
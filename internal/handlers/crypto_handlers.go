@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// cryptoPaymentRequest is the body of POST /api/invoices/{id}/crypto-payment
+type cryptoPaymentRequest struct {
+	Amount   models.Money `json:"amount"`              // USDC received
+	PaidDate string       `json:"paid_date,omitempty"` // YYYY-MM-DD, today if empty
+	Rate     float64      `json:"rate,omitempty"`      // Invoice currency units per USDC, the ECB US dollar rate of the payment date if 0
+}
+
+// cryptoPaymentHandler handles POST /api/invoices/{id}/crypto-payment, which
+// marks an invoice paid in USDC and records the amount received with its
+// value in the invoice currency. USDC is valued as US dollars at the ECB
+// reference rate of the payment date, unless the request sets the rate the
+// payment was actually exchanged at.
+func (h *AppHandler) cryptoPaymentHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodPost {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	var request cryptoPaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.writeBodyError(w, "Invalid request body", err)
+		return
+	}
+	if request.Amount <= 0 {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "The amount received must be positive", nil)
+		return
+	}
+	if request.Rate < 0 {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "The rate must not be negative", nil)
+		return
+	}
+
+	now := time.Now()
+	paidDate := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if request.PaidDate != "" {
+		date, err := time.Parse("2006-01-02", request.PaidDate)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid paid date format. Expected YYYY-MM-DD, got: %s", request.PaidDate), nil)
+			return
+		}
+		paidDate = date
+	}
+
+	invoice, _, err := h.invoices.GetInvoice(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", id), nil)
+			return
+		}
+		h.writeInternalError(w, "Failed to load invoice", err)
+		return
+	}
+	if invoice.IsProforma() {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "Pro forma invoices are not paid, convert them into an invoice first", nil)
+		return
+	}
+
+	rate := request.Rate
+	if rate == 0 {
+		rate, _, err = h.exchangeRateService.Rate("USD", invoice.Currency, paidDate)
+		switch {
+		case errors.Is(err, services.ErrUnsupportedCurrency), errors.Is(err, services.ErrExchangeRateUnavailable):
+			h.writeError(w, http.StatusBadRequest, errCodeValidation,
+				fmt.Sprintf("No US dollar to %s rate on %s (%v), send the rate with the payment", invoice.Currency, paidDate.Format("2006-01-02"), err), nil)
+			return
+		case err != nil:
+			h.logger.Error("Failed to look up the US dollar rate: %v", err)
+			h.writeError(w, http.StatusBadGateway, errCodeLookupFailed, "The exchange rate could not be looked up, send the rate with the payment", nil)
+			return
+		}
+	}
+	fiatAmount := request.Amount.Mul(rate).Round(invoice.Currency)
+
+	if err := h.dbService.RecordCryptoPayment(id, request.Amount, rate, fiatAmount, paidDate); err != nil {
+		h.writeInternalError(w, "Failed to record crypto payment", err)
+		return
+	}
+	h.logger.Info("Invoice %s was paid %s %s, worth %s %s", invoice.InvoiceNumber, request.Amount, models.CryptoCurrency, fiatAmount, invoice.Currency)
+	h.publishInvoiceStatus(id)
+	if invoice.Status != models.InvoiceStatusPaid {
+		h.notificationService.Notify(services.Notification{
+			Event:   services.EventInvoicePaid,
+			Title:   fmt.Sprintf("Invoice %s was paid", invoice.InvoiceNumber),
+			Message: fmt.Sprintf("%s %s received, worth %s %s.", request.Amount, models.CryptoCurrency, fiatAmount, invoice.Currency),
+		})
+	}
+
+	invoice, _, err = h.invoices.GetInvoice(id)
+	if err != nil {
+		h.writeInternalError(w, "Failed to load invoice", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invoice)
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// templateCheckInterval is how often the templates are checked for changes in
+// development mode
+const templateCheckInterval = 500 * time.Millisecond
+
+// templateWatch is the running template watcher of development mode
+type templateWatch struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// WatchTemplates re-parses the HTML templates whenever a file in the
+// templates directory or DATA_DIR/templates-override changes, so pages can be customized without restarting
+// the server. A template that fails to parse is logged and the previous
+// templates are kept.
+func (h *AppHandler) WatchTemplates() {
+	if h.templateWatch != nil {
+		return
+	}
+	watch := &templateWatch{stop: make(chan struct{}), done: make(chan struct{})}
+	h.templateWatch = watch
+	h.logger.Info("Watching %s and %s for template changes", templatesDir, filepath.Join(h.dataDir, templateOverrideDir))
+
+	go func() {
+		defer close(watch.done)
+
+		ticker := time.NewTicker(templateCheckInterval)
+		defer ticker.Stop()
+
+		modTime := templatesModTime(h.dataDir)
+		for {
+			select {
+			case <-watch.stop:
+				return
+			case <-ticker.C:
+			}
+
+			latest := templatesModTime(h.dataDir)
+			if latest.Equal(modTime) {
+				continue
+			}
+			modTime = latest
+			h.ReloadTemplates()
+		}
+	}()
+}
+
+// StopTemplateWatch stops the watcher started by WatchTemplates
+func (h *AppHandler) StopTemplateWatch() {
+	if h.templateWatch == nil {
+		return
+	}
+	close(h.templateWatch.stop)
+	<-h.templateWatch.done
+	h.templateWatch = nil
+}
+
+// ReloadTemplates parses the HTML templates again and replaces the current
+// ones if all of them parse
+func (h *AppHandler) ReloadTemplates() error {
+	templates, err := parseTemplates(h.dataDir, h.logger, h.settingsService)
+	if err != nil {
+		h.logger.Error("Failed to reload templates, keeping the previous ones: %v", err)
+		return err
+	}
+	h.templatesMu.Lock()
+	h.templates = templates
+	h.templatesMu.Unlock()
+	h.logger.Info("Reloaded templates")
+	return nil
+}
+
+// templatesModTime returns the latest modification time of the templates.
+// Added and removed files change it as well, since the directory is modified.
+func templatesModTime(dataDir string) time.Time {
+	var paths []string
+	for _, dir := range []string{templatesDir, filepath.Join(dataDir, templateOverrideDir)} {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.html"))
+		paths = append(append(paths, files...), dir)
+	}
+
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
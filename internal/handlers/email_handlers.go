@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"os"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// EmailTemplatesHandler handles the email templates page
+func (h *AppHandler) EmailTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	templates, err := h.emailTemplateService.List()
+	if err != nil {
+		h.logger.Error("Failed to list email templates: %v", err)
+		http.Error(w, "Failed to list email templates", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Title":          "Email Templates",
+		"EmailTemplates": templates,
+		"Kinds":          services.EmailTemplateKinds,
+		"Variables":      services.EmailTemplateVariables,
+		"CurrentYear":    time.Now().Year(),
+	}
+
+	h.renderTemplate(w, "email-templates", data)
+}
+
+// EmailTemplatesAPIHandler handles email template API requests
+// GET lists the templates, POST saves one, DELETE ?kind=&language= removes a customized one
+func (h *AppHandler) EmailTemplatesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		templates, err := h.emailTemplateService.List()
+		if err != nil {
+			h.writeInternalError(w, "Failed to list email templates", err)
+			return
+		}
+		json.NewEncoder(w).Encode(templates)
+
+	case http.MethodPost:
+		var t models.EmailTemplate
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			h.logger.Error("Failed to decode email template: %v", err)
+			h.writeBodyError(w, fmt.Sprintf("Invalid request body: %v", err), err)
+			return
+		}
+
+		if err := h.emailTemplateService.Save(&t); err != nil {
+			h.logger.Error("Failed to save email template: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, err.Error(), nil)
+			return
+		}
+		json.NewEncoder(w).Encode(t)
+
+	case http.MethodDelete:
+		kind, language := r.URL.Query().Get("kind"), r.URL.Query().Get("language")
+		if err := h.emailTemplateService.Delete(kind, language); err != nil {
+			if errors.Is(err, services.ErrEmailTemplateNotFound) {
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("No customized %s template for language %s", kind, language), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to delete email template", err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"message": "Email template deleted successfully"})
+
+	default:
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// emailResponse is an email rendered from a template
+type emailResponse struct {
+	Kind     string `json:"kind"`
+	Language string `json:"language"`
+	Subject  string `json:"subject"`
+	Body     string `json:"body"`
+}
+
+// invoiceEmailHandler renders the email of an invoice in its client's language.
+// Route: GET /api/invoices/{id}/email?kind=invoice|reminder|receipt
+func (h *AppHandler) invoiceEmailHandler(w http.ResponseWriter, r *http.Request, id int) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	data, ok := h.loadInvoiceForEmail(w, id)
+	if !ok {
+		return
+	}
+	email, err := h.renderInvoiceEmail(r, data, r.URL.Query().Get("kind"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, err.Error(), nil)
+		return
+	}
+	json.NewEncoder(w).Encode(email)
+}
+
+// sendInvoiceRequest is the body of POST /api/invoices/{id}/send
+type sendInvoiceRequest struct {
+	To     string    `json:"to"`      // Defaults to the client's email
+	Kind   string    `json:"kind"`    // Email template, defaults to invoice
+	SendAt time.Time `json:"send_at"` // Sends the email at this later time instead of now
+}
+
+// sendInvoiceResponse is returned after an invoice email was queued
+type sendInvoiceResponse struct {
+	Message string     `json:"message"`
+	To      string     `json:"to"`
+	Status  string     `json:"status"`
+	JobID   int        `json:"job_id"`            // The send_invoice_email job delivering the email
+	SendAt  *time.Time `json:"send_at,omitempty"` // When a scheduled email will be sent
+}
+
+// invoiceEmailJobPayload is the payload of a send_invoice_email job. The
+// email is rendered when it is queued, the PDF when it is sent.
+type invoiceEmailJobPayload struct {
+	InvoiceID int    `json:"invoice_id"`
+	Kind      string `json:"kind"`
+	To        string `json:"to"`
+	Subject   string `json:"subject"`
+	Body      string `json:"body"`
+	Scheduled bool   `json:"scheduled,omitempty"` // Sent at a time set in advance
+}
+
+// sendInvoiceHandler queues an email of an invoice with its PDF attached. The
+// job queue delivers it, retrying when the SMTP server is unavailable, and
+// marks a draft invoice as sent. With send_at the email is scheduled and the
+// job runs at that time.
+// Route: POST /api/invoices/{id}/send
+func (h *AppHandler) sendInvoiceHandler(w http.ResponseWriter, r *http.Request, id int) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	var req sendInvoiceRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeBodyError(w, fmt.Sprintf("Invalid request body: %v", err), err)
+			return
+		}
+	}
+
+	if !h.emailService.Configured() {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, services.ErrEmailNotConfigured.Error(), nil)
+		return
+	}
+
+	data, ok := h.loadInvoiceForEmail(w, id)
+	if !ok {
+		return
+	}
+	if req.To == "" {
+		req.To = data.Client.Email
+	}
+	if req.To == "" {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "The client has no email address", nil)
+		return
+	}
+	if _, err := mail.ParseAddress(req.To); err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("%q is not an email address", req.To), nil)
+		return
+	}
+
+	email, err := h.renderInvoiceEmail(r, data, req.Kind)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, err.Error(), nil)
+		return
+	}
+
+	payload := invoiceEmailJobPayload{
+		InvoiceID: id,
+		Kind:      email.Kind,
+		To:        req.To,
+		Subject:   email.Subject,
+		Body:      email.Body,
+	}
+	if !req.SendAt.IsZero() {
+		if !req.SendAt.After(time.Now()) {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "send_at must be in the future", nil)
+			return
+		}
+		payload.Scheduled = true
+		scheduled := &models.ScheduledEmail{InvoiceID: id, Kind: email.Kind, Recipient: req.To, SendAt: req.SendAt}
+		if err := h.dbService.ScheduleInvoiceEmail(scheduled, payload); err != nil {
+			if errors.Is(err, services.ErrScheduledEmailSending) {
+				h.writeError(w, http.StatusConflict, errCodeEmailSending, "The email scheduled before is being sent right now", nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to schedule the invoice email", err)
+			return
+		}
+		h.logger.Info("Scheduled invoice %d to be sent to %s at %s", id, req.To, scheduled.SendAt.Format(time.RFC3339))
+		json.NewEncoder(w).Encode(sendInvoiceResponse{
+			Message: "The invoice will be sent to " + req.To + " at " + scheduled.SendAt.Format("2006-01-02 15:04 MST"),
+			To:      req.To,
+			Status:  data.Invoice.Status,
+			JobID:   scheduled.JobID,
+			SendAt:  &scheduled.SendAt,
+		})
+		return
+	}
+
+	job, err := h.jobService.Enqueue(services.JobTypeSendInvoiceEmail, payload)
+	if err != nil {
+		h.writeInternalError(w, "Failed to queue the invoice email", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(sendInvoiceResponse{Message: "Sending invoice to " + req.To, To: req.To, Status: data.Invoice.Status, JobID: job.ID})
+}
+
+// scheduledEmailHandler shows and cancels the email scheduled for an invoice.
+// Route: GET, DELETE /api/invoices/{id}/scheduled-email
+func (h *AppHandler) scheduledEmailHandler(w http.ResponseWriter, r *http.Request, id int) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		email, err := h.dbService.GetScheduledInvoiceEmail(id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("No email is scheduled for invoice %d", id), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to load the scheduled email", err)
+			return
+		}
+		json.NewEncoder(w).Encode(email)
+
+	case http.MethodDelete:
+		if err := h.dbService.CancelScheduledInvoiceEmail(id); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("No email is scheduled for invoice %d", id), nil)
+			case errors.Is(err, services.ErrScheduledEmailSending):
+				h.writeError(w, http.StatusConflict, errCodeEmailSending, "The scheduled email is being sent right now", nil)
+			default:
+				h.writeInternalError(w, "Failed to cancel the scheduled email", err)
+			}
+			return
+		}
+		h.logger.Info("Cancelled the scheduled email of invoice %d", id)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Scheduled email cancelled"})
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// deliverInvoiceEmail sends a queued invoice email with the current PDF of the
+// invoice attached, records it for bounce tracking and marks a draft invoice
+// as sent
+func (h *AppHandler) deliverInvoiceEmail(p invoiceEmailJobPayload) error {
+	data, err := h.loadInvoicePDFData(p.InvoiceID)
+	if err != nil {
+		return err
+	}
+	// Reminders queued before the invoice was disputed or put on hold are dropped
+	if p.Kind == services.EmailTemplateReminder && data.Invoice.IsHeld() {
+		h.logger.Warn("Not sending the reminder of invoice %d to %s, the invoice is %s", p.InvoiceID, p.To, data.Invoice.Status)
+		if p.Scheduled {
+			if err := h.dbService.ScheduledInvoiceEmailSent(p.InvoiceID); err != nil {
+				h.logger.Error("Failed to remove the scheduled email of invoice %d: %v", p.InvoiceID, err)
+			}
+		}
+		return nil
+	}
+	pdfPath, err := h.currentInvoicePDF(p.InvoiceID)
+	if err != nil {
+		return fmt.Errorf("failed to generate PDF: %w", err)
+	}
+	pdf, err := os.ReadFile(pdfPath)
+	if err != nil {
+		return fmt.Errorf("failed to read PDF: %w", err)
+	}
+
+	messageID, err := h.emailService.Send(data.Business, &services.Email{
+		To:          p.To,
+		Subject:     p.Subject,
+		Body:        p.Body,
+		Attachments: []services.EmailAttachment{{Filename: data.Invoice.PDFFilename(), ContentType: "application/pdf", Data: pdf}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send invoice %d to %s: %w", p.InvoiceID, p.To, err)
+	}
+	h.logger.Info("Sent invoice %d to %s", p.InvoiceID, p.To)
+
+	// The email is on its way, so nothing below may fail the job and send it again
+	if err := h.dbService.AddInvoiceEmail(&models.InvoiceEmail{InvoiceID: p.InvoiceID, Kind: p.Kind, Recipient: p.To, MessageID: messageID}); err != nil {
+		h.logger.Error("Failed to record email sent for invoice %d: %v", p.InvoiceID, err)
+	}
+	if p.Scheduled {
+		if err := h.dbService.ScheduledInvoiceEmailSent(p.InvoiceID); err != nil {
+			h.logger.Error("Failed to remove the scheduled email of invoice %d: %v", p.InvoiceID, err)
+		}
+	}
+	if data.Invoice.Status == models.InvoiceStatusDraft {
+		if err := h.invoices.UpdateInvoiceStatus(p.InvoiceID, models.InvoiceStatusSent, time.Time{}); err != nil {
+			h.logger.Error("Invoice %d was sent, but its status could not be updated: %v", p.InvoiceID, err)
+			return nil
+		}
+		h.publishInvoiceStatus(p.InvoiceID)
+	}
+	return nil
+}
+
+// loadInvoiceForEmail loads an invoice with its business and client, and
+// writes the error response when that fails
+func (h *AppHandler) loadInvoiceForEmail(w http.ResponseWriter, id int) (*invoicePDFData, bool) {
+	data, err := h.loadInvoicePDFData(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", id), nil)
+			return nil, false
+		}
+		h.writeInternalError(w, "Failed to load invoice", err)
+		return nil, false
+	}
+	return data, true
+}
+
+// renderInvoiceEmail renders an email template for an invoice in its client's language
+func (h *AppHandler) renderInvoiceEmail(r *http.Request, data *invoicePDFData, kind string) (emailResponse, error) {
+	if kind == "" {
+		kind = services.EmailTemplateInvoice
+	}
+	if kind == services.EmailTemplateReminder && data.Invoice.IsHeld() {
+		return emailResponse{}, fmt.Errorf("invoice %s is %s, no reminders are sent until it is resolved", data.Invoice.InvoiceNumber, data.Invoice.Status)
+	}
+
+	template, err := h.emailTemplateService.Get(kind, data.Client.Language)
+	if err != nil {
+		return emailResponse{}, err
+	}
+
+	values := invoiceEmailValues(data.Invoice, data.Business, data.Client, absoluteURL(r, h.sharedInvoicePDFURL(data.Invoice.ID)))
+	return emailResponse{
+		Kind:     kind,
+		Language: template.Language,
+		Subject:  services.RenderEmailText(template.Subject, values),
+		Body:     services.RenderEmailText(template.Body, values),
+	}, nil
+}
+
+// invoiceEmailValues returns the email template variables of an invoice
+func invoiceEmailValues(invoice *models.Invoice, business *models.Business, client *models.Client, paymentLink string) map[string]string {
+	return map[string]string{
+		"client_name":    client.Name,
+		"business_name":  business.Name,
+		"invoice_number": invoice.InvoiceNumber,
+		"issue_date":     formatDate(invoice.IssueDate),
+		"due_date":       formatDate(invoice.DueDate),
+		"total":          formatMoney(invoice.TotalAmount) + " " + invoice.Currency,
+		"payment_link":   paymentLink,
+		"paypal_link":    invoice.PayPalLink,
+	}
+}
+
+// absoluteURL turns a path into a URL on the host the request was sent to,
+// honouring the scheme set by a reverse proxy
+func absoluteURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + path
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// Error codes of API error responses. Clients should branch on the code; the
+// message is meant for people and may change.
+const (
+	errCodeBadRequest           = "bad_request"
+	errCodeValidation           = "validation_failed"
+	errCodeUnauthorized         = "unauthorized"
+	errCodeForbidden            = "forbidden"
+	errCodeNotFound             = "not_found"
+	errCodeMethodNotAllowed     = "method_not_allowed"
+	errCodeVersionConflict      = "version_conflict"
+	errCodeDuplicateNumber      = "duplicate_invoice_number"
+	errCodeOpenInvoices         = "client_has_open_invoices"
+	errCodeTotalsMismatch       = "totals_mismatch"
+	errCodeAlreadyConverted     = "proforma_already_converted"
+	errCodeAlreadyCancelled     = "invoice_already_cancelled"
+	errCodeInvoiceCancelled     = "invoice_cancelled"
+	errCodeEInvoicingCancel     = "e_invoicing_cancellation"
+	errCodeInsufficientCredit   = "insufficient_credit"
+	errCodeLookupFailed         = "lookup_failed"
+	errCodeRateLimited          = "rate_limited"
+	errCodeTooLarge             = "request_too_large"
+	errCodeUnsupportedFile      = "unsupported_file_type"
+	errCodeYearClosed           = "year_closed"
+	errCodeSequenceGaps         = "sequence_gaps"
+	errCodeHookRejected         = "hook_rejected"
+	errCodeHookFailed           = "hook_failed"
+	errCodeBackupUnsupported    = "backup_unsupported"
+	errCodeDuplicateFilter      = "duplicate_filter_name"
+	errCodeEmailSending         = "email_being_sent"
+	errCodeBankSyncFailed       = "bank_sync_failed"
+	errCodePayPalFailed         = "paypal_failed"
+	errCodeAccountingSyncFailed = "accounting_sync_failed"
+	errCodeSDIFailed            = "sdi_failed"
+	errCodeNAVFailed            = "nav_reporting_failed"
+	errCodeVerifactuFailed      = "verifactu_failed"
+	errCodeKSeFFailed           = "ksef_failed"
+	errCodeComplianceFailed     = "compliance_failed"
+	errCodeInternal             = "internal_error"
+)
+
+// errorCodes lists every error code, for the API documentation
+var errorCodes = []string{
+	errCodeBadRequest, errCodeValidation, errCodeUnauthorized, errCodeForbidden, errCodeNotFound, errCodeMethodNotAllowed,
+	errCodeVersionConflict, errCodeDuplicateNumber, errCodeOpenInvoices, errCodeTotalsMismatch,
+	errCodeAlreadyConverted, errCodeAlreadyCancelled, errCodeInvoiceCancelled, errCodeEInvoicingCancel, errCodeInsufficientCredit, errCodeLookupFailed, errCodeRateLimited, errCodeTooLarge, errCodeUnsupportedFile,
+	errCodeYearClosed, errCodeSequenceGaps, errCodeHookRejected, errCodeHookFailed, errCodeBackupUnsupported, errCodeDuplicateFilter,
+	errCodeEmailSending, errCodeBankSyncFailed, errCodePayPalFailed, errCodeAccountingSyncFailed, errCodeSDIFailed, errCodeNAVFailed,
+	errCodeVerifactuFailed, errCodeKSeFFailed, errCodeComplianceFailed, errCodeInternal,
+}
+
+// apiError is the body of every API error response
+type apiError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// writeError responds with an error envelope. The message is shown to users,
+// so it must not contain internal details such as SQL errors.
+func (h *AppHandler) writeError(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{Code: code, Message: message, Details: details})
+}
+
+// writeHookError responds to a change that a hook rejected with 422 and the
+// hook's message, or with 502 if the hook could not be run. The hook service
+// logs why it failed.
+func (h *AppHandler) writeHookError(w http.ResponseWriter, err error) {
+	var rejected *services.HookRejectedError
+	if errors.As(err, &rejected) {
+		h.writeError(w, http.StatusUnprocessableEntity, errCodeHookRejected, rejected.Message, map[string]string{"hook": rejected.Hook})
+		return
+	}
+	h.writeError(w, http.StatusBadGateway, errCodeHookFailed, "A hook could not be run, see the server log", nil)
+}
+
+// writeInternalError logs err and responds with 500 and a message that does not reveal it
+func (h *AppHandler) writeInternalError(w http.ResponseWriter, message string, err error) {
+	h.logger.Error("%s: %v", message, err)
+	h.writeError(w, http.StatusInternalServerError, errCodeInternal, message, nil)
+}
+
+// writeConflict responds with 409 Conflict and the current version of the record
+// so the caller can merge their changes and try again
+func (h *AppHandler) writeConflict(w http.ResponseWriter, message string, current interface{}) {
+	h.writeError(w, http.StatusConflict, errCodeVersionConflict, message, map[string]interface{}{"current": current})
+}
+
+// writeMethodNotAllowed responds with 405 Method Not Allowed
+func (h *AppHandler) writeMethodNotAllowed(w http.ResponseWriter) {
+	h.writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed", nil)
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// eventsHeartbeatInterval is how often an idle event stream sends a comment,
+// so proxies do not close it
+const eventsHeartbeatInterval = 30 * time.Second
+
+// Payloads of the live events
+type (
+	invoiceStatusEvent struct {
+		ID            int    `json:"id"`
+		InvoiceNumber string `json:"invoice_number"`
+		Status        string `json:"status"`
+		PaidDate      string `json:"paid_date,omitempty"`
+		Reason        string `json:"reason,omitempty"` // Of disputed and on-hold invoices
+	}
+	pdfGeneratedEvent struct {
+		InvoiceID     int    `json:"invoice_id"`
+		InvoiceNumber string `json:"invoice_number"`
+	}
+	backupCreatedEvent struct {
+		Filename string `json:"filename"`
+	}
+)
+
+// EventsHandler handles GET /api/events, a server-sent event stream of
+// invoice status changes, finished PDF generations and new backups, which
+// keeps open tabs up to date without reloading
+func (h *AppHandler) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		h.logger.Debug("Failed to clear the write deadline of the event stream: %v", err)
+	}
+
+	events, unsubscribe := h.events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	// Browsers reconnect after 5 seconds when the stream ends
+	fmt.Fprint(w, "retry: 5000\n\n")
+	if err := rc.Flush(); err != nil {
+		h.logger.Error("Event stream cannot be flushed: %v", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				h.logger.Error("Failed to encode %s event: %v", event.Type, err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// CloseEventStreams ends the open event streams, which would otherwise keep
+// the server from shutting down
+func (h *AppHandler) CloseEventStreams() {
+	h.events.Close()
+}
+
+// publishInvoiceStatus tells open tabs the current status of an invoice after
+// it was changed
+func (h *AppHandler) publishInvoiceStatus(id int) {
+	if h.events == nil {
+		return
+	}
+	invoice, _, err := h.invoices.GetInvoice(id)
+	if err != nil {
+		h.logger.Error("Failed to load invoice %d for the live update: %v", id, err)
+		return
+	}
+	event := invoiceStatusEvent{ID: invoice.ID, InvoiceNumber: invoice.InvoiceNumber, Status: invoice.Status, Reason: invoice.StatusReason}
+	if !invoice.PaidDate.IsZero() {
+		event.PaidDate = invoice.PaidDate.Format("2006-01-02")
+	}
+	h.events.Publish(services.LiveEventInvoiceStatus, event)
+}
+
+// publishPDFGenerated tells open tabs that the queued PDF of an invoice is ready
+func (h *AppHandler) publishPDFGenerated(id int) {
+	if h.events == nil {
+		return
+	}
+	invoice, _, err := h.invoices.GetInvoice(id)
+	if err != nil {
+		h.logger.Error("Failed to load invoice %d for the live update: %v", id, err)
+		return
+	}
+	h.events.Publish(services.LiveEventPDFGenerated, pdfGeneratedEvent{InvoiceID: invoice.ID, InvoiceNumber: invoice.InvoiceNumber})
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// ExpenseRatesAPIHandler handles expense rate API requests
+// GET lists the rates, POST saves those of a country, DELETE ?country= removes them
+func (h *AppHandler) ExpenseRatesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		rates, err := h.expenseRateService.List()
+		if err != nil {
+			h.writeInternalError(w, "Failed to list expense rates", err)
+			return
+		}
+		json.NewEncoder(w).Encode(rates)
+
+	case http.MethodPost:
+		var rates models.ExpenseRates
+		if err := json.NewDecoder(r.Body).Decode(&rates); err != nil {
+			h.logger.Error("Failed to decode expense rates: %v", err)
+			h.writeBodyError(w, fmt.Sprintf("Invalid request body: %v", err), err)
+			return
+		}
+
+		if err := h.expenseRateService.Save(&rates); err != nil {
+			h.logger.Error("Failed to save expense rates: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, err.Error(), nil)
+			return
+		}
+		json.NewEncoder(w).Encode(rates)
+
+	case http.MethodDelete:
+		country := r.URL.Query().Get("country")
+		if err := h.expenseRateService.Delete(country); err != nil {
+			if errors.Is(err, services.ErrExpenseRatesNotFound) {
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("No customized expense rates for country %s", country), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to delete expense rates", err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"message": "Expense rates deleted successfully"})
+
+	default:
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// invoiceFatturaPAHandler handles /api/invoices/{id}/fatturapa: GET downloads
+// the invoice as a FatturaPA file, for example to hand it to an intermediary,
+// and POST sends it to SDI by PEC
+func (h *AppHandler) invoiceFatturaPAHandler(w http.ResponseWriter, r *http.Request, id int) {
+	switch r.Method {
+	case http.MethodGet:
+		file, data, err := h.fatturaPAService.Generate(id)
+		if err != nil {
+			h.writeFatturaPAError(w, id, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Filename}))
+		w.Write(data)
+
+	case http.MethodPost:
+		file, err := h.fatturaPAService.Send(id)
+		if err != nil {
+			h.writeFatturaPAError(w, id, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(file)
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// writeFatturaPAError reports why an invoice could not be issued as FatturaPA
+// or sent to SDI
+func (h *AppHandler) writeFatturaPAError(w http.ResponseWriter, id int, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", id), nil)
+	case errors.Is(err, services.ErrFatturaPAInvalid):
+		h.writeError(w, http.StatusUnprocessableEntity, errCodeValidation, err.Error(), nil)
+	case errors.Is(err, services.ErrPECNotConfigured):
+		h.writeError(w, http.StatusServiceUnavailable, errCodeSDIFailed, err.Error(), nil)
+	case errors.Is(err, services.ErrPECSendFailed):
+		h.logger.Error("Failed to send invoice %d to SDI: %v", id, err)
+		h.writeError(w, http.StatusBadGateway, errCodeSDIFailed, err.Error(), nil)
+	default:
+		h.writeInternalError(w, "Failed to generate the FatturaPA file", err)
+	}
+}
@@ -0,0 +1,447 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+// maxGraphQLDepth limits how deeply GraphQL queries nest, e.g.
+// invoices { client { invoices { items { description } } } } is 5 levels
+const maxGraphQLDepth = 6
+
+// maxGraphQLSelections limits how many fields and fragments a query selects,
+// counting fragments once
+const maxGraphQLSelections = 1000
+
+// graphQLRequest is the body of a GraphQL request
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// GraphQLHandler answers read-only GraphQL queries over the same data as the
+// REST API, so an invoice can be fetched with its items, client and business
+// in one request. Queries are sent as a POST body or the query parameter of a GET.
+func (h *AppHandler) GraphQLHandler(w http.ResponseWriter, r *http.Request) {
+	var req graphQLRequest
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				writeGraphQLError(w, http.StatusBadRequest, fmt.Sprintf("Invalid variables: %v", err))
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeGraphQLError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+	default:
+		h.writeMethodNotAllowed(w)
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		writeGraphQLError(w, http.StatusBadRequest, "The query is required")
+		return
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"})})
+	if err != nil {
+		writeGraphQLError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	depth, err := graphQLDepth(doc)
+	if err != nil {
+		writeGraphQLError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if depth > maxGraphQLDepth {
+		writeGraphQLError(w, http.StatusBadRequest, fmt.Sprintf("The query is nested %d levels deep, at most %d are allowed", depth, maxGraphQLDepth))
+		return
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         h.graphQLSchema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        r.Context(),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// writeGraphQLError responds with a GraphQL error document
+func writeGraphQLError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"message": message}},
+	})
+}
+
+// graphQLDepth returns how deeply the operations of a query nest, following
+// fragments. Introspection fields are not counted, since the schema is
+// finite and tools such as GraphiQL nest them deeply. The depth of each
+// fragment is computed once, and queries with more than maxGraphQLSelections
+// selections are rejected, so fragments spread many times cannot make the
+// walk itself expensive.
+func graphQLDepth(doc *ast.Document) (int, error) {
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, def := range doc.Definitions {
+		if fragment, ok := def.(*ast.FragmentDefinition); ok {
+			fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	fragmentDepths := make(map[string]int)
+	visiting := make(map[string]bool)
+	selections := 0
+	var depthOf func(set *ast.SelectionSet) (int, error)
+	depthOf = func(set *ast.SelectionSet) (int, error) {
+		if set == nil {
+			return 0, nil
+		}
+		selections += len(set.Selections)
+		if selections > maxGraphQLSelections {
+			return 0, fmt.Errorf("The query has more than %d selections", maxGraphQLSelections)
+		}
+		deepest := 0
+		for _, selection := range set.Selections {
+			var depth int
+			var err error
+			switch selection := selection.(type) {
+			case *ast.Field:
+				if strings.HasPrefix(selection.Name.Value, "__") {
+					continue
+				}
+				depth, err = depthOf(selection.SelectionSet)
+				depth++
+			case *ast.InlineFragment:
+				depth, err = depthOf(selection.SelectionSet)
+			case *ast.FragmentSpread:
+				name := selection.Name.Value
+				fragment, ok := fragments[name]
+				if !ok || visiting[name] {
+					continue
+				}
+				if known, ok := fragmentDepths[name]; ok {
+					depth = known
+					break
+				}
+				visiting[name] = true
+				depth, err = depthOf(fragment.SelectionSet)
+				delete(visiting, name)
+				fragmentDepths[name] = depth
+			}
+			if err != nil {
+				return 0, err
+			}
+			deepest = max(deepest, depth)
+		}
+		return deepest, nil
+	}
+
+	deepest := 0
+	for _, def := range doc.Definitions {
+		if operation, ok := def.(*ast.OperationDefinition); ok {
+			depth, err := depthOf(operation.SelectionSet)
+			if err != nil {
+				return 0, err
+			}
+			deepest = max(deepest, depth)
+		}
+	}
+	return deepest, nil
+}
+
+// newGraphQLSchema builds the GraphQL schema. Field names are those of the
+// REST API's JSON; amounts are Floats and dates YYYY-MM-DD strings.
+func (h *AppHandler) newGraphQLSchema() (graphql.Schema, error) {
+	business := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Business",
+		Fields: graphql.Fields{
+			"id":                    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"name":                  &graphql.Field{Type: graphql.String},
+			"address":               &graphql.Field{Type: graphql.String},
+			"city":                  &graphql.Field{Type: graphql.String},
+			"postal_code":           &graphql.Field{Type: graphql.String},
+			"country":               &graphql.Field{Type: graphql.String},
+			"vat_id":                &graphql.Field{Type: graphql.String},
+			"email":                 &graphql.Field{Type: graphql.String},
+			"bank_name":             &graphql.Field{Type: graphql.String},
+			"iban":                  &graphql.Field{Type: graphql.String},
+			"bic":                   &graphql.Field{Type: graphql.String},
+			"currency":              &graphql.Field{Type: graphql.String},
+			"extra_business_detail": &graphql.Field{Type: graphql.String},
+			"vat_exempt":            &graphql.Field{Type: graphql.Boolean},
+		},
+	})
+
+	item := graphql.NewObject(graphql.ObjectConfig{
+		Name: "InvoiceItem",
+		Fields: graphql.Fields{
+			"id":               &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"description":      &graphql.Field{Type: graphql.String},
+			"quantity":         &graphql.Field{Type: graphql.Float},
+			"unit":             &graphql.Field{Type: graphql.String},
+			"unit_price":       moneyField(func(i *models.InvoiceItem) models.Money { return i.UnitPrice }),
+			"amount":           moneyField(func(i *models.InvoiceItem) models.Money { return i.Amount }),
+			"discount_percent": &graphql.Field{Type: graphql.Float},
+			"discount_amount":  moneyField(func(i *models.InvoiceItem) models.Money { return i.DiscountAmount }),
+		},
+	})
+
+	client := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Client",
+		Fields: graphql.Fields{
+			"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"name":        &graphql.Field{Type: graphql.String},
+			"address":     &graphql.Field{Type: graphql.String},
+			"city":        &graphql.Field{Type: graphql.String},
+			"postal_code": &graphql.Field{Type: graphql.String},
+			"country":     &graphql.Field{Type: graphql.String},
+			"vat_id":      &graphql.Field{Type: graphql.String},
+			"email":       &graphql.Field{Type: graphql.String},
+			"language":    &graphql.Field{Type: graphql.String},
+			"deleted":     &graphql.Field{Type: graphql.Boolean},
+		},
+	})
+
+	invoice := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Invoice",
+		Fields: graphql.Fields{
+			"id":                      &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"invoice_number":          &graphql.Field{Type: graphql.String},
+			"type":                    &graphql.Field{Type: graphql.String},
+			"status":                  &graphql.Field{Type: graphql.String},
+			"status_reason":           &graphql.Field{Type: graphql.String},
+			"cancels_invoice_id":      &graphql.Field{Type: graphql.Int},
+			"cancelled_by_invoice_id": &graphql.Field{Type: graphql.Int},
+			"issue_date":              dateField(func(i *models.Invoice) time.Time { return i.IssueDate }),
+			"due_date":                dateField(func(i *models.Invoice) time.Time { return i.DueDate }),
+			"paid_date":               dateField(func(i *models.Invoice) time.Time { return i.PaidDate }),
+			"currency":                &graphql.Field{Type: graphql.String},
+			"vat_rate":                &graphql.Field{Type: graphql.Float},
+			"reverse_charge_vat":      &graphql.Field{Type: graphql.Boolean},
+			"hours_worked":            &graphql.Field{Type: graphql.Float},
+			"vat_amount":              moneyField(func(i *models.Invoice) models.Money { return i.VatAmount }),
+			"total_amount":            moneyField(func(i *models.Invoice) models.Money { return i.TotalAmount }),
+			"credit_applied":          moneyField(func(i *models.Invoice) models.Money { return i.CreditApplied }),
+			"amount_due":              moneyField(func(i *models.Invoice) models.Money { return i.AmountDue() }),
+			"notes":                   &graphql.Field{Type: graphql.String},
+			"po_number":               &graphql.Field{Type: graphql.String},
+			"contract_reference":      &graphql.Field{Type: graphql.String},
+			"tags":                    &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"items": &graphql.Field{
+				Type: graphql.NewList(graphql.NewNonNull(item)),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					_, items, err := h.invoices.GetInvoice(p.Source.(*models.Invoice).ID)
+					if err != nil {
+						return nil, h.graphQLError("items", err)
+					}
+					result := make([]*models.InvoiceItem, len(items))
+					for i := range items {
+						result[i] = &items[i]
+					}
+					return result, nil
+				},
+			},
+			"client": &graphql.Field{
+				Type: client,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return h.resolveGraphQLClient(p.Source.(*models.Invoice).ClientID)
+				},
+			},
+			"business": &graphql.Field{
+				Type: business,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					b, err := h.businesses.GetBusiness(p.Source.(*models.Invoice).BusinessID)
+					if errors.Is(err, sql.ErrNoRows) {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, h.graphQLError("business", err)
+					}
+					return b, nil
+				},
+			},
+		},
+	})
+
+	// Clients list their invoices; the field is added afterwards since the
+	// two types refer to each other
+	client.AddFieldConfig("invoices", &graphql.Field{
+		Type: graphql.NewList(graphql.NewNonNull(invoice)),
+		Args: graphql.FieldConfigArgument{
+			"status": &graphql.ArgumentConfig{Type: graphql.String},
+			"tag":    &graphql.ArgumentConfig{Type: graphql.String},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			filter, err := graphQLInvoiceFilter(p.Args)
+			if err != nil {
+				return nil, err
+			}
+			filter.ClientID = p.Source.(*models.Client).ID
+			return h.resolveGraphQLInvoices(filter)
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"invoice": &graphql.Field{
+				Type: invoice,
+				Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					inv, _, err := h.invoices.GetInvoice(p.Args["id"].(int))
+					if errors.Is(err, sql.ErrNoRows) {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, h.graphQLError("invoice", err)
+					}
+					return inv, nil
+				},
+			},
+			"invoices": &graphql.Field{
+				Type: graphql.NewList(graphql.NewNonNull(invoice)),
+				Args: graphql.FieldConfigArgument{
+					"client_id": &graphql.ArgumentConfig{Type: graphql.Int},
+					"status":    &graphql.ArgumentConfig{Type: graphql.String},
+					"tag":       &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					filter, err := graphQLInvoiceFilter(p.Args)
+					if err != nil {
+						return nil, err
+					}
+					filter.ClientID, _ = p.Args["client_id"].(int)
+					return h.resolveGraphQLInvoices(filter)
+				},
+			},
+			"client": &graphql.Field{
+				Type: client,
+				Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return h.resolveGraphQLClient(p.Args["id"].(int))
+				},
+			},
+			"clients": &graphql.Field{
+				Type: graphql.NewList(graphql.NewNonNull(client)),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					clients, err := h.clients.GetClients()
+					if err != nil {
+						return nil, h.graphQLError("clients", err)
+					}
+					result := make([]*models.Client, len(clients))
+					for i := range clients {
+						result[i] = &clients[i]
+					}
+					return result, nil
+				},
+			},
+			"businesses": &graphql.Field{
+				Type: graphql.NewList(graphql.NewNonNull(business)),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					businesses, err := h.businesses.GetBusinesses()
+					if err != nil {
+						return nil, h.graphQLError("businesses", err)
+					}
+					result := make([]*models.Business, len(businesses))
+					for i := range businesses {
+						result[i] = &businesses[i]
+					}
+					return result, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+// graphQLInvoiceFilter reads the status and tag arguments of an invoice list;
+// tag may hold comma-separated tags that invoices must all have
+func graphQLInvoiceFilter(args map[string]interface{}) (models.InvoiceFilter, error) {
+	status, _ := args["status"].(string)
+	tag, _ := args["tag"].(string)
+	tags, err := models.NormalizeTags(strings.Split(tag, ","))
+	if err != nil {
+		return models.InvoiceFilter{}, err
+	}
+	return models.InvoiceFilter{Status: status, Tags: tags}, nil
+}
+
+// resolveGraphQLInvoices returns the invoices selected by filter
+func (h *AppHandler) resolveGraphQLInvoices(filter models.InvoiceFilter) ([]*models.Invoice, error) {
+	invoices, err := h.invoices.GetInvoices()
+	if err != nil {
+		return nil, h.graphQLError("invoices", err)
+	}
+	var result []*models.Invoice
+	for i := range invoices {
+		if filter.Matches(&invoices[i]) {
+			result = append(result, &invoices[i])
+		}
+	}
+	return result, nil
+}
+
+// resolveGraphQLClient returns a client, including clients in the trash that
+// invoices still refer to, or nil if there is none
+func (h *AppHandler) resolveGraphQLClient(id int) (*models.Client, error) {
+	client, err := h.clients.GetClient(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, h.graphQLError("client", err)
+	}
+	return client, nil
+}
+
+// graphQLError logs err and returns an error for the response that does not reveal it
+func (h *AppHandler) graphQLError(field string, err error) error {
+	h.logger.Error("Failed to resolve GraphQL field %s: %v", field, err)
+	return fmt.Errorf("failed to load %s", field)
+}
+
+// moneyField is a Float field with the amount returned by get
+func moneyField[T any](get func(*T) models.Money) *graphql.Field {
+	return &graphql.Field{
+		Type: graphql.Float,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return get(p.Source.(*T)).Float(), nil
+		},
+	}
+}
+
+// dateField is a YYYY-MM-DD String field with the date returned by get, null
+// when the date is not set
+func dateField(get func(*models.Invoice) time.Time) *graphql.Field {
+	return &graphql.Field{
+		Type: graphql.String,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			if date := get(p.Source.(*models.Invoice)); !date.IsZero() {
+				return formatDate(date), nil
+			}
+			return nil, nil
+		},
+	}
+}
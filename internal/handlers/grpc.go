@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	pb "github.com/0dragosh/simple-invoice/internal/pb/simpleinvoicev1"
+	"github.com/0dragosh/simple-invoice/internal/services"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// pdfChunkSize is the size of the chunks GetInvoicePDF streams a PDF in
+const pdfChunkSize = 64 << 10
+
+// NewGRPCServer returns a gRPC server exposing clients, invoices and invoice
+// PDFs. Calls must send the token as "authorization: Bearer <token>" metadata
+// unless token is empty, which is only allowed on a Unix socket.
+func (h *AppHandler) NewGRPCServer(token string) *grpc.Server {
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+			if err := checkGRPCToken(ctx, token); err != nil {
+				return nil, err
+			}
+			defer h.recoverGRPCPanic(info.FullMethod, &err)
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+			if err := checkGRPCToken(stream.Context(), token); err != nil {
+				return err
+			}
+			defer h.recoverGRPCPanic(info.FullMethod, &err)
+			return handler(srv, stream)
+		}),
+	)
+	pb.RegisterInvoiceServiceServer(server, &grpcServer{h: h})
+	return server
+}
+
+// recoverGRPCPanic turns a panic in a gRPC method into an Internal error,
+// since gRPC does not recover panics and one would end the process. Call it
+// deferred.
+func (h *AppHandler) recoverGRPCPanic(method string, err *error) {
+	if r := recover(); r != nil {
+		services.LogPanic(h.logger, method, r)
+		*err = status.Error(codes.Internal, "internal server error")
+	}
+}
+
+// checkGRPCToken verifies the bearer token in the metadata of a call
+func checkGRPCToken(ctx context.Context, token string) error {
+	if token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		sent, ok := strings.CutPrefix(value, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(sent), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "a valid bearer token is required")
+}
+
+// grpcServer implements the InvoiceService with the services of the AppHandler,
+// validating requests like the JSON API does
+type grpcServer struct {
+	pb.UnimplementedInvoiceServiceServer
+	h *AppHandler
+}
+
+func (s *grpcServer) ListClients(ctx context.Context, req *pb.ListClientsRequest) (*pb.ListClientsResponse, error) {
+	clients, err := s.h.clients.GetClients()
+	if err != nil {
+		return nil, s.internalError("Failed to get clients", err)
+	}
+	resp := &pb.ListClientsResponse{}
+	for i := range clients {
+		resp.Clients = append(resp.Clients, clientToProto(&clients[i]))
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) GetClient(ctx context.Context, req *pb.GetClientRequest) (*pb.Client, error) {
+	client, err := s.h.clients.GetClient(int(req.GetId()))
+	if err != nil {
+		return nil, s.lookupError("Client", req.GetId(), err)
+	}
+	return clientToProto(client), nil
+}
+
+func (s *grpcServer) SaveClient(ctx context.Context, req *pb.SaveClientRequest) (*pb.Client, error) {
+	in := req.GetClient()
+	if in == nil {
+		return nil, status.Error(codes.InvalidArgument, "client is required")
+	}
+	client := models.Client{
+		ID:         int(in.GetId()),
+		Name:       in.GetName(),
+		Address:    in.GetAddress(),
+		City:       in.GetCity(),
+		PostalCode: in.GetPostalCode(),
+		Country:    in.GetCountry(),
+		VatID:      in.GetVatId(),
+		Email:      in.GetEmail(),
+		Language:   in.GetLanguage(),
+		Version:    int(in.GetVersion()),
+	}
+	if client.ID == 0 {
+		now := time.Now()
+		client.CreatedDate = &now
+	} else {
+		current, err := s.h.clients.GetClient(client.ID)
+		if err != nil {
+			return nil, s.lookupError("Client", in.GetId(), err)
+		}
+		// The gRPC API does not carry the risk notes, the SDI details or the
+		// invoice formats, so they are kept
+		client.CreatedDate = current.CreatedDate
+		client.RiskNotes = current.RiskNotes
+		client.SDICode = current.SDICode
+		client.PEC = current.PEC
+		client.DateFormat = current.DateFormat
+		client.NumberFormat = current.NumberFormat
+	}
+
+	if err := services.ValidateClient(&client); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := s.h.hookService.ClientSave(&client); err != nil {
+		return nil, hookStatus(err)
+	}
+	if err := s.h.clients.SaveClient(&client); err != nil {
+		if errors.Is(err, services.ErrVersionConflict) {
+			return nil, status.Error(codes.Aborted, "Client was changed by someone else")
+		}
+		return nil, s.internalError("Failed to save client", err)
+	}
+	s.h.logger.Info("Saved client %s with ID %d over gRPC", services.MaskPII(client.Name), client.ID)
+	return clientToProto(&client), nil
+}
+
+func (s *grpcServer) ListInvoices(ctx context.Context, req *pb.ListInvoicesRequest) (*pb.ListInvoicesResponse, error) {
+	invoices, err := s.h.invoices.GetInvoices()
+	if err != nil {
+		return nil, s.internalError("Failed to get invoices", err)
+	}
+	resp := &pb.ListInvoicesResponse{}
+	for i := range invoices {
+		invoice := &invoices[i]
+		if req.GetClientId() != 0 && int64(invoice.ClientID) != req.GetClientId() {
+			continue
+		}
+		if req.GetStatus() != "" && invoice.Status != req.GetStatus() {
+			continue
+		}
+		resp.Invoices = append(resp.Invoices, invoiceToProto(invoice, nil))
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) GetInvoice(ctx context.Context, req *pb.GetInvoiceRequest) (*pb.Invoice, error) {
+	invoice, items, err := s.h.invoices.GetInvoice(int(req.GetId()))
+	if err != nil {
+		return nil, s.lookupError("Invoice", req.GetId(), err)
+	}
+	return invoiceToProto(invoice, items), nil
+}
+
+func (s *grpcServer) UpdateInvoiceStatus(ctx context.Context, req *pb.UpdateInvoiceStatusRequest) (*pb.Invoice, error) {
+	newStatus := req.GetStatus()
+	if newStatus != models.InvoiceStatusDraft && newStatus != models.InvoiceStatusSent && newStatus != models.InvoiceStatusPaid {
+		return nil, status.Error(codes.InvalidArgument, "status must be draft, sent or paid")
+	}
+	var paidDate time.Time
+	if req.GetPaidDate() != "" {
+		var err error
+		if paidDate, err = time.Parse("2006-01-02", req.GetPaidDate()); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid paid date format. Expected YYYY-MM-DD, got: %s", req.GetPaidDate())
+		}
+	}
+
+	id := int(req.GetId())
+	invoice, _, err := s.h.invoices.GetInvoice(id)
+	if err != nil {
+		return nil, s.lookupError("Invoice", req.GetId(), err)
+	}
+	if invoice.Status == models.InvoiceStatusDraft {
+		finalized := *invoice
+		finalized.Status = newStatus
+		if err := s.h.checkCompliance(&finalized); err != nil {
+			var violation *services.ComplianceError
+			if errors.As(err, &violation) {
+				return nil, status.Error(codes.FailedPrecondition, err.Error())
+			}
+			return nil, s.internalError("Failed to check the compliance profile", err)
+		}
+	}
+	if err := s.h.invoices.UpdateInvoiceStatus(id, newStatus, paidDate); err != nil {
+		if errors.Is(err, services.ErrYearClosed) {
+			return nil, status.Errorf(codes.FailedPrecondition, "Invoices of a closed fiscal year can only be marked paid (%v)", err)
+		}
+		return nil, s.internalError("Failed to update invoice status", err)
+	}
+	s.h.publishInvoiceStatus(id)
+	if newStatus == models.InvoiceStatusPaid && invoice.Status != models.InvoiceStatusPaid {
+		s.h.notificationService.Notify(services.Notification{
+			Event:   services.EventInvoicePaid,
+			Title:   fmt.Sprintf("Invoice %s was paid", invoice.InvoiceNumber),
+			Message: fmt.Sprintf("%s %s received.", invoice.TotalAmount, invoice.Currency),
+		})
+	}
+
+	invoice, items, err := s.h.invoices.GetInvoice(id)
+	if err != nil {
+		return nil, s.internalError("Failed to load invoice", err)
+	}
+	return invoiceToProto(invoice, items), nil
+}
+
+func (s *grpcServer) GetInvoicePDF(req *pb.GetInvoicePDFRequest, stream grpc.ServerStreamingServer[pb.PDFChunk]) error {
+	id := int(req.GetId())
+	invoice, _, err := s.h.invoices.GetInvoice(id)
+	if err != nil {
+		return s.lookupError("Invoice", req.GetId(), err)
+	}
+	pdfPath, err := s.h.currentInvoicePDF(id)
+	if err != nil {
+		return s.internalError("Failed to generate PDF", err)
+	}
+	file, err := os.Open(pdfPath)
+	if err != nil {
+		return s.internalError("Failed to open PDF", err)
+	}
+	defer file.Close()
+
+	chunk := &pb.PDFChunk{Filename: invoice.PDFFilename()}
+	buf := make([]byte, pdfChunkSize)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			chunk.Data = buf[:n]
+			if err := stream.Send(chunk); err != nil {
+				return err
+			}
+			chunk = &pb.PDFChunk{}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return s.internalError("Failed to read PDF", err)
+		}
+	}
+}
+
+// lookupError returns NOT_FOUND for a missing record and INTERNAL otherwise
+func (s *grpcServer) lookupError(kind string, id int64, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return status.Errorf(codes.NotFound, "%s not found with ID: %d", kind, id)
+	}
+	return s.internalError("Failed to load "+strings.ToLower(kind), err)
+}
+
+// internalError logs err and returns an INTERNAL status without its details,
+// like writeInternalError
+func (s *grpcServer) internalError(message string, err error) error {
+	s.h.logger.Error("%s: %v", message, err)
+	return status.Error(codes.Internal, message)
+}
+
+// hookStatus maps an error of a hook to FAILED_PRECONDITION when the hook
+// rejected the change and UNAVAILABLE when it failed
+func hookStatus(err error) error {
+	var rejected *services.HookRejectedError
+	if errors.As(err, &rejected) {
+		return status.Error(codes.FailedPrecondition, rejected.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+func clientToProto(client *models.Client) *pb.Client {
+	return &pb.Client{
+		Id:         int64(client.ID),
+		Name:       client.Name,
+		Address:    client.Address,
+		City:       client.City,
+		PostalCode: client.PostalCode,
+		Country:    client.Country,
+		VatId:      client.VatID,
+		Email:      client.Email,
+		Language:   client.Language,
+		Version:    int64(client.Version),
+	}
+}
+
+func invoiceToProto(invoice *models.Invoice, items []models.InvoiceItem) *pb.Invoice {
+	out := &pb.Invoice{
+		Id:               int64(invoice.ID),
+		InvoiceNumber:    invoice.InvoiceNumber,
+		Type:             invoice.Type,
+		Status:           invoice.Status,
+		BusinessId:       int64(invoice.BusinessID),
+		ClientId:         int64(invoice.ClientID),
+		IssueDate:        protoDate(invoice.IssueDate),
+		DueDate:          protoDate(invoice.DueDate),
+		PaidDate:         protoDate(invoice.PaidDate),
+		Currency:         invoice.Currency,
+		VatRate:          invoice.VatRate,
+		ReverseChargeVat: invoice.ReverseChargeVat,
+		VatAmount:        int64(invoice.VatAmount),
+		TotalAmount:      int64(invoice.TotalAmount),
+		CreditApplied:    int64(invoice.CreditApplied),
+		Notes:            invoice.Notes,
+		PoNumber:         invoice.PONumber,
+	}
+	for _, item := range items {
+		out.Items = append(out.Items, &pb.InvoiceItem{
+			Id:              int64(item.ID),
+			Description:     item.Description,
+			Quantity:        item.Quantity,
+			Unit:            item.Unit,
+			UnitPrice:       int64(item.UnitPrice),
+			Amount:          int64(item.Amount),
+			DiscountPercent: item.DiscountPercent,
+			DiscountAmount:  int64(item.DiscountAmount),
+		})
+	}
+	return out
+}
+
+// protoDate formats a date as YYYY-MM-DD, or an empty string when not set
+func protoDate(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format("2006-01-02")
+}
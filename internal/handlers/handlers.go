@@ -2,33 +2,78 @@ package handlers
 
 import (
 	"bytes"
-	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"html/template"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/refdata"
 	"github.com/0dragosh/simple-invoice/internal/services"
+	"github.com/graphql-go/graphql"
 )
 
 // AppHandler handles HTTP requests
 type AppHandler struct {
-	dbService     *services.DBService
-	vatService    *services.VatService
-	pdfService    *services.PDFService
-	backupService *services.BackupService
-	templates     map[string]*template.Template
-	dataDir       string
-	logger        *services.Logger
-	version       string
+	dbService *services.DBService
+	// businesses, clients and invoices are dbService unless a test fakes them
+	businesses      services.BusinessRepo
+	clients         services.ClientRepo
+	invoices        services.InvoiceRepo
+	vatService      *services.VatService
+	addressService  *services.AddressService
+	holidayService  *services.HolidayService
+	pdfService      *services.PDFService
+	backupService   *services.BackupService
+	jobService      *services.JobService
+	importService   *services.ImportService
+	settingsService *services.SettingsService
+	authService     *services.AuthService
+	// emailTemplateService holds the editable invoice, reminder and receipt emails
+	emailTemplateService  *services.EmailTemplateService
+	emailService          *services.EmailService
+	bounceService         *services.BounceService
+	bankSyncService       *services.BankSyncService
+	accountingSyncService *services.AccountingSyncService
+	paypalService         *services.PayPalService
+	notificationService   *services.NotificationService
+	projectService        *services.ProjectService
+	contractService       *services.ContractService
+	exchangeRateService   *services.ExchangeRateService
+	reverseChargeService  *services.ReverseChargeService
+	complianceService     *services.ComplianceService
+	expenseRateService    *services.ExpenseRateService
+	reportService         *services.ReportService
+	exportService         *services.ExportService
+	fatturaPAService      *services.FatturaPAService
+	navService            *services.NAVService
+	verifactuService      *services.VerifactuService
+	ksefService           *services.KSeFService
+	closingService        *services.ClosingService
+	hookService           *services.HookService
+	events                *services.EventBroker // Live updates for open tabs
+	graphQLSchema         graphql.Schema
+	templates             map[string]*template.Template
+	templatesMu           sync.RWMutex
+	templateWatch         *templateWatch // Set in development mode
+	dataDir               string
+	logger                *services.Logger
+	version               string
 }
 
 // NewAppHandler creates a new AppHandler
@@ -39,8 +84,11 @@ func NewAppHandler(dataDir string, logger *services.Logger, version string) (*Ap
 		return nil, fmt.Errorf("failed to create DB service: %w", err)
 	}
 
-	// Create VAT service
-	vatService := services.NewVatService(logger)
+	// Create Auth service (AUTH_MODE and related environment variables)
+	authService, err := services.NewAuthService(dbService, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure authentication: %w", err)
+	}
 
 	// Create PDF service
 	pdfService := services.NewPDFService(dataDir)
@@ -51,8 +99,42 @@ func NewAppHandler(dataDir string, logger *services.Logger, version string) (*Ap
 		return nil, fmt.Errorf("failed to create backup service: %w", err)
 	}
 
-	// Start backup scheduler if BACKUP_CRON is set
-	backupCron := os.Getenv("BACKUP_CRON")
+	// Create Job service
+	jobService := services.NewJobService(dbService, logger)
+	backupService.SetJobService(jobService)
+
+	// Create Settings service
+	settingsService := services.NewSettingsService(dbService, logger)
+	if level, saved := settingsService.LogLevel(); saved && level != logger.Level() {
+		logger.Info("Log level set to %s on the settings page", level)
+		logger.SetLevel(level)
+	}
+
+	// Encrypt secret settings when a key is configured (SECRETS_KEY or the OS keyring)
+	secretBox, err := services.NewSecretBoxFromEnvironment()
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up secrets encryption: %w", err)
+	}
+	if secretBox != nil {
+		settingsService.SetSecretBox(secretBox)
+		encrypted, err := settingsService.EncryptStoredSecrets()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt stored secrets: %w", err)
+		}
+		logger.Info("Secret settings are encrypted with the key from the %s", secretBox.Source())
+		if encrypted > 0 {
+			logger.Info("Encrypted %d secret settings stored in plain text", encrypted)
+		}
+	}
+
+	// Create VAT service
+	vatService := services.NewVatService(settingsService, logger)
+	pdfService.SetSettingsService(settingsService)
+	pdfService.SetLogger(logger)
+	pdfService.SetSigner(services.NewPDFSigner(settingsService, logger))
+
+	// Start backup scheduler if a schedule is configured (settings page or BACKUP_CRON)
+	backupCron := settingsService.GetString(services.SettingBackupCron)
 	if backupCron != "" {
 		if err := backupService.StartScheduler(backupCron); err != nil {
 			logger.Warn("Failed to start backup scheduler: %v", err)
@@ -60,21 +142,99 @@ func NewAppHandler(dataDir string, logger *services.Logger, version string) (*Ap
 	}
 
 	// Parse templates
-	templates, err := parseTemplates(logger)
+	templates, err := parseTemplates(dataDir, logger, settingsService)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse templates: %w", err)
 	}
 
-	return &AppHandler{
-		dbService:     dbService,
-		vatService:    vatService,
-		pdfService:    pdfService,
-		backupService: backupService,
-		templates:     templates,
-		dataDir:       dataDir,
-		logger:        logger,
-		version:       version,
-	}, nil
+	h := &AppHandler{
+		dbService:             dbService,
+		businesses:            dbService,
+		clients:               dbService,
+		invoices:              dbService,
+		vatService:            vatService,
+		addressService:        services.NewAddressService(settingsService, logger),
+		holidayService:        services.NewHolidayService(dbService, settingsService, logger),
+		pdfService:            pdfService,
+		backupService:         backupService,
+		jobService:            jobService,
+		importService:         services.NewImportService(dbService, logger),
+		settingsService:       settingsService,
+		authService:           authService,
+		emailTemplateService:  services.NewEmailTemplateService(dbService, settingsService, logger),
+		emailService:          services.NewEmailService(settingsService, logger),
+		bounceService:         services.NewBounceService(dbService, settingsService, logger),
+		bankSyncService:       services.NewBankSyncService(dbService, settingsService, logger),
+		accountingSyncService: services.NewAccountingSyncService(dbService, settingsService, logger),
+		paypalService:         services.NewPayPalService(settingsService, logger),
+		notificationService:   services.NewNotificationService(dbService, settingsService, jobService, logger),
+		projectService:        services.NewProjectService(dbService, logger),
+		contractService:       services.NewContractService(dbService, logger),
+		exchangeRateService:   services.NewExchangeRateService(dbService, logger),
+		reverseChargeService:  services.NewReverseChargeService(dbService, settingsService, logger),
+		complianceService:     services.NewComplianceService(dbService, logger),
+		expenseRateService:    services.NewExpenseRateService(dbService, logger),
+		reportService:         services.NewReportService(dbService, settingsService, logger),
+		exportService:         services.NewExportService(dbService, settingsService, logger),
+		fatturaPAService:      services.NewFatturaPAService(dbService, settingsService, logger),
+		navService:            services.NewNAVService(dbService, settingsService, version, logger),
+		verifactuService:      services.NewVerifactuService(dbService, settingsService, version, logger),
+		ksefService:           services.NewKSeFService(dbService, settingsService, version, logger),
+		closingService:        services.NewClosingService(dbService, pdfService, logger),
+		hookService:           services.NewHookService(settingsService, dataDir, logger),
+		events:                services.NewEventBroker(logger),
+		templates:             templates,
+		dataDir:               dataDir,
+		logger:                logger,
+		version:               version,
+	}
+	h.importService.SetHookService(h.hookService)
+	h.contractService.SetHookService(h.hookService)
+	h.navService.SetExchangeRateService(h.exchangeRateService)
+	h.ksefService.SetExchangeRateService(h.exchangeRateService)
+	h.fatturaPAService.SetComplianceService(h.complianceService)
+	h.navService.SetComplianceService(h.complianceService)
+	h.verifactuService.SetComplianceService(h.complianceService)
+	h.ksefService.SetComplianceService(h.complianceService)
+
+	if h.graphQLSchema, err = h.newGraphQLSchema(); err != nil {
+		return nil, fmt.Errorf("failed to build GraphQL schema: %w", err)
+	}
+
+	// Register job handlers and start the worker
+	h.registerJobHandlers()
+	if err := jobService.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start job worker: %w", err)
+	}
+
+	// Remove old preview PDFs now and periodically
+	pdfService.StartPreviewCleanup()
+
+	// Check the IMAP mailbox for bounced invoice emails when configured
+	h.bounceService.Start()
+
+	// Sync incoming payments from the connected banks when GoCardless is configured
+	h.bankSyncService.Start()
+
+	// Push finalized invoices to Xero or QuickBooks once connected
+	h.accountingSyncService.Start()
+
+	// Report the invoices of Hungarian businesses to NAV once configured
+	h.navService.Start()
+
+	// Register the invoices of Spanish businesses with Verifactu once enabled
+	h.verifactuService.Start()
+
+	// Send the invoices of Polish businesses to KSeF once configured
+	h.ksefService.Start()
+
+	// Notify about invoices that become overdue
+	h.notificationService.StartOverdueCheck()
+
+	// Generate the invoices of retainer contracts as they come due
+	h.contractService.Start(h.lookupExchangeRate)
+
+	return h, nil
 }
 
 // Helper function to format dates
@@ -83,8 +243,8 @@ func formatDate(t time.Time) string {
 }
 
 // Helper function to format money
-func formatMoney(amount float64) string {
-	return fmt.Sprintf("%.2f", amount)
+func formatMoney(amount models.Money) string {
+	return amount.String()
 }
 
 // Helper function to format file sizes
@@ -101,9 +261,9 @@ func formatFileSize(size int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
 }
 
-// formatCurrency formats a float as a currency value with 2 decimal places
-func formatCurrency(amount float64) string {
-	return fmt.Sprintf("%.2f", amount)
+// formatCurrency formats an amount as a currency value with 2 decimal places
+func formatCurrency(amount models.Money) string {
+	return amount.String()
 }
 
 // currencySymbol returns the symbol for a given currency code
@@ -111,43 +271,104 @@ func currencySymbol(currency string) string {
 	return services.FormatCurrencySymbol(currency)
 }
 
-// add adds two float64 values
-func add(a, b float64) float64 {
-	return a + b
+// countryCurrency returns the currency a country uses today
+func countryCurrency(country string) string {
+	return services.GetCurrencyForCountry(country)
+}
+
+// isEUCountry reports whether a country is an EU member state today
+func isEUCountry(country string) bool {
+	return refdata.IsEUMember(country, time.Now())
+}
+
+// invoiceCurrencies returns the currencies invoices can be issued in: the
+// ISO 4217 currencies still in use
+func invoiceCurrencies() []refdata.Currency {
+	return slices.DeleteFunc(refdata.Currencies(), func(c refdata.Currency) bool {
+		return c.Former
+	})
+}
+
+// templatesDir holds the HTML templates, relative to the working directory
+const templatesDir = "internal/templates"
+
+// templateOverrideDir is the directory in DATA_DIR for customized copies of
+// templates, which are used instead of the ones in templatesDir
+const templateOverrideDir = "templates-override"
+
+// templateFiles are the HTML templates: the layout followed by the pages
+var templateFiles = []string{
+	"layout.html",
+	"index.html",
+	"business.html",
+	"clients.html",
+	"projects.html",
+	"contracts.html",
+	"reports.html",
+	"invoices.html",
+	"create-invoice.html",
+	"view-invoice.html",
+	"reconcile.html",
+	"backups.html",
+	"jobs.html",
+	"logs.html",
+	"settings.html",
+	"email-templates.html",
+}
+
+// templatePath returns the path of a template file, the customized copy in
+// DATA_DIR/templates-override if there is one
+func templatePath(dataDir, name string) string {
+	override := filepath.Join(dataDir, templateOverrideDir, name)
+	if info, err := os.Stat(override); err == nil && info.Mode().IsRegular() {
+		return override
+	}
+	return filepath.Join(templatesDir, name)
 }
 
-// parseTemplates parses all HTML templates
-func parseTemplates(logger *services.Logger) (map[string]*template.Template, error) {
+// parseTemplates parses all HTML templates, preferring customized copies in
+// DATA_DIR/templates-override
+func parseTemplates(dataDir string, logger *services.Logger, settingsService *services.SettingsService) (map[string]*template.Template, error) {
 	templates := make(map[string]*template.Template)
 
+	// Files in the override directory that do not replace a template are
+	// most likely misnamed
+	overrides, _ := filepath.Glob(filepath.Join(dataDir, templateOverrideDir, "*"))
+	for _, override := range overrides {
+		if !slices.Contains(templateFiles, filepath.Base(override)) {
+			logger.Warn("Ignoring %s, it does not match any template", override)
+		}
+	}
+
 	// Define template functions
 	funcMap := template.FuncMap{
-		"formatDate":     formatDate,
-		"formatMoney":    formatMoney,
-		"formatFileSize": formatFileSize,
-		"formatCurrency": formatCurrency,
-		"currencySymbol": currencySymbol,
-		"add":            add,
+		"formatDate":      formatDate,
+		"formatMoney":     formatMoney,
+		"formatFileSize":  formatFileSize,
+		"formatCurrency":  formatCurrency,
+		"currencySymbol":  currencySymbol,
+		"countryCurrency": countryCurrency,
+		"isEUCountry":     isEUCountry,
+		"itemKindLabel": func(kind string) string {
+			return models.ItemKindLabels[kind]
+		},
+		"locale": func() string {
+			return settingsService.GetString(services.SettingLocale)
+		},
 	}
 
 	// Parse base template
-	baseTemplate, err := template.New("layout.html").Funcs(funcMap).ParseFiles("internal/templates/layout.html")
+	layoutPath := templatePath(dataDir, templateFiles[0])
+	baseTemplate, err := template.New(templateFiles[0]).Funcs(funcMap).ParseFiles(layoutPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse base template: %w", err)
 	}
-
-	// Parse content templates
-	contentTemplates := []string{
-		"internal/templates/index.html",
-		"internal/templates/business.html",
-		"internal/templates/clients.html",
-		"internal/templates/invoices.html",
-		"internal/templates/create-invoice.html",
-		"internal/templates/view-invoice.html",
-		"internal/templates/backups.html",
+	if layoutPath != filepath.Join(templatesDir, templateFiles[0]) {
+		logger.Info("Using customized template %s", layoutPath)
 	}
 
-	for _, tmpl := range contentTemplates {
+	// Parse content templates
+	for _, tmpl := range templateFiles[1:] {
 		// Clone the base template
 		t, err := baseTemplate.Clone()
 		if err != nil {
@@ -155,9 +376,13 @@ func parseTemplates(logger *services.Logger) (map[string]*template.Template, err
 		}
 
 		// Parse the content template
-		t, err = t.ParseFiles(tmpl)
+		path := templatePath(dataDir, tmpl)
+		t, err = t.ParseFiles(path)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse template %s: %w", tmpl, err)
+			return nil, fmt.Errorf("failed to parse template %s: %w", path, err)
+		}
+		if path != filepath.Join(templatesDir, tmpl) {
+			logger.Info("Using customized template %s", path)
 		}
 
 		// Add to templates map
@@ -190,33 +415,55 @@ func RegisterHandlers(mux *http.ServeMux, dataDir string, logger *services.Logge
 	mux.HandleFunc("/", handler.IndexHandler)
 	mux.HandleFunc("/business", handler.BusinessHandler)
 	mux.HandleFunc("/clients", handler.ClientsHandler)
+	mux.HandleFunc("/projects", handler.ProjectsHandler)
+	mux.HandleFunc("/contracts", handler.ContractsHandler)
 	mux.HandleFunc("/invoices", handler.InvoicesHandler)
+	mux.HandleFunc("/reports", handler.ReportsHandler)
 	mux.HandleFunc("/invoices/create", handler.CreateInvoiceHandler)
 	mux.HandleFunc("/invoices/view/", handler.ViewInvoiceHandler)
+	mux.HandleFunc("/invoices/print/", handler.PrintInvoiceHandler)
+	mux.HandleFunc("/invoices/pdf/", handler.InvoicePDFHandler)
+	mux.HandleFunc("/invoices/reconcile", handler.ReconcilePageHandler)
 	mux.HandleFunc("/backups", handler.BackupsHandler)
+	mux.HandleFunc("/jobs", handler.JobsHandler)
+	mux.HandleFunc("/logs", handler.LogsHandler)
+	mux.HandleFunc("/settings", handler.SettingsHandler)
+	mux.HandleFunc("/email-templates", handler.EmailTemplatesHandler)
 
-	// API endpoints
-	mux.HandleFunc("/api/business", handler.BusinessAPIHandler)
-	mux.HandleFunc("/api/clients", handler.ClientsAPIHandler)
-	mux.HandleFunc("/api/clients/", handler.ClientsAPIHandler)
-	mux.HandleFunc("/api/clients/vat-lookup", handler.VatLookupHandler)
-	mux.HandleFunc("/api/clients/uk-company-lookup", handler.UKCompanyLookupHandler)
-	mux.HandleFunc("/api/invoices", handler.InvoicesAPIHandler)
-	mux.HandleFunc("/api/invoices/", handler.InvoiceByIDHandler)
-	mux.HandleFunc("/api/invoices/generate-pdf", handler.GeneratePDFHandler)
-	mux.HandleFunc("/api/invoices/preview-pdf", handler.PreviewPDFHandler)
-	mux.HandleFunc("/api/upload/logo", handler.UploadLogoHandler)
-	mux.HandleFunc("/api/backups", handler.BackupsAPIHandler)
-	mux.HandleFunc("/api/backups/restore", handler.RestoreBackupHandler)
+	// Installable app
+	mux.HandleFunc(manifestPath, handler.ManifestHandler)
+	for path := range pwaAssets {
+		mux.HandleFunc(path, handler.PWAAssetHandler)
+	}
 
-	// Register static file handler
-	fileServer = http.FileServer(http.Dir(dataDir))
-	mux.Handle("/data/", http.StripPrefix("/data/", fileServer))
+	// Sign-in endpoints, used when AUTH_MODE=oidc
+	mux.HandleFunc("/auth/login", handler.LoginHandler)
+	mux.HandleFunc("/auth/callback", handler.CallbackHandler)
+	mux.HandleFunc("/auth/logout", handler.LogoutHandler)
+
+	// Redirect URI of the OAuth app of the accounting software
+	mux.HandleFunc(accountingCallbackPath, handler.AccountingCallbackHandler)
+
+	// API endpoints, documented at /api/openapi.json
+	for _, endpoint := range handler.apiEndpoints() {
+		mux.HandleFunc(endpoint.Pattern, endpoint.Handler)
+	}
+	mux.HandleFunc("/api/docs", handler.APIDocsHandler)
+	mux.HandleFunc("/api/docs/", handler.APIDocsAssetHandler)
+	mux.HandleFunc("/graphql", handler.GraphQLHandler)
+
+	// Runtime metrics such as panics_recovered, in the expvar JSON format
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	// Serve generated PDFs and uploaded images, but not the database or backups
+	mux.HandleFunc("/data/pdfs/", handler.PDFFileHandler)
+	mux.HandleFunc("/data/images/", handler.ImageFileHandler)
+	mux.HandleFunc("/data/previews/", handler.PreviewFileHandler)
 
 	// Log the data directory and static file paths
 	logger.Info("Data directory: %s", dataDir)
 	logger.Info("Static files will be served from: %s", dataDir)
-	logger.Info("PDFs will be available at: /data/pdfs/")
+	logger.Info("PDFs will be available at: /invoices/pdf/{id}")
 
 	return handler, nil
 }
@@ -238,9 +485,9 @@ func (h *AppHandler) IndexHandler(w http.ResponseWriter, r *http.Request) {
 
 // BusinessHandler handles the business details page
 func (h *AppHandler) BusinessHandler(w http.ResponseWriter, r *http.Request) {
-	businesses, err := h.dbService.GetBusinesses()
+	businesses, err := h.businesses.GetBusinesses()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeInternalError(w, "Failed to load business details", err)
 		return
 	}
 
@@ -250,10 +497,25 @@ func (h *AppHandler) BusinessHandler(w http.ResponseWriter, r *http.Request) {
 		business.LogoURL = business.GetLogoURL()
 	}
 
+	// Working days are listed from Monday
+	type workDayOption struct {
+		Day     int
+		Name    string
+		Checked bool
+	}
+	var workDays []workDayOption
+	for i := 1; i <= 7; i++ {
+		day := time.Weekday(i % 7)
+		workDays = append(workDays, workDayOption{Day: int(day), Name: day.String(), Checked: slices.Contains(business.WorkingDays(), day)})
+	}
+
 	data := map[string]interface{}{
 		"Title":       "Business Details",
 		"Business":    business,
 		"CurrentYear": time.Now().Year(),
+		"HoursPerDay": business.HoursPerDay(),
+		"WorkDays":    workDays,
+		"Countries":   refdata.Countries(),
 	}
 
 	h.renderTemplate(w, "business", data)
@@ -261,16 +523,39 @@ func (h *AppHandler) BusinessHandler(w http.ResponseWriter, r *http.Request) {
 
 // ClientsHandler handles the clients page
 func (h *AppHandler) ClientsHandler(w http.ResponseWriter, r *http.Request) {
-	clients, err := h.dbService.GetClients()
+	clients, err := h.clients.GetClients()
+	if err != nil {
+		h.writeInternalError(w, "Failed to load clients", err)
+		return
+	}
+
+	deletedClients, err := h.clients.GetDeletedClients()
+	if err != nil {
+		h.writeInternalError(w, "Failed to load clients", err)
+		return
+	}
+
+	creditBalances, err := h.dbService.GetCreditBalances()
+	if err != nil {
+		h.writeInternalError(w, "Failed to load credit balances", err)
+		return
+	}
+
+	invoices, err := h.invoices.GetInvoices()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeInternalError(w, "Failed to load invoices", err)
 		return
 	}
 
 	data := map[string]interface{}{
-		"Title":       "Clients",
-		"Clients":     clients,
-		"CurrentYear": time.Now().Year(),
+		"Title":          "Clients",
+		"Clients":        clients,
+		"DeletedClients": deletedClients,
+		"CreditBalances": creditBalances,
+		"PaymentStats":   services.PaymentStatsByClient(invoices, time.Now()),
+		"DateFormats":    models.DateFormats,
+		"NumberFormats":  models.NumberFormats,
+		"CurrentYear":    time.Now().Year(),
 	}
 
 	h.renderTemplate(w, "clients", data)
@@ -278,40 +563,85 @@ func (h *AppHandler) ClientsHandler(w http.ResponseWriter, r *http.Request) {
 
 // InvoicesHandler handles the invoices page
 func (h *AppHandler) InvoicesHandler(w http.ResponseWriter, r *http.Request) {
-	invoices, err := h.dbService.GetInvoices()
+	filter, err := models.ParseInvoiceFilter(r.URL.Query())
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error(), nil)
+		return
+	}
+
+	invoices, err := h.invoices.GetInvoices()
+	if err != nil {
+		h.writeInternalError(w, "Failed to load invoices", err)
+		return
+	}
+	invoices = filterInvoices(invoices, filter)
+
+	// The filter bar offers the clients, tags in use and saved filters
+	clients, err := h.clients.GetClients()
+	if err != nil {
+		h.writeInternalError(w, "Failed to load clients", err)
+		return
+	}
+	tagCounts, err := h.dbService.GetInvoiceTagCounts()
+	if err != nil {
+		h.writeInternalError(w, "Failed to load invoice tags", err)
+		return
+	}
+	savedFilters, err := h.dbService.GetSavedFilters(savedFilterUserID(r))
+	if err != nil {
+		h.writeInternalError(w, "Failed to load saved filters", err)
+		return
+	}
+
+	deliveryStatuses, err := h.dbService.GetInvoiceDeliveryStatuses()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeInternalError(w, "Failed to load email delivery statuses", err)
 		return
 	}
 
 	// Fetch client information for each invoice
 	type InvoiceWithClient struct {
 		models.Invoice
-		ClientName string
+		ClientName    string
+		ClientDeleted bool
+		PDFURL        string
+		// DeliveryStatus is the status of the last email sent for the invoice, empty if never emailed
+		DeliveryStatus string
 	}
 
 	invoicesWithClients := make([]InvoiceWithClient, 0, len(invoices))
 	for _, invoice := range invoices {
-		client, err := h.dbService.GetClient(invoice.ClientID)
+		client, err := h.clients.GetClient(invoice.ClientID)
 		if err != nil {
 			// If client not found, use a placeholder
 			invoicesWithClients = append(invoicesWithClients, InvoiceWithClient{
-				Invoice:    invoice,
-				ClientName: "Unknown Client",
+				Invoice:        invoice,
+				ClientName:     "Unknown Client",
+				PDFURL:         h.invoicePDFURL(invoice.ID, pdfViewLinkLifetime),
+				DeliveryStatus: deliveryStatuses[invoice.ID],
 			})
 			continue
 		}
 
 		invoicesWithClients = append(invoicesWithClients, InvoiceWithClient{
-			Invoice:    invoice,
-			ClientName: client.Name,
+			Invoice:        invoice,
+			ClientName:     client.Name,
+			ClientDeleted:  client.Deleted,
+			PDFURL:         h.invoicePDFURL(invoice.ID, pdfViewLinkLifetime),
+			DeliveryStatus: deliveryStatuses[invoice.ID],
 		})
 	}
 
 	data := map[string]interface{}{
-		"Title":       "Invoices",
-		"Invoices":    invoicesWithClients,
-		"CurrentYear": time.Now().Year(),
+		"Title":        "Invoices",
+		"Invoices":     invoicesWithClients,
+		"Clients":      clients,
+		"Filter":       filter,
+		"FilterTags":   strings.Join(filter.Tags, ", "),
+		"FilterQuery":  filter.Query().Encode(),
+		"TagCounts":    tagCounts,
+		"SavedFilters": savedFilters,
+		"CurrentYear":  time.Now().Year(),
 	}
 
 	h.renderTemplate(w, "invoices", data)
@@ -319,15 +649,15 @@ func (h *AppHandler) InvoicesHandler(w http.ResponseWriter, r *http.Request) {
 
 // CreateInvoiceHandler handles the create invoice page
 func (h *AppHandler) CreateInvoiceHandler(w http.ResponseWriter, r *http.Request) {
-	clients, err := h.dbService.GetClients()
+	clients, err := h.clients.GetClients()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeInternalError(w, "Failed to load clients", err)
 		return
 	}
 
-	businesses, err := h.dbService.GetBusinesses()
+	businesses, err := h.businesses.GetBusinesses()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeInternalError(w, "Failed to load business details", err)
 		return
 	}
 
@@ -336,17 +666,52 @@ func (h *AppHandler) CreateInvoiceHandler(w http.ResponseWriter, r *http.Request
 		business = businesses[0]
 	}
 
-	// Calculate work hours for the current month
-	workHours := services.CalculateWorkHoursForCurrentMonth()
+	projects, err := h.projectService.List()
+	if err != nil {
+		h.writeInternalError(w, "Failed to load projects", err)
+		return
+	}
+
+	tagCounts, err := h.dbService.GetInvoiceTagCounts()
+	if err != nil {
+		h.writeInternalError(w, "Failed to load invoice tags", err)
+		return
+	}
+
+	// Pre-fill the work hours of the current month from the business's calendar
+	now := time.Now()
+	workMonth, err := h.holidayService.WorkMonth(&business, now.Year(), now.Month())
+	if err != nil {
+		h.logger.Warn("Work hours pre-filled without public holidays: %v", err)
+	}
+	workHours := workMonth.TotalHours
+
+	expenseRates, err := h.expenseRateService.List()
+	if err != nil {
+		h.writeInternalError(w, "Failed to load expense rates", err)
+		return
+	}
 
 	data := map[string]interface{}{
-		"Title":       "Create Invoice",
-		"Clients":     clients,
-		"Business":    business,
-		"IssueDate":   time.Now().Format("2006-01-02"),
-		"DueDate":     time.Now().AddDate(0, 0, 30).Format("2006-01-02"), // Due in 30 days
-		"CurrentYear": time.Now().Year(),
-		"WorkHours":   workHours, // Add work hours for the current month
+		"Title":          "Create Invoice",
+		"Clients":        clients,
+		"Projects":       projects,
+		"TagCounts":      tagCounts,
+		"Business":       business,
+		"IssueDate":      time.Now().Format("2006-01-02"),
+		"DueDate":        time.Now().AddDate(0, 0, h.settingsService.GetInt(services.SettingInvoiceDueDays)).Format("2006-01-02"),
+		"CurrentYear":    time.Now().Year(),
+		"WorkHours":      workHours, // Add work hours for the current month
+		"TimesheetMonth": now.Format("2006-01"),
+		"VatRate":        h.settingsService.GetFloat(services.SettingInvoiceVatRate),
+		"Currency":       h.settingsService.GetString(services.SettingInvoiceCurrency),
+		"Notes":          h.settingsService.GetString(services.SettingInvoiceNotes),
+		"ItemUnits":      models.ItemUnits,
+		"ItemKinds":      models.ItemKinds,
+		"ExpenseRates":   expenseRates,
+		"Currencies":     invoiceCurrencies(),
+		"PayPalCheckout": h.paypalService.Email() != "",
+		"PayPalMe":       h.paypalService.MeUsername() != "",
 	}
 
 	h.renderTemplate(w, "create-invoice", data)
@@ -357,958 +722,2621 @@ func (h *AppHandler) ViewInvoiceHandler(w http.ResponseWriter, r *http.Request)
 	idStr := r.URL.Path[len("/invoices/view/"):]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Invalid invoice ID", nil)
 		return
 	}
 
-	invoice, items, err := h.dbService.GetInvoice(id)
+	invoice, items, err := h.invoices.GetInvoice(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeInternalError(w, "Failed to load invoice", err)
 		return
 	}
+	invoice.PayPalLink = h.paypalService.Link(invoice)
 
-	business, err := h.dbService.GetBusiness(invoice.BusinessID)
+	business, err := h.businesses.GetBusiness(invoice.BusinessID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeInternalError(w, "Failed to load business details", err)
 		return
 	}
 
-	client, err := h.dbService.GetClient(invoice.ClientID)
+	client, err := h.clients.GetClient(invoice.ClientID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeInternalError(w, "Failed to load client details", err)
 		return
 	}
 
-	data := map[string]interface{}{
-		"Title":       fmt.Sprintf("Invoice #%s", invoice.InvoiceNumber),
-		"Invoice":     invoice,
-		"Items":       items,
-		"Business":    business,
-		"Client":      client,
-		"CurrentYear": time.Now().Year(),
+	pdfVersions, err := h.invoicePDFVersions(id)
+	if err != nil {
+		h.writeInternalError(w, "Failed to load PDF versions", err)
+		return
 	}
 
-	h.renderTemplate(w, "view-invoice", data)
-}
-
-// BusinessAPIHandler handles business API requests
-func (h *AppHandler) BusinessAPIHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	emails, err := h.dbService.GetInvoiceEmails(id)
+	if err != nil {
+		h.writeInternalError(w, "Failed to load sent emails", err)
+		return
+	}
 
-	switch r.Method {
-	case http.MethodGet:
-		businesses, err := h.dbService.GetBusinesses()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	balances, err := h.dbService.GetCreditBalances()
+	if err != nil {
+		h.writeInternalError(w, "Failed to load credit balances", err)
+		return
+	}
+	var creditAvailable models.Money
+	for _, balance := range balances[invoice.ClientID] {
+		if balance.Currency == invoice.Currency {
+			creditAvailable = balance.Amount
 		}
+	}
 
-		var business models.Business
-		if len(businesses) > 0 {
-			business = businesses[0]
+	var project *models.Project
+	if invoice.ProjectID != 0 {
+		project, err = h.projectService.Get(invoice.ProjectID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			h.writeInternalError(w, "Failed to load project", err)
+			return
 		}
+	}
+	timeEntries, err := h.projectService.GetInvoiceTimeEntries(id)
+	if err != nil {
+		h.writeInternalError(w, "Failed to load billed time", err)
+		return
+	}
+	timeline, err := h.invoiceTimeline(invoice, emails)
+	if err != nil {
+		h.writeInternalError(w, "Failed to load the invoice timeline", err)
+		return
+	}
+	scheduledEmail, err := h.dbService.GetScheduledInvoiceEmail(id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		h.writeInternalError(w, "Failed to load the scheduled email", err)
+		return
+	}
+	accountingSync, err := h.accountingSyncService.InvoiceStatus(invoice, items)
+	if err != nil {
+		h.writeInternalError(w, "Failed to load the accounting sync status", err)
+		return
+	}
 
-		json.NewEncoder(w).Encode(business)
+	eInvoicing, err := h.eInvoicingFormats(business)
+	if err != nil {
+		h.writeInternalError(w, "Failed to load the compliance profile", err)
+		return
+	}
 
-	case http.MethodPost:
-		var business models.Business
-		if err := json.NewDecoder(r.Body).Decode(&business); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+	// Invoices of Italian businesses are also issued as FatturaPA files
+	italian := slices.Contains(eInvoicing, models.ComplianceFatturaPA)
+	var fatturaPAFile *models.FatturaPAFile
+	if italian {
+		if fatturaPAFile, err = h.fatturaPAService.File(id); err != nil {
+			h.writeInternalError(w, "Failed to load the FatturaPA file", err)
 			return
 		}
+	}
 
-		if err := h.dbService.SaveBusiness(&business); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Invoices of Hungarian businesses are reported to NAV
+	hungarian := slices.Contains(eInvoicing, models.ComplianceNAV)
+	var navReport *models.NAVReport
+	if hungarian {
+		if navReport, err = h.navService.Status(id); err != nil {
+			h.writeInternalError(w, "Failed to load the NAV report", err)
 			return
 		}
-
-		json.NewEncoder(w).Encode(business)
-
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
-}
-
-// ClientsAPIHandler handles clients API requests
-func (h *AppHandler) ClientsAPIHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	// Check if we're fetching a specific client by ID
-	path := r.URL.Path
-	h.logger.Debug("ClientsAPIHandler received request for path: %s", path)
 
-	// Skip the vat-lookup endpoint which is handled by VatLookupHandler
-	if strings.HasSuffix(path, "/vat-lookup") {
-		h.logger.Debug("Skipping vat-lookup request in ClientsAPIHandler")
-		return
+	// Invoices of Spanish businesses get Verifactu records once it is enabled
+	verifactu := slices.Contains(eInvoicing, models.ComplianceVerifactu)
+	var verifactuRecord *models.VerifactuRecord
+	if verifactu {
+		if verifactuRecord, err = h.verifactuService.Latest(id); err != nil {
+			h.writeInternalError(w, "Failed to load the Verifactu record", err)
+			return
+		}
 	}
 
-	pathParts := strings.Split(path, "/")
-	if len(pathParts) > 3 && pathParts[3] != "" {
-		// Path format: /api/clients/{id}
-		h.logger.Info("Received request to fetch client by ID: %s", pathParts[3])
-
-		clientID, err := strconv.Atoi(pathParts[3])
-		if err != nil {
-			h.logger.Error("Invalid client ID format: %s - %v", pathParts[3], err)
-			http.Error(w, fmt.Sprintf("Invalid client ID format: %s", pathParts[3]), http.StatusBadRequest)
+	// Invoices of Polish businesses are sent to KSeF
+	polish := slices.Contains(eInvoicing, models.ComplianceKSeF)
+	var ksefInvoice *models.KSeFInvoice
+	if polish {
+		if ksefInvoice, err = h.ksefService.Status(id); err != nil {
+			h.writeInternalError(w, "Failed to load the KSeF status", err)
 			return
 		}
+	}
 
-		// Handle DELETE request for a specific client
-		if r.Method == http.MethodDelete {
-			h.logger.Info("Received request to delete client with ID: %d", clientID)
+	data := map[string]interface{}{
+		"Title":           fmt.Sprintf("Invoice #%s", invoice.InvoiceNumber),
+		"Invoice":         invoice,
+		"PDFVersions":     pdfVersions,
+		"PDFURL":          h.invoicePDFURL(id, pdfViewLinkLifetime), // Shown inline once a PDF was generated
+		"Emails":          emails,
+		"ScheduledEmail":  scheduledEmail, // nil when no email is scheduled
+		"AccountingSync":  accountingSync, // nil when no accounting software is connected
+		"FatturaPA":       italian,
+		"FatturaPAFile":   fatturaPAFile, // nil until the invoice is downloaded or sent as FatturaPA
+		"PECConfigured":   h.fatturaPAService.Configured(),
+		"NAV":             hungarian,
+		"NAVReport":       navReport, // nil until the invoice is queued for NAV
+		"NAVConfigured":   h.navService.Configured(),
+		"Verifactu":       verifactu,
+		"VerifactuRecord": verifactuRecord, // nil until the invoice gets a record
+		"KSeF":            polish,
+		"KSeFInvoice":     ksefInvoice, // nil until the invoice is queued for KSeF
+		"KSeFConfigured":  h.ksefService.Configured(),
+		"CreditAvailable": creditAvailable, // Client credit in the invoice currency
+		"Project":         project,
+		"TimeEntries":     timeEntries,
+		"Timeline":        timeline,
+		"Items":           items,
+		"Totals":          invoice.CalculateTotals(items),
+		"Business":        business,
+		"Client":          client,
+		"CurrentYear":     time.Now().Year(),
+	}
 
-			if err := h.dbService.DeleteClient(clientID); err != nil {
-				h.logger.Error("Failed to delete client: %v", err)
-				http.Error(w, fmt.Sprintf("Failed to delete client: %v", err), http.StatusInternalServerError)
-				return
-			}
+	h.renderTemplate(w, "view-invoice", data)
+}
 
-			h.logger.Info("Successfully deleted client with ID: %d", clientID)
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(map[string]string{"message": "Client deleted successfully"})
-			return
-		}
+// PrintInvoiceHandler shows an invoice on its own, rendered from the HTML
+// invoice template, to print it or save it as a PDF from the browser
+func (h *AppHandler) PrintInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.writeMethodNotAllowed(w)
+		return
+	}
 
-		// Handle GET request for a specific client
-		h.logger.Info("Looking up client with ID: %d", clientID)
-		client, err := h.dbService.GetClient(clientID)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				h.logger.Error("Client not found with ID: %d", clientID)
-				http.Error(w, fmt.Sprintf("Client not found with ID: %d", clientID), http.StatusNotFound)
-			} else {
-				h.logger.Error("Database error when looking up client ID %d: %v", clientID, err)
-				http.Error(w, fmt.Sprintf("Failed to lookup client: %v", err), http.StatusInternalServerError)
-			}
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/invoices/print/"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Invalid invoice ID", nil)
+		return
+	}
+
+	data, err := h.loadInvoicePDFData(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, "Invoice not found", nil)
 			return
 		}
+		h.writeInternalError(w, "Failed to load invoice", err)
+		return
+	}
 
-		h.logger.Info("Successfully found client: %s (ID: %d)", client.Name, client.ID)
-		json.NewEncoder(w).Encode(client)
+	html, err := h.pdfService.RenderInvoicePrintHTML(data.Invoice, data.Business, data.Client, data.Items)
+	if err != nil {
+		h.writeInternalError(w, "Failed to render invoice", err)
 		return
 	}
 
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(html)
+}
+
+// BusinessAPIHandler handles business API requests
+func (h *AppHandler) BusinessAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
 	switch r.Method {
 	case http.MethodGet:
-		clients, err := h.dbService.GetClients()
+		businesses, err := h.businesses.GetBusinesses()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			h.writeInternalError(w, "Failed to load business details", err)
 			return
 		}
 
-		json.NewEncoder(w).Encode(clients)
+		var business models.Business
+		if len(businesses) > 0 {
+			business = businesses[0]
+		}
 
-	case http.MethodPost:
-		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(business)
 
-		var client models.Client
-		h.logger.Debug("Decoding client JSON from request body")
-		if err := json.NewDecoder(r.Body).Decode(&client); err != nil {
-			h.logger.Error("Failed to decode client JSON: %v", err)
-			http.Error(w, fmt.Sprintf("Invalid client data: %v", err), http.StatusBadRequest)
+	case http.MethodPost:
+		var business models.Business
+		if err := json.NewDecoder(r.Body).Decode(&business); err != nil {
+			h.writeBodyError(w, fmt.Sprintf("Invalid business data: %v", err), err)
 			return
 		}
 
-		// If no created date is provided, use current date
-		if client.CreatedDate == nil {
-			now := time.Now()
-			client.CreatedDate = &now
-			h.logger.Debug("No created date provided, using current time: %v", now)
+		if err := validateWorkCalendar(&business); err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, err.Error(), nil)
+			return
 		}
-
-		h.logger.Info("Processing client with ID: %d, Name: %s, VAT ID: %s, Country: %s",
-			client.ID, client.Name, client.VatID, client.Country)
-
-		// Special handling for UK VAT IDs
-		if strings.HasPrefix(strings.ToUpper(client.VatID), "GB") {
-			h.logger.Info("UK VAT ID detected: %s", client.VatID)
-
-			// Ensure country is set to GB for UK VAT IDs
-			if client.Country != "GB" {
-				h.logger.Info("Setting country to GB for UK VAT ID")
-				client.Country = "GB"
-			}
+		if err := validateCryptoAddress(&business); err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, err.Error(), nil)
+			return
 		}
 
-		h.logger.Debug("Saving client to database: %+v", client)
-		if err := h.dbService.SaveClient(&client); err != nil {
-			h.logger.Error("Failed to save client: %v", err)
-			http.Error(w, fmt.Sprintf("Failed to save client: %v", err), http.StatusInternalServerError)
+		if err := h.businesses.SaveBusiness(&business); err != nil {
+			if errors.Is(err, services.ErrVersionConflict) {
+				current, getErr := h.businesses.GetBusiness(business.ID)
+				if getErr != nil {
+					h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Business not found with ID: %d", business.ID), nil)
+					return
+				}
+				h.writeConflict(w, "Business details were changed in another window", current)
+				return
+			}
+			h.writeInternalError(w, "Failed to save business details", err)
 			return
 		}
 
-		h.logger.Info("Successfully saved client: %s with ID: %d", client.Name, client.ID)
-		json.NewEncoder(w).Encode(client)
+		json.NewEncoder(w).Encode(business)
 
 	default:
-		h.logger.Warn("Method not allowed: %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		h.writeMethodNotAllowed(w)
 	}
 }
 
-// VatLookupHandler handles VAT ID lookup requests
-func (h *AppHandler) VatLookupHandler(w http.ResponseWriter, r *http.Request) {
+// validateWorkCalendar checks the working time of a business and normalizes
+// its holiday country
+func validateWorkCalendar(business *models.Business) error {
+	if business.WorkHoursPerDay < 0 || business.WorkHoursPerDay > 24 {
+		return fmt.Errorf("Work hours per day must be between 0 and 24, got %v", business.WorkHoursPerDay)
+	}
+	for _, day := range business.WorkDays {
+		if day < time.Sunday || day > time.Saturday {
+			return fmt.Errorf("Invalid working day %d, expected 0 (Sunday) to 6 (Saturday)", day)
+		}
+	}
+	slices.Sort(business.WorkDays)
+	business.WorkDays = slices.Compact(business.WorkDays)
+
+	business.HolidayCountry = refdata.NormalizeCountryCode(business.HolidayCountry)
+	if business.HolidayCountry != "" && !refdata.IsCountryCode(business.HolidayCountry) {
+		return fmt.Errorf("Invalid holiday country: %s", business.HolidayCountry)
+	}
+	return nil
+}
+
+// validateCryptoAddress checks the USDC payment address of a business. The
+// network is required with an address, as USDC sent on another network is lost.
+func validateCryptoAddress(business *models.Business) error {
+	business.CryptoAddress = strings.TrimSpace(business.CryptoAddress)
+	business.CryptoNetwork = strings.TrimSpace(business.CryptoNetwork)
+	if business.CryptoAddress == "" {
+		business.CryptoNetwork = ""
+		return nil
+	}
+	if strings.ContainsFunc(business.CryptoAddress, unicode.IsSpace) {
+		return fmt.Errorf("Invalid crypto payment address: %s", business.CryptoAddress)
+	}
+	if business.CryptoNetwork == "" {
+		return errors.New("The network of the crypto payment address is required, e.g. Ethereum or Solana")
+	}
+	return nil
+}
+
+// WorkCalendarHandler returns the working days and hours of the business in
+// a month, the current month by default
+func (h *AppHandler) WorkCalendarHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodGet {
-		h.logger.Warn("Method not allowed: %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		h.writeMethodNotAllowed(w)
 		return
 	}
 
-	vatID := r.URL.Query().Get("vat_id")
-
-	if vatID == "" {
-		h.logger.Warn("VAT ID is required")
-		http.Error(w, "VAT ID is required", http.StatusBadRequest)
-		return
+	month := time.Now()
+	if value := r.URL.Query().Get("month"); value != "" {
+		parsed, err := time.Parse("2006-01", value)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid month format. Expected YYYY-MM, got: %s", value), nil)
+			return
+		}
+		month = parsed
 	}
 
-	var client *models.Client
-	var err error
-
-	h.logger.Info("Looking up VAT ID: %s", vatID)
-	client, err = h.vatService.ValidateVatID(vatID)
-
+	businesses, err := h.businesses.GetBusinesses()
 	if err != nil {
-		h.logger.Error("VAT lookup failed: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeInternalError(w, "Failed to load business details", err)
 		return
 	}
+	var business models.Business
+	if len(businesses) > 0 {
+		business = businesses[0]
+	}
 
-	h.logger.Info("Successfully looked up client: %s", client.Name)
-	json.NewEncoder(w).Encode(client)
+	workMonth, err := h.holidayService.WorkMonth(&business, month.Year(), month.Month())
+	if err != nil {
+		h.logger.Warn("Work calendar without public holidays: %v", err)
+	}
+	json.NewEncoder(w).Encode(workMonth)
 }
 
-// UKCompanyLookupHandler handles UK company lookup requests
-func (h *AppHandler) UKCompanyLookupHandler(w http.ResponseWriter, r *http.Request) {
+// WorkPeriodHandler lays out the working days of the business in a billing
+// period, for the hours of an invoice
+func (h *AppHandler) WorkPeriodHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodGet {
-		h.logger.Warn("Method not allowed: %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		h.writeMethodNotAllowed(w)
 		return
 	}
 
-	companyName := r.URL.Query().Get("name")
-	companyNumber := r.URL.Query().Get("number")
+	from, err := time.Parse("2006-01-02", r.URL.Query().Get("from"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid from date. Expected YYYY-MM-DD, got: %s", r.URL.Query().Get("from")), nil)
+		return
+	}
+	to, err := time.Parse("2006-01-02", r.URL.Query().Get("to"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid to date. Expected YYYY-MM-DD, got: %s", r.URL.Query().Get("to")), nil)
+		return
+	}
+	if to.Before(from) {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "The period must not end before it starts", nil)
+		return
+	}
+	if days := int(to.Sub(from).Hours()/24) + 1; days > models.MaxWorkPeriodDays {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("The period must not be longer than %d days", models.MaxWorkPeriodDays), nil)
+		return
+	}
 
-	if companyName == "" && companyNumber == "" {
-		h.logger.Warn("Either company name or number is required")
-		http.Error(w, "Either company name or number is required", http.StatusBadRequest)
+	businesses, err := h.businesses.GetBusinesses()
+	if err != nil {
+		h.writeInternalError(w, "Failed to load business details", err)
 		return
 	}
+	var business models.Business
+	if len(businesses) > 0 {
+		business = businesses[0]
+	}
 
-	var clients []*models.Client
-	var client *models.Client
-	var err error
+	period, err := h.holidayService.WorkPeriod(&business, from, to)
+	if err != nil {
+		h.logger.Warn("Work period without public holidays: %v", err)
+	}
+	json.NewEncoder(w).Encode(period)
+}
 
-	if companyNumber != "" {
-		// Lookup by company number
-		h.logger.Info("Looking up UK company by number: %s", companyNumber)
-		client, err = h.vatService.LookupUKCompanyByNumber(companyNumber)
-		if err != nil {
-			h.logger.Error("UK company lookup by number failed: %v", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+// HolidaysHandler lists the public holidays of a country in a year, or
+// downloads them again on POST
+func (h *AppHandler) HolidaysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	country := refdata.NormalizeCountryCode(r.URL.Query().Get("country"))
+	if !refdata.IsCountryCode(country) {
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid country code: %s", country), nil)
+		return
+	}
+	year := time.Now().Year()
+	if value := r.URL.Query().Get("year"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1900 || parsed > 2200 {
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid year: %s", value), nil)
 			return
 		}
-		clients = []*models.Client{client}
-	} else {
-		// Lookup by company name
-		h.logger.Info("Looking up UK company by name: %s", companyName)
-		clients, err = h.vatService.LookupUKCompany(companyName)
-		if err != nil {
-			h.logger.Error("UK company lookup by name failed: %v", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		year = parsed
+	}
+
+	if r.Method == http.MethodPost {
+		if err := h.holidayService.Refresh(country, year); err != nil {
+			h.writeHolidayError(w, err)
 			return
 		}
 	}
-
-	if len(clients) == 0 {
-		h.logger.Warn("No UK companies found")
-		http.Error(w, "No UK companies found", http.StatusNotFound)
+	holidays, err := h.holidayService.Holidays(country, year)
+	if err != nil {
+		h.writeHolidayError(w, err)
 		return
 	}
+	json.NewEncoder(w).Encode(holidays)
+}
 
-	h.logger.Info("Successfully looked up %d UK companies", len(clients))
-	json.NewEncoder(w).Encode(clients)
+// writeHolidayError reports a failed public holiday download
+func (h *AppHandler) writeHolidayError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrHolidaysDisabled) {
+		h.writeError(w, http.StatusServiceUnavailable, errCodeLookupFailed, "Public holiday downloads are disabled on the Settings page", nil)
+		return
+	}
+	h.logger.Error("Public holiday download failed: %v", err)
+	h.writeError(w, http.StatusBadGateway, errCodeLookupFailed, "The public holiday server could not be reached", nil)
 }
 
-// InvoicesAPIHandler handles invoices API requests
-func (h *AppHandler) InvoicesAPIHandler(w http.ResponseWriter, r *http.Request) {
+// ClientsAPIHandler handles clients API requests
+func (h *AppHandler) ClientsAPIHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	switch r.Method {
-	case http.MethodGet:
-		h.logger.Info("Fetching all invoices")
-		invoices, err := h.dbService.GetInvoices()
-		if err != nil {
-			h.logger.Error("Failed to fetch invoices: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	// Check if we're fetching a specific client by ID
+	path := r.URL.Path
+	h.logger.Debug("ClientsAPIHandler received request for path: %s", path)
 
-		h.logger.Info("Successfully fetched %d invoices", len(invoices))
-		json.NewEncoder(w).Encode(invoices)
+	// Skip the vat-lookup endpoint which is handled by VatLookupHandler
+	if strings.HasSuffix(path, "/vat-lookup") {
+		h.logger.Debug("Skipping vat-lookup request in ClientsAPIHandler")
+		return
+	}
 
-	case http.MethodPost:
-		h.logger.Info("Received request to create/update invoice")
+	pathParts := strings.Split(path, "/")
+	if len(pathParts) > 3 && pathParts[3] != "" {
+		// Path format: /api/clients/{id}
+		h.logger.Info("Received request to fetch client by ID: %s", pathParts[3])
 
-		// Read the request body for logging
-		bodyBytes, err := io.ReadAll(r.Body)
+		clientID, err := strconv.Atoi(pathParts[3])
 		if err != nil {
-			h.logger.Error("Failed to read request body: %v", err)
-			http.Error(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+			h.logger.Error("Invalid client ID format: %s - %v", pathParts[3], err)
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid client ID format: %s", pathParts[3]), nil)
 			return
 		}
 
-		// Log the raw request body for debugging
-		h.logger.Debug("Raw request body: %s", string(bodyBytes))
+		// Handle POST /api/clients/{id}/restore to move a client out of the trash
+		if len(pathParts) > 4 && pathParts[4] == "restore" {
+			if r.Method != http.MethodPost {
+				h.logger.Warn("Method not allowed: %s", r.Method)
+				h.writeMethodNotAllowed(w)
+				return
+			}
 
-		// Create a new reader from the bytes for further processing
-		r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+			h.logger.Info("Received request to restore client with ID: %d", clientID)
+			if err := h.clients.RestoreClient(clientID); err != nil {
+				h.logger.Error("Failed to restore client: %v", err)
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Deleted client not found with ID: %d", clientID), nil)
+				return
+			}
 
-		// First, decode the raw JSON to handle date strings manually
-		var rawRequest map[string]json.RawMessage
-		if err := json.NewDecoder(r.Body).Decode(&rawRequest); err != nil {
-			h.logger.Error("Failed to decode invoice JSON: %v", err)
-			http.Error(w, fmt.Sprintf("Invalid invoice data: %v", err), http.StatusBadRequest)
+			h.logger.Info("Successfully restored client with ID: %d", clientID)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Client restored successfully"})
 			return
 		}
 
-		// Check if required fields exist in the request
-		if _, ok := rawRequest["invoice"]; !ok {
-			h.logger.Error("Missing 'invoice' field in request")
-			http.Error(w, "Missing 'invoice' field in request", http.StatusBadRequest)
+		// Handle /api/clients/{id}/credits, the client's prepayments and applied credit
+		if len(pathParts) > 4 && pathParts[4] == "credits" {
+			h.clientCreditsHandler(w, r, clientID)
 			return
 		}
 
-		if _, ok := rawRequest["items"]; !ok {
-			h.logger.Error("Missing 'items' field in request")
-			http.Error(w, "Missing 'items' field in request", http.StatusBadRequest)
+		// Handle /api/clients/{id}/notes and /api/clients/{id}/timeline, the
+		// notes logged against the client merged with its recorded events
+		if len(pathParts) > 4 && (pathParts[4] == "notes" || pathParts[4] == "timeline") {
+			client, err := h.clients.GetClient(clientID)
+			if err != nil {
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Client not found with ID: %d", clientID), nil)
+				return
+			}
+			if pathParts[4] == "notes" {
+				h.notesHandler(w, r, models.NoteEntityClient, clientID)
+			} else {
+				h.clientTimelineHandler(w, r, client)
+			}
 			return
 		}
 
-		// Extract and parse the invoice part
-		var rawInvoice map[string]interface{}
-		if err := json.Unmarshal(rawRequest["invoice"], &rawInvoice); err != nil {
-			h.logger.Error("Failed to parse invoice data: %v", err)
-			http.Error(w, fmt.Sprintf("Invalid invoice data: %v", err), http.StatusBadRequest)
+		// Handle GET /api/clients/{id}/payment-stats, how promptly the client pays
+		if len(pathParts) > 4 && pathParts[4] == "payment-stats" {
+			h.clientPaymentStatsHandler(w, r, clientID)
 			return
 		}
 
-		// Extract and parse the items part
-		var items []models.InvoiceItem
-		if err := json.Unmarshal(rawRequest["items"], &items); err != nil {
-			h.logger.Error("Failed to parse invoice items: %v", err)
-			http.Error(w, fmt.Sprintf("Invalid invoice items: %v", err), http.StatusBadRequest)
+		// Handle POST /api/clients/{id}/anonymize to erase a client's personal data
+		if len(pathParts) > 4 && pathParts[4] == "anonymize" {
+			if r.Method != http.MethodPost {
+				h.logger.Warn("Method not allowed: %s", r.Method)
+				h.writeMethodNotAllowed(w)
+				return
+			}
+
+			h.logger.Info("Received request to anonymize client with ID: %d", clientID)
+			invoiceNumbers, err := h.clients.AnonymizeClient(clientID)
+			if err != nil {
+				h.logger.Error("Failed to anonymize client: %v", err)
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Client not found with ID: %d", clientID), nil)
+				return
+			}
+
+			// Rendered PDFs still contain the erased data; they are regenerated on demand
+			removed := h.removeInvoicePDFs(invoiceNumbers)
+			if invoices, err := h.invoices.GetInvoices(); err != nil {
+				h.logger.Error("Failed to list invoices to remove earlier PDF versions: %v", err)
+			} else {
+				for _, invoice := range invoices {
+					if invoice.ClientID != clientID {
+						continue
+					}
+					if err := h.removeInvoicePDFHistory(invoice.ID); err != nil {
+						h.logger.Error("Failed to remove earlier PDF versions of invoice %d: %v", invoice.ID, err)
+					}
+				}
+			}
+
+			h.logger.Info("Successfully anonymized client with ID: %d (%d PDFs removed)", clientID, removed)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"message":           "Client data erased successfully",
+				"invoices_retained": len(invoiceNumbers),
+				"pdfs_removed":      removed,
+			})
 			return
 		}
 
-		// Log the parsed data for debugging
-		h.logger.Debug("Parsed invoice data: %+v", rawInvoice)
-		h.logger.Debug("Parsed items: %+v", items)
+		// Handle DELETE request for a specific client
+		if r.Method == http.MethodDelete {
+			h.logger.Info("Received request to delete client with ID: %d", clientID)
 
-		// Create the invoice object
-		invoice := models.Invoice{
-			ID:               int(rawInvoice["id"].(float64)),
-			InvoiceNumber:    rawInvoice["invoice_number"].(string),
-			BusinessID:       int(rawInvoice["business_id"].(float64)),
-			ClientID:         int(rawInvoice["client_id"].(float64)),
-			HourlyRate:       rawInvoice["hourly_rate"].(float64),
-			HoursWorked:      rawInvoice["hours_worked"].(float64),
-			TotalAmount:      rawInvoice["total_amount"].(float64),
-			VatRate:          rawInvoice["vat_rate"].(float64),
-			VatAmount:        rawInvoice["vat_amount"].(float64),
-			ReverseChargeVat: rawInvoice["reverse_charge_vat"].(bool),
-			Currency:         rawInvoice["currency"].(string),
-			Notes:            rawInvoice["notes"].(string),
-			Status:           rawInvoice["status"].(string),
-		}
+			// Refuse to trash clients with unpaid invoices unless explicitly forced
+			if r.URL.Query().Get("force") != "true" {
+				openInvoices, err := h.clients.CountOpenInvoicesForClient(clientID)
+				if err != nil {
+					h.writeInternalError(w, "Failed to delete client", err)
+					return
+				}
+				if openInvoices > 0 {
+					h.logger.Warn("Client %d has %d open invoices, confirmation required", clientID, openInvoices)
+					h.writeError(w, http.StatusConflict, errCodeOpenInvoices, fmt.Sprintf("Client has %d open invoice(s)", openInvoices),
+						map[string]int{"open_invoices": openInvoices})
+					return
+				}
+			}
 
-		// Parse the date strings
-		issueDateStr, ok := rawInvoice["issue_date"].(string)
-		if !ok {
-			h.logger.Error("Issue date is missing or not a string")
-			http.Error(w, "Issue date is required and must be a string in YYYY-MM-DD format", http.StatusBadRequest)
+			if err := h.clients.DeleteClient(clientID); err != nil {
+				h.writeInternalError(w, "Failed to delete client", err)
+				return
+			}
+
+			h.logger.Info("Successfully deleted client with ID: %d", clientID)
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Client deleted successfully"})
 			return
 		}
 
-		issueDate, err := time.Parse("2006-01-02", issueDateStr)
+		// Handle GET request for a specific client
+		h.logger.Info("Looking up client with ID: %d", clientID)
+		client, err := h.clients.GetClient(clientID)
 		if err != nil {
-			h.logger.Error("Failed to parse issue date: %v", err)
-			http.Error(w, fmt.Sprintf("Invalid issue date format. Expected YYYY-MM-DD, got: %s", issueDateStr), http.StatusBadRequest)
+			if err == sql.ErrNoRows {
+				h.logger.Error("Client not found with ID: %d", clientID)
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Client not found with ID: %d", clientID), nil)
+			} else {
+				h.writeInternalError(w, "Failed to lookup client", err)
+			}
 			return
 		}
-		invoice.IssueDate = issueDate
 
-		dueDateStr, ok := rawInvoice["due_date"].(string)
-		if !ok {
-			h.logger.Error("Due date is missing or not a string")
-			http.Error(w, "Due date is required and must be a string in YYYY-MM-DD format", http.StatusBadRequest)
+		h.logger.Info("Successfully found client: %s (ID: %d)", services.MaskPII(client.Name), client.ID)
+		json.NewEncoder(w).Encode(client)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		clients, err := h.clients.GetClients()
+		if err != nil {
+			h.writeInternalError(w, "Failed to load clients", err)
 			return
 		}
-
-		dueDate, err := time.Parse("2006-01-02", dueDateStr)
+		page, err := paginate(w, r, clients)
 		if err != nil {
-			h.logger.Error("Failed to parse due date: %v", err)
-			http.Error(w, fmt.Sprintf("Invalid due date format. Expected YYYY-MM-DD, got: %s", dueDateStr), http.StatusBadRequest)
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error(), nil)
 			return
 		}
-		invoice.DueDate = dueDate
 
-		h.logger.Info("Processing invoice with %d items, client ID: %d, business ID: %d",
-			len(items), invoice.ClientID, invoice.BusinessID)
+		json.NewEncoder(w).Encode(page)
 
-		h.logger.Debug("Invoice dates: issue_date=%s, due_date=%s",
-			invoice.IssueDate.Format("2006-01-02"), invoice.DueDate.Format("2006-01-02"))
+	case http.MethodPost:
+		w.Header().Set("Content-Type", "application/json")
 
-		// Validate required fields
-		if invoice.ClientID == 0 {
-			h.logger.Error("Missing client ID in invoice data")
-			http.Error(w, "Client ID is required", http.StatusBadRequest)
+		var client models.Client
+		h.logger.Debug("Decoding client JSON from request body")
+		if err := json.NewDecoder(r.Body).Decode(&client); err != nil {
+			h.logger.Error("Failed to decode client JSON: %v", err)
+			h.writeBodyError(w, fmt.Sprintf("Invalid client data: %v", err), err)
 			return
 		}
 
-		if invoice.BusinessID == 0 {
-			h.logger.Error("Missing business ID in invoice data")
-			http.Error(w, "Business ID is required", http.StatusBadRequest)
+		// If no created date is provided, use current date
+		if client.CreatedDate == nil {
+			now := time.Now()
+			client.CreatedDate = &now
+			h.logger.Debug("No created date provided, using current time: %v", now)
+		}
+
+		h.logger.Info("Processing client with ID: %d, Name: %s, VAT ID: %s, Country: %s",
+			client.ID, services.MaskPII(client.Name), client.VatID, client.Country)
+
+		if err := services.ValidateClient(&client); err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, err.Error(), nil)
 			return
 		}
 
-		if len(items) == 0 {
-			h.logger.Error("No invoice items provided")
-			http.Error(w, "At least one invoice item is required", http.StatusBadRequest)
+		if err := h.hookService.ClientSave(&client); err != nil {
+			h.writeHookError(w, err)
 			return
 		}
 
-		if err := h.dbService.SaveInvoice(&invoice, items); err != nil {
-			h.logger.Error("Failed to save invoice: %v", err)
-			http.Error(w, fmt.Sprintf("Failed to save invoice: %v", err), http.StatusInternalServerError)
+		h.logger.Debug("Saving client to database: %s", services.Redact(client))
+		if err := h.clients.SaveClient(&client); err != nil {
+			if errors.Is(err, services.ErrVersionConflict) {
+				current, getErr := h.clients.GetClient(client.ID)
+				if getErr != nil {
+					h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Client not found with ID: %d", client.ID), nil)
+					return
+				}
+				h.writeConflict(w, "Client was changed in another window", current)
+				return
+			}
+			h.writeInternalError(w, "Failed to save client", err)
 			return
 		}
 
-		h.logger.Info("Successfully saved invoice #%s with ID: %d", invoice.InvoiceNumber, invoice.ID)
+		h.logger.Info("Successfully saved client: %s with ID: %d", services.MaskPII(client.Name), client.ID)
+		json.NewEncoder(w).Encode(client)
 
-		// Automatically generate PDF for the new invoice
-		go func() {
-			// Create a context with timeout for PDF generation
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer cancel()
+	default:
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+	}
+}
 
-			// Create a channel to receive errors
-			errCh := make(chan error, 1)
+// clientPaymentStatsHandler handles GET /api/clients/{id}/payment-stats,
+// the payment behavior of a client computed from its invoices
+func (h *AppHandler) clientPaymentStatsHandler(w http.ResponseWriter, r *http.Request, clientID int) {
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+	if _, err := h.clients.GetClient(clientID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Client not found with ID: %d", clientID), nil)
+			return
+		}
+		h.writeInternalError(w, "Failed to lookup client", err)
+		return
+	}
 
-			// Run PDF generation in a goroutine
-			go func() {
-				h.logger.Info("Automatically generating PDF for invoice ID: %d", invoice.ID)
+	invoices, err := h.invoices.GetInvoices()
+	if err != nil {
+		h.writeInternalError(w, "Failed to fetch invoices", err)
+		return
+	}
 
-				// Get the necessary data for PDF generation
-				savedInvoice, savedItems, err := h.dbService.GetInvoice(invoice.ID)
-				if err != nil {
-					h.logger.Error("Failed to get invoice for automatic PDF generation: %v", err)
-					errCh <- fmt.Errorf("failed to get invoice: %w", err)
-					return
-				}
+	stats := services.PaymentStatsByClient(invoices, time.Now())[clientID]
+	if stats == nil {
+		stats = &models.ClientPaymentStats{ClientID: clientID, OverdueAmounts: map[string]models.Money{}}
+	}
+	json.NewEncoder(w).Encode(stats)
+}
 
-				business, err := h.dbService.GetBusiness(savedInvoice.BusinessID)
-				if err != nil {
-					h.logger.Error("Failed to get business for automatic PDF generation: %v", err)
-					errCh <- fmt.Errorf("failed to get business: %w", err)
-					return
-				}
+// VatLookupHandler handles VAT ID lookup requests
+func (h *AppHandler) VatLookupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
 
-				client, err := h.dbService.GetClient(savedInvoice.ClientID)
-				if err != nil {
-					h.logger.Error("Failed to get client for automatic PDF generation: %v", err)
-					errCh <- fmt.Errorf("failed to get client: %w", err)
-					return
-				}
+	if r.Method != http.MethodGet {
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+		return
+	}
 
-				// Ensure the pdfs directory exists
-				pdfsDir := filepath.Join(h.dataDir, "pdfs")
-				if err := os.MkdirAll(pdfsDir, 0755); err != nil {
-					h.logger.Error("Failed to create pdfs directory for automatic generation: %v", err)
-					errCh <- fmt.Errorf("failed to create pdfs directory: %w", err)
-					return
-				}
+	vatID := r.URL.Query().Get("vat_id")
 
-				// Generate the PDF
-				pdfPath, err := h.pdfService.GenerateInvoice(savedInvoice, business, client, savedItems)
-				if err != nil {
-					h.logger.Error("Failed to automatically generate PDF: %v", err)
-					errCh <- fmt.Errorf("failed to generate PDF: %w", err)
-					return
-				}
+	if vatID == "" {
+		h.logger.Warn("VAT ID is required")
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "VAT ID is required", nil)
+		return
+	}
+
+	var client *models.Client
+	var err error
+
+	h.logger.Info("Looking up VAT ID: %s", vatID)
+	client, err = h.vatService.ValidateVatID(vatID)
+
+	if err != nil {
+		h.logger.Error("VAT lookup failed: %v", err)
+		h.writeLookupError(w, err)
+		return
+	}
+
+	h.logger.Info("Successfully looked up client: %s", services.MaskPII(client.Name))
+	json.NewEncoder(w).Encode(client)
+}
+
+// UKCompanyLookupHandler handles UK company lookup requests
+func (h *AppHandler) UKCompanyLookupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	companyName := r.URL.Query().Get("name")
+	companyNumber := r.URL.Query().Get("number")
+
+	if companyName == "" && companyNumber == "" {
+		h.logger.Warn("Either company name or number is required")
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Either company name or number is required", nil)
+		return
+	}
+
+	var clients []*models.Client
+	var client *models.Client
+	var err error
+
+	if companyNumber != "" {
+		// Lookup by company number
+		h.logger.Info("Looking up UK company by number: %s", companyNumber)
+		client, err = h.vatService.LookupUKCompanyByNumber(companyNumber)
+		if err != nil {
+			h.logger.Error("UK company lookup by number failed: %v", err)
+			h.writeLookupError(w, err)
+			return
+		}
+		clients = []*models.Client{client}
+	} else {
+		// Lookup by company name
+		h.logger.Info("Looking up UK company by name: %s", companyName)
+		clients, err = h.vatService.LookupUKCompany(companyName)
+		if err != nil {
+			h.logger.Error("UK company lookup by name failed: %v", err)
+			h.writeLookupError(w, err)
+			return
+		}
+	}
+
+	if len(clients) == 0 {
+		h.logger.Warn("No UK companies found")
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, "No UK companies found", nil)
+		return
+	}
+
+	h.logger.Info("Successfully looked up %d UK companies", len(clients))
+	json.NewEncoder(w).Encode(clients)
+}
+
+// writeLookupError writes the error of a VAT ID or Companies House lookup
+func (h *AppHandler) writeLookupError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrCompanyNotFound):
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, "No UK companies found", nil)
+	case errors.Is(err, services.ErrLookupRateLimited):
+		h.writeError(w, http.StatusTooManyRequests, errCodeRateLimited, err.Error(), nil)
+	case errors.Is(err, services.ErrLookupUnavailable):
+		h.writeError(w, http.StatusBadGateway, errCodeLookupFailed, err.Error(), nil)
+	default:
+		h.writeError(w, http.StatusBadRequest, errCodeLookupFailed, err.Error(), nil)
+	}
+}
+
+// AddressLookupHandler handles address autocomplete requests, suggesting
+// addresses from OpenStreetMap for clients entered manually
+func (h *AppHandler) AddressLookupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if utf8.RuneCountInString(query) < services.MinAddressQueryLength {
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Enter at least %d characters of the address", services.MinAddressQueryLength), nil)
+		return
+	}
+	country := refdata.NormalizeCountryCode(r.URL.Query().Get("country"))
+	if country != "" && !refdata.IsCountryCode(country) {
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid country code: %s", country), nil)
+		return
+	}
+
+	suggestions, err := h.addressService.Search(r.Context(), query, country)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAddressLookupDisabled):
+			h.writeError(w, http.StatusServiceUnavailable, errCodeLookupFailed, "Address lookup is disabled on the Settings page", nil)
+		case errors.Is(err, services.ErrAddressLookupBusy):
+			h.writeError(w, http.StatusTooManyRequests, errCodeRateLimited, err.Error(), nil)
+		default:
+			h.logger.Error("Address lookup failed: %v", err)
+			h.writeError(w, http.StatusBadGateway, errCodeLookupFailed, "The address lookup service could not be reached", nil)
+		}
+		return
+	}
+
+	json.NewEncoder(w).Encode(suggestions)
+}
+
+// ReferenceData is the country and currency reference data on a date
+type ReferenceData struct {
+	Date       string             `json:"date"`
+	Countries  []refdata.Country  `json:"countries"`
+	Currencies []refdata.Currency `json:"currencies"`
+	EUMembers  []string           `json:"eu_members"`
+	EuroArea   []string           `json:"euro_area"`
+}
+
+// ReferenceDataHandler returns the ISO countries and currencies with the EU
+// and euro area members on a date, today by default
+func (h *AppHandler) ReferenceDataHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	date := time.Now().UTC()
+	if value := r.URL.Query().Get("date"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid date format. Expected YYYY-MM-DD, got: %s", value), nil)
+			return
+		}
+		date = parsed
+	}
+
+	data := ReferenceData{
+		Date:       date.Format("2006-01-02"),
+		Countries:  refdata.Countries(),
+		Currencies: refdata.Currencies(),
+		EUMembers:  refdata.EUMembers(date),
+		EuroArea:   []string{},
+	}
+	for i := range data.Countries {
+		data.Countries[i].Currency = refdata.CountryCurrency(data.Countries[i].Code, date)
+	}
+	for _, code := range data.EUMembers {
+		if refdata.IsEurozone(code, date) {
+			data.EuroArea = append(data.EuroArea, code)
+		}
+	}
+
+	json.NewEncoder(w).Encode(data)
+}
+
+// InvoicesAPIHandler handles invoices API requests
+func (h *AppHandler) InvoicesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		filter, err := models.ParseInvoiceFilter(r.URL.Query())
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error(), nil)
+			return
+		}
+
+		h.logger.Info("Fetching all invoices")
+		invoices, err := h.invoices.GetInvoices()
+		if err != nil {
+			h.writeInternalError(w, "Failed to fetch invoices", err)
+			return
+		}
+		invoices = filterInvoices(invoices, filter)
+		page, err := paginate(w, r, invoices)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error(), nil)
+			return
+		}
+
+		h.logger.Info("Successfully fetched %d of %d invoices", len(page), len(invoices))
+		json.NewEncoder(w).Encode(page)
+
+	case http.MethodPost:
+		h.logger.Info("Received request to create/update invoice")
+
+		// Read the request body for logging
+		bodyBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			h.logger.Error("Failed to read request body: %v", err)
+			h.writeBodyError(w, fmt.Sprintf("Failed to read request body: %v", err), err)
+			return
+		}
+
+		// Log the request body for debugging, without the client's details
+		h.logger.Debug("Request body: %s", services.RedactJSON(bodyBytes))
+
+		// Create a new reader from the bytes for further processing
+		r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+
+		// First, decode the raw JSON to handle date strings manually
+		var rawRequest map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&rawRequest); err != nil {
+			h.logger.Error("Failed to decode invoice JSON: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid invoice data: %v", err), nil)
+			return
+		}
+
+		// Check if required fields exist in the request
+		if _, ok := rawRequest["invoice"]; !ok {
+			h.logger.Error("Missing 'invoice' field in request")
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Missing 'invoice' field in request", nil)
+			return
+		}
+
+		if _, ok := rawRequest["items"]; !ok {
+			h.logger.Error("Missing 'items' field in request")
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Missing 'items' field in request", nil)
+			return
+		}
+
+		// Extract and parse the invoice part
+		var rawInvoice map[string]interface{}
+		if err := json.Unmarshal(rawRequest["invoice"], &rawInvoice); err != nil {
+			h.logger.Error("Failed to parse invoice data: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid invoice data: %v", err), nil)
+			return
+		}
+
+		// Extract and parse the items part
+		var items []models.InvoiceItem
+		if err := json.Unmarshal(rawRequest["items"], &items); err != nil {
+			h.logger.Error("Failed to parse invoice items: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid invoice items: %v", err), nil)
+			return
+		}
+
+		// Log the parsed data for debugging
+		h.logger.Debug("Parsed invoice data: %s", services.Redact(rawInvoice))
+		h.logger.Debug("Parsed items: %s", services.Redact(items))
+
+		// Create the invoice object
+		invoice := models.Invoice{
+			ID:               int(rawInvoice["id"].(float64)),
+			InvoiceNumber:    rawInvoice["invoice_number"].(string),
+			BusinessID:       int(rawInvoice["business_id"].(float64)),
+			ClientID:         int(rawInvoice["client_id"].(float64)),
+			HoursWorked:      rawInvoice["hours_worked"].(float64),
+			VatRate:          rawInvoice["vat_rate"].(float64),
+			ReverseChargeVat: rawInvoice["reverse_charge_vat"].(bool),
+			Currency:         rawInvoice["currency"].(string),
+			Notes:            rawInvoice["notes"].(string),
+		}
+
+		if err := applyInvoiceAmounts(rawRequest["invoice"], &invoice); err != nil {
+			h.logger.Error("Failed to parse invoice amounts: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice data: %v", err), nil)
+			return
+		}
+
+		// Parse the date strings
+		issueDateStr, ok := rawInvoice["issue_date"].(string)
+		if !ok {
+			h.logger.Error("Issue date is missing or not a string")
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "Issue date is required and must be a string in YYYY-MM-DD format", nil)
+			return
+		}
+
+		issueDate, err := time.Parse("2006-01-02", issueDateStr)
+		if err != nil {
+			h.logger.Error("Failed to parse issue date: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid issue date format. Expected YYYY-MM-DD, got: %s", issueDateStr), nil)
+			return
+		}
+		invoice.IssueDate = issueDate
+
+		dueDateStr, ok := rawInvoice["due_date"].(string)
+		if !ok {
+			h.logger.Error("Due date is missing or not a string")
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "Due date is required and must be a string in YYYY-MM-DD format", nil)
+			return
+		}
+
+		dueDate, err := time.Parse("2006-01-02", dueDateStr)
+		if err != nil {
+			h.logger.Error("Failed to parse due date: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid due date format. Expected YYYY-MM-DD, got: %s", dueDateStr), nil)
+			return
+		}
+		invoice.DueDate = dueDate
+
+		if err := applyInvoiceReferences(rawInvoice, &invoice); err != nil {
+			h.logger.Error("Invalid invoice references: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice data: %v", err), nil)
+			return
+		}
+
+		if err := applyHoursBreakdown(rawInvoice, &invoice); err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice data: %v", err), nil)
+			return
+		}
+		if err := applyInvoiceTags(rawInvoice, &invoice); err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice data: %v", err), nil)
+			return
+		}
+		if err := applyPayPalLink(rawInvoice, &invoice); err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice data: %v", err), nil)
+			return
+		}
+
+		if err := applyInvoiceDiscounts(rawInvoice, &invoice, items); err != nil {
+			h.logger.Error("Invalid invoice discount: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice data: %v", err), nil)
+			return
+		}
+
+		// The document type only applies to new invoices, it cannot be changed later
+		invoice.Type, _ = rawInvoice["type"].(string)
+		if invoice.Type == "" {
+			invoice.Type = models.InvoiceTypeInvoice
+		}
+		if !slices.Contains(models.InvoiceTypes, invoice.Type) {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice type %q, expected one of %s", invoice.Type, strings.Join(models.InvoiceTypes, ", ")), nil)
+			return
+		}
 
-				// Verify the file exists and is accessible
-				if _, err := os.Stat(pdfPath); os.IsNotExist(err) {
-					h.logger.Error("Generated PDF file does not exist: %s", pdfPath)
-					errCh <- fmt.Errorf("generated PDF file not found: %s", pdfPath)
+		// The status follows the same rules as on the status route; a held
+		// invoice sent without a reason keeps the one it has
+		if invoice.Status, ok = rawInvoice["status"].(string); !ok {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Status is required and must be one of %s", strings.Join(models.InvoiceStatuses, ", ")), nil)
+			return
+		}
+		invoice.StatusReason, _ = rawInvoice["status_reason"].(string)
+		current := &models.Invoice{Status: models.InvoiceStatusSent}
+		if invoice.ID != 0 {
+			if current, _, err = h.invoices.GetInvoice(invoice.ID); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", invoice.ID), nil)
 					return
 				}
+				h.writeInternalError(w, "Failed to load invoice", err)
+				return
+			}
+			if invoice.StatusReason == "" && invoice.Status == current.Status {
+				invoice.StatusReason = current.StatusReason
+			}
+		}
+		if err := current.ValidateStatusChange(invoice.Status, invoice.StatusReason); err != nil {
+			h.logger.Error("Invalid invoice status: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice data: %v", err), nil)
+			return
+		}
+
+		if err := validateItemKinds(items, invoice.Currency); err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice items: %v", err), nil)
+			return
+		}
+		if err := validateItemUnits(items); err != nil {
+			h.logger.Error("Invalid invoice items: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice items: %v", err), nil)
+			return
+		}
+
+		h.logger.Info("Processing invoice with %d items, client ID: %d, business ID: %d",
+			len(items), invoice.ClientID, invoice.BusinessID)
+
+		h.logger.Debug("Invoice dates: issue_date=%s, due_date=%s",
+			invoice.IssueDate.Format("2006-01-02"), invoice.DueDate.Format("2006-01-02"))
+
+		// Validate required fields
+		if invoice.ClientID == 0 {
+			h.logger.Error("Missing client ID in invoice data")
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "Client ID is required", nil)
+			return
+		}
+
+		if invoice.BusinessID == 0 {
+			h.logger.Error("Missing business ID in invoice data")
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "Business ID is required", nil)
+			return
+		}
+
+		if len(items) == 0 {
+			h.logger.Error("No invoice items provided")
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "At least one invoice item is required", nil)
+			return
+		}
+
+		// Businesses exempt from VAT cannot charge it
+		business, err := h.businesses.GetBusiness(invoice.BusinessID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Business not found with ID: %d", invoice.BusinessID), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to load business", err)
+			return
+		}
+		if err := checkVatExemption(business, &invoice); err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice data: %v", err), nil)
+			return
+		}
+
+		// The project is optional but must belong to the invoiced client
+		if projectID, ok := rawInvoice["project_id"].(float64); ok {
+			invoice.ProjectID = int(projectID)
+		}
+		if err := h.projectService.CheckInvoiceProject(invoice.ProjectID, invoice.ClientID); err != nil {
+			if errors.Is(err, services.ErrProjectClientMismatch) {
+				h.writeError(w, http.StatusBadRequest, errCodeValidation, "The project belongs to a different client", nil)
+				return
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Project not found with ID: %d", invoice.ProjectID), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to load project", err)
+			return
+		}
+
+		if err := h.applyExchangeRate(rawInvoice, &invoice); err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice data: %v", err), nil)
+			return
+		}
+
+		// Finalized invoices must meet the compliance profile of the business
+		if err := h.checkCompliance(&invoice); err != nil {
+			h.writeComplianceError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		// The invoice.create hook may number or reject new invoices
+		if invoice.ID == 0 {
+			if err := h.hookService.InvoiceCreate(&invoice, items); err != nil {
+				h.writeHookError(w, err)
+				return
+			}
+		}
+
+		if err := h.invoices.SaveInvoice(&invoice, items); err != nil {
+			if errors.Is(err, services.ErrDuplicateInvoiceNumber) {
+				h.writeError(w, http.StatusConflict, errCodeDuplicateNumber, fmt.Sprintf("Invoice number %s is already in use", invoice.InvoiceNumber), nil)
+				return
+			}
+			if errors.Is(err, services.ErrInvoiceTotalsMismatch) {
+				h.writeError(w, http.StatusBadRequest, errCodeTotalsMismatch, err.Error(), nil)
+				return
+			}
+			if errors.Is(err, services.ErrCreditExceedsTotal) {
+				h.writeError(w, http.StatusBadRequest, errCodeValidation, err.Error(), nil)
+				return
+			}
+			if errors.Is(err, services.ErrYearClosed) {
+				h.writeError(w, http.StatusConflict, errCodeYearClosed, fmt.Sprintf("Invoices of a closed fiscal year cannot be changed (%v)", err), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to save invoice", err)
+			return
+		}
+
+		h.logger.Info("Successfully saved invoice #%s with ID: %d", invoice.InvoiceNumber, invoice.ID)
+
+		// Return the created invoice to the client
+		json.NewEncoder(w).Encode(invoice)
+
+	default:
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// SimilarInvoicesHandler handles GET /api/invoices/similar, which lists the
+// invoices that an invoice about to be created may duplicate, so the client
+// is not billed twice by accident. Query parameters: client_id and
+// total_amount (required), currency, issue_date (YYYY-MM-DD, today if empty),
+// type, service_period_start and service_period_end, and id to leave out the
+// invoice being edited.
+func (h *AppHandler) SimilarInvoicesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+	query := r.URL.Query()
+
+	invoice := models.Invoice{
+		Currency: query.Get("currency"),
+		Type:     query.Get("type"),
+	}
+	var err error
+	if invoice.ClientID, err = strconv.Atoi(query.Get("client_id")); err != nil || invoice.ClientID <= 0 {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "client_id must be a client ID", nil)
+		return
+	}
+	if invoice.TotalAmount, err = models.ParseMoney(query.Get("total_amount")); err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid total_amount: %v", err), nil)
+		return
+	}
+	if value := query.Get("id"); value != "" {
+		if invoice.ID, err = strconv.Atoi(value); err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice ID: %s", value), nil)
+			return
+		}
+	}
+	if invoice.Currency == "" {
+		invoice.Currency = h.settingsService.GetString(services.SettingInvoiceCurrency)
+	}
+	if invoice.Type == "" {
+		invoice.Type = models.InvoiceTypeInvoice
+	}
+	if !slices.Contains(models.InvoiceTypes, invoice.Type) {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice type %q, expected one of %s", invoice.Type, strings.Join(models.InvoiceTypes, ", ")), nil)
+		return
+	}
+
+	now := time.Now()
+	invoice.IssueDate = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for _, date := range []struct {
+		name   string
+		target *time.Time
+	}{
+		{"issue_date", &invoice.IssueDate},
+		{"service_period_start", &invoice.ServicePeriodStart},
+		{"service_period_end", &invoice.ServicePeriodEnd},
+	} {
+		value := query.Get(date.name)
+		if value == "" {
+			continue
+		}
+		if *date.target, err = time.Parse("2006-01-02", value); err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid %s format. Expected YYYY-MM-DD, got: %s", date.name, value), nil)
+			return
+		}
+	}
+
+	invoices, err := h.invoices.GetInvoices()
+	if err != nil {
+		h.writeInternalError(w, "Failed to fetch invoices", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(services.SimilarInvoices(invoices, &invoice))
+}
+
+// checkVatExemption rejects VAT on the invoices of a business that is exempt
+// from VAT under a small-business scheme
+func checkVatExemption(business *models.Business, invoice *models.Invoice) error {
+	if !business.VatExempt {
+		return nil
+	}
+	if invoice.VatRate != 0 || invoice.ReverseChargeVat {
+		return fmt.Errorf("the business is exempt from VAT, so the VAT rate must be 0 and reverse charge cannot be used")
+	}
+	return nil
+}
+
+// applyExchangeRate records the rate used to show the totals of an invoice in
+// the home currency. A positive exchange_rate in the request is taken as the
+// official rate of the issue date, otherwise the ECB reference rate is used.
+func (h *AppHandler) applyExchangeRate(rawInvoice map[string]interface{}, invoice *models.Invoice) error {
+	rate, _ := rawInvoice["exchange_rate"].(float64)
+	if rate < 0 {
+		return fmt.Errorf("exchange rate must not be negative")
+	}
+	homeCurrency := h.homeCurrency(invoice)
+	if rate == 0 || homeCurrency == "" {
+		h.lookupExchangeRate(invoice)
+		return nil
+	}
+	invoice.HomeCurrency = homeCurrency
+	invoice.ExchangeRate = rate
+	invoice.ExchangeRateDate = invoice.IssueDate
+	return nil
+}
+
+// lookupExchangeRate sets the ECB reference rate of the issue date between the
+// invoice currency and the home currency. Without a home currency or a rate
+// the totals are not converted.
+func (h *AppHandler) lookupExchangeRate(invoice *models.Invoice) {
+	invoice.HomeCurrency = ""
+	invoice.ExchangeRate = 0
+	invoice.ExchangeRateDate = time.Time{}
+
+	homeCurrency := h.homeCurrency(invoice)
+	if homeCurrency == "" {
+		return
+	}
+	rate, rateDate, err := h.exchangeRateService.Rate(invoice.Currency, homeCurrency, invoice.IssueDate)
+	if err != nil {
+		h.logger.Warn("No %s to %s exchange rate for %s, totals are not converted: %v",
+			invoice.Currency, homeCurrency, invoice.IssueDate.Format("2006-01-02"), err)
+		return
+	}
+	invoice.HomeCurrency = homeCurrency
+	invoice.ExchangeRate = rate
+	invoice.ExchangeRateDate = rateDate
+}
+
+// homeCurrency returns the configured home currency if the totals of the
+// invoice are converted into it, or an empty string
+func (h *AppHandler) homeCurrency(invoice *models.Invoice) string {
+	if h.settingsService == nil || h.exchangeRateService == nil {
+		return ""
+	}
+	homeCurrency := h.settingsService.GetString(services.SettingHomeCurrency)
+	if homeCurrency == invoice.Currency {
+		return ""
+	}
+	return homeCurrency
+}
+
+// applyHoursBreakdown sets whether the PDF lists the hours worked per day and,
+// when the request has a timesheet grid or an hours_table, the days of that
+// page. A timesheet also sets the hours worked to the hours of its days.
+func applyHoursBreakdown(rawInvoice map[string]interface{}, invoice *models.Invoice) error {
+	invoice.ShowHoursBreakdown, _ = rawInvoice["show_hours_breakdown"].(bool)
+	table, hasTable := rawInvoice["hours_table"].(string)
+	rawTimesheet, hasTimesheet := rawInvoice["timesheet"]
+	if hasTimesheet && rawTimesheet != nil {
+		if hasTable && strings.TrimSpace(table) != "" {
+			return errors.New("send either a timesheet or an hours table, not both")
+		}
+		data, err := json.Marshal(rawTimesheet)
+		if err != nil {
+			return fmt.Errorf("invalid timesheet: %w", err)
+		}
+		var days []models.TimesheetDay
+		if err := json.Unmarshal(data, &days); err != nil {
+			return errors.New("invalid timesheet: expected a list of days with a date and hours")
+		}
+		entries, err := models.TimesheetEntries(days)
+		if err != nil {
+			return fmt.Errorf("invalid timesheet: %w", err)
+		}
+		invoice.HoursBreakdown = entries
+		invoice.HoursWorked = models.TotalHours(entries)
+		return nil
+	}
+	if !hasTable {
+		return nil
+	}
+	entries, err := models.ParseHoursTable(table)
+	if err != nil {
+		return fmt.Errorf("invalid hours table: %w", err)
+	}
+	invoice.HoursBreakdown = entries
+	if invoice.HoursBreakdown == nil {
+		invoice.HoursBreakdown = []models.TimeEntry{}
+	}
+	return nil
+}
+
+// applyInvoiceReferences sets the optional PO number, contract reference and
+// service period (YYYY-MM-DD dates) from a decoded invoice request
+func applyInvoiceReferences(rawInvoice map[string]interface{}, invoice *models.Invoice) error {
+	poNumber, _ := rawInvoice["po_number"].(string)
+	contractReference, _ := rawInvoice["contract_reference"].(string)
+	invoice.PONumber = strings.TrimSpace(poNumber)
+	invoice.ContractReference = strings.TrimSpace(contractReference)
+
+	for _, field := range []struct {
+		key    string
+		label  string
+		target *time.Time
+	}{
+		{"service_period_start", "service period start", &invoice.ServicePeriodStart},
+		{"service_period_end", "service period end", &invoice.ServicePeriodEnd},
+	} {
+		value, _ := rawInvoice[field.key].(string)
+		if value == "" {
+			*field.target = time.Time{}
+			continue
+		}
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return fmt.Errorf("invalid %s format, expected YYYY-MM-DD, got: %s", field.label, value)
+		}
+		*field.target = date
+	}
+
+	if invoice.ServicePeriodStart.IsZero() != invoice.ServicePeriodEnd.IsZero() {
+		return errors.New("service period requires both a start and an end date")
+	}
+	if invoice.ServicePeriodEnd.Before(invoice.ServicePeriodStart) {
+		return errors.New("service period end must not be before its start")
+	}
+	return nil
+}
+
+// applyPayPalLink sets the PayPal payment link offered by an invoice from a
+// decoded invoice request
+func applyPayPalLink(rawInvoice map[string]interface{}, invoice *models.Invoice) error {
+	invoice.PayPal, _ = rawInvoice["paypal"].(string)
+	if invoice.PayPal != "" && !slices.Contains(models.PayPalLinks, invoice.PayPal) {
+		return fmt.Errorf("invalid PayPal link %q, expected one of %s", invoice.PayPal, strings.Join(models.PayPalLinks, ", "))
+	}
+	return nil
+}
+
+// applyInvoiceAmounts decodes the money fields of an invoice request. They
+// are decoded from the raw JSON so amounts are never rounded through a float.
+func applyInvoiceAmounts(data json.RawMessage, invoice *models.Invoice) error {
+	var amounts struct {
+		HourlyRate     models.Money `json:"hourly_rate"`
+		TotalAmount    models.Money `json:"total_amount"`
+		VatAmount      models.Money `json:"vat_amount"`
+		DiscountAmount models.Money `json:"discount_amount"`
+	}
+	if err := json.Unmarshal(data, &amounts); err != nil {
+		return err
+	}
+
+	invoice.HourlyRate = amounts.HourlyRate
+	invoice.TotalAmount = amounts.TotalAmount
+	invoice.VatAmount = amounts.VatAmount
+	invoice.DiscountAmount = amounts.DiscountAmount
+	return nil
+}
+
+// applyInvoiceDiscounts sets the optional invoice-level discount percentage
+// from a decoded invoice request and validates the invoice and item discounts
+func applyInvoiceDiscounts(rawInvoice map[string]interface{}, invoice *models.Invoice, items []models.InvoiceItem) error {
+	invoice.DiscountPercent, _ = rawInvoice["discount_percent"].(float64)
+	if err := models.ValidateDiscount(invoice.DiscountPercent, invoice.DiscountAmount); err != nil {
+		return err
+	}
+
+	for i, item := range items {
+		if err := models.ValidateDiscount(item.DiscountPercent, item.DiscountAmount); err != nil {
+			return fmt.Errorf("item %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// validateItemUnits defaults missing units to hours and rejects unknown ones
+func validateItemUnits(items []models.InvoiceItem) error {
+	for i := range items {
+		if items[i].Unit == "" {
+			items[i].Unit = models.UnitHours
+		}
+		if !models.IsValidUnit(items[i].Unit) {
+			return fmt.Errorf("item %d: unsupported unit %q, expected one of %s", i+1, items[i].Unit, strings.Join(models.ItemUnits, ", "))
+		}
+	}
+	return nil
+}
+
+// validateItemKinds defaults missing kinds to services, rejects unknown ones
+// and sets the unit and VAT treatment of expense items. Mileage and per diems
+// without a description are described from their quantity and rate.
+func validateItemKinds(items []models.InvoiceItem, currency string) error {
+	for i := range items {
+		item := &items[i]
+		if item.Kind == "" {
+			item.Kind = models.ItemKindService
+		}
+		switch item.Kind {
+		case models.ItemKindService:
+			item.VatExempt = false
+		case models.ItemKindMileage:
+			item.Unit = models.UnitKilometres
+		case models.ItemKindPerDiem:
+			item.Unit = models.UnitDays
+		case models.ItemKindDisbursement:
+			item.VatExempt = true
+		default:
+			return fmt.Errorf("item %d: unsupported kind %q, expected one of %s", i+1, item.Kind, strings.Join(models.ItemKinds, ", "))
+		}
+		if strings.TrimSpace(item.Description) == "" {
+			item.Description = item.ExpenseDescription(currency)
+		}
+	}
+	return nil
+}
+
+// GeneratePDFHandler generates a PDF invoice
+func (h *AppHandler) GeneratePDFHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.logger.Warn("Method not allowed for PDF generation: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	idStr := r.URL.Path[len("/api/invoices/generate-pdf/"):]
+	h.logger.Debug("PDF generation requested for invoice ID: %s", idStr)
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		h.logger.Error("Invalid invoice ID for PDF generation: %s - %v", idStr, err)
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Invalid invoice ID", nil)
+		return
+	}
+
+	h.logger.Info("Generating PDF for invoice ID: %d", id)
+
+	pdfPath, err := h.generateInvoicePDF(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", id), nil)
+			return
+		}
+		h.writeInternalError(w, "Failed to generate PDF", err)
+		return
+	}
+
+	// Link to the PDF by invoice ID with a signature, so PDFs cannot be fetched by guessing their name
+	pdfURL := h.invoicePDFURL(id, pdfViewLinkLifetime)
+	h.logger.Debug("PDF URL: %s", pdfURL)
+
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]string{
+		"filename":  filepath.Base(pdfPath),
+		"url":       pdfURL,
+		"share_url": h.sharedInvoicePDFURL(id),
+	}
+	h.logger.Debug("Sending PDF response: %v", response)
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Error("Failed to encode PDF response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PreviewPDFHandler generates a PDF preview based on form data. With
+// stream=true the PDF is rendered in memory and returned in the response;
+// otherwise it is saved to the previews directory and a signed link returned.
+func (h *AppHandler) PreviewPDFHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.logger.Warn("Method not allowed for PDF preview: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	h.logger.Info("Generating PDF preview")
+
+	// First parse the raw JSON data to access the date fields
+	var rawData map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&rawData); err != nil {
+		h.logger.Error("Failed to decode raw preview data: %v", err)
+		h.writeBodyError(w, "Invalid request data", err)
+		return
+	}
+
+	// Parse the invoice data to access date fields
+	var rawInvoice map[string]interface{}
+	if err := json.Unmarshal(rawData["invoice"], &rawInvoice); err != nil {
+		h.logger.Error("Failed to parse invoice data: %v", err)
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Invalid invoice data", nil)
+		return
+	}
+
+	// Create the preview data structure
+	var previewData struct {
+		Invoice  models.Invoice       `json:"invoice"`
+		Items    []models.InvoiceItem `json:"items"`
+		Business models.Business      `json:"business"`
+		Client   models.Client        `json:"client"`
+	}
+
+	// Parse the rest of the data
+	if err := json.Unmarshal(rawData["business"], &previewData.Business); err != nil {
+		h.logger.Error("Failed to decode business data: %v", err)
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Invalid business data", nil)
+		return
+	}
+
+	if err := json.Unmarshal(rawData["client"], &previewData.Client); err != nil {
+		h.logger.Error("Failed to decode client data: %v", err)
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Invalid client data", nil)
+		return
+	}
+
+	if err := json.Unmarshal(rawData["items"], &previewData.Items); err != nil {
+		h.logger.Error("Failed to decode items data: %v", err)
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Invalid items data", nil)
+		return
+	}
+
+	// Fill in the invoice fields
+	previewData.Invoice.ID = int(rawInvoice["id"].(float64))
+	previewData.Invoice.InvoiceNumber = rawInvoice["invoice_number"].(string)
+	previewData.Invoice.BusinessID = int(rawInvoice["business_id"].(float64))
+	previewData.Invoice.ClientID = int(rawInvoice["client_id"].(float64))
+	previewData.Invoice.HoursWorked = rawInvoice["hours_worked"].(float64)
+	previewData.Invoice.VatRate = rawInvoice["vat_rate"].(float64)
+	previewData.Invoice.ReverseChargeVat = rawInvoice["reverse_charge_vat"].(bool)
+	previewData.Invoice.Currency = rawInvoice["currency"].(string)
+	previewData.Invoice.Notes = rawInvoice["notes"].(string)
+	previewData.Invoice.Status, _ = rawInvoice["status"].(string)
+	previewData.Invoice.Type, _ = rawInvoice["type"].(string)
+
+	if err := applyInvoiceAmounts(rawData["invoice"], &previewData.Invoice); err != nil {
+		h.logger.Error("Failed to parse invoice amounts: %v", err)
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Invalid invoice data", nil)
+		return
+	}
+
+	// Handle date parsing
+	issueDateStr, ok := rawInvoice["issue_date"].(string)
+	if !ok {
+		h.logger.Error("Issue date is missing or not a string")
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Issue date is required", nil)
+		return
+	}
+
+	// Try parsing with the simple date format first
+	issueDate, err := time.Parse("2006-01-02", issueDateStr)
+	if err != nil {
+		// If that fails, try the full timestamp format
+		issueDate, err = time.Parse(time.RFC3339, issueDateStr)
+		if err != nil {
+			h.logger.Error("Failed to parse issue date: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Invalid issue date format", nil)
+			return
+		}
+	}
+	previewData.Invoice.IssueDate = issueDate
+
+	dueDateStr, ok := rawInvoice["due_date"].(string)
+	if !ok {
+		h.logger.Error("Due date is missing or not a string")
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Due date is required", nil)
+		return
+	}
+
+	// Try parsing with the simple date format first
+	dueDate, err := time.Parse("2006-01-02", dueDateStr)
+	if err != nil {
+		// If that fails, try the full timestamp format
+		dueDate, err = time.Parse(time.RFC3339, dueDateStr)
+		if err != nil {
+			h.logger.Error("Failed to parse due date: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Invalid due date format", nil)
+			return
+		}
+	}
+	previewData.Invoice.DueDate = dueDate
+
+	if err := applyInvoiceReferences(rawInvoice, &previewData.Invoice); err != nil {
+		h.logger.Error("Invalid invoice references: %v", err)
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice data: %v", err), nil)
+		return
+	}
+
+	if err := applyHoursBreakdown(rawInvoice, &previewData.Invoice); err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice data: %v", err), nil)
+		return
+	}
+	if err := applyPayPalLink(rawInvoice, &previewData.Invoice); err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice data: %v", err), nil)
+		return
+	}
+
+	if err := applyInvoiceDiscounts(rawInvoice, &previewData.Invoice, previewData.Items); err != nil {
+		h.logger.Error("Invalid invoice discount: %v", err)
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice data: %v", err), nil)
+		return
+	}
+
+	if err := validateItemKinds(previewData.Items, previewData.Invoice.Currency); err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice items: %v", err), nil)
+		return
+	}
+	if err := validateItemUnits(previewData.Items); err != nil {
+		h.logger.Error("Invalid invoice items: %v", err)
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice items: %v", err), nil)
+		return
+	}
+
+	if err := checkVatExemption(&previewData.Business, &previewData.Invoice); err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice data: %v", err), nil)
+		return
+	}
+
+	if err := h.applyExchangeRate(rawInvoice, &previewData.Invoice); err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid invoice data: %v", err), nil)
+		return
+	}
+
+	// Previews are not saved, so they show the recalculated amounts instead of rejecting mismatches
+	previewData.Invoice.ApplyTotals(previewData.Items)
+	h.applyReverseChargeClause(&previewData.Invoice, &previewData.Client)
+	if previewData.Invoice.ComplianceClauses, err = h.complianceService.Clauses(&previewData.Business); err != nil {
+		h.writeInternalError(w, "Failed to load the compliance profile", err)
+		return
+	}
+
+	// Create a unique preview filename using a timestamp
+	previewID := fmt.Sprintf("preview-%d", time.Now().UnixNano())
+	previewData.Invoice.InvoiceNumber = previewID
+	previewData.Invoice.PayPalLink = h.paypalService.Link(&previewData.Invoice)
+
+	if r.URL.Query().Get("stream") == "true" {
+		data, err := h.pdfService.RenderInvoice(&previewData.Invoice, &previewData.Business, &previewData.Client, previewData.Items)
+		if err != nil {
+			h.writeInternalError(w, "Failed to generate preview", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": previewData.Invoice.PDFFilename()}))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(data)
+		return
+	}
+
+	// Generate the PDF
+	pdfPath, err := h.pdfService.GeneratePreview(&previewData.Invoice, &previewData.Business, &previewData.Client, previewData.Items)
+	if err != nil {
+		h.writeInternalError(w, "Failed to generate preview", err)
+		return
+	}
 
-				// Extract just the filename from the full path
-				pdfFilename := filepath.Base(pdfPath)
-				h.logger.Info("Successfully generated PDF: %s at path: %s", pdfFilename, pdfPath)
-				errCh <- nil
-			}()
+	// Extract just the filename from the full path
+	pdfFilename := filepath.Base(pdfPath)
+	pdfURL := h.previewURL(pdfFilename)
+	h.logger.Info("Generated preview PDF: %s", pdfFilename)
 
-			// Wait for either the PDF generation to complete or the context to timeout
-			select {
-			case err := <-errCh:
-				if err != nil {
-					h.logger.Error("PDF generation failed: %v", err)
-				}
-			case <-ctx.Done():
-				h.logger.Error("PDF generation timed out after 30 seconds")
-			}
-		}()
+	// Return the PDF URL
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"url": pdfURL,
+	})
+}
 
-		// Return the created invoice to the client
-		json.NewEncoder(w).Encode(invoice)
+// maxLogoUploadSize limits the size of uploaded logo files
+const maxLogoUploadSize = 10 << 20 // 10 MB
 
+// UploadLogoHandler handles logo uploads (POST) and removal (DELETE)
+func (h *AppHandler) UploadLogoHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodDelete:
+		h.deleteLogo(w)
+		return
 	default:
-		h.logger.Warn("Method not allowed: %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
-}
-
-// GeneratePDFHandler generates a PDF invoice
-func (h *AppHandler) GeneratePDFHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		h.logger.Warn("Method not allowed for PDF generation: %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		h.logger.Warn("Method not allowed for logo upload: %s", r.Method)
+		h.writeMethodNotAllowed(w)
 		return
 	}
 
-	idStr := r.URL.Path[len("/api/invoices/generate-pdf/"):]
-	h.logger.Debug("PDF generation requested for invoice ID: %s", idStr)
-
-	id, err := strconv.Atoi(idStr)
+	// Parse the multipart form
+	r.Body = http.MaxBytesReader(w, r.Body, maxLogoUploadSize)
+	err := r.ParseMultipartForm(maxLogoUploadSize)
 	if err != nil {
-		h.logger.Error("Invalid invoice ID for PDF generation: %s - %v", idStr, err)
-		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		h.logger.Error("Failed to parse multipart form: %v", err)
+		h.writeBodyError(w, fmt.Sprintf("Failed to parse form: %v", err), err)
 		return
 	}
 
-	h.logger.Info("Generating PDF for invoice ID: %d", id)
-
-	invoice, items, err := h.dbService.GetInvoice(id)
+	// Get the file from the form
+	file, handler, err := r.FormFile("logo")
 	if err != nil {
-		h.logger.Error("Failed to get invoice for PDF generation: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to get invoice: %v", err), http.StatusInternalServerError)
+		h.logger.Error("Failed to get logo file from form: %v", err)
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Failed to get logo file: %v", err), nil)
 		return
 	}
-	h.logger.Debug("Retrieved invoice #%s with %d items", invoice.InvoiceNumber, len(items))
+	defer file.Close()
 
-	business, err := h.dbService.GetBusiness(invoice.BusinessID)
-	if err != nil {
-		h.logger.Error("Failed to get business for PDF generation: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to get business details: %v", err), http.StatusInternalServerError)
+	h.logger.Debug("Received logo upload: %s, size: %d bytes, content type: %s",
+		handler.Filename, handler.Size, handler.Header.Get("Content-Type"))
+
+	if err := checkUploadedFile(file, handler, logoExtensions, logoContentTypes); err != nil {
+		h.logger.Warn("Rejected logo upload %s: %v", handler.Filename, err)
+		if errors.Is(err, errUnsupportedFile) {
+			h.writeError(w, http.StatusUnsupportedMediaType, errCodeUnsupportedFile, "Invalid image. Upload a PNG, JPEG or GIF file.", nil)
+			return
+		}
+		h.writeInternalError(w, "Failed to read logo file", err)
 		return
 	}
-	h.logger.Debug("Retrieved business details: %s", business.Name)
 
-	client, err := h.dbService.GetClient(invoice.ClientID)
+	// Decode, validate and scale the image; it is always stored as PNG
+	logo, err := services.ProcessLogo(file)
 	if err != nil {
-		h.logger.Error("Failed to get client for PDF generation: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to get client details: %v", err), http.StatusInternalServerError)
+		h.logger.Error("Failed to process logo: %v", err)
+		if errors.Is(err, services.ErrInvalidImage) {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "Invalid image. Upload a PNG, JPEG or GIF file.", nil)
+			return
+		}
+		h.writeInternalError(w, "Failed to process logo", err)
 		return
 	}
-	h.logger.Debug("Retrieved client details: %s", client.Name)
 
-	// Ensure the pdfs directory exists
-	pdfsDir := filepath.Join(h.dataDir, "pdfs")
-	if err := os.MkdirAll(pdfsDir, 0755); err != nil {
-		h.logger.Error("Failed to create pdfs directory: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to create pdfs directory: %v", err), http.StatusInternalServerError)
+	// Create the uploads directory if it doesn't exist
+	uploadsDir := filepath.Join(h.dataDir, "images")
+	h.logger.Debug("Ensuring uploads directory exists: %s", uploadsDir)
+	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
+		h.writeInternalError(w, "Failed to create uploads directory", err)
 		return
 	}
-	h.logger.Debug("Ensured pdfs directory exists: %s", pdfsDir)
 
-	h.logger.Debug("Calling PDF service to generate invoice PDF")
-	pdfPath, err := h.pdfService.GenerateInvoice(invoice, business, client, items)
+	// Use a unique filename so uploads never overwrite each other
+	logoFilename, err := services.NewLogoFilename()
 	if err != nil {
-		h.logger.Error("Failed to generate PDF: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to generate PDF: %v", err), http.StatusInternalServerError)
+		h.writeInternalError(w, "Failed to save logo file", err)
+		return
+	}
+	filename := filepath.Join(uploadsDir, logoFilename)
+	if err := os.WriteFile(filename, logo, 0644); err != nil {
+		h.writeInternalError(w, "Failed to save uploaded file", err)
 		return
 	}
 
-	// Extract just the filename from the full path
-	pdfFilename := filepath.Base(pdfPath)
-	h.logger.Info("Successfully generated PDF: %s at path: %s", pdfFilename, pdfPath)
+	h.logger.Info("Successfully saved logo to: %s (%d bytes written)", filename, len(logo))
 
-	// Verify the file exists and is accessible
-	if _, err := os.Stat(pdfPath); os.IsNotExist(err) {
-		h.logger.Error("Generated PDF file does not exist: %s", pdfPath)
-		http.Error(w, "Generated PDF file not found", http.StatusInternalServerError)
+	// Update the business logo path
+	businesses, err := h.businesses.GetBusinesses()
+	if err != nil {
+		h.logger.Error("Failed to get businesses: %v", err)
+		os.Remove(filename)
+		h.writeInternalError(w, "Failed to get business details", err)
 		return
 	}
 
-	// Check file permissions
-	fileInfo, err := os.Stat(pdfPath)
-	if err != nil {
-		h.logger.Error("Error checking PDF file: %v", err)
+	businessVersion := 0
+	if len(businesses) > 0 {
+		business := businesses[0]
+		previousLogo := business.LogoPath
+		// Store only the filename, not the full path
+		business.LogoPath = logoFilename
+		h.logger.Debug("Updating business with logo path: %s", business.LogoPath)
+		if err := h.businesses.SaveBusiness(&business); err != nil {
+			h.logger.Error("Failed to save business with logo: %v", err)
+			os.Remove(filename)
+			h.writeInternalError(w, "Failed to update business with logo", err)
+			return
+		}
+		h.logger.Info("Updated business with logo path: %s", business.LogoPath)
+		businessVersion = business.Version
+		h.removeLogoFile(previousLogo)
 	} else {
-		h.logger.Debug("PDF file permissions: %v, size: %d bytes", fileInfo.Mode(), fileInfo.Size())
+		h.logger.Warn("No business found to update with logo")
 	}
 
-	// Set the correct URL for the PDF file
-	pdfURL := fmt.Sprintf("/data/pdfs/%s", pdfFilename)
-	h.logger.Debug("PDF URL: %s", pdfURL)
-
+	// Return success response
 	w.Header().Set("Content-Type", "application/json")
-	response := map[string]string{
-		"filename": pdfFilename,
-		"url":      pdfURL,
+	response := map[string]interface{}{
+		"filename": logoFilename,
+		"path":     filename,
+		"url":      "/data/images/" + logoFilename,
+		"message":  "Logo uploaded successfully",
+		"version":  businessVersion,
 	}
-	h.logger.Debug("Sending PDF response: %v", response)
+	h.logger.Debug("Sending logo upload response: %v", response)
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		h.logger.Error("Failed to encode PDF response: %v", err)
+		h.logger.Error("Failed to encode response: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 }
 
-// PreviewPDFHandler generates a PDF preview based on form data
-func (h *AppHandler) PreviewPDFHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		h.logger.Warn("Method not allowed for PDF preview: %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// deleteLogo removes the business logo and its file
+func (h *AppHandler) deleteLogo(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+
+	businesses, err := h.businesses.GetBusinesses()
+	if err != nil {
+		h.writeInternalError(w, "Failed to get business details", err)
+		return
+	}
+	if len(businesses) == 0 {
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, "Business not found", nil)
 		return
 	}
 
-	h.logger.Info("Generating PDF preview")
+	business := businesses[0]
+	previousLogo := business.LogoPath
+	if previousLogo != "" {
+		business.LogoPath = ""
+		if err := h.businesses.SaveBusiness(&business); err != nil {
+			h.writeInternalError(w, "Failed to remove logo", err)
+			return
+		}
+		h.removeLogoFile(previousLogo)
+		h.logger.Info("Removed business logo: %s", previousLogo)
+	}
 
-	// First parse the raw JSON data to access the date fields
-	var rawData map[string]json.RawMessage
-	if err := json.NewDecoder(r.Body).Decode(&rawData); err != nil {
-		h.logger.Error("Failed to decode raw preview data: %v", err)
-		http.Error(w, "Invalid request data", http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Logo removed successfully",
+		"version": business.Version,
+	})
+}
+
+// removeLogoFile deletes a logo that is no longer referenced from the images directory
+func (h *AppHandler) removeLogoFile(logoPath string) {
+	if logoPath == "" {
 		return
 	}
-
-	// Parse the invoice data to access date fields
-	var rawInvoice map[string]interface{}
-	if err := json.Unmarshal(rawData["invoice"], &rawInvoice); err != nil {
-		h.logger.Error("Failed to parse invoice data: %v", err)
-		http.Error(w, "Invalid invoice data", http.StatusBadRequest)
+	path := filepath.Join(h.dataDir, "images", filepath.Base(logoPath))
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		h.logger.Warn("Failed to remove old logo %s: %v", path, err)
 		return
 	}
+	h.logger.Debug("Removed old logo file: %s", path)
+}
 
-	// Create the preview data structure
-	var previewData struct {
-		Invoice  models.Invoice       `json:"invoice"`
-		Items    []models.InvoiceItem `json:"items"`
-		Business models.Business      `json:"business"`
-		Client   models.Client        `json:"client"`
+// InvoiceByIDHandler handles operations on a specific invoice by ID
+func (h *AppHandler) InvoiceByIDHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the invoice ID from the URL
+	path := r.URL.Path
+	idStr, subresource, _ := strings.Cut(path[len("/api/invoices/"):], "/")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Invalid invoice ID", nil)
+		return
 	}
 
-	// Parse the rest of the data
-	if err := json.Unmarshal(rawData["business"], &previewData.Business); err != nil {
-		h.logger.Error("Failed to decode business data: %v", err)
-		http.Error(w, "Invalid business data", http.StatusBadRequest)
+	// Handle GET /api/invoices/{id}/pdfs, the generated PDF versions of the invoice
+	if subresource == "pdfs" {
+		if r.Method != http.MethodGet {
+			h.writeMethodNotAllowed(w)
+			return
+		}
+		versions, err := h.invoicePDFVersions(id)
+		if err != nil {
+			h.writeInternalError(w, "Failed to load PDF versions", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(versions)
+		return
+	}
+	if subresource == "email" {
+		h.invoiceEmailHandler(w, r, id)
+		return
+	}
+	if subresource == "emails" {
+		if r.Method != http.MethodGet {
+			h.writeMethodNotAllowed(w)
+			return
+		}
+		emails, err := h.dbService.GetInvoiceEmails(id)
+		if err != nil {
+			h.writeInternalError(w, "Failed to load sent emails", err)
+			return
+		}
+		if emails == nil {
+			emails = []models.InvoiceEmail{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(emails)
+		return
+	}
+	if subresource == "send" {
+		h.sendInvoiceHandler(w, r, id)
+		return
+	}
+	if subresource == "scheduled-email" {
+		h.scheduledEmailHandler(w, r, id)
+		return
+	}
+	if subresource == "convert" {
+		h.convertProformaHandler(w, r, id)
+		return
+	}
+	if subresource == "cancel" {
+		h.cancelInvoiceHandler(w, r, id)
+		return
+	}
+	if subresource == "time-entries" {
+		h.invoiceTimeEntriesHandler(w, r, id)
+		return
+	}
+	if subresource == "credit" {
+		h.applyCreditHandler(w, r, id)
+		return
+	}
+	if subresource == "crypto-payment" {
+		h.cryptoPaymentHandler(w, r, id)
+		return
+	}
+	if subresource == "accounting" {
+		h.invoiceAccountingHandler(w, r, id)
+		return
+	}
+	if subresource == "fatturapa" {
+		h.invoiceFatturaPAHandler(w, r, id)
+		return
+	}
+	if subresource == "nav" {
+		h.invoiceNAVHandler(w, r, id)
+		return
+	}
+	if subresource == "verifactu" {
+		h.invoiceVerifactuHandler(w, r, id)
+		return
+	}
+	if subresource == "ksef" {
+		h.invoiceKSeFHandler(w, r, id)
+		return
+	}
+	if subresource == "ksef-xml" {
+		h.invoiceKSeFXMLHandler(w, r, id)
+		return
+	}
+	if subresource == "tags" {
+		h.invoiceTagsHandler(w, r, id)
+		return
+	}
+	if subresource == "notes" {
+		if _, _, err := h.invoices.GetInvoice(id); err != nil {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", id), nil)
+			return
+		}
+		h.notesHandler(w, r, models.NoteEntityInvoice, id)
+		return
+	}
+	if subresource == "timeline" {
+		h.invoiceTimelineHandler(w, r, id)
+		return
+	}
+	if subresource != "" {
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, "Not found", nil)
 		return
 	}
 
-	if err := json.Unmarshal(rawData["client"], &previewData.Client); err != nil {
-		h.logger.Error("Failed to decode client data: %v", err)
-		http.Error(w, "Invalid client data", http.StatusBadRequest)
+	// Handle DELETE requests for deleting invoices
+	if r.Method == http.MethodDelete {
+		h.logger.Info("Deleting invoice with ID: %d", id)
+
+		// With gapless numbering, finalized invoices are kept
+		if invoice, _, err := h.invoices.GetInvoice(id); err == nil {
+			business, err := h.businesses.GetBusiness(invoice.BusinessID)
+			if err == nil {
+				err = h.complianceService.CheckDelete(invoice, business)
+			}
+			if err != nil {
+				h.writeComplianceError(w, http.StatusConflict, err)
+				return
+			}
+		}
+
+		if err := h.invoices.DeleteInvoice(id); err != nil {
+			if errors.Is(err, services.ErrYearClosed) {
+				h.writeError(w, http.StatusConflict, errCodeYearClosed, fmt.Sprintf("Invoices of a closed fiscal year cannot be deleted (%v)", err), nil)
+				return
+			}
+			if errors.Is(err, services.ErrInvoiceCancelled) {
+				h.writeError(w, http.StatusConflict, errCodeInvoiceCancelled, fmt.Sprintf("Cancelled invoices and cancellation invoices cannot be deleted (%v)", err), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to delete invoice", err)
+			return
+		}
+		if err := h.removeInvoicePDFHistory(id); err != nil {
+			h.logger.Warn("Failed to remove earlier PDF versions of invoice %d: %v", id, err)
+		}
+
+		// Return success response
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": fmt.Sprintf("Invoice %d deleted successfully", id),
+		})
 		return
 	}
 
-	if err := json.Unmarshal(rawData["items"], &previewData.Items); err != nil {
-		h.logger.Error("Failed to decode items data: %v", err)
-		http.Error(w, "Invalid items data", http.StatusBadRequest)
+	// Handle PATCH requests for updating invoice status
+	if r.Method == http.MethodPatch {
+		h.logger.Info("Updating invoice status for invoice ID: %d", id)
+
+		// Parse the request body
+		var updateData struct {
+			Status   string `json:"status"`
+			PaidDate string `json:"paid_date"` // YYYY-MM-DD, today if empty
+			Reason   string `json:"reason"`    // Required for disputed and on-hold invoices
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&updateData); err != nil {
+			h.logger.Error("Failed to decode status update request: %v", err)
+			h.writeBodyError(w, "Invalid request body", err)
+			return
+		}
+
+		// Validate the status
+		status := updateData.Status
+		if !slices.Contains(models.InvoiceStatuses, status) {
+			h.logger.Error("Invalid status value: %s", status)
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid status value. Must be one of %s", strings.Join(models.InvoiceStatuses, ", ")), nil)
+			return
+		}
+
+		var paidDate time.Time
+		if updateData.PaidDate != "" {
+			paidDate, err = time.Parse("2006-01-02", updateData.PaidDate)
+			if err != nil {
+				h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid paid date format. Expected YYYY-MM-DD, got: %s", updateData.PaidDate), nil)
+				return
+			}
+		}
+
+		invoice, _, err := h.invoices.GetInvoice(id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", id), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to load invoice", err)
+			return
+		}
+		if err := invoice.ValidateStatusChange(status, updateData.Reason); err != nil {
+			h.logger.Error("Invalid status change of invoice %d: %v", id, err)
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, err.Error(), nil)
+			return
+		}
+
+		// Drafts being finalized must meet the compliance profile of the business
+		if invoice.Status == models.InvoiceStatusDraft {
+			finalized := *invoice
+			finalized.Status = status
+			if err := h.checkCompliance(&finalized); err != nil {
+				h.writeComplianceError(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+		}
+
+		// Update the invoice status in the database
+		if models.IsHeldStatus(status) {
+			err = h.invoices.HoldInvoice(id, status, updateData.Reason)
+		} else {
+			err = h.invoices.UpdateInvoiceStatus(id, status, paidDate)
+		}
+		if err != nil {
+			if errors.Is(err, services.ErrYearClosed) {
+				h.writeError(w, http.StatusConflict, errCodeYearClosed, fmt.Sprintf("Invoices of a closed fiscal year can only be marked paid (%v)", err), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to update invoice status", err)
+			return
+		}
+		h.publishInvoiceStatus(id)
+
+		if status == models.InvoiceStatusPaid && invoice.Status != models.InvoiceStatusPaid {
+			h.notificationService.Notify(services.Notification{
+				Event:   services.EventInvoicePaid,
+				Title:   fmt.Sprintf("Invoice %s was paid", invoice.InvoiceNumber),
+				Message: fmt.Sprintf("%s %s received.", invoice.TotalAmount, invoice.Currency),
+			})
+		}
+
+		// Return success response
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":     id,
+			"status": status,
+		})
 		return
 	}
 
-	// Fill in the invoice fields
-	previewData.Invoice.ID = int(rawInvoice["id"].(float64))
-	previewData.Invoice.InvoiceNumber = rawInvoice["invoice_number"].(string)
-	previewData.Invoice.BusinessID = int(rawInvoice["business_id"].(float64))
-	previewData.Invoice.ClientID = int(rawInvoice["client_id"].(float64))
-	previewData.Invoice.HourlyRate = rawInvoice["hourly_rate"].(float64)
-	previewData.Invoice.HoursWorked = rawInvoice["hours_worked"].(float64)
-	previewData.Invoice.TotalAmount = rawInvoice["total_amount"].(float64)
-	previewData.Invoice.VatRate = rawInvoice["vat_rate"].(float64)
-	previewData.Invoice.VatAmount = rawInvoice["vat_amount"].(float64)
-	previewData.Invoice.ReverseChargeVat = rawInvoice["reverse_charge_vat"].(bool)
-	previewData.Invoice.Currency = rawInvoice["currency"].(string)
-	previewData.Invoice.Notes = rawInvoice["notes"].(string)
-	previewData.Invoice.Status = rawInvoice["status"].(string)
+	// Method not allowed for other HTTP methods
+	h.writeMethodNotAllowed(w)
+}
 
-	// Handle date parsing
-	issueDateStr, ok := rawInvoice["issue_date"].(string)
-	if !ok {
-		h.logger.Error("Issue date is missing or not a string")
-		http.Error(w, "Issue date is required", http.StatusBadRequest)
+// convertProformaRequest is the optional body of POST /api/invoices/{id}/convert
+type convertProformaRequest struct {
+	IssueDate string `json:"issue_date,omitempty"` // YYYY-MM-DD, today if empty
+}
+
+// convertProformaHandler handles POST /api/invoices/{id}/convert, which turns a
+// pro-forma invoice into a draft invoice once the client confirmed the order.
+// The invoice is issued today unless the body sets an issue_date.
+func (h *AppHandler) convertProformaHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodPost {
+		h.writeMethodNotAllowed(w)
 		return
 	}
 
-	// Try parsing with the simple date format first
-	issueDate, err := time.Parse("2006-01-02", issueDateStr)
-	if err != nil {
-		// If that fails, try the full timestamp format
-		issueDate, err = time.Parse(time.RFC3339, issueDateStr)
-		if err != nil {
-			h.logger.Error("Failed to parse issue date: %v", err)
-			http.Error(w, "Invalid issue date format", http.StatusBadRequest)
+	var request convertProformaRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			h.writeBodyError(w, "Invalid request body", err)
 			return
 		}
 	}
-	previewData.Invoice.IssueDate = issueDate
-
-	dueDateStr, ok := rawInvoice["due_date"].(string)
-	if !ok {
-		h.logger.Error("Due date is missing or not a string")
-		http.Error(w, "Due date is required", http.StatusBadRequest)
-		return
-	}
 
-	// Try parsing with the simple date format first
-	dueDate, err := time.Parse("2006-01-02", dueDateStr)
-	if err != nil {
-		// If that fails, try the full timestamp format
-		dueDate, err = time.Parse(time.RFC3339, dueDateStr)
+	now := time.Now()
+	issueDate := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if request.IssueDate != "" {
+		date, err := time.Parse("2006-01-02", request.IssueDate)
 		if err != nil {
-			h.logger.Error("Failed to parse due date: %v", err)
-			http.Error(w, "Invalid due date format", http.StatusBadRequest)
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid issue date format. Expected YYYY-MM-DD, got: %s", request.IssueDate), nil)
 			return
 		}
+		issueDate = date
 	}
-	previewData.Invoice.DueDate = dueDate
 
-	// Ensure the pdfs directory exists
-	pdfsDir := filepath.Join(h.dataDir, "pdfs", "previews")
-	if err := os.MkdirAll(pdfsDir, 0755); err != nil {
-		h.logger.Error("Failed to create pdfs preview directory: %v", err)
-		http.Error(w, "Failed to create preview directory", http.StatusInternalServerError)
+	// The invoice is converted at the rate of its own issue date
+	invoice, err := h.invoices.ConvertProforma(id, issueDate, h.lookupExchangeRate)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", id), nil)
+		return
+	case errors.Is(err, services.ErrNotProforma):
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "Only pro-forma invoices can be converted", nil)
+		return
+	case errors.Is(err, services.ErrAlreadyConverted):
+		h.writeError(w, http.StatusConflict, errCodeAlreadyConverted, "This pro-forma invoice was already converted into an invoice", nil)
+		return
+	case errors.Is(err, services.ErrYearClosed):
+		h.writeError(w, http.StatusConflict, errCodeYearClosed, fmt.Sprintf("Invoices cannot be issued in a closed fiscal year (%v)", err), nil)
+		return
+	case err != nil:
+		h.writeInternalError(w, "Failed to convert pro-forma invoice", err)
 		return
 	}
 
-	// Create a unique preview filename using a timestamp
-	previewID := fmt.Sprintf("preview-%d", time.Now().UnixNano())
-	previewData.Invoice.InvoiceNumber = previewID
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invoice)
+}
 
-	// Generate the PDF
-	pdfPath, err := h.pdfService.GenerateInvoice(&previewData.Invoice, &previewData.Business, &previewData.Client, previewData.Items)
+// cancelInvoiceRequest is the optional body of POST /api/invoices/{id}/cancel
+type cancelInvoiceRequest struct {
+	IssueDate string `json:"issue_date,omitempty"` // YYYY-MM-DD, today if empty
+}
+
+// eInvoicingFormats returns the e-invoicing formats the invoices of a
+// business are issued or reported in. The compliance profile of the business
+// decides which apply; businesses without a profile get every format of their
+// country.
+func (h *AppHandler) eInvoicingFormats(business *models.Business) ([]string, error) {
+	compliance, err := h.complianceService.Profile(business.Country)
 	if err != nil {
-		h.logger.Error("Failed to generate preview PDF: %v", err)
-		http.Error(w, "Failed to generate preview", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
+	allows := func(format string) bool { return compliance == nil || compliance.HasFormat(format) }
 
-	// Extract just the filename from the full path
-	pdfFilename := filepath.Base(pdfPath)
-	pdfURL := fmt.Sprintf("/data/pdfs/%s", pdfFilename)
-	h.logger.Info("Generated preview PDF: %s", pdfURL)
-
-	// Return the PDF URL
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"url": pdfURL,
-	})
+	var formats []string
+	if refdata.NormalizeCountryCode(business.Country) == "IT" && allows(models.ComplianceFatturaPA) {
+		formats = append(formats, models.ComplianceFatturaPA)
+	}
+	if services.IsNAVBusiness(business) && allows(models.ComplianceNAV) {
+		formats = append(formats, models.ComplianceNAV)
+	}
+	if h.verifactuService.Enabled() && services.IsVerifactuBusiness(business) && allows(models.ComplianceVerifactu) {
+		formats = append(formats, models.ComplianceVerifactu)
+	}
+	if services.IsKSeFBusiness(business) && allows(models.ComplianceKSeF) {
+		formats = append(formats, models.ComplianceKSeF)
+	}
+	return formats, nil
 }
 
-// UploadLogoHandler handles logo uploads
-func (h *AppHandler) UploadLogoHandler(w http.ResponseWriter, r *http.Request) {
+// cancelInvoiceHandler handles POST /api/invoices/{id}/cancel, which reverses
+// an issued invoice with a cancellation (storno) invoice, where invoices must
+// not be deleted. The cancellation is issued today unless the body sets an
+// issue_date.
+func (h *AppHandler) cancelInvoiceHandler(w http.ResponseWriter, r *http.Request, id int) {
 	if r.Method != http.MethodPost {
-		h.logger.Warn("Method not allowed for logo upload: %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		h.writeMethodNotAllowed(w)
 		return
 	}
 
-	// Parse the multipart form
-	err := r.ParseMultipartForm(10 << 20) // 10 MB max
+	var request cancelInvoiceRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			h.writeBodyError(w, "Invalid request body", err)
+			return
+		}
+	}
+
+	now := time.Now()
+	issueDate := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if request.IssueDate != "" {
+		date, err := time.Parse("2006-01-02", request.IssueDate)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid issue date format. Expected YYYY-MM-DD, got: %s", request.IssueDate), nil)
+			return
+		}
+		issueDate = date
+	}
+
+	// Invoices issued as FatturaPA or reported to NAV, Verifactu or KSeF stay
+	// valid there, and need the correction document the tax authority requires
+	original, _, err := h.invoices.GetInvoice(id)
 	if err != nil {
-		h.logger.Error("Failed to parse multipart form: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to parse form: %v", err), http.StatusBadRequest)
+		if errors.Is(err, sql.ErrNoRows) {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", id), nil)
+			return
+		}
+		h.writeInternalError(w, "Failed to load invoice", err)
 		return
 	}
-
-	// Get the file from the form
-	file, handler, err := r.FormFile("logo")
+	business, err := h.businesses.GetBusiness(original.BusinessID)
 	if err != nil {
-		h.logger.Error("Failed to get logo file from form: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to get logo file: %v", err), http.StatusBadRequest)
+		h.writeInternalError(w, "Failed to load business", err)
 		return
 	}
-	defer file.Close()
-
-	h.logger.Debug("Received logo upload: %s, size: %d bytes, content type: %s",
-		handler.Filename, handler.Size, handler.Header.Get("Content-Type"))
-
-	// Validate file type
-	contentType := handler.Header.Get("Content-Type")
-	if !strings.HasPrefix(contentType, "image/") {
-		h.logger.Error("Invalid file type: %s", contentType)
-		http.Error(w, "Invalid file type. Only images are allowed.", http.StatusBadRequest)
+	eInvoicing, err := h.eInvoicingFormats(business)
+	if err != nil {
+		h.writeInternalError(w, "Failed to load the compliance profile", err)
+		return
+	}
+	if len(eInvoicing) > 0 {
+		h.writeError(w, http.StatusConflict, errCodeEInvoicingCancel, fmt.Sprintf("Invoices issued as %s cannot be cancelled with a cancellation invoice; correct them as the tax authority requires", strings.Join(eInvoicing, ", ")), nil)
 		return
 	}
 
-	// Create the uploads directory if it doesn't exist
-	uploadsDir := filepath.Join(h.dataDir, "images")
-	h.logger.Debug("Ensuring uploads directory exists: %s", uploadsDir)
-	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
-		h.logger.Error("Failed to create uploads directory: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to create uploads directory: %v", err), http.StatusInternalServerError)
+	// The cancellation is converted at the rate of its own issue date, and
+	// numbered by the invoice.create hook like any new invoice
+	var hookErr error
+	invoice, err := h.invoices.CancelInvoice(id, issueDate, func(storno *models.Invoice, items []models.InvoiceItem) error {
+		h.lookupExchangeRate(storno)
+		hookErr = h.hookService.InvoiceCreate(storno, items)
+		return hookErr
+	})
+	switch {
+	case hookErr != nil:
+		h.writeHookError(w, hookErr)
+		return
+	case errors.Is(err, sql.ErrNoRows):
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", id), nil)
+		return
+	case errors.Is(err, services.ErrNotCancellable):
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "Only issued invoices can be cancelled; drafts and pro-forma invoices can be deleted", nil)
+		return
+	case errors.Is(err, services.ErrAlreadyCancelled):
+		h.writeError(w, http.StatusConflict, errCodeAlreadyCancelled, "This invoice was already cancelled", nil)
+		return
+	case errors.Is(err, services.ErrDuplicateInvoiceNumber):
+		h.writeError(w, http.StatusConflict, errCodeDuplicateNumber, fmt.Sprintf("The number of the cancellation invoice is already in use (%v)", err), nil)
+		return
+	case errors.Is(err, services.ErrScheduledEmailSending):
+		h.writeError(w, http.StatusConflict, errCodeEmailSending, "The scheduled email of the invoice is being sent right now", nil)
+		return
+	case errors.Is(err, services.ErrYearClosed):
+		h.writeError(w, http.StatusConflict, errCodeYearClosed, fmt.Sprintf("Invoices of a closed fiscal year cannot be cancelled, nor cancelled in one (%v)", err), nil)
+		return
+	case err != nil:
+		h.writeInternalError(w, "Failed to cancel invoice", err)
 		return
 	}
+	h.publishInvoiceStatus(id)
 
-	// Create a new file in the uploads directory
-	filename := filepath.Join(uploadsDir, handler.Filename)
-	h.logger.Debug("Creating destination file: %s", filename)
-	dst, err := os.Create(filename)
-	if err != nil {
-		h.logger.Error("Failed to create destination file: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to create destination file: %v", err), http.StatusInternalServerError)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invoice)
+}
+
+const (
+	// pdfViewLinkLifetime is how long PDF links shown in the web interface stay valid
+	pdfViewLinkLifetime = 24 * time.Hour
+	// pdfLinkLifetime is how long shared PDF links stay valid
+	pdfLinkLifetime = 30 * 24 * time.Hour
+)
+
+// InvoicePDFHandler serves the PDF of an invoice at /invoices/pdf/{id}. Access
+// needs a link signed for that invoice, or a signed-in user when
+// authentication is enabled. The PDF is generated if it does not exist yet or
+// the invoice changed since; ?version=N serves an earlier version instead.
+func (h *AppHandler) InvoicePDFHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	defer dst.Close()
 
-	// Copy the uploaded file to the destination file
-	h.logger.Debug("Copying uploaded file to destination")
-	bytesWritten, err := io.Copy(dst, file)
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/invoices/pdf/"))
 	if err != nil {
-		h.logger.Error("Failed to copy uploaded file: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to save uploaded file: %v", err), http.StatusInternalServerError)
+		http.NotFound(w, r)
 		return
 	}
 
-	h.logger.Info("Successfully saved logo to: %s (%d bytes written)", filename, bytesWritten)
-
-	// Verify the file exists and is readable
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		h.logger.Error("File was not saved correctly: %v", err)
-		http.Error(w, "Failed to save logo file", http.StatusInternalServerError)
+	shared := r.URL.Query().Get("shared") == "1"
+	if token := r.URL.Query().Get("token"); token != "" {
+		linkName := invoicePDFLinkName(id)
+		if shared {
+			linkName += "/shared"
+		}
+		if !h.authService.VerifyLink(linkName, token) {
+			h.logger.Warn("Refused invoice PDF link with an invalid or expired token: %d", id)
+			http.Error(w, "This link is invalid or has expired", http.StatusForbidden)
+			return
+		}
+	} else if h.authService.Mode() == services.AuthModeNone || currentUser(r) == nil {
+		// Without authentication, only signed links are accepted so invoice PDFs cannot be guessed
+		http.Error(w, "This link is invalid or has expired", http.StatusForbidden)
 		return
 	}
 
-	// Update the business logo path
-	businesses, err := h.dbService.GetBusinesses()
+	invoice, _, err := h.invoices.GetInvoice(id)
 	if err != nil {
-		h.logger.Error("Failed to get businesses: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to get business details: %v", err), http.StatusInternalServerError)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		h.logger.Error("Failed to load invoice %d for PDF download: %v", id, err)
+		http.Error(w, "Failed to load invoice", http.StatusInternalServerError)
 		return
 	}
 
-	if len(businesses) > 0 {
-		business := businesses[0]
-		// Store only the filename, not the full path
-		business.LogoPath = filepath.Base(handler.Filename)
-		h.logger.Debug("Updating business with logo path: %s", business.LogoPath)
-		if err := h.dbService.SaveBusiness(&business); err != nil {
-			h.logger.Error("Failed to save business with logo: %v", err)
-			http.Error(w, fmt.Sprintf("Failed to update business with logo: %v", err), http.StatusInternalServerError)
+	filename := invoice.PDFFilename()
+	var pdfPath string
+	if raw := r.URL.Query().Get("version"); raw != "" {
+		number, _ := strconv.Atoi(raw)
+		version, err := h.findInvoicePDFVersion(id, number)
+		if err != nil {
+			h.logger.Error("Failed to load PDF versions of invoice %d: %v", id, err)
+			http.Error(w, "Failed to load PDF versions", http.StatusInternalServerError)
 			return
 		}
-		h.logger.Info("Updated business with logo path: %s", business.LogoPath)
-	} else {
-		h.logger.Warn("No business found to update with logo")
+		if version == nil {
+			http.NotFound(w, r)
+			return
+		}
+		pdfPath = filepath.Join(h.dataDir, "pdfs", version.Filename)
+		filename = fmt.Sprintf("%s-v%d.pdf", strings.TrimSuffix(filename, ".pdf"), version.Version)
+		if _, err := os.Stat(pdfPath); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	} else if pdfPath, err = h.currentInvoicePDF(id); err != nil {
+		h.logger.Error("Failed to generate PDF for invoice %d: %v", id, err)
+		http.Error(w, "Failed to generate PDF", http.StatusInternalServerError)
+		return
 	}
 
-	// Return success response
-	w.Header().Set("Content-Type", "application/json")
-	response := map[string]string{
-		"filename": handler.Filename,
-		"path":     filename,
-		"url":      "/data/images/" + filepath.Base(handler.Filename),
-		"message":  "Logo uploaded successfully",
+	// A link shared with the client was opened by someone who is not signed in
+	if shared && currentUser(r) == nil && r.Method == http.MethodGet {
+		h.notificationService.NotifyThrottled(fmt.Sprintf("viewed/%d", id), time.Hour, services.Notification{
+			Event:   services.EventInvoiceViewed,
+			Title:   fmt.Sprintf("Invoice %s was viewed", invoice.InvoiceNumber),
+			Message: fmt.Sprintf("The PDF of invoice %s (%s %s) was opened from a shared link.", invoice.InvoiceNumber, invoice.TotalAmount, invoice.Currency),
+		})
 	}
-	h.logger.Debug("Sending logo upload response: %v", response)
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		h.logger.Error("Failed to encode response: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+	disposition := "inline"
+	if r.URL.Query().Get("download") == "1" {
+		disposition = "attachment"
 	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeFile(w, r, pdfPath)
 }
 
-// InvoiceByIDHandler handles operations on a specific invoice by ID
-func (h *AppHandler) InvoiceByIDHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract the invoice ID from the URL
-	path := r.URL.Path
-	idStr := path[len("/api/invoices/"):]
-	id, err := strconv.Atoi(idStr)
+// findInvoicePDFVersion returns a PDF version of an invoice, or nil if it does not exist
+func (h *AppHandler) findInvoicePDFVersion(invoiceID, number int) (*models.InvoicePDFVersion, error) {
+	versions, err := h.dbService.GetInvoicePDFVersions(invoiceID)
 	if err != nil {
-		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
-		return
+		return nil, err
+	}
+	for _, version := range versions {
+		if version.Version == number {
+			return &version, nil
+		}
 	}
+	return nil, nil
+}
 
-	// Handle DELETE requests for deleting invoices
-	if r.Method == http.MethodDelete {
-		h.logger.Info("Deleting invoice with ID: %d", id)
+// pdfVersionResponse is a generated PDF version of an invoice as shown in its history
+type pdfVersionResponse struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Current   bool      `json:"current"`
+	URL       string    `json:"url"`
+}
 
-		if err := h.dbService.DeleteInvoice(id); err != nil {
-			h.logger.Error("Failed to delete invoice: %v", err)
-			http.Error(w, fmt.Sprintf("Failed to delete invoice: %v", err), http.StatusInternalServerError)
-			return
-		}
+// invoicePDFVersions returns the PDF versions of an invoice, newest first, with signed links
+func (h *AppHandler) invoicePDFVersions(invoiceID int) ([]pdfVersionResponse, error) {
+	versions, err := h.dbService.GetInvoicePDFVersions(invoiceID)
+	if err != nil {
+		return nil, err
+	}
 
-		// Return success response
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": true,
-			"message": fmt.Sprintf("Invoice %d deleted successfully", id),
+	response := make([]pdfVersionResponse, 0, len(versions))
+	for i, version := range versions {
+		pdfURL := h.invoicePDFURL(invoiceID, pdfViewLinkLifetime)
+		if i > 0 {
+			pdfURL += fmt.Sprintf("&version=%d", version.Version)
+		}
+		response = append(response, pdfVersionResponse{
+			Version:   version.Version,
+			CreatedAt: version.CreatedAt,
+			Current:   i == 0,
+			URL:       pdfURL,
 		})
-		return
 	}
+	return response, nil
+}
 
-	// Handle PATCH requests for updating invoice status
-	if r.Method == http.MethodPatch {
-		h.logger.Info("Updating invoice status for invoice ID: %d", id)
+// invoicePDFLinkName is the name that invoice PDF links are signed for
+func invoicePDFLinkName(id int) string {
+	return fmt.Sprintf("invoice/%d", id)
+}
 
-		// Parse the request body
-		var updateData struct {
-			Status string `json:"status"`
-		}
+// invoicePDFURL returns a signed link to the PDF of an invoice
+func (h *AppHandler) invoicePDFURL(id int, lifetime time.Duration) string {
+	token := h.authService.SignLink(invoicePDFLinkName(id), time.Now().Add(lifetime))
+	return fmt.Sprintf("/invoices/pdf/%d?token=%s", id, url.QueryEscape(token))
+}
 
-		if err := json.NewDecoder(r.Body).Decode(&updateData); err != nil {
-			h.logger.Error("Failed to decode status update request: %v", err)
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
+// sharedInvoicePDFURL returns a link to the PDF of an invoice for sending to
+// the client. It is signed for a separate name, so opening it can be told
+// apart from the links used in the web interface.
+func (h *AppHandler) sharedInvoicePDFURL(id int) string {
+	token := h.authService.SignLink(invoicePDFLinkName(id)+"/shared", time.Now().Add(pdfLinkLifetime))
+	return fmt.Sprintf("/invoices/pdf/%d?shared=1&token=%s", id, url.QueryEscape(token))
+}
 
-		// Validate the status
-		status := updateData.Status
-		if status != "draft" && status != "sent" && status != "paid" {
-			h.logger.Error("Invalid status value: %s", status)
-			http.Error(w, "Invalid status value. Must be 'draft', 'sent', or 'paid'", http.StatusBadRequest)
+// PDFFileHandler serves generated PDFs to authenticated users, and to anyone
+// holding a valid signed link. Without authentication a signed link is required.
+func (h *AppHandler) PDFFileHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/data/pdfs/")
+	if token := r.URL.Query().Get("token"); token != "" {
+		if !h.authService.VerifyLink(name, token) {
+			h.logger.Warn("Refused PDF link with an invalid or expired token: %s", name)
+			http.Error(w, "This link is invalid or has expired", http.StatusForbidden)
 			return
 		}
+	} else if h.authService.Mode() == services.AuthModeNone {
+		http.Error(w, "This link is invalid or has expired", http.StatusForbidden)
+		return
+	} else if currentUser(r) == nil {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
 
-		// Update the invoice status in the database
-		if err := h.dbService.UpdateInvoiceStatus(id, status); err != nil {
-			h.logger.Error("Failed to update invoice status: %v", err)
-			http.Error(w, "Failed to update invoice status", http.StatusInternalServerError)
-			return
-		}
+	h.serveDataFile(w, r, "pdfs", name, []string{".pdf"})
+}
 
-		// Return success response
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"id":     id,
-			"status": status,
-		})
+// PreviewFileHandler serves preview PDFs, which are only reachable through the
+// signed link returned when the preview was generated
+func (h *AppHandler) PreviewFileHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/data/previews/")
+	if !h.authService.VerifyLink(previewLinkName(name), r.URL.Query().Get("token")) {
+		h.logger.Warn("Refused preview link with an invalid or expired token: %s", name)
+		http.Error(w, "This link is invalid or has expired", http.StatusForbidden)
 		return
 	}
 
-	// Method not allowed for other HTTP methods
-	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	h.serveDataFile(w, r, services.PreviewsDir, name, []string{".pdf"})
+}
+
+// previewLinkName is the name that preview links are signed for
+func previewLinkName(filename string) string {
+	return "preview/" + filename
+}
+
+// previewURL returns a signed link to a preview PDF
+func (h *AppHandler) previewURL(filename string) string {
+	token := h.authService.SignLink(previewLinkName(filename), time.Now().Add(pdfViewLinkLifetime))
+	return "/data/previews/" + url.PathEscape(filename) + "?token=" + url.QueryEscape(token)
+}
+
+// ImageFileHandler serves uploaded logos
+func (h *AppHandler) ImageFileHandler(w http.ResponseWriter, r *http.Request) {
+	h.serveDataFile(w, r, "images", strings.TrimPrefix(r.URL.Path, "/data/images/"), logoExtensions)
+}
+
+// serveDataFile serves a file from a folder of the data directory. Only plain
+// filenames with one of the given extensions are served, so requests can never
+// reach other files in the data directory, such as the database.
+func (h *AppHandler) serveDataFile(w http.ResponseWriter, r *http.Request, dir, name string, extensions []string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") ||
+		!slices.Contains(extensions, strings.ToLower(filepath.Ext(name))) {
+		h.logger.Warn("Refused to serve data file: %s", r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+
+	path := filepath.Join(h.dataDir, dir, name)
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeFile(w, r, path)
+}
+
+// removeInvoicePDFs deletes the generated PDF files of the given invoices and
+// returns how many were removed
+func (h *AppHandler) removeInvoicePDFs(invoiceNumbers []string) int {
+	removed := 0
+	for _, number := range invoiceNumbers {
+		pdfPath := filepath.Join(h.dataDir, "pdfs", models.InvoicePDFFilename(number))
+		if err := os.Remove(pdfPath); err != nil {
+			if !os.IsNotExist(err) {
+				h.logger.Warn("Failed to remove PDF %s: %v", pdfPath, err)
+			}
+			continue
+		}
+		removed++
+	}
+	return removed
 }
 
 // renderTemplate renders a template with the given data
 func (h *AppHandler) renderTemplate(w http.ResponseWriter, tmpl string, data map[string]interface{}) {
 	// Get the template
+	h.templatesMu.RLock()
 	t, ok := h.templates[tmpl]
+	h.templatesMu.RUnlock()
 	if !ok {
 		h.logger.Error("Template not found: %s", tmpl)
-		http.Error(w, fmt.Sprintf("Template not found: %s", tmpl), http.StatusInternalServerError)
+		http.Error(w, "Failed to render page", http.StatusInternalServerError)
 		return
 	}
 
@@ -1322,23 +3350,93 @@ func (h *AppHandler) renderTemplate(w http.ResponseWriter, tmpl string, data map
 		data["Version"] = h.version
 	}
 
+	// Problems that need the administrator are shown on every page
+	data["Warnings"] = h.adminWarnings()
+
 	// Render the template
 	if err := t.ExecuteTemplate(w, "layout", data); err != nil {
 		h.logger.Error("Failed to render template: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to render template: %v", err), http.StatusInternalServerError)
+		http.Error(w, "Failed to render page", http.StatusInternalServerError)
 		return
 	}
 }
 
+// adminWarnings returns the problems with the data shown at the top of every
+// page until they are fixed
+func (h *AppHandler) adminWarnings() []string {
+	var warnings []string
+	if n := h.dbService.DuplicateInvoiceNumbers(); n > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d invoice numbers are used by more than one invoice, so the database cannot enforce unique invoice numbers. "+
+			"Renumber the duplicates listed by the numbering check on the Reports page and restart the server.", n))
+	}
+	return warnings
+}
+
 // Cleanup performs cleanup tasks before application shutdown
 func (h *AppHandler) Cleanup() error {
 	h.logger.Info("Performing cleanup tasks")
 
+	// Stop watching the templates
+	h.StopTemplateWatch()
+
+	// End the event streams of open tabs
+	h.CloseEventStreams()
+
 	// Stop the backup scheduler
 	if h.backupService != nil {
 		h.backupService.StopScheduler()
 	}
 
+	// Stop generating contract invoices, which queues their PDFs
+	if h.contractService != nil {
+		h.contractService.Stop()
+	}
+
+	// Stop the job worker before the database goes away
+	if h.jobService != nil {
+		h.jobService.Stop()
+	}
+
+	// Stop the preview cleanup
+	if h.pdfService != nil {
+		h.pdfService.StopPreviewCleanup()
+	}
+
+	// Stop checking for bounced emails
+	if h.bounceService != nil {
+		h.bounceService.Stop()
+	}
+
+	// Stop syncing bank payments
+	if h.bankSyncService != nil {
+		h.bankSyncService.Stop()
+	}
+
+	// Stop pushing invoices to the accounting software
+	if h.accountingSyncService != nil {
+		h.accountingSyncService.Stop()
+	}
+
+	// Stop reporting invoices to NAV
+	if h.navService != nil {
+		h.navService.Stop()
+	}
+
+	// Stop registering invoices with Verifactu
+	if h.verifactuService != nil {
+		h.verifactuService.Stop()
+	}
+
+	// Stop sending invoices to KSeF
+	if h.ksefService != nil {
+		h.ksefService.Stop()
+	}
+
+	// Stop checking for overdue invoices
+	if h.notificationService != nil {
+		h.notificationService.StopOverdueCheck()
+	}
+
 	// Close database connection
 	if h.dbService != nil {
 		if err := h.dbService.Close(); err != nil {
@@ -1,12 +1,38 @@
 package handlers
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/0dragosh/simple-invoice/internal/models"
+	pb "github.com/0dragosh/simple-invoice/internal/pb/simpleinvoicev1"
 	"github.com/0dragosh/simple-invoice/internal/services"
+	"github.com/0dragosh/simple-invoice/internal/services/mocks"
+	"github.com/graphql-go/graphql/language/parser"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 func setupTestHandler(t *testing.T) (*AppHandler, string, func()) {
@@ -38,13 +64,92 @@ func setupTestHandler(t *testing.T) (*AppHandler, string, func()) {
 	return handler, tempDir, cleanup
 }
 
-// Skip the handler tests for now as they require more setup
-func TestCreateInvoiceHandlerSkip(t *testing.T) {
-	t.Skip("Skipping TestCreateInvoiceHandler as it requires more setup")
+func TestCreateInvoiceHandler(t *testing.T) {
+	t.Chdir("../..")
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	ctrl := gomock.NewController(t)
+	businesses := mocks.NewMockBusinessRepo(ctrl)
+	clients := mocks.NewMockClientRepo(ctrl)
+	handler.businesses = businesses
+	handler.clients = clients
+
+	clients.EXPECT().GetClients().Return([]models.Client{{ID: 7, Name: "Acme GmbH", VatID: "DE123456789"}}, nil)
+	businesses.EXPECT().GetBusinesses().Return([]models.Business{{ID: 1, Name: "Example Consulting"}}, nil)
+	rec := httptest.NewRecorder()
+	handler.CreateInvoiceHandler(rec, httptest.NewRequest(http.MethodGet, "/invoices/create", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	for _, want := range []string{`<option value="7"`, "Acme GmbH (DE123456789)", "Example Consulting"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("Expected the page to contain %q", want)
+		}
+	}
+
+	// A failing repository is an internal error, not an empty form
+	clients.EXPECT().GetClients().Return(nil, nil)
+	businesses.EXPECT().GetBusinesses().Return(nil, errors.New("database is closed"))
+	rec = httptest.NewRecorder()
+	handler.CreateInvoiceHandler(rec, httptest.NewRequest(http.MethodGet, "/invoices/create", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", rec.Code)
+	}
 }
 
-func TestInvoicesHandlerSkip(t *testing.T) {
-	t.Skip("Skipping TestInvoicesHandler as it requires more setup")
+func TestInvoicesHandler(t *testing.T) {
+	t.Chdir("../..")
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	ctrl := gomock.NewController(t)
+	clients := mocks.NewMockClientRepo(ctrl)
+	invoices := mocks.NewMockInvoiceRepo(ctrl)
+	handler.clients = clients
+	handler.invoices = invoices
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoices.EXPECT().GetInvoices().Return([]models.Invoice{
+		{ID: 1, InvoiceNumber: "INV-2024-0001", ClientID: 7, IssueDate: issueDate, DueDate: issueDate, Currency: "EUR", Status: "sent"},
+		{ID: 2, InvoiceNumber: "INV-2024-0002", ClientID: 8, IssueDate: issueDate, DueDate: issueDate, Currency: "EUR", Status: "draft"},
+	}, nil)
+	clients.EXPECT().GetClients().Return([]models.Client{{ID: 7, Name: "Acme GmbH"}}, nil)
+	clients.EXPECT().GetClient(7).Return(&models.Client{ID: 7, Name: "Acme GmbH", Deleted: true}, nil)
+	clients.EXPECT().GetClient(8).Return(nil, errors.New("client not found"))
+	rec := httptest.NewRecorder()
+	handler.InvoicesHandler(rec, httptest.NewRequest(http.MethodGet, "/invoices", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	for _, want := range []string{"INV-2024-0001", "INV-2024-0002", "Acme GmbH", "This client is in the trash", "Unknown Client"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("Expected the page to contain %q", want)
+		}
+	}
+
+	// The filter bar narrows the list down by tag
+	invoices.EXPECT().GetInvoices().Return([]models.Invoice{
+		{ID: 1, InvoiceNumber: "INV-2024-0001", ClientID: 7, IssueDate: issueDate, DueDate: issueDate, Currency: "EUR", Status: "sent", Tags: []string{"Retainer"}},
+		{ID: 2, InvoiceNumber: "INV-2024-0002", ClientID: 7, IssueDate: issueDate, DueDate: issueDate, Currency: "EUR", Status: "sent", Tags: []string{"travel"}},
+	}, nil)
+	clients.EXPECT().GetClients().Return([]models.Client{{ID: 7, Name: "Acme GmbH"}}, nil)
+	clients.EXPECT().GetClient(7).Return(&models.Client{ID: 7, Name: "Acme GmbH"}, nil)
+	rec = httptest.NewRecorder()
+	handler.InvoicesHandler(rec, httptest.NewRequest(http.MethodGet, "/invoices?tag=retainer", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "INV-2024-0001") || strings.Contains(rec.Body.String(), "INV-2024-0002") {
+		t.Errorf("Expected only the invoice tagged retainer")
+	}
+
+	invoices.EXPECT().GetInvoices().Return(nil, errors.New("database is closed"))
+	rec = httptest.NewRecorder()
+	handler.InvoicesHandler(rec, httptest.NewRequest(http.MethodGet, "/invoices", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", rec.Code)
+	}
 }
 
 func TestCalculateWorkHours(t *testing.T) {
@@ -65,3 +170,1600 @@ func TestCalculateWorkHours(t *testing.T) {
 		t.Errorf("Work hours should be a multiple of 8: %f", workHours)
 	}
 }
+
+func TestOpenAPIDocumentCoversRegisteredRoutes(t *testing.T) {
+	endpoints := (&AppHandler{}).apiEndpoints()
+
+	// Every documented path must be served by the endpoint that documents it
+	mux := http.NewServeMux()
+	for _, endpoint := range endpoints {
+		mux.HandleFunc(endpoint.Pattern, func(http.ResponseWriter, *http.Request) {})
+	}
+	operationIDs := map[string]bool{}
+	for _, endpoint := range endpoints {
+		for _, op := range endpoint.Operations {
+			path := strings.ReplaceAll(op.Path, "{id}", "1")
+			if _, pattern := mux.Handler(httptest.NewRequest(op.Method, path, nil)); pattern != endpoint.Pattern {
+				t.Errorf("%s %s is routed to %q, documented under %q", op.Method, op.Path, pattern, endpoint.Pattern)
+			}
+			id := operationID(op)
+			if operationIDs[id] {
+				t.Errorf("Duplicate operation ID %s", id)
+			}
+			operationIDs[id] = true
+		}
+	}
+
+	data, err := json.Marshal(buildOpenAPI(endpoints, "test"))
+	if err != nil {
+		t.Fatalf("Failed to encode OpenAPI document: %v", err)
+	}
+	var doc struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]interface{} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Failed to decode OpenAPI document: %v", err)
+	}
+
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("Unexpected OpenAPI version %q", doc.OpenAPI)
+	}
+	if _, ok := doc.Paths["/api/invoices"]["post"]; !ok {
+		t.Error("Expected POST /api/invoices to be documented")
+	}
+	if _, ok := doc.Paths["/api/clients/{id}"]["delete"]; !ok {
+		t.Error("Expected DELETE /api/clients/{id} to be documented")
+	}
+
+	invoice := doc.Components.Schemas["Invoice"].Properties
+	if invoice["total_amount"]["type"] != "number" || invoice["issue_date"]["format"] != "date-time" {
+		t.Errorf("Unexpected Invoice schema: %v", invoice)
+	}
+	if _, ok := doc.Components.Schemas["InvoiceItem"].Properties["unit"]; !ok {
+		t.Error("Expected the InvoiceItem schema to include unit")
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	h := &AppHandler{logger: services.NewLogger(services.FATAL)}
+
+	rec := httptest.NewRecorder()
+	h.writeInternalError(rec, "Failed to save client", errors.New("database is locked: SELECT * FROM clients"))
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Unexpected response %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if strings.Contains(rec.Body.String(), "database") {
+		t.Errorf("Internal error details leaked to the client: %s", rec.Body.String())
+	}
+	var body apiError
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != errCodeInternal || body.Message != "Failed to save client" {
+		t.Errorf("Unexpected error body %+v, %v", body, err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.writeConflict(rec, "Client was changed in another window", map[string]int{"version": 3})
+	var conflict struct {
+		Code    string `json:"code"`
+		Details struct {
+			Current map[string]int `json:"current"`
+		} `json:"details"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &conflict); err != nil || rec.Code != http.StatusConflict ||
+		conflict.Code != errCodeVersionConflict || conflict.Details.Current["version"] != 3 {
+		t.Errorf("Unexpected conflict response %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestLimitRequestBody(t *testing.T) {
+	h := &AppHandler{logger: services.NewLogger(services.FATAL)}
+	handler := h.LimitRequestBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			h.writeBodyError(w, "Invalid request body", err)
+		}
+	}))
+
+	// Declared length over the limit
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/settings", strings.NewReader(`{}`))
+	req.ContentLength = maxRequestBodySize + 1
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a large declared body, got %d", rec.Code)
+	}
+
+	// Body without a declared length that turns out to be too large
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/settings", strings.NewReader(`{"notes": "`+strings.Repeat("x", maxRequestBodySize)+`"}`))
+	req.ContentLength = -1
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), errCodeTooLarge) {
+		t.Errorf("Expected 413 for a large streamed body, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/settings", strings.NewReader(`{"notes": "ok"}`)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected a small body to be accepted, got %d", rec.Code)
+	}
+}
+
+func TestCheckUploadedFile(t *testing.T) {
+	pngHeader := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	tests := []struct {
+		filename string
+		content  []byte
+		ok       bool
+	}{
+		{"logo.png", pngHeader, true},
+		{"logo.PNG", pngHeader, true},
+		{"logo.svg", pngHeader, false},
+		{"logo.png", []byte("<html><script>alert(1)</script></html>"), false},
+		{"clients.csv", []byte("name,vat_id\nAcme,DE123\n"), true},
+	}
+
+	for _, tt := range tests {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, _ := form.CreateFormFile("file", tt.filename)
+		part.Write(tt.content)
+		form.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		file, header, err := req.FormFile("file")
+		if err != nil {
+			t.Fatalf("Failed to read form file: %v", err)
+		}
+
+		extensions, contentTypes := logoExtensions, logoContentTypes
+		if strings.HasSuffix(tt.filename, ".csv") {
+			extensions, contentTypes = csvExtensions, csvContentTypes
+		}
+		err = checkUploadedFile(file, header, extensions, contentTypes)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkUploadedFile(%q) = %v, want ok %t", tt.filename, err, tt.ok)
+		}
+		if rest, _ := io.ReadAll(file); tt.ok && !bytes.Equal(rest, tt.content) {
+			t.Errorf("Expected %q to be rewound after the check", tt.filename)
+		}
+	}
+}
+
+func TestDataFilesRequireAuthOrSharedLink(t *testing.T) {
+	dataDir := t.TempDir()
+	os.MkdirAll(filepath.Join(dataDir, "pdfs"), 0755)
+	os.MkdirAll(filepath.Join(dataDir, "backups"), 0755)
+	os.WriteFile(filepath.Join(dataDir, "pdfs", "invoice-1.pdf"), []byte("%PDF-1.4"), 0644)
+	os.WriteFile(filepath.Join(dataDir, "pdfs", "invoice-2.pdf"), []byte("%PDF-1.4"), 0644)
+	os.WriteFile(filepath.Join(dataDir, "backups", "backup.pdf"), []byte("secret"), 0644)
+
+	logger := services.NewLogger(services.FATAL)
+	dbService, err := services.NewDBService(dataDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to create DB service: %v", err)
+	}
+	defer dbService.Close()
+	t.Setenv("AUTH_MODE", "proxy")
+	authService, err := services.NewAuthService(dbService, logger)
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+
+	h := &AppHandler{dataDir: dataDir, logger: logger, authService: authService}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/data/pdfs/", h.PDFFileHandler)
+	mux.HandleFunc("/data/images/", h.ImageFileHandler)
+	server := h.RequireAuth(mux)
+
+	get := func(path string, signedIn bool) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if signedIn {
+			req.RemoteAddr = "127.0.0.1:4321"
+			req.Header.Set("Remote-User", "jane")
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	valid := authService.SignLink("invoice-1.pdf", time.Now().Add(time.Hour))
+	expired := authService.SignLink("invoice-1.pdf", time.Now().Add(-time.Minute))
+	for _, tt := range []struct {
+		path     string
+		signedIn bool
+		want     int
+	}{
+		{"/data/pdfs/invoice-1.pdf", true, http.StatusOK},
+		{"/data/pdfs/invoice-1.pdf", false, http.StatusUnauthorized},
+		{"/data/pdfs/invoice-1.pdf?token=" + valid, false, http.StatusOK},
+		{"/data/pdfs/invoice-2.pdf?token=" + valid, false, http.StatusForbidden},
+		{"/data/pdfs/invoice-1.pdf?token=" + expired, false, http.StatusForbidden},
+		{"/data/pdfs/invoice-1.pdf?token=garbage", false, http.StatusForbidden},
+		{"/data/database.db", true, http.StatusNotFound},
+		{"/data/backups/backup.pdf", true, http.StatusNotFound},
+		{"/data/pdfs/%2e%2e%2fdatabase.db", true, http.StatusNotFound},
+		{"/data/images/..%2fdatabase.db", true, http.StatusNotFound},
+		{"/data/pdfs/missing.pdf", true, http.StatusNotFound},
+	} {
+		if got := get(tt.path, tt.signedIn); got != tt.want {
+			t.Errorf("GET %s (signed in: %t) = %d, want %d", tt.path, tt.signedIn, got, tt.want)
+		}
+	}
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	logger := services.NewLogger(services.FATAL)
+	dbService, err := services.NewDBService(t.TempDir(), logger)
+	if err != nil {
+		t.Fatalf("Failed to create DB service: %v", err)
+	}
+	defer dbService.Close()
+	t.Setenv("AUTH_MODE", "proxy")
+	authService, err := services.NewAuthService(dbService, logger)
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+	user, err := authService.ProvisionUser(services.AuthModeProxy, "jane", "jane", "", "")
+	if err != nil {
+		t.Fatalf("Failed to provision user: %v", err)
+	}
+	_, reader, err := authService.CreateAPIToken(user.ID, "Reports", []string{models.TokenScopeRead})
+	if err != nil {
+		t.Fatalf("Failed to create API token: %v", err)
+	}
+	_, writer, err := authService.CreateAPIToken(user.ID, "Sync", []string{models.TokenScopeInvoicesWrite})
+	if err != nil {
+		t.Fatalf("Failed to create API token: %v", err)
+	}
+
+	h := &AppHandler{logger: logger, authService: authService}
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		if currentUser(r) == nil || currentUser(r).ID != user.ID {
+			t.Errorf("Expected %s %s to run as jane, got %+v", r.Method, r.URL.Path, currentUser(r))
+		}
+	}
+	mux := http.NewServeMux()
+	for _, pattern := range []string{"/api/invoices", "/api/backups", "/api/settings", "/api/tokens", "/api/logs", "/debug/vars", "/graphql"} {
+		mux.HandleFunc(pattern, ok)
+	}
+	server := h.RequireAuth(mux)
+
+	for _, tt := range []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodGet, "/api/invoices", reader, http.StatusOK},
+		{http.MethodPost, "/graphql", reader, http.StatusOK},
+		{http.MethodPost, "/api/invoices", reader, http.StatusForbidden},
+		{http.MethodPost, "/api/invoices", writer, http.StatusOK},
+		{http.MethodGet, "/api/backups", writer, http.StatusForbidden},
+		{http.MethodGet, "/api/settings", reader, http.StatusOK},
+		{http.MethodPost, "/api/settings", writer, http.StatusForbidden},
+		{http.MethodGet, "/api/tokens", writer, http.StatusForbidden},
+		{http.MethodGet, "/api/logs", reader, http.StatusForbidden},
+		{http.MethodGet, "/debug/vars", reader, http.StatusForbidden},
+		{http.MethodGet, "/api/invoices", "si_unknown", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, rec.Code, tt.want, rec.Body.String())
+		}
+	}
+}
+
+func TestLogLevelHandler(t *testing.T) {
+	t.Chdir("../..")
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+	handler.logger.SetOutput(io.Discard)
+
+	put := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.LogLevelHandler(rec, httptest.NewRequest(http.MethodPut, "/api/logs/level", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := put(`{"level": "verbose"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown level to be rejected, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := put(`{"level": "warn"}`); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"level":"WARN"`) {
+		t.Fatalf("Expected the level to be changed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if level := handler.logger.Level(); level != services.WARN {
+		t.Errorf("Expected the logger to be at WARN, got %s", level)
+	}
+	if level, saved := handler.settingsService.LogLevel(); level != services.WARN || !saved {
+		t.Errorf("Expected WARN to be saved, got %s (saved %v)", level, saved)
+	}
+
+	// Saving the settings page applies the level as well
+	rec := httptest.NewRecorder()
+	handler.SettingsAPIHandler(rec, httptest.NewRequest(http.MethodPost, "/api/settings", strings.NewReader(`{"server.log_level": "DEBUG"}`)))
+	if rec.Code != http.StatusOK || handler.logger.Level() != services.DEBUG {
+		t.Errorf("Expected the settings page to change the level to DEBUG, got %s (%d: %s)", handler.logger.Level(), rec.Code, rec.Body.String())
+	}
+}
+
+func TestLogRequests(t *testing.T) {
+	var output bytes.Buffer
+	logger := services.NewLogger(services.INFO)
+	logger.SetOutput(&output)
+	dbService, err := services.NewDBService(t.TempDir(), logger)
+	if err != nil {
+		t.Fatalf("Failed to create DB service: %v", err)
+	}
+	defer dbService.Close()
+	t.Setenv("AUTH_MODE", "proxy")
+	authService, err := services.NewAuthService(dbService, logger)
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+
+	h := &AppHandler{logger: logger, authService: authService}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/invoices", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})
+	server := h.LogRequests(h.RequireAuth(mux))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/invoices?token=s3cr3t&year=2024", nil)
+	req.RemoteAddr = "127.0.0.1:51234"
+	req.Header.Set("Remote-User", "jane")
+	req.Header.Set("X-Request-ID", "proxy-42")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("X-Request-ID"); got != "proxy-42" {
+		t.Errorf("Expected the request ID of the proxy to be sent back, got %q", got)
+	}
+	log := output.String()
+	want := `access request_id=proxy-42 method=POST path="/api/invoices?token=REDACTED&year=2024" status=201 bytes=7`
+	if !strings.Contains(log, want) || !strings.Contains(log, `remote=127.0.0.1 user="jane"`) {
+		t.Errorf("Expected an access log line with %s, got:\n%s", want, log)
+	}
+	if strings.Contains(log, "s3cr3t") {
+		t.Errorf("Expected the token to be redacted, got:\n%s", log)
+	}
+
+	// Request IDs that are not safe to log are replaced
+	req = httptest.NewRequest(http.MethodGet, "/static/app.js", nil)
+	req.Header.Set("X-Request-ID", "bad id\ninjected")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-ID"); len(got) != 16 {
+		t.Errorf("Expected a generated request ID, got %q", got)
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	h := &AppHandler{logger: services.NewLogger(services.FATAL)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/invoices", func(w http.ResponseWriter, r *http.Request) {
+		var invoice *models.Invoice
+		_ = invoice.InvoiceNumber // nil pointer dereference
+	})
+	mux.HandleFunc("/invoices", func(w http.ResponseWriter, r *http.Request) {
+		panic("template missing")
+	})
+	mux.HandleFunc("/api/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: "))
+		panic("stream broken")
+	})
+	server := h.RecoverPanics(mux)
+	before := services.PanicsRecovered()
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/invoices", nil))
+	var body apiError
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusInternalServerError || body.Code != errCodeInternal {
+		t.Errorf("Expected a 500 error envelope, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/invoices", nil))
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "Internal server error") {
+		t.Errorf("Expected a 500 page, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// A response already started is aborted, so the client sees it is incomplete
+	func() {
+		defer func() {
+			if r := recover(); r != http.ErrAbortHandler {
+				t.Errorf("Expected the response to be aborted, got %v", r)
+			}
+		}()
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/events", nil))
+	}()
+
+	if got := services.PanicsRecovered() - before; got != 3 {
+		t.Errorf("Expected 3 recovered panics to be counted, got %d", got)
+	}
+}
+
+func TestInvoicePDFRequiresSignedLink(t *testing.T) {
+	dataDir := t.TempDir()
+	logger := services.NewLogger(services.FATAL)
+	dbService, err := services.NewDBService(dataDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to create DB service: %v", err)
+	}
+	defer dbService.Close()
+	authService, err := services.NewAuthService(dbService, logger)
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+
+	business := &models.Business{Name: "Test Business", Country: "Germany"}
+	if err := dbService.SaveBusiness(business); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	client := &models.Client{Name: "Test Client", Country: "Germany"}
+	if err := dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{
+		InvoiceNumber: "INV/2024 0001",
+		BusinessID:    business.ID,
+		ClientID:      client.ID,
+		IssueDate:     issueDate,
+		DueDate:       issueDate.AddDate(0, 0, 30),
+		Currency:      "EUR",
+		Status:        "draft",
+	}
+	if err := dbService.SaveInvoice(invoice, nil); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+
+	h := &AppHandler{dataDir: dataDir, logger: logger, dbService: dbService, businesses: dbService, clients: dbService, invoices: dbService, authService: authService, pdfService: services.NewPDFService(dataDir),
+		complianceService: services.NewComplianceService(dbService, logger)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/invoices/pdf/", h.InvoicePDFHandler)
+	mux.HandleFunc("/data/pdfs/", h.PDFFileHandler)
+	server := h.RequireAuth(mux)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	link := h.invoicePDFURL(invoice.ID, time.Hour)
+	rec := get(link)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d, want %d", link, rec.Code, http.StatusOK)
+	}
+	if got, want := rec.Header().Get("Content-Disposition"), "inline; filename="+invoice.PDFFilename(); got != want {
+		t.Errorf("Content-Disposition = %q, want %q", got, want)
+	}
+	if got := get(link + "&download=1").Header().Get("Content-Disposition"); !strings.HasPrefix(got, "attachment;") {
+		t.Errorf("Content-Disposition with download=1 = %q, want an attachment", got)
+	}
+
+	otherInvoice := authService.SignLink(invoicePDFLinkName(invoice.ID+1), time.Now().Add(time.Hour))
+	expired := authService.SignLink(invoicePDFLinkName(invoice.ID), time.Now().Add(-time.Minute))
+	for _, tt := range []struct {
+		path string
+		want int
+	}{
+		{fmt.Sprintf("/invoices/pdf/%d", invoice.ID), http.StatusForbidden},
+		{fmt.Sprintf("/invoices/pdf/%d?token=%s", invoice.ID, otherInvoice), http.StatusForbidden},
+		{fmt.Sprintf("/invoices/pdf/%d?token=%s", invoice.ID, expired), http.StatusForbidden},
+		{fmt.Sprintf("/invoices/pdf/%d?token=%s", invoice.ID+1, otherInvoice), http.StatusNotFound},
+		{"/data/pdfs/" + invoice.PDFFilename(), http.StatusForbidden},
+	} {
+		if got := get(tt.path).Code; got != tt.want {
+			t.Errorf("GET %s = %d, want %d", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPreviewPDFStream(t *testing.T) {
+	dataDir := t.TempDir()
+	h := &AppHandler{dataDir: dataDir, logger: services.NewLogger(services.FATAL), pdfService: services.NewPDFService(dataDir)}
+
+	body := `{
+		"invoice": {"id": 0, "invoice_number": "", "business_id": 1, "client_id": 1, "hours_worked": 0, "vat_rate": 0,
+			"reverse_charge_vat": false, "currency": "EUR", "notes": "", "status": "draft",
+			"issue_date": "2024-03-01", "due_date": "2024-03-31"},
+		"items": [{"description": "Work", "quantity": 1, "unit_price": 100}],
+		"business": {"name": "Test Business"},
+		"client": {"name": "Test Client"}
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/api/invoices/preview-pdf?stream=true", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.PreviewPDFHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/pdf" {
+		t.Errorf("Content-Type = %q, want application/pdf", got)
+	}
+	if !bytes.HasPrefix(rec.Body.Bytes(), []byte("%PDF-")) {
+		t.Error("Response body is not a PDF")
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dataDir, services.PreviewsDir)); len(entries) != 0 {
+		t.Errorf("Streamed preview saved %d files, want none", len(entries))
+	}
+}
+
+func TestInvoicePDFVersions(t *testing.T) {
+	dataDir := t.TempDir()
+	logger := services.NewLogger(services.FATAL)
+	dbService, err := services.NewDBService(dataDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to create DB service: %v", err)
+	}
+	defer dbService.Close()
+	authService, err := services.NewAuthService(dbService, logger)
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+	h := &AppHandler{dataDir: dataDir, logger: logger, dbService: dbService, businesses: dbService, clients: dbService, invoices: dbService, authService: authService, pdfService: services.NewPDFService(dataDir),
+		complianceService: services.NewComplianceService(dbService, logger)}
+
+	business := &models.Business{Name: "Test Business", Country: "Germany"}
+	if err := dbService.SaveBusiness(business); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	client := &models.Client{Name: "Test Client", Country: "Germany"}
+	if err := dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{
+		InvoiceNumber: "INV-2024-0001",
+		BusinessID:    business.ID,
+		ClientID:      client.ID,
+		IssueDate:     issueDate,
+		DueDate:       issueDate.AddDate(0, 0, 30),
+		Currency:      "EUR",
+		Status:        "draft",
+	}
+	if err := dbService.SaveInvoice(invoice, nil); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+
+	versions := func() []models.InvoicePDFVersion {
+		t.Helper()
+		versions, err := dbService.GetInvoicePDFVersions(invoice.ID)
+		if err != nil {
+			t.Fatalf("Failed to get PDF versions: %v", err)
+		}
+		return versions
+	}
+
+	if _, err := h.generateInvoicePDF(invoice.ID); err != nil {
+		t.Fatalf("Failed to generate PDF: %v", err)
+	}
+
+	// Unchanged data and status changes regenerate the same version
+	if err := dbService.UpdateInvoiceStatus(invoice.ID, "sent", time.Time{}); err != nil {
+		t.Fatalf("Failed to update status: %v", err)
+	}
+	if _, err := h.currentInvoicePDF(invoice.ID); err != nil {
+		t.Fatalf("Failed to get current PDF: %v", err)
+	}
+	if got := versions(); len(got) != 1 || got[0].Filename != invoice.PDFFilename() {
+		t.Fatalf("Versions after an unchanged invoice = %+v, want only version 1", got)
+	}
+
+	// An edited invoice is regenerated on access and the previous PDF is kept
+	invoice.Notes = "Edited"
+	if err := dbService.SaveInvoice(invoice, nil); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+	pdfPath, err := h.currentInvoicePDF(invoice.ID)
+	if err != nil {
+		t.Fatalf("Failed to get current PDF: %v", err)
+	}
+	got := versions()
+	if len(got) != 2 || got[0].Version != 2 || got[1].Version != 1 {
+		t.Fatalf("Versions after an edit = %+v, want versions 2 and 1", got)
+	}
+	if pdfPath != filepath.Join(dataDir, "pdfs", got[0].Filename) {
+		t.Errorf("Current PDF = %s, want version 2 at %s", pdfPath, got[0].Filename)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "pdfs", got[1].Filename)); err != nil || got[1].Filename == got[0].Filename {
+		t.Errorf("Version 1 was not kept separately: %s (%v)", got[1].Filename, err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.InvoicePDFHandler(rec, httptest.NewRequest(http.MethodGet, h.invoicePDFURL(invoice.ID, time.Hour)+"&version=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET version 1 = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got, want := rec.Header().Get("Content-Disposition"), "inline; filename=invoice-INV-2024-0001-v1.pdf"; got != want {
+		t.Errorf("Content-Disposition = %q, want %q", got, want)
+	}
+
+	if err := h.removeInvoicePDFHistory(invoice.ID); err != nil {
+		t.Fatalf("Failed to remove PDF history: %v", err)
+	}
+	if got := versions(); len(got) != 0 {
+		t.Errorf("Versions after removing the history = %+v, want none", got)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "pdfs", invoicePDFHistoryDir(invoice.ID))); !os.IsNotExist(err) {
+		t.Errorf("PDF history directory still exists: %v", err)
+	}
+}
+
+func TestPrintInvoiceHandler(t *testing.T) {
+	t.Chdir("../..")
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	business := &models.Business{Name: "Test Business", Country: "DE"}
+	if err := handler.dbService.SaveBusiness(business); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	client := &models.Client{Name: "Acme & Sons", Country: "DE"}
+	if err := handler.dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{InvoiceNumber: "INV-2024-0007", BusinessID: business.ID, ClientID: client.ID, IssueDate: issueDate,
+		DueDate: issueDate.AddDate(0, 0, 30), TotalAmount: 10000, Currency: "EUR", Status: "draft"}
+	items := []models.InvoiceItem{{Description: "Work", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+	if err := handler.dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.PrintInvoiceHandler(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/invoices/print/%d", invoice.ID), nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("Expected an HTML page, got %d %s: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	body := rec.Body.String()
+	for _, want := range []string{"INV-2024-0007", "Acme &amp; Sons", "100,00 EUR", "window.print()", fmt.Sprintf(`href="/invoices/view/%d"`, invoice.ID)} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the print page to contain %q", want)
+		}
+	}
+	if strings.Contains(body, "navbar") {
+		t.Error("Expected the print page without the navigation")
+	}
+
+	for path, want := range map[string]int{"/invoices/print/9999": http.StatusNotFound, "/invoices/print/abc": http.StatusBadRequest} {
+		rec := httptest.NewRecorder()
+		handler.PrintInvoiceHandler(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("Expected %d for %s, got %d", want, path, rec.Code)
+		}
+	}
+}
+
+func TestViewInvoicePDFViewer(t *testing.T) {
+	t.Chdir("../..")
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	business := &models.Business{Name: "Test Business", Country: "DE"}
+	if err := handler.dbService.SaveBusiness(business); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	client := &models.Client{Name: "Test Client", Country: "DE"}
+	if err := handler.dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{InvoiceNumber: "INV-2024-0008", BusinessID: business.ID, ClientID: client.ID, IssueDate: issueDate,
+		DueDate: issueDate.AddDate(0, 0, 30), Currency: "EUR", Status: "draft"}
+	if err := handler.dbService.SaveInvoice(invoice, nil); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+
+	viewPage := func() string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ViewInvoiceHandler(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/invoices/view/%d", invoice.ID), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+	pdfSrc := fmt.Sprintf(`src="/invoices/pdf/%d?token=`, invoice.ID)
+
+	// Without a PDF the viewer is empty, so opening the page does not generate one
+	if body := viewPage(); strings.Contains(body, pdfSrc) || !strings.Contains(body, "No PDF has been generated") {
+		t.Error("Expected an empty PDF viewer before the PDF is generated")
+	}
+
+	if _, err := handler.generateInvoicePDF(invoice.ID); err != nil {
+		t.Fatalf("Failed to generate PDF: %v", err)
+	}
+	body := viewPage()
+	if !strings.Contains(body, pdfSrc) {
+		t.Error("Expected the PDF viewer to show the signed link to the current PDF")
+	}
+	if !strings.Contains(body, `&download=1" class="btn btn-outline-secondary" id="pdfDownloadLink"`) {
+		t.Error("Expected a download link next to the PDF viewer")
+	}
+}
+
+func TestInvoiceEmailUsesClientLanguage(t *testing.T) {
+	dataDir := t.TempDir()
+	logger := services.NewLogger(services.FATAL)
+	dbService, err := services.NewDBService(dataDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to create DB service: %v", err)
+	}
+	defer dbService.Close()
+	authService, err := services.NewAuthService(dbService, logger)
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+	emailTemplateService := services.NewEmailTemplateService(dbService, services.NewSettingsService(dbService, logger), logger)
+	h := &AppHandler{dataDir: dataDir, logger: logger, dbService: dbService, businesses: dbService, clients: dbService, invoices: dbService, authService: authService, emailTemplateService: emailTemplateService,
+		complianceService: services.NewComplianceService(dbService, logger)}
+
+	business := &models.Business{Name: "Test Business", Country: "Germany"}
+	if err := dbService.SaveBusiness(business); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	client := &models.Client{Name: "Test Client", Country: "Austria", Language: "de-AT"}
+	if err := dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{
+		InvoiceNumber: "INV-2024-0001",
+		BusinessID:    business.ID,
+		ClientID:      client.ID,
+		IssueDate:     issueDate,
+		DueDate:       issueDate.AddDate(0, 0, 30),
+		Currency:      "EUR",
+		Status:        "draft",
+	}
+	if err := dbService.SaveInvoice(invoice, nil); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+	if err := emailTemplateService.Save(&models.EmailTemplate{
+		Kind: services.EmailTemplateInvoice, Language: "de",
+		Subject: "Rechnung {{invoice_number}}", Body: "Fällig am {{due_date}}: {{payment_link}}",
+	}); err != nil {
+		t.Fatalf("Failed to save template: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.InvoiceByIDHandler(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/invoices/%d/email", invoice.ID), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var email emailResponse
+	if err := json.NewDecoder(rec.Body).Decode(&email); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if email.Language != "de" || email.Subject != "Rechnung INV-2024-0001" {
+		t.Errorf("Unexpected email %+v", email)
+	}
+	if !strings.HasPrefix(email.Body, "Fällig am 2024-03-31: http://example.com/invoices/pdf/") {
+		t.Errorf("Unexpected body %q", email.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.InvoiceByIDHandler(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/invoices/%d/email?kind=newsletter", invoice.ID), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown kind, got %d", rec.Code)
+	}
+}
+
+func TestReloadTemplates(t *testing.T) {
+	// Work on a copy of the templates
+	root, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatalf("Failed to resolve repository root: %v", err)
+	}
+	workDir := t.TempDir()
+	if err := os.CopyFS(filepath.Join(workDir, templatesDir), os.DirFS(filepath.Join(root, templatesDir))); err != nil {
+		t.Fatalf("Failed to copy templates: %v", err)
+	}
+	t.Chdir(workDir)
+
+	logger := services.NewLogger(services.FATAL)
+	templates, err := parseTemplates(workDir, logger, nil)
+	if err != nil {
+		t.Fatalf("parseTemplates failed: %v", err)
+	}
+	h := &AppHandler{logger: logger, templates: templates, dataDir: workDir}
+
+	layout := filepath.Join(templatesDir, "layout.html")
+	content, err := os.ReadFile(layout)
+	if err != nil {
+		t.Fatalf("Failed to read layout: %v", err)
+	}
+	if err := os.WriteFile(layout, bytes.Replace(content, []byte("</body>"), []byte("<p>Customized</p></body>"), 1), 0644); err != nil {
+		t.Fatalf("Failed to write layout: %v", err)
+	}
+	if err := h.ReloadTemplates(); err != nil {
+		t.Fatalf("ReloadTemplates failed: %v", err)
+	}
+	if layout := h.templates["jobs"].Lookup("layout"); layout == nil || !strings.Contains(layout.Tree.Root.String(), "Customized") {
+		t.Error("Expected the changed layout to be used")
+	}
+
+	// A template that does not parse keeps the previous ones
+	if err := os.WriteFile(layout, []byte(`{{define "layout"}}{{if}}`), 0644); err != nil {
+		t.Fatalf("Failed to write layout: %v", err)
+	}
+	previous := h.templates
+	if err := h.ReloadTemplates(); err == nil {
+		t.Error("Expected a broken template to be rejected")
+	}
+	if h.templates["jobs"] != previous["jobs"] {
+		t.Error("Expected the previous templates to be kept")
+	}
+}
+
+func TestTemplateOverrides(t *testing.T) {
+	t.Chdir("../..")
+	dataDir := t.TempDir()
+	overrideDir := filepath.Join(dataDir, templateOverrideDir)
+	if err := os.MkdirAll(overrideDir, 0755); err != nil {
+		t.Fatalf("Failed to create override directory: %v", err)
+	}
+	view := `{{define "content"}}<p>Our own invoice page</p>{{end}}`
+	if err := os.WriteFile(filepath.Join(overrideDir, "view-invoice.html"), []byte(view), 0644); err != nil {
+		t.Fatalf("Failed to write override: %v", err)
+	}
+
+	templates, err := parseTemplates(dataDir, services.NewLogger(services.FATAL), nil)
+	if err != nil {
+		t.Fatalf("parseTemplates failed: %v", err)
+	}
+	if content := templates["view-invoice"].Lookup("content"); content == nil || content.Tree.Root.String() != "<p>Our own invoice page</p>" {
+		t.Error("Expected the customized view-invoice.html to be used")
+	}
+	if content := templates["invoices"].Lookup("content"); content == nil || strings.Contains(content.Tree.Root.String(), "Our own") {
+		t.Error("Expected the other templates to be unchanged")
+	}
+}
+
+func TestGraphQL(t *testing.T) {
+	dataDir := t.TempDir()
+	logger := services.NewLogger(services.FATAL)
+	dbService, err := services.NewDBService(dataDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to create DB service: %v", err)
+	}
+	defer dbService.Close()
+	h := &AppHandler{dataDir: dataDir, logger: logger, dbService: dbService, businesses: dbService, clients: dbService, invoices: dbService}
+	if h.graphQLSchema, err = h.newGraphQLSchema(); err != nil {
+		t.Fatalf("Failed to build schema: %v", err)
+	}
+
+	business := &models.Business{Name: "Test Business", Country: "Germany"}
+	if err := dbService.SaveBusiness(business); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	client := &models.Client{Name: "Test Client", Country: "Germany"}
+	if err := dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{
+		InvoiceNumber: "INV-2024-0001",
+		BusinessID:    business.ID,
+		ClientID:      client.ID,
+		IssueDate:     issueDate,
+		DueDate:       issueDate.AddDate(0, 0, 30),
+		TotalAmount:   11900,
+		VatRate:       19,
+		VatAmount:     1900,
+		Currency:      "EUR",
+		Status:        "sent",
+	}
+	items := []models.InvoiceItem{{Description: "Consulting", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+	if err := dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+
+	query := func(q string) (int, string) {
+		body, _ := json.Marshal(graphQLRequest{Query: q, Variables: map[string]interface{}{"id": invoice.ID}})
+		rec := httptest.NewRecorder()
+		h.GraphQLHandler(rec, httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body)))
+		return rec.Code, rec.Body.String()
+	}
+
+	// One request returns the invoice with its items, client and business
+	code, body := query(`query($id: Int!) { invoice(id: $id) { invoice_number issue_date paid_date total_amount items { description amount } client { name } business { name } } }`)
+	want := `{"data":{"invoice":{"business":{"name":"Test Business"},"client":{"name":"Test Client"},"invoice_number":"INV-2024-0001","issue_date":"2024-03-01","items":[{"amount":100,"description":"Consulting"}],"paid_date":null,"total_amount":119}}}`
+	if code != http.StatusOK || strings.TrimSpace(body) != want {
+		t.Errorf("Unexpected response %d %s", code, body)
+	}
+
+	code, body = query(`{ clients { name invoices(status: "sent") { invoice_number } } }`)
+	if code != http.StatusOK || !strings.Contains(body, `"invoices":[{"invoice_number":"INV-2024-0001"}]`) {
+		t.Errorf("Expected the client's invoices, got %d %s", code, body)
+	}
+
+	// Deeply nested queries are rejected, also when hidden in fragments
+	code, body = query(`{ invoices { client { invoices { client { invoices { items { description } } } } } } }`)
+	if code != http.StatusBadRequest || !strings.Contains(body, "nested 7 levels") {
+		t.Errorf("Expected a deep query to be rejected, got %d %s", code, body)
+	}
+	code, _ = query(`{ invoices { ...Deep } } fragment Deep on Invoice { client { invoices { client { invoices { items { description } } } } } }`)
+	if code != http.StatusBadRequest {
+		t.Errorf("Expected a deep query with fragments to be rejected, got %d", code)
+	}
+
+	// Fragments spreading each other many times are walked once each
+	var fragments strings.Builder
+	fragments.WriteString(`{ invoices { ...F0 } }`)
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(&fragments, ` fragment F%d on Invoice { ...F%d ...F%d }`, i, i+1, i+1)
+	}
+	fragments.WriteString(` fragment F30 on Invoice { invoice_number }`)
+	if doc, err := parser.Parse(parser.ParseParams{Source: fragments.String()}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	} else if depth, err := graphQLDepth(doc); err != nil || depth != 2 {
+		t.Errorf("Expected depth 2, got %d (%v)", depth, err)
+	}
+	code, body = query(`{ invoices { ` + strings.Repeat("invoice_number ", maxGraphQLSelections) + `} }`)
+	if code != http.StatusBadRequest || !strings.Contains(body, "selections") {
+		t.Errorf("Expected a query with too many selections to be rejected, got %d %s", code, body)
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	dataDir := t.TempDir()
+	logger := services.NewLogger(services.FATAL)
+	dbService, err := services.NewDBService(dataDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to create DB service: %v", err)
+	}
+	defer dbService.Close()
+	h := &AppHandler{dataDir: dataDir, logger: logger, dbService: dbService, businesses: dbService, clients: dbService, invoices: dbService, pdfService: services.NewPDFService(dataDir),
+		complianceService: services.NewComplianceService(dbService, logger)}
+
+	business := &models.Business{Name: "Test Business", Country: "Germany"}
+	if err := dbService.SaveBusiness(business); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	client := &models.Client{Name: "Test Client", Country: "Germany"}
+	if err := dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{
+		InvoiceNumber: "INV-2024-0001",
+		BusinessID:    business.ID,
+		ClientID:      client.ID,
+		IssueDate:     issueDate,
+		DueDate:       issueDate.AddDate(0, 0, 30),
+		TotalAmount:   11900,
+		VatRate:       19,
+		VatAmount:     1900,
+		Currency:      "EUR",
+		Status:        "sent",
+	}
+	items := []models.InvoiceItem{{Description: "Consulting", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+	if err := dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	server := h.NewGRPCServer("secret")
+	go server.Serve(listener)
+	defer server.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	api := pb.NewInvoiceServiceClient(conn)
+
+	// Calls without the token are rejected
+	if _, err := api.ListClients(context.Background(), &pb.ListClientsRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated without a token, got %v", err)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+
+	got, err := api.GetInvoice(ctx, &pb.GetInvoiceRequest{Id: int64(invoice.ID)})
+	if err != nil {
+		t.Fatalf("GetInvoice failed: %v", err)
+	}
+	if got.InvoiceNumber != "INV-2024-0001" || got.TotalAmount != 11900 || got.IssueDate != "2024-03-01" || got.PaidDate != "" || len(got.Items) != 1 {
+		t.Errorf("Unexpected invoice: %v", got)
+	}
+	if _, err := api.GetInvoice(ctx, &pb.GetInvoiceRequest{Id: 999}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound for a missing invoice, got %v", err)
+	}
+
+	list, err := api.ListInvoices(ctx, &pb.ListInvoicesRequest{Status: "draft"})
+	if err != nil || len(list.Invoices) != 0 {
+		t.Errorf("Expected no draft invoices, got %v, %v", list, err)
+	}
+
+	paid, err := api.UpdateInvoiceStatus(ctx, &pb.UpdateInvoiceStatusRequest{Id: int64(invoice.ID), Status: "paid", PaidDate: "2024-03-20"})
+	if err != nil || paid.Status != "paid" || paid.PaidDate != "2024-03-20" {
+		t.Errorf("Unexpected result of marking paid: %v, %v", paid, err)
+	}
+	if _, err := api.UpdateInvoiceStatus(ctx, &pb.UpdateInvoiceStatusRequest{Id: int64(invoice.ID), Status: "void"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for an unknown status, got %v", err)
+	}
+
+	// Saving with a stale version is aborted
+	saved, err := api.SaveClient(ctx, &pb.SaveClientRequest{Client: &pb.Client{Id: int64(client.ID), Name: "Renamed", Country: "Germany", Version: int64(client.Version)}})
+	if err != nil || saved.Name != "Renamed" || saved.Version != int64(client.Version)+1 {
+		t.Fatalf("Unexpected result of saving the client: %v, %v", saved, err)
+	}
+	if _, err := api.SaveClient(ctx, &pb.SaveClientRequest{Client: &pb.Client{Id: int64(client.ID), Name: "Stale", Version: int64(client.Version)}}); status.Code(err) != codes.Aborted {
+		t.Errorf("Expected Aborted for a stale version, got %v", err)
+	}
+	if _, err := api.SaveClient(ctx, &pb.SaveClientRequest{Client: &pb.Client{Name: "New", Email: "not an address"}}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for an invalid email, got %v", err)
+	}
+
+	stream, err := api.GetInvoicePDF(ctx, &pb.GetInvoicePDFRequest{Id: int64(invoice.ID)})
+	if err != nil {
+		t.Fatalf("GetInvoicePDF failed: %v", err)
+	}
+	var pdf bytes.Buffer
+	var filename string
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to receive PDF: %v", err)
+		}
+		if filename == "" {
+			filename = chunk.Filename
+		}
+		pdf.Write(chunk.Data)
+	}
+	if filename != invoice.PDFFilename() || !bytes.HasPrefix(pdf.Bytes(), []byte("%PDF")) {
+		t.Errorf("Unexpected PDF %q of %d bytes", filename, pdf.Len())
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	logger := services.NewLogger(services.FATAL)
+	h := &AppHandler{logger: logger, events: services.NewEventBroker(logger)}
+	server := httptest.NewServer(http.HandlerFunc(h.EventsHandler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to open the event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %q", got)
+	}
+
+	// The headers are sent once the handler subscribed
+	h.events.Publish(services.LiveEventBackupCreated, backupCreatedEvent{Filename: "backup_2024-03-01_120000.db"})
+	h.CloseEventStreams()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read the event stream: %v", err)
+	}
+	want := "retry: 5000\n\nevent: backup_created\ndata: {\"filename\":\"backup_2024-03-01_120000.db\"}\n\n"
+	if string(body) != want {
+		t.Errorf("Unexpected event stream:\n%s", body)
+	}
+}
+
+func TestLogoutRequiresPost(t *testing.T) {
+	t.Chdir("../..")
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	rec := httptest.NewRecorder()
+	handler.LogoutHandler(rec, httptest.NewRequest(http.MethodGet, "/auth/logout", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.LogoutHandler(rec, httptest.NewRequest(http.MethodPost, "/auth/logout", nil))
+	if rec.Code != http.StatusFound || !strings.Contains(rec.Header().Get("Set-Cookie"), "Max-Age=0") {
+		t.Errorf("Expected the session cookie to be cleared, got %d %q", rec.Code, rec.Header().Get("Set-Cookie"))
+	}
+}
+
+func TestAPIDocsAssetsMatchIntegrity(t *testing.T) {
+	h := &AppHandler{logger: services.NewLogger(services.FATAL)}
+	for name, asset := range swaggerUIAssets {
+		if !strings.Contains(apiDocsPage, `integrity="`+asset.integrity+`"`) {
+			t.Errorf("The docs page does not check the integrity of %s", name)
+		}
+		for _, encoding := range []string{"", "gzip"} {
+			req := httptest.NewRequest(http.MethodGet, "/api/docs/"+name, nil)
+			req.Header.Set("Accept-Encoding", encoding)
+			rec := httptest.NewRecorder()
+			h.APIDocsAssetHandler(rec, req)
+			body := rec.Body.Bytes()
+			if rec.Header().Get("Content-Encoding") == "gzip" {
+				reader, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatalf("%s is not gzipped: %v", name, err)
+				}
+				body, _ = io.ReadAll(reader)
+			}
+			digest := sha512.Sum384(body)
+			if got := "sha384-" + base64.StdEncoding.EncodeToString(digest[:]); got != asset.integrity {
+				t.Errorf("%s (Accept-Encoding %q) has integrity %s, the docs page expects %s", name, encoding, got, asset.integrity)
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.APIDocsAssetHandler(rec, httptest.NewRequest(http.MethodGet, "/api/docs/index.html", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for other files, got %d", rec.Code)
+	}
+}
+
+func TestPWAAssetsArePublic(t *testing.T) {
+	dataDir := t.TempDir()
+	logger := services.NewLogger(services.FATAL)
+	dbService, err := services.NewDBService(dataDir, logger)
+	if err != nil {
+		t.Fatalf("Failed to create DB service: %v", err)
+	}
+	defer dbService.Close()
+	t.Setenv("AUTH_MODE", "proxy")
+	authService, err := services.NewAuthService(dbService, logger)
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+
+	h := &AppHandler{dataDir: dataDir, logger: logger, authService: authService, version: "1.2.3"}
+	mux := http.NewServeMux()
+	mux.HandleFunc(manifestPath, h.ManifestHandler)
+	for path := range pwaAssets {
+		mux.HandleFunc(path, h.PWAAssetHandler)
+	}
+	mux.HandleFunc("/invoices", func(w http.ResponseWriter, r *http.Request) {})
+	server := h.RequireAuth(mux)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// Browsers fetch the manifest and icons without the session
+	rec := get(manifestPath)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d, want 200", manifestPath, rec.Code)
+	}
+	var manifest webManifest
+	if err := json.NewDecoder(rec.Body).Decode(&manifest); err != nil {
+		t.Fatalf("Failed to decode the manifest: %v", err)
+	}
+	for _, icon := range manifest.Icons {
+		if rec := get(icon.Src); rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != icon.Type {
+			t.Errorf("GET %s = %d %s, want 200 %s", icon.Src, rec.Code, rec.Header().Get("Content-Type"), icon.Type)
+		}
+	}
+
+	rec = get("/service-worker.js")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "'simple-invoice-1.2.3'") {
+		t.Errorf("GET /service-worker.js = %d, want 200 with a cache named after the version:\n%s", rec.Code, rec.Body.String())
+	}
+	if rec := get("/offline.html"); rec.Code != http.StatusOK {
+		t.Errorf("GET /offline.html = %d, want 200", rec.Code)
+	}
+
+	if rec := get("/invoices"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET /invoices = %d, want 401 without signing in", rec.Code)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	for query, want := range map[string][]int{
+		"":                  {1, 2, 3, 4, 5},
+		"limit=2":           {1, 2},
+		"limit=2&offset=4":  {5},
+		"offset=3":          {4, 5},
+		"offset=9":          {},
+		"limit=10&offset=1": {2, 3, 4, 5},
+	} {
+		rec := httptest.NewRecorder()
+		page, err := paginate(rec, httptest.NewRequest(http.MethodGet, "/api/invoices?"+query, nil), items)
+		if err != nil {
+			t.Errorf("%q: %v", query, err)
+			continue
+		}
+		if fmt.Sprint(page) != fmt.Sprint(want) {
+			t.Errorf("%q: expected %v, got %v", query, want, page)
+		}
+		if rec.Header().Get(totalCountHeader) != "5" {
+			t.Errorf("%q: expected X-Total-Count 5, got %q", query, rec.Header().Get(totalCountHeader))
+		}
+	}
+	for _, query := range []string{"limit=0", "limit=x", "offset=-1"} {
+		if _, err := paginate(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/invoices?"+query, nil), items); err == nil {
+			t.Errorf("Expected an error for %q", query)
+		}
+	}
+}
+
+func TestSimilarInvoicesHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	invoices := mocks.NewMockInvoiceRepo(ctrl)
+	h := &AppHandler{logger: services.NewLogger(services.FATAL), invoices: invoices}
+
+	rec := httptest.NewRecorder()
+	h.SimilarInvoicesHandler(rec, httptest.NewRequest(http.MethodGet, "/api/invoices/similar?total_amount=100.00", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a client, got %d", rec.Code)
+	}
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoices.EXPECT().GetInvoices().Return([]models.Invoice{
+		{ID: 1, InvoiceNumber: "INV-2024-0001", ClientID: 7, IssueDate: issueDate, TotalAmount: 119000, Currency: "EUR", Type: models.InvoiceTypeInvoice},
+		{ID: 2, InvoiceNumber: "INV-2024-0002", ClientID: 7, IssueDate: issueDate, TotalAmount: 50000, Currency: "EUR", Type: models.InvoiceTypeInvoice},
+	}, nil)
+	rec = httptest.NewRecorder()
+	h.SimilarInvoicesHandler(rec, httptest.NewRequest(http.MethodGet, "/api/invoices/similar?client_id=7&total_amount=1190.00&currency=EUR&issue_date=2024-03-20", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var similar []models.Invoice
+	if err := json.NewDecoder(rec.Body).Decode(&similar); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(similar) != 1 || similar[0].InvoiceNumber != "INV-2024-0001" {
+		t.Errorf("Expected INV-2024-0001 to be similar, got %+v", similar)
+	}
+}
+
+func TestInvoicesAPIHandlerValidatesStatus(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	invoices := mocks.NewMockInvoiceRepo(ctrl)
+	h := &AppHandler{logger: services.NewLogger(services.FATAL), invoices: invoices}
+	invoices.EXPECT().GetInvoice(5).Return(&models.Invoice{ID: 5, Status: models.InvoiceStatusDraft}, nil, nil)
+
+	for _, tt := range []struct {
+		name   string
+		fields string
+	}{
+		{"missing status", `"id": 0`},
+		{"status not a string", `"id": 0, "status": 1`},
+		{"unknown status", `"id": 0, "status": "lost"`},
+		{"new disputed invoice without a reason", `"id": 0, "status": "disputed"`},
+		{"draft put on hold", `"id": 5, "status": "on-hold", "status_reason": "Waiting for the PO"`},
+	} {
+		body := `{"invoice": {` + tt.fields + `, "invoice_number": "", "business_id": 1, "client_id": 1, "hours_worked": 0, "vat_rate": 0,
+			"reverse_charge_vat": false, "currency": "EUR", "notes": "", "issue_date": "2024-03-01", "due_date": "2024-03-31"},
+			"items": [{"description": "Work", "quantity": 1, "unit_price": "100.00", "amount": "100.00"}]}`
+		rec := httptest.NewRecorder()
+		h.InvoicesAPIHandler(rec, httptest.NewRequest(http.MethodPost, "/api/invoices", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), errCodeValidation) {
+			t.Errorf("%s: expected 400 %s, got %d: %s", tt.name, errCodeValidation, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestCancelInvoiceWithEInvoicing(t *testing.T) {
+	t.Chdir("../..")
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	client := &models.Client{Name: "Test Client", Country: "IT"}
+	if err := handler.dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+	cancel := func(country string) *httptest.ResponseRecorder {
+		t.Helper()
+		business := &models.Business{Name: "Test Business", Country: country}
+		if err := handler.dbService.SaveBusiness(business); err != nil {
+			t.Fatalf("Failed to save business: %v", err)
+		}
+		issueDate := time.Now().UTC()
+		invoice := &models.Invoice{BusinessID: business.ID, ClientID: client.ID, IssueDate: issueDate, DueDate: issueDate, TotalAmount: 10000, Currency: "EUR", Status: models.InvoiceStatusSent}
+		items := []models.InvoiceItem{{Description: "Work", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+		if err := handler.dbService.SaveInvoice(invoice, items); err != nil {
+			t.Fatalf("Failed to save invoice: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		handler.InvoiceByIDHandler(rec, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/invoices/%d/cancel", invoice.ID), nil))
+		return rec
+	}
+
+	// Italian invoices stay valid at SDI, so they are not cancelled locally
+	if rec := cancel("IT"); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), errCodeEInvoicingCancel) {
+		t.Errorf("Expected 409 %s for an Italian business, got %d: %s", errCodeEInvoicingCancel, rec.Code, rec.Body.String())
+	}
+	if rec := cancel("DE"); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 for a German business, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCancelInvoiceRunsInvoiceCreateHook(t *testing.T) {
+	t.Chdir("../..")
+	handler, tempDir, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	business := &models.Business{Name: "Test Business", Country: "DE"}
+	if err := handler.dbService.SaveBusiness(business); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	client := &models.Client{Name: "Test Client", Country: "DE"}
+	if err := handler.dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+	issueDate := time.Now().UTC()
+	invoice := &models.Invoice{BusinessID: business.ID, ClientID: client.ID, IssueDate: issueDate, DueDate: issueDate, TotalAmount: 10000, Currency: "EUR", Status: models.InvoiceStatusSent}
+	items := []models.InvoiceItem{{Description: "Work", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+	if err := handler.dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+
+	// The hook numbers cancellation invoices in its own scheme
+	if err := os.MkdirAll(filepath.Join(tempDir, "hooks"), 0755); err != nil {
+		t.Fatalf("Failed to create hooks directory: %v", err)
+	}
+	script := fmt.Sprintf("#!/bin/sh\ngrep -q '\"cancels_invoice_id\":%d' && echo '{\"invoice_number\": \"ST-1\"}'\n", invoice.ID)
+	if err := os.WriteFile(filepath.Join(tempDir, "hooks", "number.sh"), []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write hook: %v", err)
+	}
+	if err := handler.settingsService.Set(services.SettingHookInvoiceCreate, "number.sh"); err != nil {
+		t.Fatalf("Failed to configure hook: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.InvoiceByIDHandler(rec, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/invoices/%d/cancel", invoice.ID), nil))
+	var storno models.Invoice
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&storno) != nil || storno.InvoiceNumber != "ST-1" {
+		t.Errorf("Expected the cancellation to be numbered ST-1 by the hook, got %d %q", rec.Code, storno.InvoiceNumber)
+	}
+}
+
+func TestInvoiceNotesAndTimeline(t *testing.T) {
+	t.Chdir("../..")
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	business := &models.Business{Name: "Test Business", Country: "Germany"}
+	if err := handler.dbService.SaveBusiness(business); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	client := &models.Client{Name: "Test Client", Country: "Germany"}
+	if err := handler.dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+	issueDate := time.Now().UTC().AddDate(0, 0, -40)
+	invoice := &models.Invoice{BusinessID: business.ID, ClientID: client.ID, IssueDate: issueDate, DueDate: issueDate.AddDate(0, 0, 30), TotalAmount: 10000, Currency: "EUR", Status: "sent"}
+	items := []models.InvoiceItem{{Description: "Work", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+	if err := handler.dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if strings.HasPrefix(path, "/api/clients/") {
+			handler.ClientsAPIHandler(rec, req)
+		} else {
+			handler.InvoiceByIDHandler(rec, req)
+		}
+		return rec
+	}
+	if rec := post(fmt.Sprintf("/api/invoices/%d/notes", invoice.ID), `{"body": "Client promised payment Friday"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := post(fmt.Sprintf("/api/clients/%d/notes", client.ID), `{"body": "New contact in accounts payable"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := post(fmt.Sprintf("/api/invoices/%d/notes", invoice.ID), `{"body": "  "}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an empty note, got %d", rec.Code)
+	}
+	if rec := post("/api/invoices/9999/notes", `{"body": "Lost"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing invoice, got %d", rec.Code)
+	}
+
+	// The client timeline has the notes of the client and its invoices
+	rec := httptest.NewRecorder()
+	handler.ClientsAPIHandler(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/clients/%d/timeline", client.ID), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var events []models.TimelineEvent
+	if err := json.NewDecoder(rec.Body).Decode(&events); err != nil {
+		t.Fatalf("Failed to decode timeline: %v", err)
+	}
+	kinds := map[string]int{}
+	for _, event := range events {
+		kinds[event.Kind]++
+	}
+	if kinds[models.TimelineNote] != 2 || kinds[models.TimelineIssued] != 1 || kinds[models.TimelineOverdue] != 1 {
+		t.Errorf("Unexpected client timeline: %+v", events)
+	}
+
+	// The invoice page shows the invoice's own timeline
+	rec = httptest.NewRecorder()
+	handler.ViewInvoiceHandler(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/invoices/view/%d", invoice.ID), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "Client promised payment Friday") || strings.Contains(rec.Body.String(), "New contact in accounts payable") {
+		t.Errorf("Expected the invoice page to show only the invoice's notes")
+	}
+
+	notes, err := handler.dbService.GetNotes(models.NoteEntityInvoice, invoice.ID)
+	if err != nil || len(notes) != 1 {
+		t.Fatalf("Expected one invoice note, got %v, %v", notes, err)
+	}
+	rec = httptest.NewRecorder()
+	handler.NotesHandler(rec, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/notes/%d", notes[0].ID), nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	handler.NotesHandler(rec, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/notes/%d", notes[0].ID), nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a deleted note, got %d", rec.Code)
+	}
+}
+
+func TestAddressLookupHandler(t *testing.T) {
+	t.Chdir("../..")
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	// Disable the lookup so no request reaches the public Nominatim server
+	if err := handler.settingsService.Set(services.SettingNominatimURL, ""); err != nil {
+		t.Fatalf("Failed to disable address lookup: %v", err)
+	}
+
+	tests := []struct {
+		query  string
+		status int
+		code   string
+	}{
+		{"q=ab", http.StatusBadRequest, errCodeBadRequest},
+		{"q=Downing+Street&country=GBR", http.StatusBadRequest, errCodeBadRequest},
+		{"q=Downing+Street&country=gb", http.StatusServiceUnavailable, errCodeLookupFailed},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.AddressLookupHandler(rec, httptest.NewRequest(http.MethodGet, "/api/clients/address-lookup?"+tt.query, nil))
+		var body apiError
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if rec.Code != tt.status || body.Code != tt.code {
+			t.Errorf("%s: expected %d %s, got %d %s", tt.query, tt.status, tt.code, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestReferenceDataHandler(t *testing.T) {
+	t.Chdir("../..")
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	rec := httptest.NewRecorder()
+	handler.ReferenceDataHandler(rec, httptest.NewRequest(http.MethodGet, "/api/reference-data?date=2022-12-31", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var data ReferenceData
+	if err := json.Unmarshal(rec.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Failed to decode reference data: %v", err)
+	}
+	if len(data.EUMembers) != 27 || slices.Contains(data.EuroArea, "HR") || !slices.Contains(data.EuroArea, "DE") {
+		t.Errorf("Unexpected EU members %v and euro area %v on 2022-12-31", data.EUMembers, data.EuroArea)
+	}
+	for _, country := range data.Countries {
+		if country.Code == "HR" && country.Currency != "HRK" {
+			t.Errorf("Expected Croatia to use HRK on 2022-12-31, got %s", country.Currency)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ReferenceDataHandler(rec, httptest.NewRequest(http.MethodGet, "/api/reference-data?date=yesterday", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid date, got %d", rec.Code)
+	}
+}
+
+func TestWorkCalendarHandler(t *testing.T) {
+	t.Chdir("../..")
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	body := `{"name": "Example Consulting", "country": "DE", "work_hours_per_day": 6, "work_days": [5, 1, 1, 3]}`
+	rec := httptest.NewRecorder()
+	handler.BusinessAPIHandler(rec, httptest.NewRequest(http.MethodPost, "/api/business", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 saving the business, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.WorkCalendarHandler(rec, httptest.NewRequest(http.MethodGet, "/api/business/work-calendar?month=2024-05", nil))
+	var month models.WorkMonth
+	if err := json.Unmarshal(rec.Body.Bytes(), &month); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected a work month, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// May 2024 has 4 Mondays, 5 Wednesdays and 5 Fridays
+	if month.Month != "2024-05" || month.TotalHours != 14*6 {
+		t.Errorf("Expected 14 working days of 6 hours in May 2024, got %v hours", month.TotalHours)
+	}
+
+	for _, body := range []string{
+		`{"name": "Example Consulting", "work_hours_per_day": 25}`,
+		`{"name": "Example Consulting", "work_days": [7]}`,
+		`{"name": "Example Consulting", "holiday_country": "XX"}`,
+	} {
+		rec = httptest.NewRecorder()
+		handler.BusinessAPIHandler(rec, httptest.NewRequest(http.MethodPost, "/api/business", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, rec.Code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handler.WorkCalendarHandler(rec, httptest.NewRequest(http.MethodGet, "/api/business/work-calendar?month=May", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid month, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.WorkPeriodHandler(rec, httptest.NewRequest(http.MethodGet, "/api/business/work-period?from=2024-05-13&to=2024-05-26", nil))
+	var period models.WorkPeriod
+	if err := json.Unmarshal(rec.Body.Bytes(), &period); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected a work period, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(period.Days) != 14 || period.TotalHours != 6*6 {
+		t.Errorf("Expected 6 working days of 6 hours in two weeks, got %v hours in %d days", period.TotalHours, len(period.Days))
+	}
+	for _, query := range []string{"from=2024-05&to=2024-05-26", "from=2024-05-26&to=2024-05-13", "from=2024-01-01&to=2025-01-01"} {
+		rec = httptest.NewRecorder()
+		handler.WorkPeriodHandler(rec, httptest.NewRequest(http.MethodGet, "/api/business/work-period?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestApplyHoursBreakdown(t *testing.T) {
+	var rawInvoice map[string]interface{}
+	body := `{"hours_worked": 160, "show_hours_breakdown": true, "hours_table": "",
+		"timesheet": [{"date": "2024-05-02", "hours": 8}, {"date": "2024-05-03", "hours": 6.5}, {"date": "2024-05-06", "hours": 0}]}`
+	if err := json.Unmarshal([]byte(body), &rawInvoice); err != nil {
+		t.Fatal(err)
+	}
+	invoice := models.Invoice{HoursWorked: 160}
+	if err := applyHoursBreakdown(rawInvoice, &invoice); err != nil {
+		t.Fatalf("applyHoursBreakdown failed: %v", err)
+	}
+	if !invoice.ShowHoursBreakdown || len(invoice.HoursBreakdown) != 2 || invoice.HoursWorked != 14.5 {
+		t.Errorf("Expected the timesheet days and their 14.5 hours, got %v and %v hours", invoice.HoursBreakdown, invoice.HoursWorked)
+	}
+
+	for _, body := range []string{
+		`{"timesheet": [{"date": "2024-05-02", "hours": 8}], "hours_table": "2024-05-02;8"}`,
+		`{"timesheet": [{"date": "2024-05-02", "hours": 30}]}`,
+		`{"timesheet": "2024-05-02"}`,
+	} {
+		rawInvoice = nil
+		if err := json.Unmarshal([]byte(body), &rawInvoice); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyHoursBreakdown(rawInvoice, &models.Invoice{}); err == nil {
+			t.Errorf("%s: expected an error", body)
+		}
+	}
+}
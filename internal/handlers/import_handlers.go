@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// maxImportSize limits the size of uploaded import files
+const maxImportSize = 10 << 20 // 10 MB
+
+// ClientImportHandler imports clients from an uploaded CSV file
+// Form fields: file (CSV), mapping (optional JSON object of client field -> CSV column), dry_run (true/false)
+func (h *AppHandler) ClientImportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		h.logger.Error("Failed to parse import form: %v", err)
+		h.writeBodyError(w, "Failed to parse form", err)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		h.logger.Error("Failed to get import file: %v", err)
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Failed to get file", nil)
+		return
+	}
+	defer file.Close()
+
+	if !h.checkImportFile(w, file, header) {
+		return
+	}
+
+	var mapping map[string]string
+	if raw := r.FormValue("mapping"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+			h.logger.Error("Invalid column mapping: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid column mapping: %v", err), nil)
+			return
+		}
+	}
+
+	dryRun := r.FormValue("dry_run") == "true"
+	h.logger.Info("Importing clients from %s (dry run: %t)", header.Filename, dryRun)
+
+	result, err := h.importService.ImportClients(file, mapping, dryRun)
+	if err != nil {
+		h.logger.Error("Failed to import clients: %v", err)
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Failed to import clients: %v", err), nil)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+// InvoiceImportHandler imports historical invoices from an uploaded export
+// Form fields: file (CSV), format (generic, invoiceninja, wave), dry_run (true/false)
+func (h *AppHandler) InvoiceImportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		h.logger.Error("Failed to parse import form: %v", err)
+		h.writeBodyError(w, "Failed to parse form", err)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		h.logger.Error("Failed to get import file: %v", err)
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Failed to get file", nil)
+		return
+	}
+	defer file.Close()
+
+	if !h.checkImportFile(w, file, header) {
+		return
+	}
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = services.InvoiceImportGeneric
+	}
+	dryRun := r.FormValue("dry_run") == "true"
+	h.logger.Info("Importing %s invoices from %s (dry run: %t)", format, header.Filename, dryRun)
+
+	result, err := h.importService.ImportInvoices(file, format, dryRun)
+	if err != nil {
+		h.logger.Error("Failed to import invoices: %v", err)
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Failed to import invoices: %v", err), nil)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+// HoursInvoiceHandler generates draft invoices from an uploaded CSV of hours
+// Form fields: file (CSV), issue_date (YYYY-MM-DD, default today), business_id, dry_run (true/false)
+func (h *AppHandler) HoursInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		h.logger.Error("Failed to parse import form: %v", err)
+		h.writeBodyError(w, "Failed to parse form", err)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		h.logger.Error("Failed to get import file: %v", err)
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Failed to get file", nil)
+		return
+	}
+	defer file.Close()
+
+	if !h.checkImportFile(w, file, header) {
+		return
+	}
+
+	opts := services.HoursInvoiceOptions{
+		IssueDate: time.Now().Truncate(24 * time.Hour),
+		DueDays:   h.settingsService.GetInt(services.SettingInvoiceDueDays),
+		VatRate:   h.settingsService.GetFloat(services.SettingInvoiceVatRate),
+		Notes:     h.settingsService.GetString(services.SettingInvoiceNotes),
+		// Like invoices created in the form, at the rate of the issue date
+		ExchangeRate: h.lookupExchangeRate,
+	}
+	if value := r.FormValue("issue_date"); value != "" {
+		if opts.IssueDate, err = time.Parse("2006-01-02", value); err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid issue date format. Expected YYYY-MM-DD, got: %s", value), nil)
+			return
+		}
+	}
+	if value := r.FormValue("business_id"); value != "" {
+		if opts.BusinessID, err = strconv.Atoi(value); err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid business ID: %s", value), nil)
+			return
+		}
+	}
+	dryRun := r.FormValue("dry_run") == "true"
+	h.logger.Info("Generating invoices from the hours in %s (dry run: %t)", header.Filename, dryRun)
+
+	result, err := h.importService.GenerateInvoices(file, opts, dryRun)
+	switch {
+	case errors.Is(err, services.ErrYearClosed):
+		h.writeError(w, http.StatusConflict, errCodeYearClosed, fmt.Sprintf("Invoices cannot be issued in a closed fiscal year (%v)", err), nil)
+		return
+	case errors.Is(err, services.ErrDuplicateInvoiceNumber):
+		h.writeError(w, http.StatusConflict, errCodeDuplicateNumber, fmt.Sprintf("Failed to generate invoices: %v", err), nil)
+		return
+	case err != nil:
+		h.logger.Error("Failed to generate invoices: %v", err)
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Failed to generate invoices: %v", err), nil)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+// checkImportFile rejects import uploads that are not CSV files and reports
+// whether the import can go ahead
+func (h *AppHandler) checkImportFile(w http.ResponseWriter, file multipart.File, header *multipart.FileHeader) bool {
+	err := checkUploadedFile(file, header, csvExtensions, csvContentTypes)
+	if err == nil {
+		return true
+	}
+	h.logger.Warn("Rejected import file %s: %v", header.Filename, err)
+	if errors.Is(err, errUnsupportedFile) {
+		h.writeError(w, http.StatusUnsupportedMediaType, errCodeUnsupportedFile, "Invalid file. Upload a CSV file.", nil)
+	} else {
+		h.writeInternalError(w, "Failed to read import file", err)
+	}
+	return false
+}
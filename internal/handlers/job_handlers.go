@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// registerJobHandlers wires the job types to their implementations
+func (h *AppHandler) registerJobHandlers() {
+	h.jobService.RegisterHandler(services.JobTypeGeneratePDF, func(payload []byte) error {
+		var p services.PDFJobPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		if _, err := h.generateInvoicePDF(p.InvoiceID); err != nil {
+			return err
+		}
+		h.publishPDFGenerated(p.InvoiceID)
+		return nil
+	})
+
+	h.jobService.RegisterHandler(services.JobTypeCreateBackup, func(payload []byte) error {
+		filename, err := h.backupService.CreateBackup()
+		if err != nil {
+			return err
+		}
+		h.events.Publish(services.LiveEventBackupCreated, backupCreatedEvent{Filename: filename})
+		return nil
+	})
+
+	h.jobService.RegisterHandler(services.JobTypeSendInvoiceEmail, func(payload []byte) error {
+		var p invoiceEmailJobPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		return h.deliverInvoiceEmail(p)
+	})
+
+	h.jobService.RegisterHandler(services.JobTypeSendNotification, func(payload []byte) error {
+		var p services.NotificationJobPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		return h.notificationService.Deliver(p.Provider, p.Notification)
+	})
+
+	// Backups are retried by the job queue, so only the last failed attempt is notified
+	h.jobService.OnFailure(func(job *models.Job, err error) {
+		if job.Type == services.JobTypeCreateBackup {
+			h.notificationService.Notify(services.Notification{
+				Event:   services.EventBackupFailed,
+				Title:   "Backup failed",
+				Message: fmt.Sprintf("The backup failed after %d attempts: %v", job.Attempts, err),
+			})
+		}
+	})
+}
+
+// invoicePDFData is everything an invoice PDF is rendered from
+type invoicePDFData struct {
+	Invoice  *models.Invoice
+	Items    []models.InvoiceItem
+	Business *models.Business
+	Client   *models.Client
+}
+
+// loadInvoicePDFData loads an invoice with its items, business and client
+func (h *AppHandler) loadInvoicePDFData(invoiceID int) (*invoicePDFData, error) {
+	invoice, items, err := h.invoices.GetInvoice(invoiceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invoice: %w", err)
+	}
+
+	business, err := h.businesses.GetBusiness(invoice.BusinessID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get business: %w", err)
+	}
+
+	client, err := h.clients.GetClient(invoice.ClientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client: %w", err)
+	}
+
+	if invoice.ShowHoursBreakdown {
+		invoice.HoursBreakdown, err = h.projectService.GetInvoiceTimeEntries(invoiceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hours breakdown: %w", err)
+		}
+	}
+
+	h.applyReverseChargeClause(invoice, client)
+	if invoice.ComplianceClauses, err = h.complianceService.Clauses(business); err != nil {
+		return nil, fmt.Errorf("failed to get compliance clauses: %w", err)
+	}
+	if h.paypalService != nil {
+		invoice.PayPalLink = h.paypalService.Link(invoice)
+	}
+	if h.ksefService != nil {
+		if invoice.KSeFNumber, err = h.ksefService.Number(invoiceID); err != nil {
+			return nil, fmt.Errorf("failed to get KSeF number: %w", err)
+		}
+	}
+	if invoice.IsStorno() {
+		cancelled, _, err := h.invoices.GetInvoice(invoice.CancelsInvoiceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get cancelled invoice: %w", err)
+		}
+		invoice.CancelsInvoiceNumber, invoice.CancelsInvoiceDate = cancelled.InvoiceNumber, cancelled.IssueDate
+	}
+
+	return &invoicePDFData{Invoice: invoice, Items: items, Business: business, Client: client}, nil
+}
+
+// applyReverseChargeClause sets the legal clause printed on reverse-charge
+// invoices for the client's country or language
+func (h *AppHandler) applyReverseChargeClause(invoice *models.Invoice, client *models.Client) {
+	invoice.ReverseChargeClause = ""
+	if !invoice.ReverseChargeVat || h.reverseChargeService == nil {
+		return
+	}
+	clause, err := h.reverseChargeService.Clause(client)
+	if err != nil {
+		h.logger.Warn("Failed to get the reverse charge clause for client ID %d: %v", client.ID, err)
+		return
+	}
+	invoice.ReverseChargeClause = clause
+}
+
+// fingerprint hashes the data shown on the PDF, so changes to the invoice, its
+// business or its client can be told apart from regenerating unchanged data
+func (d *invoicePDFData) fingerprint() string {
+	invoice, business, client := *d.Invoice, *d.Business, *d.Client
+	// The status, payment details, cancellation and record versions are not printed
+	invoice.Status, invoice.StatusReason = "", ""
+	invoice.CancelledByInvoiceID = 0
+	invoice.PaidDate = time.Time{}
+	invoice.CryptoAmount, invoice.CryptoRate, invoice.CryptoFiatAmount = 0, 0, 0
+	business.Version = 0
+	client.Version = 0
+
+	data, _ := json.Marshal(invoicePDFData{Invoice: &invoice, Items: d.Items, Business: &business, Client: &client})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// generateInvoicePDF loads an invoice with its business and client and renders the PDF
+func (h *AppHandler) generateInvoicePDF(invoiceID int) (string, error) {
+	data, err := h.loadInvoicePDFData(invoiceID)
+	if err != nil {
+		return "", err
+	}
+	return h.renderInvoicePDF(data)
+}
+
+// currentInvoicePDF returns the PDF of an invoice, generating it again when it
+// is missing or the invoice data changed since it was generated
+func (h *AppHandler) currentInvoicePDF(invoiceID int) (string, error) {
+	data, err := h.loadInvoicePDFData(invoiceID)
+	if err != nil {
+		return "", err
+	}
+
+	versions, err := h.dbService.GetInvoicePDFVersions(invoiceID)
+	if err != nil {
+		return "", err
+	}
+	if len(versions) > 0 && versions[0].Fingerprint == data.fingerprint() {
+		pdfPath := filepath.Join(h.dataDir, "pdfs", versions[0].Filename)
+		if _, err := os.Stat(pdfPath); err == nil {
+			return pdfPath, nil
+		}
+	}
+
+	h.logger.Info("PDF of invoice %d is missing or out of date, generating it again", invoiceID)
+	return h.renderInvoicePDF(data)
+}
+
+// renderInvoicePDF renders the PDF of an invoice. When the invoice data changed
+// since the previous PDF, that PDF is moved to the invoice's history folder and
+// the new one is recorded as the next version.
+func (h *AppHandler) renderInvoicePDF(data *invoicePDFData) (string, error) {
+	invoiceID := data.Invoice.ID
+	h.logger.Info("Generating PDF for invoice ID: %d", invoiceID)
+
+	versions, err := h.dbService.GetInvoicePDFVersions(invoiceID)
+	if err != nil {
+		return "", err
+	}
+	fingerprint := data.fingerprint()
+	changed := len(versions) == 0 || versions[0].Fingerprint != fingerprint
+
+	// Keep the previous PDF before it is overwritten
+	if changed && len(versions) > 0 {
+		if err := h.archiveInvoicePDF(versions[0]); err != nil {
+			return "", err
+		}
+	}
+
+	pdfPath, err := h.pdfService.GenerateInvoice(data.Invoice, data.Business, data.Client, data.Items)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate PDF: %w", err)
+	}
+
+	// Verify the file exists and is accessible
+	if _, err := os.Stat(pdfPath); os.IsNotExist(err) {
+		return "", fmt.Errorf("generated PDF file not found: %s", pdfPath)
+	}
+
+	// The PDF is usable even if the pdf.render hook fails, which is logged
+	h.hookService.PDFRender(data.Invoice, pdfPath)
+
+	if changed {
+		version := &models.InvoicePDFVersion{InvoiceID: invoiceID, Filename: filepath.Base(pdfPath), Fingerprint: fingerprint}
+		if err := h.dbService.AddInvoicePDFVersion(version); err != nil {
+			return "", err
+		}
+		h.logger.Info("Successfully generated PDF version %d: %s", version.Version, pdfPath)
+	} else {
+		h.logger.Info("Successfully generated PDF: %s", pdfPath)
+	}
+	return pdfPath, nil
+}
+
+// invoicePDFHistoryDir returns the folder of the pdfs directory that keeps the
+// earlier PDF versions of an invoice
+func invoicePDFHistoryDir(invoiceID int) string {
+	return filepath.Join("history", strconv.Itoa(invoiceID))
+}
+
+// archiveInvoicePDF moves the PDF of a version into the invoice's history folder
+func (h *AppHandler) archiveInvoicePDF(version models.InvoicePDFVersion) error {
+	archived := filepath.Join(invoicePDFHistoryDir(version.InvoiceID), fmt.Sprintf("v%d.pdf", version.Version))
+	if version.Filename == archived {
+		return nil
+	}
+
+	pdfsDir := filepath.Join(h.dataDir, "pdfs")
+	if err := os.MkdirAll(filepath.Join(pdfsDir, invoicePDFHistoryDir(version.InvoiceID)), 0755); err != nil {
+		return fmt.Errorf("failed to create PDF history directory: %w", err)
+	}
+	if err := os.Rename(filepath.Join(pdfsDir, version.Filename), filepath.Join(pdfsDir, archived)); err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to archive PDF version %d: %w", version.Version, err)
+		}
+		h.logger.Warn("PDF of invoice %d version %d no longer exists", version.InvoiceID, version.Version)
+	}
+	return h.dbService.UpdateInvoicePDFVersionFilename(version.ID, archived)
+}
+
+// removeInvoicePDFHistory deletes the earlier PDF versions of an invoice
+func (h *AppHandler) removeInvoicePDFHistory(invoiceID int) error {
+	if err := h.dbService.DeleteInvoicePDFVersions(invoiceID); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(h.dataDir, "pdfs", invoicePDFHistoryDir(invoiceID))); err != nil {
+		return fmt.Errorf("failed to remove PDF history: %w", err)
+	}
+	return nil
+}
+
+// JobsHandler handles the jobs admin page
+func (h *AppHandler) JobsHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+
+	jobs, err := h.jobService.ListJobs(status, 200)
+	if err != nil {
+		h.logger.Error("Failed to list jobs: %v", err)
+		http.Error(w, "Failed to list jobs", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Title":        "Jobs",
+		"Jobs":         jobs,
+		"StatusFilter": status,
+		"CurrentYear":  time.Now().Year(),
+	}
+
+	h.renderTemplate(w, "jobs", data)
+}
+
+// JobsAPIHandler handles job listing API requests
+func (h *AppHandler) JobsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	jobs, err := h.jobService.ListJobs(r.URL.Query().Get("status"), limit)
+	if err != nil {
+		h.writeInternalError(w, "Failed to list jobs", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// JobByIDHandler handles operations on a specific job
+// Routes: GET/DELETE /api/jobs/{id}, POST /api/jobs/{id}/retry
+func (h *AppHandler) JobByIDHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/"), "/")
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Invalid job ID", nil)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "retry" {
+		if r.Method != http.MethodPost {
+			h.writeMethodNotAllowed(w)
+			return
+		}
+
+		h.logger.Info("Retrying job with ID: %d", id)
+		if err := h.jobService.RetryJob(id); err != nil {
+			h.logger.Error("Failed to retry job: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Failed to retry job: %v", err), nil)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"message": "Job queued for retry"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		job, err := h.jobService.GetJob(id)
+		if err != nil {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Job not found with ID: %d", id), nil)
+			return
+		}
+		json.NewEncoder(w).Encode(job)
+
+	case http.MethodDelete:
+		h.logger.Info("Deleting job with ID: %d", id)
+		if err := h.jobService.DeleteJob(id); err != nil {
+			h.logger.Error("Failed to delete job: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Failed to delete job: %v", err), nil)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"message": "Job deleted successfully"})
+
+	default:
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// invoiceKSeFHandler handles /api/invoices/{id}/ksef: GET shows how the
+// invoice was sent to KSeF and POST sends it now
+func (h *AppHandler) invoiceKSeFHandler(w http.ResponseWriter, r *http.Request, id int) {
+	switch r.Method {
+	case http.MethodGet:
+		if _, _, err := h.invoices.GetInvoice(id); err != nil {
+			h.writeKSeFError(w, id, err)
+			return
+		}
+		sent, err := h.ksefService.Status(id)
+		if err != nil {
+			h.writeInternalError(w, "Failed to load the KSeF status", err)
+			return
+		}
+		if sent == nil {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice %d was not queued for KSeF", id), nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sent)
+
+	case http.MethodPost:
+		sent, err := h.ksefService.Submit(id)
+		if err != nil {
+			h.writeKSeFError(w, id, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sent)
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// invoiceKSeFXMLHandler handles /api/invoices/{id}/ksef-xml, which downloads
+// the invoice as an FA(2) structured invoice
+func (h *AppHandler) invoiceKSeFXMLHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+	data, filename, err := h.ksefService.Generate(id)
+	if err != nil {
+		h.writeKSeFError(w, id, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Write(data)
+}
+
+// writeKSeFError reports why an invoice could not be sent to KSeF
+func (h *AppHandler) writeKSeFError(w http.ResponseWriter, id int, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", id), nil)
+	case errors.Is(err, services.ErrKSeFInvalid):
+		h.writeError(w, http.StatusUnprocessableEntity, errCodeValidation, err.Error(), nil)
+	case errors.Is(err, services.ErrKSeFNotConfigured):
+		h.writeError(w, http.StatusServiceUnavailable, errCodeKSeFFailed, err.Error(), nil)
+	default:
+		h.writeInternalError(w, "Failed to send the invoice to KSeF", err)
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// maxLogEntries is the most log entries /api/logs returns at once
+const maxLogEntries = 1000
+
+// LogsHandler renders the page that tails the recent log entries
+func (h *AppHandler) LogsHandler(w http.ResponseWriter, r *http.Request) {
+	data := map[string]interface{}{
+		"Title":       "Logs",
+		"LogLevel":    h.logger.Level().String(),
+		"LogLevels":   []string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"},
+		"CurrentYear": time.Now().Year(),
+	}
+
+	h.renderTemplate(w, "logs", data)
+}
+
+// LogsAPIHandler returns the most recent log entries kept in memory, oldest
+// first. The page polls it with after set to the last sequence number it has.
+func (h *AppHandler) LogsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	query := r.URL.Query()
+	minLevel := services.DEBUG
+	if name := query.Get("level"); name != "" {
+		level, ok := services.ParseLogLevel(name)
+		if !ok {
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Invalid log level, expected DEBUG, INFO, WARN, ERROR or FATAL", nil)
+			return
+		}
+		minLevel = level
+	}
+
+	var after int64
+	if value := strings.TrimSpace(query.Get("after")); value != "" {
+		var err error
+		if after, err = strconv.ParseInt(value, 10, 64); err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Invalid after, expected a sequence number", nil)
+			return
+		}
+	}
+
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit <= 0 {
+		limit = 200
+	}
+	limit = min(limit, maxLogEntries)
+
+	json.NewEncoder(w).Encode(h.logger.Recent(minLevel, after, limit))
+}
+
+// logLevelRequest is the body of PUT /api/logs/level, and its response
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// LogLevelHandler returns or changes the log level. A change is saved like on
+// the settings page and applied at once.
+// Routes: GET/PUT /api/logs/level
+func (h *AppHandler) LogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(logLevelRequest{Level: h.logger.Level().String()})
+
+	case http.MethodPut:
+		var req logLevelRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeBodyError(w, fmt.Sprintf("Invalid request body: %v", err), err)
+			return
+		}
+		level, ok := services.ParseLogLevel(req.Level)
+		if !ok {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "Invalid log level, expected DEBUG, INFO, WARN, ERROR or FATAL", nil)
+			return
+		}
+		if err := h.settingsService.Set(services.SettingLogLevel, level.String()); err != nil {
+			h.writeInternalError(w, "Failed to save the log level", err)
+			return
+		}
+		h.applyLogLevel()
+
+		json.NewEncoder(w).Encode(logLevelRequest{Level: h.logger.Level().String()})
+
+	default:
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// applyLogLevel changes the level of the logger to the saved one
+func (h *AppHandler) applyLogLevel() {
+	level, _ := h.settingsService.LogLevel()
+	if level == h.logger.Level() {
+		return
+	}
+	// Logged while the lower of both levels applies, so the change shows
+	// when either level includes INFO
+	if level > h.logger.Level() {
+		h.logger.Info("Log level changed from %s to %s", h.logger.Level(), level)
+		h.logger.SetLevel(level)
+	} else {
+		h.logger.SetLevel(level)
+		h.logger.Info("Log level changed to %s", level)
+	}
+}
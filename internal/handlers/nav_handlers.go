@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// invoiceNAVHandler handles /api/invoices/{id}/nav: GET shows how the invoice
+// was reported to NAV Online Számla and POST reports it now, for example
+// again after NAV aborted it
+func (h *AppHandler) invoiceNAVHandler(w http.ResponseWriter, r *http.Request, id int) {
+	switch r.Method {
+	case http.MethodGet:
+		if _, _, err := h.invoices.GetInvoice(id); err != nil {
+			h.writeNAVError(w, id, err)
+			return
+		}
+		report, err := h.navService.Status(id)
+		if err != nil {
+			h.writeInternalError(w, "Failed to load the NAV report", err)
+			return
+		}
+		if report == nil {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice %d was not reported to NAV", id), nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+
+	case http.MethodPost:
+		report, err := h.navService.Submit(id)
+		if err != nil {
+			h.writeNAVError(w, id, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// writeNAVError reports why an invoice could not be reported to NAV
+func (h *AppHandler) writeNAVError(w http.ResponseWriter, id int, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", id), nil)
+	case errors.Is(err, services.ErrNAVInvalid):
+		h.writeError(w, http.StatusUnprocessableEntity, errCodeValidation, err.Error(), nil)
+	case errors.Is(err, services.ErrNAVNotConfigured):
+		h.writeError(w, http.StatusServiceUnavailable, errCodeNAVFailed, err.Error(), nil)
+	default:
+		h.writeInternalError(w, "Failed to report the invoice to NAV", err)
+	}
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// noteRequest is the body of POST /api/invoices/{id}/notes and /api/clients/{id}/notes
+type noteRequest struct {
+	Body string `json:"body"`
+}
+
+// noteAuthor returns the name notes of a request are logged under, empty
+// when authentication is disabled
+func noteAuthor(r *http.Request) string {
+	user := currentUser(r)
+	if user == nil {
+		return ""
+	}
+	for _, name := range []string{user.Name, user.Username, user.Email} {
+		if name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// notesHandler handles GET and POST /api/invoices/{id}/notes and
+// /api/clients/{id}/notes, the notes logged against an invoice or client.
+// The caller checks that the invoice or client exists.
+func (h *AppHandler) notesHandler(w http.ResponseWriter, r *http.Request, entityType string, entityID int) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		notes, err := h.dbService.GetNotes(entityType, entityID)
+		if err != nil {
+			h.writeInternalError(w, "Failed to load notes", err)
+			return
+		}
+		json.NewEncoder(w).Encode(notes)
+
+	case http.MethodPost:
+		var request noteRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			h.writeBodyError(w, "Invalid request body", err)
+			return
+		}
+		body := strings.TrimSpace(request.Body)
+		if body == "" {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "The note is empty", nil)
+			return
+		}
+		if utf8.RuneCountInString(body) > models.MaxNoteLength {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Notes may be at most %d characters long", models.MaxNoteLength), nil)
+			return
+		}
+
+		note := models.Note{EntityType: entityType, EntityID: entityID, Body: body, Author: noteAuthor(r)}
+		if err := h.dbService.AddNote(&note); err != nil {
+			h.writeInternalError(w, "Failed to save note", err)
+			return
+		}
+
+		h.logger.Info("Added note %d to %s %d", note.ID, entityType, entityID)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(note)
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// NotesHandler handles DELETE /api/notes/{id}
+func (h *AppHandler) NotesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/notes/")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid note ID: %s", idStr), nil)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	if err := h.dbService.DeleteNote(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Note not found with ID: %d", id), nil)
+			return
+		}
+		h.writeInternalError(w, "Failed to delete note", err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"message": "Note deleted successfully"})
+}
+
+// invoiceTimeline returns the notes and recorded events of an invoice,
+// newest first. emails are the emails sent for the invoice.
+func (h *AppHandler) invoiceTimeline(invoice *models.Invoice, emails []models.InvoiceEmail) ([]models.TimelineEvent, error) {
+	notes, err := h.dbService.GetNotes(models.NoteEntityInvoice, invoice.ID)
+	if err != nil {
+		return nil, err
+	}
+	credits, err := h.dbService.GetClientCredits(invoice.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	applied := []models.ClientCredit{}
+	for _, credit := range credits {
+		if credit.InvoiceID == invoice.ID {
+			applied = append(applied, credit)
+		}
+	}
+	audit, err := h.dbService.GetAuditLog("invoice", invoice.ID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return services.BuildTimeline(services.TimelineSources{
+		Invoices: []models.Invoice{*invoice},
+		Notes:    notes,
+		Emails:   emails,
+		Credits:  applied,
+		Audit:    audit,
+	}, time.Now()), nil
+}
+
+// invoiceTimelineHandler handles GET /api/invoices/{id}/timeline
+func (h *AppHandler) invoiceTimelineHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+	invoice, _, err := h.invoices.GetInvoice(id)
+	if err != nil {
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", id), nil)
+		return
+	}
+	emails, err := h.dbService.GetInvoiceEmails(id)
+	if err != nil {
+		h.writeInternalError(w, "Failed to load sent emails", err)
+		return
+	}
+
+	events, err := h.invoiceTimeline(invoice, emails)
+	if err != nil {
+		h.writeInternalError(w, "Failed to load the invoice timeline", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// clientTimelineHandler handles GET /api/clients/{id}/timeline, the notes
+// and recorded events of a client and its invoices
+func (h *AppHandler) clientTimelineHandler(w http.ResponseWriter, r *http.Request, client *models.Client) {
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	allInvoices, err := h.invoices.GetInvoices()
+	if err != nil {
+		h.writeInternalError(w, "Failed to fetch invoices", err)
+		return
+	}
+	invoices := []models.Invoice{}
+	for _, invoice := range allInvoices {
+		if invoice.ClientID == client.ID {
+			invoices = append(invoices, invoice)
+		}
+	}
+	notes, err := h.dbService.GetClientNotes(client.ID)
+	if err != nil {
+		h.writeInternalError(w, "Failed to load notes", err)
+		return
+	}
+	emails, err := h.dbService.GetClientInvoiceEmails(client.ID)
+	if err != nil {
+		h.writeInternalError(w, "Failed to load sent emails", err)
+		return
+	}
+	credits, err := h.dbService.GetClientCredits(client.ID)
+	if err != nil {
+		h.writeInternalError(w, "Failed to load client credits", err)
+		return
+	}
+	audit, err := h.dbService.GetAuditLog("client", client.ID, 0)
+	if err != nil {
+		h.writeInternalError(w, "Failed to read audit log", err)
+		return
+	}
+
+	events := services.BuildTimeline(services.TimelineSources{
+		Client:   client,
+		Invoices: invoices,
+		Notes:    notes,
+		Emails:   emails,
+		Credits:  credits,
+		Audit:    audit,
+	}, time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
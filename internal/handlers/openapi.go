@@ -0,0 +1,1128 @@
+package handlers
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+	swaggerui "github.com/swaggest/swgui/v5/static"
+)
+
+// apiEndpoint is a ServeMux pattern of the JSON API together with the
+// operations its handler serves. RegisterHandlers registers the API from this
+// list, so every endpoint is documented in the OpenAPI document.
+type apiEndpoint struct {
+	Pattern    string
+	Handler    http.HandlerFunc
+	Operations []apiOperation
+}
+
+// apiOperation documents one method and path of the API
+type apiOperation struct {
+	Method       string
+	Path         string // OpenAPI path template, e.g. /api/clients/{id}
+	Tag          string
+	Summary      string
+	Description  string
+	Params       []apiParam
+	Body         interface{} // Value whose type describes the JSON request body
+	Form         []apiParam  // Fields of a form request body
+	FormType     string      // Content type of the form, multipart/form-data if empty
+	Response     interface{} // Value whose type describes the JSON response, a message if nil
+	ResponseType string      // Content type of non-JSON responses
+	Success      []int       // Success statuses, 200 if empty
+	Errors       []int
+	Paged        bool // The list can be paged with limit and offset, see paginate
+}
+
+// apiParam documents a path or query parameter, or a form field
+type apiParam struct {
+	Name        string
+	In          string // path or query; empty for form fields
+	Type        string // string, integer, boolean or binary
+	Description string
+	Required    bool
+	Enum        []string
+}
+
+// Response shapes that are built from maps in the handlers
+type (
+	messageResponse struct {
+		Message string `json:"message"`
+	}
+	invoiceRequest struct {
+		Invoice models.Invoice       `json:"invoice"`
+		Items   []models.InvoiceItem `json:"items"`
+	}
+	previewRequest struct {
+		Invoice  models.Invoice       `json:"invoice"`
+		Items    []models.InvoiceItem `json:"items"`
+		Business models.Business      `json:"business"`
+		Client   models.Client        `json:"client"`
+	}
+	invoiceStatusRequest struct {
+		Status   string `json:"status"`
+		PaidDate string `json:"paid_date,omitempty"`
+		Reason   string `json:"reason,omitempty"`
+	}
+	invoiceStatusResponse struct {
+		ID     int    `json:"id"`
+		Status string `json:"status"`
+	}
+	invoiceDeleteResponse struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+	previewResponse struct {
+		URL string `json:"url"`
+	}
+	pdfResponse struct {
+		Filename string `json:"filename"`
+		URL      string `json:"url"`
+		ShareURL string `json:"share_url"`
+	}
+	logoResponse struct {
+		Filename string `json:"filename"`
+		Path     string `json:"path"`
+		URL      string `json:"url"`
+		Message  string `json:"message"`
+		Version  int    `json:"version"`
+	}
+	logoRemovedResponse struct {
+		Message string `json:"message"`
+		Version int    `json:"version"` // Version of the business after the change
+	}
+	backupCreatedResponse struct {
+		Message  string `json:"message"`
+		Filename string `json:"filename"`
+	}
+	anonymizeResponse struct {
+		Message          string `json:"message"`
+		InvoicesRetained int    `json:"invoices_retained"`
+		PDFsRemoved      int    `json:"pdfs_removed"`
+	}
+	currentUserResponse struct {
+		Mode string       `json:"mode"`
+		User *models.User `json:"user"`
+	}
+)
+
+// limitParam is accepted by list endpoints that return the most recent entries first
+var limitParam = apiParam{Name: "limit", In: "query", Type: "integer", Description: "Maximum number of entries to return, most recent first (default 100)"}
+
+// yearParam is the fiscal year in the path of the closing endpoints
+var yearParam = apiParam{Name: "year", In: "path", Type: "integer", Description: "Fiscal year", Required: true}
+
+// holidayParams select the public holidays of the holiday endpoints
+var holidayParams = []apiParam{
+	{Name: "country", In: "query", Type: "string", Description: "ISO 3166-1 alpha-2 country code", Required: true},
+	{Name: "year", In: "query", Type: "integer", Description: "Year, the current year by default"},
+}
+
+func idParam(what string) apiParam {
+	return apiParam{Name: "id", In: "path", Type: "integer", Description: what + " ID", Required: true}
+}
+
+// apiEndpoints lists every JSON API endpoint
+func (h *AppHandler) apiEndpoints() []apiEndpoint {
+	return []apiEndpoint{
+		{Pattern: "/api/business", Handler: h.BusinessAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/business", Tag: "Business", Summary: "Get the business details", Response: models.Business{}},
+			{Method: http.MethodPost, Path: "/api/business", Tag: "Business", Summary: "Save the business details",
+				Description: "The version must match the stored version; a 409 response contains the current record.",
+				Body:        models.Business{}, Response: models.Business{}, Errors: []int{http.StatusBadRequest, http.StatusConflict}},
+		}},
+		{Pattern: "/api/business/work-calendar", Handler: h.WorkCalendarHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/business/work-calendar", Tag: "Business", Summary: "Get the working days of a month",
+				Description: "Lays out the month with the working hours, working days and public holidays of the business. " +
+					"If the public holidays cannot be downloaded the month is laid out without them.",
+				Params: []apiParam{
+					{Name: "month", In: "query", Type: "string", Description: "Month as YYYY-MM, the current month by default"},
+				},
+				Response: models.WorkMonth{}, Errors: []int{http.StatusBadRequest}},
+		}},
+		{Pattern: "/api/business/work-period", Handler: h.WorkPeriodHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/business/work-period", Tag: "Business", Summary: "Get the working days of a billing period",
+				Description: "Lays out the days from one date to another, both included, like the work calendar of a month. " +
+					"Periods are at most " + strconv.Itoa(models.MaxWorkPeriodDays) + " days long.",
+				Params: []apiParam{
+					{Name: "from", In: "query", Type: "string", Required: true, Description: "First day as YYYY-MM-DD"},
+					{Name: "to", In: "query", Type: "string", Required: true, Description: "Last day as YYYY-MM-DD"},
+				},
+				Response: models.WorkPeriod{}, Errors: []int{http.StatusBadRequest}},
+		}},
+		{Pattern: "/api/holidays", Handler: h.HolidaysHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/holidays", Tag: "Business", Summary: "List the public holidays of a country",
+				Description: "Nationwide public holidays are downloaded from the holiday server set on the Settings page the first time and again once a month.",
+				Params:      holidayParams, Response: []models.PublicHoliday{}, Errors: []int{http.StatusBadRequest, http.StatusBadGateway, http.StatusServiceUnavailable}},
+			{Method: http.MethodPost, Path: "/api/holidays", Tag: "Business", Summary: "Download the public holidays of a country again",
+				Params: holidayParams, Response: []models.PublicHoliday{}, Errors: []int{http.StatusBadRequest, http.StatusBadGateway, http.StatusServiceUnavailable}},
+		}},
+		{Pattern: "/api/clients", Handler: h.ClientsAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/clients", Tag: "Clients", Summary: "List clients", Response: []models.Client{}, Paged: true, Errors: []int{http.StatusBadRequest}},
+			{Method: http.MethodPost, Path: "/api/clients", Tag: "Clients", Summary: "Create or update a client",
+				Description: "Clients with an ID are updated; the version must match the stored version. " +
+					"date_format (" + strings.Join(models.DateFormats, ", ") + ") and number_format (" + strings.Join(models.NumberFormats, ", ") + ") set how the client's invoices print dates and numbers; " +
+					"empty uses the usual format of the client's country. The client.save hook may reject the client with 422.",
+				Body: models.Client{}, Response: models.Client{}, Errors: []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusBadGateway}},
+		}},
+		{Pattern: "/api/clients/", Handler: h.ClientsAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/clients/{id}", Tag: "Clients", Summary: "Get a client",
+				Params: []apiParam{idParam("Client")}, Response: models.Client{}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodDelete, Path: "/api/clients/{id}", Tag: "Clients", Summary: "Move a client to the trash",
+				Description: "Clients with sent, unpaid invoices are only deleted with force=true; otherwise the response is 409 with the number of open invoices. Drafts, pro-forma invoices and disputed or on-hold invoices do not count as open.",
+				Params:      []apiParam{idParam("Client"), {Name: "force", In: "query", Type: "boolean", Description: "Delete even if the client has open invoices"}},
+				Errors:      []int{http.StatusConflict}},
+			{Method: http.MethodPost, Path: "/api/clients/{id}/restore", Tag: "Clients", Summary: "Restore a client from the trash",
+				Params: []apiParam{idParam("Client")}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodPost, Path: "/api/clients/{id}/anonymize", Tag: "Clients", Summary: "Erase a client's personal data",
+				Description: "Invoices are retained with redacted client details and their PDFs are removed.",
+				Params:      []apiParam{idParam("Client")}, Response: anonymizeResponse{}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodGet, Path: "/api/clients/{id}/payment-stats", Tag: "Clients", Summary: "Get a client's payment behavior",
+				Description: "Computed from the client's invoices, excluding drafts and pro-forma invoices: the average days from issue to payment, the invoices paid late and the unpaid invoices past their due date.",
+				Params:      []apiParam{idParam("Client")}, Response: models.ClientPaymentStats{}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodGet, Path: "/api/clients/{id}/notes", Tag: "Notes", Summary: "List the notes logged against a client",
+				Description: "Newest first. Notes on the client's invoices are listed with GET /api/invoices/{id}/notes.",
+				Params:      []apiParam{idParam("Client")}, Response: []models.Note{}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodPost, Path: "/api/clients/{id}/notes", Tag: "Notes", Summary: "Log a note against a client",
+				Description: "The note is logged under the name of the signed-in user.",
+				Params:      []apiParam{idParam("Client")}, Body: noteRequest{}, Response: models.Note{},
+				Success: []int{http.StatusCreated}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+			{Method: http.MethodGet, Path: "/api/clients/{id}/timeline", Tag: "Notes", Summary: "Get the activity timeline of a client",
+				Description: "The notes logged against the client and its invoices merged with the recorded events, newest first: the client being added, invoices issued, overdue and paid, emails sent and bounced, credit recorded and applied, and audit log entries.",
+				Params:      []apiParam{idParam("Client")}, Response: []models.TimelineEvent{}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodGet, Path: "/api/clients/{id}/credits", Tag: "Clients", Summary: "Get a client's credit balance and ledger",
+				Params: []apiParam{idParam("Client")}, Response: clientCreditsResponse{}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodPost, Path: "/api/clients/{id}/credits", Tag: "Clients", Summary: "Record a prepayment or retainer as client credit",
+				Description: "The currency defaults to the currency of the client's country.",
+				Params:      []apiParam{idParam("Client")}, Body: addCreditRequest{}, Response: models.ClientCredit{},
+				Success: []int{http.StatusCreated}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+		}},
+		{Pattern: "/api/clients/vat-lookup", Handler: h.VatLookupHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/clients/vat-lookup", Tag: "Clients", Summary: "Look up a company by VAT ID",
+				Description: "Checks EU VAT IDs in VIES. Busy registers fail with 429, unreachable ones with 502.",
+				Params:      []apiParam{{Name: "vat_id", In: "query", Type: "string", Description: "VAT ID including the country prefix", Required: true}},
+				Response:    models.Client{}, Errors: []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusBadGateway}},
+		}},
+		{Pattern: "/api/clients/uk-company-lookup", Handler: h.UKCompanyLookupHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/clients/uk-company-lookup", Tag: "Clients", Summary: "Look up UK companies in Companies House",
+				Description: "Unknown company numbers fail with 404. When Companies House limits the requests of the API key, lookups fail with 429.",
+				Params: []apiParam{
+					{Name: "name", In: "query", Type: "string", Description: "Company name to search for"},
+					{Name: "number", In: "query", Type: "string", Description: "Company number, takes precedence over name"},
+				},
+				Response: []models.Client{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusBadGateway}},
+		}},
+		{Pattern: "/api/clients/address-lookup", Handler: h.AddressLookupHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/clients/address-lookup", Tag: "Clients", Summary: "Suggest addresses from OpenStreetMap",
+				Description: "Searches the Nominatim server set on the Settings page and splits up to five results into the address fields of a client. Results are cached for a day and requests to the server are limited to one per second; lookups that would wait longer than a few seconds fail with 429.",
+				Params: []apiParam{
+					{Name: "q", In: "query", Type: "string", Description: "Address to search for, at least three characters", Required: true},
+					{Name: "country", In: "query", Type: "string", Description: "Only addresses in this country, an ISO 3166-1 alpha-2 code"},
+				},
+				Response: []models.AddressSuggestion{}, Errors: []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable}},
+		}},
+		{Pattern: "/api/reference-data", Handler: h.ReferenceDataHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/reference-data", Tag: "Reference Data", Summary: "List countries, currencies and EU members",
+				Description: "Returns the ISO 3166-1 countries with the currency each used on the date, the ISO 4217 currencies with their decimal digits, " +
+					"and the EU and euro area member states on the date. Former currencies replaced by the euro are kept for existing invoices.",
+				Params: []apiParam{
+					{Name: "date", In: "query", Type: "string", Description: "Date as YYYY-MM-DD, today by default"},
+				},
+				Response: ReferenceData{}, Errors: []int{http.StatusBadRequest}},
+		}},
+		{Pattern: "/api/clients/import", Handler: h.ClientImportHandler, Operations: []apiOperation{
+			{Method: http.MethodPost, Path: "/api/clients/import", Tag: "Import", Summary: "Import clients from CSV",
+				Form: []apiParam{
+					{Name: "file", Type: "binary", Description: "CSV file", Required: true},
+					{Name: "mapping", Type: "string", Description: "JSON object mapping client fields to CSV columns"},
+					{Name: "dry_run", Type: "boolean", Description: "Validate without saving"},
+				},
+				Response: services.ImportResult{}, Errors: []int{http.StatusBadRequest, http.StatusUnsupportedMediaType}},
+		}},
+		{Pattern: "/api/projects", Handler: h.ProjectsAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/projects", Tag: "Projects", Summary: "List projects with their profitability",
+				Description: "Invoiced and paid amounts are net of VAT and exclude pro-forma invoices.",
+				Response:    []models.ProjectSummary{}, Paged: true, Errors: []int{http.StatusBadRequest}},
+			{Method: http.MethodPost, Path: "/api/projects", Tag: "Projects", Summary: "Create or update a project",
+				Description: "The currency defaults to the currency of the client's country. The client and currency of an existing project cannot change. New projects are answered with 201.",
+				Body:        models.Project{}, Response: models.Project{}, Success: []int{http.StatusOK, http.StatusCreated}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+		}},
+		{Pattern: "/api/projects/", Handler: h.ProjectsAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/projects/{id}", Tag: "Projects", Summary: "Get a project",
+				Params: []apiParam{idParam("Project")}, Response: models.Project{}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodDelete, Path: "/api/projects/{id}", Tag: "Projects", Summary: "Delete a project with its time entries",
+				Description: "The invoices of the project are kept and no longer belong to a project.",
+				Params:      []apiParam{idParam("Project")}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodGet, Path: "/api/projects/{id}/time-entries", Tag: "Projects", Summary: "List the time logged on a project",
+				Params: []apiParam{idParam("Project")}, Response: []models.TimeEntry{}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodPost, Path: "/api/projects/{id}/time-entries", Tag: "Projects", Summary: "Log time on a project",
+				Params: []apiParam{idParam("Project")}, Body: timeEntryRequest{}, Response: models.TimeEntry{},
+				Success: []int{http.StatusCreated}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+			{Method: http.MethodDelete, Path: "/api/projects/{id}/time-entries/{entry_id}", Tag: "Projects", Summary: "Delete a time entry",
+				Description: "Billed time cannot be deleted.",
+				Params: []apiParam{idParam("Project"),
+					{Name: "entry_id", In: "path", Type: "integer", Description: "Time entry ID", Required: true}},
+				Errors: []int{http.StatusNotFound}},
+		}},
+		{Pattern: "/api/contracts", Handler: h.ContractsAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/contracts", Tag: "Contracts", Summary: "List retainer contracts",
+				Response: []models.Contract{}, Paged: true, Errors: []int{http.StatusBadRequest}},
+			{Method: http.MethodPost, Path: "/api/contracts", Tag: "Contracts", Summary: "Create or update a retainer contract",
+				Description: "A draft invoice for the monthly amount is generated when each month comes due, on its first day when billed in advance " +
+					"and on its last day when billed in arrears. The first and last month are prorated by the days the contract runs. " +
+					"The currency defaults to the currency of the client's country and the business to the first business. New contracts are answered with 201.",
+				Body: contractRequest{}, Response: models.Contract{}, Success: []int{http.StatusOK, http.StatusCreated}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+		}},
+		{Pattern: "/api/contracts/", Handler: h.ContractsAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodPost, Path: "/api/contracts/generate", Tag: "Contracts", Summary: "Generate the contract invoices that are due",
+				Description: "Due invoices are also generated every hour. Contracts that fail are listed in errors.",
+				Response:    generateContractInvoicesResponse{}},
+			{Method: http.MethodGet, Path: "/api/contracts/{id}", Tag: "Contracts", Summary: "Get a retainer contract",
+				Params: []apiParam{idParam("Contract")}, Response: models.Contract{}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodDelete, Path: "/api/contracts/{id}", Tag: "Contracts", Summary: "Delete a retainer contract",
+				Description: "The invoices generated for the contract are kept.",
+				Params:      []apiParam{idParam("Contract")}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodGet, Path: "/api/contracts/{id}/invoices", Tag: "Contracts", Summary: "List the invoices generated for a contract",
+				Params: []apiParam{idParam("Contract")}, Response: []models.ContractInvoice{}, Errors: []int{http.StatusNotFound}},
+		}},
+		{Pattern: "/api/invoices", Handler: h.InvoicesAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/invoices", Tag: "Invoices", Summary: "List invoices",
+				Params: invoiceFilterParams, Response: []models.Invoice{}, Paged: true, Errors: []int{http.StatusBadRequest}},
+			{Method: http.MethodPost, Path: "/api/invoices", Tag: "Invoices", Summary: "Create or update an invoice",
+				Description: "Dates are sent as YYYY-MM-DD. Item amounts, the VAT amount and the total are recalculated; requests whose amounts differ by more than one minor unit are rejected. " +
+					"With show_hours_breakdown the PDF gets a page with the hours worked per day, listing the time billed on the invoice. " +
+					"An hours_table string in the invoice (one day per line: date, hours and an optional description, separated by tabs, semicolons or commas) replaces the pasted rows of that page. " +
+					"A timesheet list in the invoice (days with a date, hours and an optional description) replaces them too and sets hours_worked to its total; days without hours are left out. " +
+					"When a home currency is set and differs from the invoice currency, the totals are also shown in the home currency at the ECB reference rate of the issue date, or at the exchange_rate sent with the invoice. " +
+					"Invoices of a business exempt from VAT must have a vat_rate of 0 and no reverse charge. " +
+					"The status is one of " + strings.Join(models.InvoiceStatuses, ", ") + "; disputed and on-hold need a status_reason, which an invoice already held keeps when none is sent. " +
+					"A tags list replaces the tags of the invoice; without one they are kept. " +
+					"The kind of an item is one of " + strings.Join(models.ItemKinds, ", ") + ", service by default. Mileage is billed in km and per diems in days; " +
+					"without a description they are described from the quantity and rate, e.g. 420 km × 0.30 EUR/km. " +
+					"Items with vat_exempt, always disbursements, take their share of the invoice discount and are left out of the VAT base. " +
+					"Invoices that are not drafts or pro-forma must meet the compliance profile of the business country, or are rejected with 422 compliance_failed and the problems as details. " +
+					"The invoice.create hook may set the number of a new invoice or reject it with 422.",
+				Body: invoiceRequest{}, Response: models.Invoice{}, Errors: []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusBadGateway}},
+		}},
+		{Pattern: "/api/invoices/", Handler: h.InvoiceByIDHandler, Operations: []apiOperation{
+			{Method: http.MethodPatch, Path: "/api/invoices/{id}", Tag: "Invoices", Summary: "Update the status of an invoice",
+				Description: "The status is one of " + strings.Join(models.InvoiceStatuses, ", ") + ". " +
+					"When the status becomes paid, paid_date (YYYY-MM-DD) records the payment date; it defaults to today, or keeps the date already recorded. " +
+					"Only sent invoices can be disputed or put on hold, with a reason of up to 500 characters that is cleared when the status changes again; they are never overdue. " +
+					"A draft being finalized must meet the compliance profile of the business country, or is rejected with 422 compliance_failed.",
+				Params: []apiParam{idParam("Invoice")}, Body: invoiceStatusRequest{}, Response: invoiceStatusResponse{}, Errors: []int{http.StatusBadRequest, http.StatusUnprocessableEntity}},
+			{Method: http.MethodDelete, Path: "/api/invoices/{id}", Tag: "Invoices", Summary: "Delete an invoice",
+				Description: "When the compliance profile of the business country requires gapless numbering, only drafts and pro-forma invoices can be deleted; others are refused with 409 compliance_failed. Cancelled invoices and cancellation invoices are refused with 409 invoice_cancelled.",
+				Params:      []apiParam{idParam("Invoice")}, Response: invoiceDeleteResponse{}, Errors: []int{http.StatusConflict}},
+			{Method: http.MethodGet, Path: "/api/invoices/{id}/pdfs", Tag: "Invoices", Summary: "List the generated PDF versions of an invoice",
+				Description: "A new version is kept whenever the PDF is generated after the invoice, its business or its client changed. Links expire after a day.",
+				Params:      []apiParam{idParam("Invoice")}, Response: []pdfVersionResponse{}},
+			{Method: http.MethodGet, Path: "/api/invoices/{id}/email", Tag: "Invoices", Summary: "Render an email for an invoice",
+				Description: "Uses the template in the client's language, falling back to its base language, then the default locale, then English.",
+				Params: []apiParam{idParam("Invoice"),
+					{Name: "kind", In: "query", Type: "string", Description: "Template to render (default invoice)", Enum: services.EmailTemplateKinds}},
+				Response: emailResponse{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+			{Method: http.MethodGet, Path: "/api/invoices/{id}/emails", Tag: "Invoices", Summary: "List the emails sent for an invoice",
+				Description: "Newest first. status becomes bounced when a bounce message for the email is found in the IMAP mailbox.",
+				Params:      []apiParam{idParam("Invoice")}, Response: []models.InvoiceEmail{}},
+			{Method: http.MethodPost, Path: "/api/invoices/{id}/send", Tag: "Invoices", Summary: "Email an invoice with its PDF attached",
+				Description: "Queues the rendered invoice email (or the given template kind) to the client's email address, or to the address in the body. A send_invoice_email job delivers it with the PDF attached, retrying when the SMTP server is unavailable, and marks a draft invoice as sent. With a future send_at (RFC 3339) the email is scheduled instead and sent at that time, replacing the email scheduled before; the PDF attached is the one current then. Returns 409 with email_being_sent when the email scheduled before is being sent.",
+				Params:      []apiParam{idParam("Invoice")}, Body: sendInvoiceRequest{}, Response: sendInvoiceResponse{},
+				Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict}},
+			{Method: http.MethodGet, Path: "/api/invoices/{id}/scheduled-email", Tag: "Invoices", Summary: "Get the email scheduled for an invoice",
+				Description: "job_status is failed, with last_error, when all attempts to send it failed.",
+				Params:      []apiParam{idParam("Invoice")}, Response: models.ScheduledEmail{}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodDelete, Path: "/api/invoices/{id}/scheduled-email", Tag: "Invoices", Summary: "Cancel the email scheduled for an invoice",
+				Description: "Returns 409 with email_being_sent when it is being sent.",
+				Params:      []apiParam{idParam("Invoice")}, Errors: []int{http.StatusNotFound, http.StatusConflict}},
+			{Method: http.MethodPost, Path: "/api/invoices/{id}/convert", Tag: "Invoices", Summary: "Convert a pro-forma invoice into an invoice",
+				Description: "Creates a draft invoice numbered in the invoice sequence with the items and amounts of the pro-forma, issued today (or on issue_date) with the same payment term. Returns 409 with proforma_already_converted when the pro-forma was converted before.",
+				Params:      []apiParam{idParam("Pro-forma invoice")}, Body: convertProformaRequest{}, Response: models.Invoice{},
+				Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict}},
+			{Method: http.MethodPost, Path: "/api/invoices/{id}/cancel", Tag: "Invoices", Summary: "Cancel an invoice with a cancellation (storno) invoice",
+				Description: "Creates a paid invoice numbered in the invoice sequence that mirrors the items and amounts of the invoice with negated quantities, issued today (or on issue_date), with cancels_invoice_id set. The cancelled invoice gets cancelled_by_invoice_id, is marked paid if it was not, credit applied to it returns to the client and its scheduled email is cancelled. Drafts, pro-forma invoices and cancellation invoices cannot be cancelled. Returns 409 with year_closed for invoices of a closed fiscal year, 409 with invoice_already_cancelled when the invoice was cancelled before, and 409 with e_invoicing_cancellation for invoices of businesses that issue FatturaPA or report to NAV, Verifactu or KSeF, which must be corrected as the tax authority requires. " +
+					"The invoice.create hook may set the number of the cancellation invoice or reject it with 422.",
+				Params: []apiParam{idParam("Invoice")}, Body: cancelInvoiceRequest{}, Response: models.Invoice{},
+				Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusBadGateway}},
+			{Method: http.MethodGet, Path: "/api/invoices/{id}/time-entries", Tag: "Invoices", Summary: "List the time billed on an invoice",
+				Params: []apiParam{idParam("Invoice")}, Response: []models.TimeEntry{}},
+			{Method: http.MethodPost, Path: "/api/invoices/{id}/time-entries", Tag: "Invoices", Summary: "Attach the unbilled time of the invoice's project",
+				Description: "With a service period only time logged up to its end is attached.",
+				Params:      []apiParam{idParam("Invoice")}, Response: billTimeResponse{},
+				Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+			{Method: http.MethodPut, Path: "/api/invoices/{id}/tags", Tag: "Invoices", Summary: "Replace the tags of an invoice",
+				Description: "Tags are trimmed, deduplicated ignoring case and sorted; they may not contain commas or be longer than 50 characters. They can be changed on invoices of closed fiscal years.",
+				Params:      []apiParam{idParam("Invoice")}, Body: invoiceTagsRequest{}, Response: invoiceTagsRequest{},
+				Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+			{Method: http.MethodGet, Path: "/api/invoices/{id}/notes", Tag: "Notes", Summary: "List the notes logged against an invoice",
+				Params: []apiParam{idParam("Invoice")}, Response: []models.Note{}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodPost, Path: "/api/invoices/{id}/notes", Tag: "Notes", Summary: "Log a note against an invoice",
+				Description: "E.g. a call in which the client promised to pay. The note is logged under the name of the signed-in user.",
+				Params:      []apiParam{idParam("Invoice")}, Body: noteRequest{}, Response: models.Note{},
+				Success: []int{http.StatusCreated}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+			{Method: http.MethodGet, Path: "/api/invoices/{id}/timeline", Tag: "Notes", Summary: "Get the activity timeline of an invoice",
+				Description: "The notes logged against the invoice merged with its recorded events, newest first: issued, overdue and paid, emails sent and bounced, credit applied and audit log entries.",
+				Params:      []apiParam{idParam("Invoice")}, Response: []models.TimelineEvent{}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodPost, Path: "/api/invoices/{id}/credit", Tag: "Invoices", Summary: "Apply client credit to an invoice",
+				Description: "Deducts credit in the invoice currency from the amount due. Without an amount as much credit as possible is applied. Returns 409 with insufficient_credit when the client has less credit or the invoice less due.",
+				Params:      []apiParam{idParam("Invoice")}, Body: applyCreditRequest{}, Response: models.Invoice{},
+				Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict}},
+			{Method: http.MethodGet, Path: "/api/invoices/{id}/accounting", Tag: "Accounting Sync", Summary: "Show whether an invoice was pushed to the accounting software",
+				Description: "status is synced, pending when the invoice or its payment is new or changed since the last sync, failed with last_error when the last push failed, or skipped for drafts and pro-forma invoices. " +
+					"Returns 503 with accounting_sync_failed when no accounting software is connected.",
+				Params: []apiParam{idParam("Invoice")}, Response: models.AccountingSyncStatus{}, Errors: []int{http.StatusNotFound, http.StatusServiceUnavailable}},
+			{Method: http.MethodGet, Path: "/api/invoices/{id}/fatturapa", Tag: "E-Invoicing", Summary: "Download an invoice as a FatturaPA file",
+				Description: "The FatturaPA XML of an Italian business's invoice, named IT{partita IVA}_{progressive}.xml, e.g. to hand it to an intermediary. " +
+					"The name is kept until the file is sent to SDI. Returns 422 when the invoice is a draft or pro-forma, or lacks data FatturaPA requires.",
+				Params: []apiParam{idParam("Invoice")}, ResponseType: "application/xml", Errors: []int{http.StatusNotFound, http.StatusUnprocessableEntity}},
+			{Method: http.MethodPost, Path: "/api/invoices/{id}/fatturapa", Tag: "E-Invoicing", Summary: "Send an invoice to SDI by PEC",
+				Description: "Sends the FatturaPA file from the PEC mailbox in the settings to the SDI address; SDI receipts arrive in that mailbox. " +
+					"An invoice sent before is sent as a new file. Invoices to the public administration must be signed and cannot be sent this way. " +
+					"Returns 503 with sdi_failed when PEC is not configured and 502 when the PEC server refuses the file.",
+				Params: []apiParam{idParam("Invoice")}, Response: models.FatturaPAFile{},
+				Errors: []int{http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusBadGateway, http.StatusServiceUnavailable}},
+			{Method: http.MethodGet, Path: "/api/invoices/{id}/nav", Tag: "E-Invoicing", Summary: "Show how an invoice was reported to NAV",
+				Description: "Invoices of businesses with a HU VAT ID are queued for NAV Online Számla once finalized. status is queued (also while a failed attempt waits to be retried, see attempts and message), " +
+					"submitted while NAV processes the transaction_id, done, or aborted with NAV's validation messages. Returns 404 when the invoice was not queued.",
+				Params: []apiParam{idParam("Invoice")}, Response: models.NAVReport{}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodPost, Path: "/api/invoices/{id}/nav", Tag: "E-Invoicing", Summary: "Report an invoice to NAV now",
+				Description: "Reports the invoice without waiting for the queue, for example again after NAV aborted it. A failed attempt leaves it queued with the error as message. " +
+					"Returns 422 for drafts, pro-forma invoices, invoices reported already and businesses without a HU VAT ID, and 503 with nav_reporting_failed when the technical user is not configured.",
+				Params: []apiParam{idParam("Invoice")}, Response: models.NAVReport{},
+				Errors: []int{http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusServiceUnavailable}},
+			{Method: http.MethodGet, Path: "/api/invoices/{id}/verifactu", Tag: "E-Invoicing", Summary: "Show the Verifactu record of an invoice",
+				Description: "With Verifactu enabled, finalized invoices of businesses with an ES VAT ID get a billing record chained to the previous record by its hash, which is sent to AEAT. " +
+					"Returns the latest record: status is pending (also while a failed attempt waits to be retried, see attempts and message), accepted with AEAT's csv, " +
+					"accepted_with_errors or rejected with AEAT's error as message. Returns 404 when the invoice has no record.",
+				Params: []apiParam{idParam("Invoice")}, Response: models.VerifactuRecord{}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodPost, Path: "/api/invoices/{id}/verifactu", Tag: "E-Invoicing", Summary: "Send the Verifactu record of an invoice now",
+				Description: "Creates the record of the invoice if it has none, or a correcting record when AEAT rejected or objected to the latest one, and sends the pending records to AEAT. " +
+					"A failed attempt leaves them pending with the error as message. Returns 422 for drafts, pro-forma invoices, invoices AEAT accepted already and businesses without an ES VAT ID, " +
+					"and 503 with verifactu_failed when Verifactu is not enabled or no certificate is set.",
+				Params: []apiParam{idParam("Invoice")}, Response: models.VerifactuRecord{},
+				Errors: []int{http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusServiceUnavailable}},
+			{Method: http.MethodGet, Path: "/api/invoices/{id}/ksef", Tag: "E-Invoicing", Summary: "Show how an invoice was sent to KSeF",
+				Description: "Invoices of businesses with a PL VAT ID are queued for KSeF once finalized. status is queued (also while a failed attempt waits to be retried, see attempts and message), " +
+					"submitted while KSeF processes the reference_number, accepted with the ksef_number printed on the PDF, or rejected with KSeF's reason as message. Returns 404 when the invoice was not queued.",
+				Params: []apiParam{idParam("Invoice")}, Response: models.KSeFInvoice{}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodPost, Path: "/api/invoices/{id}/ksef", Tag: "E-Invoicing", Summary: "Send an invoice to KSeF now",
+				Description: "Sends the invoice as an FA(2) structured invoice without waiting for the queue, for example again after KSeF rejected it. A failed attempt leaves it queued with the error as message. " +
+					"Returns 422 for drafts, pro-forma invoices, invoices sent already and businesses without a PL VAT ID, and 503 with ksef_failed when no token or KSeF public key is set.",
+				Params: []apiParam{idParam("Invoice")}, Response: models.KSeFInvoice{},
+				Errors: []int{http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusServiceUnavailable}},
+			{Method: http.MethodGet, Path: "/api/invoices/{id}/ksef-xml", Tag: "E-Invoicing", Summary: "Download an invoice as an FA(2) structured invoice",
+				Description: "The FA(2) XML KSeF receives for a Polish business's invoice, e.g. to upload it by other means. Returns 422 when the invoice is a draft or pro-forma, or lacks data FA(2) requires.",
+				Params:      []apiParam{idParam("Invoice")}, ResponseType: "application/xml", Errors: []int{http.StatusNotFound, http.StatusUnprocessableEntity}},
+			{Method: http.MethodPost, Path: "/api/invoices/{id}/crypto-payment", Tag: "Invoices", Summary: "Record a payment in USDC",
+				Description: "Marks the invoice paid on paid_date (YYYY-MM-DD, today if empty) and records the USDC amount received, the rate and crypto_fiat_amount, its value in the invoice currency. " +
+					"USDC is valued as US dollars at the ECB reference rate of the payment date unless a rate (invoice currency units per USDC) is sent; without an ECB rate for the invoice currency the rate is required.",
+				Params: []apiParam{idParam("Invoice")}, Body: cryptoPaymentRequest{}, Response: models.Invoice{},
+				Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusBadGateway}},
+		}},
+		{Pattern: "/api/notes/", Handler: h.NotesHandler, Operations: []apiOperation{
+			{Method: http.MethodDelete, Path: "/api/notes/{id}", Tag: "Notes", Summary: "Delete a note",
+				Params: []apiParam{idParam("Note")}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+		}},
+		{Pattern: "/api/invoices/tags", Handler: h.InvoiceTagsHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/invoices/tags", Tag: "Invoices", Summary: "List the tags in use",
+				Description: "Tags that differ only in case are listed once.", Response: []models.TagCount{}},
+		}},
+		{Pattern: "/api/filters", Handler: h.SavedFiltersHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/filters", Tag: "Invoices", Summary: "List the saved invoice filters of the signed-in user",
+				Description: "Filters are shared by everyone when authentication is disabled.", Response: []models.SavedFilter{}},
+			{Method: http.MethodPost, Path: "/api/filters", Tag: "Invoices", Summary: "Save an invoice filter",
+				Description: "The query holds the client_id, status and tag parameters of GET /api/invoices and is stored in a canonical form. Names are unique per user, ignoring case.",
+				Body:        savedFilterRequest{}, Response: models.SavedFilter{}, Success: []int{http.StatusCreated}, Errors: []int{http.StatusBadRequest, http.StatusConflict}},
+		}},
+		{Pattern: "/api/filters/", Handler: h.SavedFiltersHandler, Operations: []apiOperation{
+			{Method: http.MethodDelete, Path: "/api/filters/{id}", Tag: "Invoices", Summary: "Delete a saved invoice filter",
+				Params: []apiParam{idParam("Filter")}, Errors: []int{http.StatusNotFound}},
+		}},
+		{Pattern: "/api/invoices/similar", Handler: h.SimilarInvoicesHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/invoices/similar", Tag: "Invoices", Summary: "Find invoices that a new invoice may duplicate",
+				Description: "Lists the invoices and pro-forma invoices of the same type for the client in the same currency, with a total within 2% of total_amount, issued within 45 days of issue_date and, when both have a service period, for an overlapping period. " +
+					"The web interface asks for confirmation before creating an invoice that has any, so the client is not billed twice by accident.",
+				Params: []apiParam{
+					{Name: "client_id", In: "query", Type: "integer", Description: "Client of the new invoice", Required: true},
+					{Name: "total_amount", In: "query", Type: "string", Description: "Total of the new invoice, e.g. 1190.00", Required: true},
+					{Name: "currency", In: "query", Type: "string", Description: "Currency of the new invoice (default the invoice currency setting)"},
+					{Name: "issue_date", In: "query", Type: "string", Description: "Issue date, YYYY-MM-DD (default today)"},
+					{Name: "type", In: "query", Type: "string", Description: "Document type (default invoice)", Enum: models.InvoiceTypes},
+					{Name: "service_period_start", In: "query", Type: "string", Description: "Start of the service period, YYYY-MM-DD"},
+					{Name: "service_period_end", In: "query", Type: "string", Description: "End of the service period, YYYY-MM-DD"},
+					{Name: "id", In: "query", Type: "integer", Description: "Invoice being edited, which is left out"},
+				},
+				Response: []models.Invoice{}, Errors: []int{http.StatusBadRequest}},
+		}},
+		{Pattern: "/api/invoices/import", Handler: h.InvoiceImportHandler, Operations: []apiOperation{
+			{Method: http.MethodPost, Path: "/api/invoices/import", Tag: "Import", Summary: "Import historical invoices",
+				Form: []apiParam{
+					{Name: "file", Type: "binary", Description: "CSV export", Required: true},
+					{Name: "format", Type: "string", Description: "Export format (default generic)", Enum: []string{services.InvoiceImportGeneric, services.InvoiceImportInvoiceNinja, services.InvoiceImportWave}},
+					{Name: "dry_run", Type: "boolean", Description: "Validate without saving"},
+				},
+				Response: services.ImportResult{}, Errors: []int{http.StatusBadRequest, http.StatusUnsupportedMediaType}},
+		}},
+		{Pattern: "/api/invoices/batch", Handler: h.HoursInvoiceHandler, Operations: []apiOperation{
+			{Method: http.MethodPost, Path: "/api/invoices/batch", Tag: "Import", Summary: "Generate draft invoices from a CSV of hours",
+				Description: "Creates one draft invoice per row with the columns client (ID, VAT ID or name), hours, rate, description and period (2024-03 or 2024-03-01..2024-03-31). The invoices are created in one transaction: when a row fails, none is created and the valid rows are reported as skipped. Rows for a client and period that were already invoiced are reported as duplicates.",
+				Form: []apiParam{
+					{Name: "file", Type: "binary", Description: "CSV of hours", Required: true},
+					{Name: "issue_date", Type: "string", Description: "Issue date, YYYY-MM-DD (default today)"},
+					{Name: "business_id", Type: "integer", Description: "Issuing business (default the first)"},
+					{Name: "dry_run", Type: "boolean", Description: "Validate without saving"},
+				},
+				Response: services.ImportResult{}, Errors: []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnsupportedMediaType}},
+		}},
+		{Pattern: "/api/bank-statements/import", Handler: h.BankStatementImportHandler, Operations: []apiOperation{
+			{Method: http.MethodPost, Path: "/api/bank-statements/import", Tag: "Import", Summary: "Match the payments of a bank statement to open invoices",
+				Description: "Reads a CSV export, MT940 file or camt.053 statement and proposes, for each incoming payment, the sent invoice it pays: the invoice whose number is in the payment reference or, failing that, the invoice due for exactly the amount in the same currency. " +
+					"When several invoices are due for the amount, the one of the client named as the payer is proposed; otherwise the payment is ambiguous and lists the candidates. Each invoice is proposed once. Outgoing payments are skipped. Nothing is changed, confirm the matches with POST /api/bank-statements/reconcile.",
+				Form: []apiParam{
+					{Name: "file", Type: "binary", Description: "Bank statement", Required: true},
+					{Name: "format", Type: "string", Description: "Statement format (detected from the content by default)", Enum: services.StatementFormats()},
+				},
+				Response: services.StatementMatchResult{}, Errors: []int{http.StatusBadRequest, http.StatusUnsupportedMediaType}},
+		}},
+		{Pattern: "/api/bank-statements/reconcile", Handler: h.ReconcileHandler, Operations: []apiOperation{
+			{Method: http.MethodPost, Path: "/api/bank-statements/reconcile", Tag: "Import", Summary: "Mark the invoices of confirmed payments paid",
+				Description: "Marks each invoice paid on the paid date, today when empty. All invoices are checked before any is changed; invoices that are already paid are counted in already_paid and left alone. " +
+					"A payment from GET /api/bank-sync/matches is recorded as reconciled with its bank_transaction_id.",
+				Body: reconcileRequest{}, Response: reconcileResponse{}, Errors: []int{http.StatusBadRequest}},
+		}},
+		{Pattern: "/api/bank-sync", Handler: h.BankSyncHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/bank-sync", Tag: "Import", Summary: "List the banks connected for syncing payments",
+				Description: "configured is false until the GoCardless secret ID and key are set on the Settings page. A pending connection has a link where the account holder grants access at the bank.",
+				Response:    bankSyncStatus{}},
+			{Method: http.MethodPost, Path: "/api/bank-sync", Tag: "Import", Summary: "Sync the incoming payments of the connected banks now",
+				Description: "Updates the status of each connection and stores the incoming payments booked since its last sync, 90 days back the first time. Connections are also synced periodically. " +
+					"A connection that cannot be synced is counted in failed and has a last_error. Returns 503 with bank_sync_failed when GoCardless is not configured.",
+				Response: services.BankSyncResult{}, Errors: []int{http.StatusBadGateway, http.StatusServiceUnavailable}},
+		}},
+		{Pattern: "/api/bank-sync/institutions", Handler: h.BankInstitutionsHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/bank-sync/institutions", Tag: "Import", Summary: "List the banks of a country that can be connected",
+				Params:   []apiParam{{Name: "country", In: "query", Type: "string", Description: "ISO 3166-1 alpha-2 country code", Required: true}},
+				Response: []services.BankInstitution{}, Errors: []int{http.StatusBadRequest, http.StatusBadGateway, http.StatusServiceUnavailable}},
+		}},
+		{Pattern: "/api/bank-sync/connections", Handler: h.BankConnectionsHandler, Operations: []apiOperation{
+			{Method: http.MethodPost, Path: "/api/bank-sync/connections", Tag: "Import", Summary: "Connect a bank",
+				Description: "Requests access to the accounts at the bank. Open the link of the pending connection to grant it; the bank then returns to the reconciliation screen and the connection is linked on the next sync.",
+				Body:        bankConnectRequest{}, Response: models.BankConnection{}, Success: []int{http.StatusCreated},
+				Errors: []int{http.StatusBadRequest, http.StatusBadGateway, http.StatusServiceUnavailable}},
+		}},
+		{Pattern: "/api/bank-sync/connections/", Handler: h.BankConnectionsHandler, Operations: []apiOperation{
+			{Method: http.MethodDelete, Path: "/api/bank-sync/connections/{id}", Tag: "Import", Summary: "Disconnect a bank",
+				Description: "Revokes the access at GoCardless and removes the payments synced from the bank. Invoices reconciled with them stay paid.",
+				Params:      []apiParam{idParam("Bank connection")}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+		}},
+		{Pattern: "/api/bank-sync/matches", Handler: h.BankSyncMatchesHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/bank-sync/matches", Tag: "Import", Summary: "Match the synced payments to open invoices",
+				Description: "Matches the synced incoming payments of the last 90 days that were not reconciled yet, like POST /api/bank-statements/import does for a statement; the format is gocardless and each transaction has an id. " +
+					"Confirm the matches with POST /api/bank-statements/reconcile, passing the id as bank_transaction_id so the payment is not proposed again.",
+				Response: services.StatementMatchResult{}},
+		}},
+		{Pattern: "/api/accounting-sync", Handler: h.AccountingSyncHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/accounting-sync", Tag: "Accounting Sync", Summary: "Show the connected accounting software",
+				Description: "configured is false until the accounting software (xero or quickbooks) and its OAuth client ID and secret are set on the Settings page. connection is null until an organization is connected.",
+				Response:    accountingSyncStatus{}},
+			{Method: http.MethodPost, Path: "/api/accounting-sync", Tag: "Accounting Sync", Summary: "Push new and changed invoices to the accounting software now",
+				Description: "Pushes every invoice that is neither a draft nor a pro-forma invoice and is new or changed since it was last pushed, creating or updating its client first, and the payment of each paid invoice. " +
+					"Records are mapped to their remote IDs, so they are updated rather than duplicated; unmapped clients and invoices are looked up by name and number first. " +
+					"Invoices that cannot be pushed are counted in failed, with the error in their sync status, and retried on the next sync. The sync also runs periodically. " +
+					"Returns 503 with accounting_sync_failed when the accounting software is not configured or connected.",
+				Response: services.AccountingSyncResult{}, Errors: []int{http.StatusBadGateway, http.StatusServiceUnavailable}},
+		}},
+		{Pattern: "/api/accounting-sync/connection", Handler: h.AccountingConnectionHandler, Operations: []apiOperation{
+			{Method: http.MethodPost, Path: "/api/accounting-sync/connection", Tag: "Accounting Sync", Summary: "Start connecting the accounting software",
+				Description: "Returns the URL where the user grants access to an organization, valid for 15 minutes. The accounting software then sends the user to " + accountingCallbackPath + ", which completes the connection; register that URL as the redirect URI of the OAuth app.",
+				Response:    accountingConnectResponse{}, Errors: []int{http.StatusServiceUnavailable}},
+			{Method: http.MethodDelete, Path: "/api/accounting-sync/connection", Tag: "Accounting Sync", Summary: "Disconnect the accounting software",
+				Description: "Removes the tokens and forgets which records were pushed. The records stay in the accounting software; revoke the app's access there too.",
+				Errors:      []int{http.StatusNotFound}},
+		}},
+		{Pattern: paypalIPNPath, Handler: h.PayPalIPNHandler, Operations: []apiOperation{
+			{Method: http.MethodPost, Path: paypalIPNPath, Tag: "Invoices", Summary: "Receive a PayPal payment notification",
+				Description: "Set this URL as the Instant Payment Notification (IPN) URL of the PayPal account. It needs no authentication: each notification is posted back to PayPal, and only notifications PayPal confirms for payments to the configured account are accepted. " +
+					"A completed payment of the amount due in the invoice currency through the invoice's checkout link marks the invoice paid. Other notifications, such as pending or partial payments, are acknowledged with paid false and logged. " +
+					"Returns 503 with paypal_failed when PayPal checkout is not configured, and 502 when PayPal cannot be reached to verify the notification, so PayPal sends it again.",
+				FormType: "application/x-www-form-urlencoded",
+				Form: []apiParam{
+					{Name: "receiver_email", Type: "string", Description: "PayPal account that was paid", Required: true},
+					{Name: "payment_status", Type: "string", Description: "Completed once the payment is settled", Required: true},
+					{Name: "custom", Type: "string", Description: "Invoice ID, set by the checkout link", Required: true},
+					{Name: "invoice", Type: "string", Description: "Invoice number, set by the checkout link", Required: true},
+					{Name: "mc_gross", Type: "string", Description: "Amount paid", Required: true},
+					{Name: "mc_currency", Type: "string", Description: "Currency paid", Required: true},
+					{Name: "txn_id", Type: "string", Description: "PayPal transaction ID"},
+				},
+				Response: paypalIPNResponse{}, Errors: []int{http.StatusBadRequest, http.StatusBadGateway, http.StatusServiceUnavailable}},
+		}},
+		{Pattern: "/api/invoices/quick", Handler: h.QuickInvoiceHandler, Operations: []apiOperation{
+			{Method: http.MethodPost, Path: "/api/invoices/quick", Tag: "Invoices", Summary: "Create, generate and send an invoice of hours in one request",
+				Description: "Creates a draft invoice for the client with one line of hours at the hourly rate, numbered in the invoice sequence and with the due date, VAT rate and notes of the settings. Clients in another country than the business are invoiced with reverse charge VAT. " +
+					"The PDF is generated before the response, and url is a signed link to it that expires after a day. " +
+					"With send the invoice is emailed like POST /api/invoices/{id}/send, to the client's email address or to; the address is checked before the invoice is created. " +
+					"Once the invoice is created the request succeeds: when its email cannot be queued, email.job_id is 0 and email.message says why.",
+				Body: quickInvoiceRequest{}, Response: quickInvoiceResponse{}, Success: []int{http.StatusCreated},
+				Errors: []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusBadGateway}},
+		}},
+		{Pattern: "/api/invoices/generate-pdf/", Handler: h.GeneratePDFHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/invoices/generate-pdf/{id}", Tag: "Invoices", Summary: "Generate the PDF of an invoice",
+				Description: "url and share_url are signed links to /invoices/pdf/{id}; url expires after a day, share_url opens the PDF without signing in for 30 days. Add download=1 to download the PDF instead of opening it.",
+				Params:      []apiParam{idParam("Invoice")}, Response: pdfResponse{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+		}},
+		{Pattern: "/api/invoices/preview-pdf", Handler: h.PreviewPDFHandler, Operations: []apiOperation{
+			{Method: http.MethodPost, Path: "/api/invoices/preview-pdf", Tag: "Invoices", Summary: "Render a PDF preview of an unsaved invoice",
+				Description: "Saves the preview and returns a signed link to it that expires after a day. With stream=true the PDF is returned in the response instead (application/pdf) and nothing is saved.",
+				Params:      []apiParam{{Name: "stream", In: "query", Type: "boolean", Description: "Return the PDF in the response instead of a link"}},
+				Body:        previewRequest{}, Response: previewResponse{}, Errors: []int{http.StatusBadRequest}},
+		}},
+		{Pattern: "/api/upload/logo", Handler: h.UploadLogoHandler, Operations: []apiOperation{
+			{Method: http.MethodPost, Path: "/api/upload/logo", Tag: "Business", Summary: "Upload the business logo",
+				Form:     []apiParam{{Name: "logo", Type: "binary", Description: "PNG, JPEG or GIF image", Required: true}},
+				Response: logoResponse{}, Errors: []int{http.StatusBadRequest, http.StatusUnsupportedMediaType}},
+			{Method: http.MethodDelete, Path: "/api/upload/logo", Tag: "Business", Summary: "Remove the business logo", Response: logoRemovedResponse{}},
+		}},
+		{Pattern: "/api/backups", Handler: h.BackupsAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/backups", Tag: "Backups", Summary: "List backups", Response: []services.BackupInfo{}},
+			{Method: http.MethodPost, Path: "/api/backups", Tag: "Backups", Summary: "Create a backup", Response: backupCreatedResponse{}},
+			{Method: http.MethodDelete, Path: "/api/backups", Tag: "Backups", Summary: "Delete a backup",
+				Params: []apiParam{{Name: "filename", In: "query", Type: "string", Required: true}}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+		}},
+		{Pattern: "/api/backups/restore", Handler: h.RestoreBackupHandler, Operations: []apiOperation{
+			{Method: http.MethodPost, Path: "/api/backups/restore", Tag: "Backups", Summary: "Restore the database from a backup",
+				Params: []apiParam{{Name: "filename", In: "query", Type: "string", Required: true}}, Errors: []int{http.StatusBadRequest, http.StatusNotFound}},
+		}},
+		{Pattern: "/api/jobs", Handler: h.JobsAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/jobs", Tag: "Jobs", Summary: "List background jobs",
+				Params: []apiParam{
+					{Name: "status", In: "query", Type: "string", Enum: []string{services.JobStatusPending, services.JobStatusRunning, services.JobStatusDone, services.JobStatusFailed}},
+					limitParam,
+				},
+				Response: []models.Job{}},
+		}},
+		{Pattern: "/api/jobs/", Handler: h.JobByIDHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/jobs/{id}", Tag: "Jobs", Summary: "Get a job",
+				Params: []apiParam{idParam("Job")}, Response: models.Job{}, Errors: []int{http.StatusNotFound}},
+			{Method: http.MethodDelete, Path: "/api/jobs/{id}", Tag: "Jobs", Summary: "Delete a job",
+				Params: []apiParam{idParam("Job")}, Errors: []int{http.StatusBadRequest}},
+			{Method: http.MethodPost, Path: "/api/jobs/{id}/retry", Tag: "Jobs", Summary: "Retry a failed job",
+				Params: []apiParam{idParam("Job")}, Errors: []int{http.StatusBadRequest}},
+		}},
+		{Pattern: "/api/logs", Handler: h.LogsAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/logs", Tag: "Logs", Summary: "Tail the recent log entries",
+				Description: "Returns the most recent entries kept in memory, oldest first. Poll with after set to the seq of the last entry to get only new ones. " +
+					"Not available to API tokens.",
+				Params: []apiParam{
+					{Name: "level", In: "query", Type: "string", Description: "Minimum level", Enum: []string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"}},
+					{Name: "after", In: "query", Type: "integer", Description: "Only entries with a greater seq"},
+					{Name: "limit", In: "query", Type: "integer", Description: "Maximum number of entries to return, the most recent ones (default 200, at most 1000)"},
+				},
+				Response: []services.LogEntry{}, Errors: []int{http.StatusBadRequest}},
+		}},
+		{Pattern: "/api/logs/level", Handler: h.LogLevelHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/logs/level", Tag: "Logs", Summary: "Get the log level",
+				Response: logLevelRequest{}},
+			{Method: http.MethodPut, Path: "/api/logs/level", Tag: "Logs", Summary: "Change the log level",
+				Body: logLevelRequest{}, Response: logLevelRequest{}, Errors: []int{http.StatusBadRequest},
+				Description: "Saved like the log level on the settings page and applied at once, without a restart. Not available to API tokens."},
+		}},
+		{Pattern: "/api/events", Handler: h.EventsHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/events", Tag: "Jobs", Summary: "Stream live updates as server-sent events",
+				Description: "Sends an invoice_status event ({id, invoice_number, status, paid_date}) when the status of an invoice changes, " +
+					"pdf_generated ({invoice_id, invoice_number}) when a queued PDF generation finished, and backup_created ({filename}) after a backup. " +
+					"Events are not replayed: a client that reconnects should reload what it shows.",
+				ResponseType: "text/event-stream"},
+		}},
+		{Pattern: "/api/audit-log", Handler: h.AuditLogAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/audit-log", Tag: "Audit Log", Summary: "List audit log entries",
+				Params: []apiParam{
+					{Name: "entity_type", In: "query", Type: "string", Description: "Only entries for this entity type, e.g. client"},
+					{Name: "entity_id", In: "query", Type: "integer", Description: "Only entries for this entity"},
+					limitParam,
+				},
+				Response: []models.AuditEntry{}, Errors: []int{http.StatusBadRequest}},
+		}},
+		{Pattern: "/api/settings", Handler: h.SettingsAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/settings", Tag: "Settings", Summary: "List settings", Description: "Secret values are never returned; masked shows the last characters of long secrets.",
+				Response: []settingResponse{}},
+			{Method: http.MethodPost, Path: "/api/settings", Tag: "Settings", Summary: "Save settings",
+				Description: "Takes an object of setting keys and values. Empty secret values keep the current secret. Secrets are stored encrypted when a secrets key is configured.",
+				Body:        map[string]string{}, Response: []settingResponse{}, Errors: []int{http.StatusBadRequest}},
+		}},
+		{Pattern: "/api/email-templates", Handler: h.EmailTemplatesAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/email-templates", Tag: "Email Templates", Summary: "List email templates",
+				Description: "Returns the customized templates and the built-in English template of every kind that has not been customized.",
+				Response:    []models.EmailTemplate{}},
+			{Method: http.MethodPost, Path: "/api/email-templates", Tag: "Email Templates", Summary: "Save an email template",
+				Description: "Subject and body may use {{variable}} placeholders: " + strings.Join(services.EmailTemplateVariables, ", ") + ".",
+				Body:        models.EmailTemplate{}, Response: models.EmailTemplate{}, Errors: []int{http.StatusBadRequest}},
+			{Method: http.MethodDelete, Path: "/api/email-templates", Tag: "Email Templates", Summary: "Delete a customized email template",
+				Params: []apiParam{
+					{Name: "kind", In: "query", Type: "string", Required: true, Enum: services.EmailTemplateKinds},
+					{Name: "language", In: "query", Type: "string", Required: true},
+				},
+				Errors: []int{http.StatusNotFound}},
+		}},
+		{Pattern: "/api/reverse-charge-clauses", Handler: h.ReverseChargeClausesAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/reverse-charge-clauses", Tag: "Reverse Charge", Summary: "List reverse charge clauses",
+				Description: "Returns the clauses customized per client country and the built-in clause of every language.",
+				Response:    []models.ReverseChargeClause{}},
+			{Method: http.MethodPost, Path: "/api/reverse-charge-clauses", Tag: "Reverse Charge", Summary: "Save the reverse charge clause of a country",
+				Description: "Reverse-charge invoices of clients in the country print this text instead of the built-in clause in the client's language.",
+				Body:        models.ReverseChargeClause{}, Response: models.ReverseChargeClause{}, Errors: []int{http.StatusBadRequest}},
+			{Method: http.MethodDelete, Path: "/api/reverse-charge-clauses", Tag: "Reverse Charge", Summary: "Delete the customized reverse charge clause of a country",
+				Params: []apiParam{{Name: "country", In: "query", Type: "string", Required: true, Description: "Two-letter country code, e.g. DE"}},
+				Errors: []int{http.StatusNotFound}},
+		}},
+		{Pattern: "/api/compliance-profiles", Handler: h.ComplianceProfilesAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/compliance-profiles", Tag: "Compliance", Summary: "List compliance profiles",
+				Description: "Returns the profiles customized per business country and the built-in profiles of DE, FR, RO, PL, IT, ES and GB that were not customized. " +
+					"Businesses in other countries follow no profile.",
+				Response: []models.ComplianceProfile{}},
+			{Method: http.MethodPost, Path: "/api/compliance-profiles", Tag: "Compliance", Summary: "Save the compliance profile of a country",
+				Description: "Replaces the built-in profile of the country, if any. required_fields are checked when an invoice is finalized: " + strings.Join(models.ComplianceFields, ", ") + ". " +
+					"clauses are printed on every invoice. With gapless_numbering finalized invoices cannot be deleted. " +
+					"number_max_length and number_pattern (a regular expression) restrict invoice numbers. " +
+					"export_formats enables the e-invoicing formats of the country: " + strings.Join(models.ComplianceFormats, ", ") + ".",
+				Body: models.ComplianceProfile{}, Response: models.ComplianceProfile{}, Errors: []int{http.StatusBadRequest}},
+			{Method: http.MethodDelete, Path: "/api/compliance-profiles", Tag: "Compliance", Summary: "Delete the customized compliance profile of a country",
+				Description: "The built-in profile of the country, if any, applies again.",
+				Params:      []apiParam{{Name: "country", In: "query", Type: "string", Required: true, Description: "Two-letter country code, e.g. DE"}},
+				Errors:      []int{http.StatusNotFound}},
+		}},
+		{Pattern: "/api/expense-rates", Handler: h.ExpenseRatesAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/expense-rates", Tag: "Invoices", Summary: "List the mileage and per-diem rates",
+				Description: "Returns the rates customized per country and the built-in rates of AT, DE, GB and NL that were not customized. " +
+					"The invoice form fills in the price of mileage and per-diem items from the rates of the country travelled in.",
+				Response: []models.ExpenseRates{}},
+			{Method: http.MethodPost, Path: "/api/expense-rates", Tag: "Invoices", Summary: "Save the mileage and per-diem rates of a country",
+				Description: "Replaces the built-in rates of the country, if any. mileage_rate is per km and per_diem for a full day, both in currency. " +
+					"With vat_exempt mileage and per diems of the country are billed outside the VAT base.",
+				Body: models.ExpenseRates{}, Response: models.ExpenseRates{}, Errors: []int{http.StatusBadRequest}},
+			{Method: http.MethodDelete, Path: "/api/expense-rates", Tag: "Invoices", Summary: "Delete the customized rates of a country",
+				Description: "The built-in rates of the country, if any, apply again.",
+				Params:      []apiParam{{Name: "country", In: "query", Type: "string", Required: true, Description: "Two-letter country code, e.g. DE"}},
+				Errors:      []int{http.StatusNotFound}},
+		}},
+		{Pattern: "/api/reports", Handler: h.ReportsAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/reports", Tag: "Reports", Summary: "Get the revenue of a year by month and currency",
+				Description: "On the accrual basis invoices count in the month of their issue date. On the cash basis paid invoices count in the month of their paid_date, " +
+					"the credit applied to them is deducted, and client prepayments count in the month they were received. Pro-forma invoices are not counted.",
+				Params: []apiParam{
+					{Name: "basis", In: "query", Type: "string", Description: "Accounting basis, the report.basis setting by default", Enum: services.ReportBases},
+					{Name: "year", In: "query", Type: "integer", Description: "Year, the current year by default"}},
+				Response: models.Report{}, Errors: []int{http.StatusBadRequest}},
+		}},
+		{Pattern: "/api/export", Handler: h.ExportAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/export", Tag: "Reports", Summary: "Export the invoices of a period for an accountant",
+				Description: "csv lists the invoices issued in the period, including pro-forma invoices, or all invoices without a period. " +
+					"datev is a DATEV booking batch (EXTF Buchungsstapel) in the Windows-1252 encoding, with the finalized invoices issued and the payments received in the period, which must lie within one year. " +
+					"saft is an OECD SAF-T 2.0 audit file with the same invoices and payments, their clients and VAT rates. Both need from and to.",
+				Params: []apiParam{
+					{Name: "format", In: "query", Type: "string", Description: "Export format, csv by default", Enum: services.ExportFormats},
+					{Name: "from", In: "query", Type: "string", Description: "First issue or payment date, YYYY-MM-DD"},
+					{Name: "to", In: "query", Type: "string", Description: "Last issue or payment date, YYYY-MM-DD"}},
+				ResponseType: "text/csv", Errors: []int{http.StatusBadRequest}},
+		}},
+		{Pattern: "/api/closings", Handler: h.ClosingsAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/closings", Tag: "Reports", Summary: "List closed fiscal years", Response: []models.YearClosing{}},
+			{Method: http.MethodPost, Path: "/api/closings", Tag: "Reports", Summary: "Close a fiscal year",
+				Description: "Locks the invoices issued in the year: they can no longer be created, changed, deleted or credited, only their status can change. " +
+					"The invoice numbers of the year must form a sequence without gaps; otherwise the request fails with sequence_gaps and the numbering check as details, " +
+					"unless acknowledge_gaps is set. A summary PDF listing the invoices of the year is generated. Only past years can be closed, and closing cannot be undone.",
+				Body: closeYearRequest{}, Response: models.YearClosing{}, Errors: []int{http.StatusBadRequest, http.StatusConflict}},
+		}},
+		{Pattern: "/api/closings/", Handler: h.ClosingsAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/closings/{year}/check", Tag: "Reports", Summary: "Check the invoice numbering of a year",
+				Description: "Lists the numbers missing from the generated sequence, with the audit log entries of those that were deleted or renumbered, numbers used twice, and the invoices issued in the year with manually entered numbers.",
+				Params:      []apiParam{yearParam}, Response: models.SequenceCheck{}, Errors: []int{http.StatusBadRequest}},
+			{Method: http.MethodGet, Path: "/api/closings/{year}/pdf", Tag: "Reports", Summary: "Download the closing summary of a year",
+				Params: []apiParam{yearParam}, ResponseType: "application/pdf", Errors: []int{http.StatusNotFound}},
+		}},
+		{Pattern: "/api/diagnostics/numbering", Handler: h.NumberingDiagnosticsAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/diagnostics/numbering", Tag: "Reports", Summary: "Find gaps and duplicates in the invoice numbering",
+				Description: "Checks the invoice numbers of every year, most recent first. Missing numbers are explained by the audit log entries of invoices that were deleted (including drafts) or renumbered; " +
+					"numbers without an entry were never used or disappeared without a trace, e.g. when restoring an older backup. " +
+					"gaps lists the runs of missing numbers and missing the numbers themselves, at most 100 of each; missing_count counts them all. Numbers more than 1000 beyond the number of invoices are listed as unsequenced.",
+				Params:   []apiParam{{Name: "year", In: "query", Type: "integer", Description: "Only check this year"}},
+				Response: []models.SequenceCheck{}, Errors: []int{http.StatusBadRequest}},
+		}},
+		{Pattern: "/api/auth/me", Handler: h.CurrentUserAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/auth/me", Tag: "Authentication", Summary: "Get the signed-in user",
+				Description: "The user is null when authentication is disabled.", Response: currentUserResponse{}},
+		}},
+		{Pattern: "/api/auth/sessions", Handler: h.SessionsAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/auth/sessions", Tag: "Authentication", Summary: "List the sessions of the signed-in user",
+				Description: "Sessions are OIDC sign-ins, most recently active first, with the address of the last request and the browser. " +
+					"The list is empty with other authentication modes.",
+				Response: []models.Session{}},
+		}},
+		{Pattern: "/api/auth/sessions/", Handler: h.SessionsAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodDelete, Path: "/api/auth/sessions/{id}", Tag: "Authentication", Summary: "Revoke a session",
+				Description: "Signs the browser of the session out. Revoking the current session signs out.",
+				Params:      []apiParam{{Name: "id", In: "path", Type: "string", Description: "Session ID", Required: true}},
+				Errors:      []int{http.StatusNotFound}},
+		}},
+		{Pattern: "/api/tokens", Handler: h.TokensAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/tokens", Tag: "Authentication", Summary: "List API tokens",
+				Description: "Revoked tokens are listed last. Token secrets are never returned.", Response: []models.APIToken{}},
+			{Method: http.MethodPost, Path: "/api/tokens", Tag: "Authentication", Summary: "Create an API token",
+				Description: "The token acts as the signed-in user, limited to its scopes: read allows GET requests and GraphQL queries, " +
+					"invoices:write also allows changes, and backups:admin allows the backup endpoints. " +
+					"The secret is only returned in this response; only its hash is stored. Tokens cannot manage tokens or change settings.",
+				Body: createTokenRequest{}, Response: createTokenResponse{}, Success: []int{http.StatusCreated}, Errors: []int{http.StatusBadRequest}},
+		}},
+		{Pattern: "/api/tokens/", Handler: h.TokensAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodDelete, Path: "/api/tokens/{id}", Tag: "Authentication", Summary: "Revoke an API token",
+				Params: []apiParam{idParam("Token")}, Errors: []int{http.StatusNotFound}},
+		}},
+		{Pattern: "/api/openapi.json", Handler: h.OpenAPIHandler, Operations: []apiOperation{
+			{Method: http.MethodGet, Path: "/api/openapi.json", Tag: "Documentation", Summary: "Get this OpenAPI document", Response: map[string]interface{}{}},
+		}},
+	}
+}
+
+// apiTokenDescription explains how scripts authenticate, in the OpenAPI document
+const apiTokenDescription = "When authentication is enabled, scripts send an API token created on the settings page as Authorization: Bearer <token>."
+
+// OpenAPIHandler serves the OpenAPI 3 document of the JSON API
+func (h *AppHandler) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildOpenAPI(h.apiEndpoints(), h.version))
+}
+
+// APIDocsHandler serves Swagger UI for the OpenAPI document
+func (h *AppHandler) APIDocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(apiDocsPage))
+}
+
+// swaggerUIAssets are the Swagger UI files served under /api/docs/ with the
+// Subresource Integrity hash the docs page expects. They are Swagger UI
+// v5.32.8 as embedded by github.com/swaggest/swgui, so the version is pinned
+// by go.sum and no CDN is involved.
+var swaggerUIAssets = map[string]struct {
+	contentType string
+	integrity   string
+}{
+	"swagger-ui.css":       {"text/css; charset=utf-8", "sha384-9Q2fpS+xeS4ffJy6CagnwoUl+4ldAYhOs9pgZuEKxypVModhmZFzeMlvVsAjf7uT"},
+	"swagger-ui-bundle.js": {"text/javascript; charset=utf-8", "sha384-IKpAWwsTL0pcw7/Amtnt2eXF4P1BK64WNuY2E/RG15SWLUW5HXzFuyqCSAr/DP8C"},
+}
+
+// APIDocsAssetHandler serves the Swagger UI files of the docs page. They are
+// stored gzipped and sent as they are to clients that accept gzip.
+func (h *AppHandler) APIDocsAssetHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/api/docs/")
+	asset, ok := swaggerUIAssets[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	compressed, err := swaggerui.FS.ReadFile(name + ".gz")
+	if err != nil {
+		h.writeInternalError(w, "Failed to read Swagger UI", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", asset.contentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Add("Vary", "Accept-Encoding")
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed)
+		return
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		h.writeInternalError(w, "Failed to read Swagger UI", err)
+		return
+	}
+	io.Copy(w, reader)
+}
+
+var apiDocsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>Simple Invoice API</title>
+    <link rel="stylesheet" href="/api/docs/swagger-ui.css" integrity="` + swaggerUIAssets["swagger-ui.css"].integrity + `">
+</head>
+<body>
+    <div id="swagger-ui"></div>
+    <script src="/api/docs/swagger-ui-bundle.js" integrity="` + swaggerUIAssets["swagger-ui-bundle.js"].integrity + `"></script>
+    <script>
+        window.ui = SwaggerUIBundle({ url: '/api/openapi.json', dom_id: '#swagger-ui' });
+    </script>
+</body>
+</html>
+`
+
+// buildOpenAPI generates the OpenAPI document from the endpoint registry.
+// Schemas are derived from the Go types and their JSON tags.
+func buildOpenAPI(endpoints []apiEndpoint, version string) map[string]interface{} {
+	schemas := &openAPISchemas{components: map[string]interface{}{}}
+	paths := map[string]map[string]interface{}{}
+
+	for _, endpoint := range endpoints {
+		for _, op := range endpoint.Operations {
+			operation := map[string]interface{}{
+				"tags":        []string{op.Tag},
+				"summary":     op.Summary,
+				"operationId": operationID(op),
+				"responses":   operationResponses(op, schemas),
+			}
+			if op.Description != "" {
+				operation["description"] = op.Description
+			}
+
+			opParams := op.Params
+			if op.Paged {
+				opParams = append(append([]apiParam{}, op.Params...), pageParams...)
+			}
+			if len(opParams) > 0 {
+				params := make([]map[string]interface{}, 0, len(opParams))
+				for _, p := range opParams {
+					param := map[string]interface{}{
+						"name":     p.Name,
+						"in":       p.In,
+						"required": p.Required,
+						"schema":   paramSchema(p),
+					}
+					if p.Description != "" {
+						param["description"] = p.Description
+					}
+					params = append(params, param)
+				}
+				operation["parameters"] = params
+			}
+
+			if op.Body != nil {
+				operation["requestBody"] = map[string]interface{}{
+					"required": true,
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": schemas.schemaOf(reflect.TypeOf(op.Body))},
+					},
+				}
+			} else if len(op.Form) > 0 {
+				properties := map[string]interface{}{}
+				var required []string
+				for _, field := range op.Form {
+					properties[field.Name] = paramSchema(field)
+					if field.Required {
+						required = append(required, field.Name)
+					}
+				}
+				operation["requestBody"] = map[string]interface{}{
+					"required": true,
+					"content": map[string]interface{}{
+						cmp.Or(op.FormType, "multipart/form-data"): map[string]interface{}{
+							"schema": map[string]interface{}{"type": "object", "properties": properties, "required": required},
+						},
+					},
+				}
+			}
+
+			if paths[op.Path] == nil {
+				paths[op.Path] = map[string]interface{}{}
+			}
+			paths[op.Path][strings.ToLower(op.Method)] = operation
+		}
+	}
+
+	schemas.components["Error"] = map[string]interface{}{
+		"type":     "object",
+		"required": []string{"code", "message"},
+		"properties": map[string]interface{}{
+			"code":    map[string]interface{}{"type": "string", "enum": errorCodes},
+			"message": map[string]interface{}{"type": "string"},
+			"details": map[string]interface{}{"type": "object", "description": "Additional data, such as the current record of a version conflict"},
+		},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Simple Invoice API",
+			"version":     version,
+			"description": "JSON API used by the Simple Invoice web interface. Amounts are decimal numbers with two decimal places. " + apiTokenDescription,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]interface{}{
+				"apiToken": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "API token with the scopes the request needs"},
+			},
+		},
+	}
+}
+
+func operationResponses(op apiOperation, schemas *openAPISchemas) map[string]interface{} {
+	var content map[string]interface{}
+	switch {
+	case op.ResponseType != "":
+		content = map[string]interface{}{op.ResponseType: map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}}}
+	case op.Response != nil:
+		content = map[string]interface{}{"application/json": map[string]interface{}{"schema": schemas.schemaOf(reflect.TypeOf(op.Response))}}
+	default:
+		content = map[string]interface{}{"application/json": map[string]interface{}{"schema": schemas.schemaOf(reflect.TypeOf(messageResponse{}))}}
+	}
+
+	responses := map[string]interface{}{}
+	for _, status := range successStatuses(op) {
+		ok := map[string]interface{}{"description": http.StatusText(status), "content": content}
+		if op.Paged {
+			ok["headers"] = map[string]interface{}{
+				totalCountHeader: map[string]interface{}{
+					"description": "Number of entries before limit and offset are applied",
+					"schema":      map[string]interface{}{"type": "integer"},
+				},
+			}
+		}
+		responses[strconv.Itoa(status)] = ok
+	}
+	errorContent := map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"}}}
+	statuses := append([]int{}, op.Errors...)
+	if op.Body != nil || len(op.Form) > 0 {
+		statuses = append(statuses, http.StatusRequestEntityTooLarge)
+	}
+	for _, status := range statuses {
+		responses[strconv.Itoa(status)] = map[string]interface{}{"description": http.StatusText(status), "content": errorContent}
+	}
+	responses["500"] = map[string]interface{}{"description": http.StatusText(http.StatusInternalServerError), "content": errorContent}
+	return responses
+}
+
+// successStatuses returns the statuses of successful responses to an operation
+func successStatuses(op apiOperation) []int {
+	if len(op.Success) == 0 {
+		return []int{http.StatusOK}
+	}
+	return op.Success
+}
+
+// operationID derives a stable operation ID such as getApiClientsId
+func operationID(op apiOperation) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(op.Method))
+	for _, part := range strings.FieldsFunc(op.Path, func(r rune) bool { return r == '/' || r == '-' || r == '{' || r == '}' || r == '.' }) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+func paramSchema(p apiParam) map[string]interface{} {
+	schema := map[string]interface{}{"type": p.Type}
+	if p.Type == "binary" {
+		schema = map[string]interface{}{"type": "string", "format": "binary"}
+	}
+	if len(p.Enum) > 0 {
+		schema["enum"] = p.Enum
+	}
+	if p.In == "" && p.Description != "" {
+		schema["description"] = p.Description
+	}
+	return schema
+}
+
+// openAPISchemas collects the named schemas referenced by the document
+type openAPISchemas struct {
+	components map[string]interface{}
+}
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	moneyType = reflect.TypeOf(models.Money(0))
+	rawType   = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaOf returns the schema of t. Named structs become components and are
+// referenced by name.
+func (s *openAPISchemas) schemaOf(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case moneyType:
+		return map[string]interface{}{"type": "number", "multipleOf": 0.01, "example": 118.99}
+	case rawType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := s.schemaOf(t.Elem())
+		if _, ok := schema["$ref"]; ok {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": s.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := s.components[t.Name()]; !ok {
+			s.components[t.Name()] = map[string]interface{}{} // Placeholder for recursive types
+			s.components[t.Name()] = s.structSchema(t)
+		}
+		return ref
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func (s *openAPISchemas) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	s.addFields(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// addFields adds the JSON properties of t, flattening embedded structs like encoding/json
+func (s *openAPISchemas) addFields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			s.addFields(field.Type, properties)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.schemaOf(field.Type)
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// totalCountHeader is the response header with the number of entries of a
+// paged list before limit and offset are applied
+const totalCountHeader = "X-Total-Count"
+
+// pageParams are the query parameters of list endpoints that can be paged
+var pageParams = []apiParam{
+	{Name: "limit", In: "query", Type: "integer", Description: "Maximum number of entries to return; all entries when not set"},
+	{Name: "offset", In: "query", Type: "integer", Description: "Number of entries to skip (default 0)"},
+}
+
+// paginate returns the page of items selected by the limit and offset query
+// parameters, and sets X-Total-Count to the number of items
+func paginate[T any](w http.ResponseWriter, r *http.Request, items []T) ([]T, error) {
+	query := r.URL.Query()
+	offset, limit := 0, len(items)
+	if raw := query.Get("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid offset %q, expected a number of at least 0", raw)
+		}
+		offset = n
+	}
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid limit %q, expected a number of at least 1", raw)
+		}
+		limit = n
+	}
+
+	w.Header().Set(totalCountHeader, strconv.Itoa(len(items)))
+	if offset >= len(items) {
+		return []T{}, nil
+	}
+	end := len(items)
+	if limit < end-offset {
+		end = offset + limit
+	}
+	return items[offset:end], nil
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// paypalIPNPath receives the payment notifications of PayPal checkout links.
+// PayPal cannot sign in, so the notifications are verified with PayPal instead.
+const paypalIPNPath = "/api/paypal/ipn"
+
+// paypalIPNResponse tells what a payment notification changed
+type paypalIPNResponse struct {
+	Message   string `json:"message"`
+	InvoiceID int    `json:"invoice_id,omitempty"`
+	Paid      bool   `json:"paid"` // Whether the invoice was marked paid
+}
+
+// PayPalIPNHandler marks an invoice paid when PayPal notifies that its
+// checkout link was paid in full. The notification is posted back to PayPal
+// to verify it before anything is changed. Notifications that do not pay an
+// open invoice, such as pending or partial payments, are acknowledged so PayPal
+// stops sending them, and left for review in the log.
+// Route: POST /api/paypal/ipn
+func (h *AppHandler) PayPalIPNHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+	receiver := h.paypalService.Email()
+	if receiver == "" {
+		h.writeError(w, http.StatusServiceUnavailable, errCodePayPalFailed, "PayPal checkout is not configured on the Settings page", nil)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeBodyError(w, "Failed to read notification", err)
+		return
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid notification: %v", err), nil)
+		return
+	}
+
+	if err := h.paypalService.VerifyNotification(body); err != nil {
+		if errors.Is(err, services.ErrPayPalNotificationInvalid) {
+			h.logger.Warn("Rejected a PayPal notification that PayPal did not send, from %s", h.authService.ClientIP(r))
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, err.Error(), nil)
+			return
+		}
+		// PayPal sends the notification again later
+		h.logger.Error("Failed to verify PayPal notification: %v", err)
+		h.writeError(w, http.StatusBadGateway, errCodePayPalFailed, err.Error(), nil)
+		return
+	}
+
+	// A verified notification can still be for a payment to another account
+	if payee := cmp.Or(values.Get("receiver_email"), values.Get("business")); !strings.EqualFold(payee, receiver) {
+		h.logger.Warn("Ignored PayPal payment %s to %s", values.Get("txn_id"), payee)
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "The payment was not made to the configured PayPal account", nil)
+		return
+	}
+
+	response := paypalIPNResponse{}
+	ignore := func(message string) {
+		h.logger.Warn("PayPal payment %s: %s", values.Get("txn_id"), message)
+		response.Message = message
+		json.NewEncoder(w).Encode(response)
+	}
+
+	if status := values.Get("payment_status"); status != "Completed" {
+		ignore(fmt.Sprintf("payment status is %q, invoices are marked paid when it is completed", status))
+		return
+	}
+	response.InvoiceID, _ = strconv.Atoi(values.Get("custom"))
+	invoice, _, err := h.invoices.GetInvoice(response.InvoiceID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			response.InvoiceID = 0
+			ignore(fmt.Sprintf("no invoice found for invoice ID %q", values.Get("custom")))
+			return
+		}
+		h.writeInternalError(w, "Failed to load invoice", err)
+		return
+	}
+	if invoice.InvoiceNumber != values.Get("invoice") {
+		ignore(fmt.Sprintf("invoice number %q does not match invoice %s", values.Get("invoice"), invoice.InvoiceNumber))
+		return
+	}
+	if invoice.Status == models.InvoiceStatusPaid {
+		response.Message = fmt.Sprintf("Invoice %s is already paid", invoice.InvoiceNumber)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	amount, err := models.ParseMoney(values.Get("mc_gross"))
+	if err != nil || values.Get("mc_currency") != invoice.Currency || amount < invoice.AmountDue() {
+		ignore(fmt.Sprintf("%s %s paid for invoice %s, which is due for %s %s; mark it paid yourself once it is settled",
+			values.Get("mc_gross"), values.Get("mc_currency"), invoice.InvoiceNumber, invoice.AmountDue(), invoice.Currency))
+		return
+	}
+
+	if err := h.markInvoicePaid(invoice, time.Time{}); err != nil {
+		h.writeInternalError(w, fmt.Sprintf("Failed to mark invoice %s paid", invoice.InvoiceNumber), err)
+		return
+	}
+	h.logger.Info("Invoice %s was paid through PayPal (transaction %s)", invoice.InvoiceNumber, values.Get("txn_id"))
+	response.Paid = true
+	response.Message = fmt.Sprintf("Invoice %s marked paid", invoice.InvoiceNumber)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// timeEntryRequest is the body of POST /api/projects/{id}/time-entries
+type timeEntryRequest struct {
+	Date        string  `json:"date"` // YYYY-MM-DD
+	Hours       float64 `json:"hours"`
+	Description string  `json:"description,omitempty"`
+}
+
+// billTimeResponse is the response of POST /api/invoices/{id}/time-entries
+type billTimeResponse struct {
+	Hours       float64            `json:"hours"` // Total hours billed on the invoice
+	TimeEntries []models.TimeEntry `json:"time_entries"`
+}
+
+// ProjectsHandler handles the projects page with the profitability of every project
+func (h *AppHandler) ProjectsHandler(w http.ResponseWriter, r *http.Request) {
+	summaries, err := h.projectService.Summaries()
+	if err != nil {
+		h.writeInternalError(w, "Failed to load projects", err)
+		return
+	}
+
+	clients, err := h.clients.GetClients()
+	if err != nil {
+		h.writeInternalError(w, "Failed to load clients", err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Title":       "Projects",
+		"Projects":    summaries,
+		"Clients":     clients,
+		"Today":       time.Now().Format("2006-01-02"),
+		"CurrentYear": time.Now().Year(),
+	}
+
+	h.renderTemplate(w, "projects", data)
+}
+
+// ProjectsAPIHandler handles /api/projects and /api/projects/{id} with its
+// time entries
+func (h *AppHandler) ProjectsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/projects"), "/")
+	if rest == "" {
+		h.projectsHandler(w, r)
+		return
+	}
+
+	idStr, subresource, _ := strings.Cut(rest, "/")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid project ID format: %s", idStr), nil)
+		return
+	}
+	project, err := h.projectService.Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Project not found with ID: %d", id), nil)
+		return
+	}
+	if err != nil {
+		h.writeInternalError(w, "Failed to load project", err)
+		return
+	}
+
+	if subresource == "time-entries" || strings.HasPrefix(subresource, "time-entries/") {
+		h.projectTimeEntriesHandler(w, r, project, strings.TrimPrefix(subresource, "time-entries"))
+		return
+	}
+	if subresource != "" {
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, "Not found", nil)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(project)
+
+	case http.MethodDelete:
+		if err := h.projectService.Delete(id); err != nil {
+			h.writeInternalError(w, "Failed to delete project", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(messageResponse{Message: "Project deleted successfully"})
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// projectsHandler handles GET and POST /api/projects. GET returns the
+// profitability summary of every project, POST creates or updates a project.
+func (h *AppHandler) projectsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		summaries, err := h.projectService.Summaries()
+		if err != nil {
+			h.writeInternalError(w, "Failed to load projects", err)
+			return
+		}
+		if summaries == nil {
+			summaries = []models.ProjectSummary{}
+		}
+		page, err := paginate(w, r, summaries)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error(), nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+
+	case http.MethodPost:
+		var project models.Project
+		if err := json.NewDecoder(r.Body).Decode(&project); err != nil {
+			h.writeBodyError(w, "Invalid request body", err)
+			return
+		}
+		project.Name = strings.TrimSpace(project.Name)
+		if project.Name == "" {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "Project name is required", nil)
+			return
+		}
+		if project.Budget < 0 || project.HourlyRate < 0 {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "Budget and hourly rate must not be negative", nil)
+			return
+		}
+
+		if project.ID == 0 {
+			client, err := h.clients.GetClient(project.ClientID)
+			if err != nil {
+				h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Client not found with ID: %d", project.ClientID), nil)
+				return
+			}
+			project.Currency = strings.ToUpper(strings.TrimSpace(project.Currency))
+			if project.Currency == "" {
+				project.Currency = services.GetCurrencyForCountry(client.Country)
+			}
+			if len(project.Currency) != 3 || strings.Trim(project.Currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+				h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("%q is not a currency code like EUR", project.Currency), nil)
+				return
+			}
+		} else {
+			existing, err := h.projectService.Get(project.ID)
+			if err != nil {
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Project not found with ID: %d", project.ID), nil)
+				return
+			}
+			project.ClientID = existing.ClientID
+			project.Currency = existing.Currency
+			project.CreatedAt = existing.CreatedAt
+		}
+		project.Budget = project.Budget.Round(project.Currency)
+		project.HourlyRate = project.HourlyRate.Round(project.Currency)
+
+		created := project.ID == 0
+		if err := h.projectService.Save(&project); err != nil {
+			h.writeInternalError(w, "Failed to save project", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(project)
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// projectTimeEntriesHandler handles /api/projects/{id}/time-entries and
+// DELETE /api/projects/{id}/time-entries/{entry_id}
+func (h *AppHandler) projectTimeEntriesHandler(w http.ResponseWriter, r *http.Request, project *models.Project, entryPath string) {
+	if entryIDStr := strings.Trim(entryPath, "/"); entryIDStr != "" {
+		if r.Method != http.MethodDelete {
+			h.writeMethodNotAllowed(w)
+			return
+		}
+		entryID, err := strconv.Atoi(entryIDStr)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid time entry ID format: %s", entryIDStr), nil)
+			return
+		}
+		err = h.projectService.DeleteTimeEntry(project.ID, entryID)
+		if errors.Is(err, sql.ErrNoRows) {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, "Time entry not found or already billed", nil)
+			return
+		}
+		if err != nil {
+			h.writeInternalError(w, "Failed to delete time entry", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(messageResponse{Message: "Time entry deleted successfully"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		entries, err := h.projectService.GetTimeEntries(project.ID)
+		if err != nil {
+			h.writeInternalError(w, "Failed to load time entries", err)
+			return
+		}
+		if entries == nil {
+			entries = []models.TimeEntry{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+
+	case http.MethodPost:
+		var request timeEntryRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			h.writeBodyError(w, "Invalid request body", err)
+			return
+		}
+		date, err := time.Parse("2006-01-02", request.Date)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid date format. Expected YYYY-MM-DD, got: %s", request.Date), nil)
+			return
+		}
+		if request.Hours <= 0 || request.Hours > 24 {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "Hours must be more than 0 and at most 24", nil)
+			return
+		}
+
+		entry := models.TimeEntry{
+			ProjectID:   project.ID,
+			Date:        date,
+			Hours:       request.Hours,
+			Description: strings.TrimSpace(request.Description),
+		}
+		if err := h.projectService.AddTimeEntry(&entry); err != nil {
+			h.writeInternalError(w, "Failed to log time", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(entry)
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// invoiceTimeEntriesHandler handles /api/invoices/{id}/time-entries. GET lists
+// the time billed on the invoice, POST attaches the unbilled time of its project.
+func (h *AppHandler) invoiceTimeEntriesHandler(w http.ResponseWriter, r *http.Request, id int) {
+	switch r.Method {
+	case http.MethodGet:
+		entries, err := h.projectService.GetInvoiceTimeEntries(id)
+		if err != nil {
+			h.writeInternalError(w, "Failed to load billed time", err)
+			return
+		}
+		if entries == nil {
+			entries = []models.TimeEntry{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+
+	case http.MethodPost:
+		invoice, _, err := h.invoices.GetInvoice(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", id), nil)
+			return
+		}
+		if err != nil {
+			h.writeInternalError(w, "Failed to load invoice", err)
+			return
+		}
+		if invoice.ProjectID == 0 || invoice.IsProforma() {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "Time can only be attached to invoices that belong to a project", nil)
+			return
+		}
+
+		hours, err := h.projectService.BillTimeEntries(id)
+		if err != nil {
+			h.writeInternalError(w, "Failed to attach time", err)
+			return
+		}
+		entries, err := h.projectService.GetInvoiceTimeEntries(id)
+		if err != nil {
+			h.writeInternalError(w, "Failed to load billed time", err)
+			return
+		}
+		if entries == nil {
+			entries = []models.TimeEntry{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(billTimeResponse{Hours: hours, TimeEntries: entries})
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"net/http"
+)
+
+// pwaFiles are the files that make the web interface an installable app
+//
+//go:embed pwa
+var pwaFiles embed.FS
+
+// pwaAssets maps the URL paths of the app files to the embedded files and
+// their content types. They hold no data, so they are served without signing
+// in: browsers fetch the manifest and icons without cookies.
+var pwaAssets = map[string]struct {
+	file        string
+	contentType string
+}{
+	"/service-worker.js":          {"pwa/service-worker.js", "text/javascript; charset=utf-8"},
+	"/offline.html":               {"pwa/offline.html", "text/html; charset=utf-8"},
+	"/icons/icon-192.png":         {"pwa/icon-192.png", "image/png"},
+	"/icons/icon-512.png":         {"pwa/icon-512.png", "image/png"},
+	"/icons/apple-touch-icon.png": {"pwa/apple-touch-icon.png", "image/png"},
+}
+
+// manifestPath is the URL path of the web app manifest
+const manifestPath = "/manifest.webmanifest"
+
+// isPWAAsset reports whether path is the manifest or a file of the app
+func isPWAAsset(path string) bool {
+	_, ok := pwaAssets[path]
+	return ok || path == manifestPath
+}
+
+// webManifest is the web app manifest, see https://www.w3.org/TR/appmanifest/
+type webManifest struct {
+	Name            string                `json:"name"`
+	ShortName       string                `json:"short_name"`
+	Description     string                `json:"description"`
+	StartURL        string                `json:"start_url"`
+	Scope           string                `json:"scope"`
+	Display         string                `json:"display"`
+	BackgroundColor string                `json:"background_color"`
+	ThemeColor      string                `json:"theme_color"`
+	Icons           []webManifestIcon     `json:"icons"`
+	Shortcuts       []webManifestShortcut `json:"shortcuts"`
+}
+
+type webManifestIcon struct {
+	Src     string `json:"src"`
+	Sizes   string `json:"sizes"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose"`
+}
+
+type webManifestShortcut struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// ManifestHandler serves the web app manifest, which lets phones install the
+// web interface on the home screen. The app opens on the invoice list.
+// Route: GET /manifest.webmanifest
+func (h *AppHandler) ManifestHandler(w http.ResponseWriter, r *http.Request) {
+	manifest := webManifest{
+		Name:            "Simple Invoice",
+		ShortName:       "Invoices",
+		Description:     "Create, send and track invoices",
+		StartURL:        "/invoices",
+		Scope:           "/",
+		Display:         "standalone",
+		BackgroundColor: "#ffffff",
+		ThemeColor:      "#198754",
+		Icons: []webManifestIcon{
+			{Src: "/icons/icon-192.png", Sizes: "192x192", Type: "image/png", Purpose: "any"},
+			{Src: "/icons/icon-512.png", Sizes: "512x512", Type: "image/png", Purpose: "any"},
+			{Src: "/icons/icon-512.png", Sizes: "512x512", Type: "image/png", Purpose: "maskable"},
+		},
+		Shortcuts: []webManifestShortcut{
+			{Name: "Create Invoice", URL: "/invoices/create"},
+			{Name: "Unpaid Invoices", URL: "/invoices?status=sent"},
+		},
+	}
+	w.Header().Set("Content-Type", "application/manifest+json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// PWAAssetHandler serves the service worker, the page it shows offline and
+// the app icons. The service worker names its cache after the version, so an
+// update replaces the cached files.
+// Route: GET /service-worker.js, /offline.html, /icons/icon-192.png, /icons/icon-512.png, /icons/apple-touch-icon.png
+func (h *AppHandler) PWAAssetHandler(w http.ResponseWriter, r *http.Request) {
+	asset, ok := pwaAssets[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := pwaFiles.ReadFile(asset.file)
+	if err != nil {
+		h.logger.Error("Failed to read %s: %v", asset.file, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", asset.contentType)
+	if r.URL.Path == "/service-worker.js" {
+		version := h.version
+		if version == "" {
+			version = "dev"
+		}
+		data = bytes.ReplaceAll(data, []byte("{{VERSION}}"), []byte(version))
+		// Browsers look for a new service worker on every visit
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	w.Write(data)
+}
@@ -0,0 +1,38 @@
+// Service worker of Simple Invoice. It makes the app installable and shows
+// the offline page when the server cannot be reached. Invoices, clients and
+// PDFs are never cached, only the assets every page needs.
+const CACHE = 'simple-invoice-{{VERSION}}';
+const ASSETS = [
+    '/offline.html',
+    '/icons/icon-192.png',
+    'https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/css/bootstrap.min.css',
+    'https://cdn.jsdelivr.net/npm/bootstrap@5.3.0/dist/js/bootstrap.bundle.min.js',
+];
+const ASSET_URLS = ASSETS.map(asset => new URL(asset, self.location).href);
+
+self.addEventListener('install', event => {
+    event.waitUntil(caches.open(CACHE).then(cache => cache.addAll(ASSETS)).then(() => self.skipWaiting()));
+});
+
+// Caches of earlier versions are removed when this version takes over
+self.addEventListener('activate', event => {
+    event.waitUntil(
+        caches.keys()
+            .then(keys => Promise.all(keys.filter(key => key !== CACHE).map(key => caches.delete(key))))
+            .then(() => self.clients.claim())
+    );
+});
+
+self.addEventListener('fetch', event => {
+    const request = event.request;
+    if (request.method !== 'GET') {
+        return;
+    }
+    if (request.mode === 'navigate') {
+        event.respondWith(fetch(request).catch(() => caches.match('/offline.html')));
+        return;
+    }
+    if (ASSET_URLS.includes(request.url)) {
+        event.respondWith(caches.match(request).then(cached => cached || fetch(request)));
+    }
+});
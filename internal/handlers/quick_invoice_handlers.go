@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// quickInvoiceRequest is the body of POST /api/invoices/quick
+type quickInvoiceRequest struct {
+	ClientID    int          `json:"client_id"`
+	Hours       float64      `json:"hours"`
+	Rate        models.Money `json:"rate"`        // Hourly rate in the client's currency
+	Description string       `json:"description"` // Defaults to "Hours worked"
+	Period      string       `json:"period"`      // Service period, a month like 2024-03 or dates like 2024-03-01..2024-03-31
+	IssueDate   string       `json:"issue_date"`  // YYYY-MM-DD, defaults to today
+	BusinessID  int          `json:"business_id"` // Defaults to the first business
+	Send        bool         `json:"send"`        // Email the invoice to the client
+	To          string       `json:"to"`          // Defaults to the client's email
+}
+
+// quickInvoiceResponse is returned after a quick invoice was created
+type quickInvoiceResponse struct {
+	Invoice models.Invoice       `json:"invoice"`
+	URL     string               `json:"url"`             // Signed link to the PDF that expires after a day
+	Email   *sendInvoiceResponse `json:"email,omitempty"` // Set when the invoice is sent
+}
+
+// QuickInvoiceHandler creates an invoice of hours worked for a client in one
+// request: the invoice is numbered, its PDF generated and, with send, emailed
+// to the client. It saves the clicks of the invoice form for the common case
+// of a single line of hours at an hourly rate.
+// Route: POST /api/invoices/quick
+func (h *AppHandler) QuickInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	var req quickInvoiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeBodyError(w, fmt.Sprintf("Invalid request body: %v", err), err)
+		return
+	}
+	if req.Hours <= 0 {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "hours must be greater than 0", nil)
+		return
+	}
+	if req.Rate <= 0 {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "rate must be greater than 0", nil)
+		return
+	}
+
+	opts := services.HoursInvoiceOptions{
+		IssueDate: time.Now().Truncate(24 * time.Hour),
+		DueDays:   h.settingsService.GetInt(services.SettingInvoiceDueDays),
+		VatRate:   h.settingsService.GetFloat(services.SettingInvoiceVatRate),
+		Notes:     h.settingsService.GetString(services.SettingInvoiceNotes),
+	}
+	if req.IssueDate != "" {
+		issueDate, err := time.Parse("2006-01-02", req.IssueDate)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid issue date format. Expected YYYY-MM-DD, got: %s", req.IssueDate), nil)
+			return
+		}
+		opts.IssueDate = issueDate
+	}
+	periodStart, periodEnd, err := services.ParseServicePeriod(req.Period)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, err.Error(), nil)
+		return
+	}
+
+	client, err := h.clients.GetClient(req.ClientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Client not found with ID: %d", req.ClientID), nil)
+			return
+		}
+		h.writeInternalError(w, "Failed to load client", err)
+		return
+	}
+	if client.Deleted {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "The client was deleted", nil)
+		return
+	}
+
+	if req.BusinessID == 0 {
+		businesses, err := h.businesses.GetBusinesses()
+		if err != nil {
+			h.writeInternalError(w, "Failed to load business", err)
+			return
+		}
+		if len(businesses) == 0 {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "Set up your business details before creating invoices", nil)
+			return
+		}
+		req.BusinessID = businesses[0].ID
+	}
+	business, err := h.businesses.GetBusiness(req.BusinessID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Business not found with ID: %d", req.BusinessID), nil)
+			return
+		}
+		h.writeInternalError(w, "Failed to load business", err)
+		return
+	}
+
+	// Check that the email can be sent before the invoice is created, so a
+	// mistyped address does not leave an unsent invoice behind
+	if req.Send {
+		if !h.emailService.Configured() {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, services.ErrEmailNotConfigured.Error(), nil)
+			return
+		}
+		if req.To == "" {
+			req.To = client.Email
+		}
+		if req.To == "" {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "The client has no email address", nil)
+			return
+		}
+		if _, err := mail.ParseAddress(req.To); err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("%q is not an email address", req.To), nil)
+			return
+		}
+	}
+
+	invoice, items := services.NewHoursInvoice(business, client, req.Hours, req.Rate, req.Description, opts)
+	invoice.ServicePeriodStart, invoice.ServicePeriodEnd = periodStart, periodEnd
+	h.lookupExchangeRate(invoice)
+
+	// The invoice.create hook may number or reject new invoices
+	if err := h.hookService.InvoiceCreate(invoice, items); err != nil {
+		h.writeHookError(w, err)
+		return
+	}
+	if err := h.invoices.SaveInvoice(invoice, items); err != nil {
+		switch {
+		case errors.Is(err, services.ErrDuplicateInvoiceNumber):
+			h.writeError(w, http.StatusConflict, errCodeDuplicateNumber, fmt.Sprintf("Invoice number %s is already in use", invoice.InvoiceNumber), nil)
+		case errors.Is(err, services.ErrYearClosed):
+			h.writeError(w, http.StatusConflict, errCodeYearClosed, fmt.Sprintf("Invoices cannot be issued in a closed fiscal year (%v)", err), nil)
+		default:
+			h.writeInternalError(w, "Failed to save invoice", err)
+		}
+		return
+	}
+	h.logger.Info("Created quick invoice #%s with ID: %d", invoice.InvoiceNumber, invoice.ID)
+
+	// The invoice exists from here on, so later failures do not fail the
+	// request, which would invite creating the invoice twice
+	response := quickInvoiceResponse{Invoice: *invoice, URL: h.invoicePDFURL(invoice.ID, pdfViewLinkLifetime)}
+	if _, err := h.generateInvoicePDF(invoice.ID); err != nil {
+		// The PDF job queued with the invoice tries again
+		h.logger.Error("Failed to generate the PDF of quick invoice %d: %v", invoice.ID, err)
+	}
+
+	if req.Send {
+		response.Email = h.queueQuickInvoiceEmail(r, invoice.ID, req.To)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// queueQuickInvoiceEmail queues the invoice email of a quick invoice to to. The
+// message of the response says why when the email could not be queued.
+func (h *AppHandler) queueQuickInvoiceEmail(r *http.Request, id int, to string) *sendInvoiceResponse {
+	data, err := h.loadInvoicePDFData(id)
+	if err != nil {
+		h.logger.Error("Failed to load quick invoice %d for its email: %v", id, err)
+		return &sendInvoiceResponse{Message: "The invoice was created, but its email could not be queued", To: to}
+	}
+	email, err := h.renderInvoiceEmail(r, data, services.EmailTemplateInvoice)
+	if err != nil {
+		h.logger.Error("Failed to render the email of quick invoice %d: %v", id, err)
+		return &sendInvoiceResponse{Message: "The invoice was created, but its email could not be rendered", To: to, Status: data.Invoice.Status}
+	}
+	job, err := h.jobService.Enqueue(services.JobTypeSendInvoiceEmail, invoiceEmailJobPayload{
+		InvoiceID: id,
+		Kind:      email.Kind,
+		To:        to,
+		Subject:   email.Subject,
+		Body:      email.Body,
+	})
+	if err != nil {
+		h.logger.Error("Failed to queue the email of quick invoice %d: %v", id, err)
+		return &sendInvoiceResponse{Message: "The invoice was created, but its email could not be queued", To: to, Status: data.Invoice.Status}
+	}
+	return &sendInvoiceResponse{Message: "Sending invoice to " + to, To: to, Status: data.Invoice.Status, JobID: job.ID}
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// File types accepted as bank statements: CSV exports, MT940 files, which
+// banks name in many ways, and camt.053 XML
+var (
+	statementExtensions   = []string{".csv", ".txt", ".sta", ".mt940", ".940", ".xml"}
+	statementContentTypes = []string{"text/plain", "text/csv", "text/xml", "application/xml"}
+)
+
+// reconcileRequest is the body of POST /api/bank-statements/reconcile
+type reconcileRequest struct {
+	Payments []reconcilePayment `json:"payments"`
+}
+
+// reconcilePayment confirms that an invoice was paid
+type reconcilePayment struct {
+	InvoiceID         int    `json:"invoice_id"`
+	PaidDate          string `json:"paid_date"`                     // YYYY-MM-DD, today if empty
+	BankTransactionID int    `json:"bank_transaction_id,omitempty"` // Synced payment that paid the invoice
+}
+
+// reconcileResponse lists the invoices that were marked paid
+type reconcileResponse struct {
+	Paid        int              `json:"paid"`
+	AlreadyPaid int              `json:"already_paid"`
+	Invoices    []models.Invoice `json:"invoices"`
+}
+
+// BankStatementImportHandler reads an uploaded bank statement and proposes
+// the open invoices its incoming payments pay. Nothing is changed until the
+// proposals are confirmed with ReconcileHandler.
+// Form fields: file (CSV, MT940 or camt.053), format (csv, mt940, camt053; detected when empty)
+func (h *AppHandler) BankStatementImportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		h.logger.Error("Failed to parse bank statement form: %v", err)
+		h.writeBodyError(w, "Failed to parse form", err)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		h.logger.Error("Failed to get bank statement file: %v", err)
+		h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "Failed to get file", nil)
+		return
+	}
+	defer file.Close()
+
+	if err := checkUploadedFile(file, header, statementExtensions, statementContentTypes); err != nil {
+		h.logger.Warn("Rejected bank statement %s: %v", header.Filename, err)
+		if errors.Is(err, errUnsupportedFile) {
+			h.writeError(w, http.StatusUnsupportedMediaType, errCodeUnsupportedFile, "Invalid file. Upload a CSV, MT940 or camt.053 statement.", nil)
+		} else {
+			h.writeInternalError(w, "Failed to read bank statement", err)
+		}
+		return
+	}
+
+	format := r.FormValue("format")
+	if format != "" && !slices.Contains(services.StatementFormats(), format) {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Unsupported statement format: %s", format), nil)
+		return
+	}
+	h.logger.Info("Matching the payments in bank statement %s", header.Filename)
+
+	result, err := h.importService.MatchBankStatement(file, format)
+	if err != nil {
+		h.logger.Error("Failed to read bank statement: %v", err)
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Failed to read bank statement: %v", err), nil)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+// ReconcileHandler marks the invoices confirmed on the reconciliation screen as
+// paid on the dates of their payments. All invoices are checked before any is
+// changed; invoices that are already paid are left alone.
+// Route: POST /api/bank-statements/reconcile
+func (h *AppHandler) ReconcileHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	var req reconcileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeBodyError(w, fmt.Sprintf("Invalid request body: %v", err), err)
+		return
+	}
+	if len(req.Payments) == 0 {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, "No payments to reconcile", nil)
+		return
+	}
+
+	invoices := make([]*models.Invoice, len(req.Payments))
+	paidDates := make([]time.Time, len(req.Payments))
+	seen := make(map[int]bool, len(req.Payments))
+	for i, payment := range req.Payments {
+		if seen[payment.InvoiceID] {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invoice %d is listed twice", payment.InvoiceID), nil)
+			return
+		}
+		seen[payment.InvoiceID] = true
+
+		if payment.PaidDate != "" {
+			date, err := time.Parse("2006-01-02", payment.PaidDate)
+			if err != nil {
+				h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid paid date format. Expected YYYY-MM-DD, got: %s", payment.PaidDate), nil)
+				return
+			}
+			paidDates[i] = date
+		}
+
+		invoice, _, err := h.invoices.GetInvoice(payment.InvoiceID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invoice not found with ID: %d", payment.InvoiceID), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to load invoice", err)
+			return
+		}
+		if invoice.IsProforma() {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("%s is a pro-forma invoice, convert it before marking it paid", invoice.InvoiceNumber), nil)
+			return
+		}
+		invoices[i] = invoice
+	}
+
+	response := reconcileResponse{Invoices: []models.Invoice{}}
+	for i, invoice := range invoices {
+		// A synced payment is settled either way, so it is not proposed again
+		if id := req.Payments[i].BankTransactionID; id != 0 {
+			if err := h.bankSyncService.MarkReconciled(id, invoice.ID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+				h.writeInternalError(w, "Failed to reconcile synced payment", err)
+				return
+			}
+		}
+		if invoice.Status == models.InvoiceStatusPaid {
+			response.AlreadyPaid++
+			continue
+		}
+		if err := h.markInvoicePaid(invoice, paidDates[i]); err != nil {
+			h.writeInternalError(w, fmt.Sprintf("Failed to mark invoice %s paid", invoice.InvoiceNumber), err)
+			return
+		}
+
+		updated, _, err := h.invoices.GetInvoice(invoice.ID)
+		if err != nil {
+			h.writeInternalError(w, "Failed to load invoice", err)
+			return
+		}
+		response.Paid++
+		response.Invoices = append(response.Invoices, *updated)
+	}
+	h.logger.Info("Reconciled bank payments: %d invoices marked paid, %d already paid", response.Paid, response.AlreadyPaid)
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// markInvoicePaid marks an unpaid invoice paid on paidDate, today if zero,
+// and notifies the open pages and the configured notification channels
+func (h *AppHandler) markInvoicePaid(invoice *models.Invoice, paidDate time.Time) error {
+	if err := h.invoices.UpdateInvoiceStatus(invoice.ID, models.InvoiceStatusPaid, paidDate); err != nil {
+		return err
+	}
+	h.publishInvoiceStatus(invoice.ID)
+	h.notificationService.Notify(services.Notification{
+		Event:   services.EventInvoicePaid,
+		Title:   fmt.Sprintf("Invoice %s was paid", invoice.InvoiceNumber),
+		Message: fmt.Sprintf("%s %s received.", invoice.TotalAmount, invoice.Currency),
+	})
+	return nil
+}
+
+// ReconcilePageHandler shows the reconciliation screen, where a bank statement
+// is uploaded or the payments synced from connected banks are loaded, and the
+// proposed matches are reviewed before the invoices are marked paid
+func (h *AppHandler) ReconcilePageHandler(w http.ResponseWriter, r *http.Request) {
+	data := map[string]interface{}{
+		"Title":       "Reconcile Payments",
+		"Formats":     services.StatementFormats(),
+		"BankSync":    h.bankSyncService.Configured(),
+		"CurrentYear": time.Now().Year(),
+	}
+	h.renderTemplate(w, "reconcile", data)
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// RecoverPanics turns a panic in a handler into a 500 response, with the
+// error envelope for API requests, instead of a dropped connection. The panic
+// is logged with its stack trace and counted in the panics_recovered metric.
+func (h *AppHandler) RecoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tracker := &headerTracker{ResponseWriter: w}
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+			// Handlers abort responses on purpose with ErrAbortHandler
+			if value == http.ErrAbortHandler {
+				panic(value)
+			}
+			services.LogPanic(h.logger, fmt.Sprintf("%s %s", r.Method, r.URL.Path), value)
+			if tracker.wroteHeader {
+				// Too late for an error response, close the connection so
+				// the client sees the response is incomplete
+				panic(http.ErrAbortHandler)
+			}
+			if strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/graphql" {
+				h.writeError(w, http.StatusInternalServerError, errCodeInternal, "Internal server error", nil)
+				return
+			}
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(tracker, r)
+	})
+}
+
+// headerTracker records whether the response has been started
+type headerTracker struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (t *headerTracker) WriteHeader(status int) {
+	t.wroteHeader = true
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *headerTracker) Write(b []byte) (int, error) {
+	t.wroteHeader = true
+	return t.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush
+func (t *headerTracker) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
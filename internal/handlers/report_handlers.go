@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// ReportsHandler handles the reports page with the revenue of a year by month
+func (h *AppHandler) ReportsHandler(w http.ResponseWriter, r *http.Request) {
+	report, ok := h.loadReport(w, r)
+	if !ok {
+		return
+	}
+
+	data := map[string]interface{}{
+		"Title":       "Reports",
+		"Report":      report,
+		"Bases":       services.ReportBases,
+		"CurrentYear": time.Now().Year(),
+	}
+
+	check, err := h.closingService.CheckSequence(report.Year)
+	if err != nil {
+		h.writeInternalError(w, "Failed to check the invoice numbering", err)
+		return
+	}
+	data["SequenceCheck"] = check
+
+	// Past years can be closed
+	closing, err := h.closingService.Get(report.Year)
+	switch {
+	case err == nil:
+		data["Closing"] = closing
+	case !errors.Is(err, sql.ErrNoRows):
+		h.writeInternalError(w, "Failed to load the closing", err)
+		return
+	}
+	data["CanClose"] = closing == nil && report.Year < time.Now().Year()
+
+	h.renderTemplate(w, "reports", data)
+}
+
+// ReportsAPIHandler handles GET /api/reports?basis=&year=
+func (h *AppHandler) ReportsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	report, ok := h.loadReport(w, r)
+	if !ok {
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// ExportAPIHandler handles GET /api/export?format=&from=&to=, which downloads
+// the invoices of a period for an accountant
+func (h *AppHandler) ExportAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = services.ExportFormatCSV
+	}
+	if !slices.Contains(services.ExportFormats, format) {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid format %q, expected one of %s", format, strings.Join(services.ExportFormats, ", ")), nil)
+		return
+	}
+	var period services.ExportPeriod
+	for _, param := range []struct {
+		name string
+		date *time.Time
+	}{{"from", &period.From}, {"to", &period.To}} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		var err error
+		if *param.date, err = time.Parse("2006-01-02", value); err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid %s date %q, expected YYYY-MM-DD", param.name, value), nil)
+			return
+		}
+	}
+	if err := services.ValidateExportPeriod(format, period); err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, err.Error(), nil)
+		return
+	}
+
+	// Exported in full before sending, so a failure is reported as an error
+	var out bytes.Buffer
+	if _, err := h.exportService.ExportInvoices(&out, format, period); err != nil {
+		h.writeInternalError(w, "Failed to export invoices", err)
+		return
+	}
+	contentType := "text/csv; charset=utf-8"
+	switch format {
+	case services.ExportFormatDATEV:
+		contentType = "text/csv; charset=windows-1252"
+	case services.ExportFormatSAFT:
+		contentType = "application/xml"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": services.ExportFilename(format, period)}))
+	w.Write(out.Bytes())
+}
+
+// loadReport computes the report for the basis and year in the query, the
+// configured basis and the current year by default. It writes an error and
+// returns false if they are invalid.
+func (h *AppHandler) loadReport(w http.ResponseWriter, r *http.Request) (*models.Report, bool) {
+	basis := r.URL.Query().Get("basis")
+	if basis != "" && !slices.Contains(services.ReportBases, basis) {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid basis %q, expected accrual or cash", basis), nil)
+		return nil, false
+	}
+
+	year := time.Now().Year()
+	if yearStr := r.URL.Query().Get("year"); yearStr != "" {
+		var err error
+		year, err = strconv.Atoi(yearStr)
+		if err != nil || year < 1 || year > 9999 {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid year: %s", yearStr), nil)
+			return nil, false
+		}
+	}
+
+	report, err := h.reportService.Report(basis, year)
+	if err != nil {
+		h.writeInternalError(w, "Failed to compute report", err)
+		return nil, false
+	}
+	return report, true
+}
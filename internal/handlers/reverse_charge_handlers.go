@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// ReverseChargeClausesAPIHandler handles reverse charge clause API requests
+// GET lists the clauses, POST saves one for a country, DELETE ?country= removes it
+func (h *AppHandler) ReverseChargeClausesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		clauses, err := h.reverseChargeService.List()
+		if err != nil {
+			h.writeInternalError(w, "Failed to list reverse charge clauses", err)
+			return
+		}
+		json.NewEncoder(w).Encode(clauses)
+
+	case http.MethodPost:
+		var c models.ReverseChargeClause
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			h.logger.Error("Failed to decode reverse charge clause: %v", err)
+			h.writeBodyError(w, fmt.Sprintf("Invalid request body: %v", err), err)
+			return
+		}
+
+		if err := h.reverseChargeService.Save(&c); err != nil {
+			h.logger.Error("Failed to save reverse charge clause: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, err.Error(), nil)
+			return
+		}
+		json.NewEncoder(w).Encode(c)
+
+	case http.MethodDelete:
+		country := r.URL.Query().Get("country")
+		if err := h.reverseChargeService.Delete(country); err != nil {
+			if errors.Is(err, services.ErrReverseChargeClauseNotFound) {
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("No customized reverse charge clause for country %s", country), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to delete reverse charge clause", err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"message": "Reverse charge clause deleted successfully"})
+
+	default:
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+	}
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// settingGroup is a titled section of the settings page
+type settingGroup struct {
+	Name     string
+	Settings []services.SettingValue
+}
+
+// SettingsHandler handles the settings page
+func (h *AppHandler) SettingsHandler(w http.ResponseWriter, r *http.Request) {
+	var groups []settingGroup
+	for _, setting := range h.settingsService.All() {
+		if len(groups) == 0 || groups[len(groups)-1].Name != setting.Group {
+			groups = append(groups, settingGroup{Name: setting.Group})
+		}
+		groups[len(groups)-1].Settings = append(groups[len(groups)-1].Settings, setting)
+	}
+
+	clauses, err := h.reverseChargeService.List()
+	if err != nil {
+		h.logger.Error("Failed to list reverse charge clauses: %v", err)
+		http.Error(w, "Failed to list reverse charge clauses", http.StatusInternalServerError)
+		return
+	}
+
+	profiles, err := h.complianceService.List()
+	if err != nil {
+		h.logger.Error("Failed to list compliance profiles: %v", err)
+		http.Error(w, "Failed to list compliance profiles", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := h.authService.APITokens()
+	if err != nil {
+		h.logger.Error("Failed to list API tokens: %v", err)
+		http.Error(w, "Failed to list API tokens", http.StatusInternalServerError)
+		return
+	}
+
+	// Sessions are OIDC sign-ins of the signed-in user
+	sessions := []models.Session{}
+	if user := currentUser(r); user != nil && h.authService.Mode() == services.AuthModeOIDC {
+		current := ""
+		if cookie, err := r.Cookie(sessionCookieName); err == nil {
+			current = cookie.Value
+		}
+		if sessions, err = h.authService.Sessions(user.ID, current); err != nil {
+			h.logger.Error("Failed to list sessions: %v", err)
+			http.Error(w, "Failed to list sessions", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	connection, err := h.accountingSyncService.Connection()
+	if err != nil {
+		h.logger.Error("Failed to load accounting connection: %v", err)
+		http.Error(w, "Failed to load accounting connection", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Title":                "Settings",
+		"SettingGroups":        groups,
+		"ReverseChargeClauses": clauses,
+		"ComplianceProfiles":   profiles,
+		"ComplianceFields":     models.ComplianceFields,
+		"ComplianceFormats":    models.ComplianceFormats,
+		"APITokens":            tokens,
+		"TokenScopes":          models.TokenScopes,
+		"AuthEnabled":          h.authService.Mode() != services.AuthModeNone,
+		"SessionsEnabled":      h.authService.Mode() == services.AuthModeOIDC,
+		"SecretsEncrypted":     h.settingsService.EncryptsSecrets(),
+		"Sessions":             sessions,
+		"Accounting": accountingSyncStatus{
+			Provider:   h.accountingSyncService.Provider(),
+			Configured: h.accountingSyncService.Configured(),
+			Connection: connection,
+		},
+		"CurrentYear": time.Now().Year(),
+	}
+
+	h.renderTemplate(w, "settings", data)
+}
+
+// SettingsAPIHandler handles settings API requests
+// GET returns all settings, POST saves a JSON object of setting key -> value
+func (h *AppHandler) SettingsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(settingsResponse(h.settingsService.All()))
+
+	case http.MethodPost:
+		var values map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+			h.logger.Error("Failed to decode settings: %v", err)
+			h.writeBodyError(w, fmt.Sprintf("Invalid request body: %v", err), err)
+			return
+		}
+
+		// Secrets are never sent to the browser, so an empty value means "keep the current one"
+		for _, def := range h.settingsService.Definitions() {
+			if value, ok := values[def.Key]; ok && def.Secret && value == "" {
+				delete(values, def.Key)
+			}
+		}
+
+		if err := h.settingsService.SetMany(values); err != nil {
+			h.logger.Error("Failed to save settings: %v", err)
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, err.Error(), nil)
+			return
+		}
+
+		if _, ok := values[services.SettingLogLevel]; ok {
+			h.applyLogLevel()
+		}
+
+		if _, ok := values[services.SettingBackupCron]; ok {
+			if err := h.backupService.Reschedule(h.settingsService.GetString(services.SettingBackupCron)); err != nil {
+				h.writeInternalError(w, "Settings saved, but the backup schedule could not be applied", err)
+				return
+			}
+		}
+
+		json.NewEncoder(w).Encode(settingsResponse(h.settingsService.All()))
+
+	default:
+		h.logger.Warn("Method not allowed: %s", r.Method)
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// settingResponse is the JSON shape of a setting returned by the API
+type settingResponse struct {
+	Key       string `json:"key"`
+	Group     string `json:"group"`
+	Label     string `json:"label"`
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+	Masked    string `json:"masked,omitempty"`
+	IsSet     bool   `json:"is_set"`
+	Secret    bool   `json:"secret"`
+	Encrypted bool   `json:"encrypted"`
+	Source    string `json:"source"`
+}
+
+// settingsResponse converts settings to the JSON shape returned by the API
+func settingsResponse(settings []services.SettingValue) []settingResponse {
+	response := make([]settingResponse, 0, len(settings))
+	for _, setting := range settings {
+		response = append(response, settingResponse{
+			Key:       setting.Key,
+			Group:     setting.Group,
+			Label:     setting.Label,
+			Type:      setting.Type,
+			Value:     setting.Value,
+			Masked:    setting.Masked,
+			IsSet:     setting.IsSet,
+			Secret:    setting.Secret,
+			Encrypted: setting.Encrypted,
+			Source:    setting.Source,
+		})
+	}
+	return response
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// invoiceTagsRequest is the body of PUT /api/invoices/{id}/tags
+type invoiceTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// savedFilterRequest is the body of POST /api/filters
+type savedFilterRequest struct {
+	Name  string `json:"name"`
+	Query string `json:"query"` // Query string of the invoice list, e.g. status=sent&tag=2024-Q1
+}
+
+// invoiceFilterParams are the query parameters that filter invoice lists
+var invoiceFilterParams = []apiParam{
+	{Name: "client_id", In: "query", Type: "integer", Description: "Only invoices of this client"},
+	{Name: "status", In: "query", Type: "string", Enum: models.InvoiceStatuses, Description: "Only invoices with this status"},
+	{Name: "tag", In: "query", Type: "string", Description: "Only invoices with this tag, ignoring case; repeat or separate with commas to require several tags"},
+}
+
+// filterInvoices returns the invoices selected by filter
+func filterInvoices(invoices []models.Invoice, filter models.InvoiceFilter) []models.Invoice {
+	if filter.IsZero() {
+		return invoices
+	}
+	filtered := []models.Invoice{}
+	for i := range invoices {
+		if filter.Matches(&invoices[i]) {
+			filtered = append(filtered, invoices[i])
+		}
+	}
+	return filtered
+}
+
+// applyInvoiceTags sets the tags of a decoded invoice request when it has a
+// tags list; without one, saving keeps the tags of the invoice
+func applyInvoiceTags(rawInvoice map[string]interface{}, invoice *models.Invoice) error {
+	raw, ok := rawInvoice["tags"]
+	if !ok || raw == nil {
+		return nil
+	}
+	values, ok := raw.([]interface{})
+	if !ok {
+		return errors.New("tags must be a list of strings")
+	}
+	tags := make([]string, 0, len(values))
+	for _, value := range values {
+		tag, ok := value.(string)
+		if !ok {
+			return errors.New("tags must be a list of strings")
+		}
+		tags = append(tags, tag)
+	}
+	var err error
+	invoice.Tags, err = models.NormalizeTags(tags)
+	return err
+}
+
+// invoiceTagsHandler handles PUT /api/invoices/{id}/tags, which replaces the
+// tags of an invoice
+func (h *AppHandler) invoiceTagsHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodPut {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+
+	var request invoiceTagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.writeBodyError(w, "Invalid request body", err)
+		return
+	}
+	if _, err := models.NormalizeTags(request.Tags); err != nil {
+		h.writeError(w, http.StatusBadRequest, errCodeValidation, err.Error(), nil)
+		return
+	}
+
+	tags, err := h.dbService.SetInvoiceTags(id, request.Tags)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", id), nil)
+			return
+		}
+		h.writeInternalError(w, "Failed to save invoice tags", err)
+		return
+	}
+
+	h.logger.Info("Set the tags of invoice %d to %s", id, strings.Join(tags, ", "))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invoiceTagsRequest{Tags: tags})
+}
+
+// InvoiceTagsHandler handles GET /api/invoices/tags, the tags in use
+func (h *AppHandler) InvoiceTagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w)
+		return
+	}
+	tags, err := h.dbService.GetInvoiceTagCounts()
+	if err != nil {
+		h.writeInternalError(w, "Failed to load invoice tags", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+// savedFilterUserID returns the ID of the user whose filters a request
+// manages, 0 when authentication is disabled
+func savedFilterUserID(r *http.Request) int {
+	if user := currentUser(r); user != nil {
+		return user.ID
+	}
+	return 0
+}
+
+// SavedFiltersHandler handles GET and POST /api/filters and DELETE
+// /api/filters/{id}, the invoice filter presets of the signed-in user
+func (h *AppHandler) SavedFiltersHandler(w http.ResponseWriter, r *http.Request) {
+	userID := savedFilterUserID(r)
+	w.Header().Set("Content-Type", "application/json")
+
+	if idStr := strings.TrimPrefix(r.URL.Path, "/api/filters/"); idStr != r.URL.Path && idStr != "" {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid filter ID: %s", idStr), nil)
+			return
+		}
+		if r.Method != http.MethodDelete {
+			h.writeMethodNotAllowed(w)
+			return
+		}
+		if err := h.dbService.DeleteSavedFilter(userID, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Filter not found with ID: %d", id), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to delete filter", err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"message": "Filter deleted successfully"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		filters, err := h.dbService.GetSavedFilters(userID)
+		if err != nil {
+			h.writeInternalError(w, "Failed to load saved filters", err)
+			return
+		}
+		json.NewEncoder(w).Encode(filters)
+
+	case http.MethodPost:
+		var request savedFilterRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			h.writeBodyError(w, "Invalid request body", err)
+			return
+		}
+		name := strings.TrimSpace(request.Name)
+		if name == "" {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "The filter needs a name", nil)
+			return
+		}
+
+		// Store the filter in a canonical form, which also validates it
+		query, err := url.ParseQuery(strings.TrimPrefix(request.Query, "?"))
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid query: %v", err), nil)
+			return
+		}
+		invoiceFilter, err := models.ParseInvoiceFilter(query)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, fmt.Sprintf("Invalid filter: %v", err), nil)
+			return
+		}
+		if invoiceFilter.IsZero() {
+			h.writeError(w, http.StatusBadRequest, errCodeValidation, "The filter needs a client, status or tag", nil)
+			return
+		}
+
+		filter := models.SavedFilter{UserID: userID, Name: name, Query: invoiceFilter.Query().Encode()}
+		if err := h.dbService.AddSavedFilter(&filter); err != nil {
+			if errors.Is(err, services.ErrSavedFilterExists) {
+				h.writeError(w, http.StatusConflict, errCodeDuplicateFilter, fmt.Sprintf("A filter named %q already exists", name), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to save filter", err)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(filter)
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
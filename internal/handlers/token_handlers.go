@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// createTokenRequest is the body of POST /api/tokens
+type createTokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"` // Any of read, invoices:write and backups:admin
+}
+
+// createTokenResponse returns a new API token with its secret, which is only
+// shown this once
+type createTokenResponse struct {
+	models.APIToken
+	Token string `json:"token"`
+}
+
+// TokensAPIHandler handles /api/tokens and /api/tokens/{id}. GET lists the
+// API tokens, POST creates one for the signed-in user and DELETE revokes one.
+func (h *AppHandler) TokensAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/tokens"), "/")
+	if idStr != "" {
+		if r.Method != http.MethodDelete {
+			h.writeMethodNotAllowed(w)
+			return
+		}
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid token ID format: %s", idStr), nil)
+			return
+		}
+		if err := h.authService.RevokeAPIToken(id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("No active API token with ID: %d", id), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to revoke API token", err)
+			return
+		}
+		json.NewEncoder(w).Encode(messageResponse{Message: "API token revoked"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := h.authService.APITokens()
+		if err != nil {
+			h.writeInternalError(w, "Failed to load API tokens", err)
+			return
+		}
+		json.NewEncoder(w).Encode(tokens)
+
+	case http.MethodPost:
+		user := currentUser(r)
+		if user == nil {
+			h.writeError(w, http.StatusBadRequest, errCodeBadRequest, "API tokens act as the user who creates them, so they need authentication to be enabled with AUTH_MODE", nil)
+			return
+		}
+		var req createTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeBodyError(w, "Invalid request body", err)
+			return
+		}
+		token, secret, err := h.authService.CreateAPIToken(user.ID, req.Name, req.Scopes)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidToken) {
+				h.writeError(w, http.StatusBadRequest, errCodeValidation, err.Error(), nil)
+				return
+			}
+			h.writeInternalError(w, "Failed to create API token", err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(createTokenResponse{APIToken: *token, Token: secret})
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// maxRequestBodySize limits the body of requests other than file uploads
+	maxRequestBodySize = 1 << 20 // 1 MB
+
+	// maxUploadBodySize limits the body of multipart file uploads. Upload
+	// handlers may apply a lower limit.
+	maxUploadBodySize = 10 << 20 // 10 MB
+)
+
+// File types accepted by the upload handlers. The extension of the uploaded
+// filename and the type sniffed from the content must both be allowed.
+var (
+	logoExtensions   = []string{".png", ".jpg", ".jpeg", ".gif"}
+	logoContentTypes = []string{"image/png", "image/jpeg", "image/gif"}
+
+	csvExtensions   = []string{".csv"}
+	csvContentTypes = []string{"text/plain", "text/csv"}
+)
+
+// errUnsupportedFile is returned for uploads whose name or content is not an allowed type
+var errUnsupportedFile = errors.New("unsupported file type")
+
+// LimitRequestBody rejects request bodies larger than maxRequestBodySize, or
+// maxUploadBodySize for multipart uploads
+func (h *AppHandler) LimitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := int64(maxRequestBodySize)
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+			limit = maxUploadBodySize
+		}
+
+		if r.ContentLength > limit {
+			h.logger.Warn("Rejected %s %s with a %d byte body", r.Method, r.URL.Path, r.ContentLength)
+			h.writeError(w, http.StatusRequestEntityTooLarge, errCodeTooLarge, fmt.Sprintf("Request body is larger than %d bytes", limit), nil)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeBodyError responds to a request body that could not be read or parsed.
+// Bodies cut off by a size limit get 413, anything else 400 with message.
+func (h *AppHandler) writeBodyError(w http.ResponseWriter, message string, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		h.writeError(w, http.StatusRequestEntityTooLarge, errCodeTooLarge, fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit), nil)
+		return
+	}
+	h.writeError(w, http.StatusBadRequest, errCodeBadRequest, message, nil)
+}
+
+// checkUploadedFile verifies that an uploaded file has one of the allowed
+// extensions and that its content, rather than the declared content type,
+// sniffs as one of the allowed media types. The file is rewound afterwards.
+func checkUploadedFile(file multipart.File, header *multipart.FileHeader, extensions, contentTypes []string) error {
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	if !slices.Contains(extensions, ext) {
+		return fmt.Errorf("%w: extension %q", errUnsupportedFile, ext)
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind upload: %w", err)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if !slices.Contains(contentTypes, mediaType) {
+		return fmt.Errorf("%w: content sniffed as %q", errUnsupportedFile, mediaType)
+	}
+	return nil
+}
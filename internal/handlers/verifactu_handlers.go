@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/0dragosh/simple-invoice/internal/services"
+)
+
+// invoiceVerifactuHandler handles /api/invoices/{id}/verifactu: GET shows the
+// latest Verifactu record of the invoice and POST sends it to AEAT now, with a
+// correcting record when AEAT rejected the last one
+func (h *AppHandler) invoiceVerifactuHandler(w http.ResponseWriter, r *http.Request, id int) {
+	switch r.Method {
+	case http.MethodGet:
+		if _, _, err := h.invoices.GetInvoice(id); err != nil {
+			h.writeVerifactuError(w, id, err)
+			return
+		}
+		record, err := h.verifactuService.Latest(id)
+		if err != nil {
+			h.writeInternalError(w, "Failed to load the Verifactu record", err)
+			return
+		}
+		if record == nil {
+			h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice %d has no Verifactu record", id), nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(record)
+
+	case http.MethodPost:
+		record, err := h.verifactuService.Submit(id)
+		if err != nil {
+			h.writeVerifactuError(w, id, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(record)
+
+	default:
+		h.writeMethodNotAllowed(w)
+	}
+}
+
+// writeVerifactuError reports why an invoice could not be registered with Verifactu
+func (h *AppHandler) writeVerifactuError(w http.ResponseWriter, id int, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		h.writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Invoice not found with ID: %d", id), nil)
+	case errors.Is(err, services.ErrVerifactuInvalid):
+		h.writeError(w, http.StatusUnprocessableEntity, errCodeValidation, err.Error(), nil)
+	case errors.Is(err, services.ErrVerifactuNotConfigured):
+		h.writeError(w, http.StatusServiceUnavailable, errCodeVerifactuFailed, err.Error(), nil)
+	default:
+		h.writeInternalError(w, "Failed to register the invoice with Verifactu", err)
+	}
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// AccountingConnection is OAuth access to an organization in accounting
+// software, to which finalized invoices and their payments are pushed
+type AccountingConnection struct {
+	Provider     string    `json:"provider"`
+	TenantID     string    `json:"tenant_id"` // Xero organization or QuickBooks company (realm) ID
+	TenantName   string    `json:"tenant_name"`
+	ConnectedAt  time.Time `json:"connected_at"`
+	LastSyncedAt time.Time `json:"last_synced_at"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// Accounting sync statuses of an invoice
+const (
+	AccountingSyncPending = "pending" // Not pushed yet, or changed since it was pushed
+	AccountingSyncSynced  = "synced"
+	AccountingSyncFailed  = "failed"  // The last push failed, it is retried on the next sync
+	AccountingSyncSkipped = "skipped" // Drafts and pro-forma invoices are not pushed
+)
+
+// AccountingSyncStatus tells whether an invoice and its payment are in the
+// connected accounting software
+type AccountingSyncStatus struct {
+	Provider        string    `json:"provider"`
+	Status          string    `json:"status"`
+	RemoteID        string    `json:"remote_id,omitempty"`         // ID of the invoice at the provider
+	PaymentRemoteID string    `json:"payment_remote_id,omitempty"` // ID of the payment, once a paid invoice was synced
+	LastError       string    `json:"last_error,omitempty"`
+	SyncedAt        time.Time `json:"synced_at"`
+}
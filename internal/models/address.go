@@ -0,0 +1,11 @@
+package models
+
+// AddressSuggestion is an address found for a search, split into the
+// address fields of a client
+type AddressSuggestion struct {
+	Label      string `json:"label"` // The full address as shown in the suggestion list
+	Address    string `json:"address"`
+	City       string `json:"city"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"` // ISO 3166-1 alpha-2 code
+}
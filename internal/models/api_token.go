@@ -0,0 +1,61 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// API token scopes. Every scope allows reading; the others also allow changes.
+const (
+	TokenScopeRead          = "read"           // Read-only access to the API
+	TokenScopeInvoicesWrite = "invoices:write" // Create and change invoices, clients and the rest of the bookkeeping
+	TokenScopeBackupsAdmin  = "backups:admin"  // List, create, download, delete and restore backups
+)
+
+// TokenScopes lists the scopes an API token can be granted
+var TokenScopes = []string{TokenScopeRead, TokenScopeInvoicesWrite, TokenScopeBackupsAdmin}
+
+// APIToken is a named token scripts use to call the API as the user who
+// created it. Only a hash of the token is stored; it is shown once, when
+// it is created.
+type APIToken struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	Username   string    `json:"username"` // The user who created the token
+	Name       string    `json:"name"`
+	Prefix     string    `json:"prefix"` // Start of the token, to tell tokens apart
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"` // Zero until the token is used
+	RevokedAt  time.Time `json:"revoked_at"`   // Zero unless the token was revoked
+}
+
+// Revoked reports whether the token was revoked
+func (t *APIToken) Revoked() bool {
+	return !t.RevokedAt.IsZero()
+}
+
+// Allows reports whether the token grants scope. Any scope grants read access.
+func (t *APIToken) Allows(scope string) bool {
+	return scope == TokenScopeRead && len(t.Scopes) > 0 || slices.Contains(t.Scopes, scope)
+}
+
+// NormalizeTokenScopes trims, sorts and deduplicates scopes and checks that
+// they are known and at least one is given
+func NormalizeTokenScopes(scopes []string) ([]string, error) {
+	var normalized []string
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !slices.Contains(TokenScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q, expected %s", scope, strings.Join(TokenScopes, ", "))
+		}
+		normalized = append(normalized, scope)
+	}
+	if len(normalized) == 0 {
+		return nil, fmt.Errorf("a token needs at least one scope")
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized), nil
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// AuditEntry records a change that has to be traceable later, such as a data erasure
+type AuditEntry struct {
+	ID         int       `json:"id"`
+	Action     string    `json:"action"`
+	EntityType string    `json:"entity_type"`
+	EntityID   int       `json:"entity_id"`
+	Details    string    `json:"details"`
+	CreatedAt  time.Time `json:"created_at"`
+}
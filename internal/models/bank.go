@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// BankTransaction is a booking read from a bank statement. Incoming payments
+// have a positive amount, outgoing payments a negative one.
+type BankTransaction struct {
+	ID           int       `json:"id,omitempty"` // Set for transactions synced from a bank
+	Date         time.Time `json:"date"`
+	Amount       Money     `json:"amount"`
+	Currency     string    `json:"currency"`
+	Counterparty string    `json:"counterparty"` // Name of the payer
+	IBAN         string    `json:"iban,omitempty"`
+	Reference    string    `json:"reference"` // Remittance information, usually the invoice number
+}
+
+// IsCredit reports whether the transaction is an incoming payment
+func (t BankTransaction) IsCredit() bool {
+	return t.Amount > 0
+}
+
+// Bank connection statuses
+const (
+	BankConnectionPending  = "pending"  // Waiting for the account holder to grant access at the bank
+	BankConnectionLinked   = "linked"   // Transactions can be synced
+	BankConnectionExpired  = "expired"  // Access ran out or was suspended, connect the bank again
+	BankConnectionRejected = "rejected" // The account holder did not grant access
+)
+
+// BankConnection is access to the accounts at a bank granted through an
+// open-banking provider, from which incoming payments are synced
+type BankConnection struct {
+	ID              int       `json:"id"`
+	RequisitionID   string    `json:"requisition_id"` // ID of the access request at the provider
+	InstitutionID   string    `json:"institution_id"`
+	InstitutionName string    `json:"institution_name"`
+	Status          string    `json:"status"`
+	Link            string    `json:"link,omitempty"` // Where access is granted while pending
+	AccountIDs      []string  `json:"account_ids"`
+	CreatedAt       time.Time `json:"created_at"`
+	LastSyncedAt    time.Time `json:"last_synced_at"`
+	LastError       string    `json:"last_error,omitempty"`
+}
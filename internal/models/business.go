@@ -1,5 +1,10 @@
 package models
 
+import (
+	"strings"
+	"time"
+)
+
 // Business represents the consultant's business details
 type Business struct {
 	ID                  int    `json:"id"`
@@ -21,7 +26,50 @@ type Business struct {
 	SecondCurrency      string `json:"second_currency"`
 	ExtraBusinessDetail string `json:"extra_business_detail"`
 	LogoPath            string `json:"logo_path"`
-	LogoURL             string `json:"logo_url"` // URL to display the logo, without the /app prefix
+	LogoURL             string `json:"logo_url"`        // URL to display the logo, without the /app prefix
+	EmailSignature      string `json:"email_signature"` // Appended to emails sent to clients
+
+	// Businesses not registered for VAT under a small-business scheme, such as
+	// German § 19 UStG, charge no VAT and print an exemption clause instead
+	VatExempt          bool   `json:"vat_exempt"`
+	VatExemptionClause string `json:"vat_exemption_clause"` // Empty uses the built-in clause of the business country
+
+	// The working time the hours of a month are pre-filled from
+	WorkHoursPerDay float64        `json:"work_hours_per_day"` // 0 uses DefaultWorkHoursPerDay
+	WorkDays        []time.Weekday `json:"work_days"`          // 0 is Sunday; empty uses DefaultWorkDays
+	HolidayCountry  string         `json:"holiday_country"`    // Public holidays taken off; empty for none
+
+	// Businesses that accept USDC print the address to send it to, with a QR code
+	CryptoAddress string `json:"crypto_address"` // Empty for none
+	CryptoNetwork string `json:"crypto_network"` // The network the address is on, such as Ethereum or Solana
+
+	Version int `json:"version"` // Incremented on every update, used for optimistic locking
+}
+
+// vatExemptionClauses are the built-in small-business exemption clauses by
+// country code of the business
+var vatExemptionClauses = map[string]string{
+	"AT": "Umsatzsteuerfrei aufgrund der Kleinunternehmerregelung gemäß § 6 Abs. 1 Z 27 UStG.",
+	"DE": "Gemäß § 19 UStG wird keine Umsatzsteuer berechnet.",
+	"FR": "TVA non applicable, art. 293 B du CGI.",
+	"IT": "Operazione effettuata ai sensi dell'art. 1, commi 54-89, Legge n. 190/2014 (regime forfettario).",
+	"NL": "Vrijgesteld van btw op grond van de kleineondernemersregeling (KOR).",
+	"RO": "Scutit de TVA conform art. 310 din Codul fiscal (regim special de scutire pentru întreprinderile mici).",
+}
+
+// defaultVatExemptionClause is printed for countries without a built-in clause
+const defaultVatExemptionClause = "No VAT is charged: the supplier is exempt from VAT under the small-business scheme."
+
+// ExemptionClause returns the clause printed on invoices of a VAT exempt
+// business: the business's own clause, or else the one of its country
+func (b *Business) ExemptionClause() string {
+	if clause := strings.TrimSpace(b.VatExemptionClause); clause != "" {
+		return clause
+	}
+	if clause, ok := vatExemptionClauses[strings.ToUpper(strings.TrimSpace(b.Country))]; ok {
+		return clause
+	}
+	return defaultVatExemptionClause
 }
 
 // GetLogoURL returns the correct URL to display the logo
@@ -30,6 +78,11 @@ func (b *Business) GetLogoURL() string {
 		return ""
 	}
 
+	// Uploaded logos are stored by filename in the images directory
+	if !strings.Contains(b.LogoPath, "/") {
+		return "/data/images/" + b.LogoPath
+	}
+
 	// Strip the /app prefix if it exists
 	logoPath := b.LogoPath
 	if len(logoPath) >= 4 && logoPath[:4] == "/app" {
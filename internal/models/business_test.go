@@ -0,0 +1,79 @@
+package models
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBusinessExemptionClause(t *testing.T) {
+	business := Business{Country: "de", VatExempt: true}
+	if got := business.ExemptionClause(); got != "Gemäß § 19 UStG wird keine Umsatzsteuer berechnet." {
+		t.Errorf("Expected the § 19 UStG clause for German businesses, got %q", got)
+	}
+
+	business.Country = "Germany"
+	if got := business.ExemptionClause(); got != defaultVatExemptionClause {
+		t.Errorf("Expected the default clause for an unknown country, got %q", got)
+	}
+
+	business.VatExemptionClause = "  Kleinunternehmer, keine USt.  "
+	if got := business.ExemptionClause(); got != "Kleinunternehmer, keine USt." {
+		t.Errorf("Expected the business's own clause, got %q", got)
+	}
+}
+
+func TestBusinessWorkMonth(t *testing.T) {
+	var business Business
+	if month := business.WorkMonth(2024, time.May, nil); month.TotalHours != 23*8 || len(month.Days) != 31 {
+		t.Errorf("Expected 23 working days of 8 hours in May 2024 by default, got %v hours in %d days", month.TotalHours, len(month.Days))
+	}
+
+	// Ascension Day and Whit Monday fall on working days, Labour Day on the
+	// Wednesday off
+	business = Business{WorkHoursPerDay: 7.5, WorkDays: []time.Weekday{time.Monday, time.Tuesday, time.Thursday, time.Friday}, HolidayCountry: "DE"}
+	holidays := []PublicHoliday{
+		{Date: "2024-05-01", Name: "Labour Day", Country: "DE"},
+		{Date: "2024-05-09", Name: "Ascension Day", Country: "DE"},
+		{Date: "2024-05-20", Name: "Whit Monday", Country: "DE"},
+	}
+	month := business.WorkMonth(2024, time.May, holidays)
+	if month.TotalHours != 16*7.5 || month.HolidayCountry != "DE" || month.Month != "2024-05" {
+		t.Errorf("Expected 16 working days of 7.5 hours in May 2024, got %+v", month)
+	}
+	if day := month.Days[8]; day.Date != "2024-05-09" || day.Hours != 0 || day.Holiday != "Ascension Day" || day.Weekday != "Thursday" {
+		t.Errorf("Expected Ascension Day to be taken off, got %+v", day)
+	}
+	if day := month.Days[0]; day.Holiday != "" || day.Hours != 0 {
+		t.Errorf("Expected a holiday on a day off not to be listed, got %+v", day)
+	}
+}
+
+func TestBusinessWorkPeriod(t *testing.T) {
+	business := Business{HolidayCountry: "DE"}
+	holidays := []PublicHoliday{
+		{Date: "2024-12-25", Name: "Christmas Day", Country: "DE"},
+		{Date: "2025-01-01", Name: "New Year's Day", Country: "DE"},
+	}
+	// Monday 23 December 2024 to Friday 3 January 2025
+	period := business.WorkPeriod(time.Date(2024, time.December, 23, 0, 0, 0, 0, time.UTC), time.Date(2025, time.January, 3, 0, 0, 0, 0, time.UTC), holidays)
+	if period.From != "2024-12-23" || period.To != "2025-01-03" || len(period.Days) != 12 || period.TotalHours != 8*8 {
+		t.Errorf("Expected 8 working days in 12 days over the new year, got %+v", period)
+	}
+	if period.HolidayCountry != "DE" || period.Days[9].Holiday != "New Year's Day" {
+		t.Errorf("Expected New Year's Day to be taken off, got %+v", period)
+	}
+}
+
+func TestParseWorkDays(t *testing.T) {
+	days := []time.Weekday{time.Sunday, time.Monday, time.Saturday}
+	if got := FormatWorkDays(days); got != "0,1,6" {
+		t.Errorf("FormatWorkDays() = %q, want 0,1,6", got)
+	}
+	if got := ParseWorkDays("0, 1,6,7,x"); !slices.Equal(got, days) {
+		t.Errorf("ParseWorkDays() = %v, want %v", got, days)
+	}
+	if got := ParseWorkDays(""); len(got) != 0 {
+		t.Errorf("ParseWorkDays(\"\") = %v, want none", got)
+	}
+}
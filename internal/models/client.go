@@ -4,13 +4,49 @@ import "time"
 
 // Client represents a client's details
 type Client struct {
-	ID          int        `json:"id"`
-	Name        string     `json:"name"`
-	Address     string     `json:"address"`
-	City        string     `json:"city"`
-	PostalCode  string     `json:"postal_code"`
-	Country     string     `json:"country"`
-	VatID       string     `json:"vat_id"`
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Address    string `json:"address"`
+	City       string `json:"city"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+	VatID      string `json:"vat_id"`
+	Email      string `json:"email"`      // Invoices are sent to this address
+	Language   string `json:"language"`   // e.g. de or de-DE; empty uses the default locale
+	RiskNotes  string `json:"risk_notes"` // Free-form notes on the client's credit risk
+
+	// Italian clients receive FatturaPA invoices through SDI at their recipient
+	// code (codice destinatario), or at their certified email address (PEC)
+	// when they have no code
+	SDICode string `json:"sdi_code"`
+	PEC     string `json:"pec"`
+
+	// DateFormat and NumberFormat set how the client's invoices print dates
+	// and numbers, of DateFormats and NumberFormats; empty uses the usual
+	// format of the client's country
+	DateFormat   string `json:"date_format"`
+	NumberFormat string `json:"number_format"`
+
 	CreatedDate *time.Time `json:"created_date"`
 	Deleted     bool       `json:"deleted"`
+	Version     int        `json:"version"` // Incremented on every update, used for optimistic locking
+}
+
+// ClientPaymentStats describes how a client pays its invoices, computed from
+// the invoices issued to it. Drafts and pro-forma invoices are not counted.
+type ClientPaymentStats struct {
+	ClientID int `json:"client_id"`
+	Invoices int `json:"invoices"`
+	Paid     int `json:"paid"`
+
+	// The averages are computed from the paid invoices with a payment date
+	AverageDaysToPay float64 `json:"average_days_to_pay"` // From the issue date to the payment date
+	PaidLate         int     `json:"paid_late"`           // Paid after the due date
+	AverageDaysLate  float64 `json:"average_days_late"`   // Past the due date, of the invoices paid late
+	MaxDaysLate      int     `json:"max_days_late"`
+
+	// Overdue counts the unpaid invoices past their due date, and
+	// OverdueAmounts their amounts due by currency
+	Overdue        int              `json:"overdue"`
+	OverdueAmounts map[string]Money `json:"overdue_amounts"`
 }
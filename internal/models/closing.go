@@ -0,0 +1,80 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// YearClosing records a closed fiscal year. Invoices issued in a closed year
+// can no longer be created, changed or deleted; only their status can change.
+type YearClosing struct {
+	Year            int       `json:"year"`
+	ClosedAt        time.Time `json:"closed_at"`
+	Invoices        int       `json:"invoices"` // Without pro-forma invoices
+	FirstNumber     string    `json:"first_number"`
+	LastNumber      string    `json:"last_number"`
+	MissingNumbers  []string  `json:"missing_numbers"` // Gaps acknowledged when closing, e.g. INV-2024-0002 to INV-2024-0004
+	SummaryFilename string    `json:"summary_filename"`
+}
+
+// SequenceCheck is the result of verifying the invoice numbering of a year
+type SequenceCheck struct {
+	Year        int    `json:"year"`
+	Invoices    int    `json:"invoices"` // Without pro-forma invoices
+	FirstNumber string `json:"first_number"`
+	LastNumber  string `json:"last_number"`
+	// Missing are the first numbers skipped in the sequence, at most 100;
+	// MissingCount is how many are skipped in all, and Gaps the runs of
+	// skipped numbers, at most 100
+	Missing      []string    `json:"missing"`
+	MissingCount int         `json:"missing_count"`
+	Gaps         []NumberGap `json:"gaps"`
+	Duplicates   []string    `json:"duplicates"` // Numbers of the sequence used by more than one invoice
+	// Unsequenced are numbers of invoices issued in the year that were entered
+	// manually and do not follow the generated sequence, including numbers of
+	// the sequence far beyond the number of invoices
+	Unsequenced []string `json:"unsequenced"`
+	// Explanations are the audit log entries of missing numbers whose invoice
+	// was deleted or renumbered, by number. Numbers without an entry were
+	// never used or disappeared without a trace, e.g. in a restored backup.
+	Explanations map[string][]AuditEntry `json:"explanations"`
+}
+
+// NumberGap is a run of consecutive numbers missing from a sequence
+type NumberGap struct {
+	First string `json:"first"`
+	Last  string `json:"last"` // The same as First for a single number
+	Count int    `json:"count"`
+}
+
+// String describes the gap, e.g. INV-2024-0002 to INV-2024-0005
+func (g NumberGap) String() string {
+	if g.Count == 1 {
+		return g.First
+	}
+	return g.First + " to " + g.Last
+}
+
+// HasGaps reports whether numbers are missing from the sequence
+func (c SequenceCheck) HasGaps() bool {
+	return c.MissingCount > 0
+}
+
+// OK reports whether the sequence has neither gaps nor duplicates
+func (c SequenceCheck) OK() bool {
+	return c.MissingCount == 0 && len(c.Duplicates) == 0
+}
+
+// GapList describes the gaps listed, with a note on the ones left out
+func (c SequenceCheck) GapList() []string {
+	list := make([]string, 0, len(c.Gaps)+1)
+	listed := 0
+	for _, gap := range c.Gaps {
+		list = append(list, gap.String())
+		listed += gap.Count
+	}
+	if listed < c.MissingCount {
+		list = append(list, fmt.Sprintf("%d more", c.MissingCount-listed))
+	}
+	return list
+}
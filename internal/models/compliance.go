@@ -0,0 +1,62 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Fields a compliance profile can require before an invoice is finalized
+const (
+	ComplianceBusinessVatID   = "business.vat_id"
+	ComplianceBusinessAddress = "business.address" // Street, postal code and city
+	ComplianceBusinessIBAN    = "business.iban"
+	ComplianceClientAddress   = "client.address" // Street, postal code and city
+	ComplianceClientVatID     = "client.vat_id"
+	ComplianceClientSDI       = "client.sdi" // SDI recipient code or PEC address, for clients in Italy
+	ComplianceServicePeriod   = "invoice.service_period"
+	CompliancePONumber        = "invoice.po_number"
+)
+
+// ComplianceFields lists the fields a compliance profile can require
+var ComplianceFields = []string{
+	ComplianceBusinessVatID, ComplianceBusinessAddress, ComplianceBusinessIBAN,
+	ComplianceClientAddress, ComplianceClientVatID, ComplianceClientSDI,
+	ComplianceServicePeriod, CompliancePONumber,
+}
+
+// E-invoicing formats a compliance profile can enable
+const (
+	ComplianceFatturaPA = "fatturapa"
+	ComplianceKSeF      = "ksef"
+	ComplianceVerifactu = "verifactu"
+	ComplianceNAV       = "nav"
+)
+
+// ComplianceFormats lists the e-invoicing formats a compliance profile can enable
+var ComplianceFormats = []string{ComplianceFatturaPA, ComplianceKSeF, ComplianceVerifactu, ComplianceNAV}
+
+// ComplianceProfile holds the fiscal rules for the invoices of businesses in
+// a country: the fields that must be filled in before an invoice is
+// finalized, legal clauses printed on every invoice, how invoices are
+// numbered and which e-invoicing formats apply. Built-in profiles exist for
+// some countries and can be customized; businesses in other countries follow
+// no profile.
+type ComplianceProfile struct {
+	Country        string   `json:"country"` // Two-letter country code of the business, e.g. DE
+	Name           string   `json:"name"`
+	RequiredFields []string `json:"required_fields"` // Of ComplianceFields
+	Clauses        []string `json:"clauses"`         // Printed on every invoice, after the VAT clauses
+	// GaplessNumbering keeps finalized invoices from being deleted, so their
+	// numbers leave no gap; drafts and pro-forma invoices can still be deleted
+	GaplessNumbering bool      `json:"gapless_numbering"`
+	NumberMaxLength  int       `json:"number_max_length,omitempty"` // 0 for no limit
+	NumberPattern    string    `json:"number_pattern,omitempty"`    // Regular expression invoice numbers must match, empty for any
+	ExportFormats    []string  `json:"export_formats"`              // Of ComplianceFormats
+	BuiltIn          bool      `json:"built_in"`                    // Not customized
+	UpdatedAt        time.Time `json:"updated_at"`                  // Zero for built-in profiles
+}
+
+// HasFormat reports whether the profile enables an e-invoicing format
+func (p *ComplianceProfile) HasFormat(format string) bool {
+	return slices.Contains(p.ExportFormats, format)
+}
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Contract billing modes: invoices for a month are generated on its first day
+// (in advance) or on its last day (in arrears)
+const (
+	ContractBillingAdvance = "advance"
+	ContractBillingArrears = "arrears"
+)
+
+// ContractBillingModes lists the supported billing modes
+var ContractBillingModes = []string{ContractBillingAdvance, ContractBillingArrears}
+
+// DefaultContractPaymentTermDays is the payment term of contract invoices
+// when none is set
+const DefaultContractPaymentTermDays = 30
+
+// Contract is a retainer billed monthly. A draft invoice for the monthly
+// amount is generated for every month it runs; the months it starts or ends
+// in are prorated by the days covered.
+type Contract struct {
+	ID               int       `json:"id"`
+	BusinessID       int       `json:"business_id"`
+	ClientID         int       `json:"client_id"`
+	Name             string    `json:"name"`      // Invoice item description, e.g. Development retainer
+	Reference        string    `json:"reference"` // Contract reference printed on the invoices
+	StartDate        time.Time `json:"start_date"`
+	EndDate          time.Time `json:"end_date"` // Zero for open-ended contracts
+	MonthlyAmount    Money     `json:"monthly_amount"`
+	Currency         string    `json:"currency"`
+	VatRate          float64   `json:"vat_rate"`
+	ReverseChargeVat bool      `json:"reverse_charge_vat"`
+	PaymentTermDays  int       `json:"payment_term_days"` // Days until the invoices are due, 0 uses DefaultContractPaymentTermDays
+	Billing          string    `json:"billing"`           // One of ContractBillingModes, in advance by default
+	Active           bool      `json:"active"`            // Paused contracts generate no invoices
+
+	// InvoicedThrough is the last day invoiced, zero before the first invoice.
+	// Setting it skips the months invoiced by hand.
+	InvoicedThrough time.Time `json:"invoiced_through"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// ContractPeriod is the part of a calendar month a contract invoice covers
+type ContractPeriod struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Days      int       `json:"days"`       // Days covered
+	MonthDays int       `json:"month_days"` // Days of the month
+	Amount    Money     `json:"amount"`     // Monthly amount prorated by the days covered
+}
+
+// ContractInvoice links an invoice generated for a contract to the period it covers
+type ContractInvoice struct {
+	ContractID    int       `json:"contract_id"`
+	InvoiceID     int       `json:"invoice_id"`
+	InvoiceNumber string    `json:"invoice_number"`
+	PeriodStart   time.Time `json:"period_start"`
+	PeriodEnd     time.Time `json:"period_end"`
+}
+
+// Validate checks a contract before it is saved
+func (c *Contract) Validate() error {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Reference = strings.TrimSpace(c.Reference)
+	if c.Billing == "" {
+		c.Billing = ContractBillingAdvance
+	}
+	if c.PaymentTermDays == 0 {
+		c.PaymentTermDays = DefaultContractPaymentTermDays
+	}
+	switch {
+	case c.ClientID == 0:
+		return errors.New("client is required")
+	case c.BusinessID == 0:
+		return errors.New("business is required")
+	case c.Name == "":
+		return errors.New("name is required")
+	case c.StartDate.IsZero():
+		return errors.New("start date is required")
+	case !c.EndDate.IsZero() && c.EndDate.Before(c.StartDate):
+		return errors.New("end date must not be before the start date")
+	case c.MonthlyAmount <= 0:
+		return errors.New("monthly amount must be positive")
+	case c.VatRate < 0 || c.VatRate > 100:
+		return errors.New("VAT rate must be between 0 and 100")
+	case c.PaymentTermDays < 0:
+		return errors.New("payment term must not be negative")
+	case !slices.Contains(ContractBillingModes, c.Billing):
+		return fmt.Errorf("billing must be one of %s", strings.Join(ContractBillingModes, ", "))
+	}
+	return nil
+}
+
+// Ended reports whether the contract has been invoiced through its end date
+func (c *Contract) Ended() bool {
+	return !c.EndDate.IsZero() && !c.InvoicedThrough.Before(c.EndDate)
+}
+
+// DuePeriods returns the periods that are not invoiced yet and due on the
+// given day: those starting on or before it when billed in advance, those
+// ending on or before it when billed in arrears
+func (c *Contract) DuePeriods(today time.Time) []ContractPeriod {
+	today = dateOf(today)
+	start := dateOf(c.StartDate)
+	if !c.InvoicedThrough.IsZero() && !c.InvoicedThrough.Before(start) {
+		start = dateOf(c.InvoicedThrough).AddDate(0, 0, 1)
+	}
+
+	var periods []ContractPeriod
+	for c.EndDate.IsZero() || !start.After(c.EndDate) {
+		period := c.period(start)
+		due := period.Start
+		if c.Billing == ContractBillingArrears {
+			due = period.End
+		}
+		if due.After(today) {
+			break
+		}
+		periods = append(periods, period)
+		start = period.End.AddDate(0, 0, 1)
+	}
+	return periods
+}
+
+// period returns the period from start to the end of its month, or to the
+// end of the contract when that comes first
+func (c *Contract) period(start time.Time) ContractPeriod {
+	first := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+	last := first.AddDate(0, 1, -1)
+	end := last
+	if !c.EndDate.IsZero() && dateOf(c.EndDate).Before(end) {
+		end = dateOf(c.EndDate)
+	}
+
+	period := ContractPeriod{
+		Start:     start,
+		End:       end,
+		Days:      int(end.Sub(start).Hours()/24) + 1,
+		MonthDays: last.Day(),
+		Amount:    c.MonthlyAmount,
+	}
+	if period.Prorated() {
+		period.Amount = c.MonthlyAmount.Mul(float64(period.Days) / float64(period.MonthDays)).Round(c.Currency)
+	}
+	return period
+}
+
+// Prorated reports whether the period covers only part of its month
+func (p ContractPeriod) Prorated() bool {
+	return p.Days < p.MonthDays
+}
+
+// InvoiceItem returns the item an invoice for the period bills, e.g.
+// "Retainer, May 2024" or "Retainer, 16–31 May 2024 (16 of 31 days)"
+func (c *Contract) InvoiceItem(p ContractPeriod) InvoiceItem {
+	description := fmt.Sprintf("%s, %s", c.Name, p.Start.Format("January 2006"))
+	if p.Prorated() {
+		description = fmt.Sprintf("%s, %d–%d %s (%d of %d days)", c.Name, p.Start.Day(), p.End.Day(), p.Start.Format("January 2006"), p.Days, p.MonthDays)
+	}
+	return InvoiceItem{
+		Description: description,
+		Quantity:    1,
+		Unit:        UnitFlat,
+		UnitPrice:   p.Amount,
+		Amount:      p.Amount,
+	}
+}
+
+// dateOf returns the calendar day of t at midnight UTC, the way dates are stored
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestContractDuePeriods(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	contract := Contract{
+		ClientID:      1,
+		BusinessID:    1,
+		Name:          "Retainer",
+		StartDate:     date(2024, 5, 16),
+		EndDate:       date(2024, 7, 10),
+		MonthlyAmount: 310000,
+		Currency:      "EUR",
+	}
+	if err := contract.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	// Billed in advance, each month is due on the day it starts
+	periods := contract.DuePeriods(date(2024, 6, 1))
+	if len(periods) != 2 {
+		t.Fatalf("Expected May and June to be due, got %v", periods)
+	}
+	if p := periods[0]; !p.Start.Equal(date(2024, 5, 16)) || !p.End.Equal(date(2024, 5, 31)) || p.Days != 16 || p.Amount != 160000 {
+		t.Errorf("Unexpected first period: %+v", p)
+	}
+	if p := periods[1]; p.Prorated() || p.Amount != 310000 {
+		t.Errorf("Expected June in full, got %+v", p)
+	}
+	if item := contract.InvoiceItem(periods[0]); item.Description != "Retainer, 16–31 May 2024 (16 of 31 days)" || item.Amount != 160000 {
+		t.Errorf("Unexpected prorated item: %+v", item)
+	}
+	if item := contract.InvoiceItem(periods[1]); item.Description != "Retainer, June 2024" {
+		t.Errorf("Unexpected item: %+v", item)
+	}
+
+	// Months already invoiced are skipped and the last month ends with the contract
+	contract.InvoicedThrough = date(2024, 6, 30)
+	periods = contract.DuePeriods(date(2024, 12, 1))
+	if len(periods) != 1 || !periods[0].End.Equal(date(2024, 7, 10)) || periods[0].Amount != 100000 {
+		t.Fatalf("Expected 1–10 July, got %v", periods)
+	}
+	contract.InvoicedThrough = periods[0].End
+	if !contract.Ended() || len(contract.DuePeriods(date(2025, 1, 1))) != 0 {
+		t.Errorf("Expected the contract to have ended")
+	}
+
+	// Billed in arrears, a month is due on its last day
+	contract.Billing = ContractBillingArrears
+	contract.InvoicedThrough = time.Time{}
+	if periods := contract.DuePeriods(date(2024, 5, 30)); len(periods) != 0 {
+		t.Errorf("Expected nothing due before the end of May, got %v", periods)
+	}
+	if periods := contract.DuePeriods(date(2024, 5, 31)); len(periods) != 1 {
+		t.Errorf("Expected May to be due on its last day, got %v", periods)
+	}
+
+	invalid := contract
+	invalid.EndDate = date(2024, 5, 1)
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected an end date before the start to be rejected")
+	}
+	invalid = contract
+	invalid.Billing = "weekly"
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected an unknown billing mode to be rejected")
+	}
+}
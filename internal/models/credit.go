@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// ClientCredit is an entry in the credit ledger of a client. Prepayments and
+// retainers add credit; applying credit to an invoice adds a negative entry
+// linked to that invoice.
+type ClientCredit struct {
+	ID          int       `json:"id"`
+	ClientID    int       `json:"client_id"`
+	InvoiceID   int       `json:"invoice_id,omitempty"` // Set when the credit was applied to an invoice
+	Amount      Money     `json:"amount"`
+	Currency    string    `json:"currency"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// CreditBalance is the remaining credit of a client in one currency
+type CreditBalance struct {
+	Currency string `json:"currency"`
+	Amount   Money  `json:"amount"`
+}
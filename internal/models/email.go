@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// EmailTemplate is the subject and body of an email sent to clients, such as
+// the message an invoice is sent with, in one language
+type EmailTemplate struct {
+	Kind      string    `json:"kind"`     // invoice, reminder or receipt
+	Language  string    `json:"language"` // e.g. en or de-DE
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+	BuiltIn   bool      `json:"built_in"` // Not customized; the default text is used
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Delivery statuses of emails sent to clients
+const (
+	EmailStatusSent    = "sent"
+	EmailStatusBounced = "bounced"
+)
+
+// InvoiceEmail records an email sent for an invoice, so bounce messages can
+// be matched to it by its Message-ID
+type InvoiceEmail struct {
+	ID           int        `json:"id"`
+	InvoiceID    int        `json:"invoice_id"`
+	Kind         string     `json:"kind"` // Email template kind, e.g. invoice
+	Recipient    string     `json:"recipient"`
+	MessageID    string     `json:"message_id"`
+	Status       string     `json:"status"` // sent or bounced
+	BounceReason string     `json:"bounce_reason,omitempty"`
+	SentAt       time.Time  `json:"sent_at"`
+	BouncedAt    *time.Time `json:"bounced_at,omitempty"`
+}
+
+// ScheduledEmail is an invoice email to be sent at a later time, e.g. on the
+// 1st of the month. A send_invoice_email job due at SendAt sends it.
+type ScheduledEmail struct {
+	ID        int       `json:"id"`
+	InvoiceID int       `json:"invoice_id"`
+	Kind      string    `json:"kind"` // Email template kind, e.g. invoice
+	Recipient string    `json:"recipient"`
+	SendAt    time.Time `json:"send_at"`
+	JobID     int       `json:"job_id"`
+	JobStatus string    `json:"job_status"`           // pending, running or failed
+	LastError string    `json:"last_error,omitempty"` // Why the last attempt to send it failed
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+// Kinds of invoice items. Services are what the business is paid for; travel
+// expenses are billed as mileage by the kilometre and per diems by the day;
+// disbursements are costs paid in the client's name and passed on at cost.
+const (
+	ItemKindService      = "service"
+	ItemKindMileage      = "mileage"
+	ItemKindPerDiem      = "per_diem"
+	ItemKindDisbursement = "disbursement"
+)
+
+// ItemKinds lists the kinds of invoice items in display order
+var ItemKinds = []string{ItemKindService, ItemKindMileage, ItemKindPerDiem, ItemKindDisbursement}
+
+// ItemKindLabels name the kinds of invoice items
+var ItemKindLabels = map[string]string{
+	ItemKindService:      "Service",
+	ItemKindMileage:      "Mileage",
+	ItemKindPerDiem:      "Per diem",
+	ItemKindDisbursement: "Disbursement",
+}
+
+// IsExpense reports whether the item bills an expense rather than a service
+func (item *InvoiceItem) IsExpense() bool {
+	return item.Kind != "" && item.Kind != ItemKindService
+}
+
+// ExpenseDescription describes a mileage or per-diem item from its quantity
+// and rate, e.g. 420 km × 0.30 EUR/km, or returns an empty string for other
+// items
+func (item *InvoiceItem) ExpenseDescription(currency string) string {
+	quantity := strconv.FormatFloat(item.Quantity, 'f', -1, 64)
+	switch item.Kind {
+	case ItemKindMileage:
+		return quantity + " km × " + item.UnitPrice.String() + " " + currency + "/km"
+	case ItemKindPerDiem:
+		days := " days × "
+		if item.Quantity == 1 {
+			days = " day × "
+		}
+		return "Per diem, " + quantity + days + item.UnitPrice.String() + " " + currency + "/day"
+	}
+	return ""
+}
+
+// ExpenseRates holds the mileage rate and the per-diem allowance for travel
+// in a country. Built-in rates exist for some countries and can be customized.
+type ExpenseRates struct {
+	Country     string `json:"country"`  // Two-letter country code, e.g. DE
+	Currency    string `json:"currency"` // Of the rates
+	MileageRate Money  `json:"mileage_rate"`
+	PerDiem     Money  `json:"per_diem"` // For a full day
+	// VatExempt bills mileage and per diems outside the VAT base, where they
+	// are reimbursed rather than charged as part of the service
+	VatExempt bool      `json:"vat_exempt"`
+	BuiltIn   bool      `json:"built_in"`   // Not customized
+	UpdatedAt time.Time `json:"updated_at"` // Zero for built-in rates
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// FatturaPAFile is a FatturaPA file generated for an invoice. Its name
+// carries a progressive number unique to the transmitter, so a file that was
+// sent to SDI is never reused: sending the invoice again creates a new file.
+type FatturaPAFile struct {
+	ID        int       `json:"id"`
+	InvoiceID int       `json:"invoice_id"`
+	Filename  string    `json:"filename"` // e.g. IT01234567890_0000A.xml
+	CreatedAt time.Time `json:"created_at"`
+
+	// Where and when the file was sent to SDI by PEC; empty until it is sent
+	SentTo    string    `json:"sent_to,omitempty"`
+	SentAt    time.Time `json:"sent_at"`
+	MessageID string    `json:"message_id,omitempty"`
+}
+
+// Sent reports whether the file was sent to SDI
+func (f *FatturaPAFile) Sent() bool {
+	return !f.SentAt.IsZero()
+}
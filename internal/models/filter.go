@@ -0,0 +1,120 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxTagLength is the maximum length of an invoice tag in characters
+const MaxTagLength = 50
+
+// NormalizeTags trims the tags, drops empty ones and duplicates, ignoring
+// case, and sorts them. Tags may not contain commas, which separate them in
+// forms, or be longer than MaxTagLength.
+func NormalizeTags(tags []string) ([]string, error) {
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.Join(strings.Fields(tag), " ")
+		if tag == "" {
+			continue
+		}
+		if strings.Contains(tag, ",") {
+			return nil, fmt.Errorf("tag %q must not contain a comma", tag)
+		}
+		if len([]rune(tag)) > MaxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, MaxTagLength)
+		}
+		if !slices.ContainsFunc(normalized, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			normalized = append(normalized, tag)
+		}
+	}
+	slices.SortFunc(normalized, func(a, b string) int { return strings.Compare(strings.ToLower(a), strings.ToLower(b)) })
+	return normalized, nil
+}
+
+// InvoiceFilter selects invoices in list endpoints and saved filters. Zero
+// fields do not filter.
+type InvoiceFilter struct {
+	ClientID int
+	Status   string
+	Tags     []string // Invoices must have all of them, ignoring case
+}
+
+// ParseInvoiceFilter reads a filter from the client_id, status and tag query
+// parameters; tag may be repeated or hold comma-separated tags
+func ParseInvoiceFilter(query url.Values) (InvoiceFilter, error) {
+	var filter InvoiceFilter
+	if value := query.Get("client_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			return filter, fmt.Errorf("invalid client_id %q", value)
+		}
+		filter.ClientID = id
+	}
+	filter.Status = strings.TrimSpace(query.Get("status"))
+
+	var tags []string
+	for _, value := range query["tag"] {
+		tags = append(tags, strings.Split(value, ",")...)
+	}
+	var err error
+	if filter.Tags, err = NormalizeTags(tags); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// Query returns the filter as query parameters, the inverse of ParseInvoiceFilter
+func (f InvoiceFilter) Query() url.Values {
+	query := url.Values{}
+	if f.ClientID != 0 {
+		query.Set("client_id", strconv.Itoa(f.ClientID))
+	}
+	if f.Status != "" {
+		query.Set("status", f.Status)
+	}
+	for _, tag := range f.Tags {
+		query.Add("tag", tag)
+	}
+	return query
+}
+
+// IsZero reports whether the filter selects every invoice
+func (f InvoiceFilter) IsZero() bool {
+	return f.ClientID == 0 && f.Status == "" && len(f.Tags) == 0
+}
+
+// Matches reports whether the filter selects the invoice
+func (f InvoiceFilter) Matches(invoice *Invoice) bool {
+	if f.ClientID != 0 && invoice.ClientID != f.ClientID {
+		return false
+	}
+	if f.Status != "" && invoice.Status != f.Status {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !slices.ContainsFunc(invoice.Tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// SavedFilter is a named invoice filter preset of a user
+type SavedFilter struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"` // 0 when authentication is disabled
+	Name      string    `json:"name"`
+	Query     string    `json:"query"` // Query string of the invoice list, e.g. status=sent&tag=2024-Q1
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TagCount is a tag in use and the number of invoices that have it
+type TagCount struct {
+	Tag      string `json:"tag"`
+	Invoices int    `json:"invoices"`
+}
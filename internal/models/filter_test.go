@@ -0,0 +1,71 @@
+package models
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := NormalizeTags([]string{"  retainer ", "2024-Q1", "", "Retainer", "client  work"})
+	if err != nil {
+		t.Fatalf("NormalizeTags failed: %v", err)
+	}
+	expected := []string{"2024-Q1", "client work", "retainer"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Expected %v, got %v", expected, tags)
+	}
+
+	if tags, err := NormalizeTags(nil); err != nil || tags == nil || len(tags) != 0 {
+		t.Errorf("Expected an empty list, got %v, %v", tags, err)
+	}
+	if _, err := NormalizeTags([]string{"a,b"}); err == nil {
+		t.Error("Expected an error for a tag with a comma")
+	}
+	if _, err := NormalizeTags([]string{strings.Repeat("x", MaxTagLength+1)}); err == nil {
+		t.Error("Expected an error for a tag that is too long")
+	}
+}
+
+func TestParseInvoiceFilter(t *testing.T) {
+	query, _ := url.ParseQuery("client_id=7&status=sent&tag=retainer,2024-Q1&tag=Retainer")
+	filter, err := ParseInvoiceFilter(query)
+	if err != nil {
+		t.Fatalf("ParseInvoiceFilter failed: %v", err)
+	}
+	if filter.ClientID != 7 || filter.Status != "sent" || !reflect.DeepEqual(filter.Tags, []string{"2024-Q1", "retainer"}) {
+		t.Errorf("Unexpected filter: %+v", filter)
+	}
+	if encoded := filter.Query().Encode(); encoded != "client_id=7&status=sent&tag=2024-Q1&tag=retainer" {
+		t.Errorf("Unexpected query: %s", encoded)
+	}
+
+	if filter, err := ParseInvoiceFilter(url.Values{}); err != nil || !filter.IsZero() {
+		t.Errorf("Expected an empty filter, got %+v, %v", filter, err)
+	}
+	if _, err := ParseInvoiceFilter(url.Values{"client_id": {"acme"}}); err == nil {
+		t.Error("Expected an error for an invalid client_id")
+	}
+}
+
+func TestInvoiceFilterMatches(t *testing.T) {
+	invoice := &Invoice{ClientID: 7, Status: "sent", Tags: []string{"2024-Q1", "Retainer"}}
+	tests := []struct {
+		filter InvoiceFilter
+		want   bool
+	}{
+		{InvoiceFilter{}, true},
+		{InvoiceFilter{ClientID: 7, Status: "sent"}, true},
+		{InvoiceFilter{ClientID: 8}, false},
+		{InvoiceFilter{Status: "paid"}, false},
+		{InvoiceFilter{Tags: []string{"retainer"}}, true},
+		{InvoiceFilter{Tags: []string{"retainer", "2024-q1"}}, true},
+		{InvoiceFilter{Tags: []string{"retainer", "travel"}}, false},
+	}
+	for _, test := range tests {
+		if got := test.filter.Matches(invoice); got != test.want {
+			t.Errorf("Filter %+v: expected %v, got %v", test.filter, test.want, got)
+		}
+	}
+}
@@ -1,7 +1,13 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Invoice represents an invoice
@@ -12,15 +18,268 @@ type Invoice struct {
 	ClientID         int       `json:"client_id"`
 	IssueDate        time.Time `json:"issue_date"`
 	DueDate          time.Time `json:"due_date"`
-	HourlyRate       float64   `json:"hourly_rate"`
+	HourlyRate       Money     `json:"hourly_rate"`
 	HoursWorked      float64   `json:"hours_worked"`
-	TotalAmount      float64   `json:"total_amount"`
+	TotalAmount      Money     `json:"total_amount"`
 	VatRate          float64   `json:"vat_rate"`
-	VatAmount        float64   `json:"vat_amount"`
+	VatAmount        Money     `json:"vat_amount"`
 	ReverseChargeVat bool      `json:"reverse_charge_vat"`
 	Currency         string    `json:"currency"`
 	Notes            string    `json:"notes"`
-	Status           string    `json:"status"` // draft, sent, paid
+	Status           string    `json:"status"` // One of InvoiceStatuses
+	Type             string    `json:"type"`   // One of InvoiceTypes, invoice by default
+
+	// StatusReason is why a disputed or on-hold invoice is not being paid,
+	// empty for other statuses
+	StatusReason string `json:"status_reason"`
+
+	// ConvertedInvoiceID is the invoice a pro-forma was converted into, 0 if not converted
+	ConvertedInvoiceID int `json:"converted_invoice_id,omitempty"`
+
+	// CancelsInvoiceID is the invoice a cancellation (storno) invoice
+	// reverses, 0 for other invoices
+	CancelsInvoiceID int `json:"cancels_invoice_id,omitempty"`
+
+	// CancelledByInvoiceID is the cancellation invoice of a cancelled
+	// invoice, 0 if not cancelled
+	CancelledByInvoiceID int `json:"cancelled_by_invoice_id,omitempty"`
+
+	// CancelsInvoiceNumber and CancelsInvoiceDate identify the invoice a
+	// cancellation invoice reverses on its PDF. They are loaded with the PDF
+	// data and not stored with the invoice.
+	CancelsInvoiceNumber string    `json:"cancels_invoice_number,omitempty"`
+	CancelsInvoiceDate   time.Time `json:"cancels_invoice_date,omitzero"`
+
+	// CreditApplied is the client credit (prepayment or retainer) deducted from the total
+	CreditApplied Money `json:"credit_applied"`
+
+	// ProjectID is the project the invoice belongs to, 0 if none
+	ProjectID int `json:"project_id,omitempty"`
+
+	// Tags are free-form labels such as 2024-Q1 or disputed, sorted. When
+	// saving, a non-nil list replaces the tags of the invoice.
+	Tags []string `json:"tags"`
+
+	// Invoices in a foreign currency record the rate used to show their totals
+	// in the home currency; a zero rate means the totals are not converted
+	HomeCurrency     string    `json:"home_currency,omitempty"`
+	ExchangeRate     float64   `json:"exchange_rate,omitempty"` // Home currency units per unit of the invoice currency
+	ExchangeRateDate time.Time `json:"exchange_rate_date"`
+
+	// PaidDate is when a paid invoice was paid, zero for unpaid invoices and
+	// invoices imported as paid without a payment date
+	PaidDate time.Time `json:"paid_date"`
+
+	// ShowHoursBreakdown appends a page with the hours worked per day to the PDF
+	ShowHoursBreakdown bool `json:"show_hours_breakdown"`
+
+	// HoursBreakdown lists the time billed on the invoice, from project time
+	// entries or a pasted table, for that page. It is loaded with the PDF data;
+	// when saving, a non-nil list replaces the pasted rows.
+	HoursBreakdown []TimeEntry `json:"hours_breakdown,omitempty"`
+
+	// ReverseChargeClause is the legal clause printed on reverse-charge
+	// invoices in the client's language. It is loaded with the PDF data and
+	// not stored with the invoice.
+	ReverseChargeClause string `json:"reverse_charge_clause,omitempty"`
+
+	// ComplianceClauses are the legal clauses the compliance profile of the
+	// business prints on every invoice. They are loaded with the PDF data and
+	// not stored with the invoice.
+	ComplianceClauses []string `json:"compliance_clauses,omitempty"`
+
+	// References required by many corporate clients; zero dates mean no service period
+	PONumber           string    `json:"po_number"`
+	ContractReference  string    `json:"contract_reference"`
+	ServicePeriodStart time.Time `json:"service_period_start"`
+	ServicePeriodEnd   time.Time `json:"service_period_end"`
+
+	// Invoice-level discount applied to the sum of the line items before VAT
+	DiscountPercent float64 `json:"discount_percent"`
+	DiscountAmount  Money   `json:"discount_amount"`
+
+	// PayPal is the PayPal payment link the invoice offers, one of
+	// PayPalLinks or empty for none
+	PayPal string `json:"paypal"`
+
+	// PayPalLink is the URL of that link for the amount due. It is loaded
+	// with the PDF data and not stored with the invoice.
+	PayPalLink string `json:"paypal_link,omitempty"`
+
+	// KSeFNumber is the number KSeF assigned to the invoice, printed on the
+	// PDF. It is loaded with the PDF data and not stored with the invoice.
+	KSeFNumber string `json:"ksef_number,omitempty"`
+
+	// Invoices paid in USDC record the amount received and what it was worth
+	// in the invoice currency on the payment date
+	CryptoAmount     Money   `json:"crypto_amount,omitempty"` // USDC received
+	CryptoRate       float64 `json:"crypto_rate,omitempty"`   // Invoice currency units per USDC
+	CryptoFiatAmount Money   `json:"crypto_fiat_amount,omitempty"`
+}
+
+// Invoice statuses. Disputed invoices are contested by the client, and
+// on-hold invoices wait for something else, e.g. a purchase order or the
+// acceptance of the work. Neither is expected to be paid until resolved, so
+// they are never overdue.
+const (
+	InvoiceStatusDraft    = "draft"
+	InvoiceStatusSent     = "sent"
+	InvoiceStatusPaid     = "paid"
+	InvoiceStatusDisputed = "disputed"
+	InvoiceStatusOnHold   = "on-hold"
+)
+
+// InvoiceStatuses lists the statuses of invoices
+var InvoiceStatuses = []string{InvoiceStatusDraft, InvoiceStatusSent, InvoiceStatusPaid, InvoiceStatusDisputed, InvoiceStatusOnHold}
+
+// MaxStatusReasonLength is the maximum length of a status reason in characters
+const MaxStatusReasonLength = 500
+
+// IsHeldStatus reports whether invoices with the status are disputed or on
+// hold, and need a reason
+func IsHeldStatus(status string) bool {
+	return status == InvoiceStatusDisputed || status == InvoiceStatusOnHold
+}
+
+// IsHeld reports whether the invoice is disputed or on hold
+func (i Invoice) IsHeld() bool {
+	return IsHeldStatus(i.Status)
+}
+
+// ValidateStatusChange checks that the invoice can change to status, with
+// the reason disputed and on-hold invoices need. Only sent invoices can be
+// disputed or put on hold.
+func (i Invoice) ValidateStatusChange(status, reason string) error {
+	if !slices.Contains(InvoiceStatuses, status) {
+		return fmt.Errorf("invalid status %q, expected one of %s", status, strings.Join(InvoiceStatuses, ", "))
+	}
+	if !IsHeldStatus(status) {
+		return nil
+	}
+	if i.Status != InvoiceStatusSent && !i.IsHeld() {
+		return fmt.Errorf("only sent invoices can be %s, this one is %s", status, i.Status)
+	}
+	if strings.TrimSpace(reason) == "" {
+		return fmt.Errorf("a reason is required for %s invoices", status)
+	}
+	if utf8.RuneCountInString(reason) > MaxStatusReasonLength {
+		return fmt.Errorf("the reason is longer than %d characters", MaxStatusReasonLength)
+	}
+	return nil
+}
+
+// CryptoCurrency is the stablecoin invoices can be paid in. It is pegged to
+// the US dollar, so its rate follows the dollar's.
+const CryptoCurrency = "USDC"
+
+// PayPal payment links. A PayPal.Me link lets the client pay the amount due
+// to the business's PayPal.Me name; a checkout link carries the invoice, so
+// PayPal notifies the application when it is paid.
+const (
+	PayPalMe       = "me"
+	PayPalCheckout = "checkout"
+)
+
+// PayPalLinks lists the PayPal payment links an invoice can offer
+var PayPalLinks = []string{PayPalMe, PayPalCheckout}
+
+// Invoice document types. Pro-forma invoices are quotes in invoice form: they
+// are numbered separately and do not use up the fiscal invoice sequence.
+const (
+	InvoiceTypeInvoice  = "invoice"
+	InvoiceTypeProforma = "proforma"
+)
+
+// InvoiceTypes lists the supported document types
+var InvoiceTypes = []string{InvoiceTypeInvoice, InvoiceTypeProforma}
+
+// IsProforma reports whether the invoice is a pro-forma invoice
+func (i Invoice) IsProforma() bool {
+	return i.Type == InvoiceTypeProforma
+}
+
+// IsStorno reports whether the invoice is a cancellation (storno) invoice
+// reversing another invoice
+func (i Invoice) IsStorno() bool {
+	return i.CancelsInvoiceID != 0
+}
+
+// IsSettledByCancellation reports whether the invoice is a cancellation
+// invoice or was cancelled by one. The two settle each other and are marked
+// paid, but no payment was made, so neither is booked as one.
+func (i Invoice) IsSettledByCancellation() bool {
+	return i.IsStorno() || i.CancelledByInvoiceID != 0
+}
+
+// AmountDue returns the total less the client credit applied to the invoice
+func (i Invoice) AmountDue() Money {
+	return i.TotalAmount - i.CreditApplied
+}
+
+// HasExchangeRate reports whether the totals are also shown in the home currency
+func (i Invoice) HasExchangeRate() bool {
+	return i.ExchangeRate > 0 && i.HomeCurrency != "" && i.HomeCurrency != i.Currency
+}
+
+// ToHomeCurrency converts an amount of the invoice to the home currency
+func (i Invoice) ToHomeCurrency(amount Money) Money {
+	return amount.Mul(i.ExchangeRate).Round(i.HomeCurrency)
+}
+
+// InvoicePDFVersion records a generated PDF of an invoice. A new version is
+// created whenever the PDF is generated after the invoice data changed.
+type InvoicePDFVersion struct {
+	ID          int       `json:"id"`
+	InvoiceID   int       `json:"invoice_id"`
+	Version     int       `json:"version"`
+	Filename    string    `json:"filename"` // Relative to the pdfs directory
+	Fingerprint string    `json:"-"`        // Hash of the data the PDF was rendered from
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// HasServicePeriod reports whether a delivery or service period is set
+func (i *Invoice) HasServicePeriod() bool {
+	return !i.ServicePeriodStart.IsZero() && !i.ServicePeriodEnd.IsZero()
+}
+
+// PDFFilename returns the name of the generated PDF file of the invoice
+func (i Invoice) PDFFilename() string {
+	return InvoicePDFFilename(i.InvoiceNumber)
+}
+
+// InvoicePDFFilename returns the PDF filename for an invoice number. Characters
+// other than letters, digits, dots, dashes and underscores are replaced so the
+// number cannot escape the PDF directory.
+func InvoicePDFFilename(invoiceNumber string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && (r == '-' || r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '_'
+	}, invoiceNumber)
+	return "invoice-" + strings.ReplaceAll(safe, "..", "__") + ".pdf"
+}
+
+// Units of measure for invoice items
+const (
+	UnitHours      = "hours"
+	UnitDays       = "days"
+	UnitPieces     = "pcs"
+	UnitKilometres = "km"
+	UnitFlat       = "flat"
+)
+
+// ItemUnits lists the supported units of measure in display order
+var ItemUnits = []string{UnitHours, UnitDays, UnitPieces, UnitKilometres, UnitFlat}
+
+// IsValidUnit reports whether unit is a supported unit of measure
+func IsValidUnit(unit string) bool {
+	for _, u := range ItemUnits {
+		if u == unit {
+			return true
+		}
+	}
+	return false
 }
 
 // InvoiceItem represents a line item on an invoice
@@ -29,6 +288,115 @@ type InvoiceItem struct {
 	InvoiceID   int     `json:"invoice_id"`
 	Description string  `json:"description"`
 	Quantity    float64 `json:"quantity"`
-	UnitPrice   float64 `json:"unit_price"`
-	Amount      float64 `json:"amount"`
+	Unit        string  `json:"unit"` // One of ItemUnits, hours by default
+	UnitPrice   Money   `json:"unit_price"`
+	Amount      Money   `json:"amount"` // Net of the item discount
+
+	DiscountPercent float64 `json:"discount_percent"`
+	DiscountAmount  Money   `json:"discount_amount"`
+
+	Kind      string `json:"kind"`       // One of ItemKinds, service by default
+	VatExempt bool   `json:"vat_exempt"` // Billed outside the VAT base, always for disbursements
+}
+
+// InvoiceTotals holds the amounts derived from an invoice's items and discounts
+type InvoiceTotals struct {
+	ItemsTotal Money // Sum of the item amounts after their discounts
+	Discount   Money // Invoice-level discount
+	Subtotal   Money // Items total less the discount
+	NonTaxable Money // Part of the subtotal outside the VAT base
+	VatAmount  Money
+	Total      Money
+}
+
+// ValidateDiscount checks a percentage and fixed discount pair
+func ValidateDiscount(percent float64, amount Money) error {
+	if percent < 0 || percent > 100 {
+		return errors.New("discount percentage must be between 0 and 100")
+	}
+	if amount < 0 {
+		return errors.New("discount amount must not be negative")
+	}
+	return nil
+}
+
+// applyDiscount returns the discount on base: the percentage first, then the
+// fixed amount, never exceeding base. Negative bases, e.g. of cancellation
+// invoices, get the negated discount of the positive base.
+func applyDiscount(base Money, percent float64, amount Money) Money {
+	if base < 0 {
+		return -applyDiscount(-base, percent, amount)
+	}
+	return min(base.Mul(percent/100)+amount, base)
+}
+
+// GrossAmount returns the item amount before its discount
+func (item *InvoiceItem) GrossAmount() Money {
+	return item.UnitPrice.Mul(item.Quantity)
+}
+
+// Discount returns the discount on the item
+func (item *InvoiceItem) Discount() Money {
+	return applyDiscount(item.GrossAmount(), item.DiscountPercent, item.DiscountAmount)
+}
+
+// NetAmount returns the item amount after its discount, before rounding
+func (item *InvoiceItem) NetAmount() Money {
+	return item.GrossAmount() - item.Discount()
+}
+
+// HasDiscount reports whether the item is discounted
+func (item *InvoiceItem) HasDiscount() bool {
+	return item.DiscountPercent > 0 || item.DiscountAmount > 0
+}
+
+// HasMixedVat reports whether the invoice charges VAT on some items but
+// leaves others out of the VAT base
+func (i *Invoice) HasMixedVat(items []InvoiceItem) bool {
+	if i.ReverseChargeVat || i.VatRate == 0 {
+		return false
+	}
+	return slices.ContainsFunc(items, func(item InvoiceItem) bool { return item.VatExempt })
+}
+
+// HasDiscount reports whether an invoice-level discount is set
+func (i *Invoice) HasDiscount() bool {
+	return i.DiscountPercent > 0 || i.DiscountAmount > 0
+}
+
+// CalculateTotals computes the invoice totals from the items and discounts.
+// Item amounts, the discount and the VAT amount are each rounded to the
+// currency's minor unit. VAT is charged on the subtotal after all discounts,
+// or not at all for reverse charge invoices. Items exempt from VAT take their
+// share of the invoice discount and are left out of the VAT base.
+func (i *Invoice) CalculateTotals(items []InvoiceItem) InvoiceTotals {
+	var totals InvoiceTotals
+	for idx := range items {
+		amount := items[idx].NetAmount().Round(i.Currency)
+		totals.ItemsTotal += amount
+		if items[idx].VatExempt {
+			totals.NonTaxable += amount
+		}
+	}
+	totals.Discount = applyDiscount(totals.ItemsTotal, i.DiscountPercent, i.DiscountAmount).Round(i.Currency)
+	totals.Subtotal = totals.ItemsTotal - totals.Discount
+	if totals.NonTaxable != 0 && totals.ItemsTotal != 0 {
+		totals.NonTaxable -= totals.Discount.Mul(totals.NonTaxable.Float() / totals.ItemsTotal.Float()).Round(i.Currency)
+	}
+	if !i.ReverseChargeVat {
+		totals.VatAmount = (totals.Subtotal - totals.NonTaxable).Mul(i.VatRate / 100).Round(i.Currency)
+	}
+	totals.Total = totals.Subtotal + totals.VatAmount
+	return totals
+}
+
+// ApplyTotals sets the item amounts and the invoice VAT and total amounts
+// from the quantities, prices and discounts
+func (i *Invoice) ApplyTotals(items []InvoiceItem) {
+	for idx := range items {
+		items[idx].Amount = items[idx].NetAmount().Round(i.Currency)
+	}
+	totals := i.CalculateTotals(items)
+	i.VatAmount = totals.VatAmount
+	i.TotalAmount = totals.Total
 }
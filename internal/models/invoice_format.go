@@ -0,0 +1,73 @@
+package models
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateFormats are the formats invoices can print dates in, as Go layouts of
+// the reference date 2 January 2006
+var DateFormats = []string{"Jan 02, 2006", "02 Jan 2006", "02.01.2006", "02/01/2006", "01/02/2006", "02-01-2006", "2006-01-02", "2006.01.02."}
+
+// NumberFormats are the formats invoices can print numbers in, written as the
+// number 1234.56 with its thousands and decimal separators
+var NumberFormats = []string{"1234.56", "1,234.56", "1.234,56", "1 234,56", "1'234.56"}
+
+// InvoiceFormat is how an invoice prints dates and numbers
+type InvoiceFormat struct {
+	Date   string // Of DateFormats
+	Number string // Of NumberFormats
+}
+
+// DefaultInvoiceFormat is used for clients in countries without a usual format
+var DefaultInvoiceFormat = InvoiceFormat{Date: "Jan 02, 2006", Number: "1234.56"}
+
+// FormatDate formats a date, e.g. 01.03.2024
+func (f InvoiceFormat) FormatDate(date time.Time) string {
+	layout := f.Date
+	if !slices.Contains(DateFormats, layout) {
+		layout = DefaultInvoiceFormat.Date
+	}
+	return date.Format(layout)
+}
+
+// FormatAmount formats an amount with two decimal places, e.g. 1.234,50
+func (f InvoiceFormat) FormatAmount(amount Money) string {
+	return f.localize(amount.String())
+}
+
+// FormatNumber formats a number with a number of decimal places, e.g. 7,50
+func (f InvoiceFormat) FormatNumber(value float64, decimals int) string {
+	return f.localize(strconv.FormatFloat(value, 'f', decimals, 64))
+}
+
+// localize groups the thousands of a number formatted like -1234.56 and
+// replaces its separators with those of the format
+func (f InvoiceFormat) localize(number string) string {
+	format := f.Number
+	if !slices.Contains(NumberFormats, format) {
+		format = DefaultInvoiceFormat.Number
+	}
+	runes := []rune(format)
+	group, decimal := string(runes[1:len(runes)-6]), string(runes[len(runes)-3])
+
+	var b strings.Builder
+	if rest, negative := strings.CutPrefix(number, "-"); negative {
+		b.WriteString("-")
+		number = rest
+	}
+	integer, fraction, hasFraction := strings.Cut(number, ".")
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			b.WriteString(group)
+		}
+		b.WriteRune(digit)
+	}
+	if hasFraction {
+		b.WriteString(decimal)
+		b.WriteString(fraction)
+	}
+	return b.String()
+}
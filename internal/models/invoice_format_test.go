@@ -0,0 +1,51 @@
+package models
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/refdata"
+)
+
+func TestInvoiceFormat(t *testing.T) {
+	date := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		format  InvoiceFormat
+		date    string
+		amount  string
+		number  string
+		negated string
+	}{
+		{DefaultInvoiceFormat, "Mar 01, 2024", "1234567.89", "7.50", "-0.05"},
+		{InvoiceFormat{Date: "02 Jan 2006", Number: "1,234.56"}, "01 Mar 2024", "1,234,567.89", "7.50", "-0.05"},
+		{InvoiceFormat{Date: "02.01.2006", Number: "1.234,56"}, "01.03.2024", "1.234.567,89", "7,50", "-0,05"},
+		{InvoiceFormat{Date: "2006.01.02.", Number: "1 234,56"}, "2024.03.01.", "1 234 567,89", "7,50", "-0,05"},
+		{InvoiceFormat{Date: "01/02/2006", Number: "1'234.56"}, "03/01/2024", "1'234'567.89", "7.50", "-0.05"},
+		{InvoiceFormat{Date: "2 January", Number: "1_234.56"}, "Mar 01, 2024", "1234567.89", "7.50", "-0.05"},
+	}
+	for _, tt := range tests {
+		if got := tt.format.FormatDate(date); got != tt.date {
+			t.Errorf("%+v: FormatDate = %q, want %q", tt.format, got, tt.date)
+		}
+		if got := tt.format.FormatAmount(123456789); got != tt.amount {
+			t.Errorf("%+v: FormatAmount = %q, want %q", tt.format, got, tt.amount)
+		}
+		if got := tt.format.FormatNumber(7.5, 2); got != tt.number {
+			t.Errorf("%+v: FormatNumber = %q, want %q", tt.format, got, tt.number)
+		}
+		if got := tt.format.FormatAmount(-5); got != tt.negated {
+			t.Errorf("%+v: FormatAmount of a negative amount = %q, want %q", tt.format, got, tt.negated)
+		}
+	}
+
+	// The usual formats of countries are formats invoices can print
+	for _, country := range refdata.Countries() {
+		if date := refdata.DateFormat(country.Code); date != "" && !slices.Contains(DateFormats, date) {
+			t.Errorf("date format %q of %s is not listed", date, country.Code)
+		}
+		if number := refdata.NumberFormat(country.Code); number != "" && !slices.Contains(NumberFormats, number) {
+			t.Errorf("number format %q of %s is not listed", number, country.Code)
+		}
+	}
+}
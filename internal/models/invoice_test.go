@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 )
@@ -15,11 +16,11 @@ func TestInvoiceJSON(t *testing.T) {
 		ClientID:         3,
 		IssueDate:        time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
 		DueDate:          time.Date(2023, 1, 31, 0, 0, 0, 0, time.UTC),
-		HourlyRate:       50.0,
+		HourlyRate:       5000,
 		HoursWorked:      40.0,
-		TotalAmount:      2000.0,
+		TotalAmount:      200000,
 		VatRate:          19.0,
-		VatAmount:        380.0,
+		VatAmount:        38000,
 		ReverseChargeVat: false,
 		Currency:         "EUR",
 		Notes:            "Test invoice",
@@ -47,7 +48,7 @@ func TestInvoiceJSON(t *testing.T) {
 		t.Errorf("Expected invoice number %s, got %s", invoice.InvoiceNumber, unmarshaledInvoice.InvoiceNumber)
 	}
 	if unmarshaledInvoice.TotalAmount != invoice.TotalAmount {
-		t.Errorf("Expected total amount %f, got %f", invoice.TotalAmount, unmarshaledInvoice.TotalAmount)
+		t.Errorf("Expected total amount %s, got %s", invoice.TotalAmount, unmarshaledInvoice.TotalAmount)
 	}
 	if unmarshaledInvoice.Currency != invoice.Currency {
 		t.Errorf("Expected currency %s, got %s", invoice.Currency, unmarshaledInvoice.Currency)
@@ -61,8 +62,8 @@ func TestInvoiceItemJSON(t *testing.T) {
 		InvoiceID:   2,
 		Description: "Test Item",
 		Quantity:    10.0,
-		UnitPrice:   50.0,
-		Amount:      500.0,
+		UnitPrice:   5000,
+		Amount:      50000,
 	}
 
 	// Marshal to JSON
@@ -89,9 +90,221 @@ func TestInvoiceItemJSON(t *testing.T) {
 		t.Errorf("Expected quantity %f, got %f", item.Quantity, unmarshaledItem.Quantity)
 	}
 	if unmarshaledItem.UnitPrice != item.UnitPrice {
-		t.Errorf("Expected unit price %f, got %f", item.UnitPrice, unmarshaledItem.UnitPrice)
+		t.Errorf("Expected unit price %s, got %s", item.UnitPrice, unmarshaledItem.UnitPrice)
 	}
 	if unmarshaledItem.Amount != item.Amount {
-		t.Errorf("Expected amount %f, got %f", item.Amount, unmarshaledItem.Amount)
+		t.Errorf("Expected amount %s, got %s", item.Amount, unmarshaledItem.Amount)
+	}
+}
+
+func TestCalculateTotalsWithDiscounts(t *testing.T) {
+	items := []InvoiceItem{
+		{Description: "Development", Quantity: 10, UnitPrice: 10000, DiscountPercent: 10},
+		{Description: "Support", Quantity: 2, UnitPrice: 5000, DiscountAmount: 2000},
+		{Description: "Free setup", Quantity: 1, UnitPrice: 3000, DiscountAmount: 5000},
+	}
+	invoice := Invoice{VatRate: 20, DiscountPercent: 5, DiscountAmount: 1000}
+
+	invoice.ApplyTotals(items)
+
+	for i, want := range []Money{90000, 8000, 0} {
+		if items[i].Amount != want {
+			t.Errorf("Expected item %d amount %s, got %s", i, want, items[i].Amount)
+		}
+	}
+
+	totals := invoice.CalculateTotals(items)
+	// 980 items total, 5% (49) plus 10 off, VAT on the remaining 921
+	if totals.ItemsTotal != 98000 || totals.Discount != 5900 || totals.Subtotal != 92100 {
+		t.Errorf("Unexpected totals: %+v", totals)
+	}
+	if invoice.VatAmount != 18420 || invoice.TotalAmount != 110520 {
+		t.Errorf("Expected VAT 184.20 and total 1105.20, got %s and %s", invoice.VatAmount, invoice.TotalAmount)
+	}
+
+	invoice.ReverseChargeVat = true
+	invoice.ApplyTotals(items)
+	if invoice.VatAmount != 0 || invoice.TotalAmount != 92100 {
+		t.Errorf("Expected no VAT for reverse charge, got %s and %s", invoice.VatAmount, invoice.TotalAmount)
+	}
+
+	// Negated quantities, as on cancellation invoices, negate every total
+	for i := range items {
+		items[i].Quantity = -items[i].Quantity
+	}
+	invoice.ReverseChargeVat = false
+	invoice.ApplyTotals(items)
+	for i, want := range []Money{-90000, -8000, 0} {
+		if items[i].Amount != want {
+			t.Errorf("Expected negated item %d amount %s, got %s", i, want, items[i].Amount)
+		}
+	}
+	if negated := invoice.CalculateTotals(items); negated.Discount != -5900 || negated.Subtotal != -92100 ||
+		invoice.VatAmount != -18420 || invoice.TotalAmount != -110520 {
+		t.Errorf("Expected the negated totals, got %+v", negated)
+	}
+}
+
+func TestCalculateTotalsWithExpenses(t *testing.T) {
+	items := []InvoiceItem{
+		{Description: "Development", Quantity: 10, UnitPrice: 8000},
+		{Kind: ItemKindMileage, Quantity: 420, UnitPrice: 30},
+		{Kind: ItemKindDisbursement, Description: "Court fee", Quantity: 1, UnitPrice: 7400, VatExempt: true},
+	}
+	invoice := Invoice{Currency: "EUR", VatRate: 19, DiscountPercent: 10}
+	invoice.ApplyTotals(items)
+
+	// 1000 items total, 10% off; the court fee keeps 66.60 of its 74 outside the VAT base
+	totals := invoice.CalculateTotals(items)
+	if totals.Subtotal != 90000 || totals.NonTaxable != 6660 || totals.VatAmount != 15835 || invoice.TotalAmount != 105835 {
+		t.Errorf("Unexpected totals: %+v", totals)
+	}
+	if !invoice.HasMixedVat(items) {
+		t.Error("Expected the court fee to be left out of the VAT")
+	}
+	invoice.ReverseChargeVat = true
+	if invoice.HasMixedVat(items) {
+		t.Error("Expected no VAT to be mixed on a reverse charge invoice")
+	}
+
+	if got := items[1].ExpenseDescription("EUR"); got != "420 km × 0.30 EUR/km" {
+		t.Errorf("Unexpected mileage description %q", got)
+	}
+	perDiem := InvoiceItem{Kind: ItemKindPerDiem, Quantity: 1, UnitPrice: 2800}
+	if got := perDiem.ExpenseDescription("EUR"); got != "Per diem, 1 day × 28.00 EUR/day" {
+		t.Errorf("Unexpected per-diem description %q", got)
+	}
+	if got := items[0].ExpenseDescription("EUR"); got != "" {
+		t.Errorf("Expected services not to be described, got %q", got)
+	}
+}
+
+func TestValidateStatusChange(t *testing.T) {
+	sent := Invoice{Status: InvoiceStatusSent}
+	tests := []struct {
+		invoice Invoice
+		status  string
+		reason  string
+		valid   bool
+	}{
+		{sent, InvoiceStatusPaid, "", true},
+		{sent, InvoiceStatusDisputed, "Hours not agreed", true},
+		{sent, InvoiceStatusOnHold, "Waiting for the purchase order", true},
+		{Invoice{Status: InvoiceStatusDisputed}, InvoiceStatusOnHold, "Settled, waiting for the PO", true},
+		{Invoice{Status: InvoiceStatusDisputed}, InvoiceStatusSent, "", true},
+		{sent, "lost", "", false},
+		{sent, InvoiceStatusDisputed, " ", false},
+		{sent, InvoiceStatusOnHold, strings.Repeat("x", MaxStatusReasonLength+1), false},
+		{Invoice{Status: InvoiceStatusDraft}, InvoiceStatusDisputed, "Hours not agreed", false},
+		{Invoice{Status: InvoiceStatusPaid}, InvoiceStatusOnHold, "Refund requested", false},
+	}
+	for _, tt := range tests {
+		if err := tt.invoice.ValidateStatusChange(tt.status, tt.reason); (err == nil) != tt.valid {
+			t.Errorf("%s to %s with %q: expected valid = %v, got %v", tt.invoice.Status, tt.status, tt.reason, tt.valid, err)
+		}
+	}
+}
+
+func TestValidateDiscount(t *testing.T) {
+	if err := ValidateDiscount(10, 5); err != nil {
+		t.Errorf("Expected valid discount, got %v", err)
+	}
+	if err := ValidateDiscount(101, 0); err == nil {
+		t.Error("Expected an error for a percentage above 100")
+	}
+	if err := ValidateDiscount(0, -1); err == nil {
+		t.Error("Expected an error for a negative amount")
+	}
+}
+
+func TestMoneyRound(t *testing.T) {
+	tests := []struct {
+		amount   Money
+		currency string
+		want     Money
+	}{
+		{1013, "EUR", 1013},
+		{123450, "JPY", 123500},
+		{-123450, "JPY", -123500},
+		{123449, "JPY", 123400},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.Round(tt.currency); got != tt.want {
+			t.Errorf("Money(%d).Round(%s) = %d, want %d", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Money
+	}{
+		{"118.99", 11899},
+		{"-1234.5", -123450},
+		{"0.1", 10},
+		{"10.125", 1013},
+		{"7", 700},
+		{".5", 50},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.input)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, %v, want %d", tt.input, got, err, tt.want)
+		}
+	}
+
+	for _, input := range []string{"", "abc", "1.2.3", "-", "1e5"} {
+		if _, err := ParseMoney(input); err == nil {
+			t.Errorf("Expected an error for %q", input)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	item := InvoiceItem{UnitPrice: 3333, Amount: -50}
+	data, err := json.Marshal(item)
+	if err != nil {
+		t.Fatalf("Failed to marshal item: %v", err)
+	}
+	if !strings.Contains(string(data), `"unit_price":33.33`) || !strings.Contains(string(data), `"amount":-0.50`) {
+		t.Errorf("Unexpected JSON: %s", data)
+	}
+
+	var decoded InvoiceItem
+	if err := json.Unmarshal([]byte(`{"unit_price": 0.1, "amount": "12.345", "discount_amount": null}`), &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal item: %v", err)
+	}
+	if decoded.UnitPrice != 10 || decoded.Amount != 1235 || decoded.DiscountAmount != 0 {
+		t.Errorf("Unexpected amounts: %+v", decoded)
+	}
+}
+
+func TestInvoicePDFFilename(t *testing.T) {
+	tests := map[string]string{
+		"INV-2024-001": "invoice-INV-2024-001.pdf",
+		"2024/07":      "invoice-2024_07.pdf",
+		"../../db":     "invoice-______db.pdf",
+		"a..b":         "invoice-a__b.pdf",
+	}
+	for number, want := range tests {
+		if got := InvoicePDFFilename(number); got != want {
+			t.Errorf("InvoicePDFFilename(%q) = %q, want %q", number, got, want)
+		}
+	}
+}
+
+func TestInvoiceToHomeCurrency(t *testing.T) {
+	invoice := Invoice{Currency: "USD", HomeCurrency: "RON", ExchangeRate: 4.5906}
+	if !invoice.HasExchangeRate() {
+		t.Fatal("Expected the invoice to have an exchange rate")
+	}
+	if got := invoice.ToHomeCurrency(123456); got != 566737 {
+		t.Errorf("ToHomeCurrency(1234.56) = %s, want 5667.37", got)
+	}
+
+	invoice.HomeCurrency = "USD"
+	if invoice.HasExchangeRate() {
+		t.Error("Expected no conversion into the invoice currency")
 	}
 }
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Job represents a unit of background work persisted in the jobs table
+type Job struct {
+	ID          int       `json:"id"`
+	Type        string    `json:"type"`
+	Payload     string    `json:"payload"`
+	Status      string    `json:"status"` // pending, running, done, failed
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"max_attempts"`
+	LastError   string    `json:"last_error"`
+	RunAt       time.Time `json:"run_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Statuses of an invoice sent to KSeF, the Polish national e-invoicing system
+const (
+	KSeFQueued    = "queued"    // Waiting to be sent, or to be retried after a failed attempt
+	KSeFSubmitted = "submitted" // Received by KSeF, which is still processing it
+	KSeFAccepted  = "accepted"  // Given a KSeF number
+	KSeFRejected  = "rejected"  // Not accepted, see the message; it must be sent again
+)
+
+// KSeFInvoice tracks the sending of an invoice to KSeF as an FA(2)
+// structured invoice
+type KSeFInvoice struct {
+	InvoiceID       int       `json:"invoice_id"`
+	Status          string    `json:"status"`
+	ReferenceNumber string    `json:"reference_number,omitempty"` // Of the last request KSeF received
+	KSeFNumber      string    `json:"ksef_number,omitempty"`      // Assigned by KSeF once accepted
+	Attempts        int       `json:"attempts"`                   // Failed attempts since the invoice was queued
+	Message         string    `json:"message,omitempty"`          // The error of the last attempt, or why KSeF rejected it
+	NextAttemptAt   time.Time `json:"next_attempt_at"`
+	SubmittedAt     time.Time `json:"submitted_at"` // Zero until KSeF received it
+	AcceptedAt      time.Time `json:"accepted_at"`  // When KSeF acquired it, zero until accepted
+	UpdatedAt       time.Time `json:"updated_at"`
+}
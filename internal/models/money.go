@@ -0,0 +1,142 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/0dragosh/simple-invoice/internal/refdata"
+)
+
+// Money is an amount in hundredths of the currency unit (cents). Amounts are
+// stored and added as integers to avoid floating point drift; JSON encodes
+// them as decimal numbers such as 118.99.
+type Money int64
+
+// CurrencyDecimals returns the number of decimal places amounts in currency are rounded to
+func CurrencyDecimals(currency string) int {
+	return refdata.CurrencyDecimals(currency)
+}
+
+// MinorUnit returns the smallest amount of currency, e.g. one cent
+func MinorUnit(currency string) Money {
+	return Money(math.Pow10(2 - min(CurrencyDecimals(currency), 2)))
+}
+
+// NewMoney converts a decimal amount to Money, rounding half away from zero
+func NewMoney(amount float64) Money {
+	return Money(math.Round(amount * 100))
+}
+
+// ParseMoney parses a decimal amount such as "-1234.5" without going through
+// a float. Digits beyond the cent are rounded half away from zero.
+func ParseMoney(s string) (Money, error) {
+	value := strings.TrimSpace(s)
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "-"), "+")
+
+	whole, fraction, _ := strings.Cut(value, ".")
+	if whole == "" && fraction == "" {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	for _, part := range []string{whole, fraction} {
+		if strings.Trim(part, "0123456789") != "" {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+	}
+
+	units := int64(0)
+	if whole != "" {
+		var err error
+		if units, err = strconv.ParseInt(whole, 10, 64); err != nil || units > math.MaxInt64/100-1 {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+	}
+
+	fraction += "000"
+	cents, _ := strconv.ParseInt(fraction[:2], 10, 64)
+	cents += units * 100
+	if fraction[2] >= '5' {
+		cents++
+	}
+
+	if negative {
+		cents = -cents
+	}
+	return Money(cents), nil
+}
+
+// Float returns the amount in currency units, for rates and display only
+func (m Money) Float() float64 {
+	return float64(m) / 100
+}
+
+// Mul returns the amount multiplied by a quantity or rate, rounded to the cent
+func (m Money) Mul(factor float64) Money {
+	return Money(math.Round(float64(m) * factor))
+}
+
+// Neg returns the negated amount, e.g. to print a discount as a deduction
+func (m Money) Neg() Money {
+	return -m
+}
+
+// Round rounds the amount to the minor unit of currency, half away from zero
+func (m Money) Round(currency string) Money {
+	unit := MinorUnit(currency)
+	if unit == 1 {
+		return m
+	}
+	remainder := m % unit
+	m -= remainder
+	if 2*remainder >= unit {
+		m += unit
+	} else if -2*remainder >= unit {
+		m -= unit
+	}
+	return m
+}
+
+// String formats the amount with two decimal places, e.g. "-1234.50"
+func (m Money) String() string {
+	sign := ""
+	cents := int64(m)
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// MarshalJSON encodes the amount as a decimal number
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON decodes a decimal number or numeric string
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*m = 0
+		return nil
+	}
+	value := strings.Trim(string(data), `"`)
+
+	// Numbers in exponent notation are rare but valid JSON
+	if strings.ContainsAny(value, "eE") {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid amount %s", data)
+		}
+		*m = NewMoney(f)
+		return nil
+	}
+
+	parsed, err := ParseMoney(value)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Statuses of an invoice reported to the Hungarian tax authority (NAV)
+const (
+	NAVReportQueued    = "queued"    // Waiting to be sent, or to be retried after a failed attempt
+	NAVReportSubmitted = "submitted" // Accepted by NAV, which is still processing it
+	NAVReportDone      = "done"
+	NAVReportAborted   = "aborted" // Rejected by NAV, see the message; it must be reported again
+)
+
+// NAVReport tracks the reporting of an invoice to NAV Online Számla, the
+// real-time invoice data reporting of Hungary
+type NAVReport struct {
+	InvoiceID     int       `json:"invoice_id"`
+	Status        string    `json:"status"`
+	TransactionID string    `json:"transaction_id,omitempty"` // Of the last request NAV accepted
+	Attempts      int       `json:"attempts"`                 // Failed attempts since the invoice was queued
+	Message       string    `json:"message,omitempty"`        // The error of the last attempt, or NAV's validation messages
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	SubmittedAt   time.Time `json:"submitted_at"` // Zero until NAV accepted a request
+	UpdatedAt     time.Time `json:"updated_at"`
+}
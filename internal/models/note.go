@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Entities notes can be logged against
+const (
+	NoteEntityInvoice = "invoice"
+	NoteEntityClient  = "client"
+)
+
+// MaxNoteLength is the maximum length of a note in characters
+const MaxNoteLength = 2000
+
+// Note is a comment logged against an invoice or client, such as a call in
+// which the client promised to pay
+type Note struct {
+	ID         int       `json:"id"`
+	EntityType string    `json:"entity_type"` // invoice or client
+	EntityID   int       `json:"entity_id"`
+	Body       string    `json:"body"`
+	Author     string    `json:"author,omitempty"` // Name of the signed-in user, empty when authentication is disabled
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Kinds of timeline events
+const (
+	TimelineNote    = "note"
+	TimelineCreated = "created"
+	TimelineIssued  = "issued"
+	TimelineOverdue = "overdue"
+	TimelinePaid    = "paid"
+	TimelineEmail   = "email"
+	TimelineBounce  = "bounce"
+	TimelineCredit  = "credit"
+	TimelineAudit   = "audit"
+)
+
+// TimelineEvent is an entry in the activity timeline of an invoice or
+// client: a note or something the application recorded
+type TimelineEvent struct {
+	Time          time.Time `json:"time"`
+	Kind          string    `json:"kind"` // note, created, issued, overdue, paid, email, bounce, credit or audit
+	Summary       string    `json:"summary"`
+	InvoiceID     int       `json:"invoice_id,omitempty"`
+	InvoiceNumber string    `json:"invoice_number,omitempty"`
+	Note          *Note     `json:"note,omitempty"` // Set for notes
+}
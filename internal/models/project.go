@@ -0,0 +1,185 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Project groups the invoices and time entries of an engagement with a client
+type Project struct {
+	ID         int       `json:"id"`
+	ClientID   int       `json:"client_id"`
+	Name       string    `json:"name"`
+	Currency   string    `json:"currency"`
+	Budget     Money     `json:"budget"`      // Net amount agreed for the project, 0 if open-ended
+	HourlyRate Money     `json:"hourly_rate"` // Agreed rate, used to value unbilled time
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TimeEntry is time worked on a project on one day
+type TimeEntry struct {
+	ID          int       `json:"id"`
+	ProjectID   int       `json:"project_id"`
+	Date        time.Time `json:"date"`
+	Hours       float64   `json:"hours"`
+	Description string    `json:"description"`
+	InvoiceID   int       `json:"invoice_id,omitempty"` // Set once the time was billed
+}
+
+// ProjectSummary is a project with the figures of its profitability view
+type ProjectSummary struct {
+	Project
+	ClientName    string  `json:"client_name"`
+	Invoiced      Money   `json:"invoiced"` // Net of VAT, without pro-forma invoices
+	Paid          Money   `json:"paid"`     // Net of VAT
+	HoursLogged   float64 `json:"hours_logged"`
+	UnbilledHours float64 `json:"unbilled_hours"`
+}
+
+// BudgetUsed returns the invoiced amount as a percentage of the budget, 0 without a budget
+func (p ProjectSummary) BudgetUsed() float64 {
+	if p.Budget <= 0 {
+		return 0
+	}
+	return float64(p.Invoiced) / float64(p.Budget) * 100
+}
+
+// EffectiveRate returns the invoiced amount per hour logged, 0 without logged time.
+// Compared to the agreed rate it shows whether the project pays off.
+func (p ProjectSummary) EffectiveRate() Money {
+	if p.HoursLogged <= 0 {
+		return 0
+	}
+	return p.Invoiced.Mul(1 / p.HoursLogged).Round(p.Currency)
+}
+
+// UnbilledValue returns the unbilled time valued at the agreed rate
+func (p ProjectSummary) UnbilledValue() Money {
+	return p.HourlyRate.Mul(p.UnbilledHours).Round(p.Currency)
+}
+
+// hoursTableDateLayouts are the date formats accepted in pasted hours tables
+var hoursTableDateLayouts = []string{"2006-01-02", "02.01.2006", "2.1.2006", "02/01/2006", "2/1/2006"}
+
+// ParseHoursTable parses a pasted table of hours worked, one day per line with
+// the date, the hours and an optional description. Columns are separated by
+// tabs, semicolons or commas, as copied from a spreadsheet or CSV file. Hours
+// may be decimal (7.5 or 7,5) or hours and minutes (7:30). A header line is skipped.
+func ParseHoursTable(text string) ([]TimeEntry, error) {
+	var entries []TimeEntry
+	header := true
+	for n, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		first := header
+		header = false
+
+		separator := ","
+		if strings.Contains(line, "\t") {
+			separator = "\t"
+		} else if strings.Contains(line, ";") {
+			separator = ";"
+		}
+		fields := strings.SplitN(line, separator, 3)
+		if len(fields) < 2 {
+			if _, err := parseHoursTableDate(strings.TrimSpace(fields[0])); first && err != nil {
+				continue // Header line
+			}
+			return nil, fmt.Errorf("line %d: expected a date and hours separated by a tab, semicolon or comma", n+1)
+		}
+
+		date, err := parseHoursTableDate(strings.TrimSpace(fields[0]))
+		if err != nil {
+			if first {
+				continue // Header line
+			}
+			return nil, fmt.Errorf("line %d: %q is not a date like 2024-03-31 or 31.03.2024", n+1, strings.TrimSpace(fields[0]))
+		}
+		hours, err := parseHoursTableHours(strings.TrimSpace(fields[1]), separator)
+		if err != nil || hours <= 0 || hours > 24 {
+			return nil, fmt.Errorf("line %d: %q is not a number of hours between 0 and 24", n+1, strings.TrimSpace(fields[1]))
+		}
+
+		entry := TimeEntry{Date: date, Hours: hours}
+		if len(fields) == 3 {
+			entry.Description = strings.TrimSpace(fields[2])
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func parseHoursTableDate(s string) (time.Time, error) {
+	for _, layout := range hoursTableDateLayouts {
+		if date, err := time.Parse(layout, s); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+func parseHoursTableHours(s, separator string) (float64, error) {
+	if h, m, ok := strings.Cut(s, ":"); ok {
+		hours, err := strconv.Atoi(h)
+		if err != nil {
+			return 0, err
+		}
+		minutes, err := strconv.Atoi(m)
+		if err != nil || minutes < 0 || minutes >= 60 {
+			return 0, fmt.Errorf("invalid minutes %q", m)
+		}
+		return float64(hours) + float64(minutes)/60, nil
+	}
+	if separator != "," {
+		s = strings.ReplaceAll(s, ",", ".")
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// TimesheetDay is a day of the timesheet grid of an invoice
+type TimesheetDay struct {
+	Date        string  `json:"date"` // YYYY-MM-DD
+	Hours       float64 `json:"hours"`
+	Description string  `json:"description,omitempty"`
+}
+
+// TimesheetEntries converts the days of a timesheet grid to time entries,
+// oldest first. Days without hours are left out; each day may appear once.
+func TimesheetEntries(days []TimesheetDay) ([]TimeEntry, error) {
+	entries := []TimeEntry{}
+	seen := make(map[string]bool, len(days))
+	for _, day := range days {
+		date, err := time.Parse("2006-01-02", day.Date)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a date like 2024-03-31", day.Date)
+		}
+		if seen[day.Date] {
+			return nil, fmt.Errorf("%s is listed twice", day.Date)
+		}
+		seen[day.Date] = true
+		if day.Hours < 0 || day.Hours > 24 {
+			return nil, fmt.Errorf("%s: %v is not a number of hours between 0 and 24", day.Date, day.Hours)
+		}
+		if day.Hours == 0 {
+			continue
+		}
+		entries = append(entries, TimeEntry{Date: date, Hours: day.Hours, Description: strings.TrimSpace(day.Description)})
+	}
+	slices.SortStableFunc(entries, func(a, b TimeEntry) int { return a.Date.Compare(b.Date) })
+	return entries, nil
+}
+
+// TotalHours returns the hours of time entries added up, rounded to
+// hundredths of an hour
+func TotalHours(entries []TimeEntry) float64 {
+	var total float64
+	for _, entry := range entries {
+		total += entry.Hours
+	}
+	return math.Round(total*100) / 100
+}
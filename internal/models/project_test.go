@@ -0,0 +1,71 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHoursTable(t *testing.T) {
+	table := "Date\tHours\tTask\n" +
+		"2024-03-01\t7,5\tSprint planning\n" +
+		"\n" +
+		"04.03.2024\t8:15\tDevelopment, reviews\r\n"
+	entries, err := ParseHoursTable(table)
+	if err != nil {
+		t.Fatalf("ParseHoursTable failed: %v", err)
+	}
+	expected := []TimeEntry{
+		{Date: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), Hours: 7.5, Description: "Sprint planning"},
+		{Date: time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), Hours: 8.25, Description: "Development, reviews"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %d: %v", len(expected), len(entries), entries)
+	}
+	for i := range expected {
+		if !entries[i].Date.Equal(expected[i].Date) || entries[i].Hours != expected[i].Hours || entries[i].Description != expected[i].Description {
+			t.Errorf("Entry %d: expected %v, got %v", i, expected[i], entries[i])
+		}
+	}
+
+	// CSV uses a decimal point, the comma separates the columns
+	entries, err = ParseHoursTable("2024-03-01,6.5")
+	if err != nil || len(entries) != 1 || entries[0].Hours != 6.5 {
+		t.Errorf("Expected 6.5 hours from a CSV line, got %v (%v)", entries, err)
+	}
+
+	for _, invalid := range []string{"2024-03-01;25", "2024-03-01;8\nyesterday;8", "2024-03-01"} {
+		if _, err := ParseHoursTable(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestTimesheetEntries(t *testing.T) {
+	days := []TimesheetDay{
+		{Date: "2024-05-03", Hours: 7.5, Description: " Development "},
+		{Date: "2024-05-01", Hours: 0},
+		{Date: "2024-05-02", Hours: 0.1},
+		{Date: "2024-05-06", Hours: 0.2},
+	}
+	entries, err := TimesheetEntries(days)
+	if err != nil {
+		t.Fatalf("TimesheetEntries failed: %v", err)
+	}
+	if len(entries) != 3 || entries[0].Date.Day() != 2 || entries[1].Description != "Development" {
+		t.Errorf("Expected the three days with hours, oldest first, got %v", entries)
+	}
+	if total := TotalHours(entries); total != 7.8 {
+		t.Errorf("TotalHours() = %v, want 7.8", total)
+	}
+
+	for _, invalid := range [][]TimesheetDay{
+		{{Date: "03.05.2024", Hours: 8}},
+		{{Date: "2024-05-03", Hours: 25}},
+		{{Date: "2024-05-03", Hours: -1}},
+		{{Date: "2024-05-03", Hours: 8}, {Date: "2024-05-03", Hours: 2}},
+	} {
+		if _, err := TimesheetEntries(invalid); err == nil {
+			t.Errorf("Expected %v to be rejected", invalid)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package models
+
+// ReportRow is the revenue of one month in one currency
+type ReportRow struct {
+	Month    string `json:"month"` // YYYY-MM, empty in the yearly totals
+	Currency string `json:"currency"`
+	Invoices int    `json:"invoices"`
+	Net      Money  `json:"net"`
+	VAT      Money  `json:"vat"`
+	Total    Money  `json:"total"`
+	// CreditApplied is the client credit deducted from the invoices, received
+	// earlier as a prepayment. Only set on the cash basis.
+	CreditApplied Money `json:"credit_applied"`
+	// Prepayments is the client credit received, less credit refunded. Only
+	// set on the cash basis.
+	Prepayments Money `json:"prepayments"`
+}
+
+// Received returns the money received in the month: the invoice totals less
+// the credit applied to them, plus the prepayments
+func (r ReportRow) Received() Money {
+	return r.Total - r.CreditApplied + r.Prepayments
+}
+
+// HeldAmount is the amount due on the disputed or on-hold invoices of a
+// status in one currency
+type HeldAmount struct {
+	Status   string `json:"status"` // disputed or on-hold
+	Currency string `json:"currency"`
+	Invoices int    `json:"invoices"`
+	Amount   Money  `json:"amount"` // Due, less the credit applied
+}
+
+// Report is the revenue of a year by month and currency. On the accrual basis
+// invoices count in the month they were issued, on the cash basis in the month
+// they were paid.
+type Report struct {
+	Basis  string      `json:"basis"`
+	Year   int         `json:"year"`
+	Rows   []ReportRow `json:"rows"`   // By month, then currency
+	Totals []ReportRow `json:"totals"` // By currency
+	// Held are the invoices issued in the year that are disputed or on hold,
+	// by status, then currency, on either basis
+	Held []HeldAmount `json:"held"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// ReverseChargeClause is the legal text printed on reverse-charge invoices.
+// Built-in clauses exist per language; customized clauses apply to clients in
+// one country.
+type ReverseChargeClause struct {
+	Country   string    `json:"country,omitempty"`  // Two-letter country code of customized clauses, e.g. DE
+	Language  string    `json:"language,omitempty"` // Language of built-in clauses, e.g. de
+	Text      string    `json:"text"`
+	BuiltIn   bool      `json:"built_in"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
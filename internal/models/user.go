@@ -0,0 +1,71 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// User is a person signed in through the reverse proxy or the OIDC provider.
+// Users are created automatically on their first sign-in.
+type User struct {
+	ID          int       `json:"id"`
+	AuthSource  string    `json:"auth_source"` // proxy or oidc
+	Subject     string    `json:"subject"`     // Remote-User header or OIDC sub claim
+	Username    string    `json:"username"`
+	Email       string    `json:"email"`
+	Name        string    `json:"name"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// Session is a sign-in through the OIDC provider on one browser
+type Session struct {
+	ID         string    `json:"id"` // Identifies the session without revealing its token
+	UserID     int       `json:"user_id"`
+	IPAddress  string    `json:"ip_address"` // Address of the last request
+	UserAgent  string    `json:"user_agent"`
+	Device     string    `json:"device"` // Browser and operating system, e.g. Firefox on Linux
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // The session of the request
+}
+
+// userAgentBrowsers and userAgentSystems are matched in order, since e.g.
+// Edge also claims to be Chrome and Android also claims to be Linux
+var (
+	userAgentBrowsers = []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"}, {"curl/", "curl"},
+	}
+	userAgentSystems = []struct{ token, name string }{
+		{"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Android", "Android"}, {"Windows", "Windows"}, {"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	}
+)
+
+// DescribeUserAgent names the browser and operating system of a User-Agent
+// header, e.g. "Firefox on Linux", or "Unknown device"
+func DescribeUserAgent(userAgent string) string {
+	var browser, system string
+	for _, b := range userAgentBrowsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, s := range userAgentSystems {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return "Browser on " + system
+	default:
+		return "Unknown device"
+	}
+}
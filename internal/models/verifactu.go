@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Statuses of a Verifactu billing record
+const (
+	VerifactuPending            = "pending" // Not sent to AEAT yet, or to be retried after a failed attempt
+	VerifactuAccepted           = "accepted"
+	VerifactuAcceptedWithErrors = "accepted_with_errors" // Accepted, but the invoice should be corrected, see the message
+	VerifactuRejected           = "rejected"             // Not accepted, see the message; it must be sent again as a correction
+)
+
+// VerifactuRecord is the billing record (registro de facturación) of an
+// invoice of a Spanish business under Verifactu. Every record of an issuer
+// chains to the previous one through its hash, so records are never changed
+// or deleted; an invoice AEAT rejected gets a new, correcting record.
+type VerifactuRecord struct {
+	ID            int       `json:"id"`
+	InvoiceID     int       `json:"invoice_id"`
+	IssuerNIF     string    `json:"issuer_nif"`
+	IssuerName    string    `json:"issuer_name"`
+	InvoiceNumber string    `json:"invoice_number"`
+	IssueDate     string    `json:"issue_date"`   // DD-MM-YYYY, as hashed
+	InvoiceType   string    `json:"invoice_type"` // F1 for invoices, F2 for simplified invoices without a recipient
+	VatTotal      string    `json:"vat_total"`
+	Total         string    `json:"total"`
+	PreviousHash  string    `json:"previous_hash,omitempty"` // Empty for the first record of the issuer
+	Hash          string    `json:"hash"`
+	GeneratedAt   string    `json:"generated_at"` // With the time zone, as hashed
+	Correction    bool      `json:"correction"`   // Corrects a record AEAT rejected (subsanación)
+	Status        string    `json:"status"`
+	CSV           string    `json:"csv,omitempty"` // Secure verification code of the submission AEAT accepted
+	Message       string    `json:"message,omitempty"`
+	Attempts      int       `json:"attempts"` // Failed attempts to send the record
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	SentAt        time.Time `json:"sent_at"` // Zero until AEAT answered
+	XML           string    `json:"-"`       // The RegistroAlta element sent to AEAT
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultWorkHoursPerDay are the hours of a working day of businesses
+// without their own
+const DefaultWorkHoursPerDay = 8.0
+
+// DefaultWorkDays are the working days of businesses without their own
+var DefaultWorkDays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+
+// PublicHoliday is a nationwide public holiday of a country
+type PublicHoliday struct {
+	Date    string `json:"date"` // YYYY-MM-DD
+	Name    string `json:"name"`
+	Country string `json:"country"`
+}
+
+// WorkDay is a day of a work month
+type WorkDay struct {
+	Date    string  `json:"date"` // YYYY-MM-DD
+	Weekday string  `json:"weekday"`
+	Hours   float64 `json:"hours"`             // 0 on days off
+	Holiday string  `json:"holiday,omitempty"` // Name of the public holiday taken off
+}
+
+// WorkMonth is the working time of a business in a calendar month
+type WorkMonth struct {
+	Month          string    `json:"month"`                     // YYYY-MM
+	HolidayCountry string    `json:"holiday_country,omitempty"` // Empty when no public holidays are taken off
+	Days           []WorkDay `json:"days"`
+	TotalHours     float64   `json:"total_hours"`
+}
+
+// WorkPeriod is the working time of a business in a billing period
+type WorkPeriod struct {
+	From           string    `json:"from"`                      // YYYY-MM-DD
+	To             string    `json:"to"`                        // YYYY-MM-DD, included
+	HolidayCountry string    `json:"holiday_country,omitempty"` // Empty when no public holidays are taken off
+	Days           []WorkDay `json:"days"`
+	TotalHours     float64   `json:"total_hours"`
+}
+
+// MaxWorkPeriodDays is the longest billing period that is laid out
+const MaxWorkPeriodDays = 366
+
+// HoursPerDay returns the hours of a working day of the business
+func (b *Business) HoursPerDay() float64 {
+	if b.WorkHoursPerDay > 0 {
+		return b.WorkHoursPerDay
+	}
+	return DefaultWorkHoursPerDay
+}
+
+// WorkingDays returns the weekdays the business works on
+func (b *Business) WorkingDays() []time.Weekday {
+	if len(b.WorkDays) > 0 {
+		return b.WorkDays
+	}
+	return DefaultWorkDays
+}
+
+// WorkMonth lays out the working days of the business in a month, taking
+// the public holidays given off. Holidays on days off change nothing.
+func (b *Business) WorkMonth(year int, month time.Month, holidays []PublicHoliday) WorkMonth {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	period := b.WorkPeriod(first, first.AddDate(0, 1, -1), holidays)
+	return WorkMonth{Month: first.Format("2006-01"), HolidayCountry: period.HolidayCountry, Days: period.Days, TotalHours: period.TotalHours}
+}
+
+// WorkPeriod lays out the working days of the business from one day to
+// another, both included, like WorkMonth
+func (b *Business) WorkPeriod(from, to time.Time, holidays []PublicHoliday) WorkPeriod {
+	names := make(map[string]string, len(holidays))
+	for _, holiday := range holidays {
+		names[holiday.Date] = holiday.Name
+	}
+	workDays := b.WorkingDays()
+
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	result := WorkPeriod{From: from.Format("2006-01-02"), To: to.Format("2006-01-02"), Days: []WorkDay{}}
+	if len(holidays) > 0 {
+		result.HolidayCountry = b.HolidayCountry
+	}
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		workDay := WorkDay{Date: day.Format("2006-01-02"), Weekday: day.Weekday().String()}
+		if slices.Contains(workDays, day.Weekday()) {
+			if name, ok := names[workDay.Date]; ok {
+				workDay.Holiday = name
+			} else {
+				workDay.Hours = b.HoursPerDay()
+			}
+		}
+		result.TotalHours += workDay.Hours
+		result.Days = append(result.Days, workDay)
+	}
+	return result
+}
+
+// FormatWorkDays stores working days as comma-separated weekday numbers,
+// e.g. 1,2,3,4,5 for Monday to Friday
+func FormatWorkDays(days []time.Weekday) string {
+	parts := make([]string, len(days))
+	for i, day := range days {
+		parts[i] = strconv.Itoa(int(day))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseWorkDays reads working days stored by FormatWorkDays, skipping
+// anything that is not a weekday number
+func ParseWorkDays(value string) []time.Weekday {
+	days := []time.Weekday{}
+	for _, part := range strings.Split(value, ",") {
+		if day, err := strconv.Atoi(strings.TrimSpace(part)); err == nil && day >= 0 && day <= 6 {
+			days = append(days, time.Weekday(day))
+		}
+	}
+	return days
+}
@@ -0,0 +1,1045 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        (unknown)
+// source: proto/simpleinvoice/v1/simpleinvoice.proto
+
+package simpleinvoicev1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Client struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Address       string                 `protobuf:"bytes,3,opt,name=address,proto3" json:"address,omitempty"`
+	City          string                 `protobuf:"bytes,4,opt,name=city,proto3" json:"city,omitempty"`
+	PostalCode    string                 `protobuf:"bytes,5,opt,name=postal_code,json=postalCode,proto3" json:"postal_code,omitempty"`
+	Country       string                 `protobuf:"bytes,6,opt,name=country,proto3" json:"country,omitempty"`
+	VatId         string                 `protobuf:"bytes,7,opt,name=vat_id,json=vatId,proto3" json:"vat_id,omitempty"`
+	Email         string                 `protobuf:"bytes,8,opt,name=email,proto3" json:"email,omitempty"`
+	Language      string                 `protobuf:"bytes,9,opt,name=language,proto3" json:"language,omitempty"`
+	Version       int64                  `protobuf:"varint,10,opt,name=version,proto3" json:"version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Client) Reset() {
+	*x = Client{}
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Client) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Client) ProtoMessage() {}
+
+func (x *Client) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Client.ProtoReflect.Descriptor instead.
+func (*Client) Descriptor() ([]byte, []int) {
+	return file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Client) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Client) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Client) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *Client) GetCity() string {
+	if x != nil {
+		return x.City
+	}
+	return ""
+}
+
+func (x *Client) GetPostalCode() string {
+	if x != nil {
+		return x.PostalCode
+	}
+	return ""
+}
+
+func (x *Client) GetCountry() string {
+	if x != nil {
+		return x.Country
+	}
+	return ""
+}
+
+func (x *Client) GetVatId() string {
+	if x != nil {
+		return x.VatId
+	}
+	return ""
+}
+
+func (x *Client) GetEmail() string {
+	if x != nil {
+		return x.Email
+	}
+	return ""
+}
+
+func (x *Client) GetLanguage() string {
+	if x != nil {
+		return x.Language
+	}
+	return ""
+}
+
+func (x *Client) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+type InvoiceItem struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Id              int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Description     string                 `protobuf:"bytes,2,opt,name=description,proto3" json:"description,omitempty"`
+	Quantity        float64                `protobuf:"fixed64,3,opt,name=quantity,proto3" json:"quantity,omitempty"`
+	Unit            string                 `protobuf:"bytes,4,opt,name=unit,proto3" json:"unit,omitempty"`
+	UnitPrice       int64                  `protobuf:"varint,5,opt,name=unit_price,json=unitPrice,proto3" json:"unit_price,omitempty"`
+	Amount          int64                  `protobuf:"varint,6,opt,name=amount,proto3" json:"amount,omitempty"`
+	DiscountPercent float64                `protobuf:"fixed64,7,opt,name=discount_percent,json=discountPercent,proto3" json:"discount_percent,omitempty"`
+	DiscountAmount  int64                  `protobuf:"varint,8,opt,name=discount_amount,json=discountAmount,proto3" json:"discount_amount,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *InvoiceItem) Reset() {
+	*x = InvoiceItem{}
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InvoiceItem) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InvoiceItem) ProtoMessage() {}
+
+func (x *InvoiceItem) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InvoiceItem.ProtoReflect.Descriptor instead.
+func (*InvoiceItem) Descriptor() ([]byte, []int) {
+	return file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *InvoiceItem) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *InvoiceItem) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *InvoiceItem) GetQuantity() float64 {
+	if x != nil {
+		return x.Quantity
+	}
+	return 0
+}
+
+func (x *InvoiceItem) GetUnit() string {
+	if x != nil {
+		return x.Unit
+	}
+	return ""
+}
+
+func (x *InvoiceItem) GetUnitPrice() int64 {
+	if x != nil {
+		return x.UnitPrice
+	}
+	return 0
+}
+
+func (x *InvoiceItem) GetAmount() int64 {
+	if x != nil {
+		return x.Amount
+	}
+	return 0
+}
+
+func (x *InvoiceItem) GetDiscountPercent() float64 {
+	if x != nil {
+		return x.DiscountPercent
+	}
+	return 0
+}
+
+func (x *InvoiceItem) GetDiscountAmount() int64 {
+	if x != nil {
+		return x.DiscountAmount
+	}
+	return 0
+}
+
+type Invoice struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Id               int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	InvoiceNumber    string                 `protobuf:"bytes,2,opt,name=invoice_number,json=invoiceNumber,proto3" json:"invoice_number,omitempty"`
+	Type             string                 `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"`
+	Status           string                 `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	BusinessId       int64                  `protobuf:"varint,5,opt,name=business_id,json=businessId,proto3" json:"business_id,omitempty"`
+	ClientId         int64                  `protobuf:"varint,6,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	IssueDate        string                 `protobuf:"bytes,7,opt,name=issue_date,json=issueDate,proto3" json:"issue_date,omitempty"`
+	DueDate          string                 `protobuf:"bytes,8,opt,name=due_date,json=dueDate,proto3" json:"due_date,omitempty"`
+	PaidDate         string                 `protobuf:"bytes,9,opt,name=paid_date,json=paidDate,proto3" json:"paid_date,omitempty"`
+	Currency         string                 `protobuf:"bytes,10,opt,name=currency,proto3" json:"currency,omitempty"`
+	VatRate          float64                `protobuf:"fixed64,11,opt,name=vat_rate,json=vatRate,proto3" json:"vat_rate,omitempty"`
+	ReverseChargeVat bool                   `protobuf:"varint,12,opt,name=reverse_charge_vat,json=reverseChargeVat,proto3" json:"reverse_charge_vat,omitempty"`
+	VatAmount        int64                  `protobuf:"varint,13,opt,name=vat_amount,json=vatAmount,proto3" json:"vat_amount,omitempty"`
+	TotalAmount      int64                  `protobuf:"varint,14,opt,name=total_amount,json=totalAmount,proto3" json:"total_amount,omitempty"`
+	CreditApplied    int64                  `protobuf:"varint,15,opt,name=credit_applied,json=creditApplied,proto3" json:"credit_applied,omitempty"`
+	Notes            string                 `protobuf:"bytes,16,opt,name=notes,proto3" json:"notes,omitempty"`
+	PoNumber         string                 `protobuf:"bytes,17,opt,name=po_number,json=poNumber,proto3" json:"po_number,omitempty"`
+	Items            []*InvoiceItem         `protobuf:"bytes,18,rep,name=items,proto3" json:"items,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *Invoice) Reset() {
+	*x = Invoice{}
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Invoice) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Invoice) ProtoMessage() {}
+
+func (x *Invoice) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Invoice.ProtoReflect.Descriptor instead.
+func (*Invoice) Descriptor() ([]byte, []int) {
+	return file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Invoice) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Invoice) GetInvoiceNumber() string {
+	if x != nil {
+		return x.InvoiceNumber
+	}
+	return ""
+}
+
+func (x *Invoice) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Invoice) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Invoice) GetBusinessId() int64 {
+	if x != nil {
+		return x.BusinessId
+	}
+	return 0
+}
+
+func (x *Invoice) GetClientId() int64 {
+	if x != nil {
+		return x.ClientId
+	}
+	return 0
+}
+
+func (x *Invoice) GetIssueDate() string {
+	if x != nil {
+		return x.IssueDate
+	}
+	return ""
+}
+
+func (x *Invoice) GetDueDate() string {
+	if x != nil {
+		return x.DueDate
+	}
+	return ""
+}
+
+func (x *Invoice) GetPaidDate() string {
+	if x != nil {
+		return x.PaidDate
+	}
+	return ""
+}
+
+func (x *Invoice) GetCurrency() string {
+	if x != nil {
+		return x.Currency
+	}
+	return ""
+}
+
+func (x *Invoice) GetVatRate() float64 {
+	if x != nil {
+		return x.VatRate
+	}
+	return 0
+}
+
+func (x *Invoice) GetReverseChargeVat() bool {
+	if x != nil {
+		return x.ReverseChargeVat
+	}
+	return false
+}
+
+func (x *Invoice) GetVatAmount() int64 {
+	if x != nil {
+		return x.VatAmount
+	}
+	return 0
+}
+
+func (x *Invoice) GetTotalAmount() int64 {
+	if x != nil {
+		return x.TotalAmount
+	}
+	return 0
+}
+
+func (x *Invoice) GetCreditApplied() int64 {
+	if x != nil {
+		return x.CreditApplied
+	}
+	return 0
+}
+
+func (x *Invoice) GetNotes() string {
+	if x != nil {
+		return x.Notes
+	}
+	return ""
+}
+
+func (x *Invoice) GetPoNumber() string {
+	if x != nil {
+		return x.PoNumber
+	}
+	return ""
+}
+
+func (x *Invoice) GetItems() []*InvoiceItem {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+type ListClientsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListClientsRequest) Reset() {
+	*x = ListClientsRequest{}
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListClientsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListClientsRequest) ProtoMessage() {}
+
+func (x *ListClientsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListClientsRequest.ProtoReflect.Descriptor instead.
+func (*ListClientsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescGZIP(), []int{3}
+}
+
+type ListClientsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Clients       []*Client              `protobuf:"bytes,1,rep,name=clients,proto3" json:"clients,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListClientsResponse) Reset() {
+	*x = ListClientsResponse{}
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListClientsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListClientsResponse) ProtoMessage() {}
+
+func (x *ListClientsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListClientsResponse.ProtoReflect.Descriptor instead.
+func (*ListClientsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListClientsResponse) GetClients() []*Client {
+	if x != nil {
+		return x.Clients
+	}
+	return nil
+}
+
+type GetClientRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetClientRequest) Reset() {
+	*x = GetClientRequest{}
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetClientRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetClientRequest) ProtoMessage() {}
+
+func (x *GetClientRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetClientRequest.ProtoReflect.Descriptor instead.
+func (*GetClientRequest) Descriptor() ([]byte, []int) {
+	return file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetClientRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type SaveClientRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Client        *Client                `protobuf:"bytes,1,opt,name=client,proto3" json:"client,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SaveClientRequest) Reset() {
+	*x = SaveClientRequest{}
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SaveClientRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SaveClientRequest) ProtoMessage() {}
+
+func (x *SaveClientRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SaveClientRequest.ProtoReflect.Descriptor instead.
+func (*SaveClientRequest) Descriptor() ([]byte, []int) {
+	return file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *SaveClientRequest) GetClient() *Client {
+	if x != nil {
+		return x.Client
+	}
+	return nil
+}
+
+type ListInvoicesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ClientId      int64                  `protobuf:"varint,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	Status        string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListInvoicesRequest) Reset() {
+	*x = ListInvoicesRequest{}
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListInvoicesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListInvoicesRequest) ProtoMessage() {}
+
+func (x *ListInvoicesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListInvoicesRequest.ProtoReflect.Descriptor instead.
+func (*ListInvoicesRequest) Descriptor() ([]byte, []int) {
+	return file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListInvoicesRequest) GetClientId() int64 {
+	if x != nil {
+		return x.ClientId
+	}
+	return 0
+}
+
+func (x *ListInvoicesRequest) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+type ListInvoicesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Invoices      []*Invoice             `protobuf:"bytes,1,rep,name=invoices,proto3" json:"invoices,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListInvoicesResponse) Reset() {
+	*x = ListInvoicesResponse{}
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListInvoicesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListInvoicesResponse) ProtoMessage() {}
+
+func (x *ListInvoicesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListInvoicesResponse.ProtoReflect.Descriptor instead.
+func (*ListInvoicesResponse) Descriptor() ([]byte, []int) {
+	return file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListInvoicesResponse) GetInvoices() []*Invoice {
+	if x != nil {
+		return x.Invoices
+	}
+	return nil
+}
+
+type GetInvoiceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetInvoiceRequest) Reset() {
+	*x = GetInvoiceRequest{}
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetInvoiceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetInvoiceRequest) ProtoMessage() {}
+
+func (x *GetInvoiceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetInvoiceRequest.ProtoReflect.Descriptor instead.
+func (*GetInvoiceRequest) Descriptor() ([]byte, []int) {
+	return file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *GetInvoiceRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type UpdateInvoiceStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Status        string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	PaidDate      string                 `protobuf:"bytes,3,opt,name=paid_date,json=paidDate,proto3" json:"paid_date,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateInvoiceStatusRequest) Reset() {
+	*x = UpdateInvoiceStatusRequest{}
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateInvoiceStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateInvoiceStatusRequest) ProtoMessage() {}
+
+func (x *UpdateInvoiceStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateInvoiceStatusRequest.ProtoReflect.Descriptor instead.
+func (*UpdateInvoiceStatusRequest) Descriptor() ([]byte, []int) {
+	return file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *UpdateInvoiceStatusRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *UpdateInvoiceStatusRequest) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *UpdateInvoiceStatusRequest) GetPaidDate() string {
+	if x != nil {
+		return x.PaidDate
+	}
+	return ""
+}
+
+type GetInvoicePDFRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetInvoicePDFRequest) Reset() {
+	*x = GetInvoicePDFRequest{}
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetInvoicePDFRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetInvoicePDFRequest) ProtoMessage() {}
+
+func (x *GetInvoicePDFRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetInvoicePDFRequest.ProtoReflect.Descriptor instead.
+func (*GetInvoicePDFRequest) Descriptor() ([]byte, []int) {
+	return file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *GetInvoicePDFRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type PDFChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Filename      string                 `protobuf:"bytes,1,opt,name=filename,proto3" json:"filename,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PDFChunk) Reset() {
+	*x = PDFChunk{}
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PDFChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PDFChunk) ProtoMessage() {}
+
+func (x *PDFChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PDFChunk.ProtoReflect.Descriptor instead.
+func (*PDFChunk) Descriptor() ([]byte, []int) {
+	return file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *PDFChunk) GetFilename() string {
+	if x != nil {
+		return x.Filename
+	}
+	return ""
+}
+
+func (x *PDFChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+var File_proto_simpleinvoice_v1_simpleinvoice_proto protoreflect.FileDescriptor
+
+const file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDesc = "" +
+	"\n" +
+	"*proto/simpleinvoice/v1/simpleinvoice.proto\x12\x10simpleinvoice.v1\"\xf8\x01\n" +
+	"\x06Client\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x18\n" +
+	"\aaddress\x18\x03 \x01(\tR\aaddress\x12\x12\n" +
+	"\x04city\x18\x04 \x01(\tR\x04city\x12\x1f\n" +
+	"\vpostal_code\x18\x05 \x01(\tR\n" +
+	"postalCode\x12\x18\n" +
+	"\acountry\x18\x06 \x01(\tR\acountry\x12\x15\n" +
+	"\x06vat_id\x18\a \x01(\tR\x05vatId\x12\x14\n" +
+	"\x05email\x18\b \x01(\tR\x05email\x12\x1a\n" +
+	"\blanguage\x18\t \x01(\tR\blanguage\x12\x18\n" +
+	"\aversion\x18\n" +
+	" \x01(\x03R\aversion\"\xfa\x01\n" +
+	"\vInvoiceItem\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12 \n" +
+	"\vdescription\x18\x02 \x01(\tR\vdescription\x12\x1a\n" +
+	"\bquantity\x18\x03 \x01(\x01R\bquantity\x12\x12\n" +
+	"\x04unit\x18\x04 \x01(\tR\x04unit\x12\x1d\n" +
+	"\n" +
+	"unit_price\x18\x05 \x01(\x03R\tunitPrice\x12\x16\n" +
+	"\x06amount\x18\x06 \x01(\x03R\x06amount\x12)\n" +
+	"\x10discount_percent\x18\a \x01(\x01R\x0fdiscountPercent\x12'\n" +
+	"\x0fdiscount_amount\x18\b \x01(\x03R\x0ediscountAmount\"\xb7\x04\n" +
+	"\aInvoice\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12%\n" +
+	"\x0einvoice_number\x18\x02 \x01(\tR\rinvoiceNumber\x12\x12\n" +
+	"\x04type\x18\x03 \x01(\tR\x04type\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\tR\x06status\x12\x1f\n" +
+	"\vbusiness_id\x18\x05 \x01(\x03R\n" +
+	"businessId\x12\x1b\n" +
+	"\tclient_id\x18\x06 \x01(\x03R\bclientId\x12\x1d\n" +
+	"\n" +
+	"issue_date\x18\a \x01(\tR\tissueDate\x12\x19\n" +
+	"\bdue_date\x18\b \x01(\tR\adueDate\x12\x1b\n" +
+	"\tpaid_date\x18\t \x01(\tR\bpaidDate\x12\x1a\n" +
+	"\bcurrency\x18\n" +
+	" \x01(\tR\bcurrency\x12\x19\n" +
+	"\bvat_rate\x18\v \x01(\x01R\avatRate\x12,\n" +
+	"\x12reverse_charge_vat\x18\f \x01(\bR\x10reverseChargeVat\x12\x1d\n" +
+	"\n" +
+	"vat_amount\x18\r \x01(\x03R\tvatAmount\x12!\n" +
+	"\ftotal_amount\x18\x0e \x01(\x03R\vtotalAmount\x12%\n" +
+	"\x0ecredit_applied\x18\x0f \x01(\x03R\rcreditApplied\x12\x14\n" +
+	"\x05notes\x18\x10 \x01(\tR\x05notes\x12\x1b\n" +
+	"\tpo_number\x18\x11 \x01(\tR\bpoNumber\x123\n" +
+	"\x05items\x18\x12 \x03(\v2\x1d.simpleinvoice.v1.InvoiceItemR\x05items\"\x14\n" +
+	"\x12ListClientsRequest\"I\n" +
+	"\x13ListClientsResponse\x122\n" +
+	"\aclients\x18\x01 \x03(\v2\x18.simpleinvoice.v1.ClientR\aclients\"\"\n" +
+	"\x10GetClientRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\"E\n" +
+	"\x11SaveClientRequest\x120\n" +
+	"\x06client\x18\x01 \x01(\v2\x18.simpleinvoice.v1.ClientR\x06client\"J\n" +
+	"\x13ListInvoicesRequest\x12\x1b\n" +
+	"\tclient_id\x18\x01 \x01(\x03R\bclientId\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\"M\n" +
+	"\x14ListInvoicesResponse\x125\n" +
+	"\binvoices\x18\x01 \x03(\v2\x19.simpleinvoice.v1.InvoiceR\binvoices\"#\n" +
+	"\x11GetInvoiceRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\"a\n" +
+	"\x1aUpdateInvoiceStatusRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x12\x1b\n" +
+	"\tpaid_date\x18\x03 \x01(\tR\bpaidDate\"&\n" +
+	"\x14GetInvoicePDFRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\":\n" +
+	"\bPDFChunk\x12\x1a\n" +
+	"\bfilename\x18\x01 \x01(\tR\bfilename\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data2\xe8\x04\n" +
+	"\x0eInvoiceService\x12Z\n" +
+	"\vListClients\x12$.simpleinvoice.v1.ListClientsRequest\x1a%.simpleinvoice.v1.ListClientsResponse\x12I\n" +
+	"\tGetClient\x12\".simpleinvoice.v1.GetClientRequest\x1a\x18.simpleinvoice.v1.Client\x12K\n" +
+	"\n" +
+	"SaveClient\x12#.simpleinvoice.v1.SaveClientRequest\x1a\x18.simpleinvoice.v1.Client\x12]\n" +
+	"\fListInvoices\x12%.simpleinvoice.v1.ListInvoicesRequest\x1a&.simpleinvoice.v1.ListInvoicesResponse\x12L\n" +
+	"\n" +
+	"GetInvoice\x12#.simpleinvoice.v1.GetInvoiceRequest\x1a\x19.simpleinvoice.v1.Invoice\x12^\n" +
+	"\x13UpdateInvoiceStatus\x12,.simpleinvoice.v1.UpdateInvoiceStatusRequest\x1a\x19.simpleinvoice.v1.Invoice\x12U\n" +
+	"\rGetInvoicePDF\x12&.simpleinvoice.v1.GetInvoicePDFRequest\x1a\x1a.simpleinvoice.v1.PDFChunk0\x01B@Z>github.com/0dragosh/simple-invoice/internal/pb/simpleinvoicev1b\x06proto3"
+
+var (
+	file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescOnce sync.Once
+	file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescData []byte
+)
+
+func file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescGZIP() []byte {
+	file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescOnce.Do(func() {
+		file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDesc), len(file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDesc)))
+	})
+	return file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDescData
+}
+
+var file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
+var file_proto_simpleinvoice_v1_simpleinvoice_proto_goTypes = []any{
+	(*Client)(nil),                     // 0: simpleinvoice.v1.Client
+	(*InvoiceItem)(nil),                // 1: simpleinvoice.v1.InvoiceItem
+	(*Invoice)(nil),                    // 2: simpleinvoice.v1.Invoice
+	(*ListClientsRequest)(nil),         // 3: simpleinvoice.v1.ListClientsRequest
+	(*ListClientsResponse)(nil),        // 4: simpleinvoice.v1.ListClientsResponse
+	(*GetClientRequest)(nil),           // 5: simpleinvoice.v1.GetClientRequest
+	(*SaveClientRequest)(nil),          // 6: simpleinvoice.v1.SaveClientRequest
+	(*ListInvoicesRequest)(nil),        // 7: simpleinvoice.v1.ListInvoicesRequest
+	(*ListInvoicesResponse)(nil),       // 8: simpleinvoice.v1.ListInvoicesResponse
+	(*GetInvoiceRequest)(nil),          // 9: simpleinvoice.v1.GetInvoiceRequest
+	(*UpdateInvoiceStatusRequest)(nil), // 10: simpleinvoice.v1.UpdateInvoiceStatusRequest
+	(*GetInvoicePDFRequest)(nil),       // 11: simpleinvoice.v1.GetInvoicePDFRequest
+	(*PDFChunk)(nil),                   // 12: simpleinvoice.v1.PDFChunk
+}
+var file_proto_simpleinvoice_v1_simpleinvoice_proto_depIdxs = []int32{
+	1,  // 0: simpleinvoice.v1.Invoice.items:type_name -> simpleinvoice.v1.InvoiceItem
+	0,  // 1: simpleinvoice.v1.ListClientsResponse.clients:type_name -> simpleinvoice.v1.Client
+	0,  // 2: simpleinvoice.v1.SaveClientRequest.client:type_name -> simpleinvoice.v1.Client
+	2,  // 3: simpleinvoice.v1.ListInvoicesResponse.invoices:type_name -> simpleinvoice.v1.Invoice
+	3,  // 4: simpleinvoice.v1.InvoiceService.ListClients:input_type -> simpleinvoice.v1.ListClientsRequest
+	5,  // 5: simpleinvoice.v1.InvoiceService.GetClient:input_type -> simpleinvoice.v1.GetClientRequest
+	6,  // 6: simpleinvoice.v1.InvoiceService.SaveClient:input_type -> simpleinvoice.v1.SaveClientRequest
+	7,  // 7: simpleinvoice.v1.InvoiceService.ListInvoices:input_type -> simpleinvoice.v1.ListInvoicesRequest
+	9,  // 8: simpleinvoice.v1.InvoiceService.GetInvoice:input_type -> simpleinvoice.v1.GetInvoiceRequest
+	10, // 9: simpleinvoice.v1.InvoiceService.UpdateInvoiceStatus:input_type -> simpleinvoice.v1.UpdateInvoiceStatusRequest
+	11, // 10: simpleinvoice.v1.InvoiceService.GetInvoicePDF:input_type -> simpleinvoice.v1.GetInvoicePDFRequest
+	4,  // 11: simpleinvoice.v1.InvoiceService.ListClients:output_type -> simpleinvoice.v1.ListClientsResponse
+	0,  // 12: simpleinvoice.v1.InvoiceService.GetClient:output_type -> simpleinvoice.v1.Client
+	0,  // 13: simpleinvoice.v1.InvoiceService.SaveClient:output_type -> simpleinvoice.v1.Client
+	8,  // 14: simpleinvoice.v1.InvoiceService.ListInvoices:output_type -> simpleinvoice.v1.ListInvoicesResponse
+	2,  // 15: simpleinvoice.v1.InvoiceService.GetInvoice:output_type -> simpleinvoice.v1.Invoice
+	2,  // 16: simpleinvoice.v1.InvoiceService.UpdateInvoiceStatus:output_type -> simpleinvoice.v1.Invoice
+	12, // 17: simpleinvoice.v1.InvoiceService.GetInvoicePDF:output_type -> simpleinvoice.v1.PDFChunk
+	11, // [11:18] is the sub-list for method output_type
+	4,  // [4:11] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_proto_simpleinvoice_v1_simpleinvoice_proto_init() }
+func file_proto_simpleinvoice_v1_simpleinvoice_proto_init() {
+	if File_proto_simpleinvoice_v1_simpleinvoice_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDesc), len(file_proto_simpleinvoice_v1_simpleinvoice_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   13,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proto_simpleinvoice_v1_simpleinvoice_proto_goTypes,
+		DependencyIndexes: file_proto_simpleinvoice_v1_simpleinvoice_proto_depIdxs,
+		MessageInfos:      file_proto_simpleinvoice_v1_simpleinvoice_proto_msgTypes,
+	}.Build()
+	File_proto_simpleinvoice_v1_simpleinvoice_proto = out.File
+	file_proto_simpleinvoice_v1_simpleinvoice_proto_goTypes = nil
+	file_proto_simpleinvoice_v1_simpleinvoice_proto_depIdxs = nil
+}
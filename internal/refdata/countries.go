@@ -0,0 +1,336 @@
+// Package refdata holds reference data about countries and currencies: the
+// ISO 3166 country codes, the ISO 4217 currencies and EU and euro area
+// membership over time.
+package refdata
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// Country is an ISO 3166-1 country
+type Country struct {
+	Code     string `json:"code"`     // Alpha-2 code, e.g. DE
+	Name     string `json:"name"`     // English short name
+	Currency string `json:"currency"` // ISO 4217 code of the currency in use today
+}
+
+// countries are the ISO 3166-1 countries, sorted by code
+var countries = []Country{
+	{"AD", "Andorra", "EUR"},
+	{"AE", "United Arab Emirates", "AED"},
+	{"AF", "Afghanistan", "AFN"},
+	{"AG", "Antigua and Barbuda", "XCD"},
+	{"AI", "Anguilla", "XCD"},
+	{"AL", "Albania", "ALL"},
+	{"AM", "Armenia", "AMD"},
+	{"AO", "Angola", "AOA"},
+	{"AQ", "Antarctica", ""},
+	{"AR", "Argentina", "ARS"},
+	{"AS", "American Samoa", "USD"},
+	{"AT", "Austria", "EUR"},
+	{"AU", "Australia", "AUD"},
+	{"AW", "Aruba", "AWG"},
+	{"AX", "Åland Islands", "EUR"},
+	{"AZ", "Azerbaijan", "AZN"},
+	{"BA", "Bosnia and Herzegovina", "BAM"},
+	{"BB", "Barbados", "BBD"},
+	{"BD", "Bangladesh", "BDT"},
+	{"BE", "Belgium", "EUR"},
+	{"BF", "Burkina Faso", "XOF"},
+	{"BG", "Bulgaria", "EUR"},
+	{"BH", "Bahrain", "BHD"},
+	{"BI", "Burundi", "BIF"},
+	{"BJ", "Benin", "XOF"},
+	{"BL", "Saint Barthélemy", "EUR"},
+	{"BM", "Bermuda", "BMD"},
+	{"BN", "Brunei Darussalam", "BND"},
+	{"BO", "Bolivia", "BOB"},
+	{"BQ", "Bonaire, Sint Eustatius and Saba", "USD"},
+	{"BR", "Brazil", "BRL"},
+	{"BS", "Bahamas", "BSD"},
+	{"BT", "Bhutan", "BTN"},
+	{"BV", "Bouvet Island", "NOK"},
+	{"BW", "Botswana", "BWP"},
+	{"BY", "Belarus", "BYN"},
+	{"BZ", "Belize", "BZD"},
+	{"CA", "Canada", "CAD"},
+	{"CC", "Cocos (Keeling) Islands", "AUD"},
+	{"CD", "Congo, Democratic Republic of the", "CDF"},
+	{"CF", "Central African Republic", "XAF"},
+	{"CG", "Congo", "XAF"},
+	{"CH", "Switzerland", "CHF"},
+	{"CI", "Côte d'Ivoire", "XOF"},
+	{"CK", "Cook Islands", "NZD"},
+	{"CL", "Chile", "CLP"},
+	{"CM", "Cameroon", "XAF"},
+	{"CN", "China", "CNY"},
+	{"CO", "Colombia", "COP"},
+	{"CR", "Costa Rica", "CRC"},
+	{"CU", "Cuba", "CUP"},
+	{"CV", "Cabo Verde", "CVE"},
+	{"CW", "Curaçao", "XCG"},
+	{"CX", "Christmas Island", "AUD"},
+	{"CY", "Cyprus", "EUR"},
+	{"CZ", "Czechia", "CZK"},
+	{"DE", "Germany", "EUR"},
+	{"DJ", "Djibouti", "DJF"},
+	{"DK", "Denmark", "DKK"},
+	{"DM", "Dominica", "XCD"},
+	{"DO", "Dominican Republic", "DOP"},
+	{"DZ", "Algeria", "DZD"},
+	{"EC", "Ecuador", "USD"},
+	{"EE", "Estonia", "EUR"},
+	{"EG", "Egypt", "EGP"},
+	{"EH", "Western Sahara", "MAD"},
+	{"ER", "Eritrea", "ERN"},
+	{"ES", "Spain", "EUR"},
+	{"ET", "Ethiopia", "ETB"},
+	{"FI", "Finland", "EUR"},
+	{"FJ", "Fiji", "FJD"},
+	{"FK", "Falkland Islands (Malvinas)", "FKP"},
+	{"FM", "Micronesia", "USD"},
+	{"FO", "Faroe Islands", "DKK"},
+	{"FR", "France", "EUR"},
+	{"GA", "Gabon", "XAF"},
+	{"GB", "United Kingdom", "GBP"},
+	{"GD", "Grenada", "XCD"},
+	{"GE", "Georgia", "GEL"},
+	{"GF", "French Guiana", "EUR"},
+	{"GG", "Guernsey", "GBP"},
+	{"GH", "Ghana", "GHS"},
+	{"GI", "Gibraltar", "GIP"},
+	{"GL", "Greenland", "DKK"},
+	{"GM", "Gambia", "GMD"},
+	{"GN", "Guinea", "GNF"},
+	{"GP", "Guadeloupe", "EUR"},
+	{"GQ", "Equatorial Guinea", "XAF"},
+	{"GR", "Greece", "EUR"},
+	{"GS", "South Georgia and the South Sandwich Islands", "GBP"},
+	{"GT", "Guatemala", "GTQ"},
+	{"GU", "Guam", "USD"},
+	{"GW", "Guinea-Bissau", "XOF"},
+	{"GY", "Guyana", "GYD"},
+	{"HK", "Hong Kong", "HKD"},
+	{"HM", "Heard Island and McDonald Islands", "AUD"},
+	{"HN", "Honduras", "HNL"},
+	{"HR", "Croatia", "EUR"},
+	{"HT", "Haiti", "HTG"},
+	{"HU", "Hungary", "HUF"},
+	{"ID", "Indonesia", "IDR"},
+	{"IE", "Ireland", "EUR"},
+	{"IL", "Israel", "ILS"},
+	{"IM", "Isle of Man", "GBP"},
+	{"IN", "India", "INR"},
+	{"IO", "British Indian Ocean Territory", "USD"},
+	{"IQ", "Iraq", "IQD"},
+	{"IR", "Iran", "IRR"},
+	{"IS", "Iceland", "ISK"},
+	{"IT", "Italy", "EUR"},
+	{"JE", "Jersey", "GBP"},
+	{"JM", "Jamaica", "JMD"},
+	{"JO", "Jordan", "JOD"},
+	{"JP", "Japan", "JPY"},
+	{"KE", "Kenya", "KES"},
+	{"KG", "Kyrgyzstan", "KGS"},
+	{"KH", "Cambodia", "KHR"},
+	{"KI", "Kiribati", "AUD"},
+	{"KM", "Comoros", "KMF"},
+	{"KN", "Saint Kitts and Nevis", "XCD"},
+	{"KP", "Korea, Democratic People's Republic of", "KPW"},
+	{"KR", "Korea, Republic of", "KRW"},
+	{"KW", "Kuwait", "KWD"},
+	{"KY", "Cayman Islands", "KYD"},
+	{"KZ", "Kazakhstan", "KZT"},
+	{"LA", "Lao People's Democratic Republic", "LAK"},
+	{"LB", "Lebanon", "LBP"},
+	{"LC", "Saint Lucia", "XCD"},
+	{"LI", "Liechtenstein", "CHF"},
+	{"LK", "Sri Lanka", "LKR"},
+	{"LR", "Liberia", "LRD"},
+	{"LS", "Lesotho", "LSL"},
+	{"LT", "Lithuania", "EUR"},
+	{"LU", "Luxembourg", "EUR"},
+	{"LV", "Latvia", "EUR"},
+	{"LY", "Libya", "LYD"},
+	{"MA", "Morocco", "MAD"},
+	{"MC", "Monaco", "EUR"},
+	{"MD", "Moldova", "MDL"},
+	{"ME", "Montenegro", "EUR"},
+	{"MF", "Saint Martin (French part)", "EUR"},
+	{"MG", "Madagascar", "MGA"},
+	{"MH", "Marshall Islands", "USD"},
+	{"MK", "North Macedonia", "MKD"},
+	{"ML", "Mali", "XOF"},
+	{"MM", "Myanmar", "MMK"},
+	{"MN", "Mongolia", "MNT"},
+	{"MO", "Macao", "MOP"},
+	{"MP", "Northern Mariana Islands", "USD"},
+	{"MQ", "Martinique", "EUR"},
+	{"MR", "Mauritania", "MRU"},
+	{"MS", "Montserrat", "XCD"},
+	{"MT", "Malta", "EUR"},
+	{"MU", "Mauritius", "MUR"},
+	{"MV", "Maldives", "MVR"},
+	{"MW", "Malawi", "MWK"},
+	{"MX", "Mexico", "MXN"},
+	{"MY", "Malaysia", "MYR"},
+	{"MZ", "Mozambique", "MZN"},
+	{"NA", "Namibia", "NAD"},
+	{"NC", "New Caledonia", "XPF"},
+	{"NE", "Niger", "XOF"},
+	{"NF", "Norfolk Island", "AUD"},
+	{"NG", "Nigeria", "NGN"},
+	{"NI", "Nicaragua", "NIO"},
+	{"NL", "Netherlands", "EUR"},
+	{"NO", "Norway", "NOK"},
+	{"NP", "Nepal", "NPR"},
+	{"NR", "Nauru", "AUD"},
+	{"NU", "Niue", "NZD"},
+	{"NZ", "New Zealand", "NZD"},
+	{"OM", "Oman", "OMR"},
+	{"PA", "Panama", "PAB"},
+	{"PE", "Peru", "PEN"},
+	{"PF", "French Polynesia", "XPF"},
+	{"PG", "Papua New Guinea", "PGK"},
+	{"PH", "Philippines", "PHP"},
+	{"PK", "Pakistan", "PKR"},
+	{"PL", "Poland", "PLN"},
+	{"PM", "Saint Pierre and Miquelon", "EUR"},
+	{"PN", "Pitcairn", "NZD"},
+	{"PR", "Puerto Rico", "USD"},
+	{"PS", "Palestine, State of", "ILS"},
+	{"PT", "Portugal", "EUR"},
+	{"PW", "Palau", "USD"},
+	{"PY", "Paraguay", "PYG"},
+	{"QA", "Qatar", "QAR"},
+	{"RE", "Réunion", "EUR"},
+	{"RO", "Romania", "RON"},
+	{"RS", "Serbia", "RSD"},
+	{"RU", "Russian Federation", "RUB"},
+	{"RW", "Rwanda", "RWF"},
+	{"SA", "Saudi Arabia", "SAR"},
+	{"SB", "Solomon Islands", "SBD"},
+	{"SC", "Seychelles", "SCR"},
+	{"SD", "Sudan", "SDG"},
+	{"SE", "Sweden", "SEK"},
+	{"SG", "Singapore", "SGD"},
+	{"SH", "Saint Helena, Ascension and Tristan da Cunha", "SHP"},
+	{"SI", "Slovenia", "EUR"},
+	{"SJ", "Svalbard and Jan Mayen", "NOK"},
+	{"SK", "Slovakia", "EUR"},
+	{"SL", "Sierra Leone", "SLE"},
+	{"SM", "San Marino", "EUR"},
+	{"SN", "Senegal", "XOF"},
+	{"SO", "Somalia", "SOS"},
+	{"SR", "Suriname", "SRD"},
+	{"SS", "South Sudan", "SSP"},
+	{"ST", "Sao Tome and Principe", "STN"},
+	{"SV", "El Salvador", "USD"},
+	{"SX", "Sint Maarten (Dutch part)", "XCG"},
+	{"SY", "Syrian Arab Republic", "SYP"},
+	{"SZ", "Eswatini", "SZL"},
+	{"TC", "Turks and Caicos Islands", "USD"},
+	{"TD", "Chad", "XAF"},
+	{"TF", "French Southern Territories", "EUR"},
+	{"TG", "Togo", "XOF"},
+	{"TH", "Thailand", "THB"},
+	{"TJ", "Tajikistan", "TJS"},
+	{"TK", "Tokelau", "NZD"},
+	{"TL", "Timor-Leste", "USD"},
+	{"TM", "Turkmenistan", "TMT"},
+	{"TN", "Tunisia", "TND"},
+	{"TO", "Tonga", "TOP"},
+	{"TR", "Türkiye", "TRY"},
+	{"TT", "Trinidad and Tobago", "TTD"},
+	{"TV", "Tuvalu", "AUD"},
+	{"TW", "Taiwan", "TWD"},
+	{"TZ", "Tanzania", "TZS"},
+	{"UA", "Ukraine", "UAH"},
+	{"UG", "Uganda", "UGX"},
+	{"UM", "United States Minor Outlying Islands", "USD"},
+	{"US", "United States", "USD"},
+	{"UY", "Uruguay", "UYU"},
+	{"UZ", "Uzbekistan", "UZS"},
+	{"VA", "Holy See", "EUR"},
+	{"VC", "Saint Vincent and the Grenadines", "XCD"},
+	{"VE", "Venezuela", "VES"},
+	{"VG", "Virgin Islands (British)", "USD"},
+	{"VI", "Virgin Islands (U.S.)", "USD"},
+	{"VN", "Viet Nam", "VND"},
+	{"VU", "Vanuatu", "VUV"},
+	{"WF", "Wallis and Futuna", "XPF"},
+	{"WS", "Samoa", "WST"},
+	{"YE", "Yemen", "YER"},
+	{"YT", "Mayotte", "EUR"},
+	{"ZA", "South Africa", "ZAR"},
+	{"ZM", "Zambia", "ZMW"},
+	{"ZW", "Zimbabwe", "ZWG"},
+}
+
+// countryAliases maps codes used in place of ISO codes to the ISO code: the
+// VAT prefixes of Greece and Northern Ireland and the common UK
+var countryAliases = map[string]string{"EL": "GR", "UK": "GB", "XI": "GB"}
+
+// NormalizeCountryCode returns the ISO 3166-1 alpha-2 code for a country
+// code in any case, also accepting VAT prefixes such as EL for Greece. Other
+// codes are returned in upper case.
+func NormalizeCountryCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if alias, ok := countryAliases[code]; ok {
+		return alias
+	}
+	return code
+}
+
+// Countries returns the ISO 3166-1 countries, sorted by code
+func Countries() []Country {
+	return slices.Clone(countries)
+}
+
+// LookupCountry returns the country with an ISO 3166-1 alpha-2 code
+func LookupCountry(code string) (Country, bool) {
+	code = NormalizeCountryCode(code)
+	i, found := slices.BinarySearchFunc(countries, code, func(c Country, code string) int {
+		return strings.Compare(c.Code, code)
+	})
+	if !found {
+		return Country{}, false
+	}
+	return countries[i], true
+}
+
+// FindCountry returns the country with an ISO 3166-1 alpha-2 code or, in any
+// case, an English short name, such as DE or germany
+func FindCountry(codeOrName string) (Country, bool) {
+	if country, ok := LookupCountry(codeOrName); ok {
+		return country, true
+	}
+	name := strings.TrimSpace(codeOrName)
+	for _, country := range countries {
+		if strings.EqualFold(country.Name, name) {
+			return country, true
+		}
+	}
+	return Country{}, false
+}
+
+// IsCountryCode reports whether code is an ISO 3166-1 alpha-2 code or an alias of one
+func IsCountryCode(code string) bool {
+	_, ok := LookupCountry(code)
+	return ok
+}
+
+// CountryCurrency returns the currency a country used on a date, taking euro
+// adoptions into account, or an empty string for unknown countries
+func CountryCurrency(code string, date time.Time) string {
+	country, ok := LookupCountry(code)
+	if !ok {
+		return ""
+	}
+	if adoption, ok := euroAdoptions[country.Code]; ok && date.Before(adoption.date) {
+		return adoption.formerCurrency
+	}
+	return country.Currency
+}
@@ -0,0 +1,217 @@
+package refdata
+
+import (
+	"slices"
+	"strings"
+)
+
+// Currency is an ISO 4217 currency
+type Currency struct {
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	Decimals int    `json:"decimals"`         // Digits after the decimal point of the minor unit
+	Symbol   string `json:"symbol,omitempty"` // Empty when the code is written instead
+	// Former currencies were replaced, by the euro for example, and are only
+	// kept for existing invoices
+	Former bool `json:"former,omitempty"`
+}
+
+// currencies are the ISO 4217 currencies of the countries, and the national
+// currencies replaced by the euro since 2023, sorted by code
+var currencies = []Currency{
+	{Code: "AED", Name: "UAE Dirham", Decimals: 2},
+	{Code: "AFN", Name: "Afghani", Decimals: 2},
+	{Code: "ALL", Name: "Lek", Decimals: 2},
+	{Code: "AMD", Name: "Armenian Dram", Decimals: 2},
+	{Code: "AOA", Name: "Kwanza", Decimals: 2},
+	{Code: "ARS", Name: "Argentine Peso", Decimals: 2},
+	{Code: "AUD", Name: "Australian Dollar", Decimals: 2, Symbol: "A$"},
+	{Code: "AWG", Name: "Aruban Florin", Decimals: 2},
+	{Code: "AZN", Name: "Azerbaijan Manat", Decimals: 2},
+	{Code: "BAM", Name: "Convertible Mark", Decimals: 2},
+	{Code: "BBD", Name: "Barbados Dollar", Decimals: 2},
+	{Code: "BDT", Name: "Taka", Decimals: 2},
+	{Code: "BGN", Name: "Bulgarian Lev", Decimals: 2, Symbol: "лв", Former: true},
+	{Code: "BHD", Name: "Bahraini Dinar", Decimals: 3},
+	{Code: "BIF", Name: "Burundi Franc", Decimals: 0},
+	{Code: "BMD", Name: "Bermudian Dollar", Decimals: 2},
+	{Code: "BND", Name: "Brunei Dollar", Decimals: 2},
+	{Code: "BOB", Name: "Boliviano", Decimals: 2},
+	{Code: "BRL", Name: "Brazilian Real", Decimals: 2, Symbol: "R$"},
+	{Code: "BSD", Name: "Bahamian Dollar", Decimals: 2},
+	{Code: "BTN", Name: "Ngultrum", Decimals: 2},
+	{Code: "BWP", Name: "Pula", Decimals: 2},
+	{Code: "BYN", Name: "Belarusian Ruble", Decimals: 2},
+	{Code: "BZD", Name: "Belize Dollar", Decimals: 2},
+	{Code: "CAD", Name: "Canadian Dollar", Decimals: 2, Symbol: "C$"},
+	{Code: "CDF", Name: "Congolese Franc", Decimals: 2},
+	{Code: "CHF", Name: "Swiss Franc", Decimals: 2},
+	{Code: "CLP", Name: "Chilean Peso", Decimals: 0},
+	{Code: "CNY", Name: "Yuan Renminbi", Decimals: 2, Symbol: "¥"},
+	{Code: "COP", Name: "Colombian Peso", Decimals: 2},
+	{Code: "CRC", Name: "Costa Rican Colon", Decimals: 2},
+	{Code: "CUP", Name: "Cuban Peso", Decimals: 2},
+	{Code: "CVE", Name: "Cabo Verde Escudo", Decimals: 2},
+	{Code: "CZK", Name: "Czech Koruna", Decimals: 2, Symbol: "Kč"},
+	{Code: "DJF", Name: "Djibouti Franc", Decimals: 0},
+	{Code: "DKK", Name: "Danish Krone", Decimals: 2, Symbol: "kr"},
+	{Code: "DOP", Name: "Dominican Peso", Decimals: 2},
+	{Code: "DZD", Name: "Algerian Dinar", Decimals: 2},
+	{Code: "EGP", Name: "Egyptian Pound", Decimals: 2},
+	{Code: "ERN", Name: "Nakfa", Decimals: 2},
+	{Code: "ETB", Name: "Ethiopian Birr", Decimals: 2},
+	{Code: "EUR", Name: "Euro", Decimals: 2, Symbol: "€"},
+	{Code: "FJD", Name: "Fiji Dollar", Decimals: 2},
+	{Code: "FKP", Name: "Falkland Islands Pound", Decimals: 2},
+	{Code: "GBP", Name: "Pound Sterling", Decimals: 2, Symbol: "£"},
+	{Code: "GEL", Name: "Lari", Decimals: 2},
+	{Code: "GHS", Name: "Ghana Cedi", Decimals: 2},
+	{Code: "GIP", Name: "Gibraltar Pound", Decimals: 2},
+	{Code: "GMD", Name: "Dalasi", Decimals: 2},
+	{Code: "GNF", Name: "Guinean Franc", Decimals: 0},
+	{Code: "GTQ", Name: "Quetzal", Decimals: 2},
+	{Code: "GYD", Name: "Guyana Dollar", Decimals: 2},
+	{Code: "HKD", Name: "Hong Kong Dollar", Decimals: 2, Symbol: "HK$"},
+	{Code: "HNL", Name: "Lempira", Decimals: 2},
+	{Code: "HRK", Name: "Kuna", Decimals: 2, Symbol: "kn", Former: true},
+	{Code: "HTG", Name: "Gourde", Decimals: 2},
+	{Code: "HUF", Name: "Forint", Decimals: 2, Symbol: "Ft"},
+	{Code: "IDR", Name: "Rupiah", Decimals: 2},
+	{Code: "ILS", Name: "New Israeli Sheqel", Decimals: 2, Symbol: "₪"},
+	{Code: "INR", Name: "Indian Rupee", Decimals: 2, Symbol: "₹"},
+	{Code: "IQD", Name: "Iraqi Dinar", Decimals: 3},
+	{Code: "IRR", Name: "Iranian Rial", Decimals: 2},
+	{Code: "ISK", Name: "Iceland Krona", Decimals: 0, Symbol: "kr"},
+	{Code: "JMD", Name: "Jamaican Dollar", Decimals: 2},
+	{Code: "JOD", Name: "Jordanian Dinar", Decimals: 3},
+	{Code: "JPY", Name: "Yen", Decimals: 0, Symbol: "¥"},
+	{Code: "KES", Name: "Kenyan Shilling", Decimals: 2},
+	{Code: "KGS", Name: "Som", Decimals: 2},
+	{Code: "KHR", Name: "Riel", Decimals: 2},
+	{Code: "KMF", Name: "Comorian Franc", Decimals: 0},
+	{Code: "KPW", Name: "North Korean Won", Decimals: 2},
+	{Code: "KRW", Name: "Won", Decimals: 0, Symbol: "₩"},
+	{Code: "KWD", Name: "Kuwaiti Dinar", Decimals: 3},
+	{Code: "KYD", Name: "Cayman Islands Dollar", Decimals: 2},
+	{Code: "KZT", Name: "Tenge", Decimals: 2},
+	{Code: "LAK", Name: "Lao Kip", Decimals: 2},
+	{Code: "LBP", Name: "Lebanese Pound", Decimals: 2},
+	{Code: "LKR", Name: "Sri Lanka Rupee", Decimals: 2},
+	{Code: "LRD", Name: "Liberian Dollar", Decimals: 2},
+	{Code: "LSL", Name: "Loti", Decimals: 2},
+	{Code: "LYD", Name: "Libyan Dinar", Decimals: 3},
+	{Code: "MAD", Name: "Moroccan Dirham", Decimals: 2},
+	{Code: "MDL", Name: "Moldovan Leu", Decimals: 2},
+	{Code: "MGA", Name: "Malagasy Ariary", Decimals: 2},
+	{Code: "MKD", Name: "Denar", Decimals: 2},
+	{Code: "MMK", Name: "Kyat", Decimals: 2},
+	{Code: "MNT", Name: "Tugrik", Decimals: 2},
+	{Code: "MOP", Name: "Pataca", Decimals: 2},
+	{Code: "MRU", Name: "Ouguiya", Decimals: 2},
+	{Code: "MUR", Name: "Mauritius Rupee", Decimals: 2},
+	{Code: "MVR", Name: "Rufiyaa", Decimals: 2},
+	{Code: "MWK", Name: "Malawi Kwacha", Decimals: 2},
+	{Code: "MXN", Name: "Mexican Peso", Decimals: 2},
+	{Code: "MYR", Name: "Malaysian Ringgit", Decimals: 2},
+	{Code: "MZN", Name: "Mozambique Metical", Decimals: 2},
+	{Code: "NAD", Name: "Namibia Dollar", Decimals: 2},
+	{Code: "NGN", Name: "Naira", Decimals: 2},
+	{Code: "NIO", Name: "Cordoba Oro", Decimals: 2},
+	{Code: "NOK", Name: "Norwegian Krone", Decimals: 2, Symbol: "kr"},
+	{Code: "NPR", Name: "Nepalese Rupee", Decimals: 2},
+	{Code: "NZD", Name: "New Zealand Dollar", Decimals: 2, Symbol: "NZ$"},
+	{Code: "OMR", Name: "Rial Omani", Decimals: 3},
+	{Code: "PAB", Name: "Balboa", Decimals: 2},
+	{Code: "PEN", Name: "Sol", Decimals: 2},
+	{Code: "PGK", Name: "Kina", Decimals: 2},
+	{Code: "PHP", Name: "Philippine Peso", Decimals: 2},
+	{Code: "PKR", Name: "Pakistan Rupee", Decimals: 2},
+	{Code: "PLN", Name: "Zloty", Decimals: 2, Symbol: "zł"},
+	{Code: "PYG", Name: "Guarani", Decimals: 0},
+	{Code: "QAR", Name: "Qatari Rial", Decimals: 2},
+	{Code: "RON", Name: "Romanian Leu", Decimals: 2, Symbol: "lei"},
+	{Code: "RSD", Name: "Serbian Dinar", Decimals: 2},
+	{Code: "RUB", Name: "Russian Ruble", Decimals: 2},
+	{Code: "RWF", Name: "Rwanda Franc", Decimals: 0},
+	{Code: "SAR", Name: "Saudi Riyal", Decimals: 2},
+	{Code: "SBD", Name: "Solomon Islands Dollar", Decimals: 2},
+	{Code: "SCR", Name: "Seychelles Rupee", Decimals: 2},
+	{Code: "SDG", Name: "Sudanese Pound", Decimals: 2},
+	{Code: "SEK", Name: "Swedish Krona", Decimals: 2, Symbol: "kr"},
+	{Code: "SGD", Name: "Singapore Dollar", Decimals: 2, Symbol: "S$"},
+	{Code: "SHP", Name: "Saint Helena Pound", Decimals: 2},
+	{Code: "SLE", Name: "Leone", Decimals: 2},
+	{Code: "SOS", Name: "Somali Shilling", Decimals: 2},
+	{Code: "SRD", Name: "Surinam Dollar", Decimals: 2},
+	{Code: "SSP", Name: "South Sudanese Pound", Decimals: 2},
+	{Code: "STN", Name: "Dobra", Decimals: 2},
+	{Code: "SYP", Name: "Syrian Pound", Decimals: 2},
+	{Code: "SZL", Name: "Lilangeni", Decimals: 2},
+	{Code: "THB", Name: "Baht", Decimals: 2, Symbol: "฿"},
+	{Code: "TJS", Name: "Somoni", Decimals: 2},
+	{Code: "TMT", Name: "Turkmenistan New Manat", Decimals: 2},
+	{Code: "TND", Name: "Tunisian Dinar", Decimals: 3},
+	{Code: "TOP", Name: "Pa'anga", Decimals: 2},
+	{Code: "TRY", Name: "Turkish Lira", Decimals: 2, Symbol: "₺"},
+	{Code: "TTD", Name: "Trinidad and Tobago Dollar", Decimals: 2},
+	{Code: "TWD", Name: "New Taiwan Dollar", Decimals: 2},
+	{Code: "TZS", Name: "Tanzanian Shilling", Decimals: 2},
+	{Code: "UAH", Name: "Hryvnia", Decimals: 2, Symbol: "₴"},
+	{Code: "UGX", Name: "Uganda Shilling", Decimals: 0},
+	{Code: "USD", Name: "US Dollar", Decimals: 2, Symbol: "$"},
+	{Code: "UYU", Name: "Peso Uruguayo", Decimals: 2},
+	{Code: "UZS", Name: "Uzbekistan Sum", Decimals: 2},
+	{Code: "VES", Name: "Bolívar Soberano", Decimals: 2},
+	{Code: "VND", Name: "Dong", Decimals: 0, Symbol: "₫"},
+	{Code: "VUV", Name: "Vatu", Decimals: 0},
+	{Code: "WST", Name: "Tala", Decimals: 2},
+	{Code: "XAF", Name: "CFA Franc BEAC", Decimals: 0},
+	{Code: "XCD", Name: "East Caribbean Dollar", Decimals: 2},
+	{Code: "XCG", Name: "Caribbean Guilder", Decimals: 2},
+	{Code: "XOF", Name: "CFA Franc BCEAO", Decimals: 0},
+	{Code: "XPF", Name: "CFP Franc", Decimals: 0},
+	{Code: "YER", Name: "Yemeni Rial", Decimals: 2},
+	{Code: "ZAR", Name: "Rand", Decimals: 2, Symbol: "R"},
+	{Code: "ZMW", Name: "Zambian Kwacha", Decimals: 2},
+	{Code: "ZWG", Name: "Zimbabwe Gold", Decimals: 2},
+}
+
+// Currencies returns the ISO 4217 currencies, sorted by code
+func Currencies() []Currency {
+	return slices.Clone(currencies)
+}
+
+// LookupCurrency returns the currency with an ISO 4217 code
+func LookupCurrency(code string) (Currency, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	i, found := slices.BinarySearchFunc(currencies, code, func(c Currency, code string) int {
+		return strings.Compare(c.Code, code)
+	})
+	if !found {
+		return Currency{}, false
+	}
+	return currencies[i], true
+}
+
+// IsCurrencyCode reports whether code is an ISO 4217 currency code
+func IsCurrencyCode(code string) bool {
+	_, ok := LookupCurrency(code)
+	return ok
+}
+
+// CurrencyDecimals returns the digits after the decimal point of a
+// currency's minor unit, 2 for unknown currencies
+func CurrencyDecimals(code string) int {
+	if currency, ok := LookupCurrency(code); ok {
+		return currency.Decimals
+	}
+	return 2
+}
+
+// CurrencySymbol returns the symbol of a currency, or the code when it has none
+func CurrencySymbol(code string) string {
+	if currency, ok := LookupCurrency(code); ok && currency.Symbol != "" {
+		return currency.Symbol
+	}
+	return code
+}
@@ -0,0 +1,106 @@
+package refdata
+
+import (
+	"slices"
+	"time"
+)
+
+// membership is when a country joined and, for former members, left a union
+type membership struct {
+	joined time.Time
+	left   time.Time // Zero for current members
+}
+
+// day returns midnight UTC of a date
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+// euMembers are the member states of the EU, including the founding members
+// of the European Economic Community, with the dates they joined and left
+var euMembers = map[string]membership{
+	"BE": {joined: day(1958, time.January, 1)},
+	"DE": {joined: day(1958, time.January, 1)},
+	"FR": {joined: day(1958, time.January, 1)},
+	"IT": {joined: day(1958, time.January, 1)},
+	"LU": {joined: day(1958, time.January, 1)},
+	"NL": {joined: day(1958, time.January, 1)},
+	"DK": {joined: day(1973, time.January, 1)},
+	"IE": {joined: day(1973, time.January, 1)},
+	"GB": {joined: day(1973, time.January, 1), left: day(2020, time.February, 1)},
+	"GR": {joined: day(1981, time.January, 1)},
+	"ES": {joined: day(1986, time.January, 1)},
+	"PT": {joined: day(1986, time.January, 1)},
+	"AT": {joined: day(1995, time.January, 1)},
+	"FI": {joined: day(1995, time.January, 1)},
+	"SE": {joined: day(1995, time.January, 1)},
+	"CY": {joined: day(2004, time.May, 1)},
+	"CZ": {joined: day(2004, time.May, 1)},
+	"EE": {joined: day(2004, time.May, 1)},
+	"HU": {joined: day(2004, time.May, 1)},
+	"LT": {joined: day(2004, time.May, 1)},
+	"LV": {joined: day(2004, time.May, 1)},
+	"MT": {joined: day(2004, time.May, 1)},
+	"PL": {joined: day(2004, time.May, 1)},
+	"SI": {joined: day(2004, time.May, 1)},
+	"SK": {joined: day(2004, time.May, 1)},
+	"BG": {joined: day(2007, time.January, 1)},
+	"RO": {joined: day(2007, time.January, 1)},
+	"HR": {joined: day(2013, time.July, 1)},
+}
+
+// euroAdoption is when an EU member state replaced its currency with the euro
+type euroAdoption struct {
+	date           time.Time
+	formerCurrency string
+}
+
+// euroAdoptions are the members of the euro area. Countries that use the
+// euro without being members, such as Montenegro, are not included.
+var euroAdoptions = map[string]euroAdoption{
+	"AT": {day(1999, time.January, 1), "ATS"},
+	"BE": {day(1999, time.January, 1), "BEF"},
+	"DE": {day(1999, time.January, 1), "DEM"},
+	"ES": {day(1999, time.January, 1), "ESP"},
+	"FI": {day(1999, time.January, 1), "FIM"},
+	"FR": {day(1999, time.January, 1), "FRF"},
+	"IE": {day(1999, time.January, 1), "IEP"},
+	"IT": {day(1999, time.January, 1), "ITL"},
+	"LU": {day(1999, time.January, 1), "LUF"},
+	"NL": {day(1999, time.January, 1), "NLG"},
+	"PT": {day(1999, time.January, 1), "PTE"},
+	"GR": {day(2001, time.January, 1), "GRD"},
+	"SI": {day(2007, time.January, 1), "SIT"},
+	"CY": {day(2008, time.January, 1), "CYP"},
+	"MT": {day(2008, time.January, 1), "MTL"},
+	"SK": {day(2009, time.January, 1), "SKK"},
+	"EE": {day(2011, time.January, 1), "EEK"},
+	"LV": {day(2014, time.January, 1), "LVL"},
+	"LT": {day(2015, time.January, 1), "LTL"},
+	"HR": {day(2023, time.January, 1), "HRK"},
+	"BG": {day(2026, time.January, 1), "BGN"},
+}
+
+// IsEUMember reports whether a country was a member state of the EU on a date
+func IsEUMember(code string, date time.Time) bool {
+	m, ok := euMembers[NormalizeCountryCode(code)]
+	return ok && !date.Before(m.joined) && (m.left.IsZero() || date.Before(m.left))
+}
+
+// IsEurozone reports whether a country used the euro as an EU member state on a date
+func IsEurozone(code string, date time.Time) bool {
+	adoption, ok := euroAdoptions[NormalizeCountryCode(code)]
+	return ok && !date.Before(adoption.date)
+}
+
+// EUMembers returns the codes of the EU member states on a date, sorted
+func EUMembers(date time.Time) []string {
+	codes := []string{}
+	for code := range euMembers {
+		if IsEUMember(code, date) {
+			codes = append(codes, code)
+		}
+	}
+	slices.Sort(codes)
+	return codes
+}
@@ -0,0 +1,44 @@
+package refdata
+
+// dateFormats are the usual formats of dates in countries, as Go layouts of
+// the reference date 2 January 2006
+var dateFormats = map[string]string{
+	"AT": "02.01.2006", "BG": "02.01.2006", "CH": "02.01.2006", "CZ": "02.01.2006", "DE": "02.01.2006", "DK": "02.01.2006",
+	"EE": "02.01.2006", "FI": "02.01.2006", "HR": "02.01.2006", "LI": "02.01.2006", "LU": "02.01.2006", "LV": "02.01.2006",
+	"NO": "02.01.2006", "PL": "02.01.2006", "RO": "02.01.2006", "RU": "02.01.2006", "SI": "02.01.2006", "SK": "02.01.2006",
+	"TR": "02.01.2006", "UA": "02.01.2006",
+	"AU": "02/01/2006", "BE": "02/01/2006", "BR": "02/01/2006", "CY": "02/01/2006", "ES": "02/01/2006", "FR": "02/01/2006",
+	"GR": "02/01/2006", "IN": "02/01/2006", "IT": "02/01/2006", "MT": "02/01/2006", "MX": "02/01/2006", "NZ": "02/01/2006",
+	"PT": "02/01/2006",
+	"GB": "02 Jan 2006", "IE": "02 Jan 2006",
+	"NL": "02-01-2006",
+	"US": "01/02/2006",
+	"CA": "2006-01-02", "CN": "2006-01-02", "JP": "2006-01-02", "KR": "2006-01-02", "LT": "2006-01-02", "SE": "2006-01-02",
+	"HU": "2006.01.02.",
+}
+
+// numberFormats are the usual formats of numbers in countries, written as the
+// number 1234.56 with its thousands and decimal separators
+var numberFormats = map[string]string{
+	"AT": "1.234,56", "BE": "1.234,56", "BR": "1.234,56", "DE": "1.234,56", "DK": "1.234,56", "ES": "1.234,56", "GR": "1.234,56",
+	"HR": "1.234,56", "ID": "1.234,56", "IT": "1.234,56", "NL": "1.234,56", "PT": "1.234,56", "RO": "1.234,56", "SI": "1.234,56",
+	"TR": "1.234,56",
+	"BG": "1 234,56", "CZ": "1 234,56", "EE": "1 234,56", "FI": "1 234,56", "FR": "1 234,56", "HU": "1 234,56", "LT": "1 234,56",
+	"LU": "1 234,56", "LV": "1 234,56", "NO": "1 234,56", "PL": "1 234,56", "RU": "1 234,56", "SE": "1 234,56", "SK": "1 234,56",
+	"UA": "1 234,56",
+	"CH": "1'234.56", "LI": "1'234.56",
+	"AU": "1,234.56", "CA": "1,234.56", "CN": "1,234.56", "CY": "1,234.56", "GB": "1,234.56", "IE": "1,234.56", "IN": "1,234.56",
+	"JP": "1,234.56", "KR": "1,234.56", "MT": "1,234.56", "MX": "1,234.56", "NZ": "1,234.56", "US": "1,234.56",
+}
+
+// DateFormat returns the usual format of dates in a country as a Go layout,
+// e.g. 02.01.2006 for DE, or an empty string for countries without one
+func DateFormat(code string) string {
+	return dateFormats[NormalizeCountryCode(code)]
+}
+
+// NumberFormat returns the usual format of numbers in a country, e.g.
+// 1.234,56 for DE, or an empty string for countries without one
+func NumberFormat(code string) string {
+	return numberFormats[NormalizeCountryCode(code)]
+}
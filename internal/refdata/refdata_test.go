@@ -0,0 +1,169 @@
+package refdata
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListsAreSorted(t *testing.T) {
+	if !slices.IsSortedFunc(countries, func(a, b Country) int { return strings.Compare(a.Code, b.Code) }) {
+		t.Error("countries are not sorted by code")
+	}
+	if !slices.IsSortedFunc(currencies, func(a, b Currency) int { return strings.Compare(a.Code, b.Code) }) {
+		t.Error("currencies are not sorted by code")
+	}
+	for _, country := range countries {
+		if _, ok := LookupCurrency(country.Currency); !ok && country.Currency != "" {
+			t.Errorf("currency %s of %s is not listed", country.Currency, country.Code)
+		}
+	}
+	for code, adoption := range euroAdoptions {
+		if _, ok := euMembers[code]; !ok {
+			t.Errorf("euro area member %s is not an EU member", code)
+		}
+		if country, _ := LookupCountry(code); country.Currency != "EUR" {
+			t.Errorf("currency of %s = %q, want EUR", code, country.Currency)
+		}
+		if adoption.date.Year() >= 2023 {
+			if currency, ok := LookupCurrency(adoption.formerCurrency); !ok || !currency.Former {
+				t.Errorf("former currency %s of %s is not listed as former", adoption.formerCurrency, code)
+			}
+		}
+	}
+}
+
+func TestLookupCountry(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+		ok   bool
+	}{
+		{"DE", "DE", true},
+		{"de", "DE", true},
+		{" fr ", "FR", true},
+		{"EL", "GR", true},
+		{"UK", "GB", true},
+		{"XI", "GB", true},
+		{"XX", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		country, ok := LookupCountry(tt.code)
+		if ok != tt.ok || country.Code != tt.want {
+			t.Errorf("LookupCountry(%q) = %q, %v; want %q, %v", tt.code, country.Code, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFindCountry(t *testing.T) {
+	tests := []struct {
+		codeOrName string
+		want       string
+		ok         bool
+	}{
+		{"de", "DE", true},
+		{"Germany", "DE", true},
+		{" united kingdom ", "GB", true},
+		{"EL", "GR", true},
+		{"Deutschland", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		country, ok := FindCountry(tt.codeOrName)
+		if ok != tt.ok || country.Code != tt.want {
+			t.Errorf("FindCountry(%q) = %q, %v; want %q, %v", tt.codeOrName, country.Code, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCountryCurrency(t *testing.T) {
+	tests := []struct {
+		code string
+		date time.Time
+		want string
+	}{
+		{"DE", day(2024, time.June, 1), "EUR"},
+		{"HR", day(2022, time.December, 31), "HRK"},
+		{"HR", day(2023, time.January, 1), "EUR"},
+		{"BG", day(2025, time.December, 31), "BGN"},
+		{"BG", day(2026, time.January, 1), "EUR"},
+		{"RO", day(2024, time.June, 1), "RON"},
+		{"GB", day(2024, time.June, 1), "GBP"},
+		{"CH", day(2024, time.June, 1), "CHF"},
+		{"XX", day(2024, time.June, 1), ""},
+	}
+
+	for _, tt := range tests {
+		if got := CountryCurrency(tt.code, tt.date); got != tt.want {
+			t.Errorf("CountryCurrency(%q, %s) = %q, want %q", tt.code, tt.date.Format(time.DateOnly), got, tt.want)
+		}
+	}
+}
+
+func TestEUMembership(t *testing.T) {
+	tests := []struct {
+		code string
+		date time.Time
+		eu   bool
+		euro bool
+	}{
+		{"DE", day(2024, time.June, 1), true, true},
+		{"EL", day(2024, time.June, 1), true, true},
+		{"HR", day(2013, time.June, 30), false, false},
+		{"HR", day(2013, time.July, 1), true, false},
+		{"HR", day(2023, time.January, 1), true, true},
+		{"SE", day(2024, time.June, 1), true, false},
+		{"GB", day(2020, time.January, 31), true, false},
+		{"GB", day(2020, time.February, 1), false, false},
+		{"CH", day(2024, time.June, 1), false, false},
+		{"ME", day(2024, time.June, 1), false, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsEUMember(tt.code, tt.date); got != tt.eu {
+			t.Errorf("IsEUMember(%q, %s) = %v, want %v", tt.code, tt.date.Format(time.DateOnly), got, tt.eu)
+		}
+		if got := IsEurozone(tt.code, tt.date); got != tt.euro {
+			t.Errorf("IsEurozone(%q, %s) = %v, want %v", tt.code, tt.date.Format(time.DateOnly), got, tt.euro)
+		}
+	}
+
+	if members := EUMembers(day(2024, time.June, 1)); len(members) != 27 || slices.Contains(members, "GB") {
+		t.Errorf("EUMembers(2024) = %v, want the 27 member states", members)
+	}
+	if members := EUMembers(day(2019, time.June, 1)); len(members) != 28 {
+		t.Errorf("EUMembers(2019) has %d members, want 28", len(members))
+	}
+}
+
+func TestCurrencies(t *testing.T) {
+	tests := []struct {
+		code     string
+		decimals int
+		symbol   string
+	}{
+		{"EUR", 2, "€"},
+		{"eur", 2, "€"},
+		{"JPY", 0, "¥"},
+		{"ISK", 0, "kr"},
+		{"KWD", 3, "KWD"},
+		{"CHF", 2, "CHF"},
+		{"XXX", 2, "XXX"},
+	}
+
+	for _, tt := range tests {
+		if got := CurrencyDecimals(tt.code); got != tt.decimals {
+			t.Errorf("CurrencyDecimals(%q) = %d, want %d", tt.code, got, tt.decimals)
+		}
+		if got := CurrencySymbol(tt.code); got != tt.symbol {
+			t.Errorf("CurrencySymbol(%q) = %q, want %q", tt.code, got, tt.symbol)
+		}
+	}
+	if IsCurrencyCode("ABC") || !IsCurrencyCode("USD") {
+		t.Error("IsCurrencyCode does not tell listed currencies apart")
+	}
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// quickBooksMinorVersion is the QuickBooks Online API version requested
+const quickBooksMinorVersion = "75"
+
+// quickBooksEntities maps the kinds of records to their QuickBooks entity
+var quickBooksEntities = map[string]string{
+	accountingContact:    "Customer",
+	accountingInvoice:    "Invoice",
+	accountingCreditNote: "CreditMemo",
+	accountingPayment:    "Payment",
+}
+
+// quickBooksRecord identifies a saved QuickBooks entity
+type quickBooksRecord struct {
+	ID        string `json:"Id"`
+	SyncToken string `json:"SyncToken"`
+}
+
+// quickBooksClient pushes customers, invoices, credit memos and payments to a
+// QuickBooks Online company. Invoice lines are booked as the configured product or
+// service, and taxed as the company's tax settings decide.
+type quickBooksClient struct {
+	api      *accountingAPI
+	settings *SettingsService
+}
+
+func newQuickBooksClient(api *accountingAPI, settings *SettingsService) accountingClient {
+	return &quickBooksClient{api: api, settings: settings}
+}
+
+// quickBooksPath returns the API path of a resource of a company
+func quickBooksPath(realmID, resource string) string {
+	return "/v3/company/" + url.PathEscape(realmID) + "/" + resource + "?minorversion=" + quickBooksMinorVersion
+}
+
+// quickBooksTenant returns the company access was granted to, whose realm ID
+// QuickBooks sends to the redirect URI
+func quickBooksTenant(api *accountingAPI, realmID string) (string, string, error) {
+	if realmID == "" {
+		return "", "", errors.New("QuickBooks did not send the company ID")
+	}
+	var response struct {
+		CompanyInfo struct {
+			CompanyName string `json:"CompanyName"`
+		} `json:"CompanyInfo"`
+	}
+	if err := api.call(http.MethodGet, quickBooksPath(realmID, "companyinfo/"+url.PathEscape(realmID)), nil, &response); err != nil {
+		return "", "", err
+	}
+	return realmID, response.CompanyInfo.CompanyName, nil
+}
+
+func (c *quickBooksClient) contactBody(client *models.Client) map[string]interface{} {
+	body := map[string]interface{}{
+		"DisplayName": client.Name,
+		"CompanyName": client.Name,
+		"BillAddr": map[string]string{
+			"Line1":      client.Address,
+			"City":       client.City,
+			"PostalCode": client.PostalCode,
+			"Country":    client.Country,
+		},
+	}
+	if client.Email != "" {
+		body["PrimaryEmailAddr"] = map[string]string{"Address": client.Email}
+	}
+	return body
+}
+
+func (c *quickBooksClient) invoiceBody(invoice *models.Invoice, items []models.InvoiceItem, contact accountingRecord) map[string]interface{} {
+	item := map[string]string{"value": c.settings.GetString(SettingQuickBooksItemID)}
+	lines := []map[string]interface{}{}
+	items = accountingItems(invoice, items)
+	for _, invoiceItem := range items {
+		// QuickBooks requires the amount to be the quantity times the unit
+		// price, so discounted lines only carry their amount
+		detail := map[string]interface{}{"ItemRef": item}
+		if !invoiceItem.HasDiscount() {
+			detail["Qty"] = invoiceItem.Quantity
+			detail["UnitPrice"] = invoiceItem.UnitPrice
+		}
+		lines = append(lines, map[string]interface{}{
+			"DetailType":          "SalesItemLineDetail",
+			"Amount":              invoiceItem.Amount,
+			"Description":         invoiceItem.Description,
+			"SalesItemLineDetail": detail,
+		})
+	}
+	if totals := invoice.CalculateTotals(items); totals.Discount > 0 {
+		lines = append(lines, map[string]interface{}{
+			"DetailType":         "DiscountLineDetail",
+			"Amount":             totals.Discount,
+			"DiscountLineDetail": map[string]bool{"PercentBased": false},
+		})
+	}
+	body := map[string]interface{}{
+		"DocNumber":   invoice.InvoiceNumber,
+		"TxnDate":     invoice.IssueDate.Format("2006-01-02"),
+		"DueDate":     invoice.DueDate.Format("2006-01-02"),
+		"CustomerRef": map[string]string{"value": contact.ID},
+		"CurrencyRef": map[string]string{"value": invoice.Currency},
+		"Line":        lines,
+	}
+	// Cancellation invoices are credit memos, which have no due date
+	if invoice.IsStorno() {
+		delete(body, "DueDate")
+	}
+	return body
+}
+
+func (c *quickBooksClient) paymentBody(invoice *models.Invoice, contact, remoteInvoice accountingRecord) map[string]interface{} {
+	return map[string]interface{}{
+		"CustomerRef": map[string]string{"value": contact.ID},
+		"CurrencyRef": map[string]string{"value": invoice.Currency},
+		"TxnDate":     accountingPaymentDate(invoice).Format("2006-01-02"),
+		"TotalAmt":    invoice.AmountDue(),
+		"Line": []map[string]interface{}{{
+			"Amount":    invoice.AmountDue(),
+			"LinkedTxn": []map[string]string{{"TxnId": remoteInvoice.ID, "TxnType": "Invoice"}},
+		}},
+	}
+}
+
+func (c *quickBooksClient) find(entityType, key string) (accountingRecord, error) {
+	var query string
+	quoted := strings.ReplaceAll(key, "'", `\'`)
+	switch entityType {
+	case accountingContact:
+		query = fmt.Sprintf("select Id, SyncToken from Customer where DisplayName = '%s'", quoted)
+	case accountingInvoice, accountingCreditNote:
+		query = fmt.Sprintf("select Id, SyncToken from %s where DocNumber = '%s'", quickBooksEntities[entityType], quoted)
+	default:
+		return accountingRecord{}, nil
+	}
+
+	var response struct {
+		QueryResponse map[string]json.RawMessage `json:"QueryResponse"`
+	}
+	if err := c.api.call(http.MethodGet, quickBooksPath(c.api.tenantID, "query")+"&query="+url.QueryEscape(query), nil, &response); err != nil {
+		return accountingRecord{}, err
+	}
+	var found []quickBooksRecord
+	if data, ok := response.QueryResponse[quickBooksEntities[entityType]]; ok {
+		if err := json.Unmarshal(data, &found); err != nil {
+			return accountingRecord{}, fmt.Errorf("failed to parse QuickBooks response: %w", err)
+		}
+	}
+	if len(found) == 0 {
+		return accountingRecord{}, nil
+	}
+	return accountingRecord{ID: found[0].ID, Version: found[0].SyncToken}, nil
+}
+
+func (c *quickBooksClient) push(entityType string, body map[string]interface{}, remote accountingRecord) (accountingRecord, error) {
+	entity := quickBooksEntities[entityType]
+	record := maps.Clone(body)
+	// Sparse updates only change the fields sent, and need the SyncToken of
+	// the current version
+	if remote.ID != "" {
+		record["Id"] = remote.ID
+		record["SyncToken"] = remote.Version
+		record["sparse"] = true
+	}
+
+	var response map[string]json.RawMessage
+	if err := c.api.call(http.MethodPost, quickBooksPath(c.api.tenantID, strings.ToLower(entity)), record, &response); err != nil {
+		return remote, err
+	}
+	var saved quickBooksRecord
+	if err := json.Unmarshal(response[entity], &saved); err != nil || saved.ID == "" {
+		return remote, fmt.Errorf("QuickBooks returned no %s", strings.ToLower(entity))
+	}
+	return accountingRecord{ID: saved.ID, Version: saved.SyncToken}, nil
+}
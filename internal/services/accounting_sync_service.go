@@ -0,0 +1,812 @@
+package services
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// ErrAccountingNotConfigured is returned when accounting software is connected
+// or synced without a provider and OAuth client
+var ErrAccountingNotConfigured = errors.New("set the accounting software and its OAuth client ID and secret on the settings page to connect it")
+
+// ErrAccountingNotConnected is returned when syncing before an organization
+// was connected
+var ErrAccountingNotConnected = errors.New("connect the accounting software on the settings page first")
+
+// Accounting software invoices can be pushed to
+const (
+	AccountingProviderXero       = "xero"
+	AccountingProviderQuickBooks = "quickbooks"
+)
+
+// AccountingProviders lists the supported accounting software
+var AccountingProviders = []string{AccountingProviderXero, AccountingProviderQuickBooks}
+
+// Kinds of records pushed to the accounting software, as stored in accounting_sync
+const (
+	accountingContact    = "client"
+	accountingInvoice    = "invoice"
+	accountingCreditNote = "credit-note" // Cancellation invoices
+	accountingPayment    = "payment"
+)
+
+// accountingTokenMargin renews access tokens that expire this soon, so they
+// do not run out during a sync. They are valid for 30 to 60 minutes.
+const accountingTokenMargin = 2 * time.Minute
+
+// accountingProvider describes the OAuth endpoints and API of accounting software
+type accountingProvider struct {
+	key          string
+	name         string // Shown to users
+	authorizeURL string
+	tokenURL     string
+	apiURL       string
+	scope        string
+
+	// tenant returns the ID and name of the organization access was granted
+	// to; QuickBooks sends its realm ID to the redirect URI
+	tenant func(api *accountingAPI, realmID string) (string, string, error)
+
+	newClient func(api *accountingAPI, settings *SettingsService) accountingClient
+}
+
+var accountingProviders = map[string]accountingProvider{
+	AccountingProviderXero: {
+		name:         "Xero",
+		authorizeURL: "https://login.xero.com/identity/connect/authorize",
+		tokenURL:     "https://identity.xero.com/connect/token",
+		apiURL:       "https://api.xero.com",
+		scope:        "offline_access accounting.transactions accounting.contacts",
+		tenant:       xeroTenant,
+		newClient:    newXeroClient,
+	},
+	AccountingProviderQuickBooks: {
+		name:         "QuickBooks",
+		authorizeURL: "https://appcenter.intuit.com/connect/oauth2",
+		tokenURL:     "https://oauth.platform.intuit.com/oauth2/v1/tokens/bearer",
+		apiURL:       "https://quickbooks.api.intuit.com",
+		scope:        "com.intuit.quickbooks.accounting",
+		tenant:       quickBooksTenant,
+		newClient:    newQuickBooksClient,
+	},
+}
+
+// accountingRecord identifies a record at the accounting provider
+type accountingRecord struct {
+	ID      string
+	Version string // QuickBooks SyncToken, needed to update the record
+}
+
+// accountingClient pushes the records of one provider. Bodies are built
+// without the remote IDs, so they only change when the invoice data does.
+type accountingClient interface {
+	contactBody(client *models.Client) map[string]interface{}
+	invoiceBody(invoice *models.Invoice, items []models.InvoiceItem, contact accountingRecord) map[string]interface{}
+	paymentBody(invoice *models.Invoice, contact, remoteInvoice accountingRecord) map[string]interface{}
+
+	// find looks up a record that exists but is not mapped yet, such as one
+	// pushed with an earlier connection, by client name or invoice number
+	find(entityType, key string) (accountingRecord, error)
+
+	// push creates the record, or updates it when remote has an ID
+	push(entityType string, body map[string]interface{}, remote accountingRecord) (accountingRecord, error)
+}
+
+// AccountingSyncResult summarizes a sync with the accounting software
+type AccountingSyncResult struct {
+	Contacts int `json:"contacts"` // Clients created or updated
+	Invoices int `json:"invoices"` // Invoices created or updated
+	Payments int `json:"payments"` // Payments of paid invoices created
+	Failed   int `json:"failed"`   // Invoices that could not be pushed, see their sync status
+}
+
+// AccountingSyncService pushes finalized invoices and their payments to Xero
+// or QuickBooks Online, connected through OAuth. Every record pushed is mapped
+// to its remote ID, so later changes update it instead of creating another.
+type AccountingSyncService struct {
+	dbService       *DBService
+	settingsService *SettingsService
+	logger          *Logger
+	client          *http.Client
+	mu              sync.Mutex // Serializes syncs and token renewals
+	stop            chan struct{}
+	done            chan struct{}
+}
+
+// NewAccountingSyncService creates a new AccountingSyncService
+func NewAccountingSyncService(dbService *DBService, settingsService *SettingsService, logger *Logger) *AccountingSyncService {
+	return &AccountingSyncService{
+		dbService:       dbService,
+		settingsService: settingsService,
+		logger:          logger,
+		client:          &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Provider returns the configured accounting software, empty when disabled
+func (s *AccountingSyncService) Provider() string {
+	return s.settingsService.GetString(SettingAccountingProvider)
+}
+
+// provider returns the configured provider with the endpoints set in the settings
+func (s *AccountingSyncService) provider() (accountingProvider, error) {
+	provider, ok := accountingProviders[s.Provider()]
+	if !ok || s.settingsService.GetString(SettingAccountingClientID) == "" || s.settingsService.GetString(SettingAccountingClientSecret) == "" {
+		return accountingProvider{}, ErrAccountingNotConfigured
+	}
+	provider.key = s.Provider()
+	if apiURL := s.settingsService.GetString(SettingAccountingAPIURL); apiURL != "" {
+		provider.apiURL = strings.TrimRight(apiURL, "/")
+	}
+	provider.tokenURL = cmp.Or(s.settingsService.GetString(SettingAccountingTokenURL), provider.tokenURL)
+	return provider, nil
+}
+
+// Configured reports whether accounting software and its OAuth client are set
+func (s *AccountingSyncService) Configured() bool {
+	_, err := s.provider()
+	return err == nil
+}
+
+// Start syncs with the connected accounting software periodically. The
+// interval is read from the settings before every wait.
+func (s *AccountingSyncService) Start() {
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		for {
+			interval := time.Duration(max(s.settingsService.GetInt(SettingAccountingInterval), 1)) * time.Hour
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(interval):
+			}
+
+			RunProtected(s.logger, "accounting sync", func() {
+				if !s.Configured() {
+					return
+				}
+				result, err := s.Sync()
+				switch {
+				case errors.Is(err, ErrAccountingNotConnected):
+				case err != nil:
+					s.logger.Error("Failed to sync with the accounting software: %v", err)
+				case result.Invoices > 0 || result.Payments > 0:
+					s.logger.Info("Pushed %d invoices and %d payments to the accounting software", result.Invoices, result.Payments)
+				}
+			})
+		}
+	}()
+}
+
+// Stop stops the syncing started by Start
+func (s *AccountingSyncService) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
+
+// AuthorizeURL returns where the user grants access to an organization. The
+// provider then sends the user to redirectURL with an authorization code and
+// state, which Connect exchanges for tokens.
+func (s *AccountingSyncService) AuthorizeURL(redirectURL, state string) (string, error) {
+	provider, err := s.provider()
+	if err != nil {
+		return "", err
+	}
+	values := url.Values{
+		"response_type": {"code"},
+		"client_id":     {s.settingsService.GetString(SettingAccountingClientID)},
+		"redirect_uri":  {redirectURL},
+		"scope":         {provider.scope},
+		"state":         {state},
+	}
+	return provider.authorizeURL + "?" + values.Encode(), nil
+}
+
+// accountingToken is the response of an OAuth token endpoint
+type accountingToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // Seconds
+}
+
+// requestToken requests tokens from the token endpoint of the provider,
+// authenticated with the OAuth client
+func (s *AccountingSyncService) requestToken(provider accountingProvider, form url.Values) (*accountingToken, error) {
+	req, err := http.NewRequest(http.MethodPost, provider.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(s.settingsService.GetString(SettingAccountingClientID), s.settingsService.GetString(SettingAccountingClientSecret))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s could not be reached: %w", provider.name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, accountingResponseError(provider.name, resp)
+	}
+
+	var token accountingToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed to parse %s token response: %w", provider.name, err)
+	}
+	if token.AccessToken == "" || token.RefreshToken == "" {
+		return nil, fmt.Errorf("%s returned no tokens", provider.name)
+	}
+	return &token, nil
+}
+
+// saveTokens stores the tokens of a connection, encrypted like secret settings
+func (s *AccountingSyncService) saveTokens(provider string, token *accountingToken) error {
+	access, err := s.settingsService.seal(token.AccessToken)
+	if err != nil {
+		return err
+	}
+	refresh, err := s.settingsService.seal(token.RefreshToken)
+	if err != nil {
+		return err
+	}
+	expires := time.Now().UTC().Add(time.Duration(token.ExpiresIn) * time.Second)
+	if _, err := s.dbService.GetDB().Exec(`UPDATE accounting_connections SET access_token = ?, refresh_token = ?, expires_at = ? WHERE provider = ?`,
+		access, refresh, expires, provider); err != nil {
+		return fmt.Errorf("failed to save accounting tokens: %w", err)
+	}
+	return nil
+}
+
+// Connect exchanges the authorization code sent to the redirect URI for
+// tokens and saves the connection. When another organization was connected
+// before, the records pushed to it are forgotten so they are pushed again.
+func (s *AccountingSyncService) Connect(code, realmID, redirectURL string) (*models.AccountingConnection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	provider, err := s.provider()
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.requestToken(provider, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirectURL},
+	})
+	if err != nil {
+		return nil, err
+	}
+	tenantID, tenantName, err := provider.tenant(s.api(provider, token.AccessToken, ""), realmID)
+	if err != nil {
+		return nil, err
+	}
+
+	connection := &models.AccountingConnection{
+		Provider:    provider.key,
+		TenantID:    tenantID,
+		TenantName:  tenantName,
+		ConnectedAt: time.Now().UTC(),
+	}
+	tx, err := s.dbService.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRow(`SELECT tenant_id FROM accounting_connections WHERE provider = ?`, provider.key).Scan(&previous)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to load accounting connection: %w", err)
+	}
+	if previous != tenantID {
+		if _, err := tx.Exec(`DELETE FROM accounting_sync WHERE provider = ?`, provider.key); err != nil {
+			return nil, fmt.Errorf("failed to reset synced records: %w", err)
+		}
+	}
+	_, err = tx.Exec(`
+		INSERT INTO accounting_connections (provider, tenant_id, tenant_name, access_token, refresh_token, expires_at, connected_at, last_synced_at, last_error)
+		VALUES (?, ?, ?, '', '', ?, ?, ?, '')
+		ON CONFLICT (provider) DO UPDATE SET tenant_id = excluded.tenant_id, tenant_name = excluded.tenant_name,
+			connected_at = excluded.connected_at, last_error = ''
+	`, provider.key, tenantID, tenantName, time.Time{}, connection.ConnectedAt, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save accounting connection: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if err := s.saveTokens(provider.key, token); err != nil {
+		return nil, err
+	}
+	s.logger.Info("Connected %s organization %s", provider.name, tenantName)
+	return connection, nil
+}
+
+// Connection returns the connection of the configured accounting software,
+// nil when it is not connected
+func (s *AccountingSyncService) Connection() (*models.AccountingConnection, error) {
+	var connection models.AccountingConnection
+	err := s.dbService.GetDB().QueryRow(`
+		SELECT provider, tenant_id, tenant_name, connected_at, last_synced_at, last_error
+		FROM accounting_connections WHERE provider = ?
+	`, s.Provider()).Scan(&connection.Provider, &connection.TenantID, &connection.TenantName,
+		&connection.ConnectedAt, &connection.LastSyncedAt, &connection.LastError)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load accounting connection: %w", err)
+	}
+	return &connection, nil
+}
+
+// Disconnect removes the connection of the configured accounting software
+// and forgets the records pushed with it. The records stay in the accounting
+// software; connecting the same organization again finds them by client name
+// and invoice number.
+func (s *AccountingSyncService) Disconnect() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	provider := s.Provider()
+	tx, err := s.dbService.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`DELETE FROM accounting_connections WHERE provider = ?`, provider)
+	if err != nil {
+		return fmt.Errorf("failed to delete accounting connection: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec(`DELETE FROM accounting_sync WHERE provider = ?`, provider); err != nil {
+		return fmt.Errorf("failed to delete synced records: %w", err)
+	}
+	return tx.Commit()
+}
+
+// session returns an API client for the connected organization, renewing the
+// access token when it is about to expire
+func (s *AccountingSyncService) session() (accountingProvider, *accountingAPI, error) {
+	provider, err := s.provider()
+	if err != nil {
+		return provider, nil, err
+	}
+	var tenantID, access, refresh string
+	var expires time.Time
+	err = s.dbService.GetDB().QueryRow(`SELECT tenant_id, access_token, refresh_token, expires_at FROM accounting_connections WHERE provider = ?`,
+		provider.key).Scan(&tenantID, &access, &refresh, &expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return provider, nil, ErrAccountingNotConnected
+	}
+	if err != nil {
+		return provider, nil, fmt.Errorf("failed to load accounting connection: %w", err)
+	}
+	if access, err = s.settingsService.open(access); err != nil {
+		return provider, nil, fmt.Errorf("failed to decrypt the %s access token: %w", provider.name, err)
+	}
+	if refresh, err = s.settingsService.open(refresh); err != nil {
+		return provider, nil, fmt.Errorf("failed to decrypt the %s refresh token: %w", provider.name, err)
+	}
+
+	if time.Now().Add(accountingTokenMargin).After(expires) {
+		token, err := s.requestToken(provider, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refresh}})
+		if err != nil {
+			// Refresh tokens run out when unused for months, or when the access was revoked
+			err = fmt.Errorf("failed to renew the access, connect %s again: %w", provider.name, err)
+			if _, dbErr := s.dbService.GetDB().Exec(`UPDATE accounting_connections SET last_error = ? WHERE provider = ?`, err.Error(), provider.key); dbErr != nil {
+				s.logger.Error("Failed to update accounting connection: %v", dbErr)
+			}
+			return provider, nil, err
+		}
+		if err := s.saveTokens(provider.key, token); err != nil {
+			return provider, nil, err
+		}
+		access = token.AccessToken
+	}
+	return provider, s.api(provider, access, tenantID), nil
+}
+
+// Sync pushes the finalized invoices that are new or changed since they were
+// last pushed, with their clients, and the payments of paid invoices. An
+// invoice that fails is skipped with its error recorded and retried on the
+// next sync.
+func (s *AccountingSyncService) Sync() (*AccountingSyncResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	provider, api, err := s.session()
+	if err != nil {
+		return nil, err
+	}
+	client := provider.newClient(api, s.settingsService)
+	invoices, err := s.dbService.GetInvoices()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AccountingSyncResult{}
+	contacts := map[int]accountingContactResult{}
+	for _, invoice := range invoices {
+		if !accountingSyncable(&invoice) {
+			continue
+		}
+		if err := s.syncInvoice(provider.key, client, invoice.ID, contacts, result); err != nil {
+			s.logger.Error("Failed to push invoice %s to %s: %v", invoice.InvoiceNumber, provider.name, err)
+			result.Failed++
+		}
+	}
+
+	lastError := ""
+	if result.Failed > 0 {
+		lastError = fmt.Sprintf("%d invoices could not be pushed, see their sync status", result.Failed)
+	}
+	if _, err := s.dbService.GetDB().Exec(`UPDATE accounting_connections SET last_synced_at = ?, last_error = ? WHERE provider = ?`,
+		time.Now().UTC(), lastError, provider.key); err != nil {
+		return nil, fmt.Errorf("failed to update accounting connection: %w", err)
+	}
+	return result, nil
+}
+
+// accountingContactResult is the outcome of pushing a client, which is done
+// once per sync however many invoices it has
+type accountingContactResult struct {
+	record accountingRecord
+	err    error
+}
+
+// accountingSyncable reports whether an invoice is pushed to the accounting
+// software. Drafts can still change and pro-forma invoices are not booked.
+func accountingSyncable(invoice *models.Invoice) bool {
+	return invoice.Status != models.InvoiceStatusDraft && !invoice.IsProforma()
+}
+
+// accountingEntity returns the kind of record an invoice is pushed as:
+// cancellation invoices are credit notes, booked against the sales they reverse
+func accountingEntity(invoice *models.Invoice) string {
+	if invoice.IsStorno() {
+		return accountingCreditNote
+	}
+	return accountingInvoice
+}
+
+// accountingItems returns the items of an invoice as pushed. Credit notes
+// carry the items of a cancellation invoice with positive quantities and
+// amounts, as the credit note type already reverses them.
+func accountingItems(invoice *models.Invoice, items []models.InvoiceItem) []models.InvoiceItem {
+	if !invoice.IsStorno() {
+		return items
+	}
+	credited := slices.Clone(items)
+	for i := range credited {
+		credited[i].Quantity = -credited[i].Quantity
+		credited[i].Amount = -credited[i].Amount
+	}
+	return credited
+}
+
+// accountingPaid reports whether an invoice was paid, so its payment is
+// pushed. A cancellation settles the invoice it cancels without a payment.
+func accountingPaid(invoice *models.Invoice) bool {
+	return invoice.Status == models.InvoiceStatusPaid && !invoice.IsSettledByCancellation() && invoice.AmountDue() > 0
+}
+
+// accountingPaymentDate returns the date the payment of a paid invoice is
+// booked on. Invoices marked paid without a payment date use their issue date.
+func accountingPaymentDate(invoice *models.Invoice) time.Time {
+	if invoice.PaidDate.IsZero() {
+		return invoice.IssueDate
+	}
+	return invoice.PaidDate
+}
+
+// syncInvoice pushes an invoice or credit note with its client and, once
+// paid, its payment
+func (s *AccountingSyncService) syncInvoice(provider string, client accountingClient, id int, contacts map[int]accountingContactResult, result *AccountingSyncResult) error {
+	invoice, items, err := s.dbService.GetInvoice(id)
+	if err != nil {
+		return err
+	}
+
+	contact, ok := contacts[invoice.ClientID]
+	if !ok {
+		customer, err := s.dbService.GetClient(invoice.ClientID)
+		if err != nil {
+			contact.err = fmt.Errorf("failed to load client: %w", err)
+		} else {
+			var pushed bool
+			contact.record, pushed, contact.err = s.push(provider, client, accountingContact, customer.ID, customer.Name, client.contactBody(customer))
+			if contact.err != nil {
+				contact.err = fmt.Errorf("client %s: %w", customer.Name, contact.err)
+			}
+			if pushed {
+				result.Contacts++
+			}
+		}
+		contacts[invoice.ClientID] = contact
+	}
+	if contact.err != nil {
+		return s.saveFailure(provider, accountingEntity(invoice), id, contact.err)
+	}
+
+	remote, pushed, err := s.push(provider, client, accountingEntity(invoice), id, invoice.InvoiceNumber, client.invoiceBody(invoice, items, contact.record))
+	if err != nil {
+		return err
+	}
+	if pushed {
+		result.Invoices++
+	}
+
+	if !accountingPaid(invoice) {
+		return nil
+	}
+	_, pushed, err = s.push(provider, client, accountingPayment, id, "", client.paymentBody(invoice, contact.record, remote))
+	if pushed {
+		result.Payments++
+	}
+	return err
+}
+
+// accountingMapping is a record pushed to the accounting software, as stored
+// in accounting_sync
+type accountingMapping struct {
+	RemoteID      string
+	RemoteVersion string
+	Fingerprint   string // Hash of the body last pushed
+	Status        string
+	LastError     string
+	SyncedAt      time.Time
+}
+
+// mapping returns how a record was pushed, the zero mapping if it never was
+func (s *AccountingSyncService) mapping(provider, entityType string, entityID int) (accountingMapping, error) {
+	var mapping accountingMapping
+	err := s.dbService.GetDB().QueryRow(`
+		SELECT remote_id, remote_version, fingerprint, status, last_error, synced_at
+		FROM accounting_sync WHERE provider = ? AND entity_type = ? AND entity_id = ?
+	`, provider, entityType, entityID).Scan(&mapping.RemoteID, &mapping.RemoteVersion, &mapping.Fingerprint,
+		&mapping.Status, &mapping.LastError, &mapping.SyncedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return accountingMapping{}, nil
+	}
+	if err != nil {
+		return mapping, fmt.Errorf("failed to load synced %s: %w", entityType, err)
+	}
+	return mapping, nil
+}
+
+// accountingFingerprint hashes the body of a record. JSON objects are encoded
+// with sorted keys, so equal bodies have equal fingerprints.
+func accountingFingerprint(body map[string]interface{}) string {
+	data, _ := json.Marshal(body)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// push creates or updates a record unless the same body was pushed before,
+// and returns its remote ID and whether it was pushed. A record that is not
+// mapped yet is looked up by key first, so it is not created twice.
+func (s *AccountingSyncService) push(provider string, client accountingClient, entityType string, entityID int, key string, body map[string]interface{}) (accountingRecord, bool, error) {
+	fingerprint := accountingFingerprint(body)
+	mapping, err := s.mapping(provider, entityType, entityID)
+	if err != nil {
+		return accountingRecord{}, false, err
+	}
+	remote := accountingRecord{ID: mapping.RemoteID, Version: mapping.RemoteVersion}
+
+	// Payments are pushed once, booked payments cannot be changed
+	if mapping.Status == models.AccountingSyncSynced && (mapping.Fingerprint == fingerprint || entityType == accountingPayment) {
+		return remote, false, nil
+	}
+	if remote.ID == "" && key != "" {
+		if remote, err = client.find(entityType, key); err != nil {
+			return remote, false, s.saveFailure(provider, entityType, entityID, err)
+		}
+	}
+	if remote, err = client.push(entityType, body, remote); err != nil {
+		return remote, false, s.saveFailure(provider, entityType, entityID, err)
+	}
+
+	_, err = s.dbService.GetDB().Exec(`
+		INSERT INTO accounting_sync (provider, entity_type, entity_id, remote_id, remote_version, fingerprint, status, last_error, synced_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, '', ?)
+		ON CONFLICT (provider, entity_type, entity_id) DO UPDATE SET remote_id = excluded.remote_id, remote_version = excluded.remote_version,
+			fingerprint = excluded.fingerprint, status = excluded.status, last_error = '', synced_at = excluded.synced_at
+	`, provider, entityType, entityID, remote.ID, remote.Version, fingerprint, models.AccountingSyncSynced, time.Now().UTC())
+	if err != nil {
+		return remote, true, fmt.Errorf("failed to save synced %s: %w", entityType, err)
+	}
+	return remote, true, nil
+}
+
+// saveFailure records that a record could not be pushed and returns err. The
+// remote ID is kept, so the next attempt updates the record.
+func (s *AccountingSyncService) saveFailure(provider, entityType string, entityID int, err error) error {
+	_, dbErr := s.dbService.GetDB().Exec(`
+		INSERT INTO accounting_sync (provider, entity_type, entity_id, status, last_error, synced_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (provider, entity_type, entity_id) DO UPDATE SET status = excluded.status, last_error = excluded.last_error
+	`, provider, entityType, entityID, models.AccountingSyncFailed, err.Error(), time.Time{})
+	if dbErr != nil {
+		s.logger.Error("Failed to record sync failure of %s %d: %v", entityType, entityID, dbErr)
+	}
+	return err
+}
+
+// InvoiceStatus tells whether an invoice and its payment are in the connected
+// accounting software, or returns nil when none is connected
+func (s *AccountingSyncService) InvoiceStatus(invoice *models.Invoice, items []models.InvoiceItem) (*models.AccountingSyncStatus, error) {
+	connection, err := s.Connection()
+	if err != nil || connection == nil {
+		return nil, err
+	}
+	status := &models.AccountingSyncStatus{Provider: connection.Provider, Status: models.AccountingSyncSkipped}
+	if !accountingSyncable(invoice) {
+		return status, nil
+	}
+
+	mapping, err := s.mapping(connection.Provider, accountingEntity(invoice), invoice.ID)
+	if err != nil {
+		return nil, err
+	}
+	status.Status = cmp.Or(mapping.Status, models.AccountingSyncPending)
+	status.RemoteID, status.LastError, status.SyncedAt = mapping.RemoteID, mapping.LastError, mapping.SyncedAt
+
+	// An invoice changed since it was pushed is pushed again on the next sync
+	if status.Status == models.AccountingSyncSynced {
+		contact, err := s.mapping(connection.Provider, accountingContact, invoice.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		client := accountingProviders[connection.Provider].newClient(nil, s.settingsService)
+		body := client.invoiceBody(invoice, items, accountingRecord{ID: contact.RemoteID, Version: contact.RemoteVersion})
+		if accountingFingerprint(body) != mapping.Fingerprint {
+			status.Status = models.AccountingSyncPending
+		}
+	}
+
+	if accountingPaid(invoice) {
+		payment, err := s.mapping(connection.Provider, accountingPayment, invoice.ID)
+		if err != nil {
+			return nil, err
+		}
+		status.PaymentRemoteID = payment.RemoteID
+		switch {
+		case payment.Status == models.AccountingSyncFailed:
+			status.Status, status.LastError = models.AccountingSyncFailed, payment.LastError
+		case payment.Status != models.AccountingSyncSynced && status.Status == models.AccountingSyncSynced:
+			status.Status = models.AccountingSyncPending
+		}
+	}
+	return status, nil
+}
+
+// accountingAPI calls the API of a connected organization
+type accountingAPI struct {
+	client   *http.Client
+	provider accountingProvider
+	token    string
+	tenantID string
+}
+
+// api returns a client of the provider's API authenticated with an access token
+func (s *AccountingSyncService) api(provider accountingProvider, token, tenantID string) *accountingAPI {
+	return &accountingAPI{client: s.client, provider: provider, token: token, tenantID: tenantID}
+}
+
+// call sends a JSON request to the API and decodes the JSON response into out
+func (a *accountingAPI) call(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, a.provider.apiURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if a.provider.key == AccountingProviderXero && a.tenantID != "" {
+		req.Header.Set("Xero-tenant-id", a.tenantID)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s could not be reached: %w", a.provider.name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return accountingResponseError(a.provider.name, resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to parse %s response: %w", a.provider.name, err)
+	}
+	return nil
+}
+
+// accountingErrorResponse holds the error fields of Xero, QuickBooks and
+// OAuth error responses
+type accountingErrorResponse struct {
+	// Xero
+	Message  string `json:"Message"`
+	Detail   string `json:"Detail"`
+	Elements []struct {
+		ValidationErrors []struct {
+			Message string `json:"Message"`
+		} `json:"ValidationErrors"`
+	} `json:"Elements"`
+
+	// QuickBooks
+	Fault struct {
+		Error []struct {
+			Message string `json:"Message"`
+			Detail  string `json:"Detail"`
+		} `json:"Error"`
+	} `json:"Fault"`
+
+	// OAuth
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// accountingResponseError returns the error of a failed API or token request
+func accountingResponseError(name string, resp *http.Response) error {
+	var apiErr accountingErrorResponse
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr)
+
+	var messages []string
+	for _, element := range apiErr.Elements {
+		for _, validation := range element.ValidationErrors {
+			messages = append(messages, validation.Message)
+		}
+	}
+	for _, fault := range apiErr.Fault.Error {
+		messages = append(messages, cmp.Or(fault.Detail, fault.Message))
+	}
+	message := strings.Join(messages, "; ")
+	if message == "" {
+		message = cmp.Or(apiErr.ErrorDescription, apiErr.Error, apiErr.Detail, apiErr.Message)
+	}
+	if message != "" {
+		return fmt.Errorf("%s: %s (%s)", name, message, resp.Status)
+	}
+	return fmt.Errorf("%s: %s", name, resp.Status)
+}
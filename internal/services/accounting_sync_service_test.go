@@ -0,0 +1,200 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestAccountingSyncQuickBooks(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var grants []string
+	var posted []map[string]interface{}
+	failInvoices := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth2/v1/tokens/bearer" {
+			if id, secret, _ := r.BasicAuth(); id != "client" || secret != "secret" {
+				http.Error(w, `{"error": "invalid_client"}`, http.StatusUnauthorized)
+				return
+			}
+			r.ParseForm()
+			grants = append(grants, r.Form.Get("grant_type"))
+			fmt.Fprintf(w, `{"access_token": "access-%d", "refresh_token": "refresh", "expires_in": 3600}`, len(grants))
+			return
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer access-") || r.URL.Query().Get("minorversion") == "" {
+			http.Error(w, `{"Fault": {"Error": [{"Message": "AuthenticationFailed"}]}}`, http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v3/company/realm-1/companyinfo/realm-1":
+			fmt.Fprint(w, `{"CompanyInfo": {"CompanyName": "Test Company"}}`)
+		case "/v3/company/realm-1/query":
+			// An invoice pushed before it was mapped is found by its number
+			if strings.Contains(r.URL.Query().Get("query"), "DocNumber = 'INV-2024-0002'") {
+				fmt.Fprint(w, `{"QueryResponse": {"Invoice": [{"Id": "77", "SyncToken": "3"}]}}`)
+				return
+			}
+			fmt.Fprint(w, `{"QueryResponse": {}}`)
+		case "/v3/company/realm-1/customer", "/v3/company/realm-1/invoice", "/v3/company/realm-1/creditmemo", "/v3/company/realm-1/payment":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			posted = append(posted, body)
+			entity := map[string]string{"customer": "Customer", "invoice": "Invoice", "creditmemo": "CreditMemo", "payment": "Payment"}[r.URL.Path[len("/v3/company/realm-1/"):]]
+			if entity == "Invoice" && failInvoices {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"Fault": {"Error": [{"Message": "Stale object", "Detail": "Stale Object Error"}]}}`)
+				return
+			}
+			id, _ := body["Id"].(string)
+			if id == "" {
+				id = fmt.Sprint(len(posted))
+			}
+			fmt.Fprintf(w, `{%q: {"Id": %q, "SyncToken": "1"}}`, entity, id)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	logger := NewLogger(ERROR)
+	settings := NewSettingsService(dbService, logger)
+	accounting := NewAccountingSyncService(dbService, settings, logger)
+	if _, err := accounting.Sync(); !errors.Is(err, ErrAccountingNotConfigured) {
+		t.Fatalf("Expected ErrAccountingNotConfigured, got %v", err)
+	}
+	for key, value := range map[string]string{SettingAccountingProvider: AccountingProviderQuickBooks, SettingAccountingClientID: "client",
+		SettingAccountingClientSecret: "secret", SettingAccountingAPIURL: server.URL, SettingAccountingTokenURL: server.URL + "/oauth2/v1/tokens/bearer"} {
+		if err := settings.Set(key, value); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+	if _, err := accounting.Sync(); !errors.Is(err, ErrAccountingNotConnected) {
+		t.Fatalf("Expected ErrAccountingNotConnected, got %v", err)
+	}
+
+	link, err := accounting.AuthorizeURL("https://invoices.example.com/accounting/callback", "state")
+	if err != nil || !strings.HasPrefix(link, "https://appcenter.intuit.com/connect/oauth2?") || !strings.Contains(link, "scope=com.intuit.quickbooks.accounting") {
+		t.Errorf("Unexpected authorize URL %s (%v)", link, err)
+	}
+	connection, err := accounting.Connect("code", "realm-1", "https://invoices.example.com/accounting/callback")
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if connection.TenantID != "realm-1" || connection.TenantName != "Test Company" {
+		t.Errorf("Unexpected connection %+v", connection)
+	}
+
+	client := &models.Client{Name: "Acme GmbH", Email: "billing@acme.example", Country: "DE"}
+	if err := dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+	issued := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoices := []*models.Invoice{
+		{InvoiceNumber: "INV-2024-0001", Status: "paid", PaidDate: issued.AddDate(0, 0, 10)},
+		{InvoiceNumber: "INV-2024-0002", Status: "sent"},
+		{InvoiceNumber: "INV-2024-0003", Status: "draft"},
+	}
+	for _, invoice := range invoices {
+		invoice.ClientID, invoice.IssueDate, invoice.DueDate, invoice.Currency, invoice.VatRate = client.ID, issued, issued.AddDate(0, 0, 30), "EUR", 19
+		items := []models.InvoiceItem{{Description: "Consulting", Quantity: 10, Unit: models.UnitHours, UnitPrice: models.NewMoney(80)}}
+		invoice.ApplyTotals(items)
+		if err := dbService.SaveInvoice(invoice, items); err != nil {
+			t.Fatalf("Failed to save invoice: %v", err)
+		}
+	}
+
+	// The draft is skipped; the unmapped invoice found by number is updated
+	result, err := accounting.Sync()
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if result.Contacts != 1 || result.Invoices != 2 || result.Payments != 1 || result.Failed != 0 {
+		t.Errorf("Unexpected first sync %+v", result)
+	}
+	if len(posted) != 4 || posted[2]["TotalAmt"] != 952.0 || posted[3]["Id"] != "77" || posted[3]["SyncToken"] != "3" {
+		t.Errorf("Unexpected records pushed %+v", posted)
+	}
+	if result, err := accounting.Sync(); err != nil || result.Invoices != 0 || result.Payments != 0 {
+		t.Errorf("Expected the second sync to push nothing, got %+v (%v)", result, err)
+	}
+
+	status, err := accounting.InvoiceStatus(invoices[0], nil)
+	if err != nil || status.Status != models.AccountingSyncPending || status.PaymentRemoteID == "" {
+		t.Errorf("Expected an invoice without its items to differ from the pushed one, got %+v (%v)", status, err)
+	}
+	if status, _ := accounting.InvoiceStatus(invoices[2], nil); status.Status != models.AccountingSyncSkipped {
+		t.Errorf("Expected drafts to be skipped, got %+v", status)
+	}
+
+	// A changed invoice is pushed again; the failure is recorded and the access token renewed
+	if _, err := dbService.GetDB().Exec(`UPDATE accounting_connections SET expires_at = ?`, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("Failed to expire the access token: %v", err)
+	}
+	invoices[1].DueDate = invoices[1].DueDate.AddDate(0, 0, 14)
+	items := []models.InvoiceItem{{Description: "Consulting", Quantity: 10, Unit: models.UnitHours, UnitPrice: models.NewMoney(80)}}
+	invoices[1].ApplyTotals(items)
+	if err := dbService.SaveInvoice(invoices[1], items); err != nil {
+		t.Fatalf("Failed to update invoice: %v", err)
+	}
+	failInvoices = true
+	if result, err := accounting.Sync(); err != nil || result.Failed != 1 {
+		t.Errorf("Expected the changed invoice to fail, got %+v (%v)", result, err)
+	}
+	invoice, items, _ := dbService.GetInvoice(invoices[1].ID)
+	status, _ = accounting.InvoiceStatus(invoice, items)
+	if status.Status != models.AccountingSyncFailed || status.LastError != "QuickBooks: Stale Object Error (400 Bad Request)" || status.RemoteID != "77" {
+		t.Errorf("Unexpected status of the failed invoice %+v", status)
+	}
+	if len(grants) != 2 || grants[1] != "refresh_token" {
+		t.Errorf("Expected the access token to be renewed, got grants %v", grants)
+	}
+
+	failInvoices = false
+	if result, err := accounting.Sync(); err != nil || result.Invoices != 1 || result.Failed != 0 {
+		t.Errorf("Expected the changed invoice to be pushed, got %+v (%v)", result, err)
+	}
+	if status, _ := accounting.InvoiceStatus(invoice, items); status.Status != models.AccountingSyncSynced {
+		t.Errorf("Expected the invoice to be synced, got %+v", status)
+	}
+
+	// A cancellation is pushed as a credit memo, and settles the invoice
+	// without a payment
+	storno, err := dbService.CancelInvoice(invoice.ID, issued.AddDate(0, 0, 20), nil)
+	if err != nil {
+		t.Fatalf("CancelInvoice failed: %v", err)
+	}
+	pushed := len(posted)
+	if result, err := accounting.Sync(); err != nil || result.Invoices != 1 || result.Payments != 0 || result.Failed != 0 {
+		t.Errorf("Expected only the credit memo to be pushed, got %+v (%v)", result, err)
+	}
+	if len(posted) != pushed+1 {
+		t.Fatalf("Expected one record pushed, got %+v", posted[pushed:])
+	}
+	creditMemo := posted[pushed]
+	lines, _ := creditMemo["Line"].([]interface{})
+	if _, ok := creditMemo["DueDate"]; ok || creditMemo["DocNumber"] != storno.InvoiceNumber || len(lines) != 1 || lines[0].(map[string]interface{})["Amount"] != 800.0 {
+		t.Errorf("Unexpected credit memo %+v", creditMemo)
+	}
+	storno, stornoItems, _ := dbService.GetInvoice(storno.ID)
+	if status, _ := accounting.InvoiceStatus(storno, stornoItems); status.Status != models.AccountingSyncSynced || status.PaymentRemoteID != "" {
+		t.Errorf("Expected the credit memo to be synced without a payment, got %+v", status)
+	}
+
+	if err := accounting.Disconnect(); err != nil {
+		t.Fatalf("Disconnect failed: %v", err)
+	}
+	if connection, err := accounting.Connection(); connection != nil || err != nil {
+		t.Errorf("Expected no connection after disconnecting, got %+v (%v)", connection, err)
+	}
+}
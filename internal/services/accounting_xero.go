@@ -0,0 +1,214 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// xeroEndpoints maps the kinds of records to their Accounting API collection
+// and ID field
+var xeroEndpoints = map[string]struct{ collection, idField string }{
+	accountingContact:    {"Contacts", "ContactID"},
+	accountingInvoice:    {"Invoices", "InvoiceID"},
+	accountingCreditNote: {"CreditNotes", "CreditNoteID"},
+	accountingPayment:    {"Payments", "PaymentID"},
+}
+
+// xeroClient pushes contacts, invoices, credit notes and payments to a Xero
+// organization.
+// Invoice lines are booked to the sales account and taxed at its default tax
+// rate, as Xero tax rates cannot be matched to VAT rates reliably.
+type xeroClient struct {
+	api      *accountingAPI
+	settings *SettingsService
+}
+
+func newXeroClient(api *accountingAPI, settings *SettingsService) accountingClient {
+	return &xeroClient{api: api, settings: settings}
+}
+
+// xeroTenant returns the organization access was granted to
+func xeroTenant(api *accountingAPI, _ string) (string, string, error) {
+	var connections []struct {
+		TenantID   string `json:"tenantId"`
+		TenantName string `json:"tenantName"`
+		TenantType string `json:"tenantType"`
+	}
+	if err := api.call(http.MethodGet, "/connections", nil, &connections); err != nil {
+		return "", "", err
+	}
+	for _, connection := range connections {
+		if connection.TenantType == "ORGANISATION" {
+			return connection.TenantID, connection.TenantName, nil
+		}
+	}
+	return "", "", errors.New("Xero: no organization was connected")
+}
+
+func (c *xeroClient) contactBody(client *models.Client) map[string]interface{} {
+	return map[string]interface{}{
+		"Name":         client.Name,
+		"EmailAddress": client.Email,
+		"TaxNumber":    client.VatID,
+		"Addresses": []map[string]string{{
+			"AddressType":  "POBOX",
+			"AddressLine1": client.Address,
+			"City":         client.City,
+			"PostalCode":   client.PostalCode,
+			"Country":      client.Country,
+		}},
+	}
+}
+
+func (c *xeroClient) invoiceBody(invoice *models.Invoice, items []models.InvoiceItem, contact accountingRecord) map[string]interface{} {
+	account := c.settings.GetString(SettingXeroSalesAccount)
+	lines := []map[string]interface{}{}
+	items = accountingItems(invoice, items)
+	for _, item := range items {
+		line := map[string]interface{}{
+			"Description": item.Description,
+			"Quantity":    item.Quantity,
+			"UnitAmount":  item.UnitPrice,
+			"AccountCode": account,
+		}
+		if item.HasDiscount() {
+			line["DiscountAmount"] = item.GrossAmount().Round(invoice.Currency) - item.Amount
+		}
+		// Items outside the VAT base are not taxed at the account's rate
+		if item.VatExempt {
+			line["TaxType"] = "NONE"
+		}
+		lines = append(lines, line)
+	}
+	if totals := invoice.CalculateTotals(items); totals.Discount > 0 {
+		lines = append(lines, map[string]interface{}{"Description": "Discount", "Quantity": 1, "UnitAmount": -totals.Discount, "AccountCode": account})
+	}
+
+	// Lines are net amounts; without VAT the account's tax rate must not be added
+	amountTypes := "Exclusive"
+	if invoice.ReverseChargeVat || invoice.VatRate == 0 {
+		amountTypes = "NoTax"
+	}
+	body := map[string]interface{}{
+		"Type":            "ACCREC",
+		"Contact":         map[string]string{"ContactID": contact.ID},
+		"InvoiceNumber":   invoice.InvoiceNumber,
+		"Reference":       invoice.PONumber,
+		"Date":            invoice.IssueDate.Format("2006-01-02"),
+		"DueDate":         invoice.DueDate.Format("2006-01-02"),
+		"CurrencyCode":    invoice.Currency,
+		"Status":          "AUTHORISED",
+		"LineAmountTypes": amountTypes,
+		"LineItems":       lines,
+	}
+	// Cancellation invoices are credit notes, which have no due date
+	if invoice.IsStorno() {
+		body["Type"] = "ACCRECCREDIT"
+		body["CreditNoteNumber"] = body["InvoiceNumber"]
+		delete(body, "InvoiceNumber")
+		delete(body, "DueDate")
+	}
+	return body
+}
+
+func (c *xeroClient) paymentBody(invoice *models.Invoice, _, remoteInvoice accountingRecord) map[string]interface{} {
+	return map[string]interface{}{
+		"Invoice":   map[string]string{"InvoiceID": remoteInvoice.ID},
+		"Account":   map[string]string{"Code": c.settings.GetString(SettingXeroPaymentAccount)},
+		"Date":      accountingPaymentDate(invoice).Format("2006-01-02"),
+		"Amount":    invoice.AmountDue(),
+		"Reference": invoice.InvoiceNumber,
+	}
+}
+
+func (c *xeroClient) find(entityType, key string) (accountingRecord, error) {
+	switch entityType {
+	case accountingContact:
+		// Contact names are unique in Xero, the search also matches parts of names
+		var response struct {
+			Contacts []struct {
+				ContactID string `json:"ContactID"`
+				Name      string `json:"Name"`
+			} `json:"Contacts"`
+		}
+		if err := c.api.call(http.MethodGet, "/api.xro/2.0/Contacts?searchTerm="+url.QueryEscape(key), nil, &response); err != nil {
+			return accountingRecord{}, err
+		}
+		for _, contact := range response.Contacts {
+			if strings.EqualFold(contact.Name, key) {
+				return accountingRecord{ID: contact.ContactID}, nil
+			}
+		}
+
+	case accountingInvoice:
+		var response struct {
+			Invoices []struct {
+				InvoiceID string `json:"InvoiceID"`
+				Status    string `json:"Status"`
+			} `json:"Invoices"`
+		}
+		if err := c.api.call(http.MethodGet, "/api.xro/2.0/Invoices?InvoiceNumbers="+url.QueryEscape(key), nil, &response); err != nil {
+			return accountingRecord{}, err
+		}
+		for _, invoice := range response.Invoices {
+			if invoice.Status != "DELETED" && invoice.Status != "VOIDED" {
+				return accountingRecord{ID: invoice.InvoiceID}, nil
+			}
+		}
+
+	case accountingCreditNote:
+		var response struct {
+			CreditNotes []struct {
+				CreditNoteID string `json:"CreditNoteID"`
+				Status       string `json:"Status"`
+			} `json:"CreditNotes"`
+		}
+		where := fmt.Sprintf("CreditNoteNumber==%q", key)
+		if err := c.api.call(http.MethodGet, "/api.xro/2.0/CreditNotes?where="+url.QueryEscape(where), nil, &response); err != nil {
+			return accountingRecord{}, err
+		}
+		for _, creditNote := range response.CreditNotes {
+			if creditNote.Status != "DELETED" && creditNote.Status != "VOIDED" {
+				return accountingRecord{ID: creditNote.CreditNoteID}, nil
+			}
+		}
+	}
+	return accountingRecord{}, nil
+}
+
+func (c *xeroClient) push(entityType string, body map[string]interface{}, remote accountingRecord) (accountingRecord, error) {
+	endpoint := xeroEndpoints[entityType]
+	record := maps.Clone(body)
+	if remote.ID != "" {
+		record[endpoint.idField] = remote.ID
+	}
+
+	// POST creates or updates contacts, invoices and credit notes; payments
+	// are created with PUT
+	method := http.MethodPost
+	if entityType == accountingPayment {
+		method = http.MethodPut
+	}
+	var response map[string]json.RawMessage
+	request := map[string]interface{}{endpoint.collection: []map[string]interface{}{record}}
+	if err := c.api.call(method, "/api.xro/2.0/"+endpoint.collection, request, &response); err != nil {
+		return remote, err
+	}
+
+	var saved []map[string]interface{}
+	if err := json.Unmarshal(response[endpoint.collection], &saved); err != nil || len(saved) == 0 {
+		return remote, fmt.Errorf("Xero returned no %s", strings.ToLower(endpoint.collection))
+	}
+	id, _ := saved[0][endpoint.idField].(string)
+	if id == "" {
+		return remote, fmt.Errorf("Xero returned no %s", endpoint.idField)
+	}
+	return accountingRecord{ID: id}, nil
+}
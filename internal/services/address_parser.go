@@ -0,0 +1,269 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/0dragosh/simple-invoice/internal/refdata"
+)
+
+// minAddressConfidence is the confidence below which a parsed field is left
+// blank, so a wrong guess never ends up on an invoice
+const minAddressConfidence = 0.5
+
+// parsedAddress is a postal address split into the address fields of a
+// client. The confidences say how sure the parser is of each field, from 0
+// for a field left blank to 1 for one that matched the country's format.
+type parsedAddress struct {
+	Address    string
+	City       string
+	PostalCode string
+
+	AddressConfidence    float64
+	CityConfidence       float64
+	PostalCodeConfidence float64
+}
+
+// postalFormat is how a country writes its postal codes
+type postalFormat struct {
+	pattern *regexp.Regexp
+	// cityFirst is set for countries that write the postal code after the
+	// city, e.g. London SW1A 1AA instead of 10115 Berlin
+	cityFirst bool
+}
+
+// Postal code shapes shared by several countries
+var (
+	fourDigits     = regexp.MustCompile(`\b(?:[A-Z]{1,2}-)?\d{4}\b`)
+	fiveDigits     = regexp.MustCompile(`\b(?:[A-Z]{1,2}-)?\d{5}\b`)
+	threeTwoDigits = regexp.MustCompile(`\b(?:[A-Z]{1,2}-)?\d{3} ?\d{2}\b`)
+	ukPostcode     = regexp.MustCompile(`\b[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}\b`)
+)
+
+// postalFormats are the postal code formats of the EU member states and the
+// UK, by ISO country code. A leading country prefix such as D- or L-, still
+// printed on some registered addresses, is accepted and dropped.
+var postalFormats = map[string]postalFormat{
+	"AT": {pattern: fourDigits},
+	"BE": {pattern: fourDigits},
+	"BG": {pattern: fourDigits},
+	"CY": {pattern: fourDigits},
+	"CZ": {pattern: threeTwoDigits},
+	"DE": {pattern: fiveDigits},
+	"DK": {pattern: fourDigits},
+	"EE": {pattern: fiveDigits},
+	"ES": {pattern: fiveDigits},
+	"FI": {pattern: fiveDigits},
+	"FR": {pattern: fiveDigits},
+	"GR": {pattern: threeTwoDigits},
+	"HR": {pattern: fiveDigits},
+	"HU": {pattern: fourDigits},
+	"IE": {pattern: regexp.MustCompile(`\b(?:[AC-FHKNPRTV-Y]\d{2}|D6W) ?[0-9AC-FHKNPRTV-Y]{4}\b`), cityFirst: true}, // Eircode
+	"IT": {pattern: fiveDigits},
+	"LT": {pattern: fiveDigits},
+	"LU": {pattern: fourDigits},
+	"LV": {pattern: regexp.MustCompile(`\b(?:LV-? ?)?\d{4}\b`), cityFirst: true},
+	"MT": {pattern: regexp.MustCompile(`\b[A-Z]{3} ?\d{2,4}\b`), cityFirst: true},
+	"NL": {pattern: regexp.MustCompile(`\b\d{4} ?[A-Z]{2}\b`)},
+	"PL": {pattern: regexp.MustCompile(`\b\d{2}-\d{3}\b`)},
+	"PT": {pattern: regexp.MustCompile(`\b\d{4}-\d{3}\b`)},
+	"RO": {pattern: regexp.MustCompile(`\b\d{6}\b`)},
+	"SE": {pattern: threeTwoDigits},
+	"SI": {pattern: fourDigits},
+	"SK": {pattern: threeTwoDigits},
+	"GB": {pattern: ukPostcode, cityFirst: true},
+}
+
+// genericPostalFormat is used for other countries; codes found with it get a
+// lower confidence
+var genericPostalFormat = postalFormat{pattern: regexp.MustCompile(`\b\d{4,6}\b`)}
+
+// postalCodePrefix matches a country prefix in front of a postal code
+var postalCodePrefix = regexp.MustCompile(`^[A-Z]{1,2}-`)
+
+// addressCountryNames are country names that end registered addresses and
+// are dropped, in English and the local languages
+var addressCountryNames = map[string]bool{
+	"AUSTRIA": true, "ÖSTERREICH": true, "BELGIUM": true, "BELGIQUE": true, "BELGIË": true, "BELGIE": true,
+	"BULGARIA": true, "БЪЛГАРИЯ": true, "CYPRUS": true, "ΚΥΠΡΟΣ": true, "CZECH REPUBLIC": true, "CZECHIA": true,
+	"ČESKÁ REPUBLIKA": true, "GERMANY": true, "DEUTSCHLAND": true, "DENMARK": true, "DANMARK": true,
+	"ESTONIA": true, "EESTI": true, "SPAIN": true, "ESPAÑA": true, "FINLAND": true, "SUOMI": true,
+	"FRANCE": true, "GREECE": true, "ΕΛΛΑΔΑ": true, "ΕΛΛΆΔΑ": true, "CROATIA": true, "HRVATSKA": true,
+	"HUNGARY": true, "MAGYARORSZÁG": true, "IRELAND": true, "ÉIRE": true, "ITALY": true, "ITALIA": true,
+	"LITHUANIA": true, "LIETUVA": true, "LUXEMBOURG": true, "LATVIA": true, "LATVIJA": true, "MALTA": true,
+	"NETHERLANDS": true, "THE NETHERLANDS": true, "NEDERLAND": true, "POLAND": true, "POLSKA": true,
+	"PORTUGAL": true, "ROMANIA": true, "ROMÂNIA": true, "SWEDEN": true, "SVERIGE": true, "SLOVENIA": true,
+	"SLOVENIJA": true, "SLOVAKIA": true, "SLOVENSKO": true, "UNITED KINGDOM": true, "UK": true,
+	"GREAT BRITAIN": true, "ENGLAND": true, "SCOTLAND": true, "WALES": true, "NORTHERN IRELAND": true,
+}
+
+// parseAddress splits a registered address, as returned by VIES or Companies
+// House, into the street address, city and postal code. The address is read
+// line by line, or by commas when it is a single line; the postal code is the
+// last one in the country's format and the city is taken from its side of
+// the postal code line. Without a postal code the whole address is kept as
+// the street address and the city is left blank rather than guessed.
+func parseAddress(rawAddress, countryCode string) parsedAddress {
+	countryCode = refdata.NormalizeCountryCode(countryCode)
+	format, known := postalFormats[countryCode]
+	if !known {
+		format = genericPostalFormat
+	}
+
+	lines := addressLines(rawAddress)
+	if len(lines) > 1 && addressCountryNames[strings.ToUpper(lines[len(lines)-1])] {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return parsedAddress{}
+	}
+
+	for i := len(lines) - 1; i >= 0; i-- {
+		matches := format.pattern.FindAllStringIndex(lines[i], -1)
+		if len(matches) == 0 {
+			continue
+		}
+		match := matches[len(matches)-1]
+		line := lines[i]
+		before := trimAddressPart(line[:match[0]])
+		after := trimAddressPart(line[match[1]:])
+
+		result := parsedAddress{
+			PostalCode:           normalizePostalCode(postalCodePrefix.ReplaceAllString(line[match[0]:match[1]], ""), countryCode),
+			PostalCodeConfidence: 1,
+		}
+		if !known {
+			result.PostalCodeConfidence = 0.6
+		}
+
+		street := append([]string{}, lines[:i]...)
+		switch {
+		case !format.cityFirst && after != "":
+			// 10115 Berlin, with the street in front on single-line addresses
+			result.City, result.CityConfidence = after, 0.9
+			if before != "" {
+				street = append(street, before)
+			}
+		case format.cityFirst && before != "" && i > 0:
+			// London SW1A 1AA below the street lines
+			result.City, result.CityConfidence = before, 0.9
+		case format.cityFirst && before == "" && after == "" && i > 1:
+			// SW1A 1AA on a line of its own below the city
+			result.City, result.CityConfidence = lines[i-1], 0.7
+			street = street[:len(street)-1]
+		case !format.cityFirst && before == "" && after == "" && i+1 < len(lines):
+			// 10115 on a line of its own above the city
+			result.City, result.CityConfidence = lines[i+1], 0.7
+		default:
+			// The city cannot be told apart from the street
+			for _, part := range []string{before, after} {
+				if part != "" {
+					street = append(street, part)
+				}
+			}
+		}
+		if strings.IndexFunc(result.City, unicode.IsDigit) >= 0 && result.CityConfidence < 0.9 {
+			// A line with numbers next to the postal code is more likely part of the street
+			result.CityConfidence = 0.3
+		}
+
+		result.Address = strings.Join(street, ", ")
+		result.AddressConfidence = 0.7
+		if strings.IndexFunc(result.Address, unicode.IsDigit) >= 0 {
+			result.AddressConfidence = 0.9
+		}
+		return result.withoutUnsureFields()
+	}
+
+	return parsedAddress{Address: strings.Join(lines, ", "), AddressConfidence: 0.4}
+}
+
+// withoutUnsureFields blanks the city and postal code when the parser is not
+// sure enough of them. The street address is always kept, as it holds
+// whatever the other fields could not be told apart from.
+func (p parsedAddress) withoutUnsureFields() parsedAddress {
+	if p.CityConfidence < minAddressConfidence {
+		if p.City != "" && p.Address != "" {
+			p.Address += ", " + p.City
+		} else if p.City != "" {
+			p.Address = p.City
+		}
+		p.City, p.CityConfidence = "", 0
+	}
+	if p.PostalCodeConfidence < minAddressConfidence {
+		p.PostalCode, p.PostalCodeConfidence = "", 0
+	}
+	return p
+}
+
+// addressLines splits an address into trimmed, non-empty lines, or into its
+// comma-separated parts when it is a single line
+func addressLines(rawAddress string) []string {
+	parts := strings.Split(strings.ReplaceAll(rawAddress, "\r\n", "\n"), "\n")
+	if len(parts) == 1 {
+		parts = strings.Split(rawAddress, ",")
+	}
+	lines := []string{}
+	for _, part := range parts {
+		if part = strings.Join(strings.Fields(part), " "); part != "" {
+			lines = append(lines, part)
+		}
+	}
+	return lines
+}
+
+// trimAddressPart trims the spaces and separators around a part of an address line
+func trimAddressPart(part string) string {
+	return strings.Trim(part, " ,-–")
+}
+
+// normalizePostalCode formats a postal code the way the country writes it,
+// e.g. SW1A 1AA, 123 45, 12-345 or 1012 AB. Codes are measured in runes, so
+// one misread from a register is never split inside a character.
+func normalizePostalCode(postalCode string, countryCode string) string {
+	code := []rune(strings.ToUpper(strings.ReplaceAll(postalCode, " ", "")))
+	countryCode = refdata.NormalizeCountryCode(countryCode)
+
+	// split inserts sep in front of the character at i
+	split := func(i int, sep string) string {
+		return string(code[:i]) + sep + string(code[i:])
+	}
+
+	switch countryCode {
+	case "GB":
+		// Outward code, space, inward code of a digit and two letters
+		if len(code) >= 5 && len(code) <= 7 {
+			return split(len(code)-3, " ")
+		}
+	case "CZ", "GR", "SE", "SK":
+		if len(code) == 5 {
+			return split(3, " ")
+		}
+	case "PL":
+		if len(code) == 5 && !strings.ContainsRune(string(code), '-') {
+			return split(2, "-")
+		}
+	case "NL":
+		if len(code) == 6 {
+			return split(4, " ")
+		}
+	case "IE":
+		// Routing key, space, unique identifier
+		if len(code) == 7 {
+			return split(3, " ")
+		}
+	case "MT":
+		if len(code) > 3 {
+			return split(3, " ")
+		}
+	case "LV":
+		digits := strings.TrimPrefix(strings.TrimPrefix(string(code), "LV"), "-")
+		if utf8.RuneCountInString(digits) == 4 {
+			return "LV-" + digits
+		}
+	}
+
+	return string(code)
+}
@@ -0,0 +1,312 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		name           string
+		rawAddress     string
+		countryCode    string
+		wantAddress    string
+		wantCity       string
+		wantPostalCode string
+	}{
+		{
+			name:           "UK Address",
+			rawAddress:     "123 TEST STREET\nTEST CITY\nLONDON\nSW1A 1AA\nUNITED KINGDOM",
+			countryCode:    "GB",
+			wantAddress:    "123 TEST STREET, TEST CITY",
+			wantCity:       "LONDON",
+			wantPostalCode: "SW1A 1AA",
+		},
+		{
+			name:           "Companies House snippet",
+			rawAddress:     "10 Downing Street, London, SW1A2AA",
+			countryCode:    "GB",
+			wantAddress:    "10 Downing Street",
+			wantCity:       "London",
+			wantPostalCode: "SW1A 2AA",
+		},
+		{
+			name:           "UK city and postcode on one line",
+			rawAddress:     "Unit 5\nBusiness Park\nLEEDS LS1 4AP",
+			countryCode:    "GB",
+			wantAddress:    "Unit 5, Business Park",
+			wantCity:       "LEEDS",
+			wantPostalCode: "LS1 4AP",
+		},
+		{
+			name:           "German Address",
+			rawAddress:     "TESTSTRASSE 123\n10115 BERLIN\nGERMANY",
+			countryCode:    "DE",
+			wantAddress:    "TESTSTRASSE 123",
+			wantCity:       "BERLIN",
+			wantPostalCode: "10115",
+		},
+		{
+			name:           "German single line",
+			rawAddress:     "Hauptstraße 5 10115 Berlin",
+			countryCode:    "DE",
+			wantAddress:    "Hauptstraße 5",
+			wantCity:       "Berlin",
+			wantPostalCode: "10115",
+		},
+		{
+			name:           "French Address",
+			rawAddress:     "123 RUE DE TEST\n75001 PARIS\nFRANCE",
+			countryCode:    "FR",
+			wantAddress:    "123 RUE DE TEST",
+			wantCity:       "PARIS",
+			wantPostalCode: "75001",
+		},
+		{
+			name:           "Austrian four digits after a house number",
+			rawAddress:     "Ringstraße 1234\n1010 Wien",
+			countryCode:    "AT",
+			wantAddress:    "Ringstraße 1234",
+			wantCity:       "Wien",
+			wantPostalCode: "1010",
+		},
+		{
+			name:           "Luxembourg country prefix",
+			rawAddress:     "12, RUE DU FOSSE\nL-1536 LUXEMBOURG",
+			countryCode:    "LU",
+			wantAddress:    "12, RUE DU FOSSE",
+			wantCity:       "LUXEMBOURG",
+			wantPostalCode: "1536",
+		},
+		{
+			name:           "Dutch postal code",
+			rawAddress:     "DAMRAK 00001\n1012LG AMSTERDAM",
+			countryCode:    "NL",
+			wantAddress:    "DAMRAK 00001",
+			wantCity:       "AMSTERDAM",
+			wantPostalCode: "1012 LG",
+		},
+		{
+			name:           "Czech postal code with a district",
+			rawAddress:     "Václavské náměstí 832/19\n110 00 Praha 1",
+			countryCode:    "CZ",
+			wantAddress:    "Václavské náměstí 832/19",
+			wantCity:       "Praha 1",
+			wantPostalCode: "110 00",
+		},
+		{
+			name:           "Swedish postal code",
+			rawAddress:     "BOX 123\n12345 STOCKHOLM",
+			countryCode:    "SE",
+			wantAddress:    "BOX 123",
+			wantCity:       "STOCKHOLM",
+			wantPostalCode: "123 45",
+		},
+		{
+			name:           "Polish postal code",
+			rawAddress:     "UL. MARSZAŁKOWSKA 1\n00-624 WARSZAWA",
+			countryCode:    "PL",
+			wantAddress:    "UL. MARSZAŁKOWSKA 1",
+			wantCity:       "WARSZAWA",
+			wantPostalCode: "00-624",
+		},
+		{
+			name:           "Portuguese postal code",
+			rawAddress:     "RUA AUGUSTA 10\n1100-053 LISBOA",
+			countryCode:    "PT",
+			wantAddress:    "RUA AUGUSTA 10",
+			wantCity:       "LISBOA",
+			wantPostalCode: "1100-053",
+		},
+		{
+			name:           "Greek single line under the VAT prefix EL",
+			rawAddress:     "ΛΕΩΦ ΚΗΦΙΣΙΑΣ 44 15125 - ΜΑΡΟΥΣΙ",
+			countryCode:    "EL",
+			wantAddress:    "ΛΕΩΦ ΚΗΦΙΣΙΑΣ 44",
+			wantCity:       "ΜΑΡΟΥΣΙ",
+			wantPostalCode: "151 25",
+		},
+		{
+			name:           "Irish Eircode",
+			rawAddress:     "1 GRAND CANAL SQUARE\nDUBLIN 2\nD02 P820",
+			countryCode:    "IE",
+			wantAddress:    "1 GRAND CANAL SQUARE, DUBLIN 2",
+			wantCity:       "",
+			wantPostalCode: "D02 P820",
+		},
+		{
+			name:           "Latvian postal code",
+			rawAddress:     "BRĪVĪBAS IELA 1\nRĪGA, LV-1050",
+			countryCode:    "LV",
+			wantAddress:    "BRĪVĪBAS IELA 1",
+			wantCity:       "RĪGA",
+			wantPostalCode: "LV-1050",
+		},
+		{
+			name:           "Maltese postal code",
+			rawAddress:     "TRIQ IR-REPUBBLIKA 12\nVALLETTA VLT1117",
+			countryCode:    "MT",
+			wantAddress:    "TRIQ IR-REPUBBLIKA 12",
+			wantCity:       "VALLETTA",
+			wantPostalCode: "VLT 1117",
+		},
+		{
+			name:           "Romanian address without a postal code",
+			rawAddress:     "MUN. BUCUREŞTI SEC. 1\nSTR. VICTORIEI NR. 5",
+			countryCode:    "RO",
+			wantAddress:    "MUN. BUCUREŞTI SEC. 1, STR. VICTORIEI NR. 5",
+			wantCity:       "",
+			wantPostalCode: "",
+		},
+		{
+			name:           "UK single line leaves the city blank",
+			rawAddress:     "1 HIGH STREET LONDON SW1A 1AA",
+			countryCode:    "GB",
+			wantAddress:    "1 HIGH STREET LONDON",
+			wantCity:       "",
+			wantPostalCode: "SW1A 1AA",
+		},
+		{
+			name:           "Empty address",
+			rawAddress:     " \n ",
+			countryCode:    "DE",
+			wantAddress:    "",
+			wantCity:       "",
+			wantPostalCode: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseAddress(tt.rawAddress, tt.countryCode)
+
+			if got.Address != tt.wantAddress {
+				t.Errorf("parseAddress() Address = %q, want %q", got.Address, tt.wantAddress)
+			}
+			if got.City != tt.wantCity {
+				t.Errorf("parseAddress() City = %q, want %q", got.City, tt.wantCity)
+			}
+			if got.PostalCode != tt.wantPostalCode {
+				t.Errorf("parseAddress() PostalCode = %q, want %q", got.PostalCode, tt.wantPostalCode)
+			}
+			if (got.City == "") != (got.CityConfidence == 0) || (got.PostalCode == "") != (got.PostalCodeConfidence == 0) {
+				t.Errorf("parseAddress() confidences %+v do not match the fields", got)
+			}
+		})
+	}
+}
+
+func TestParseAddressConfidence(t *testing.T) {
+	// A postal code in a country without a known format is less certain
+	known := parseAddress("Main Street 1\n10115 Berlin", "DE")
+	generic := parseAddress("Main Street 1\n10115 Berlin", "CH")
+	if known.PostalCodeConfidence != 1 || generic.PostalCodeConfidence >= known.PostalCodeConfidence {
+		t.Errorf("Expected a known format to be more certain, got %.1f and %.1f", known.PostalCodeConfidence, generic.PostalCodeConfidence)
+	}
+	if generic.PostalCode != "10115" || generic.City != "Berlin" {
+		t.Errorf("Unexpected generic parse %+v", generic)
+	}
+
+	// A city taken from the line above the postal code is less certain than
+	// one on the same line, and one with numbers is left in the address
+	sameLine := parseAddress("10 Downing Street\nLONDON SW1A 2AA", "GB")
+	lineAbove := parseAddress("10 Downing Street\nLONDON\nSW1A 2AA", "GB")
+	if sameLine.CityConfidence <= lineAbove.CityConfidence || lineAbove.City != "LONDON" {
+		t.Errorf("Expected the same line to be more certain, got %.1f and %.1f", sameLine.CityConfidence, lineAbove.CityConfidence)
+	}
+	numbered := parseAddress("Unit 5\nFloor 2\nSW1A 2AA", "GB")
+	if numbered.City != "" || numbered.Address != "Unit 5, Floor 2" {
+		t.Errorf("Expected a line with numbers to stay in the address, got %+v", numbered)
+	}
+
+	// Without a postal code only the address is set
+	none := parseAddress("Somewhere", "DE")
+	if none.Address != "Somewhere" || none.AddressConfidence >= minAddressConfidence {
+		t.Errorf("Unexpected parse without postal code %+v", none)
+	}
+}
+
+func TestNormalizePostalCode(t *testing.T) {
+	tests := []struct {
+		postalCode, countryCode, want string
+	}{
+		{"SW1A1AA", "GB", "SW1A 1AA"},
+		{"sw1a 1aa", "UK", "SW1A 1AA"},
+		{"M11AA", "GB", "M1 1AA"},
+		{"11000", "CZ", "110 00"},
+		{"15125", "EL", "151 25"},
+		{"00624", "PL", "00-624"},
+		{"1012lg", "NL", "1012 LG"},
+		{"D02P820", "IE", "D02 P820"},
+		{"VLT1117", "MT", "VLT 1117"},
+		{"1050", "LV", "LV-1050"},
+		{"LV 1050", "LV", "LV-1050"},
+		{"10115", "DE", "10115"},
+		{"1100-053", "PT", "1100-053"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizePostalCode(tt.postalCode, tt.countryCode); got != tt.want {
+			t.Errorf("normalizePostalCode(%q, %q) = %q, want %q", tt.postalCode, tt.countryCode, got, tt.want)
+		}
+	}
+}
+
+// FuzzParseAddress checks that registered addresses, however malformed, are
+// split without panicking into fields that are valid text and consistent with
+// their confidences
+func FuzzParseAddress(f *testing.F) {
+	f.Add("123 TEST STREET\nTEST CITY\nLONDON\nSW1A 1AA\nUNITED KINGDOM", "GB")
+	f.Add("10 Downing Street, London, SW1A2AA", "GB")
+	f.Add("Hauptstraße 5 10115 Berlin", "DE")
+	f.Add("12, RUE DU FOSSE\nL-1536 LUXEMBOURG", "LU")
+	f.Add("Václavské náměstí 832/19\n110 00 Praha 1", "CZ")
+	f.Add("ΛΕΩΦ ΚΗΦΙΣΙΑΣ 44 15125 - ΜΑΡΟΥΣΙ", "EL")
+	f.Add("BRĪVĪBAS IELA 1\nRĪGA, LV-1050", "LV")
+	f.Add("TRIQ IR-REPUBBLIKA 12\nVALLETTA VLT1117", "MT")
+	f.Add("Main Street 1\n10115 Berlin", "CH")
+	f.Add(" \r\n , ", "")
+
+	f.Fuzz(func(t *testing.T, rawAddress, countryCode string) {
+		got := parseAddress(rawAddress, countryCode)
+
+		if utf8.ValidString(rawAddress) {
+			for _, field := range []string{got.Address, got.City, got.PostalCode} {
+				if !utf8.ValidString(field) {
+					t.Fatalf("parseAddress(%q, %q) returned invalid UTF-8 %q", rawAddress, countryCode, field)
+				}
+			}
+		}
+		if (got.City == "") != (got.CityConfidence == 0) || (got.PostalCode == "") != (got.PostalCodeConfidence == 0) {
+			t.Fatalf("parseAddress(%q, %q) confidences %+v do not match the fields", rawAddress, countryCode, got)
+		}
+		if got.City != "" && got.CityConfidence < minAddressConfidence || got.PostalCode != "" && got.PostalCodeConfidence < minAddressConfidence {
+			t.Fatalf("parseAddress(%q, %q) kept an unsure field in %+v", rawAddress, countryCode, got)
+		}
+		if strings.ContainsAny(got.City+got.PostalCode, "\r\n") {
+			t.Fatalf("parseAddress(%q, %q) kept a line break in %+v", rawAddress, countryCode, got)
+		}
+	})
+}
+
+// FuzzNormalizePostalCode checks that formatting a postal code never panics,
+// keeps it valid text and is stable when applied again
+func FuzzNormalizePostalCode(f *testing.F) {
+	for _, seed := range []struct{ postalCode, countryCode string }{
+		{"SW1A1AA", "GB"}, {"sw1a 1aa", "UK"}, {"11000", "CZ"}, {"00624", "PL"}, {"1012lg", "NL"},
+		{"D02P820", "IE"}, {"VLT1117", "MT"}, {"LV 1050", "LV"}, {"1100-053", "PT"}, {"", "GB"},
+	} {
+		f.Add(seed.postalCode, seed.countryCode)
+	}
+
+	f.Fuzz(func(t *testing.T, postalCode, countryCode string) {
+		got := normalizePostalCode(postalCode, countryCode)
+		if utf8.ValidString(postalCode) && !utf8.ValidString(got) {
+			t.Fatalf("normalizePostalCode(%q, %q) returned invalid UTF-8 %q", postalCode, countryCode, got)
+		}
+		if again := normalizePostalCode(got, countryCode); utf8.ValidString(postalCode) && again != got {
+			t.Fatalf("normalizePostalCode(%q, %q) = %q, but %q when normalized again", postalCode, countryCode, got, again)
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// ErrAddressLookupDisabled is returned when no Nominatim server is configured
+var ErrAddressLookupDisabled = errors.New("address lookup is disabled")
+
+// ErrAddressLookupBusy is returned when a lookup would wait too long for the
+// rate limit of the Nominatim server
+var ErrAddressLookupBusy = errors.New("too many address lookups, try again in a moment")
+
+// MinAddressQueryLength is the shortest search the address lookup accepts
+const MinAddressQueryLength = 3
+
+const (
+	// The public Nominatim server allows one request per second
+	nominatimInterval = time.Second
+	// nominatimMaxWait is how long a lookup waits for its turn before failing
+	nominatimMaxWait = 3 * time.Second
+	// Addresses rarely change, so results are kept for a day
+	addressCacheTTL     = 24 * time.Hour
+	addressCacheSize    = 500
+	maxAddressSuggests  = 5
+	nominatimUserAgent  = "SimpleInvoice (+https://github.com/0dragosh/simple-invoice)"
+	nominatimSearchPath = "/search"
+)
+
+// houseNumberFirst are the countries that write the house number before the
+// street name, e.g. 10 Downing Street instead of Hauptstraße 10
+var houseNumberFirst = []string{"AU", "CA", "FR", "GB", "IE", "LU", "NZ", "US"}
+
+// AddressService suggests client addresses from an OpenStreetMap Nominatim
+// server. Results are cached and requests are spaced to stay within the
+// usage policy of the public server.
+type AddressService struct {
+	settingsService *SettingsService
+	logger          *Logger
+	client          *http.Client
+
+	mu    sync.Mutex
+	next  time.Time // Earliest time of the next request to the server
+	cache map[string]addressCacheEntry
+}
+
+// addressCacheEntry is a cached search result
+type addressCacheEntry struct {
+	suggestions []models.AddressSuggestion
+	expires     time.Time
+}
+
+// NewAddressService creates a new AddressService
+func NewAddressService(settingsService *SettingsService, logger *Logger) *AddressService {
+	return &AddressService{
+		settingsService: settingsService,
+		logger:          logger,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		cache:           make(map[string]addressCacheEntry),
+	}
+}
+
+// nominatimPlace is a search result of the Nominatim API
+type nominatimPlace struct {
+	DisplayName string `json:"display_name"`
+	Address     struct {
+		HouseNumber  string `json:"house_number"`
+		Road         string `json:"road"`
+		Pedestrian   string `json:"pedestrian"`
+		Square       string `json:"square"`
+		City         string `json:"city"`
+		Town         string `json:"town"`
+		Village      string `json:"village"`
+		Municipality string `json:"municipality"`
+		Hamlet       string `json:"hamlet"`
+		Postcode     string `json:"postcode"`
+		CountryCode  string `json:"country_code"`
+	} `json:"address"`
+}
+
+// Search returns up to five addresses matching query, optionally limited to
+// the country with the ISO code country
+func (s *AddressService) Search(ctx context.Context, query, country string) ([]models.AddressSuggestion, error) {
+	server := strings.TrimSuffix(s.settingsService.GetString(SettingNominatimURL), "/")
+	if server == "" {
+		return nil, ErrAddressLookupDisabled
+	}
+	query = strings.Join(strings.Fields(query), " ")
+	country = strings.ToUpper(strings.TrimSpace(country))
+
+	key := country + "|" + strings.ToLower(query)
+	if suggestions, ok := s.cached(key); ok {
+		return suggestions, nil
+	}
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	params := url.Values{
+		"q":              {query},
+		"format":         {"jsonv2"},
+		"addressdetails": {"1"},
+		"limit":          {fmt.Sprint(maxAddressSuggests)},
+	}
+	if country != "" {
+		params.Set("countrycodes", strings.ToLower(country))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server+nominatimSearchPath+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create address lookup request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", nominatimUserAgent)
+
+	s.logger.Debug("Address lookup - Query: %s (country %q)", MaskPII(query), country)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("address lookup failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("address lookup failed: Nominatim returned %s", resp.Status)
+	}
+
+	var places []nominatimPlace
+	if err := json.NewDecoder(resp.Body).Decode(&places); err != nil {
+		return nil, fmt.Errorf("failed to parse address lookup response: %w", err)
+	}
+	suggestions := make([]models.AddressSuggestion, 0, len(places))
+	for _, place := range places {
+		suggestions = append(suggestions, place.suggestion())
+	}
+
+	s.store(key, suggestions)
+	return suggestions, nil
+}
+
+// suggestion splits a place into the address fields of a client, formatting
+// the street and postal code like the VAT lookup does
+func (p nominatimPlace) suggestion() models.AddressSuggestion {
+	a := p.Address
+	country := strings.ToUpper(a.CountryCode)
+
+	street := firstNonEmpty(a.Road, a.Pedestrian, a.Square)
+	if street != "" && a.HouseNumber != "" {
+		if slices.Contains(houseNumberFirst, country) {
+			street = a.HouseNumber + " " + street
+		} else {
+			street = street + " " + a.HouseNumber
+		}
+	}
+
+	return models.AddressSuggestion{
+		Label:      p.DisplayName,
+		Address:    street,
+		City:       firstNonEmpty(a.City, a.Town, a.Village, a.Municipality, a.Hamlet),
+		PostalCode: normalizePostalCode(a.Postcode, country),
+		Country:    country,
+	}
+}
+
+// wait blocks until the next request may be sent to the server, or fails
+// with ErrAddressLookupBusy when that is too far away
+func (s *AddressService) wait(ctx context.Context) error {
+	s.mu.Lock()
+	now := time.Now()
+	at := s.next
+	if at.Before(now) {
+		at = now
+	}
+	if at.Sub(now) > nominatimMaxWait {
+		s.mu.Unlock()
+		return ErrAddressLookupBusy
+	}
+	s.next = at.Add(nominatimInterval)
+	s.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// cached returns the cached suggestions for a search
+func (s *AddressService) cached(key string) ([]models.AddressSuggestion, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.suggestions, true
+}
+
+// store caches the suggestions for a search. When the cache is full, expired
+// entries are dropped, or else the entry that expires first.
+func (s *AddressService) store(key string, suggestions []models.AddressSuggestion) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if len(s.cache) >= addressCacheSize {
+		oldestKey := ""
+		var oldest time.Time
+		for k, entry := range s.cache {
+			if now.After(entry.expires) {
+				delete(s.cache, k)
+			} else if oldestKey == "" || entry.expires.Before(oldest) {
+				oldestKey, oldest = k, entry.expires
+			}
+		}
+		if len(s.cache) >= addressCacheSize {
+			delete(s.cache, oldestKey)
+		}
+	}
+	s.cache[key] = addressCacheEntry{suggestions: suggestions, expires: now.Add(addressCacheTTL)}
+}
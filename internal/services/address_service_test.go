@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const nominatimTestResults = `[
+  {"display_name": "10, Downing Street, Westminster, London, SW1A 2AA, United Kingdom",
+   "address": {"house_number": "10", "road": "Downing Street", "city": "London", "postcode": "SW1A2AA", "country_code": "gb"}},
+  {"display_name": "Unter den Linden 77, 10117 Berlin, Deutschland",
+   "address": {"house_number": "77", "road": "Unter den Linden", "city": "Berlin", "postcode": "10117", "country_code": "de"}},
+  {"display_name": "Prusy, gmina Kondratowice, 57-150, Polska",
+   "address": {"village": "Prusy", "postcode": "57150", "country_code": "pl"}}
+]`
+
+func TestAddressSearch(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	requests := 0
+	var query map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		query = r.URL.Query()
+		if r.URL.Path != "/search" || r.Header.Get("User-Agent") == "" {
+			t.Errorf("Unexpected request %s with User-Agent %q", r.URL.Path, r.Header.Get("User-Agent"))
+		}
+		w.Write([]byte(nominatimTestResults))
+	}))
+	defer server.Close()
+
+	logger := NewLogger(ERROR)
+	settings := NewSettingsService(dbService, logger)
+	if err := settings.Set(SettingNominatimURL, server.URL+"/"); err != nil {
+		t.Fatalf("Failed to set Nominatim URL: %v", err)
+	}
+	addresses := NewAddressService(settings, logger)
+
+	suggestions, err := addresses.Search(context.Background(), "  downing   street ", "gb")
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if got := query["q"]; len(got) != 1 || got[0] != "downing street" {
+		t.Errorf("Expected the query to be trimmed, got %v", got)
+	}
+	if got := query["countrycodes"]; len(got) != 1 || got[0] != "gb" {
+		t.Errorf("Expected the search to be limited to gb, got %v", got)
+	}
+	if len(suggestions) != 3 {
+		t.Fatalf("Expected 3 suggestions, got %d", len(suggestions))
+	}
+
+	tests := []struct{ address, city, postalCode, country string }{
+		{"10 Downing Street", "London", "SW1A 2AA", "GB"},
+		{"Unter den Linden 77", "Berlin", "10117", "DE"},
+		{"", "Prusy", "57-150", "PL"},
+	}
+	for i, tt := range tests {
+		s := suggestions[i]
+		if s.Address != tt.address || s.City != tt.city || s.PostalCode != tt.postalCode || s.Country != tt.country {
+			t.Errorf("Suggestion %d: expected %s, %s %s, %s, got %s, %s %s, %s", i,
+				tt.address, tt.postalCode, tt.city, tt.country, s.Address, s.PostalCode, s.City, s.Country)
+		}
+	}
+	if suggestions[0].Label == "" {
+		t.Error("Expected suggestions to have a label")
+	}
+
+	// The same search, ignoring case and spacing, is answered from the cache
+	if _, err := addresses.Search(context.Background(), "Downing Street", "GB"); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected a cached result, got %d requests", requests)
+	}
+
+	// Lookups that would wait too long for the rate limit fail right away
+	addresses.next = time.Now().Add(time.Minute)
+	if _, err := addresses.Search(context.Background(), "Unter den Linden", ""); !errors.Is(err, ErrAddressLookupBusy) {
+		t.Errorf("Expected ErrAddressLookupBusy, got %v", err)
+	}
+
+	if err := settings.Set(SettingNominatimURL, ""); err != nil {
+		t.Fatalf("Failed to clear Nominatim URL: %v", err)
+	}
+	if _, err := addresses.Search(context.Background(), "Downing Street", "GB"); !errors.Is(err, ErrAddressLookupDisabled) {
+		t.Errorf("Expected ErrAddressLookupDisabled, got %v", err)
+	}
+	if err := settings.Set(SettingNominatimURL, "ftp://nominatim.example.com"); err == nil {
+		t.Error("Expected an error for a URL that is not http or https")
+	}
+}
@@ -0,0 +1,811 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// Authentication modes, selected with AUTH_MODE
+const (
+	AuthModeNone  = "none"
+	AuthModeProxy = "proxy"
+	AuthModeOIDC  = "oidc"
+)
+
+const (
+	// sessionLifetime is how long an OIDC sign-in stays valid
+	sessionLifetime = 7 * 24 * time.Hour
+	// lastLoginInterval limits how often proxy users' last login time is written
+	lastLoginInterval = time.Hour
+	// linkKeySetting is the settings row holding the key that signs file links.
+	// It is not a user setting and never shown on the settings page.
+	linkKeySetting = "auth.link_key"
+	// apiTokenPrefix starts every API token, so leaked tokens are easy to recognize
+	apiTokenPrefix = "si_"
+	// tokenLastUsedInterval limits how often API tokens' last used time is written
+	tokenLastUsedInterval = time.Minute
+	// sessionActivityInterval limits how often sessions' last activity is written
+	sessionActivityInterval = time.Minute
+	// sessionIDLength is the length of the public session ID, a prefix of the token hash
+	sessionIDLength = 16
+)
+
+// ErrUserExists is returned when creating a user that already exists
+var ErrUserExists = errors.New("user already exists")
+
+// ErrInvalidToken is returned for API token requests that cannot be fulfilled,
+// such as a token without a name
+var ErrInvalidToken = errors.New("invalid API token")
+
+// defaultTrustedProxies are trusted when AUTH_TRUSTED_PROXIES is not set:
+// loopback and private networks, where reverse proxies usually run
+var defaultTrustedProxies = []string{"127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"}
+
+// AuthService authenticates users through trusted reverse proxy headers or an
+// OIDC provider and provisions them on their first sign-in. With AUTH_MODE
+// unset, authentication is disabled, as before.
+type AuthService struct {
+	mode           string
+	userHeader     string
+	emailHeader    string
+	nameHeader     string
+	trustedProxies []*net.IPNet
+	oidc           *OIDCProvider
+	secureCookies  bool
+	linkKey        []byte
+	dbService      *DBService
+	logger         *Logger
+}
+
+// NewAuthService creates a new AuthService configured from environment variables
+func NewAuthService(dbService *DBService, logger *Logger) (*AuthService, error) {
+	s := &AuthService{
+		mode:        strings.ToLower(strings.TrimSpace(os.Getenv("AUTH_MODE"))),
+		userHeader:  envOrDefault("AUTH_PROXY_USER_HEADER", "Remote-User"),
+		emailHeader: envOrDefault("AUTH_PROXY_EMAIL_HEADER", "Remote-Email"),
+		nameHeader:  envOrDefault("AUTH_PROXY_NAME_HEADER", "Remote-Name"),
+		dbService:   dbService,
+		logger:      logger,
+	}
+	if s.mode == "" {
+		s.mode = AuthModeNone
+	}
+
+	// Trusted proxies set the user headers and the client address in X-Forwarded-For
+	proxies := defaultTrustedProxies
+	if env := os.Getenv("AUTH_TRUSTED_PROXIES"); env != "" {
+		proxies = strings.Split(env, ",")
+	}
+	trusted, err := parseTrustedProxies(proxies)
+	if err != nil {
+		return nil, err
+	}
+	s.trustedProxies = trusted
+
+	switch s.mode {
+	case AuthModeNone:
+		logger.Warn("Authentication is disabled - protect the application with a reverse proxy or set AUTH_MODE")
+	case AuthModeProxy:
+		logger.Info("Authenticating users from the %s header set by trusted proxies %s", s.userHeader, strings.Join(proxies, ", "))
+	case AuthModeOIDC:
+		config := OIDCConfig{
+			IssuerURL:    os.Getenv("OIDC_ISSUER_URL"),
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+		}
+		if config.IssuerURL == "" || config.ClientID == "" || config.RedirectURL == "" {
+			return nil, fmt.Errorf("AUTH_MODE=oidc requires OIDC_ISSUER_URL, OIDC_CLIENT_ID and OIDC_REDIRECT_URL")
+		}
+		s.oidc = NewOIDCProvider(config, logger)
+		s.secureCookies = strings.HasPrefix(config.RedirectURL, "https://")
+		logger.Info("Authenticating users with OIDC provider %s", config.IssuerURL)
+	default:
+		return nil, fmt.Errorf("unknown AUTH_MODE %q, expected none, proxy or oidc", s.mode)
+	}
+
+	if err := s.loadLinkKey(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Mode returns the authentication mode
+func (s *AuthService) Mode() string {
+	return s.mode
+}
+
+// OIDC returns the OIDC provider, or nil unless the mode is oidc
+func (s *AuthService) OIDC() *OIDCProvider {
+	return s.oidc
+}
+
+// SecureCookies reports whether session cookies must only be sent over HTTPS
+func (s *AuthService) SecureCookies() bool {
+	return s.secureCookies
+}
+
+// unixSocketKey marks the context of connections accepted on a Unix socket
+type unixSocketKey struct{}
+
+// UnixSocketConnContext is an http.Server ConnContext that marks connections
+// accepted on a Unix socket. They come from local processes allowed to open the
+// socket, i.e. the reverse proxy, and are trusted like AUTH_TRUSTED_PROXIES.
+func UnixSocketConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if _, ok := conn.(*net.UnixConn); ok {
+		return context.WithValue(ctx, unixSocketKey{}, true)
+	}
+	return ctx
+}
+
+// FromUnixSocket reports whether a request's connection was accepted on a Unix socket
+func FromUnixSocket(ctx context.Context) bool {
+	fromSocket, _ := ctx.Value(unixSocketKey{}).(bool)
+	return fromSocket
+}
+
+// ProxyUser returns the user named in the request's proxy headers, provisioning
+// them if needed. It returns nil if the request did not come from a trusted
+// proxy or carries no user header.
+func (s *AuthService) ProxyUser(r *http.Request) (*models.User, error) {
+	subject := strings.TrimSpace(r.Header.Get(s.userHeader))
+	if subject == "" {
+		return nil, nil
+	}
+	if !FromUnixSocket(r.Context()) && !s.isTrustedProxy(r.RemoteAddr) {
+		s.logger.Warn("Ignoring %s header from untrusted address %s", s.userHeader, r.RemoteAddr)
+		return nil, nil
+	}
+
+	return s.ProvisionUser(AuthModeProxy, subject, subject,
+		strings.TrimSpace(r.Header.Get(s.emailHeader)), strings.TrimSpace(r.Header.Get(s.nameHeader)))
+}
+
+// OIDCUser provisions the user identified by verified ID token claims
+func (s *AuthService) OIDCUser(claims *OIDCClaims) (*models.User, error) {
+	username := claims.PreferredUsername
+	if username == "" {
+		username = claims.Email
+	}
+	if username == "" {
+		username = claims.Subject
+	}
+	return s.ProvisionUser(AuthModeOIDC, claims.Subject, username, claims.Email, claims.Name)
+}
+
+// ProvisionUser returns the user with the given source and subject, creating
+// them on their first sign-in and updating their profile and last login time
+// on later ones. New users are recorded in the audit log.
+func (s *AuthService) ProvisionUser(source, subject, username, email, name string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	db := s.dbService.GetDB()
+	now := time.Now().UTC()
+
+	user, err := scanUser(db.QueryRowContext(ctx, `
+		SELECT id, auth_source, subject, username, email, name, created_at, last_login_at
+		FROM users WHERE auth_source = ? AND subject = ?
+	`, source, subject))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	if err == nil {
+		unchanged := user.Username == username && user.Email == email && user.Name == name
+		if unchanged && now.Sub(user.LastLoginAt) < lastLoginInterval {
+			return user, nil
+		}
+		_, err := db.ExecContext(ctx, `
+			UPDATE users SET username = ?, email = ?, name = ?, last_login_at = ? WHERE id = ?
+		`, username, email, name, now, user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update user: %w", err)
+		}
+		user.Username, user.Email, user.Name, user.LastLoginAt = username, email, name, now
+		return user, nil
+	}
+
+	user = &models.User{
+		AuthSource:  source,
+		Subject:     subject,
+		Username:    username,
+		Email:       email,
+		Name:        name,
+		CreatedAt:   now,
+		LastLoginAt: now,
+	}
+	if err := s.insertUser(ctx, user, fmt.Sprintf("Provisioned %s user %s", source, username)); err != nil {
+		return nil, err
+	}
+	s.logger.Info("Provisioned %s user %s (ID %d)", source, username, user.ID)
+	return user, nil
+}
+
+// CreateUser adds a user ahead of their first sign-in, e.g. from the command
+// line, so they can be referred to before they sign in. The user is matched
+// by source and subject when they do; their last login time stays zero until
+// then. It returns ErrUserExists if the user was already created.
+func (s *AuthService) CreateUser(source, subject, username, email, name string) (*models.User, error) {
+	if source != AuthModeProxy && source != AuthModeOIDC {
+		return nil, fmt.Errorf("unknown user source %q, expected proxy or oidc", source)
+	}
+	if subject == "" || username == "" {
+		return nil, errors.New("a user needs a subject and a username")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := s.dbService.GetDB().QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM users WHERE auth_source = ? AND subject = ?)
+	`, source, subject).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	if exists {
+		return nil, fmt.Errorf("%w: %s user %s", ErrUserExists, source, subject)
+	}
+
+	user := &models.User{
+		AuthSource: source,
+		Subject:    subject,
+		Username:   username,
+		Email:      email,
+		Name:       name,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := s.insertUser(ctx, user, fmt.Sprintf("Created %s user %s", source, username)); err != nil {
+		return nil, err
+	}
+	s.logger.Info("Created %s user %s (ID %d)", source, username, user.ID)
+	return user, nil
+}
+
+// insertUser stores a new user, setting its ID, and records it in the audit log
+func (s *AuthService) insertUser(ctx context.Context, user *models.User, details string) error {
+	tx, err := s.dbService.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO users (auth_source, subject, username, email, name, created_at, last_login_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, user.AuthSource, user.Subject, user.Username, user.Email, user.Name, user.CreatedAt, user.LastLoginAt).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	if err := logAudit(ctx, tx, AuditActionProvision, "user", int(id), details); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit user: %w", err)
+	}
+	user.ID = int(id)
+	return nil
+}
+
+// CreateSession starts a session for the user on the browser with the given
+// address and User-Agent and returns its token. Only a hash of the token is stored.
+func (s *AuthService) CreateSession(userID int, ipAddress, userAgent string) (string, time.Time, error) {
+	token, err := RandomToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(sessionLifetime)
+	_, err = s.dbService.GetDB().Exec(`
+		INSERT INTO sessions (token_hash, user_id, ip_address, user_agent, created_at, last_seen_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, hashSessionToken(token), userID, ipAddress, truncateUserAgent(userAgent), now, now, expiresAt)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	// Expired sessions are only needed until the next sign-in
+	if _, err := s.dbService.GetDB().Exec(`DELETE FROM sessions WHERE expires_at < ?`, now); err != nil {
+		s.logger.Warn("Failed to remove expired sessions: %v", err)
+	}
+	return token, expiresAt, nil
+}
+
+// SessionUser returns the user of an unexpired session, or nil if there is
+// none. The session's last activity and address are updated at most once a minute.
+func (s *AuthService) SessionUser(token, ipAddress string) (*models.User, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	db := s.dbService.GetDB()
+	now := time.Now().UTC()
+	var user models.User
+	var lastSeenAt time.Time
+	var lastAddress string
+	err := db.QueryRow(`
+		SELECT u.id, u.auth_source, u.subject, u.username, u.email, u.name, u.created_at, u.last_login_at, s.last_seen_at, s.ip_address
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.expires_at > ?
+	`, hashSessionToken(token), now).Scan(&user.ID, &user.AuthSource, &user.Subject, &user.Username, &user.Email, &user.Name,
+		&user.CreatedAt, &user.LastLoginAt, &lastSeenAt, &lastAddress)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up session: %w", err)
+	}
+
+	if now.Sub(lastSeenAt) >= sessionActivityInterval || ipAddress != lastAddress {
+		if _, err := db.Exec(`UPDATE sessions SET last_seen_at = ?, ip_address = ? WHERE token_hash = ?`,
+			now, ipAddress, hashSessionToken(token)); err != nil {
+			s.logger.Warn("Failed to update session activity: %v", err)
+		}
+	}
+	return &user, nil
+}
+
+// Sessions returns the unexpired sessions of a user, most recently active
+// first. The session with token current is marked as such.
+func (s *AuthService) Sessions(userID int, current string) ([]models.Session, error) {
+	rows, err := s.dbService.GetDB().Query(`
+		SELECT token_hash, user_id, ip_address, user_agent, created_at, last_seen_at, expires_at
+		FROM sessions WHERE user_id = ? AND expires_at > ?
+		ORDER BY last_seen_at DESC
+	`, userID, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	currentHash := hashSessionToken(current)
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		var tokenHash string
+		if err := rows.Scan(&tokenHash, &session.UserID, &session.IPAddress, &session.UserAgent,
+			&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		session.ID = tokenHash[:sessionIDLength]
+		session.Device = models.DescribeUserAgent(session.UserAgent)
+		session.Current = current != "" && tokenHash == currentHash
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession ends a session of a user by its ID, signing that browser
+// out. It returns sql.ErrNoRows if the user has no such session.
+func (s *AuthService) RevokeSession(userID int, id string) error {
+	if len(id) != sessionIDLength {
+		return sql.ErrNoRows
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, err := s.dbService.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var ipAddress, userAgent string
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM sessions WHERE user_id = ? AND SUBSTR(token_hash, 1, ?) = ?
+		RETURNING ip_address, user_agent
+	`, userID, sessionIDLength, id).Scan(&ipAddress, &userAgent)
+	if err != nil {
+		return err
+	}
+	details := fmt.Sprintf("Revoked the session from %s with %s", ipAddress, models.DescribeUserAgent(userAgent))
+	if err := logAudit(ctx, tx, AuditActionRevokeSession, "user", userID, details); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit session: %w", err)
+	}
+	s.logger.Info("%s of user %d", details, userID)
+	return nil
+}
+
+// RecordLogin records a sign-in in the audit log along with the device it
+// came from, identified by a random ID kept in a cookie. It reports whether
+// the device is new for a user who signed in on other devices before, so
+// the first sign-in of a user is not reported.
+func (s *AuthService) RecordLogin(userID int, deviceID, ipAddress, userAgent string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, err := s.dbService.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var known, devices int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(CASE WHEN device_hash = ? THEN 1 ELSE 0 END), 0) FROM user_devices WHERE user_id = ?
+	`, hashSessionToken(deviceID), userID).Scan(&devices, &known)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up devices: %w", err)
+	}
+
+	now := time.Now().UTC()
+	userAgent = truncateUserAgent(userAgent)
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_devices (user_id, device_hash, ip_address, user_agent, first_seen_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, device_hash) DO UPDATE SET ip_address = excluded.ip_address, user_agent = excluded.user_agent, last_seen_at = excluded.last_seen_at
+	`, userID, hashSessionToken(deviceID), ipAddress, userAgent, now, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to record device: %w", err)
+	}
+
+	newDevice := known == 0 && devices > 0
+	details := fmt.Sprintf("Signed in from %s with %s", ipAddress, models.DescribeUserAgent(userAgent))
+	if newDevice {
+		details += " on a new device"
+	}
+	if err := logAudit(ctx, tx, AuditActionLogin, "user", userID, details); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit sign-in: %w", err)
+	}
+	return newDevice, nil
+}
+
+// ClientIP returns the address of the browser that made a request. Behind a
+// trusted proxy it is the last address in X-Forwarded-For that is not a
+// trusted proxy itself.
+func (s *AuthService) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !FromUnixSocket(r.Context()) && !s.isTrustedProxy(r.RemoteAddr) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(address) == nil {
+			break
+		}
+		host = address
+		if !s.isTrustedProxy(address) {
+			break
+		}
+	}
+	return host
+}
+
+// DeleteSession ends a session
+func (s *AuthService) DeleteSession(token string) error {
+	_, err := s.dbService.GetDB().Exec(`DELETE FROM sessions WHERE token_hash = ?`, hashSessionToken(token))
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+const apiTokenColumns = `t.id, t.user_id, u.username, t.name, t.prefix, t.scopes, t.created_at, t.last_used_at, t.revoked_at`
+
+// scanAPIToken scans an api_tokens row joined with its user, selected with apiTokenColumns
+func scanAPIToken(row rowScanner) (*models.APIToken, error) {
+	var token models.APIToken
+	var scopes string
+	err := row.Scan(&token.ID, &token.UserID, &token.Username, &token.Name, &token.Prefix, &scopes,
+		&token.CreatedAt, &token.LastUsedAt, &token.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	token.Scopes = strings.Fields(scopes)
+	return &token, nil
+}
+
+// CreateAPIToken creates a named token with the given scopes for a user and
+// returns it with its secret, which is not stored and cannot be shown again
+func (s *AuthService) CreateAPIToken(userID int, name string, scopes []string) (*models.APIToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", fmt.Errorf("%w: a token needs a name", ErrInvalidToken)
+	}
+	scopes, err := models.NormalizeTokenScopes(scopes)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	random, err := RandomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	secret := apiTokenPrefix + random
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, err := s.dbService.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	token := &models.APIToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    secret[:len(apiTokenPrefix)+6],
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, created_at, last_used_at, revoked_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, userID, name, hashSessionToken(secret), token.Prefix, strings.Join(scopes, " "), token.CreatedAt,
+		time.Time{}, time.Time{}).Scan(&token.ID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create API token: %w", err)
+	}
+	if err := tx.QueryRowContext(ctx, `SELECT username FROM users WHERE id = ?`, userID).Scan(&token.Username); err != nil {
+		return nil, "", fmt.Errorf("failed to look up user %d: %w", userID, err)
+	}
+
+	details := fmt.Sprintf("Created API token %s (%s) for %s with scopes %s", token.Name, token.Prefix, token.Username, strings.Join(scopes, " "))
+	if err := logAudit(ctx, tx, AuditActionCreateToken, "api_token", token.ID, details); err != nil {
+		return nil, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, "", fmt.Errorf("failed to commit API token: %w", err)
+	}
+	s.logger.Info("%s", details)
+	return token, secret, nil
+}
+
+// APITokens returns all API tokens, revoked ones last
+func (s *AuthService) APITokens() ([]models.APIToken, error) {
+	rows, err := s.dbService.GetDB().Query(`
+		SELECT ` + apiTokenColumns + ` FROM api_tokens t JOIN users u ON u.id = t.user_id
+		ORDER BY t.revoked_at, t.created_at DESC, t.id DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API token: %w", err)
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken revokes a token, which is kept to show when it was last
+// used. It returns sql.ErrNoRows if there is no such token or it was
+// already revoked.
+func (s *AuthService) RevokeAPIToken(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, err := s.dbService.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var name, prefix string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND revoked_at = ?
+		RETURNING name, prefix
+	`, time.Now().UTC(), id, time.Time{}).Scan(&name, &prefix)
+	if err != nil {
+		return err
+	}
+	details := fmt.Sprintf("Revoked API token %s (%s)", name, prefix)
+	if err := logAudit(ctx, tx, AuditActionRevokeToken, "api_token", id, details); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit API token: %w", err)
+	}
+	s.logger.Info("%s", details)
+	return nil
+}
+
+// TokenUser returns the user and token of an unrevoked API token, or nils if
+// there is none. The token's last used time is updated at most once a minute.
+func (s *AuthService) TokenUser(secret string) (*models.User, *models.APIToken, error) {
+	if !strings.HasPrefix(secret, apiTokenPrefix) {
+		return nil, nil, nil
+	}
+
+	db := s.dbService.GetDB()
+	token, err := scanAPIToken(db.QueryRow(`
+		SELECT `+apiTokenColumns+` FROM api_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ?
+	`, hashSessionToken(secret)))
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to look up API token: %w", err)
+	}
+	if token.Revoked() {
+		return nil, nil, nil
+	}
+
+	user, err := scanUser(db.QueryRow(`
+		SELECT id, auth_source, subject, username, email, name, created_at, last_login_at
+		FROM users WHERE id = ?
+	`, token.UserID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to look up user of API token: %w", err)
+	}
+
+	now := time.Now().UTC()
+	if now.Sub(token.LastUsedAt) >= tokenLastUsedInterval {
+		if _, err := db.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, now, token.ID); err != nil {
+			s.logger.Warn("Failed to update last use of API token %d: %v", token.ID, err)
+		}
+		token.LastUsedAt = now
+	}
+	return user, token, nil
+}
+
+// SignLink returns a token that grants access to the named file until expires,
+// without signing in. The token is bound to the name and cannot be reused for
+// other files.
+func (s *AuthService) SignLink(name string, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	return expiry + "." + base64.RawURLEncoding.EncodeToString(s.linkMAC(name, expiry))
+}
+
+// VerifyLink reports whether token is an unexpired token for the named file
+func (s *AuthService) VerifyLink(name, token string) bool {
+	expiry, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(mac, s.linkMAC(name, expiry))
+}
+
+func (s *AuthService) linkMAC(name, expiry string) []byte {
+	mac := hmac.New(sha256.New, s.linkKey)
+	mac.Write([]byte(name + "\n" + expiry))
+	return mac.Sum(nil)
+}
+
+// loadLinkKey reads the link signing key from LINK_SIGNING_KEY, or from the
+// database, generating and storing one on first start
+func (s *AuthService) loadLinkKey() error {
+	if env := os.Getenv("LINK_SIGNING_KEY"); env != "" {
+		s.linkKey = []byte(env)
+		return nil
+	}
+
+	db := s.dbService.GetDB()
+	key, err := RandomToken()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`INSERT INTO settings (key, value, updated_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`,
+		linkKeySetting, key, time.Now()); err != nil {
+		return fmt.Errorf("failed to store link signing key: %w", err)
+	}
+	if err := db.QueryRow(`SELECT value FROM settings WHERE key = ?`, linkKeySetting).Scan(&key); err != nil {
+		return fmt.Errorf("failed to read link signing key: %w", err)
+	}
+	s.linkKey = []byte(key)
+	return nil
+}
+
+// isTrustedProxy reports whether remoteAddr, a host:port pair, is a trusted proxy
+func (s *AuthService) isTrustedProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range s.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses IP addresses and CIDR ranges
+func parseTrustedProxies(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", value, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// RandomToken returns a random URL-safe token with 256 bits of entropy
+func RandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// truncateUserAgent limits the length of stored User-Agent headers
+func truncateUserAgent(userAgent string) string {
+	if len(userAgent) > 512 {
+		return strings.ToValidUTF8(userAgent[:512], "")
+	}
+	return userAgent
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func scanUser(row *sql.Row) (*models.User, error) {
+	var user models.User
+	err := row.Scan(&user.ID, &user.AuthSource, &user.Subject, &user.Username, &user.Email, &user.Name, &user.CreatedAt, &user.LastLoginAt)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func envOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,448 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// fakeOIDCProvider serves discovery, JWKS and token endpoints and issues ID
+// tokens with the given claims
+type fakeOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+	form   url.Values
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	p := &fakeOIDCProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test-key",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.form = r.PostForm
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.sign(t, p.claims)})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *fakeOIDCProvider) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCExchange(t *testing.T) {
+	fake := newFakeOIDCProvider(t)
+	provider := NewOIDCProvider(OIDCConfig{
+		IssuerURL:    fake.server.URL,
+		ClientID:     "simple-invoice",
+		ClientSecret: "secret",
+		RedirectURL:  "https://invoices.example.com/auth/callback",
+	}, NewLogger(INFO))
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatalf("Failed to build authorization URL: %v", err)
+	}
+	parsed, _ := url.Parse(authURL)
+	if parsed.Query().Get("state") != "state" || parsed.Query().Get("code_challenge_method") != "S256" {
+		t.Errorf("Unexpected authorization URL: %s", authURL)
+	}
+
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":                fake.server.URL,
+			"aud":                []string{"simple-invoice"},
+			"sub":                "user-1",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"nonce":              "nonce",
+			"email":              "jane@example.com",
+			"preferred_username": "jane",
+		}
+	}
+
+	fake.claims = validClaims()
+	claims, err := provider.Exchange(ctx, "code", "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("Failed to exchange code: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.PreferredUsername != "jane" || claims.Email != "jane@example.com" {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+	if fake.form.Get("code_verifier") != "verifier" || fake.form.Get("code") != "code" {
+		t.Errorf("Unexpected token request: %v", fake.form)
+	}
+
+	for name, change := range map[string]func(map[string]interface{}){
+		"nonce":    func(c map[string]interface{}) { c["nonce"] = "other" },
+		"audience": func(c map[string]interface{}) { c["aud"] = "other-client" },
+		"issuer":   func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"expiry":   func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+	} {
+		fake.claims = validClaims()
+		change(fake.claims)
+		if _, err := provider.Exchange(ctx, "code", "verifier", "nonce"); err == nil {
+			t.Errorf("Expected an error for a wrong %s", name)
+		}
+	}
+
+	// A token signed by another key with the same key ID must be rejected
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	fake.key = otherKey
+	if _, err := provider.verifyIDToken(ctx, fake.sign(t, validClaims()), "nonce"); err == nil {
+		t.Error("Expected an error for a forged signature")
+	}
+}
+
+func TestVerifyJWTSignatureRejectsMismatchedKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	signed := "header.payload"
+	digest256 := sha256.Sum256([]byte(signed))
+	rsaSignature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest256[:])
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	if err := verifyJWTSignature("RS256", &rsaKey.PublicKey, signed, rsaSignature); err != nil {
+		t.Errorf("Expected a valid RS256 signature, got %v", err)
+	}
+	for _, alg := range []string{"PS256", "ES256", "HS256", "none", "XRS256"} {
+		if err := verifyJWTSignature(alg, &rsaKey.PublicKey, signed, rsaSignature); err == nil {
+			t.Errorf("Expected %s to be rejected for an RS256 signature", alg)
+		}
+	}
+
+	// ES256 requires a P-256 key, even when the signature itself is valid
+	digest384 := sha512.Sum384([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, p384Key, digest384[:])
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	ecSignature := append(r.FillBytes(make([]byte, 48)), s.FillBytes(make([]byte, 48))...)
+	if err := verifyJWTSignature("ES384", &p384Key.PublicKey, signed, ecSignature); err != nil {
+		t.Errorf("Expected a valid ES384 signature, got %v", err)
+	}
+	for _, alg := range []string{"ES256", "ES512", "RS384"} {
+		if err := verifyJWTSignature(alg, &p384Key.PublicKey, signed, ecSignature); err == nil {
+			t.Errorf("Expected %s to be rejected for a P-384 key", alg)
+		}
+	}
+}
+
+func TestProvisionUserAndSessions(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	authService, err := NewAuthService(dbService, NewLogger(INFO))
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+
+	user, err := authService.OIDCUser(&OIDCClaims{Subject: "user-1", Email: "jane@example.com", Name: "Jane Doe"})
+	if err != nil {
+		t.Fatalf("Failed to provision user: %v", err)
+	}
+	if user.Username != "jane@example.com" || user.AuthSource != AuthModeOIDC {
+		t.Errorf("Unexpected user: %+v", user)
+	}
+
+	again, err := authService.OIDCUser(&OIDCClaims{Subject: "user-1", PreferredUsername: "jane", Email: "jane@example.com"})
+	if err != nil || again.ID != user.ID || again.Username != "jane" {
+		t.Errorf("Expected the existing user to be updated, got %+v, %v", again, err)
+	}
+
+	entries, err := dbService.GetAuditLog("user", user.ID, 0)
+	if err != nil || len(entries) != 1 || entries[0].Action != AuditActionProvision {
+		t.Errorf("Expected one provisioning audit entry, got %+v, %v", entries, err)
+	}
+
+	firefox := "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"
+	token, _, err := authService.CreateSession(user.ID, "192.0.2.1", firefox)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	sessionUser, err := authService.SessionUser(token, "192.0.2.7")
+	if err != nil || sessionUser == nil || sessionUser.ID != user.ID {
+		t.Errorf("Expected session user %d, got %+v, %v", user.ID, sessionUser, err)
+	}
+
+	other, _, err := authService.CreateSession(user.ID, "198.51.100.2", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 Version/17.5 Mobile/15E148 Safari/604.1")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	sessions, err := authService.Sessions(user.ID, token)
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("Expected two sessions, got %+v, %v", sessions, err)
+	}
+	for _, session := range sessions {
+		if session.Current && (session.IPAddress != "192.0.2.7" || session.Device != "Firefox on Linux") {
+			t.Errorf("Expected the current session to be seen from 192.0.2.7 with Firefox, got %+v", session)
+		}
+		if !session.Current && session.Device != "Safari on iOS" {
+			t.Errorf("Expected the other session to be Safari on iOS, got %+v", session)
+		}
+	}
+
+	// Revoking a session signs that browser out; other users cannot revoke it
+	otherID := sessions[0].ID
+	if sessions[0].Current {
+		otherID = sessions[1].ID
+	}
+	if err := authService.RevokeSession(user.ID+1, otherID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected another user's session not to be found, got %v", err)
+	}
+	if err := authService.RevokeSession(user.ID, otherID); err != nil {
+		t.Fatalf("Failed to revoke session: %v", err)
+	}
+	if sessionUser, err := authService.SessionUser(other, "198.51.100.2"); err != nil || sessionUser != nil {
+		t.Errorf("Expected no user for a revoked session, got %+v, %v", sessionUser, err)
+	}
+
+	if err := authService.DeleteSession(token); err != nil {
+		t.Fatalf("Failed to delete session: %v", err)
+	}
+	if sessionUser, err := authService.SessionUser(token, "192.0.2.7"); err != nil || sessionUser != nil {
+		t.Errorf("Expected no user after logout, got %+v, %v", sessionUser, err)
+	}
+
+	// The first device of a user is not new; a second one is until it has been seen
+	for i, tt := range []struct {
+		device string
+		want   bool
+	}{{"laptop", false}, {"laptop", false}, {"phone", true}, {"phone", false}} {
+		newDevice, err := authService.RecordLogin(user.ID, tt.device, "192.0.2.1", firefox)
+		if err != nil || newDevice != tt.want {
+			t.Errorf("Sign-in %d on %s: new device = %t, %v, want %t", i, tt.device, newDevice, err, tt.want)
+		}
+	}
+	entries, err = dbService.GetAuditLog("user", user.ID, 0)
+	if err != nil || len(entries) != 6 {
+		t.Errorf("Expected the sign-ins and the revoked session in the audit log, got %d entries, %v", len(entries), err)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	t.Setenv("AUTH_TRUSTED_PROXIES", "10.0.0.1")
+	authService, err := NewAuthService(dbService, NewLogger(ERROR))
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+
+	for _, tt := range []struct {
+		remoteAddr, forwardedFor, want string
+	}{
+		{"203.0.113.9:4321", "", "203.0.113.9"},
+		{"203.0.113.9:4321", "192.0.2.1", "203.0.113.9"},
+		{"10.0.0.1:4321", "192.0.2.1", "192.0.2.1"},
+		{"10.0.0.1:4321", "192.0.2.66, 192.0.2.1, 10.0.0.1", "192.0.2.1"},
+		{"10.0.0.1:4321", "", "10.0.0.1"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		}
+		if got := authService.ClientIP(r); got != tt.want {
+			t.Errorf("ClientIP(%s, %q) = %s, want %s", tt.remoteAddr, tt.forwardedFor, got, tt.want)
+		}
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	authService, err := NewAuthService(dbService, NewLogger(ERROR))
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+	user, err := authService.OIDCUser(&OIDCClaims{Subject: "user-1", PreferredUsername: "jane"})
+	if err != nil {
+		t.Fatalf("Failed to provision user: %v", err)
+	}
+
+	if _, _, err := authService.CreateAPIToken(user.ID, "Export", []string{"invoices:delete"}); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected an unknown scope to be rejected, got %v", err)
+	}
+	if _, _, err := authService.CreateAPIToken(user.ID, " ", []string{"read"}); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a token without a name to be rejected, got %v", err)
+	}
+
+	token, secret, err := authService.CreateAPIToken(user.ID, "Export", []string{"invoices:write", "read", "read"})
+	if err != nil {
+		t.Fatalf("Failed to create API token: %v", err)
+	}
+	if !strings.HasPrefix(secret, token.Prefix) || len(token.Scopes) != 2 || token.Username != "jane" {
+		t.Errorf("Unexpected token %+v for secret %s", token, secret)
+	}
+
+	// Only the hash of the secret is stored
+	var stored int
+	dbService.GetDB().QueryRow(`SELECT COUNT(*) FROM api_tokens WHERE token_hash = ?`, secret).Scan(&stored)
+	if stored != 0 {
+		t.Error("Expected the token secret not to be stored")
+	}
+
+	tokenUser, used, err := authService.TokenUser(secret)
+	if err != nil || tokenUser == nil || tokenUser.ID != user.ID || !used.Allows(models.TokenScopeInvoicesWrite) || used.Allows(models.TokenScopeBackupsAdmin) {
+		t.Fatalf("Expected the token to act as %d, got %+v, %+v, %v", user.ID, tokenUser, used, err)
+	}
+	tokens, err := authService.APITokens()
+	if err != nil || len(tokens) != 1 || tokens[0].LastUsedAt.IsZero() {
+		t.Errorf("Expected the token to be listed as used, got %+v, %v", tokens, err)
+	}
+	if tokenUser, _, err := authService.TokenUser(secret + "x"); err != nil || tokenUser != nil {
+		t.Errorf("Expected an unknown token to be refused, got %+v, %v", tokenUser, err)
+	}
+
+	if err := authService.RevokeAPIToken(token.ID); err != nil {
+		t.Fatalf("Failed to revoke API token: %v", err)
+	}
+	if err := authService.RevokeAPIToken(token.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected revoking twice to fail with sql.ErrNoRows, got %v", err)
+	}
+	if tokenUser, _, err := authService.TokenUser(secret); err != nil || tokenUser != nil {
+		t.Errorf("Expected a revoked token to be refused, got %+v, %v", tokenUser, err)
+	}
+
+	entries, err := dbService.GetAuditLog("api_token", token.ID, 0)
+	if err != nil || len(entries) != 2 {
+		t.Errorf("Expected the token to be created and revoked in the audit log, got %+v, %v", entries, err)
+	}
+}
+
+func TestProxyUserRequiresTrustedProxy(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	t.Setenv("AUTH_MODE", "proxy")
+	t.Setenv("AUTH_TRUSTED_PROXIES", "10.0.0.1, 172.18.0.0/16")
+	authService, err := NewAuthService(dbService, NewLogger(INFO))
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+
+	newRequest := func(remoteAddr string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("Remote-User", "jane")
+		r.Header.Set("Remote-Email", "jane@example.com")
+		return r
+	}
+
+	for _, remoteAddr := range []string{"10.0.0.1:4321", "172.18.3.4:80"} {
+		user, err := authService.ProxyUser(newRequest(remoteAddr))
+		if err != nil || user == nil || user.Username != "jane" || user.Email != "jane@example.com" {
+			t.Errorf("Expected user jane from %s, got %+v, %v", remoteAddr, user, err)
+		}
+	}
+
+	if user, err := authService.ProxyUser(newRequest("192.168.1.10:4321")); err != nil || user != nil {
+		t.Errorf("Expected the header from an untrusted address to be ignored, got %+v, %v", user, err)
+	}
+
+	// Connections on a Unix socket have no address and come from the proxy
+	r := newRequest("@")
+	r = r.WithContext(context.WithValue(r.Context(), unixSocketKey{}, true))
+	if user, err := authService.ProxyUser(r); err != nil || user == nil || user.Username != "jane" {
+		t.Errorf("Expected user jane from the Unix socket, got %+v, %v", user, err)
+	}
+
+	t.Setenv("AUTH_MODE", "ldap")
+	if _, err := NewAuthService(dbService, NewLogger(INFO)); err == nil {
+		t.Error("Expected an error for an unknown AUTH_MODE")
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	authService, err := NewAuthService(dbService, NewLogger(ERROR))
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+
+	user, err := authService.CreateUser(AuthModeProxy, "jane", "jane", "jane@example.com", "Jane Doe")
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if !user.LastLoginAt.IsZero() {
+		t.Errorf("Expected no last login for a created user, got %v", user.LastLoginAt)
+	}
+	if _, err := authService.CreateUser(AuthModeProxy, "jane", "jane", "", ""); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Expected ErrUserExists, got %v", err)
+	}
+	if _, err := authService.CreateUser("ldap", "jane", "jane", "", ""); err == nil {
+		t.Error("Expected an unknown source to be rejected")
+	}
+
+	// The created user is the one signing in later
+	signedIn, err := authService.ProvisionUser(AuthModeProxy, "jane", "jane", "jane@example.com", "Jane Doe")
+	if err != nil || signedIn.ID != user.ID || signedIn.LastLoginAt.IsZero() {
+		t.Errorf("Expected the created user to sign in, got %+v (%v)", signedIn, err)
+	}
+}
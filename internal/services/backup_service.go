@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"compress/gzip"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -15,6 +16,24 @@ import (
 	"github.com/robfig/cron/v3"
 )
 
+const (
+	// backupFilePrefix and backupFileSuffix frame the names of backup files
+	backupFilePrefix = "simple-invoice-backup-"
+	backupFileSuffix = ".tar.gz"
+)
+
+var (
+	// ErrInvalidBackupName is returned for filenames that are not backups in the backup directory
+	ErrInvalidBackupName = errors.New("invalid backup filename")
+
+	// ErrBackupNotFound is returned when the requested backup does not exist
+	ErrBackupNotFound = errors.New("backup not found")
+
+	// ErrBackupUnsupported is returned when the database is PostgreSQL, which
+	// is not in the data directory
+	ErrBackupUnsupported = errors.New("backups of a PostgreSQL database are made with pg_dump")
+)
+
 // BackupService provides methods for backing up and restoring the database
 type BackupService struct {
 	db          *sql.DB
@@ -22,6 +41,7 @@ type BackupService struct {
 	backupDir   string
 	logger      *Logger
 	cron        *cron.Cron
+	jobService  *JobService
 	needsReopen bool
 }
 
@@ -51,22 +71,26 @@ func NewBackupService(db *sql.DB, dataDir string, logger *Logger) (*BackupServic
 	}, nil
 }
 
+// SetJobService routes scheduled backups through the job queue so failures are retried
+func (s *BackupService) SetJobService(jobService *JobService) {
+	s.jobService = jobService
+}
+
 // StartScheduler starts the backup scheduler with the given cron expression
 func (s *BackupService) StartScheduler(cronExpr string) error {
 	if cronExpr == "" {
 		s.logger.Info("No backup schedule configured, automatic backups disabled")
 		return nil
 	}
+	if DatabaseURL() != "" {
+		s.logger.Warn("Automatic backups are disabled with a PostgreSQL database, back it up with pg_dump")
+		return nil
+	}
 
 	s.logger.Info("Starting backup scheduler with cron expression: %s", cronExpr)
 
 	_, err := s.cron.AddFunc(cronExpr, func() {
-		s.logger.Info("Running scheduled backup")
-		if err := s.CreateBackup(); err != nil {
-			s.logger.Error("Scheduled backup failed: %v", err)
-		} else {
-			s.logger.Info("Scheduled backup completed successfully")
-		}
+		RunProtected(s.logger, "scheduled backup", s.runScheduledBackup)
 	})
 
 	if err != nil {
@@ -77,6 +101,25 @@ func (s *BackupService) StartScheduler(cronExpr string) error {
 	return nil
 }
 
+// runScheduledBackup queues a backup job, or creates the backup right away
+// without a job service
+func (s *BackupService) runScheduledBackup() {
+	if s.jobService != nil {
+		s.logger.Info("Queueing scheduled backup")
+		if _, err := s.jobService.Enqueue(JobTypeCreateBackup, struct{}{}); err != nil {
+			s.logger.Error("Failed to queue scheduled backup: %v", err)
+		}
+		return
+	}
+
+	s.logger.Info("Running scheduled backup")
+	if _, err := s.CreateBackup(); err != nil {
+		s.logger.Error("Scheduled backup failed: %v", err)
+	} else {
+		s.logger.Info("Scheduled backup completed successfully")
+	}
+}
+
 // StopScheduler stops the backup scheduler
 func (s *BackupService) StopScheduler() {
 	if s.cron != nil {
@@ -84,19 +127,33 @@ func (s *BackupService) StopScheduler() {
 	}
 }
 
-// CreateBackup creates a backup of the database
-func (s *BackupService) CreateBackup() error {
+// Reschedule replaces the current backup schedule. An empty expression disables automatic backups.
+func (s *BackupService) Reschedule(cronExpr string) error {
+	if _, err := cron.ParseStandard(cronExpr); cronExpr != "" && err != nil {
+		return fmt.Errorf("invalid cron expression: %w", err)
+	}
+
+	s.StopScheduler()
+	s.cron = cron.New()
+	return s.StartScheduler(cronExpr)
+}
+
+// CreateBackup creates a backup of the database and returns its filename
+func (s *BackupService) CreateBackup() (string, error) {
+	if DatabaseURL() != "" {
+		return "", ErrBackupUnsupported
+	}
 	s.logger.Info("Creating database backup")
 
 	// Generate backup filename with timestamp
 	timestamp := time.Now().Format("2006-01-02_150405")
-	backupFilename := fmt.Sprintf("simple-invoice-backup-%s.tar.gz", timestamp)
+	backupFilename := backupFilePrefix + timestamp + backupFileSuffix
 	backupPath := filepath.Join(s.backupDir, backupFilename)
 
 	// Create the tar.gz file
 	file, err := os.Create(backupPath)
 	if err != nil {
-		return fmt.Errorf("failed to create backup file: %w", err)
+		return "", fmt.Errorf("failed to create backup file: %w", err)
 	}
 	defer file.Close()
 
@@ -118,13 +175,13 @@ func (s *BackupService) CreateBackup() error {
 		// Try with the old name
 		dbPath = filepath.Join(s.dataDir, "simple-invoice.db")
 		if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-			return fmt.Errorf("database file not found")
+			return "", fmt.Errorf("database file not found")
 		}
 	}
 
 	s.logger.Debug("Adding database file to backup: %s", dbPath)
 	if err := addFileToTar(tarWriter, dbPath, "database.db"); err != nil {
-		return fmt.Errorf("failed to add database file to backup: %w", err)
+		return "", fmt.Errorf("failed to add database file to backup: %w", err)
 	}
 
 	// Add images directory to the archive if it exists
@@ -146,7 +203,7 @@ func (s *BackupService) CreateBackup() error {
 	}
 
 	s.logger.Info("Backup created successfully: %s", backupFilename)
-	return nil
+	return backupFilename, nil
 }
 
 // ListBackups returns a list of available backups
@@ -161,7 +218,7 @@ func (s *BackupService) ListBackups() ([]BackupInfo, error) {
 	var backups []BackupInfo
 
 	for _, file := range files {
-		if file.IsDir() || !strings.HasPrefix(file.Name(), "simple-invoice-backup-") || !strings.HasSuffix(file.Name(), ".tar.gz") {
+		if file.IsDir() || !isBackupFilename(file.Name()) {
 			continue
 		}
 
@@ -189,13 +246,14 @@ func (s *BackupService) ListBackups() ([]BackupInfo, error) {
 
 // RestoreBackup restores the database from a backup file
 func (s *BackupService) RestoreBackup(backupFilename string) error {
+	if DatabaseURL() != "" {
+		return ErrBackupUnsupported
+	}
 	s.logger.Info("Restoring database from backup: %s", backupFilename)
 
-	backupPath := filepath.Join(s.backupDir, backupFilename)
-
-	// Check if backup file exists
-	if _, err := os.Stat(backupPath); err != nil {
-		return fmt.Errorf("backup file not found: %w", err)
+	backupPath, err := s.backupPath(backupFilename)
+	if err != nil {
+		return err
 	}
 
 	// Create a temporary directory for extraction
@@ -237,6 +295,11 @@ func (s *BackupService) RestoreBackup(backupFilename string) error {
 			continue
 		}
 
+		// Refuse entries that would be extracted outside the temporary directory
+		if !filepath.IsLocal(header.Name) {
+			return fmt.Errorf("invalid path in backup: %s", header.Name)
+		}
+
 		// Create directory for file if needed
 		targetPath := filepath.Join(tempDir, header.Name)
 		targetDir := filepath.Dir(targetPath)
@@ -319,6 +382,79 @@ func (s *BackupService) RestoreBackup(backupFilename string) error {
 	return nil
 }
 
+// AddBackup copies a backup archive from outside the backup directory into it,
+// e.g. one brought over from another machine, so it can be restored. It keeps
+// the name of backup files and names other archives after the time they were added.
+// It returns the backup filename.
+func (s *BackupService) AddBackup(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve backup path: %w", err)
+	}
+	name := filepath.Base(absPath)
+	if filepath.Dir(absPath) == s.backupDir && isBackupFilename(name) {
+		return name, nil
+	}
+
+	info, err := os.Stat(absPath)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && !info.Mode().IsRegular()) {
+		return "", fmt.Errorf("%w: %s", ErrBackupNotFound, path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read backup file: %w", err)
+	}
+
+	if !isBackupFilename(name) {
+		name = backupFilePrefix + time.Now().Format("2006-01-02_150405") + "-added" + backupFileSuffix
+	}
+	target := filepath.Join(s.backupDir, name)
+	if _, err := os.Stat(target); err == nil {
+		return "", fmt.Errorf("backup %s already exists in %s", name, s.backupDir)
+	}
+	if err := copyFile(absPath, target); err != nil {
+		return "", fmt.Errorf("failed to copy backup: %w", err)
+	}
+
+	s.logger.Info("Added backup %s from %s", name, path)
+	return name, nil
+}
+
+// DeleteBackup removes a backup file
+func (s *BackupService) DeleteBackup(backupFilename string) error {
+	backupPath, err := s.backupPath(backupFilename)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(backupPath); err != nil {
+		return fmt.Errorf("failed to delete backup: %w", err)
+	}
+	s.logger.Info("Deleted backup: %s", backupFilename)
+	return nil
+}
+
+// backupPath returns the path of an existing backup file. The filename must be
+// a plain backup name, so it cannot refer to files outside the backup directory.
+func (s *BackupService) backupPath(backupFilename string) (string, error) {
+	if !isBackupFilename(backupFilename) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidBackupName, backupFilename)
+	}
+	backupPath := filepath.Join(s.backupDir, backupFilename)
+	info, err := os.Stat(backupPath)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && !info.Mode().IsRegular()) {
+		return "", fmt.Errorf("%w: %s", ErrBackupNotFound, backupFilename)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read backup file: %w", err)
+	}
+	return backupPath, nil
+}
+
+// isBackupFilename reports whether name is the plain name of a backup file
+func isBackupFilename(name string) bool {
+	return name == filepath.Base(name) && !strings.ContainsAny(name, `/\`) &&
+		strings.HasPrefix(name, backupFilePrefix) && strings.HasSuffix(name, backupFileSuffix)
+}
+
 // NeedsReopen returns true if the database connection needs to be reopened
 func (s *BackupService) NeedsReopen() bool {
 	return s.needsReopen
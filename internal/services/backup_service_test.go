@@ -0,0 +1,76 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeleteBackupRejectsPathsOutsideBackupDir(t *testing.T) {
+	dbService, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	backupService, err := NewBackupService(dbService.GetDB(), tempDir, NewLogger(INFO))
+	if err != nil {
+		t.Fatalf("Failed to create backup service: %v", err)
+	}
+
+	for _, name := range []string{"../database.db", "simple-invoice-backup-x/../../database.db", "/etc/passwd", "notes.txt"} {
+		if err := backupService.DeleteBackup(name); !errors.Is(err, ErrInvalidBackupName) {
+			t.Errorf("Expected ErrInvalidBackupName for %q, got %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "database.db")); err != nil {
+		t.Errorf("Expected the database to be kept: %v", err)
+	}
+
+	if err := backupService.DeleteBackup("simple-invoice-backup-missing.tar.gz"); !errors.Is(err, ErrBackupNotFound) {
+		t.Errorf("Expected ErrBackupNotFound, got %v", err)
+	}
+
+	name := "simple-invoice-backup-2024-01-01_000000.tar.gz"
+	if err := os.WriteFile(filepath.Join(tempDir, "backups", name), []byte("backup"), 0644); err != nil {
+		t.Fatalf("Failed to write backup: %v", err)
+	}
+	if err := backupService.DeleteBackup(name); err != nil {
+		t.Fatalf("Failed to delete backup: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "backups", name)); !os.IsNotExist(err) {
+		t.Errorf("Expected the backup to be deleted, got %v", err)
+	}
+}
+
+func TestAddBackup(t *testing.T) {
+	dbService, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	backupService, err := NewBackupService(dbService.GetDB(), tempDir, NewLogger(ERROR))
+	if err != nil {
+		t.Fatalf("Failed to create backup service: %v", err)
+	}
+
+	name, err := backupService.CreateBackup()
+	if err != nil {
+		t.Fatalf("CreateBackup failed: %v", err)
+	}
+	if added, err := backupService.AddBackup(filepath.Join(tempDir, "backups", name)); err != nil || added != name {
+		t.Errorf("Expected a backup in the backup directory to be used as is, got %q (%v)", added, err)
+	}
+
+	outside := filepath.Join(tempDir, "copied.tar.gz")
+	if err := copyFile(filepath.Join(tempDir, "backups", name), outside); err != nil {
+		t.Fatalf("Failed to copy backup: %v", err)
+	}
+	added, err := backupService.AddBackup(outside)
+	if err != nil || !isBackupFilename(added) {
+		t.Fatalf("Expected the archive to be added under a backup name, got %q (%v)", added, err)
+	}
+	if err := backupService.RestoreBackup(added); err != nil {
+		t.Errorf("Failed to restore the added backup: %v", err)
+	}
+
+	if _, err := backupService.AddBackup(filepath.Join(tempDir, "missing.tar.gz")); !errors.Is(err, ErrBackupNotFound) {
+		t.Errorf("Expected ErrBackupNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,743 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// Supported bank statement formats
+const (
+	StatementFormatCSV     = "csv"
+	StatementFormatMT940   = "mt940"
+	StatementFormatCAMT053 = "camt053"
+)
+
+// StatementFormats returns the supported bank statement formats
+func StatementFormats() []string {
+	return []string{StatementFormatCSV, StatementFormatMT940, StatementFormatCAMT053}
+}
+
+// maxStatementSize limits the bank statements that are read into memory
+const maxStatementSize = 10 << 20 // 10 MB
+
+// Statement match statuses
+const (
+	StatementMatched   = "matched"   // One open invoice fits the payment
+	StatementAmbiguous = "ambiguous" // Several open invoices fit, see the candidates
+	StatementUnmatched = "unmatched" // No open invoice fits
+)
+
+// How a payment was matched to an invoice
+const (
+	MatchedByReference = "reference" // The invoice number is in the remittance information
+	MatchedByAmount    = "amount"    // The amount due equals the payment
+	MatchedByClient    = "client"    // Several invoices are due for the amount, one of them to the payer
+)
+
+// StatementInvoice is an open invoice proposed for a payment
+type StatementInvoice struct {
+	InvoiceID     int          `json:"invoice_id"`
+	InvoiceNumber string       `json:"invoice_number"`
+	ClientName    string       `json:"client_name"`
+	IssueDate     time.Time    `json:"issue_date"`
+	DueDate       time.Time    `json:"due_date"`
+	AmountDue     models.Money `json:"amount_due"`
+	Currency      string       `json:"currency"`
+}
+
+// StatementMatch is an incoming payment of a statement and the invoice it
+// probably pays
+type StatementMatch struct {
+	Transaction models.BankTransaction `json:"transaction"`
+	Status      string                 `json:"status"`
+	MatchedBy   string                 `json:"matched_by,omitempty"`
+	Invoice     *StatementInvoice      `json:"invoice,omitempty"`    // Set when matched
+	Candidates  []StatementInvoice     `json:"candidates,omitempty"` // Set when ambiguous
+	Note        string                 `json:"note,omitempty"`       // Why a match needs a closer look
+}
+
+// StatementMatchResult lists the proposed matches of a bank statement. Nothing
+// is marked paid until the matches are confirmed.
+type StatementMatchResult struct {
+	Format    string           `json:"format"`    // Statement format, or gocardless for synced transactions
+	Total     int              `json:"total"`     // Incoming payments
+	Matched   int              `json:"matched"`   // Payments with a proposed invoice
+	Ambiguous int              `json:"ambiguous"` // Payments that fit several invoices
+	Unmatched int              `json:"unmatched"`
+	Debits    int              `json:"debits"` // Outgoing payments, which are skipped
+	Matches   []StatementMatch `json:"matches"`
+}
+
+// MatchBankStatement reads a bank statement in the given format, detected from
+// the content when empty, and matches its incoming payments to open invoices.
+// A payment matches the invoice whose number is in its reference or, failing
+// that, the invoice due for exactly its amount in the same currency. Each
+// invoice is proposed for one payment at most. Nothing is written.
+func (s *ImportService) MatchBankStatement(r io.Reader, format string) (*StatementMatchResult, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxStatementSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bank statement: %w", err)
+	}
+	format, transactions, err := ParseBankStatement(data, format)
+	if err != nil {
+		return nil, err
+	}
+	return s.MatchTransactions(format, transactions)
+}
+
+// MatchTransactions matches the incoming payments among transactions to open
+// invoices like MatchBankStatement. source names where the transactions come
+// from in the result.
+func (s *ImportService) MatchTransactions(source string, transactions []models.BankTransaction) (*StatementMatchResult, error) {
+	open, err := s.openInvoices()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &StatementMatchResult{Format: source, Matches: []StatementMatch{}}
+	for _, tx := range transactions {
+		if !tx.IsCredit() {
+			result.Debits++
+			continue
+		}
+		result.Matches = append(result.Matches, StatementMatch{Transaction: tx, Status: StatementUnmatched})
+	}
+	matchStatement(result.Matches, open)
+
+	for _, match := range result.Matches {
+		result.Total++
+		switch match.Status {
+		case StatementMatched:
+			result.Matched++
+		case StatementAmbiguous:
+			result.Ambiguous++
+		default:
+			result.Unmatched++
+		}
+	}
+
+	s.logger.Info("Matched %s bank transactions: %d payments, %d matched, %d ambiguous, %d unmatched",
+		source, result.Total, result.Matched, result.Ambiguous, result.Unmatched)
+	return result, nil
+}
+
+// openInvoices returns the invoices that are awaiting payment. Drafts have not
+// been sent and pro-forma invoices are not paid themselves.
+func (s *ImportService) openInvoices() ([]StatementInvoice, error) {
+	invoices, err := s.store.GetInvoices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load invoices: %w", err)
+	}
+	clients, err := s.store.GetClients()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load clients: %w", err)
+	}
+	names := make(map[int]string, len(clients))
+	for _, client := range clients {
+		names[client.ID] = client.Name
+	}
+
+	var open []StatementInvoice
+	for _, invoice := range invoices {
+		if invoice.Status == models.InvoiceStatusPaid || invoice.Status == models.InvoiceStatusDraft || invoice.IsProforma() || invoice.AmountDue() <= 0 {
+			continue
+		}
+		open = append(open, StatementInvoice{
+			InvoiceID:     invoice.ID,
+			InvoiceNumber: invoice.InvoiceNumber,
+			ClientName:    names[invoice.ClientID],
+			IssueDate:     invoice.IssueDate,
+			DueDate:       invoice.DueDate,
+			AmountDue:     invoice.AmountDue(),
+			Currency:      invoice.Currency,
+		})
+	}
+	// Older invoices are proposed first when several fit
+	slices.SortStableFunc(open, func(a, b StatementInvoice) int {
+		return cmp.Or(a.DueDate.Compare(b.DueDate), cmp.Compare(a.InvoiceID, b.InvoiceID))
+	})
+	return open, nil
+}
+
+// matchStatement proposes invoices for the payments. References are matched
+// first for all payments, so a payment that names its invoice is not beaten to
+// it by an earlier payment of the same amount.
+func matchStatement(matches []StatementMatch, open []StatementInvoice) {
+	used := make(map[int]bool)
+	propose := func(match *StatementMatch, invoice StatementInvoice, by string) {
+		match.Status = StatementMatched
+		match.MatchedBy = by
+		match.Invoice = &invoice
+		used[invoice.InvoiceID] = true
+		if invoice.AmountDue != match.Transaction.Amount {
+			match.Note = fmt.Sprintf("Paid %s, but %s is due", match.Transaction.Amount, invoice.AmountDue)
+		}
+	}
+	available := func(tx models.BankTransaction, keep func(StatementInvoice) bool) []StatementInvoice {
+		var found []StatementInvoice
+		for _, invoice := range open {
+			if used[invoice.InvoiceID] || !sameCurrency(tx.Currency, invoice.Currency) || !keep(invoice) {
+				continue
+			}
+			found = append(found, invoice)
+		}
+		return found
+	}
+
+	for i := range matches {
+		match := &matches[i]
+		reference := normalizeReference(match.Transaction.Reference)
+		found := available(match.Transaction, func(invoice StatementInvoice) bool {
+			return referenceContains(reference, normalizeReference(invoice.InvoiceNumber))
+		})
+		// A reference naming INV-10 also contains 10, the longer number wins
+		if len(found) > 1 {
+			longest := slices.MaxFunc(found, func(a, b StatementInvoice) int {
+				return cmp.Compare(len(normalizeReference(a.InvoiceNumber)), len(normalizeReference(b.InvoiceNumber)))
+			})
+			found = slices.DeleteFunc(found, func(invoice StatementInvoice) bool {
+				return len(normalizeReference(invoice.InvoiceNumber)) < len(normalizeReference(longest.InvoiceNumber))
+			})
+		}
+		switch len(found) {
+		case 0:
+		case 1:
+			propose(match, found[0], MatchedByReference)
+		default:
+			match.Status = StatementAmbiguous
+			match.Candidates = found
+			match.Note = "The reference names several invoices"
+		}
+	}
+
+	for i := range matches {
+		match := &matches[i]
+		if match.Status != StatementUnmatched {
+			continue
+		}
+		found := available(match.Transaction, func(invoice StatementInvoice) bool {
+			return invoice.AmountDue == match.Transaction.Amount
+		})
+		if len(found) == 1 {
+			propose(match, found[0], MatchedByAmount)
+			continue
+		}
+		if len(found) == 0 {
+			continue
+		}
+		payer := strings.ToLower(match.Transaction.Counterparty)
+		fromPayer := slices.DeleteFunc(slices.Clone(found), func(invoice StatementInvoice) bool {
+			return !namesMatch(payer, strings.ToLower(invoice.ClientName))
+		})
+		if len(fromPayer) == 1 {
+			propose(match, fromPayer[0], MatchedByClient)
+			continue
+		}
+		if len(fromPayer) > 1 {
+			found = fromPayer
+		}
+		match.Status = StatementAmbiguous
+		match.Candidates = found
+		match.Note = fmt.Sprintf("%d invoices are due for this amount", len(match.Candidates))
+	}
+}
+
+// sameCurrency compares currencies; statements without a currency match any
+func sameCurrency(statement, invoice string) bool {
+	return statement == "" || strings.EqualFold(statement, invoice)
+}
+
+// normalizeReference uppercases a reference and drops everything but letters
+// and digits, as banks often strip the dashes of invoice numbers
+func normalizeReference(reference string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, reference)
+}
+
+// referenceContains reports whether the normalized reference contains the
+// normalized invoice number, not as part of a longer number: 2024-1 is not
+// in 2024-10.
+func referenceContains(reference, number string) bool {
+	if len(number) < 3 {
+		return false
+	}
+	for offset := 0; ; {
+		i := strings.Index(reference[offset:], number)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(number)
+		digitBefore := start > 0 && isDigit(reference[start-1]) && isDigit(number[0])
+		digitAfter := end < len(reference) && isDigit(reference[end]) && isDigit(number[len(number)-1])
+		if !digitBefore && !digitAfter {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// namesMatch reports whether the payer on a statement is the client, which
+// banks often shorten or extend with a legal form
+func namesMatch(payer, client string) bool {
+	if payer == "" || client == "" {
+		return false
+	}
+	return strings.Contains(payer, client) || strings.Contains(client, payer)
+}
+
+// ParseBankStatement reads the transactions of a bank statement. The format is
+// detected from the content when empty and returned with the transactions.
+func ParseBankStatement(data []byte, format string) (string, []models.BankTransaction, error) {
+	if format == "" {
+		format = detectStatementFormat(data)
+	}
+	var transactions []models.BankTransaction
+	var err error
+	switch format {
+	case StatementFormatCSV:
+		transactions, err = parseStatementCSV(data)
+	case StatementFormatMT940:
+		transactions, err = parseMT940(data)
+	case StatementFormatCAMT053:
+		transactions, err = parseCAMT053(data)
+	default:
+		return "", nil, fmt.Errorf("unsupported statement format: %s", format)
+	}
+	if err != nil {
+		return format, nil, err
+	}
+	return format, transactions, nil
+}
+
+// detectStatementFormat guesses the format of a statement from its content
+func detectStatementFormat(data []byte) string {
+	switch {
+	case bytes.Contains(data, []byte("BkToCstmrStmt")):
+		return StatementFormatCAMT053
+	case bytes.Contains(data, []byte(":61:")) && bytes.Contains(data, []byte(":20:")):
+		return StatementFormatMT940
+	default:
+		return StatementFormatCSV
+	}
+}
+
+// statementCSVHeaders lists the header names that can hold each field of a CSV
+// bank statement, as exported by common banks. Headers are compared
+// case-insensitively.
+var statementCSVHeaders = map[string][]string{
+	"date":         {"date", "booking date", "booking_date", "value date", "transaction date", "completed date", "started date", "buchungstag", "valutadatum", "datum"},
+	"amount":       {"amount", "transaction amount", "betrag", "umsatz", "montant", "importe"},
+	"credit":       {"credit", "credit amount", "paid in", "money in", "haben"},
+	"debit":        {"debit", "debit amount", "paid out", "money out", "soll"},
+	"currency":     {"currency", "währung", "waehrung", "devise", "divisa"},
+	"counterparty": {"counterparty", "counterparty name", "payer", "payer name", "name", "from", "beguenstigter/zahlungspflichtiger", "auftraggeber", "zahlungspflichtiger"},
+	"iban":         {"iban", "counterparty iban", "payer iban", "account", "kontonummer/iban"},
+	"reference":    {"reference", "payment reference", "description", "details", "remittance information", "purpose", "memo", "verwendungszweck"},
+}
+
+// parseStatementCSV reads a CSV export of bank transactions. The delimiter is
+// a comma, semicolon or tab, whichever the header uses most.
+func parseStatementCSV(data []byte) ([]models.BankTransaction, error) {
+	headerLine, _, _ := bytes.Cut(data, []byte("\n"))
+	delimiter := ','
+	for _, d := range []rune{';', '\t'} {
+		if bytes.Count(headerLine, []byte(string(d))) > bytes.Count(headerLine, []byte(string(delimiter))) {
+			delimiter = d
+		}
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := resolveColumns(header, statementCSVHeaders)
+	if _, ok := columns["date"]; !ok {
+		return nil, errors.New("no column found for the date")
+	}
+	_, hasAmount := columns["amount"]
+	_, hasCredit := columns["credit"]
+	if !hasAmount && !hasCredit {
+		return nil, errors.New("no column found for the amount")
+	}
+
+	var transactions []models.BankTransaction
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if isBlankRecord(record) {
+			continue
+		}
+		value := func(field string) string {
+			i, ok := columns[field]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		date, err := parseImportDate(value("date"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		var amount models.Money
+		if hasAmount && value("amount") != "" {
+			amount, err = parseStatementAmount(value("amount"))
+		} else if value("credit") != "" {
+			amount, err = parseStatementAmount(value("credit"))
+		} else if value("debit") != "" {
+			amount, err = parseStatementAmount(value("debit"))
+			if amount > 0 {
+				amount = -amount
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		transactions = append(transactions, models.BankTransaction{
+			Date:         date,
+			Amount:       amount,
+			Currency:     strings.ToUpper(value("currency")),
+			Counterparty: value("counterparty"),
+			IBAN:         strings.ReplaceAll(value("iban"), " ", ""),
+			Reference:    value("reference"),
+		})
+	}
+	return transactions, nil
+}
+
+// parseStatementAmount parses an amount that may use a decimal comma, such as
+// "1.234,50" or "-99,00", as well as the forms parseImportAmount accepts
+func parseStatementAmount(value string) (models.Money, error) {
+	value = strings.TrimSpace(value)
+	// Some banks put the sign of debits after the amount
+	if strings.HasSuffix(value, "-") {
+		value = "-" + strings.TrimSuffix(value, "-")
+	}
+	lastComma, lastDot := strings.LastIndex(value, ","), strings.LastIndex(value, ".")
+	// A single comma followed by three digits is a thousands separator
+	if lastComma > lastDot && (lastDot >= 0 || len(value)-lastComma-1 != 3) {
+		value = strings.ReplaceAll(value, ".", "")
+		value = strings.Replace(value, ",", ".", 1)
+	}
+	return parseImportAmount(value)
+}
+
+// mt940Line matches the statement line of a transaction: the value date, the
+// optional entry date, the debit or credit mark with an optional funds code,
+// and the amount with a decimal comma
+var mt940Line = regexp.MustCompile(`^(\d{6})(\d{4})?(RC|RD|C|D)[A-Z]?(\d+,\d*)`)
+
+// mt940Subfield matches the numbered subfields of structured :86: details
+var mt940Subfield = regexp.MustCompile(`\?(\d{2})`)
+
+// mt940Code matches the codes of :86: details in the SWIFT style, such as
+// /EREF/ or /REMI/
+var mt940Code = regexp.MustCompile(`/(EREF|MREF|CRED|CNTP|REMI|NAME|IBAN|PURP|ULTC|ULTD|BENM|ORDP|RTRN|ISDT|CSID)/`)
+
+// parseMT940 reads a SWIFT MT940 statement. The currency comes from the opening
+// balance, the counterparty and reference from the :86: details that follow
+// each :61: statement line.
+func parseMT940(data []byte) ([]models.BankTransaction, error) {
+	var transactions []models.BankTransaction
+	var currency string
+	var tag, content string
+
+	flush := func() error {
+		switch tag {
+		case "60F", "60M":
+			// D/C mark, YYMMDD date, currency, amount
+			if len(content) >= 10 {
+				currency = content[7:10]
+			}
+		case "61":
+			m := mt940Line.FindStringSubmatch(content)
+			if m == nil {
+				return fmt.Errorf("unrecognized statement line :61:%s", content)
+			}
+			date, err := time.Parse("060102", m[1])
+			if err != nil {
+				return fmt.Errorf("invalid date in statement line :61:%s", content)
+			}
+			amount, err := models.ParseMoney(strings.Replace(m[4], ",", ".", 1))
+			if err != nil {
+				return fmt.Errorf("invalid amount in statement line :61:%s", content)
+			}
+			// Debits and reversed credits take money out of the account
+			if m[3] == "D" || m[3] == "RC" {
+				amount = -amount
+			}
+			transactions = append(transactions, models.BankTransaction{Date: date, Amount: amount, Currency: currency})
+		case "86":
+			if len(transactions) > 0 {
+				tx := &transactions[len(transactions)-1]
+				tx.Counterparty, tx.IBAN, tx.Reference = parseMT940Details(content)
+			}
+		}
+		tag, content = "", ""
+		return nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxStatementSize)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if next, rest, ok := cutMT940Tag(line); ok {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			tag, content = next, rest
+			continue
+		}
+		if strings.HasPrefix(line, "-}") || strings.HasPrefix(line, "{") || line == "-" {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// Fields are wrapped at 65 characters, wherever that falls
+		content += line
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read MT940 statement: %w", err)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	if transactions == nil && currency == "" {
+		return nil, errors.New("no MT940 statement found")
+	}
+	return transactions, nil
+}
+
+// cutMT940Tag splits a line such as ":61:2401150115C100,00NTRF" into its tag
+// and content
+func cutMT940Tag(line string) (tag, content string, ok bool) {
+	if !strings.HasPrefix(line, ":") {
+		return "", "", false
+	}
+	tag, content, ok = strings.Cut(line[1:], ":")
+	if !ok || len(tag) < 2 || len(tag) > 3 || !isDigit(tag[0]) || !isDigit(tag[1]) {
+		return "", "", false
+	}
+	return tag, content, true
+}
+
+// parseMT940Details reads the payer and the remittance information from the
+// :86: details. German banks structure them in ?NN subfields, others use
+// /NAME/ and /REMI/ codes; anything else is taken as the reference.
+func parseMT940Details(details string) (counterparty, iban, reference string) {
+	if locs := mt940Subfield.FindAllStringSubmatchIndex(details, -1); len(locs) > 0 {
+		var purpose, name []string
+		for i, loc := range locs {
+			end := len(details)
+			if i+1 < len(locs) {
+				end = locs[i+1][0]
+			}
+			value := details[loc[1]:end]
+			switch code := details[loc[2]:loc[3]]; {
+			case code >= "20" && code <= "29", code >= "60" && code <= "63":
+				purpose = append(purpose, value)
+			case code == "31":
+				iban = value
+			case code == "32" || code == "33":
+				name = append(name, value)
+			}
+		}
+		reference = strings.Join(purpose, "")
+		// SEPA purpose codes prefix the remittance information
+		if i := strings.Index(reference, "SVWZ+"); i >= 0 {
+			reference = reference[i+len("SVWZ+"):]
+		}
+		return strings.TrimSpace(strings.Join(name, "")), iban, strings.TrimSpace(reference)
+	}
+
+	if locs := mt940Code.FindAllStringSubmatchIndex(details, -1); len(locs) > 0 {
+		for i, loc := range locs {
+			end := len(details)
+			if i+1 < len(locs) {
+				end = locs[i+1][0]
+			}
+			value := strings.Trim(details[loc[1]:end], "/ ")
+			switch details[loc[2]:loc[3]] {
+			case "REMI":
+				// Unstructured remittance information is prefixed by USTD//
+				reference = strings.TrimPrefix(strings.TrimPrefix(value, "USTD"), "//")
+			case "NAME":
+				counterparty = value
+			case "IBAN":
+				iban = value
+			case "CNTP":
+				// Account, BIC, name and city of the counterparty
+				parts := strings.Split(value, "/")
+				iban = parts[0]
+				if len(parts) > 2 {
+					counterparty = parts[2]
+				}
+			}
+		}
+		return counterparty, iban, reference
+	}
+	return "", "", strings.TrimSpace(details)
+}
+
+// camtDocument is the part of an ISO 20022 camt.053 bank to customer statement
+// that is needed to match payments. The elements are matched regardless of the
+// namespace, which changes with every version of the message.
+type camtDocument struct {
+	Statements []struct {
+		Entries []camtEntry `xml:"Ntry"`
+	} `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtEntry struct {
+	Amount        camtAmount `xml:"Amt"`
+	CreditDebit   string     `xml:"CdtDbtInd"`
+	Reversal      bool       `xml:"RvslInd"`
+	BookingDate   camtDate   `xml:"BookgDt"`
+	ValueDate     camtDate   `xml:"ValDt"`
+	Details       []camtTx   `xml:"NtryDtls>TxDtls"`
+	AdditionalInf string     `xml:"AddtlNtryInf"`
+}
+
+type camtAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+type camtTx struct {
+	Amount       camtAmount `xml:"Amt"`
+	TxAmount     camtAmount `xml:"AmtDtls>TxAmt>Amt"`
+	DebtorName   string     `xml:"RltdPties>Dbtr>Nm"`
+	DebtorPty    string     `xml:"RltdPties>Dbtr>Pty>Nm"`
+	DebtorIBAN   string     `xml:"RltdPties>DbtrAcct>Id>IBAN"`
+	Unstructured []string   `xml:"RmtInf>Ustrd"`
+	CreditorRefs []string   `xml:"RmtInf>Strd>CdtrRefInf>Ref"`
+	EndToEndID   string     `xml:"Refs>EndToEndId"`
+}
+
+// time returns the date of a camt.053 date element
+func (d camtDate) time() (time.Time, bool) {
+	if d.Date != "" {
+		t, err := time.Parse("2006-01-02", strings.TrimSpace(d.Date))
+		return t, err == nil
+	}
+	if d.DateTime != "" {
+		value := strings.TrimSpace(d.DateTime)
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05"} {
+			if t, err := time.Parse(layout, value); err == nil {
+				return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseCAMT053 reads an ISO 20022 camt.053 statement. Batch entries that list
+// the amount of each transaction become one transaction each.
+func parseCAMT053(data []byte) ([]models.BankTransaction, error) {
+	var doc camtDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to read camt.053 statement: %w", err)
+	}
+	if len(doc.Statements) == 0 {
+		return nil, errors.New("no camt.053 statement found")
+	}
+
+	var transactions []models.BankTransaction
+	for _, stmt := range doc.Statements {
+		for _, entry := range stmt.Entries {
+			date, ok := entry.BookingDate.time()
+			if !ok {
+				if date, ok = entry.ValueDate.time(); !ok {
+					return nil, fmt.Errorf("entry of %s %s has no booking date", entry.Amount.Value, entry.Amount.Currency)
+				}
+			}
+			// Debits and reversed credits take money out of the account
+			debit := (entry.CreditDebit == "DBIT") != entry.Reversal
+
+			details := entry.Details
+			split := len(details) > 1
+			for _, tx := range details {
+				split = split && cmp.Or(tx.Amount.Value, tx.TxAmount.Value) != ""
+			}
+			if !split {
+				tx := camtTx{}
+				if len(details) == 1 {
+					tx = details[0]
+				}
+				tx.Amount, tx.TxAmount = entry.Amount, camtAmount{}
+				details = []camtTx{tx}
+			}
+
+			for _, tx := range details {
+				amt := tx.Amount
+				if amt.Value == "" {
+					amt = tx.TxAmount
+				}
+				amount, err := models.ParseMoney(strings.TrimSpace(amt.Value))
+				if err != nil {
+					return nil, fmt.Errorf("invalid amount %q in camt.053 entry", amt.Value)
+				}
+				if debit {
+					amount = -amount
+				}
+				reference := strings.Join(slices.Concat(tx.CreditorRefs, tx.Unstructured), " ")
+				if reference == "" {
+					reference = cmp.Or(entry.AdditionalInf, strings.TrimPrefix(tx.EndToEndID, "NOTPROVIDED"))
+				}
+				transactions = append(transactions, models.BankTransaction{
+					Date:         date,
+					Amount:       amount,
+					Currency:     cmp.Or(amt.Currency, entry.Amount.Currency),
+					Counterparty: strings.TrimSpace(cmp.Or(tx.DebtorName, tx.DebtorPty)),
+					IBAN:         strings.TrimSpace(tx.DebtorIBAN),
+					Reference:    strings.TrimSpace(reference),
+				})
+			}
+		}
+	}
+	return transactions, nil
+}
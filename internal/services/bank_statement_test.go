@@ -0,0 +1,144 @@
+package services
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestMatchBankStatement(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := dbService.SaveBusiness(&models.Business{Name: "My Business", Country: "DE"}); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	clients := map[string]*models.Client{}
+	for _, name := range []string{"Acme GmbH", "Beta BV", "Gamma Ltd"} {
+		client := &models.Client{Name: name, Country: "DE"}
+		if err := dbService.SaveClient(client); err != nil {
+			t.Fatalf("Failed to save client: %v", err)
+		}
+		clients[name] = client
+	}
+
+	invoices := map[string]*models.Invoice{}
+	for _, tt := range []struct {
+		number, client, status string
+		total                  float64
+	}{
+		{"INV-2024-0001", "Acme GmbH", "sent", 571.20},
+		{"INV-2024-0002", "Beta BV", "sent", 250},
+		{"INV-2024-0003", "Gamma Ltd", "sent", 500},
+		{"INV-2024-0004", "Acme GmbH", "sent", 500},
+		{"INV-2024-0005", "Acme GmbH", "paid", 250},
+		{"INV-2024-0006", "Beta BV", "draft", 250},
+	} {
+		invoice := &models.Invoice{
+			InvoiceNumber: tt.number, BusinessID: 1, ClientID: clients[tt.client].ID, Currency: "EUR", Status: tt.status,
+			IssueDate: time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC), DueDate: time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC),
+			TotalAmount: models.NewMoney(tt.total), Type: models.InvoiceTypeInvoice,
+		}
+		items := []models.InvoiceItem{{Description: "Work", Quantity: 1, Unit: models.UnitFlat, UnitPrice: invoice.TotalAmount, Amount: invoice.TotalAmount}}
+		if err := dbService.SaveImportedInvoice(invoice, items); err != nil {
+			t.Fatalf("Failed to save invoice %s: %v", tt.number, err)
+		}
+		invoices[tt.number] = invoice
+	}
+
+	importService := NewImportService(dbService, NewLogger(ERROR))
+
+	tests := []struct {
+		file   string
+		format string
+		want   []string // Matched invoice per payment, "" when not matched
+		by     []string
+	}{
+		{"statement.sta", StatementFormatMT940, []string{"INV-2024-0001", "INV-2024-0002"}, []string{MatchedByReference, MatchedByAmount}},
+		{"statement.xml", StatementFormatCAMT053, []string{"INV-2024-0001", "INV-2024-0002", "INV-2024-0003"}, []string{MatchedByReference, MatchedByAmount, MatchedByClient}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			file, err := os.Open("testdata/bank/" + tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			result, err := importService.MatchBankStatement(file, "")
+			if err != nil {
+				t.Fatalf("MatchBankStatement failed: %v", err)
+			}
+			if result.Format != tt.format || result.Debits != 1 || result.Total != len(tt.want) {
+				t.Fatalf("unexpected result: %+v", result)
+			}
+			for i, match := range result.Matches {
+				if match.Status != StatementMatched || match.Invoice.InvoiceNumber != tt.want[i] || match.MatchedBy != tt.by[i] {
+					t.Errorf("payment %d: got %s %+v by %s, want %s by %s", i, match.Status, match.Invoice, match.MatchedBy, tt.want[i], tt.by[i])
+				}
+			}
+			first := result.Matches[0].Transaction
+			if first.Counterparty != "Acme GmbH" && first.Counterparty != "ACME GMBH" || first.IBAN != "DE89370400440532013000" || first.Date.Format("2006-01-02") != "2024-12-02" {
+				t.Errorf("unexpected first transaction: %+v", first)
+			}
+		})
+	}
+
+	// Without a client named as the payer, two invoices fit 500.00
+	csvData := "Date;Amount;Currency;Payer;Reference\n2024-12-05;500,00;EUR;Unknown;Thanks\n2024-12-06;1.000,00;EUR;Acme;INV-2024-0005\n"
+	result, err := importService.MatchBankStatement(strings.NewReader(csvData), StatementFormatCSV)
+	if err != nil {
+		t.Fatalf("MatchBankStatement failed: %v", err)
+	}
+	if result.Ambiguous != 1 || len(result.Matches[0].Candidates) != 2 {
+		t.Errorf("expected the 500.00 payment to be ambiguous between two invoices, got %+v", result.Matches[0])
+	}
+	// Paid invoices are not proposed again
+	if result.Matches[1].Status != StatementUnmatched || result.Matches[1].Transaction.Amount != models.NewMoney(1000) {
+		t.Errorf("expected the payment of a paid invoice to be unmatched, got %+v", result.Matches[1])
+	}
+}
+
+func TestParseStatementAmount(t *testing.T) {
+	tests := []struct {
+		value string
+		want  models.Money
+	}{
+		{"1234.50", 123450},
+		{"1,234.50", 123450},
+		{"1.234,50", 123450},
+		{"99,9", 9990},
+		{"-45,99", -4599},
+		{"45,99-", -4599},
+		{"1,234", 123400},
+		{"€ 12,00", 1200},
+	}
+	for _, tt := range tests {
+		got, err := parseStatementAmount(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("parseStatementAmount(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestReferenceContains(t *testing.T) {
+	tests := []struct {
+		reference, number string
+		want              bool
+	}{
+		{"Rechnung INV-2024-0001 danke", "INV-2024-0001", true},
+		{"inv20240001", "INV-2024-0001", true},
+		{"Invoice 2024-10", "2024-1", false},
+		{"Invoice 12024-1", "2024-1", false},
+		{"Invoices 2024-10 and 2024-1", "2024-1", true},
+		{"RE 17", "17", false},
+	}
+	for _, tt := range tests {
+		if got := referenceContains(normalizeReference(tt.reference), normalizeReference(tt.number)); got != tt.want {
+			t.Errorf("referenceContains(%q, %q) = %t, want %t", tt.reference, tt.number, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,526 @@
+package services
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// ErrBankSyncNotConfigured is returned when banks are connected or synced
+// without GoCardless credentials
+var ErrBankSyncNotConfigured = errors.New("set the GoCardless secret ID and key on the settings page to connect a bank")
+
+// BankSyncSource names synced transactions in match results
+const BankSyncSource = "gocardless"
+
+// bankSyncLookback is how far back transactions are fetched the first time,
+// and how long unreconciled payments are offered for reconciliation. Banks
+// give at least 90 days of history.
+const bankSyncLookback = 90 * 24 * time.Hour
+
+// bankSyncOverlap is fetched again on every sync, as banks book transactions
+// a few days after their value date
+const bankSyncOverlap = 7 * 24 * time.Hour
+
+// BankInstitution is a bank that can be connected
+type BankInstitution struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	BIC  string `json:"bic"`
+	Logo string `json:"logo"`
+}
+
+// BankSyncResult summarizes a sync of the connected banks
+type BankSyncResult struct {
+	Connections int `json:"connections"` // Linked connections that were synced
+	Fetched     int `json:"fetched"`     // Incoming payments returned by the banks
+	New         int `json:"new"`         // Incoming payments not seen before
+	Failed      int `json:"failed"`      // Connections that could not be synced, see their last_error
+}
+
+// BankSyncService syncs the incoming payments of bank accounts through the
+// GoCardless Bank Account Data API, so they can be matched to open invoices
+// without uploading statements
+type BankSyncService struct {
+	dbService       *DBService
+	settingsService *SettingsService
+	logger          *Logger
+	client          *http.Client
+	stop            chan struct{}
+	done            chan struct{}
+}
+
+// NewBankSyncService creates a new BankSyncService
+func NewBankSyncService(dbService *DBService, settingsService *SettingsService, logger *Logger) *BankSyncService {
+	return &BankSyncService{
+		dbService:       dbService,
+		settingsService: settingsService,
+		logger:          logger,
+		client:          &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Configured reports whether GoCardless credentials are set
+func (s *BankSyncService) Configured() bool {
+	return s.settingsService.GetString(SettingBankSyncSecretID) != "" &&
+		s.settingsService.GetString(SettingBankSyncSecretKey) != "" &&
+		s.settingsService.GetString(SettingBankSyncURL) != ""
+}
+
+// Start syncs the connected banks periodically while GoCardless is
+// configured. The interval is read from the settings before every wait.
+func (s *BankSyncService) Start() {
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		for {
+			interval := time.Duration(max(s.settingsService.GetInt(SettingBankSyncInterval), 1)) * time.Hour
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(interval):
+			}
+
+			RunProtected(s.logger, "bank sync", func() {
+				if !s.Configured() {
+					return
+				}
+				if result, err := s.Sync(); err != nil {
+					s.logger.Error("Failed to sync bank transactions: %v", err)
+				} else if result.New > 0 {
+					s.logger.Info("Synced %d new incoming payments from %d bank connections", result.New, result.Connections)
+				}
+			})
+		}
+	}()
+}
+
+// Stop stops the syncing started by Start
+func (s *BankSyncService) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
+
+// gocardlessError is the body of GoCardless error responses
+type gocardlessError struct {
+	Summary string `json:"summary"`
+	Detail  string `json:"detail"`
+}
+
+// request calls the GoCardless API with an access token and decodes the JSON
+// response into out. GoCardless paths end with a slash.
+func (s *BankSyncService) request(method, path string, body, out interface{}) error {
+	token, err := s.accessToken()
+	if err != nil {
+		return err
+	}
+	return s.call(method, path, token, body, out)
+}
+
+// call sends a request to the GoCardless API, authenticated with token
+// unless it is empty
+func (s *BankSyncService) call(method, path, token string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(s.settingsService.GetString(SettingBankSyncURL), "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("GoCardless could not be reached: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr gocardlessError
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr)
+		if message := cmp.Or(apiErr.Detail, apiErr.Summary); message != "" {
+			return fmt.Errorf("GoCardless: %s (%s)", message, resp.Status)
+		}
+		return fmt.Errorf("GoCardless: %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to parse GoCardless response: %w", err)
+	}
+	return nil
+}
+
+// accessToken requests an access token with the secret ID and key. Tokens are
+// valid for a day, longer than any sync takes.
+func (s *BankSyncService) accessToken() (string, error) {
+	if !s.Configured() {
+		return "", ErrBankSyncNotConfigured
+	}
+	var token struct {
+		Access string `json:"access"`
+	}
+	err := s.call(http.MethodPost, "/api/v2/token/new/", "", map[string]string{
+		"secret_id":  s.settingsService.GetString(SettingBankSyncSecretID),
+		"secret_key": s.settingsService.GetString(SettingBankSyncSecretKey),
+	}, &token)
+	if err != nil {
+		return "", err
+	}
+	return token.Access, nil
+}
+
+// Institutions returns the banks in a country that can be connected
+func (s *BankSyncService) Institutions(country string) ([]BankInstitution, error) {
+	institutions := []BankInstitution{}
+	err := s.request(http.MethodGet, "/api/v2/institutions/?country="+url.QueryEscape(strings.ToLower(country)), nil, &institutions)
+	return institutions, err
+}
+
+// gocardlessRequisition is a request for access to the accounts at a bank
+type gocardlessRequisition struct {
+	ID            string   `json:"id"`
+	Status        string   `json:"status"`
+	InstitutionID string   `json:"institution_id"`
+	Accounts      []string `json:"accounts"`
+	Link          string   `json:"link"`
+}
+
+// connectionStatus maps the status of a requisition to a connection status
+func connectionStatus(requisitionStatus string) string {
+	switch requisitionStatus {
+	case "LN":
+		return models.BankConnectionLinked
+	case "EX", "SU":
+		return models.BankConnectionExpired
+	case "RJ":
+		return models.BankConnectionRejected
+	default:
+		return models.BankConnectionPending
+	}
+}
+
+// Connect requests access to the accounts at a bank. The account holder grants
+// it at the link of the returned connection, after which the bank redirects to
+// redirectURL and the connection is linked on the next sync.
+func (s *BankSyncService) Connect(institutionID, redirectURL string) (*models.BankConnection, error) {
+	var institution BankInstitution
+	if err := s.request(http.MethodGet, "/api/v2/institutions/"+url.PathEscape(institutionID)+"/", nil, &institution); err != nil {
+		return nil, err
+	}
+
+	reference := make([]byte, 16)
+	rand.Read(reference)
+	var requisition gocardlessRequisition
+	err := s.request(http.MethodPost, "/api/v2/requisitions/", map[string]string{
+		"institution_id": institutionID,
+		"redirect":       redirectURL,
+		"reference":      hex.EncodeToString(reference),
+	}, &requisition)
+	if err != nil {
+		return nil, err
+	}
+
+	connection := &models.BankConnection{
+		RequisitionID:   requisition.ID,
+		InstitutionID:   institutionID,
+		InstitutionName: institution.Name,
+		Status:          connectionStatus(requisition.Status),
+		Link:            requisition.Link,
+		AccountIDs:      requisition.Accounts,
+		CreatedAt:       time.Now().UTC(),
+	}
+	err = s.dbService.GetDB().QueryRow(`
+		INSERT INTO bank_connections (requisition_id, institution_id, institution_name, status, link, account_ids, created_at, last_synced_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, connection.RequisitionID, connection.InstitutionID, connection.InstitutionName, connection.Status, connection.Link,
+		strings.Join(connection.AccountIDs, ","), connection.CreatedAt, time.Time{}).Scan(&connection.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save bank connection: %w", err)
+	}
+	s.logger.Info("Requested access to the accounts at %s", institution.Name)
+	return connection, nil
+}
+
+// Connections returns the connected banks, the latest first
+func (s *BankSyncService) Connections() ([]models.BankConnection, error) {
+	rows, err := s.dbService.GetDB().Query(`
+		SELECT id, requisition_id, institution_id, institution_name, status, link, account_ids, created_at, last_synced_at, last_error
+		FROM bank_connections ORDER BY id DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query bank connections: %w", err)
+	}
+	defer rows.Close()
+
+	connections := []models.BankConnection{}
+	for rows.Next() {
+		var connection models.BankConnection
+		var accounts string
+		if err := rows.Scan(&connection.ID, &connection.RequisitionID, &connection.InstitutionID, &connection.InstitutionName,
+			&connection.Status, &connection.Link, &accounts, &connection.CreatedAt, &connection.LastSyncedAt, &connection.LastError); err != nil {
+			return nil, fmt.Errorf("failed to read bank connection: %w", err)
+		}
+		connection.AccountIDs = []string{}
+		if accounts != "" {
+			connection.AccountIDs = strings.Split(accounts, ",")
+		}
+		if connection.Status != models.BankConnectionPending {
+			connection.Link = ""
+		}
+		connections = append(connections, connection)
+	}
+	return connections, rows.Err()
+}
+
+// Disconnect revokes the access to a bank and removes the connection with
+// its synced payments. Invoices reconciled with them stay paid.
+func (s *BankSyncService) Disconnect(id int) error {
+	var requisitionID string
+	err := s.dbService.GetDB().QueryRow(`SELECT requisition_id FROM bank_connections WHERE id = ?`, id).Scan(&requisitionID)
+	if err != nil {
+		return err
+	}
+	// The access may have been revoked at GoCardless already
+	if err := s.request(http.MethodDelete, "/api/v2/requisitions/"+url.PathEscape(requisitionID)+"/", nil, nil); err != nil {
+		s.logger.Warn("Failed to revoke access of bank connection %d: %v", id, err)
+	}
+
+	tx, err := s.dbService.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM bank_transactions WHERE connection_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete synced payments: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM bank_connections WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete bank connection: %w", err)
+	}
+	return tx.Commit()
+}
+
+// gocardlessTransaction is a booked transaction of an account
+type gocardlessTransaction struct {
+	TransactionID         string `json:"transactionId"`
+	InternalTransactionID string `json:"internalTransactionId"`
+	BookingDate           string `json:"bookingDate"`
+	ValueDate             string `json:"valueDate"`
+	TransactionAmount     struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	} `json:"transactionAmount"`
+	DebtorName    string `json:"debtorName"`
+	DebtorAccount struct {
+		IBAN string `json:"iban"`
+	} `json:"debtorAccount"`
+	RemittanceInformationUnstructured      string   `json:"remittanceInformationUnstructured"`
+	RemittanceInformationUnstructuredArray []string `json:"remittanceInformationUnstructuredArray"`
+	RemittanceInformationStructured        string   `json:"remittanceInformationStructured"`
+	EndToEndID                             string   `json:"endToEndId"`
+}
+
+// Sync updates the status of the connected banks and stores the incoming
+// payments booked on their accounts since the last sync. A connection that
+// fails is skipped with its error recorded, so one bank does not hold up the
+// others.
+func (s *BankSyncService) Sync() (*BankSyncResult, error) {
+	token, err := s.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	connections, err := s.Connections()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BankSyncResult{}
+	for _, connection := range connections {
+		if connection.Status == models.BankConnectionExpired || connection.Status == models.BankConnectionRejected {
+			continue
+		}
+		fetched, added, err := s.syncConnection(token, connection)
+		lastError := ""
+		if err != nil {
+			s.logger.Error("Failed to sync bank connection %d (%s): %v", connection.ID, connection.InstitutionName, err)
+			lastError = err.Error()
+			result.Failed++
+		}
+		if _, err := s.dbService.GetDB().Exec(`UPDATE bank_connections SET last_error = ? WHERE id = ?`, lastError, connection.ID); err != nil {
+			return nil, fmt.Errorf("failed to update bank connection: %w", err)
+		}
+		if fetched >= 0 {
+			result.Connections++
+			result.Fetched += fetched
+			result.New += added
+		}
+	}
+	return result, nil
+}
+
+// syncConnection syncs one bank and returns the number of incoming payments
+// fetched and added, or -1 fetched when the connection is not linked
+func (s *BankSyncService) syncConnection(token string, connection models.BankConnection) (int, int, error) {
+	var requisition gocardlessRequisition
+	if err := s.call(http.MethodGet, "/api/v2/requisitions/"+url.PathEscape(connection.RequisitionID)+"/", token, nil, &requisition); err != nil {
+		return -1, 0, err
+	}
+	status := connectionStatus(requisition.Status)
+	if _, err := s.dbService.GetDB().Exec(`UPDATE bank_connections SET status = ?, account_ids = ? WHERE id = ?`,
+		status, strings.Join(requisition.Accounts, ","), connection.ID); err != nil {
+		return -1, 0, fmt.Errorf("failed to update bank connection: %w", err)
+	}
+	if status != models.BankConnectionLinked {
+		return -1, 0, nil
+	}
+
+	from := time.Now().Add(-bankSyncLookback)
+	if !connection.LastSyncedAt.IsZero() && connection.LastSyncedAt.Add(-bankSyncOverlap).After(from) {
+		from = connection.LastSyncedAt.Add(-bankSyncOverlap)
+	}
+	started := time.Now().UTC()
+
+	fetched, added := 0, 0
+	for _, account := range requisition.Accounts {
+		var response struct {
+			Transactions struct {
+				Booked []gocardlessTransaction `json:"booked"`
+			} `json:"transactions"`
+		}
+		path := fmt.Sprintf("/api/v2/accounts/%s/transactions/?date_from=%s", url.PathEscape(account), from.Format("2006-01-02"))
+		if err := s.call(http.MethodGet, path, token, nil, &response); err != nil {
+			return fetched, added, err
+		}
+		for _, booked := range response.Transactions.Booked {
+			tx, externalID, err := bankTransactionFromGoCardless(booked)
+			if err != nil {
+				s.logger.Warn("Skipped a transaction of bank connection %d: %v", connection.ID, err)
+				continue
+			}
+			if !tx.IsCredit() {
+				continue
+			}
+			fetched++
+			res, err := s.dbService.GetDB().Exec(`
+				INSERT INTO bank_transactions (connection_id, external_id, date, amount, currency, counterparty, iban, reference, created_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT (connection_id, external_id) DO NOTHING
+			`, connection.ID, account+"/"+externalID, tx.Date.Format("2006-01-02"), tx.Amount, tx.Currency, tx.Counterparty, tx.IBAN, tx.Reference, started)
+			if err != nil {
+				return fetched, added, fmt.Errorf("failed to store transaction: %w", err)
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				added++
+			}
+		}
+	}
+
+	if _, err := s.dbService.GetDB().Exec(`UPDATE bank_connections SET last_synced_at = ? WHERE id = ?`, started, connection.ID); err != nil {
+		return fetched, added, fmt.Errorf("failed to update bank connection: %w", err)
+	}
+	return fetched, added, nil
+}
+
+// bankTransactionFromGoCardless converts a booked transaction and returns the
+// ID that identifies it across syncs
+func bankTransactionFromGoCardless(booked gocardlessTransaction) (models.BankTransaction, string, error) {
+	date, err := time.Parse("2006-01-02", cmp.Or(booked.BookingDate, booked.ValueDate))
+	if err != nil {
+		return models.BankTransaction{}, "", fmt.Errorf("invalid booking date %q", booked.BookingDate)
+	}
+	amount, err := models.ParseMoney(booked.TransactionAmount.Amount)
+	if err != nil {
+		return models.BankTransaction{}, "", fmt.Errorf("invalid amount %q", booked.TransactionAmount.Amount)
+	}
+	reference := cmp.Or(booked.RemittanceInformationUnstructured, strings.Join(booked.RemittanceInformationUnstructuredArray, " "),
+		booked.RemittanceInformationStructured)
+	tx := models.BankTransaction{
+		Date:         date,
+		Amount:       amount,
+		Currency:     strings.ToUpper(booked.TransactionAmount.Currency),
+		Counterparty: strings.TrimSpace(booked.DebtorName),
+		IBAN:         booked.DebtorAccount.IBAN,
+		Reference:    strings.TrimSpace(reference),
+	}
+
+	// Not every bank sets a transaction ID; the details identify the rest
+	externalID := cmp.Or(booked.TransactionID, booked.InternalTransactionID)
+	if externalID == "" {
+		externalID = strings.Join([]string{booked.BookingDate, booked.TransactionAmount.Amount, booked.TransactionAmount.Currency,
+			booked.DebtorName, booked.EndToEndID, reference}, "|")
+	}
+	return tx, externalID, nil
+}
+
+// UnreconciledTransactions returns the synced incoming payments of the last 90
+// days that were not reconciled with an invoice, the oldest first
+func (s *BankSyncService) UnreconciledTransactions() ([]models.BankTransaction, error) {
+	rows, err := s.dbService.GetDB().Query(`
+		SELECT id, date, amount, currency, counterparty, iban, reference FROM bank_transactions
+		WHERE invoice_id = 0 AND date >= ? ORDER BY date, id
+	`, time.Now().Add(-bankSyncLookback).Format("2006-01-02"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query synced payments: %w", err)
+	}
+	defer rows.Close()
+
+	var transactions []models.BankTransaction
+	for rows.Next() {
+		var tx models.BankTransaction
+		var date string
+		if err := rows.Scan(&tx.ID, &date, &tx.Amount, &tx.Currency, &tx.Counterparty, &tx.IBAN, &tx.Reference); err != nil {
+			return nil, fmt.Errorf("failed to read synced payment: %w", err)
+		}
+		tx.Date, _ = time.Parse("2006-01-02", date)
+		transactions = append(transactions, tx)
+	}
+	return transactions, rows.Err()
+}
+
+// MarkReconciled records that a synced payment paid an invoice, so it is no
+// longer offered for reconciliation
+func (s *BankSyncService) MarkReconciled(transactionID, invoiceID int) error {
+	res, err := s.dbService.GetDB().Exec(`UPDATE bank_transactions SET invoice_id = ? WHERE id = ?`, invoiceID, transactionID)
+	if err != nil {
+		return fmt.Errorf("failed to reconcile synced payment: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestBankSync(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	today := time.Now().Format("2006-01-02")
+	requisitions := map[string]string{"req-linked": "LN", "req-failing": "LN"}
+	var dateFrom []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/api/v2/token/new/" && r.Header.Get("Authorization") != "Bearer test-token" {
+			http.Error(w, `{"summary": "Authentication failed"}`, http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v2/token/new/":
+			fmt.Fprint(w, `{"access": "test-token"}`)
+		case "/api/v2/institutions/TEST_BANK/":
+			fmt.Fprint(w, `{"id": "TEST_BANK", "name": "Test Bank"}`)
+		case "/api/v2/requisitions/":
+			fmt.Fprint(w, `{"id": "req-linked", "status": "CR", "link": "https://bank.invalid/grant"}`)
+		case "/api/v2/requisitions/req-linked/", "/api/v2/requisitions/req-failing/":
+			id := r.URL.Path[len("/api/v2/requisitions/") : len(r.URL.Path)-1]
+			fmt.Fprintf(w, `{"id": %q, "status": %q, "accounts": [%q]}`, id, requisitions[id], id+"-account")
+		case "/api/v2/accounts/req-linked-account/transactions/":
+			dateFrom = append(dateFrom, r.URL.Query().Get("date_from"))
+			fmt.Fprintf(w, `{"transactions": {"booked": [
+				{"transactionId": "1", "bookingDate": "%[1]s", "transactionAmount": {"amount": "571.20", "currency": "eur"},
+				 "debtorName": "Acme GmbH", "debtorAccount": {"iban": "DE89370400440532013000"}, "remittanceInformationUnstructured": "INV-2024-0001"},
+				{"bookingDate": "%[1]s", "transactionAmount": {"amount": "250.00", "currency": "EUR"}, "debtorName": "Beta BV",
+				 "remittanceInformationUnstructuredArray": ["Invoice", "INV-2024-0002"]},
+				{"transactionId": "3", "bookingDate": "%[1]s", "transactionAmount": {"amount": "-12.00", "currency": "EUR"}, "creditorName": "Bank"},
+				{"transactionId": "4", "bookingDate": "not a date", "transactionAmount": {"amount": "1.00", "currency": "EUR"}}
+			]}}`, today)
+		case "/api/v2/accounts/req-failing-account/transactions/":
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"summary": "Rate limit exceeded", "detail": "Try again in 3600 seconds"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	logger := NewLogger(ERROR)
+	settings := NewSettingsService(dbService, logger)
+	bankSync := NewBankSyncService(dbService, settings, logger)
+	if _, err := bankSync.Sync(); !errors.Is(err, ErrBankSyncNotConfigured) {
+		t.Fatalf("Expected ErrBankSyncNotConfigured without credentials, got %v", err)
+	}
+	for key, value := range map[string]string{SettingBankSyncSecretID: "id", SettingBankSyncSecretKey: "key", SettingBankSyncURL: server.URL + "/"} {
+		if err := settings.Set(key, value); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+
+	connection, err := bankSync.Connect("TEST_BANK", "https://invoices.example.com/invoices/reconcile")
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if connection.Status != models.BankConnectionPending || connection.InstitutionName != "Test Bank" || connection.Link == "" {
+		t.Errorf("Unexpected connection %+v", connection)
+	}
+	if _, err := dbService.GetDB().Exec(`INSERT INTO bank_connections (requisition_id, institution_id, institution_name, status, link, account_ids, created_at, last_synced_at)
+		VALUES ('req-failing', 'TEST_BANK', 'Test Bank', 'linked', '', '', ?, ?)`, time.Now(), time.Time{}); err != nil {
+		t.Fatalf("Failed to add connection: %v", err)
+	}
+
+	// Incoming payments are stored once; outgoing payments are skipped
+	result, err := bankSync.Sync()
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if result.Connections != 2 || result.Fetched != 2 || result.New != 2 || result.Failed != 1 {
+		t.Errorf("Unexpected first sync %+v", result)
+	}
+	if result, err := bankSync.Sync(); err != nil || result.New != 0 || result.Fetched != 2 {
+		t.Errorf("Expected the second sync to add nothing, got %+v (%v)", result, err)
+	}
+	if len(dateFrom) != 2 || dateFrom[0] != time.Now().Add(-bankSyncLookback).Format("2006-01-02") || dateFrom[1] != time.Now().Add(-bankSyncOverlap).Format("2006-01-02") {
+		t.Errorf("Expected 90 days on the first sync and 7 days after, got %v", dateFrom)
+	}
+
+	connections, err := bankSync.Connections()
+	if err != nil {
+		t.Fatalf("Connections failed: %v", err)
+	}
+	for _, c := range connections {
+		switch c.RequisitionID {
+		case "req-linked":
+			if c.Status != models.BankConnectionLinked || c.Link != "" || c.LastError != "" || c.LastSyncedAt.IsZero() || len(c.AccountIDs) != 1 {
+				t.Errorf("Unexpected linked connection %+v", c)
+			}
+		case "req-failing":
+			if c.LastError != "GoCardless: Try again in 3600 seconds (429 Too Many Requests)" {
+				t.Errorf("Unexpected error of the failing connection: %q", c.LastError)
+			}
+		}
+	}
+
+	transactions, err := bankSync.UnreconciledTransactions()
+	if err != nil {
+		t.Fatalf("UnreconciledTransactions failed: %v", err)
+	}
+	if len(transactions) != 2 {
+		t.Fatalf("Expected 2 unreconciled payments, got %+v", transactions)
+	}
+	first, second := transactions[0], transactions[1]
+	if first.Amount != models.NewMoney(571.20) || first.Currency != "EUR" || first.IBAN != "DE89370400440532013000" || first.Reference != "INV-2024-0001" {
+		t.Errorf("Unexpected first payment %+v", first)
+	}
+	if second.Counterparty != "Beta BV" || second.Reference != "Invoice INV-2024-0002" {
+		t.Errorf("Unexpected second payment %+v", second)
+	}
+
+	// Reconciled payments are not offered again
+	if err := bankSync.MarkReconciled(first.ID, 1); err != nil {
+		t.Fatalf("MarkReconciled failed: %v", err)
+	}
+	if transactions, _ := bankSync.UnreconciledTransactions(); len(transactions) != 1 || transactions[0].ID != second.ID {
+		t.Errorf("Expected only the second payment to be unreconciled, got %+v", transactions)
+	}
+
+	if err := bankSync.Disconnect(connection.ID); err != nil {
+		t.Fatalf("Disconnect failed: %v", err)
+	}
+	if transactions, _ := bankSync.UnreconciledTransactions(); len(transactions) != 0 {
+		t.Errorf("Expected the payments of the disconnected bank to be removed, got %+v", transactions)
+	}
+}
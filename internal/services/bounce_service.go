@@ -0,0 +1,231 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// bounceLookback limits bounce detection to emails sent and bounces received
+// in this period
+const bounceLookback = 30 * 24 * time.Hour
+
+var (
+	messageIDPattern      = regexp.MustCompile(`<[^<>\s@]+@[^<>\s]+>`)
+	diagnosticCodePattern = regexp.MustCompile(`(?im)^Diagnostic-Code:[ \t]*(.+)$`)
+	dsnStatusPattern      = regexp.MustCompile(`(?im)^Status:[ \t]*(5\.\d{1,3}\.\d{1,3})`)
+	dsnActionPattern      = regexp.MustCompile(`(?im)^Action:[ \t]*(\w+)`)
+)
+
+// bounceSubjects are subject fragments of bounce messages from servers that
+// do not send standard delivery status notifications
+var bounceSubjects = []string{"undeliver", "delivery status notification", "returned mail", "delivery failure", "failure notice", "mail delivery failed"}
+
+// BounceService polls an IMAP mailbox for bounce messages and marks the sent
+// invoice emails they refer to as bounced
+type BounceService struct {
+	dbService       *DBService
+	settingsService *SettingsService
+	logger          *Logger
+	dial            func() (*imapClient, error) // Replaced in tests
+	stop            chan struct{}
+	done            chan struct{}
+}
+
+// NewBounceService creates a new BounceService
+func NewBounceService(dbService *DBService, settingsService *SettingsService, logger *Logger) *BounceService {
+	s := &BounceService{
+		dbService:       dbService,
+		settingsService: settingsService,
+		logger:          logger,
+	}
+	s.dial = func() (*imapClient, error) {
+		return dialIMAP(
+			settingsService.GetString(SettingIMAPHost),
+			settingsService.GetInt(SettingIMAPPort),
+			settingsService.GetString(SettingIMAPUsername),
+			settingsService.GetString(SettingIMAPPassword),
+		)
+	}
+	return s
+}
+
+// Configured reports whether an IMAP host is set
+func (s *BounceService) Configured() bool {
+	return s.settingsService.GetString(SettingIMAPHost) != ""
+}
+
+// Start checks for bounces periodically while an IMAP host is configured. The
+// interval is read from the settings before every wait, so changes apply
+// without a restart.
+func (s *BounceService) Start() {
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		for {
+			RunProtected(s.logger, "bounce check", func() {
+				if !s.Configured() {
+					return
+				}
+				if bounced, err := s.CheckBounces(); err != nil {
+					s.logger.Error("Failed to check for bounced emails: %v", err)
+				} else if bounced > 0 {
+					s.logger.Warn("%d sent email(s) bounced", bounced)
+				}
+			})
+
+			interval := time.Duration(max(s.settingsService.GetInt(SettingIMAPPollInterval), 1)) * time.Minute
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+// Stop stops the polling started by Start
+func (s *BounceService) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
+
+// CheckBounces reads the unseen messages of the last 30 days, marks the sent
+// emails referenced by bounce messages as bounced and returns how many were
+// marked. Matched bounce messages are flagged as seen; other messages are
+// left untouched.
+func (s *BounceService) CheckBounces() (int, error) {
+	if !s.Configured() {
+		return 0, nil
+	}
+
+	sent, err := s.dbService.GetDeliveredInvoiceEmails(time.Now().Add(-bounceLookback))
+	if err != nil {
+		return 0, err
+	}
+	if len(sent) == 0 {
+		return 0, nil
+	}
+	byMessageID := make(map[string]models.InvoiceEmail, len(sent))
+	for _, email := range sent {
+		byMessageID[email.MessageID] = email
+	}
+
+	c, err := s.dial()
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	if err := c.Select(firstNonEmpty(s.settingsService.GetString(SettingIMAPMailbox), "INBOX")); err != nil {
+		return 0, fmt.Errorf("failed to open mailbox: %w", err)
+	}
+	uids, err := c.SearchUnseenSince(time.Now().Add(-bounceLookback))
+	if err != nil {
+		return 0, fmt.Errorf("failed to search mailbox: %w", err)
+	}
+
+	bounced := 0
+	for _, uid := range uids {
+		raw, err := c.Fetch(uid)
+		if err != nil {
+			return bounced, fmt.Errorf("failed to fetch message: %w", err)
+		}
+
+		reason, ok := parseBounce(raw)
+		if !ok {
+			continue
+		}
+
+		matched := false
+		for _, id := range messageIDPattern.FindAllString(string(raw), -1) {
+			email, ok := byMessageID[id]
+			if !ok {
+				continue
+			}
+			if err := s.dbService.MarkInvoiceEmailBounced(email.ID, reason); err != nil {
+				return bounced, err
+			}
+			delete(byMessageID, id)
+			s.logger.Warn("Email for invoice %d to %s bounced: %s", email.InvoiceID, email.Recipient, reason)
+			matched = true
+			bounced++
+		}
+
+		if matched {
+			if err := c.MarkSeen(uid); err != nil {
+				s.logger.Warn("Failed to mark bounce message as seen: %v", err)
+			}
+		}
+	}
+	return bounced, nil
+}
+
+// parseBounce reports whether a raw message is a bounce of a failed delivery
+// and returns the reason given by the mail server
+func parseBounce(raw []byte) (string, bool) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return "", false
+	}
+
+	subject := msg.Header.Get("Subject")
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+		subject = decoded
+	}
+	from := strings.ToLower(msg.Header.Get("From"))
+	mediaType, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+
+	isBounce := mediaType == "multipart/report" && strings.EqualFold(params["report-type"], "delivery-status")
+	isBounce = isBounce || strings.Contains(from, "mailer-daemon") || strings.Contains(from, "postmaster@")
+	for _, fragment := range bounceSubjects {
+		isBounce = isBounce || strings.Contains(strings.ToLower(subject), fragment)
+	}
+	if !isBounce {
+		return "", false
+	}
+
+	// Delivery status notifications also report delays, which are not bounces
+	actions := dsnActionPattern.FindAllStringSubmatch(string(raw), -1)
+	if len(actions) > 0 && !slicesContainsFold(actions, "failed") {
+		return "", false
+	}
+
+	reason := subject
+	if m := diagnosticCodePattern.FindSubmatch(raw); m != nil {
+		reason = string(m[1])
+	} else if m := dsnStatusPattern.FindSubmatch(raw); m != nil {
+		reason = "Status " + string(m[1])
+	}
+	reason = strings.TrimSpace(reason)
+	if len(reason) > 200 {
+		reason = reason[:200]
+	}
+	return reason, true
+}
+
+// slicesContainsFold reports whether any submatch has the value, ignoring case
+func slicesContainsFold(matches [][]string, value string) bool {
+	for _, m := range matches {
+		if strings.EqualFold(m[1], value) {
+			return true
+		}
+	}
+	return false
+}
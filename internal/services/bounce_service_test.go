@@ -0,0 +1,140 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// fakeIMAPServer answers the commands of imapClient from a map of UID to message
+func fakeIMAPServer(t *testing.T, conn net.Conn, messages map[int]string, seen chan<- int) {
+	t.Helper()
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK IMAP4rev1 ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		tag, command, _ := strings.Cut(strings.TrimSpace(line), " ")
+
+		switch {
+		case strings.HasPrefix(command, "UID SEARCH"):
+			var uids []string
+			for uid := range messages {
+				uids = append(uids, fmt.Sprint(uid))
+			}
+			fmt.Fprintf(conn, "* SEARCH %s\r\n", strings.Join(uids, " "))
+		case strings.HasPrefix(command, "UID FETCH"):
+			var uid int
+			fmt.Sscanf(command, "UID FETCH %d", &uid)
+			msg := messages[uid]
+			fmt.Fprintf(conn, "* 1 FETCH (UID %d BODY[] {%d}\r\n%s)\r\n", uid, len(msg), msg)
+		case strings.HasPrefix(command, "UID STORE"):
+			var uid int
+			fmt.Sscanf(command, "UID STORE %d", &uid)
+			seen <- uid
+		case command == "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK LOGOUT completed\r\n", tag)
+			return
+		}
+		fmt.Fprintf(conn, "%s OK done\r\n", tag)
+	}
+}
+
+func TestCheckBounces(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	logger := NewLogger(ERROR)
+	settings := NewSettingsService(dbService, logger)
+	if err := settings.SetMany(map[string]string{SettingIMAPHost: "imap.example"}); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+
+	sent := &models.InvoiceEmail{InvoiceID: 1, Kind: EmailTemplateInvoice, Recipient: "billing@client.example", MessageID: "<abc123@business.example>"}
+	if err := dbService.AddInvoiceEmail(sent); err != nil {
+		t.Fatalf("AddInvoiceEmail failed: %v", err)
+	}
+	delivered := &models.InvoiceEmail{InvoiceID: 2, Kind: EmailTemplateInvoice, Recipient: "ok@client.example", MessageID: "<def456@business.example>"}
+	if err := dbService.AddInvoiceEmail(delivered); err != nil {
+		t.Fatalf("AddInvoiceEmail failed: %v", err)
+	}
+
+	bounce := strings.Join([]string{
+		"From: Mail Delivery System <MAILER-DAEMON@mx.example>",
+		"Subject: Undelivered Mail Returned to Sender",
+		`Content-Type: multipart/report; report-type=delivery-status; boundary="b1"`,
+		"",
+		"--b1",
+		"Content-Type: message/delivery-status",
+		"",
+		"Final-Recipient: rfc822; billing@client.example",
+		"Action: failed",
+		"Status: 5.1.1",
+		"Diagnostic-Code: smtp; 550 5.1.1 User unknown",
+		"",
+		"--b1",
+		"Content-Type: text/rfc822-headers",
+		"",
+		"Message-ID: <abc123@business.example>",
+		"--b1--",
+		"",
+	}, "\r\n")
+	delay := strings.Join([]string{
+		"From: MAILER-DAEMON@mx.example",
+		"Subject: Delivery Status Notification (Delay)",
+		"",
+		"Action: delayed",
+		"Message-ID: <def456@business.example>",
+		"",
+	}, "\r\n")
+	other := "From: client@client.example\r\nSubject: Re: Invoice\r\n\r\nThanks for <def456@business.example>\r\n"
+
+	seen := make(chan int, 3)
+	bounceService := NewBounceService(dbService, settings, logger)
+	bounceService.dial = func() (*imapClient, error) {
+		client, server := net.Pipe()
+		go fakeIMAPServer(t, server, map[int]string{7: bounce, 8: delay, 9: other}, seen)
+		return newIMAPClient(client)
+	}
+
+	bounced, err := bounceService.CheckBounces()
+	if err != nil {
+		t.Fatalf("CheckBounces failed: %v", err)
+	}
+	if bounced != 1 {
+		t.Fatalf("Expected 1 bounce, got %d", bounced)
+	}
+
+	emails, err := dbService.GetInvoiceEmails(1)
+	if err != nil || len(emails) != 1 {
+		t.Fatalf("GetInvoiceEmails = %v, %v", emails, err)
+	}
+	if emails[0].Status != models.EmailStatusBounced || emails[0].BounceReason != "smtp; 550 5.1.1 User unknown" || emails[0].BouncedAt == nil {
+		t.Errorf("Unexpected bounced email %+v", emails[0])
+	}
+
+	statuses, err := dbService.GetInvoiceDeliveryStatuses()
+	if err != nil {
+		t.Fatalf("GetInvoiceDeliveryStatuses failed: %v", err)
+	}
+	if statuses[1] != models.EmailStatusBounced || statuses[2] != models.EmailStatusSent {
+		t.Errorf("Unexpected delivery statuses %v", statuses)
+	}
+
+	close(seen)
+	var marked []int
+	for uid := range seen {
+		marked = append(marked, uid)
+	}
+	if len(marked) != 1 || marked[0] != 7 {
+		t.Errorf("Expected only the bounce to be marked as seen, got %v", marked)
+	}
+}
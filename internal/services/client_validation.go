@@ -0,0 +1,43 @@
+package services
+
+import (
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// ValidateClient checks a client saved through the REST or gRPC API or
+// imported from CSV, and sets the country of clients with a UK VAT ID to GB
+func ValidateClient(client *models.Client) error {
+	if client.Email != "" {
+		if _, err := mail.ParseAddress(client.Email); err != nil {
+			return fmt.Errorf("%q is not an email address", client.Email)
+		}
+	}
+	if client.Language != "" && !IsLanguageTag(client.Language) {
+		return fmt.Errorf("%q is not a language like en or de-DE", client.Language)
+	}
+	client.SDICode = strings.ToUpper(strings.TrimSpace(client.SDICode))
+	if client.SDICode != "" && !IsSDICode(client.SDICode) {
+		return fmt.Errorf("%q is not an SDI recipient code of 6 or 7 letters and digits", client.SDICode)
+	}
+	if client.PEC != "" {
+		if _, err := mail.ParseAddress(client.PEC); err != nil {
+			return fmt.Errorf("%q is not a PEC address", client.PEC)
+		}
+	}
+	if client.DateFormat != "" && !slices.Contains(models.DateFormats, client.DateFormat) {
+		return fmt.Errorf("%q is not a date format, expected one of %s", client.DateFormat, strings.Join(models.DateFormats, ", "))
+	}
+	if client.NumberFormat != "" && !slices.Contains(models.NumberFormats, client.NumberFormat) {
+		return fmt.Errorf("%q is not a number format, expected one of %s", client.NumberFormat, strings.Join(models.NumberFormats, ", "))
+	}
+	// UK VAT IDs belong to clients in GB
+	if strings.HasPrefix(strings.ToUpper(client.VatID), "GB") {
+		client.Country = "GB"
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestValidateClient(t *testing.T) {
+	client := &models.Client{Name: "Acme", VatID: "gb123456789", Country: "DE", Email: "billing@acme.example", Language: "en-GB"}
+	if err := ValidateClient(client); err != nil || client.Country != "GB" {
+		t.Errorf("Expected a valid client in GB, got %q (%v)", client.Country, err)
+	}
+	if err := ValidateClient(&models.Client{Email: "not an address"}); err == nil {
+		t.Error("Expected an invalid email address to be rejected")
+	}
+	if err := ValidateClient(&models.Client{Language: "english"}); err == nil {
+		t.Error("Expected an invalid language to be rejected")
+	}
+}
@@ -0,0 +1,364 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// ErrYearAlreadyClosed is returned when closing a year a second time
+var ErrYearAlreadyClosed = errors.New("fiscal year is already closed")
+
+// ErrYearNotOver is returned when closing the current or a future year
+var ErrYearNotOver = errors.New("only past years can be closed")
+
+// ErrSequenceGaps is returned when closing a year with gaps in its invoice
+// numbering that were not acknowledged
+var ErrSequenceGaps = errors.New("invoice numbers are missing")
+
+// ClosingService closes fiscal years: it verifies the invoice numbering,
+// locks the invoices of the year and produces a summary for the accountant
+type ClosingService struct {
+	dbService  *DBService
+	pdfService *PDFService
+	logger     *Logger
+}
+
+// NewClosingService creates a new ClosingService
+func NewClosingService(dbService *DBService, pdfService *PDFService, logger *Logger) *ClosingService {
+	return &ClosingService{
+		dbService:  dbService,
+		pdfService: pdfService,
+		logger:     logger,
+	}
+}
+
+// CheckSequence verifies that the generated invoice numbers of a year form a
+// sequence without gaps or duplicates, and explains missing numbers with the
+// audit log. Pro-forma invoices are numbered separately and not checked.
+func (s *ClosingService) CheckSequence(year int) (*models.SequenceCheck, error) {
+	invoices, err := s.dbService.GetInvoices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load invoices: %w", err)
+	}
+	check := checkSequence(year, invoices)
+	if err := s.explain(check); err != nil {
+		return nil, err
+	}
+	return check, nil
+}
+
+// CheckAllSequences checks the numbering of every year with invoices, most recent first
+func (s *ClosingService) CheckAllSequences() ([]models.SequenceCheck, error) {
+	invoices, err := s.dbService.GetInvoices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load invoices: %w", err)
+	}
+
+	var years []int
+	for _, invoice := range invoices {
+		if invoice.IsProforma() {
+			continue
+		}
+		year := invoice.IssueDate.Year()
+		if y, ok := sequenceYear(invoice.InvoiceNumber); ok {
+			year = y
+		}
+		if !slices.Contains(years, year) {
+			years = append(years, year)
+		}
+	}
+	slices.Sort(years)
+	slices.Reverse(years)
+
+	checks := []models.SequenceCheck{}
+	for _, year := range years {
+		check := checkSequence(year, invoices)
+		if err := s.explain(check); err != nil {
+			return nil, err
+		}
+		checks = append(checks, *check)
+	}
+	return checks, nil
+}
+
+// explain adds the audit log entries of the missing numbers listed to a
+// check, read in one query for the year
+func (s *ClosingService) explain(check *models.SequenceCheck) error {
+	if len(check.Missing) == 0 {
+		return nil
+	}
+	entries, err := s.dbService.GetInvoiceNumberAuditLog(sequencePrefix(check.Year))
+	if err != nil {
+		return err
+	}
+	for _, number := range check.Missing {
+		if len(entries[number]) > 0 {
+			check.Explanations[number] = entries[number]
+		}
+	}
+	return nil
+}
+
+// sequenceYear returns the year of a generated invoice number such as INV-2024-0001
+func sequenceYear(number string) (int, bool) {
+	rest, ok := strings.CutPrefix(number, invoiceNumberPrefixes[models.InvoiceTypeInvoice]+"-")
+	if !ok || len(rest) < 5 || rest[4] != '-' {
+		return 0, false
+	}
+	year, err := strconv.Atoi(rest[:4])
+	return year, err == nil
+}
+
+// sequencePrefix returns the prefix of the generated invoice numbers of a year, e.g. INV-2024-
+func sequencePrefix(year int) string {
+	return fmt.Sprintf("%s-%d-", invoiceNumberPrefixes[models.InvoiceTypeInvoice], year)
+}
+
+const (
+	// maxListedMissing and maxListedGaps limit how many missing numbers and
+	// runs of them a sequence check lists
+	maxListedMissing = 100
+	maxListedGaps    = 100
+	// maxSequenceJump is how far beyond the number of invoices a number of the
+	// sequence may be; numbers further out were entered by hand, e.g.
+	// INV-2024-5000000, and count as out of sequence rather than leaving
+	// millions of gaps
+	maxSequenceJump = 1000
+)
+
+func checkSequence(year int, invoices []models.Invoice) *models.SequenceCheck {
+	prefix := sequencePrefix(year)
+	check := &models.SequenceCheck{Year: year, Missing: []string{}, Gaps: []models.NumberGap{}, Duplicates: []string{}, Unsequenced: []string{},
+		Explanations: map[string][]models.AuditEntry{}}
+
+	type sequenced struct {
+		n      int
+		number string
+		issued bool // In the year checked
+	}
+	var numbers []sequenced
+	for _, invoice := range invoices {
+		if invoice.IsProforma() {
+			continue
+		}
+		issued := invoice.IssueDate.Year() == year
+		if issued {
+			check.Invoices++
+		}
+		if suffix, ok := strings.CutPrefix(invoice.InvoiceNumber, prefix); ok {
+			if n, err := strconv.Atoi(suffix); err == nil && n > 0 {
+				numbers = append(numbers, sequenced{n, invoice.InvoiceNumber, issued})
+				continue
+			}
+		}
+		if issued {
+			check.Unsequenced = append(check.Unsequenced, invoice.InvoiceNumber)
+		}
+	}
+	limit := len(numbers) + maxSequenceJump
+	numbers = slices.DeleteFunc(numbers, func(s sequenced) bool {
+		if s.n <= limit {
+			return false
+		}
+		if s.issued {
+			check.Unsequenced = append(check.Unsequenced, s.number)
+		}
+		return true
+	})
+	slices.Sort(check.Unsequenced)
+	if len(numbers) == 0 {
+		return check
+	}
+
+	slices.SortFunc(numbers, func(a, b sequenced) int { return cmp.Compare(a.n, b.n) })
+	format := func(n int) string { return fmt.Sprintf("%s%04d", prefix, n) }
+	check.FirstNumber = format(numbers[0].n)
+	check.LastNumber = format(numbers[len(numbers)-1].n)
+	// The sequence starts at 1 every year. Numbers written differently, such as
+	// INV-2024-7 and INV-2024-0007, are duplicates.
+	next := 1
+	for i, s := range numbers {
+		n := s.n
+		if i > 0 && n == numbers[i-1].n {
+			if duplicate := format(n); !slices.Contains(check.Duplicates, duplicate) {
+				check.Duplicates = append(check.Duplicates, duplicate)
+			}
+			continue
+		}
+		if next < n {
+			check.MissingCount += n - next
+			if len(check.Gaps) < maxListedGaps {
+				check.Gaps = append(check.Gaps, models.NumberGap{First: format(next), Last: format(n - 1), Count: n - next})
+			}
+			for ; next < n && len(check.Missing) < maxListedMissing; next++ {
+				check.Missing = append(check.Missing, format(next))
+			}
+		}
+		next = n + 1
+	}
+	return check
+}
+
+// Close closes a past fiscal year. The numbering must not have gaps unless
+// acknowledgeGaps is set, in which case the missing numbers are listed in the
+// summary. Closing cannot be undone.
+func (s *ClosingService) Close(year int, acknowledgeGaps bool) (*models.YearClosing, error) {
+	if year >= time.Now().Year() {
+		return nil, fmt.Errorf("%w: %d", ErrYearNotOver, year)
+	}
+
+	// The year is checked, summarized and closed in one transaction, so no
+	// invoice can be issued in it between the check and the closing
+	ctx := context.Background()
+	tx, err := s.dbService.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkYearOpen(ctx, tx, time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)); errors.Is(err, ErrYearClosed) {
+		return nil, fmt.Errorf("%w: %d", ErrYearAlreadyClosed, year)
+	} else if err != nil {
+		return nil, err
+	}
+
+	invoices, err := getInvoices(ctx, tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load invoices: %w", err)
+	}
+	check := checkSequence(year, invoices)
+	if check.HasGaps() && !acknowledgeGaps {
+		return nil, fmt.Errorf("%w: %s", ErrSequenceGaps, strings.Join(check.GapList(), ", "))
+	}
+
+	closing := &models.YearClosing{
+		Year:           year,
+		ClosedAt:       time.Now().UTC(),
+		Invoices:       check.Invoices,
+		FirstNumber:    check.FirstNumber,
+		LastNumber:     check.LastNumber,
+		MissingNumbers: check.GapList(),
+	}
+
+	// The summary lists the invoices issued in the year, in number order
+	var issued []models.Invoice
+	for _, invoice := range invoices {
+		if invoice.IsProforma() || invoice.IssueDate.Year() != year {
+			continue
+		}
+		issued = append(issued, invoice)
+	}
+	slices.SortFunc(issued, func(a, b models.Invoice) int { return strings.Compare(a.InvoiceNumber, b.InvoiceNumber) })
+
+	clientNames, err := clientNames(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	closing.SummaryFilename = yearClosingFilename(year)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO closed_years (year, closed_at, invoices, first_number, last_number, missing_numbers, summary_filename)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, closing.Year, closing.ClosedAt, closing.Invoices, closing.FirstNumber, closing.LastNumber,
+		strings.Join(closing.MissingNumbers, ","), closing.SummaryFilename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to close year: %w", err)
+	}
+
+	details := fmt.Sprintf("%d invoices, %s to %s", closing.Invoices, cmp.Or(closing.FirstNumber, "-"), cmp.Or(closing.LastNumber, "-"))
+	if len(closing.MissingNumbers) > 0 {
+		details += ", missing " + strings.Join(closing.MissingNumbers, ", ")
+	}
+	if err := logAudit(ctx, tx, AuditActionCloseYear, "year", year, details); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to close year: %w", err)
+	}
+
+	// The summary is generated once the transaction no longer holds the only
+	// database connection, which signing needs for its settings. The invoices
+	// of the closed year cannot change in the meantime.
+	pdfPath, err := s.pdfService.GenerateYearClosing(closing, issued, clientNames)
+	if err != nil {
+		return nil, fmt.Errorf("year %d was closed but its summary could not be generated: %w", year, err)
+	}
+	s.logger.Info("Closed fiscal year %d (%s), summary at %s", year, details, pdfPath)
+	return closing, nil
+}
+
+// clientNames returns the names of all clients by ID, using db, which may be a
+// transaction
+func clientNames(ctx context.Context, db rowsQueryer) (map[int]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, name FROM clients`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query clients: %w", err)
+	}
+	defer rows.Close()
+
+	names := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, fmt.Errorf("failed to scan client: %w", err)
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
+
+// Get returns the closing of a year, or sql.ErrNoRows if it is open
+func (s *ClosingService) Get(year int) (*models.YearClosing, error) {
+	closings, err := s.query(`WHERE year = ?`, year)
+	if err != nil {
+		return nil, err
+	}
+	if len(closings) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &closings[0], nil
+}
+
+// List returns the closed years, most recent first
+func (s *ClosingService) List() ([]models.YearClosing, error) {
+	return s.query(``)
+}
+
+func (s *ClosingService) query(where string, args ...any) ([]models.YearClosing, error) {
+	rows, err := s.dbService.GetDB().Query(`
+		SELECT year, closed_at, invoices, first_number, last_number, missing_numbers, summary_filename
+		FROM closed_years `+where+` ORDER BY year DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query closed years: %w", err)
+	}
+	defer rows.Close()
+
+	closings := []models.YearClosing{}
+	for rows.Next() {
+		var closing models.YearClosing
+		var missing string
+		if err := rows.Scan(&closing.Year, &closing.ClosedAt, &closing.Invoices, &closing.FirstNumber, &closing.LastNumber,
+			&missing, &closing.SummaryFilename); err != nil {
+			return nil, fmt.Errorf("failed to scan closed year: %w", err)
+		}
+		closing.MissingNumbers = append([]string{}, splitList(missing)...)
+		closings = append(closings, closing)
+	}
+	return closings, rows.Err()
+}
+
+// yearClosingFilename is the filename of the closing summary of a year in the pdfs directory
+func yearClosingFilename(year int) string {
+	return fmt.Sprintf("closing-%d.pdf", year)
+}
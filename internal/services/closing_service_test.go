@@ -0,0 +1,205 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestCloseYear(t *testing.T) {
+	dbService, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+	closings := NewClosingService(dbService, NewPDFService(tempDir), NewLogger(ERROR))
+
+	issued := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	saveInvoice := func(number string, issueDate time.Time) *models.Invoice {
+		t.Helper()
+		invoice := &models.Invoice{
+			InvoiceNumber: number,
+			BusinessID:    1,
+			ClientID:      1,
+			IssueDate:     issueDate,
+			DueDate:       issueDate.AddDate(0, 0, 14),
+			TotalAmount:   11900,
+			VatRate:       19,
+			VatAmount:     1900,
+			Currency:      "EUR",
+			Status:        "sent",
+		}
+		items := []models.InvoiceItem{{Description: "Consulting", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+		if err := dbService.SaveInvoice(invoice, items); err != nil {
+			t.Fatalf("Failed to save invoice %s: %v", number, err)
+		}
+		return invoice
+	}
+	first := saveInvoice("", issued)
+	saveInvoice("INV-2024-0003", issued)
+	saveInvoice("ACME-7", issued)
+
+	check, err := closings.CheckSequence(2024)
+	if err != nil {
+		t.Fatalf("CheckSequence failed: %v", err)
+	}
+	if check.Invoices != 3 || check.FirstNumber != "INV-2024-0001" || check.LastNumber != "INV-2024-0003" {
+		t.Errorf("Unexpected sequence check %+v", check)
+	}
+	if len(check.Missing) != 1 || check.Missing[0] != "INV-2024-0002" || len(check.Unsequenced) != 1 {
+		t.Errorf("Expected INV-2024-0002 missing and ACME-7 unsequenced, got %+v", check)
+	}
+
+	if _, err := closings.Close(2024, false); !errors.Is(err, ErrSequenceGaps) {
+		t.Fatalf("Expected ErrSequenceGaps, got %v", err)
+	}
+	if _, err := closings.Close(time.Now().Year(), true); !errors.Is(err, ErrYearNotOver) {
+		t.Errorf("Expected ErrYearNotOver, got %v", err)
+	}
+
+	closing, err := closings.Close(2024, true)
+	if err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if closing.Invoices != 3 || len(closing.MissingNumbers) != 1 {
+		t.Errorf("Unexpected closing %+v", closing)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "pdfs", closing.SummaryFilename)); err != nil {
+		t.Errorf("Expected the closing summary PDF: %v", err)
+	}
+	if _, err := closings.Close(2024, true); !errors.Is(err, ErrYearAlreadyClosed) {
+		t.Errorf("Expected ErrYearAlreadyClosed, got %v", err)
+	}
+	if list, err := closings.List(); err != nil || len(list) != 1 || list[0].MissingNumbers[0] != "INV-2024-0002" {
+		t.Errorf("Unexpected closed years %+v (%v)", list, err)
+	}
+
+	// Invoices of the closed year are locked, but payments can still be recorded
+	invoice, items, err := dbService.GetInvoice(first.ID)
+	if err != nil {
+		t.Fatalf("GetInvoice failed: %v", err)
+	}
+	invoice.Notes = "Changed"
+	if err := dbService.SaveInvoice(invoice, items); !errors.Is(err, ErrYearClosed) {
+		t.Errorf("Expected ErrYearClosed when changing an invoice, got %v", err)
+	}
+	if err := dbService.DeleteInvoice(first.ID); !errors.Is(err, ErrYearClosed) {
+		t.Errorf("Expected ErrYearClosed when deleting an invoice, got %v", err)
+	}
+	if _, err := dbService.ApplyCredit(first.ID, 100); !errors.Is(err, ErrYearClosed) {
+		t.Errorf("Expected ErrYearClosed when applying credit, got %v", err)
+	}
+	if err := dbService.UpdateInvoiceStatus(first.ID, "paid", time.Time{}); err != nil {
+		t.Errorf("Expected the invoice to be marked paid, got %v", err)
+	}
+	if err := dbService.UpdateInvoiceStatus(first.ID, "draft", time.Time{}); !errors.Is(err, ErrYearClosed) {
+		t.Errorf("Expected ErrYearClosed when reverting a payment, got %v", err)
+	}
+	if err := dbService.SetInvoiceExchangeRate(first.ID, "RON", 4.97, issued); !errors.Is(err, ErrYearClosed) {
+		t.Errorf("Expected ErrYearClosed when changing the exchange rate, got %v", err)
+	}
+	if _, err := dbService.CancelInvoice(first.ID, time.Now().UTC(), nil); !errors.Is(err, ErrYearClosed) {
+		t.Errorf("Expected ErrYearClosed when cancelling an invoice, got %v", err)
+	}
+	if reloaded, _, err := dbService.GetInvoice(first.ID); err != nil || reloaded.CancelledByInvoiceID != 0 {
+		t.Errorf("Expected the invoice not to be cancelled, got %+v (%v)", reloaded, err)
+	}
+
+	// New invoices cannot be issued in the closed year, nor moved into it
+	late := &models.Invoice{BusinessID: 1, ClientID: 1, IssueDate: issued, DueDate: issued, Currency: "EUR"}
+	if err := dbService.SaveInvoice(late, nil); !errors.Is(err, ErrYearClosed) {
+		t.Errorf("Expected ErrYearClosed for a new invoice, got %v", err)
+	}
+	open := saveInvoice("", issued.AddDate(1, 0, 0))
+	open.IssueDate = issued
+	if err := dbService.SaveInvoice(open, []models.InvoiceItem{{Description: "Consulting", Quantity: 1, UnitPrice: 10000, Amount: 10000}}); !errors.Is(err, ErrYearClosed) {
+		t.Errorf("Expected ErrYearClosed when moving an invoice into a closed year, got %v", err)
+	}
+}
+
+func TestCheckSequenceExplanations(t *testing.T) {
+	dbService, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+	closings := NewClosingService(dbService, NewPDFService(tempDir), NewLogger(ERROR))
+
+	issued := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	var invoices []*models.Invoice
+	for _, number := range []string{"", "", "INV-2024-0003", "INV-2024-3"} {
+		invoice := &models.Invoice{InvoiceNumber: number, BusinessID: 1, ClientID: 1, IssueDate: issued, DueDate: issued, Currency: "EUR", Status: "draft"}
+		if err := dbService.SaveInvoice(invoice, nil); err != nil {
+			t.Fatalf("Failed to save invoice: %v", err)
+		}
+		invoices = append(invoices, invoice)
+	}
+
+	// INV-2024-0001 is deleted, INV-2024-0002 renumbered
+	if err := dbService.DeleteInvoice(invoices[0].ID); err != nil {
+		t.Fatalf("DeleteInvoice failed: %v", err)
+	}
+	invoices[1].InvoiceNumber = "INV-2024-0005"
+	if err := dbService.SaveInvoice(invoices[1], nil); err != nil {
+		t.Fatalf("SaveInvoice failed: %v", err)
+	}
+
+	check, err := closings.CheckSequence(2024)
+	if err != nil {
+		t.Fatalf("CheckSequence failed: %v", err)
+	}
+	if len(check.Missing) != 3 || check.Missing[2] != "INV-2024-0004" {
+		t.Fatalf("Expected INV-2024-0001, 0002 and 0004 missing, got %v", check.Missing)
+	}
+	if len(check.Duplicates) != 1 || check.Duplicates[0] != "INV-2024-0003" {
+		t.Errorf("Expected INV-2024-0003 used twice, got %v", check.Duplicates)
+	}
+	if entries := check.Explanations["INV-2024-0001"]; len(entries) != 1 || entries[0].Action != AuditActionDelete {
+		t.Errorf("Expected the deletion of INV-2024-0001 to be explained, got %+v", entries)
+	}
+	if entries := check.Explanations["INV-2024-0002"]; len(entries) != 1 || entries[0].Action != AuditActionRenumber {
+		t.Errorf("Expected the renumbering of INV-2024-0002 to be explained, got %+v", entries)
+	}
+	if _, ok := check.Explanations["INV-2024-0004"]; ok {
+		t.Error("Expected no explanation for a number that was never used")
+	}
+
+	checks, err := closings.CheckAllSequences()
+	if err != nil || len(checks) != 1 || checks[0].Year != 2024 {
+		t.Errorf("Expected one year to be checked, got %+v (%v)", checks, err)
+	}
+}
+
+func TestCheckSequenceLargeGaps(t *testing.T) {
+	issued := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	invoicesNumbered := func(numbers ...string) []models.Invoice {
+		invoices := make([]models.Invoice, len(numbers))
+		for i, number := range numbers {
+			invoices[i] = models.Invoice{InvoiceNumber: number, IssueDate: issued}
+		}
+		return invoices
+	}
+
+	// A number far beyond the others is out of sequence rather than a gap
+	check := checkSequence(2024, invoicesNumbered("INV-2024-0001", "INV-2024-5000000"))
+	if check.HasGaps() || check.LastNumber != "INV-2024-0001" || len(check.Unsequenced) != 1 || check.Unsequenced[0] != "INV-2024-5000000" {
+		t.Errorf("Expected INV-2024-5000000 to be unsequenced, got %+v", check)
+	}
+
+	// Gaps are reported as runs, listing the first missing numbers only
+	check = checkSequence(2024, invoicesNumbered("INV-2024-0001", "INV-2024-0500"))
+	if check.MissingCount != 498 || len(check.Missing) != maxListedMissing || check.Missing[0] != "INV-2024-0002" {
+		t.Errorf("Expected 498 missing numbers, the first %d listed, got %d and %d", maxListedMissing, check.MissingCount, len(check.Missing))
+	}
+	if list := check.GapList(); len(list) != 1 || list[0] != "INV-2024-0002 to INV-2024-0499" {
+		t.Errorf("Expected one run of missing numbers, got %v", list)
+	}
+
+	var numbers []string
+	for n := 1; n <= 401; n += 2 {
+		numbers = append(numbers, fmt.Sprintf("INV-2024-%04d", n))
+	}
+	check = checkSequence(2024, invoicesNumbered(numbers...))
+	if list := check.GapList(); check.MissingCount != 200 || len(check.Gaps) != maxListedGaps || list[len(list)-1] != "100 more" {
+		t.Errorf("Expected %d of 200 gaps listed, got %d of %d: %v", maxListedGaps, len(check.Gaps), check.MissingCount, list)
+	}
+}
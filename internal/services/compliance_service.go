@@ -0,0 +1,358 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/refdata"
+)
+
+// defaultComplianceProfiles are the built-in compliance profiles by country
+// code of the business
+var defaultComplianceProfiles = map[string]models.ComplianceProfile{
+	"DE": {
+		Name:             "Germany (§ 14 UStG)",
+		RequiredFields:   []string{models.ComplianceBusinessVatID, models.ComplianceBusinessAddress, models.ComplianceClientAddress},
+		Clauses:          []string{"Sofern nicht anders angegeben, entspricht das Leistungsdatum dem Rechnungsdatum."},
+		GaplessNumbering: true,
+	},
+	"FR": {
+		Name:           "France (art. 242 nonies A annexe II CGI)",
+		RequiredFields: []string{models.ComplianceBusinessVatID, models.ComplianceBusinessAddress, models.ComplianceClientAddress},
+		Clauses: []string{"En cas de retard de paiement, une pénalité égale à trois fois le taux d'intérêt légal est exigible, ainsi qu'une indemnité forfaitaire " +
+			"pour frais de recouvrement de 40 € (art. L441-10 du Code de commerce). Pas d'escompte pour paiement anticipé."},
+		GaplessNumbering: true,
+	},
+	"RO": {
+		Name:             "Romania (art. 319 Codul fiscal)",
+		RequiredFields:   []string{models.ComplianceBusinessVatID, models.ComplianceBusinessAddress, models.ComplianceClientAddress},
+		Clauses:          []string{"Factura circulă fără semnătură și ștampilă conform art. 319 alin. (29) din Codul fiscal."},
+		GaplessNumbering: true,
+	},
+	"PL": {
+		Name:             "Poland (art. 106e ustawy o VAT)",
+		RequiredFields:   []string{models.ComplianceBusinessVatID, models.ComplianceBusinessAddress, models.ComplianceClientAddress},
+		GaplessNumbering: true,
+		NumberMaxLength:  256,
+		ExportFormats:    []string{models.ComplianceKSeF},
+	},
+	"IT": {
+		Name:             "Italy (art. 21 DPR 633/1972)",
+		RequiredFields:   []string{models.ComplianceBusinessVatID, models.ComplianceBusinessAddress, models.ComplianceClientAddress, models.ComplianceClientSDI},
+		GaplessNumbering: true,
+		NumberMaxLength:  20,
+		NumberPattern:    `\d`,
+		ExportFormats:    []string{models.ComplianceFatturaPA},
+	},
+	"ES": {
+		Name:             "Spain (Real Decreto 1619/2012)",
+		RequiredFields:   []string{models.ComplianceBusinessVatID, models.ComplianceBusinessAddress},
+		GaplessNumbering: true,
+		NumberMaxLength:  60,
+		ExportFormats:    []string{models.ComplianceVerifactu},
+	},
+	"GB": {
+		Name:           "United Kingdom (HMRC VAT Notice 700)",
+		RequiredFields: []string{models.ComplianceBusinessAddress, models.ComplianceClientAddress},
+	},
+}
+
+// ErrComplianceProfileNotFound is returned when deleting a profile that was never customized
+var ErrComplianceProfileNotFound = errors.New("compliance profile not found")
+
+// ComplianceError is returned when an invoice breaks the compliance profile
+// of its business
+type ComplianceError struct {
+	Country  string
+	Problems []string
+}
+
+func (e *ComplianceError) Error() string {
+	return fmt.Sprintf("the invoice does not meet the %s compliance profile: %s", e.Country, strings.Join(e.Problems, "; "))
+}
+
+// ComplianceService manages the compliance profiles customized per business
+// country and checks invoices against the profile of their business
+type ComplianceService struct {
+	dbService *DBService
+	logger    *Logger
+}
+
+// NewComplianceService creates a new ComplianceService
+func NewComplianceService(dbService *DBService, logger *Logger) *ComplianceService {
+	return &ComplianceService{
+		dbService: dbService,
+		logger:    logger,
+	}
+}
+
+// List returns the profile of every country with one, the customized profile
+// or else the built-in one, ordered by country
+func (s *ComplianceService) List() ([]models.ComplianceProfile, error) {
+	rows, err := s.dbService.GetDB().Query(`
+		SELECT country, name, required_fields, clauses, gapless_numbering, number_max_length, number_pattern, export_formats, updated_at
+		FROM compliance_profiles ORDER BY country
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query compliance profiles: %w", err)
+	}
+	defer rows.Close()
+
+	profiles := map[string]models.ComplianceProfile{}
+	for rows.Next() {
+		profile, err := scanComplianceProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles[profile.Country] = *profile
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for country := range defaultComplianceProfiles {
+		if _, ok := profiles[country]; !ok {
+			profiles[country] = *builtInComplianceProfile(country)
+		}
+	}
+
+	list := []models.ComplianceProfile{}
+	for _, country := range slices.Sorted(maps.Keys(profiles)) {
+		list = append(list, profiles[country])
+	}
+	return list, nil
+}
+
+// Profile returns the profile of a country, the customized profile or else
+// the built-in one, or nil if the country has none. Businesses without a
+// country follow no profile.
+func (s *ComplianceService) Profile(country string) (*models.ComplianceProfile, error) {
+	country = refdata.NormalizeCountryCode(country)
+	if country == "" {
+		return nil, nil
+	}
+	profile, err := scanComplianceProfile(s.dbService.GetDB().QueryRow(`
+		SELECT country, name, required_fields, clauses, gapless_numbering, number_max_length, number_pattern, export_formats, updated_at
+		FROM compliance_profiles WHERE country = ?
+	`, country))
+	if errors.Is(err, sql.ErrNoRows) {
+		return builtInComplianceProfile(country), nil
+	}
+	return profile, err
+}
+
+// builtInComplianceProfile returns the built-in profile of a country, or nil
+func builtInComplianceProfile(country string) *models.ComplianceProfile {
+	profile, ok := defaultComplianceProfiles[country]
+	if !ok {
+		return nil
+	}
+	profile.Country = country
+	profile.RequiredFields = slices.Clone(profile.RequiredFields)
+	profile.Clauses = slices.Clone(profile.Clauses)
+	profile.ExportFormats = slices.Clone(profile.ExportFormats)
+	profile.BuiltIn = true
+	return &profile
+}
+
+// scanComplianceProfile scans a compliance_profiles row
+func scanComplianceProfile(row rowScanner) (*models.ComplianceProfile, error) {
+	var profile models.ComplianceProfile
+	var required, clauses, formats string
+	var gapless int
+	if err := row.Scan(&profile.Country, &profile.Name, &required, &clauses, &gapless, &profile.NumberMaxLength,
+		&profile.NumberPattern, &formats, &profile.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan compliance profile: %w", err)
+	}
+	profile.RequiredFields = append([]string{}, splitList(required)...)
+	profile.Clauses = []string{}
+	for _, clause := range strings.Split(clauses, "\n") {
+		if clause = strings.TrimSpace(clause); clause != "" {
+			profile.Clauses = append(profile.Clauses, clause)
+		}
+	}
+	profile.GaplessNumbering = gapless != 0
+	profile.ExportFormats = append([]string{}, splitList(formats)...)
+	return &profile, nil
+}
+
+// Save stores a customized profile for businesses in a country, replacing
+// the previous one or the built-in profile
+func (s *ComplianceService) Save(profile *models.ComplianceProfile) error {
+	profile.Country = refdata.NormalizeCountryCode(profile.Country)
+	if !countryCodePattern.MatchString(profile.Country) {
+		return fmt.Errorf("%q is not a two-letter country code like DE", profile.Country)
+	}
+	profile.Name = strings.TrimSpace(profile.Name)
+	if profile.Name == "" {
+		return errors.New("name is required")
+	}
+	for _, field := range profile.RequiredFields {
+		if !slices.Contains(models.ComplianceFields, field) {
+			return fmt.Errorf("unknown required field %q, expected one of %s", field, strings.Join(models.ComplianceFields, ", "))
+		}
+	}
+	for _, format := range profile.ExportFormats {
+		if !slices.Contains(models.ComplianceFormats, format) {
+			return fmt.Errorf("unknown export format %q, expected one of %s", format, strings.Join(models.ComplianceFormats, ", "))
+		}
+	}
+	var clauses []string
+	for _, clause := range profile.Clauses {
+		// Clauses are stored one per line
+		if clause = strings.Join(strings.Fields(clause), " "); clause != "" {
+			clauses = append(clauses, clause)
+		}
+	}
+	profile.Clauses = append([]string{}, clauses...)
+	if profile.NumberMaxLength < 0 {
+		return errors.New("number_max_length must not be negative")
+	}
+	if _, err := regexp.Compile(profile.NumberPattern); err != nil {
+		return fmt.Errorf("invalid number_pattern: %w", err)
+	}
+	profile.RequiredFields = append([]string{}, profile.RequiredFields...)
+	profile.ExportFormats = append([]string{}, profile.ExportFormats...)
+
+	profile.BuiltIn = false
+	profile.UpdatedAt = time.Now().UTC()
+	_, err := s.dbService.GetDB().Exec(`
+		INSERT INTO compliance_profiles (country, name, required_fields, clauses, gapless_numbering, number_max_length, number_pattern, export_formats, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (country) DO UPDATE SET name = excluded.name, required_fields = excluded.required_fields, clauses = excluded.clauses,
+			gapless_numbering = excluded.gapless_numbering, number_max_length = excluded.number_max_length, number_pattern = excluded.number_pattern,
+			export_formats = excluded.export_formats, updated_at = excluded.updated_at
+	`, profile.Country, profile.Name, strings.Join(profile.RequiredFields, ","), strings.Join(profile.Clauses, "\n"), boolToInt(profile.GaplessNumbering),
+		profile.NumberMaxLength, profile.NumberPattern, strings.Join(profile.ExportFormats, ","), profile.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save compliance profile: %w", err)
+	}
+
+	s.logger.Info("Saved compliance profile for country %s", profile.Country)
+	return nil
+}
+
+// Delete removes the customized profile of a country; its businesses then
+// follow the built-in profile again, or none
+func (s *ComplianceService) Delete(country string) error {
+	result, err := s.dbService.GetDB().Exec(`DELETE FROM compliance_profiles WHERE country = ?`, refdata.NormalizeCountryCode(country))
+	if err != nil {
+		return fmt.Errorf("failed to delete compliance profile: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrComplianceProfileNotFound
+	}
+
+	s.logger.Info("Deleted compliance profile for country %s", country)
+	return nil
+}
+
+// Check verifies that an invoice about to be finalized has the fields the
+// profile of its business requires and a number following its numbering
+// rules. It returns a *ComplianceError listing every problem.
+func (s *ComplianceService) Check(invoice *models.Invoice, business *models.Business, client *models.Client) error {
+	profile, err := s.Profile(business.Country)
+	if err != nil || profile == nil {
+		return err
+	}
+
+	var problems []string
+	missing := func(field, problem string) {
+		if slices.Contains(profile.RequiredFields, field) {
+			problems = append(problems, problem)
+		}
+	}
+	blank := func(values ...string) bool {
+		return slices.ContainsFunc(values, func(value string) bool { return strings.TrimSpace(value) == "" })
+	}
+	if blank(business.VatID) {
+		missing(models.ComplianceBusinessVatID, "the business needs a VAT ID")
+	}
+	if blank(business.Address, business.PostalCode, business.City) {
+		missing(models.ComplianceBusinessAddress, "the business needs an address, a postal code and a city")
+	}
+	if blank(business.IBAN) {
+		missing(models.ComplianceBusinessIBAN, "the business needs an IBAN")
+	}
+	if blank(client.Address, client.PostalCode, client.City) {
+		missing(models.ComplianceClientAddress, "the client needs an address, a postal code and a city")
+	}
+	if blank(client.VatID) {
+		missing(models.ComplianceClientVatID, "the client needs a VAT ID")
+	}
+	if clientCountryCode(client) == "IT" && blank(client.SDICode) && blank(client.PEC) {
+		missing(models.ComplianceClientSDI, "the client needs an SDI recipient code or a PEC address")
+	}
+	if !invoice.HasServicePeriod() {
+		missing(models.ComplianceServicePeriod, "the invoice needs a service period")
+	}
+	if blank(invoice.PONumber) {
+		missing(models.CompliancePONumber, "the invoice needs a PO number")
+	}
+
+	if number := invoice.InvoiceNumber; number != "" {
+		if profile.NumberMaxLength > 0 && len([]rune(number)) > profile.NumberMaxLength {
+			problems = append(problems, fmt.Sprintf("the invoice number may have at most %d characters", profile.NumberMaxLength))
+		}
+		if profile.NumberPattern != "" {
+			if pattern, err := regexp.Compile(profile.NumberPattern); err == nil && !pattern.MatchString(number) {
+				problems = append(problems, fmt.Sprintf("the invoice number must match %s", profile.NumberPattern))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ComplianceError{Country: profile.Country, Problems: problems}
+	}
+	return nil
+}
+
+// CheckDelete verifies that an invoice may be deleted: with gapless
+// numbering, only drafts and pro-forma invoices can be
+func (s *ComplianceService) CheckDelete(invoice *models.Invoice, business *models.Business) error {
+	profile, err := s.Profile(business.Country)
+	if err != nil || profile == nil {
+		return err
+	}
+	if profile.GaplessNumbering && isBooked(invoice) {
+		return &ComplianceError{Country: profile.Country, Problems: []string{"finalized invoices cannot be deleted, as their numbers must not leave a gap"}}
+	}
+	return nil
+}
+
+// Clauses returns the legal clauses printed on the invoices of a business
+func (s *ComplianceService) Clauses(business *models.Business) ([]string, error) {
+	profile, err := s.Profile(business.Country)
+	if err != nil || profile == nil {
+		return nil, err
+	}
+	return profile.Clauses, nil
+}
+
+// Allows reports whether the profile of a business enables an e-invoicing
+// format. Businesses in countries without a profile may use every format.
+func (s *ComplianceService) Allows(business *models.Business, format string) (bool, error) {
+	profile, err := s.Profile(business.Country)
+	if err != nil || profile == nil {
+		return err == nil, err
+	}
+	return profile.HasFormat(format), nil
+}
+
+// complianceAllows reports whether compliance, which may be nil, allows a
+// business an e-invoicing format
+func complianceAllows(compliance *ComplianceService, business *models.Business, format string) (bool, error) {
+	if compliance == nil {
+		return true, nil
+	}
+	return compliance.Allows(business, format)
+}
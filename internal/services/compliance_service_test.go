@@ -0,0 +1,92 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestComplianceProfiles(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+	compliance := NewComplianceService(dbService, NewLogger(ERROR))
+
+	profiles, err := compliance.List()
+	if err != nil || len(profiles) != 7 || profiles[0].Country != "DE" || !profiles[0].BuiltIn {
+		t.Fatalf("Expected the built-in profiles, got %+v (%v)", profiles, err)
+	}
+
+	business := &models.Business{Name: "Rossi S.r.l.", Country: "IT"}
+	client := &models.Client{Name: "Bianchi S.p.A.", Country: "IT"}
+	invoice := &models.Invoice{InvoiceNumber: "2024/FAT/000000000001", Status: "sent"}
+
+	// The Italian profile needs addresses, an SDI code and numbers of up to 20 characters with a digit
+	var violation *ComplianceError
+	if err := compliance.Check(invoice, business, client); !errors.As(err, &violation) || violation.Country != "IT" || len(violation.Problems) != 5 {
+		t.Fatalf("Expected five problems, got %v", err)
+	}
+	business.VatID, business.Address, business.PostalCode, business.City = "IT01234567890", "Via Roma 1", "00100", "Roma"
+	client.Address, client.PostalCode, client.City, client.PEC = "Via Milano 2", "20100", "Milano", "fatture@pec.example.it"
+	invoice.InvoiceNumber = "2024/1"
+	if err := compliance.Check(invoice, business, client); err != nil {
+		t.Errorf("Expected the invoice to meet the profile, got %v", err)
+	}
+	if allowed, err := compliance.Allows(business, models.ComplianceFatturaPA); err != nil || !allowed {
+		t.Errorf("Expected FatturaPA to be allowed, got %v (%v)", allowed, err)
+	}
+	if err := compliance.CheckDelete(invoice, business); !errors.As(err, &violation) {
+		t.Errorf("Expected a finalized invoice not to be deleted, got %v", err)
+	}
+	if err := compliance.CheckDelete(&models.Invoice{Status: "draft"}, business); err != nil {
+		t.Errorf("Expected a draft to be deleted, got %v", err)
+	}
+
+	// A customized profile replaces the built-in one until it is deleted
+	if err := compliance.Save(&models.ComplianceProfile{Country: "it", Name: "Italia", RequiredFields: []string{"invoice.due_date"}}); err == nil {
+		t.Error("Expected an unknown field to be refused")
+	}
+	if err := compliance.Save(&models.ComplianceProfile{Country: "it", Name: "Italia", NumberPattern: "("}); err == nil {
+		t.Error("Expected an invalid number pattern to be refused")
+	}
+	custom := &models.ComplianceProfile{Country: "it", Name: "Italia", RequiredFields: []string{models.CompliancePONumber},
+		Clauses: []string{"  Operazione   soggetta a IVA. ", ""}}
+	if err := compliance.Save(custom); err != nil {
+		t.Fatalf("Failed to save profile: %v", err)
+	}
+	if clauses, err := compliance.Clauses(business); err != nil || len(clauses) != 1 || clauses[0] != "Operazione soggetta a IVA." {
+		t.Errorf("Unexpected clauses %q (%v)", clauses, err)
+	}
+	if err := compliance.Check(invoice, business, client); err == nil || !strings.Contains(err.Error(), "PO number") {
+		t.Errorf("Expected a PO number to be required, got %v", err)
+	}
+	if allowed, _ := compliance.Allows(business, models.ComplianceFatturaPA); allowed {
+		t.Error("Expected FatturaPA to be disabled by the customized profile")
+	}
+	if err := compliance.CheckDelete(invoice, business); err != nil {
+		t.Errorf("Expected invoices to be deleted without gapless numbering, got %v", err)
+	}
+	if profiles, _ := compliance.List(); len(profiles) != 7 || profiles[4].Country != "IT" || profiles[4].BuiltIn {
+		t.Errorf("Expected the customized profile in place of the built-in one, got %+v", profiles)
+	}
+
+	if err := compliance.Delete("IT"); err != nil {
+		t.Fatalf("Failed to delete profile: %v", err)
+	}
+	if err := compliance.Delete("IT"); !errors.Is(err, ErrComplianceProfileNotFound) {
+		t.Errorf("Expected ErrComplianceProfileNotFound, got %v", err)
+	}
+	if profile, err := compliance.Profile("IT"); err != nil || profile == nil || !profile.BuiltIn {
+		t.Errorf("Expected the built-in profile again, got %+v (%v)", profile, err)
+	}
+
+	// Businesses in other countries follow no profile
+	other := &models.Business{Country: "US"}
+	if err := compliance.Check(invoice, other, client); err != nil {
+		t.Errorf("Expected no profile to apply, got %v", err)
+	}
+	if allowed, _ := compliance.Allows(other, models.ComplianceKSeF); !allowed {
+		t.Error("Expected every format to be allowed without a profile")
+	}
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigFileFormats(t *testing.T) {
+	yaml := `# Simple Invoice
+server:
+  port: 9090
+  data_dir: "/srv/invoices"  # quoted
+  socket_mode: 0600
+smtp:
+  host: smtp.example.com
+  password: 'p#ss ''word'''
+auth:
+  trusted_proxies:
+    - 10.0.0.0/8
+    - ::1/128
+invoice:
+  notes: Thanks, O'Brien # comment
+`
+	toml := `# Simple Invoice
+[server]
+port = 9090
+data_dir = "/srv/invoices"
+socket_mode = "0600"
+
+[smtp]
+host = "smtp.example.com" # comment
+password = "p#ss 'word'"
+
+[auth]
+trusted_proxies = ["10.0.0.0/8", "::1/128"]
+
+[invoice]
+notes = "Thanks, O'Brien"
+`
+	want := map[string]string{
+		"server.port":          "9090",
+		"server.data_dir":      "/srv/invoices",
+		"server.socket_mode":   "0600",
+		"smtp.host":            "smtp.example.com",
+		"smtp.password":        "p#ss 'word'",
+		"auth.trusted_proxies": "10.0.0.0/8,::1/128",
+		"invoice.notes":        "Thanks, O'Brien",
+	}
+
+	for name, content := range map[string]string{"config.yaml": yaml, "config.toml": toml} {
+		config, err := LoadConfigFile(writeConfigFile(t, name, content))
+		if err != nil {
+			t.Fatalf("%s: LoadConfigFile failed: %v", name, err)
+		}
+		if len(config.values) != len(want) {
+			t.Errorf("%s: expected %d options, got %v", name, len(want), config.values)
+		}
+		for key, value := range want {
+			if config.values[key] != value {
+				t.Errorf("%s: expected %s = %q, got %q", name, key, value, config.values[key])
+			}
+		}
+	}
+}
+
+func TestLoadConfigFileValidation(t *testing.T) {
+	_, err := LoadConfigFile(writeConfigFile(t, "config.toml", `
+[server]
+port = 70000
+[smtp]
+hots = "smtp.example.com"
+[backup]
+cron = "every night"
+[database]
+url = "mysql://localhost/invoice"
+`))
+	if err == nil {
+		t.Fatal("Expected invalid options to be rejected")
+	}
+	for _, expected := range []string{"server.port", "unknown option smtp.hots", "backup.cron", "database.url"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q in %v", expected, err)
+		}
+	}
+
+	if _, err := LoadConfigFile(writeConfigFile(t, "config.ini", "port=1")); err == nil {
+		t.Error("Expected an unsupported file type to be rejected")
+	}
+	if _, err := LoadConfigFile(writeConfigFile(t, "config.yaml", "smtp:\n  host: a\n  host: b\n")); err == nil {
+		t.Error("Expected a key set twice to be rejected")
+	}
+	if _, err := LoadConfigFile(writeConfigFile(t, "config.yaml", "smtp.host: a\nsmtp:\n  host: b\n")); err == nil {
+		t.Error("Expected a key set twice through a dotted key to be rejected")
+	}
+	if _, err := LoadConfigFile(writeConfigFile(t, "config.toml", "[smtp\nhost = \"a\"\n")); err == nil {
+		t.Error("Expected invalid TOML to be rejected")
+	}
+}
+
+func TestConfigEnvironmentTakesPrecedence(t *testing.T) {
+	t.Setenv("SMTP_HOST", "env.example.com")
+	t.Setenv("SMTP_PORT", "")
+	os.Unsetenv("SMTP_PORT")
+	t.Setenv("SMTP_PASSWORD", "")
+	os.Unsetenv("SMTP_PASSWORD")
+
+	config, err := LoadConfigFile(writeConfigFile(t, "config.yaml", "smtp:\n  host: file.example.com\n  port: 2525\n  password: secret\n"))
+	if err != nil {
+		t.Fatalf("LoadConfigFile failed: %v", err)
+	}
+	if err := config.Apply(); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if os.Getenv("SMTP_HOST") != "env.example.com" || os.Getenv("SMTP_PORT") != "2525" {
+		t.Errorf("Expected SMTP_HOST from the environment and SMTP_PORT from the file, got %q and %q",
+			os.Getenv("SMTP_HOST"), os.Getenv("SMTP_PORT"))
+	}
+
+	values, err := config.Effective()
+	if err != nil {
+		t.Fatalf("Effective failed: %v", err)
+	}
+	sources := make(map[string]ConfigValue)
+	for _, value := range values {
+		sources[value.Key] = value
+	}
+	if sources[SettingSMTPHost].Source != "environment" || sources[SettingSMTPPort].Source != "file" || sources["server.port"].Source != "default" {
+		t.Errorf("Unexpected sources: %+v, %+v, %+v", sources[SettingSMTPHost], sources[SettingSMTPPort], sources["server.port"])
+	}
+	if sources[SettingSMTPPassword].Value != "********" {
+		t.Errorf("Expected the password to be masked, got %q", sources[SettingSMTPPassword].Value)
+	}
+
+	t.Setenv("PORT", "http")
+	if _, err := config.Effective(); err == nil || !strings.Contains(err.Error(), "PORT") {
+		t.Errorf("Expected an invalid PORT in the environment to be reported, got %v", err)
+	}
+}
+
+func TestParseListenAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		network string
+		address string
+	}{
+		{":8080", "tcp", ":8080"},
+		{"127.0.0.1:8080", "tcp", "127.0.0.1:8080"},
+		{"[::1]:8080", "tcp", "[::1]:8080"},
+		{"unix:/run/simple-invoice.sock", "unix", "/run/simple-invoice.sock"},
+	}
+	for _, tt := range tests {
+		network, address, err := ParseListenAddr(tt.addr)
+		if err != nil || network != tt.network || address != tt.address {
+			t.Errorf("ParseListenAddr(%q) = %q, %q, %v", tt.addr, network, address, err)
+		}
+	}
+
+	for _, addr := range []string{"8080", "localhost", ":http", ":70000", "unix:"} {
+		if _, _, err := ParseListenAddr(addr); err == nil {
+			t.Errorf("Expected %q to be rejected", addr)
+		}
+	}
+}
+
+func TestCheckTLSOptions(t *testing.T) {
+	tests := []struct {
+		env   map[string]string
+		valid bool
+	}{
+		{map[string]string{}, true},
+		{map[string]string{"TLS_CERT_FILE": "cert.pem", "TLS_KEY_FILE": "key.pem"}, true},
+		{map[string]string{"TLS_DOMAINS": "invoices.example.com", "TLS_EMAIL": "admin@example.com", "TLS_HTTP_ADDR": ":80"}, true},
+		{map[string]string{"TLS_CERT_FILE": "cert.pem"}, false},
+		{map[string]string{"TLS_CERT_FILE": "cert.pem", "TLS_KEY_FILE": "key.pem", "TLS_DOMAINS": "invoices.example.com"}, false},
+		{map[string]string{"TLS_EMAIL": "admin@example.com"}, false},
+	}
+	for _, tt := range tests {
+		for _, name := range []string{"TLS_CERT_FILE", "TLS_KEY_FILE", "TLS_DOMAINS", "TLS_EMAIL", "TLS_HTTP_ADDR"} {
+			t.Setenv(name, tt.env[name])
+		}
+		if err := CheckTLSOptions(); (err == nil) != tt.valid {
+			t.Errorf("CheckTLSOptions() with %v = %v", tt.env, err)
+		}
+	}
+}
+
+func TestCheckGRPCOptions(t *testing.T) {
+	tests := []struct {
+		addr, token string
+		valid       bool
+	}{
+		{"", "", true},
+		{":9090", "secret", true},
+		{"unix:/run/simple-invoice/grpc.sock", "", true},
+		{":9090", "", false},
+	}
+	for _, tt := range tests {
+		t.Setenv("GRPC_LISTEN_ADDR", tt.addr)
+		t.Setenv("GRPC_TOKEN", tt.token)
+		if err := CheckGRPCOptions(); (err == nil) != tt.valid {
+			t.Errorf("CheckGRPCOptions() with %q and token %q = %v", tt.addr, tt.token, err)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// contractCheckInterval is how often contracts are checked for invoices due
+const contractCheckInterval = time.Hour
+
+// errContractChanged is returned when a contract was invoiced by someone else
+// while its invoice was generated
+var errContractChanged = errors.New("contract was invoiced in the meantime")
+
+// ContractService manages retainer contracts and generates their monthly invoices
+type ContractService struct {
+	dbService   *DBService
+	hookService *HookService
+	logger      *Logger
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewContractService creates a new ContractService
+func NewContractService(dbService *DBService, logger *Logger) *ContractService {
+	return &ContractService{
+		dbService: dbService,
+		logger:    logger,
+	}
+}
+
+// SetHookService sets the hooks run for the invoices generated for contracts
+func (s *ContractService) SetHookService(hookService *HookService) {
+	s.hookService = hookService
+}
+
+const contractColumns = `id, business_id, client_id, name, reference, start_date, end_date, monthly_amount, currency,
+	vat_rate, reverse_charge_vat, payment_term_days, billing, active, invoiced_through, created_at`
+
+// scanContract scans a contracts row selected with contractColumns
+func scanContract(row rowScanner) (*models.Contract, error) {
+	var contract models.Contract
+	var startDate, endDate, invoicedThrough string
+	err := row.Scan(&contract.ID, &contract.BusinessID, &contract.ClientID, &contract.Name, &contract.Reference,
+		&startDate, &endDate, &contract.MonthlyAmount, &contract.Currency, &contract.VatRate, &contract.ReverseChargeVat,
+		&contract.PaymentTermDays, &contract.Billing, &contract.Active, &invoicedThrough, &contract.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	contract.StartDate = parseOptionalDate(startDate)
+	contract.EndDate = parseOptionalDate(endDate)
+	contract.InvoicedThrough = parseOptionalDate(invoicedThrough)
+	return &contract, nil
+}
+
+// Save creates or updates a contract after validating it
+func (s *ContractService) Save(contract *models.Contract) error {
+	if err := contract.Validate(); err != nil {
+		return err
+	}
+
+	db := s.dbService.GetDB()
+	if contract.ID == 0 {
+		contract.CreatedAt = time.Now().UTC()
+		err := db.QueryRow(`
+			INSERT INTO contracts (business_id, client_id, name, reference, start_date, end_date, monthly_amount, currency,
+				vat_rate, reverse_charge_vat, payment_term_days, billing, active, invoiced_through, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id
+		`, contract.BusinessID, contract.ClientID, contract.Name, contract.Reference, formatOptionalDate(contract.StartDate),
+			formatOptionalDate(contract.EndDate), contract.MonthlyAmount, contract.Currency, contract.VatRate, contract.ReverseChargeVat,
+			contract.PaymentTermDays, contract.Billing, contract.Active, formatOptionalDate(contract.InvoicedThrough), contract.CreatedAt).Scan(&contract.ID)
+		if err != nil {
+			return fmt.Errorf("failed to create contract: %w", err)
+		}
+		s.logger.Info("Created contract %d (%s) for client %d", contract.ID, contract.Name, contract.ClientID)
+		return nil
+	}
+
+	result, err := db.Exec(`
+		UPDATE contracts
+		SET business_id = ?, client_id = ?, name = ?, reference = ?, start_date = ?, end_date = ?, monthly_amount = ?, currency = ?,
+			vat_rate = ?, reverse_charge_vat = ?, payment_term_days = ?, billing = ?, active = ?, invoiced_through = ?
+		WHERE id = ?
+	`, contract.BusinessID, contract.ClientID, contract.Name, contract.Reference, formatOptionalDate(contract.StartDate),
+		formatOptionalDate(contract.EndDate), contract.MonthlyAmount, contract.Currency, contract.VatRate, contract.ReverseChargeVat,
+		contract.PaymentTermDays, contract.Billing, contract.Active, formatOptionalDate(contract.InvoicedThrough), contract.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update contract: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Get returns a contract, or sql.ErrNoRows if it does not exist
+func (s *ContractService) Get(id int) (*models.Contract, error) {
+	return scanContract(s.dbService.GetDB().QueryRow(`SELECT `+contractColumns+` FROM contracts WHERE id = ?`, id))
+}
+
+// List returns the contracts ordered by start date
+func (s *ContractService) List() ([]models.Contract, error) {
+	rows, err := s.dbService.GetDB().Query(`SELECT ` + contractColumns + ` FROM contracts ORDER BY start_date, id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query contracts: %w", err)
+	}
+	defer rows.Close()
+
+	var contracts []models.Contract
+	for rows.Next() {
+		contract, err := scanContract(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan contract: %w", err)
+		}
+		contracts = append(contracts, *contract)
+	}
+	return contracts, rows.Err()
+}
+
+// Delete removes a contract. The invoices generated for it are kept.
+func (s *ContractService) Delete(id int) error {
+	tx, err := s.dbService.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM contracts WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete contract: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec(`DELETE FROM contract_invoices WHERE contract_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to unlink invoices: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.logger.Info("Deleted contract %d", id)
+	return nil
+}
+
+// Invoices returns the invoices generated for a contract, newest period first
+func (s *ContractService) Invoices(contractID int) ([]models.ContractInvoice, error) {
+	rows, err := s.dbService.GetDB().Query(`
+		SELECT ci.contract_id, ci.invoice_id, i.invoice_number, ci.period_start, ci.period_end
+		FROM contract_invoices ci
+		JOIN invoices i ON i.id = ci.invoice_id
+		WHERE ci.contract_id = ?
+		ORDER BY ci.period_start DESC
+	`, contractID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query contract invoices: %w", err)
+	}
+	defer rows.Close()
+
+	invoices := []models.ContractInvoice{}
+	for rows.Next() {
+		var invoice models.ContractInvoice
+		var start, end string
+		if err := rows.Scan(&invoice.ContractID, &invoice.InvoiceID, &invoice.InvoiceNumber, &start, &end); err != nil {
+			return nil, fmt.Errorf("failed to scan contract invoice: %w", err)
+		}
+		invoice.PeriodStart, invoice.PeriodEnd = parseOptionalDate(start), parseOptionalDate(end)
+		invoices = append(invoices, invoice)
+	}
+	return invoices, rows.Err()
+}
+
+// GenerateInvoices creates the draft invoices due on the given day for all
+// active contracts of clients that are not deleted, one per month. prepare,
+// if not nil, is called on every invoice before it is saved, e.g. to set
+// its exchange rate. A contract that fails is skipped until the next run.
+func (s *ContractService) GenerateInvoices(today time.Time, prepare func(invoice *models.Invoice)) ([]models.Invoice, error) {
+	rows, err := s.dbService.GetDB().Query(`
+		SELECT ` + contractColumns + ` FROM contracts
+		WHERE active = 1 AND client_id IN (SELECT id FROM clients WHERE deleted = 0 OR deleted IS NULL)
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query contracts: %w", err)
+	}
+	var contracts []*models.Contract
+	for rows.Next() {
+		contract, err := scanContract(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan contract: %w", err)
+		}
+		contracts = append(contracts, contract)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	issueDate := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	var created []models.Invoice
+	var errs []error
+	for _, contract := range contracts {
+		for _, period := range contract.DuePeriods(issueDate) {
+			invoice, err := s.invoicePeriod(contract, period, issueDate, prepare)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("contract %d (%s), %s: %w", contract.ID, contract.Name, period.Start.Format("2006-01"), err))
+				break
+			}
+			s.logger.Info("Generated invoice %s for contract %d (%s), %s to %s", invoice.InvoiceNumber, contract.ID, contract.Name,
+				period.Start.Format("2006-01-02"), period.End.Format("2006-01-02"))
+			created = append(created, *invoice)
+		}
+	}
+	return created, errors.Join(errs...)
+}
+
+// invoicePeriod saves the invoice of a contract for a period and marks the
+// contract as invoiced through its end in the same transaction
+func (s *ContractService) invoicePeriod(contract *models.Contract, period models.ContractPeriod, issueDate time.Time, prepare func(invoice *models.Invoice)) (*models.Invoice, error) {
+	invoice := &models.Invoice{
+		BusinessID:         contract.BusinessID,
+		ClientID:           contract.ClientID,
+		IssueDate:          issueDate,
+		DueDate:            issueDate.AddDate(0, 0, contract.PaymentTermDays),
+		VatRate:            contract.VatRate,
+		ReverseChargeVat:   contract.ReverseChargeVat,
+		Currency:           contract.Currency,
+		Status:             models.InvoiceStatusDraft,
+		Type:               models.InvoiceTypeInvoice,
+		ContractReference:  contract.Reference,
+		ServicePeriodStart: period.Start,
+		ServicePeriodEnd:   period.End,
+	}
+	items := []models.InvoiceItem{contract.InvoiceItem(period)}
+	invoice.ApplyTotals(items)
+	if prepare != nil {
+		prepare(invoice)
+	}
+	if s.hookService != nil {
+		if err := s.hookService.InvoiceCreate(invoice, items); err != nil {
+			return nil, err
+		}
+	}
+
+	previous := formatOptionalDate(contract.InvoicedThrough)
+	then := func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `UPDATE contracts SET invoiced_through = ? WHERE id = ? AND invoiced_through = ?`,
+			formatOptionalDate(period.End), contract.ID, previous)
+		if err != nil {
+			return fmt.Errorf("failed to update contract: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return errContractChanged
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO contract_invoices (contract_id, invoice_id, period_start, period_end) VALUES (?, ?, ?, ?)
+		`, contract.ID, invoice.ID, formatOptionalDate(period.Start), formatOptionalDate(period.End)); err != nil {
+			return fmt.Errorf("failed to link invoice to contract: %w", err)
+		}
+		return queueInvoicePDFs(invoice)(ctx, tx)
+	}
+	if err := s.dbService.saveInvoices([]*models.Invoice{invoice}, [][]models.InvoiceItem{items}, true, then); err != nil {
+		return nil, err
+	}
+	contract.InvoicedThrough = period.End
+	return invoice, nil
+}
+
+// Start generates the invoices due now and then every hour. prepare is
+// passed to GenerateInvoices.
+func (s *ContractService) Start(prepare func(invoice *models.Invoice)) {
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(contractCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			RunProtected(s.logger, "contract invoicing", func() {
+				if _, err := s.GenerateInvoices(time.Now(), prepare); err != nil {
+					s.logger.Error("Failed to generate contract invoices: %v", err)
+				}
+			})
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops the invoice generation started by Start
+func (s *ContractService) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
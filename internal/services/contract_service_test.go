@@ -0,0 +1,85 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestContractGenerateInvoices(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+	contractService := NewContractService(dbService, NewLogger(ERROR))
+
+	client := &models.Client{Name: "Acme GmbH", Country: "DE"}
+	if err := dbService.SaveClient(client); err != nil {
+		t.Fatalf("SaveClient failed: %v", err)
+	}
+	contract := &models.Contract{
+		BusinessID:    1,
+		ClientID:      client.ID,
+		Name:          "Development retainer",
+		Reference:     "MSA-2024-05",
+		StartDate:     time.Date(2024, 5, 16, 0, 0, 0, 0, time.UTC),
+		MonthlyAmount: 310000,
+		Currency:      "EUR",
+		VatRate:       19,
+		Active:        true,
+	}
+	if err := contractService.Save(contract); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	paused := &models.Contract{BusinessID: 1, ClientID: client.ID, Name: "Support", StartDate: contract.StartDate, MonthlyAmount: 50000, Currency: "EUR"}
+	if err := contractService.Save(paused); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	today := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	invoices, err := contractService.GenerateInvoices(today, nil)
+	if err != nil {
+		t.Fatalf("GenerateInvoices failed: %v", err)
+	}
+	if len(invoices) != 2 {
+		t.Fatalf("Expected invoices for May and June, got %d", len(invoices))
+	}
+
+	may, items, err := dbService.GetInvoice(invoices[0].ID)
+	if err != nil {
+		t.Fatalf("GetInvoice failed: %v", err)
+	}
+	if may.Status != "draft" || may.ContractReference != "MSA-2024-05" || may.TotalAmount != 190400 || may.VatAmount != 30400 {
+		t.Errorf("Unexpected May invoice: %+v", may)
+	}
+	if !may.ServicePeriodStart.Equal(contract.StartDate) || may.ServicePeriodEnd.Day() != 31 || !may.DueDate.Equal(time.Date(2024, 7, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected May dates: %v to %v, due %v", may.ServicePeriodStart, may.ServicePeriodEnd, may.DueDate)
+	}
+	if len(items) != 1 || items[0].Description != "Development retainer, 16–31 May 2024 (16 of 31 days)" {
+		t.Errorf("Unexpected May items: %+v", items)
+	}
+
+	// Running again creates nothing new
+	if again, err := contractService.GenerateInvoices(today, nil); err != nil || len(again) != 0 {
+		t.Errorf("Expected no further invoices, got %d (%v)", len(again), err)
+	}
+
+	stored, err := contractService.Get(contract.ID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !stored.InvoicedThrough.Equal(time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the contract to be invoiced through June, got %v", stored.InvoicedThrough)
+	}
+	linked, err := contractService.Invoices(contract.ID)
+	if err != nil || len(linked) != 2 || linked[1].InvoiceNumber != invoices[0].InvoiceNumber {
+		t.Errorf("Unexpected contract invoices: %+v (%v)", linked, err)
+	}
+
+	// Deleting an invoice unlinks it from the contract
+	if err := dbService.DeleteInvoice(invoices[1].ID); err != nil {
+		t.Fatalf("DeleteInvoice failed: %v", err)
+	}
+	if linked, _ := contractService.Invoices(contract.ID); len(linked) != 1 {
+		t.Errorf("Expected one linked invoice after deleting, got %d", len(linked))
+	}
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+)
+
+// dialect is what differs between the databases a DBService can use. The
+// queries themselves are written in the SQL both SQLite and PostgreSQL accept,
+// with ? placeholders.
+type dialect interface {
+	// Name is the name of the database in messages
+	Name() string
+	// tableExists reports whether a table exists
+	tableExists(db *sql.DB, table string) (bool, error)
+	// optimize runs maintenance before the connection is closed
+	optimize(db *sql.DB, logger *Logger)
+}
+
+// DatabaseURL returns the PostgreSQL URL set in DATABASE_URL, or an empty
+// string when the SQLite database in DATA_DIR is used
+func DatabaseURL() string {
+	return strings.TrimSpace(os.Getenv("DATABASE_URL"))
+}
+
+// isPostgresURL reports whether a database URL is a postgres:// or
+// postgresql:// URL
+func isPostgresURL(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// sqliteDialect is the default database, a file in DATA_DIR
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string {
+	return "SQLite"
+}
+
+func (sqliteDialect) tableExists(db *sql.DB, table string) (bool, error) {
+	var exists bool
+	err := db.QueryRow(`SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&exists)
+	return exists, err
+}
+
+func (sqliteDialect) optimize(db *sql.DB, logger *Logger) {
+	// Execute PRAGMA to optimize database before closing
+	if _, err := db.Exec("PRAGMA optimize"); err != nil {
+		logger.Warn("Failed to optimize database: %v", err)
+	}
+
+	// Execute VACUUM to compact the database
+	if _, err := db.Exec("VACUUM"); err != nil {
+		logger.Warn("Failed to vacuum database: %v", err)
+	}
+}
@@ -0,0 +1,330 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// defaultSlowQueryThreshold is how long a statement may take before it is
+// logged when DB_SLOW_QUERY_MS is not set
+const defaultSlowQueryThreshold = 500 * time.Millisecond
+
+// SlowQueryThreshold returns how long a statement may take before it is
+// logged, from DB_SLOW_QUERY_MS. Zero disables slow query logging.
+func SlowQueryThreshold() time.Duration {
+	value := strings.TrimSpace(os.Getenv("DB_SLOW_QUERY_MS"))
+	if value == "" {
+		return defaultSlowQueryThreshold
+	}
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms < 0 {
+		return defaultSlowQueryThreshold
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// openSQLite opens the SQLite database at dsn with slow queries logged
+func openSQLite(dsn string, logger *Logger) *sql.DB {
+	return sql.OpenDB(instrumentConnector(sqliteConnector{dsn: dsn}, logger))
+}
+
+// sqliteConnector opens SQLite connections like sql.Open("sqlite3", dsn)
+type sqliteConnector struct {
+	dsn string
+}
+
+func (c sqliteConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.Driver().Open(c.dsn)
+}
+
+func (c sqliteConnector) Driver() driver.Driver {
+	return &sqlite3.SQLiteDriver{}
+}
+
+// instrumentConnector wraps a connector so that statements and commits taking
+// longer than SlowQueryThreshold are logged as warnings, with the statement
+// and the types of its arguments but never their values. The connector is
+// returned unchanged when slow query logging is disabled.
+func instrumentConnector(connector driver.Connector, logger *Logger) driver.Connector {
+	threshold := SlowQueryThreshold()
+	if threshold <= 0 {
+		return connector
+	}
+	return slowQueryConnector{Connector: connector, log: &slowQueryLog{logger: logger, threshold: threshold}}
+}
+
+// slowQueryLog logs statements that took longer than threshold
+type slowQueryLog struct {
+	logger    *Logger
+	threshold time.Duration
+}
+
+// observe logs a statement started at start if it was slow
+func (l *slowQueryLog) observe(start time.Time, query string, args []driver.NamedValue, err error) {
+	elapsed := time.Since(start)
+	if elapsed < l.threshold {
+		return
+	}
+	status := "ok"
+	if err != nil && err != driver.ErrSkip {
+		status = "error"
+	}
+	l.logger.Warn("slow_query duration=%s status=%s query=%q args=%s",
+		elapsed.Round(time.Millisecond), status, compactQuery(query), redactArgs(args))
+}
+
+// compactQuery collapses the whitespace of a query to one line
+func compactQuery(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// redactArgs describes the arguments of a statement by their types, so slow
+// queries can be logged without client data, amounts or secrets
+func redactArgs(args []driver.NamedValue) string {
+	types := make([]string, len(args))
+	for i, arg := range args {
+		switch v := arg.Value.(type) {
+		case nil:
+			types[i] = "NULL"
+		case string:
+			types[i] = fmt.Sprintf("string(%d)", len(v))
+		case []byte:
+			types[i] = fmt.Sprintf("bytes(%d)", len(v))
+		case time.Time:
+			types[i] = "time"
+		default:
+			types[i] = reflect.TypeOf(v).String()
+		}
+	}
+	return "[" + strings.Join(types, " ") + "]"
+}
+
+// plainValues converts arguments for the pre-context driver interfaces
+func plainValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
+
+type slowQueryConnector struct {
+	driver.Connector
+	log *slowQueryLog
+}
+
+func (c slowQueryConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &slowQueryConn{Conn: conn, log: c.log}, nil
+}
+
+// slowQueryConn times the statements of a connection. The optional driver
+// interfaces are passed on to the wrapped connection, or return driver.ErrSkip
+// so database/sql falls back to the same behavior as without the wrapper.
+type slowQueryConn struct {
+	driver.Conn
+	log *slowQueryLog
+}
+
+func (c *slowQueryConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &slowQueryStmt{Stmt: stmt, conn: c.Conn, query: query, log: c.log}, nil
+}
+
+func (c *slowQueryConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	c.log.observe(start, query, args, err)
+	return result, err
+}
+
+func (c *slowQueryConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err != nil {
+		c.log.observe(start, query, args, err)
+		return nil, err
+	}
+	return &slowQueryRows{Rows: rows, start: start, query: query, args: args, log: c.log}, nil
+}
+
+func (c *slowQueryConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+	var err error
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &slowQueryTx{Tx: tx, log: c.log}, nil
+}
+
+func (c *slowQueryConn) CheckNamedValue(arg *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(arg)
+	}
+	return driver.ErrSkip
+}
+
+func (c *slowQueryConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *slowQueryConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *slowQueryConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// slowQueryTx times commits, where SQLite writes the changes to disk and
+// waits for other writers
+type slowQueryTx struct {
+	driver.Tx
+	log *slowQueryLog
+}
+
+func (t *slowQueryTx) Commit() error {
+	start := time.Now()
+	err := t.Tx.Commit()
+	t.log.observe(start, "COMMIT", nil, err)
+	return err
+}
+
+// slowQueryStmt times the executions of a prepared statement
+type slowQueryStmt struct {
+	driver.Stmt
+	conn  driver.Conn
+	query string
+	log   *slowQueryLog
+}
+
+func (s *slowQueryStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	var result driver.Result
+	var err error
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		result, err = execer.ExecContext(ctx, args)
+	} else {
+		result, err = s.Stmt.Exec(plainValues(args))
+	}
+	s.log.observe(start, s.query, args, err)
+	return result, err
+}
+
+func (s *slowQueryStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	var rows driver.Rows
+	var err error
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(plainValues(args))
+	}
+	if err != nil {
+		s.log.observe(start, s.query, args, err)
+		return nil, err
+	}
+	return &slowQueryRows{Rows: rows, start: start, query: s.query, args: args, log: s.log}, nil
+}
+
+// CheckNamedValue passes arguments to the checker of the statement, or else of
+// the connection, since database/sql only asks the statement when it has one
+func (s *slowQueryStmt) CheckNamedValue(arg *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(arg)
+	}
+	if checker, ok := s.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(arg)
+	}
+	return driver.ErrSkip
+}
+
+// slowQueryRows times a query until its rows are closed, since SQLite runs
+// most of a query while the rows are read
+type slowQueryRows struct {
+	driver.Rows
+	start time.Time
+	query string
+	args  []driver.NamedValue
+	log   *slowQueryLog
+	err   error
+}
+
+func (r *slowQueryRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return err
+}
+
+func (r *slowQueryRows) Close() error {
+	err := r.Rows.Close()
+	r.log.observe(r.start, r.query, r.args, r.err)
+	return err
+}
+
+func (r *slowQueryRows) ColumnTypeScanType(index int) reflect.Type {
+	if rows, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return rows.ColumnTypeScanType(index)
+	}
+	return reflect.TypeFor[any]()
+}
+
+func (r *slowQueryRows) ColumnTypeDatabaseTypeName(index int) string {
+	if rows, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return rows.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r *slowQueryRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if rows, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return rows.ColumnTypeNullable(index)
+	}
+	return false, false
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowQueryLogRedactsArguments(t *testing.T) {
+	var output bytes.Buffer
+	logger := NewLogger(WARN)
+	logger.SetOutput(&output)
+
+	// Every statement is slow with a threshold of a nanosecond
+	connector := slowQueryConnector{
+		Connector: sqliteConnector{dsn: ":memory:"},
+		log:       &slowQueryLog{logger: logger, threshold: time.Nanosecond},
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(`CREATE TABLE clients (name TEXT, vat_rate REAL)`); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO clients (name, vat_rate)
+		VALUES (?, ?)`, "Globex Corporation", 19.5); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	var name string
+	if err := db.QueryRow(`SELECT name FROM clients WHERE name = ?`, "Globex Corporation").Scan(&name); err != nil || name != "Globex Corporation" {
+		t.Fatalf("Expected the row to be read through the wrapper, got %q, %v", name, err)
+	}
+
+	log := output.String()
+	for _, want := range []string{
+		`query="INSERT INTO clients (name, vat_rate) VALUES (?, ?)" args=[string(18) float64]`,
+		`query="SELECT name FROM clients WHERE name = ?" args=[string(18)]`,
+		`query="COMMIT"`,
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("Expected the slow query log to contain %s, got:\n%s", want, log)
+		}
+	}
+	if strings.Contains(log, "Globex") || strings.Contains(log, "19.5") {
+		t.Errorf("Expected arguments to be redacted, got:\n%s", log)
+	}
+}
+
+func TestSlowQueryThreshold(t *testing.T) {
+	for _, tt := range []struct {
+		env  string
+		want time.Duration
+	}{
+		{"", defaultSlowQueryThreshold},
+		{"250", 250 * time.Millisecond},
+		{"0", 0},
+		{"soon", defaultSlowQueryThreshold},
+	} {
+		t.Setenv("DB_SLOW_QUERY_MS", tt.env)
+		if got := SlowQueryThreshold(); got != tt.want {
+			t.Errorf("DB_SLOW_QUERY_MS=%q: expected %s, got %s", tt.env, tt.want, got)
+		}
+	}
+}
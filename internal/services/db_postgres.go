@@ -0,0 +1,571 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgresMigrationLock is the advisory lock held while the schema is brought
+// up to date, so instances starting at the same time do not race
+const postgresMigrationLock int64 = 0x73696e766f696365
+
+// postgresInstanceLock is the class of the advisory lock the server holds on
+// its schema for as long as it runs, see lockPostgresInstance
+const postgresInstanceLock int32 = 0x73696e76
+
+// postgresTimestampFormat is how times are sent, the format SQLite stores them in
+const postgresTimestampFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+// postgresDialect is a PostgreSQL database selected with DATABASE_URL. Like
+// the SQLite database, it is used by one server at a time.
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string {
+	return "PostgreSQL"
+}
+
+func (postgresDialect) tableExists(db *sql.DB, table string) (bool, error) {
+	var exists bool
+	err := db.QueryRow(`
+		SELECT COUNT(*) > 0 FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_name = ?
+	`, table).Scan(&exists)
+	return exists, err
+}
+
+// optimize does nothing, PostgreSQL vacuums and analyzes tables itself
+func (postgresDialect) optimize(db *sql.DB, logger *Logger) {}
+
+// newPostgresDBService connects to the PostgreSQL database at dsn and brings
+// its schema up to date. PDFs, logos and other files are still kept in dataDir.
+func newPostgresDBService(dsn, dataDir string, logger *Logger) (*DBService, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	unlock, err := lockPostgresInstance(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db, err := connectPostgres(dsn, logger, false)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	service := &DBService{
+		db:      db,
+		dialect: postgresDialect{},
+		dataDir: dataDir,
+		logger:  logger,
+		unlock:  unlock,
+	}
+	if err := service.migratePostgres(ctx); err != nil {
+		db.Close()
+		unlock()
+		logger.Error("Failed to initialize database: %v", err)
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	logger.Info("Database service initialized successfully")
+	return service, nil
+}
+
+// openPostgresDBService connects to the PostgreSQL database at dsn without
+// migrating it, see OpenDBService. Read-only connections run every
+// transaction read-only.
+func openPostgresDBService(dsn, dataDir string, logger *Logger, readOnly bool) (*DBService, error) {
+	db, err := connectPostgres(dsn, logger, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	return &DBService{
+		db:              db,
+		dialect:         postgresDialect{},
+		dataDir:         dataDir,
+		logger:          logger,
+		skipMaintenance: true,
+	}, nil
+}
+
+// connectPostgres opens and checks a connection pool to the PostgreSQL
+// database at dsn
+func connectPostgres(dsn string, logger *Logger, readOnly bool) (*sql.DB, error) {
+	if !isPostgresURL(dsn) {
+		return nil, fmt.Errorf("DATABASE_URL must be a postgres:// or postgresql:// URL")
+	}
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		logger.Error("Invalid DATABASE_URL: %v", err)
+		return nil, fmt.Errorf("invalid DATABASE_URL: %w", err)
+	}
+	if readOnly {
+		config.RuntimeParams["default_transaction_read_only"] = "on"
+	}
+	logger.Info("Using PostgreSQL database %s on %s", config.Database, config.Host)
+
+	// One connection, like SQLite: the services read and write in separate
+	// statements and transactions that must not interleave
+	db := sql.OpenDB(instrumentConnector(postgresConnector{stdlib.GetConnector(*config)}, logger))
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		logger.Error("Failed to connect to PostgreSQL: %v", err)
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+	return db, nil
+}
+
+// lockPostgresInstance takes an advisory lock on the schema of the database
+// at dsn, on a connection of its own that holds it until the returned unlock
+// function is called or the process exits. Only one server can use a
+// database, as the job queue, the scheduled checks and live updates assume
+// they run in a single process.
+func lockPostgresInstance(ctx context.Context, dsn string) (func(), error) {
+	if !isPostgresURL(dsn) {
+		return nil, fmt.Errorf("DATABASE_URL must be a postgres:// or postgresql:// URL")
+	}
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DATABASE_URL: %w", err)
+	}
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+
+	var locked bool
+	err = conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1, hashtext(current_schema()))`, postgresInstanceLock).Scan(&locked)
+	if err == nil && !locked {
+		err = fmt.Errorf("%w (another server uses the PostgreSQL database)", ErrInstanceLocked)
+	}
+	if err != nil {
+		conn.Close(ctx)
+		return nil, err
+	}
+	return func() { conn.Close(context.Background()) }, nil
+}
+
+// migratePostgres creates the tables, columns and indexes of the SQLite
+// schema that the PostgreSQL database is missing. The schema is defined once,
+// by initDB, and translated, so both databases are migrated by the same
+// release. Columns are only ever added, never changed or dropped.
+func (s *DBService) migratePostgres(ctx context.Context) error {
+	tables, err := sqliteSchema()
+	if err != nil {
+		return fmt.Errorf("failed to read the reference schema: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(?)`, postgresMigrationLock); err != nil {
+		return fmt.Errorf("failed to lock the schema: %w", err)
+	}
+
+	for _, table := range tables {
+		statement, err := postgresCreateTable(table)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to create table %s: %w", table.name, err)
+		}
+
+		existing := make(map[string]bool)
+		rows, err := tx.QueryContext(ctx, `
+			SELECT column_name FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = ?
+		`, table.name)
+		if err != nil {
+			return fmt.Errorf("failed to read columns of %s: %w", table.name, err)
+		}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to read columns of %s: %w", table.name, err)
+			}
+			existing[name] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to read columns of %s: %w", table.name, err)
+		}
+
+		for _, column := range table.columns {
+			if existing[column.name] {
+				continue
+			}
+			definition, err := postgresAddedColumn(column)
+			if err != nil {
+				return fmt.Errorf("%s: %w", table.name, err)
+			}
+			s.logger.Info("Adding %s column to %s table", column.name, table.name)
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s`, quoteIdentifier(table.name), definition)); err != nil {
+				return fmt.Errorf("failed to add %s column to %s: %w", column.name, table.name, err)
+			}
+		}
+
+		for _, index := range table.indexes {
+			if _, err := tx.ExecContext(ctx, postgresCreateIndex(table.name, index)); err != nil {
+				return fmt.Errorf("failed to create index %s: %w", index.name, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit schema: %w", err)
+	}
+	s.logger.Debug("PostgreSQL schema is up to date with %d tables", len(tables))
+	return nil
+}
+
+// schemaTable is a table of the reference schema
+type schemaTable struct {
+	name          string
+	columns       []schemaColumn
+	autoIncrement bool // The integer primary key is assigned by the database
+	indexes       []schemaIndex
+}
+
+type schemaColumn struct {
+	name         string
+	sqlType      string
+	notNull      bool
+	defaultValue sql.NullString // An SQL expression
+	primaryKey   int            // Position in the primary key, 0 if not part of it
+}
+
+type schemaIndex struct {
+	name    string
+	unique  bool
+	columns []string
+}
+
+// sqliteSchema creates the schema in an in-memory SQLite database and returns
+// its tables, in the order they were created
+func sqliteSchema() ([]schemaTable, error) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	// Every connection has its own in-memory database
+	db.SetMaxOpenConns(1)
+
+	reference := &DBService{db: db, dialect: sqliteDialect{}, logger: NewLogger(ERROR)}
+	if err := reference.initDB(); err != nil {
+		return nil, err
+	}
+	if err := reference.EnsureInvoiceItemsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT name, sql FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	var tables []schemaTable
+	for rows.Next() {
+		var table schemaTable
+		var definition string
+		if err := rows.Scan(&table.name, &definition); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		table.autoIncrement = strings.Contains(strings.ToUpper(definition), "AUTOINCREMENT")
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range tables {
+		table := &tables[i]
+		if table.columns, err = sqliteColumns(db, table.name); err != nil {
+			return nil, err
+		}
+		if table.indexes, err = sqliteIndexes(db, table.name); err != nil {
+			return nil, err
+		}
+	}
+	return tables, nil
+}
+
+func sqliteColumns(db *sql.DB, table string) ([]schemaColumn, error) {
+	rows, err := db.Query(`SELECT name, type, "notnull", dflt_value, pk FROM pragma_table_info(?) ORDER BY cid`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []schemaColumn
+	for rows.Next() {
+		var column schemaColumn
+		if err := rows.Scan(&column.name, &column.sqlType, &column.notNull, &column.defaultValue, &column.primaryKey); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// sqliteIndexes returns the indexes of a table and its unique constraints,
+// which are created as unique indexes so they can be added to existing tables
+func sqliteIndexes(db *sql.DB, table string) ([]schemaIndex, error) {
+	rows, err := db.Query(`SELECT name, "unique", origin FROM pragma_index_list(?) WHERE origin != 'pk' ORDER BY name`, table)
+	if err != nil {
+		return nil, err
+	}
+	var indexes []schemaIndex
+	var origins []string
+	for rows.Next() {
+		var index schemaIndex
+		var origin string
+		if err := rows.Scan(&index.name, &index.unique, &origin); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		indexes = append(indexes, index)
+		origins = append(origins, origin)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range indexes {
+		index := &indexes[i]
+		columnRows, err := db.Query(`SELECT name FROM pragma_index_info(?) ORDER BY seqno`, index.name)
+		if err != nil {
+			return nil, err
+		}
+		for columnRows.Next() {
+			var name string
+			if err := columnRows.Scan(&name); err != nil {
+				columnRows.Close()
+				return nil, err
+			}
+			index.columns = append(index.columns, name)
+		}
+		columnRows.Close()
+		if err := columnRows.Err(); err != nil {
+			return nil, err
+		}
+		// Unique constraints get names like sqlite_autoindex_users_1
+		if origins[i] == "u" {
+			index.name = table + "_" + strings.Join(index.columns, "_") + "_key"
+		}
+	}
+	return indexes, nil
+}
+
+// postgresColumnType maps a type of the SQLite schema to PostgreSQL
+func postgresColumnType(sqliteType string) (string, error) {
+	switch strings.ToUpper(sqliteType) {
+	case "INTEGER":
+		return "BIGINT", nil
+	case "REAL":
+		return "DOUBLE PRECISION", nil
+	case "TEXT":
+		return "TEXT", nil
+	case "TIMESTAMP":
+		return "TIMESTAMPTZ", nil
+	}
+	return "", fmt.Errorf("no PostgreSQL type for %q", sqliteType)
+}
+
+// postgresColumn returns the definition of a column in CREATE TABLE
+func postgresColumn(column schemaColumn) (string, error) {
+	columnType, err := postgresColumnType(column.sqlType)
+	if err != nil {
+		return "", fmt.Errorf("column %s: %w", column.name, err)
+	}
+	definition := quoteIdentifier(column.name) + " " + columnType
+	if column.notNull {
+		definition += " NOT NULL"
+	}
+	if column.defaultValue.Valid {
+		definition += " DEFAULT " + column.defaultValue.String
+	}
+	return definition, nil
+}
+
+// postgresAddedColumn returns the definition of a column added to an existing
+// table. A NOT NULL column without a default gets the zero value of its type
+// as default, as the table may have rows.
+func postgresAddedColumn(column schemaColumn) (string, error) {
+	if column.notNull && !column.defaultValue.Valid {
+		switch strings.ToUpper(column.sqlType) {
+		case "INTEGER", "REAL":
+			column.defaultValue = sql.NullString{String: "0", Valid: true}
+		case "TEXT":
+			column.defaultValue = sql.NullString{String: "''", Valid: true}
+		case "TIMESTAMP":
+			column.defaultValue = sql.NullString{String: "CURRENT_TIMESTAMP", Valid: true}
+		}
+	}
+	return postgresColumn(column)
+}
+
+// postgresCreateTable returns the CREATE TABLE statement of a table. Foreign
+// keys are left out, as SQLite does not enforce them either.
+func postgresCreateTable(table schemaTable) (string, error) {
+	var definitions, primaryKey []string
+	for _, column := range table.columns {
+		if column.primaryKey > 0 {
+			primaryKey = append(primaryKey, quoteIdentifier(column.name))
+		}
+		if table.autoIncrement && column.primaryKey > 0 {
+			definitions = append(definitions, quoteIdentifier(column.name)+" BIGINT GENERATED BY DEFAULT AS IDENTITY")
+			continue
+		}
+		definition, err := postgresColumn(column)
+		if err != nil {
+			return "", fmt.Errorf("table %s: %w", table.name, err)
+		}
+		definitions = append(definitions, definition)
+	}
+	if len(primaryKey) > 0 {
+		definitions = append(definitions, "PRIMARY KEY ("+strings.Join(primaryKey, ", ")+")")
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n)", quoteIdentifier(table.name), strings.Join(definitions, ",\n\t")), nil
+}
+
+// postgresCreateIndex returns the CREATE INDEX statement of an index
+func postgresCreateIndex(table string, index schemaIndex) string {
+	columns := make([]string, len(index.columns))
+	for i, column := range index.columns {
+		columns[i] = quoteIdentifier(column)
+	}
+	unique := ""
+	if index.unique {
+		unique = "UNIQUE "
+	}
+	return fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS %s ON %s (%s)", unique, quoteIdentifier(index.name), quoteIdentifier(table), strings.Join(columns, ", "))
+}
+
+func quoteIdentifier(name string) string {
+	return pgx.Identifier{name}.Sanitize()
+}
+
+// postgresConnector opens connections that run the queries written for
+// SQLite: ? placeholders are numbered and arguments are sent as text
+type postgresConnector struct {
+	driver.Connector
+}
+
+func (c postgresConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pgConn, ok := conn.(*stdlib.Conn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected PostgreSQL connection %T", conn)
+	}
+	return &postgresConn{Conn: pgConn}, nil
+}
+
+type postgresConn struct {
+	*stdlib.Conn
+}
+
+func (c *postgresConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(rebindPostgres(query))
+}
+
+func (c *postgresConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.Conn.PrepareContext(ctx, rebindPostgres(query))
+}
+
+func (c *postgresConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.Conn.ExecContext(ctx, rebindPostgres(query), args)
+}
+
+func (c *postgresConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.Conn.QueryContext(ctx, rebindPostgres(query), args)
+}
+
+// CheckNamedValue converts arguments to text, which PostgreSQL parses as the
+// type of the parameter. SQLite converts between types freely and the queries
+// rely on it, e.g. passing bools for INTEGER columns.
+func (c *postgresConn) CheckNamedValue(arg *driver.NamedValue) error {
+	value, err := driver.DefaultParameterConverter.ConvertValue(arg.Value)
+	if err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case int64:
+		value = strconv.FormatInt(v, 10)
+	case float64:
+		value = strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		value = "0"
+		if v {
+			value = "1"
+		}
+	case time.Time:
+		value = v.Format(postgresTimestampFormat)
+	}
+	arg.Value = value
+	return nil
+}
+
+// rebindPostgres numbers the ? placeholders of a query as $1, $2, ..., leaving
+// question marks in strings, quoted names and comments alone
+func rebindPostgres(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	n := 0
+	for i := 0; i < len(query); i++ {
+		end := -1 // Length of the string or comment starting at i
+		switch {
+		case query[i] == '\'' || query[i] == '"':
+			// A doubled quote inside is read as two quoted strings in a row
+			if j := strings.IndexByte(query[i+1:], query[i]); j >= 0 {
+				end = j + 2
+			}
+		case strings.HasPrefix(query[i:], "--"):
+			if j := strings.IndexByte(query[i:], '\n'); j >= 0 {
+				end = j + 1
+			}
+		case strings.HasPrefix(query[i:], "/*"):
+			if j := strings.Index(query[i+2:], "*/"); j >= 0 {
+				end = j + 4
+			}
+		case query[i] == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		default:
+			b.WriteByte(query[i])
+			continue
+		}
+		// An unterminated string or comment runs to the end of the query
+		if end < 0 {
+			end = len(query) - i
+		}
+		b.WriteString(query[i : i+end])
+		i += end - 1
+	}
+	return b.String()
+}
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestRebindPostgres(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`SELECT 1`, `SELECT 1`},
+		{`SELECT * FROM invoices WHERE id = ? AND status = ?`, `SELECT * FROM invoices WHERE id = $1 AND status = $2`},
+		{`UPDATE t SET a = '?', b = ? WHERE c = 'it''s ?'`, `UPDATE t SET a = '?', b = $1 WHERE c = 'it''s ?'`},
+		{`SELECT "what?" FROM t WHERE a = ?`, `SELECT "what?" FROM t WHERE a = $1`},
+		{"SELECT a -- why?\nFROM t WHERE b = ?", "SELECT a -- why?\nFROM t WHERE b = $1"},
+		{`SELECT /* ? */ a FROM t WHERE b = ?`, `SELECT /* ? */ a FROM t WHERE b = $1`},
+		{`SELECT a FROM t WHERE b = 'unterminated ?`, `SELECT a FROM t WHERE b = 'unterminated ?`},
+		{`SELECT ? /* unterminated ?`, `SELECT $1 /* unterminated ?`},
+	}
+	for _, tt := range tests {
+		if got := rebindPostgres(tt.query); got != tt.want {
+			t.Errorf("rebindPostgres(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestPostgresConnArguments(t *testing.T) {
+	when := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	var missing *time.Time
+	tests := []struct {
+		value interface{}
+		want  driver.Value
+	}{
+		{42, "42"},
+		{models.Money(1050), "1050"},
+		{2.5, "2.5"},
+		{true, "1"},
+		{false, "0"},
+		{"text", "text"},
+		{when, "2024-03-01 09:30:00+00:00"},
+		{&when, "2024-03-01 09:30:00+00:00"},
+		{missing, nil},
+		{sql.NullString{}, nil},
+	}
+	conn := &postgresConn{}
+	for _, tt := range tests {
+		arg := &driver.NamedValue{Value: tt.value}
+		if err := conn.CheckNamedValue(arg); err != nil {
+			t.Errorf("CheckNamedValue(%#v) failed: %v", tt.value, err)
+			continue
+		}
+		if arg.Value != tt.want {
+			t.Errorf("CheckNamedValue(%#v) = %#v, want %#v", tt.value, arg.Value, tt.want)
+		}
+	}
+}
+
+func TestPostgresSchema(t *testing.T) {
+	tables, err := sqliteSchema()
+	if err != nil {
+		t.Fatalf("Failed to read the reference schema: %v", err)
+	}
+
+	statements := make(map[string]string)
+	var indexes []string
+	for _, table := range tables {
+		statement, err := postgresCreateTable(table)
+		if err != nil {
+			t.Fatalf("Failed to translate table %s: %v", table.name, err)
+		}
+		statements[table.name] = statement
+		for _, index := range table.indexes {
+			indexes = append(indexes, postgresCreateIndex(table.name, index))
+		}
+		for _, column := range table.columns {
+			if _, err := postgresAddedColumn(column); err != nil {
+				t.Errorf("Failed to translate column %s.%s: %v", table.name, column.name, err)
+			}
+		}
+	}
+
+	for _, table := range []string{"businesses", "clients", "invoices", "invoice_items", "jobs", "settings", "users", "sessions"} {
+		if statements[table] == "" {
+			t.Errorf("Table %s is missing from the schema", table)
+		}
+	}
+	for table, want := range map[string]string{
+		"invoices":         `"id" BIGINT GENERATED BY DEFAULT AS IDENTITY`,
+		"number_sequences": `PRIMARY KEY ("scope", "year")`,
+		"closed_years":     `"year" BIGINT,`,
+		"jobs":             `"run_at" TIMESTAMPTZ NOT NULL`,
+		"invoice_items":    `"quantity" DOUBLE PRECISION NOT NULL`,
+		"businesses":       `"currency" TEXT DEFAULT 'EUR'`,
+	} {
+		if !strings.Contains(statements[table], want) {
+			t.Errorf("Table %s should contain %s:\n%s", table, want, statements[table])
+		}
+	}
+	if strings.Contains(statements["closed_years"], "IDENTITY") {
+		t.Errorf("closed_years.year is not assigned by the database:\n%s", statements["closed_years"])
+	}
+	for _, want := range []string{
+		`CREATE UNIQUE INDEX IF NOT EXISTS "idx_invoices_invoice_number" ON "invoices" ("invoice_number")`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS "invoice_emails_message_id_key" ON "invoice_emails" ("message_id")`,
+		`CREATE INDEX IF NOT EXISTS "idx_jobs_status_run_at" ON "jobs" ("status", "run_at")`,
+	} {
+		if !strings.Contains(strings.Join(indexes, "\n"), want) {
+			t.Errorf("Missing index %s", want)
+		}
+	}
+
+	// Columns added to tables with rows need a default
+	added, _ := postgresAddedColumn(schemaColumn{name: "subject", sqlType: "TEXT", notNull: true})
+	if added != `"subject" TEXT NOT NULL DEFAULT ''` {
+		t.Errorf("Unexpected added column %s", added)
+	}
+}
+
+// TestPostgresDBService runs against the PostgreSQL server in
+// TEST_POSTGRES_URL, in a schema of its own that is dropped afterwards
+func TestPostgresDBService(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_URL")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer admin.Close()
+	schema := fmt.Sprintf("simple_invoice_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	defer admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	dbService, err := newPostgresDBService(dsn+separator+"search_path="+schema, t.TempDir(), NewLogger(ERROR))
+	if err != nil {
+		t.Fatalf("Failed to create DB service: %v", err)
+	}
+	defer dbService.Close()
+
+	// Only one server can use the database, commands can run next to it
+	if second, err := newPostgresDBService(dsn+separator+"search_path="+schema, t.TempDir(), NewLogger(FATAL)); !errors.Is(err, ErrInstanceLocked) {
+		if second != nil {
+			second.Close()
+		}
+		t.Errorf("Expected ErrInstanceLocked for a second server, got %v", err)
+	}
+	if readOnly, err := openPostgresDBService(dsn+separator+"search_path="+schema, t.TempDir(), NewLogger(FATAL), true); err != nil {
+		t.Errorf("Expected a command to open the database next to the server, got %v", err)
+	} else {
+		readOnly.Close()
+	}
+
+	// Migrating an up to date schema changes nothing
+	if err := dbService.migratePostgres(t.Context()); err != nil {
+		t.Fatalf("Failed to migrate a second time: %v", err)
+	}
+
+	business := &models.Business{Name: "Acme", Currency: "EUR"}
+	if err := dbService.SaveBusiness(business); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	now := time.Now()
+	client := &models.Client{Name: "Client", Country: "DE", CreatedDate: &now}
+	if err := dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+	if business.ID == 0 || client.ID == 0 {
+		t.Fatalf("Expected IDs, got business %d and client %d", business.ID, client.ID)
+	}
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	items := []models.InvoiceItem{{Description: "Work", Quantity: 1.5, UnitPrice: 10000, Amount: 15000}}
+	manual := &models.Invoice{InvoiceNumber: "INV-2024-0005-B", BusinessID: business.ID, ClientID: client.ID,
+		IssueDate: issueDate, DueDate: issueDate.AddDate(0, 0, 30), TotalAmount: 15000, Currency: "EUR", Status: "draft"}
+	if err := dbService.SaveInvoice(manual, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+	invoice := &models.Invoice{BusinessID: business.ID, ClientID: client.ID, IssueDate: issueDate,
+		DueDate: issueDate.AddDate(0, 0, 30), TotalAmount: 15000, Currency: "EUR", Status: "draft", ReverseChargeVat: true}
+	if err := dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+	if invoice.InvoiceNumber != "INV-2024-0006" {
+		t.Errorf("Expected INV-2024-0006, got %s", invoice.InvoiceNumber)
+	}
+
+	saved, savedItems, err := dbService.GetInvoice(invoice.ID)
+	if err != nil {
+		t.Fatalf("Failed to load invoice: %v", err)
+	}
+	if !saved.ReverseChargeVat || !saved.IssueDate.Equal(issueDate) || len(savedItems) != 1 || savedItems[0].Quantity != 1.5 {
+		t.Errorf("Invoice did not round-trip: %+v %+v", saved, savedItems)
+	}
+
+	for want := 1; want <= 2; want++ {
+		version := &models.InvoicePDFVersion{InvoiceID: invoice.ID, Filename: "invoice.pdf", Fingerprint: "abc"}
+		if err := dbService.AddInvoicePDFVersion(version); err != nil {
+			t.Fatalf("Failed to add PDF version: %v", err)
+		}
+		if version.Version != want {
+			t.Errorf("Expected PDF version %d, got %d", want, version.Version)
+		}
+	}
+
+	if first, err := dbService.MarkNotified("invoice.overdue", invoice.ID); err != nil || !first {
+		t.Errorf("Expected the first notification, got %v, %v", first, err)
+	}
+	if first, err := dbService.MarkNotified("invoice.overdue", invoice.ID); err != nil || first {
+		t.Errorf("Expected a repeated notification, got %v, %v", first, err)
+	}
+}
@@ -1,27 +1,85 @@
 package services
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/0dragosh/simple-invoice/internal/models"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// ErrDuplicateInvoiceNumber is returned when an invoice number is already in use
+var ErrDuplicateInvoiceNumber = errors.New("invoice number already exists")
+
+// ErrNotProforma is returned when converting an invoice that is not a pro-forma
+var ErrNotProforma = errors.New("invoice is not a pro-forma invoice")
+
+// ErrAlreadyConverted is returned when converting a pro-forma invoice a second time
+var ErrAlreadyConverted = errors.New("pro-forma invoice was already converted")
+
+// ErrNotCancellable is returned when cancelling a draft, a pro-forma or a
+// cancellation invoice
+var ErrNotCancellable = errors.New("only issued invoices can be cancelled")
+
+// ErrAlreadyCancelled is returned when cancelling an invoice a second time
+var ErrAlreadyCancelled = errors.New("invoice was already cancelled")
+
+// ErrInvoiceCancelled is returned when deleting an invoice that was cancelled
+// or a cancellation invoice. Both are kept, as cancelling replaces deletion.
+var ErrInvoiceCancelled = errors.New("invoice was cancelled")
+
+// ErrVersionConflict is returned when a record was modified since it was loaded
+var ErrVersionConflict = errors.New("record was modified by someone else")
+
+// ErrInvoiceTotalsMismatch is returned when submitted invoice amounts differ from the recalculated ones
+var ErrInvoiceTotalsMismatch = errors.New("invoice totals do not match")
+
+// ErrInsufficientCredit is returned when applying more credit than the client
+// has or the invoice is still due
+var ErrInsufficientCredit = errors.New("not enough credit")
+
+// ErrCreditNotApplicable is returned when applying credit to a pro-forma invoice
+var ErrCreditNotApplicable = errors.New("credit cannot be applied to pro-forma invoices")
+
+// ErrCreditExceedsTotal is returned when an invoice total drops below the credit applied to it
+var ErrCreditExceedsTotal = errors.New("invoice total is less than the credit applied to it")
+
+// ErrYearClosed is returned when changing an invoice issued in a closed fiscal year
+var ErrYearClosed = errors.New("fiscal year is closed")
+
+// ErrScheduledEmailSending is returned when a scheduled email that is being
+// sent right now is cancelled or replaced
+var ErrScheduledEmailSending = errors.New("the scheduled email is being sent")
+
 // DBService provides methods for database operations
 type DBService struct {
 	db      *sql.DB
+	dialect dialect
 	dataDir string
 	logger  *Logger
+	// duplicateNumbers is the number of invoice numbers used more than once,
+	// which keep the unique index on invoice_number from being created
+	duplicateNumbers int
+	// skipMaintenance is set by OpenDBService, so Close leaves the database as
+	// the server expects it
+	skipMaintenance bool
+	// unlock releases the PostgreSQL instance lock, see lockPostgresInstance
+	unlock func()
+	// jobsQueued wakes the job worker after a transaction queued jobs
+	jobsQueued func()
 }
 
-// NewDBService creates a new DBService
+// NewDBService creates a new DBService. It uses the PostgreSQL database in
+// DATABASE_URL when set, and the SQLite database in dataDir otherwise.
 func NewDBService(dataDir string, logger *Logger) (*DBService, error) {
 	logger.Info("Initializing database service...")
 
@@ -32,6 +90,10 @@ func NewDBService(dataDir string, logger *Logger) (*DBService, error) {
 	}
 	logger.Debug("Data directory ensured: %s", dataDir)
 
+	if dsn := DatabaseURL(); dsn != "" {
+		return newPostgresDBService(dsn, dataDir, logger)
+	}
+
 	// Open database
 	dbPath := filepath.Join(dataDir, "database.db")
 	logger.Debug("Database path: %s", dbPath)
@@ -77,11 +139,7 @@ func NewDBService(dataDir string, logger *Logger) (*DBService, error) {
 
 	// Use a connection with strict timeout and no journal
 	logger.Debug("Opening database connection with timeout")
-	db, err := sql.Open("sqlite3", dbPath+"?_timeout=5000&_journal=DELETE")
-	if err != nil {
-		logger.Error("Failed to open database: %v", err)
-		return nil, fmt.Errorf("failed to open database: %w", err)
-	}
+	db := openSQLite(dbPath+"?_timeout=5000&_journal=DELETE", logger)
 
 	// Set connection parameters
 	db.SetMaxOpenConns(1) // Restrict to a single connection to avoid locks
@@ -108,11 +166,7 @@ func NewDBService(dataDir string, logger *Logger) (*DBService, error) {
 
 			// Reopen database with simpler settings
 			logger.Debug("Reopening database after removal")
-			db, err = sql.Open("sqlite3", dbPath)
-			if err != nil {
-				logger.Error("Failed to reopen database after lock: %v", err)
-				return nil, fmt.Errorf("failed to reopen database after lock: %w", err)
-			}
+			db = openSQLite(dbPath, logger)
 
 			// Verify connection again with timeout
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -132,6 +186,7 @@ func NewDBService(dataDir string, logger *Logger) (*DBService, error) {
 
 	service := &DBService{
 		db:      db,
+		dialect: sqliteDialect{},
 		dataDir: dataDir,
 		logger:  logger,
 	}
@@ -188,6 +243,99 @@ func NewDBService(dataDir string, logger *Logger) (*DBService, error) {
 	return service, nil
 }
 
+// OpenDBService opens an existing database for administration commands that
+// run next to the server. Unlike NewDBService it applies no migrations,
+// removes no files and runs no maintenance on Close. With readOnly nothing
+// can be written, SQLite databases are opened with mode=ro.
+func OpenDBService(dataDir string, logger *Logger, readOnly bool) (*DBService, error) {
+	if dsn := DatabaseURL(); dsn != "" {
+		return openPostgresDBService(dsn, dataDir, logger, readOnly)
+	}
+
+	dbPath := filepath.Join(dataDir, "database.db")
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("no database in %s, start the server or run migrate first: %w", dataDir, err)
+	}
+	dsn := "file:" + dbPath + "?_timeout=5000"
+	if readOnly {
+		dsn += "&mode=ro"
+	}
+	db := openSQLite(dsn, logger)
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	return &DBService{
+		db:              db,
+		dialect:         sqliteDialect{},
+		dataDir:         dataDir,
+		logger:          logger,
+		skipMaintenance: true,
+	}, nil
+}
+
+// moneyColumns lists the columns holding amounts of money, stored as integer cents
+var moneyColumns = []struct {
+	table   string
+	columns []string
+}{
+	{"invoices", []string{"hourly_rate", "total_amount", "vat_amount", "discount_amount"}},
+	{"invoice_items", []string{"unit_price", "amount", "discount_amount"}},
+}
+
+// migrateMoneyColumns converts money columns of older databases from REAL
+// decimal amounts to INTEGER cents, one transaction per table
+func (s *DBService) migrateMoneyColumns() error {
+	for _, money := range moneyColumns {
+		var pending []string
+		for _, column := range money.columns {
+			var columnType string
+			err := s.db.QueryRow(fmt.Sprintf(`SELECT type FROM pragma_table_info('%s') WHERE name = ?`, money.table), column).Scan(&columnType)
+			if err != nil {
+				s.logger.Error("Failed to check type of %s.%s: %v", money.table, column, err)
+				return fmt.Errorf("failed to check type of %s.%s: %w", money.table, column, err)
+			}
+			if strings.EqualFold(columnType, "REAL") {
+				pending = append(pending, column)
+			}
+		}
+		if len(pending) == 0 {
+			continue
+		}
+
+		s.logger.Info("Converting %s columns %s to integer cents", money.table, strings.Join(pending, ", "))
+		tx, err := s.db.Begin()
+		if err != nil {
+			s.logger.Error("Failed to begin transaction: %v", err)
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		for _, column := range pending {
+			for _, statement := range []string{
+				`ALTER TABLE %[1]s RENAME COLUMN %[2]s TO %[2]s_real`,
+				`ALTER TABLE %[1]s ADD COLUMN %[2]s INTEGER NOT NULL DEFAULT 0`,
+				`UPDATE %[1]s SET %[2]s = CAST(ROUND(COALESCE(%[2]s_real, 0) * 100) AS INTEGER)`,
+				`ALTER TABLE %[1]s DROP COLUMN %[2]s_real`,
+			} {
+				if _, err := tx.Exec(fmt.Sprintf(statement, money.table, column)); err != nil {
+					tx.Rollback()
+					s.logger.Error("Failed to convert %s.%s to integer cents: %v", money.table, column, err)
+					return fmt.Errorf("failed to convert %s.%s to integer cents: %w", money.table, column, err)
+				}
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			s.logger.Error("Failed to commit money column conversion: %v", err)
+			return fmt.Errorf("failed to commit money column conversion: %w", err)
+		}
+	}
+	return nil
+}
+
 // GetDataDir returns the data directory path
 func (s *DBService) GetDataDir() string {
 	return s.dataDir
@@ -196,17 +344,12 @@ func (s *DBService) GetDataDir() string {
 // Close closes the database connection
 func (s *DBService) Close() error {
 	s.logger.Info("Closing database connection")
-
-	// Execute PRAGMA to optimize database before closing
-	_, err := s.db.Exec("PRAGMA optimize")
-	if err != nil {
-		s.logger.Warn("Failed to optimize database: %v", err)
+	if !s.skipMaintenance {
+		s.dialect.optimize(s.db, s.logger)
 	}
 
-	// Execute VACUUM to compact the database
-	_, err = s.db.Exec("VACUUM")
-	if err != nil {
-		s.logger.Warn("Failed to vacuum database: %v", err)
+	if s.unlock != nil {
+		defer s.unlock()
 	}
 
 	// Close the database connection
@@ -379,11 +522,11 @@ func (s *DBService) initDB() error {
 			client_id INTEGER NOT NULL,
 			issue_date TEXT NOT NULL,
 			due_date TEXT NOT NULL,
-			hourly_rate REAL NOT NULL,
+			hourly_rate INTEGER NOT NULL,
 			hours_worked REAL NOT NULL,
-			total_amount REAL NOT NULL,
+			total_amount INTEGER NOT NULL,
 			vat_rate REAL NOT NULL,
-			vat_amount REAL NOT NULL,
+			vat_amount INTEGER NOT NULL,
 			reverse_charge_vat INTEGER NOT NULL,
 			currency TEXT DEFAULT 'EUR',
 			notes TEXT,
@@ -405,8 +548,8 @@ func (s *DBService) initDB() error {
 			invoice_id INTEGER NOT NULL,
 			description TEXT NOT NULL,
 			quantity REAL NOT NULL,
-			unit_price REAL NOT NULL,
-			amount REAL NOT NULL,
+			unit_price INTEGER NOT NULL,
+			amount INTEGER NOT NULL,
 			FOREIGN KEY (invoice_id) REFERENCES invoices (id) ON DELETE CASCADE
 		)
 	`)
@@ -540,607 +683,3050 @@ func (s *DBService) initDB() error {
 		}
 	}
 
-	s.logger.Debug("Database initialization completed successfully")
-	return nil
-}
+	// Add version columns used for optimistic locking
+	for _, table := range []string{"businesses", "clients"} {
+		var versionColumnExists bool
+		err = s.db.QueryRow(`
+			SELECT COUNT(*) > 0
+			FROM pragma_table_info(?)
+			WHERE name = 'version'
+		`, table).Scan(&versionColumnExists)
+		if err != nil {
+			s.logger.Error("Failed to check if version column exists in %s: %v", table, err)
+			return fmt.Errorf("failed to check if version column exists in %s: %w", table, err)
+		}
 
-// Business methods
+		if !versionColumnExists {
+			s.logger.Info("Adding version column to %s table", table)
+			_, err = s.db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN version INTEGER NOT NULL DEFAULT 1`, table))
+			if err != nil {
+				s.logger.Error("Failed to add version column to %s: %v", table, err)
+				return fmt.Errorf("failed to add version column to %s: %w", table, err)
+			}
+		}
+	}
 
-// SaveBusiness saves a business to the database
-func (s *DBService) SaveBusiness(business *models.Business) error {
-	if business.ID == 0 {
-		// Insert new business
-		result, err := s.db.Exec(`
-			INSERT INTO businesses (
-				name, address, city, postal_code, country, vat_id, email, 
-				bank_name, bank_account, iban, bic, currency,
-				second_bank_name, second_iban, second_bic, second_currency,
-				extra_business_detail, logo_path
-			)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`,
-			business.Name, business.Address, business.City, business.PostalCode, business.Country,
-			business.VatID, business.Email, business.BankName, business.BankAccount, business.IBAN, business.BIC, business.Currency,
-			business.SecondBankName, business.SecondIBAN, business.SecondBIC, business.SecondCurrency,
-			business.ExtraBusinessDetail, business.LogoPath,
-		)
+	// Add language column to clients, used to pick email template variants
+	var languageColumnExists bool
+	err = s.db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM pragma_table_info('clients')
+		WHERE name = 'language'
+	`).Scan(&languageColumnExists)
+	if err != nil {
+		s.logger.Error("Failed to check if language column exists: %v", err)
+		return fmt.Errorf("failed to check if language column exists: %w", err)
+	}
+
+	if !languageColumnExists {
+		s.logger.Info("Adding language column to clients table")
+		_, err = s.db.Exec(`ALTER TABLE clients ADD COLUMN language TEXT NOT NULL DEFAULT ''`)
 		if err != nil {
-			return err
+			s.logger.Error("Failed to add language column: %v", err)
+			return fmt.Errorf("failed to add language column: %w", err)
 		}
+	}
 
-		id, err := result.LastInsertId()
+	// Add the email address clients are sent invoices at, notes on their
+	// credit risk, where Italian clients receive FatturaPA invoices, how their
+	// invoices print dates and numbers, and the signature appended to emails
+	// sent by a business
+	for _, column := range []struct{ table, name string }{{"clients", "email"}, {"clients", "risk_notes"}, {"clients", "sdi_code"}, {"clients", "pec"},
+		{"clients", "date_format"}, {"clients", "number_format"}, {"businesses", "email_signature"}} {
+		var columnExists bool
+		err = s.db.QueryRow(`
+			SELECT COUNT(*) > 0
+			FROM pragma_table_info(?)
+			WHERE name = ?
+		`, column.table, column.name).Scan(&columnExists)
 		if err != nil {
-			return err
+			s.logger.Error("Failed to check if %s column exists in %s: %v", column.name, column.table, err)
+			return fmt.Errorf("failed to check if %s column exists in %s: %w", column.name, column.table, err)
 		}
 
-		business.ID = int(id)
-	} else {
-		// Update existing business
-		_, err := s.db.Exec(`
-			UPDATE businesses
-			SET name = ?, address = ?, city = ?, postal_code = ?, country = ?, vat_id = ?, email = ?, 
-				bank_name = ?, bank_account = ?, iban = ?, bic = ?, currency = ?,
-				second_bank_name = ?, second_iban = ?, second_bic = ?, second_currency = ?,
-				extra_business_detail = ?, logo_path = ?
-			WHERE id = ?
-		`,
-			business.Name, business.Address, business.City, business.PostalCode, business.Country,
-			business.VatID, business.Email, business.BankName, business.BankAccount, business.IBAN, business.BIC, business.Currency,
-			business.SecondBankName, business.SecondIBAN, business.SecondBIC, business.SecondCurrency,
-			business.ExtraBusinessDetail, business.LogoPath, business.ID,
-		)
-		if err != nil {
-			return err
+		if !columnExists {
+			s.logger.Info("Adding %s column to %s table", column.name, column.table)
+			_, err = s.db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s TEXT NOT NULL DEFAULT ''`, column.table, column.name))
+			if err != nil {
+				s.logger.Error("Failed to add %s column to %s: %v", column.name, column.table, err)
+				return fmt.Errorf("failed to add %s column to %s: %w", column.name, column.table, err)
+			}
 		}
 	}
 
-	return nil
-}
-
-// GetBusiness retrieves a business from the database
-func (s *DBService) GetBusiness(id int) (*models.Business, error) {
-	// Create a context with timeout for database operations
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// Add purchase order, contract and service period columns to invoices
+	for _, column := range []string{"po_number", "contract_reference", "service_period_start", "service_period_end"} {
+		var columnExists bool
+		err = s.db.QueryRow(`
+			SELECT COUNT(*) > 0
+			FROM pragma_table_info('invoices')
+			WHERE name = ?
+		`, column).Scan(&columnExists)
+		if err != nil {
+			s.logger.Error("Failed to check if %s column exists: %v", column, err)
+			return fmt.Errorf("failed to check if %s column exists: %w", column, err)
+		}
 
-	s.logger.Info("Fetching business with ID: %d", id)
+		if !columnExists {
+			s.logger.Info("Adding %s column to invoices table", column)
+			_, err = s.db.Exec(fmt.Sprintf(`ALTER TABLE invoices ADD COLUMN %s TEXT NOT NULL DEFAULT ''`, column))
+			if err != nil {
+				s.logger.Error("Failed to add %s column: %v", column, err)
+				return fmt.Errorf("failed to add %s column: %w", column, err)
+			}
+		}
+	}
 
-	var business models.Business
-	err := s.db.QueryRowContext(ctx, `
-		SELECT id, name, address, city, postal_code, country, vat_id, email, 
-			bank_name, bank_account, iban, bic, COALESCE(currency, 'EUR') as currency,
-			COALESCE(second_bank_name, '') as second_bank_name, 
-			COALESCE(second_iban, '') as second_iban, 
-			COALESCE(second_bic, '') as second_bic, 
-			COALESCE(second_currency, '') as second_currency,
-			COALESCE(extra_business_detail, '') as extra_business_detail,
-			logo_path
-		FROM businesses
-		WHERE id = ?
-	`, id).Scan(
-		&business.ID,
-		&business.Name,
-		&business.Address,
-		&business.City,
-		&business.PostalCode,
-		&business.Country,
-		&business.VatID,
-		&business.Email,
-		&business.BankName,
-		&business.BankAccount,
-		&business.IBAN,
-		&business.BIC,
-		&business.Currency,
-		&business.SecondBankName,
-		&business.SecondIBAN,
-		&business.SecondBIC,
-		&business.SecondCurrency,
-		&business.ExtraBusinessDetail,
-		&business.LogoPath,
-	)
+	// Add discount columns to invoices and invoice items
+	for _, table := range []string{"invoices", "invoice_items"} {
+		for column, columnType := range map[string]string{"discount_percent": "REAL", "discount_amount": "INTEGER"} {
+			var columnExists bool
+			err = s.db.QueryRow(fmt.Sprintf(`
+				SELECT COUNT(*) > 0
+				FROM pragma_table_info('%s')
+				WHERE name = ?
+			`, table), column).Scan(&columnExists)
+			if err != nil {
+				s.logger.Error("Failed to check if %s column exists in %s: %v", column, table, err)
+				return fmt.Errorf("failed to check if %s column exists in %s: %w", column, table, err)
+			}
 
-	if err != nil {
-		if err == sql.ErrNoRows {
-			s.logger.Warn("No business found with ID: %d", id)
-		} else {
-			s.logger.Error("Database error when fetching business ID %d: %v", id, err)
+			if !columnExists {
+				s.logger.Info("Adding %s column to %s table", column, table)
+				_, err = s.db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s NOT NULL DEFAULT 0`, table, column, columnType))
+				if err != nil {
+					s.logger.Error("Failed to add %s column to %s: %v", column, table, err)
+					return fmt.Errorf("failed to add %s column to %s: %w", column, table, err)
+				}
+			}
 		}
-		return nil, err
 	}
 
-	s.logger.Debug("Successfully fetched business: %s (ID: %d)", business.Name, business.ID)
-	return &business, nil
-}
-
-// GetBusinesses retrieves all businesses from the database
-func (s *DBService) GetBusinesses() ([]models.Business, error) {
-	rows, err := s.db.Query(`
-		SELECT id, name, address, city, postal_code, country, vat_id, email, 
-			bank_name, bank_account, iban, bic, COALESCE(currency, 'EUR') as currency,
-			COALESCE(second_bank_name, '') as second_bank_name, 
-			COALESCE(second_iban, '') as second_iban, 
-			COALESCE(second_bic, '') as second_bic, 
-			COALESCE(second_currency, '') as second_currency,
-			COALESCE(extra_business_detail, '') as extra_business_detail,
-			logo_path
-		FROM businesses
-	`)
+	// Add unit of measure column to invoice items; existing items were billed by the hour
+	var unitColumnExists bool
+	err = s.db.QueryRow(`
+		SELECT COUNT(*) > 0
+		FROM pragma_table_info('invoice_items')
+		WHERE name = 'unit'
+	`).Scan(&unitColumnExists)
 	if err != nil {
-		return nil, err
+		s.logger.Error("Failed to check if unit column exists: %v", err)
+		return fmt.Errorf("failed to check if unit column exists: %w", err)
 	}
-	defer rows.Close()
 
-	var businesses []models.Business
-	for rows.Next() {
-		var business models.Business
-		err := rows.Scan(
-			&business.ID, &business.Name, &business.Address, &business.City, &business.PostalCode,
-			&business.Country, &business.VatID, &business.Email, &business.BankName, &business.BankAccount,
-			&business.IBAN, &business.BIC, &business.Currency,
-			&business.SecondBankName, &business.SecondIBAN, &business.SecondBIC, &business.SecondCurrency,
-			&business.ExtraBusinessDetail, &business.LogoPath,
-		)
+	if !unitColumnExists {
+		s.logger.Info("Adding unit column to invoice_items table")
+		_, err = s.db.Exec(`ALTER TABLE invoice_items ADD COLUMN unit TEXT NOT NULL DEFAULT 'hours'`)
 		if err != nil {
-			return nil, err
+			s.logger.Error("Failed to add unit column: %v", err)
+			return fmt.Errorf("failed to add unit column: %w", err)
 		}
-		businesses = append(businesses, business)
 	}
 
-	return businesses, nil
-}
-
-// Client methods
-
-// SaveClient saves a client to the database
-func (s *DBService) SaveClient(client *models.Client) error {
-	// No validation for VAT ID - accept as provided
-	s.logger.Debug("SaveClient called with client: %+v", client)
+	// Add the kind of invoice items and whether they are billed outside the
+	// VAT base; existing items are services subject to VAT
+	for column, definition := range map[string]string{
+		"kind":       "TEXT NOT NULL DEFAULT 'service'",
+		"vat_exempt": "INTEGER NOT NULL DEFAULT 0",
+	} {
+		var columnExists bool
+		err = s.db.QueryRow(`
+			SELECT COUNT(*) > 0
+			FROM pragma_table_info('invoice_items')
+			WHERE name = ?
+		`, column).Scan(&columnExists)
+		if err != nil {
+			s.logger.Error("Failed to check if %s column exists in invoice_items: %v", column, err)
+			return fmt.Errorf("failed to check if %s column exists in invoice_items: %w", column, err)
+		}
 
-	// Ensure created_date is not nil
-	if client.CreatedDate == nil {
-		now := time.Now()
-		client.CreatedDate = &now
-		s.logger.Debug("Created date was nil, using current time: %v", now)
+		if !columnExists {
+			s.logger.Info("Adding %s column to invoice_items table", column)
+			_, err = s.db.Exec(fmt.Sprintf(`ALTER TABLE invoice_items ADD COLUMN %s %s`, column, definition))
+			if err != nil {
+				s.logger.Error("Failed to add %s column to invoice_items: %v", column, err)
+				return fmt.Errorf("failed to add %s column to invoice_items: %w", column, err)
+			}
+		}
 	}
 
-	if client.ID == 0 {
-		// Insert new client
-		s.logger.Debug("Inserting new client: %s", client.Name)
-		result, err := s.db.Exec(`
-			INSERT INTO clients (name, address, city, postal_code, country, vat_id, created_date, deleted)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-		`, client.Name, client.Address, client.City, client.PostalCode, client.Country, client.VatID, client.CreatedDate, boolToInt(client.Deleted))
+	// Add the small-business VAT exemption, working time and crypto payment
+	// columns to businesses; existing businesses charge VAT, work the default
+	// hours and accept no crypto payments
+	for column, definition := range map[string]string{
+		"vat_exempt":           "INTEGER NOT NULL DEFAULT 0",
+		"vat_exemption_clause": "TEXT NOT NULL DEFAULT ''",
+		"work_hours_per_day":   "REAL NOT NULL DEFAULT 0",
+		"work_days":            "TEXT NOT NULL DEFAULT ''",
+		"holiday_country":      "TEXT NOT NULL DEFAULT ''",
+		"crypto_address":       "TEXT NOT NULL DEFAULT ''",
+		"crypto_network":       "TEXT NOT NULL DEFAULT ''",
+	} {
+		var columnExists bool
+		err = s.db.QueryRow(`
+			SELECT COUNT(*) > 0
+			FROM pragma_table_info('businesses')
+			WHERE name = ?
+		`, column).Scan(&columnExists)
 		if err != nil {
-			s.logger.Error("Failed to insert client: %v", err)
-			return err
+			s.logger.Error("Failed to check if %s column exists: %v", column, err)
+			return fmt.Errorf("failed to check if %s column exists: %w", column, err)
 		}
 
-		id, err := result.LastInsertId()
-		if err != nil {
-			s.logger.Error("Failed to get last insert ID: %v", err)
-			return err
+		if !columnExists {
+			s.logger.Info("Adding %s column to businesses table", column)
+			_, err = s.db.Exec(fmt.Sprintf(`ALTER TABLE businesses ADD COLUMN %s %s`, column, definition))
+			if err != nil {
+				s.logger.Error("Failed to add %s column: %v", column, err)
+				return fmt.Errorf("failed to add %s column: %w", column, err)
+			}
 		}
+	}
 
-		client.ID = int(id)
-		s.logger.Info("Successfully inserted client with ID: %d", client.ID)
-	} else {
-		// Update existing client
-		s.logger.Debug("Updating existing client with ID: %d", client.ID)
-		_, err := s.db.Exec(`
-			UPDATE clients
-			SET name = ?, address = ?, city = ?, postal_code = ?, country = ?, vat_id = ?, created_date = ?, deleted = ?
-			WHERE id = ?
-		`, client.Name, client.Address, client.City, client.PostalCode, client.Country, client.VatID, client.CreatedDate, boolToInt(client.Deleted), client.ID)
+	// Add document type, credit, project, hours breakdown, exchange rate,
+	// payment date, PayPal link, crypto payment, status reason and
+	// cancellation columns to invoices; existing invoices are regular
+	// invoices without any of them
+	for column, definition := range map[string]string{
+		"type":                    "TEXT NOT NULL DEFAULT 'invoice'",
+		"converted_invoice_id":    "INTEGER NOT NULL DEFAULT 0",
+		"credit_applied":          "INTEGER NOT NULL DEFAULT 0",
+		"project_id":              "INTEGER NOT NULL DEFAULT 0",
+		"hours_breakdown":         "INTEGER NOT NULL DEFAULT 0",
+		"home_currency":           "TEXT NOT NULL DEFAULT ''",
+		"exchange_rate":           "REAL NOT NULL DEFAULT 0",
+		"exchange_rate_date":      "TEXT NOT NULL DEFAULT ''",
+		"paid_date":               "TEXT NOT NULL DEFAULT ''",
+		"paypal":                  "TEXT NOT NULL DEFAULT ''",
+		"crypto_amount":           "INTEGER NOT NULL DEFAULT 0",
+		"crypto_rate":             "REAL NOT NULL DEFAULT 0",
+		"crypto_fiat_amount":      "INTEGER NOT NULL DEFAULT 0",
+		"status_reason":           "TEXT NOT NULL DEFAULT ''",
+		"cancels_invoice_id":      "INTEGER NOT NULL DEFAULT 0",
+		"cancelled_by_invoice_id": "INTEGER NOT NULL DEFAULT 0",
+	} {
+		var columnExists bool
+		err = s.db.QueryRow(`
+			SELECT COUNT(*) > 0
+			FROM pragma_table_info('invoices')
+			WHERE name = ?
+		`, column).Scan(&columnExists)
 		if err != nil {
-			s.logger.Error("Failed to update client: %v", err)
-			return err
+			s.logger.Error("Failed to check if %s column exists: %v", column, err)
+			return fmt.Errorf("failed to check if %s column exists: %w", column, err)
+		}
+
+		if !columnExists {
+			s.logger.Info("Adding %s column to invoices table", column)
+			_, err = s.db.Exec(fmt.Sprintf(`ALTER TABLE invoices ADD COLUMN %s %s`, column, definition))
+			if err != nil {
+				s.logger.Error("Failed to add %s column: %v", column, err)
+				return fmt.Errorf("failed to add %s column: %w", column, err)
+			}
 		}
-		s.logger.Info("Successfully updated client with ID: %d", client.ID)
 	}
 
-	return nil
-}
+	if err := s.migrateMoneyColumns(); err != nil {
+		return err
+	}
 
-// GetClient retrieves a client from the database
-func (s *DBService) GetClient(id int) (*models.Client, error) {
-	// Create a context with timeout for database operations
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// Create jobs table for the background job queue
+	s.logger.Debug("Creating jobs table if not exists")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT NOT NULL,
+			payload TEXT NOT NULL DEFAULT '{}',
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			max_attempts INTEGER NOT NULL DEFAULT 5,
+			last_error TEXT NOT NULL DEFAULT '',
+			run_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create jobs table: %v", err)
+		return fmt.Errorf("failed to create jobs table: %w", err)
+	}
 
-	s.logger.Info("Fetching client with ID: %d from database", id)
+	_, err = s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_jobs_status_run_at ON jobs (status, run_at)`)
+	if err != nil {
+		s.logger.Error("Failed to create jobs index: %v", err)
+		return fmt.Errorf("failed to create jobs index: %w", err)
+	}
 
-	var client models.Client
-	query := `
-		SELECT id, name, address, city, postal_code, country, vat_id, created_date, deleted
-		FROM clients
+	// Create number_sequences table used to hand out invoice numbers atomically
+	s.logger.Debug("Creating number_sequences table if not exists")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS number_sequences (
+			scope TEXT NOT NULL,
+			year INTEGER NOT NULL,
+			last_value INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (scope, year)
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create number_sequences table: %v", err)
+		return fmt.Errorf("failed to create number_sequences table: %w", err)
+	}
+
+	// Enforce unique invoice numbers, unless existing data already contains duplicates
+	var duplicateNumbers int
+	err = s.db.QueryRow(`
+		SELECT COUNT(*) FROM (
+			SELECT invoice_number FROM invoices GROUP BY invoice_number HAVING COUNT(*) > 1
+		)
+	`).Scan(&duplicateNumbers)
+	if err != nil {
+		s.logger.Error("Failed to check for duplicate invoice numbers: %v", err)
+		return fmt.Errorf("failed to check for duplicate invoice numbers: %w", err)
+	}
+
+	s.duplicateNumbers = duplicateNumbers
+	if duplicateNumbers > 0 {
+		s.logger.Error("Found %d duplicate invoice numbers, invoice numbers are NOT enforced to be unique until they are renumbered. "+
+			"GET /api/diagnostics/numbering lists them.", duplicateNumbers)
+	} else {
+		_, err = s.db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_invoices_invoice_number ON invoices (invoice_number)`)
+		if err != nil {
+			s.logger.Error("Failed to create unique index on invoice_number: %v", err)
+			return fmt.Errorf("failed to create unique index on invoice_number: %w", err)
+		}
+	}
+
+	// Create invoice_pdf_versions table to keep earlier PDFs of edited invoices
+	s.logger.Debug("Creating invoice_pdf_versions table if not exists")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS invoice_pdf_versions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			invoice_id INTEGER NOT NULL,
+			version INTEGER NOT NULL,
+			filename TEXT NOT NULL,
+			fingerprint TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			UNIQUE (invoice_id, version)
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create invoice_pdf_versions table: %v", err)
+		return fmt.Errorf("failed to create invoice_pdf_versions table: %w", err)
+	}
+
+	// Create email_templates table for customized email subjects and bodies
+	s.logger.Debug("Creating email_templates table if not exists")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS email_templates (
+			kind TEXT NOT NULL,
+			language TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			body TEXT NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (kind, language)
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create email_templates table: %v", err)
+		return fmt.Errorf("failed to create email_templates table: %w", err)
+	}
+
+	// Create reverse_charge_clauses table for clauses customized per client country
+	s.logger.Debug("Creating reverse_charge_clauses table if not exists")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS reverse_charge_clauses (
+			country TEXT PRIMARY KEY,
+			text TEXT NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create reverse_charge_clauses table: %v", err)
+		return fmt.Errorf("failed to create reverse_charge_clauses table: %w", err)
+	}
+
+	// Create compliance_profiles table for compliance profiles customized per business country
+	s.logger.Debug("Creating compliance_profiles table if not exists")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS compliance_profiles (
+			country TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			required_fields TEXT NOT NULL DEFAULT '',
+			clauses TEXT NOT NULL DEFAULT '',
+			gapless_numbering INTEGER NOT NULL DEFAULT 0,
+			number_max_length INTEGER NOT NULL DEFAULT 0,
+			number_pattern TEXT NOT NULL DEFAULT '',
+			export_formats TEXT NOT NULL DEFAULT '',
+			updated_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create compliance_profiles table: %v", err)
+		return fmt.Errorf("failed to create compliance_profiles table: %w", err)
+	}
+
+	// Create expense_rates table for the mileage and per-diem rates customized per country
+	s.logger.Debug("Creating expense_rates table if not exists")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS expense_rates (
+			country TEXT PRIMARY KEY,
+			currency TEXT NOT NULL,
+			mileage_rate INTEGER NOT NULL DEFAULT 0,
+			per_diem INTEGER NOT NULL DEFAULT 0,
+			vat_exempt INTEGER NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create expense_rates table: %v", err)
+		return fmt.Errorf("failed to create expense_rates table: %w", err)
+	}
+
+	// Create closed_years table, the fiscal years whose invoices are locked
+	s.logger.Debug("Creating closed_years table if not exists")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS closed_years (
+			year INTEGER PRIMARY KEY,
+			closed_at TIMESTAMP NOT NULL,
+			invoices INTEGER NOT NULL,
+			first_number TEXT NOT NULL DEFAULT '',
+			last_number TEXT NOT NULL DEFAULT '',
+			missing_numbers TEXT NOT NULL DEFAULT '',
+			summary_filename TEXT NOT NULL DEFAULT ''
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create closed_years table: %v", err)
+		return fmt.Errorf("failed to create closed_years table: %w", err)
+	}
+
+	// Create notifications_sent table so one-off notifications are sent once
+	s.logger.Debug("Creating notifications_sent table if not exists")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS notifications_sent (
+			event TEXT NOT NULL,
+			entity_id INTEGER NOT NULL,
+			sent_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (event, entity_id)
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create notifications_sent table: %v", err)
+		return fmt.Errorf("failed to create notifications_sent table: %w", err)
+	}
+
+	// Create invoice_emails table to match bounce messages to sent invoices
+	s.logger.Debug("Creating invoice_emails table if not exists")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS invoice_emails (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			invoice_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			recipient TEXT NOT NULL,
+			message_id TEXT NOT NULL UNIQUE,
+			status TEXT NOT NULL,
+			bounce_reason TEXT NOT NULL DEFAULT '',
+			sent_at TIMESTAMP NOT NULL,
+			bounced_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create invoice_emails table: %v", err)
+		return fmt.Errorf("failed to create invoice_emails table: %w", err)
+	}
+
+	// Create invoice_scheduled_emails table, the invoice emails to be sent
+	// later by a send_invoice_email job; one per invoice
+	s.logger.Debug("Creating invoice_scheduled_emails table if not exists")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS invoice_scheduled_emails (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			invoice_id INTEGER NOT NULL UNIQUE,
+			kind TEXT NOT NULL,
+			recipient TEXT NOT NULL,
+			job_id INTEGER NOT NULL,
+			send_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create invoice_scheduled_emails table: %v", err)
+		return fmt.Errorf("failed to create invoice_scheduled_emails table: %w", err)
+	}
+
+	// Create client_credits table, the ledger of client prepayments and the
+	// credit applied to invoices
+	s.logger.Debug("Creating client_credits table if not exists")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS client_credits (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			client_id INTEGER NOT NULL,
+			invoice_id INTEGER NOT NULL DEFAULT 0,
+			amount INTEGER NOT NULL,
+			currency TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (client_id) REFERENCES clients (id)
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create client_credits table: %v", err)
+		return fmt.Errorf("failed to create client_credits table: %w", err)
+	}
+
+	// Create projects and time_entries tables to group invoices and time per engagement
+	s.logger.Debug("Creating projects and time_entries tables if not exist")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS projects (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			client_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			currency TEXT NOT NULL,
+			budget INTEGER NOT NULL DEFAULT 0,
+			hourly_rate INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (client_id) REFERENCES clients (id)
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create projects table: %v", err)
+		return fmt.Errorf("failed to create projects table: %w", err)
+	}
+
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS time_entries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			project_id INTEGER NOT NULL,
+			date TEXT NOT NULL,
+			hours REAL NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			invoice_id INTEGER NOT NULL DEFAULT 0,
+			FOREIGN KEY (project_id) REFERENCES projects (id)
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create time_entries table: %v", err)
+		return fmt.Errorf("failed to create time_entries table: %w", err)
+	}
+
+	// Create contracts and contract_invoices tables for retainers invoiced
+	// every month and the invoices generated for them
+	s.logger.Debug("Creating contracts and contract_invoices tables if not exist")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS contracts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			business_id INTEGER NOT NULL,
+			client_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			reference TEXT NOT NULL DEFAULT '',
+			start_date TEXT NOT NULL,
+			end_date TEXT NOT NULL DEFAULT '',
+			monthly_amount INTEGER NOT NULL,
+			currency TEXT NOT NULL,
+			vat_rate REAL NOT NULL DEFAULT 0,
+			reverse_charge_vat INTEGER NOT NULL DEFAULT 0,
+			payment_term_days INTEGER NOT NULL DEFAULT 30,
+			billing TEXT NOT NULL DEFAULT 'advance',
+			active INTEGER NOT NULL DEFAULT 1,
+			invoiced_through TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (client_id) REFERENCES clients (id)
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create contracts table: %v", err)
+		return fmt.Errorf("failed to create contracts table: %w", err)
+	}
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS contract_invoices (
+			contract_id INTEGER NOT NULL,
+			invoice_id INTEGER NOT NULL PRIMARY KEY,
+			period_start TEXT NOT NULL,
+			period_end TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create contract_invoices table: %v", err)
+		return fmt.Errorf("failed to create contract_invoices table: %w", err)
+	}
+
+	// Create invoice_tags and saved_filters tables for labelling invoices and
+	// the filter presets of the invoice list
+	s.logger.Debug("Creating invoice_tags and saved_filters tables if not exist")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS invoice_tags (
+			invoice_id INTEGER NOT NULL,
+			tag TEXT NOT NULL,
+			PRIMARY KEY (invoice_id, tag),
+			FOREIGN KEY (invoice_id) REFERENCES invoices (id)
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create invoice_tags table: %v", err)
+		return fmt.Errorf("failed to create invoice_tags table: %w", err)
+	}
+
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS saved_filters (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			query TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			UNIQUE (user_id, name)
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create saved_filters table: %v", err)
+		return fmt.Errorf("failed to create saved_filters table: %w", err)
+	}
+
+	// Create notes table for comments logged against invoices and clients
+	s.logger.Debug("Creating notes table if not exists")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS notes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			entity_type TEXT NOT NULL,
+			entity_id INTEGER NOT NULL,
+			body TEXT NOT NULL,
+			author TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create notes table: %v", err)
+		return fmt.Errorf("failed to create notes table: %w", err)
+	}
+
+	_, err = s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_notes_entity ON notes (entity_type, entity_id)`)
+	if err != nil {
+		s.logger.Error("Failed to create notes index: %v", err)
+		return fmt.Errorf("failed to create notes index: %w", err)
+	}
+
+	// Cache of the ECB reference rates, in units of the currency per euro
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS exchange_rates (
+			date TEXT NOT NULL,
+			currency TEXT NOT NULL,
+			rate REAL NOT NULL,
+			PRIMARY KEY (date, currency)
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create exchange_rates table: %v", err)
+		return fmt.Errorf("failed to create exchange_rates table: %w", err)
+	}
+
+	// Cache of the nationwide public holidays by country, with when each
+	// country's year was downloaded
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS public_holidays (
+			country TEXT NOT NULL,
+			date TEXT NOT NULL,
+			name TEXT NOT NULL,
+			PRIMARY KEY (country, date)
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create public_holidays table: %v", err)
+		return fmt.Errorf("failed to create public_holidays table: %w", err)
+	}
+
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS public_holiday_years (
+			country TEXT NOT NULL,
+			year INTEGER NOT NULL,
+			fetched_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (country, year)
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create public_holiday_years table: %v", err)
+		return fmt.Errorf("failed to create public_holiday_years table: %w", err)
+	}
+
+	// Bank accounts linked through GoCardless and the incoming payments synced
+	// from them; invoice_id is set once a payment was reconciled with an invoice
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS bank_connections (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			requisition_id TEXT NOT NULL UNIQUE,
+			institution_id TEXT NOT NULL,
+			institution_name TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL,
+			link TEXT NOT NULL DEFAULT '',
+			account_ids TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			last_synced_at TIMESTAMP NOT NULL,
+			last_error TEXT NOT NULL DEFAULT ''
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create bank_connections table: %v", err)
+		return fmt.Errorf("failed to create bank_connections table: %w", err)
+	}
+
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS bank_transactions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			connection_id INTEGER NOT NULL,
+			external_id TEXT NOT NULL,
+			date TEXT NOT NULL,
+			amount INTEGER NOT NULL,
+			currency TEXT NOT NULL,
+			counterparty TEXT NOT NULL DEFAULT '',
+			iban TEXT NOT NULL DEFAULT '',
+			reference TEXT NOT NULL DEFAULT '',
+			invoice_id INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			UNIQUE (connection_id, external_id)
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create bank_transactions table: %v", err)
+		return fmt.Errorf("failed to create bank_transactions table: %w", err)
+	}
+
+	// Organizations in Xero or QuickBooks connected through OAuth, and the
+	// records pushed to them: accounting_sync maps clients, invoices and
+	// payments to their remote IDs so they are updated instead of duplicated
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS accounting_connections (
+			provider TEXT PRIMARY KEY,
+			tenant_id TEXT NOT NULL,
+			tenant_name TEXT NOT NULL DEFAULT '',
+			access_token TEXT NOT NULL,
+			refresh_token TEXT NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			connected_at TIMESTAMP NOT NULL,
+			last_synced_at TIMESTAMP NOT NULL,
+			last_error TEXT NOT NULL DEFAULT ''
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create accounting_connections table: %v", err)
+		return fmt.Errorf("failed to create accounting_connections table: %w", err)
+	}
+
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS accounting_sync (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			provider TEXT NOT NULL,
+			entity_type TEXT NOT NULL,
+			entity_id INTEGER NOT NULL,
+			remote_id TEXT NOT NULL DEFAULT '',
+			remote_version TEXT NOT NULL DEFAULT '',
+			fingerprint TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL,
+			last_error TEXT NOT NULL DEFAULT '',
+			synced_at TIMESTAMP NOT NULL,
+			UNIQUE (provider, entity_type, entity_id)
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create accounting_sync table: %v", err)
+		return fmt.Errorf("failed to create accounting_sync table: %w", err)
+	}
+
+	// FatturaPA files generated for invoices of Italian businesses. A file
+	// sent to SDI keeps its name; sending the invoice again creates a new file.
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS fatturapa_files (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			invoice_id INTEGER NOT NULL,
+			filename TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			sent_to TEXT NOT NULL DEFAULT '',
+			sent_at TIMESTAMP,
+			message_id TEXT NOT NULL DEFAULT ''
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create fatturapa_files table: %v", err)
+		return fmt.Errorf("failed to create fatturapa_files table: %w", err)
+	}
+
+	_, err = s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_fatturapa_files_invoice ON fatturapa_files (invoice_id)`)
+	if err != nil {
+		s.logger.Error("Failed to create fatturapa_files index: %v", err)
+		return fmt.Errorf("failed to create fatturapa_files index: %w", err)
+	}
+
+	// Invoices of Hungarian businesses reported to NAV Online Számla, a queue
+	// retried until NAV has processed every invoice
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS nav_reports (
+			invoice_id INTEGER PRIMARY KEY,
+			status TEXT NOT NULL,
+			transaction_id TEXT NOT NULL DEFAULT '',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			message TEXT NOT NULL DEFAULT '',
+			next_attempt_at TIMESTAMP NOT NULL,
+			submitted_at TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create nav_reports table: %v", err)
+		return fmt.Errorf("failed to create nav_reports table: %w", err)
+	}
+
+	// Invoices of Polish businesses sent to KSeF
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS ksef_invoices (
+			invoice_id INTEGER PRIMARY KEY,
+			status TEXT NOT NULL,
+			reference_number TEXT NOT NULL DEFAULT '',
+			ksef_number TEXT NOT NULL DEFAULT '',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			message TEXT NOT NULL DEFAULT '',
+			next_attempt_at TIMESTAMP NOT NULL,
+			submitted_at TIMESTAMP,
+			accepted_at TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create ksef_invoices table: %v", err)
+		return fmt.Errorf("failed to create ksef_invoices table: %w", err)
+	}
+
+	// Verifactu billing records of invoices of Spanish businesses. Records are
+	// kept when their invoice is deleted, since later records chain to them.
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS verifactu_records (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			invoice_id INTEGER NOT NULL,
+			issuer_nif TEXT NOT NULL,
+			issuer_name TEXT NOT NULL,
+			invoice_number TEXT NOT NULL,
+			issue_date TEXT NOT NULL,
+			invoice_type TEXT NOT NULL,
+			vat_total TEXT NOT NULL,
+			total TEXT NOT NULL,
+			previous_hash TEXT NOT NULL DEFAULT '',
+			hash TEXT NOT NULL,
+			generated_at TEXT NOT NULL,
+			correction INTEGER NOT NULL DEFAULT 0,
+			status TEXT NOT NULL,
+			csv TEXT NOT NULL DEFAULT '',
+			message TEXT NOT NULL DEFAULT '',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMP NOT NULL,
+			sent_at TIMESTAMP,
+			xml TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create verifactu_records table: %v", err)
+		return fmt.Errorf("failed to create verifactu_records table: %w", err)
+	}
+
+	_, err = s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_verifactu_records_invoice ON verifactu_records (invoice_id)`)
+	if err != nil {
+		s.logger.Error("Failed to create verifactu_records index: %v", err)
+		return fmt.Errorf("failed to create verifactu_records index: %w", err)
+	}
+
+	// Create audit_log table
+	s.logger.Debug("Creating audit_log table if not exists")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			action TEXT NOT NULL,
+			entity_type TEXT NOT NULL,
+			entity_id INTEGER NOT NULL,
+			details TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create audit_log table: %v", err)
+		return fmt.Errorf("failed to create audit_log table: %w", err)
+	}
+
+	_, err = s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log (entity_type, entity_id)`)
+	if err != nil {
+		s.logger.Error("Failed to create audit_log index: %v", err)
+		return fmt.Errorf("failed to create audit_log index: %w", err)
+	}
+
+	// Create settings table for runtime configuration
+	s.logger.Debug("Creating settings table if not exists")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create settings table: %v", err)
+		return fmt.Errorf("failed to create settings table: %w", err)
+	}
+
+	// Create users and sessions tables for external authentication
+	s.logger.Debug("Creating users table if not exists")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			auth_source TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			username TEXT NOT NULL,
+			email TEXT NOT NULL DEFAULT '',
+			name TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			last_login_at TIMESTAMP NOT NULL,
+			UNIQUE (auth_source, subject)
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create users table: %v", err)
+		return fmt.Errorf("failed to create users table: %w", err)
+	}
+
+	s.logger.Debug("Creating sessions table if not exists")
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			ip_address TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			last_seen_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create sessions table: %v", err)
+		return fmt.Errorf("failed to create sessions table: %w", err)
+	}
+
+	// Add the address, browser and last activity of sessions, so they can be
+	// told apart and revoked. Existing sessions were last seen when created.
+	var lastSeenExists bool
+	err = s.db.QueryRow(`SELECT COUNT(*) > 0 FROM pragma_table_info('sessions') WHERE name = 'last_seen_at'`).Scan(&lastSeenExists)
+	if err != nil {
+		s.logger.Error("Failed to check if last_seen_at column exists in sessions: %v", err)
+		return fmt.Errorf("failed to check if last_seen_at column exists in sessions: %w", err)
+	}
+	if !lastSeenExists {
+		s.logger.Info("Adding device columns to sessions table")
+		for _, statement := range []string{
+			`ALTER TABLE sessions ADD COLUMN ip_address TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE sessions ADD COLUMN last_seen_at TIMESTAMP`,
+			`UPDATE sessions SET last_seen_at = created_at`,
+		} {
+			if _, err := s.db.Exec(statement); err != nil {
+				s.logger.Error("Failed to add device columns to sessions: %v", err)
+				return fmt.Errorf("failed to add device columns to sessions: %w", err)
+			}
+		}
+	}
+
+	// Devices users signed in on, recognized by a cookie, to notify about
+	// sign-ins from new ones. Only a hash of the cookie is stored.
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_devices (
+			user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			device_hash TEXT NOT NULL,
+			ip_address TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			first_seen_at TIMESTAMP NOT NULL,
+			last_seen_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (user_id, device_hash)
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create user_devices table: %v", err)
+		return fmt.Errorf("failed to create user_devices table: %w", err)
+	}
+
+	// API tokens, stored as hashes like sessions. Scopes are separated by spaces.
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			prefix TEXT NOT NULL,
+			scopes TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			last_used_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		s.logger.Error("Failed to create api_tokens table: %v", err)
+		return fmt.Errorf("failed to create api_tokens table: %w", err)
+	}
+
+	s.logger.Debug("Database initialization completed successfully")
+	return nil
+}
+
+// Business methods
+
+// SaveBusiness saves a business to the database
+func (s *DBService) SaveBusiness(business *models.Business) error {
+	if business.ID == 0 {
+		// Insert new business
+		var id int64
+		err := s.db.QueryRow(`
+			INSERT INTO businesses (
+				name, address, city, postal_code, country, vat_id, email, 
+				bank_name, bank_account, iban, bic, currency,
+				second_bank_name, second_iban, second_bic, second_currency,
+				extra_business_detail, logo_path, email_signature, vat_exempt, vat_exemption_clause,
+				work_hours_per_day, work_days, holiday_country, crypto_address, crypto_network
+			)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id
+		`,
+			business.Name, business.Address, business.City, business.PostalCode, business.Country,
+			business.VatID, business.Email, business.BankName, business.BankAccount, business.IBAN, business.BIC, business.Currency,
+			business.SecondBankName, business.SecondIBAN, business.SecondBIC, business.SecondCurrency,
+			business.ExtraBusinessDetail, business.LogoPath, business.EmailSignature, business.VatExempt, business.VatExemptionClause,
+			business.WorkHoursPerDay, models.FormatWorkDays(business.WorkDays), business.HolidayCountry,
+			business.CryptoAddress, business.CryptoNetwork,
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		business.ID = int(id)
+		business.Version = 1
+	} else {
+		// Update existing business. A version of 0 skips the conflict check for
+		// API callers that don't track versions.
+		result, err := s.db.Exec(`
+			UPDATE businesses
+			SET name = ?, address = ?, city = ?, postal_code = ?, country = ?, vat_id = ?, email = ?, 
+				bank_name = ?, bank_account = ?, iban = ?, bic = ?, currency = ?,
+				second_bank_name = ?, second_iban = ?, second_bic = ?, second_currency = ?,
+				extra_business_detail = ?, logo_path = ?, email_signature = ?, vat_exempt = ?, vat_exemption_clause = ?,
+				work_hours_per_day = ?, work_days = ?, holiday_country = ?, crypto_address = ?, crypto_network = ?, version = version + 1
+			WHERE id = ? AND (? = 0 OR version = ?)
+		`,
+			business.Name, business.Address, business.City, business.PostalCode, business.Country,
+			business.VatID, business.Email, business.BankName, business.BankAccount, business.IBAN, business.BIC, business.Currency,
+			business.SecondBankName, business.SecondIBAN, business.SecondBIC, business.SecondCurrency,
+			business.ExtraBusinessDetail, business.LogoPath, business.EmailSignature, business.VatExempt, business.VatExemptionClause,
+			business.WorkHoursPerDay, models.FormatWorkDays(business.WorkDays), business.HolidayCountry,
+			business.CryptoAddress, business.CryptoNetwork,
+			business.ID, business.Version, business.Version,
+		)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			s.logger.Warn("Version conflict updating business ID %d (version %d)", business.ID, business.Version)
+			return ErrVersionConflict
+		}
+
+		if err := s.db.QueryRow(`SELECT version FROM businesses WHERE id = ?`, business.ID).Scan(&business.Version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetBusiness retrieves a business from the database
+func (s *DBService) GetBusiness(id int) (*models.Business, error) {
+	// Create a context with timeout for database operations
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s.logger.Info("Fetching business with ID: %d", id)
+
+	var business models.Business
+	var workDays string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, name, address, city, postal_code, country, vat_id, email, 
+			bank_name, bank_account, iban, bic, COALESCE(currency, 'EUR') as currency,
+			COALESCE(second_bank_name, '') as second_bank_name, 
+			COALESCE(second_iban, '') as second_iban, 
+			COALESCE(second_bic, '') as second_bic, 
+			COALESCE(second_currency, '') as second_currency,
+			COALESCE(extra_business_detail, '') as extra_business_detail,
+			logo_path, email_signature, vat_exempt, vat_exemption_clause,
+			work_hours_per_day, work_days, holiday_country, crypto_address, crypto_network, version
+		FROM businesses
+		WHERE id = ?
+	`, id).Scan(
+		&business.ID,
+		&business.Name,
+		&business.Address,
+		&business.City,
+		&business.PostalCode,
+		&business.Country,
+		&business.VatID,
+		&business.Email,
+		&business.BankName,
+		&business.BankAccount,
+		&business.IBAN,
+		&business.BIC,
+		&business.Currency,
+		&business.SecondBankName,
+		&business.SecondIBAN,
+		&business.SecondBIC,
+		&business.SecondCurrency,
+		&business.ExtraBusinessDetail,
+		&business.LogoPath,
+		&business.EmailSignature,
+		&business.VatExempt,
+		&business.VatExemptionClause,
+		&business.WorkHoursPerDay,
+		&workDays,
+		&business.HolidayCountry,
+		&business.CryptoAddress,
+		&business.CryptoNetwork,
+		&business.Version,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			s.logger.Warn("No business found with ID: %d", id)
+		} else {
+			s.logger.Error("Database error when fetching business ID %d: %v", id, err)
+		}
+		return nil, err
+	}
+
+	business.WorkDays = models.ParseWorkDays(workDays)
+
+	s.logger.Debug("Successfully fetched business: %s (ID: %d)", business.Name, business.ID)
+	return &business, nil
+}
+
+// GetBusinesses retrieves all businesses from the database
+func (s *DBService) GetBusinesses() ([]models.Business, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, address, city, postal_code, country, vat_id, email, 
+			bank_name, bank_account, iban, bic, COALESCE(currency, 'EUR') as currency,
+			COALESCE(second_bank_name, '') as second_bank_name, 
+			COALESCE(second_iban, '') as second_iban, 
+			COALESCE(second_bic, '') as second_bic, 
+			COALESCE(second_currency, '') as second_currency,
+			COALESCE(extra_business_detail, '') as extra_business_detail,
+			logo_path, email_signature, vat_exempt, vat_exemption_clause,
+			work_hours_per_day, work_days, holiday_country, crypto_address, crypto_network, version
+		FROM businesses
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var businesses []models.Business
+	for rows.Next() {
+		var business models.Business
+		var workDays string
+		err := rows.Scan(
+			&business.ID, &business.Name, &business.Address, &business.City, &business.PostalCode,
+			&business.Country, &business.VatID, &business.Email, &business.BankName, &business.BankAccount,
+			&business.IBAN, &business.BIC, &business.Currency,
+			&business.SecondBankName, &business.SecondIBAN, &business.SecondBIC, &business.SecondCurrency,
+			&business.ExtraBusinessDetail, &business.LogoPath, &business.EmailSignature, &business.VatExempt, &business.VatExemptionClause,
+			&business.WorkHoursPerDay, &workDays, &business.HolidayCountry, &business.CryptoAddress, &business.CryptoNetwork, &business.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		business.WorkDays = models.ParseWorkDays(workDays)
+		businesses = append(businesses, business)
+	}
+
+	return businesses, nil
+}
+
+// Client methods
+
+// SaveClient saves a client to the database
+func (s *DBService) SaveClient(client *models.Client) error {
+	// No validation for VAT ID - accept as provided
+	s.logger.Debug("SaveClient called with client: %s", Redact(client))
+
+	// Ensure created_date is not nil
+	if client.CreatedDate == nil {
+		now := time.Now()
+		client.CreatedDate = &now
+		s.logger.Debug("Created date was nil, using current time: %v", now)
+	}
+
+	if client.ID == 0 {
+		// Insert new client
+		s.logger.Debug("Inserting new client: %s", MaskPII(client.Name))
+		var id int64
+		err := s.db.QueryRow(`
+			INSERT INTO clients (name, address, city, postal_code, country, vat_id, email, language, risk_notes, sdi_code, pec, date_format, number_format, created_date, deleted)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id
+		`, client.Name, client.Address, client.City, client.PostalCode, client.Country, client.VatID, client.Email, client.Language, client.RiskNotes, client.SDICode, client.PEC, client.DateFormat, client.NumberFormat, client.CreatedDate, boolToInt(client.Deleted)).Scan(&id)
+		if err != nil {
+			s.logger.Error("Failed to insert client: %v", err)
+			return err
+		}
+
+		client.ID = int(id)
+		client.Version = 1
+		s.logger.Info("Successfully inserted client with ID: %d", client.ID)
+	} else {
+		// Update existing client. A version of 0 skips the conflict check for
+		// API callers that don't track versions.
+		s.logger.Debug("Updating existing client with ID: %d", client.ID)
+		result, err := s.db.Exec(`
+			UPDATE clients
+			SET name = ?, address = ?, city = ?, postal_code = ?, country = ?, vat_id = ?, email = ?, language = ?, risk_notes = ?, sdi_code = ?, pec = ?, date_format = ?, number_format = ?, created_date = ?, deleted = ?, version = version + 1
+			WHERE id = ? AND (? = 0 OR version = ?)
+		`, client.Name, client.Address, client.City, client.PostalCode, client.Country, client.VatID, client.Email, client.Language, client.RiskNotes, client.SDICode, client.PEC, client.DateFormat, client.NumberFormat, client.CreatedDate, boolToInt(client.Deleted), client.ID, client.Version, client.Version)
+		if err != nil {
+			s.logger.Error("Failed to update client: %v", err)
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			s.logger.Error("Failed to get rows affected: %v", err)
+			return err
+		}
+		if rowsAffected == 0 {
+			s.logger.Warn("Version conflict updating client ID %d (version %d)", client.ID, client.Version)
+			return ErrVersionConflict
+		}
+
+		if err := s.db.QueryRow(`SELECT version FROM clients WHERE id = ?`, client.ID).Scan(&client.Version); err != nil {
+			s.logger.Error("Failed to read client version: %v", err)
+			return err
+		}
+		s.logger.Info("Successfully updated client with ID: %d", client.ID)
+	}
+
+	return nil
+}
+
+// GetClient retrieves a client from the database
+func (s *DBService) GetClient(id int) (*models.Client, error) {
+	// Create a context with timeout for database operations
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s.logger.Info("Fetching client with ID: %d from database", id)
+
+	var client models.Client
+	query := `
+		SELECT id, name, address, city, postal_code, country, vat_id, email, language, risk_notes, sdi_code, pec, date_format, number_format, created_date, deleted, version
+		FROM clients
+		WHERE id = ?
+	`
+
+	s.logger.Debug("Executing query: %s with ID: %d", query, id)
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&client.ID,
+		&client.Name,
+		&client.Address,
+		&client.City,
+		&client.PostalCode,
+		&client.Country,
+		&client.VatID,
+		&client.Email,
+		&client.Language,
+		&client.RiskNotes,
+		&client.SDICode,
+		&client.PEC,
+		&client.DateFormat,
+		&client.NumberFormat,
+		&client.CreatedDate,
+		&client.Deleted,
+		&client.Version,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			s.logger.Warn("No client found with ID: %d", id)
+		} else {
+			s.logger.Error("Database error when fetching client ID %d: %v", id, err)
+		}
+		return nil, err
+	}
+
+	s.logger.Debug("Successfully fetched client: %s (ID: %d)", MaskPII(client.Name), client.ID)
+	return &client, nil
+}
+
+// GetClients retrieves all clients from the database
+func (s *DBService) GetClients() ([]models.Client, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, address, city, postal_code, country, vat_id, email, language, risk_notes, sdi_code, pec, date_format, number_format, created_date, deleted, version
+		FROM clients
+		WHERE deleted = 0
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []models.Client
+	for rows.Next() {
+		var client models.Client
+		if err := rows.Scan(&client.ID, &client.Name, &client.Address, &client.City, &client.PostalCode, &client.Country, &client.VatID, &client.Email, &client.Language, &client.RiskNotes, &client.SDICode, &client.PEC, &client.DateFormat, &client.NumberFormat, &client.CreatedDate, &client.Deleted, &client.Version); err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, nil
+}
+
+// DeleteClient marks a client as deleted
+func (s *DBService) DeleteClient(id int) error {
+	_, err := s.db.Exec(`
+		UPDATE clients
+		SET deleted = 1, version = version + 1
+		WHERE id = ?
+	`, id)
+	return err
+}
+
+// GetDeletedClients retrieves all clients that have been moved to the trash
+func (s *DBService) GetDeletedClients() ([]models.Client, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, address, city, postal_code, country, vat_id, email, language, risk_notes, sdi_code, pec, date_format, number_format, created_date, deleted, version
+		FROM clients
+		WHERE deleted = 1
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []models.Client
+	for rows.Next() {
+		var client models.Client
+		if err := rows.Scan(&client.ID, &client.Name, &client.Address, &client.City, &client.PostalCode, &client.Country, &client.VatID, &client.Email, &client.Language, &client.RiskNotes, &client.SDICode, &client.PEC, &client.DateFormat, &client.NumberFormat, &client.CreatedDate, &client.Deleted, &client.Version); err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, nil
+}
+
+// RestoreClient moves a deleted client out of the trash
+func (s *DBService) RestoreClient(id int) error {
+	result, err := s.db.Exec(`
+		UPDATE clients
+		SET deleted = 0, version = version + 1
+		WHERE id = ? AND deleted = 1
+	`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("deleted client with ID %d not found", id)
+	}
+	return nil
+}
+
+// CountOpenInvoicesForClient returns the number of issued, unpaid invoices for
+// a client. Drafts, pro-forma invoices and held invoices are not counted.
+func (s *DBService) CountOpenInvoicesForClient(clientID int) (int, error) {
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM invoices WHERE client_id = ? AND status = ? AND type = ?
+	`, clientID, models.InvoiceStatusSent, models.InvoiceTypeInvoice).Scan(&count)
+	return count, err
+}
+
+// AnonymizeClient irreversibly replaces a client's personal data with redacted
+// placeholders and moves it to the trash. Invoices keep their financial data and
+// still reference the client; the country is kept because it determines the VAT
+// treatment of those invoices. The erasure is recorded in the audit log without
+// any of the removed data. It returns the numbers of the client's invoices so
+// rendered copies can be discarded.
+func (s *DBService) AnonymizeClient(id int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE clients
+		SET name = ?, address = ?, city = ?, postal_code = '', vat_id = '', email = '', risk_notes = '', pec = '', deleted = 1, version = version + 1
+		WHERE id = ?
+	`, fmt.Sprintf("Redacted client #%d", id), RedactedPlaceholder, RedactedPlaceholder, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to anonymize client: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, fmt.Errorf("client with ID %d not found", id)
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT invoice_number FROM invoices WHERE client_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list client invoices: %w", err)
+	}
+	var invoiceNumbers []string
+	for rows.Next() {
+		var number string
+		if err := rows.Scan(&number); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		invoiceNumbers = append(invoiceNumbers, number)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The addresses invoices were emailed to are personal data as well
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE invoice_emails SET recipient = '' WHERE invoice_id IN (SELECT id FROM invoices WHERE client_id = ?)
+	`, id); err != nil {
+		return nil, fmt.Errorf("failed to erase email recipients: %w", err)
+	}
+	if err := cancelScheduledEmails(ctx, tx, `invoice_id IN (SELECT id FROM invoices WHERE client_id = ?)`, id); err != nil {
+		return nil, err
+	}
+
+	// Notes about the client and its invoices may name people as well
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM notes
+		WHERE (entity_type = ? AND entity_id = ?)
+		OR (entity_type = ? AND entity_id IN (SELECT id FROM invoices WHERE client_id = ?))
+	`, models.NoteEntityClient, id, models.NoteEntityInvoice, id); err != nil {
+		return nil, fmt.Errorf("failed to erase notes: %w", err)
+	}
+
+	details := fmt.Sprintf("Personal data erased, %d invoice(s) retained", len(invoiceNumbers))
+	if err := logAudit(ctx, tx, AuditActionErase, "client", id, details); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	committed = true
+
+	s.logger.Info("Anonymized client %d (%d invoices retained)", id, len(invoiceNumbers))
+	return invoiceNumbers, nil
+}
+
+// Invoice methods
+
+// verifyInvoiceTotals checks the submitted item amounts, VAT amount and total
+// against the amounts recalculated from the invoice data
+func verifyInvoiceTotals(invoice *models.Invoice, items []models.InvoiceItem) error {
+	// Allow one minor unit of difference for client-side rounding
+	tolerance := models.MinorUnit(invoice.Currency)
+	differs := func(submitted, expected models.Money) bool {
+		return max(submitted-expected, expected-submitted) > tolerance
+	}
+
+	for i := range items {
+		if expected := items[i].NetAmount().Round(invoice.Currency); differs(items[i].Amount, expected) {
+			return fmt.Errorf("%w: item %d amount is %s, expected %s", ErrInvoiceTotalsMismatch, i+1, items[i].Amount, expected)
+		}
+	}
+
+	totals := invoice.CalculateTotals(items)
+	if differs(invoice.VatAmount, totals.VatAmount) {
+		return fmt.Errorf("%w: VAT amount is %s, expected %s", ErrInvoiceTotalsMismatch, invoice.VatAmount, totals.VatAmount)
+	}
+	if differs(invoice.TotalAmount, totals.Total) {
+		return fmt.Errorf("%w: total is %s, expected %s", ErrInvoiceTotalsMismatch, invoice.TotalAmount, totals.Total)
+	}
+	return nil
+}
+
+// SaveInvoice saves an invoice and its items to the database. The item
+// amounts, VAT amount and total are recalculated from the quantities, prices,
+// discounts and VAT rate; ErrInvoiceTotalsMismatch is returned if the
+// submitted amounts differ by more than one minor currency unit.
+// The PDF of the invoice is queued in the same transaction.
+func (s *DBService) SaveInvoice(invoice *models.Invoice, items []models.InvoiceItem) error {
+	return s.saveInvoices([]*models.Invoice{invoice}, [][]models.InvoiceItem{items}, true, queueInvoicePDFs(invoice))
+}
+
+// SaveImportedInvoice saves an invoice and its items with their amounts as
+// given, so historical invoices keep the totals they were issued with. Its PDF
+// is rendered when it is first opened.
+func (s *DBService) SaveImportedInvoice(invoice *models.Invoice, items []models.InvoiceItem) error {
+	return s.saveInvoices([]*models.Invoice{invoice}, [][]models.InvoiceItem{items}, false, nil)
+}
+
+// SaveInvoices saves new invoices and their items in a single transaction, so
+// either all of them are created or none. Their amounts are recalculated and
+// checked like in SaveInvoice, and their PDFs are queued.
+func (s *DBService) SaveInvoices(invoices []*models.Invoice, items [][]models.InvoiceItem) error {
+	return s.saveInvoices(invoices, items, true, queueInvoicePDFs(invoices...))
+}
+
+// queueInvoicePDFs returns a function that queues the PDFs of invoices in the
+// transaction that writes them, so a crash right after the commit does not
+// lose them; the jobs table is the outbox the job worker dispatches
+func queueInvoicePDFs(invoices ...*models.Invoice) func(ctx context.Context, tx *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		for _, invoice := range invoices {
+			if _, err := insertJob(ctx, tx, JobTypeGeneratePDF, PDFJobPayload{InvoiceID: invoice.ID}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// saveInvoices writes invoices in one transaction. then, if not nil, runs in
+// the same transaction after the invoices were written.
+func (s *DBService) saveInvoices(invoices []*models.Invoice, items [][]models.InvoiceItem, recalculate bool, then func(ctx context.Context, tx *sql.Tx) error) error {
+	s.logger.Info("Starting transaction to save %d invoices", len(invoices))
+
+	// Create a context with timeout for database operations
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Ensure the invoice_items table exists
+	if err := s.EnsureInvoiceItemsTable(); err != nil {
+		s.logger.Error("Failed to ensure invoice_items table exists: %v", err)
+		return fmt.Errorf("failed to ensure invoice_items table exists: %w", err)
+	}
+
+	for i, invoice := range invoices {
+		if err := s.prepareInvoice(invoice, items[i], recalculate); err != nil {
+			return err
+		}
+	}
+
+	// Start a transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		s.logger.Error("Failed to begin transaction: %v", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	committed := false
+	newInvoices := make([]bool, len(invoices))
+	defer func() {
+		if !committed {
+			s.logger.Warn("Rolling back transaction due to error")
+			tx.Rollback()
+			// The IDs of invoices that were to be created are gone with the transaction
+			for i, invoice := range invoices {
+				if newInvoices[i] {
+					invoice.ID = 0
+				}
+			}
+		}
+	}()
+
+	for i, invoice := range invoices {
+		newInvoices[i] = invoice.ID == 0
+		if err := s.writeInvoice(ctx, tx, invoice, items[i], recalculate); err != nil {
+			return err
+		}
+	}
+	if then != nil {
+		if err := then(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	s.logger.Info("Committing transaction")
+	if err := tx.Commit(); err != nil {
+		s.logger.Error("Failed to commit transaction: %v", err)
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	committed = true
+	if then != nil && s.jobsQueued != nil {
+		s.jobsQueued()
+	}
+
+	s.logger.Info("Successfully saved %d invoices", len(invoices))
+	return nil
+}
+
+// prepareInvoice sets the default currency and, when recalculate is set,
+// checks and applies the totals of an invoice before it is written
+func (s *DBService) prepareInvoice(invoice *models.Invoice, items []models.InvoiceItem, recalculate bool) error {
+	// If no currency is provided, set a default based on the client's country.
+	// This must happen before the transaction starts, as the pool only has one
+	// connection, see NewDBService and connectPostgres.
+	if invoice.Currency == "" {
+		// Get the client to determine the country
+		client, err := s.GetClient(invoice.ClientID)
+		if err == nil && client != nil {
+			// Set currency based on client's country
+			invoice.Currency = GetCurrencyForCountry(client.Country)
+			s.logger.Info("Set currency to %s based on client's country %s", invoice.Currency, client.Country)
+		} else {
+			// Default to EUR if client can't be found
+			invoice.Currency = "EUR"
+			s.logger.Info("Set default currency to EUR")
+		}
+	}
+
+	if recalculate {
+		if err := verifyInvoiceTotals(invoice, items); err != nil {
+			s.logger.Warn("Rejecting invoice %s: %v", invoice.InvoiceNumber, err)
+			return err
+		}
+		invoice.ApplyTotals(items)
+	}
+	return nil
+}
+
+// writeInvoice inserts or updates an invoice and its items inside tx
+func (s *DBService) writeInvoice(ctx context.Context, tx *sql.Tx, invoice *models.Invoice, items []models.InvoiceItem, recalculate bool) error {
+	var err error
+	if invoice.Type == "" {
+		invoice.Type = models.InvoiceTypeInvoice
+	}
+
+	// Generate invoice number if not provided
+	if invoice.InvoiceNumber == "" {
+		year := invoice.IssueDate.Year()
+		if invoice.IssueDate.IsZero() {
+			year = time.Now().Year()
+		}
+
+		invoice.InvoiceNumber, err = nextInvoiceNumber(ctx, tx, invoice.Type, year)
+		if err != nil {
+			s.logger.Error("Failed to generate invoice number for year %d: %v", year, err)
+			return fmt.Errorf("failed to generate invoice number: %w", err)
+		}
+		s.logger.Info("Generated invoice number: %s", invoice.InvoiceNumber)
+	}
+
+	// Invoices cannot be added to or moved into a closed year
+	if err := checkYearOpen(ctx, tx, invoice.IssueDate); err != nil {
+		return err
+	}
+
+	// Reject numbers that are already taken by another invoice
+	var numberTaken bool
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) > 0 FROM invoices WHERE invoice_number = ? AND id != ?`,
+		invoice.InvoiceNumber, invoice.ID).Scan(&numberTaken)
+	if err != nil {
+		s.logger.Error("Failed to check invoice number uniqueness: %v", err)
+		return fmt.Errorf("failed to check invoice number: %w", err)
+	}
+	if numberTaken {
+		s.logger.Warn("Invoice number %s is already in use", invoice.InvoiceNumber)
+		return fmt.Errorf("%w: %s", ErrDuplicateInvoiceNumber, invoice.InvoiceNumber)
+	}
+
+	// Invoices saved as paid were paid today unless a payment date is given;
+	// the payment date of imported invoices is only known from the import
+	defaultPaidDate := ""
+	if recalculate {
+		defaultPaidDate = time.Now().Format("2006-01-02")
+	}
+
+	if invoice.ID == 0 {
+		// Insert new invoice
+		s.logger.Info("Creating new invoice with number: %s", invoice.InvoiceNumber)
+
+		paidDate := ""
+		if invoice.Status == models.InvoiceStatusPaid {
+			paidDate = cmp.Or(formatOptionalDate(invoice.PaidDate), defaultPaidDate)
+		}
+		statusReason := ""
+		if invoice.IsHeld() {
+			statusReason = invoice.StatusReason
+		}
+
+		// Log the invoice data for debugging
+		s.logger.Debug("Invoice data: ClientID=%d, BusinessID=%d, IssueDate=%s, DueDate=%s, Total=%s, Currency=%s",
+			invoice.ClientID, invoice.BusinessID, invoice.IssueDate.Format("2006-01-02"),
+			invoice.DueDate.Format("2006-01-02"), invoice.TotalAmount, invoice.Currency)
+
+		var id int64
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO invoices (invoice_number, business_id, client_id, issue_date, due_date, hourly_rate, hours_worked, total_amount, vat_rate, vat_amount, reverse_charge_vat, currency, notes, status,
+				po_number, contract_reference, service_period_start, service_period_end, discount_percent, discount_amount, type, project_id, hours_breakdown,
+				home_currency, exchange_rate, exchange_rate_date, paid_date, paypal, status_reason)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id
+		`, invoice.InvoiceNumber, invoice.BusinessID, invoice.ClientID, invoice.IssueDate.Format("2006-01-02"), invoice.DueDate.Format("2006-01-02"),
+			invoice.HourlyRate, invoice.HoursWorked, invoice.TotalAmount, invoice.VatRate, invoice.VatAmount, boolToInt(invoice.ReverseChargeVat), invoice.Currency, invoice.Notes, invoice.Status,
+			invoice.PONumber, invoice.ContractReference, formatOptionalDate(invoice.ServicePeriodStart), formatOptionalDate(invoice.ServicePeriodEnd),
+			invoice.DiscountPercent, invoice.DiscountAmount, invoice.Type, invoice.ProjectID, invoice.ShowHoursBreakdown,
+			invoice.HomeCurrency, invoice.ExchangeRate, formatOptionalDate(invoice.ExchangeRateDate), paidDate, invoice.PayPal, statusReason).Scan(&id)
+		if err != nil {
+			s.logger.Error("Failed to insert invoice: %v", err)
+			return fmt.Errorf("failed to insert invoice: %w", err)
+		}
+		invoice.ID = int(id)
+		s.logger.Info("Created new invoice with ID: %d", invoice.ID)
+	} else {
+		// Update existing invoice. The document type is fixed once created, a
+		// pro-forma only becomes an invoice through ConvertProforma.
+		s.logger.Info("Updating existing invoice with ID: %d", invoice.ID)
+		var issueDate, number string
+		err := tx.QueryRowContext(ctx, `SELECT type, converted_invoice_id, credit_applied, issue_date, invoice_number, cancels_invoice_id FROM invoices WHERE id = ?`, invoice.ID).
+			Scan(&invoice.Type, &invoice.ConvertedInvoiceID, &invoice.CreditApplied, &issueDate, &number, &invoice.CancelsInvoiceID)
+		if err != nil {
+			s.logger.Error("Failed to load invoice type: %v", err)
+			return fmt.Errorf("failed to load invoice: %w", err)
+		}
+		if err := checkYearOpen(ctx, tx, parseOptionalDate(issueDate)); err != nil {
+			return err
+		}
+		if invoice.IsStorno() {
+			return s.writeStornoEdit(ctx, tx, invoice)
+		}
+		// The old number leaves a gap in its sequence, which the audit log explains
+		if number != invoice.InvoiceNumber {
+			if err := logAudit(ctx, tx, AuditActionRenumber, "invoice", invoice.ID, number+" renumbered to "+invoice.InvoiceNumber); err != nil {
+				return err
+			}
+		}
+		if invoice.TotalAmount < invoice.CreditApplied {
+			return fmt.Errorf("%w: the total is %s, the credit %s", ErrCreditExceedsTotal, invoice.TotalAmount, invoice.CreditApplied)
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE invoices
+			SET invoice_number = ?, business_id = ?, client_id = ?, issue_date = ?, due_date = ?, hourly_rate = ?, hours_worked = ?, total_amount = ?, vat_rate = ?, vat_amount = ?, reverse_charge_vat = ?, currency = ?, notes = ?, status = ?,
+				po_number = ?, contract_reference = ?, service_period_start = ?, service_period_end = ?, discount_percent = ?, discount_amount = ?, project_id = ?, hours_breakdown = ?,
+				home_currency = ?, exchange_rate = ?, exchange_rate_date = ?, paid_date = `+paidDateSQL+`, paypal = ?, status_reason = `+statusReasonSQL+`
+			WHERE id = ?
+		`, invoice.InvoiceNumber, invoice.BusinessID, invoice.ClientID, invoice.IssueDate.Format("2006-01-02"), invoice.DueDate.Format("2006-01-02"),
+			invoice.HourlyRate, invoice.HoursWorked, invoice.TotalAmount, invoice.VatRate, invoice.VatAmount, boolToInt(invoice.ReverseChargeVat), invoice.Currency, invoice.Notes, invoice.Status,
+			invoice.PONumber, invoice.ContractReference, formatOptionalDate(invoice.ServicePeriodStart), formatOptionalDate(invoice.ServicePeriodEnd),
+			invoice.DiscountPercent, invoice.DiscountAmount, invoice.ProjectID, invoice.ShowHoursBreakdown,
+			invoice.HomeCurrency, invoice.ExchangeRate, formatOptionalDate(invoice.ExchangeRateDate),
+			invoice.Status, formatOptionalDate(invoice.PaidDate), defaultPaidDate, invoice.PayPal, invoice.Status, invoice.StatusReason, invoice.ID)
+		if err != nil {
+			s.logger.Error("Failed to update invoice: %v", err)
+			return fmt.Errorf("failed to update invoice: %w", err)
+		}
+
+		// Delete existing items
+		s.logger.Info("Deleting existing invoice items for invoice ID: %d", invoice.ID)
+		_, err = tx.ExecContext(ctx, `DELETE FROM invoice_items WHERE invoice_id = ?`, invoice.ID)
+		if err != nil {
+			s.logger.Error("Failed to delete existing invoice items: %v", err)
+			return fmt.Errorf("failed to delete existing invoice items: %w", err)
+		}
+	}
+
+	// Insert invoice items
+	s.logger.Info("Inserting %d invoice items", len(items))
+	for i := range items {
+		items[i].InvoiceID = invoice.ID
+		if items[i].Unit == "" {
+			items[i].Unit = models.UnitHours
+		}
+		if items[i].Kind == "" {
+			items[i].Kind = models.ItemKindService
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO invoice_items (invoice_id, description, quantity, unit, unit_price, amount, discount_percent, discount_amount, kind, vat_exempt)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, items[i].InvoiceID, items[i].Description, items[i].Quantity, items[i].Unit, items[i].UnitPrice, items[i].Amount,
+			items[i].DiscountPercent, items[i].DiscountAmount, items[i].Kind, boolToInt(items[i].VatExempt))
+		if err != nil {
+			s.logger.Error("Failed to insert invoice item %d: %v", i, err)
+			return fmt.Errorf("failed to insert invoice item: %w", err)
+		}
+	}
+
+	// Pasted hours are stored as time entries without a project; project
+	// time is billed through the project
+	if invoice.HoursBreakdown != nil {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM time_entries WHERE invoice_id = ? AND project_id = 0`, invoice.ID); err != nil {
+			return fmt.Errorf("failed to replace hours breakdown: %w", err)
+		}
+		for _, entry := range invoice.HoursBreakdown {
+			if entry.ProjectID != 0 {
+				continue
+			}
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO time_entries (project_id, date, hours, description, invoice_id) VALUES (0, ?, ?, ?, ?)
+			`, entry.Date.Format("2006-01-02"), entry.Hours, entry.Description, invoice.ID)
+			if err != nil {
+				return fmt.Errorf("failed to save hours breakdown: %w", err)
+			}
+		}
+	}
+
+	if invoice.Tags != nil {
+		if err := setInvoiceTags(ctx, tx, invoice.ID, invoice.Tags); err != nil {
+			return err
+		}
+	}
+
+	s.logger.Info("Saved invoice %s and %d items", invoice.InvoiceNumber, len(items))
+	return nil
+}
+
+// writeStornoEdit saves the changes to a cancellation invoice inside tx. Only
+// its notes, dates and tags can change; its number, amounts and items keep
+// mirroring the invoice it cancels.
+func (s *DBService) writeStornoEdit(ctx context.Context, tx *sql.Tx, invoice *models.Invoice) error {
+	_, err := tx.ExecContext(ctx, `UPDATE invoices SET issue_date = ?, due_date = ?, notes = ? WHERE id = ?`,
+		invoice.IssueDate.Format("2006-01-02"), invoice.DueDate.Format("2006-01-02"), invoice.Notes, invoice.ID)
+	if err != nil {
+		s.logger.Error("Failed to update cancellation invoice: %v", err)
+		return fmt.Errorf("failed to update invoice: %w", err)
+	}
+	if invoice.Tags != nil {
+		if err := setInvoiceTags(ctx, tx, invoice.ID, invoice.Tags); err != nil {
+			return err
+		}
+	}
+	s.logger.Info("Saved the notes and dates of cancellation invoice %d", invoice.ID)
+	return nil
+}
+
+// invoiceNumberPrefixes are the number prefixes of the document types, each
+// numbered in its own sequence
+var invoiceNumberPrefixes = map[string]string{
+	models.InvoiceTypeInvoice:  "INV",
+	models.InvoiceTypeProforma: "PRO",
+}
+
+// nextInvoiceNumber reserves the next number of a document type for the given
+// year inside tx. The sequence is seeded from existing invoices the first time
+// a year is used.
+func nextInvoiceNumber(ctx context.Context, tx *sql.Tx, invoiceType string, year int) (string, error) {
+	prefix := fmt.Sprintf("%s-%d-", invoiceNumberPrefixes[invoiceType], year)
+
+	// Seed the sequence from the highest number already issued for this year
+	seed, err := highestInvoiceNumber(ctx, tx, prefix)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO number_sequences (scope, year, last_value) VALUES (?, ?, ?)
+		ON CONFLICT (scope, year) DO NOTHING
+	`, invoiceType, year, seed)
+	if err != nil {
+		return "", fmt.Errorf("failed to seed invoice sequence: %w", err)
+	}
+
+	// Skip over any numbers that were entered manually
+	for {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE number_sequences SET last_value = last_value + 1
+			WHERE scope = ? AND year = ?
+		`, invoiceType, year)
+		if err != nil {
+			return "", fmt.Errorf("failed to advance invoice sequence: %w", err)
+		}
+
+		var value int
+		err = tx.QueryRowContext(ctx, `
+			SELECT last_value FROM number_sequences WHERE scope = ? AND year = ?
+		`, invoiceType, year).Scan(&value)
+		if err != nil {
+			return "", fmt.Errorf("failed to read invoice sequence: %w", err)
+		}
+
+		// Generate invoice number in format: INV-YYYY-XXXX (PRO-YYYY-XXXX for pro-formas)
+		number := fmt.Sprintf("%s%04d", prefix, value)
+
+		var exists bool
+		err = tx.QueryRowContext(ctx, `SELECT COUNT(*) > 0 FROM invoices WHERE invoice_number = ?`, number).Scan(&exists)
+		if err != nil {
+			return "", fmt.Errorf("failed to check invoice number: %w", err)
+		}
+		if !exists {
+			return number, nil
+		}
+	}
+}
+
+// highestInvoiceNumber returns the highest sequence value of the invoice
+// numbers starting with prefix, reading the digits the suffix starts with, or
+// 0 if there are none
+func highestInvoiceNumber(ctx context.Context, tx *sql.Tx, prefix string) (int, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT invoice_number FROM invoices WHERE invoice_number LIKE ?`, prefix+"%")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read invoice numbers: %w", err)
+	}
+	defer rows.Close()
+
+	highest := 0
+	for rows.Next() {
+		var number string
+		if err := rows.Scan(&number); err != nil {
+			return 0, fmt.Errorf("failed to read invoice numbers: %w", err)
+		}
+		suffix := number[min(len(prefix), len(number)):]
+		digits := len(suffix) - len(strings.TrimLeft(suffix, "0123456789"))
+		if value, err := strconv.Atoi(suffix[:digits]); err == nil {
+			highest = max(highest, value)
+		}
+	}
+	return highest, rows.Err()
+}
+
+// GetInvoice retrieves an invoice from the database
+func (s *DBService) GetInvoice(id int) (*models.Invoice, []models.InvoiceItem, error) {
+	// Create a context with timeout for database operations
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s.logger.Info("Fetching invoice with ID: %d", id)
+
+	// Get invoice
+	var invoice models.Invoice
+	var issueDate, dueDate string
+	var reverseChargeVat int
+	var currency sql.NullString // Use sql.NullString to handle NULL values
+	var servicePeriodStart, servicePeriodEnd, exchangeRateDate, paidDate string
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, invoice_number, business_id, client_id, issue_date, due_date, hourly_rate, hours_worked, total_amount, vat_rate, vat_amount, reverse_charge_vat, currency, notes, status,
+			po_number, contract_reference, service_period_start, service_period_end, discount_percent, discount_amount, type, converted_invoice_id, credit_applied, project_id, hours_breakdown,
+			home_currency, exchange_rate, exchange_rate_date, paid_date, paypal, crypto_amount, crypto_rate, crypto_fiat_amount, status_reason,
+			cancels_invoice_id, cancelled_by_invoice_id
+		FROM invoices
 		WHERE id = ?
-	`
+	`, id).Scan(
+		&invoice.ID,
+		&invoice.InvoiceNumber,
+		&invoice.BusinessID,
+		&invoice.ClientID,
+		&issueDate,
+		&dueDate,
+		&invoice.HourlyRate,
+		&invoice.HoursWorked,
+		&invoice.TotalAmount,
+		&invoice.VatRate,
+		&invoice.VatAmount,
+		&reverseChargeVat,
+		&currency,
+		&invoice.Notes,
+		&invoice.Status,
+		&invoice.PONumber,
+		&invoice.ContractReference,
+		&servicePeriodStart,
+		&servicePeriodEnd,
+		&invoice.DiscountPercent,
+		&invoice.DiscountAmount,
+		&invoice.Type,
+		&invoice.ConvertedInvoiceID,
+		&invoice.CreditApplied,
+		&invoice.ProjectID,
+		&invoice.ShowHoursBreakdown,
+		&invoice.HomeCurrency,
+		&invoice.ExchangeRate,
+		&exchangeRateDate,
+		&paidDate,
+		&invoice.PayPal,
+		&invoice.CryptoAmount,
+		&invoice.CryptoRate,
+		&invoice.CryptoFiatAmount,
+		&invoice.StatusReason,
+		&invoice.CancelsInvoiceID,
+		&invoice.CancelledByInvoiceID,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			s.logger.Warn("No invoice found with ID: %d", id)
+		} else {
+			s.logger.Error("Database error when fetching invoice ID %d: %v", id, err)
+		}
+		return nil, nil, err
+	}
+
+	// Parse dates
+	invoice.IssueDate, err = time.Parse("2006-01-02", issueDate)
+	if err != nil {
+		s.logger.Error("Failed to parse issue date: %v", err)
+		return nil, nil, fmt.Errorf("failed to parse issue date: %w", err)
+	}
+
+	invoice.DueDate, err = time.Parse("2006-01-02", dueDate)
+	if err != nil {
+		s.logger.Error("Failed to parse due date: %v", err)
+		return nil, nil, fmt.Errorf("failed to parse due date: %w", err)
+	}
+
+	// Convert reverseChargeVat to bool
+	invoice.ReverseChargeVat = intToBool(reverseChargeVat)
+	invoice.ServicePeriodStart = parseOptionalDate(servicePeriodStart)
+	invoice.ServicePeriodEnd = parseOptionalDate(servicePeriodEnd)
+	invoice.ExchangeRateDate = parseOptionalDate(exchangeRateDate)
+	invoice.PaidDate = parseOptionalDate(paidDate)
+
+	// Handle currency
+	if currency.Valid {
+		invoice.Currency = currency.String
+	} else {
+		invoice.Currency = "EUR" // Default to EUR if NULL
+	}
+
+	// Get invoice items
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, invoice_id, description, quantity, unit, unit_price, amount, discount_percent, discount_amount, kind, vat_exempt
+		FROM invoice_items
+		WHERE invoice_id = ?
+	`, id)
+	if err != nil {
+		s.logger.Error("Failed to fetch invoice items: %v", err)
+		return nil, nil, fmt.Errorf("failed to fetch invoice items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []models.InvoiceItem
+	for rows.Next() {
+		var item models.InvoiceItem
+		var vatExempt int
+		if err := rows.Scan(
+			&item.ID,
+			&item.InvoiceID,
+			&item.Description,
+			&item.Quantity,
+			&item.Unit,
+			&item.UnitPrice,
+			&item.Amount,
+			&item.DiscountPercent,
+			&item.DiscountAmount,
+			&item.Kind,
+			&vatExempt,
+		); err != nil {
+			s.logger.Error("Failed to scan invoice item: %v", err)
+			return nil, nil, fmt.Errorf("failed to scan invoice item: %w", err)
+		}
+		item.VatExempt = vatExempt != 0
+		items = append(items, item)
+	}
 
-	s.logger.Debug("Executing query: %s with ID: %d", query, id)
-	err := s.db.QueryRowContext(ctx, query, id).Scan(
-		&client.ID,
-		&client.Name,
-		&client.Address,
-		&client.City,
-		&client.PostalCode,
-		&client.Country,
-		&client.VatID,
-		&client.CreatedDate,
-		&client.Deleted,
-	)
+	if err := rows.Err(); err != nil {
+		s.logger.Error("Error iterating invoice items: %v", err)
+		return nil, nil, fmt.Errorf("error iterating invoice items: %w", err)
+	}
 
+	tags, err := getInvoiceTags(ctx, s.db, id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			s.logger.Warn("No client found with ID: %d", id)
+		return nil, nil, fmt.Errorf("failed to fetch invoice tags: %w", err)
+	}
+	invoice.Tags = tags[id]
+	if invoice.Tags == nil {
+		invoice.Tags = []string{}
+	}
+
+	s.logger.Info("Successfully fetched invoice #%s with %d items", invoice.InvoiceNumber, len(items))
+	return &invoice, items, nil
+}
+
+// GetInvoices retrieves all invoices from the database
+func (s *DBService) GetInvoices() ([]models.Invoice, error) {
+	return getInvoices(context.Background(), s.db)
+}
+
+// rowsQueryer is satisfied by both *sql.DB and *sql.Tx
+type rowsQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// getInvoices retrieves all invoices using db, which may be a transaction
+func getInvoices(ctx context.Context, db rowsQueryer) ([]models.Invoice, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, invoice_number, business_id, client_id, issue_date, due_date, hourly_rate, hours_worked, total_amount, vat_rate, vat_amount, reverse_charge_vat, currency, notes, status,
+			po_number, contract_reference, service_period_start, service_period_end, discount_percent, discount_amount, type, converted_invoice_id, credit_applied, project_id, hours_breakdown,
+			home_currency, exchange_rate, exchange_rate_date, paid_date, paypal, crypto_amount, crypto_rate, crypto_fiat_amount, status_reason,
+			cancels_invoice_id, cancelled_by_invoice_id
+		FROM invoices
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invoices []models.Invoice
+	for rows.Next() {
+		var invoice models.Invoice
+		var issueDate, dueDate string
+		var reverseChargeVat int
+		var currency sql.NullString // Use sql.NullString to handle NULL values
+		var servicePeriodStart, servicePeriodEnd, exchangeRateDate, paidDate string
+		err := rows.Scan(
+			&invoice.ID, &invoice.InvoiceNumber, &invoice.BusinessID, &invoice.ClientID, &issueDate, &dueDate,
+			&invoice.HourlyRate, &invoice.HoursWorked, &invoice.TotalAmount, &invoice.VatRate, &invoice.VatAmount,
+			&reverseChargeVat, &currency, &invoice.Notes, &invoice.Status,
+			&invoice.PONumber, &invoice.ContractReference, &servicePeriodStart, &servicePeriodEnd,
+			&invoice.DiscountPercent, &invoice.DiscountAmount, &invoice.Type, &invoice.ConvertedInvoiceID, &invoice.CreditApplied, &invoice.ProjectID, &invoice.ShowHoursBreakdown,
+			&invoice.HomeCurrency, &invoice.ExchangeRate, &exchangeRateDate, &paidDate, &invoice.PayPal,
+			&invoice.CryptoAmount, &invoice.CryptoRate, &invoice.CryptoFiatAmount, &invoice.StatusReason,
+			&invoice.CancelsInvoiceID, &invoice.CancelledByInvoiceID,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// Parse dates
+		invoice.IssueDate, _ = time.Parse("2006-01-02", issueDate)
+		invoice.DueDate, _ = time.Parse("2006-01-02", dueDate)
+		invoice.ReverseChargeVat = intToBool(reverseChargeVat)
+		invoice.ServicePeriodStart = parseOptionalDate(servicePeriodStart)
+		invoice.ServicePeriodEnd = parseOptionalDate(servicePeriodEnd)
+		invoice.ExchangeRateDate = parseOptionalDate(exchangeRateDate)
+		invoice.PaidDate = parseOptionalDate(paidDate)
+
+		// Set currency, default to EUR if NULL
+		if currency.Valid {
+			invoice.Currency = currency.String
 		} else {
-			s.logger.Error("Database error when fetching client ID %d: %v", id, err)
+			invoice.Currency = "EUR"
 		}
+
+		invoices = append(invoices, invoice)
+	}
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	s.logger.Debug("Successfully fetched client: %s (ID: %d)", client.Name, client.ID)
-	return &client, nil
+	tags, err := getInvoiceTags(ctx, db, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := range invoices {
+		invoices[i].Tags = tags[invoices[i].ID]
+		if invoices[i].Tags == nil {
+			invoices[i].Tags = []string{}
+		}
+	}
+	return invoices, nil
 }
 
-// GetClients retrieves all clients from the database
-func (s *DBService) GetClients() ([]models.Client, error) {
+// getInvoiceTags returns the tags of an invoice, or of all invoices if
+// invoiceID is 0, keyed by invoice ID
+func getInvoiceTags(ctx context.Context, db rowsQueryer, invoiceID int) (map[int][]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT invoice_id, tag FROM invoice_tags WHERE ? = 0 OR invoice_id = ? ORDER BY invoice_id, LOWER(tag)
+	`, invoiceID, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[int][]string)
+	for rows.Next() {
+		var id int
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return nil, err
+		}
+		tags[id] = append(tags[id], tag)
+	}
+	return tags, rows.Err()
+}
+
+// setInvoiceTags replaces the tags of an invoice with normalized tags
+func setInvoiceTags(ctx context.Context, tx *sql.Tx, invoiceID int, tags []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM invoice_tags WHERE invoice_id = ?`, invoiceID); err != nil {
+		return fmt.Errorf("failed to replace invoice tags: %w", err)
+	}
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO invoice_tags (invoice_id, tag) VALUES (?, ?)`, invoiceID, tag); err != nil {
+			return fmt.Errorf("failed to save invoice tags: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetInvoiceTags replaces the tags of an invoice. Tags are labels rather than
+// invoice data, so they can be changed in closed fiscal years as well.
+func (s *DBService) SetInvoiceTags(invoiceID int, tags []string) ([]string, error) {
+	tags, err := models.NormalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) > 0 FROM invoices WHERE id = ?`, invoiceID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+	if err := setInvoiceTags(ctx, tx, invoiceID, tags); err != nil {
+		return nil, err
+	}
+	return tags, tx.Commit()
+}
+
+// GetInvoiceTagCounts returns every tag in use with the number of invoices
+// that have it
+func (s *DBService) GetInvoiceTagCounts() ([]models.TagCount, error) {
+	rows, err := s.db.Query(`SELECT MIN(tag), COUNT(*) FROM invoice_tags GROUP BY LOWER(tag) ORDER BY LOWER(tag)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []models.TagCount{}
+	for rows.Next() {
+		var count models.TagCount
+		if err := rows.Scan(&count.Tag, &count.Invoices); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// paidDateSQL is the payment date stored with the status of an invoice: it is
+// cleared unless the invoice is paid, and otherwise set to the given date, the
+// date already stored or the default date, in that order. Its parameters are
+// the new status, the given date and the default date, with empty strings for no date.
+const paidDateSQL = `CASE WHEN ? = '` + models.InvoiceStatusPaid + `' THEN COALESCE(NULLIF(?, ''), NULLIF(paid_date, ''), ?) ELSE '' END`
+
+// statusReasonSQL is the reason stored with the status of an invoice: it is
+// cleared unless the invoice is disputed or on hold, and otherwise set to the
+// given reason or the reason already stored. Its parameters are the new
+// status and the given reason, an empty string for none.
+const statusReasonSQL = `CASE WHEN ? IN ('` + models.InvoiceStatusDisputed + `', '` + models.InvoiceStatusOnHold + `') THEN COALESCE(NULLIF(?, ''), status_reason) ELSE '' END`
+
+// UpdateInvoiceStatus updates the status of an invoice. Invoices marked paid
+// record paidDate as their payment date, or today if it is zero and they have
+// no payment date yet. In a closed fiscal year the only status change allowed
+// is recording a payment, anything else returns ErrYearClosed.
+func (s *DBService) UpdateInvoiceStatus(id int, status string, paidDate time.Time) error {
+	return s.updateInvoiceStatus(id, status, paidDate, "")
+}
+
+// HoldInvoice marks an invoice disputed or on hold for a reason, which
+// replaces the previous one. Like other status changes it returns
+// ErrYearClosed in a closed fiscal year.
+func (s *DBService) HoldInvoice(id int, status, reason string) error {
+	if !models.IsHeldStatus(status) {
+		return fmt.Errorf("invoices cannot be held as %s", status)
+	}
+	return s.updateInvoiceStatus(id, status, time.Time{}, strings.TrimSpace(reason))
+}
+
+// updateInvoiceStatus updates the status of an invoice with its payment date
+// and the reason of disputed and on-hold invoices
+func (s *DBService) updateInvoiceStatus(id int, status string, paidDate time.Time, reason string) error {
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var issueDate, current string
+	if err := tx.QueryRowContext(ctx, `SELECT issue_date, status FROM invoices WHERE id = ?`, id).Scan(&issueDate, &current); err != nil {
+		return err
+	}
+	if status != current && status != models.InvoiceStatusPaid {
+		if err := checkYearOpen(ctx, tx, parseOptionalDate(issueDate)); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE invoices SET status = ?, paid_date = `+paidDateSQL+`, status_reason = `+statusReasonSQL+` WHERE id = ?`,
+		status, status, formatOptionalDate(paidDate), time.Now().Format("2006-01-02"), status, reason, id)
+	if err != nil {
+		return err
+	}
+	// An invoice that is no longer paid was not paid in USDC either
+	if status != models.InvoiceStatusPaid {
+		if _, err := tx.ExecContext(ctx, `UPDATE invoices SET crypto_amount = 0, crypto_rate = 0, crypto_fiat_amount = 0 WHERE id = ?`, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ConvertProforma creates a draft invoice from a pro-forma invoice, numbered in
+// the invoice sequence and issued on issueDate with the same payment term. The
+// amounts are copied as confirmed by the client, and the pro-forma is linked
+// to the new invoice so it cannot be converted twice. prepare, if not nil, is
+// called with the new invoice before it is saved to set its exchange rate.
+func (s *DBService) ConvertProforma(id int, issueDate time.Time, prepare func(invoice *models.Invoice)) (*models.Invoice, error) {
+	proforma, items, err := s.GetInvoice(id)
+	if err != nil {
+		return nil, err
+	}
+	if !proforma.IsProforma() {
+		return nil, ErrNotProforma
+	}
+	if proforma.ConvertedInvoiceID != 0 {
+		return nil, fmt.Errorf("%w into invoice %d", ErrAlreadyConverted, proforma.ConvertedInvoiceID)
+	}
+
+	invoice := *proforma
+	invoice.ID = 0
+	invoice.InvoiceNumber = ""
+	invoice.Type = models.InvoiceTypeInvoice
+	invoice.Status = models.InvoiceStatusDraft
+	invoice.CreditApplied = 0
+	// The exchange rate belongs to the issue date, so prepare sets a new one
+	invoice.HomeCurrency = ""
+	invoice.ExchangeRate = 0
+	invoice.ExchangeRateDate = time.Time{}
+	invoice.IssueDate = issueDate
+	invoice.DueDate = issueDate.Add(proforma.DueDate.Sub(proforma.IssueDate))
+	for i := range items {
+		items[i].ID = 0
+	}
+	// Pasted hours move to the invoice
+	invoice.HoursBreakdown, err = s.pastedHours(id)
+	if err != nil {
+		return nil, err
+	}
+	if prepare != nil {
+		prepare(&invoice)
+	}
+	// The pro-forma is linked in the transaction that creates the invoice, and
+	// only if it was not converted in the meantime
+	link := func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "UPDATE invoices SET converted_invoice_id = ? WHERE id = ? AND converted_invoice_id = 0", invoice.ID, id)
+		if err != nil {
+			return fmt.Errorf("failed to link pro-forma invoice: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrAlreadyConverted
+		}
+		return queueInvoicePDFs(&invoice)(ctx, tx)
+	}
+	if err := s.saveInvoices([]*models.Invoice{&invoice}, [][]models.InvoiceItem{items}, false, link); err != nil {
+		return nil, err
+	}
+	s.logger.Info("Converted pro-forma invoice %s into invoice %s", proforma.InvoiceNumber, invoice.InvoiceNumber)
+	return &invoice, nil
+}
+
+// CancelInvoice creates a cancellation (storno) invoice for an issued
+// invoice, numbered in the invoice sequence and issued on issueDate. It
+// mirrors the original with negated quantities and amounts, so the two net to
+// zero, and both are linked so the invoice cannot be cancelled twice. The
+// cancellation settles the original: both are marked paid on issueDate, and
+// an original that was already paid keeps its payment date. Credit applied
+// to the original returns to the client. Invoices of a closed fiscal year
+// cannot be cancelled. prepare, if not nil, is called with the cancellation
+// invoice and its items before it is saved, to set its exchange rate and
+// number; an error it returns aborts the cancellation.
+func (s *DBService) CancelInvoice(id int, issueDate time.Time, prepare func(invoice *models.Invoice, items []models.InvoiceItem) error) (*models.Invoice, error) {
+	original, items, err := s.GetInvoice(id)
+	if err != nil {
+		return nil, err
+	}
+	if original.Status == models.InvoiceStatusDraft || original.IsProforma() || original.IsStorno() {
+		return nil, ErrNotCancellable
+	}
+	if original.CancelledByInvoiceID != 0 {
+		return nil, fmt.Errorf("%w by invoice %d", ErrAlreadyCancelled, original.CancelledByInvoiceID)
+	}
+
+	storno := *original
+	storno.ID = 0
+	storno.InvoiceNumber = ""
+	storno.Status = models.InvoiceStatusPaid
+	storno.StatusReason = ""
+	storno.PaidDate = issueDate
+	storno.IssueDate = issueDate
+	storno.DueDate = issueDate
+	storno.CancelsInvoiceID = id
+	storno.HoursWorked = -original.HoursWorked
+	storno.TotalAmount = -original.TotalAmount
+	storno.VatAmount = -original.VatAmount
+	storno.CreditApplied = 0
+	storno.PayPal = ""
+	storno.ShowHoursBreakdown = false
+	storno.CryptoAmount, storno.CryptoRate, storno.CryptoFiatAmount = 0, 0, 0
+	// The exchange rate belongs to the issue date, so prepare sets a new one
+	storno.HomeCurrency = ""
+	storno.ExchangeRate = 0
+	storno.ExchangeRateDate = time.Time{}
+	for i := range items {
+		items[i].ID = 0
+		items[i].Quantity = -items[i].Quantity
+		items[i].Amount = -items[i].Amount
+	}
+	if prepare != nil {
+		if err := prepare(&storno, items); err != nil {
+			return nil, err
+		}
+	}
+	// The invoices are linked in the transaction that creates the
+	// cancellation, and only if the original was not cancelled in the meantime
+	link := func(ctx context.Context, tx *sql.Tx) error {
+		// The original is settled, so it must not be locked in a closed year
+		var originalIssued string
+		if err := tx.QueryRowContext(ctx, `SELECT issue_date FROM invoices WHERE id = ?`, id).Scan(&originalIssued); err != nil {
+			return fmt.Errorf("failed to load cancelled invoice: %w", err)
+		}
+		if err := checkYearOpen(ctx, tx, parseOptionalDate(originalIssued)); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE invoices SET cancels_invoice_id = ? WHERE id = ?", id, storno.ID); err != nil {
+			return fmt.Errorf("failed to link cancellation invoice: %w", err)
+		}
+		result, err := tx.ExecContext(ctx, `
+			UPDATE invoices SET cancelled_by_invoice_id = ?, status = ?, status_reason = '',
+				paid_date = CASE WHEN status = ? THEN paid_date ELSE ? END
+			WHERE id = ? AND cancelled_by_invoice_id = 0
+		`, storno.ID, models.InvoiceStatusPaid, models.InvoiceStatusPaid, formatOptionalDate(issueDate), id)
+		if err != nil {
+			return fmt.Errorf("failed to link cancelled invoice: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrAlreadyCancelled
+		}
+		// Credit applied to the original returns to the client, recorded with
+		// the cancellation
+		var credit models.Money
+		if err := tx.QueryRowContext(ctx, `SELECT credit_applied FROM invoices WHERE id = ?`, id).Scan(&credit); err != nil {
+			return fmt.Errorf("failed to read applied credit: %w", err)
+		}
+		if credit > 0 {
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO client_credits (client_id, invoice_id, amount, currency, description, created_at)
+				VALUES (?, ?, ?, ?, ?, ?)
+			`, original.ClientID, storno.ID, credit, original.Currency, "Returned by cancellation invoice "+storno.InvoiceNumber, time.Now().UTC())
+			if err != nil {
+				return fmt.Errorf("failed to return applied credit: %w", err)
+			}
+		}
+		// Emails scheduled for the cancelled invoice are not sent any more
+		if err := cancelScheduledEmails(ctx, tx, `invoice_id = ?`, id); err != nil {
+			return err
+		}
+		return queueInvoicePDFs(&storno)(ctx, tx)
+	}
+	if err := s.saveInvoices([]*models.Invoice{&storno}, [][]models.InvoiceItem{items}, false, link); err != nil {
+		return nil, err
+	}
+	s.logger.Info("Cancelled invoice %s with invoice %s", original.InvoiceNumber, storno.InvoiceNumber)
+	return &storno, nil
+}
+
+// SetInvoiceExchangeRate records the rate used to show the totals of an
+// invoice in the home currency. Invoices of a closed fiscal year keep theirs.
+func (s *DBService) SetInvoiceExchangeRate(id int, homeCurrency string, rate float64, date time.Time) error {
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var issueDate string
+	if err := tx.QueryRowContext(ctx, `SELECT issue_date FROM invoices WHERE id = ?`, id).Scan(&issueDate); err != nil {
+		return err
+	}
+	if err := checkYearOpen(ctx, tx, parseOptionalDate(issueDate)); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE invoices SET home_currency = ?, exchange_rate = ?, exchange_rate_date = ? WHERE id = ?
+	`, homeCurrency, rate, formatOptionalDate(date), id)
+	if err != nil {
+		return fmt.Errorf("failed to set exchange rate: %w", err)
+	}
+	return tx.Commit()
+}
+
+// RecordCryptoPayment marks an invoice paid on paidDate by a payment of
+// amount USDC, worth fiatAmount in the invoice currency at rate. Like any
+// payment it can be recorded in a closed fiscal year.
+func (s *DBService) RecordCryptoPayment(id int, amount models.Money, rate float64, fiatAmount models.Money, paidDate time.Time) error {
+	result, err := s.db.Exec(`
+		UPDATE invoices SET status = ?, paid_date = ?, crypto_amount = ?, crypto_rate = ?, crypto_fiat_amount = ? WHERE id = ?
+	`, models.InvoiceStatusPaid, formatOptionalDate(paidDate), amount, rate, fiatAmount, id)
+	if err != nil {
+		return fmt.Errorf("failed to record crypto payment: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// pastedHours returns the hours pasted into an invoice, which are stored as
+// time entries without a project
+func (s *DBService) pastedHours(invoiceID int) ([]models.TimeEntry, error) {
 	rows, err := s.db.Query(`
-		SELECT id, name, address, city, postal_code, country, vat_id, created_date, deleted
-		FROM clients
-		WHERE deleted = 0
-		ORDER BY name
-	`)
+		SELECT date, hours, description FROM time_entries WHERE invoice_id = ? AND project_id = 0 ORDER BY date, id
+	`, invoiceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hours breakdown: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.TimeEntry{}
+	for rows.Next() {
+		var entry models.TimeEntry
+		var date string
+		if err := rows.Scan(&date, &entry.Hours, &entry.Description); err != nil {
+			return nil, fmt.Errorf("failed to scan hours breakdown: %w", err)
+		}
+		entry.Date, _ = time.Parse("2006-01-02", date)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// DeleteInvoice deletes an invoice and its items from the database
+func (s *DBService) DeleteInvoice(id int) error {
+	// Start a transaction
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Invoices of a closed year are kept, and so are cancelled invoices and
+	// their cancellation invoices, whose numbers must not leave a gap
+	var number, status, issueDate string
+	var cancelledBy, cancels int
+	err = tx.QueryRow("SELECT invoice_number, status, issue_date, cancelled_by_invoice_id, cancels_invoice_id FROM invoices WHERE id = ?", id).Scan(&number, &status, &issueDate, &cancelledBy, &cancels)
+	if err == nil {
+		if err := checkYearOpen(context.Background(), tx, parseOptionalDate(issueDate)); err != nil {
+			return err
+		}
+		if cancelledBy != 0 {
+			return fmt.Errorf("%w by invoice %d", ErrInvoiceCancelled, cancelledBy)
+		}
+		if cancels != 0 {
+			return fmt.Errorf("%w: %s is the cancellation invoice of invoice %d", ErrInvoiceCancelled, number, cancels)
+		}
+	}
+
+	// Delete invoice items first (due to foreign key constraint)
+	_, err = tx.Exec("DELETE FROM invoice_items WHERE invoice_id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM invoice_pdf_versions WHERE invoice_id = ?", id)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	var clients []models.Client
-	for rows.Next() {
-		var client models.Client
-		if err := rows.Scan(&client.ID, &client.Name, &client.Address, &client.City, &client.PostalCode, &client.Country, &client.VatID, &client.CreatedDate, &client.Deleted); err != nil {
-			return nil, err
-		}
-		clients = append(clients, client)
+	_, err = tx.Exec("DELETE FROM invoice_emails WHERE invoice_id = ?", id)
+	if err != nil {
+		return err
 	}
 
-	return clients, nil
-}
+	_, err = tx.Exec("DELETE FROM fatturapa_files WHERE invoice_id = ?", id)
+	if err != nil {
+		return err
+	}
 
-// DeleteClient marks a client as deleted
-func (s *DBService) DeleteClient(id int) error {
-	_, err := s.db.Exec(`
-		UPDATE clients
-		SET deleted = 1
-		WHERE id = ?
-	`, id)
-	return err
-}
+	_, err = tx.Exec("DELETE FROM nav_reports WHERE invoice_id = ?", id)
+	if err != nil {
+		return err
+	}
 
-// Invoice methods
+	_, err = tx.Exec("DELETE FROM ksef_invoices WHERE invoice_id = ?", id)
+	if err != nil {
+		return err
+	}
 
-// SaveInvoice saves an invoice and its items to the database
-func (s *DBService) SaveInvoice(invoice *models.Invoice, items []models.InvoiceItem) error {
-	s.logger.Info("Starting transaction to save invoice")
+	if err := cancelScheduledEmails(context.Background(), tx, `invoice_id = ?`, id); err != nil {
+		return err
+	}
 
-	// Create a context with timeout for database operations
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	_, err = tx.Exec("DELETE FROM contract_invoices WHERE invoice_id = ?", id)
+	if err != nil {
+		return err
+	}
 
-	// Ensure the invoice_items table exists
-	if err := s.EnsureInvoiceItemsTable(); err != nil {
-		s.logger.Error("Failed to ensure invoice_items table exists: %v", err)
-		return fmt.Errorf("failed to ensure invoice_items table exists: %w", err)
+	_, err = tx.Exec("DELETE FROM notifications_sent WHERE event LIKE 'invoice.%' AND entity_id = ?", id)
+	if err != nil {
+		return err
 	}
 
-	// Start a transaction
-	tx, err := s.db.BeginTx(ctx, nil)
+	// Time billed on the invoice becomes unbilled again; pasted hours belong to the invoice only
+	_, err = tx.Exec("DELETE FROM time_entries WHERE invoice_id = ? AND project_id = 0", id)
 	if err != nil {
-		s.logger.Error("Failed to begin transaction: %v", err)
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return err
+	}
+	_, err = tx.Exec("UPDATE time_entries SET invoice_id = 0 WHERE invoice_id = ?", id)
+	if err != nil {
+		return err
 	}
-	defer func() {
-		if err != nil {
-			s.logger.Warn("Rolling back transaction due to error")
-			tx.Rollback()
-		}
-	}()
 
-	// If no currency is provided, set a default based on the client's country
-	if invoice.Currency == "" {
-		// Get the client to determine the country
-		client, err := s.GetClient(invoice.ClientID)
-		if err == nil && client != nil {
-			// Set currency based on client's country
-			invoice.Currency = GetCurrencyForCountry(client.Country)
-			s.logger.Info("Set currency to %s based on client's country %s", invoice.Currency, client.Country)
-		} else {
-			// Default to EUR if client can't be found
-			invoice.Currency = "EUR"
-			s.logger.Info("Set default currency to EUR")
-		}
+	// Credit applied to the invoice returns to the client
+	_, err = tx.Exec("DELETE FROM client_credits WHERE invoice_id = ?", id)
+	if err != nil {
+		return err
 	}
 
-	// Generate invoice number if not provided
-	if invoice.InvoiceNumber == "" {
-		// Get the current year
-		currentYear := time.Now().Year()
+	_, err = tx.Exec("DELETE FROM invoice_tags WHERE invoice_id = ?", id)
+	if err != nil {
+		return err
+	}
 
-		// Count existing invoices for this year
-		var count int
-		err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM invoices WHERE strftime('%Y', issue_date) = ?",
-			strconv.Itoa(currentYear)).Scan(&count)
-		if err != nil {
-			s.logger.Error("Failed to count invoices for year %d: %v", currentYear, err)
-			return fmt.Errorf("failed to count invoices: %w", err)
-		}
+	_, err = tx.Exec("DELETE FROM notes WHERE entity_type = ? AND entity_id = ?", models.NoteEntityInvoice, id)
+	if err != nil {
+		return err
+	}
 
-		// Generate invoice number in format: INV-YYYY-XXXX
-		invoice.InvoiceNumber = fmt.Sprintf("INV-%d-%04d", currentYear, count+1)
-		s.logger.Info("Generated invoice number: %s", invoice.InvoiceNumber)
+	// A pro-forma converted into this invoice can be converted again
+	_, err = tx.Exec("UPDATE invoices SET converted_invoice_id = 0 WHERE converted_invoice_id = ?", id)
+	if err != nil {
+		return err
 	}
 
-	if invoice.ID == 0 {
-		// Insert new invoice
-		s.logger.Info("Creating new invoice with number: %s", invoice.InvoiceNumber)
+	// Delete the invoice
+	result, err := tx.Exec("DELETE FROM invoices WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
 
-		// Log the invoice data for debugging
-		s.logger.Debug("Invoice data: ClientID=%d, BusinessID=%d, IssueDate=%s, DueDate=%s, Total=%f, Currency=%s",
-			invoice.ClientID, invoice.BusinessID, invoice.IssueDate.Format("2006-01-02"),
-			invoice.DueDate.Format("2006-01-02"), invoice.TotalAmount, invoice.Currency)
+	// Check if the invoice was found and deleted
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("invoice with ID %d not found", id)
+	}
 
-		result, err := tx.ExecContext(ctx, `
-			INSERT INTO invoices (invoice_number, business_id, client_id, issue_date, due_date, hourly_rate, hours_worked, total_amount, vat_rate, vat_amount, reverse_charge_vat, currency, notes, status)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`, invoice.InvoiceNumber, invoice.BusinessID, invoice.ClientID, invoice.IssueDate.Format("2006-01-02"), invoice.DueDate.Format("2006-01-02"),
-			invoice.HourlyRate, invoice.HoursWorked, invoice.TotalAmount, invoice.VatRate, invoice.VatAmount, boolToInt(invoice.ReverseChargeVat), invoice.Currency, invoice.Notes, invoice.Status)
-		if err != nil {
-			s.logger.Error("Failed to insert invoice: %v", err)
-			return fmt.Errorf("failed to insert invoice: %w", err)
-		}
+	// The number leaves a gap in its sequence, which the audit log explains
+	details := fmt.Sprintf("%s deleted (%s, issued %s)", number, status, issueDate)
+	if err := logAudit(context.Background(), tx, AuditActionDelete, "invoice", id, details); err != nil {
+		return err
+	}
 
-		id, err := result.LastInsertId()
-		if err != nil {
-			s.logger.Error("Failed to get last insert ID: %v", err)
-			return fmt.Errorf("failed to get last insert ID: %w", err)
-		}
-		invoice.ID = int(id)
-		s.logger.Info("Created new invoice with ID: %d", invoice.ID)
-	} else {
-		// Update existing invoice
-		s.logger.Info("Updating existing invoice with ID: %d", invoice.ID)
-		_, err := tx.ExecContext(ctx, `
-			UPDATE invoices
-			SET invoice_number = ?, business_id = ?, client_id = ?, issue_date = ?, due_date = ?, hourly_rate = ?, hours_worked = ?, total_amount = ?, vat_rate = ?, vat_amount = ?, reverse_charge_vat = ?, currency = ?, notes = ?, status = ?
-			WHERE id = ?
-		`, invoice.InvoiceNumber, invoice.BusinessID, invoice.ClientID, invoice.IssueDate.Format("2006-01-02"), invoice.DueDate.Format("2006-01-02"),
-			invoice.HourlyRate, invoice.HoursWorked, invoice.TotalAmount, invoice.VatRate, invoice.VatAmount, boolToInt(invoice.ReverseChargeVat), invoice.Currency, invoice.Notes, invoice.Status, invoice.ID)
-		if err != nil {
-			s.logger.Error("Failed to update invoice: %v", err)
-			return fmt.Errorf("failed to update invoice: %w", err)
-		}
+	// Commit the transaction
+	return tx.Commit()
+}
 
-		// Delete existing items
-		s.logger.Info("Deleting existing invoice items for invoice ID: %d", invoice.ID)
-		_, err = tx.ExecContext(ctx, `DELETE FROM invoice_items WHERE invoice_id = ?`, invoice.ID)
-		if err != nil {
-			s.logger.Error("Failed to delete existing invoice items: %v", err)
-			return fmt.Errorf("failed to delete existing invoice items: %w", err)
-		}
+// GetInvoicePDFVersions returns the generated PDF versions of an invoice, newest first
+func (s *DBService) GetInvoicePDFVersions(invoiceID int) ([]models.InvoicePDFVersion, error) {
+	rows, err := s.db.Query(`
+		SELECT id, invoice_id, version, filename, fingerprint, created_at
+		FROM invoice_pdf_versions WHERE invoice_id = ? ORDER BY version DESC
+	`, invoiceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query PDF versions: %w", err)
 	}
+	defer rows.Close()
 
-	// Insert invoice items
-	s.logger.Info("Inserting %d invoice items", len(items))
-	for i := range items {
-		items[i].InvoiceID = invoice.ID
-		_, err := tx.ExecContext(ctx, `
-			INSERT INTO invoice_items (invoice_id, description, quantity, unit_price, amount)
-			VALUES (?, ?, ?, ?, ?)
-		`, items[i].InvoiceID, items[i].Description, items[i].Quantity, items[i].UnitPrice, items[i].Amount)
-		if err != nil {
-			s.logger.Error("Failed to insert invoice item %d: %v", i, err)
-			return fmt.Errorf("failed to insert invoice item: %w", err)
+	var versions []models.InvoicePDFVersion
+	for rows.Next() {
+		var version models.InvoicePDFVersion
+		if err := rows.Scan(&version.ID, &version.InvoiceID, &version.Version, &version.Filename, &version.Fingerprint, &version.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan PDF version: %w", err)
 		}
+		versions = append(versions, version)
 	}
+	return versions, rows.Err()
+}
 
-	s.logger.Info("Committing transaction")
-	if err := tx.Commit(); err != nil {
-		s.logger.Error("Failed to commit transaction: %v", err)
-		return fmt.Errorf("failed to commit transaction: %w", err)
+// AddInvoicePDFVersion records a generated PDF as the next version of its invoice
+func (s *DBService) AddInvoicePDFVersion(version *models.InvoicePDFVersion) error {
+	version.CreatedAt = time.Now().UTC()
+	err := s.db.QueryRow(`
+		INSERT INTO invoice_pdf_versions (invoice_id, version, filename, fingerprint, created_at)
+		VALUES (?, (SELECT COALESCE(MAX(version), 0) + 1 FROM invoice_pdf_versions WHERE invoice_id = ?), ?, ?, ?)
+		RETURNING id, version
+	`, version.InvoiceID, version.InvoiceID, version.Filename, version.Fingerprint, version.CreatedAt).Scan(&version.ID, &version.Version)
+	if err != nil {
+		return fmt.Errorf("failed to add PDF version: %w", err)
 	}
+	return nil
+}
 
-	s.logger.Info("Successfully saved invoice and %d items", len(items))
+// UpdateInvoicePDFVersionFilename records that the PDF of a version was moved
+func (s *DBService) UpdateInvoicePDFVersionFilename(id int, filename string) error {
+	_, err := s.db.Exec(`UPDATE invoice_pdf_versions SET filename = ? WHERE id = ?`, filename, id)
+	if err != nil {
+		return fmt.Errorf("failed to update PDF version: %w", err)
+	}
 	return nil
 }
 
-// GetInvoice retrieves an invoice from the database
-func (s *DBService) GetInvoice(id int) (*models.Invoice, []models.InvoiceItem, error) {
-	// Create a context with timeout for database operations
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// DeleteInvoicePDFVersions forgets all PDF versions of an invoice
+func (s *DBService) DeleteInvoicePDFVersions(invoiceID int) error {
+	_, err := s.db.Exec(`DELETE FROM invoice_pdf_versions WHERE invoice_id = ?`, invoiceID)
+	if err != nil {
+		return fmt.Errorf("failed to delete PDF versions: %w", err)
+	}
+	return nil
+}
 
-	s.logger.Info("Fetching invoice with ID: %d", id)
+// AddInvoiceEmail records an email sent for an invoice
+func (s *DBService) AddInvoiceEmail(email *models.InvoiceEmail) error {
+	email.SentAt = time.Now().UTC()
+	email.Status = models.EmailStatusSent
+	err := s.db.QueryRow(`
+		INSERT INTO invoice_emails (invoice_id, kind, recipient, message_id, status, sent_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, email.InvoiceID, email.Kind, email.Recipient, email.MessageID, email.Status, email.SentAt).Scan(&email.ID)
+	if err != nil {
+		return fmt.Errorf("failed to record sent email: %w", err)
+	}
+	return nil
+}
 
-	// Get invoice
-	var invoice models.Invoice
-	var issueDate, dueDate string
-	var reverseChargeVat int
-	var currency sql.NullString // Use sql.NullString to handle NULL values
+// GetInvoiceEmails returns the emails sent for an invoice, newest first
+func (s *DBService) GetInvoiceEmails(invoiceID int) ([]models.InvoiceEmail, error) {
+	return s.queryInvoiceEmails(`WHERE invoice_id = ? ORDER BY sent_at DESC, id DESC`, invoiceID)
+}
 
-	err := s.db.QueryRowContext(ctx, `
-		SELECT id, invoice_number, business_id, client_id, issue_date, due_date, hourly_rate, hours_worked, total_amount, vat_rate, vat_amount, reverse_charge_vat, currency, notes, status
-		FROM invoices
-		WHERE id = ?
-	`, id).Scan(
-		&invoice.ID,
-		&invoice.InvoiceNumber,
-		&invoice.BusinessID,
-		&invoice.ClientID,
-		&issueDate,
-		&dueDate,
-		&invoice.HourlyRate,
-		&invoice.HoursWorked,
-		&invoice.TotalAmount,
-		&invoice.VatRate,
-		&invoice.VatAmount,
-		&reverseChargeVat,
-		&currency,
-		&invoice.Notes,
-		&invoice.Status,
-	)
+// GetClientInvoiceEmails returns the emails sent for the invoices of a client, newest first
+func (s *DBService) GetClientInvoiceEmails(clientID int) ([]models.InvoiceEmail, error) {
+	return s.queryInvoiceEmails(`WHERE invoice_id IN (SELECT id FROM invoices WHERE client_id = ?) ORDER BY sent_at DESC, id DESC`, clientID)
+}
+
+// GetDeliveredInvoiceEmails returns the emails sent since a time that have not bounced
+func (s *DBService) GetDeliveredInvoiceEmails(since time.Time) ([]models.InvoiceEmail, error) {
+	return s.queryInvoiceEmails(`WHERE status = ? AND sent_at >= ? ORDER BY id`, models.EmailStatusSent, since.UTC())
+}
 
+// queryInvoiceEmails returns the sent emails matching a WHERE clause
+func (s *DBService) queryInvoiceEmails(where string, args ...interface{}) ([]models.InvoiceEmail, error) {
+	rows, err := s.db.Query(`
+		SELECT id, invoice_id, kind, recipient, message_id, status, bounce_reason, sent_at, bounced_at
+		FROM invoice_emails `+where, args...)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			s.logger.Warn("No invoice found with ID: %d", id)
-		} else {
-			s.logger.Error("Database error when fetching invoice ID %d: %v", id, err)
+		return nil, fmt.Errorf("failed to query sent emails: %w", err)
+	}
+	defer rows.Close()
+
+	var emails []models.InvoiceEmail
+	for rows.Next() {
+		var email models.InvoiceEmail
+		if err := rows.Scan(&email.ID, &email.InvoiceID, &email.Kind, &email.Recipient, &email.MessageID,
+			&email.Status, &email.BounceReason, &email.SentAt, &email.BouncedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan sent email: %w", err)
 		}
-		return nil, nil, err
+		emails = append(emails, email)
 	}
+	return emails, rows.Err()
+}
 
-	// Parse dates
-	invoice.IssueDate, err = time.Parse("2006-01-02", issueDate)
+// ScheduleInvoiceEmail queues a send_invoice_email job with the payload to
+// run at email.SendAt and records it as the scheduled email of the invoice,
+// replacing the email scheduled before
+func (s *DBService) ScheduleInvoiceEmail(email *models.ScheduledEmail, payload interface{}) error {
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		s.logger.Error("Failed to parse issue date: %v", err)
-		return nil, nil, fmt.Errorf("failed to parse issue date: %w", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := cancelScheduledEmails(ctx, tx, `invoice_id = ?`, email.InvoiceID); err != nil {
+		return err
+	}
+	job, err := insertJobAt(ctx, tx, JobTypeSendInvoiceEmail, payload, email.SendAt)
+	if err != nil {
+		return err
+	}
+
+	email.SendAt = job.RunAt
+	email.JobID, email.JobStatus, email.LastError = job.ID, job.Status, ""
+	email.CreatedAt = time.Now().UTC()
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO invoice_scheduled_emails (invoice_id, kind, recipient, job_id, send_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, email.InvoiceID, email.Kind, email.Recipient, email.JobID, email.SendAt, email.CreatedAt).Scan(&email.ID)
+	if err != nil {
+		return fmt.Errorf("failed to schedule email: %w", err)
+	}
+	return tx.Commit()
+}
+
+// GetScheduledInvoiceEmail returns the email scheduled for an invoice with
+// the status of its job, or sql.ErrNoRows when none is
+func (s *DBService) GetScheduledInvoiceEmail(invoiceID int) (*models.ScheduledEmail, error) {
+	var email models.ScheduledEmail
+	var jobStatus, lastError sql.NullString
+	err := s.db.QueryRow(`
+		SELECT e.id, e.invoice_id, e.kind, e.recipient, e.job_id, e.send_at, e.created_at, j.status, j.last_error
+		FROM invoice_scheduled_emails e
+		LEFT JOIN jobs j ON j.id = e.job_id
+		WHERE e.invoice_id = ?
+	`, invoiceID).Scan(&email.ID, &email.InvoiceID, &email.Kind, &email.Recipient, &email.JobID,
+		&email.SendAt, &email.CreatedAt, &jobStatus, &lastError)
+	if err != nil {
+		return nil, err
+	}
+	// A job removed from the queue cannot send the email anymore
+	email.JobStatus, email.LastError = JobStatusFailed, "The job sending the email was deleted"
+	if jobStatus.Valid {
+		email.JobStatus, email.LastError = jobStatus.String, lastError.String
+	}
+	return &email, nil
+}
+
+// CancelScheduledInvoiceEmail removes the email scheduled for an invoice
+// with its job. It returns sql.ErrNoRows when no email is scheduled and
+// ErrScheduledEmailSending when it is being sent.
+func (s *DBService) CancelScheduledInvoiceEmail(invoiceID int) error {
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM invoice_scheduled_emails WHERE invoice_id = ?`, invoiceID).Scan(&id); err != nil {
+		return err
+	}
+	if err := cancelScheduledEmails(ctx, tx, `id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ScheduledInvoiceEmailSent removes the email scheduled for an invoice once
+// its job has sent it
+func (s *DBService) ScheduledInvoiceEmailSent(invoiceID int) error {
+	if _, err := s.db.Exec(`DELETE FROM invoice_scheduled_emails WHERE invoice_id = ?`, invoiceID); err != nil {
+		return fmt.Errorf("failed to remove scheduled email: %w", err)
 	}
+	return nil
+}
 
-	invoice.DueDate, err = time.Parse("2006-01-02", dueDate)
-	if err != nil {
-		s.logger.Error("Failed to parse due date: %v", err)
-		return nil, nil, fmt.Errorf("failed to parse due date: %w", err)
+// cancelScheduledEmails removes the scheduled emails matching a WHERE
+// condition and the jobs that would send them, in the transaction tx
+func cancelScheduledEmails(ctx context.Context, tx *sql.Tx, where string, args ...interface{}) error {
+	var sending int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM jobs
+		WHERE status = ? AND id IN (SELECT job_id FROM invoice_scheduled_emails WHERE `+where+`)
+	`, append([]interface{}{JobStatusRunning}, args...)...).Scan(&sending); err != nil {
+		return fmt.Errorf("failed to check scheduled emails: %w", err)
+	}
+	if sending > 0 {
+		return ErrScheduledEmailSending
 	}
 
-	// Convert reverseChargeVat to bool
-	invoice.ReverseChargeVat = intToBool(reverseChargeVat)
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM jobs WHERE id IN (SELECT job_id FROM invoice_scheduled_emails WHERE `+where+`)
+	`, args...); err != nil {
+		return fmt.Errorf("failed to cancel scheduled emails: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM invoice_scheduled_emails WHERE `+where, args...); err != nil {
+		return fmt.Errorf("failed to cancel scheduled emails: %w", err)
+	}
+	return nil
+}
 
-	// Handle currency
-	if currency.Valid {
-		invoice.Currency = currency.String
-	} else {
-		invoice.Currency = "EUR" // Default to EUR if NULL
+// MarkInvoiceEmailBounced records that a sent email bounced
+func (s *DBService) MarkInvoiceEmailBounced(id int, reason string) error {
+	_, err := s.db.Exec(`
+		UPDATE invoice_emails SET status = ?, bounce_reason = ?, bounced_at = ? WHERE id = ?
+	`, models.EmailStatusBounced, reason, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to mark email as bounced: %w", err)
 	}
+	return nil
+}
 
-	// Get invoice items
-	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, invoice_id, description, quantity, unit_price, amount
-		FROM invoice_items
-		WHERE invoice_id = ?
-	`, id)
+// GetInvoiceDeliveryStatuses returns the status of the most recent email sent
+// for each invoice that has been emailed, by invoice ID
+func (s *DBService) GetInvoiceDeliveryStatuses() (map[int]string, error) {
+	rows, err := s.db.Query(`
+		SELECT invoice_id, status FROM invoice_emails
+		WHERE id IN (SELECT MAX(id) FROM invoice_emails GROUP BY invoice_id)
+	`)
 	if err != nil {
-		s.logger.Error("Failed to fetch invoice items: %v", err)
-		return nil, nil, fmt.Errorf("failed to fetch invoice items: %w", err)
+		return nil, fmt.Errorf("failed to query delivery statuses: %w", err)
 	}
 	defer rows.Close()
 
-	var items []models.InvoiceItem
+	statuses := make(map[int]string)
 	for rows.Next() {
-		var item models.InvoiceItem
-		if err := rows.Scan(
-			&item.ID,
-			&item.InvoiceID,
-			&item.Description,
-			&item.Quantity,
-			&item.UnitPrice,
-			&item.Amount,
-		); err != nil {
-			s.logger.Error("Failed to scan invoice item: %v", err)
-			return nil, nil, fmt.Errorf("failed to scan invoice item: %w", err)
+		var invoiceID int
+		var status string
+		if err := rows.Scan(&invoiceID, &status); err != nil {
+			return nil, fmt.Errorf("failed to scan delivery status: %w", err)
 		}
-		items = append(items, item)
+		statuses[invoiceID] = status
 	}
+	return statuses, rows.Err()
+}
 
-	if err := rows.Err(); err != nil {
-		s.logger.Error("Error iterating invoice items: %v", err)
-		return nil, nil, fmt.Errorf("error iterating invoice items: %w", err)
+// MarkNotified records that a notification about an event of an entity was
+// sent, and reports whether it is the first one
+func (s *DBService) MarkNotified(event string, entityID int) (bool, error) {
+	result, err := s.db.Exec(`
+		INSERT INTO notifications_sent (event, entity_id, sent_at) VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, event, entityID, time.Now().UTC())
+	if err != nil {
+		return false, fmt.Errorf("failed to record notification: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
 	}
+	return n == 1, nil
+}
 
-	s.logger.Info("Successfully fetched invoice #%s with %d items", invoice.InvoiceNumber, len(items))
-	return &invoice, items, nil
+// AddClientCredit records a prepayment or retainer paid by a client
+func (s *DBService) AddClientCredit(credit *models.ClientCredit) error {
+	credit.CreatedAt = time.Now().UTC()
+	err := s.db.QueryRow(`
+		INSERT INTO client_credits (client_id, invoice_id, amount, currency, description, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, credit.ClientID, credit.InvoiceID, credit.Amount, credit.Currency, credit.Description, credit.CreatedAt).Scan(&credit.ID)
+	if err != nil {
+		return fmt.Errorf("failed to record client credit: %w", err)
+	}
+	return nil
 }
 
-// GetInvoices retrieves all invoices from the database
-func (s *DBService) GetInvoices() ([]models.Invoice, error) {
+// GetClientCredits returns the credit ledger of a client, newest first
+func (s *DBService) GetClientCredits(clientID int) ([]models.ClientCredit, error) {
 	rows, err := s.db.Query(`
-		SELECT id, invoice_number, business_id, client_id, issue_date, due_date, hourly_rate, hours_worked, total_amount, vat_rate, vat_amount, reverse_charge_vat, currency, notes, status
-		FROM invoices
-	`)
+		SELECT id, client_id, invoice_id, amount, currency, description, created_at
+		FROM client_credits
+		WHERE client_id = ?
+		ORDER BY id DESC
+	`, clientID)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to query client credits: %w", err)
 	}
 	defer rows.Close()
 
-	var invoices []models.Invoice
+	var credits []models.ClientCredit
 	for rows.Next() {
-		var invoice models.Invoice
-		var issueDate, dueDate string
-		var reverseChargeVat int
-		var currency sql.NullString // Use sql.NullString to handle NULL values
-		err := rows.Scan(
-			&invoice.ID, &invoice.InvoiceNumber, &invoice.BusinessID, &invoice.ClientID, &issueDate, &dueDate,
-			&invoice.HourlyRate, &invoice.HoursWorked, &invoice.TotalAmount, &invoice.VatRate, &invoice.VatAmount,
-			&reverseChargeVat, &currency, &invoice.Notes, &invoice.Status,
-		)
-		if err != nil {
-			return nil, err
+		var credit models.ClientCredit
+		if err := rows.Scan(&credit.ID, &credit.ClientID, &credit.InvoiceID, &credit.Amount, &credit.Currency, &credit.Description, &credit.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan client credit: %w", err)
 		}
+		credits = append(credits, credit)
+	}
+	return credits, rows.Err()
+}
 
-		// Parse dates
-		invoice.IssueDate, _ = time.Parse("2006-01-02", issueDate)
-		invoice.DueDate, _ = time.Parse("2006-01-02", dueDate)
-		invoice.ReverseChargeVat = intToBool(reverseChargeVat)
+// GetCreditBalances returns the remaining credit of every client that has
+// some, by client ID and then by currency
+func (s *DBService) GetCreditBalances() (map[int][]models.CreditBalance, error) {
+	rows, err := s.db.Query(`
+		SELECT client_id, currency, SUM(amount)
+		FROM client_credits
+		GROUP BY client_id, currency
+		HAVING SUM(amount) != 0
+		ORDER BY client_id, currency
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query credit balances: %w", err)
+	}
+	defer rows.Close()
 
-		// Set currency, default to EUR if NULL
-		if currency.Valid {
-			invoice.Currency = currency.String
-		} else {
-			invoice.Currency = "EUR"
+	balances := make(map[int][]models.CreditBalance)
+	for rows.Next() {
+		var clientID int
+		var balance models.CreditBalance
+		if err := rows.Scan(&clientID, &balance.Currency, &balance.Amount); err != nil {
+			return nil, fmt.Errorf("failed to scan credit balance: %w", err)
 		}
-
-		invoices = append(invoices, invoice)
+		balances[clientID] = append(balances[clientID], balance)
 	}
-
-	return invoices, nil
+	return balances, rows.Err()
 }
 
-// UpdateInvoiceStatus updates the status of an invoice
-func (s *DBService) UpdateInvoiceStatus(id int, status string) error {
-	_, err := s.db.Exec("UPDATE invoices SET status = ? WHERE id = ?", status, id)
-	return err
-}
+// ApplyCredit deducts client credit from the amount due of an invoice. An
+// amount of 0 applies as much credit as the client has in the invoice
+// currency, up to the amount due. It returns the updated invoice.
+func (s *DBService) ApplyCredit(invoiceID int, amount models.Money) (*models.Invoice, error) {
+	invoice, _, err := s.GetInvoice(invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	if invoice.IsProforma() {
+		return nil, ErrCreditNotApplicable
+	}
+	if amount < 0 {
+		return nil, errors.New("the credit to apply must not be negative")
+	}
+	if err := checkYearOpen(context.Background(), s.db, invoice.IssueDate); err != nil {
+		return nil, err
+	}
 
-// DeleteInvoice deletes an invoice and its items from the database
-func (s *DBService) DeleteInvoice(id int) error {
-	// Start a transaction
 	tx, err := s.db.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer tx.Rollback()
 
-	// Delete invoice items first (due to foreign key constraint)
-	_, err = tx.Exec("DELETE FROM invoice_items WHERE invoice_id = ?", id)
+	// The credit applied so far and the balance are read in the transaction
+	// that changes them, so credit cannot be applied twice
+	if err := tx.QueryRow(`SELECT credit_applied FROM invoices WHERE id = ?`, invoice.ID).Scan(&invoice.CreditApplied); err != nil {
+		return nil, fmt.Errorf("failed to read applied credit: %w", err)
+	}
+	var balance models.Money
+	err = tx.QueryRow(`
+		SELECT COALESCE(SUM(amount), 0) FROM client_credits WHERE client_id = ? AND currency = ?
+	`, invoice.ClientID, invoice.Currency).Scan(&balance)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to read credit balance: %w", err)
 	}
 
-	// Delete the invoice
-	result, err := tx.Exec("DELETE FROM invoices WHERE id = ?", id)
-	if err != nil {
-		return err
+	available := min(balance, invoice.AmountDue())
+	if amount == 0 {
+		amount = available
+	}
+	if amount == 0 || amount > available {
+		return nil, fmt.Errorf("%w: %s %s available for an amount due of %s", ErrInsufficientCredit,
+			max(balance, 0), invoice.Currency, invoice.AmountDue())
 	}
 
-	// Check if the invoice was found and deleted
-	rowsAffected, err := result.RowsAffected()
+	_, err = tx.Exec(`
+		INSERT INTO client_credits (client_id, invoice_id, amount, currency, description, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, invoice.ClientID, invoice.ID, -amount, invoice.Currency, "Applied to invoice "+invoice.InvoiceNumber, time.Now().UTC())
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to record applied credit: %w", err)
 	}
-	if rowsAffected == 0 {
-		return fmt.Errorf("invoice with ID %d not found", id)
+	_, err = tx.Exec(`UPDATE invoices SET credit_applied = credit_applied + ? WHERE id = ?`, amount, invoice.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update invoice: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
 
-	// Commit the transaction
-	return tx.Commit()
+	invoice.CreditApplied += amount
+	s.logger.Info("Applied %s %s credit to invoice %s", amount, invoice.Currency, invoice.InvoiceNumber)
+	return invoice, nil
 }
 
 // EnsureInvoiceItemsTable checks if the invoice_items table exists and creates it if it doesn't
@@ -1148,12 +3734,7 @@ func (s *DBService) EnsureInvoiceItemsTable() error {
 	s.logger.Debug("Checking if invoice_items table exists")
 
 	// Check if the invoice_items table exists
-	var tableExists bool
-	err := s.db.QueryRow(`
-		SELECT COUNT(*) > 0 FROM sqlite_master 
-		WHERE type='table' AND name='invoice_items'
-	`).Scan(&tableExists)
-
+	tableExists, err := s.dialect.tableExists(s.db, "invoice_items")
 	if err != nil {
 		s.logger.Error("Failed to check if invoice_items table exists: %v", err)
 		return fmt.Errorf("failed to check if invoice_items table exists: %w", err)
@@ -1168,8 +3749,8 @@ func (s *DBService) EnsureInvoiceItemsTable() error {
 				invoice_id INTEGER NOT NULL,
 				description TEXT NOT NULL,
 				quantity REAL NOT NULL,
-				unit_price REAL NOT NULL,
-				amount REAL NOT NULL,
+				unit_price INTEGER NOT NULL,
+				amount INTEGER NOT NULL,
 				FOREIGN KEY (invoice_id) REFERENCES invoices (id) ON DELETE CASCADE
 			)
 		`)
@@ -1187,6 +3768,258 @@ func (s *DBService) EnsureInvoiceItemsTable() error {
 	return nil
 }
 
+// rowQueryer is satisfied by both *sql.DB and *sql.Tx
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// checkYearOpen returns ErrYearClosed if the date falls in a closed fiscal
+// year, using db, which may be a transaction. A zero date is never closed.
+func checkYearOpen(ctx context.Context, db rowQueryer, date time.Time) error {
+	if date.IsZero() {
+		return nil
+	}
+	var closed bool
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) > 0 FROM closed_years WHERE year = ?`, date.Year()).Scan(&closed)
+	if err != nil {
+		return fmt.Errorf("failed to check closed years: %w", err)
+	}
+	if closed {
+		return fmt.Errorf("%w: %d", ErrYearClosed, date.Year())
+	}
+	return nil
+}
+
+// Saved filter methods
+
+// ErrSavedFilterExists is returned when a user saves a second filter with the same name
+var ErrSavedFilterExists = errors.New("a filter with this name already exists")
+
+// GetSavedFilters returns the saved filters of a user, by name
+func (s *DBService) GetSavedFilters(userID int) ([]models.SavedFilter, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, name, query, created_at FROM saved_filters WHERE user_id = ? ORDER BY LOWER(name)
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	filters := []models.SavedFilter{}
+	for rows.Next() {
+		var filter models.SavedFilter
+		if err := rows.Scan(&filter.ID, &filter.UserID, &filter.Name, &filter.Query, &filter.CreatedAt); err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, rows.Err()
+}
+
+// AddSavedFilter saves a filter preset of a user
+func (s *DBService) AddSavedFilter(filter *models.SavedFilter) error {
+	var exists bool
+	if err := s.db.QueryRow(`SELECT COUNT(*) > 0 FROM saved_filters WHERE user_id = ? AND LOWER(name) = LOWER(?)`,
+		filter.UserID, filter.Name).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrSavedFilterExists
+	}
+
+	filter.CreatedAt = time.Now().UTC()
+	return s.db.QueryRow(`
+		INSERT INTO saved_filters (user_id, name, query, created_at) VALUES (?, ?, ?, ?) RETURNING id
+	`, filter.UserID, filter.Name, filter.Query, filter.CreatedAt).Scan(&filter.ID)
+}
+
+// DeleteSavedFilter deletes a saved filter of a user, returning sql.ErrNoRows
+// if the user has no filter with that ID
+func (s *DBService) DeleteSavedFilter(userID, id int) error {
+	result, err := s.db.Exec(`DELETE FROM saved_filters WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Note methods
+
+// AddNote logs a note against an invoice or client
+func (s *DBService) AddNote(note *models.Note) error {
+	note.CreatedAt = time.Now().UTC()
+	err := s.db.QueryRow(`
+		INSERT INTO notes (entity_type, entity_id, body, author, created_at) VALUES (?, ?, ?, ?, ?) RETURNING id
+	`, note.EntityType, note.EntityID, note.Body, note.Author, note.CreatedAt).Scan(&note.ID)
+	if err != nil {
+		return fmt.Errorf("failed to add note: %w", err)
+	}
+	return nil
+}
+
+// GetNotes returns the notes logged against an invoice or client, newest first
+func (s *DBService) GetNotes(entityType string, entityID int) ([]models.Note, error) {
+	return s.queryNotes(`WHERE entity_type = ? AND entity_id = ?`, entityType, entityID)
+}
+
+// GetClientNotes returns the notes logged against a client and its invoices, newest first
+func (s *DBService) GetClientNotes(clientID int) ([]models.Note, error) {
+	return s.queryNotes(`
+		WHERE (entity_type = ? AND entity_id = ?)
+		OR (entity_type = ? AND entity_id IN (SELECT id FROM invoices WHERE client_id = ?))
+	`, models.NoteEntityClient, clientID, models.NoteEntityInvoice, clientID)
+}
+
+// queryNotes returns the notes matching a WHERE clause, newest first
+func (s *DBService) queryNotes(where string, args ...interface{}) ([]models.Note, error) {
+	rows, err := s.db.Query(`
+		SELECT id, entity_type, entity_id, body, author, created_at FROM notes `+where+` ORDER BY created_at DESC, id DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notes: %w", err)
+	}
+	defer rows.Close()
+
+	notes := []models.Note{}
+	for rows.Next() {
+		var note models.Note
+		if err := rows.Scan(&note.ID, &note.EntityType, &note.EntityID, &note.Body, &note.Author, &note.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan note: %w", err)
+		}
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+// DeleteNote deletes a note, returning sql.ErrNoRows if there is no note with that ID
+func (s *DBService) DeleteNote(id int) error {
+	result, err := s.db.Exec(`DELETE FROM notes WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete note: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Audit log methods
+
+// Audit log actions
+const (
+	AuditActionErase     = "erase"
+	AuditActionProvision = "provision"
+	AuditActionCloseYear = "close_year"
+	AuditActionDelete    = "delete"
+	AuditActionRenumber  = "renumber"
+	// API tokens
+	AuditActionCreateToken = "create_token"
+	AuditActionRevokeToken = "revoke_token"
+	// Sign-ins
+	AuditActionLogin         = "login"
+	AuditActionRevokeSession = "revoke_session"
+)
+
+// RedactedPlaceholder replaces personal data that has been erased
+const RedactedPlaceholder = "[redacted]"
+
+// auditExecer is satisfied by both *sql.DB and *sql.Tx
+type auditExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// logAudit writes an audit log entry using db, which may be a transaction
+func logAudit(ctx context.Context, db auditExecer, action, entityType string, entityID int, details string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO audit_log (action, entity_type, entity_id, details, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, action, entityType, entityID, details, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// LogAudit records an action in the audit log
+func (s *DBService) LogAudit(action, entityType string, entityID int, details string) error {
+	return logAudit(context.Background(), s.db, action, entityType, entityID, details)
+}
+
+// GetInvoiceNumberAuditLog returns the audit log entries about deleted or
+// renumbered invoices whose number starts with prefix, e.g. INV-2024-, by
+// number and oldest first
+func (s *DBService) GetInvoiceNumberAuditLog(prefix string) (map[string][]models.AuditEntry, error) {
+	rows, err := s.db.Query(`
+		SELECT id, action, entity_type, entity_id, details, created_at FROM audit_log
+		WHERE entity_type = 'invoice' AND action IN (?, ?) AND SUBSTR(details, 1, ?) = ?
+		ORDER BY id
+	`, AuditActionDelete, AuditActionRenumber, utf8.RuneCountInString(prefix), prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := map[string][]models.AuditEntry{}
+	for rows.Next() {
+		var entry models.AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.Action, &entry.EntityType, &entry.EntityID, &entry.Details, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		// The details start with the number, e.g. INV-2024-0003 deleted (...)
+		number, _, _ := strings.Cut(entry.Details, " ")
+		entries[number] = append(entries[number], entry)
+	}
+	return entries, rows.Err()
+}
+
+// GetAuditLog returns the most recent audit log entries, optionally limited to one
+// entity type and, if entityID is non-zero, to a single entity
+func (s *DBService) GetAuditLog(entityType string, entityID int, limit int) ([]models.AuditEntry, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	query := `SELECT id, action, entity_type, entity_id, details, created_at FROM audit_log WHERE 1 = 1`
+	args := []interface{}{}
+	if entityType != "" {
+		query += ` AND entity_type = ?`
+		args = append(args, entityType)
+	}
+	if entityID != 0 {
+		query += ` AND entity_id = ?`
+		args = append(args, entityID)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var entry models.AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.Action, &entry.EntityType, &entry.EntityID, &entry.Details, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
 // Helper functions
 
 // boolToInt converts a boolean to an integer (1 for true, 0 for false)
@@ -1202,6 +4035,23 @@ func intToBool(i int) bool {
 	return i != 0
 }
 
+// formatOptionalDate formats a date for storage, using an empty string for the zero time
+func formatOptionalDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// parseOptionalDate parses a stored date, returning the zero time for empty or invalid values
+func parseOptionalDate(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
 // RemoveDatabase completely removes the database file and its associated files
 func RemoveDatabase(dataDir string, logger *Logger) error {
 	logger.Warn("Removing database file and associated files")
@@ -1232,6 +4082,13 @@ func RemoveDatabase(dataDir string, logger *Logger) error {
 	return nil
 }
 
+// DuplicateInvoiceNumbers returns the number of invoice numbers found more
+// than once when the database was opened. While it is not 0, the database
+// does not enforce unique invoice numbers.
+func (s *DBService) DuplicateInvoiceNumbers() int {
+	return s.duplicateNumbers
+}
+
 // GetDB returns the database connection
 func (s *DBService) GetDB() *sql.DB {
 	return s.db
@@ -1239,6 +4096,9 @@ func (s *DBService) GetDB() *sql.DB {
 
 // ReopenConnection reopens the database connection
 func (s *DBService) ReopenConnection() error {
+	if _, ok := s.dialect.(sqliteDialect); !ok {
+		return fmt.Errorf("reopening the connection is not supported with %s", s.dialect.Name())
+	}
 	s.logger.Info("Reopening database connection...")
 
 	// Close the existing connection if it's still open
@@ -1255,11 +4115,7 @@ func (s *DBService) ReopenConnection() error {
 
 	// Use a connection with strict timeout and no journal
 	s.logger.Debug("Opening database connection with timeout")
-	db, err := sql.Open("sqlite3", dbPath+"?_timeout=5000&_journal=DELETE")
-	if err != nil {
-		s.logger.Error("Failed to open database: %v", err)
-		return fmt.Errorf("failed to open database: %w", err)
-	}
+	db := openSQLite(dbPath+"?_timeout=5000&_journal=DELETE", s.logger)
 
 	// Set connection parameters
 	db.SetMaxOpenConns(1) // Restrict to a single connection to avoid locks
@@ -1,8 +1,17 @@
 package services
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"os"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
 )
 
 func setupTestDB(t *testing.T) (*DBService, string, func()) {
@@ -31,15 +40,1066 @@ func setupTestDB(t *testing.T) (*DBService, string, func()) {
 	return dbService, tempDir, cleanup
 }
 
-// Skip the DB service tests for now as they require more setup
-func TestSaveAndGetBusinessSkip(t *testing.T) {
-	t.Skip("Skipping TestSaveAndGetBusiness as it requires more setup")
+func TestSaveAndGetBusiness(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var repo BusinessRepo = dbService
+	business := &models.Business{Name: "Example Consulting", Country: "DE", VatID: "DE123456789", IBAN: "DE89370400440532013000", Currency: "EUR",
+		WorkHoursPerDay: 7.5, WorkDays: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday}, HolidayCountry: "DE",
+		CryptoAddress: "0x71C7656EC7ab88b098defB751B7401B5f6d8976F", CryptoNetwork: "Ethereum"}
+	if err := repo.SaveBusiness(business); err != nil {
+		t.Fatalf("SaveBusiness failed: %v", err)
+	}
+	if business.ID == 0 {
+		t.Fatal("Expected SaveBusiness to set the ID")
+	}
+
+	stored, err := repo.GetBusiness(business.ID)
+	if err != nil {
+		t.Fatalf("GetBusiness failed: %v", err)
+	}
+	if stored.Name != business.Name || stored.VatID != business.VatID || stored.IBAN != business.IBAN ||
+		stored.CryptoAddress != business.CryptoAddress || stored.CryptoNetwork != business.CryptoNetwork {
+		t.Errorf("Unexpected business: %+v", stored)
+	}
+	if stored.WorkHoursPerDay != 7.5 || len(stored.WorkDays) != 4 || stored.WorkDays[3] != time.Thursday || stored.HolidayCountry != "DE" {
+		t.Errorf("Unexpected working time: %v hours on %v, holidays of %q", stored.WorkHoursPerDay, stored.WorkDays, stored.HolidayCountry)
+	}
+
+	businesses, err := repo.GetBusinesses()
+	if err != nil {
+		t.Fatalf("GetBusinesses failed: %v", err)
+	}
+	if len(businesses) != 1 || businesses[0].ID != business.ID || businesses[0].CryptoNetwork != "Ethereum" {
+		t.Errorf("Unexpected businesses: %+v", businesses)
+	}
+
+	if _, err := repo.GetBusiness(business.ID + 1); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a missing business, got %v", err)
+	}
 }
 
-func TestSaveAndGetClientSkip(t *testing.T) {
-	t.Skip("Skipping TestSaveAndGetClient as it requires more setup")
+func TestSaveAndGetClient(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var repo ClientRepo = dbService
+	client := &models.Client{Name: "Acme GmbH", Country: "DE", Email: "billing@acme.example", Language: "de", RiskNotes: "Pays after reminders", SDICode: "M5UXCR1", PEC: "acme@pec.example"}
+	if err := repo.SaveClient(client); err != nil {
+		t.Fatalf("SaveClient failed: %v", err)
+	}
+	if client.ID == 0 {
+		t.Fatal("Expected SaveClient to set the ID")
+	}
+
+	stored, err := repo.GetClient(client.ID)
+	if err != nil {
+		t.Fatalf("GetClient failed: %v", err)
+	}
+	if stored.Name != client.Name || stored.Email != client.Email || stored.Language != "de" || stored.RiskNotes != client.RiskNotes || stored.SDICode != "M5UXCR1" || stored.PEC != client.PEC || stored.Deleted {
+		t.Errorf("Unexpected client: %+v", stored)
+	}
+
+	stored.City = "Berlin"
+	if err := repo.SaveClient(stored); err != nil {
+		t.Fatalf("Failed to update client: %v", err)
+	}
+	clients, err := repo.GetClients()
+	if err != nil {
+		t.Fatalf("GetClients failed: %v", err)
+	}
+	if len(clients) != 1 || clients[0].City != "Berlin" {
+		t.Errorf("Unexpected clients: %+v", clients)
+	}
+
+	if _, err := repo.GetClient(client.ID + 1); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a missing client, got %v", err)
+	}
 }
 
-func TestSaveAndGetInvoiceSkip(t *testing.T) {
-	t.Skip("Skipping TestSaveAndGetInvoice as it requires more setup")
+func TestSaveAndGetInvoice(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var repo InvoiceRepo = dbService
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{
+		BusinessID:  1,
+		ClientID:    1,
+		IssueDate:   issueDate,
+		DueDate:     issueDate.AddDate(0, 0, 30),
+		VatRate:     19,
+		VatAmount:   3800,
+		TotalAmount: 23800,
+		Currency:    "EUR",
+		Status:      "draft",
+	}
+	items := []models.InvoiceItem{
+		{Description: "Consulting", Quantity: 2, UnitPrice: 5000, Amount: 10000},
+		{Description: "Travel", Quantity: 1, UnitPrice: 10000, Amount: 10000},
+	}
+	if err := repo.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("SaveInvoice failed: %v", err)
+	}
+	if invoice.ID == 0 || invoice.InvoiceNumber == "" {
+		t.Fatalf("Expected SaveInvoice to set the ID and number, got %+v", invoice)
+	}
+
+	stored, storedItems, err := repo.GetInvoice(invoice.ID)
+	if err != nil {
+		t.Fatalf("GetInvoice failed: %v", err)
+	}
+	if stored.InvoiceNumber != invoice.InvoiceNumber || stored.TotalAmount != 23800 || !stored.IssueDate.Equal(issueDate) {
+		t.Errorf("Unexpected invoice: %+v", stored)
+	}
+	if len(storedItems) != 2 || storedItems[0].Description != "Consulting" || storedItems[1].Amount != 10000 {
+		t.Errorf("Unexpected items: %+v", storedItems)
+	}
+
+	paidDate := time.Date(2024, 3, 20, 0, 0, 0, 0, time.UTC)
+	if err := repo.UpdateInvoiceStatus(invoice.ID, "paid", paidDate); err != nil {
+		t.Fatalf("UpdateInvoiceStatus failed: %v", err)
+	}
+	invoices, err := repo.GetInvoices()
+	if err != nil {
+		t.Fatalf("GetInvoices failed: %v", err)
+	}
+	if len(invoices) != 1 || invoices[0].Status != "paid" || !invoices[0].PaidDate.Equal(paidDate) {
+		t.Errorf("Unexpected invoices: %+v", invoices)
+	}
+
+	if err := repo.DeleteInvoice(invoice.ID); err != nil {
+		t.Fatalf("DeleteInvoice failed: %v", err)
+	}
+	if _, _, err := repo.GetInvoice(invoice.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a deleted invoice, got %v", err)
+	}
+}
+
+func TestSaveInvoiceGeneratesSequentialNumbers(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	newInvoice := func(number string) *models.Invoice {
+		return &models.Invoice{
+			InvoiceNumber: number,
+			BusinessID:    1,
+			ClientID:      1,
+			IssueDate:     issueDate,
+			DueDate:       issueDate.AddDate(0, 0, 30),
+			TotalAmount:   10000,
+			Currency:      "EUR",
+			Status:        "draft",
+		}
+	}
+	items := []models.InvoiceItem{{Description: "Work", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+
+	// A manually numbered invoice seeds the sequence for its year
+	manual := newInvoice("INV-2024-0005")
+	if err := dbService.SaveInvoice(manual, items); err != nil {
+		t.Fatalf("Failed to save manual invoice: %v", err)
+	}
+
+	first := newInvoice("")
+	if err := dbService.SaveInvoice(first, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+	if first.InvoiceNumber != "INV-2024-0006" {
+		t.Errorf("Expected INV-2024-0006, got %s", first.InvoiceNumber)
+	}
+
+	second := newInvoice("")
+	if err := dbService.SaveInvoice(second, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+	if second.InvoiceNumber != "INV-2024-0007" {
+		t.Errorf("Expected INV-2024-0007, got %s", second.InvoiceNumber)
+	}
+
+	// Reusing a number is rejected
+	duplicate := newInvoice("INV-2024-0006")
+	err := dbService.SaveInvoice(duplicate, items)
+	if !errors.Is(err, ErrDuplicateInvoiceNumber) {
+		t.Errorf("Expected ErrDuplicateInvoiceNumber, got %v", err)
+	}
+}
+
+func TestDuplicateInvoiceNumbersAreReported(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if n := dbService.DuplicateInvoiceNumbers(); n != 0 {
+		t.Fatalf("Expected no duplicate invoice numbers, got %d", n)
+	}
+
+	// Data from before the unique index can contain duplicates
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		invoice := &models.Invoice{BusinessID: 1, ClientID: 1, IssueDate: issueDate, DueDate: issueDate, Currency: "EUR", Status: "draft"}
+		if err := dbService.SaveInvoice(invoice, nil); err != nil {
+			t.Fatalf("Failed to save invoice: %v", err)
+		}
+	}
+	db := dbService.GetDB()
+	if _, err := db.Exec(`DROP INDEX idx_invoices_invoice_number`); err != nil {
+		t.Fatalf("Failed to drop index: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE invoices SET invoice_number = 'INV-2024-0001'`); err != nil {
+		t.Fatalf("Failed to renumber invoices: %v", err)
+	}
+
+	if err := dbService.ReopenConnection(); err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	if n := dbService.DuplicateInvoiceNumbers(); n != 1 {
+		t.Errorf("Expected 1 duplicate invoice number, got %d", n)
+	}
+}
+
+func TestConvertProforma(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	items := []models.InvoiceItem{{Description: "Work", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+	newInvoice := func(invoiceType string) *models.Invoice {
+		return &models.Invoice{
+			BusinessID:  1,
+			ClientID:    1,
+			IssueDate:   issueDate,
+			DueDate:     issueDate.AddDate(0, 0, 14),
+			TotalAmount: 10000,
+			Currency:    "EUR",
+			Status:      "draft",
+			Type:        invoiceType,
+		}
+	}
+
+	invoice := newInvoice("")
+	if err := dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+	proforma := newInvoice(models.InvoiceTypeProforma)
+	if err := dbService.SaveInvoice(proforma, items); err != nil {
+		t.Fatalf("Failed to save pro-forma invoice: %v", err)
+	}
+	if invoice.InvoiceNumber != "INV-2024-0001" || invoice.Type != models.InvoiceTypeInvoice {
+		t.Errorf("Expected invoice INV-2024-0001, got %s %s", invoice.Type, invoice.InvoiceNumber)
+	}
+	if proforma.InvoiceNumber != "PRO-2024-0001" {
+		t.Errorf("Expected PRO-2024-0001, got %s", proforma.InvoiceNumber)
+	}
+
+	// Editing a pro-forma keeps its type
+	proforma.Type = models.InvoiceTypeInvoice
+	if err := dbService.SaveInvoice(proforma, items); err != nil {
+		t.Fatalf("Failed to update pro-forma invoice: %v", err)
+	}
+	if !proforma.IsProforma() {
+		t.Errorf("Expected the document type to be kept, got %s", proforma.Type)
+	}
+
+	if _, err := dbService.ConvertProforma(invoice.ID, issueDate, nil); !errors.Is(err, ErrNotProforma) {
+		t.Errorf("Expected ErrNotProforma, got %v", err)
+	}
+
+	convertedOn := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	converted, err := dbService.ConvertProforma(proforma.ID, convertedOn, func(invoice *models.Invoice) {
+		invoice.HomeCurrency = "CHF"
+		invoice.ExchangeRate = 0.95
+		invoice.ExchangeRateDate = invoice.IssueDate
+	})
+	if err != nil {
+		t.Fatalf("ConvertProforma failed: %v", err)
+	}
+	if converted.InvoiceNumber != "INV-2024-0002" || converted.IsProforma() || converted.Status != "draft" {
+		t.Errorf("Expected draft invoice INV-2024-0002, got %s %s %s", converted.Status, converted.Type, converted.InvoiceNumber)
+	}
+	if !converted.IssueDate.Equal(convertedOn) || !converted.DueDate.Equal(convertedOn.AddDate(0, 0, 14)) {
+		t.Errorf("Expected the payment term to be kept, got %s to %s", converted.IssueDate, converted.DueDate)
+	}
+	stored, convertedItems, err := dbService.GetInvoice(converted.ID)
+	if err != nil || len(convertedItems) != 1 || convertedItems[0].Amount != 10000 || converted.TotalAmount != 10000 {
+		t.Errorf("Expected the items and totals to be copied, got %v %v (%v)", converted.TotalAmount, convertedItems, err)
+	}
+	if err == nil && (stored.HomeCurrency != "CHF" || stored.ExchangeRate != 0.95) {
+		t.Errorf("Expected the exchange rate set by prepare to be saved, got %s %v", stored.HomeCurrency, stored.ExchangeRate)
+	}
+
+	if _, err := dbService.ConvertProforma(proforma.ID, convertedOn, nil); !errors.Is(err, ErrAlreadyConverted) {
+		t.Errorf("Expected ErrAlreadyConverted, got %v", err)
+	}
+
+	// Deleting the invoice allows converting the pro-forma again
+	if err := dbService.DeleteInvoice(converted.ID); err != nil {
+		t.Fatalf("DeleteInvoice failed: %v", err)
+	}
+	reloaded, _, err := dbService.GetInvoice(proforma.ID)
+	if err != nil || reloaded.ConvertedInvoiceID != 0 {
+		t.Errorf("Expected the pro-forma to be unlinked, got %+v (%v)", reloaded, err)
+	}
+}
+
+func TestCancelInvoice(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{
+		BusinessID:      1,
+		ClientID:        1,
+		IssueDate:       issueDate,
+		DueDate:         issueDate.AddDate(0, 0, 14),
+		VatRate:         19,
+		Currency:        "EUR",
+		Status:          "draft",
+		DiscountPercent: 5,
+		DiscountAmount:  100,
+	}
+	items := []models.InvoiceItem{
+		{Description: "Work", Quantity: 3, UnitPrice: 3333, DiscountPercent: 10},
+		{Description: "Travel", Quantity: 420, Unit: "km", UnitPrice: 30, Kind: models.ItemKindMileage, VatExempt: true},
+	}
+	invoice.ApplyTotals(items)
+	if err := dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+
+	if _, err := dbService.CancelInvoice(invoice.ID, issueDate, nil); !errors.Is(err, ErrNotCancellable) {
+		t.Errorf("Expected drafts not to be cancellable, got %v", err)
+	}
+	invoice.Status = "sent"
+	if err := dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to finalize invoice: %v", err)
+	}
+	if err := dbService.AddClientCredit(&models.ClientCredit{ClientID: 1, Amount: 5000, Currency: "EUR", Description: "Retainer"}); err != nil {
+		t.Fatalf("Failed to add credit: %v", err)
+	}
+	if _, err := dbService.ApplyCredit(invoice.ID, 3000); err != nil {
+		t.Fatalf("Failed to apply credit: %v", err)
+	}
+	creditBalance := func() models.Money {
+		t.Helper()
+		balances, err := dbService.GetCreditBalances()
+		if err != nil {
+			t.Fatalf("GetCreditBalances failed: %v", err)
+		}
+		if len(balances[1]) == 0 {
+			return 0
+		}
+		return balances[1][0].Amount
+	}
+
+	cancelledOn := time.Date(2024, 3, 20, 0, 0, 0, 0, time.UTC)
+	storno, err := dbService.CancelInvoice(invoice.ID, cancelledOn, nil)
+	if err != nil {
+		t.Fatalf("CancelInvoice failed: %v", err)
+	}
+	if storno.InvoiceNumber != "INV-2024-0002" || !storno.IsStorno() || storno.CancelsInvoiceID != invoice.ID {
+		t.Errorf("Expected cancellation invoice INV-2024-0002 of invoice %d, got %s of %d", invoice.ID, storno.InvoiceNumber, storno.CancelsInvoiceID)
+	}
+	if storno.Status != "paid" || !storno.PaidDate.Equal(cancelledOn) || !storno.IssueDate.Equal(cancelledOn) {
+		t.Errorf("Expected the cancellation to be paid on %s, got %s on %s", cancelledOn, storno.Status, storno.PaidDate)
+	}
+
+	// The cancellation mirrors the original, also when recalculated from its items
+	stored, stornoItems, err := dbService.GetInvoice(storno.ID)
+	if err != nil {
+		t.Fatalf("Failed to load cancellation invoice: %v", err)
+	}
+	if stored.TotalAmount != -invoice.TotalAmount || stored.VatAmount != -invoice.VatAmount {
+		t.Errorf("Expected total %s and VAT %s, got %s and %s", -invoice.TotalAmount, -invoice.VatAmount, stored.TotalAmount, stored.VatAmount)
+	}
+	original, totals := invoice.CalculateTotals(items), stored.CalculateTotals(stornoItems)
+	if totals.Total != -original.Total || totals.VatAmount != -original.VatAmount || totals.Discount != -original.Discount || totals.NonTaxable != -original.NonTaxable {
+		t.Errorf("Expected the negated totals %+v, got %+v", original, totals)
+	}
+	if len(stornoItems) != 2 || stornoItems[0].Quantity != -3 || stornoItems[0].Amount != -items[0].Amount || stornoItems[1].Quantity != -420 {
+		t.Errorf("Expected negated items, got %+v", stornoItems)
+	}
+
+	reloaded, _, err := dbService.GetInvoice(invoice.ID)
+	if err != nil || reloaded.CancelledByInvoiceID != storno.ID || reloaded.Status != "paid" || !reloaded.PaidDate.Equal(cancelledOn) {
+		t.Errorf("Expected the original to be cancelled and paid on %s, got %+v (%v)", cancelledOn, reloaded, err)
+	}
+
+	// The credit applied to the original returns to the client
+	if balance := creditBalance(); balance != 5000 {
+		t.Errorf("Expected the applied credit to be returned, got a balance of %s", balance)
+	}
+
+	if _, err := dbService.CancelInvoice(invoice.ID, cancelledOn, nil); !errors.Is(err, ErrAlreadyCancelled) {
+		t.Errorf("Expected ErrAlreadyCancelled, got %v", err)
+	}
+	if _, err := dbService.CancelInvoice(storno.ID, cancelledOn, nil); !errors.Is(err, ErrNotCancellable) {
+		t.Errorf("Expected cancellation invoices not to be cancellable, got %v", err)
+	}
+
+	// Only the notes and dates of the cancellation can be edited, its items
+	// and amounts keep mirroring the original
+	stored.Notes = "Cancelled at the client's request"
+	stored.DueDate = cancelledOn.AddDate(0, 0, 14)
+	changedItems := slices.Clone(stornoItems)
+	changedItems[0].Quantity, changedItems[0].Amount = -1, -items[0].UnitPrice
+	stored.ApplyTotals(changedItems)
+	if err := dbService.SaveInvoice(stored, changedItems); err != nil {
+		t.Errorf("Failed to edit the cancellation invoice: %v", err)
+	}
+	edited, editedItems, err := dbService.GetInvoice(storno.ID)
+	if err != nil || edited.Notes != stored.Notes || !edited.DueDate.Equal(stored.DueDate) {
+		t.Errorf("Expected the notes and due date to be saved, got %+v (%v)", edited, err)
+	}
+	if edited.CancelsInvoiceID != invoice.ID || edited.TotalAmount != -invoice.TotalAmount || editedItems[0].Quantity != -3 {
+		t.Errorf("Expected the edited cancellation to keep its link, totals and items, got %+v %+v", edited, editedItems)
+	}
+
+	// Neither the original nor its cancellation can be deleted
+	if err := dbService.DeleteInvoice(invoice.ID); !errors.Is(err, ErrInvoiceCancelled) {
+		t.Errorf("Expected ErrInvoiceCancelled, got %v", err)
+	}
+	if err := dbService.DeleteInvoice(storno.ID); !errors.Is(err, ErrInvoiceCancelled) {
+		t.Errorf("Expected ErrInvoiceCancelled for the cancellation invoice, got %v", err)
+	}
+	reloaded, _, err = dbService.GetInvoice(invoice.ID)
+	if err != nil || reloaded.CancelledByInvoiceID != storno.ID {
+		t.Errorf("Expected the original to stay cancelled, got %+v (%v)", reloaded, err)
+	}
+	if balance := creditBalance(); balance != 5000 {
+		t.Errorf("Expected the returned credit to be kept, got a balance of %s", balance)
+	}
+}
+
+func TestApplyCredit(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{
+		BusinessID:  1,
+		ClientID:    1,
+		IssueDate:   issueDate,
+		DueDate:     issueDate.AddDate(0, 0, 14),
+		TotalAmount: 10000,
+		Currency:    "EUR",
+		Status:      "draft",
+	}
+	items := []models.InvoiceItem{{Description: "Work", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+	if err := dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+
+	for _, credit := range []models.ClientCredit{
+		{ClientID: 1, Amount: 6000, Currency: "EUR", Description: "Retainer"},
+		{ClientID: 1, Amount: 5000, Currency: "EUR", Description: "Prepayment"},
+		{ClientID: 1, Amount: 9000, Currency: "USD", Description: "Prepayment"},
+	} {
+		if err := dbService.AddClientCredit(&credit); err != nil {
+			t.Fatalf("AddClientCredit failed: %v", err)
+		}
+	}
+
+	if _, err := dbService.ApplyCredit(invoice.ID, 20000); !errors.Is(err, ErrInsufficientCredit) {
+		t.Errorf("Expected ErrInsufficientCredit, got %v", err)
+	}
+
+	// Without an amount the credit is applied up to the amount due
+	applied, err := dbService.ApplyCredit(invoice.ID, 0)
+	if err != nil {
+		t.Fatalf("ApplyCredit failed: %v", err)
+	}
+	if applied.CreditApplied != 10000 || applied.AmountDue() != 0 {
+		t.Errorf("Expected 100.00 credit applied, got %s with %s due", applied.CreditApplied, applied.AmountDue())
+	}
+	if _, err := dbService.ApplyCredit(invoice.ID, 0); !errors.Is(err, ErrInsufficientCredit) {
+		t.Errorf("Expected ErrInsufficientCredit for a fully credited invoice, got %v", err)
+	}
+
+	balances, err := dbService.GetCreditBalances()
+	if err != nil {
+		t.Fatalf("GetCreditBalances failed: %v", err)
+	}
+	expected := []models.CreditBalance{{Currency: "EUR", Amount: 1000}, {Currency: "USD", Amount: 9000}}
+	if !reflect.DeepEqual(balances[1], expected) {
+		t.Errorf("Expected balances %v, got %v", expected, balances[1])
+	}
+
+	// The total of a credited invoice cannot drop below the credit
+	invoice.TotalAmount = 5000
+	if err := dbService.SaveInvoice(invoice, []models.InvoiceItem{{Description: "Work", Quantity: 1, UnitPrice: 5000, Amount: 5000}}); !errors.Is(err, ErrCreditExceedsTotal) {
+		t.Errorf("Expected ErrCreditExceedsTotal, got %v", err)
+	}
+
+	// Deleting the invoice returns its credit to the client
+	if err := dbService.DeleteInvoice(invoice.ID); err != nil {
+		t.Fatalf("DeleteInvoice failed: %v", err)
+	}
+	balances, err = dbService.GetCreditBalances()
+	if err != nil {
+		t.Fatalf("GetCreditBalances failed: %v", err)
+	}
+	if balances[1][0].Amount != 11000 {
+		t.Errorf("Expected the EUR credit to be restored to 110.00, got %v", balances[1])
+	}
+}
+
+func TestRecordCryptoPayment(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{BusinessID: 1, ClientID: 1, IssueDate: issueDate, DueDate: issueDate.AddDate(0, 0, 14), TotalAmount: 10000, Currency: "EUR", Status: "sent"}
+	if err := dbService.SaveInvoice(invoice, []models.InvoiceItem{{Description: "Work", Quantity: 1, UnitPrice: 10000, Amount: 10000}}); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+
+	paidDate := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	if err := dbService.RecordCryptoPayment(invoice.ID, 10870, 0.92, 10000, paidDate); err != nil {
+		t.Fatalf("RecordCryptoPayment failed: %v", err)
+	}
+	paid, _, err := dbService.GetInvoice(invoice.ID)
+	if err != nil {
+		t.Fatalf("GetInvoice failed: %v", err)
+	}
+	if paid.Status != "paid" || !paid.PaidDate.Equal(paidDate) || paid.CryptoAmount != 10870 || paid.CryptoRate != 0.92 || paid.CryptoFiatAmount != 10000 {
+		t.Errorf("Unexpected invoice paid in USDC: %+v", paid)
+	}
+
+	// Reopening the invoice removes the payment
+	if err := dbService.UpdateInvoiceStatus(invoice.ID, "sent", time.Time{}); err != nil {
+		t.Fatalf("UpdateInvoiceStatus failed: %v", err)
+	}
+	if reopened, _, _ := dbService.GetInvoice(invoice.ID); reopened.CryptoAmount != 0 || reopened.CryptoRate != 0 || reopened.CryptoFiatAmount != 0 {
+		t.Errorf("Expected no USDC payment on the reopened invoice, got %+v", reopened)
+	}
+
+	if err := dbService.RecordCryptoPayment(invoice.ID+1, 10870, 0.92, 10000, paidDate); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a missing invoice, got %v", err)
+	}
+}
+
+func TestSaveInvoiceReferences(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{
+		BusinessID:         1,
+		ClientID:           1,
+		IssueDate:          issueDate,
+		DueDate:            issueDate.AddDate(0, 0, 30),
+		TotalAmount:        10000,
+		Currency:           "EUR",
+		Status:             "draft",
+		PONumber:           "PO-4711",
+		ContractReference:  "MSA-2023-12",
+		ServicePeriodStart: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		ServicePeriodEnd:   time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+	}
+	items := []models.InvoiceItem{{Description: "Work", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+	if err := dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+
+	stored, _, err := dbService.GetInvoice(invoice.ID)
+	if err != nil {
+		t.Fatalf("GetInvoice failed: %v", err)
+	}
+	if stored.PONumber != "PO-4711" || stored.ContractReference != "MSA-2023-12" {
+		t.Errorf("Unexpected references: %q, %q", stored.PONumber, stored.ContractReference)
+	}
+	if !stored.ServicePeriodStart.Equal(invoice.ServicePeriodStart) || !stored.ServicePeriodEnd.Equal(invoice.ServicePeriodEnd) {
+		t.Errorf("Unexpected service period: %v - %v", stored.ServicePeriodStart, stored.ServicePeriodEnd)
+	}
+
+	// Clearing the fields stores them as unset
+	stored.PONumber = ""
+	stored.ServicePeriodStart = time.Time{}
+	stored.ServicePeriodEnd = time.Time{}
+	if err := dbService.SaveInvoice(stored, items); err != nil {
+		t.Fatalf("Failed to update invoice: %v", err)
+	}
+	updated, _, err := dbService.GetInvoice(invoice.ID)
+	if err != nil {
+		t.Fatalf("GetInvoice failed: %v", err)
+	}
+	if updated.PONumber != "" || updated.HasServicePeriod() || updated.ContractReference != "MSA-2023-12" {
+		t.Errorf("Unexpected invoice after update: %+v", updated)
+	}
+}
+
+func TestInvoiceTagsAndSavedFilters(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	items := []models.InvoiceItem{{Description: "Work", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+	newInvoice := func(tags []string) *models.Invoice {
+		invoice := &models.Invoice{BusinessID: 1, ClientID: 1, IssueDate: issueDate, DueDate: issueDate.AddDate(0, 0, 30),
+			TotalAmount: 10000, Currency: "EUR", Status: "draft", Tags: tags}
+		if err := dbService.SaveInvoice(invoice, items); err != nil {
+			t.Fatalf("Failed to save invoice: %v", err)
+		}
+		return invoice
+	}
+	first := newInvoice([]string{"Retainer", "2024-Q1"})
+	second := newInvoice([]string{"retainer"})
+
+	stored, _, err := dbService.GetInvoice(first.ID)
+	if err != nil {
+		t.Fatalf("GetInvoice failed: %v", err)
+	}
+	if !reflect.DeepEqual(stored.Tags, []string{"2024-Q1", "Retainer"}) {
+		t.Errorf("Unexpected tags: %v", stored.Tags)
+	}
+
+	// Saving without a tags list keeps the tags
+	stored.Tags = nil
+	if err := dbService.SaveInvoice(stored, items); err != nil {
+		t.Fatalf("Failed to update invoice: %v", err)
+	}
+	if stored, _, _ = dbService.GetInvoice(first.ID); len(stored.Tags) != 2 {
+		t.Errorf("Expected the tags to be kept, got %v", stored.Tags)
+	}
+
+	counts, err := dbService.GetInvoiceTagCounts()
+	if err != nil {
+		t.Fatalf("GetInvoiceTagCounts failed: %v", err)
+	}
+	if len(counts) != 2 || counts[0].Tag != "2024-Q1" || counts[0].Invoices != 1 || !strings.EqualFold(counts[1].Tag, "retainer") || counts[1].Invoices != 2 {
+		t.Errorf("Unexpected tag counts: %+v", counts)
+	}
+
+	tags, err := dbService.SetInvoiceTags(second.ID, []string{"travel", " Travel "})
+	if err != nil || !reflect.DeepEqual(tags, []string{"travel"}) {
+		t.Errorf("Unexpected result of SetInvoiceTags: %v, %v", tags, err)
+	}
+	if _, err := dbService.SetInvoiceTags(9999, []string{"travel"}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a missing invoice, got %v", err)
+	}
+	invoices, err := dbService.GetInvoices()
+	if err != nil {
+		t.Fatalf("GetInvoices failed: %v", err)
+	}
+	for _, invoice := range invoices {
+		if invoice.ID == second.ID && !reflect.DeepEqual(invoice.Tags, []string{"travel"}) {
+			t.Errorf("Unexpected tags in the invoice list: %v", invoice.Tags)
+		}
+	}
+
+	// Deleting an invoice deletes its tags
+	if err := dbService.DeleteInvoice(second.ID); err != nil {
+		t.Fatalf("DeleteInvoice failed: %v", err)
+	}
+	if counts, _ := dbService.GetInvoiceTagCounts(); len(counts) != 2 {
+		t.Errorf("Expected the tags of the deleted invoice to be gone, got %+v", counts)
+	}
+
+	filter := &models.SavedFilter{UserID: 1, Name: "Open retainers", Query: "status=sent&tag=retainer"}
+	if err := dbService.AddSavedFilter(filter); err != nil || filter.ID == 0 {
+		t.Fatalf("AddSavedFilter failed: %v", err)
+	}
+	if err := dbService.AddSavedFilter(&models.SavedFilter{UserID: 1, Name: "open RETAINERS", Query: "status=paid"}); !errors.Is(err, ErrSavedFilterExists) {
+		t.Errorf("Expected ErrSavedFilterExists, got %v", err)
+	}
+	if err := dbService.AddSavedFilter(&models.SavedFilter{UserID: 2, Name: "Open retainers", Query: "status=sent"}); err != nil {
+		t.Errorf("Expected another user to be able to use the name, got %v", err)
+	}
+	filters, err := dbService.GetSavedFilters(1)
+	if err != nil || len(filters) != 1 || filters[0].Query != filter.Query {
+		t.Errorf("Unexpected saved filters: %+v, %v", filters, err)
+	}
+	if err := dbService.DeleteSavedFilter(2, filter.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected another user not to delete the filter, got %v", err)
+	}
+	if err := dbService.DeleteSavedFilter(1, filter.ID); err != nil {
+		t.Errorf("DeleteSavedFilter failed: %v", err)
+	}
+}
+
+func TestSaveInvoiceDiscounts(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{
+		BusinessID:      1,
+		ClientID:        1,
+		IssueDate:       issueDate,
+		DueDate:         issueDate.AddDate(0, 0, 30),
+		Currency:        "EUR",
+		Status:          "draft",
+		VatRate:         20,
+		DiscountPercent: 5,
+		DiscountAmount:  1000,
+	}
+	items := []models.InvoiceItem{{Description: "Work", Quantity: 10, UnitPrice: 10000, DiscountPercent: 10, DiscountAmount: 2500}}
+	invoice.ApplyTotals(items)
+	if err := dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+
+	stored, storedItems, err := dbService.GetInvoice(invoice.ID)
+	if err != nil {
+		t.Fatalf("GetInvoice failed: %v", err)
+	}
+	if stored.DiscountPercent != 5 || stored.DiscountAmount != 1000 {
+		t.Errorf("Unexpected invoice discount: %v, %v", stored.DiscountPercent, stored.DiscountAmount)
+	}
+	if len(storedItems) != 1 || storedItems[0].DiscountPercent != 10 || storedItems[0].DiscountAmount != 2500 || storedItems[0].Amount != 87500 {
+		t.Errorf("Unexpected items: %+v", storedItems)
+	}
+	if totals := stored.CalculateTotals(storedItems); totals.Total != stored.TotalAmount {
+		t.Errorf("Stored total %s does not match recalculated total %s", stored.TotalAmount, totals.Total)
+	}
+}
+
+func TestSaveInvoiceRecalculatesTotals(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	newInvoice := func(vatAmount, total models.Money) *models.Invoice {
+		return &models.Invoice{BusinessID: 1, ClientID: 1, IssueDate: issueDate, DueDate: issueDate.AddDate(0, 0, 30),
+			VatRate: 19, VatAmount: vatAmount, TotalAmount: total, Currency: "EUR", Status: "draft"}
+	}
+	newItems := func() []models.InvoiceItem {
+		return []models.InvoiceItem{{Description: "Work", Quantity: 3, UnitPrice: 3333, Amount: 9999}}
+	}
+
+	// 3 x 33.33 = 99.99; 19% VAT is 18.9981, rounded to 19.00. Client-side
+	// amounts within a cent are accepted and replaced by the rounded values.
+	invoice := newInvoice(1899, 11898)
+	items := newItems()
+	if err := dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+	if items[0].Amount != 9999 || invoice.VatAmount != 1900 || invoice.TotalAmount != 11899 {
+		t.Errorf("Expected amounts 99.99/19.00/118.99, got %s/%s/%s", items[0].Amount, invoice.VatAmount, invoice.TotalAmount)
+	}
+
+	for name, invoice := range map[string]*models.Invoice{
+		"VAT":   newInvoice(1000, 10999),
+		"total": newInvoice(1900, 15000),
+	} {
+		if err := dbService.SaveInvoice(invoice, newItems()); !errors.Is(err, ErrInvoiceTotalsMismatch) {
+			t.Errorf("Expected ErrInvoiceTotalsMismatch for a wrong %s, got %v", name, err)
+		}
+	}
+
+	items = newItems()
+	items[0].Amount = 9000
+	if err := dbService.SaveInvoice(newInvoice(1900, 11899), items); !errors.Is(err, ErrInvoiceTotalsMismatch) {
+		t.Errorf("Expected ErrInvoiceTotalsMismatch for a wrong item amount, got %v", err)
+	}
+
+	// Imported invoices keep their historical amounts
+	imported := newInvoice(2000, 12000)
+	if err := dbService.SaveImportedInvoice(imported, newItems()); err != nil {
+		t.Errorf("Failed to save imported invoice: %v", err)
+	}
+	if imported.VatAmount != 2000 || imported.TotalAmount != 12000 {
+		t.Errorf("Imported amounts were changed: %s/%s", imported.VatAmount, imported.TotalAmount)
+	}
+}
+
+func TestSaveInvoiceItemUnits(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{BusinessID: 1, ClientID: 1, IssueDate: issueDate, DueDate: issueDate.AddDate(0, 0, 30), TotalAmount: 86000, Currency: "EUR", Status: "draft"}
+	items := []models.InvoiceItem{
+		{Description: "Consulting", Quantity: 8, UnitPrice: 10000, Amount: 80000},
+		{Description: "Travel", Quantity: 120, Unit: models.UnitKilometres, UnitPrice: 50, Amount: 6000},
+	}
+	if err := dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+
+	_, storedItems, err := dbService.GetInvoice(invoice.ID)
+	if err != nil {
+		t.Fatalf("GetInvoice failed: %v", err)
+	}
+	if len(storedItems) != 2 || storedItems[0].Unit != models.UnitHours || storedItems[1].Unit != models.UnitKilometres {
+		t.Errorf("Unexpected item units: %+v", storedItems)
+	}
+}
+
+func TestMigrateMoneyColumnsToCents(t *testing.T) {
+	dbService, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{BusinessID: 1, ClientID: 1, IssueDate: issueDate, DueDate: issueDate.AddDate(0, 0, 30),
+		VatRate: 19, Currency: "EUR", Status: "draft"}
+	items := []models.InvoiceItem{{Description: "Work", Quantity: 3, UnitPrice: 3333}}
+	invoice.ApplyTotals(items)
+	if err := dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+
+	// Store the amounts as REAL decimals, like databases created by older versions
+	for _, money := range moneyColumns {
+		for _, column := range money.columns {
+			for _, statement := range []string{
+				`ALTER TABLE %[1]s RENAME COLUMN %[2]s TO %[2]s_cents`,
+				`ALTER TABLE %[1]s ADD COLUMN %[2]s REAL NOT NULL DEFAULT 0`,
+				`UPDATE %[1]s SET %[2]s = %[2]s_cents / 100.0`,
+				`ALTER TABLE %[1]s DROP COLUMN %[2]s_cents`,
+			} {
+				if _, err := dbService.db.Exec(fmt.Sprintf(statement, money.table, column)); err != nil {
+					t.Fatalf("Failed to downgrade %s.%s: %v", money.table, column, err)
+				}
+			}
+		}
+	}
+	dbService.Close()
+
+	migrated, err := NewDBService(tempDir, NewLogger(INFO))
+	if err != nil {
+		t.Fatalf("Failed to reopen DB service: %v", err)
+	}
+	defer migrated.Close()
+
+	var columnType string
+	if err := migrated.db.QueryRow(`SELECT type FROM pragma_table_info('invoices') WHERE name = 'total_amount'`).Scan(&columnType); err != nil || columnType != "INTEGER" {
+		t.Errorf("Expected total_amount to be INTEGER, got %q (%v)", columnType, err)
+	}
+
+	saved, savedItems, err := migrated.GetInvoice(invoice.ID)
+	if err != nil {
+		t.Fatalf("Failed to get invoice: %v", err)
+	}
+	if saved.TotalAmount != 11899 || saved.VatAmount != 1900 || len(savedItems) != 1 || savedItems[0].UnitPrice != 3333 || savedItems[0].Amount != 9999 {
+		t.Errorf("Unexpected amounts after migration: total %s, VAT %s, items %+v", saved.TotalAmount, saved.VatAmount, savedItems)
+	}
+}
+
+func TestSaveClientDetectsVersionConflict(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	client := &models.Client{Name: "Acme", Address: "Street 1", City: "Berlin", PostalCode: "10115", Country: "DE", VatID: "DE123456789"}
+	if err := dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+	if client.Version != 1 {
+		t.Fatalf("Expected version 1 after insert, got %d", client.Version)
+	}
+
+	// Two copies loaded at the same version
+	tabA, _ := dbService.GetClient(client.ID)
+	tabB, _ := dbService.GetClient(client.ID)
+
+	tabA.Name = "Acme GmbH"
+	if err := dbService.SaveClient(tabA); err != nil {
+		t.Fatalf("Failed to save first edit: %v", err)
+	}
+	if tabA.Version != 2 {
+		t.Errorf("Expected version 2 after update, got %d", tabA.Version)
+	}
+
+	tabB.Name = "Acme AG"
+	if err := dbService.SaveClient(tabB); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("Expected ErrVersionConflict, got %v", err)
+	}
+
+	current, _ := dbService.GetClient(client.ID)
+	if current.Name != "Acme GmbH" {
+		t.Errorf("Stale write overwrote the record: %s", current.Name)
+	}
+}
+
+func TestClientTrashAndRestore(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	client := &models.Client{Name: "Acme", Address: "Street 1", City: "Berlin", PostalCode: "10115", Country: "DE", VatID: "DE123456789"}
+	if err := dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+
+	// Only the sent invoice is open; the draft, the pro-forma and the held
+	// invoice are not
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, invoice := range []*models.Invoice{
+		{Status: models.InvoiceStatusSent},
+		{Status: models.InvoiceStatusDraft},
+		{Status: models.InvoiceStatusSent, Type: models.InvoiceTypeProforma},
+		{Status: models.InvoiceStatusOnHold, StatusReason: "Waiting for the purchase order"},
+		{Status: models.InvoiceStatusPaid},
+	} {
+		invoice.BusinessID, invoice.ClientID, invoice.IssueDate, invoice.DueDate = 1, client.ID, issueDate, issueDate.AddDate(0, 0, 30)
+		invoice.TotalAmount, invoice.Currency = 10000, "EUR"
+		items := []models.InvoiceItem{{Description: "Work", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+		if err := dbService.SaveInvoice(invoice, items); err != nil {
+			t.Fatalf("Failed to save invoice: %v", err)
+		}
+	}
+
+	open, err := dbService.CountOpenInvoicesForClient(client.ID)
+	if err != nil || open != 1 {
+		t.Fatalf("CountOpenInvoicesForClient() = %d, %v; want 1, nil", open, err)
+	}
+
+	if err := dbService.DeleteClient(client.ID); err != nil {
+		t.Fatalf("Failed to delete client: %v", err)
+	}
+	deleted, err := dbService.GetDeletedClients()
+	if err != nil || len(deleted) != 1 || deleted[0].ID != client.ID {
+		t.Fatalf("GetDeletedClients() = %v, %v; want the deleted client", deleted, err)
+	}
+
+	if err := dbService.RestoreClient(client.ID); err != nil {
+		t.Fatalf("Failed to restore client: %v", err)
+	}
+	if err := dbService.RestoreClient(client.ID); err == nil {
+		t.Error("Expected restoring a client that is not deleted to fail")
+	}
+
+	clients, _ := dbService.GetClients()
+	if len(clients) != 1 || clients[0].Deleted {
+		t.Errorf("Expected restored client to be listed, got %v", clients)
+	}
+}
+
+func TestAnonymizeClientKeepsInvoices(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	client := &models.Client{Name: "Jane Doe", Address: "Private St 5", City: "Vienna", PostalCode: "1010", Country: "AT", VatID: "ATU12345678", RiskNotes: "Jane is often late", PEC: "jane@pec.example"}
+	if err := dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{BusinessID: 1, ClientID: client.ID, IssueDate: issueDate, DueDate: issueDate.AddDate(0, 0, 30), TotalAmount: 12000, VatRate: 20, VatAmount: 2000, Currency: "EUR", Status: "paid"}
+	items := []models.InvoiceItem{{Description: "Work", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+	if err := dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+
+	numbers, err := dbService.AnonymizeClient(client.ID)
+	if err != nil {
+		t.Fatalf("AnonymizeClient failed: %v", err)
+	}
+	if len(numbers) != 1 || numbers[0] != invoice.InvoiceNumber {
+		t.Errorf("Expected invoice %s to be returned, got %v", invoice.InvoiceNumber, numbers)
+	}
+
+	erased, err := dbService.GetClient(client.ID)
+	if err != nil {
+		t.Fatalf("GetClient failed: %v", err)
+	}
+	if erased.Name == "Jane Doe" || erased.Address != RedactedPlaceholder || erased.VatID != "" || erased.RiskNotes != "" || erased.PEC != "" || !erased.Deleted {
+		t.Errorf("Client data was not erased: %+v", erased)
+	}
+	if erased.Country != "AT" {
+		t.Errorf("Expected country to be retained, got %s", erased.Country)
+	}
+
+	stored, _, err := dbService.GetInvoice(invoice.ID)
+	if err != nil || stored.TotalAmount != 12000 {
+		t.Errorf("Expected invoice to be retained, got %+v, %v", stored, err)
+	}
+
+	entries, err := dbService.GetAuditLog("client", client.ID, 0)
+	if err != nil || len(entries) != 1 || entries[0].Action != AuditActionErase {
+		t.Fatalf("Expected one erase audit entry, got %+v, %v", entries, err)
+	}
+	if strings.Contains(entries[0].Details, "Jane") {
+		t.Errorf("Audit entry must not contain erased data: %s", entries[0].Details)
+	}
+
+	if _, err := dbService.AnonymizeClient(9999); err == nil {
+		t.Error("Expected anonymizing a missing client to fail")
+	}
+}
+
+func TestNotes(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	client := &models.Client{Name: "Acme GmbH", Address: "Main St 1", City: "Berlin", PostalCode: "10115", Country: "DE", VatID: "DE123456789"}
+	if err := dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	items := []models.InvoiceItem{{Description: "Work", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+	newInvoice := func() *models.Invoice {
+		invoice := &models.Invoice{BusinessID: 1, ClientID: client.ID, IssueDate: issueDate, DueDate: issueDate.AddDate(0, 0, 30), TotalAmount: 10000, Currency: "EUR", Status: "draft"}
+		if err := dbService.SaveInvoice(invoice, items); err != nil {
+			t.Fatalf("Failed to save invoice: %v", err)
+		}
+		return invoice
+	}
+	kept, deleted := newInvoice(), newInvoice()
+
+	for _, note := range []*models.Note{
+		{EntityType: models.NoteEntityClient, EntityID: client.ID, Body: "New contact in accounts payable"},
+		{EntityType: models.NoteEntityInvoice, EntityID: kept.ID, Body: "Client promised payment Friday", Author: "Jane"},
+		{EntityType: models.NoteEntityInvoice, EntityID: deleted.ID, Body: "Sent by mistake"},
+	} {
+		if err := dbService.AddNote(note); err != nil || note.ID == 0 {
+			t.Fatalf("AddNote failed: %v", err)
+		}
+	}
+
+	notes, err := dbService.GetNotes(models.NoteEntityInvoice, kept.ID)
+	if err != nil || len(notes) != 1 || notes[0].Body != "Client promised payment Friday" || notes[0].Author != "Jane" {
+		t.Errorf("Unexpected invoice notes: %+v, %v", notes, err)
+	}
+	if notes, _ := dbService.GetClientNotes(client.ID); len(notes) != 3 || notes[0].EntityID != deleted.ID {
+		t.Errorf("Expected the notes of the client and its invoices, newest first, got %+v", notes)
+	}
+
+	// Deleting an invoice deletes its notes
+	if err := dbService.DeleteInvoice(deleted.ID); err != nil {
+		t.Fatalf("DeleteInvoice failed: %v", err)
+	}
+	if notes, _ := dbService.GetClientNotes(client.ID); len(notes) != 2 {
+		t.Errorf("Expected the note of the deleted invoice to be gone, got %+v", notes)
+	}
+
+	if err := dbService.DeleteNote(notes[0].ID); err != nil {
+		t.Errorf("DeleteNote failed: %v", err)
+	}
+	if err := dbService.DeleteNote(notes[0].ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a deleted note, got %v", err)
+	}
+
+	// Erasing the client's personal data erases the notes as well
+	if _, err := dbService.AnonymizeClient(client.ID); err != nil {
+		t.Fatalf("AnonymizeClient failed: %v", err)
+	}
+	if notes, _ := dbService.GetClientNotes(client.ID); len(notes) != 0 {
+		t.Errorf("Expected the notes to be erased, got %+v", notes)
+	}
+}
+
+func TestOpenDBServiceReadOnly(t *testing.T) {
+	dbService, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+	if err := dbService.SaveClient(&models.Client{Name: "Client", Address: "Street 1", City: "City", PostalCode: "12345", Country: "DE"}); err != nil {
+		t.Fatalf("SaveClient failed: %v", err)
+	}
+
+	readOnly, err := OpenDBService(tempDir, NewLogger(ERROR), true)
+	if err != nil {
+		t.Fatalf("OpenDBService failed: %v", err)
+	}
+	defer readOnly.Close()
+
+	if clients, err := readOnly.GetClients(); err != nil || len(clients) != 1 {
+		t.Errorf("Expected to read 1 client, got %d (%v)", len(clients), err)
+	}
+	if err := readOnly.SaveClient(&models.Client{Name: "Other"}); err == nil {
+		t.Error("Expected writing to a read-only database to fail")
+	}
+
+	if _, err := OpenDBService(t.TempDir(), NewLogger(ERROR), true); err == nil {
+		t.Error("Expected opening a missing database to fail")
+	}
 }
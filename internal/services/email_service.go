@@ -0,0 +1,276 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// ErrEmailNotConfigured is returned when sending email without an SMTP host
+var ErrEmailNotConfigured = errors.New("email is not configured: set the SMTP host on the Settings page")
+
+// EmailAttachment is a file attached to an email
+type EmailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Email is a message sent to a client on behalf of a business
+type Email struct {
+	To          string
+	Subject     string
+	Body        string
+	Attachments []EmailAttachment
+}
+
+// sendMailFunc delivers a message; it has the signature of smtp.SendMail
+type sendMailFunc func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+
+// EmailService sends email through the SMTP server configured in the settings
+type EmailService struct {
+	settingsService *SettingsService
+	logger          *Logger
+	sendMail        sendMailFunc // Replaced in tests
+}
+
+// NewEmailService creates a new EmailService
+func NewEmailService(settingsService *SettingsService, logger *Logger) *EmailService {
+	return &EmailService{
+		settingsService: settingsService,
+		logger:          logger,
+		sendMail:        sendSMTP,
+	}
+}
+
+// Configured reports whether an SMTP host is set
+func (s *EmailService) Configured() bool {
+	return s.settingsService.GetString(SettingSMTPHost) != ""
+}
+
+// Send sends an email on behalf of a business. The sender defaults to the
+// business name and email, the business signature is appended to the body,
+// and a copy is sent to the business when BCC-to-self is enabled. It returns
+// the Message-ID of the sent email.
+func (s *EmailService) Send(business *models.Business, email *Email) (string, error) {
+	host := s.settingsService.GetString(SettingSMTPHost)
+	if host == "" {
+		return "", ErrEmailNotConfigured
+	}
+
+	to, err := mail.ParseAddress(email.To)
+	if err != nil {
+		return "", fmt.Errorf("%q is not an email address", email.To)
+	}
+
+	from := &mail.Address{
+		Name:    firstNonEmpty(s.settingsService.GetString(SettingSMTPFromName), business.Name),
+		Address: firstNonEmpty(s.settingsService.GetString(SettingSMTPFrom), business.Email),
+	}
+	if from.Address == "" {
+		return "", errors.New("no from address: set the SMTP from address or the business email")
+	}
+
+	replyTo := s.settingsService.GetString(SettingSMTPReplyTo)
+	if replyTo == "" && business.Email != "" && !strings.EqualFold(business.Email, from.Address) {
+		replyTo = business.Email
+	}
+
+	recipients := []string{to.Address}
+	if s.settingsService.GetBool(SettingSMTPBCCSelf) {
+		self := firstNonEmpty(business.Email, from.Address)
+		if !strings.EqualFold(self, to.Address) {
+			recipients = append(recipients, self)
+		}
+	}
+
+	body := email.Body
+	if signature := strings.TrimSpace(business.EmailSignature); signature != "" {
+		body = strings.TrimRight(body, "\n") + "\n\n-- \n" + signature + "\n"
+	}
+
+	id := messageID(from.Address)
+	msg, err := buildEmailMessage(id, from, to, replyTo, email.Subject, body, email.Attachments)
+	if err != nil {
+		return "", err
+	}
+
+	var auth smtp.Auth
+	if username := s.settingsService.GetString(SettingSMTPUsername); username != "" {
+		auth = smtp.PlainAuth("", username, s.settingsService.GetString(SettingSMTPPassword), host)
+	}
+
+	addr := net.JoinHostPort(host, strconv.Itoa(s.settingsService.GetInt(SettingSMTPPort)))
+	if err := s.sendMail(addr, auth, from.Address, recipients, msg); err != nil {
+		return "", fmt.Errorf("failed to send email: %w", err)
+	}
+
+	s.logger.Info("Sent email %q to %s", email.Subject, strings.Join(recipients, ", "))
+	return id, nil
+}
+
+// buildEmailMessage builds a MIME message with a plain text body and attachments.
+// Bcc recipients are only part of the envelope, never of the headers.
+func buildEmailMessage(id string, from, to *mail.Address, replyTo, subject, body string, attachments []EmailAttachment) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", from.String())
+	header("To", to.String())
+	if replyTo != "" {
+		header("Reply-To", replyTo)
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", id)
+	header("MIME-Version", "1.0")
+	header("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": writer.Boundary()}))
+	buf.WriteString("\r\n")
+
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range attachments {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// messageID returns a unique Message-ID on the domain of the sender
+func messageID(from string) string {
+	random := make([]byte, 16)
+	rand.Read(random)
+	_, domain, _ := strings.Cut(from, "@")
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(random), domain)
+}
+
+// smtpDialTimeout limits connecting to the SMTP server, and smtpTimeout the
+// whole conversation, so an unresponsive server cannot hold a worker forever
+var (
+	smtpDialTimeout = 30 * time.Second
+	smtpTimeout     = 2 * time.Minute
+)
+
+// sendSMTP delivers a message like smtp.SendMail, but with timeouts, and
+// connects with TLS from the start on port 465 instead of upgrading with
+// STARTTLS
+func sendSMTP(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	host, port, _ := net.SplitHostPort(addr)
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+
+	var conn net.Conn
+	var err error
+	if port == "465" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(smtpTimeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if port != "465" {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+				return err
+			}
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	// The server has accepted the message, a failing QUIT does not undo that
+	client.Quit()
+	return nil
+}
+
+// firstNonEmpty returns the first value that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
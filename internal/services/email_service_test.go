@@ -0,0 +1,113 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestEmailServiceSend(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	logger := NewLogger(ERROR)
+	settings := NewSettingsService(dbService, logger)
+	emailService := NewEmailService(settings, logger)
+
+	business := &models.Business{Name: "Test Business", Email: "owner@business.example", EmailSignature: "Jane Doe\nTest Business"}
+	email := &Email{
+		To:          "billing@client.example",
+		Subject:     "Rechnung INV-1 für Müller",
+		Body:        "Hello,\nplease find the invoice attached.\n",
+		Attachments: []EmailAttachment{{Filename: "invoice-INV-1.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.4")}},
+	}
+
+	if _, err := emailService.Send(business, email); !errors.Is(err, ErrEmailNotConfigured) {
+		t.Fatalf("Expected ErrEmailNotConfigured, got %v", err)
+	}
+
+	if err := settings.SetMany(map[string]string{
+		SettingSMTPHost:    "smtp.example",
+		SettingSMTPFrom:    "invoices@business.example",
+		SettingSMTPBCCSelf: "true",
+	}); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+
+	var gotAddr, gotFrom string
+	var gotTo []string
+	var gotMsg string
+	emailService.sendMail = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		gotAddr, gotFrom, gotTo, gotMsg = addr, from, to, string(msg)
+		return nil
+	}
+
+	id, err := emailService.Send(business, email)
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if gotAddr != "smtp.example:587" || gotFrom != "invoices@business.example" {
+		t.Errorf("Unexpected SMTP server %q or sender %q", gotAddr, gotFrom)
+	}
+	if strings.Join(gotTo, ",") != "billing@client.example,owner@business.example" {
+		t.Errorf("Expected the client and a copy to the business, got %v", gotTo)
+	}
+	for _, want := range []string{
+		"Message-ID: " + id + "\r\n",
+		"From: \"Test Business\" <invoices@business.example>\r\n",
+		"To: <billing@client.example>\r\n",
+		"Reply-To: owner@business.example\r\n",
+		"Subject: =?utf-8?q?Rechnung_INV-1_f=C3=BCr_M=C3=BCller?=\r\n",
+		"\r\n--=20\r\nJane Doe\r\nTest Business\r\n",
+		"Content-Disposition: attachment; filename=invoice-INV-1.pdf",
+		"JVBERi0xLjQ=",
+	} {
+		if !strings.Contains(gotMsg, want) {
+			t.Errorf("Message does not contain %q:\n%s", want, gotMsg)
+		}
+	}
+	if strings.Contains(gotMsg, "Bcc:") {
+		t.Error("The copy to the business must not be listed in the headers")
+	}
+}
+
+func TestSendSMTPTimesOut(t *testing.T) {
+	// A server that accepts the connection but never sends its greeting
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	defer func(timeout time.Duration) { smtpTimeout = timeout }(smtpTimeout)
+	smtpTimeout = 100 * time.Millisecond
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sendSMTP(listener.Addr().String(), nil, "a@example.com", []string{"b@example.com"}, []byte("Subject: test\r\n\r\n"))
+	}()
+	select {
+	case err := <-done:
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("Expected a timeout, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sendSMTP did not time out")
+	}
+}
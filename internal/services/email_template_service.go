@@ -0,0 +1,214 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// Email template kinds
+const (
+	EmailTemplateInvoice  = "invoice"
+	EmailTemplateReminder = "reminder"
+	EmailTemplateReceipt  = "receipt"
+)
+
+// EmailTemplateKinds lists the email template kinds in display order
+var EmailTemplateKinds = []string{EmailTemplateInvoice, EmailTemplateReminder, EmailTemplateReceipt}
+
+// EmailTemplateVariables lists the variables that can be used as {{name}} in email templates
+var EmailTemplateVariables = []string{
+	"client_name", "business_name", "invoice_number", "issue_date", "due_date", "total", "payment_link", "paypal_link",
+}
+
+// defaultEmailLanguage is the language of the built-in email templates
+const defaultEmailLanguage = "en"
+
+// defaultEmailTemplates are used until a template is customized
+var defaultEmailTemplates = map[string]models.EmailTemplate{
+	EmailTemplateInvoice: {
+		Subject: "Invoice {{invoice_number}} from {{business_name}}",
+		Body: "Dear {{client_name}},\n\nplease find attached invoice {{invoice_number}} of {{issue_date}} over {{total}}, due on {{due_date}}.\n\n" +
+			"You can also view the invoice online: {{payment_link}}\n\nKind regards,\n{{business_name}}",
+	},
+	EmailTemplateReminder: {
+		Subject: "Reminder: invoice {{invoice_number}} was due on {{due_date}}",
+		Body: "Dear {{client_name}},\n\nour records show that invoice {{invoice_number}} over {{total}}, due on {{due_date}}, has not been paid yet. " +
+			"If you have already paid, please disregard this message.\n\nYou can view the invoice here: {{payment_link}}\n\nKind regards,\n{{business_name}}",
+	},
+	EmailTemplateReceipt: {
+		Subject: "Payment received for invoice {{invoice_number}}",
+		Body:    "Dear {{client_name}},\n\nthank you for your payment of {{total}} for invoice {{invoice_number}}.\n\nKind regards,\n{{business_name}}",
+	},
+}
+
+// emailVariablePattern matches {{name}} placeholders, allowing spaces inside the braces
+var emailVariablePattern = regexp.MustCompile(`\{\{\s*([a-zA-Z0-9_]+)\s*\}\}`)
+
+// ErrEmailTemplateNotFound is returned when deleting a template that was never customized
+var ErrEmailTemplateNotFound = errors.New("email template not found")
+
+// EmailTemplateService manages the email templates and renders them in the client's language
+type EmailTemplateService struct {
+	dbService       *DBService
+	settingsService *SettingsService
+	logger          *Logger
+}
+
+// NewEmailTemplateService creates a new EmailTemplateService
+func NewEmailTemplateService(dbService *DBService, settingsService *SettingsService, logger *Logger) *EmailTemplateService {
+	return &EmailTemplateService{
+		dbService:       dbService,
+		settingsService: settingsService,
+		logger:          logger,
+	}
+}
+
+// IsLanguageTag reports whether s is a language like de or a locale like de-DE
+func IsLanguageTag(s string) bool {
+	return localePattern.MatchString(s)
+}
+
+// List returns the customized templates and the built-in templates that have
+// not been customized, ordered by kind and language
+func (s *EmailTemplateService) List() ([]models.EmailTemplate, error) {
+	rows, err := s.dbService.GetDB().Query(`SELECT kind, language, subject, body, updated_at FROM email_templates`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query email templates: %w", err)
+	}
+	defer rows.Close()
+
+	var templates []models.EmailTemplate
+	for rows.Next() {
+		var t models.EmailTemplate
+		if err := rows.Scan(&t.Kind, &t.Language, &t.Subject, &t.Body, &t.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan email template: %w", err)
+		}
+		templates = append(templates, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, kind := range EmailTemplateKinds {
+		customized := slices.ContainsFunc(templates, func(t models.EmailTemplate) bool {
+			return t.Kind == kind && t.Language == defaultEmailLanguage
+		})
+		if !customized {
+			templates = append(templates, builtInEmailTemplate(kind))
+		}
+	}
+
+	slices.SortFunc(templates, func(a, b models.EmailTemplate) int {
+		if c := slices.Index(EmailTemplateKinds, a.Kind) - slices.Index(EmailTemplateKinds, b.Kind); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Language, b.Language)
+	})
+	return templates, nil
+}
+
+// Get returns the template of a kind for a language. It falls back to the
+// language without region (de for de-AT), then to the default locale and
+// finally to the built-in English template.
+func (s *EmailTemplateService) Get(kind, language string) (models.EmailTemplate, error) {
+	if _, ok := defaultEmailTemplates[kind]; !ok {
+		return models.EmailTemplate{}, fmt.Errorf("unknown email template %q", kind)
+	}
+
+	var candidates []string
+	for _, lang := range []string{language, s.settingsService.GetString(SettingLocale), defaultEmailLanguage} {
+		if lang == "" {
+			continue
+		}
+		base, _, _ := strings.Cut(lang, "-")
+		for _, candidate := range []string{lang, base} {
+			if !slices.Contains(candidates, candidate) {
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+
+	for _, candidate := range candidates {
+		var t models.EmailTemplate
+		err := s.dbService.GetDB().QueryRow(`
+			SELECT kind, language, subject, body, updated_at FROM email_templates WHERE kind = ? AND language = ?
+		`, kind, candidate).Scan(&t.Kind, &t.Language, &t.Subject, &t.Body, &t.UpdatedAt)
+		if err == nil {
+			return t, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return models.EmailTemplate{}, fmt.Errorf("failed to read email template: %w", err)
+		}
+	}
+	return builtInEmailTemplate(kind), nil
+}
+
+// Save stores a customized template, replacing the one for the same kind and language
+func (s *EmailTemplateService) Save(t *models.EmailTemplate) error {
+	if _, ok := defaultEmailTemplates[t.Kind]; !ok {
+		return fmt.Errorf("unknown email template %q", t.Kind)
+	}
+	if !IsLanguageTag(t.Language) {
+		return fmt.Errorf("%q is not a language like en or de-DE", t.Language)
+	}
+	if strings.TrimSpace(t.Subject) == "" {
+		return errors.New("subject is required")
+	}
+	for _, text := range []string{t.Subject, t.Body} {
+		for _, match := range emailVariablePattern.FindAllStringSubmatch(text, -1) {
+			if !slices.Contains(EmailTemplateVariables, match[1]) {
+				return fmt.Errorf("unknown variable {{%s}}", match[1])
+			}
+		}
+	}
+
+	t.BuiltIn = false
+	t.UpdatedAt = time.Now().UTC()
+	_, err := s.dbService.GetDB().Exec(`
+		INSERT INTO email_templates (kind, language, subject, body, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (kind, language) DO UPDATE SET subject = excluded.subject, body = excluded.body, updated_at = excluded.updated_at
+	`, t.Kind, t.Language, t.Subject, t.Body, t.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save email template: %w", err)
+	}
+
+	s.logger.Info("Saved %s email template for language %s", t.Kind, t.Language)
+	return nil
+}
+
+// Delete removes a customized template; the built-in English templates then apply again
+func (s *EmailTemplateService) Delete(kind, language string) error {
+	result, err := s.dbService.GetDB().Exec(`DELETE FROM email_templates WHERE kind = ? AND language = ?`, kind, language)
+	if err != nil {
+		return fmt.Errorf("failed to delete email template: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrEmailTemplateNotFound
+	}
+
+	s.logger.Info("Deleted %s email template for language %s", kind, language)
+	return nil
+}
+
+// RenderEmailText replaces the {{name}} variables in text; variables without a value become empty
+func RenderEmailText(text string, values map[string]string) string {
+	return emailVariablePattern.ReplaceAllStringFunc(text, func(match string) string {
+		return values[emailVariablePattern.FindStringSubmatch(match)[1]]
+	})
+}
+
+// builtInEmailTemplate returns the default English template of a kind
+func builtInEmailTemplate(kind string) models.EmailTemplate {
+	t := defaultEmailTemplates[kind]
+	t.Kind = kind
+	t.Language = defaultEmailLanguage
+	t.BuiltIn = true
+	return t
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestEmailTemplateFallback(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	logger := NewLogger(ERROR)
+	settings := NewSettingsService(dbService, logger)
+	templates := NewEmailTemplateService(dbService, settings, logger)
+
+	// Nothing customized: the built-in English template is used
+	tmpl, err := templates.Get(EmailTemplateInvoice, "de-AT")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !tmpl.BuiltIn || tmpl.Language != "en" {
+		t.Errorf("Expected the built-in English template, got %+v", tmpl)
+	}
+
+	if err := templates.Save(&models.EmailTemplate{Kind: EmailTemplateInvoice, Language: "de", Subject: "Rechnung {{invoice_number}}", Body: "Hallo {{client_name}}"}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := templates.Save(&models.EmailTemplate{Kind: EmailTemplateInvoice, Language: "fr", Subject: "Facture {{invoice_number}}", Body: "Bonjour"}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// The base language is used for a regional variant
+	if tmpl, _ := templates.Get(EmailTemplateInvoice, "de-AT"); tmpl.Language != "de" {
+		t.Errorf("Expected the de template for de-AT, got %q", tmpl.Language)
+	}
+
+	// Clients without a language get the default locale
+	if err := settings.SetMany(map[string]string{SettingLocale: "fr-FR"}); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+	if tmpl, _ := templates.Get(EmailTemplateInvoice, ""); tmpl.Language != "fr" {
+		t.Errorf("Expected the default locale's template, got %q", tmpl.Language)
+	}
+
+	subject := RenderEmailText("Rechnung {{invoice_number}} {{ client_name }}", map[string]string{"invoice_number": "INV-1", "client_name": "Acme"})
+	if subject != "Rechnung INV-1 Acme" {
+		t.Errorf("Unexpected rendered text %q", subject)
+	}
+
+	if err := templates.Delete(EmailTemplateInvoice, "de"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := templates.Delete(EmailTemplateInvoice, "de"); !errors.Is(err, ErrEmailTemplateNotFound) {
+		t.Errorf("Expected ErrEmailTemplateNotFound, got %v", err)
+	}
+}
+
+func TestEmailTemplateValidation(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	logger := NewLogger(ERROR)
+	templates := NewEmailTemplateService(dbService, NewSettingsService(dbService, logger), logger)
+
+	for _, tmpl := range []models.EmailTemplate{
+		{Kind: "newsletter", Language: "en", Subject: "Hi"},
+		{Kind: EmailTemplateReminder, Language: "german", Subject: "Hi"},
+		{Kind: EmailTemplateReminder, Language: "de", Subject: " "},
+		{Kind: EmailTemplateReminder, Language: "de", Subject: "Hi", Body: "{{amount_due}}"},
+	} {
+		if err := templates.Save(&tmpl); err == nil {
+			t.Errorf("Expected %+v to be rejected", tmpl)
+		}
+	}
+
+	list, err := templates.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != len(EmailTemplateKinds) {
+		t.Errorf("Expected only the %d built-in templates, got %d", len(EmailTemplateKinds), len(list))
+	}
+	for _, tmpl := range list {
+		if !tmpl.BuiltIn || strings.TrimSpace(tmpl.Subject) == "" {
+			t.Errorf("Unexpected template %+v", tmpl)
+		}
+	}
+}
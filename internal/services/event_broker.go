@@ -0,0 +1,89 @@
+package services
+
+import "sync"
+
+// Live events pushed to open browser tabs
+const (
+	LiveEventInvoiceStatus = "invoice_status" // The status of an invoice changed
+	LiveEventPDFGenerated  = "pdf_generated"  // A queued PDF generation finished
+	LiveEventBackupCreated = "backup_created" // A backup was created
+)
+
+// liveEventBuffer is how many events a subscriber may fall behind before
+// further events are dropped for it
+const liveEventBuffer = 16
+
+// LiveEvent is a change pushed to subscribers
+type LiveEvent struct {
+	Type string
+	Data interface{} // Encoded as JSON
+}
+
+// EventBroker fans out live events to the subscribers, the server-sent event
+// streams of open browser tabs. Publishing never blocks: a subscriber that
+// does not keep up misses events rather than slowing down the publisher.
+type EventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan LiveEvent]struct{}
+	closed      bool
+	logger      *Logger
+}
+
+// NewEventBroker creates a new EventBroker
+func NewEventBroker(logger *Logger) *EventBroker {
+	return &EventBroker{subscribers: make(map[chan LiveEvent]struct{}), logger: logger}
+}
+
+// Subscribe returns a channel receiving the events published from now on, and
+// a function ending the subscription. The channel is closed when the
+// subscription ends or the broker is closed.
+func (b *EventBroker) Subscribe() (<-chan LiveEvent, func()) {
+	ch := make(chan LiveEvent, liveEventBuffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subscribers[ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Publish sends an event to all subscribers. It does nothing on a nil broker,
+// so code paths without live updates need no checks.
+func (b *EventBroker) Publish(eventType string, data interface{}) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- LiveEvent{Type: eventType, Data: data}:
+		default:
+			b.logger.Debug("Dropped a %s event for a slow subscriber", eventType)
+		}
+	}
+}
+
+// Close ends all subscriptions, so the streams of open tabs finish and the
+// server can shut down
+func (b *EventBroker) Close() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
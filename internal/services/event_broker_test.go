@@ -0,0 +1,49 @@
+package services
+
+import "testing"
+
+func TestEventBroker(t *testing.T) {
+	broker := NewEventBroker(NewLogger(FATAL))
+	first, unsubscribeFirst := broker.Subscribe()
+	second, unsubscribeSecond := broker.Subscribe()
+	defer unsubscribeSecond()
+
+	broker.Publish(LiveEventBackupCreated, "backup.db")
+	for _, ch := range []<-chan LiveEvent{first, second} {
+		if event := <-ch; event.Type != LiveEventBackupCreated || event.Data != "backup.db" {
+			t.Errorf("Unexpected event %+v", event)
+		}
+	}
+
+	// An ended subscription receives nothing more
+	unsubscribeFirst()
+	unsubscribeFirst()
+	broker.Publish(LiveEventBackupCreated, "later.db")
+	if _, ok := <-first; ok {
+		t.Error("Expected the channel of an ended subscription to be closed")
+	}
+
+	// A subscriber that does not keep up misses events instead of blocking
+	for i := 0; i < liveEventBuffer*2; i++ {
+		broker.Publish(LiveEventPDFGenerated, i)
+	}
+	if got := len(second); got != liveEventBuffer {
+		t.Errorf("Expected %d buffered events, got %d", liveEventBuffer, got)
+	}
+
+	broker.Close()
+	for range second {
+	}
+	if _, ok := <-second; ok {
+		t.Error("Expected Close to close the channels")
+	}
+	if ch, _ := broker.Subscribe(); len(ch) != 0 {
+		t.Error("Expected no events after Close")
+	} else if _, ok := <-ch; ok {
+		t.Error("Expected subscribing to a closed broker to return a closed channel")
+	}
+
+	var nilBroker *EventBroker
+	nilBroker.Publish(LiveEventInvoiceStatus, nil)
+	nilBroker.Close()
+}
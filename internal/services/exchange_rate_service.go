@@ -0,0 +1,206 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrExchangeRateUnavailable is returned when the ECB has no reference rate
+// for a currency around the requested date
+var ErrExchangeRateUnavailable = errors.New("exchange rate unavailable")
+
+// ErrUnsupportedCurrency is returned for currencies the ECB publishes no
+// reference rates for
+var ErrUnsupportedCurrency = errors.New("unsupported currency")
+
+// ECB reference rates, replaced in tests. The 90 day file is small and covers
+// new invoices, the full history is only downloaded for older dates.
+var (
+	ecbRecentRatesURL  = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist-90d.xml"
+	ecbHistoryRatesURL = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist.xml"
+)
+
+// ecbRateGap is how far back a rate is looked up for dates without one, as
+// the ECB publishes no rates on weekends and TARGET holidays
+const ecbRateGap = 4 * 24 * time.Hour
+
+// ExchangeRateService provides the ECB euro foreign exchange reference rates,
+// cached in the database
+type ExchangeRateService struct {
+	dbService *DBService
+	logger    *Logger
+	client    *http.Client
+
+	mu     sync.Mutex
+	misses map[string]rateMiss // Failed lookups by currencies and date
+}
+
+// rateMiss remembers a lookup that failed after fetching the rates, so the
+// same lookup does not download the rates again until the next day
+type rateMiss struct {
+	day string
+	err error
+}
+
+// NewExchangeRateService creates a new ExchangeRateService
+func NewExchangeRateService(dbService *DBService, logger *Logger) *ExchangeRateService {
+	return &ExchangeRateService{
+		dbService: dbService,
+		logger:    logger,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		misses:    make(map[string]rateMiss),
+	}
+}
+
+// ecbEnvelope is the XML document of the ECB reference rates
+type ecbEnvelope struct {
+	Days []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string `xml:"currency,attr"`
+			Rate     string `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+// Rate returns how many units of the currency to one unit of the currency
+// from are worth on date, and the date of the reference rate used. That is
+// the last rate published on or before date.
+func (s *ExchangeRateService) Rate(from, to string, date time.Time) (float64, time.Time, error) {
+	if from == to {
+		return 1, date, nil
+	}
+
+	rate, rateDate, err := s.cachedRate(from, to, date)
+	if !isRateMiss(err) {
+		return rate, rateDate, err
+	}
+
+	key := from + "/" + to + "/" + date.Format("2006-01-02")
+	today := time.Now().Format("2006-01-02")
+	s.mu.Lock()
+	miss, ok := s.misses[key]
+	s.mu.Unlock()
+	if ok && miss.day == today {
+		return 0, time.Time{}, miss.err
+	}
+
+	url := ecbHistoryRatesURL
+	if time.Since(date) < 85*24*time.Hour {
+		url = ecbRecentRatesURL
+	}
+	if err := s.fetch(url); err != nil {
+		return 0, time.Time{}, err
+	}
+	rate, rateDate, err = s.cachedRate(from, to, date)
+	if isRateMiss(err) {
+		s.mu.Lock()
+		s.misses[key] = rateMiss{day: today, err: err}
+		s.mu.Unlock()
+	}
+	return rate, rateDate, err
+}
+
+// isRateMiss reports whether err means the cache has no rate for a lookup
+func isRateMiss(err error) bool {
+	return errors.Is(err, ErrExchangeRateUnavailable) || errors.Is(err, ErrUnsupportedCurrency)
+}
+
+// cachedRate computes the cross rate through the euro from the cached
+// reference rates
+func (s *ExchangeRateService) cachedRate(from, to string, date time.Time) (float64, time.Time, error) {
+	db := s.dbService.GetDB()
+	var day string
+	err := db.QueryRow(`SELECT COALESCE(MAX(date), '') FROM exchange_rates WHERE date <= ? AND date >= ?`,
+		date.Format("2006-01-02"), date.Add(-ecbRateGap).Format("2006-01-02")).Scan(&day)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to query exchange rates: %w", err)
+	}
+	if day == "" {
+		return 0, time.Time{}, ErrExchangeRateUnavailable
+	}
+
+	euroRate := func(currency string) (float64, error) {
+		if currency == "EUR" {
+			return 1, nil
+		}
+		var rate float64
+		err := db.QueryRow(`SELECT rate FROM exchange_rates WHERE date = ? AND currency = ?`, day, currency).Scan(&rate)
+		if errors.Is(err, sql.ErrNoRows) {
+			var known bool
+			if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM exchange_rates WHERE currency = ?)`, currency).Scan(&known); err != nil {
+				return 0, fmt.Errorf("failed to query exchange rates: %w", err)
+			}
+			if !known {
+				return 0, fmt.Errorf("%w %s", ErrUnsupportedCurrency, currency)
+			}
+			return 0, fmt.Errorf("%w for %s on %s", ErrExchangeRateUnavailable, currency, day)
+		}
+		return rate, err
+	}
+	fromRate, err := euroRate(from)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	toRate, err := euroRate(to)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	rateDate, _ := time.Parse("2006-01-02", day)
+	return toRate / fromRate, rateDate, nil
+}
+
+// fetch downloads reference rates from the ECB into the cache
+func (s *ExchangeRateService) fetch(url string) error {
+	s.logger.Info("Fetching ECB exchange rates from %s", url)
+	resp, err := s.client.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch exchange rates: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch exchange rates: %s", resp.Status)
+	}
+
+	var envelope ecbEnvelope
+	if err := xml.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("failed to parse exchange rates: %w", err)
+	}
+
+	tx, err := s.dbService.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`
+		INSERT INTO exchange_rates (date, currency, rate) VALUES (?, ?, ?)
+		ON CONFLICT (date, currency) DO UPDATE SET rate = excluded.rate
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, day := range envelope.Days {
+		if _, err := time.Parse("2006-01-02", day.Time); err != nil {
+			continue
+		}
+		for _, r := range day.Rates {
+			rate, err := strconv.ParseFloat(r.Rate, 64)
+			if err != nil || rate <= 0 {
+				continue
+			}
+			if _, err := stmt.Exec(day.Time, r.Currency, rate); err != nil {
+				return fmt.Errorf("failed to store exchange rate: %w", err)
+			}
+		}
+	}
+	return tx.Commit()
+}
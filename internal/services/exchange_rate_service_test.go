@@ -0,0 +1,82 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const ecbTestRates = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2024-03-01">
+			<Cube currency="USD" rate="1.0830"/>
+			<Cube currency="RON" rate="4.9710"/>
+		</Cube>
+		<Cube time="2024-02-29">
+			<Cube currency="USD" rate="1.0813"/>
+			<Cube currency="RON" rate="4.9700"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+func TestExchangeRates(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(ecbTestRates))
+	}))
+	defer server.Close()
+
+	oldRecent, oldHistory := ecbRecentRatesURL, ecbHistoryRatesURL
+	ecbRecentRatesURL, ecbHistoryRatesURL = server.URL, server.URL
+	defer func() { ecbRecentRatesURL, ecbHistoryRatesURL = oldRecent, oldHistory }()
+
+	rates := NewExchangeRateService(dbService, NewLogger(ERROR))
+
+	// A Sunday uses the rate of the Friday before
+	sunday := time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)
+	rate, rateDate, err := rates.Rate("USD", "EUR", sunday)
+	if err != nil {
+		t.Fatalf("Rate failed: %v", err)
+	}
+	if math.Abs(rate-1/1.0830) > 1e-9 || rateDate.Format("2006-01-02") != "2024-03-01" {
+		t.Errorf("Expected 1/1.0830 of 2024-03-01, got %v of %s", rate, rateDate.Format("2006-01-02"))
+	}
+
+	// Cross rates go through the euro and come from the cache
+	rate, _, err = rates.Rate("USD", "RON", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC))
+	if err != nil || math.Abs(rate-4.9700/1.0813) > 1e-9 {
+		t.Errorf("Expected a USD to RON rate of %v, got %v (%v)", 4.9700/1.0813, rate, err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected the rates to be fetched once, got %d requests", requests)
+	}
+
+	// Unknown currencies fetch the rates once a day
+	for i := 0; i < 2; i++ {
+		if _, _, err := rates.Rate("XYZ", "EUR", sunday); !errors.Is(err, ErrUnsupportedCurrency) {
+			t.Errorf("Expected ErrUnsupportedCurrency for an unknown currency, got %v", err)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("Expected one more request for the unknown currency, got %d requests", requests)
+	}
+
+	// So do dates without rates
+	for i := 0; i < 2; i++ {
+		if _, _, err := rates.Rate("USD", "EUR", time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)); !errors.Is(err, ErrExchangeRateUnavailable) {
+			t.Errorf("Expected ErrExchangeRateUnavailable for a date without rates, got %v", err)
+		}
+	}
+	if requests != 3 {
+		t.Errorf("Expected one more request for the date without rates, got %d requests", requests)
+	}
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/refdata"
+)
+
+// defaultExpenseRates are the built-in expense rates by country code, from
+// the tax-free allowances for business travel by car and for a full day away
+var defaultExpenseRates = map[string]models.ExpenseRates{
+	"AT": {Currency: "EUR", MileageRate: 50, PerDiem: 3000},
+	"DE": {Currency: "EUR", MileageRate: 30, PerDiem: 2800},
+	"GB": {Currency: "GBP", MileageRate: 28}, // 45p per mile
+	"NL": {Currency: "EUR", MileageRate: 23},
+}
+
+// ErrExpenseRatesNotFound is returned when deleting rates that were never customized
+var ErrExpenseRatesNotFound = errors.New("expense rates not found")
+
+// ExpenseRateService manages the mileage and per-diem rates customized per
+// country, which the invoice form offers for travel expense items
+type ExpenseRateService struct {
+	dbService *DBService
+	logger    *Logger
+}
+
+// NewExpenseRateService creates a new ExpenseRateService
+func NewExpenseRateService(dbService *DBService, logger *Logger) *ExpenseRateService {
+	return &ExpenseRateService{
+		dbService: dbService,
+		logger:    logger,
+	}
+}
+
+// List returns the rates of every country with some, the customized rates
+// or else the built-in ones, ordered by country
+func (s *ExpenseRateService) List() ([]models.ExpenseRates, error) {
+	rows, err := s.dbService.GetDB().Query(`
+		SELECT country, currency, mileage_rate, per_diem, vat_exempt, updated_at FROM expense_rates ORDER BY country
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expense rates: %w", err)
+	}
+	defer rows.Close()
+
+	rates := map[string]models.ExpenseRates{}
+	for rows.Next() {
+		entry, err := scanExpenseRates(rows)
+		if err != nil {
+			return nil, err
+		}
+		rates[entry.Country] = *entry
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for country := range defaultExpenseRates {
+		if _, ok := rates[country]; !ok {
+			rates[country] = *builtInExpenseRates(country)
+		}
+	}
+
+	list := []models.ExpenseRates{}
+	for _, country := range slices.Sorted(maps.Keys(rates)) {
+		list = append(list, rates[country])
+	}
+	return list, nil
+}
+
+// Rates returns the rates of a country, the customized rates or else the
+// built-in ones, or nil if the country has none
+func (s *ExpenseRateService) Rates(country string) (*models.ExpenseRates, error) {
+	country = refdata.NormalizeCountryCode(country)
+	rates, err := scanExpenseRates(s.dbService.GetDB().QueryRow(`
+		SELECT country, currency, mileage_rate, per_diem, vat_exempt, updated_at FROM expense_rates WHERE country = ?
+	`, country))
+	if errors.Is(err, sql.ErrNoRows) {
+		return builtInExpenseRates(country), nil
+	}
+	return rates, err
+}
+
+// builtInExpenseRates returns the built-in rates of a country, or nil
+func builtInExpenseRates(country string) *models.ExpenseRates {
+	rates, ok := defaultExpenseRates[country]
+	if !ok {
+		return nil
+	}
+	rates.Country = country
+	rates.BuiltIn = true
+	return &rates
+}
+
+// scanExpenseRates scans an expense_rates row
+func scanExpenseRates(row rowScanner) (*models.ExpenseRates, error) {
+	var rates models.ExpenseRates
+	var vatExempt int
+	if err := row.Scan(&rates.Country, &rates.Currency, &rates.MileageRate, &rates.PerDiem, &vatExempt, &rates.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan expense rates: %w", err)
+	}
+	rates.VatExempt = vatExempt != 0
+	return &rates, nil
+}
+
+// Save stores customized rates for a country, replacing the previous or
+// built-in ones
+func (s *ExpenseRateService) Save(rates *models.ExpenseRates) error {
+	rates.Country = refdata.NormalizeCountryCode(rates.Country)
+	if !countryCodePattern.MatchString(rates.Country) {
+		return fmt.Errorf("%q is not a two-letter country code like DE", rates.Country)
+	}
+	rates.Currency = strings.ToUpper(strings.TrimSpace(rates.Currency))
+	if !refdata.IsCurrencyCode(rates.Currency) {
+		return fmt.Errorf("unsupported currency %q", rates.Currency)
+	}
+	if rates.MileageRate < 0 || rates.PerDiem < 0 {
+		return errors.New("rates must not be negative")
+	}
+
+	rates.BuiltIn = false
+	rates.UpdatedAt = time.Now().UTC()
+	_, err := s.dbService.GetDB().Exec(`
+		INSERT INTO expense_rates (country, currency, mileage_rate, per_diem, vat_exempt, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (country) DO UPDATE SET currency = excluded.currency, mileage_rate = excluded.mileage_rate, per_diem = excluded.per_diem,
+			vat_exempt = excluded.vat_exempt, updated_at = excluded.updated_at
+	`, rates.Country, rates.Currency, rates.MileageRate, rates.PerDiem, boolToInt(rates.VatExempt), rates.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save expense rates: %w", err)
+	}
+
+	s.logger.Info("Saved expense rates for country %s", rates.Country)
+	return nil
+}
+
+// Delete removes the customized rates of a country; the built-in rates apply
+// again, if any
+func (s *ExpenseRateService) Delete(country string) error {
+	result, err := s.dbService.GetDB().Exec(`DELETE FROM expense_rates WHERE country = ?`, refdata.NormalizeCountryCode(country))
+	if err != nil {
+		return fmt.Errorf("failed to delete expense rates: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrExpenseRatesNotFound
+	}
+
+	s.logger.Info("Deleted expense rates for country %s", country)
+	return nil
+}
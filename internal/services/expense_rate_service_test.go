@@ -0,0 +1,54 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestExpenseRates(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+	expenses := NewExpenseRateService(dbService, NewLogger(ERROR))
+
+	list, err := expenses.List()
+	if err != nil || len(list) != 4 || list[0].Country != "AT" || !list[0].BuiltIn {
+		t.Fatalf("Expected the built-in rates, got %+v (%v)", list, err)
+	}
+	if rates, err := expenses.Rates("de"); err != nil || rates == nil || rates.MileageRate != 30 || rates.PerDiem != 2800 {
+		t.Errorf("Expected the German rates, got %+v (%v)", rates, err)
+	}
+	if rates, err := expenses.Rates("US"); err != nil || rates != nil {
+		t.Errorf("Expected no rates for the US, got %+v (%v)", rates, err)
+	}
+
+	for _, invalid := range []models.ExpenseRates{
+		{Country: "Germany", Currency: "EUR"},
+		{Country: "DE", Currency: "XXX"},
+		{Country: "DE", Currency: "EUR", PerDiem: -1},
+	} {
+		if err := expenses.Save(&invalid); err == nil {
+			t.Errorf("Expected %+v to be refused", invalid)
+		}
+	}
+	if err := expenses.Save(&models.ExpenseRates{Country: "de", Currency: "eur", MileageRate: 38, PerDiem: 2800, VatExempt: true}); err != nil {
+		t.Fatalf("Failed to save rates: %v", err)
+	}
+	if rates, _ := expenses.Rates("DE"); rates == nil || rates.MileageRate != 38 || !rates.VatExempt || rates.BuiltIn {
+		t.Errorf("Expected the customized rates, got %+v", rates)
+	}
+	if list, _ := expenses.List(); len(list) != 4 || list[1].Country != "DE" || list[1].BuiltIn {
+		t.Errorf("Expected the customized rates in place of the built-in ones, got %+v", list)
+	}
+
+	if err := expenses.Delete("DE"); err != nil {
+		t.Fatalf("Failed to delete rates: %v", err)
+	}
+	if err := expenses.Delete("DE"); !errors.Is(err, ErrExpenseRatesNotFound) {
+		t.Errorf("Expected ErrExpenseRatesNotFound, got %v", err)
+	}
+	if rates, _ := expenses.Rates("DE"); rates == nil || !rates.BuiltIn {
+		t.Errorf("Expected the built-in rates again, got %+v", rates)
+	}
+}
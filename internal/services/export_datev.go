@@ -0,0 +1,155 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// datevDebtorOffset is added to client IDs to number their debtor accounts,
+// which DATEV expects to have one digit more than the general ledger accounts
+const datevDebtorOffset = 10000
+
+// datevColumns are the leading columns of a DATEV booking batch, in the order
+// the format defines them. Later columns are optional and left out.
+var datevColumns = []string{
+	"Umsatz (ohne Soll/Haben-Kz)", "Soll/Haben-Kennzeichen", "WKZ Umsatz", "Kurs", "Basis-Umsatz", "WKZ Basis-Umsatz",
+	"Konto", "Gegenkonto (ohne BU-Schlüssel)", "BU-Schlüssel", "Belegdatum", "Belegfeld 1", "Belegfeld 2", "Skonto", "Buchungstext",
+}
+
+// datevBooking is a row of a DATEV booking batch
+type datevBooking struct {
+	amount        models.Money
+	currency      string
+	rate          float64 // Currency units per euro, 0 for bookings in euros or without a known rate
+	baseAmount    models.Money
+	account       string
+	contraAccount string
+	date          time.Time
+	reference     string    // Belegfeld 1, the invoice number
+	dueDate       time.Time // Belegfeld 2, for the open items of the debtor
+	text          string
+}
+
+// writeDATEV writes the invoices issued and the payments received in the
+// period as a DATEV booking batch (EXTF Buchungsstapel, format version 700),
+// the CSV format German tax advisors import into DATEV. Invoices are booked
+// from the client's debtor account to the revenue account matching their VAT,
+// payments from the bank account to the debtor account.
+func (s *ExportService) writeDATEV(w io.Writer, invoices []models.Invoice, clients *exportClients, period ExportPeriod) (int, error) {
+	revenueAccount := s.settings.GetString(SettingDATEVRevenueAccount)
+	bankAccount := s.settings.GetString(SettingDATEVBankAccount)
+	var bookings []datevBooking
+	count := 0
+	for _, invoice := range invoices {
+		if !isBooked(&invoice) {
+			continue
+		}
+		client, err := clients.get(&invoice)
+		if err != nil {
+			return 0, err
+		}
+		debtor := strconv.Itoa(datevDebtorOffset + invoice.ClientID)
+
+		if period.Contains(invoice.IssueDate) {
+			contraAccount := revenueAccount
+			switch {
+			case invoice.ReverseChargeVat:
+				contraAccount = s.settings.GetString(SettingDATEVReverseChargeAccount)
+			case invoice.VatAmount == 0:
+				contraAccount = s.settings.GetString(SettingDATEVTaxFreeAccount)
+			}
+			booking := newDATEVBooking(&invoice, invoice.TotalAmount, debtor, contraAccount, invoice.IssueDate, client.Name)
+			booking.dueDate = invoice.DueDate
+			bookings = append(bookings, booking)
+			count++
+		}
+		// A cancellation settles the invoice it cancels, no money is received
+		if invoice.Status == models.InvoiceStatusPaid && !invoice.IsSettledByCancellation() && !invoice.PaidDate.IsZero() && period.Contains(invoice.PaidDate) && invoice.AmountDue() != 0 {
+			bookings = append(bookings, newDATEVBooking(&invoice, invoice.AmountDue(), bankAccount, debtor, invoice.PaidDate, client.Name))
+		}
+	}
+
+	// DATEV reads the Windows code page; characters it lacks become question marks
+	buffered := bufio.NewWriter(encoding.ReplaceUnsupported(charmap.Windows1252.NewEncoder()).Writer(w))
+	header := []string{
+		`"EXTF"`, "700", "21", `"Buchungsstapel"`, "13", time.Now().Format("20060102150405000"), "", `"RE"`, `""`, `""`,
+		s.settings.GetString(SettingDATEVConsultantNumber), s.settings.GetString(SettingDATEVClientNumber),
+		period.From.Format("2006") + "0101", strconv.Itoa(len(revenueAccount)),
+		period.From.Format("20060102"), period.To.Format("20060102"),
+		datevText("Rechnungen "+period.From.Format("02.01.2006")+" - "+period.To.Format("02.01.2006"), 30),
+		`""`, "1", "0", "0", `"EUR"`, "", `""`, "", "", `""`, "", "", "", `""`,
+	}
+	fmt.Fprint(buffered, strings.Join(header, ";")+"\r\n")
+	fmt.Fprint(buffered, strings.Join(datevColumns, ";")+"\r\n")
+	for _, booking := range bookings {
+		fmt.Fprint(buffered, booking.row()+"\r\n")
+	}
+	if err := buffered.Flush(); err != nil {
+		return 0, fmt.Errorf("failed to write DATEV export: %w", err)
+	}
+	return count, nil
+}
+
+// newDATEVBooking books an amount of an invoice. Amounts in other currencies
+// carry the euro amount when the invoice was converted to euros.
+func newDATEVBooking(invoice *models.Invoice, amount models.Money, account, contraAccount string, date time.Time, text string) datevBooking {
+	booking := datevBooking{
+		amount:        amount,
+		currency:      invoice.Currency,
+		account:       account,
+		contraAccount: contraAccount,
+		date:          date,
+		reference:     invoice.InvoiceNumber,
+		text:          text,
+	}
+	if invoice.Currency != "EUR" && invoice.HasExchangeRate() && invoice.HomeCurrency == "EUR" {
+		booking.rate = 1 / invoice.ExchangeRate
+		booking.baseAmount = invoice.ToHomeCurrency(amount)
+	}
+	return booking
+}
+
+// row formats the booking as a line of the batch. Amounts are positive with
+// the debit or credit flag; credits and refunds are credited to the account.
+func (b datevBooking) row() string {
+	side, amount, baseAmount := `"S"`, b.amount, b.baseAmount
+	if amount < 0 {
+		side, amount, baseAmount = `"H"`, -amount, -baseAmount
+	}
+	rate, base, baseCurrency := "", "", `""`
+	if b.rate > 0 {
+		rate = strings.Replace(strconv.FormatFloat(b.rate, 'f', 6, 64), ".", ",", 1)
+		base, baseCurrency = datevAmount(baseAmount), `"EUR"`
+	}
+	dueDate := `""`
+	if !b.dueDate.IsZero() {
+		dueDate = `"` + b.dueDate.Format("020106") + `"`
+	}
+	return strings.Join([]string{
+		datevAmount(amount), side, datevText(b.currency, 3), rate, base, baseCurrency,
+		b.account, b.contraAccount, `""`, b.date.Format("0201"),
+		datevText(b.reference, 36), dueDate, "", datevText(b.text, 60),
+	}, ";")
+}
+
+// datevAmount formats an amount with a decimal comma, e.g. 1190,00
+func datevAmount(amount models.Money) string {
+	return strings.Replace(amount.String(), ".", ",", 1)
+}
+
+// datevText quotes a text field, cut to the length DATEV allows
+func datevText(text string, maxLength int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > maxLength {
+		text = string(runes[:maxLength])
+	}
+	return `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+}
@@ -0,0 +1,395 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// saftNamespace is the namespace of the OECD Standard Audit File for Tax 2.0
+// the export follows
+const saftNamespace = "urn:OECD:StandardAuditFile-Tax:2.00"
+
+// saftAuditFile is the root of a SAF-T file. Only the elements the invoices
+// provide data for are written.
+type saftAuditFile struct {
+	XMLName         xml.Name            `xml:"AuditFile"`
+	Namespace       string              `xml:"xmlns,attr"`
+	Header          saftHeader          `xml:"Header"`
+	MasterFiles     saftMasterFiles     `xml:"MasterFiles"`
+	SourceDocuments saftSourceDocuments `xml:"SourceDocuments"`
+}
+
+type saftHeader struct {
+	AuditFileVersion     string      `xml:"AuditFileVersion"`
+	AuditFileCountry     string      `xml:"AuditFileCountry"`
+	AuditFileDateCreated string      `xml:"AuditFileDateCreated"`
+	SoftwareCompanyName  string      `xml:"SoftwareCompanyName"`
+	SoftwareID           string      `xml:"SoftwareID"`
+	SoftwareVersion      string      `xml:"SoftwareVersion"`
+	Company              saftCompany `xml:"Company"`
+	DefaultCurrencyCode  string      `xml:"DefaultCurrencyCode"`
+	SelectionStartDate   string      `xml:"SelectionCriteria>SelectionStartDate"`
+	SelectionEndDate     string      `xml:"SelectionCriteria>SelectionEndDate"`
+	TaxAccountingBasis   string      `xml:"TaxAccountingBasis"`
+}
+
+// saftCompany describes the business, or a customer in the master files
+type saftCompany struct {
+	Name            string               `xml:"Name"`
+	Address         saftAddress          `xml:"Address"`
+	TaxRegistration *saftTaxRegistration `xml:"TaxRegistration,omitempty"`
+}
+
+type saftAddress struct {
+	StreetName string `xml:"StreetName,omitempty"`
+	City       string `xml:"City,omitempty"`
+	PostalCode string `xml:"PostalCode,omitempty"`
+	Country    string `xml:"Country,omitempty"`
+}
+
+type saftTaxRegistration struct {
+	TaxRegistrationNumber string `xml:"TaxRegistrationNumber"`
+	TaxType               string `xml:"TaxType"`
+}
+
+type saftMasterFiles struct {
+	Customers []saftCustomer    `xml:"Customers>Customer"`
+	TaxTable  []saftTaxCodeInfo `xml:"TaxTable>TaxTableEntry>TaxCodeDetails"`
+}
+
+type saftCustomer struct {
+	saftCompany
+	CustomerID string `xml:"CustomerID"`
+}
+
+type saftTaxCodeInfo struct {
+	TaxCode       string  `xml:"TaxCode"`
+	Description   string  `xml:"Description"`
+	TaxPercentage float64 `xml:"TaxPercentage"`
+}
+
+type saftSourceDocuments struct {
+	SalesInvoices saftSalesInvoices `xml:"SalesInvoices"`
+	Payments      saftPayments      `xml:"Payments"`
+}
+
+type saftSalesInvoices struct {
+	NumberOfEntries int           `xml:"NumberOfEntries"`
+	TotalDebit      saftMoney     `xml:"TotalDebit"`
+	TotalCredit     saftMoney     `xml:"TotalCredit"`
+	Invoices        []saftInvoice `xml:"Invoice"`
+}
+
+type saftInvoice struct {
+	InvoiceNo      string             `xml:"InvoiceNo"`
+	CustomerID     string             `xml:"CustomerInfo>CustomerID"`
+	BillingAddress saftAddress        `xml:"CustomerInfo>BillingAddress"`
+	Period         int                `xml:"Period"`
+	PeriodYear     int                `xml:"PeriodYear"`
+	InvoiceDate    string             `xml:"InvoiceDate"`
+	InvoiceType    string             `xml:"InvoiceType"`
+	GLPostingDate  string             `xml:"GLPostingDate"`
+	Lines          []saftInvoiceLine  `xml:"Line"`
+	DocumentTotals saftDocumentTotals `xml:"DocumentTotals"`
+}
+
+type saftInvoiceLine struct {
+	LineNumber           int            `xml:"LineNumber"`
+	Quantity             float64        `xml:"Quantity"`
+	UnitOfMeasure        string         `xml:"UnitOfMeasure"`
+	UnitPrice            saftMoney      `xml:"UnitPrice"`
+	TaxPointDate         string         `xml:"TaxPointDate"`
+	Description          string         `xml:"Description"`
+	InvoiceLineAmount    saftAmount     `xml:"InvoiceLineAmount"`
+	DebitCreditIndicator string         `xml:"DebitCreditIndicator"`
+	TaxInformation       saftTaxDetails `xml:"TaxInformation"`
+}
+
+type saftDocumentTotals struct {
+	TaxInformationTotals saftTaxDetails `xml:"TaxInformationTotals"`
+	SettlementAmount     *saftMoney     `xml:"Settlement>SettlementAmount,omitempty"` // Invoice discount
+	NetTotal             saftMoney      `xml:"NetTotal"`
+	GrossTotal           saftMoney      `xml:"GrossTotal"`
+}
+
+type saftTaxDetails struct {
+	TaxType       string      `xml:"TaxType"`
+	TaxCode       string      `xml:"TaxCode"`
+	TaxPercentage float64     `xml:"TaxPercentage"`
+	TaxBase       *saftAmount `xml:"TaxBase,omitempty"`
+	TaxAmount     *saftAmount `xml:"TaxAmount,omitempty"`
+}
+
+// saftMoney is an amount written with two decimals, like 1190.00
+type saftMoney models.Money
+
+// MarshalText formats the amount like Money.String
+func (m saftMoney) MarshalText() ([]byte, error) {
+	return []byte(models.Money(m).String()), nil
+}
+
+// saftAmount is an amount in the default currency, with the amount in the
+// invoice currency when it differs
+type saftAmount struct {
+	Amount         saftMoney  `xml:"Amount"`
+	CurrencyCode   string     `xml:"CurrencyCode,omitempty"`
+	CurrencyAmount *saftMoney `xml:"CurrencyAmount,omitempty"`
+	ExchangeRate   float64    `xml:"ExchangeRate,omitempty"`
+}
+
+type saftPayments struct {
+	NumberOfEntries int           `xml:"NumberOfEntries"`
+	TotalDebit      saftMoney     `xml:"TotalDebit"`
+	TotalCredit     saftMoney     `xml:"TotalCredit"`
+	Payments        []saftPayment `xml:"Payment"`
+}
+
+type saftPayment struct {
+	PaymentRefNo         string     `xml:"PaymentRefNo"`
+	Period               int        `xml:"Period"`
+	PeriodYear           int        `xml:"PeriodYear"`
+	TransactionDate      string     `xml:"TransactionDate"`
+	Description          string     `xml:"Description"`
+	LineNumber           int        `xml:"Line>LineNumber"`
+	SourceDocumentID     string     `xml:"Line>SourceDocumentID"`
+	CustomerID           string     `xml:"Line>CustomerID"`
+	DebitCreditIndicator string     `xml:"Line>DebitCreditIndicator"`
+	PaymentLineAmount    saftAmount `xml:"Line>PaymentLineAmount"`
+	GrossTotal           saftMoney  `xml:"DocumentTotals>GrossTotal"`
+}
+
+// writeSAFT writes the invoices issued and the payments received in the
+// period as an OECD Standard Audit File for Tax (SAF-T 2.0), with the clients
+// and VAT rates they use. Cancellation invoices are written as credit notes.
+// Countries that require SAF-T, such as Portugal, Romania and Norway, define
+// their own variants of the schema with additional data, so the file may need
+// to be completed by an accountant before it is filed.
+func (s *ExportService) writeSAFT(w io.Writer, invoices []models.Invoice, clients *exportClients, period ExportPeriod) (int, error) {
+	businesses, err := s.store.GetBusinesses()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load business: %w", err)
+	}
+	var business models.Business
+	if len(businesses) > 0 {
+		business = businesses[0]
+	}
+	currency := business.Currency
+	if currency == "" {
+		currency = "EUR"
+	}
+
+	file := saftAuditFile{
+		Namespace: saftNamespace,
+		Header: saftHeader{
+			AuditFileVersion:     "2.00",
+			AuditFileCountry:     business.Country,
+			AuditFileDateCreated: time.Now().Format("2006-01-02"),
+			SoftwareCompanyName:  "Simple Invoice",
+			SoftwareID:           "Simple Invoice",
+			SoftwareVersion:      softwareVersion(),
+			Company:              saftCompanyOf(business.Name, business.Address, business.City, business.PostalCode, business.Country, business.VatID),
+			DefaultCurrencyCode:  currency,
+			SelectionStartDate:   period.From.Format("2006-01-02"),
+			SelectionEndDate:     period.To.Format("2006-01-02"),
+			TaxAccountingBasis:   "Invoice",
+		},
+	}
+
+	customers := map[int]bool{}
+	taxCodes := map[string]bool{}
+	addCustomer := func(invoice *models.Invoice) (models.Client, error) {
+		client, err := clients.get(invoice)
+		if err != nil || customers[invoice.ClientID] {
+			return client, err
+		}
+		customers[invoice.ClientID] = true
+		file.MasterFiles.Customers = append(file.MasterFiles.Customers, saftCustomer{
+			saftCompany: saftCompanyOf(client.Name, client.Address, client.City, client.PostalCode, client.Country, client.VatID),
+			CustomerID:  strconv.Itoa(invoice.ClientID),
+		})
+		return client, nil
+	}
+
+	sales := &file.SourceDocuments.SalesInvoices
+	payments := &file.SourceDocuments.Payments
+	for _, summary := range invoices {
+		if !isBooked(&summary) {
+			continue
+		}
+		// A cancellation settles the invoice it cancels, no payment is made
+		paid := summary.Status == models.InvoiceStatusPaid && !summary.IsSettledByCancellation() && !summary.PaidDate.IsZero() && period.Contains(summary.PaidDate)
+		issued := period.Contains(summary.IssueDate)
+		if !issued && !paid {
+			continue
+		}
+		invoice, items, err := s.store.GetInvoice(summary.ID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to load invoice %s: %w", summary.InvoiceNumber, err)
+		}
+		client, err := addCustomer(invoice)
+		if err != nil {
+			return 0, err
+		}
+
+		if issued {
+			// Cancellation invoices are credit notes: their amounts are
+			// written as positive debits of the sales they reverse
+			invoiceType, indicator, sign := "Invoice", "C", models.Money(1)
+			if invoice.IsStorno() {
+				invoiceType, indicator, sign = "CreditNote", "D", -1
+			}
+			tax := saftTaxOf(invoice)
+			if !taxCodes[tax.TaxCode] {
+				taxCodes[tax.TaxCode] = true
+				file.MasterFiles.TaxTable = append(file.MasterFiles.TaxTable, saftTaxCodeInfo{TaxCode: tax.TaxCode, Description: saftTaxDescription(invoice), TaxPercentage: tax.TaxPercentage})
+			}
+			saftInvoice := saftInvoice{
+				InvoiceNo:      invoice.InvoiceNumber,
+				CustomerID:     strconv.Itoa(invoice.ClientID),
+				BillingAddress: saftAddress{StreetName: client.Address, City: client.City, PostalCode: client.PostalCode, Country: client.Country},
+				Period:         int(invoice.IssueDate.Month()),
+				PeriodYear:     invoice.IssueDate.Year(),
+				InvoiceDate:    invoice.IssueDate.Format("2006-01-02"),
+				InvoiceType:    invoiceType,
+				GLPostingDate:  invoice.IssueDate.Format("2006-01-02"),
+			}
+			for i, item := range items {
+				// Items outside the VAT base of an invoice with VAT are exempt
+				lineTax := tax
+				if item.VatExempt && invoice.HasMixedVat(items) {
+					lineTax = saftTaxDetails{TaxType: "VAT", TaxCode: "EX"}
+					if !taxCodes[lineTax.TaxCode] {
+						taxCodes[lineTax.TaxCode] = true
+						file.MasterFiles.TaxTable = append(file.MasterFiles.TaxTable, saftTaxCodeInfo{TaxCode: lineTax.TaxCode, Description: "Exempt from VAT"})
+					}
+				}
+				saftInvoice.Lines = append(saftInvoice.Lines, saftInvoiceLine{
+					LineNumber:           i + 1,
+					Quantity:             item.Quantity * float64(sign),
+					UnitOfMeasure:        item.Unit,
+					UnitPrice:            saftMoney(item.UnitPrice),
+					TaxPointDate:         invoice.IssueDate.Format("2006-01-02"),
+					Description:          item.Description,
+					InvoiceLineAmount:    saftAmountOf(invoice, item.Amount*sign, currency),
+					DebitCreditIndicator: indicator,
+					TaxInformation:       lineTax,
+				})
+			}
+			totals := invoice.CalculateTotals(items)
+			taxBase, taxAmount := saftAmountOf(invoice, (totals.Subtotal-totals.NonTaxable)*sign, currency), saftAmountOf(invoice, invoice.VatAmount*sign, currency)
+			tax.TaxBase, tax.TaxAmount = &taxBase, &taxAmount
+			saftInvoice.DocumentTotals = saftDocumentTotals{
+				TaxInformationTotals: tax,
+				NetTotal:             saftMoney(totals.Subtotal * sign),
+				GrossTotal:           saftMoney(invoice.TotalAmount * sign),
+			}
+			if totals.Discount*sign > 0 {
+				discount := saftMoney(totals.Discount * sign)
+				saftInvoice.DocumentTotals.SettlementAmount = &discount
+			}
+			sales.Invoices = append(sales.Invoices, saftInvoice)
+			if invoice.IsStorno() {
+				sales.TotalDebit += saftAmountOf(invoice, -totals.Subtotal, currency).Amount
+			} else {
+				sales.TotalCredit += saftAmountOf(invoice, totals.Subtotal, currency).Amount
+			}
+		}
+
+		if paid && invoice.AmountDue() != 0 {
+			amount := saftAmountOf(invoice, invoice.AmountDue(), currency)
+			payments.Payments = append(payments.Payments, saftPayment{
+				PaymentRefNo:         "PAY-" + invoice.InvoiceNumber,
+				Period:               int(invoice.PaidDate.Month()),
+				PeriodYear:           invoice.PaidDate.Year(),
+				TransactionDate:      invoice.PaidDate.Format("2006-01-02"),
+				Description:          "Payment of invoice " + invoice.InvoiceNumber,
+				LineNumber:           1,
+				SourceDocumentID:     invoice.InvoiceNumber,
+				CustomerID:           strconv.Itoa(invoice.ClientID),
+				DebitCreditIndicator: "C",
+				PaymentLineAmount:    amount,
+				GrossTotal:           amount.Amount,
+			})
+			payments.TotalCredit += amount.Amount
+		}
+	}
+	sales.NumberOfEntries = len(sales.Invoices)
+	payments.NumberOfEntries = len(payments.Payments)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return 0, fmt.Errorf("failed to write SAF-T export: %w", err)
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(file); err != nil {
+		return 0, fmt.Errorf("failed to write SAF-T export: %w", err)
+	}
+	return sales.NumberOfEntries, nil
+}
+
+// saftCompanyOf describes a business or client
+func saftCompanyOf(name, address, city, postalCode, country, vatID string) saftCompany {
+	company := saftCompany{
+		Name:    name,
+		Address: saftAddress{StreetName: address, City: city, PostalCode: postalCode, Country: country},
+	}
+	if vatID != "" {
+		company.TaxRegistration = &saftTaxRegistration{TaxRegistrationNumber: vatID, TaxType: "VAT"}
+	}
+	return company
+}
+
+// saftTaxOf returns the VAT code of an invoice: RC for reverse charge, EX
+// without VAT, and the rate otherwise
+func saftTaxOf(invoice *models.Invoice) saftTaxDetails {
+	tax := saftTaxDetails{TaxType: "VAT", TaxPercentage: invoice.VatRate}
+	switch {
+	case invoice.ReverseChargeVat:
+		tax.TaxCode, tax.TaxPercentage = "RC", 0
+	case invoice.VatAmount == 0:
+		tax.TaxCode, tax.TaxPercentage = "EX", 0
+	default:
+		tax.TaxCode = "S" + strconv.FormatFloat(invoice.VatRate, 'f', -1, 64)
+	}
+	return tax
+}
+
+// saftTaxDescription describes the VAT code of an invoice in the tax table
+func saftTaxDescription(invoice *models.Invoice) string {
+	switch {
+	case invoice.ReverseChargeVat:
+		return "Reverse charge, VAT due by the customer"
+	case invoice.VatAmount == 0:
+		return "Exempt from VAT"
+	}
+	return fmt.Sprintf("VAT at %s%%", strconv.FormatFloat(invoice.VatRate, 'f', -1, 64))
+}
+
+// saftAmountOf converts an amount of an invoice to the default currency.
+// Amounts that cannot be converted stay in the invoice currency.
+func saftAmountOf(invoice *models.Invoice, amount models.Money, currency string) saftAmount {
+	if invoice.Currency == currency {
+		return saftAmount{Amount: saftMoney(amount)}
+	}
+	currencyAmount := saftMoney(amount)
+	converted := saftAmount{Amount: currencyAmount, CurrencyCode: invoice.Currency, CurrencyAmount: &currencyAmount}
+	if invoice.HasExchangeRate() && invoice.HomeCurrency == currency {
+		converted.Amount, converted.ExchangeRate = saftMoney(invoice.ToHomeCurrency(amount)), invoice.ExchangeRate
+	}
+	return converted
+}
+
+// softwareVersion returns the version of the module the binary was built
+// from, as go install records it
+func softwareVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
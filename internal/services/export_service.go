@@ -0,0 +1,243 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// Supported export formats. DATEV and SAF-T are bookkeeping formats for
+// accountants and tax authorities.
+const (
+	ExportFormatCSV   = "csv"
+	ExportFormatDATEV = "datev"
+	ExportFormatSAFT  = "saft"
+)
+
+// ExportFormats lists the supported export formats
+var ExportFormats = []string{ExportFormatCSV, ExportFormatDATEV, ExportFormatSAFT}
+
+// ErrInvalidExportPeriod is returned when the period of an export is missing
+// or not supported by the format
+var ErrInvalidExportPeriod = errors.New("invalid export period")
+
+// ExportPeriod limits an export to the invoices issued and the payments
+// received from From to To, both inclusive. Zero dates leave the period open.
+type ExportPeriod struct {
+	From time.Time
+	To   time.Time
+}
+
+// IsSet reports whether the period has a start and an end
+func (p ExportPeriod) IsSet() bool {
+	return !p.From.IsZero() && !p.To.IsZero()
+}
+
+// Contains reports whether a date falls in the period
+func (p ExportPeriod) Contains(date time.Time) bool {
+	day := date.Format("2006-01-02")
+	return (p.From.IsZero() || day >= p.From.Format("2006-01-02")) && (p.To.IsZero() || day <= p.To.Format("2006-01-02"))
+}
+
+// invoiceExportHeader is the header of invoice CSV exports. The columns are
+// read by the generic invoice import, so an export can be imported elsewhere.
+var invoiceExportHeader = []string{
+	"invoice_number", "type", "client", "client_vat_id", "issue_date", "due_date", "paid_date",
+	"currency", "net", "vat_amount", "total", "credit_applied", "balance", "status", "notes",
+}
+
+// ExportService exports invoices for accountants and other tools
+type ExportService struct {
+	store    Store
+	settings *SettingsService
+	logger   *Logger
+}
+
+// NewExportService creates a new ExportService
+func NewExportService(store Store, settings *SettingsService, logger *Logger) *ExportService {
+	return &ExportService{
+		store:    store,
+		settings: settings,
+		logger:   logger,
+	}
+}
+
+// ValidateExportPeriod checks that a period can be exported in a format.
+// DATEV and SAF-T exports need a start and end date, and DATEV exports cover
+// one fiscal year at most, as their booking dates only carry day and month.
+func ValidateExportPeriod(format string, period ExportPeriod) error {
+	switch {
+	case !period.From.IsZero() && !period.To.IsZero() && period.To.Before(period.From):
+		return fmt.Errorf("%w: it ends before it starts", ErrInvalidExportPeriod)
+	case format != ExportFormatCSV && !period.IsSet():
+		return fmt.Errorf("%w: %s exports need a start and end date", ErrInvalidExportPeriod, format)
+	case format == ExportFormatDATEV && period.From.Year() != period.To.Year():
+		return fmt.Errorf("%w: DATEV exports cannot span more than one year", ErrInvalidExportPeriod)
+	}
+	return nil
+}
+
+// ExportFilename returns the name of the file an export is downloaded as
+func ExportFilename(format string, period ExportPeriod) string {
+	name := "invoices"
+	if period.IsSet() {
+		name += "-" + period.From.Format("20060102") + "-" + period.To.Format("20060102")
+	}
+	switch format {
+	case ExportFormatDATEV:
+		// DATEV recognizes imports by the EXTF_ prefix
+		return "EXTF_Buchungsstapel_" + name + ".csv"
+	case ExportFormatSAFT:
+		return "SAF-T_" + name + ".xml"
+	}
+	return name + ".csv"
+}
+
+// ExportInvoices writes the invoices of the period in the given format
+// ordered by issue date and number, and returns the number of invoices
+// written. CSV exports contain all invoices, including pro-forma invoices, and
+// the whole history when the period is open. DATEV and SAF-T exports are
+// bookkeeping records: they contain the finalized invoices issued and the
+// payments received in the period, which must be set.
+func (s *ExportService) ExportInvoices(w io.Writer, format string, period ExportPeriod) (int, error) {
+	if !slices.Contains(ExportFormats, format) {
+		return 0, fmt.Errorf("unsupported export format: %s", format)
+	}
+	if err := ValidateExportPeriod(format, period); err != nil {
+		return 0, err
+	}
+
+	invoices, err := s.store.GetInvoices()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load invoices: %w", err)
+	}
+	sort.SliceStable(invoices, func(i, j int) bool {
+		if !invoices[i].IssueDate.Equal(invoices[j].IssueDate) {
+			return invoices[i].IssueDate.Before(invoices[j].IssueDate)
+		}
+		return invoices[i].InvoiceNumber < invoices[j].InvoiceNumber
+	})
+	clients, err := s.loadClients()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	switch format {
+	case ExportFormatDATEV:
+		count, err = s.writeDATEV(w, invoices, clients, period)
+	case ExportFormatSAFT:
+		count, err = s.writeSAFT(w, invoices, clients, period)
+	default:
+		count, err = s.writeCSV(w, invoices, clients, period)
+	}
+	if err != nil {
+		return 0, err
+	}
+	s.logger.Info("Exported %d invoices as %s", count, format)
+	return count, nil
+}
+
+// exportClients looks up the clients of the exported invoices
+type exportClients struct {
+	store Store
+	byID  map[int]models.Client
+}
+
+// loadClients loads the clients for an export
+func (s *ExportService) loadClients() (*exportClients, error) {
+	clients, err := s.store.GetClients()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load clients: %w", err)
+	}
+	byID := make(map[int]models.Client, len(clients))
+	for _, client := range clients {
+		byID[client.ID] = client
+	}
+	return &exportClients{store: s.store, byID: byID}, nil
+}
+
+// get returns the client of an invoice. Invoices keep referring to deleted
+// clients, which GetClients leaves out.
+func (c *exportClients) get(invoice *models.Invoice) (models.Client, error) {
+	client, ok := c.byID[invoice.ClientID]
+	if !ok {
+		deleted, err := c.store.GetClient(invoice.ClientID)
+		switch {
+		case err == nil:
+			client = *deleted
+		case !errors.Is(err, sql.ErrNoRows):
+			return client, fmt.Errorf("failed to load client of invoice %s: %w", invoice.InvoiceNumber, err)
+		}
+		c.byID[invoice.ClientID] = client
+	}
+	return client, nil
+}
+
+// isBooked reports whether an invoice is part of the books: drafts can still
+// change and pro-forma invoices are quotes
+func isBooked(invoice *models.Invoice) bool {
+	return invoice.Status != models.InvoiceStatusDraft && !invoice.IsProforma()
+}
+
+// writeCSV writes the invoices issued in the period as CSV
+func (s *ExportService) writeCSV(w io.Writer, invoices []models.Invoice, clients *exportClients, period ExportPeriod) (int, error) {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(invoiceExportHeader); err != nil {
+		return 0, fmt.Errorf("failed to write CSV header: %w", err)
+	}
+	count := 0
+	for _, invoice := range invoices {
+		if !period.Contains(invoice.IssueDate) {
+			continue
+		}
+		client, err := clients.get(&invoice)
+		if err != nil {
+			return 0, err
+		}
+		balance := invoice.AmountDue()
+		if invoice.Status == models.InvoiceStatusPaid {
+			balance = 0
+		}
+		if err := writer.Write([]string{
+			invoice.InvoiceNumber,
+			invoice.Type,
+			client.Name,
+			client.VatID,
+			formatExportDate(invoice.IssueDate),
+			formatExportDate(invoice.DueDate),
+			formatExportDate(invoice.PaidDate),
+			invoice.Currency,
+			(invoice.TotalAmount - invoice.VatAmount).String(),
+			invoice.VatAmount.String(),
+			invoice.TotalAmount.String(),
+			invoice.CreditApplied.String(),
+			balance.String(),
+			invoice.Status,
+			invoice.Notes,
+		}); err != nil {
+			return 0, fmt.Errorf("failed to write invoice %s: %w", invoice.InvoiceNumber, err)
+		}
+		count++
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return 0, fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return count, nil
+}
+
+// formatExportDate formats a date as YYYY-MM-DD, or empty if it is not set
+func formatExportDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
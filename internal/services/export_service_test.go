@@ -0,0 +1,291 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestExportInvoicesCSV(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := dbService.SaveBusiness(&models.Business{Name: "My Business", Country: "DE"}); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	client := &models.Client{Name: "Acme, Inc.", Country: "DE", VatID: "DE123456789"}
+	if err := dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+
+	issued := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	for _, invoice := range []*models.Invoice{
+		{IssueDate: issued.AddDate(0, 1, 0), TotalAmount: 50000, Status: "sent"},
+		{IssueDate: issued, TotalAmount: 119000, VatRate: 19, VatAmount: 19000, Status: "paid", PaidDate: issued.AddDate(0, 0, 10)},
+	} {
+		invoice.BusinessID, invoice.ClientID, invoice.DueDate, invoice.Currency = 1, client.ID, invoice.IssueDate, "EUR"
+		net := invoice.TotalAmount - invoice.VatAmount
+		items := []models.InvoiceItem{{Description: "Consulting", Quantity: 1, UnitPrice: net, Amount: net}}
+		if err := dbService.SaveInvoice(invoice, items); err != nil {
+			t.Fatalf("Failed to save invoice: %v", err)
+		}
+	}
+
+	var out bytes.Buffer
+	count, err := NewExportService(dbService, NewSettingsService(dbService, NewLogger(ERROR)), NewLogger(ERROR)).ExportInvoices(&out, ExportFormatCSV, ExportPeriod{})
+	if err != nil {
+		t.Fatalf("ExportInvoices failed: %v", err)
+	}
+	records, err := csv.NewReader(bytes.NewReader(out.Bytes())).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read export: %v", err)
+	}
+	if count != 2 || len(records) != 3 {
+		t.Fatalf("Expected a header and 2 invoices, got %d: %v", count, records)
+	}
+
+	// Oldest first
+	paid := records[1]
+	if paid[2] != "Acme, Inc." || paid[3] != "DE123456789" || paid[4] != "2024-03-01" || paid[6] != "2024-03-11" {
+		t.Errorf("Unexpected client or dates: %v", paid)
+	}
+	if paid[8] != "1000.00" || paid[9] != "190.00" || paid[10] != "1190.00" || paid[12] != "0.00" || paid[13] != "paid" {
+		t.Errorf("Unexpected amounts or status: %v", paid)
+	}
+	if sent := records[2]; sent[6] != "" || sent[12] != "500.00" {
+		t.Errorf("Expected an open balance and no paid date: %v", sent)
+	}
+
+	// The generic import reads the export back
+	target, _, cleanupTarget := setupTestDB(t)
+	defer cleanupTarget()
+	if err := target.SaveBusiness(&models.Business{Name: "My Business", Country: "DE"}); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	result, err := NewImportService(target, NewLogger(ERROR)).ImportInvoices(&out, InvoiceImportGeneric, true)
+	if err != nil || result.Created != 2 || result.Rows[0].Invoice.Status != "paid" {
+		t.Errorf("Expected the export to be importable, got %+v (%v)", result, err)
+	}
+
+	if _, err := NewExportService(dbService, nil, NewLogger(ERROR)).ExportInvoices(&out, "xlsx", ExportPeriod{}); err == nil {
+		t.Error("Expected an unsupported format to be rejected")
+	}
+}
+
+func TestExportInvoicesBookkeeping(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := dbService.SaveBusiness(&models.Business{Name: "My Business", Country: "DE", VatID: "DE999999999", Currency: "EUR"}); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	client := &models.Client{Name: "Müller GmbH", Country: "AT", VatID: "ATU12345678"}
+	if err := dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+	issued := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	invoices := []*models.Invoice{
+		{InvoiceNumber: "INV-2024-0001", IssueDate: issued, VatRate: 19, Status: "paid", PaidDate: issued.AddDate(0, 0, 10)},
+		{InvoiceNumber: "INV-2024-0002", IssueDate: issued.AddDate(0, 0, 5), ReverseChargeVat: true, Status: "sent", DiscountAmount: models.NewMoney(100)},
+		{InvoiceNumber: "INV-2024-0003", IssueDate: issued.AddDate(0, 0, 6), VatRate: 19, Status: "draft"},
+		{InvoiceNumber: "INV-2024-0004", IssueDate: issued.AddDate(0, 2, 0), VatRate: 19, Status: "sent"},
+	}
+	for _, invoice := range invoices {
+		invoice.BusinessID, invoice.ClientID, invoice.DueDate, invoice.Currency = 1, client.ID, invoice.IssueDate.AddDate(0, 0, 30), "EUR"
+		items := []models.InvoiceItem{{Description: "Consulting", Quantity: 10, Unit: models.UnitHours, UnitPrice: models.NewMoney(100)}}
+		invoice.ApplyTotals(items)
+		if err := dbService.SaveInvoice(invoice, items); err != nil {
+			t.Fatalf("Failed to save invoice: %v", err)
+		}
+	}
+
+	settings := NewSettingsService(dbService, NewLogger(ERROR))
+	if err := settings.Set(SettingDATEVConsultantNumber, "1001"); err != nil {
+		t.Fatalf("Failed to set consultant number: %v", err)
+	}
+	if err := settings.Set(SettingDATEVClientNumber, "A1"); err == nil {
+		t.Error("Expected a client number with letters to be rejected")
+	}
+	exportService := NewExportService(dbService, settings, NewLogger(ERROR))
+	march := ExportPeriod{From: issued, To: issued.AddDate(0, 1, -1)}
+
+	// DATEV: a header, the column names, two invoices and a payment in Windows-1252
+	var out bytes.Buffer
+	count, err := exportService.ExportInvoices(&out, ExportFormatDATEV, march)
+	if err != nil {
+		t.Fatalf("DATEV export failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\r\n"), "\r\n")
+	if count != 2 || len(lines) != 5 {
+		t.Fatalf("Expected 2 invoices in 5 lines, got %d: %q", count, lines)
+	}
+	if header := strings.Split(lines[0], ";"); header[0] != `"EXTF"` || header[10] != "1001" || header[12] != "20240101" || header[14] != "20240301" || header[15] != "20240331" {
+		t.Errorf("Unexpected DATEV header %q", lines[0])
+	}
+	if want := "1190,00;\"S\";\"EUR\";;;\"\";" + strconv.Itoa(10000+client.ID) + ";8400;\"\";0103;\"INV-2024-0001\";\"310324\";;\"M\xfcller GmbH\""; lines[2] != want {
+		t.Errorf("Unexpected invoice booking\n got %q\nwant %q", lines[2], want)
+	}
+	if fields := strings.Split(lines[3], ";"); fields[0] != "1190,00" || fields[6] != "1200" || fields[9] != "1103" {
+		t.Errorf("Unexpected payment booking %q", lines[3])
+	}
+	if fields := strings.Split(lines[4], ";"); fields[0] != "900,00" || fields[7] != "8338" {
+		t.Errorf("Unexpected reverse charge booking %q", lines[4])
+	}
+	if _, err := exportService.ExportInvoices(&out, ExportFormatDATEV, ExportPeriod{From: issued, To: issued.AddDate(1, 0, 0)}); !errors.Is(err, ErrInvalidExportPeriod) {
+		t.Errorf("Expected a DATEV export over two years to fail, got %v", err)
+	}
+	if _, err := exportService.ExportInvoices(&out, ExportFormatSAFT, ExportPeriod{}); !errors.Is(err, ErrInvalidExportPeriod) {
+		t.Errorf("Expected a SAF-T export without a period to fail, got %v", err)
+	}
+
+	// SAF-T: the same invoices and payment, with the client and VAT rates
+	out.Reset()
+	if count, err = exportService.ExportInvoices(&out, ExportFormatSAFT, march); err != nil || count != 2 {
+		t.Fatalf("SAF-T export failed with %d invoices: %v", count, err)
+	}
+	var file struct {
+		Header struct {
+			AuditFileCountry   string
+			SelectionStartDate string `xml:"SelectionCriteria>SelectionStartDate"`
+		}
+		Customers []struct {
+			Name       string
+			CustomerID string
+		} `xml:"MasterFiles>Customers>Customer"`
+		TaxCodes      []string `xml:"MasterFiles>TaxTable>TaxTableEntry>TaxCodeDetails>TaxCode"`
+		SalesInvoices struct {
+			NumberOfEntries int
+			TotalCredit     string
+			Invoices        []struct {
+				InvoiceNo      string
+				DocumentTotals struct {
+					TaxCode          string `xml:"TaxInformationTotals>TaxCode"`
+					TaxAmount        string `xml:"TaxInformationTotals>TaxAmount>Amount"`
+					SettlementAmount string `xml:"Settlement>SettlementAmount"`
+					NetTotal         string
+					GrossTotal       string
+				}
+			} `xml:"Invoice"`
+		} `xml:"SourceDocuments>SalesInvoices"`
+		Payments []struct {
+			SourceDocumentID string `xml:"Line>SourceDocumentID"`
+			Amount           string `xml:"Line>PaymentLineAmount>Amount"`
+		} `xml:"SourceDocuments>Payments>Payment"`
+	}
+	if err := xml.Unmarshal(out.Bytes(), &file); err != nil {
+		t.Fatalf("Failed to parse SAF-T export: %v\n%s", err, out.String())
+	}
+	if file.Header.AuditFileCountry != "DE" || file.Header.SelectionStartDate != "2024-03-01" {
+		t.Errorf("Unexpected header %+v", file.Header)
+	}
+	if len(file.Customers) != 1 || file.Customers[0].Name != "Müller GmbH" || strings.Join(file.TaxCodes, ",") != "S19,RC" {
+		t.Errorf("Unexpected master files %+v %v", file.Customers, file.TaxCodes)
+	}
+	sales := file.SalesInvoices
+	if sales.NumberOfEntries != 2 || sales.TotalCredit != "1900.00" || len(sales.Invoices) != 2 {
+		t.Fatalf("Unexpected sales invoices %+v", sales)
+	}
+	if totals := sales.Invoices[0].DocumentTotals; totals.TaxCode != "S19" || totals.TaxAmount != "190.00" || totals.NetTotal != "1000.00" || totals.GrossTotal != "1190.00" {
+		t.Errorf("Unexpected totals %+v", totals)
+	}
+	if totals := sales.Invoices[1].DocumentTotals; totals.TaxCode != "RC" || totals.SettlementAmount != "100.00" || totals.GrossTotal != "900.00" {
+		t.Errorf("Unexpected reverse charge totals %+v", totals)
+	}
+	if len(file.Payments) != 1 || file.Payments[0].SourceDocumentID != "INV-2024-0001" || file.Payments[0].Amount != "1190.00" {
+		t.Errorf("Unexpected payments %+v", file.Payments)
+	}
+
+	// CSV exports can be limited to the period as well
+	out.Reset()
+	if count, err := exportService.ExportInvoices(&out, ExportFormatCSV, march); err != nil || count != 3 {
+		t.Errorf("Expected the 3 invoices issued in March, including the draft, got %d (%v)", count, err)
+	}
+}
+
+func TestExportCancelledInvoices(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := dbService.SaveBusiness(&models.Business{Name: "My Business", Country: "DE", VatID: "DE999999999", Currency: "EUR"}); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	client := &models.Client{Name: "Acme GmbH", Country: "DE"}
+	if err := dbService.SaveClient(client); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+	issued := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{BusinessID: 1, ClientID: client.ID, IssueDate: issued, DueDate: issued.AddDate(0, 0, 30), VatRate: 19, Currency: "EUR", Status: models.InvoiceStatusSent}
+	items := []models.InvoiceItem{{Description: "Consulting", Quantity: 10, Unit: models.UnitHours, UnitPrice: models.NewMoney(100)}}
+	invoice.ApplyTotals(items)
+	if err := dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+	storno, err := dbService.CancelInvoice(invoice.ID, issued.AddDate(0, 0, 10), nil)
+	if err != nil {
+		t.Fatalf("CancelInvoice failed: %v", err)
+	}
+
+	settings := NewSettingsService(dbService, NewLogger(ERROR))
+	exportService := NewExportService(dbService, settings, NewLogger(ERROR))
+	march := ExportPeriod{From: issued, To: issued.AddDate(0, 1, -1)}
+
+	// DATEV: the invoice and its reversal, but no payments
+	var out bytes.Buffer
+	count, err := exportService.ExportInvoices(&out, ExportFormatDATEV, march)
+	if err != nil {
+		t.Fatalf("DATEV export failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\r\n"), "\r\n")
+	if count != 2 || len(lines) != 4 {
+		t.Fatalf("Expected 2 bookings in 4 lines, got %d: %q", count, lines)
+	}
+	if fields := strings.Split(lines[3], ";"); fields[0] != "1190,00" || fields[1] != `"H"` || fields[10] != `"`+storno.InvoiceNumber+`"` {
+		t.Errorf("Unexpected cancellation booking %q", lines[3])
+	}
+
+	// SAF-T: the cancellation is a credit note, and there are no payments
+	out.Reset()
+	if _, err := exportService.ExportInvoices(&out, ExportFormatSAFT, march); err != nil {
+		t.Fatalf("SAF-T export failed: %v", err)
+	}
+	var file struct {
+		SalesInvoices struct {
+			TotalDebit  string
+			TotalCredit string
+			Invoices    []struct {
+				InvoiceNo   string
+				InvoiceType string
+				Lines       []struct {
+					Quantity             string
+					Amount               string `xml:"InvoiceLineAmount>Amount"`
+					DebitCreditIndicator string
+				} `xml:"Line"`
+				GrossTotal string `xml:"DocumentTotals>GrossTotal"`
+			} `xml:"Invoice"`
+		} `xml:"SourceDocuments>SalesInvoices"`
+		Payments []struct {
+			SourceDocumentID string `xml:"Line>SourceDocumentID"`
+		} `xml:"SourceDocuments>Payments>Payment"`
+	}
+	if err := xml.Unmarshal(out.Bytes(), &file); err != nil {
+		t.Fatalf("Failed to parse SAF-T export: %v\n%s", err, out.String())
+	}
+	sales := file.SalesInvoices
+	if sales.TotalDebit != "1000.00" || sales.TotalCredit != "1000.00" || len(sales.Invoices) != 2 {
+		t.Fatalf("Unexpected sales invoices %+v", sales)
+	}
+	if credit := sales.Invoices[1]; credit.InvoiceNo != storno.InvoiceNumber || credit.InvoiceType != "CreditNote" || credit.GrossTotal != "1190.00" ||
+		len(credit.Lines) != 1 || credit.Lines[0].Quantity != "10" || credit.Lines[0].Amount != "1000.00" || credit.Lines[0].DebitCreditIndicator != "D" {
+		t.Errorf("Unexpected credit note %+v", credit)
+	}
+	if len(file.Payments) != 0 {
+		t.Errorf("Expected no payments, got %+v", file.Payments)
+	}
+}
@@ -0,0 +1,604 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/refdata"
+)
+
+// ErrPECNotConfigured is returned when sending to SDI without a PEC mailbox
+var ErrPECNotConfigured = errors.New("PEC is not configured")
+
+// ErrPECSendFailed is returned when the PEC server did not accept a file for SDI
+var ErrPECSendFailed = errors.New("failed to send to SDI by PEC")
+
+// ErrFatturaPAInvalid is returned when an invoice lacks data FatturaPA requires
+var ErrFatturaPAInvalid = errors.New("invoice cannot be issued as FatturaPA")
+
+// FatturaPA transmission formats: FPR12 for businesses and consumers, FPA12
+// for the public administration, whose invoices must be signed
+const (
+	fatturaPAPrivate = "FPR12"
+	fatturaPAPublic  = "FPA12"
+)
+
+// fatturaPANamespace is the namespace of FatturaPA version 1.2
+const fatturaPANamespace = "http://ivaservizi.agenziaentrate.gov.it/docs/xsd/fatture/v1.2"
+
+// Recipient codes without an SDI channel: clients receiving their invoices by
+// PEC or in their tax drawer, and clients outside Italy
+const (
+	sdiCodeNone    = "0000000"
+	sdiCodeForeign = "XXXXXXX"
+)
+
+// fatturaPAStampDutyThreshold is the amount above which invoices without VAT
+// carry the stamp duty (imposta di bollo) of fatturaPAStampDuty
+const (
+	fatturaPAStampDutyThreshold = models.Money(7747)
+	fatturaPAStampDuty          = models.Money(200)
+)
+
+var (
+	fatturaPARegimePattern = regexp.MustCompile(`^RF\d{2}$`)
+	sdiCodePattern         = regexp.MustCompile(`^[A-Z0-9]{6,7}$`)
+	partitaIVAPattern      = regexp.MustCompile(`^\d{11}$`)
+	codiceFiscalePattern   = regexp.MustCompile(`^[A-Z0-9]{16}$`)
+	capPattern             = regexp.MustCompile(`^\d{5}$`)
+)
+
+// fatturaPAUnits are the units of measure of invoice items in Italian
+var fatturaPAUnits = map[string]string{
+	models.UnitHours:      "ore",
+	models.UnitDays:       "giorni",
+	models.UnitPieces:     "pz",
+	models.UnitKilometres: "km",
+}
+
+// IsSDICode reports whether code is an SDI recipient code: 7 characters for
+// businesses, 6 for offices of the public administration
+func IsSDICode(code string) bool {
+	return sdiCodePattern.MatchString(code)
+}
+
+// FatturaPAService issues invoices of Italian businesses as FatturaPA files,
+// the XML format of the Italian exchange system (SDI), and sends them to SDI
+// from a certified email (PEC) mailbox
+type FatturaPAService struct {
+	dbService       *DBService
+	settingsService *SettingsService
+	compliance      *ComplianceService
+	logger          *Logger
+	sendMail        sendMailFunc // Replaced in tests
+}
+
+// NewFatturaPAService creates a new FatturaPAService
+func NewFatturaPAService(dbService *DBService, settingsService *SettingsService, logger *Logger) *FatturaPAService {
+	return &FatturaPAService{
+		dbService:       dbService,
+		settingsService: settingsService,
+		logger:          logger,
+		sendMail:        sendSMTP,
+	}
+}
+
+// SetComplianceService sets the service whose profiles decide which
+// businesses issue FatturaPA files
+func (s *FatturaPAService) SetComplianceService(compliance *ComplianceService) {
+	s.compliance = compliance
+}
+
+// Configured reports whether a PEC server is set to send files to SDI
+func (s *FatturaPAService) Configured() bool {
+	return s.settingsService.GetString(SettingPECHost) != ""
+}
+
+// File returns the latest FatturaPA file of an invoice, or nil if none was
+// generated yet
+func (s *FatturaPAService) File(invoiceID int) (*models.FatturaPAFile, error) {
+	var file models.FatturaPAFile
+	var sentAt sql.NullTime
+	err := s.dbService.GetDB().QueryRow(`
+		SELECT id, invoice_id, filename, created_at, sent_to, sent_at, message_id
+		FROM fatturapa_files WHERE invoice_id = ?
+		ORDER BY id DESC LIMIT 1
+	`, invoiceID).Scan(&file.ID, &file.InvoiceID, &file.Filename, &file.CreatedAt, &file.SentTo, &sentAt, &file.MessageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load FatturaPA file: %w", err)
+	}
+	if sentAt.Valid {
+		file.SentAt = sentAt.Time
+	}
+	return &file, nil
+}
+
+// Generate returns the FatturaPA XML of an invoice and the file it is named
+// after, to download it or hand it to an intermediary. The file is created
+// the first time; later calls regenerate the XML under the same name.
+func (s *FatturaPAService) Generate(invoiceID int) (*models.FatturaPAFile, []byte, error) {
+	return s.generate(invoiceID, false)
+}
+
+// Send sends an invoice to SDI by PEC. An invoice sent before, for example
+// after SDI rejected it, is sent as a new file, since SDI rejects file names
+// it has received before. Receipts from SDI arrive in the PEC mailbox.
+func (s *FatturaPAService) Send(invoiceID int) (*models.FatturaPAFile, error) {
+	host := s.settingsService.GetString(SettingPECHost)
+	if host == "" {
+		return nil, fmt.Errorf("%w: set the PEC server on the Settings page", ErrPECNotConfigured)
+	}
+	username := s.settingsService.GetString(SettingPECUsername)
+	from := firstNonEmpty(s.settingsService.GetString(SettingPECAddress), username)
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("%w: set the PEC address, or a username that is an email address", ErrPECNotConfigured)
+	}
+	to := s.settingsService.GetString(SettingSDIAddress)
+
+	file, data, err := s.generate(invoiceID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	id := messageID(from)
+	msg, err := buildEmailMessage(id, &mail.Address{Address: from}, &mail.Address{Address: to}, "", file.Filename,
+		"Invio file "+file.Filename+"\n", []EmailAttachment{{Filename: file.Filename, ContentType: "application/xml", Data: data}})
+	if err != nil {
+		return nil, err
+	}
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, s.settingsService.GetString(SettingPECPassword), host)
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(s.settingsService.GetInt(SettingPECPort)))
+	if err := s.sendMail(addr, auth, from, []string{to}, msg); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrPECSendFailed, file.Filename, err)
+	}
+
+	now := time.Now()
+	if _, err := s.dbService.GetDB().Exec(`
+		UPDATE fatturapa_files SET sent_to = ?, sent_at = ?, message_id = ? WHERE id = ?
+	`, to, now, id, file.ID); err != nil {
+		return nil, fmt.Errorf("failed to record sending %s: %w", file.Filename, err)
+	}
+	file.SentTo, file.SentAt, file.MessageID = to, now, id
+	s.logger.Info("Sent invoice %d to SDI as %s", invoiceID, file.Filename)
+	return file, nil
+}
+
+// generate builds the XML of an invoice in its latest file, or in a new file
+// when there is none or, for sending, when the latest was sent already
+func (s *FatturaPAService) generate(invoiceID int, sending bool) (*models.FatturaPAFile, []byte, error) {
+	invoice, items, err := s.dbService.GetInvoice(invoiceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	business, err := s.dbService.GetBusiness(invoice.BusinessID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load business: %w", err)
+	}
+	if allowed, err := complianceAllows(s.compliance, business, models.ComplianceFatturaPA); err != nil {
+		return nil, nil, err
+	} else if !allowed {
+		return nil, nil, fmt.Errorf("%w: the compliance profile of the business does not issue FatturaPA files", ErrFatturaPAInvalid)
+	}
+	client, err := s.dbService.GetClient(invoice.ClientID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load client: %w", err)
+	}
+
+	regime := s.settingsService.GetString(SettingFatturaPARegime)
+	if regime == "" {
+		regime = "RF01"
+		if business.VatExempt {
+			regime = "RF19"
+		}
+	}
+	document, err := newFatturaPADocument(invoice, items, business, client, regime)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sending && document.Version == fatturaPAPublic {
+		return nil, nil, fmt.Errorf("%w: invoices to the public administration must be signed, download the file and send it signed", ErrFatturaPAInvalid)
+	}
+
+	file, err := s.File(invoiceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if file == nil || (sending && file.Sent()) {
+		if file, err = s.createFile(invoiceID, document.Header.Transmission.Transmitter.Code); err != nil {
+			return nil, nil, err
+		}
+	}
+	document.Header.Transmission.Progressive = strings.TrimSuffix(file.Filename[strings.LastIndex(file.Filename, "_")+1:], ".xml")
+
+	data, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to write FatturaPA XML: %w", err)
+	}
+	return file, append([]byte(xml.Header), data...), nil
+}
+
+// createFile records a new file of an invoice, named after the transmitter
+// and a progressive number derived from its ID
+func (s *FatturaPAService) createFile(invoiceID int, transmitter string) (*models.FatturaPAFile, error) {
+	tx, err := s.dbService.GetDB().Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	file := &models.FatturaPAFile{InvoiceID: invoiceID, CreatedAt: time.Now()}
+	if err := tx.QueryRow(`
+		INSERT INTO fatturapa_files (invoice_id, filename, created_at) VALUES (?, '', ?) RETURNING id
+	`, invoiceID, file.CreatedAt).Scan(&file.ID); err != nil {
+		return nil, fmt.Errorf("failed to create FatturaPA file: %w", err)
+	}
+	file.Filename = fmt.Sprintf("IT%s_%s.xml", transmitter, fatturaPAProgressive(file.ID))
+	if _, err := tx.Exec(`UPDATE fatturapa_files SET filename = ? WHERE id = ?`, file.Filename, file.ID); err != nil {
+		return nil, fmt.Errorf("failed to name FatturaPA file: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit FatturaPA file: %w", err)
+	}
+	return file, nil
+}
+
+// fatturaPAProgressive returns the progressive number of a file: its ID in
+// base 36, five characters long as the file name allows
+func fatturaPAProgressive(id int) string {
+	progressive := strings.ToUpper(strconv.FormatInt(int64(id), 36))
+	if len(progressive) < 5 {
+		progressive = strings.Repeat("0", 5-len(progressive)) + progressive
+	}
+	return progressive
+}
+
+// fatturaPADocument is a FatturaPA file with a single invoice. Only the root
+// element is in the FatturaPA namespace.
+type fatturaPADocument struct {
+	XMLName   xml.Name        `xml:"p:FatturaElettronica"`
+	Version   string          `xml:"versione,attr"`
+	Namespace string          `xml:"xmlns:p,attr"`
+	Header    fatturaPAHeader `xml:"FatturaElettronicaHeader"`
+	Body      fatturaPABody   `xml:"FatturaElettronicaBody"`
+}
+
+type fatturaPAHeader struct {
+	Transmission fatturaPATransmission `xml:"DatiTrasmissione"`
+	Supplier     fatturaPAParty        `xml:"CedentePrestatore"`
+	Customer     fatturaPAParty        `xml:"CessionarioCommittente"`
+}
+
+type fatturaPATransmission struct {
+	Transmitter   fatturaPATaxID `xml:"IdTrasmittente"`
+	Progressive   string         `xml:"ProgressivoInvio"`
+	Format        string         `xml:"FormatoTrasmissione"`
+	RecipientCode string         `xml:"CodiceDestinatario"`
+	RecipientPEC  string         `xml:"PECDestinatario,omitempty"`
+}
+
+type fatturaPATaxID struct {
+	Country string `xml:"IdPaese"`
+	Code    string `xml:"IdCodice"`
+}
+
+type fatturaPAParty struct {
+	VatID   *fatturaPATaxID  `xml:"DatiAnagrafici>IdFiscaleIVA,omitempty"`
+	TaxCode string           `xml:"DatiAnagrafici>CodiceFiscale,omitempty"`
+	Name    string           `xml:"DatiAnagrafici>Anagrafica>Denominazione"`
+	Regime  string           `xml:"DatiAnagrafici>RegimeFiscale,omitempty"` // Of the supplier only
+	Address fatturaPAAddress `xml:"Sede"`
+}
+
+type fatturaPAAddress struct {
+	Street     string `xml:"Indirizzo"`
+	PostalCode string `xml:"CAP"`
+	City       string `xml:"Comune"`
+	Country    string `xml:"Nazione"`
+}
+
+type fatturaPABody struct {
+	Document fatturaPADocumentData `xml:"DatiGenerali>DatiGeneraliDocumento"`
+	Order    *fatturaPAReference   `xml:"DatiGenerali>DatiOrdineAcquisto,omitempty"`
+	Contract *fatturaPAReference   `xml:"DatiGenerali>DatiContratto,omitempty"`
+	Lines    []fatturaPALine       `xml:"DatiBeniServizi>DettaglioLinee"`
+	Summary  fatturaPASummary      `xml:"DatiBeniServizi>DatiRiepilogo"`
+	Payment  *fatturaPAPayment     `xml:"DatiPagamento,omitempty"`
+}
+
+type fatturaPADocumentData struct {
+	Type     string          `xml:"TipoDocumento"`
+	Currency string          `xml:"Divisa"`
+	Date     string          `xml:"Data"`
+	Number   string          `xml:"Numero"`
+	Stamp    *fatturaPAStamp `xml:"DatiBollo,omitempty"`
+	Total    string          `xml:"ImportoTotaleDocumento"`
+}
+
+type fatturaPAStamp struct {
+	Virtual string `xml:"BolloVirtuale"`
+	Amount  string `xml:"ImportoBollo"`
+}
+
+type fatturaPAReference struct {
+	DocumentID string `xml:"IdDocumento"`
+}
+
+type fatturaPALine struct {
+	Number      int                `xml:"NumeroLinea"`
+	Description string             `xml:"Descrizione"`
+	Quantity    string             `xml:"Quantita,omitempty"`
+	Unit        string             `xml:"UnitaMisura,omitempty"`
+	PeriodStart string             `xml:"DataInizioPeriodo,omitempty"`
+	PeriodEnd   string             `xml:"DataFinePeriodo,omitempty"`
+	UnitPrice   string             `xml:"PrezzoUnitario"`
+	Discount    *fatturaPADiscount `xml:"ScontoMaggiorazione,omitempty"`
+	Total       string             `xml:"PrezzoTotale"`
+	VatRate     string             `xml:"AliquotaIVA"`
+	Nature      string             `xml:"Natura,omitempty"`
+}
+
+type fatturaPADiscount struct {
+	Type   string `xml:"Tipo"` // SC for a discount
+	Amount string `xml:"Importo"`
+}
+
+type fatturaPASummary struct {
+	VatRate        string `xml:"AliquotaIVA"`
+	Nature         string `xml:"Natura,omitempty"`
+	Taxable        string `xml:"ImponibileImporto"`
+	Tax            string `xml:"Imposta"`
+	Chargeability  string `xml:"EsigibilitaIVA,omitempty"`
+	LegalReference string `xml:"RiferimentoNormativo,omitempty"`
+}
+
+type fatturaPAPayment struct {
+	Terms   string                 `xml:"CondizioniPagamento"` // TP02 for payment in full
+	Details fatturaPAPaymentDetail `xml:"DettaglioPagamento"`
+}
+
+type fatturaPAPaymentDetail struct {
+	Method  string `xml:"ModalitaPagamento"` // MP05 for a bank transfer
+	DueDate string `xml:"DataScadenzaPagamento,omitempty"`
+	Amount  string `xml:"ImportoPagamento"`
+	Bank    string `xml:"IstitutoFinanziario,omitempty"`
+	IBAN    string `xml:"IBAN,omitempty"`
+	BIC     string `xml:"BIC,omitempty"`
+}
+
+// newFatturaPADocument builds the FatturaPA of an invoice, checking the data
+// SDI requires. The supplier transmits the file itself.
+func newFatturaPADocument(invoice *models.Invoice, items []models.InvoiceItem, business *models.Business, client *models.Client, regime string) (*fatturaPADocument, error) {
+	switch {
+	case invoice.IsProforma():
+		return nil, fmt.Errorf("%w: pro-forma invoices are not sent to SDI", ErrFatturaPAInvalid)
+	case invoice.Status == models.InvoiceStatusDraft:
+		return nil, fmt.Errorf("%w: finalize the draft first", ErrFatturaPAInvalid)
+	case invoice.HasMixedVat(items):
+		return nil, fmt.Errorf("%w: items outside the VAT base cannot be combined with VAT", ErrFatturaPAInvalid)
+	case invoice.IsStorno():
+		return nil, fmt.Errorf("%w: cancellation invoices are not sent to SDI", ErrFatturaPAInvalid)
+	case len([]rune(invoice.InvoiceNumber)) > 20:
+		return nil, fmt.Errorf("%w: the invoice number is longer than 20 characters", ErrFatturaPAInvalid)
+	}
+
+	partitaIVA := strings.TrimPrefix(compactUpper(business.VatID), "IT")
+	if refdata.NormalizeCountryCode(business.Country) != "IT" || !partitaIVAPattern.MatchString(partitaIVA) {
+		return nil, fmt.Errorf("%w: the business needs to be in Italy with an Italian VAT ID (partita IVA)", ErrFatturaPAInvalid)
+	}
+	if business.Address == "" || business.City == "" || !capPattern.MatchString(strings.TrimSpace(business.PostalCode)) {
+		return nil, fmt.Errorf("%w: the business needs an address, a city and a five-digit postal code (CAP)", ErrFatturaPAInvalid)
+	}
+	supplier := fatturaPAParty{
+		VatID:   &fatturaPATaxID{Country: "IT", Code: partitaIVA},
+		Name:    fatturaPAText(business.Name, 80),
+		Regime:  regime,
+		Address: fatturaPAAddressOf(business.Address, business.PostalCode, business.City, "IT"),
+	}
+
+	country := refdata.NormalizeCountryCode(clientCountryCode(client))
+	if country == "" || client.Address == "" || client.City == "" {
+		return nil, fmt.Errorf("%w: the client needs an address, a city and a country", ErrFatturaPAInvalid)
+	}
+	customer := fatturaPAParty{
+		Name:    fatturaPAText(client.Name, 80),
+		Address: fatturaPAAddressOf(client.Address, client.PostalCode, client.City, country),
+	}
+	vatID := compactUpper(client.VatID)
+	if len(vatID) > 2 && refdata.NormalizeCountryCode(vatID[:2]) == country {
+		vatID = vatID[2:]
+	}
+	transmission := fatturaPATransmission{
+		Transmitter:   fatturaPATaxID{Country: "IT", Code: partitaIVA},
+		Format:        fatturaPAPrivate,
+		RecipientCode: sdiCodeForeign,
+	}
+	if country == "IT" {
+		switch {
+		case codiceFiscalePattern.MatchString(vatID):
+			customer.TaxCode = vatID
+		case partitaIVAPattern.MatchString(vatID):
+			customer.VatID = &fatturaPATaxID{Country: "IT", Code: vatID}
+		default:
+			return nil, fmt.Errorf("%w: the client needs an Italian VAT ID (partita IVA) or tax code (codice fiscale)", ErrFatturaPAInvalid)
+		}
+		if !capPattern.MatchString(customer.Address.PostalCode) {
+			return nil, fmt.Errorf("%w: the client needs a five-digit postal code (CAP)", ErrFatturaPAInvalid)
+		}
+		transmission.RecipientCode = sdiCodeNone
+		switch {
+		case client.SDICode != "":
+			transmission.RecipientCode = client.SDICode
+			if len(client.SDICode) == 6 {
+				transmission.Format = fatturaPAPublic
+			}
+		case client.PEC != "":
+			transmission.RecipientPEC = client.PEC
+		}
+	} else {
+		// Clients abroad without a VAT ID are identified by placeholders
+		if vatID == "" {
+			vatID = "99999999999"
+		}
+		customer.VatID = &fatturaPATaxID{Country: country, Code: vatID}
+		if !capPattern.MatchString(customer.Address.PostalCode) {
+			customer.Address.PostalCode = "00000"
+		}
+	}
+
+	// Invoices without VAT name the reason, by its nature code
+	vatRate, nature, legalReference := fmt.Sprintf("%.2f", invoice.VatRate), "", ""
+	switch {
+	case invoice.ReverseChargeVat && country == "IT":
+		vatRate, nature, legalReference = "0.00", "N6.9", "Inversione contabile"
+	case invoice.ReverseChargeVat:
+		vatRate, nature, legalReference = "0.00", "N2.1", "Operazione non soggetta, art. 7-ter DPR 633/72"
+	case invoice.VatAmount == 0:
+		vatRate, nature = "0.00", "N2.2"
+		if business.VatExempt {
+			legalReference = fatturaPAText(business.ExemptionClause(), 100)
+		}
+	}
+
+	totals := invoice.CalculateTotals(items)
+	body := fatturaPABody{
+		Document: fatturaPADocumentData{
+			Type:     "TD01",
+			Currency: invoice.Currency,
+			Date:     invoice.IssueDate.Format("2006-01-02"),
+			Number:   invoice.InvoiceNumber,
+			Total:    invoice.TotalAmount.String(),
+		},
+		Summary: fatturaPASummary{
+			VatRate:        vatRate,
+			Nature:         nature,
+			Taxable:        totals.Subtotal.String(),
+			Tax:            totals.VatAmount.String(),
+			LegalReference: legalReference,
+		},
+	}
+	if nature == "" {
+		body.Summary.Chargeability = "I"
+	}
+	if invoice.VatAmount == 0 && !invoice.ReverseChargeVat && invoice.Currency == "EUR" && invoice.TotalAmount > fatturaPAStampDutyThreshold {
+		body.Document.Stamp = &fatturaPAStamp{Virtual: "SI", Amount: fatturaPAStampDuty.String()}
+	}
+	if invoice.PONumber != "" {
+		body.Order = &fatturaPAReference{DocumentID: fatturaPAText(invoice.PONumber, 20)}
+	}
+	if invoice.ContractReference != "" {
+		body.Contract = &fatturaPAReference{DocumentID: fatturaPAText(invoice.ContractReference, 20)}
+	}
+
+	for _, item := range items {
+		line := fatturaPALine{
+			Number:      len(body.Lines) + 1,
+			Description: fatturaPAText(item.Description, 1000),
+			Quantity:    fatturaPAQuantity(item.Quantity),
+			Unit:        fatturaPAUnits[item.Unit],
+			UnitPrice:   item.UnitPrice.String(),
+			Total:       item.Amount.String(),
+			VatRate:     vatRate,
+			Nature:      nature,
+		}
+		// Discounted items are priced as a whole, so the discount is exact
+		if item.HasDiscount() {
+			gross := item.GrossAmount().Round(invoice.Currency)
+			line.Quantity, line.Unit, line.UnitPrice = "", "", gross.String()
+			line.Discount = &fatturaPADiscount{Type: "SC", Amount: (gross - item.Amount).String()}
+		}
+		if invoice.HasServicePeriod() {
+			line.PeriodStart, line.PeriodEnd = invoice.ServicePeriodStart.Format("2006-01-02"), invoice.ServicePeriodEnd.Format("2006-01-02")
+		}
+		body.Lines = append(body.Lines, line)
+	}
+	// The invoice discount is a line of its own, so the lines add up to the taxable amount
+	if totals.Discount != 0 {
+		body.Lines = append(body.Lines, fatturaPALine{
+			Number:      len(body.Lines) + 1,
+			Description: "Sconto",
+			UnitPrice:   (-totals.Discount).String(),
+			Total:       (-totals.Discount).String(),
+			VatRate:     vatRate,
+			Nature:      nature,
+		})
+	}
+	if len(body.Lines) == 0 {
+		return nil, fmt.Errorf("%w: the invoice has no items", ErrFatturaPAInvalid)
+	}
+
+	if amountDue := invoice.AmountDue(); amountDue > 0 && invoice.Status != models.InvoiceStatusPaid {
+		body.Payment = &fatturaPAPayment{
+			Terms: "TP02",
+			Details: fatturaPAPaymentDetail{
+				Method:  "MP05",
+				DueDate: invoice.DueDate.Format("2006-01-02"),
+				Amount:  amountDue.String(),
+				Bank:    fatturaPAText(business.BankName, 80),
+				IBAN:    compactUpper(business.IBAN),
+				BIC:     compactUpper(business.BIC),
+			},
+		}
+	}
+
+	return &fatturaPADocument{
+		Version:   transmission.Format,
+		Namespace: fatturaPANamespace,
+		Header: fatturaPAHeader{
+			Transmission: transmission,
+			Supplier:     supplier,
+			Customer:     customer,
+		},
+		Body: body,
+	}, nil
+}
+
+// fatturaPAAddressOf returns the seat of a party, with the address on one line
+func fatturaPAAddressOf(address, postalCode, city, country string) fatturaPAAddress {
+	return fatturaPAAddress{
+		Street:     fatturaPAText(address, 60),
+		PostalCode: strings.TrimSpace(postalCode),
+		City:       fatturaPAText(city, 60),
+		Country:    country,
+	}
+}
+
+// fatturaPAText joins the lines of a text and cuts it to the length allowed
+func fatturaPAText(text string, maxLength int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > maxLength {
+		text = string(runes[:maxLength])
+	}
+	return text
+}
+
+// fatturaPAQuantity formats a quantity with at least the two decimals required
+func fatturaPAQuantity(quantity float64) string {
+	formatted := strconv.FormatFloat(quantity, 'f', -1, 64)
+	whole, decimals, _ := strings.Cut(formatted, ".")
+	if len(decimals) < 2 {
+		decimals += strings.Repeat("0", 2-len(decimals))
+	}
+	return whole + "." + decimals
+}
+
+// compactUpper removes the spaces of an identifier such as a VAT ID or an
+// IBAN and upper-cases it
+func compactUpper(s string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(s), ""))
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// fatturaPAFile holds the parts of a FatturaPA file the tests check
+type fatturaPAFile struct {
+	Version      string `xml:"versione,attr"`
+	Transmission struct {
+		Progressive   string `xml:"ProgressivoInvio"`
+		Format        string `xml:"FormatoTrasmissione"`
+		RecipientCode string `xml:"CodiceDestinatario"`
+		RecipientPEC  string `xml:"PECDestinatario"`
+	} `xml:"FatturaElettronicaHeader>DatiTrasmissione"`
+	Supplier struct {
+		VatID  string `xml:"DatiAnagrafici>IdFiscaleIVA>IdCodice"`
+		Regime string `xml:"DatiAnagrafici>RegimeFiscale"`
+	} `xml:"FatturaElettronicaHeader>CedentePrestatore"`
+	Customer struct {
+		Country    string `xml:"DatiAnagrafici>IdFiscaleIVA>IdPaese"`
+		VatID      string `xml:"DatiAnagrafici>IdFiscaleIVA>IdCodice"`
+		PostalCode string `xml:"Sede>CAP"`
+	} `xml:"FatturaElettronicaHeader>CessionarioCommittente"`
+	Document struct {
+		Number string `xml:"Numero"`
+		Total  string `xml:"ImportoTotaleDocumento"`
+	} `xml:"FatturaElettronicaBody>DatiGenerali>DatiGeneraliDocumento"`
+	Lines []struct {
+		Quantity  string `xml:"Quantita"`
+		UnitPrice string `xml:"PrezzoUnitario"`
+		Discount  string `xml:"ScontoMaggiorazione>Importo"`
+		Total     string `xml:"PrezzoTotale"`
+		Nature    string `xml:"Natura"`
+	} `xml:"FatturaElettronicaBody>DatiBeniServizi>DettaglioLinee"`
+	Summary struct {
+		VatRate string `xml:"AliquotaIVA"`
+		Nature  string `xml:"Natura"`
+		Taxable string `xml:"ImponibileImporto"`
+		Tax     string `xml:"Imposta"`
+	} `xml:"FatturaElettronicaBody>DatiBeniServizi>DatiRiepilogo"`
+	IBAN string `xml:"FatturaElettronicaBody>DatiPagamento>DettaglioPagamento>IBAN"`
+}
+
+func TestFatturaPA(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	business := &models.Business{Name: "Studio Rossi", Address: "Via Roma 1", City: "Milano", PostalCode: "20121", Country: "IT",
+		VatID: "IT01234567890", IBAN: "IT60 X054 2811 1010 0000 0123 456", Currency: "EUR"}
+	if err := dbService.SaveBusiness(business); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	clients := []*models.Client{
+		{Name: "Bianchi S.r.l.", Address: "Corso Italia 5", City: "Torino", PostalCode: "10121", Country: "IT", VatID: "IT09876543210", SDICode: "M5UXCR1"},
+		{Name: "Acme GmbH", Address: "Hauptstr. 1", City: "Berlin", PostalCode: "D-10115", Country: "DE", VatID: "DE123456789"},
+		{Name: "Comune di Torino", Address: "Piazza Palazzo di Città 1", City: "Torino", PostalCode: "10122", Country: "IT", VatID: "00514490010", SDICode: "UFABCD"},
+	}
+	for _, client := range clients {
+		if err := dbService.SaveClient(client); err != nil {
+			t.Fatalf("Failed to save client: %v", err)
+		}
+	}
+	issued := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	invoices := []*models.Invoice{
+		{InvoiceNumber: "2024/1", ClientID: clients[0].ID, VatRate: 22, Status: "sent", DiscountAmount: models.NewMoney(50)},
+		{InvoiceNumber: "2024/2", ClientID: clients[1].ID, ReverseChargeVat: true, Status: "sent"},
+		{InvoiceNumber: "2024/3", ClientID: clients[2].ID, VatRate: 22, Status: "sent"},
+		{InvoiceNumber: "2024/4", ClientID: clients[0].ID, VatRate: 22, Status: "draft"},
+	}
+	for _, invoice := range invoices {
+		invoice.BusinessID, invoice.IssueDate, invoice.DueDate, invoice.Currency = business.ID, issued, issued.AddDate(0, 0, 30), "EUR"
+		items := []models.InvoiceItem{
+			{Description: "Consulenza", Quantity: 10, Unit: models.UnitHours, UnitPrice: models.NewMoney(80)},
+			{Description: "Workshop", Quantity: 1, Unit: models.UnitDays, UnitPrice: models.NewMoney(600), DiscountPercent: 10},
+		}
+		invoice.ApplyTotals(items)
+		if err := dbService.SaveInvoice(invoice, items); err != nil {
+			t.Fatalf("Failed to save invoice: %v", err)
+		}
+	}
+
+	logger := NewLogger(ERROR)
+	settings := NewSettingsService(dbService, logger)
+	fatturaPA := NewFatturaPAService(dbService, settings, logger)
+	parse := func(data []byte) fatturaPAFile {
+		t.Helper()
+		var file fatturaPAFile
+		if err := xml.Unmarshal(data, &file); err != nil {
+			t.Fatalf("Failed to parse FatturaPA XML: %v\n%s", err, data)
+		}
+		return file
+	}
+
+	// Lines add up to the taxable amount, with the item and invoice discounts
+	file, data, err := fatturaPA.Generate(invoices[0].ID)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if file.Filename != "IT01234567890_00001.xml" || !strings.Contains(string(data), `<p:FatturaElettronica versione="FPR12" xmlns:p="`+fatturaPANamespace+`">`) {
+		t.Errorf("Unexpected file %s:\n%s", file.Filename, data)
+	}
+	document := parse(data)
+	if document.Transmission.Progressive != "00001" || document.Transmission.RecipientCode != "M5UXCR1" || document.Supplier.Regime != "RF01" ||
+		document.Customer.VatID != "09876543210" || document.Document.Number != "2024/1" || document.Document.Total != "1573.80" {
+		t.Errorf("Unexpected FatturaPA %+v", document)
+	}
+	if len(document.Lines) != 3 || document.Lines[0].Quantity != "10.00" || document.Lines[1].UnitPrice != "600.00" || document.Lines[1].Discount != "60.00" ||
+		document.Lines[1].Total != "540.00" || document.Lines[2].Total != "-50.00" {
+		t.Errorf("Unexpected lines %+v", document.Lines)
+	}
+	if document.Summary.VatRate != "22.00" || document.Summary.Taxable != "1290.00" || document.Summary.Tax != "283.80" || document.IBAN != "IT60X0542811101000000123456" {
+		t.Errorf("Unexpected summary %+v, IBAN %s", document.Summary, document.IBAN)
+	}
+	if again, _, err := fatturaPA.Generate(invoices[0].ID); err != nil || again.Filename != file.Filename {
+		t.Errorf("Expected the file name to be kept until sent, got %+v (%v)", again, err)
+	}
+
+	// Clients abroad have no recipient code and reverse charge names its nature
+	_, data, err = fatturaPA.Generate(invoices[1].ID)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	document = parse(data)
+	if document.Transmission.RecipientCode != "XXXXXXX" || document.Customer.Country != "DE" || document.Customer.VatID != "123456789" ||
+		document.Customer.PostalCode != "00000" || document.Summary.Nature != "N2.1" || document.Summary.Tax != "0.00" {
+		t.Errorf("Unexpected reverse charge FatturaPA %+v", document)
+	}
+
+	if _, _, err := fatturaPA.Generate(invoices[3].ID); !errors.Is(err, ErrFatturaPAInvalid) {
+		t.Errorf("Expected drafts to be refused, got %v", err)
+	}
+
+	// Sending goes to SDI by PEC; a file sent before is never sent again
+	if _, err := fatturaPA.Send(invoices[0].ID); !errors.Is(err, ErrPECNotConfigured) {
+		t.Fatalf("Expected ErrPECNotConfigured, got %v", err)
+	}
+	if err := settings.SetMany(map[string]string{SettingPECHost: "smtps.pec.example", SettingPECUsername: "studio.rossi@pec.example"}); err != nil {
+		t.Fatalf("Failed to configure PEC: %v", err)
+	}
+	var gotAddr string
+	var gotTo []string
+	var gotMsg string
+	fatturaPA.sendMail = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		gotAddr, gotTo, gotMsg = addr, to, string(msg)
+		return nil
+	}
+	sent, err := fatturaPA.Send(invoices[0].ID)
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if gotAddr != "smtps.pec.example:465" || strings.Join(gotTo, ",") != "sdi01@pec.fatturapa.it" || !strings.Contains(gotMsg, "filename=IT01234567890_00001.xml") {
+		t.Errorf("Unexpected PEC message to %s %v:\n%s", gotAddr, gotTo, gotMsg)
+	}
+	if sent.Filename != file.Filename || !sent.Sent() || sent.SentTo != "sdi01@pec.fatturapa.it" {
+		t.Errorf("Unexpected sent file %+v", sent)
+	}
+	resent, err := fatturaPA.Send(invoices[0].ID)
+	if err != nil || resent.Filename == sent.Filename || !strings.HasPrefix(resent.Filename, "IT01234567890_") {
+		t.Errorf("Expected the invoice to be sent again as a new file, got %+v (%v)", resent, err)
+	}
+
+	// Invoices to the public administration must be signed first
+	_, data, err = fatturaPA.Generate(invoices[2].ID)
+	if err != nil || parse(data).Version != "FPA12" {
+		t.Errorf("Expected an FPA12 file for the public administration (%v)", err)
+	}
+	if _, err := fatturaPA.Send(invoices[2].ID); !errors.Is(err, ErrFatturaPAInvalid) {
+		t.Errorf("Expected unsigned invoices to the public administration to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/refdata"
+)
+
+// ErrHolidaysDisabled is returned when public holidays that were never
+// downloaded are needed and no holiday server is configured
+var ErrHolidaysDisabled = errors.New("public holiday downloads are disabled")
+
+// holidayRefreshAge is how old downloaded holidays may get before they are
+// downloaded again, as governments add and move holidays during the year
+const holidayRefreshAge = 30 * 24 * time.Hour
+
+// HolidayService provides the nationwide public holidays of countries from a
+// Nager.Date server, cached in the database
+type HolidayService struct {
+	dbService       *DBService
+	settingsService *SettingsService
+	logger          *Logger
+	client          *http.Client
+}
+
+// NewHolidayService creates a new HolidayService
+func NewHolidayService(dbService *DBService, settingsService *SettingsService, logger *Logger) *HolidayService {
+	return &HolidayService{
+		dbService:       dbService,
+		settingsService: settingsService,
+		logger:          logger,
+		client:          &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// nagerHoliday is a public holiday of the Nager.Date API
+type nagerHoliday struct {
+	Date      string   `json:"date"`
+	LocalName string   `json:"localName"`
+	Name      string   `json:"name"`
+	Global    bool     `json:"global"` // False for holidays of some regions only
+	Types     []string `json:"types"`
+}
+
+// Holidays returns the nationwide public holidays of a country in a year.
+// They are downloaded the first time and again once a month; when the
+// server cannot be reached the holidays downloaded before are used.
+func (s *HolidayService) Holidays(country string, year int) ([]models.PublicHoliday, error) {
+	country = refdata.NormalizeCountryCode(country)
+	if !refdata.IsCountryCode(country) {
+		return nil, fmt.Errorf("%q is not a country code like DE", country)
+	}
+
+	var fetchedAt time.Time
+	err := s.dbService.GetDB().QueryRow(`SELECT fetched_at FROM public_holiday_years WHERE country = ? AND year = ?`,
+		country, year).Scan(&fetchedAt)
+	cached := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to query public holidays: %w", err)
+	}
+	if !cached || time.Since(fetchedAt) > holidayRefreshAge {
+		if err := s.Refresh(country, year); err != nil {
+			if !cached {
+				return nil, err
+			}
+			s.logger.Warn("Using the public holidays of %s in %d downloaded on %s: %v", country, year, fetchedAt.Format("2006-01-02"), err)
+		}
+	}
+
+	rows, err := s.dbService.GetDB().Query(`SELECT date, name FROM public_holidays WHERE country = ? AND date LIKE ? ORDER BY date`,
+		country, strconv.Itoa(year)+"-%")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query public holidays: %w", err)
+	}
+	defer rows.Close()
+
+	holidays := []models.PublicHoliday{}
+	for rows.Next() {
+		holiday := models.PublicHoliday{Country: country}
+		if err := rows.Scan(&holiday.Date, &holiday.Name); err != nil {
+			return nil, fmt.Errorf("failed to read public holiday: %w", err)
+		}
+		holidays = append(holidays, holiday)
+	}
+	return holidays, rows.Err()
+}
+
+// Refresh downloads the public holidays of a country in a year, replacing
+// the ones downloaded before. Countries the server has no holidays for are
+// stored without any.
+func (s *HolidayService) Refresh(country string, year int) error {
+	country = refdata.NormalizeCountryCode(country)
+	baseURL := strings.TrimRight(s.settingsService.GetString(SettingHolidaysURL), "/")
+	if baseURL == "" {
+		return ErrHolidaysDisabled
+	}
+
+	url := fmt.Sprintf("%s/api/v3/PublicHolidays/%d/%s", baseURL, year, country)
+	s.logger.Info("Fetching public holidays from %s", url)
+	resp, err := s.client.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch public holidays: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var fetched []nagerHoliday
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(&fetched); err != nil {
+			return fmt.Errorf("failed to parse public holidays: %w", err)
+		}
+	case http.StatusNotFound, http.StatusNoContent:
+		s.logger.Warn("The holiday server has no public holidays for %s", country)
+	default:
+		return fmt.Errorf("failed to fetch public holidays: %s", resp.Status)
+	}
+
+	tx, err := s.dbService.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	prefix := strconv.Itoa(year) + "-"
+	if _, err := tx.Exec(`DELETE FROM public_holidays WHERE country = ? AND date LIKE ?`, country, prefix+"%"); err != nil {
+		return fmt.Errorf("failed to replace public holidays: %w", err)
+	}
+	for _, holiday := range fetched {
+		// Regional holidays and bank or optional days off are left out
+		if !holiday.Global || !slices.Contains(holiday.Types, "Public") || !strings.HasPrefix(holiday.Date, prefix) {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", holiday.Date); err != nil {
+			continue
+		}
+		name := holiday.LocalName
+		if holiday.Name != "" && holiday.Name != name {
+			name = holiday.Name + " (" + holiday.LocalName + ")"
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO public_holidays (country, date, name) VALUES (?, ?, ?)
+			ON CONFLICT (country, date) DO UPDATE SET name = excluded.name
+		`, country, holiday.Date, name); err != nil {
+			return fmt.Errorf("failed to store public holiday: %w", err)
+		}
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO public_holiday_years (country, year, fetched_at) VALUES (?, ?, ?)
+		ON CONFLICT (country, year) DO UPDATE SET fetched_at = excluded.fetched_at
+	`, country, year, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to store public holidays: %w", err)
+	}
+	return tx.Commit()
+}
+
+// WorkMonth lays out the working days of a business in a month, without
+// the public holidays of its holiday country. When the holidays cannot be
+// loaded the month is laid out without them and the error is returned too.
+func (s *HolidayService) WorkMonth(business *models.Business, year int, month time.Month) (models.WorkMonth, error) {
+	if business.HolidayCountry == "" {
+		return business.WorkMonth(year, month, nil), nil
+	}
+	holidays, err := s.Holidays(business.HolidayCountry, year)
+	if err != nil {
+		return business.WorkMonth(year, month, nil), fmt.Errorf("public holidays of %s: %w", business.HolidayCountry, err)
+	}
+	return business.WorkMonth(year, month, holidays), nil
+}
+
+// WorkPeriod lays out the working days of a business from one day to
+// another, both included, like WorkMonth with the holidays of every year
+// of the period
+func (s *HolidayService) WorkPeriod(business *models.Business, from, to time.Time) (models.WorkPeriod, error) {
+	if business.HolidayCountry == "" {
+		return business.WorkPeriod(from, to, nil), nil
+	}
+	var holidays []models.PublicHoliday
+	for year := from.Year(); year <= to.Year(); year++ {
+		list, err := s.Holidays(business.HolidayCountry, year)
+		if err != nil {
+			return business.WorkPeriod(from, to, nil), fmt.Errorf("public holidays of %s: %w", business.HolidayCountry, err)
+		}
+		holidays = append(holidays, list...)
+	}
+	return business.WorkPeriod(from, to, holidays), nil
+}
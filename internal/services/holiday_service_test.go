@@ -0,0 +1,98 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+const nagerTestHolidays = `[
+  {"date": "2024-01-01", "localName": "Neujahr", "name": "New Year's Day", "global": true, "types": ["Public"]},
+  {"date": "2024-01-06", "localName": "Heilige Drei Könige", "name": "Epiphany", "global": false, "types": ["Public"]},
+  {"date": "2024-05-01", "localName": "Tag der Arbeit", "name": "Labour Day", "global": true, "types": ["Public"]},
+  {"date": "2024-05-09", "localName": "Christi Himmelfahrt", "name": "Ascension Day", "global": true, "types": ["Public"]},
+  {"date": "2024-05-20", "localName": "Pfingstmontag", "name": "Whit Monday", "global": true, "types": ["Public"]},
+  {"date": "2024-12-24", "localName": "Heiligabend", "name": "Christmas Eve", "global": true, "types": ["Bank"]}
+]`
+
+func TestHolidays(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/api/v3/PublicHolidays/2024/DE":
+			w.Write([]byte(nagerTestHolidays))
+		case "/api/v3/PublicHolidays/2024/AQ":
+			http.NotFound(w, r)
+		default:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	logger := NewLogger(ERROR)
+	settings := NewSettingsService(dbService, logger)
+	if err := settings.Set(SettingHolidaysURL, server.URL+"/"); err != nil {
+		t.Fatalf("Failed to set holiday server: %v", err)
+	}
+	holidays := NewHolidayService(dbService, settings, logger)
+
+	// Regional holidays and bank holidays are left out
+	list, err := holidays.Holidays("de", 2024)
+	if err != nil {
+		t.Fatalf("Holidays failed: %v", err)
+	}
+	if len(list) != 4 || list[0].Date != "2024-01-01" || list[0].Name != "New Year's Day (Neujahr)" || list[0].Country != "DE" {
+		t.Errorf("Unexpected holidays %+v", list)
+	}
+
+	// Downloaded holidays come from the cache
+	if _, err := holidays.Holidays("DE", 2024); err != nil || requests != 1 {
+		t.Errorf("Expected the holidays to be downloaded once, got %d requests (%v)", requests, err)
+	}
+
+	// Countries the server has no holidays for have none
+	if list, err := holidays.Holidays("AQ", 2024); err != nil || len(list) != 0 {
+		t.Errorf("Expected no holidays for AQ, got %v (%v)", list, err)
+	}
+	if _, err := holidays.Holidays("DE", 2025); err == nil {
+		t.Error("Expected an error when the server fails for holidays never downloaded")
+	}
+	if _, err := holidays.Holidays("XX", 2024); err == nil {
+		t.Error("Expected an error for an unknown country")
+	}
+
+	// Stale holidays are downloaded again, and kept when that fails
+	if _, err := dbService.GetDB().Exec(`UPDATE public_holiday_years SET fetched_at = ?`, time.Now().Add(-2*holidayRefreshAge)); err != nil {
+		t.Fatalf("Failed to age holidays: %v", err)
+	}
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	if list, err := holidays.Holidays("DE", 2024); err != nil || len(list) != 4 {
+		t.Errorf("Expected the cached holidays when the server fails, got %d (%v)", len(list), err)
+	}
+
+	business := &models.Business{HolidayCountry: "DE"}
+	month, err := holidays.WorkMonth(business, 2024, time.May)
+	if err != nil || month.TotalHours != 20*8 {
+		t.Errorf("Expected 20 working days in May 2024 without German holidays, got %v hours (%v)", month.TotalHours, err)
+	}
+
+	if err := settings.Set(SettingHolidaysURL, ""); err != nil {
+		t.Fatalf("Failed to disable holiday downloads: %v", err)
+	}
+	business.HolidayCountry = "FR"
+	month, err = holidays.WorkMonth(business, 2024, time.May)
+	if !errors.Is(err, ErrHolidaysDisabled) || month.TotalHours != 23*8 {
+		t.Errorf("Expected May 2024 without holidays and ErrHolidaysDisabled, got %v hours (%v)", month.TotalHours, err)
+	}
+}
@@ -0,0 +1,286 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// Hooks, the points where custom logic can run
+const (
+	HookInvoiceCreate = "invoice.create"
+	HookClientSave    = "client.save"
+	HookPDFRender     = "pdf.render"
+)
+
+// hookSettings maps each hook to the setting naming its script or URL
+var hookSettings = map[string]string{
+	HookInvoiceCreate: SettingHookInvoiceCreate,
+	HookClientSave:    SettingHookClientSave,
+	HookPDFRender:     SettingHookPDFRender,
+}
+
+// hooksDir is the directory in DATA_DIR holding hook scripts. Only scripts in
+// it can be run, so the settings page cannot be used to run other programs.
+const hooksDir = "hooks"
+
+// maxHookOutput limits how much of a hook's output is read
+const maxHookOutput = 1 << 20
+
+// ErrHookFailed is returned when a hook could not be run or did not answer
+var ErrHookFailed = errors.New("hook failed")
+
+// HookRejectedError is returned when a hook rejects the change, by exiting
+// with a non-zero status, answering with a 4xx status, or returning an error
+type HookRejectedError struct {
+	Hook    string
+	Message string
+}
+
+func (e *HookRejectedError) Error() string {
+	return fmt.Sprintf("rejected by the %s hook: %s", e.Hook, e.Message)
+}
+
+// hookResult is the optional JSON a hook writes to stdout or responds with
+type hookResult struct {
+	Error         string `json:"error"`
+	InvoiceNumber string `json:"invoice_number"`
+}
+
+// HookService runs the scripts or HTTP endpoints configured for hooks. A hook
+// receives a JSON document describing the event on stdin or as the request
+// body, and may answer with a JSON document.
+type HookService struct {
+	settingsService *SettingsService
+	dataDir         string
+	logger          *Logger
+	client          *http.Client
+}
+
+// NewHookService creates a new HookService
+func NewHookService(settingsService *SettingsService, dataDir string, logger *Logger) *HookService {
+	return &HookService{
+		settingsService: settingsService,
+		dataDir:         dataDir,
+		logger:          logger,
+		client:          &http.Client{},
+	}
+}
+
+// InvoiceCreate runs the invoice.create hook before a new invoice is saved. An
+// invoice number returned by the hook replaces the invoice's number.
+func (s *HookService) InvoiceCreate(invoice *models.Invoice, items []models.InvoiceItem) error {
+	result, err := s.run(HookInvoiceCreate, map[string]interface{}{"invoice": invoice, "items": items})
+	if err != nil || result == nil {
+		return err
+	}
+	if number := strings.TrimSpace(result.InvoiceNumber); number != "" {
+		s.logger.Info("The %s hook numbered the invoice %s", HookInvoiceCreate, number)
+		invoice.InvoiceNumber = number
+	}
+	return nil
+}
+
+// ClientSave runs the client.save hook before a client is created or changed
+func (s *HookService) ClientSave(client *models.Client) error {
+	_, err := s.run(HookClientSave, map[string]interface{}{"client": client})
+	return err
+}
+
+// PDFRender runs the pdf.render hook after the PDF of an invoice was
+// generated. The hook may change the file at path in place.
+func (s *HookService) PDFRender(invoice *models.Invoice, path string) error {
+	_, err := s.run(HookPDFRender, map[string]interface{}{"invoice": invoice, "path": path})
+	return err
+}
+
+// run calls the script or URL configured for a hook with the payload, to
+// which the hook name is added. It returns nil when no hook is configured.
+func (s *HookService) run(hook string, payload map[string]interface{}) (*hookResult, error) {
+	if s == nil {
+		return nil, nil
+	}
+	value, source := s.settingsService.lookup(hookSettings[hook])
+	if value == "" {
+		return nil, nil
+	}
+	kind, target, err := parseHookTarget(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrHookFailed, hook, err)
+	}
+	// URLs saved on the settings page before they were limited to the
+	// environment are not called
+	if kind == "url" && source == "database" {
+		s.logger.Error("The %s hook is a URL saved on the settings page; set it in the environment or config file instead", hook)
+		return nil, fmt.Errorf("%w: %s: URL hooks can only be set in the environment or config file", ErrHookFailed, hook)
+	}
+
+	payload["hook"] = hook
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrHookFailed, hook, err)
+	}
+
+	timeout := time.Duration(s.settingsService.GetInt(SettingHookTimeout)) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	s.logger.Debug("Running the %s hook %s", hook, target)
+	var output []byte
+	if kind == "url" {
+		output, err = s.post(ctx, hook, target, body)
+	} else {
+		output, err = s.exec(ctx, hook, target, body)
+	}
+	if err != nil {
+		var rejected *HookRejectedError
+		if errors.As(err, &rejected) {
+			s.logger.Info("%v", err)
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		s.logger.Error("The %s hook failed: %v", hook, err)
+		return nil, fmt.Errorf("%w: %s: %v", ErrHookFailed, hook, err)
+	}
+
+	output = bytes.TrimSpace(output)
+	if len(output) == 0 {
+		return &hookResult{}, nil
+	}
+	var result hookResult
+	if err := json.Unmarshal(output, &result); err != nil {
+		s.logger.Error("The %s hook returned invalid JSON: %v", hook, err)
+		return nil, fmt.Errorf("%w: %s returned invalid JSON: %v", ErrHookFailed, hook, err)
+	}
+	if result.Error != "" {
+		return nil, &HookRejectedError{Hook: hook, Message: result.Error}
+	}
+	return &result, nil
+}
+
+// exec runs a script from DATA_DIR/hooks with the payload on stdin. A non-zero
+// exit status rejects the change with the script's stderr as the message.
+func (s *HookService) exec(ctx context.Context, hook, name string, body []byte) ([]byte, error) {
+	dir := filepath.Join(s.dataDir, hooksDir)
+	cmd := exec.CommandContext(ctx, filepath.Join(dir, name))
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "SIMPLE_INVOICE_HOOK="+hook)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.WaitDelay = time.Second
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &limitedBuffer{buf: &stdout, remaining: maxHookOutput}
+	cmd.Stderr = &limitedBuffer{buf: &stderr, remaining: maxHookOutput}
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil && exitErr.Exited() {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = exitErr.Error()
+		}
+		return nil, &HookRejectedError{Hook: hook, Message: message}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// post sends the payload to a URL. A 4xx response rejects the change with
+// the error in its JSON body, or the body itself, as the message.
+func (s *HookService) post(ctx context.Context, hook, target string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Simple-Invoice-Hook", hook)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	output, err := io.ReadAll(io.LimitReader(resp.Body, maxHookOutput))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return output, nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		var result hookResult
+		message := strings.TrimSpace(string(output))
+		if json.Unmarshal(output, &result) == nil && result.Error != "" {
+			message = result.Error
+		}
+		if message == "" {
+			message = resp.Status
+		}
+		return nil, &HookRejectedError{Hook: hook, Message: message}
+	default:
+		return nil, fmt.Errorf("%s returned %s", target, resp.Status)
+	}
+}
+
+// parseHookTarget checks the value of a hook setting: an http or https URL,
+// or the name of a script in DATA_DIR/hooks. It returns "url" or "script"
+// and the URL or script name.
+func parseHookTarget(value string) (string, string, error) {
+	if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+		if u, err := url.Parse(value); err != nil || u.Host == "" {
+			return "", "", fmt.Errorf("%q is not a valid URL", value)
+		}
+		return "url", value, nil
+	}
+	if value == "." || value == ".." || strings.ContainsAny(value, `/\`) {
+		return "", "", fmt.Errorf("%q is not an http(s) URL or the name of a script in DATA_DIR/%s", value, hooksDir)
+	}
+	return "script", value, nil
+}
+
+// isHookURLSetting reports whether value sets a hook to a URL. The server
+// could be made to send requests to internal services with such a hook, so
+// they cannot be saved on the settings page.
+func isHookURLSetting(key, value string) bool {
+	for _, setting := range hookSettings {
+		if setting == key {
+			kind, _, err := parseHookTarget(value)
+			return err == nil && kind == "url"
+		}
+	}
+	return false
+}
+
+// limitedBuffer keeps up to remaining bytes and discards the rest, so a
+// chatty script cannot exhaust memory
+type limitedBuffer struct {
+	buf       *bytes.Buffer
+	remaining int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if n := min(len(p), b.remaining); n > 0 {
+		b.buf.Write(p[:n])
+		b.remaining -= n
+	}
+	return len(p), nil
+}
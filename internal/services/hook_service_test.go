@@ -0,0 +1,104 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestHookScripts(t *testing.T) {
+	dbService, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+	settingsService := NewSettingsService(dbService, NewLogger(ERROR))
+	hooks := NewHookService(settingsService, tempDir, NewLogger(FATAL))
+
+	// Without hooks nothing runs
+	invoice := &models.Invoice{InvoiceNumber: "INV-1", Currency: "EUR"}
+	if err := hooks.InvoiceCreate(invoice, nil); err != nil || invoice.InvoiceNumber != "INV-1" {
+		t.Fatalf("Expected no hook to run, got %v", err)
+	}
+
+	writeScript := func(name, script string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(tempDir, hooksDir), 0755); err != nil {
+			t.Fatalf("Failed to create hooks directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(tempDir, hooksDir, name), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+			t.Fatalf("Failed to write script: %v", err)
+		}
+	}
+	writeScript("number.sh", `grep -q '"currency":"EUR"' && echo '{"invoice_number": "ACME-'$SIMPLE_INVOICE_HOOK'"}'`)
+	writeScript("reject.sh", `echo "Clients need a VAT ID" >&2; exit 1`)
+	if err := settingsService.SetMany(map[string]string{
+		SettingHookInvoiceCreate: "number.sh",
+		SettingHookClientSave:    "reject.sh",
+		SettingHookPDFRender:     "missing.sh",
+	}); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+
+	if err := hooks.InvoiceCreate(invoice, nil); err != nil || invoice.InvoiceNumber != "ACME-invoice.create" {
+		t.Errorf("Expected the hook to number the invoice, got %q (%v)", invoice.InvoiceNumber, err)
+	}
+	var rejected *HookRejectedError
+	if err := hooks.ClientSave(&models.Client{Name: "Acme"}); !errors.As(err, &rejected) || rejected.Message != "Clients need a VAT ID" {
+		t.Errorf("Expected the client to be rejected, got %v", err)
+	}
+	if err := hooks.PDFRender(invoice, "/tmp/invoice.pdf"); !errors.Is(err, ErrHookFailed) {
+		t.Errorf("Expected ErrHookFailed for a missing script, got %v", err)
+	}
+
+	// Only scripts in DATA_DIR/hooks can be configured
+	if err := settingsService.Set(SettingHookClientSave, "/bin/rm"); err == nil {
+		t.Error("Expected a path outside the hooks directory to be rejected")
+	}
+}
+
+func TestHookURL(t *testing.T) {
+	dbService, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+	settingsService := NewSettingsService(dbService, NewLogger(ERROR))
+	hooks := NewHookService(settingsService, tempDir, NewLogger(FATAL))
+
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		if r.Header.Get("X-Simple-Invoice-Hook") == HookClientSave {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"error": "Unknown customer in the ERP"}`))
+		}
+	}))
+	defer server.Close()
+
+	// URLs can only be set in the environment, not on the settings page
+	if err := settingsService.Set(SettingHookClientSave, server.URL); err == nil {
+		t.Error("Expected a URL hook to be rejected on the settings page")
+	}
+	if _, err := dbService.GetDB().Exec(`INSERT INTO settings (key, value, updated_at) VALUES (?, ?, ?)`, SettingHookInvoiceCreate, server.URL, time.Now()); err != nil {
+		t.Fatalf("Failed to save setting: %v", err)
+	}
+	if err := hooks.InvoiceCreate(&models.Invoice{}, nil); !errors.Is(err, ErrHookFailed) || received != nil {
+		t.Errorf("Expected a URL hook saved in the database not to be called, got %v", err)
+	}
+
+	t.Setenv("HOOK_CLIENT_SAVE", server.URL)
+	t.Setenv("HOOK_PDF_RENDER", server.URL)
+
+	var rejected *HookRejectedError
+	if err := hooks.ClientSave(&models.Client{Name: "Acme"}); !errors.As(err, &rejected) || rejected.Message != "Unknown customer in the ERP" {
+		t.Errorf("Expected the client to be rejected, got %v", err)
+	}
+	if err := hooks.PDFRender(&models.Invoice{InvoiceNumber: "INV-1"}, "/data/pdfs/INV-1.pdf"); err != nil {
+		t.Fatalf("PDFRender failed: %v", err)
+	}
+	if received["hook"] != HookPDFRender || received["path"] != "/data/pdfs/INV-1.pdf" {
+		t.Errorf("Unexpected payload %v", received)
+	}
+}
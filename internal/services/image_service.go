@@ -0,0 +1,154 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // Register GIF decoder
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"time"
+)
+
+const (
+	// MaxLogoWidth and MaxLogoHeight bound the size of processed logos. Larger
+	// images are scaled down, keeping their aspect ratio.
+	MaxLogoWidth  = 800
+	MaxLogoHeight = 400
+
+	// maxLogoSourcePixels rejects images whose decoded size would use excessive memory
+	maxLogoSourcePixels = 40_000_000
+
+	// logoFilePrefix is the prefix of generated logo filenames
+	logoFilePrefix = "logo-"
+)
+
+// ErrInvalidImage is returned when an upload cannot be decoded as a supported image
+var ErrInvalidImage = errors.New("invalid image")
+
+// ProcessLogo decodes an uploaded PNG, JPEG or GIF image, scales it down to fit
+// within MaxLogoWidth x MaxLogoHeight and re-encodes it as PNG. Decoding the
+// image, rather than trusting the declared content type, ensures only real
+// images are stored.
+func ProcessLogo(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxLogoSourcePixels {
+		return nil, fmt.Errorf("%w: %s image of %dx%d pixels is too large", ErrInvalidImage, format, config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	width, height := fitWithin(img.Bounds().Dx(), img.Bounds().Dy(), MaxLogoWidth, MaxLogoHeight)
+	if width != img.Bounds().Dx() || height != img.Bounds().Dy() {
+		img = resizeImage(img, width, height)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// NewLogoFilename returns a unique filename for a processed logo
+func NewLogoFilename() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate filename: %w", err)
+	}
+	return fmt.Sprintf("%s%s-%s.png", logoFilePrefix, time.Now().UTC().Format("20060102150405"), hex.EncodeToString(suffix)), nil
+}
+
+// fitWithin scales width x height down to fit within maxWidth x maxHeight, keeping the aspect ratio
+func fitWithin(width, height, maxWidth, maxHeight int) (int, int) {
+	if width <= maxWidth && height <= maxHeight {
+		return width, height
+	}
+
+	scale := float64(maxWidth) / float64(width)
+	if s := float64(maxHeight) / float64(height); s < scale {
+		scale = s
+	}
+
+	newWidth := int(float64(width)*scale + 0.5)
+	newHeight := int(float64(height)*scale + 0.5)
+	if newWidth < 1 {
+		newWidth = 1
+	}
+	if newHeight < 1 {
+		newHeight = 1
+	}
+	return newWidth, newHeight
+}
+
+// resizeImage scales src to width x height by averaging the source pixels that
+// fall into each destination pixel, which gives smooth results when shrinking
+func resizeImage(src image.Image, width, height int) image.Image {
+	bounds := src.Bounds()
+	rgba := image.NewNRGBA(bounds)
+	draw.Draw(rgba, bounds, src, bounds.Min, draw.Src)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	scaleX := float64(bounds.Dx()) / float64(width)
+	scaleY := float64(bounds.Dy()) / float64(height)
+
+	for y := 0; y < height; y++ {
+		y0 := int(float64(y) * scaleY)
+		y1 := int(float64(y+1) * scaleY)
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := int(float64(x) * scaleX)
+			x1 := int(float64(x+1) * scaleX)
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			// Average with alpha weighting so transparent pixels do not darken edges
+			var r, g, b, a, count uint64
+			for sy := y0; sy < y1 && sy < bounds.Dy(); sy++ {
+				for sx := x0; sx < x1 && sx < bounds.Dx(); sx++ {
+					c := rgba.NRGBAAt(bounds.Min.X+sx, bounds.Min.Y+sy)
+					r += uint64(c.R) * uint64(c.A)
+					g += uint64(c.G) * uint64(c.A)
+					b += uint64(c.B) * uint64(c.A)
+					a += uint64(c.A)
+					count++
+				}
+			}
+			if count == 0 {
+				continue
+			}
+			if a == 0 {
+				dst.SetNRGBA(x, y, color.NRGBA{})
+				continue
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r / a),
+				G: uint8(g / a),
+				B: uint8(b / a),
+				A: uint8(a / count),
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestProcessLogoScalesAndConvertsToPNG(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 1600, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 1600; x++ {
+			src.Set(x, y, color.RGBA{R: 200, G: 10, B: 10, A: 255})
+		}
+	}
+	var input bytes.Buffer
+	if err := jpeg.Encode(&input, src, nil); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+
+	output, err := ProcessLogo(&input)
+	if err != nil {
+		t.Fatalf("ProcessLogo failed: %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(output))
+	if err != nil {
+		t.Fatalf("Expected PNG output: %v", err)
+	}
+	if img.Bounds().Dx() != MaxLogoWidth || img.Bounds().Dy() != 200 {
+		t.Errorf("Expected %dx200, got %dx%d", MaxLogoWidth, img.Bounds().Dx(), img.Bounds().Dy())
+	}
+}
+
+func TestProcessLogoRejectsNonImages(t *testing.T) {
+	_, err := ProcessLogo(strings.NewReader("<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>"))
+	if !errors.Is(err, ErrInvalidImage) {
+		t.Errorf("Expected ErrInvalidImage, got %v", err)
+	}
+}
+
+func TestFitWithin(t *testing.T) {
+	tests := []struct {
+		w, h, maxW, maxH int
+		expW, expH       int
+	}{
+		{100, 50, 800, 400, 100, 50},
+		{1600, 400, 800, 400, 800, 200},
+		{400, 1600, 800, 400, 100, 400},
+		{10000, 1, 800, 400, 800, 1},
+	}
+
+	for _, tt := range tests {
+		w, h := fitWithin(tt.w, tt.h, tt.maxW, tt.maxH)
+		if w != tt.expW || h != tt.expH {
+			t.Errorf("fitWithin(%d, %d) = %dx%d, want %dx%d", tt.w, tt.h, w, h, tt.expW, tt.expH)
+		}
+	}
+}
+
+func TestNewLogoFilenameIsUnique(t *testing.T) {
+	a, err := NewLogoFilename()
+	if err != nil {
+		t.Fatalf("NewLogoFilename failed: %v", err)
+	}
+	b, _ := NewLogoFilename()
+	if a == b {
+		t.Errorf("Expected unique filenames, got %s twice", a)
+	}
+	if !strings.HasPrefix(a, "logo-") || !strings.HasSuffix(a, ".png") {
+		t.Errorf("Unexpected logo filename: %s", a)
+	}
+}
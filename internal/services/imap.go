@@ -0,0 +1,219 @@
+package services
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imapResponse is an untagged IMAP response line, with the string literals it
+// contained ({n} followed by n bytes) stored separately
+type imapResponse struct {
+	Line     string
+	Literals [][]byte
+}
+
+// imapClient is a minimal IMAP4rev1 client supporting the commands needed to
+// read bounce messages: LOGIN, SELECT, UID SEARCH, UID FETCH and UID STORE
+type imapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// dialIMAP connects to an IMAP server with TLS on port 993 and STARTTLS on
+// other ports, and logs in
+func dialIMAP(host string, port int, username, password string) (*imapClient, error) {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	tlsConfig := &tls.Config{ServerName: host}
+
+	var conn net.Conn
+	var err error
+	if port == 993 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Minute))
+
+	c, err := newIMAPClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if port != 993 {
+		if _, err := c.command("STARTTLS"); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("server does not support STARTTLS: %w", err)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake failed: %w", err)
+		}
+		c.conn = tlsConn
+		c.r = bufio.NewReader(tlsConn)
+	}
+
+	if _, err := c.command("LOGIN %s %s", imapQuote(username), imapQuote(password)); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("login failed: %w", err)
+	}
+	return c, nil
+}
+
+// newIMAPClient reads the server greeting from a connection
+func newIMAPClient(conn net.Conn) (*imapClient, error) {
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn)}
+	greeting, err := c.readLine()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read greeting: %w", err)
+	}
+	if !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		return nil, fmt.Errorf("unexpected greeting %q", greeting)
+	}
+	return c, nil
+}
+
+// Close logs out and closes the connection
+func (c *imapClient) Close() error {
+	c.command("LOGOUT")
+	return c.conn.Close()
+}
+
+// command sends a command and returns its untagged responses. A NO or BAD
+// completion is returned as an error.
+func (c *imapClient) command(format string, args ...interface{}) ([]imapResponse, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%03d", c.tag)
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...)); err != nil {
+		return nil, err
+	}
+
+	var responses []imapResponse
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if rest, ok := strings.CutPrefix(line, tag+" "); ok {
+			if strings.HasPrefix(rest, "OK") {
+				return responses, nil
+			}
+			return nil, fmt.Errorf("%s", rest)
+		}
+		if !strings.HasPrefix(line, "* ") {
+			continue // Continuation requests are not used
+		}
+
+		response := imapResponse{Line: line}
+		for {
+			size, ok := literalSize(response.Line)
+			if !ok {
+				break
+			}
+			literal := make([]byte, size)
+			if _, err := io.ReadFull(c.r, literal); err != nil {
+				return nil, err
+			}
+			response.Literals = append(response.Literals, literal)
+
+			next, err := c.readLine()
+			if err != nil {
+				return nil, err
+			}
+			response.Line += next
+		}
+		responses = append(responses, response)
+	}
+}
+
+// readLine reads a line without its CRLF
+func (c *imapClient) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// literalSize returns n when a response line ends with a literal marker {n}
+func literalSize(line string) (int, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false
+	}
+	open := strings.LastIndexByte(line, '{')
+	if open < 0 {
+		return 0, false
+	}
+	size, err := strconv.Atoi(line[open+1 : len(line)-1])
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
+
+// Select opens a mailbox
+func (c *imapClient) Select(mailbox string) error {
+	_, err := c.command("SELECT %s", imapQuote(mailbox))
+	return err
+}
+
+// SearchUnseenSince returns the UIDs of the messages received since a day
+// that are not marked as seen
+func (c *imapClient) SearchUnseenSince(since time.Time) ([]int, error) {
+	responses, err := c.command("UID SEARCH UNSEEN SINCE %s", since.Format("2-Jan-2006"))
+	if err != nil {
+		return nil, err
+	}
+
+	var uids []int
+	for _, response := range responses {
+		rest, ok := strings.CutPrefix(response.Line, "* SEARCH")
+		if !ok {
+			continue
+		}
+		for _, field := range strings.Fields(rest) {
+			if uid, err := strconv.Atoi(field); err == nil {
+				uids = append(uids, uid)
+			}
+		}
+	}
+	return uids, nil
+}
+
+// Fetch returns the full message with a UID without marking it as seen
+func (c *imapClient) Fetch(uid int) ([]byte, error) {
+	responses, err := c.command("UID FETCH %d BODY.PEEK[]", uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, response := range responses {
+		if strings.Contains(response.Line, "FETCH") && len(response.Literals) > 0 {
+			return response.Literals[0], nil
+		}
+	}
+	return nil, fmt.Errorf("message %d not found", uid)
+}
+
+// MarkSeen flags the message with a UID as seen
+func (c *imapClient) MarkSeen(uid int) error {
+	_, err := c.command(`UID STORE %d +FLAGS (\Seen)`, uid)
+	return err
+}
+
+// imapQuote returns s as an IMAP quoted string
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,984 @@
+package services
+
+import (
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/refdata"
+)
+
+// Import row statuses
+const (
+	ImportStatusCreated   = "created"
+	ImportStatusDuplicate = "duplicate"
+	ImportStatusError     = "error"
+	ImportStatusSkipped   = "skipped" // Valid, but not created because other rows failed
+)
+
+// clientImportFields lists the client fields that can be mapped from a CSV column
+var clientImportFields = []string{"name", "address", "city", "postal_code", "country", "vat_id"}
+
+// clientImportAliases maps common spreadsheet headers to client fields
+var clientImportAliases = map[string]string{
+	"name":         "name",
+	"company":      "name",
+	"company name": "name",
+	"client":       "name",
+	"client name":  "name",
+	"address":      "address",
+	"street":       "address",
+	"city":         "city",
+	"town":         "city",
+	"postal_code":  "postal_code",
+	"postal code":  "postal_code",
+	"postcode":     "postal_code",
+	"zip":          "postal_code",
+	"zip code":     "postal_code",
+	"country":      "country",
+	"vat_id":       "vat_id",
+	"vat id":       "vat_id",
+	"vat":          "vat_id",
+	"vat number":   "vat_id",
+}
+
+// ImportRowResult describes the outcome of importing a single CSV row
+type ImportRowResult struct {
+	Row     int             `json:"row"` // 1-based line number in the file, including the header
+	Status  string          `json:"status"`
+	Client  *models.Client  `json:"client,omitempty"`
+	Invoice *models.Invoice `json:"invoice,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// ImportResult summarizes an import run
+type ImportResult struct {
+	DryRun     bool              `json:"dry_run"`
+	Total      int               `json:"total"`
+	Created    int               `json:"created"`
+	Duplicates int               `json:"duplicates"`
+	Failed     int               `json:"failed"`
+	Rows       []ImportRowResult `json:"rows"`
+}
+
+// ImportService imports data exported from other tools
+type ImportService struct {
+	store       Store
+	hookService *HookService
+	logger      *Logger
+}
+
+// NewImportService creates a new ImportService
+func NewImportService(store Store, logger *Logger) *ImportService {
+	return &ImportService{
+		store:  store,
+		logger: logger,
+	}
+}
+
+// SetHookService runs the invoice.create hook for invoices generated from hours
+func (s *ImportService) SetHookService(hookService *HookService) {
+	s.hookService = hookService
+}
+
+// ImportClients reads clients from CSV. mapping maps client fields (name, address,
+// city, postal_code, country, vat_id) to CSV header names; unmapped fields are
+// matched against the header automatically. Countries may be codes or English
+// names. Clients are validated like in the API, and invalid rows are reported
+// as errors. Clients whose VAT ID already exists, either in the database or
+// earlier in the file, are reported as duplicates. When dryRun is set nothing
+// is written and the result is a preview.
+func (s *ImportService) ImportClients(r io.Reader, mapping map[string]string, dryRun bool) (*ImportResult, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	columns, err := resolveClientColumns(header, mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.existingVatIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{DryRun: dryRun, Rows: []ImportRowResult{}}
+	now := time.Now()
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			result.addRow(ImportRowResult{Row: line, Status: ImportStatusError, Error: err.Error()})
+			continue
+		}
+		if isBlankRecord(record) {
+			continue
+		}
+
+		client := clientFromRecord(record, columns)
+		client.CreatedDate = &now
+		if client.Name == "" {
+			result.addRow(ImportRowResult{Row: line, Status: ImportStatusError, Client: client, Error: "name is required"})
+			continue
+		}
+		if err := prepareImportedClient(client); err != nil {
+			result.addRow(ImportRowResult{Row: line, Status: ImportStatusError, Client: client, Error: err.Error()})
+			continue
+		}
+
+		vatKey := normalizeVatID(client.VatID)
+		if vatKey != "" {
+			if existing[vatKey] {
+				result.addRow(ImportRowResult{Row: line, Status: ImportStatusDuplicate, Client: client, Error: fmt.Sprintf("a client with VAT ID %s already exists", client.VatID)})
+				continue
+			}
+			existing[vatKey] = true
+		}
+
+		if !dryRun {
+			if err := s.store.SaveClient(client); err != nil {
+				s.logger.Error("Failed to import client on line %d: %v", line, err)
+				result.addRow(ImportRowResult{Row: line, Status: ImportStatusError, Client: client, Error: err.Error()})
+				continue
+			}
+		}
+		result.addRow(ImportRowResult{Row: line, Status: ImportStatusCreated, Client: client})
+	}
+
+	s.logger.Info("Client import finished (dry run: %t): %d rows, %d created, %d duplicates, %d failed",
+		dryRun, result.Total, result.Created, result.Duplicates, result.Failed)
+	return result, nil
+}
+
+// addRow records a row result and updates the counters
+func (r *ImportResult) addRow(row ImportRowResult) {
+	r.Total++
+	switch row.Status {
+	case ImportStatusCreated:
+		r.Created++
+	case ImportStatusDuplicate:
+		r.Duplicates++
+	case ImportStatusError:
+		r.Failed++
+	}
+	r.Rows = append(r.Rows, row)
+}
+
+// existingVatIDs returns the normalized VAT IDs of all clients, including those in the trash
+func (s *ImportService) existingVatIDs() (map[string]bool, error) {
+	active, err := s.store.GetClients()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load existing clients: %w", err)
+	}
+	deleted, err := s.store.GetDeletedClients()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load existing clients: %w", err)
+	}
+
+	vatIDs := make(map[string]bool)
+	for _, client := range append(active, deleted...) {
+		if key := normalizeVatID(client.VatID); key != "" {
+			vatIDs[key] = true
+		}
+	}
+	return vatIDs, nil
+}
+
+// resolveClientColumns returns the column index for each client field
+func resolveClientColumns(header []string, mapping map[string]string) (map[string]int, error) {
+	index := make(map[string]int, len(header))
+	for i, h := range header {
+		index[normalizeHeader(h)] = i
+	}
+
+	columns := make(map[string]int)
+	for field, column := range mapping {
+		if column == "" {
+			continue
+		}
+		if !isClientImportField(field) {
+			return nil, fmt.Errorf("unknown client field in mapping: %s", field)
+		}
+		i, ok := index[strings.ToLower(strings.TrimSpace(column))]
+		if !ok {
+			return nil, fmt.Errorf("column %q mapped to %s not found in CSV header", column, field)
+		}
+		columns[field] = i
+	}
+
+	// Fill in anything not mapped explicitly from well-known header names
+	for i, h := range header {
+		field, ok := clientImportAliases[normalizeHeader(h)]
+		if !ok {
+			continue
+		}
+		if _, mapped := columns[field]; !mapped {
+			columns[field] = i
+		}
+	}
+
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("no column mapped to the client name")
+	}
+	return columns, nil
+}
+
+// clientFromRecord builds a client from a CSV record using the resolved columns
+func clientFromRecord(record []string, columns map[string]int) *models.Client {
+	value := func(field string) string {
+		i, ok := columns[field]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	return &models.Client{
+		Name:       value("name"),
+		Address:    value("address"),
+		City:       value("city"),
+		PostalCode: value("postal_code"),
+		Country:    value("country"),
+		VatID:      strings.ToUpper(strings.ReplaceAll(value("vat_id"), " ", "")),
+	}
+}
+
+// prepareImportedClient sets the country of an imported client, given as a
+// code or an English name, to its ISO code and validates the client
+func prepareImportedClient(client *models.Client) error {
+	if client.Country != "" {
+		country, ok := refdata.FindCountry(client.Country)
+		if !ok {
+			return fmt.Errorf("%q is not a country code or name", client.Country)
+		}
+		client.Country = country.Code
+	}
+	return ValidateClient(client)
+}
+
+// normalizeHeader lowercases a CSV header, dropping surrounding space and a UTF-8 byte order mark
+func normalizeHeader(h string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+}
+
+func isClientImportField(field string) bool {
+	for _, f := range clientImportFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func isBlankRecord(record []string) bool {
+	for _, v := range record {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeVatID uppercases a VAT ID and strips spaces, dots and dashes for comparison
+func normalizeVatID(vatID string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", ".", "", "-", "").Replace(vatID))
+}
+
+// Supported invoice import formats
+const (
+	InvoiceImportGeneric      = "generic"
+	InvoiceImportInvoiceNinja = "invoiceninja"
+	InvoiceImportWave         = "wave"
+)
+
+// invoiceImportHeaders lists, per format, the header names that can hold each invoice field.
+// Headers are compared case-insensitively.
+var invoiceImportHeaders = map[string]map[string][]string{
+	InvoiceImportGeneric: {
+		"invoice_number": {"invoice_number", "invoice number", "number"},
+		"client":         {"client", "client_name", "client name", "customer"},
+		"client_vat_id":  {"client_vat_id", "vat_id", "vat id"},
+		"issue_date":     {"issue_date", "issue date", "date", "invoice date"},
+		"due_date":       {"due_date", "due date"},
+		"total":          {"total", "total_amount", "amount"},
+		"vat_amount":     {"vat_amount", "vat amount", "vat", "tax"},
+		"balance":        {"balance", "amount_due", "amount due"},
+		"currency":       {"currency"},
+		"status":         {"status"},
+		"notes":          {"notes", "description"},
+	},
+	InvoiceImportInvoiceNinja: {
+		"invoice_number": {"invoice number", "invoice_number", "number"},
+		"client":         {"client name", "client", "client_name"},
+		"client_vat_id":  {"client vat number", "vat number"},
+		"issue_date":     {"invoice date", "date"},
+		"due_date":       {"due date", "invoice due date"},
+		"total":          {"amount", "invoice amount", "total"},
+		"vat_amount":     {"total taxes", "tax amount", "taxes"},
+		"balance":        {"balance", "invoice balance"},
+		"currency":       {"currency", "client currency"},
+		"status":         {"status", "invoice status"},
+		"notes":          {"public notes", "notes"},
+	},
+	InvoiceImportWave: {
+		"invoice_number": {"invoice number", "invoice #"},
+		"client":         {"customer", "customer name"},
+		"issue_date":     {"invoice date", "date"},
+		"due_date":       {"due date", "payment due"},
+		"total":          {"total", "invoice total"},
+		"vat_amount":     {"tax", "taxes", "total tax"},
+		"balance":        {"amount due", "balance"},
+		"currency":       {"currency"},
+		"status":         {"status"},
+		"notes":          {"memo", "notes"},
+	},
+}
+
+// importDateLayouts are tried in order when parsing dates. Slash dates are read as MM/DD/YYYY,
+// which is what InvoiceNinja and Wave use for US locales.
+var importDateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+	"01/02/2006",
+	"02.01.2006",
+	"Jan 2, 2006",
+	"January 2, 2006",
+	"2 Jan 2006",
+}
+
+// importAmountCleaner strips currency symbols and thousands separators from amounts
+var importAmountCleaner = regexp.MustCompile(`[^0-9.\-]`)
+
+// InvoiceImportFormats returns the supported invoice import formats
+func InvoiceImportFormats() []string {
+	return []string{InvoiceImportGeneric, InvoiceImportInvoiceNinja, InvoiceImportWave}
+}
+
+// ImportInvoices reads historical invoices from a CSV export in the given format.
+// Each row becomes one invoice with a single line item for its net amount and is
+// attached to the first business. Clients are matched by VAT ID or name and created
+// when missing. Invoices whose number already exists are reported as duplicates.
+// When dryRun is set nothing is written and the result is a preview.
+func (s *ImportService) ImportInvoices(r io.Reader, format string, dryRun bool) (*ImportResult, error) {
+	headers, ok := invoiceImportHeaders[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported import format: %s", format)
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	columns := resolveColumns(header, headers)
+	for _, required := range []string{"client", "issue_date", "total"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("no column found for %s in %s export", required, format)
+		}
+	}
+
+	businesses, err := s.store.GetBusinesses()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load business: %w", err)
+	}
+	if len(businesses) == 0 {
+		return nil, errors.New("set up your business details before importing invoices")
+	}
+	businessID := businesses[0].ID
+
+	clients, err := s.clientLookup()
+	if err != nil {
+		return nil, err
+	}
+	numbers, err := s.existingInvoiceNumbers()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{DryRun: dryRun, Rows: []ImportRowResult{}}
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			result.addRow(ImportRowResult{Row: line, Status: ImportStatusError, Error: err.Error()})
+			continue
+		}
+		if isBlankRecord(record) {
+			continue
+		}
+
+		value := func(field string) string {
+			i, ok := columns[field]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		invoice, err := invoiceFromImport(value)
+		if err != nil {
+			result.addRow(ImportRowResult{Row: line, Status: ImportStatusError, Invoice: invoice, Error: err.Error()})
+			continue
+		}
+		invoice.BusinessID = businessID
+
+		if invoice.InvoiceNumber != "" {
+			if numbers[invoice.InvoiceNumber] {
+				result.addRow(ImportRowResult{Row: line, Status: ImportStatusDuplicate, Invoice: invoice,
+					Error: fmt.Sprintf("invoice %s already exists", invoice.InvoiceNumber)})
+				continue
+			}
+			numbers[invoice.InvoiceNumber] = true
+		}
+
+		client, err := s.resolveImportClient(clients, value("client"), value("client_vat_id"), dryRun)
+		if err != nil {
+			result.addRow(ImportRowResult{Row: line, Status: ImportStatusError, Invoice: invoice, Error: err.Error()})
+			continue
+		}
+		invoice.ClientID = client.ID
+
+		if !dryRun {
+			items := []models.InvoiceItem{{
+				Description: fmt.Sprintf("Imported from %s", format),
+				Quantity:    1,
+				Unit:        models.UnitFlat,
+				UnitPrice:   invoice.TotalAmount - invoice.VatAmount,
+				Amount:      invoice.TotalAmount - invoice.VatAmount,
+			}}
+			if err := s.store.SaveImportedInvoice(invoice, items); err != nil {
+				s.logger.Error("Failed to import invoice on line %d: %v", line, err)
+				result.addRow(ImportRowResult{Row: line, Status: ImportStatusError, Invoice: invoice, Client: client, Error: err.Error()})
+				continue
+			}
+		}
+		result.addRow(ImportRowResult{Row: line, Status: ImportStatusCreated, Invoice: invoice, Client: client})
+	}
+
+	s.logger.Info("Invoice import (%s) finished (dry run: %t): %d rows, %d created, %d duplicates, %d failed",
+		format, dryRun, result.Total, result.Created, result.Duplicates, result.Failed)
+	return result, nil
+}
+
+// invoiceFromImport builds an invoice from the mapped values of one row
+func invoiceFromImport(value func(field string) string) (*models.Invoice, error) {
+	invoice := &models.Invoice{
+		InvoiceNumber: value("invoice_number"),
+		Currency:      strings.ToUpper(value("currency")),
+		Notes:         value("notes"),
+	}
+
+	issueDate, err := parseImportDate(value("issue_date"))
+	if err != nil {
+		return invoice, fmt.Errorf("invalid issue date: %w", err)
+	}
+	invoice.IssueDate = issueDate
+	invoice.DueDate = issueDate
+	if due := value("due_date"); due != "" {
+		if invoice.DueDate, err = parseImportDate(due); err != nil {
+			return invoice, fmt.Errorf("invalid due date: %w", err)
+		}
+	}
+
+	if invoice.TotalAmount, err = parseImportAmount(value("total")); err != nil {
+		return invoice, fmt.Errorf("invalid total: %w", err)
+	}
+	if vat := value("vat_amount"); vat != "" {
+		if invoice.VatAmount, err = parseImportAmount(vat); err != nil {
+			return invoice, fmt.Errorf("invalid VAT amount: %w", err)
+		}
+	}
+	if net := invoice.TotalAmount - invoice.VatAmount; net != 0 {
+		invoice.VatRate = math.Round(invoice.VatAmount.Float()/net.Float()*10000) / 100
+	}
+
+	balance := models.Money(-1)
+	if raw := value("balance"); raw != "" {
+		if balance, err = parseImportAmount(raw); err != nil {
+			return invoice, fmt.Errorf("invalid balance: %w", err)
+		}
+	}
+	invoice.Status = mapImportStatus(value("status"), balance)
+
+	return invoice, nil
+}
+
+// mapImportStatus converts a status from another tool to draft, sent or paid.
+// A balance of zero marks the invoice as paid; a negative balance means unknown.
+func mapImportStatus(status string, balance models.Money) string {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "paid", "complete", "completed", "closed":
+		return models.InvoiceStatusPaid
+	case "draft", "saved":
+		return models.InvoiceStatusDraft
+	}
+	if balance == 0 {
+		return models.InvoiceStatusPaid
+	}
+	if status == "" && balance < 0 {
+		return models.InvoiceStatusDraft
+	}
+	// sent, viewed, approved, partial, overdue, unpaid and anything else still awaiting payment
+	return models.InvoiceStatusSent
+}
+
+// parseImportDate parses a date using the supported layouts
+func parseImportDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("date is required")
+	}
+	for _, layout := range importDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date format %q", value)
+}
+
+// parseImportAmount parses an amount such as "$1,234.50" or "1234.5"
+func parseImportAmount(value string) (models.Money, error) {
+	cleaned := importAmountCleaner.ReplaceAllString(value, "")
+	if cleaned == "" {
+		return 0, fmt.Errorf("amount is required")
+	}
+	amount, err := models.ParseMoney(cleaned)
+	if err != nil {
+		return 0, fmt.Errorf("unrecognized amount %q", value)
+	}
+	return amount, nil
+}
+
+// importClientIndex finds clients by normalized VAT ID or lowercase name
+type importClientIndex struct {
+	byVatID map[string]*models.Client
+	byName  map[string]*models.Client
+}
+
+func (idx *importClientIndex) add(client *models.Client) {
+	if key := normalizeVatID(client.VatID); key != "" {
+		idx.byVatID[key] = client
+	}
+	idx.byName[strings.ToLower(client.Name)] = client
+}
+
+// clientLookup indexes all clients, including those in the trash
+func (s *ImportService) clientLookup() (*importClientIndex, error) {
+	idx := &importClientIndex{byVatID: map[string]*models.Client{}, byName: map[string]*models.Client{}}
+
+	active, err := s.store.GetClients()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load clients: %w", err)
+	}
+	deleted, err := s.store.GetDeletedClients()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load clients: %w", err)
+	}
+	// Active clients are added last so they win over trashed ones with the same name
+	for _, list := range [][]models.Client{deleted, active} {
+		for i := range list {
+			idx.add(&list[i])
+		}
+	}
+	return idx, nil
+}
+
+// resolveImportClient returns the client for a row, creating it if it does not exist yet
+func (s *ImportService) resolveImportClient(idx *importClientIndex, name, vatID string, dryRun bool) (*models.Client, error) {
+	if key := normalizeVatID(vatID); key != "" {
+		if client, ok := idx.byVatID[key]; ok {
+			return client, nil
+		}
+	}
+	if name == "" {
+		return nil, errors.New("client name is required")
+	}
+	if client, ok := idx.byName[strings.ToLower(name)]; ok {
+		return client, nil
+	}
+
+	client := &models.Client{Name: name, VatID: strings.ToUpper(strings.ReplaceAll(vatID, " ", ""))}
+	if !dryRun {
+		if err := s.store.SaveClient(client); err != nil {
+			return nil, fmt.Errorf("failed to create client %s: %w", name, err)
+		}
+		s.logger.Info("Created client %s during invoice import", MaskPII(name))
+	}
+	idx.add(client)
+	return client, nil
+}
+
+// existingInvoiceNumbers returns the set of invoice numbers already in use
+func (s *ImportService) existingInvoiceNumbers() (map[string]bool, error) {
+	invoices, err := s.store.GetInvoices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load invoice numbers: %w", err)
+	}
+
+	numbers := make(map[string]bool, len(invoices))
+	for _, invoice := range invoices {
+		numbers[invoice.InvoiceNumber] = true
+	}
+	return numbers, nil
+}
+
+// resolveColumns returns the column index for each field whose candidate header appears in header
+func resolveColumns(header []string, candidates map[string][]string) map[string]int {
+	index := make(map[string]int, len(header))
+	for i, h := range header {
+		key := normalizeHeader(h)
+		if _, seen := index[key]; !seen {
+			index[key] = i
+		}
+	}
+
+	columns := make(map[string]int)
+	for field, names := range candidates {
+		for _, name := range names {
+			if i, ok := index[name]; ok {
+				columns[field] = i
+				break
+			}
+		}
+	}
+	return columns
+}
+
+// hoursImportHeaders lists the header names that can hold each field of a CSV
+// of hours to invoice. Headers are compared case-insensitively.
+var hoursImportHeaders = map[string][]string{
+	"client":      {"client", "client_id", "client id", "customer", "client name", "vat_id", "vat id"},
+	"hours":       {"hours", "quantity", "qty"},
+	"rate":        {"rate", "hourly_rate", "hourly rate", "unit_price", "unit price", "price"},
+	"description": {"description", "service", "item"},
+	"period":      {"period", "service_period", "service period", "month"},
+}
+
+// defaultHoursDescription describes the line item of rows without a description
+const defaultHoursDescription = "Hours worked"
+
+// HoursInvoiceOptions are the settings of the invoices generated from hours
+type HoursInvoiceOptions struct {
+	BusinessID int // The first business when 0
+	IssueDate  time.Time
+	DueDays    int
+	VatRate    float64 // Not charged by VAT exempt businesses and on reverse charge invoices
+	Notes      string
+	// ExchangeRate, if not nil, sets the exchange rate of each invoice before
+	// it is saved
+	ExchangeRate func(invoice *models.Invoice)
+}
+
+// GenerateInvoices creates one draft invoice per row of a CSV of hours with
+// the columns client, hours, rate, description and period. The client is
+// identified by its ID, VAT ID or name, and the period, a month like 2024-03
+// or a range like 2024-03-01..2024-03-31, becomes the service period. Rows for
+// a client and period that were already invoiced are reported as duplicates.
+// The invoices are saved in a single transaction: when a row fails, no invoice
+// is created and the valid rows are reported as skipped. When dryRun is set
+// nothing is written and the result is a preview.
+func (s *ImportService) GenerateInvoices(r io.Reader, opts HoursInvoiceOptions, dryRun bool) (*ImportResult, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	columns := resolveColumns(header, hoursImportHeaders)
+	for _, required := range []string{"client", "hours", "rate"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("no column found for %s", required)
+		}
+	}
+
+	business, err := s.invoicingBusiness(opts.BusinessID)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := s.store.GetClients()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load clients: %w", err)
+	}
+	invoiced, err := s.invoicedPeriods()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{DryRun: dryRun, Rows: []ImportRowResult{}}
+	var invoices []*models.Invoice
+	var items [][]models.InvoiceItem
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			result.addRow(ImportRowResult{Row: line, Status: ImportStatusError, Error: err.Error()})
+			continue
+		}
+		if isBlankRecord(record) {
+			continue
+		}
+
+		value := func(field string) string {
+			i, ok := columns[field]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		client := findHoursClient(clients, value("client"))
+		if client == nil {
+			result.addRow(ImportRowResult{Row: line, Status: ImportStatusError, Error: fmt.Sprintf("no client found for %q", value("client"))})
+			continue
+		}
+		invoice, item, err := invoiceFromHours(value, business, client, opts)
+		if err != nil {
+			result.addRow(ImportRowResult{Row: line, Status: ImportStatusError, Invoice: invoice, Client: client, Error: err.Error()})
+			continue
+		}
+
+		if invoice.HasServicePeriod() {
+			key := invoicedPeriodKey(client.ID, invoice.ServicePeriodStart, invoice.ServicePeriodEnd)
+			if number, ok := invoiced[key]; ok {
+				result.addRow(ImportRowResult{Row: line, Status: ImportStatusDuplicate, Invoice: invoice, Client: client,
+					Error: fmt.Sprintf("the period is already invoiced by %s", number)})
+				continue
+			}
+			invoiced[key] = fmt.Sprintf("line %d", line)
+		}
+
+		rowItems := []models.InvoiceItem{item}
+		if !dryRun {
+			if err := s.hookService.InvoiceCreate(invoice, rowItems); err != nil {
+				result.addRow(ImportRowResult{Row: line, Status: ImportStatusError, Invoice: invoice, Client: client, Error: err.Error()})
+				continue
+			}
+		}
+		invoices = append(invoices, invoice)
+		items = append(items, rowItems)
+		result.addRow(ImportRowResult{Row: line, Status: ImportStatusCreated, Invoice: invoice, Client: client})
+	}
+
+	// The batch is created as a whole or not at all
+	if result.Failed > 0 {
+		for i := range result.Rows {
+			if result.Rows[i].Status == ImportStatusCreated {
+				result.Rows[i].Status = ImportStatusSkipped
+			}
+		}
+		result.Created = 0
+	} else if !dryRun && len(invoices) > 0 {
+		if opts.ExchangeRate != nil {
+			for _, invoice := range invoices {
+				opts.ExchangeRate(invoice)
+			}
+		}
+		if err := s.store.SaveInvoices(invoices, items); err != nil {
+			return nil, err
+		}
+	}
+
+	s.logger.Info("Invoice generation from hours finished (dry run: %t): %d rows, %d created, %d duplicates, %d failed",
+		dryRun, result.Total, result.Created, result.Duplicates, result.Failed)
+	return result, nil
+}
+
+// invoicingBusiness returns the business with the given ID, or the first
+// business when id is 0
+func (s *ImportService) invoicingBusiness(id int) (*models.Business, error) {
+	if id != 0 {
+		business, err := s.store.GetBusiness(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load business %d: %w", id, err)
+		}
+		return business, nil
+	}
+	businesses, err := s.store.GetBusinesses()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load business: %w", err)
+	}
+	if len(businesses) == 0 {
+		return nil, errors.New("set up your business details before generating invoices")
+	}
+	return &businesses[0], nil
+}
+
+// findHoursClient finds a client by ID, VAT ID or case-insensitive name
+func findHoursClient(clients []models.Client, identifier string) *models.Client {
+	if identifier == "" {
+		return nil
+	}
+	id, _ := strconv.Atoi(identifier)
+	vatID := normalizeVatID(identifier)
+	for i := range clients {
+		if id != 0 && clients[i].ID == id {
+			return &clients[i]
+		}
+	}
+	for i := range clients {
+		if vatID != "" && normalizeVatID(clients[i].VatID) == vatID {
+			return &clients[i]
+		}
+	}
+	for i := range clients {
+		if strings.EqualFold(clients[i].Name, identifier) {
+			return &clients[i]
+		}
+	}
+	return nil
+}
+
+// invoiceFromHours builds a draft invoice with a single line item from the
+// mapped values of one row
+func invoiceFromHours(value func(field string) string, business *models.Business, client *models.Client, opts HoursInvoiceOptions) (*models.Invoice, models.InvoiceItem, error) {
+	invoice, items := NewHoursInvoice(business, client, 0, 0, value("description"), opts)
+	hours, err := strconv.ParseFloat(strings.Replace(value("hours"), ",", ".", 1), 64)
+	if err != nil || hours <= 0 {
+		return invoice, items[0], fmt.Errorf("invalid hours %q", value("hours"))
+	}
+	rate, err := parseImportAmount(value("rate"))
+	if err != nil {
+		return invoice, items[0], fmt.Errorf("invalid rate: %w", err)
+	}
+	if rate <= 0 {
+		return invoice, items[0], fmt.Errorf("invalid rate %q", value("rate"))
+	}
+	items[0].Quantity = hours
+	items[0].UnitPrice = rate
+	invoice.HoursWorked = hours
+	invoice.HourlyRate = rate
+
+	if invoice.ServicePeriodStart, invoice.ServicePeriodEnd, err = ParseServicePeriod(value("period")); err != nil {
+		return invoice, items[0], err
+	}
+	invoice.ApplyTotals(items)
+	return invoice, items[0], nil
+}
+
+// NewHoursInvoice builds a draft invoice with a single line item of hours at
+// an hourly rate. Clients in another country than the business are invoiced
+// with reverse charge VAT, like the invoice form suggests. The invoice is not
+// saved.
+func NewHoursInvoice(business *models.Business, client *models.Client, hours float64, rate models.Money, description string, opts HoursInvoiceOptions) (*models.Invoice, []models.InvoiceItem) {
+	invoice := &models.Invoice{
+		BusinessID:  business.ID,
+		ClientID:    client.ID,
+		IssueDate:   opts.IssueDate,
+		DueDate:     opts.IssueDate.AddDate(0, 0, opts.DueDays),
+		Currency:    GetCurrencyForCountry(client.Country),
+		Notes:       opts.Notes,
+		Status:      models.InvoiceStatusDraft,
+		Type:        models.InvoiceTypeInvoice,
+		HoursWorked: hours,
+		HourlyRate:  rate,
+	}
+	if !business.VatExempt {
+		invoice.VatRate = opts.VatRate
+		invoice.ReverseChargeVat = client.Country != "" && business.Country != "" && client.Country != business.Country
+	}
+
+	items := []models.InvoiceItem{{
+		Description: cmp.Or(strings.TrimSpace(description), defaultHoursDescription),
+		Quantity:    hours,
+		UnitPrice:   rate,
+		Unit:        models.UnitHours,
+	}}
+	invoice.ApplyTotals(items)
+	return invoice, items
+}
+
+// servicePeriodSeparators separate the start and end dates of a period
+var servicePeriodSeparators = []string{"..", " - ", " to "}
+
+// ParseServicePeriod parses a month like 2024-03 or a range of dates like
+// 2024-03-01..2024-03-31. An empty value is no period.
+func ParseServicePeriod(value string) (time.Time, time.Time, error) {
+	if value == "" {
+		return time.Time{}, time.Time{}, nil
+	}
+	if month, err := time.Parse("2006-01", value); err == nil {
+		return month, month.AddDate(0, 1, -1), nil
+	}
+	for _, separator := range servicePeriodSeparators {
+		from, to, ok := strings.Cut(value, separator)
+		if !ok {
+			continue
+		}
+		start, err := parseImportDate(strings.TrimSpace(from))
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid period start: %w", err)
+		}
+		end, err := parseImportDate(strings.TrimSpace(to))
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid period end: %w", err)
+		}
+		if end.Before(start) {
+			return time.Time{}, time.Time{}, fmt.Errorf("the period %q ends before it starts", value)
+		}
+		return start, end, nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid period %q, expected a month like 2024-03 or dates like 2024-03-01..2024-03-31", value)
+}
+
+// invoicedPeriods returns the numbers of the invoices with a service period,
+// by client and period
+func (s *ImportService) invoicedPeriods() (map[string]string, error) {
+	invoices, err := s.store.GetInvoices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load invoices: %w", err)
+	}
+	periods := make(map[string]string)
+	for _, invoice := range invoices {
+		if !invoice.HasServicePeriod() || invoice.IsProforma() {
+			continue
+		}
+		periods[invoicedPeriodKey(invoice.ClientID, invoice.ServicePeriodStart, invoice.ServicePeriodEnd)] = invoice.InvoiceNumber
+	}
+	return periods, nil
+}
+
+func invoicedPeriodKey(clientID int, start, end time.Time) string {
+	return fmt.Sprintf("%d/%s/%s", clientID, start.Format("2006-01-02"), end.Format("2006-01-02"))
+}
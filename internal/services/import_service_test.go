@@ -0,0 +1,362 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestImportClientsDryRunAndDuplicates(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	existing := &models.Client{Name: "Existing", Address: "Street 1", City: "Berlin", PostalCode: "10115", Country: "DE", VatID: "DE123456789"}
+	if err := dbService.SaveClient(existing); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+
+	csvData := strings.Join([]string{
+		"Company,Street,Town,Zip,Country,VAT Number",
+		"Acme,Main St 1,Paris,75001,fr,FR 12345678901",
+		"Existing Copy,Other St,Berlin,10115,DE,de123456789",
+		",No Name St,Rome,00100,IT,IT12345678901",
+		"Acme Again,Main St 2,Paris,75002,FR,FR12345678901",
+		"Beta,High St,London,SW1A,GB,",
+	}, "\n")
+
+	importService := NewImportService(dbService, NewLogger(ERROR))
+
+	preview, err := importService.ImportClients(strings.NewReader(csvData), nil, true)
+	if err != nil {
+		t.Fatalf("ImportClients dry run failed: %v", err)
+	}
+	if preview.Total != 5 || preview.Created != 2 || preview.Duplicates != 2 || preview.Failed != 1 {
+		t.Fatalf("unexpected preview summary: %+v", preview)
+	}
+	if preview.Rows[0].Client.VatID != "FR12345678901" || preview.Rows[0].Client.Country != "FR" {
+		t.Errorf("expected VAT ID and country to be normalized, got %+v", preview.Rows[0].Client)
+	}
+	if preview.Rows[2].Row != 4 || preview.Rows[2].Status != ImportStatusError {
+		t.Errorf("expected line 4 to be reported as an error, got %+v", preview.Rows[2])
+	}
+
+	clients, _ := dbService.GetClients()
+	if len(clients) != 1 {
+		t.Fatalf("dry run must not create clients, found %d", len(clients))
+	}
+
+	// An explicit mapping overrides the detected columns
+	result, err := importService.ImportClients(strings.NewReader(csvData), map[string]string{"name": "Street"}, false)
+	if err != nil {
+		t.Fatalf("ImportClients failed: %v", err)
+	}
+	if result.Created != 3 {
+		t.Errorf("expected 3 clients created, got %+v", result)
+	}
+
+	clients, _ = dbService.GetClients()
+	if len(clients) != 4 {
+		t.Errorf("expected 4 clients after import, found %d", len(clients))
+	}
+}
+
+func TestImportClientsValidatesRows(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	csvData := strings.Join([]string{
+		"Name,Country,VAT ID",
+		"Acme,Germany,DE123456789",
+		"Beta,Narnia,",
+		"Gamma,DE,GB123456789",
+	}, "\n")
+
+	importService := NewImportService(dbService, NewLogger(ERROR))
+
+	// Invalid rows are reported in the preview already
+	preview, err := importService.ImportClients(strings.NewReader(csvData), nil, true)
+	if err != nil {
+		t.Fatalf("ImportClients dry run failed: %v", err)
+	}
+	if preview.Created != 2 || preview.Failed != 1 || preview.Rows[1].Status != ImportStatusError || !strings.Contains(preview.Rows[1].Error, "Narnia") {
+		t.Fatalf("expected the unknown country to be reported, got %+v", preview)
+	}
+
+	result, err := importService.ImportClients(strings.NewReader(csvData), nil, false)
+	if err != nil {
+		t.Fatalf("ImportClients failed: %v", err)
+	}
+	if result.Created != 2 || result.Failed != 1 {
+		t.Fatalf("unexpected import summary: %+v", result)
+	}
+	countries := map[string]string{}
+	clients, _ := dbService.GetClients()
+	for _, client := range clients {
+		countries[client.Name] = client.Country
+		if client.CreatedDate == nil || client.CreatedDate.IsZero() {
+			t.Errorf("expected %s to have a created date", client.Name)
+		}
+	}
+	// Country names become codes and UK VAT IDs move the client to GB
+	if countries["Acme"] != "DE" || countries["Gamma"] != "GB" {
+		t.Errorf("expected Acme in DE and Gamma in GB, got %v", countries)
+	}
+}
+
+func TestImportClientsRejectsUnknownMapping(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	importService := NewImportService(dbService, NewLogger(ERROR))
+
+	if _, err := importService.ImportClients(strings.NewReader("Name\nAcme\n"), map[string]string{"name": "Missing"}, true); err == nil {
+		t.Error("expected an error for a mapping to a missing column")
+	}
+	if _, err := importService.ImportClients(strings.NewReader("Foo,Bar\n1,2\n"), nil, true); err == nil {
+		t.Error("expected an error when no name column can be found")
+	}
+}
+
+func TestImportInvoicesWave(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := dbService.SaveBusiness(&models.Business{Name: "My Business", Country: "DE"}); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	existing := &models.Client{Name: "Acme", Country: "US"}
+	if err := dbService.SaveClient(existing); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+
+	csvData := "\ufeff" + strings.Join([]string{
+		"Invoice Number,Customer,Invoice Date,Due Date,Total,Tax,Amount Due,Currency,Status",
+		`1001,ACME,03/15/2023,04/14/2023,"$1,190.00",190.00,0.00,USD,Paid`,
+		"1002,New Customer,2023-05-01,2023-05-31,500,0,500,USD,Overdue",
+		"1001,Acme,03/20/2023,04/19/2023,100,0,0,USD,Paid",
+		"1003,Acme,not a date,,100,0,0,USD,Paid",
+	}, "\n")
+
+	importService := NewImportService(dbService, NewLogger(ERROR))
+	result, err := importService.ImportInvoices(strings.NewReader(csvData), InvoiceImportWave, false)
+	if err != nil {
+		t.Fatalf("ImportInvoices failed: %v", err)
+	}
+	if result.Created != 2 || result.Duplicates != 1 || result.Failed != 1 {
+		t.Fatalf("unexpected import summary: %+v", result)
+	}
+
+	first := result.Rows[0].Invoice
+	stored, items, err := dbService.GetInvoice(first.ID)
+	if err != nil {
+		t.Fatalf("GetInvoice failed: %v", err)
+	}
+	if stored.InvoiceNumber != "1001" || stored.ClientID != existing.ID || stored.Status != "paid" {
+		t.Errorf("unexpected imported invoice: %+v", stored)
+	}
+	if stored.IssueDate.Format("2006-01-02") != "2023-03-15" || stored.TotalAmount != 119000 || stored.VatRate != 19 {
+		t.Errorf("unexpected dates or amounts: %+v", stored)
+	}
+	if len(items) != 1 || items[0].Amount != 100000 {
+		t.Errorf("expected a single net line item of 1000, got %+v", items)
+	}
+
+	if result.Rows[1].Invoice.Status != "sent" {
+		t.Errorf("expected overdue invoice to be imported as sent, got %s", result.Rows[1].Invoice.Status)
+	}
+	clients, _ := dbService.GetClients()
+	if len(clients) != 2 {
+		t.Errorf("expected the missing customer to be created, found %d clients", len(clients))
+	}
+}
+
+func TestMapImportStatus(t *testing.T) {
+	tests := []struct {
+		status   string
+		balance  models.Money
+		expected string
+	}{
+		{"Paid", 10000, "paid"},
+		{"Draft", 0, "draft"},
+		{"Sent", 5000, "sent"},
+		{"Partial", 0, "paid"},
+		{"Overdue", 2000, "sent"},
+		{"", -1, "draft"},
+		{"", 0, "paid"},
+	}
+
+	for _, tt := range tests {
+		if result := mapImportStatus(tt.status, tt.balance); result != tt.expected {
+			t.Errorf("mapImportStatus(%q, %v) = %s, want %s", tt.status, tt.balance, result, tt.expected)
+		}
+	}
+}
+
+func TestGenerateInvoicesFromHours(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := dbService.SaveBusiness(&models.Business{Name: "My Business", Country: "DE"}); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	local := &models.Client{Name: "Local GmbH", Country: "DE"}
+	foreign := &models.Client{Name: "Acme", Country: "FR", VatID: "FR12345678901"}
+	for _, client := range []*models.Client{local, foreign} {
+		if err := dbService.SaveClient(client); err != nil {
+			t.Fatalf("Failed to save client: %v", err)
+		}
+	}
+
+	opts := HoursInvoiceOptions{IssueDate: time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), DueDays: 14, VatRate: 19}
+	importService := NewImportService(dbService, NewLogger(ERROR))
+
+	failing := strings.Join([]string{
+		"Client,Hours,Rate,Description,Period",
+		"local gmbh,10,100,Consulting,2024-03",
+		"Unknown Ltd,5,100,,2024-03",
+	}, "\n")
+	result, err := importService.GenerateInvoices(strings.NewReader(failing), opts, false)
+	if err != nil {
+		t.Fatalf("GenerateInvoices failed: %v", err)
+	}
+	if result.Created != 0 || result.Failed != 1 || result.Rows[0].Status != ImportStatusSkipped {
+		t.Fatalf("expected the valid row to be skipped when another fails, got %+v", result)
+	}
+	if invoices, _ := dbService.GetInvoices(); len(invoices) != 0 {
+		t.Fatalf("expected no invoices after a failed batch, found %d", len(invoices))
+	}
+
+	csvData := strings.Join([]string{
+		"Client,Hours,Rate,Description,Period",
+		"local gmbh,10,100,Consulting,2024-03",
+		"FR 12345678901,\"7,5\",80,,2024-03-01..2024-03-15",
+		fmt.Sprintf("%d,2,100,Support,2024-03", local.ID),
+	}, "\n")
+	result, err = importService.GenerateInvoices(strings.NewReader(csvData), opts, false)
+	if err != nil {
+		t.Fatalf("GenerateInvoices failed: %v", err)
+	}
+	if result.Created != 2 || result.Duplicates != 1 || result.Failed != 0 {
+		t.Fatalf("unexpected summary: %+v", result)
+	}
+
+	stored, items, err := dbService.GetInvoice(result.Rows[0].Invoice.ID)
+	if err != nil {
+		t.Fatalf("GetInvoice failed: %v", err)
+	}
+	if stored.Status != "draft" || stored.ClientID != local.ID || stored.TotalAmount != 119000 || stored.ReverseChargeVat {
+		t.Errorf("unexpected invoice for the local client: %+v", stored)
+	}
+	if stored.DueDate.Format("2006-01-02") != "2024-04-16" || stored.ServicePeriodEnd.Format("2006-01-02") != "2024-03-31" {
+		t.Errorf("unexpected due date or service period: %+v", stored)
+	}
+	if len(items) != 1 || items[0].Description != "Consulting" || items[0].Unit != models.UnitHours || items[0].Amount != 100000 {
+		t.Errorf("unexpected items: %+v", items)
+	}
+
+	stored, items, err = dbService.GetInvoice(result.Rows[1].Invoice.ID)
+	if err != nil {
+		t.Fatalf("GetInvoice failed: %v", err)
+	}
+	if !stored.ReverseChargeVat || stored.Currency != "EUR" || stored.TotalAmount != 60000 || stored.InvoiceNumber == result.Rows[0].Invoice.InvoiceNumber {
+		t.Errorf("unexpected invoice for the foreign client: %+v", stored)
+	}
+	if len(items) != 1 || items[0].Description != defaultHoursDescription || items[0].Quantity != 7.5 {
+		t.Errorf("unexpected items: %+v", items)
+	}
+
+	// Running the same file again finds the periods already invoiced
+	result, err = importService.GenerateInvoices(strings.NewReader(csvData), opts, true)
+	if err != nil {
+		t.Fatalf("GenerateInvoices failed: %v", err)
+	}
+	if result.Created != 0 || result.Duplicates != 3 {
+		t.Errorf("expected all rows to be duplicates, got %+v", result)
+	}
+}
+
+func TestGenerateInvoicesRunsInvoiceCreateHook(t *testing.T) {
+	dbService, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := dbService.SaveBusiness(&models.Business{Name: "My Business", Country: "DE"}); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	if err := dbService.SaveClient(&models.Client{Name: "Local GmbH", Country: "DE"}); err != nil {
+		t.Fatalf("Failed to save client: %v", err)
+	}
+
+	settingsService := NewSettingsService(dbService, NewLogger(ERROR))
+	if err := os.MkdirAll(filepath.Join(tempDir, hooksDir), 0755); err != nil {
+		t.Fatalf("Failed to create hooks directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, hooksDir, "number.sh"), []byte("#!/bin/sh\necho '{\"invoice_number\": \"HOURS-1\"}'\n"), 0755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	if err := settingsService.Set(SettingHookInvoiceCreate, "number.sh"); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+	importService := NewImportService(dbService, NewLogger(ERROR))
+	importService.SetHookService(NewHookService(settingsService, tempDir, NewLogger(FATAL)))
+
+	csvData := "Client,Hours,Rate\nLocal GmbH,10,100\n"
+	opts := HoursInvoiceOptions{IssueDate: time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), DueDays: 14, VatRate: 19}
+
+	// The preview does not run the hook
+	preview, err := importService.GenerateInvoices(strings.NewReader(csvData), opts, true)
+	if err != nil || preview.Created != 1 || preview.Rows[0].Invoice.InvoiceNumber == "HOURS-1" {
+		t.Fatalf("expected a preview without the hook, got %+v (%v)", preview, err)
+	}
+
+	// Generated invoices are new invoices, so the hook numbers them
+	result, err := importService.GenerateInvoices(strings.NewReader(csvData), opts, false)
+	if err != nil || result.Created != 1 {
+		t.Fatalf("GenerateInvoices failed: %+v (%v)", result, err)
+	}
+	if stored, _, err := dbService.GetInvoice(result.Rows[0].Invoice.ID); err != nil || stored.InvoiceNumber != "HOURS-1" {
+		t.Errorf("expected the hook to number the invoice HOURS-1, got %+v (%v)", stored, err)
+	}
+}
+
+func TestParseServicePeriod(t *testing.T) {
+	tests := []struct {
+		value      string
+		start, end string
+		wantErr    bool
+	}{
+		{"", "", "", false},
+		{"2024-02", "2024-02-01", "2024-02-29", false},
+		{"2024-03-01..2024-03-15", "2024-03-01", "2024-03-15", false},
+		{"2024-03-01 to 2024-03-15", "2024-03-01", "2024-03-15", false},
+		{"2024-03-15..2024-03-01", "", "", true},
+		{"March", "", "", true},
+	}
+	for _, tt := range tests {
+		start, end, err := ParseServicePeriod(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseServicePeriod(%q) error = %v, wantErr %t", tt.value, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got := formatPeriodDate(start); got != tt.start {
+			t.Errorf("ParseServicePeriod(%q) start = %s, want %s", tt.value, got, tt.start)
+		}
+		if got := formatPeriodDate(end); got != tt.end {
+			t.Errorf("ParseServicePeriod(%q) end = %s, want %s", tt.value, got, tt.end)
+		}
+	}
+}
+
+func formatPeriodDate(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format("2006-01-02")
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// instanceLockFile is the file in DATA_DIR locked by the running instance
+const instanceLockFile = "simple-invoice.lock"
+
+// ErrInstanceLocked is returned when another instance uses the data directory
+var ErrInstanceLocked = errors.New("data directory is in use by another instance")
+
+// InstanceLock keeps other instances from using the same data directory. The
+// SQLite database has a single writer and the startup removes journal files
+// it takes for stale, so two servers on one data directory would corrupt it.
+// The lock is released when the process exits, even when it crashes.
+type InstanceLock struct {
+	file *os.File
+}
+
+// AcquireInstanceLock locks the data directory for this process. It fails
+// with ErrInstanceLocked instead of waiting when another instance holds the
+// lock, naming the host and process ID that instance recorded.
+func AcquireInstanceLock(dataDir string) (*InstanceLock, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	path := filepath.Join(dataDir, instanceLockFile)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open instance lock %s: %w", path, err)
+	}
+	if err := lockFile(file); err != nil {
+		owner, _ := os.ReadFile(path)
+		file.Close()
+		if errors.Is(err, errLockHeld) {
+			if holder := strings.TrimSpace(string(owner)); holder != "" {
+				return nil, fmt.Errorf("%w (%s)", ErrInstanceLocked, holder)
+			}
+			return nil, ErrInstanceLocked
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	// Record who holds the lock for the error message of other instances
+	hostname, _ := os.Hostname()
+	owner := fmt.Sprintf("pid %d on host %s since %s", os.Getpid(), hostname, time.Now().Format(time.RFC3339))
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(owner+"\n"), 0)
+	}
+	return &InstanceLock{file: file}, nil
+}
+
+// Release unlocks the data directory. It does nothing on a nil lock.
+func (l *InstanceLock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	l.file.Truncate(0)
+	err := unlockFile(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+	return err
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package services
+
+import (
+	"errors"
+	"os"
+)
+
+// errLockHeld is returned by lockFile when another process holds the lock
+var errLockHeld = errors.New("lock held")
+
+// lockFile does not lock on systems without flock; the Docker image and the
+// supported deployments run on Linux
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,45 @@
+//go:build unix
+
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInstanceLock(t *testing.T) {
+	dataDir := t.TempDir()
+
+	lock, err := AcquireInstanceLock(dataDir)
+	if err != nil {
+		t.Fatalf("AcquireInstanceLock failed: %v", err)
+	}
+
+	// A second instance fails fast and learns who holds the lock
+	_, err = AcquireInstanceLock(dataDir)
+	if !errors.Is(err, ErrInstanceLocked) {
+		t.Fatalf("Expected ErrInstanceLocked, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "pid ") {
+		t.Errorf("Expected the error to name the holder, got %q", err)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Errorf("Expected a second Release to do nothing, got %v", err)
+	}
+
+	lock, err = AcquireInstanceLock(dataDir)
+	if err != nil {
+		t.Fatalf("Expected the lock to be free after Release, got %v", err)
+	}
+	defer lock.Release()
+	if _, err := os.Stat(filepath.Join(dataDir, instanceLockFile)); err != nil {
+		t.Errorf("Expected the lock file in the data directory: %v", err)
+	}
+}
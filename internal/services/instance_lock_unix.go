@@ -0,0 +1,27 @@
+//go:build unix
+
+package services
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// errLockHeld is returned by lockFile when another process holds the lock
+var errLockHeld = errors.New("lock held")
+
+// lockFile takes an exclusive advisory lock on file without waiting. The lock
+// is shared by all containers on a host that mount the data directory, but
+// may not be enforced on network file systems.
+func lockFile(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errLockHeld
+	}
+	return err
+}
+
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,435 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// Job statuses
+const (
+	JobStatusPending = "pending"
+	JobStatusRunning = "running"
+	JobStatusDone    = "done"
+	JobStatusFailed  = "failed"
+)
+
+// Job types known to the application
+const (
+	JobTypeGeneratePDF      = "generate_pdf"
+	JobTypeCreateBackup     = "create_backup"
+	JobTypeSendInvoiceEmail = "send_invoice_email"
+)
+
+const (
+	defaultJobMaxAttempts = 5
+	jobBaseBackoff        = 30 * time.Second
+	jobMaxBackoff         = time.Hour
+)
+
+// PDFJobPayload is the payload of a generate_pdf job
+type PDFJobPayload struct {
+	InvoiceID int `json:"invoice_id"`
+}
+
+// JobHandler processes the JSON payload of a queued job
+type JobHandler func(payload []byte) error
+
+// JobService provides a SQLite-backed background job queue with retries
+type JobService struct {
+	dbService    *DBService
+	logger       *Logger
+	handlers     map[string]JobHandler
+	onFailure    func(job *models.Job, err error)
+	mu           sync.RWMutex
+	pollInterval time.Duration
+	wake         chan struct{}
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+// NewJobService creates a new JobService
+func NewJobService(dbService *DBService, logger *Logger) *JobService {
+	s := &JobService{
+		dbService:    dbService,
+		logger:       logger,
+		handlers:     make(map[string]JobHandler),
+		pollInterval: 2 * time.Second,
+		wake:         make(chan struct{}, 1),
+	}
+	// Jobs queued by the transactions of dbService run right away
+	dbService.jobsQueued = s.notify
+	return s
+}
+
+// RegisterHandler registers the handler used to process jobs of the given type
+func (s *JobService) RegisterHandler(jobType string, handler JobHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[jobType] = handler
+}
+
+// OnFailure sets a function that is called when a job fails for the last time
+func (s *JobService) OnFailure(hook func(job *models.Job, err error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onFailure = hook
+}
+
+// Enqueue persists a new job that will be picked up by the worker
+func (s *JobService) Enqueue(jobType string, payload interface{}) (*models.Job, error) {
+	job, err := insertJob(context.Background(), s.dbService.GetDB(), jobType, payload)
+	if err != nil {
+		s.logger.Error("Failed to enqueue %s job: %v", jobType, err)
+		return nil, err
+	}
+
+	s.logger.Info("Enqueued %s job with ID: %d", jobType, job.ID)
+	s.notify()
+	return job, nil
+}
+
+// insertJob writes a pending job using db, which may be the transaction whose
+// changes the job acts on
+func insertJob(ctx context.Context, db rowQueryer, jobType string, payload interface{}) (*models.Job, error) {
+	return insertJobAt(ctx, db, jobType, payload, time.Time{})
+}
+
+// insertJobAt writes a pending job that is not run before runAt, or right
+// away when runAt is zero
+func insertJobAt(ctx context.Context, db rowQueryer, jobType string, payload interface{}, runAt time.Time) (*models.Job, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode job payload: %w", err)
+	}
+
+	now := time.Now().UTC()
+	if runAt.IsZero() {
+		runAt = now
+	}
+	job := &models.Job{
+		Type:        jobType,
+		Payload:     string(payloadBytes),
+		Status:      JobStatusPending,
+		MaxAttempts: defaultJobMaxAttempts,
+		RunAt:       runAt.UTC(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	err = db.QueryRowContext(ctx, `
+		INSERT INTO jobs (type, payload, status, attempts, max_attempts, last_error, run_at, created_at, updated_at)
+		VALUES (?, ?, ?, 0, ?, '', ?, ?, ?)
+		RETURNING id
+	`, job.Type, job.Payload, job.Status, job.MaxAttempts, job.RunAt, job.CreatedAt, job.UpdatedAt).Scan(&job.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	return job, nil
+}
+
+// Start launches the worker goroutine. Jobs left running by a previous
+// process are returned to the queue first.
+func (s *JobService) Start() error {
+	if s.stop != nil {
+		return nil
+	}
+
+	_, err := s.dbService.GetDB().Exec(`
+		UPDATE jobs SET status = ?, updated_at = ? WHERE status = ?
+	`, JobStatusPending, time.Now().UTC(), JobStatusRunning)
+	if err != nil {
+		return fmt.Errorf("failed to requeue interrupted jobs: %w", err)
+	}
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.run()
+
+	s.logger.Info("Job worker started")
+	return nil
+}
+
+// Stop signals the worker to exit and waits for the current job to finish
+func (s *JobService) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+	s.logger.Info("Job worker stopped")
+}
+
+// ListJobs returns the most recent jobs, optionally filtered by status
+func (s *JobService) ListJobs(status string, limit int) ([]models.Job, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	query := `
+		SELECT id, type, payload, status, attempts, max_attempts, last_error, run_at, created_at, updated_at
+		FROM jobs
+	`
+	args := []interface{}{}
+	if status != "" {
+		query += ` WHERE status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.dbService.GetDB().Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []models.Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// GetJob retrieves a single job by ID
+func (s *JobService) GetJob(id int) (*models.Job, error) {
+	row := s.dbService.GetDB().QueryRow(`
+		SELECT id, type, payload, status, attempts, max_attempts, last_error, run_at, created_at, updated_at
+		FROM jobs
+		WHERE id = ?
+	`, id)
+	return scanJob(row)
+}
+
+// RetryJob puts a failed job back on the queue with a fresh attempt budget
+func (s *JobService) RetryJob(id int) error {
+	now := time.Now().UTC()
+	result, err := s.dbService.GetDB().Exec(`
+		UPDATE jobs
+		SET status = ?, attempts = 0, last_error = '', run_at = ?, updated_at = ?
+		WHERE id = ? AND status = ?
+	`, JobStatusPending, now, now, id, JobStatusFailed)
+	if err != nil {
+		return fmt.Errorf("failed to retry job: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("failed job with ID %d not found", id)
+	}
+
+	s.logger.Info("Job %d requeued for retry", id)
+	s.notify()
+	return nil
+}
+
+// DeleteJob removes a job that is not currently running
+func (s *JobService) DeleteJob(id int) error {
+	result, err := s.dbService.GetDB().Exec(`DELETE FROM jobs WHERE id = ? AND status != ?`, id, JobStatusRunning)
+	if err != nil {
+		return fmt.Errorf("failed to delete job: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("job with ID %d not found or still running", id)
+	}
+	return nil
+}
+
+// notify wakes the worker without blocking if it is already awake
+func (s *JobService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run is the worker loop
+func (s *JobService) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain all due jobs before sleeping again
+		for {
+			processed, err := s.processNext()
+			if err != nil {
+				s.logger.Error("Job worker error: %v", err)
+				break
+			}
+			if !processed {
+				break
+			}
+
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// processNext claims and runs the oldest due job. It reports whether a job was processed.
+func (s *JobService) processNext() (bool, error) {
+	db := s.dbService.GetDB()
+	now := time.Now().UTC()
+
+	row := db.QueryRow(`
+		SELECT id, type, payload, status, attempts, max_attempts, last_error, run_at, created_at, updated_at
+		FROM jobs
+		WHERE status = ? AND run_at <= ?
+		ORDER BY run_at, id
+		LIMIT 1
+	`, JobStatusPending, now)
+	job, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch next job: %w", err)
+	}
+
+	// Claim the job so a restart mid-run can be detected
+	result, err := db.Exec(`
+		UPDATE jobs SET status = ?, attempts = attempts + 1, updated_at = ?
+		WHERE id = ? AND status = ?
+	`, JobStatusRunning, now, job.ID, JobStatusPending)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim job %d: %w", job.ID, err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		// Someone else changed the job in the meantime
+		return true, nil
+	}
+	job.Attempts++
+
+	s.mu.RLock()
+	handler, ok := s.handlers[job.Type]
+	onFailure := s.onFailure
+	s.mu.RUnlock()
+
+	var runErr error
+	if !ok {
+		runErr = fmt.Errorf("no handler registered for job type %q", job.Type)
+	} else {
+		s.logger.Debug("Running %s job %d (attempt %d/%d)", job.Type, job.ID, job.Attempts, job.MaxAttempts)
+		runErr = s.runJobHandler(job, handler)
+	}
+
+	finishedAt := time.Now().UTC()
+	if runErr == nil {
+		_, err = db.Exec(`
+			UPDATE jobs SET status = ?, last_error = '', updated_at = ? WHERE id = ?
+		`, JobStatusDone, finishedAt, job.ID)
+		if err != nil {
+			return true, fmt.Errorf("failed to mark job %d as done: %w", job.ID, err)
+		}
+		s.logger.Info("Job %d (%s) completed", job.ID, job.Type)
+		return true, nil
+	}
+
+	if job.Attempts >= job.MaxAttempts || !ok {
+		s.logger.Error("Job %d (%s) failed permanently after %d attempts: %v", job.ID, job.Type, job.Attempts, runErr)
+		_, err = db.Exec(`
+			UPDATE jobs SET status = ?, last_error = ?, updated_at = ? WHERE id = ?
+		`, JobStatusFailed, runErr.Error(), finishedAt, job.ID)
+		if onFailure != nil {
+			job.Status, job.LastError = JobStatusFailed, runErr.Error()
+			onFailure(job, runErr)
+		}
+	} else {
+		nextRun := finishedAt.Add(jobBackoff(job.Attempts))
+		s.logger.Warn("Job %d (%s) failed on attempt %d, retrying at %s: %v",
+			job.ID, job.Type, job.Attempts, nextRun.Format(time.RFC3339), runErr)
+		_, err = db.Exec(`
+			UPDATE jobs SET status = ?, last_error = ?, run_at = ?, updated_at = ? WHERE id = ?
+		`, JobStatusPending, runErr.Error(), nextRun, finishedAt, job.ID)
+	}
+	if err != nil {
+		return true, fmt.Errorf("failed to record failure of job %d: %w", job.ID, err)
+	}
+
+	return true, nil
+}
+
+// runJobHandler invokes a handler, turning panics into errors so one bad job
+// cannot take down the worker
+func (s *JobService) runJobHandler(job *models.Job, handler JobHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			LogPanic(s.logger, fmt.Sprintf("%s job %d", job.Type, job.ID), r)
+			err = fmt.Errorf("job handler panicked: %v", r)
+		}
+	}()
+	return handler([]byte(job.Payload))
+}
+
+// jobBackoff returns the delay before the next attempt, doubling from
+// jobBaseBackoff and capped at jobMaxBackoff
+func jobBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := jobBaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= jobMaxBackoff {
+			return jobMaxBackoff
+		}
+	}
+	return delay
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanJob scans a jobs row into a Job
+func scanJob(row rowScanner) (*models.Job, error) {
+	var job models.Job
+	err := row.Scan(
+		&job.ID,
+		&job.Type,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.LastError,
+		&job.RunAt,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
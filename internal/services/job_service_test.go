@@ -0,0 +1,266 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestJobBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour}, // 64 minutes, capped
+		{20, time.Hour},
+	}
+
+	for _, tt := range tests {
+		result := jobBackoff(tt.attempts)
+		if result != tt.expected {
+			t.Errorf("jobBackoff(%d) = %v, want %v", tt.attempts, result, tt.expected)
+		}
+	}
+}
+
+func TestJobServiceProcessesAndRetries(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	jobService := NewJobService(dbService, NewLogger(ERROR))
+
+	calls := 0
+	jobService.RegisterHandler("test", func(payload []byte) error {
+		calls++
+		if calls == 1 {
+			return errors.New("temporary failure")
+		}
+		return nil
+	})
+
+	job, err := jobService.Enqueue("test", map[string]int{"n": 1})
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+
+	// First attempt fails and is rescheduled with a backoff
+	processed, err := jobService.processNext()
+	if err != nil || !processed {
+		t.Fatalf("processNext() = %v, %v; want true, nil", processed, err)
+	}
+
+	stored, err := jobService.GetJob(job.ID)
+	if err != nil {
+		t.Fatalf("GetJob failed: %v", err)
+	}
+	if stored.Status != JobStatusPending || stored.Attempts != 1 || stored.LastError == "" {
+		t.Fatalf("unexpected job state after failure: %+v", stored)
+	}
+	if !stored.RunAt.After(time.Now()) {
+		t.Errorf("expected retry to be scheduled in the future, got %v", stored.RunAt)
+	}
+
+	// Nothing is due until the backoff expires
+	processed, err = jobService.processNext()
+	if err != nil || processed {
+		t.Fatalf("processNext() = %v, %v; want false, nil", processed, err)
+	}
+
+	// Make the job due again and let it succeed
+	if _, err := dbService.GetDB().Exec(`UPDATE jobs SET run_at = ? WHERE id = ?`, time.Now().UTC().Add(-time.Second), job.ID); err != nil {
+		t.Fatalf("failed to reschedule job: %v", err)
+	}
+	if processed, err = jobService.processNext(); err != nil || !processed {
+		t.Fatalf("processNext() = %v, %v; want true, nil", processed, err)
+	}
+
+	stored, err = jobService.GetJob(job.ID)
+	if err != nil {
+		t.Fatalf("GetJob failed: %v", err)
+	}
+	if stored.Status != JobStatusDone || stored.Attempts != 2 {
+		t.Errorf("unexpected job state after success: %+v", stored)
+	}
+}
+
+func TestJobServiceUnknownTypeFails(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	jobService := NewJobService(dbService, NewLogger(ERROR))
+	job, err := jobService.Enqueue("unknown", struct{}{})
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+
+	if _, err := jobService.processNext(); err != nil {
+		t.Fatalf("processNext failed: %v", err)
+	}
+
+	stored, err := jobService.GetJob(job.ID)
+	if err != nil {
+		t.Fatalf("GetJob failed: %v", err)
+	}
+	if stored.Status != JobStatusFailed {
+		t.Errorf("expected job without handler to fail, got status %s", stored.Status)
+	}
+
+	if err := jobService.RetryJob(job.ID); err != nil {
+		t.Fatalf("RetryJob failed: %v", err)
+	}
+	stored, _ = jobService.GetJob(job.ID)
+	if stored.Status != JobStatusPending || stored.Attempts != 0 {
+		t.Errorf("unexpected job state after retry: %+v", stored)
+	}
+}
+
+func TestSaveInvoiceQueuesPDFInTransaction(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	pdfJobs := func() []string {
+		rows, err := dbService.GetDB().Query(`SELECT payload FROM jobs WHERE type = ? ORDER BY id`, JobTypeGeneratePDF)
+		if err != nil {
+			t.Fatalf("Failed to query jobs: %v", err)
+		}
+		defer rows.Close()
+		var payloads []string
+		for rows.Next() {
+			var payload string
+			if err := rows.Scan(&payload); err != nil {
+				t.Fatalf("Failed to scan job: %v", err)
+			}
+			payloads = append(payloads, payload)
+		}
+		return payloads
+	}
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	items := []models.InvoiceItem{{Description: "Work", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+	newInvoice := func(number string) *models.Invoice {
+		return &models.Invoice{InvoiceNumber: number, BusinessID: 1, ClientID: 1, IssueDate: issueDate, DueDate: issueDate,
+			TotalAmount: 10000, Currency: "EUR", Status: "draft"}
+	}
+
+	invoice := newInvoice("INV-2024-0001")
+	if err := dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("SaveInvoice failed: %v", err)
+	}
+	if jobs := pdfJobs(); len(jobs) != 1 || jobs[0] != `{"invoice_id":1}` {
+		t.Fatalf("Expected one PDF job for the invoice, got %v", jobs)
+	}
+
+	// A rolled back save queues nothing
+	if err := dbService.SaveInvoice(newInvoice("INV-2024-0001"), items); !errors.Is(err, ErrDuplicateInvoiceNumber) {
+		t.Fatalf("Expected ErrDuplicateInvoiceNumber, got %v", err)
+	}
+	// Imported invoices get their PDF when it is first opened
+	if err := dbService.SaveImportedInvoice(newInvoice("INV-2023-0042"), items); err != nil {
+		t.Fatalf("SaveImportedInvoice failed: %v", err)
+	}
+	if jobs := pdfJobs(); len(jobs) != 1 {
+		t.Errorf("Expected no further PDF jobs, got %v", jobs)
+	}
+}
+
+func TestScheduleInvoiceEmail(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	jobService := NewJobService(dbService, NewLogger(ERROR))
+	sent := 0
+	jobService.RegisterHandler(JobTypeSendInvoiceEmail, func(payload []byte) error {
+		sent++
+		return dbService.ScheduledInvoiceEmailSent(1)
+	})
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{InvoiceNumber: "INV-2024-0001", BusinessID: 1, ClientID: 1, IssueDate: issueDate, DueDate: issueDate,
+		Currency: "EUR", Status: "draft"}
+	if err := dbService.SaveInvoice(invoice, nil); err != nil {
+		t.Fatalf("SaveInvoice failed: %v", err)
+	}
+	if _, err := dbService.GetDB().Exec(`DELETE FROM jobs`); err != nil {
+		t.Fatalf("Failed to clear the PDF job: %v", err)
+	}
+
+	schedule := func(sendAt time.Time) *models.ScheduledEmail {
+		email := &models.ScheduledEmail{InvoiceID: invoice.ID, Kind: "invoice", Recipient: "client@example.com", SendAt: sendAt}
+		if err := dbService.ScheduleInvoiceEmail(email, map[string]int{"invoice_id": invoice.ID}); err != nil {
+			t.Fatalf("ScheduleInvoiceEmail failed: %v", err)
+		}
+		return email
+	}
+
+	// Scheduled emails are not sent before their time
+	first := schedule(time.Now().Add(time.Hour))
+	if processed, err := jobService.processNext(); err != nil || processed {
+		t.Fatalf("processNext() = %v, %v; want false, nil", processed, err)
+	}
+
+	// Rescheduling replaces the email and its job
+	second := schedule(time.Now().Add(2 * time.Hour))
+	if _, err := jobService.GetJob(first.JobID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Job of the replaced email still exists: %v", err)
+	}
+	stored, err := dbService.GetScheduledInvoiceEmail(invoice.ID)
+	if err != nil {
+		t.Fatalf("GetScheduledInvoiceEmail failed: %v", err)
+	}
+	if stored.JobID != second.JobID || stored.JobStatus != JobStatusPending || !stored.SendAt.Equal(second.SendAt) {
+		t.Errorf("Scheduled email = %+v, want %+v", stored, second)
+	}
+
+	// An email being sent cannot be cancelled
+	if _, err := dbService.GetDB().Exec(`UPDATE jobs SET status = ? WHERE id = ?`, JobStatusRunning, second.JobID); err != nil {
+		t.Fatalf("Failed to update job: %v", err)
+	}
+	if err := dbService.CancelScheduledInvoiceEmail(invoice.ID); !errors.Is(err, ErrScheduledEmailSending) {
+		t.Errorf("CancelScheduledInvoiceEmail() = %v, want ErrScheduledEmailSending", err)
+	}
+	if _, err := dbService.GetDB().Exec(`UPDATE jobs SET status = ? WHERE id = ?`, JobStatusPending, second.JobID); err != nil {
+		t.Fatalf("Failed to update job: %v", err)
+	}
+
+	if err := dbService.CancelScheduledInvoiceEmail(invoice.ID); err != nil {
+		t.Fatalf("CancelScheduledInvoiceEmail failed: %v", err)
+	}
+	if _, err := dbService.GetScheduledInvoiceEmail(invoice.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Cancelled email is still scheduled: %v", err)
+	}
+	if _, err := jobService.GetJob(second.JobID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Job of the cancelled email still exists: %v", err)
+	}
+	if err := dbService.CancelScheduledInvoiceEmail(invoice.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("CancelScheduledInvoiceEmail() without an email = %v, want sql.ErrNoRows", err)
+	}
+
+	// Once due the email is sent and no longer scheduled
+	due := schedule(time.Now().Add(time.Hour))
+	if _, err := dbService.GetDB().Exec(`UPDATE jobs SET run_at = ? WHERE id = ?`, time.Now().UTC().Add(-time.Minute), due.JobID); err != nil {
+		t.Fatalf("Failed to update job: %v", err)
+	}
+	if processed, err := jobService.processNext(); err != nil || !processed || sent != 1 {
+		t.Fatalf("processNext() = %v, %v with %d emails sent; want true, nil with 1", processed, err, sent)
+	}
+	if _, err := dbService.GetScheduledInvoiceEmail(invoice.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Sent email is still scheduled: %v", err)
+	}
+
+	// Deleting the invoice cancels its scheduled email
+	last := schedule(time.Now().Add(time.Hour))
+	if err := dbService.DeleteInvoice(invoice.ID); err != nil {
+		t.Fatalf("DeleteInvoice failed: %v", err)
+	}
+	if _, err := jobService.GetJob(last.JobID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Job of the deleted invoice's email still exists: %v", err)
+	}
+}
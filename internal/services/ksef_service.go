@@ -0,0 +1,1031 @@
+package services
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/refdata"
+)
+
+// ErrKSeFNotConfigured is returned when sending to KSeF without a token
+var ErrKSeFNotConfigured = errors.New("KSeF is not configured")
+
+// ErrKSeFInvalid is returned when an invoice is not sent to KSeF or lacks data
+// an FA(2) invoice requires
+var ErrKSeFInvalid = errors.New("invoice cannot be sent to KSeF")
+
+// The FA(2) structured invoice schema, as named when opening a session
+const (
+	ksefNamespace     = "http://crd.gov.pl/wzor/2023/06/29/12648/"
+	ksefSystemCode    = "FA (2)"
+	ksefSchemaVersion = "1-0E"
+)
+
+// Namespaces of the session request of the KSeF API
+const (
+	ksefOnlineNamespace  = "http://ksef.mf.gov.pl/schema/gtw/svc/online/types/2021/10/01/0001"
+	ksefTypesNamespace   = "http://ksef.mf.gov.pl/schema/gtw/svc/types/2021/10/01/0001"
+	ksefRequestNamespace = "http://ksef.mf.gov.pl/schema/gtw/svc/online/auth/request/2021/10/01/0001"
+	ksefXSINamespace     = "http://www.w3.org/2001/XMLSchema-instance"
+)
+
+// ksefInterval is how often the queue is processed
+const ksefInterval = time.Minute
+
+// ksefMaxBackoff caps the wait before a failed attempt is retried
+const ksefMaxBackoff = 6 * time.Hour
+
+// ksefProcessed is the processing code of an invoice KSeF accepted; lower
+// codes mean it is still being processed, higher ones that it was rejected
+const ksefProcessed = 200
+
+// ksefUnits are the units of measure of invoice items as printed in Polish
+var ksefUnits = map[string]string{
+	models.UnitHours:      "godz.",
+	models.UnitDays:       "dzień",
+	models.UnitPieces:     "szt.",
+	models.UnitKilometres: "km",
+	models.UnitFlat:       "ryczałt",
+}
+
+// IsKSeFBusiness reports whether the invoices of a business are sent to KSeF:
+// those of businesses with a PL VAT ID
+func IsKSeFBusiness(business *models.Business) bool {
+	return ksefNIP(business.VatID) != ""
+}
+
+// ksefNIP returns the ten-digit tax ID (NIP) in a Polish VAT ID, with or
+// without the PL prefix and dashes, or an empty string
+func ksefNIP(vatID string) string {
+	digits := strings.TrimPrefix(strings.ReplaceAll(compactUpper(vatID), "-", ""), "PL")
+	if len(digits) != 10 || strings.Trim(digits, "0123456789") != "" {
+		return ""
+	}
+	return digits
+}
+
+// KSeFService sends the invoices of Polish businesses to KSeF (Krajowy System
+// e-Faktur), the national e-invoicing system of Poland, as FA(2) structured
+// invoices. Invoices are queued once finalized and retried until KSeF has
+// assigned them a KSeF number.
+type KSeFService struct {
+	dbService       *DBService
+	settingsService *SettingsService
+	exchangeRates   *ExchangeRateService
+	compliance      *ComplianceService
+	logger          *Logger
+	client          *http.Client
+	version         string     // Reported as the invoicing software
+	mu              sync.Mutex // Serializes processing the queue
+	stop            chan struct{}
+	done            chan struct{}
+}
+
+// NewKSeFService creates a new KSeFService
+func NewKSeFService(dbService *DBService, settingsService *SettingsService, version string, logger *Logger) *KSeFService {
+	return &KSeFService{
+		dbService:       dbService,
+		settingsService: settingsService,
+		logger:          logger,
+		client:          &http.Client{Timeout: 30 * time.Second},
+		version:         version,
+	}
+}
+
+// SetExchangeRateService sets the service złoty amounts of invoices in other
+// currencies are converted with, when they carry no złoty exchange rate
+func (s *KSeFService) SetExchangeRateService(exchangeRates *ExchangeRateService) {
+	s.exchangeRates = exchangeRates
+}
+
+// SetComplianceService sets the service whose profiles decide which
+// businesses send their invoices to KSeF
+func (s *KSeFService) SetComplianceService(compliance *ComplianceService) {
+	s.compliance = compliance
+}
+
+// Configured reports whether the token and the KSeF public key are set
+func (s *KSeFService) Configured() bool {
+	return s.settingsService.GetString(SettingKSeFToken) != "" && s.settingsService.GetString(SettingKSeFPublicKeyPath) != ""
+}
+
+// Start processes the queue periodically
+func (s *KSeFService) Start() {
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(ksefInterval):
+			}
+
+			RunProtected(s.logger, "KSeF", func() {
+				if !s.Configured() {
+					return
+				}
+				if err := s.Process(); err != nil {
+					s.logger.Error("Failed to send invoices to KSeF: %v", err)
+				}
+			})
+		}
+	}()
+}
+
+// Stop stops the processing started by Start
+func (s *KSeFService) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
+
+// Status returns how an invoice was sent to KSeF, or nil if it was not queued
+func (s *KSeFService) Status(invoiceID int) (*models.KSeFInvoice, error) {
+	var sent models.KSeFInvoice
+	var submittedAt, acceptedAt sql.NullTime
+	err := s.dbService.GetDB().QueryRow(`
+		SELECT invoice_id, status, reference_number, ksef_number, attempts, message, next_attempt_at, submitted_at, accepted_at, updated_at
+		FROM ksef_invoices WHERE invoice_id = ?
+	`, invoiceID).Scan(&sent.InvoiceID, &sent.Status, &sent.ReferenceNumber, &sent.KSeFNumber, &sent.Attempts, &sent.Message,
+		&sent.NextAttemptAt, &submittedAt, &acceptedAt, &sent.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load KSeF status: %w", err)
+	}
+	if submittedAt.Valid {
+		sent.SubmittedAt = submittedAt.Time
+	}
+	if acceptedAt.Valid {
+		sent.AcceptedAt = acceptedAt.Time
+	}
+	return &sent, nil
+}
+
+// Number returns the KSeF number of an invoice, empty until KSeF accepted it
+func (s *KSeFService) Number(invoiceID int) (string, error) {
+	sent, err := s.Status(invoiceID)
+	if err != nil || sent == nil {
+		return "", err
+	}
+	return sent.KSeFNumber, nil
+}
+
+// Generate returns the FA(2) structured invoice of an invoice and its
+// filename, for example to upload it to KSeF by other means
+func (s *KSeFService) Generate(invoiceID int) ([]byte, string, error) {
+	invoice, items, err := s.dbService.GetInvoice(invoiceID)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := s.structuredInvoice(invoice, items)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, strings.TrimSuffix(invoice.PDFFilename(), ".pdf") + "_FA2.xml", nil
+}
+
+// Submit sends an invoice now rather than with the next run of the queue, for
+// example again after KSeF rejected it. A failed attempt leaves it queued.
+func (s *KSeFService) Submit(invoiceID int) (*models.KSeFInvoice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.Configured() {
+		return nil, fmt.Errorf("%w: set the token and the KSeF public key on the Settings page", ErrKSeFNotConfigured)
+	}
+	invoice, _, err := s.dbService.GetInvoice(invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	business, err := s.dbService.GetBusiness(invoice.BusinessID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load business: %w", err)
+	}
+	allowed, err := complianceAllows(s.compliance, business, models.ComplianceKSeF)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !IsKSeFBusiness(business):
+		return nil, fmt.Errorf("%w: only invoices of businesses with a PL VAT ID are sent", ErrKSeFInvalid)
+	case !allowed:
+		return nil, fmt.Errorf("%w: the compliance profile of the business does not send invoices to KSeF", ErrKSeFInvalid)
+	case !isBooked(invoice):
+		return nil, fmt.Errorf("%w: drafts and pro-forma invoices are not sent", ErrKSeFInvalid)
+	}
+	sent, err := s.Status(invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	if sent != nil && (sent.Status == models.KSeFSubmitted || sent.Status == models.KSeFAccepted) {
+		return nil, fmt.Errorf("%w: the invoice was sent already", ErrKSeFInvalid)
+	}
+
+	now := time.Now().UTC()
+	if _, err := s.dbService.GetDB().Exec(`
+		INSERT INTO ksef_invoices (invoice_id, status, next_attempt_at, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (invoice_id) DO UPDATE SET status = excluded.status, attempts = 0, next_attempt_at = excluded.next_attempt_at, updated_at = excluded.updated_at
+	`, invoiceID, models.KSeFQueued, now, now); err != nil {
+		return nil, fmt.Errorf("failed to queue invoice for KSeF: %w", err)
+	}
+	if sent, err = s.Status(invoiceID); err != nil {
+		return nil, err
+	}
+	if err := s.processBusiness(business, []*models.KSeFInvoice{sent}); err != nil {
+		return nil, err
+	}
+	return s.Status(invoiceID)
+}
+
+// Process queues the invoices issued since the start date, sends those due
+// and checks how KSeF processed those submitted, in a session per business.
+// Failures of single invoices are recorded on them; only database errors are
+// returned.
+func (s *KSeFService) Process() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.Configured() {
+		return ErrKSeFNotConfigured
+	}
+	if err := s.enqueue(); err != nil {
+		return err
+	}
+
+	rows, err := s.dbService.GetDB().Query(`SELECT invoice_id FROM ksef_invoices WHERE status IN (?, ?) ORDER BY invoice_id`,
+		models.KSeFQueued, models.KSeFSubmitted)
+	if err != nil {
+		return fmt.Errorf("failed to load KSeF invoices: %w", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan KSeF invoice: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load KSeF invoices: %w", err)
+	}
+
+	// Invoices due are grouped by business, which each get a session
+	now := time.Now()
+	var businessIDs []int
+	due := map[int][]*models.KSeFInvoice{}
+	for _, id := range ids {
+		sent, err := s.Status(id)
+		if err != nil {
+			return err
+		}
+		if sent.Status == models.KSeFQueued && sent.NextAttemptAt.After(now) {
+			continue
+		}
+		invoice, _, err := s.dbService.GetInvoice(id)
+		if err != nil {
+			return err
+		}
+		if _, ok := due[invoice.BusinessID]; !ok {
+			businessIDs = append(businessIDs, invoice.BusinessID)
+		}
+		due[invoice.BusinessID] = append(due[invoice.BusinessID], sent)
+	}
+	for _, businessID := range businessIDs {
+		business, err := s.dbService.GetBusiness(businessID)
+		if err != nil {
+			return fmt.Errorf("failed to load business: %w", err)
+		}
+		if err := s.processBusiness(business, due[businessID]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueue queues the finalized invoices of Polish businesses issued on or
+// after the start date that were not queued yet. Without a date, it is set to
+// today, so invoices issued before KSeF was set up are left out.
+func (s *KSeFService) enqueue() error {
+	from := s.settingsService.GetString(SettingKSeFFrom)
+	if from == "" {
+		from = time.Now().Format("2006-01-02")
+		if err := s.settingsService.Set(SettingKSeFFrom, from); err != nil {
+			return err
+		}
+	}
+	fromDate, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return fmt.Errorf("invalid KSeF start date %q: %w", from, err)
+	}
+
+	queued := map[int]bool{}
+	rows, err := s.dbService.GetDB().Query(`SELECT invoice_id FROM ksef_invoices`)
+	if err != nil {
+		return fmt.Errorf("failed to load KSeF invoices: %w", err)
+	}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan KSeF invoice: %w", err)
+		}
+		queued[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load KSeF invoices: %w", err)
+	}
+
+	invoices, err := s.dbService.GetInvoices()
+	if err != nil {
+		return err
+	}
+	polish := map[int]bool{}
+	for _, invoice := range invoices {
+		if queued[invoice.ID] || !isBooked(&invoice) || invoice.IssueDate.Before(fromDate) {
+			continue
+		}
+		isKSeF, ok := polish[invoice.BusinessID]
+		if !ok {
+			business, err := s.dbService.GetBusiness(invoice.BusinessID)
+			if err != nil {
+				return fmt.Errorf("failed to load business: %w", err)
+			}
+			if isKSeF = IsKSeFBusiness(business); isKSeF {
+				if isKSeF, err = complianceAllows(s.compliance, business, models.ComplianceKSeF); err != nil {
+					return err
+				}
+			}
+			polish[invoice.BusinessID] = isKSeF
+		}
+		if !isKSeF {
+			continue
+		}
+		now := time.Now().UTC()
+		if _, err := s.dbService.GetDB().Exec(`
+			INSERT INTO ksef_invoices (invoice_id, status, next_attempt_at, updated_at) VALUES (?, ?, ?, ?)
+		`, invoice.ID, models.KSeFQueued, now, now); err != nil {
+			return fmt.Errorf("failed to queue invoice for KSeF: %w", err)
+		}
+	}
+	return nil
+}
+
+// processBusiness opens a session for a business, sends its queued invoices
+// and checks those submitted. Without a session, the queued invoices are
+// retried later and the submitted ones checked with the next run.
+func (s *KSeFService) processBusiness(business *models.Business, invoices []*models.KSeFInvoice) error {
+	session, err := s.openSession(ksefNIP(business.VatID))
+	if err != nil {
+		s.logger.Warn("Failed to open a KSeF session for business %d: %v", business.ID, err)
+		for _, sent := range invoices {
+			if sent.Status != models.KSeFQueued {
+				continue
+			}
+			if err := s.retryLater(sent, err); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	defer s.closeSession(session)
+
+	for _, sent := range invoices {
+		if sent.Status == models.KSeFQueued {
+			err = s.send(session, sent)
+		} else {
+			err = s.checkStatus(session, sent)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send sends the structured invoice of a queued invoice. Failures are
+// recorded on it and retried later, waiting longer after every attempt.
+func (s *KSeFService) send(session string, sent *models.KSeFInvoice) error {
+	invoice, items, err := s.dbService.GetInvoice(sent.InvoiceID)
+	if err != nil {
+		return err
+	}
+	data, err := s.structuredInvoice(invoice, items)
+	var referenceNumber string
+	if err == nil {
+		referenceNumber, err = s.sendInvoice(session, data)
+	}
+	if err != nil {
+		s.logger.Warn("Failed to send invoice %d to KSeF: %v", sent.InvoiceID, err)
+		return s.retryLater(sent, err)
+	}
+
+	s.logger.Info("Sent invoice %d to KSeF as element %s", sent.InvoiceID, referenceNumber)
+	now := time.Now().UTC()
+	if _, err := s.dbService.GetDB().Exec(`
+		UPDATE ksef_invoices SET status = ?, reference_number = ?, attempts = 0, message = '', submitted_at = ?, updated_at = ? WHERE invoice_id = ?
+	`, models.KSeFSubmitted, referenceNumber, now, now, sent.InvoiceID); err != nil {
+		return fmt.Errorf("failed to update KSeF invoice: %w", err)
+	}
+	sent.Status, sent.ReferenceNumber = models.KSeFSubmitted, referenceNumber
+	return nil
+}
+
+// retryLater records a failed attempt to send a queued invoice
+func (s *KSeFService) retryLater(sent *models.KSeFInvoice, cause error) error {
+	now := time.Now().UTC()
+	backoff := min(time.Minute<<min(sent.Attempts, 16), ksefMaxBackoff)
+	if _, err := s.dbService.GetDB().Exec(`
+		UPDATE ksef_invoices SET attempts = attempts + 1, message = ?, next_attempt_at = ?, updated_at = ? WHERE invoice_id = ?
+	`, cause.Error(), now.Add(backoff), now, sent.InvoiceID); err != nil {
+		return fmt.Errorf("failed to update KSeF invoice: %w", err)
+	}
+	return nil
+}
+
+// checkStatus asks KSeF how it processed a submitted invoice. Invoices still
+// being processed are checked again on the next run.
+func (s *KSeFService) checkStatus(session string, sent *models.KSeFInvoice) error {
+	var response struct {
+		ProcessingCode        int    `json:"processingCode"`
+		ProcessingDescription string `json:"processingDescription"`
+		InvoiceStatus         struct {
+			KSeFNumber string    `json:"ksefReferenceNumber"`
+			AcquiredAt time.Time `json:"acquisitionTimestamp"`
+		} `json:"invoiceStatus"`
+	}
+	if err := s.call(http.MethodGet, "online/Invoice/Status/"+sent.ReferenceNumber, session, "", nil, &response); err != nil {
+		s.logger.Warn("Failed to check the KSeF status of invoice %d: %v", sent.InvoiceID, err)
+		return nil
+	}
+
+	now := time.Now().UTC()
+	var err error
+	switch {
+	case response.ProcessingCode < ksefProcessed:
+		return nil
+	case response.ProcessingCode == ksefProcessed && response.InvoiceStatus.KSeFNumber != "":
+		s.logger.Info("KSeF accepted invoice %d as %s", sent.InvoiceID, response.InvoiceStatus.KSeFNumber)
+		_, err = s.dbService.GetDB().Exec(`UPDATE ksef_invoices SET status = ?, ksef_number = ?, accepted_at = ?, updated_at = ? WHERE invoice_id = ?`,
+			models.KSeFAccepted, response.InvoiceStatus.KSeFNumber, cmp.Or(response.InvoiceStatus.AcquiredAt, now), now, sent.InvoiceID)
+	default:
+		message := fmt.Sprintf("%d: %s", response.ProcessingCode, response.ProcessingDescription)
+		s.logger.Warn("KSeF rejected invoice %d: %s", sent.InvoiceID, message)
+		_, err = s.dbService.GetDB().Exec(`UPDATE ksef_invoices SET status = ?, message = ?, updated_at = ? WHERE invoice_id = ?`,
+			models.KSeFRejected, message, now, sent.InvoiceID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update KSeF invoice: %w", err)
+	}
+	return nil
+}
+
+// openSession opens an interactive session for a NIP, authorizing it with
+// the token, and returns the session token
+func (s *KSeFService) openSession(nip string) (string, error) {
+	var challenge struct {
+		Timestamp time.Time `json:"timestamp"`
+		Challenge string    `json:"challenge"`
+	}
+	request, _ := json.Marshal(map[string]interface{}{"contextIdentifier": map[string]string{"type": "onip", "identifier": nip}})
+	if err := s.call(http.MethodPost, "online/Session/AuthorisationChallenge", "", "application/json", request, &challenge); err != nil {
+		return "", err
+	}
+	token, err := s.encryptToken(challenge.Timestamp)
+	if err != nil {
+		return "", err
+	}
+
+	init := ksefInitSessionTokenRequest{
+		OnlineNamespace:  ksefOnlineNamespace,
+		TypesNamespace:   ksefTypesNamespace,
+		RequestNamespace: ksefRequestNamespace,
+		XSINamespace:     ksefXSINamespace,
+		Challenge:        challenge.Challenge,
+		Identifier:       ksefIdentifier{Type: "ns2:SubjectIdentifierByCompanyType", Identifier: nip},
+		DocumentType: ksefDocumentType{
+			Service:         "KSeF",
+			SystemCode:      ksefSystemCode,
+			SchemaVersion:   ksefSchemaVersion,
+			TargetNamespace: ksefNamespace,
+			Value:           "FA",
+		},
+		Token: token,
+	}
+	body, err := xml.Marshal(init)
+	if err != nil {
+		return "", fmt.Errorf("failed to write KSeF session request: %w", err)
+	}
+	var session struct {
+		SessionToken struct {
+			Token string `json:"token"`
+		} `json:"sessionToken"`
+	}
+	if err := s.call(http.MethodPost, "online/Session/InitToken", "", "application/octet-stream", append([]byte(xml.Header), body...), &session); err != nil {
+		return "", err
+	}
+	if session.SessionToken.Token == "" {
+		return "", errors.New("KSeF returned no session token")
+	}
+	return session.SessionToken.Token, nil
+}
+
+// closeSession terminates a session; KSeF closes abandoned sessions itself
+func (s *KSeFService) closeSession(session string) {
+	if err := s.call(http.MethodGet, "online/Session/Terminate", session, "", nil, nil); err != nil {
+		s.logger.Warn("Failed to close KSeF session: %v", err)
+	}
+}
+
+// encryptToken encrypts the token with the time of the challenge, as KSeF
+// requires: RSA with the KSeF public key, in base64
+func (s *KSeFService) encryptToken(timestamp time.Time) (string, error) {
+	data, err := os.ReadFile(s.settingsService.GetString(SettingKSeFPublicKeyPath))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the KSeF public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "", errors.New("the KSeF public key is not a PEM file")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the KSeF public key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return "", errors.New("the KSeF public key is not an RSA key")
+	}
+	plain := s.settingsService.GetString(SettingKSeFToken) + "|" + strconv.FormatInt(timestamp.UnixMilli(), 10)
+	encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, rsaKey, []byte(plain))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt the KSeF token: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+// sendInvoice sends a structured invoice in a session and returns the
+// reference number of the element its status is checked by
+func (s *KSeFService) sendInvoice(session string, data []byte) (string, error) {
+	hash := sha256.Sum256(data)
+	var request ksefSendInvoiceRequest
+	request.InvoiceHash.HashSHA.Algorithm = "SHA-256"
+	request.InvoiceHash.HashSHA.Encoding = "Base64"
+	request.InvoiceHash.HashSHA.Value = base64.StdEncoding.EncodeToString(hash[:])
+	request.InvoiceHash.FileSize = len(data)
+	request.InvoicePayload.Type = "plain"
+	request.InvoicePayload.InvoiceBody = base64.StdEncoding.EncodeToString(data)
+	body, _ := json.Marshal(request)
+
+	var response struct {
+		ElementReferenceNumber string `json:"elementReferenceNumber"`
+	}
+	if err := s.call(http.MethodPut, "online/Invoice/Send", session, "application/json", body, &response); err != nil {
+		return "", err
+	}
+	if response.ElementReferenceNumber == "" {
+		return "", errors.New("KSeF returned no element reference number")
+	}
+	return response.ElementReferenceNumber, nil
+}
+
+// call sends a request to the KSeF API and decodes the JSON response. KSeF
+// answers errors with a list of exceptions.
+func (s *KSeFService) call(method, path, session, contentType string, body []byte, response interface{}) error {
+	apiURL := strings.TrimRight(s.settingsService.GetString(SettingKSeFAPIURL), "/")
+	req, err := http.NewRequest(method, apiURL+"/"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	if session != "" {
+		req.Header.Set("SessionToken", session)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("KSeF could not be reached: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read KSeF response: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		var exception struct {
+			Exception struct {
+				Details []struct {
+					Code        int    `json:"exceptionCode"`
+					Description string `json:"exceptionDescription"`
+				} `json:"exceptionDetailList"`
+			} `json:"exception"`
+		}
+		json.Unmarshal(data, &exception)
+		var messages []string
+		for _, detail := range exception.Exception.Details {
+			messages = append(messages, fmt.Sprintf("%d %s", detail.Code, detail.Description))
+		}
+		if len(messages) > 0 {
+			return fmt.Errorf("KSeF refused the request: %s", strings.Join(messages, "; "))
+		}
+		return fmt.Errorf("KSeF answered %s", resp.Status)
+	}
+	if response == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, response); err != nil {
+		return fmt.Errorf("failed to parse KSeF response: %w", err)
+	}
+	return nil
+}
+
+// structuredInvoice builds the FA(2) XML of an invoice
+func (s *KSeFService) structuredInvoice(invoice *models.Invoice, items []models.InvoiceItem) ([]byte, error) {
+	business, err := s.dbService.GetBusiness(invoice.BusinessID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load business: %w", err)
+	}
+	client, err := s.dbService.GetClient(invoice.ClientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client: %w", err)
+	}
+	if !IsKSeFBusiness(business) {
+		return nil, fmt.Errorf("%w: only invoices of businesses with a PL VAT ID are sent", ErrKSeFInvalid)
+	}
+	if allowed, err := complianceAllows(s.compliance, business, models.ComplianceKSeF); err != nil {
+		return nil, err
+	} else if !allowed {
+		return nil, fmt.Errorf("%w: the compliance profile of the business does not send invoices to KSeF", ErrKSeFInvalid)
+	}
+	rate, err := s.plnRate(invoice)
+	if err != nil {
+		return nil, err
+	}
+	faktura, err := newKSeFInvoice(invoice, items, business, client, rate, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	faktura.Header.System = fatturaPAText("Simple Invoice "+s.version, 256)
+	data, err := xml.Marshal(faktura)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write FA(2) invoice: %w", err)
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// plnRate returns the złoty per unit of the invoice currency: the rate of the
+// invoice when its home currency is the złoty, or else the ECB reference rate
+// of the day before the delivery date, as Polish VAT law takes the rate of
+// the last working day before it
+func (s *KSeFService) plnRate(invoice *models.Invoice) (float64, error) {
+	switch {
+	case invoice.Currency == "PLN":
+		return 1, nil
+	case invoice.HasExchangeRate() && invoice.HomeCurrency == "PLN":
+		return invoice.ExchangeRate, nil
+	case s.exchangeRates == nil:
+		return 0, fmt.Errorf("%w: no złoty exchange rate for %s", ErrKSeFInvalid, invoice.Currency)
+	}
+	rate, _, err := s.exchangeRates.Rate(invoice.Currency, "PLN", navDeliveryDate(invoice).AddDate(0, 0, -1))
+	if err != nil {
+		return 0, fmt.Errorf("no złoty exchange rate for %s: %w", invoice.Currency, err)
+	}
+	return rate, nil
+}
+
+// ksefInitSessionTokenRequest opens a session authorized with a token
+type ksefInitSessionTokenRequest struct {
+	XMLName          xml.Name         `xml:"ns3:InitSessionTokenRequest"`
+	OnlineNamespace  string           `xml:"xmlns,attr"`
+	TypesNamespace   string           `xml:"xmlns:ns2,attr"`
+	RequestNamespace string           `xml:"xmlns:ns3,attr"`
+	XSINamespace     string           `xml:"xmlns:xsi,attr"`
+	Challenge        string           `xml:"ns3:Context>Challenge"`
+	Identifier       ksefIdentifier   `xml:"ns3:Context>Identifier"`
+	DocumentType     ksefDocumentType `xml:"ns3:Context>DocumentType"`
+	Token            string           `xml:"ns3:Context>Token"`
+}
+
+type ksefIdentifier struct {
+	Type       string `xml:"xsi:type,attr"`
+	Identifier string `xml:"ns2:Identifier"`
+}
+
+type ksefDocumentType struct {
+	Service         string `xml:"ns2:Service"`
+	SystemCode      string `xml:"ns2:FormCode>ns2:SystemCode"`
+	SchemaVersion   string `xml:"ns2:FormCode>ns2:SchemaVersion"`
+	TargetNamespace string `xml:"ns2:FormCode>ns2:TargetNamespace"`
+	Value           string `xml:"ns2:FormCode>ns2:Value"`
+}
+
+type ksefSendInvoiceRequest struct {
+	InvoiceHash struct {
+		HashSHA struct {
+			Algorithm string `json:"algorithm"`
+			Encoding  string `json:"encoding"`
+			Value     string `json:"value"`
+		} `json:"hashSHA"`
+		FileSize int `json:"fileSize"`
+	} `json:"invoiceHash"`
+	InvoicePayload struct {
+		Type        string `json:"type"` // plain, or encrypted for sessions with an encryption key
+		InvoiceBody string `json:"invoiceBody"`
+	} `json:"invoicePayload"`
+}
+
+// ksefInvoice is an FA(2) structured invoice
+type ksefInvoice struct {
+	XMLName xml.Name   `xml:"http://crd.gov.pl/wzor/2023/06/29/12648/ Faktura"`
+	Header  ksefHeader `xml:"Naglowek"`
+	Seller  ksefParty  `xml:"Podmiot1"`
+	Buyer   ksefParty  `xml:"Podmiot2"`
+	Details ksefFa     `xml:"Fa"`
+}
+
+type ksefHeader struct {
+	FormCode  ksefFormCode `xml:"KodFormularza"`
+	Variant   int          `xml:"WariantFormularza"`
+	CreatedAt string       `xml:"DataWytworzeniaFa"`
+	System    string       `xml:"SystemInfo"`
+}
+
+type ksefFormCode struct {
+	SystemCode    string `xml:"kodSystemowy,attr"`
+	SchemaVersion string `xml:"wersjaSchemy,attr"`
+	Value         string `xml:",chardata"`
+}
+
+// ksefParty identifies the seller or the buyer by one of NIP, EU VAT ID,
+// foreign tax ID, or none for consumers
+type ksefParty struct {
+	NIP       string       `xml:"DaneIdentyfikacyjne>NIP,omitempty"`
+	EUCountry string       `xml:"DaneIdentyfikacyjne>KodUE,omitempty"`
+	EUVatID   string       `xml:"DaneIdentyfikacyjne>NrVatUE,omitempty"`
+	Country   string       `xml:"DaneIdentyfikacyjne>KodKraju,omitempty"`
+	OtherID   string       `xml:"DaneIdentyfikacyjne>NrID,omitempty"`
+	NoID      string       `xml:"DaneIdentyfikacyjne>BrakID,omitempty"`
+	Name      string       `xml:"DaneIdentyfikacyjne>Nazwa"`
+	Address   *ksefAddress `xml:"Adres,omitempty"`
+}
+
+type ksefAddress struct {
+	Country string `xml:"KodKraju"`
+	Line1   string `xml:"AdresL1"`
+	Line2   string `xml:"AdresL2,omitempty"`
+}
+
+// ksefFa holds the invoice details. The net amounts and VAT are given for the
+// one VAT rate of the invoice, in the fields of that rate (P_13_x and P_14_x).
+type ksefFa struct {
+	Currency         string          `xml:"KodWaluty"`
+	IssueDate        string          `xml:"P_1"`
+	Number           string          `xml:"P_2"`
+	DeliveryDate     string          `xml:"P_6,omitempty"`
+	PeriodStart      string          `xml:"OkresFa>P_6_Od,omitempty"`
+	PeriodEnd        string          `xml:"OkresFa>P_6_Do,omitempty"`
+	Net1             string          `xml:"P_13_1,omitempty"` // 23% or 22%
+	Vat1             string          `xml:"P_14_1,omitempty"`
+	Vat1PLN          string          `xml:"P_14_1W,omitempty"`
+	Net2             string          `xml:"P_13_2,omitempty"` // 8% or 7%
+	Vat2             string          `xml:"P_14_2,omitempty"`
+	Vat2PLN          string          `xml:"P_14_2W,omitempty"`
+	Net3             string          `xml:"P_13_3,omitempty"` // 5%
+	Vat3             string          `xml:"P_14_3,omitempty"`
+	Vat3PLN          string          `xml:"P_14_3W,omitempty"`
+	NetZero          string          `xml:"P_13_6_1,omitempty"` // 0% in Poland
+	NetExempt        string          `xml:"P_13_7,omitempty"`
+	NetAbroad        string          `xml:"P_13_8,omitempty"`  // Supplies outside Poland
+	NetEUServices    string          `xml:"P_13_9,omitempty"`  // Services to EU businesses, art. 100 ust. 1 pkt 4
+	NetReverseCharge string          `xml:"P_13_10,omitempty"` // Domestic reverse charge
+	Total            string          `xml:"P_15"`
+	Annotations      ksefAnnotations `xml:"Adnotacje"`
+	Kind             string          `xml:"RodzajFaktury"` // VAT for a regular invoice
+	Lines            []ksefLine      `xml:"FaWiersz"`
+	Payment          ksefPayment     `xml:"Platnosc"`
+}
+
+// ksefAnnotations are the statements every invoice makes, 1 for yes and 2 for no
+type ksefAnnotations struct {
+	CashAccounting       int    `xml:"P_16"`
+	SelfBilling          int    `xml:"P_17"`
+	ReverseCharge        int    `xml:"P_18"`
+	SplitPayment         int    `xml:"P_18A"`
+	Exempt               string `xml:"Zwolnienie>P_19,omitempty"`
+	ExemptionBasis       string `xml:"Zwolnienie>P_19A,omitempty"`
+	NotExempt            string `xml:"Zwolnienie>P_19N,omitempty"`
+	NoNewVehicles        int    `xml:"NoweSrodkiTransportu>P_22N"`
+	SimplifiedTriangular int    `xml:"P_23"`
+	NoMarginScheme       int    `xml:"PMarzy>P_PMarzyN"`
+}
+
+type ksefLine struct {
+	Number       int    `xml:"NrWierszaFa"`
+	Description  string `xml:"P_7"`
+	Unit         string `xml:"P_8A,omitempty"`
+	Quantity     string `xml:"P_8B,omitempty"`
+	UnitPrice    string `xml:"P_9A,omitempty"`
+	Discount     string `xml:"P_10,omitempty"`
+	NetAmount    string `xml:"P_11"`
+	VatRate      string `xml:"P_12"` // The rate, or zw (exempt), oo (reverse charge) or np (not taxed in Poland)
+	ExchangeRate string `xml:"KursWaluty,omitempty"`
+}
+
+type ksefPayment struct {
+	DueDate string `xml:"TerminPlatnosci>Termin"`
+	Method  int    `xml:"FormaPlatnosci"` // 6 for a bank transfer
+	Account string `xml:"RachunekBankowy>NrRB,omitempty"`
+}
+
+// newKSeFInvoice builds the FA(2) structured invoice of an invoice, checking
+// the data KSeF requires. Invoices in other currencies also give their VAT in
+// złoty at rate.
+func newKSeFInvoice(invoice *models.Invoice, items []models.InvoiceItem, business *models.Business, client *models.Client, rate float64, now time.Time) (*ksefInvoice, error) {
+	nip := ksefNIP(business.VatID)
+	switch {
+	case nip == "":
+		return nil, fmt.Errorf("%w: only invoices of businesses with a PL VAT ID are sent", ErrKSeFInvalid)
+	case !isBooked(invoice):
+		return nil, fmt.Errorf("%w: drafts and pro-forma invoices are not sent", ErrKSeFInvalid)
+	case invoice.HasMixedVat(items):
+		return nil, fmt.Errorf("%w: items outside the VAT base cannot be combined with VAT", ErrKSeFInvalid)
+	case invoice.IsStorno():
+		return nil, fmt.Errorf("%w: cancellation invoices are not sent", ErrKSeFInvalid)
+	case invoice.InvoiceNumber == "" || len([]rune(invoice.InvoiceNumber)) > 256:
+		return nil, fmt.Errorf("%w: the invoice number needs 1 to 256 characters", ErrKSeFInvalid)
+	case business.Address == "" || business.City == "":
+		return nil, fmt.Errorf("%w: the business needs an address and a city", ErrKSeFInvalid)
+	case len(items) == 0:
+		return nil, fmt.Errorf("%w: the invoice has no items", ErrKSeFInvalid)
+	}
+	rate = math.Round(rate*1e4) / 1e4
+	foreign := invoice.Currency != "PLN"
+	pln := func(amount models.Money) string {
+		if !foreign {
+			return ""
+		}
+		return amount.Mul(rate).Round("PLN").String()
+	}
+
+	seller := ksefParty{NIP: nip, Name: fatturaPAText(business.Name, 512), Address: ksefAddressOf(business.Address, business.PostalCode, business.City, "PL")}
+
+	country := refdata.NormalizeCountryCode(clientCountryCode(client))
+	eu := refdata.IsEUMember(country, invoice.IssueDate)
+	buyer := ksefParty{Name: fatturaPAText(client.Name, 512)}
+	if buyer.Name == "" {
+		return nil, fmt.Errorf("%w: the client needs a name", ErrKSeFInvalid)
+	}
+	if client.Address != "" && country != "" {
+		buyer.Address = ksefAddressOf(client.Address, client.PostalCode, client.City, country)
+	}
+	vatID := compactUpper(client.VatID)
+	switch {
+	case vatID == "":
+		buyer.NoID = "1"
+	case country == "PL" || (country == "" && ksefNIP(vatID) != ""):
+		if buyer.NIP = ksefNIP(vatID); buyer.NIP == "" {
+			return nil, fmt.Errorf("%w: the client needs a ten-digit NIP", ErrKSeFInvalid)
+		}
+	case eu:
+		buyer.EUCountry, buyer.EUVatID = country, strings.TrimPrefix(vatID, country)
+	case country != "":
+		buyer.Country, buyer.OtherID = country, fatturaPAText(vatID, 50)
+	default:
+		return nil, fmt.Errorf("%w: the client needs a country", ErrKSeFInvalid)
+	}
+
+	totals := invoice.CalculateTotals(items)
+	details := ksefFa{
+		Currency:    invoice.Currency,
+		IssueDate:   invoice.IssueDate.Format("2006-01-02"),
+		Number:      invoice.InvoiceNumber,
+		Total:       totals.Total.String(),
+		Annotations: ksefAnnotations{CashAccounting: 2, SelfBilling: 2, ReverseCharge: 2, SplitPayment: 2, NotExempt: "1", NoNewVehicles: 1, SimplifiedTriangular: 2, NoMarginScheme: 1},
+		Kind:        "VAT",
+		Payment:     ksefPayment{DueDate: invoice.DueDate.Format("2006-01-02"), Method: 6, Account: compactUpper(business.IBAN)},
+	}
+	if invoice.HasServicePeriod() {
+		details.PeriodStart, details.PeriodEnd = invoice.ServicePeriodStart.Format("2006-01-02"), invoice.ServicePeriodEnd.Format("2006-01-02")
+	} else {
+		details.DeliveryDate = invoice.IssueDate.Format("2006-01-02")
+	}
+
+	// The net amount and VAT go to the fields of the rate, or of the reason
+	// the invoice carries no VAT
+	net, vat, vatPLN := totals.Subtotal.String(), totals.VatAmount.String(), pln(totals.VatAmount)
+	var vatRate string
+	switch {
+	case invoice.ReverseChargeVat && country == "PL":
+		vatRate, details.NetReverseCharge = "oo", net
+		details.Annotations.ReverseCharge = 1
+	case invoice.ReverseChargeVat && eu:
+		vatRate, details.NetEUServices = "np", net
+		details.Annotations.ReverseCharge = 1
+	case invoice.ReverseChargeVat || (totals.VatAmount == 0 && country != "" && country != "PL" && !business.VatExempt):
+		vatRate, details.NetAbroad = "np", net
+	case business.VatExempt:
+		vatRate, details.NetExempt = "zw", net
+		details.Annotations.Exempt, details.Annotations.ExemptionBasis, details.Annotations.NotExempt = "1", "art. 113 ust. 1 ustawy o VAT", ""
+	case invoice.VatRate == 23 || invoice.VatRate == 22:
+		vatRate, details.Net1, details.Vat1, details.Vat1PLN = strconv.FormatFloat(invoice.VatRate, 'f', -1, 64), net, vat, vatPLN
+	case invoice.VatRate == 8 || invoice.VatRate == 7:
+		vatRate, details.Net2, details.Vat2, details.Vat2PLN = strconv.FormatFloat(invoice.VatRate, 'f', -1, 64), net, vat, vatPLN
+	case invoice.VatRate == 5:
+		vatRate, details.Net3, details.Vat3, details.Vat3PLN = "5", net, vat, vatPLN
+	case invoice.VatRate == 0:
+		vatRate, details.NetZero = "0", net
+	default:
+		return nil, fmt.Errorf("%w: %s%% is not a Polish VAT rate", ErrKSeFInvalid, strconv.FormatFloat(invoice.VatRate, 'f', -1, 64))
+	}
+
+	exchangeRate := ""
+	if foreign {
+		exchangeRate = strconv.FormatFloat(rate, 'f', -1, 64)
+	}
+	for _, item := range items {
+		line := ksefLine{
+			Number:       len(details.Lines) + 1,
+			Description:  fatturaPAText(item.Description, 512),
+			Unit:         cmp.Or(ksefUnits[item.Unit], fatturaPAText(item.Unit, 256)),
+			Quantity:     strconv.FormatFloat(item.Quantity, 'f', -1, 64),
+			UnitPrice:    item.UnitPrice.String(),
+			NetAmount:    item.Amount.String(),
+			VatRate:      vatRate,
+			ExchangeRate: exchangeRate,
+		}
+		if item.HasDiscount() {
+			line.Discount = (item.GrossAmount().Round(invoice.Currency) - item.Amount).String()
+		}
+		details.Lines = append(details.Lines, line)
+	}
+	// The invoice discount is a line of its own, so the lines add up to the net amount
+	if totals.Discount != 0 {
+		details.Lines = append(details.Lines, ksefLine{
+			Number:       len(details.Lines) + 1,
+			Description:  "Rabat",
+			NetAmount:    (-totals.Discount).String(),
+			VatRate:      vatRate,
+			ExchangeRate: exchangeRate,
+		})
+	}
+
+	return &ksefInvoice{
+		Header: ksefHeader{
+			FormCode:  ksefFormCode{SystemCode: ksefSystemCode, SchemaVersion: ksefSchemaVersion, Value: "FA"},
+			Variant:   2,
+			CreatedAt: now.UTC().Format("2006-01-02T15:04:05Z"),
+			System:    "Simple Invoice",
+		},
+		Seller:  seller,
+		Buyer:   buyer,
+		Details: details,
+	}, nil
+}
+
+// ksefAddressOf returns the address of a party: the street, then the postal
+// code and city
+func ksefAddressOf(address, postalCode, city, country string) *ksefAddress {
+	return &ksefAddress{
+		Country: country,
+		Line1:   fatturaPAText(address, 512),
+		Line2:   fatturaPAText(strings.TrimSpace(postalCode+" "+city), 512),
+	}
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// ksefTestInvoice holds the parts of FA(2) invoices the test server checks
+type ksefTestInvoice struct {
+	Seller    string   `xml:"Podmiot1>DaneIdentyfikacyjne>NIP"`
+	BuyerNIP  string   `xml:"Podmiot2>DaneIdentyfikacyjne>NIP"`
+	BuyerEU   string   `xml:"Podmiot2>DaneIdentyfikacyjne>NrVatUE"`
+	Number    string   `xml:"Fa>P_2"`
+	Net23     string   `xml:"Fa>P_13_1"`
+	Vat23     string   `xml:"Fa>P_14_1"`
+	NetEU     string   `xml:"Fa>P_13_9"`
+	Reverse   string   `xml:"Fa>Adnotacje>P_18"`
+	Total     string   `xml:"Fa>P_15"`
+	LineRates []string `xml:"Fa>FaWiersz>P_12"`
+}
+
+func TestKSeF(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	business := &models.Business{Name: "Kowalski Sp. z o.o.", Address: "ul. Marszałkowska 1", City: "Warszawa", PostalCode: "00-001", Country: "PL",
+		VatID: "PL5260250274", Currency: "PLN", IBAN: "PL61 1090 1014 0000 0712 1981 2874"}
+	if err := dbService.SaveBusiness(business); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	clients := []*models.Client{
+		{Name: "Nowak S.A.", Address: "ul. Długa 2", City: "Kraków", PostalCode: "31-001", Country: "PL", VatID: "PL6770065406"},
+		{Name: "Acme GmbH", Address: "Hauptstr. 1", City: "Berlin", PostalCode: "10115", Country: "DE", VatID: "DE123456789"},
+	}
+	for _, client := range clients {
+		if err := dbService.SaveClient(client); err != nil {
+			t.Fatalf("Failed to save client: %v", err)
+		}
+	}
+	issued := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	invoices := []*models.Invoice{
+		{InvoiceNumber: "FV/2024/1", ClientID: clients[0].ID, Currency: "PLN", VatRate: 23, Status: "sent"},
+		{InvoiceNumber: "FV/2024/2", ClientID: clients[1].ID, Currency: "PLN", ReverseChargeVat: true, Status: "sent"},
+		{InvoiceNumber: "FV/2024/3", ClientID: clients[0].ID, Currency: "PLN", VatRate: 23, Status: "draft"},
+		{InvoiceNumber: "FV/2023/9", ClientID: clients[0].ID, Currency: "PLN", VatRate: 23, Status: "paid", IssueDate: issued.AddDate(-1, 0, 0)},
+	}
+	for _, invoice := range invoices {
+		if invoice.IssueDate.IsZero() {
+			invoice.IssueDate = issued
+		}
+		invoice.BusinessID, invoice.DueDate = business.ID, invoice.IssueDate.AddDate(0, 0, 14)
+		items := []models.InvoiceItem{
+			{Description: "Doradztwo", Quantity: 10, Unit: models.UnitHours, UnitPrice: models.NewMoney(100)},
+		}
+		invoice.ApplyTotals(items)
+		if err := dbService.SaveInvoice(invoice, items); err != nil {
+			t.Fatalf("Failed to save invoice: %v", err)
+		}
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	publicKey, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	keyPath := filepath.Join(t.TempDir(), "ksef.pem")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	// The test server accepts invoices once their status is checked, except
+	// the reverse charge invoice, which it rejects
+	var mu sync.Mutex
+	sent := map[string]ksefTestInvoice{}
+	sessions, closed := 0, 0
+	fault := false
+	challengeTime := time.Date(2024, time.March, 2, 10, 0, 0, 123e6, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		if fault {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"exception":{"exceptionDetailList":[{"exceptionCode":21111,"exceptionDescription":"Brak dostępu."}]}}`)
+			return
+		}
+		if r.URL.Path != "/online/Session/AuthorisationChallenge" && r.URL.Path != "/online/Session/InitToken" && r.Header.Get("SessionToken") != "session" {
+			t.Errorf("Request to %s without a session", r.URL.Path)
+		}
+		switch {
+		case r.URL.Path == "/online/Session/AuthorisationChallenge":
+			if !strings.Contains(string(body), `"identifier":"5260250274"`) {
+				t.Errorf("Unexpected challenge request: %s", body)
+			}
+			fmt.Fprintf(w, `{"timestamp":%q,"challenge":"20240302-CR-1"}`, challengeTime.Format(time.RFC3339Nano))
+		case r.URL.Path == "/online/Session/InitToken":
+			var request struct {
+				Challenge string `xml:"Context>Challenge"`
+				Token     string `xml:"Context>Token"`
+			}
+			xml.Unmarshal(body, &request)
+			encrypted, _ := base64.StdEncoding.DecodeString(request.Token)
+			token, err := rsa.DecryptPKCS1v15(nil, key, encrypted)
+			if err != nil || request.Challenge != "20240302-CR-1" || string(token) != fmt.Sprintf("secret-token|%d", challengeTime.UnixMilli()) {
+				t.Errorf("Unexpected session request (%v, %q):\n%s", err, token, body)
+			}
+			sessions++
+			fmt.Fprint(w, `{"sessionToken":{"token":"session"}}`)
+		case r.URL.Path == "/online/Invoice/Send":
+			var request ksefSendInvoiceRequest
+			json.Unmarshal(body, &request)
+			data, _ := base64.StdEncoding.DecodeString(request.InvoicePayload.InvoiceBody)
+			var invoice ksefTestInvoice
+			if err := xml.Unmarshal(data, &invoice); err != nil || request.InvoiceHash.FileSize != len(data) {
+				t.Errorf("Unexpected invoice (%v):\n%s", err, data)
+			}
+			reference := fmt.Sprintf("E-%d", len(sent)+1)
+			sent[reference] = invoice
+			fmt.Fprintf(w, `{"elementReferenceNumber":%q}`, reference)
+		case strings.HasPrefix(r.URL.Path, "/online/Invoice/Status/"):
+			reference := strings.TrimPrefix(r.URL.Path, "/online/Invoice/Status/")
+			if sent[reference].Reverse == "1" {
+				fmt.Fprint(w, `{"processingCode":440,"processingDescription":"Duplikat faktury"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"processingCode":200,"invoiceStatus":{"ksefReferenceNumber":"5260250274-20240302-%s","acquisitionTimestamp":"2024-03-02T10:00:05Z"}}`, reference)
+		case r.URL.Path == "/online/Session/Terminate":
+			closed++
+			fmt.Fprint(w, `{}`)
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	logger := NewLogger(ERROR)
+	settings := NewSettingsService(dbService, logger)
+	ksef := NewKSeFService(dbService, settings, "1.0", logger)
+	if _, err := ksef.Submit(invoices[0].ID); !errors.Is(err, ErrKSeFNotConfigured) {
+		t.Fatalf("Expected ErrKSeFNotConfigured, got %v", err)
+	}
+	if err := settings.SetMany(map[string]string{SettingKSeFToken: "secret-token", SettingKSeFPublicKeyPath: keyPath,
+		SettingKSeFFrom: "2024-01-01", SettingKSeFAPIURL: server.URL}); err != nil {
+		t.Fatalf("Failed to configure KSeF: %v", err)
+	}
+	if _, err := ksef.Submit(invoices[2].ID); !errors.Is(err, ErrKSeFInvalid) {
+		t.Fatalf("Expected drafts to be refused, got %v", err)
+	}
+
+	// A failed session leaves the invoices queued for a later attempt
+	fault = true
+	if err := ksef.Process(); err != nil {
+		t.Fatalf("Failed to process the queue: %v", err)
+	}
+	status, err := ksef.Status(invoices[0].ID)
+	if err != nil || status == nil || status.Status != models.KSeFQueued || status.Attempts != 1 || !strings.Contains(status.Message, "Brak dostępu") {
+		t.Fatalf("Expected a failed attempt, got %+v (%v)", status, err)
+	}
+	if status, _ := ksef.Status(invoices[3].ID); status != nil {
+		t.Errorf("Expected invoices before the start date to be left out, got %+v", status)
+	}
+
+	// Submitting sends the invoice now; the next run checks how KSeF processed it
+	fault = false
+	if status, err = ksef.Submit(invoices[0].ID); err != nil || status.Status != models.KSeFSubmitted || status.ReferenceNumber == "" {
+		t.Fatalf("Expected the invoice to be submitted, got %+v (%v)", status, err)
+	}
+	if _, err := ksef.Submit(invoices[0].ID); !errors.Is(err, ErrKSeFInvalid) {
+		t.Errorf("Expected a submitted invoice not to be sent again, got %v", err)
+	}
+	dbService.GetDB().Exec(`UPDATE ksef_invoices SET next_attempt_at = ? WHERE invoice_id = ?`, time.Now().Add(-time.Minute), invoices[1].ID)
+	if err := ksef.Process(); err != nil {
+		t.Fatalf("Failed to process the queue: %v", err)
+	}
+	if err := ksef.Process(); err != nil {
+		t.Fatalf("Failed to process the queue: %v", err)
+	}
+	if number, err := ksef.Number(invoices[0].ID); err != nil || number != "5260250274-20240302-E-1" {
+		t.Errorf("Expected the KSeF number, got %q (%v)", number, err)
+	}
+	if status, _ := ksef.Status(invoices[1].ID); status == nil || status.Status != models.KSeFRejected || !strings.Contains(status.Message, "Duplikat") {
+		t.Errorf("Expected the reverse charge invoice to be rejected, got %+v", status)
+	}
+	if sessions != closed {
+		t.Errorf("Expected every session to be closed, opened %d and closed %d", sessions, closed)
+	}
+
+	domestic, reverse := sent["E-1"], sent["E-2"]
+	if domestic.Seller != "5260250274" || domestic.BuyerNIP != "6770065406" || domestic.Net23 != "1000.00" || domestic.Vat23 != "230.00" ||
+		domestic.Total != "1230.00" || domestic.Reverse != "2" || len(domestic.LineRates) != 1 || domestic.LineRates[0] != "23" {
+		t.Errorf("Unexpected domestic invoice: %+v", domestic)
+	}
+	if reverse.BuyerEU != "123456789" || reverse.NetEU != "1000.00" || reverse.Net23 != "" || reverse.Reverse != "1" || reverse.LineRates[0] != "np" {
+		t.Errorf("Unexpected reverse charge invoice: %+v", reverse)
+	}
+
+	data, filename, err := ksef.Generate(invoices[0].ID)
+	if err != nil || !strings.HasSuffix(filename, "_FA2.xml") || !strings.Contains(string(data), `<KodFormularza kodSystemowy="FA (2)" wersjaSchemy="1-0E">FA</KodFormularza>`) {
+		t.Errorf("Unexpected FA(2) invoice %q (%v):\n%s", filename, err, data)
+	}
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the log file options
+const (
+	defaultLogMaxSizeMB    = 10
+	defaultLogRotateHours  = 24
+	defaultLogMaxFiles     = 7
+	rotatedLogTimeFormat   = "20060102-150405"
+	rotatedLogCompressed   = ".gz"
+	logFilePermissions     = 0640
+	logDirectoryPermission = 0750
+)
+
+// RotatingFile is a log file that is rotated when it would grow beyond
+// maxSize or when a period of rotateEvery starts. Rotated files are renamed
+// with the time of the rotation, compressed with gzip, and removed when there
+// are more than maxFiles of them.
+type RotatingFile struct {
+	mu          sync.Mutex
+	path        string
+	maxSize     int64         // 0 for no limit
+	rotateEvery time.Duration // 0 to only rotate by size
+	maxFiles    int           // 0 to keep all rotated files
+	file        *os.File
+	size        int64
+	period      time.Time // Start of the period the file was written in
+	compressing sync.WaitGroup
+	compressMu  sync.Mutex // Compresses one rotated file at a time
+}
+
+// OpenLogFileFromEnvironment opens the log file set in LOG_FILE, rotated by
+// LOG_MAX_SIZE_MB, LOG_ROTATE_HOURS and LOG_MAX_FILES. It returns nil without
+// an error when LOG_FILE is not set.
+func OpenLogFileFromEnvironment() (*RotatingFile, error) {
+	path := strings.TrimSpace(os.Getenv("LOG_FILE"))
+	if path == "" {
+		return nil, nil
+	}
+	maxSizeMB := envInt("LOG_MAX_SIZE_MB", defaultLogMaxSizeMB)
+	rotateHours := envInt("LOG_ROTATE_HOURS", defaultLogRotateHours)
+	maxFiles := envInt("LOG_MAX_FILES", defaultLogMaxFiles)
+	return OpenRotatingFile(path, int64(maxSizeMB)<<20, time.Duration(rotateHours)*time.Hour, maxFiles)
+}
+
+// envInt returns a non-negative integer environment variable, or fallback when
+// it is not set or invalid
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(strings.TrimSpace(os.Getenv(name)))
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+// OpenRotatingFile opens or creates the log file at path, appending to it
+func OpenRotatingFile(path string, maxSize int64, rotateEvery time.Duration, maxFiles int) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), logDirectoryPermission); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	f := &RotatingFile{path: path, maxSize: maxSize, rotateEvery: rotateEvery, maxFiles: maxFiles}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	// A file left from before continues the period it was last written in
+	if info, err := f.file.Stat(); err == nil && info.Size() > 0 {
+		f.period = f.periodOf(info.ModTime())
+	}
+	return f, nil
+}
+
+// Path returns the path of the current log file
+func (f *RotatingFile) Path() string {
+	return f.path
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, logFilePermissions)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	f.period = f.periodOf(time.Now())
+	return nil
+}
+
+// periodOf returns the start of the rotation period t falls in. Periods are
+// aligned to UTC, so daily files start at midnight UTC.
+func (f *RotatingFile) periodOf(t time.Time) time.Time {
+	if f.rotateEvery <= 0 {
+		return time.Time{}
+	}
+	return t.UTC().Truncate(f.rotateEvery)
+}
+
+// Write appends p to the log file, rotating it first if needed
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	tooBig := f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize
+	if tooBig || !f.periodOf(time.Now()).Equal(f.period) {
+		if err := f.rotate(); err != nil {
+			// Keep logging to the current file rather than losing messages
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file %s: %v\n", f.path, err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file, opens a new one and compresses the
+// renamed file in the background
+func (f *RotatingFile) rotate() error {
+	if f.size == 0 {
+		f.period = f.periodOf(time.Now())
+		return nil
+	}
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	rotated := f.path + "." + time.Now().UTC().Format(rotatedLogTimeFormat)
+	for i := 1; pathExists(rotated) || pathExists(rotated+rotatedLogCompressed); i++ {
+		rotated = fmt.Sprintf("%s.%s-%d", f.path, time.Now().UTC().Format(rotatedLogTimeFormat), i)
+	}
+	renameErr := os.Rename(f.path, rotated)
+	if err := f.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	f.compressing.Add(1)
+	go func() {
+		defer f.compressing.Done()
+		f.compressMu.Lock()
+		defer f.compressMu.Unlock()
+		if err := compressLogFile(rotated); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to compress log file %s: %v\n", rotated, err)
+		}
+		if err := f.removeOldFiles(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to remove old log files: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// Close waits for rotated files to be compressed and closes the log file
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.compressing.Wait()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// RotatedFiles returns the rotated log files, oldest first
+func (f *RotatingFile) RotatedFiles() ([]string, error) {
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	// The names sort by the time of the rotation, with a file rotated again in
+	// the same second after the first one
+	slices.SortFunc(matches, func(a, b string) int {
+		return strings.Compare(strings.TrimSuffix(a, rotatedLogCompressed), strings.TrimSuffix(b, rotatedLogCompressed))
+	})
+	return matches, nil
+}
+
+// removeOldFiles removes the oldest rotated files beyond maxFiles
+func (f *RotatingFile) removeOldFiles() error {
+	if f.maxFiles <= 0 {
+		return nil
+	}
+	files, err := f.RotatedFiles()
+	if err != nil {
+		return err
+	}
+	for len(files) > f.maxFiles {
+		if err := os.Remove(files[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// compressLogFile replaces a file with a gzip-compressed copy
+func compressLogFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+rotatedLogCompressed, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, logFilePermissions)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(path)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	in.Close()
+	return os.Remove(path)
+}
+
+// pathExists reports whether a file exists, without logging like fileExists
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "app.log")
+	f, err := OpenRotatingFile(path, 100, time.Hour, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile failed: %v", err)
+	}
+	defer f.Close()
+
+	written := 0
+	write := func() {
+		t.Helper()
+		written++
+		if _, err := fmt.Fprintf(f, "line %02d %s\n", written, strings.Repeat("x", 50)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	line := func(n int) string {
+		return fmt.Sprintf("line %02d %s\n", n, strings.Repeat("x", 50))
+	}
+
+	// The second line would make the file larger than 100 bytes
+	write()
+	write()
+	// A new period starts
+	f.mu.Lock()
+	f.period = f.period.Add(-time.Hour)
+	f.mu.Unlock()
+	write()
+	// Rotations in the same second get distinct names
+	for i := 0; i < 3; i++ {
+		write()
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil || string(current) != line(6) {
+		t.Errorf("Expected the current file to hold the last line, got %q, %v", current, err)
+	}
+	rotated, err := f.RotatedFiles()
+	if err != nil {
+		t.Fatalf("RotatedFiles failed: %v", err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("Expected the 2 newest rotated files to be kept, got %v", rotated)
+	}
+	// The oldest files were removed
+	for i, name := range rotated {
+		if !strings.HasSuffix(name, ".gz") {
+			t.Errorf("Expected %s to be compressed", name)
+			continue
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", name, err)
+		}
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		content, err := io.ReadAll(zr)
+		file.Close()
+		if err != nil || string(content) != line(4+i) {
+			t.Errorf("Expected %s to hold line %d, got %q, %v", name, 4+i, content, err)
+		}
+	}
+}
+
+func TestLoggerRecent(t *testing.T) {
+	logger := NewLogger(INFO)
+	logger.SetOutput(io.Discard)
+
+	logger.Debug("not logged")
+	for i := 1; i <= recentLogEntries+5; i++ {
+		if i%10 == 0 {
+			logger.Warn("entry %d", i)
+		} else {
+			logger.Info("entry %d", i)
+		}
+	}
+
+	all := logger.Recent(DEBUG, 0, 0)
+	if len(all) != recentLogEntries {
+		t.Fatalf("Expected %d entries to be kept, got %d", recentLogEntries, len(all))
+	}
+	if all[0].Message != "entry 6" || all[len(all)-1].Message != fmt.Sprintf("entry %d", recentLogEntries+5) {
+		t.Errorf("Expected the oldest entries to be dropped, got %q to %q", all[0].Message, all[len(all)-1].Message)
+	}
+
+	newer := logger.Recent(DEBUG, all[len(all)-3].Seq, 0)
+	if len(newer) != 2 {
+		t.Errorf("Expected 2 entries after seq %d, got %d", all[len(all)-3].Seq, len(newer))
+	}
+
+	warnings := logger.Recent(WARN, 0, 3)
+	if len(warnings) != 3 || warnings[2].Message != fmt.Sprintf("entry %d", recentLogEntries) || warnings[2].Level != "WARN" {
+		t.Errorf("Expected the 3 most recent warnings, got %+v", warnings)
+	}
+}
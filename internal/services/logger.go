@@ -2,8 +2,13 @@ package services
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // LogLevel represents the severity of a log message
@@ -22,10 +27,49 @@ const (
 	FATAL
 )
 
-// Logger provides logging functionality
+// logLevelNames are the names of the log levels, as written in log lines
+var logLevelNames = []string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+
+// String returns the name of the level, e.g. WARN
+func (l LogLevel) String() string {
+	if int(l) < 0 || int(l) >= len(logLevelNames) {
+		return fmt.Sprintf("LogLevel(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+// ParseLogLevel returns the level with the given name, in any case
+func ParseLogLevel(name string) (LogLevel, bool) {
+	for i, levelName := range logLevelNames {
+		if strings.EqualFold(strings.TrimSpace(name), levelName) {
+			return LogLevel(i), true
+		}
+	}
+	return DEBUG, false
+}
+
+// recentLogEntries is how many log entries are kept in memory for /api/logs
+const recentLogEntries = 1000
+
+// LogEntry is a logged message, as returned by /api/logs
+type LogEntry struct {
+	Seq     int64     `json:"seq"` // Increases with every entry, to ask for newer ones
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+// Logger provides logging functionality. IBANs, VAT IDs and email addresses
+// are masked in every message; log structured values with Redact. The most
+// recent entries are also kept in memory, so they can be shown without access
+// to the server.
 type Logger struct {
-	level  LogLevel
+	level  atomic.Int32 // LogLevel, changed at runtime from the settings
 	logger *log.Logger
+
+	mu     sync.Mutex
+	recent []LogEntry // Ring buffer of the last recentLogEntries entries
+	seq    int64
 }
 
 // NewLogger creates a new logger
@@ -33,43 +77,57 @@ func NewLogger(level LogLevel) *Logger {
 	// Set up standard logger to stdout
 	logger := log.New(os.Stdout, "", log.LstdFlags)
 
-	return &Logger{
-		level:  level,
-		logger: logger,
-	}
+	l := &Logger{logger: logger}
+	l.level.Store(int32(level))
+	return l
+}
+
+// Level returns the lowest level that is logged
+func (l *Logger) Level() LogLevel {
+	return LogLevel(l.level.Load())
+}
+
+// SetLevel changes the lowest level that is logged, without a restart
+func (l *Logger) SetLevel(level LogLevel) {
+	l.level.Store(int32(level))
+}
+
+// SetOutput sets where messages are written, stdout by default
+func (l *Logger) SetOutput(w io.Writer) {
+	l.logger.SetOutput(w)
 }
 
 // Debug logs a debug message
 func (l *Logger) Debug(format string, v ...interface{}) {
-	if l.level <= DEBUG {
+	if l.Level() <= DEBUG {
 		l.log("DEBUG", format, v...)
 	}
 }
 
 // Info logs an info message
 func (l *Logger) Info(format string, v ...interface{}) {
-	if l.level <= INFO {
+	if l.Level() <= INFO {
 		l.log("INFO", format, v...)
 	}
 }
 
 // Warn logs a warning message
 func (l *Logger) Warn(format string, v ...interface{}) {
-	if l.level <= WARN {
+	if l.Level() <= WARN {
 		l.log("WARN", format, v...)
 	}
 }
 
 // Error logs an error message
 func (l *Logger) Error(format string, v ...interface{}) {
-	if l.level <= ERROR {
+	if l.Level() <= ERROR {
 		l.log("ERROR", format, v...)
 	}
 }
 
 // Fatal logs a fatal message and exits
 func (l *Logger) Fatal(format string, v ...interface{}) {
-	if l.level <= FATAL {
+	if l.Level() <= FATAL {
 		l.log("FATAL", format, v...)
 		os.Exit(1)
 	}
@@ -77,7 +135,46 @@ func (l *Logger) Fatal(format string, v ...interface{}) {
 
 // log logs a message with the given level
 func (l *Logger) log(level, format string, v ...interface{}) {
-	message := fmt.Sprintf(format, v...)
+	message := RedactPII(fmt.Sprintf(format, v...))
+	l.remember(level, message)
 	logMessage := fmt.Sprintf("[%s] %s", level, message)
 	l.logger.Println(logMessage)
 }
+
+// remember adds an entry to the recent entries
+func (l *Logger) remember(level, message string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	entry := LogEntry{Seq: l.seq, Time: time.Now(), Level: level, Message: message}
+	if len(l.recent) < recentLogEntries {
+		l.recent = append(l.recent, entry)
+		return
+	}
+	l.recent[(l.seq-1)%recentLogEntries] = entry
+}
+
+// Recent returns up to limit of the most recent entries at minLevel or above
+// with a sequence number after afterSeq, oldest first
+func (l *Logger) Recent(minLevel LogLevel, afterSeq int64, limit int) []LogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := []LogEntry{}
+	// The oldest entry is at the next position to be overwritten once the buffer is full
+	start := 0
+	if len(l.recent) == recentLogEntries {
+		start = int(l.seq % recentLogEntries)
+	}
+	for i := range l.recent {
+		entry := l.recent[(start+i)%len(l.recent)]
+		level, _ := ParseLogLevel(entry.Level)
+		if entry.Seq > afterSeq && level >= minLevel {
+			entries = append(entries, entry)
+		}
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries
+}
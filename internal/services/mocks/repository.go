@@ -0,0 +1,754 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: repository.go
+//
+// Generated by this command:
+//
+//	mockgen -source=repository.go -destination=mocks/repository.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	reflect "reflect"
+	time "time"
+
+	models "github.com/0dragosh/simple-invoice/internal/models"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockBusinessRepo is a mock of BusinessRepo interface.
+type MockBusinessRepo struct {
+	ctrl     *gomock.Controller
+	recorder *MockBusinessRepoMockRecorder
+	isgomock struct{}
+}
+
+// MockBusinessRepoMockRecorder is the mock recorder for MockBusinessRepo.
+type MockBusinessRepoMockRecorder struct {
+	mock *MockBusinessRepo
+}
+
+// NewMockBusinessRepo creates a new mock instance.
+func NewMockBusinessRepo(ctrl *gomock.Controller) *MockBusinessRepo {
+	mock := &MockBusinessRepo{ctrl: ctrl}
+	mock.recorder = &MockBusinessRepoMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockBusinessRepo) EXPECT() *MockBusinessRepoMockRecorder {
+	return m.recorder
+}
+
+// GetBusiness mocks base method.
+func (m *MockBusinessRepo) GetBusiness(id int) (*models.Business, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBusiness", id)
+	ret0, _ := ret[0].(*models.Business)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBusiness indicates an expected call of GetBusiness.
+func (mr *MockBusinessRepoMockRecorder) GetBusiness(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBusiness", reflect.TypeOf((*MockBusinessRepo)(nil).GetBusiness), id)
+}
+
+// GetBusinesses mocks base method.
+func (m *MockBusinessRepo) GetBusinesses() ([]models.Business, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBusinesses")
+	ret0, _ := ret[0].([]models.Business)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBusinesses indicates an expected call of GetBusinesses.
+func (mr *MockBusinessRepoMockRecorder) GetBusinesses() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBusinesses", reflect.TypeOf((*MockBusinessRepo)(nil).GetBusinesses))
+}
+
+// SaveBusiness mocks base method.
+func (m *MockBusinessRepo) SaveBusiness(business *models.Business) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveBusiness", business)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveBusiness indicates an expected call of SaveBusiness.
+func (mr *MockBusinessRepoMockRecorder) SaveBusiness(business any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBusiness", reflect.TypeOf((*MockBusinessRepo)(nil).SaveBusiness), business)
+}
+
+// MockClientRepo is a mock of ClientRepo interface.
+type MockClientRepo struct {
+	ctrl     *gomock.Controller
+	recorder *MockClientRepoMockRecorder
+	isgomock struct{}
+}
+
+// MockClientRepoMockRecorder is the mock recorder for MockClientRepo.
+type MockClientRepoMockRecorder struct {
+	mock *MockClientRepo
+}
+
+// NewMockClientRepo creates a new mock instance.
+func NewMockClientRepo(ctrl *gomock.Controller) *MockClientRepo {
+	mock := &MockClientRepo{ctrl: ctrl}
+	mock.recorder = &MockClientRepoMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockClientRepo) EXPECT() *MockClientRepoMockRecorder {
+	return m.recorder
+}
+
+// AnonymizeClient mocks base method.
+func (m *MockClientRepo) AnonymizeClient(id int) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AnonymizeClient", id)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AnonymizeClient indicates an expected call of AnonymizeClient.
+func (mr *MockClientRepoMockRecorder) AnonymizeClient(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AnonymizeClient", reflect.TypeOf((*MockClientRepo)(nil).AnonymizeClient), id)
+}
+
+// CountOpenInvoicesForClient mocks base method.
+func (m *MockClientRepo) CountOpenInvoicesForClient(clientID int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountOpenInvoicesForClient", clientID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountOpenInvoicesForClient indicates an expected call of CountOpenInvoicesForClient.
+func (mr *MockClientRepoMockRecorder) CountOpenInvoicesForClient(clientID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountOpenInvoicesForClient", reflect.TypeOf((*MockClientRepo)(nil).CountOpenInvoicesForClient), clientID)
+}
+
+// DeleteClient mocks base method.
+func (m *MockClientRepo) DeleteClient(id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteClient", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteClient indicates an expected call of DeleteClient.
+func (mr *MockClientRepoMockRecorder) DeleteClient(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteClient", reflect.TypeOf((*MockClientRepo)(nil).DeleteClient), id)
+}
+
+// GetClient mocks base method.
+func (m *MockClientRepo) GetClient(id int) (*models.Client, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetClient", id)
+	ret0, _ := ret[0].(*models.Client)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetClient indicates an expected call of GetClient.
+func (mr *MockClientRepoMockRecorder) GetClient(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetClient", reflect.TypeOf((*MockClientRepo)(nil).GetClient), id)
+}
+
+// GetClients mocks base method.
+func (m *MockClientRepo) GetClients() ([]models.Client, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetClients")
+	ret0, _ := ret[0].([]models.Client)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetClients indicates an expected call of GetClients.
+func (mr *MockClientRepoMockRecorder) GetClients() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetClients", reflect.TypeOf((*MockClientRepo)(nil).GetClients))
+}
+
+// GetDeletedClients mocks base method.
+func (m *MockClientRepo) GetDeletedClients() ([]models.Client, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDeletedClients")
+	ret0, _ := ret[0].([]models.Client)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDeletedClients indicates an expected call of GetDeletedClients.
+func (mr *MockClientRepoMockRecorder) GetDeletedClients() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeletedClients", reflect.TypeOf((*MockClientRepo)(nil).GetDeletedClients))
+}
+
+// RestoreClient mocks base method.
+func (m *MockClientRepo) RestoreClient(id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreClient", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RestoreClient indicates an expected call of RestoreClient.
+func (mr *MockClientRepoMockRecorder) RestoreClient(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreClient", reflect.TypeOf((*MockClientRepo)(nil).RestoreClient), id)
+}
+
+// SaveClient mocks base method.
+func (m *MockClientRepo) SaveClient(client *models.Client) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveClient", client)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveClient indicates an expected call of SaveClient.
+func (mr *MockClientRepoMockRecorder) SaveClient(client any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveClient", reflect.TypeOf((*MockClientRepo)(nil).SaveClient), client)
+}
+
+// MockInvoiceRepo is a mock of InvoiceRepo interface.
+type MockInvoiceRepo struct {
+	ctrl     *gomock.Controller
+	recorder *MockInvoiceRepoMockRecorder
+	isgomock struct{}
+}
+
+// MockInvoiceRepoMockRecorder is the mock recorder for MockInvoiceRepo.
+type MockInvoiceRepoMockRecorder struct {
+	mock *MockInvoiceRepo
+}
+
+// NewMockInvoiceRepo creates a new mock instance.
+func NewMockInvoiceRepo(ctrl *gomock.Controller) *MockInvoiceRepo {
+	mock := &MockInvoiceRepo{ctrl: ctrl}
+	mock.recorder = &MockInvoiceRepoMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockInvoiceRepo) EXPECT() *MockInvoiceRepoMockRecorder {
+	return m.recorder
+}
+
+// CancelInvoice mocks base method.
+func (m *MockInvoiceRepo) CancelInvoice(id int, issueDate time.Time, prepare func(*models.Invoice, []models.InvoiceItem) error) (*models.Invoice, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelInvoice", id, issueDate, prepare)
+	ret0, _ := ret[0].(*models.Invoice)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CancelInvoice indicates an expected call of CancelInvoice.
+func (mr *MockInvoiceRepoMockRecorder) CancelInvoice(id, issueDate, prepare any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelInvoice", reflect.TypeOf((*MockInvoiceRepo)(nil).CancelInvoice), id, issueDate, prepare)
+}
+
+// ConvertProforma mocks base method.
+func (m *MockInvoiceRepo) ConvertProforma(id int, issueDate time.Time, prepare func(*models.Invoice)) (*models.Invoice, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConvertProforma", id, issueDate, prepare)
+	ret0, _ := ret[0].(*models.Invoice)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConvertProforma indicates an expected call of ConvertProforma.
+func (mr *MockInvoiceRepoMockRecorder) ConvertProforma(id, issueDate, prepare any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConvertProforma", reflect.TypeOf((*MockInvoiceRepo)(nil).ConvertProforma), id, issueDate, prepare)
+}
+
+// DeleteInvoice mocks base method.
+func (m *MockInvoiceRepo) DeleteInvoice(id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteInvoice", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteInvoice indicates an expected call of DeleteInvoice.
+func (mr *MockInvoiceRepoMockRecorder) DeleteInvoice(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteInvoice", reflect.TypeOf((*MockInvoiceRepo)(nil).DeleteInvoice), id)
+}
+
+// GetInvoice mocks base method.
+func (m *MockInvoiceRepo) GetInvoice(id int) (*models.Invoice, []models.InvoiceItem, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetInvoice", id)
+	ret0, _ := ret[0].(*models.Invoice)
+	ret1, _ := ret[1].([]models.InvoiceItem)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetInvoice indicates an expected call of GetInvoice.
+func (mr *MockInvoiceRepoMockRecorder) GetInvoice(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInvoice", reflect.TypeOf((*MockInvoiceRepo)(nil).GetInvoice), id)
+}
+
+// GetInvoices mocks base method.
+func (m *MockInvoiceRepo) GetInvoices() ([]models.Invoice, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetInvoices")
+	ret0, _ := ret[0].([]models.Invoice)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetInvoices indicates an expected call of GetInvoices.
+func (mr *MockInvoiceRepoMockRecorder) GetInvoices() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInvoices", reflect.TypeOf((*MockInvoiceRepo)(nil).GetInvoices))
+}
+
+// HoldInvoice mocks base method.
+func (m *MockInvoiceRepo) HoldInvoice(id int, status, reason string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HoldInvoice", id, status, reason)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// HoldInvoice indicates an expected call of HoldInvoice.
+func (mr *MockInvoiceRepoMockRecorder) HoldInvoice(id, status, reason any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HoldInvoice", reflect.TypeOf((*MockInvoiceRepo)(nil).HoldInvoice), id, status, reason)
+}
+
+// SaveImportedInvoice mocks base method.
+func (m *MockInvoiceRepo) SaveImportedInvoice(invoice *models.Invoice, items []models.InvoiceItem) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveImportedInvoice", invoice, items)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveImportedInvoice indicates an expected call of SaveImportedInvoice.
+func (mr *MockInvoiceRepoMockRecorder) SaveImportedInvoice(invoice, items any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveImportedInvoice", reflect.TypeOf((*MockInvoiceRepo)(nil).SaveImportedInvoice), invoice, items)
+}
+
+// SaveInvoice mocks base method.
+func (m *MockInvoiceRepo) SaveInvoice(invoice *models.Invoice, items []models.InvoiceItem) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveInvoice", invoice, items)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveInvoice indicates an expected call of SaveInvoice.
+func (mr *MockInvoiceRepoMockRecorder) SaveInvoice(invoice, items any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveInvoice", reflect.TypeOf((*MockInvoiceRepo)(nil).SaveInvoice), invoice, items)
+}
+
+// SaveInvoices mocks base method.
+func (m *MockInvoiceRepo) SaveInvoices(invoices []*models.Invoice, items [][]models.InvoiceItem) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveInvoices", invoices, items)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveInvoices indicates an expected call of SaveInvoices.
+func (mr *MockInvoiceRepoMockRecorder) SaveInvoices(invoices, items any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveInvoices", reflect.TypeOf((*MockInvoiceRepo)(nil).SaveInvoices), invoices, items)
+}
+
+// SetInvoiceExchangeRate mocks base method.
+func (m *MockInvoiceRepo) SetInvoiceExchangeRate(id int, homeCurrency string, rate float64, date time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetInvoiceExchangeRate", id, homeCurrency, rate, date)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetInvoiceExchangeRate indicates an expected call of SetInvoiceExchangeRate.
+func (mr *MockInvoiceRepoMockRecorder) SetInvoiceExchangeRate(id, homeCurrency, rate, date any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetInvoiceExchangeRate", reflect.TypeOf((*MockInvoiceRepo)(nil).SetInvoiceExchangeRate), id, homeCurrency, rate, date)
+}
+
+// UpdateInvoiceStatus mocks base method.
+func (m *MockInvoiceRepo) UpdateInvoiceStatus(id int, status string, paidDate time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateInvoiceStatus", id, status, paidDate)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateInvoiceStatus indicates an expected call of UpdateInvoiceStatus.
+func (mr *MockInvoiceRepoMockRecorder) UpdateInvoiceStatus(id, status, paidDate any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateInvoiceStatus", reflect.TypeOf((*MockInvoiceRepo)(nil).UpdateInvoiceStatus), id, status, paidDate)
+}
+
+// MockStore is a mock of Store interface.
+type MockStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockStoreMockRecorder
+	isgomock struct{}
+}
+
+// MockStoreMockRecorder is the mock recorder for MockStore.
+type MockStoreMockRecorder struct {
+	mock *MockStore
+}
+
+// NewMockStore creates a new mock instance.
+func NewMockStore(ctrl *gomock.Controller) *MockStore {
+	mock := &MockStore{ctrl: ctrl}
+	mock.recorder = &MockStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockStore) EXPECT() *MockStoreMockRecorder {
+	return m.recorder
+}
+
+// AnonymizeClient mocks base method.
+func (m *MockStore) AnonymizeClient(id int) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AnonymizeClient", id)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AnonymizeClient indicates an expected call of AnonymizeClient.
+func (mr *MockStoreMockRecorder) AnonymizeClient(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AnonymizeClient", reflect.TypeOf((*MockStore)(nil).AnonymizeClient), id)
+}
+
+// CancelInvoice mocks base method.
+func (m *MockStore) CancelInvoice(id int, issueDate time.Time, prepare func(*models.Invoice, []models.InvoiceItem) error) (*models.Invoice, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelInvoice", id, issueDate, prepare)
+	ret0, _ := ret[0].(*models.Invoice)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CancelInvoice indicates an expected call of CancelInvoice.
+func (mr *MockStoreMockRecorder) CancelInvoice(id, issueDate, prepare any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelInvoice", reflect.TypeOf((*MockStore)(nil).CancelInvoice), id, issueDate, prepare)
+}
+
+// ConvertProforma mocks base method.
+func (m *MockStore) ConvertProforma(id int, issueDate time.Time, prepare func(*models.Invoice)) (*models.Invoice, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConvertProforma", id, issueDate, prepare)
+	ret0, _ := ret[0].(*models.Invoice)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConvertProforma indicates an expected call of ConvertProforma.
+func (mr *MockStoreMockRecorder) ConvertProforma(id, issueDate, prepare any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConvertProforma", reflect.TypeOf((*MockStore)(nil).ConvertProforma), id, issueDate, prepare)
+}
+
+// CountOpenInvoicesForClient mocks base method.
+func (m *MockStore) CountOpenInvoicesForClient(clientID int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountOpenInvoicesForClient", clientID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountOpenInvoicesForClient indicates an expected call of CountOpenInvoicesForClient.
+func (mr *MockStoreMockRecorder) CountOpenInvoicesForClient(clientID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountOpenInvoicesForClient", reflect.TypeOf((*MockStore)(nil).CountOpenInvoicesForClient), clientID)
+}
+
+// DeleteClient mocks base method.
+func (m *MockStore) DeleteClient(id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteClient", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteClient indicates an expected call of DeleteClient.
+func (mr *MockStoreMockRecorder) DeleteClient(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteClient", reflect.TypeOf((*MockStore)(nil).DeleteClient), id)
+}
+
+// DeleteInvoice mocks base method.
+func (m *MockStore) DeleteInvoice(id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteInvoice", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteInvoice indicates an expected call of DeleteInvoice.
+func (mr *MockStoreMockRecorder) DeleteInvoice(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteInvoice", reflect.TypeOf((*MockStore)(nil).DeleteInvoice), id)
+}
+
+// GetBusiness mocks base method.
+func (m *MockStore) GetBusiness(id int) (*models.Business, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBusiness", id)
+	ret0, _ := ret[0].(*models.Business)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBusiness indicates an expected call of GetBusiness.
+func (mr *MockStoreMockRecorder) GetBusiness(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBusiness", reflect.TypeOf((*MockStore)(nil).GetBusiness), id)
+}
+
+// GetBusinesses mocks base method.
+func (m *MockStore) GetBusinesses() ([]models.Business, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBusinesses")
+	ret0, _ := ret[0].([]models.Business)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBusinesses indicates an expected call of GetBusinesses.
+func (mr *MockStoreMockRecorder) GetBusinesses() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBusinesses", reflect.TypeOf((*MockStore)(nil).GetBusinesses))
+}
+
+// GetClient mocks base method.
+func (m *MockStore) GetClient(id int) (*models.Client, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetClient", id)
+	ret0, _ := ret[0].(*models.Client)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetClient indicates an expected call of GetClient.
+func (mr *MockStoreMockRecorder) GetClient(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetClient", reflect.TypeOf((*MockStore)(nil).GetClient), id)
+}
+
+// GetClients mocks base method.
+func (m *MockStore) GetClients() ([]models.Client, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetClients")
+	ret0, _ := ret[0].([]models.Client)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetClients indicates an expected call of GetClients.
+func (mr *MockStoreMockRecorder) GetClients() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetClients", reflect.TypeOf((*MockStore)(nil).GetClients))
+}
+
+// GetDeletedClients mocks base method.
+func (m *MockStore) GetDeletedClients() ([]models.Client, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDeletedClients")
+	ret0, _ := ret[0].([]models.Client)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDeletedClients indicates an expected call of GetDeletedClients.
+func (mr *MockStoreMockRecorder) GetDeletedClients() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeletedClients", reflect.TypeOf((*MockStore)(nil).GetDeletedClients))
+}
+
+// GetInvoice mocks base method.
+func (m *MockStore) GetInvoice(id int) (*models.Invoice, []models.InvoiceItem, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetInvoice", id)
+	ret0, _ := ret[0].(*models.Invoice)
+	ret1, _ := ret[1].([]models.InvoiceItem)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetInvoice indicates an expected call of GetInvoice.
+func (mr *MockStoreMockRecorder) GetInvoice(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInvoice", reflect.TypeOf((*MockStore)(nil).GetInvoice), id)
+}
+
+// GetInvoices mocks base method.
+func (m *MockStore) GetInvoices() ([]models.Invoice, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetInvoices")
+	ret0, _ := ret[0].([]models.Invoice)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetInvoices indicates an expected call of GetInvoices.
+func (mr *MockStoreMockRecorder) GetInvoices() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInvoices", reflect.TypeOf((*MockStore)(nil).GetInvoices))
+}
+
+// HoldInvoice mocks base method.
+func (m *MockStore) HoldInvoice(id int, status, reason string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HoldInvoice", id, status, reason)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// HoldInvoice indicates an expected call of HoldInvoice.
+func (mr *MockStoreMockRecorder) HoldInvoice(id, status, reason any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HoldInvoice", reflect.TypeOf((*MockStore)(nil).HoldInvoice), id, status, reason)
+}
+
+// RestoreClient mocks base method.
+func (m *MockStore) RestoreClient(id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreClient", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RestoreClient indicates an expected call of RestoreClient.
+func (mr *MockStoreMockRecorder) RestoreClient(id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreClient", reflect.TypeOf((*MockStore)(nil).RestoreClient), id)
+}
+
+// SaveBusiness mocks base method.
+func (m *MockStore) SaveBusiness(business *models.Business) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveBusiness", business)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveBusiness indicates an expected call of SaveBusiness.
+func (mr *MockStoreMockRecorder) SaveBusiness(business any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBusiness", reflect.TypeOf((*MockStore)(nil).SaveBusiness), business)
+}
+
+// SaveClient mocks base method.
+func (m *MockStore) SaveClient(client *models.Client) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveClient", client)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveClient indicates an expected call of SaveClient.
+func (mr *MockStoreMockRecorder) SaveClient(client any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveClient", reflect.TypeOf((*MockStore)(nil).SaveClient), client)
+}
+
+// SaveImportedInvoice mocks base method.
+func (m *MockStore) SaveImportedInvoice(invoice *models.Invoice, items []models.InvoiceItem) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveImportedInvoice", invoice, items)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveImportedInvoice indicates an expected call of SaveImportedInvoice.
+func (mr *MockStoreMockRecorder) SaveImportedInvoice(invoice, items any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveImportedInvoice", reflect.TypeOf((*MockStore)(nil).SaveImportedInvoice), invoice, items)
+}
+
+// SaveInvoice mocks base method.
+func (m *MockStore) SaveInvoice(invoice *models.Invoice, items []models.InvoiceItem) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveInvoice", invoice, items)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveInvoice indicates an expected call of SaveInvoice.
+func (mr *MockStoreMockRecorder) SaveInvoice(invoice, items any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveInvoice", reflect.TypeOf((*MockStore)(nil).SaveInvoice), invoice, items)
+}
+
+// SaveInvoices mocks base method.
+func (m *MockStore) SaveInvoices(invoices []*models.Invoice, items [][]models.InvoiceItem) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveInvoices", invoices, items)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveInvoices indicates an expected call of SaveInvoices.
+func (mr *MockStoreMockRecorder) SaveInvoices(invoices, items any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveInvoices", reflect.TypeOf((*MockStore)(nil).SaveInvoices), invoices, items)
+}
+
+// SetInvoiceExchangeRate mocks base method.
+func (m *MockStore) SetInvoiceExchangeRate(id int, homeCurrency string, rate float64, date time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetInvoiceExchangeRate", id, homeCurrency, rate, date)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetInvoiceExchangeRate indicates an expected call of SetInvoiceExchangeRate.
+func (mr *MockStoreMockRecorder) SetInvoiceExchangeRate(id, homeCurrency, rate, date any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetInvoiceExchangeRate", reflect.TypeOf((*MockStore)(nil).SetInvoiceExchangeRate), id, homeCurrency, rate, date)
+}
+
+// UpdateInvoiceStatus mocks base method.
+func (m *MockStore) UpdateInvoiceStatus(id int, status string, paidDate time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateInvoiceStatus", id, status, paidDate)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateInvoiceStatus indicates an expected call of UpdateInvoiceStatus.
+func (mr *MockStoreMockRecorder) UpdateInvoiceStatus(id, status, paidDate any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateInvoiceStatus", reflect.TypeOf((*MockStore)(nil).UpdateInvoiceStatus), id, status, paidDate)
+}
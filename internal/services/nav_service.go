@@ -0,0 +1,1058 @@
+package services
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/sha3"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/refdata"
+)
+
+// ErrNAVNotConfigured is returned when reporting to NAV without a technical user
+var ErrNAVNotConfigured = errors.New("NAV Online Számla is not configured")
+
+// ErrNAVInvalid is returned when an invoice is not reported to NAV or lacks
+// data NAV requires
+var ErrNAVInvalid = errors.New("invoice cannot be reported to NAV")
+
+// Prefixed namespaces of the NAV Online Számla API version 3.0. The default
+// namespaces of requests and invoice data are set on their root elements.
+const (
+	navCommonNamespace = "http://schemas.nav.gov.hu/NTCA/1.0/common"
+	navBaseNamespace   = "http://schemas.nav.gov.hu/OSA/3.0/base"
+)
+
+// navInterval is how often the queue is processed; NAV expects invoices to be
+// reported as they are issued
+const navInterval = time.Minute
+
+// navMaxBackoff caps the wait before a failed attempt is retried
+const navMaxBackoff = 6 * time.Hour
+
+// Statuses of an invoice processed by NAV, in a transaction status response
+const (
+	navInvoiceDone    = "DONE"
+	navInvoiceAborted = "ABORTED"
+)
+
+var navTaxNumberPattern = regexp.MustCompile(`^\d{8}(-\d-\d{2})?$`)
+
+// navUnits are the units of measure of invoice items as NAV names them; others
+// are reported as the business's own unit
+var navUnits = map[string]string{
+	models.UnitHours:      "HOUR",
+	models.UnitDays:       "DAY",
+	models.UnitPieces:     "PIECE",
+	models.UnitKilometres: "KILOMETER",
+}
+
+// IsNAVBusiness reports whether the invoices of a business are reported to
+// NAV: those of businesses with a HU VAT ID
+func IsNAVBusiness(business *models.Business) bool {
+	return navTaxpayerID(business) != ""
+}
+
+// navTaxpayerID returns the eight-digit taxpayer ID (törzsszám) in the HU VAT
+// ID of a business, empty for other businesses
+func navTaxpayerID(business *models.Business) string {
+	vatID := compactUpper(business.VatID)
+	if len(vatID) != 10 || !strings.HasPrefix(vatID, "HU") || strings.Trim(vatID[2:], "0123456789") != "" {
+		return ""
+	}
+	return vatID[2:]
+}
+
+// NAVService reports the invoices of Hungarian businesses to NAV Online
+// Számla, the real-time invoice data reporting of the Hungarian tax authority.
+// Invoices are queued once finalized and retried until NAV has processed them.
+type NAVService struct {
+	dbService       *DBService
+	settingsService *SettingsService
+	exchangeRates   *ExchangeRateService
+	compliance      *ComplianceService
+	logger          *Logger
+	client          *http.Client
+	version         string     // Reported as the version of the invoicing software
+	mu              sync.Mutex // Serializes processing the queue
+	stop            chan struct{}
+	done            chan struct{}
+}
+
+// NewNAVService creates a new NAVService
+func NewNAVService(dbService *DBService, settingsService *SettingsService, version string, logger *Logger) *NAVService {
+	return &NAVService{
+		dbService:       dbService,
+		settingsService: settingsService,
+		logger:          logger,
+		client:          &http.Client{Timeout: 30 * time.Second},
+		version:         version,
+	}
+}
+
+// SetExchangeRateService sets the service forint amounts of invoices in other
+// currencies are converted with, when they carry no forint exchange rate
+func (s *NAVService) SetExchangeRateService(exchangeRates *ExchangeRateService) {
+	s.exchangeRates = exchangeRates
+}
+
+// SetComplianceService sets the service whose profiles decide which
+// businesses report their invoices to NAV
+func (s *NAVService) SetComplianceService(compliance *ComplianceService) {
+	s.compliance = compliance
+}
+
+// Configured reports whether the technical user and its keys are set
+func (s *NAVService) Configured() bool {
+	for _, key := range []string{SettingNAVLogin, SettingNAVPassword, SettingNAVSignatureKey, SettingNAVExchangeKey} {
+		if s.settingsService.GetString(key) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Start processes the queue periodically
+func (s *NAVService) Start() {
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(navInterval):
+			}
+
+			RunProtected(s.logger, "NAV reporting", func() {
+				if !s.Configured() {
+					return
+				}
+				if err := s.Process(); err != nil {
+					s.logger.Error("Failed to report invoices to NAV: %v", err)
+				}
+			})
+		}
+	}()
+}
+
+// Stop stops the processing started by Start
+func (s *NAVService) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
+
+// Status returns how an invoice was reported to NAV, or nil if it was not
+// queued yet
+func (s *NAVService) Status(invoiceID int) (*models.NAVReport, error) {
+	var report models.NAVReport
+	var submittedAt sql.NullTime
+	err := s.dbService.GetDB().QueryRow(`
+		SELECT invoice_id, status, transaction_id, attempts, message, next_attempt_at, submitted_at, updated_at
+		FROM nav_reports WHERE invoice_id = ?
+	`, invoiceID).Scan(&report.InvoiceID, &report.Status, &report.TransactionID, &report.Attempts, &report.Message,
+		&report.NextAttemptAt, &submittedAt, &report.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load NAV report: %w", err)
+	}
+	if submittedAt.Valid {
+		report.SubmittedAt = submittedAt.Time
+	}
+	return &report, nil
+}
+
+// Submit reports an invoice now rather than with the next run of the queue,
+// for example again after NAV aborted it. A failed attempt leaves it queued.
+func (s *NAVService) Submit(invoiceID int) (*models.NAVReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.Configured() {
+		return nil, fmt.Errorf("%w: set the technical user and its keys on the Settings page", ErrNAVNotConfigured)
+	}
+	invoice, _, err := s.dbService.GetInvoice(invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	business, err := s.dbService.GetBusiness(invoice.BusinessID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load business: %w", err)
+	}
+	allowed, err := complianceAllows(s.compliance, business, models.ComplianceNAV)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !IsNAVBusiness(business):
+		return nil, fmt.Errorf("%w: only invoices of businesses with a HU VAT ID are reported", ErrNAVInvalid)
+	case !allowed:
+		return nil, fmt.Errorf("%w: the compliance profile of the business does not report invoices to NAV", ErrNAVInvalid)
+	case !isBooked(invoice):
+		return nil, fmt.Errorf("%w: drafts and pro-forma invoices are not reported", ErrNAVInvalid)
+	}
+	report, err := s.Status(invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	if report != nil && (report.Status == models.NAVReportSubmitted || report.Status == models.NAVReportDone) {
+		return nil, fmt.Errorf("%w: the invoice was reported already", ErrNAVInvalid)
+	}
+
+	now := time.Now().UTC()
+	if _, err := s.dbService.GetDB().Exec(`
+		INSERT INTO nav_reports (invoice_id, status, next_attempt_at, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (invoice_id) DO UPDATE SET status = excluded.status, attempts = 0, next_attempt_at = excluded.next_attempt_at, updated_at = excluded.updated_at
+	`, invoiceID, models.NAVReportQueued, now, now); err != nil {
+		return nil, fmt.Errorf("failed to queue invoice for NAV: %w", err)
+	}
+	if report, err = s.Status(invoiceID); err != nil {
+		return nil, err
+	}
+	if err := s.submit(report); err != nil {
+		return nil, err
+	}
+	return s.Status(invoiceID)
+}
+
+// Process queues the invoices issued since the report-from date, reports
+// those due and checks how NAV processed those submitted. Failures of single
+// invoices are recorded on their reports; only database errors are returned.
+func (s *NAVService) Process() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.Configured() {
+		return ErrNAVNotConfigured
+	}
+	if err := s.enqueue(); err != nil {
+		return err
+	}
+
+	rows, err := s.dbService.GetDB().Query(`SELECT invoice_id FROM nav_reports WHERE status IN (?, ?) ORDER BY invoice_id`,
+		models.NAVReportQueued, models.NAVReportSubmitted)
+	if err != nil {
+		return fmt.Errorf("failed to load NAV reports: %w", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan NAV report: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load NAV reports: %w", err)
+	}
+
+	now := time.Now()
+	for _, id := range ids {
+		report, err := s.Status(id)
+		if err != nil {
+			return err
+		}
+		switch {
+		case report.Status == models.NAVReportQueued && !report.NextAttemptAt.After(now):
+			err = s.submit(report)
+		case report.Status == models.NAVReportSubmitted:
+			err = s.checkStatus(report)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueue queues the finalized invoices of Hungarian businesses issued on or
+// after the report-from date that were not queued yet. Without a date, it is
+// set to today, so invoices issued before reporting was set up are left out.
+func (s *NAVService) enqueue() error {
+	from := s.settingsService.GetString(SettingNAVReportFrom)
+	if from == "" {
+		from = time.Now().Format("2006-01-02")
+		if err := s.settingsService.Set(SettingNAVReportFrom, from); err != nil {
+			return err
+		}
+	}
+	fromDate, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return fmt.Errorf("invalid NAV report-from date %q: %w", from, err)
+	}
+
+	queued := map[int]bool{}
+	rows, err := s.dbService.GetDB().Query(`SELECT invoice_id FROM nav_reports`)
+	if err != nil {
+		return fmt.Errorf("failed to load NAV reports: %w", err)
+	}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan NAV report: %w", err)
+		}
+		queued[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load NAV reports: %w", err)
+	}
+
+	invoices, err := s.dbService.GetInvoices()
+	if err != nil {
+		return err
+	}
+	hungarian := map[int]bool{}
+	for _, invoice := range invoices {
+		if queued[invoice.ID] || !isBooked(&invoice) || invoice.IssueDate.Before(fromDate) {
+			continue
+		}
+		isNAV, ok := hungarian[invoice.BusinessID]
+		if !ok {
+			business, err := s.dbService.GetBusiness(invoice.BusinessID)
+			if err != nil {
+				return fmt.Errorf("failed to load business: %w", err)
+			}
+			if isNAV = IsNAVBusiness(business); isNAV {
+				if isNAV, err = complianceAllows(s.compliance, business, models.ComplianceNAV); err != nil {
+					return err
+				}
+			}
+			hungarian[invoice.BusinessID] = isNAV
+		}
+		if !isNAV {
+			continue
+		}
+		now := time.Now().UTC()
+		if _, err := s.dbService.GetDB().Exec(`
+			INSERT INTO nav_reports (invoice_id, status, next_attempt_at, updated_at) VALUES (?, ?, ?, ?)
+		`, invoice.ID, models.NAVReportQueued, now, now); err != nil {
+			return fmt.Errorf("failed to queue invoice for NAV: %w", err)
+		}
+	}
+	return nil
+}
+
+// submit sends the data of a queued invoice to NAV. Failures are recorded on
+// the report and retried later, waiting longer after every attempt.
+func (s *NAVService) submit(report *models.NAVReport) error {
+	transactionID, err := s.manageInvoice(report.InvoiceID)
+	now := time.Now().UTC()
+	if err != nil {
+		s.logger.Warn("Failed to report invoice %d to NAV: %v", report.InvoiceID, err)
+		backoff := min(time.Minute<<min(report.Attempts, 16), navMaxBackoff)
+		_, err = s.dbService.GetDB().Exec(`
+			UPDATE nav_reports SET attempts = attempts + 1, message = ?, next_attempt_at = ?, updated_at = ? WHERE invoice_id = ?
+		`, err.Error(), now.Add(backoff), now, report.InvoiceID)
+	} else {
+		s.logger.Info("Reported invoice %d to NAV in transaction %s", report.InvoiceID, transactionID)
+		_, err = s.dbService.GetDB().Exec(`
+			UPDATE nav_reports SET status = ?, transaction_id = ?, attempts = 0, message = '', submitted_at = ?, updated_at = ? WHERE invoice_id = ?
+		`, models.NAVReportSubmitted, transactionID, now, now, report.InvoiceID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update NAV report: %w", err)
+	}
+	return nil
+}
+
+// checkStatus asks NAV how it processed a submitted invoice. Invoices still
+// being processed are checked again on the next run.
+func (s *NAVService) checkStatus(report *models.NAVReport) error {
+	invoice, _, err := s.dbService.GetInvoice(report.InvoiceID)
+	if err != nil {
+		return err
+	}
+	business, err := s.dbService.GetBusiness(invoice.BusinessID)
+	if err != nil {
+		return fmt.Errorf("failed to load business: %w", err)
+	}
+	taxNumber, err := s.taxNumber(business)
+	if err != nil {
+		return err
+	}
+	status, messages, err := s.queryTransactionStatus(taxNumber.TaxpayerID, report.TransactionID)
+	if err != nil {
+		s.logger.Warn("Failed to check the NAV status of invoice %d: %v", report.InvoiceID, err)
+		return nil
+	}
+	switch status {
+	case navInvoiceDone:
+		status = models.NAVReportDone
+	case navInvoiceAborted:
+		status = models.NAVReportAborted
+		s.logger.Warn("NAV aborted invoice %d: %s", report.InvoiceID, messages)
+	default:
+		return nil
+	}
+	if _, err := s.dbService.GetDB().Exec(`UPDATE nav_reports SET status = ?, message = ?, updated_at = ? WHERE invoice_id = ?`,
+		status, messages, time.Now().UTC(), report.InvoiceID); err != nil {
+		return fmt.Errorf("failed to update NAV report: %w", err)
+	}
+	return nil
+}
+
+// manageInvoice builds the invoice data of an invoice and sends it to NAV
+// with a new exchange token, returning the ID of the transaction
+func (s *NAVService) manageInvoice(invoiceID int) (string, error) {
+	invoice, items, err := s.dbService.GetInvoice(invoiceID)
+	if err != nil {
+		return "", err
+	}
+	business, err := s.dbService.GetBusiness(invoice.BusinessID)
+	if err != nil {
+		return "", fmt.Errorf("failed to load business: %w", err)
+	}
+	client, err := s.dbService.GetClient(invoice.ClientID)
+	if err != nil {
+		return "", fmt.Errorf("failed to load client: %w", err)
+	}
+	taxNumber, err := s.taxNumber(business)
+	if err != nil {
+		return "", err
+	}
+	rate, err := s.hufRate(invoice)
+	if err != nil {
+		return "", err
+	}
+	data, err := newNAVInvoiceData(invoice, items, business, client, taxNumber, rate)
+	if err != nil {
+		return "", err
+	}
+	encoded, err := xml.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to write NAV invoice data: %w", err)
+	}
+
+	token, err := s.exchangeToken(taxNumber.TaxpayerID)
+	if err != nil {
+		return "", err
+	}
+	operation := navInvoiceOperation{Index: 1, Operation: "CREATE", Data: base64.StdEncoding.EncodeToString(append([]byte(xml.Header), encoded...))}
+	request := navManageInvoiceRequest{
+		navRequest:    s.newRequest(taxNumber.TaxpayerID, navHash(operation.Operation+operation.Data)),
+		ExchangeToken: token,
+		Operations:    navInvoiceOperations{Operations: []navInvoiceOperation{operation}},
+	}
+	var response navManageInvoiceResponse
+	if err := s.call("manageInvoice", request, &response); err != nil {
+		return "", err
+	}
+	if response.TransactionID == "" {
+		return "", errors.New("NAV returned no transaction ID")
+	}
+	return response.TransactionID, nil
+}
+
+// exchangeToken requests the single-use token a manageInvoice request carries
+func (s *NAVService) exchangeToken(taxpayerID string) (string, error) {
+	var response navTokenExchangeResponse
+	if err := s.call("tokenExchange", navTokenExchangeRequest{navRequest: s.newRequest(taxpayerID)}, &response); err != nil {
+		return "", err
+	}
+	token, err := navDecryptToken(response.EncodedExchangeToken, s.settingsService.GetString(SettingNAVExchangeKey))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt the NAV exchange token, check the exchange key: %w", err)
+	}
+	return token, nil
+}
+
+// queryTransactionStatus returns the status of the invoice of a transaction
+// and NAV's validation messages on it
+func (s *NAVService) queryTransactionStatus(taxpayerID, transactionID string) (string, string, error) {
+	request := navQueryTransactionStatusRequest{
+		navRequest:    s.newRequest(taxpayerID),
+		TransactionID: transactionID,
+	}
+	var response navQueryTransactionStatusResponse
+	if err := s.call("queryTransactionStatus", request, &response); err != nil {
+		return "", "", err
+	}
+	if len(response.ProcessingResults) == 0 {
+		return "", "", fmt.Errorf("NAV returned no result for transaction %s", transactionID)
+	}
+	result := response.ProcessingResults[0]
+	var messages []string
+	for _, message := range append(result.TechnicalMessages, result.BusinessMessages...) {
+		messages = append(messages, fmt.Sprintf("%s %s: %s", message.ResultCode, message.ErrorCode, message.Message))
+	}
+	return result.InvoiceStatus, strings.Join(messages, "; "), nil
+}
+
+// taxNumber returns the tax number of a business, from the settings when set
+func (s *NAVService) taxNumber(business *models.Business) (navTaxNumber, error) {
+	taxNumber := navTaxNumber{TaxpayerID: navTaxpayerID(business)}
+	if taxNumber.TaxpayerID == "" {
+		return navTaxNumber{}, fmt.Errorf("%w: the business needs a HU VAT ID", ErrNAVInvalid)
+	}
+	if setting := s.settingsService.GetString(SettingNAVTaxNumber); setting != "" {
+		parts := strings.Split(setting, "-")
+		taxNumber.TaxpayerID = parts[0]
+		if len(parts) == 3 {
+			taxNumber.VatCode, taxNumber.CountyCode = parts[1], parts[2]
+		}
+	}
+	return taxNumber, nil
+}
+
+// hufRate returns the forints per unit of the invoice currency: the rate of
+// the invoice when its home currency is the forint, or else the ECB reference
+// rate of the delivery date
+func (s *NAVService) hufRate(invoice *models.Invoice) (float64, error) {
+	switch {
+	case invoice.Currency == "HUF":
+		return 1, nil
+	case invoice.HasExchangeRate() && invoice.HomeCurrency == "HUF":
+		return invoice.ExchangeRate, nil
+	case s.exchangeRates == nil:
+		return 0, fmt.Errorf("%w: no forint exchange rate for %s", ErrNAVInvalid, invoice.Currency)
+	}
+	rate, _, err := s.exchangeRates.Rate(invoice.Currency, "HUF", navDeliveryDate(invoice))
+	if err != nil {
+		return 0, fmt.Errorf("no forint exchange rate for %s: %w", invoice.Currency, err)
+	}
+	return rate, nil
+}
+
+// newRequest returns the header, user and software of a request, signed with
+// the hashes of its invoice operations
+func (s *NAVService) newRequest(taxpayerID string, operationHashes ...string) navRequest {
+	id := make([]byte, 12)
+	rand.Read(id)
+	requestID := "SI" + strings.ToUpper(hex.EncodeToString(id))
+	now := time.Now().UTC()
+	signature := navHash(requestID + now.Format("20060102150405") + s.settingsService.GetString(SettingNAVSignatureKey) + strings.Join(operationHashes, ""))
+	passwordHash := sha512.Sum512([]byte(s.settingsService.GetString(SettingNAVPassword)))
+
+	return navRequest{
+		CommonNamespace: navCommonNamespace,
+		Header: navHeader{
+			RequestID:      requestID,
+			Timestamp:      now.Format("2006-01-02T15:04:05.000Z"),
+			RequestVersion: "3.0",
+			HeaderVersion:  "1.0",
+		},
+		User: navUser{
+			Login:            s.settingsService.GetString(SettingNAVLogin),
+			PasswordHash:     navCrypto{Type: "SHA-512", Value: strings.ToUpper(hex.EncodeToString(passwordHash[:]))},
+			TaxNumber:        taxpayerID,
+			RequestSignature: navCrypto{Type: "SHA3-512", Value: signature},
+		},
+		Software: navSoftware{
+			ID:          "HU" + taxpayerID + "-SIMPINV",
+			Name:        "Simple Invoice",
+			Operation:   "LOCAL_SOFTWARE",
+			MainVersion: navText(s.version, 15),
+			DevName:     "Simple Invoice",
+			DevContact:  "https://github.com/0dragosh/simple-invoice",
+		},
+	}
+}
+
+// call posts a request to an operation of the NAV API and decodes the
+// response. NAV answers errors with a result naming the error code.
+func (s *NAVService) call(operation string, request, response interface{}) error {
+	body, err := xml.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to write NAV request: %w", err)
+	}
+	apiURL := strings.TrimRight(s.settingsService.GetString(SettingNAVAPIURL), "/")
+	req, err := http.NewRequest(http.MethodPost, apiURL+"/"+operation, bytes.NewReader(append([]byte(xml.Header), body...)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	req.Header.Set("Accept", "application/xml")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("NAV could not be reached: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read NAV response: %w", err)
+	}
+
+	var result struct {
+		Result navResult `xml:"result"`
+	}
+	xml.Unmarshal(data, &result)
+	if result.Result.FuncCode != "" && result.Result.FuncCode != "OK" {
+		return fmt.Errorf("NAV refused the request: %s %s", result.Result.ErrorCode, result.Result.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("NAV answered %s", resp.Status)
+	}
+	if err := xml.Unmarshal(data, response); err != nil {
+		return fmt.Errorf("failed to parse NAV response: %w", err)
+	}
+	return nil
+}
+
+// navHash returns the upper-case SHA3-512 hash request signatures are made of
+func navHash(s string) string {
+	hash := sha3.Sum512([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(hash[:]))
+}
+
+// navDecryptToken decrypts an exchange token, which NAV encrypts with the
+// exchange key in AES-128 ECB mode
+func navDecryptToken(encoded, key string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		return "", err
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return "", errors.New("the token is not a whole number of blocks")
+	}
+	plain := make([]byte, len(data))
+	for i := 0; i < len(data); i += aes.BlockSize {
+		block.Decrypt(plain[i:i+aes.BlockSize], data[i:i+aes.BlockSize])
+	}
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return "", errors.New("invalid padding")
+	}
+	return string(plain[:len(plain)-padding]), nil
+}
+
+// navRequest is the part every request of the NAV API starts with
+type navRequest struct {
+	CommonNamespace string      `xml:"xmlns:common,attr"`
+	Header          navHeader   `xml:"common:header"`
+	User            navUser     `xml:"common:user"`
+	Software        navSoftware `xml:"software"`
+}
+
+type navHeader struct {
+	RequestID      string `xml:"common:requestId"`
+	Timestamp      string `xml:"common:timestamp"`
+	RequestVersion string `xml:"common:requestVersion"`
+	HeaderVersion  string `xml:"common:headerVersion"`
+}
+
+type navUser struct {
+	Login            string    `xml:"common:login"`
+	PasswordHash     navCrypto `xml:"common:passwordHash"`
+	TaxNumber        string    `xml:"common:taxNumber"`
+	RequestSignature navCrypto `xml:"common:requestSignature"`
+}
+
+type navCrypto struct {
+	Type  string `xml:"cryptoType,attr"`
+	Value string `xml:",chardata"`
+}
+
+type navSoftware struct {
+	ID          string `xml:"softwareId"`
+	Name        string `xml:"softwareName"`
+	Operation   string `xml:"softwareOperation"`
+	MainVersion string `xml:"softwareMainVersion"`
+	DevName     string `xml:"softwareDevName"`
+	DevContact  string `xml:"softwareDevContact"`
+}
+
+type navTokenExchangeRequest struct {
+	XMLName xml.Name `xml:"http://schemas.nav.gov.hu/OSA/3.0/api TokenExchangeRequest"`
+	navRequest
+}
+
+type navManageInvoiceRequest struct {
+	XMLName xml.Name `xml:"http://schemas.nav.gov.hu/OSA/3.0/api ManageInvoiceRequest"`
+	navRequest
+	ExchangeToken string               `xml:"exchangeToken"`
+	Operations    navInvoiceOperations `xml:"invoiceOperations"`
+}
+
+type navInvoiceOperations struct {
+	Compressed bool                  `xml:"compressedContent"`
+	Operations []navInvoiceOperation `xml:"invoiceOperation"`
+}
+
+type navInvoiceOperation struct {
+	Index     int    `xml:"index"`
+	Operation string `xml:"invoiceOperation"` // CREATE for a new invoice
+	Data      string `xml:"invoiceData"`      // The invoice data XML in base64
+}
+
+type navQueryTransactionStatusRequest struct {
+	XMLName xml.Name `xml:"http://schemas.nav.gov.hu/OSA/3.0/api QueryTransactionStatusRequest"`
+	navRequest
+	TransactionID string `xml:"transactionId"`
+}
+
+type navResult struct {
+	FuncCode  string `xml:"funcCode"` // OK, or ERROR with an error code
+	ErrorCode string `xml:"errorCode"`
+	Message   string `xml:"message"`
+}
+
+type navTokenExchangeResponse struct {
+	EncodedExchangeToken string `xml:"encodedExchangeToken"`
+}
+
+type navManageInvoiceResponse struct {
+	TransactionID string `xml:"transactionId"`
+}
+
+type navQueryTransactionStatusResponse struct {
+	ProcessingResults []navProcessingResult `xml:"processingResults>processingResult"`
+}
+
+type navProcessingResult struct {
+	Index             int                    `xml:"index"`
+	InvoiceStatus     string                 `xml:"invoiceStatus"` // RECEIVED, PROCESSING, SAVED, DONE or ABORTED
+	TechnicalMessages []navValidationMessage `xml:"technicalValidationMessages"`
+	BusinessMessages  []navValidationMessage `xml:"businessValidationMessages"`
+}
+
+type navValidationMessage struct {
+	ResultCode string `xml:"validationResultCode"` // ERROR, WARN or INFO
+	ErrorCode  string `xml:"validationErrorCode"`
+	Message    string `xml:"message"`
+}
+
+// navInvoiceData is the data of an invoice as reported to NAV. Elements of
+// the base schema carry its prefix.
+type navInvoiceData struct {
+	XMLName       xml.Name       `xml:"http://schemas.nav.gov.hu/OSA/3.0/data InvoiceData"`
+	BaseNamespace string         `xml:"xmlns:base,attr"`
+	Number        string         `xml:"invoiceNumber"`
+	IssueDate     string         `xml:"invoiceIssueDate"`
+	Completeness  bool           `xml:"completenessIndicator"` // Whether the data is the e-invoice itself
+	Head          navInvoiceHead `xml:"invoiceMain>invoice>invoiceHead"`
+	Lines         navLines       `xml:"invoiceMain>invoice>invoiceLines"`
+	Summary       navSummary     `xml:"invoiceMain>invoice>invoiceSummary"`
+}
+
+type navInvoiceHead struct {
+	Supplier navSupplierInfo  `xml:"supplierInfo"`
+	Customer navCustomerInfo  `xml:"customerInfo"`
+	Detail   navInvoiceDetail `xml:"invoiceDetail"`
+}
+
+type navTaxNumber struct {
+	TaxpayerID string `xml:"base:taxpayerId"`
+	VatCode    string `xml:"base:vatCode,omitempty"`
+	CountyCode string `xml:"base:countyCode,omitempty"`
+}
+
+type navAddress struct {
+	Country    string `xml:"base:simpleAddress>base:countryCode"`
+	PostalCode string `xml:"base:simpleAddress>base:postalCode"`
+	City       string `xml:"base:simpleAddress>base:city"`
+	Detail     string `xml:"base:simpleAddress>base:additionalAddressDetail"`
+}
+
+type navSupplierInfo struct {
+	TaxNumber   navTaxNumber `xml:"supplierTaxNumber"`
+	Name        string       `xml:"supplierName"`
+	Address     navAddress   `xml:"supplierAddress"`
+	BankAccount string       `xml:"supplierBankAccountNumber,omitempty"`
+}
+
+type navCustomerInfo struct {
+	VatStatus string          `xml:"customerVatStatus"` // DOMESTIC, OTHER or PRIVATE_PERSON
+	VatData   *navCustomerVat `xml:"customerVatData,omitempty"`
+	Name      string          `xml:"customerName,omitempty"`
+	Address   *navAddress     `xml:"customerAddress,omitempty"`
+}
+
+type navCustomerVat struct {
+	TaxNumber       *navTaxNumber       `xml:"customerTaxNumber,omitempty"`
+	CommunityVatID  string              `xml:"communityVatNumber,omitempty"`
+	ThirdStateTaxID *navThirdStateTaxID `xml:"thirdStateTaxId,omitempty"`
+}
+
+type navThirdStateTaxID struct {
+	Country    string `xml:"countryCode"`
+	TaxpayerID string `xml:"taxpayerId"`
+}
+
+type navInvoiceDetail struct {
+	Category      string `xml:"invoiceCategory"`
+	DeliveryDate  string `xml:"invoiceDeliveryDate"`
+	PeriodStart   string `xml:"invoiceDeliveryPeriodStart,omitempty"`
+	PeriodEnd     string `xml:"invoiceDeliveryPeriodEnd,omitempty"`
+	Currency      string `xml:"currencyCode"`
+	ExchangeRate  string `xml:"exchangeRate"`
+	PaymentMethod string `xml:"paymentMethod"`
+	PaymentDate   string `xml:"paymentDate"`
+	Appearance    string `xml:"invoiceAppearance"`
+}
+
+type navLines struct {
+	Merged bool      `xml:"mergedItemIndicator"`
+	Lines  []navLine `xml:"line"`
+}
+
+type navLine struct {
+	Number       int              `xml:"lineNumber"`
+	Expression   bool             `xml:"lineExpressionIndicator"` // Whether the line has a quantity, unit and unit price
+	Description  string           `xml:"lineDescription"`
+	Quantity     string           `xml:"quantity,omitempty"`
+	Unit         string           `xml:"unitOfMeasure,omitempty"`
+	UnitOwn      string           `xml:"unitOfMeasureOwn,omitempty"`
+	UnitPrice    string           `xml:"unitPrice,omitempty"`
+	UnitPriceHUF string           `xml:"unitPriceHUF,omitempty"`
+	Discount     *navLineDiscount `xml:"lineDiscountData,omitempty"`
+	NetAmount    string           `xml:"lineAmountsNormal>lineNetAmountData>lineNetAmount"`
+	NetAmountHUF string           `xml:"lineAmountsNormal>lineNetAmountData>lineNetAmountHUF"`
+	VatRate      navVatRate       `xml:"lineAmountsNormal>lineVatRate"`
+}
+
+type navLineDiscount struct {
+	Description string `xml:"discountDescription"`
+	Value       string `xml:"discountValue"`
+	Rate        string `xml:"discountRate"`
+}
+
+// navVatRate is the VAT rate of a line, or the reason it carries no VAT
+type navVatRate struct {
+	Percentage            string        `xml:"vatPercentage,omitempty"`
+	Exemption             *navVatReason `xml:"vatExemption,omitempty"`
+	OutOfScope            *navVatReason `xml:"vatOutOfScope,omitempty"`
+	DomesticReverseCharge bool          `xml:"vatDomesticReverseCharge,omitempty"`
+}
+
+type navVatReason struct {
+	Case   string `xml:"case"`
+	Reason string `xml:"reason"`
+}
+
+type navSummary struct {
+	VatRate        navVatRate `xml:"summaryNormal>summaryByVatRate>vatRate"`
+	RateNet        string     `xml:"summaryNormal>summaryByVatRate>vatRateNetData>vatRateNetAmount"`
+	RateNetHUF     string     `xml:"summaryNormal>summaryByVatRate>vatRateNetData>vatRateNetAmountHUF"`
+	RateVat        string     `xml:"summaryNormal>summaryByVatRate>vatRateVatData>vatRateVatAmount"`
+	RateVatHUF     string     `xml:"summaryNormal>summaryByVatRate>vatRateVatData>vatRateVatAmountHUF"`
+	NetAmount      string     `xml:"summaryNormal>invoiceNetAmount"`
+	NetAmountHUF   string     `xml:"summaryNormal>invoiceNetAmountHUF"`
+	VatAmount      string     `xml:"summaryNormal>invoiceVatAmount"`
+	VatAmountHUF   string     `xml:"summaryNormal>invoiceVatAmountHUF"`
+	GrossAmount    string     `xml:"summaryGrossData>invoiceGrossAmount"`
+	GrossAmountHUF string     `xml:"summaryGrossData>invoiceGrossAmountHUF"`
+}
+
+// newNAVInvoiceData builds the data of an invoice reported to NAV, checking
+// the data NAV requires. Amounts in other currencies are also given in
+// forints at rate. The invoice has a single VAT rate, so VAT is summed once.
+func newNAVInvoiceData(invoice *models.Invoice, items []models.InvoiceItem, business *models.Business, client *models.Client, taxNumber navTaxNumber, rate float64) (*navInvoiceData, error) {
+	switch {
+	case !isBooked(invoice):
+		return nil, fmt.Errorf("%w: drafts and pro-forma invoices are not reported", ErrNAVInvalid)
+	case invoice.HasMixedVat(items):
+		return nil, fmt.Errorf("%w: items outside the VAT base cannot be combined with VAT", ErrNAVInvalid)
+	case invoice.IsStorno():
+		return nil, fmt.Errorf("%w: cancellation invoices are not reported", ErrNAVInvalid)
+	case invoice.InvoiceNumber == "" || len([]rune(invoice.InvoiceNumber)) > 50:
+		return nil, fmt.Errorf("%w: the invoice number needs 1 to 50 characters", ErrNAVInvalid)
+	case business.Address == "" || business.City == "" || strings.TrimSpace(business.PostalCode) == "":
+		return nil, fmt.Errorf("%w: the business needs an address, a city and a postal code", ErrNAVInvalid)
+	}
+	rate = math.Round(rate*1e6) / 1e6
+	huf := func(amount models.Money) string {
+		return amount.Mul(rate).Round("HUF").String()
+	}
+	deliveryDate := navDeliveryDate(invoice)
+
+	supplier := navSupplierInfo{
+		TaxNumber:   taxNumber,
+		Name:        navText(business.Name, 512),
+		Address:     navAddressOf(business.Address, business.PostalCode, business.City, "HU"),
+		BankAccount: compactUpper(business.IBAN),
+	}
+
+	country := refdata.NormalizeCountryCode(clientCountryCode(client))
+	customer := navCustomerInfo{VatStatus: "PRIVATE_PERSON"}
+	vatID := compactUpper(client.VatID)
+	if vatID != "" {
+		if country == "" || client.Address == "" || client.City == "" {
+			return nil, fmt.Errorf("%w: the client needs an address, a city and a country", ErrNAVInvalid)
+		}
+		address := navAddressOf(client.Address, client.PostalCode, client.City, country)
+		if address.PostalCode == "" {
+			address.PostalCode = "0000"
+		}
+		customer = navCustomerInfo{VatStatus: "OTHER", Name: navText(client.Name, 512), Address: &address}
+		switch {
+		case country == "HU":
+			digits := strings.Map(func(r rune) rune {
+				if r < '0' || r > '9' {
+					return -1
+				}
+				return r
+			}, vatID)
+			if len(digits) != 8 && len(digits) != 11 {
+				return nil, fmt.Errorf("%w: the client needs a Hungarian tax number like 12345678-2-42", ErrNAVInvalid)
+			}
+			customerTaxNumber := &navTaxNumber{TaxpayerID: digits[:8]}
+			if len(digits) == 11 {
+				customerTaxNumber.VatCode, customerTaxNumber.CountyCode = digits[8:9], digits[9:]
+			}
+			customer.VatStatus = "DOMESTIC"
+			customer.VatData = &navCustomerVat{TaxNumber: customerTaxNumber}
+		case refdata.IsEUMember(country, invoice.IssueDate):
+			if vatID[0] >= '0' && vatID[0] <= '9' {
+				vatID = country + vatID
+			}
+			customer.VatData = &navCustomerVat{CommunityVatID: vatID}
+		default:
+			customer.VatData = &navCustomerVat{ThirdStateTaxID: &navThirdStateTaxID{Country: country, TaxpayerID: navText(vatID, 50)}}
+		}
+	}
+
+	// Invoices without VAT name the reason
+	vatRate := navVatRate{Percentage: strconv.FormatFloat(invoice.VatRate/100, 'f', -1, 64)}
+	eu := refdata.IsEUMember(country, invoice.IssueDate)
+	switch {
+	case invoice.ReverseChargeVat && country == "HU":
+		vatRate = navVatRate{DomesticReverseCharge: true}
+	case invoice.ReverseChargeVat && eu:
+		vatRate = navVatRate{OutOfScope: &navVatReason{Case: "EUFAD37", Reason: "Áfa tv. 37. § (1), fordított adózás"}}
+	case invoice.ReverseChargeVat:
+		vatRate = navVatRate{OutOfScope: &navVatReason{Case: "HO", Reason: "Harmadik országban teljesített ügylet"}}
+	case invoice.VatAmount == 0 && business.VatExempt:
+		vatRate = navVatRate{Exemption: &navVatReason{Case: "AAM", Reason: "Alanyi adómentes"}}
+	case invoice.VatAmount == 0 && country != "" && country != "HU" && !eu:
+		vatRate = navVatRate{OutOfScope: &navVatReason{Case: "HO", Reason: "Harmadik országban teljesített ügylet"}}
+	case invoice.VatAmount == 0:
+		vatRate = navVatRate{OutOfScope: &navVatReason{Case: "ATK", Reason: "Áfa tárgyán kívüli"}}
+	}
+
+	var lines []navLine
+	for _, item := range items {
+		line := navLine{
+			Number:       len(lines) + 1,
+			Expression:   true,
+			Description:  navText(item.Description, 512),
+			Quantity:     strconv.FormatFloat(item.Quantity, 'f', -1, 64),
+			Unit:         navUnits[item.Unit],
+			UnitPrice:    item.UnitPrice.String(),
+			UnitPriceHUF: huf(item.UnitPrice),
+			NetAmount:    item.Amount.String(),
+			NetAmountHUF: huf(item.Amount),
+			VatRate:      vatRate,
+		}
+		if line.Unit == "" {
+			line.Unit, line.UnitOwn = "OWN", navText(cmp.Or(item.Unit, "-"), 50)
+		}
+		if item.HasDiscount() {
+			line.Discount = &navLineDiscount{
+				Description: "Kedvezmény",
+				Value:       (item.GrossAmount().Round(invoice.Currency) - item.Amount).String(),
+				Rate:        strconv.FormatFloat(item.DiscountPercent/100, 'f', -1, 64),
+			}
+		}
+		lines = append(lines, line)
+	}
+	// The invoice discount is a line of its own, so the lines add up to the net amount
+	totals := invoice.CalculateTotals(items)
+	if totals.Discount != 0 {
+		lines = append(lines, navLine{
+			Number:       len(lines) + 1,
+			Description:  "Kedvezmény",
+			NetAmount:    (-totals.Discount).String(),
+			NetAmountHUF: huf(-totals.Discount),
+			VatRate:      vatRate,
+		})
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%w: the invoice has no items", ErrNAVInvalid)
+	}
+
+	detail := navInvoiceDetail{
+		Category:      "NORMAL",
+		DeliveryDate:  deliveryDate.Format("2006-01-02"),
+		Currency:      invoice.Currency,
+		ExchangeRate:  strconv.FormatFloat(rate, 'f', -1, 64),
+		PaymentMethod: "TRANSFER",
+		PaymentDate:   invoice.DueDate.Format("2006-01-02"),
+		Appearance:    "ELECTRONIC",
+	}
+	if invoice.HasServicePeriod() {
+		detail.PeriodStart, detail.PeriodEnd = invoice.ServicePeriodStart.Format("2006-01-02"), invoice.ServicePeriodEnd.Format("2006-01-02")
+	}
+
+	return &navInvoiceData{
+		BaseNamespace: navBaseNamespace,
+		Number:        invoice.InvoiceNumber,
+		IssueDate:     invoice.IssueDate.Format("2006-01-02"),
+		Head:          navInvoiceHead{Supplier: supplier, Customer: customer, Detail: detail},
+		Lines:         navLines{Lines: lines},
+		Summary: navSummary{
+			VatRate:        vatRate,
+			RateNet:        totals.Subtotal.String(),
+			RateNetHUF:     huf(totals.Subtotal),
+			RateVat:        totals.VatAmount.String(),
+			RateVatHUF:     huf(totals.VatAmount),
+			NetAmount:      totals.Subtotal.String(),
+			NetAmountHUF:   huf(totals.Subtotal),
+			VatAmount:      totals.VatAmount.String(),
+			VatAmountHUF:   huf(totals.VatAmount),
+			GrossAmount:    totals.Total.String(),
+			GrossAmountHUF: huf(totals.Total),
+		},
+	}, nil
+}
+
+// navDeliveryDate returns the date of supply: the end of the service period
+// when set, the issue date otherwise
+func navDeliveryDate(invoice *models.Invoice) time.Time {
+	if invoice.HasServicePeriod() {
+		return invoice.ServicePeriodEnd
+	}
+	return invoice.IssueDate
+}
+
+// navAddressOf returns the simple address of a party
+func navAddressOf(address, postalCode, city, country string) navAddress {
+	return navAddress{
+		Country:    country,
+		PostalCode: navText(postalCode, 10),
+		City:       navText(city, 255),
+		Detail:     navText(address, 255),
+	}
+}
+
+// navText joins the lines of a text and cuts it to the length allowed
+func navText(text string, maxLength int) string {
+	return fatturaPAText(text, maxLength)
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"crypto/aes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// navTestRequest holds the parts of NAV API requests the test server checks
+type navTestRequest struct {
+	RequestID string `xml:"header>requestId"`
+	Timestamp string `xml:"header>timestamp"`
+	Login     string `xml:"user>login"`
+	TaxNumber string `xml:"user>taxNumber"`
+	Signature string `xml:"user>requestSignature"`
+	Token     string `xml:"exchangeToken"`
+	Operation struct {
+		Operation string `xml:"invoiceOperation"`
+		Data      string `xml:"invoiceData"`
+	} `xml:"invoiceOperations>invoiceOperation"`
+	TransactionID string `xml:"transactionId"`
+}
+
+// navTestInvoice holds the parts of reported invoice data the test checks
+type navTestInvoice struct {
+	Number         string `xml:"invoiceNumber"`
+	SupplierTaxID  string `xml:"invoiceMain>invoice>invoiceHead>supplierInfo>supplierTaxNumber>taxpayerId"`
+	CustomerStatus string `xml:"invoiceMain>invoice>invoiceHead>customerInfo>customerVatStatus"`
+	CustomerTaxID  string `xml:"invoiceMain>invoice>invoiceHead>customerInfo>customerVatData>customerTaxNumber>taxpayerId"`
+	CommunityVatID string `xml:"invoiceMain>invoice>invoiceHead>customerInfo>customerVatData>communityVatNumber"`
+	ExchangeRate   string `xml:"invoiceMain>invoice>invoiceHead>invoiceDetail>exchangeRate"`
+	Lines          []struct {
+		Unit       string `xml:"unitOfMeasure"`
+		Discount   string `xml:"lineDiscountData>discountValue"`
+		Net        string `xml:"lineAmountsNormal>lineNetAmountData>lineNetAmount"`
+		Percentage string `xml:"lineAmountsNormal>lineVatRate>vatPercentage"`
+		OutOfScope string `xml:"lineAmountsNormal>lineVatRate>vatOutOfScope>case"`
+	} `xml:"invoiceMain>invoice>invoiceLines>line"`
+	VatAmountHUF   string `xml:"invoiceMain>invoice>invoiceSummary>summaryNormal>invoiceVatAmountHUF"`
+	GrossAmount    string `xml:"invoiceMain>invoice>invoiceSummary>summaryGrossData>invoiceGrossAmount"`
+	GrossAmountHUF string `xml:"invoiceMain>invoice>invoiceSummary>summaryGrossData>invoiceGrossAmountHUF"`
+}
+
+func TestNAVReporting(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	business := &models.Business{Name: "Kovács Kft.", Address: "Fő utca 1", City: "Budapest", PostalCode: "1011", Country: "HU",
+		VatID: "HU12345678", Currency: "HUF"}
+	if err := dbService.SaveBusiness(business); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	clients := []*models.Client{
+		{Name: "Szabó Bt.", Address: "Kossuth tér 2", City: "Debrecen", PostalCode: "4024", Country: "HU", VatID: "87654321-2-09"},
+		{Name: "Acme GmbH", Address: "Hauptstr. 1", City: "Berlin", PostalCode: "10115", Country: "DE", VatID: "DE123456789"},
+	}
+	for _, client := range clients {
+		if err := dbService.SaveClient(client); err != nil {
+			t.Fatalf("Failed to save client: %v", err)
+		}
+	}
+	issued := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	invoices := []*models.Invoice{
+		{InvoiceNumber: "2024/1", ClientID: clients[0].ID, Currency: "HUF", VatRate: 27, Status: "sent"},
+		{InvoiceNumber: "2024/2", ClientID: clients[1].ID, Currency: "EUR", HomeCurrency: "HUF", ExchangeRate: 390.5, ReverseChargeVat: true, Status: "sent"},
+		{InvoiceNumber: "2024/3", ClientID: clients[0].ID, Currency: "HUF", VatRate: 27, Status: "draft"},
+		{InvoiceNumber: "2023/9", ClientID: clients[0].ID, Currency: "HUF", VatRate: 27, Status: "paid", IssueDate: issued.AddDate(-1, 0, 0)},
+	}
+	for _, invoice := range invoices {
+		if invoice.IssueDate.IsZero() {
+			invoice.IssueDate = issued
+		}
+		invoice.BusinessID, invoice.DueDate = business.ID, invoice.IssueDate.AddDate(0, 0, 15)
+		items := []models.InvoiceItem{
+			{Description: "Tanácsadás", Quantity: 10, Unit: models.UnitHours, UnitPrice: models.NewMoney(100)},
+			{Description: "Workshop", Quantity: 1, Unit: models.UnitFlat, UnitPrice: models.NewMoney(500), DiscountPercent: 10},
+		}
+		invoice.ApplyTotals(items)
+		if err := dbService.SaveInvoice(invoice, items); err != nil {
+			t.Fatalf("Failed to save invoice: %v", err)
+		}
+	}
+
+	const exchangeKey, signatureKey = "0123456789abcdef", "signature-key"
+	var mu sync.Mutex
+	reported := map[string]navTestInvoice{} // By transaction ID
+	statuses := map[string]string{}
+	failManage := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		var request navTestRequest
+		if err := xml.Unmarshal(body, &request); err != nil || request.Login != "techuser" || request.TaxNumber != "12345678" {
+			t.Errorf("Unexpected request to %s (%v):\n%s", r.URL.Path, err, body)
+		}
+		timestamp, _ := time.Parse("2006-01-02T15:04:05.000Z", request.Timestamp)
+		signed := request.RequestID + timestamp.Format("20060102150405") + signatureKey
+
+		switch r.URL.Path {
+		case "/tokenExchange":
+			token := []byte("token-" + request.RequestID)
+			padding := aes.BlockSize - len(token)%aes.BlockSize
+			token = append(token, strings.Repeat(string(rune(padding)), padding)...)
+			block, _ := aes.NewCipher([]byte(exchangeKey))
+			for i := 0; i < len(token); i += aes.BlockSize {
+				block.Encrypt(token[i:i+aes.BlockSize], token[i:i+aes.BlockSize])
+			}
+			fmt.Fprintf(w, `<TokenExchangeResponse><encodedExchangeToken>%s</encodedExchangeToken></TokenExchangeResponse>`, base64.StdEncoding.EncodeToString(token))
+
+		case "/manageInvoice":
+			if failManage {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, `<GeneralErrorResponse><result><funcCode>ERROR</funcCode><errorCode>OPERATION_FAILED</errorCode><message>Try again later</message></result></GeneralErrorResponse>`)
+				return
+			}
+			if want := navHash(signed + navHash(request.Operation.Operation+request.Operation.Data)); request.Signature != want || !strings.HasPrefix(request.Token, "token-") {
+				t.Errorf("Unexpected signature %s or token %s", request.Signature, request.Token)
+			}
+			data, _ := base64.StdEncoding.DecodeString(request.Operation.Data)
+			var invoice navTestInvoice
+			if err := xml.Unmarshal(data, &invoice); err != nil {
+				t.Errorf("Failed to parse invoice data: %v\n%s", err, data)
+			}
+			transactionID := fmt.Sprintf("T%d", len(reported)+1)
+			reported[transactionID] = invoice
+			fmt.Fprintf(w, `<ManageInvoiceResponse><result><funcCode>OK</funcCode></result><transactionId>%s</transactionId></ManageInvoiceResponse>`, transactionID)
+
+		case "/queryTransactionStatus":
+			if request.Signature != navHash(signed) {
+				t.Errorf("Unexpected signature %s", request.Signature)
+			}
+			status := statuses[request.TransactionID]
+			fmt.Fprintf(w, `<QueryTransactionStatusResponse><result><funcCode>OK</funcCode></result><processingResults><processingResult><index>1</index>
+				<invoiceStatus>%s</invoiceStatus>%s</processingResult></processingResults></QueryTransactionStatusResponse>`, status,
+				map[bool]string{true: `<businessValidationMessages><validationResultCode>ERROR</validationResultCode><validationErrorCode>INVALID_CUSTOMER</validationErrorCode><message>Unknown customer</message></businessValidationMessages>`}[status == "ABORTED"])
+		}
+	}))
+	defer server.Close()
+
+	logger := NewLogger(ERROR)
+	settings := NewSettingsService(dbService, logger)
+	nav := NewNAVService(dbService, settings, "1.0", logger)
+	if _, err := nav.Submit(invoices[0].ID); !errors.Is(err, ErrNAVNotConfigured) {
+		t.Fatalf("Expected ErrNAVNotConfigured, got %v", err)
+	}
+	if err := settings.SetMany(map[string]string{
+		SettingNAVLogin: "techuser", SettingNAVPassword: "secret", SettingNAVSignatureKey: signatureKey, SettingNAVExchangeKey: exchangeKey,
+		SettingNAVReportFrom: "2024-01-01", SettingNAVAPIURL: server.URL,
+	}); err != nil {
+		t.Fatalf("Failed to configure NAV: %v", err)
+	}
+
+	// Finalized invoices issued since the report-from date are queued and sent
+	if err := nav.Process(); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(reported) != 2 {
+		t.Fatalf("Expected 2 invoices reported, got %+v", reported)
+	}
+	domestic, foreign := reported["T1"], reported["T2"]
+	if domestic.Number != "2024/1" || domestic.SupplierTaxID != "12345678" || domestic.CustomerStatus != "DOMESTIC" || domestic.CustomerTaxID != "87654321" ||
+		domestic.GrossAmount != "1841.50" || domestic.GrossAmountHUF != "1841.50" {
+		t.Errorf("Unexpected domestic invoice data %+v", domestic)
+	}
+	if len(domestic.Lines) != 2 || domestic.Lines[0].Unit != "HOUR" || domestic.Lines[0].Percentage != "0.27" ||
+		domestic.Lines[1].Unit != "OWN" || domestic.Lines[1].Discount != "50.00" || domestic.Lines[1].Net != "450.00" {
+		t.Errorf("Unexpected lines %+v", domestic.Lines)
+	}
+	if foreign.CustomerStatus != "OTHER" || foreign.CommunityVatID != "DE123456789" || foreign.ExchangeRate != "390.5" ||
+		foreign.Lines[0].OutOfScope != "EUFAD37" || foreign.VatAmountHUF != "0.00" || foreign.GrossAmountHUF != "566225.00" {
+		t.Errorf("Unexpected reverse charge invoice data %+v", foreign)
+	}
+	for _, invoice := range invoices[2:] {
+		if report, err := nav.Status(invoice.ID); err != nil || report != nil {
+			t.Errorf("Expected invoice %s not to be queued, got %+v (%v)", invoice.InvoiceNumber, report, err)
+		}
+	}
+
+	// Submitted invoices are tracked until NAV has processed them
+	statuses["T1"], statuses["T2"] = "PROCESSING", "ABORTED"
+	if err := nav.Process(); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	statuses["T1"] = "DONE"
+	if err := nav.Process(); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if report, err := nav.Status(invoices[0].ID); err != nil || report.Status != models.NAVReportDone || report.TransactionID != "T1" || report.SubmittedAt.IsZero() {
+		t.Errorf("Expected invoice 2024/1 done, got %+v (%v)", report, err)
+	}
+	report, err := nav.Status(invoices[1].ID)
+	if err != nil || report.Status != models.NAVReportAborted || !strings.Contains(report.Message, "INVALID_CUSTOMER: Unknown customer") {
+		t.Errorf("Expected invoice 2024/2 aborted, got %+v (%v)", report, err)
+	}
+	if _, err := nav.Submit(invoices[0].ID); !errors.Is(err, ErrNAVInvalid) {
+		t.Errorf("Expected invoices reported already to be refused, got %v", err)
+	}
+	if _, err := nav.Submit(invoices[2].ID); !errors.Is(err, ErrNAVInvalid) {
+		t.Errorf("Expected drafts to be refused, got %v", err)
+	}
+
+	// Failed attempts stay queued and are retried later
+	failManage = true
+	report, err = nav.Submit(invoices[1].ID)
+	if err != nil || report.Status != models.NAVReportQueued || report.Attempts != 1 || !strings.Contains(report.Message, "OPERATION_FAILED") || !report.NextAttemptAt.After(time.Now()) {
+		t.Fatalf("Expected a failed attempt to be queued for retry, got %+v (%v)", report, err)
+	}
+	failManage = false
+	if err := nav.Process(); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(reported) != 2 {
+		t.Errorf("Expected the retry to wait, got %d invoices reported", len(reported))
+	}
+	report, err = nav.Submit(invoices[1].ID)
+	if err != nil || report.Status != models.NAVReportSubmitted || report.TransactionID != "T3" || report.Attempts != 0 || report.Message != "" {
+		t.Errorf("Expected the invoice to be reported again, got %+v (%v)", report, err)
+	}
+}
@@ -0,0 +1,336 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// Notification events
+const (
+	EventInvoiceViewed  = "invoice.viewed"
+	EventInvoicePaid    = "invoice.paid"
+	EventInvoiceOverdue = "invoice.overdue"
+	EventBackupFailed   = "backup.failed"
+	EventLoginNewDevice = "login.new_device"
+)
+
+// NotificationEvents lists the events that can be notified about
+var NotificationEvents = []string{EventInvoiceViewed, EventInvoicePaid, EventInvoiceOverdue, EventBackupFailed, EventLoginNewDevice}
+
+// JobTypeSendNotification delivers a notification to one provider
+const JobTypeSendNotification = "send_notification"
+
+// overdueCheckInterval is how often invoices are checked for becoming overdue
+const overdueCheckInterval = time.Hour
+
+// Notification is a message about an event
+type Notification struct {
+	Event   string `json:"event"`
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+// NotificationJobPayload is the payload of a send_notification job
+type NotificationJobPayload struct {
+	Provider     string       `json:"provider"`
+	Notification Notification `json:"notification"`
+}
+
+// notificationProvider delivers notifications to one service
+type notificationProvider struct {
+	Name string
+	// configured reports whether the settings of the provider are set
+	configured func(s *SettingsService) bool
+	// request builds the HTTP request that delivers a notification
+	request func(s *SettingsService, n Notification) (*http.Request, error)
+}
+
+// notificationProviders lists the supported providers
+var notificationProviders = []notificationProvider{
+	{
+		Name: "telegram",
+		configured: func(s *SettingsService) bool {
+			return s.GetString(SettingTelegramBotToken) != "" && s.GetString(SettingTelegramChatID) != ""
+		},
+		request: func(s *SettingsService, n Notification) (*http.Request, error) {
+			endpoint := fmt.Sprintf("%s/bot%s/sendMessage", telegramAPIURL, s.GetString(SettingTelegramBotToken))
+			return jsonRequest(endpoint, map[string]string{
+				"chat_id": s.GetString(SettingTelegramChatID),
+				"text":    n.Title + "\n" + n.Message,
+			})
+		},
+	},
+	{
+		Name: "slack",
+		configured: func(s *SettingsService) bool {
+			return s.GetString(SettingSlackWebhookURL) != ""
+		},
+		request: func(s *SettingsService, n Notification) (*http.Request, error) {
+			return jsonRequest(s.GetString(SettingSlackWebhookURL), map[string]string{"text": "*" + n.Title + "*\n" + n.Message})
+		},
+	},
+	{
+		Name: "discord",
+		configured: func(s *SettingsService) bool {
+			return s.GetString(SettingDiscordWebhookURL) != ""
+		},
+		request: func(s *SettingsService, n Notification) (*http.Request, error) {
+			return jsonRequest(s.GetString(SettingDiscordWebhookURL), map[string]string{"content": "**" + n.Title + "**\n" + n.Message})
+		},
+	},
+	{
+		Name: "gotify",
+		configured: func(s *SettingsService) bool {
+			return s.GetString(SettingGotifyURL) != "" && s.GetString(SettingGotifyToken) != ""
+		},
+		request: func(s *SettingsService, n Notification) (*http.Request, error) {
+			req, err := jsonRequest(strings.TrimRight(s.GetString(SettingGotifyURL), "/")+"/message", map[string]string{"title": n.Title, "message": n.Message})
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("X-Gotify-Key", s.GetString(SettingGotifyToken))
+			return req, nil
+		},
+	},
+	{
+		Name: "ntfy",
+		configured: func(s *SettingsService) bool {
+			return s.GetString(SettingNtfyServer) != "" && s.GetString(SettingNtfyTopic) != ""
+		},
+		request: func(s *SettingsService, n Notification) (*http.Request, error) {
+			// Publishing as JSON to the server root keeps non-ASCII titles intact,
+			// which the Title header does not
+			req, err := jsonRequest(strings.TrimRight(s.GetString(SettingNtfyServer), "/"), map[string]string{
+				"topic":   s.GetString(SettingNtfyTopic),
+				"title":   n.Title,
+				"message": n.Message,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if token := s.GetString(SettingNtfyToken); token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			return req, nil
+		},
+	},
+}
+
+// telegramAPIURL is the Telegram Bot API, replaced in tests
+var telegramAPIURL = "https://api.telegram.org"
+
+// NotificationService sends notifications about invoice and backup events to
+// the providers configured in the settings. Each delivery is a background job,
+// so failed deliveries are retried.
+type NotificationService struct {
+	dbService       *DBService
+	settingsService *SettingsService
+	jobService      *JobService
+	logger          *Logger
+	client          *http.Client
+
+	mu     sync.Mutex
+	recent map[string]time.Time // Last notification per throttle key
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewNotificationService creates a new NotificationService. Without a job
+// service notifications are delivered immediately.
+func NewNotificationService(dbService *DBService, settingsService *SettingsService, jobService *JobService, logger *Logger) *NotificationService {
+	return &NotificationService{
+		dbService:       dbService,
+		settingsService: settingsService,
+		jobService:      jobService,
+		logger:          logger,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		recent:          make(map[string]time.Time),
+	}
+}
+
+// Enabled reports whether notifications about an event are sent
+func (s *NotificationService) Enabled(event string) bool {
+	if !slices.Contains(splitList(s.settingsService.GetString(SettingNotifyEvents)), event) {
+		return false
+	}
+	return slices.ContainsFunc(notificationProviders, func(p notificationProvider) bool {
+		return p.configured(s.settingsService)
+	})
+}
+
+// Notify queues a notification for every configured provider, if its event
+// is enabled. A nil service sends nothing.
+func (s *NotificationService) Notify(n Notification) {
+	if s == nil || !s.Enabled(n.Event) {
+		return
+	}
+
+	for _, provider := range notificationProviders {
+		if !provider.configured(s.settingsService) {
+			continue
+		}
+		if s.jobService == nil {
+			if err := s.Deliver(provider.Name, n); err != nil {
+				s.logger.Error("Failed to send %s notification: %v", provider.Name, err)
+			}
+			continue
+		}
+		if _, err := s.jobService.Enqueue(JobTypeSendNotification, NotificationJobPayload{Provider: provider.Name, Notification: n}); err != nil {
+			s.logger.Error("Failed to queue %s notification: %v", provider.Name, err)
+		}
+	}
+}
+
+// NotifyThrottled sends a notification unless one with the same key was sent
+// within the window, e.g. so an invoice opened repeatedly is notified once
+func (s *NotificationService) NotifyThrottled(key string, window time.Duration, n Notification) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if last, ok := s.recent[key]; ok && time.Since(last) < window {
+		s.mu.Unlock()
+		return
+	}
+	s.recent[key] = time.Now()
+	s.mu.Unlock()
+
+	s.Notify(n)
+}
+
+// Deliver sends a notification to one provider
+func (s *NotificationService) Deliver(providerName string, n Notification) error {
+	i := slices.IndexFunc(notificationProviders, func(p notificationProvider) bool { return p.Name == providerName })
+	if i < 0 {
+		return fmt.Errorf("unknown notification provider %q", providerName)
+	}
+	provider := notificationProviders[i]
+	if !provider.configured(s.settingsService) {
+		return fmt.Errorf("%s notifications are no longer configured", providerName)
+	}
+
+	req, err := provider.request(s.settingsService, n)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send %s notification: %w", providerName, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", providerName, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// StartOverdueCheck notifies about invoices that became overdue, now and then every hour
+func (s *NotificationService) StartOverdueCheck() {
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(overdueCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			RunProtected(s.logger, "overdue check", func() {
+				if err := s.CheckOverdue(time.Now()); err != nil {
+					s.logger.Error("Failed to check for overdue invoices: %v", err)
+				}
+			})
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// StopOverdueCheck stops the check started by StartOverdueCheck
+func (s *NotificationService) StopOverdueCheck() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
+
+// CheckOverdue notifies once about every sent invoice whose due date has
+// passed; disputed and on-hold invoices are not overdue
+func (s *NotificationService) CheckOverdue(now time.Time) error {
+	if !s.Enabled(EventInvoiceOverdue) {
+		return nil
+	}
+
+	invoices, err := s.dbService.GetInvoices()
+	if err != nil {
+		return err
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for _, invoice := range invoices {
+		if invoice.Status != models.InvoiceStatusSent || invoice.IsProforma() || !invoice.DueDate.Before(today) {
+			continue
+		}
+
+		first, err := s.dbService.MarkNotified(EventInvoiceOverdue, invoice.ID)
+		if err != nil {
+			return err
+		}
+		if !first {
+			continue
+		}
+
+		s.Notify(Notification{
+			Event:   EventInvoiceOverdue,
+			Title:   fmt.Sprintf("Invoice %s is overdue", invoice.InvoiceNumber),
+			Message: fmt.Sprintf("%s %s was due on %s.", invoice.TotalAmount, invoice.Currency, invoice.DueDate.Format("2006-01-02")),
+		})
+	}
+	return nil
+}
+
+// jsonRequest builds a POST request with a JSON body
+func jsonRequest(url string, body interface{}) (*http.Request, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// splitList splits a comma-separated setting into its trimmed, non-empty values
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestNotificationProviders(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	received := map[string]map[string]string{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		received[r.URL.Path] = body
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	oldTelegramAPIURL := telegramAPIURL
+	telegramAPIURL = server.URL
+	defer func() { telegramAPIURL = oldTelegramAPIURL }()
+
+	logger := NewLogger(ERROR)
+	settings := NewSettingsService(dbService, logger)
+	notifications := NewNotificationService(dbService, settings, nil, logger)
+	notifications.client = server.Client()
+
+	if notifications.Enabled(EventInvoicePaid) {
+		t.Error("Notifications should be disabled without providers")
+	}
+
+	if err := settings.SetMany(map[string]string{
+		SettingNotifyEvents:      "invoice.paid, invoice.overdue",
+		SettingTelegramBotToken:  "123:abc",
+		SettingTelegramChatID:    "42",
+		SettingSlackWebhookURL:   server.URL + "/slack",
+		SettingDiscordWebhookURL: server.URL + "/discord",
+	}); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+	if err := settings.SetMany(map[string]string{SettingNotifyEvents: "invoice.sent"}); err == nil {
+		t.Error("Expected an unknown event to be rejected")
+	}
+
+	notifications.Notify(Notification{Event: EventInvoiceViewed, Title: "Viewed", Message: "not enabled"})
+	notifications.Notify(Notification{Event: EventInvoicePaid, Title: "Invoice INV-1 was paid", Message: "100.00 EUR received."})
+
+	if got := received["/bot123:abc/sendMessage"]; got["chat_id"] != "42" || got["text"] != "Invoice INV-1 was paid\n100.00 EUR received." {
+		t.Errorf("Unexpected Telegram message %v", got)
+	}
+	if got := received["/slack"]["text"]; got != "*Invoice INV-1 was paid*\n100.00 EUR received." {
+		t.Errorf("Unexpected Slack message %q", got)
+	}
+	if got := received["/discord"]["content"]; got != "**Invoice INV-1 was paid**\n100.00 EUR received." {
+		t.Errorf("Unexpected Discord message %q", got)
+	}
+	if len(received) != 3 {
+		t.Errorf("Expected 3 deliveries, got %v", received)
+	}
+}
+
+func TestSelfHostedNotificationProviders(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	received := map[string]*http.Request{}
+	bodies := map[string]map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		received[r.URL.Path] = r
+		bodies[r.URL.Path] = body
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	logger := NewLogger(ERROR)
+	settings := NewSettingsService(dbService, logger)
+	if err := settings.SetMany(map[string]string{
+		SettingGotifyURL:   server.URL + "/gotify/",
+		SettingGotifyToken: "gotify-token",
+		SettingNtfyServer:  server.URL + "/ntfy",
+		SettingNtfyTopic:   "invoices",
+		SettingNtfyToken:   "ntfy-token",
+	}); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+	if err := settings.SetMany(map[string]string{SettingNtfyTopic: "a/b"}); err == nil {
+		t.Error("Expected a topic with a slash to be rejected")
+	}
+	if err := settings.SetMany(map[string]string{SettingGotifyURL: "gotify.local"}); err == nil {
+		t.Error("Expected a URL without a scheme to be rejected")
+	}
+
+	notifications := NewNotificationService(dbService, settings, nil, logger)
+	notifications.Notify(Notification{Event: EventBackupFailed, Title: "Backup failed", Message: "disk full"})
+
+	if r := received["/gotify/message"]; r == nil || r.Header.Get("X-Gotify-Key") != "gotify-token" {
+		t.Errorf("Expected a Gotify message with the application token, got %v", received)
+	} else if body := bodies["/gotify/message"]; body["title"] != "Backup failed" || body["message"] != "disk full" {
+		t.Errorf("Unexpected Gotify message %v", body)
+	}
+	if r := received["/ntfy"]; r == nil || r.Header.Get("Authorization") != "Bearer ntfy-token" {
+		t.Errorf("Expected an ntfy message with the access token, got %v", received)
+	} else if body := bodies["/ntfy"]; body["topic"] != "invoices" || body["title"] != "Backup failed" || body["message"] != "disk full" {
+		t.Errorf("Unexpected ntfy message %v", body)
+	}
+}
+
+func TestCheckOverdue(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	var messages []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		messages = append(messages, body["text"])
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	logger := NewLogger(ERROR)
+	settings := NewSettingsService(dbService, logger)
+	if err := settings.SetMany(map[string]string{SettingSlackWebhookURL: server.URL}); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+	notifications := NewNotificationService(dbService, settings, nil, logger)
+	notifications.client = server.Client()
+
+	business := &models.Business{Name: "Test Business", Country: "Germany"}
+	if err := dbService.SaveBusiness(business); err != nil {
+		t.Fatalf("SaveBusiness failed: %v", err)
+	}
+	client := &models.Client{Name: "Test Client", Country: "Germany"}
+	if err := dbService.SaveClient(client); err != nil {
+		t.Fatalf("SaveClient failed: %v", err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, invoice := range []*models.Invoice{
+		{InvoiceNumber: "INV-OVERDUE", Status: "sent", DueDate: now.AddDate(0, 0, -1)},
+		{InvoiceNumber: "INV-DUE-TODAY", Status: "sent", DueDate: now},
+		{InvoiceNumber: "INV-PAID", Status: "paid", DueDate: now.AddDate(0, 0, -10)},
+	} {
+		invoice.BusinessID, invoice.ClientID, invoice.Currency = business.ID, client.ID, "EUR"
+		invoice.IssueDate = invoice.DueDate.AddDate(0, 0, -30)
+		if err := dbService.SaveInvoice(invoice, nil); err != nil {
+			t.Fatalf("SaveInvoice failed: %v", err)
+		}
+	}
+
+	for range 2 {
+		if err := notifications.CheckOverdue(now); err != nil {
+			t.Fatalf("CheckOverdue failed: %v", err)
+		}
+	}
+	if len(messages) != 1 || messages[0] != "*Invoice INV-OVERDUE is overdue*\n0.00 EUR was due on 2024-04-30." {
+		t.Errorf("Expected one overdue notification, got %q", messages)
+	}
+}
@@ -0,0 +1,459 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcClockSkew is the leeway allowed when checking ID token expiry
+const oidcClockSkew = time.Minute
+
+// OIDCConfig holds the client registration at the OIDC provider
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string // e.g. https://invoices.example.com/auth/callback
+	Scopes       []string
+}
+
+// OIDCClaims are the ID token claims used to provision users
+type OIDCClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// oidcDiscovery is the subset of the provider metadata the client needs
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider signs users in with the authorization code flow and PKCE. The
+// provider metadata and signing keys are fetched on first use, so the
+// application starts even while the provider is unreachable.
+type OIDCProvider struct {
+	config     OIDCConfig
+	httpClient *http.Client
+	logger     *Logger
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey
+}
+
+// NewOIDCProvider creates a new OIDCProvider
+func NewOIDCProvider(config OIDCConfig, logger *Logger) *OIDCProvider {
+	config.IssuerURL = strings.TrimSuffix(config.IssuerURL, "/")
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+	return &OIDCProvider{
+		config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		logger:     logger,
+	}
+}
+
+// AuthCodeURL returns the provider URL the browser is redirected to for sign-in
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified ID token claims
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*OIDCClaims, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("failed to parse token response (status %d): %w", resp.StatusCode, err)
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("token endpoint returned %s: %s", token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("token response did not contain an ID token")
+	}
+
+	return p.verifyIDToken(ctx, token.IDToken, nonce)
+}
+
+// verifyIDToken checks the signature, issuer, audience, expiry and nonce of an ID token
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, rawToken, nonce string) (*OIDCClaims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid ID token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token signature: %w", err)
+	}
+
+	key, err := p.signingKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims struct {
+		OIDCClaims
+		Issuer   string          `json:"iss"`
+		Audience json.RawMessage `json:"aud"`
+		Expiry   int64           `json:"exp"`
+		Nonce    string          `json:"nonce"`
+	}
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid ID token claims: %w", err)
+	}
+
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Issuer != discovery.Issuer {
+		return nil, fmt.Errorf("ID token issued by %q, expected %q", claims.Issuer, discovery.Issuer)
+	}
+	if !audienceContains(claims.Audience, p.config.ClientID) {
+		return nil, errors.New("ID token was not issued for this client")
+	}
+	if time.Now().Add(-oidcClockSkew).After(time.Unix(claims.Expiry, 0)) {
+		return nil, errors.New("ID token has expired")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	return &claims.OIDCClaims, nil
+}
+
+// discover fetches the provider metadata once and caches it
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := p.getJSON(ctx, p.config.IssuerURL+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.config.IssuerURL {
+		return nil, fmt.Errorf("OIDC provider reports issuer %q, expected %q", discovery.Issuer, p.config.IssuerURL)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("OIDC provider metadata is missing required endpoints")
+	}
+
+	p.logger.Info("Discovered OIDC provider %s", discovery.Issuer)
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// signingKey returns the provider key with the given ID, refreshing the key
+// set once if the key is unknown (e.g. after a key rotation)
+func (p *OIDCProvider) signingKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.findKey(kid); ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, discovery.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC signing keys: %w", err)
+	}
+
+	p.keys = make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			p.logger.Warn("Skipping OIDC signing key %q: %v", jwk.Kid, err)
+			continue
+		}
+		p.keys[jwk.Kid] = key
+	}
+
+	if key, ok := p.findKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown OIDC signing key %q", kid)
+}
+
+// findKey looks up a cached key. Tokens without a key ID are accepted when the
+// provider publishes a single key. The caller must hold p.mu.
+func (p *OIDCProvider) findKey(kid string) (crypto.PublicKey, bool) {
+	if key, ok := p.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// jsonWebKey is an RSA or EC public key from the provider's JWKS document
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil {
+			return nil, errors.New("invalid coordinates")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// jwtAlgorithm is how an ID token signed with one of the supported JWS
+// algorithms is verified
+type jwtAlgorithm struct {
+	keyType string // kty of the key
+	curve   string // crv of an EC key
+	hash    crypto.Hash
+	pss     bool // RSASSA-PSS instead of PKCS #1 v1.5
+}
+
+// jwtAlgorithms are the supported JWS algorithms (RFC 7518). A token is only
+// accepted when the key it names has the key type and curve of its alg.
+var jwtAlgorithms = map[string]jwtAlgorithm{
+	"RS256": {keyType: "RSA", hash: crypto.SHA256},
+	"RS384": {keyType: "RSA", hash: crypto.SHA384},
+	"RS512": {keyType: "RSA", hash: crypto.SHA512},
+	"PS256": {keyType: "RSA", hash: crypto.SHA256, pss: true},
+	"PS384": {keyType: "RSA", hash: crypto.SHA384, pss: true},
+	"PS512": {keyType: "RSA", hash: crypto.SHA512, pss: true},
+	"ES256": {keyType: "EC", curve: "P-256", hash: crypto.SHA256},
+	"ES384": {keyType: "EC", curve: "P-384", hash: crypto.SHA384},
+	"ES512": {keyType: "EC", curve: "P-521", hash: crypto.SHA512},
+}
+
+// minRSAKeyBits is the smallest RSA key accepted for ID token signatures
+const minRSAKeyBits = 2048
+
+// verifyJWTSignature checks a JWS signature over signed with one of the
+// algorithms in jwtAlgorithms
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	algorithm, ok := jwtAlgorithms[alg]
+	if !ok {
+		return fmt.Errorf("unsupported ID token algorithm %q", alg)
+	}
+	h := algorithm.hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if algorithm.keyType != "RSA" {
+			return fmt.Errorf("algorithm %q does not match RSA key", alg)
+		}
+		if pub.N.BitLen() < minRSAKeyBits {
+			return fmt.Errorf("RSA signing key is shorter than %d bits", minRSAKeyBits)
+		}
+		var err error
+		if algorithm.pss {
+			err = rsa.VerifyPSS(pub, algorithm.hash, digest, signature, nil)
+		} else {
+			err = rsa.VerifyPKCS1v15(pub, algorithm.hash, digest, signature)
+		}
+		if err != nil {
+			return errors.New("invalid ID token signature")
+		}
+	case *ecdsa.PublicKey:
+		if algorithm.keyType != "EC" || pub.Curve.Params().Name != algorithm.curve {
+			return fmt.Errorf("algorithm %q does not match %s key", alg, pub.Curve.Params().Name)
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return errors.New("invalid EC signing key")
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid ID token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid ID token signature")
+		}
+	default:
+		return errors.New("unsupported signing key")
+	}
+	return nil
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// audienceContains reports whether the aud claim, a string or an array, contains clientID
+func audienceContains(aud json.RawMessage, clientID string) bool {
+	var single string
+	if json.Unmarshal(aud, &single) == nil {
+		return single == clientID
+	}
+	var multiple []string
+	if json.Unmarshal(aud, &multiple) == nil {
+		for _, a := range multiple {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
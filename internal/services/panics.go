@@ -0,0 +1,36 @@
+package services
+
+import (
+	"expvar"
+	"runtime/debug"
+)
+
+// panicsRecovered counts the panics recovered in handlers and background
+// work. It is published with the other expvar metrics at /debug/vars.
+var panicsRecovered = expvar.NewInt("panics_recovered")
+
+// PanicsRecovered returns the number of panics recovered since the start
+func PanicsRecovered() int64 {
+	return panicsRecovered.Value()
+}
+
+// LogPanic logs a recovered panic with the stack trace of the goroutine that
+// panicked and counts it. Call it from the deferred function that recovered.
+func LogPanic(logger *Logger, where string, value interface{}) {
+	panicsRecovered.Add(1)
+	logger.Error("Recovered from panic in %s: %v\n%s", where, value, debug.Stack())
+}
+
+// RunProtected runs fn and recovers from a panic in it, so one failing run of
+// a background task does not crash the process. It reports whether fn
+// panicked.
+func RunProtected(logger *Logger, where string, fn func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			LogPanic(logger, where, r)
+			panicked = true
+		}
+	}()
+	fn()
+	return false
+}
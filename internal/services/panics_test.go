@@ -0,0 +1,29 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunProtected(t *testing.T) {
+	var output bytes.Buffer
+	logger := NewLogger(ERROR)
+	logger.SetOutput(&output)
+
+	before := PanicsRecovered()
+	if RunProtected(logger, "overdue check", func() {}) {
+		t.Error("Expected a function that returns to not be reported as panicking")
+	}
+	if !RunProtected(logger, "overdue check", func() { panic("nil map") }) {
+		t.Error("Expected the panic to be reported")
+	}
+
+	if got := PanicsRecovered() - before; got != 1 {
+		t.Errorf("Expected 1 recovered panic to be counted, got %d", got)
+	}
+	log := output.String()
+	if !strings.Contains(log, "Recovered from panic in overdue check: nil map") || !strings.Contains(log, "TestRunProtected") {
+		t.Errorf("Expected the panic to be logged with its stack trace, got:\n%s", log)
+	}
+}
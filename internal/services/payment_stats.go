@@ -0,0 +1,75 @@
+package services
+
+import (
+	"math"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// PaymentStatsByClient computes the payment behavior of every client with
+// invoices, keyed by client ID. Invoices that are not paid, drafts, disputed
+// or on hold count as overdue once their due date is before the day of now.
+// Cancelled invoices and their cancellation invoices are left out, as the
+// client did not pay them.
+func PaymentStatsByClient(invoices []models.Invoice, now time.Time) map[int]*models.ClientPaymentStats {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	stats := make(map[int]*models.ClientPaymentStats)
+	daysToPay := make(map[int]int)
+	datedPayments := make(map[int]int)
+	daysLate := make(map[int]int)
+	for _, invoice := range invoices {
+		if invoice.IsProforma() || invoice.Status == models.InvoiceStatusDraft || invoice.IsSettledByCancellation() {
+			continue
+		}
+		client := stats[invoice.ClientID]
+		if client == nil {
+			client = &models.ClientPaymentStats{ClientID: invoice.ClientID, OverdueAmounts: map[string]models.Money{}}
+			stats[invoice.ClientID] = client
+		}
+		client.Invoices++
+
+		if invoice.Status != models.InvoiceStatusPaid {
+			if !invoice.IsHeld() && invoice.DueDate.Before(today) {
+				client.Overdue++
+				client.OverdueAmounts[invoice.Currency] += invoice.AmountDue()
+			}
+			continue
+		}
+
+		client.Paid++
+		if invoice.PaidDate.IsZero() {
+			continue
+		}
+		datedPayments[invoice.ClientID]++
+		daysToPay[invoice.ClientID] += daysBetween(invoice.IssueDate, invoice.PaidDate)
+		if late := daysBetween(invoice.DueDate, invoice.PaidDate); late > 0 {
+			client.PaidLate++
+			client.MaxDaysLate = max(client.MaxDaysLate, late)
+			daysLate[invoice.ClientID] += late
+		}
+	}
+
+	for clientID, client := range stats {
+		if datedPayments[clientID] > 0 {
+			client.AverageDaysToPay = roundDays(float64(daysToPay[clientID]) / float64(datedPayments[clientID]))
+		}
+		if client.PaidLate > 0 {
+			client.AverageDaysLate = roundDays(float64(daysLate[clientID]) / float64(client.PaidLate))
+		}
+	}
+	return stats
+}
+
+// daysBetween returns the number of calendar days from one date to another
+func daysBetween(from, to time.Time) int {
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// roundDays rounds an average number of days to one decimal
+func roundDays(days float64) float64 {
+	return math.Round(days*10) / 10
+}
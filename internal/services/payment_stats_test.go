@@ -0,0 +1,56 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestPaymentStatsByClient(t *testing.T) {
+	date := func(month, day int) time.Time {
+		return time.Date(2024, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	}
+	invoices := []models.Invoice{
+		// Paid 10 days after issue, on time
+		{ClientID: 1, Status: "paid", IssueDate: date(1, 1), DueDate: date(1, 31), PaidDate: date(1, 11), TotalAmount: 10000, Currency: "EUR"},
+		// Paid 40 days after issue, 10 days late
+		{ClientID: 1, Status: "paid", IssueDate: date(2, 1), DueDate: date(3, 2), PaidDate: date(3, 12), TotalAmount: 10000, Currency: "EUR"},
+		// Paid without a payment date
+		{ClientID: 1, Status: "paid", IssueDate: date(3, 1), DueDate: date(3, 31), TotalAmount: 10000, Currency: "EUR"},
+		// Overdue, part of it paid with credit
+		{ClientID: 1, Status: "sent", IssueDate: date(4, 1), DueDate: date(4, 30), TotalAmount: 50000, CreditApplied: 20000, Currency: "EUR"},
+		// Not yet due
+		{ClientID: 1, Status: "sent", IssueDate: date(5, 1), DueDate: date(5, 31), TotalAmount: 10000, Currency: "EUR"},
+		// Past due, but disputed
+		{ClientID: 1, Status: models.InvoiceStatusDisputed, IssueDate: date(4, 1), DueDate: date(4, 30), TotalAmount: 10000, Currency: "EUR"},
+		// Not counted
+		{ClientID: 1, Status: "draft", IssueDate: date(4, 1), DueDate: date(4, 2), TotalAmount: 10000, Currency: "EUR"},
+		{ClientID: 1, Status: "sent", Type: models.InvoiceTypeProforma, IssueDate: date(4, 1), DueDate: date(4, 2), TotalAmount: 10000, Currency: "EUR"},
+		// Another client
+		{ClientID: 2, Status: "sent", IssueDate: date(1, 1), DueDate: date(1, 31), TotalAmount: 7500, Currency: "USD"},
+	}
+
+	stats := PaymentStatsByClient(invoices, time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC))
+
+	client := stats[1]
+	if client == nil {
+		t.Fatal("Expected stats for client 1")
+	}
+	if client.Invoices != 6 || client.Paid != 3 {
+		t.Errorf("Expected 6 invoices and 3 paid, got %d and %d", client.Invoices, client.Paid)
+	}
+	if client.AverageDaysToPay != 25 {
+		t.Errorf("Expected 25 days to pay on average, got %v", client.AverageDaysToPay)
+	}
+	if client.PaidLate != 1 || client.AverageDaysLate != 10 || client.MaxDaysLate != 10 {
+		t.Errorf("Expected one invoice paid 10 days late, got %d, %v and %d", client.PaidLate, client.AverageDaysLate, client.MaxDaysLate)
+	}
+	if client.Overdue != 1 || client.OverdueAmounts["EUR"] != 30000 {
+		t.Errorf("Expected 300.00 EUR overdue on one invoice, got %d and %v", client.Overdue, client.OverdueAmounts)
+	}
+
+	if other := stats[2]; other == nil || other.Overdue != 1 || other.OverdueAmounts["USD"] != 7500 || other.AverageDaysToPay != 0 {
+		t.Errorf("Expected client 2 to have 75.00 USD overdue, got %+v", other)
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// ErrPayPalNotificationInvalid is returned for payment notifications that
+// PayPal did not send
+var ErrPayPalNotificationInvalid = errors.New("PayPal did not confirm the notification")
+
+// PayPalService builds the PayPal payment links of invoices and verifies the
+// Instant Payment Notifications (IPN) PayPal sends when a checkout link is paid
+type PayPalService struct {
+	settingsService *SettingsService
+	logger          *Logger
+	client          *http.Client
+}
+
+// NewPayPalService creates a new PayPalService
+func NewPayPalService(settingsService *SettingsService, logger *Logger) *PayPalService {
+	return &PayPalService{
+		settingsService: settingsService,
+		logger:          logger,
+		client:          &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Email returns the PayPal account checkout links pay to, empty when PayPal
+// checkout is not configured
+func (s *PayPalService) Email() string {
+	return strings.TrimSpace(s.settingsService.GetString(SettingPayPalEmail))
+}
+
+// MeUsername returns the name of the PayPal.Me links, empty when PayPal.Me
+// links are not configured
+func (s *PayPalService) MeUsername() string {
+	return strings.TrimSpace(s.settingsService.GetString(SettingPayPalMeUsername))
+}
+
+// webscrURL returns the PayPal endpoint of checkout links and IPN verification
+func (s *PayPalService) webscrURL() string {
+	return strings.TrimRight(s.settingsService.GetString(SettingPayPalURL), "/") + "/cgi-bin/webscr"
+}
+
+// Link returns the PayPal link of an invoice for its amount due, or an empty
+// string when the invoice offers none, is a pro-forma invoice, or the link is
+// not configured
+func (s *PayPalService) Link(invoice *models.Invoice) string {
+	due := invoice.AmountDue()
+	if invoice.PayPal == "" || invoice.IsProforma() || due <= 0 {
+		return ""
+	}
+
+	switch invoice.PayPal {
+	case models.PayPalMe:
+		name := s.MeUsername()
+		if name == "" {
+			return ""
+		}
+		return fmt.Sprintf("https://www.paypal.me/%s/%s%s", url.PathEscape(name), due, invoice.Currency)
+
+	case models.PayPalCheckout:
+		email := s.Email()
+		if email == "" {
+			return ""
+		}
+		// custom carries the invoice ID back in the payment notification
+		values := url.Values{
+			"cmd":           {"_xclick"},
+			"business":      {email},
+			"item_name":     {"Invoice " + invoice.InvoiceNumber},
+			"invoice":       {invoice.InvoiceNumber},
+			"custom":        {strconv.Itoa(invoice.ID)},
+			"amount":        {due.String()},
+			"currency_code": {invoice.Currency},
+			"no_shipping":   {"1"},
+		}
+		return s.webscrURL() + "?" + values.Encode()
+	}
+	return ""
+}
+
+// VerifyNotification posts a payment notification back to PayPal, which
+// confirms that it sent it unchanged. body is the notification as received.
+func (s *PayPalService) VerifyNotification(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.webscrURL(), strings.NewReader("cmd=_notify-validate&"+string(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "Simple-Invoice-IPN-Verification")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("PayPal could not be reached: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("PayPal: %s", resp.Status)
+	}
+
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return fmt.Errorf("failed to read PayPal response: %w", err)
+	}
+	switch strings.TrimSpace(string(answer)) {
+	case "VERIFIED":
+		return nil
+	case "INVALID":
+		return ErrPayPalNotificationInvalid
+	default:
+		return fmt.Errorf("unexpected PayPal response %q", answer)
+	}
+}
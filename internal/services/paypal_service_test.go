@@ -0,0 +1,93 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestPayPalLink(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	logger := NewLogger(ERROR)
+	settings := NewSettingsService(dbService, logger)
+	paypal := NewPayPalService(settings, logger)
+
+	invoice := &models.Invoice{ID: 7, InvoiceNumber: "INV-2024-0007", Currency: "EUR", TotalAmount: models.NewMoney(1190), CreditApplied: models.NewMoney(190),
+		Type: models.InvoiceTypeInvoice, PayPal: models.PayPalCheckout}
+	if link := paypal.Link(invoice); link != "" {
+		t.Errorf("Expected no link without a PayPal account, got %s", link)
+	}
+
+	for key, value := range map[string]string{SettingPayPalEmail: "payments@example.com", SettingPayPalMeUsername: "acme", SettingPayPalURL: "https://www.sandbox.paypal.com/"} {
+		if err := settings.Set(key, value); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+
+	link, err := url.Parse(paypal.Link(invoice))
+	if err != nil {
+		t.Fatalf("Invalid checkout link: %v", err)
+	}
+	query := link.Query()
+	if link.Host != "www.sandbox.paypal.com" || link.Path != "/cgi-bin/webscr" || query.Get("business") != "payments@example.com" ||
+		query.Get("amount") != "1000.00" || query.Get("currency_code") != "EUR" || query.Get("custom") != "7" || query.Get("invoice") != "INV-2024-0007" {
+		t.Errorf("Unexpected checkout link %s", link)
+	}
+
+	invoice.PayPal = models.PayPalMe
+	if link := paypal.Link(invoice); link != "https://www.paypal.me/acme/1000.00EUR" {
+		t.Errorf("Unexpected PayPal.Me link %s", link)
+	}
+
+	// Pro-forma invoices are not paid before they are converted
+	invoice.Type = models.InvoiceTypeProforma
+	if link := paypal.Link(invoice); link != "" {
+		t.Errorf("Expected no link for a pro-forma invoice, got %s", link)
+	}
+}
+
+func TestVerifyPayPalNotification(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.URL.Path != "/cgi-bin/webscr" || r.Method != http.MethodPost:
+			http.NotFound(w, r)
+		case strings.Contains(string(body), "txn_id=down"):
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case string(body) == "cmd=_notify-validate&txn_id=1&payment_status=Completed":
+			w.Write([]byte("VERIFIED"))
+		default:
+			w.Write([]byte("INVALID"))
+		}
+	}))
+	defer server.Close()
+
+	logger := NewLogger(ERROR)
+	settings := NewSettingsService(dbService, logger)
+	if err := settings.Set(SettingPayPalURL, server.URL); err != nil {
+		t.Fatalf("Failed to set PayPal server: %v", err)
+	}
+	paypal := NewPayPalService(settings, logger)
+
+	// The notification is sent back unchanged, in its original order
+	if err := paypal.VerifyNotification([]byte("txn_id=1&payment_status=Completed")); err != nil {
+		t.Errorf("Expected the notification to be verified, got %v", err)
+	}
+	if err := paypal.VerifyNotification([]byte("payment_status=Completed&txn_id=1")); !errors.Is(err, ErrPayPalNotificationInvalid) {
+		t.Errorf("Expected a changed notification to be invalid, got %v", err)
+	}
+	if err := paypal.VerifyNotification([]byte("txn_id=down")); err == nil || errors.Is(err, ErrPayPalNotificationInvalid) {
+		t.Errorf("Expected an error when PayPal is unavailable, got %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// PDF renderers
+const (
+	PDFRendererBuiltin = "builtin"
+	PDFRendererHTML    = "html"
+)
+
+// PDFRenderers lists the supported PDF renderers
+var PDFRenderers = []string{PDFRendererBuiltin, PDFRendererHTML}
+
+// Programs that convert HTML invoices to PDF
+const (
+	HTMLConverterChromium    = "chromium"
+	HTMLConverterWkhtmltopdf = "wkhtmltopdf"
+)
+
+// HTMLConverters lists the supported HTML to PDF converters
+var HTMLConverters = []string{HTMLConverterChromium, HTMLConverterWkhtmltopdf}
+
+// htmlConverterBinaries are the executables looked up in PATH for each converter
+var htmlConverterBinaries = map[string][]string{
+	HTMLConverterChromium:    {"chromium", "chromium-browser", "google-chrome", "google-chrome-stable"},
+	HTMLConverterWkhtmltopdf: {"wkhtmltopdf"},
+}
+
+// HTMLTemplatesDir is the folder of the data directory with the HTML invoice
+// template; without one the built-in template is used
+const HTMLTemplatesDir = "pdf-templates"
+
+// HTMLInvoiceTemplate is the file name of the HTML invoice template
+const HTMLInvoiceTemplate = "invoice.html"
+
+// htmlConversionTimeout is how long a converter may take for one invoice
+const htmlConversionTimeout = time.Minute
+
+// maxConverterOutput limits the error output of a converter kept for the error message
+const maxConverterOutput = 8 << 10
+
+//go:embed pdf_templates/invoice.html
+var defaultHTMLInvoiceTemplate string
+
+// HTMLInvoiceData is the data HTML invoice templates are executed with
+type HTMLInvoiceData struct {
+	Invoice  *models.Invoice
+	Business *models.Business
+	Client   *models.Client
+	Items    []models.InvoiceItem
+	Totals   models.InvoiceTotals
+	MixedVat bool         // Some items are outside the VAT base of an invoice with VAT
+	Title    string       // INVOICE, PRO FORMA INVOICE or CANCELLATION INVOICE
+	Logo     template.URL // data: URL of the business logo, empty without one
+	// Primary and Secondary are theme colors taken from the logo, e.g. #009688
+	Primary   string
+	Secondary string
+	// The bank accounts to list: the ones in the invoice currency, or else the primary one
+	ShowPrimaryAccount   bool
+	ShowSecondaryAccount bool
+	// CryptoQR is a data: URL of the QR code of the business's USDC address,
+	// empty when it accepts none
+	CryptoQR template.URL
+	// VerifactuQR is a data: URL of the QR code invoices registered with
+	// Verifactu are checked with at AEAT, empty for other invoices
+	VerifactuQR template.URL
+	// Browser is set when the invoice is opened for printing from the
+	// browser, which shows a toolbar that is left out of the print
+	Browser bool
+}
+
+// htmlTemplateFuncs are the functions available in HTML invoice templates.
+// Invoices are rendered with the functions of the client's format.
+var htmlTemplateFuncs = formatTemplateFuncs(models.DefaultInvoiceFormat)
+
+// formatTemplateFuncs returns the functions of HTML invoice templates that
+// print dates and numbers in a format
+func formatTemplateFuncs(format models.InvoiceFormat) template.FuncMap {
+	return template.FuncMap{
+		// money formats an amount with its currency code, e.g. 1190.00 EUR
+		"money": func(amount models.Money, currency string) string {
+			return format.FormatAmount(amount) + " " + currency
+		},
+		// number formats a number with a number of decimal places, e.g. 7.50
+		"number": func(value float64, decimals int) string {
+			return format.FormatNumber(value, decimals)
+		},
+		// date formats a date, e.g. Mar 01, 2024
+		"date": func(date time.Time) string {
+			return format.FormatDate(date)
+		},
+		// discount describes a percentage and/or fixed discount, e.g. 10% + 5.00 EUR
+		"discount": func(percent float64, amount models.Money, currency string) string {
+			var parts []string
+			if percent > 0 {
+				parts = append(parts, strconv.FormatFloat(percent, 'f', -1, 64)+"%")
+			}
+			if amount > 0 {
+				parts = append(parts, format.FormatAmount(amount)+" "+currency)
+			}
+			return strings.Join(parts, " + ")
+		},
+	}
+}
+
+// ParseHTMLInvoiceTemplate parses the HTML invoice template of a data
+// directory, or the built-in template if there is none
+func ParseHTMLInvoiceTemplate(dataDir string) (*template.Template, error) {
+	text := defaultHTMLInvoiceTemplate
+	path := filepath.Join(dataDir, HTMLTemplatesDir, HTMLInvoiceTemplate)
+	if data, err := os.ReadFile(path); err == nil {
+		text = string(data)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read HTML invoice template: %w", err)
+	}
+
+	tmpl, err := template.New(HTMLInvoiceTemplate).Funcs(htmlTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTML invoice template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// RenderInvoiceHTML executes the HTML invoice template for an invoice
+func (s *PDFService) RenderInvoiceHTML(invoice *models.Invoice, business *models.Business, client *models.Client, items []models.InvoiceItem) ([]byte, error) {
+	return s.renderInvoiceHTML(invoice, business, client, items, false)
+}
+
+// RenderInvoicePrintHTML executes the HTML invoice template for printing or
+// saving as PDF from the browser, without the PDF service
+func (s *PDFService) RenderInvoicePrintHTML(invoice *models.Invoice, business *models.Business, client *models.Client, items []models.InvoiceItem) ([]byte, error) {
+	return s.renderInvoiceHTML(invoice, business, client, items, true)
+}
+
+func (s *PDFService) renderInvoiceHTML(invoice *models.Invoice, business *models.Business, client *models.Client, items []models.InvoiceItem, browser bool) ([]byte, error) {
+	tmpl, err := ParseHTMLInvoiceTemplate(s.dataDir)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.Funcs(formatTemplateFuncs(clientInvoiceFormat(client)))
+
+	data := HTMLInvoiceData{
+		Invoice:   invoice,
+		Business:  business,
+		Client:    client,
+		Items:     items,
+		Totals:    invoice.CalculateTotals(items),
+		MixedVat:  invoice.HasMixedVat(items),
+		Title:     "INVOICE",
+		Primary:   "#323232",
+		Secondary: "#646464",
+		Browser:   browser,
+	}
+	if invoice.IsProforma() {
+		data.Title = "PRO FORMA INVOICE"
+	} else if invoice.IsStorno() {
+		data.Title = "CANCELLATION INVOICE"
+	}
+	data.ShowPrimaryAccount, data.ShowSecondaryAccount = bankAccountsToShow(business, invoice.Currency)
+	if business.CryptoAddress != "" {
+		qr, err := cryptoQRCode(business.CryptoAddress)
+		if err != nil {
+			return nil, err
+		}
+		data.CryptoQR = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(qr))
+	}
+	verifactuQR, err := verifactuQRCode(s.settingsService, invoice, business)
+	if err != nil {
+		return nil, err
+	}
+	if verifactuQR != nil {
+		data.VerifactuQR = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(verifactuQR))
+	}
+
+	if business.LogoPath != "" {
+		logoPath := filepath.Join(s.dataDir, "images", filepath.Base(business.LogoPath))
+		if logo, err := os.ReadFile(logoPath); err == nil {
+			data.Logo = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(logo))
+			if theme, err := ExtractColorsFromImage(logoPath); err == nil {
+				data.Primary = "#" + RGBToHex(theme.Primary.R, theme.Primary.G, theme.Primary.B)
+				data.Secondary = "#" + RGBToHex(theme.Secondary.R, theme.Secondary.G, theme.Secondary.B)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render HTML invoice template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// renderInvoiceFromHTML renders the HTML invoice template and converts it to a PDF
+func (s *PDFService) renderInvoiceFromHTML(invoice *models.Invoice, business *models.Business, client *models.Client, items []models.InvoiceItem) ([]byte, error) {
+	html, err := s.RenderInvoiceHTML(invoice, business, client, items)
+	if err != nil {
+		return nil, err
+	}
+	converter := ""
+	if s.settingsService != nil {
+		converter = s.settingsService.GetString(SettingPDFHTMLConverter)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), htmlConversionTimeout)
+	defer cancel()
+	return ConvertHTMLToPDF(ctx, html, converter)
+}
+
+// findHTMLConverter returns the converter and the path of its executable.
+// Without a converter the first one installed is used.
+func findHTMLConverter(converter string) (string, string, error) {
+	candidates := HTMLConverters
+	if converter != "" {
+		candidates = []string{converter}
+	}
+	for _, candidate := range candidates {
+		for _, binary := range htmlConverterBinaries[candidate] {
+			if path, err := exec.LookPath(binary); err == nil {
+				return candidate, path, nil
+			}
+		}
+	}
+	if converter != "" {
+		return "", "", fmt.Errorf("%s is not installed", converter)
+	}
+	return "", "", errors.New("neither Chromium nor wkhtmltopdf is installed")
+}
+
+// ConvertHTMLToPDF prints an HTML document to a PDF with headless Chromium
+// or wkhtmltopdf. Images should be embedded in the document as data: URLs.
+func ConvertHTMLToPDF(ctx context.Context, html []byte, converter string) ([]byte, error) {
+	converter, binary, err := findHTMLConverter(converter)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "simple-invoice-html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "invoice.html")
+	output := filepath.Join(dir, "invoice.pdf")
+	if err := os.WriteFile(input, html, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write HTML invoice: %w", err)
+	}
+
+	var args []string
+	switch converter {
+	case HTMLConverterChromium:
+		args = []string{"--headless", "--disable-gpu", "--no-sandbox", "--no-pdf-header-footer", "--print-to-pdf-no-header",
+			"--user-data-dir=" + filepath.Join(dir, "profile"), "--print-to-pdf=" + output, "file://" + input}
+	case HTMLConverterWkhtmltopdf:
+		args = []string{"--quiet", "--print-media-type", "--page-size", "A4", input, output}
+	}
+
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Dir = dir
+	cmd.WaitDelay = time.Second
+	var stderr bytes.Buffer
+	cmd.Stderr = &limitedBuffer{buf: &stderr, remaining: maxConverterOutput}
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("%s failed: %v: %s", converter, err, strings.TrimSpace(stderr.String()))
+	}
+
+	pdf, err := os.ReadFile(output)
+	if err != nil {
+		return nil, fmt.Errorf("%s did not write a PDF: %w", converter, err)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+		return nil, fmt.Errorf("%s did not write a PDF", converter)
+	}
+	return pdf, nil
+}
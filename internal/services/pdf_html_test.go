@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestRenderInvoiceHTML(t *testing.T) {
+	pdfService, tempDir, cleanup := setupTestPDFService(t)
+	defer cleanup()
+
+	issueDate := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{InvoiceNumber: "INV-2024-0001", IssueDate: issueDate, DueDate: issueDate.AddDate(0, 0, 30),
+		TotalAmount: 11900, VatRate: 19, VatAmount: 1900, Currency: "EUR", Notes: "Thank you <3"}
+	business := &models.Business{Name: "Example Consulting", Currency: "EUR", IBAN: "DE89370400440532013000"}
+	client := &models.Client{Name: "Acme GmbH & Co. KG", Country: "DE"}
+	items := []models.InvoiceItem{{Description: "Consulting", Quantity: 10, Unit: "hours", UnitPrice: 1000, Amount: 10000}}
+
+	html, err := pdfService.RenderInvoiceHTML(invoice, business, client, items)
+	if err != nil {
+		t.Fatalf("RenderInvoiceHTML failed: %v", err)
+	}
+	// Dates and amounts are printed in the usual format of the client's country
+	for _, want := range []string{"INV-2024-0001", "Acme GmbH &amp; Co. KG", "Thank you &lt;3", "100,00 EUR", "119,00 EUR", "01.03.2024", "10,00 hours", "DE89370400440532013000", "color: #323232"} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("Expected the HTML to contain %q", want)
+		}
+	}
+
+	if strings.Contains(string(html), "Pay in USDC") {
+		t.Error("Expected no USDC payment without an address")
+	}
+	business.CryptoAddress, business.CryptoNetwork = "0x71C7656EC7ab88b098defB751B7401B5f6d8976F", "Base"
+	html, err = pdfService.RenderInvoiceHTML(invoice, business, client, items)
+	if err != nil {
+		t.Fatalf("RenderInvoiceHTML failed: %v", err)
+	}
+	for _, want := range []string{"Network: Base", "0x71C7656EC7ab88b098defB751B7401B5f6d8976F", "119,00 EUR in USDC", `src="data:image/png;base64,`} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("Expected the HTML to contain %q", want)
+		}
+	}
+
+	// or in the formats set for the client
+	client.DateFormat, client.NumberFormat = "2006-01-02", "1,234.56"
+	html, err = pdfService.RenderInvoiceHTML(invoice, business, client, items)
+	if err != nil {
+		t.Fatalf("RenderInvoiceHTML failed: %v", err)
+	}
+	for _, want := range []string{"119.00 EUR", "2024-03-01"} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("Expected the HTML to contain %q", want)
+		}
+	}
+
+	if strings.Contains(string(html), "window.print()") {
+		t.Error("Expected no print toolbar in the HTML converted to PDF")
+	}
+	html, err = pdfService.RenderInvoicePrintHTML(invoice, business, client, items)
+	if err != nil {
+		t.Fatalf("RenderInvoicePrintHTML failed: %v", err)
+	}
+	if !strings.Contains(string(html), "window.print()") || !strings.Contains(string(html), "INV-2024-0001") {
+		t.Error("Expected the invoice with a print toolbar for the browser")
+	}
+
+	// A template in the data directory replaces the built-in one
+	dir := filepath.Join(tempDir, HTMLTemplatesDir)
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, HTMLInvoiceTemplate), []byte(`<p>{{.Title}} {{.Invoice.InvoiceNumber}}: {{money .Invoice.TotalAmount .Invoice.Currency}}</p>`), 0644)
+	html, err = pdfService.RenderInvoiceHTML(invoice, business, client, items)
+	if err != nil {
+		t.Fatalf("RenderInvoiceHTML failed: %v", err)
+	}
+	if string(html) != "<p>INVOICE INV-2024-0001: 119.00 EUR</p>" {
+		t.Errorf("Unexpected HTML from the custom template: %s", html)
+	}
+
+	os.WriteFile(filepath.Join(dir, HTMLInvoiceTemplate), []byte(`<p>{{.Invoice.InvoiceNumber</p>`), 0644)
+	if _, err := pdfService.RenderInvoiceHTML(invoice, business, client, items); err == nil {
+		t.Error("Expected an error for a template that does not parse")
+	}
+}
+
+func TestConvertHTMLToPDF(t *testing.T) {
+	// A stand-in for wkhtmltopdf that writes a PDF to its last argument
+	bin := t.TempDir()
+	script := "#!/bin/sh\nfor last; do :; done\nprintf '%%PDF-1.4 converted' > \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "wkhtmltopdf"), []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write converter: %v", err)
+	}
+	t.Setenv("PATH", bin)
+
+	pdf, err := ConvertHTMLToPDF(context.Background(), []byte("<p>Invoice</p>"), "")
+	if err != nil {
+		t.Fatalf("ConvertHTMLToPDF failed: %v", err)
+	}
+	if string(pdf) != "%PDF-1.4 converted" {
+		t.Errorf("Unexpected PDF: %q", pdf)
+	}
+
+	if _, err := ConvertHTMLToPDF(context.Background(), []byte("<p>Invoice</p>"), HTMLConverterChromium); err == nil || !strings.Contains(err.Error(), "not installed") {
+		t.Errorf("Expected an error for a converter that is not installed, got %v", err)
+	}
+
+	// A converter that fails reports its error output
+	os.WriteFile(filepath.Join(bin, "wkhtmltopdf"), []byte("#!/bin/sh\necho 'Exit with code 1 due to network error' >&2\nexit 1\n"), 0755)
+	if _, err := ConvertHTMLToPDF(context.Background(), []byte("<p>Invoice</p>"), HTMLConverterWkhtmltopdf); err == nil || !strings.Contains(err.Error(), "network error") {
+		t.Errorf("Expected the converter's error output, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"bytes"
 	"fmt"
 	"image"
 	"image/color"
@@ -9,15 +10,36 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/refdata"
 	"github.com/jung-kurt/gofpdf/v2"
+	"github.com/skip2/go-qrcode"
 )
 
+// PreviewsDir is the folder of the data directory that holds preview PDFs.
+// It lives outside pdfs so previews are never included in backups.
+var PreviewsDir = filepath.Join("tmp", "previews")
+
+// previewCleanupInterval is how often old previews are looked for
+const previewCleanupInterval = time.Hour
+
 // PDFService provides methods for generating PDF invoices
 type PDFService struct {
-	dataDir string
+	dataDir         string
+	signer          *PDFSigner
+	settingsService *SettingsService
+	logger          *Logger
+	stop            chan struct{}
+	done            chan struct{}
+
+	// reproducibleTime is the time documents are stamped with in
+	// reproducible mode, zero otherwise
+	reproducibleTime time.Time
 }
 
 // NewPDFService creates a new PDFService
@@ -27,6 +49,144 @@ func NewPDFService(dataDir string) *PDFService {
 	}
 }
 
+// SetSettingsService sets the settings used for output options such as PDF/A compliance
+func (s *PDFService) SetSettingsService(settingsService *SettingsService) {
+	s.settingsService = settingsService
+}
+
+// SetLogger sets the logger used by the preview cleanup
+func (s *PDFService) SetLogger(logger *Logger) {
+	s.logger = logger
+}
+
+// SetSigner sets the signer used to digitally sign generated invoices when a certificate is configured
+func (s *PDFService) SetSigner(signer *PDFSigner) {
+	s.signer = signer
+}
+
+// SetReproducible makes the service stamp documents with a fixed creation
+// time, write their objects in a fixed order and leave their content streams
+// uncompressed, so the same invoice always renders to the same bytes that can
+// be compared with a golden file
+func (s *PDFService) SetReproducible(created time.Time) {
+	s.reproducibleTime = created
+}
+
+// newDocument starts an A4 document with the metadata of all generated PDFs
+func (s *PDFService) newDocument() *gofpdf.Fpdf {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(15, 15, 15)
+	pdf.SetAuthor("Simple Invoice", true)
+	pdf.SetCreator("Simple Invoice", true)
+	if !s.reproducibleTime.IsZero() {
+		pdf.SetCreationDate(s.reproducibleTime)
+		pdf.SetModificationDate(s.reproducibleTime)
+		pdf.SetCatalogSort(true)
+		pdf.SetCompression(false)
+	}
+	return pdf
+}
+
+// now returns the time documents are created at
+func (s *PDFService) now() time.Time {
+	if !s.reproducibleTime.IsZero() {
+		return s.reproducibleTime
+	}
+	return time.Now()
+}
+
+// StartPreviewCleanup removes old previews now and then every hour, keeping
+// previews for the number of hours in the preview retention setting
+func (s *PDFService) StartPreviewCleanup() {
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(previewCleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			RunProtected(s.logger, "preview cleanup", s.cleanupPreviews)
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// StopPreviewCleanup stops the preview cleanup started by StartPreviewCleanup
+func (s *PDFService) StopPreviewCleanup() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
+
+// cleanupPreviews runs CleanupPreviews with the configured retention and logs the outcome
+func (s *PDFService) cleanupPreviews() {
+	hours := 24
+	if s.settingsService != nil {
+		// Keep at least an hour so previews being looked at are not removed
+		hours = max(s.settingsService.GetInt(SettingPDFPreviewRetention), 1)
+	}
+
+	removed, err := s.CleanupPreviews(time.Duration(hours) * time.Hour)
+	if s.logger == nil {
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to clean up previews: %v", err)
+	} else if removed > 0 {
+		s.logger.Info("Removed %d preview PDFs older than %d hours", removed, hours)
+	}
+}
+
+// CleanupPreviews deletes previews last written more than maxAge ago and returns
+// how many were removed. Previews left in the pdfs directory by older versions
+// are removed as well.
+func (s *PDFService) CleanupPreviews(maxAge time.Duration) (int, error) {
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+
+	entries, err := os.ReadDir(filepath.Join(s.dataDir, PreviewsDir))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("failed to read previews directory: %w", err)
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dataDir, PreviewsDir, entry.Name())); err != nil {
+			return removed, fmt.Errorf("failed to remove preview: %w", err)
+		}
+		removed++
+	}
+
+	// Older versions wrote previews to pdfs, where they were also backed up
+	legacy, _ := filepath.Glob(filepath.Join(s.dataDir, "pdfs", "invoice-preview-*.pdf"))
+	for _, path := range legacy {
+		if err := os.Remove(path); err != nil {
+			return removed, fmt.Errorf("failed to remove preview: %w", err)
+		}
+		removed++
+	}
+	if err := os.RemoveAll(filepath.Join(s.dataDir, "pdfs", "previews")); err != nil {
+		return removed, fmt.Errorf("failed to remove old previews directory: %w", err)
+	}
+
+	return removed, nil
+}
+
 // ThemeColors represents the primary and secondary colors for the invoice theme
 type ThemeColors struct {
 	Primary   color.RGBA
@@ -137,30 +297,105 @@ func ExtractColorsFromImage(imgPath string) (ThemeColors, error) {
 	}, nil
 }
 
-// GenerateInvoice generates a PDF invoice
+// GenerateInvoice generates a PDF invoice in the pdfs directory and signs it
+// when a certificate is configured
 func (s *PDFService) GenerateInvoice(invoice *models.Invoice, business *models.Business, client *models.Client, items []models.InvoiceItem) (string, error) {
-	// Create a new PDF with UTF-8 encoding
-	pdf := gofpdf.New("P", "mm", "A4", "")
-	pdf.SetMargins(15, 15, 15)
+	data, err := s.RenderInvoice(invoice, business, client, items)
+	if err != nil {
+		return "", err
+	}
 
-	// Enable UTF-8 encoding
-	pdf.SetAuthor("Simple Invoice", true)
-	pdf.SetCreator("Simple Invoice", true)
+	// Ensure the pdfs directory exists
+	pdfsDir := filepath.Join(s.dataDir, "pdfs")
+	if err := os.MkdirAll(pdfsDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create pdfs directory: %w", err)
+	}
+
+	// Save PDF to file
+	pdfPath := filepath.Join(pdfsDir, invoice.PDFFilename())
+	if err := os.WriteFile(pdfPath, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to save PDF file: %w", err)
+	}
+
+	// Sign the saved file so its contents can no longer change unnoticed
+	if s.signer != nil && s.signer.Enabled() {
+		if err := s.signer.SignFile(pdfPath); err != nil {
+			return "", fmt.Errorf("failed to sign PDF: %w", err)
+		}
+	}
+
+	return pdfPath, nil
+}
+
+// GeneratePreview renders an unsaved invoice into the previews directory,
+// which is not backed up and is emptied by the preview cleanup
+func (s *PDFService) GeneratePreview(invoice *models.Invoice, business *models.Business, client *models.Client, items []models.InvoiceItem) (string, error) {
+	data, err := s.RenderInvoice(invoice, business, client, items)
+	if err != nil {
+		return "", err
+	}
+
+	previewsDir := filepath.Join(s.dataDir, PreviewsDir)
+	if err := os.MkdirAll(previewsDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create previews directory: %w", err)
+	}
+
+	previewPath := filepath.Join(previewsDir, invoice.PDFFilename())
+	if err := os.WriteFile(previewPath, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to save preview file: %w", err)
+	}
+	return previewPath, nil
+}
+
+// RenderInvoice renders a PDF invoice and returns its contents
+func (s *PDFService) RenderInvoice(invoice *models.Invoice, business *models.Business, client *models.Client, items []models.InvoiceItem) ([]byte, error) {
+	if s.settingsService != nil && s.settingsService.GetString(SettingPDFRenderer) == PDFRendererHTML {
+		data, err := s.renderInvoiceFromHTML(invoice, business, client, items)
+		if err != nil {
+			return nil, err
+		}
+		return s.finishInvoicePDF(data, invoice)
+	}
+
+	// Create a new PDF with UTF-8 encoding
+	pdf := s.newDocument()
+
+	// PDF/A requires embedded fonts; otherwise use the core Helvetica font
+	pdfA := s.settingsService != nil && s.settingsService.GetBool(SettingPDFA)
+	fontFamily := "Helvetica"
+	if pdfA {
+		addPDFAFonts(pdf)
+		fontFamily = pdfAFontFamily
+	}
 
-	// Use core fonts with encoding for currency symbols
 	pdf.AddPage()
 
 	// Set default font
-	pdf.SetFont("Helvetica", "", 10)
+	pdf.SetFont(fontFamily, "", 10)
 
 	// Define theme colors
 	var theme ThemeColors
 	var useColors bool = false
 
+	// Dates and amounts are printed in the client's format
+	format := clientInvoiceFormat(client)
+
 	// Helper function to format currency values
-	formatCurrency := func(amount float64) string {
+	formatCurrency := func(amount models.Money) string {
 		// Use currency code instead of symbol to avoid encoding issues
-		return fmt.Sprintf("%.2f %s", amount, invoice.Currency)
+		return format.FormatAmount(amount) + " " + invoice.Currency
+	}
+
+	// discountLabel describes a percentage and/or fixed discount, e.g. "Discount 10% + 5.00 EUR"
+	discountLabel := func(percent float64, amount models.Money) string {
+		var parts []string
+		if percent > 0 {
+			parts = append(parts, strconv.FormatFloat(percent, 'f', -1, 64)+"%")
+		}
+		if amount > 0 {
+			parts = append(parts, formatCurrency(amount))
+		}
+		return "Discount " + strings.Join(parts, " + ")
 	}
 
 	// Check if business has a logo
@@ -291,7 +526,7 @@ func (s *PDFService) GenerateInvoice(invoice *models.Invoice, business *models.B
 	}
 
 	// Modern header with clean typography
-	pdf.SetFont("Helvetica", "B", 24)
+	pdf.SetFont(fontFamily, "B", 24)
 	if useColors {
 		pdf.SetTextColor(hexToR(primaryColor), hexToG(primaryColor), hexToB(primaryColor))
 	} else {
@@ -299,10 +534,16 @@ func (s *PDFService) GenerateInvoice(invoice *models.Invoice, business *models.B
 	}
 	pdf.SetY(15)
 	pdf.SetX(60)
-	pdf.Cell(0, 10, "INVOICE")
+	if invoice.IsProforma() {
+		pdf.Cell(0, 10, "PRO FORMA INVOICE")
+	} else if invoice.IsStorno() {
+		pdf.Cell(0, 10, "CANCELLATION INVOICE")
+	} else {
+		pdf.Cell(0, 10, "INVOICE")
+	}
 
 	// Add invoice number with secondary color
-	pdf.SetFont("Helvetica", "", 12)
+	pdf.SetFont(fontFamily, "", 12)
 	if useColors {
 		pdf.SetTextColor(hexToR(secondaryColor), hexToG(secondaryColor), hexToB(secondaryColor))
 	} else {
@@ -312,13 +553,37 @@ func (s *PDFService) GenerateInvoice(invoice *models.Invoice, business *models.B
 	pdf.SetX(60)
 	pdf.Cell(0, 10, "#"+invoice.InvoiceNumber)
 
+	// Invoices KSeF accepted carry their KSeF number
+	if invoice.KSeFNumber != "" {
+		pdf.SetFont(fontFamily, "", 8)
+		pdf.SetXY(60, 33)
+		pdf.Cell(0, 5, "KSeF: "+invoice.KSeFNumber)
+	}
+
+	// Invoices registered with Verifactu carry the QR code to check them with AEAT
+	verifactuQR, err := verifactuQRCode(s.settingsService, invoice, business)
+	if err != nil {
+		return nil, err
+	}
+	if verifactuQR != nil {
+		pdf.RegisterImageOptionsReader("verifactu-qr", gofpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(verifactuQR))
+		pdf.SetFont(fontFamily, "", 7)
+		pdf.SetTextColor(80, 80, 80)
+		pdf.SetXY(160, 5)
+		pdf.CellFormat(35, 4, "QR tributario:", "", 0, "C", false, 0, "")
+		pdf.ImageOptions("verifactu-qr", 165, 9, 25, 25, false, gofpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+		pdf.SetXY(160, 34)
+		pdf.SetFont(fontFamily, "B", 7)
+		pdf.CellFormat(35, 4, "VERI*FACTU", "", 0, "C", false, 0, "")
+	}
+
 	// Add a subtle divider line
 	pdf.SetDrawColor(230, 230, 230)
 	pdf.Line(15, 40, 195, 40)
 
 	// Business and client information in a modern two-column layout
 	pdf.SetY(45)
-	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetFont(fontFamily, "B", 10)
 	pdf.SetTextColor(80, 80, 80)
 	pdf.Cell(90, 6, "FROM")
 	pdf.SetX(105)
@@ -326,25 +591,25 @@ func (s *PDFService) GenerateInvoice(invoice *models.Invoice, business *models.B
 
 	// Business details
 	pdf.SetY(53)
-	pdf.SetFont("Helvetica", "B", 11)
+	pdf.SetFont(fontFamily, "B", 11)
 	pdf.SetTextColor(50, 50, 50)
 	pdf.Cell(90, 6, business.Name)
 
 	// Client details
 	pdf.SetX(105)
-	pdf.SetFont("Helvetica", "B", 11)
+	pdf.SetFont(fontFamily, "B", 11)
 	pdf.Cell(90, 6, client.Name)
 
 	// Business address
 	pdf.SetY(61)
-	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetFont(fontFamily, "", 9)
 	pdf.SetTextColor(100, 100, 100)
 	pdf.MultiCell(90, 5.5, business.Address+"\n"+business.City+", "+business.PostalCode+"\n"+business.Country, "", "", false)
 
 	// Add VAT ID and other business details
 	y := pdf.GetY() + 3
 	pdf.SetY(y)
-	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetFont(fontFamily, "", 9)
 	pdf.Cell(90, 5, "VAT ID: "+business.VatID)
 	y += 5.5
 	pdf.SetY(y)
@@ -354,13 +619,13 @@ func (s *PDFService) GenerateInvoice(invoice *models.Invoice, business *models.B
 	if business.ExtraBusinessDetail != "" {
 		y += 7
 		pdf.SetY(y)
-		pdf.SetFont("Helvetica", "B", 9)
+		pdf.SetFont(fontFamily, "B", 9)
 		pdf.SetTextColor(80, 80, 80)
 		pdf.Cell(90, 5, "ADDITIONAL BUSINESS INFORMATION")
 
 		y += 5
 		pdf.SetY(y)
-		pdf.SetFont("Helvetica", "", 9) // Changed from italic to normal
+		pdf.SetFont(fontFamily, "", 9) // Changed from italic to normal
 		pdf.SetTextColor(100, 100, 100)
 		pdf.MultiCell(90, 5.5, business.ExtraBusinessDetail, "", "", false)
 		y = pdf.GetY() + 10 // Increased spacing after the details from 5 to 10
@@ -369,7 +634,7 @@ func (s *PDFService) GenerateInvoice(invoice *models.Invoice, business *models.B
 	// Client address
 	pdf.SetY(61)
 	pdf.SetX(105)
-	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetFont(fontFamily, "", 9)
 	pdf.MultiCell(90, 5.5, client.Address+"\n"+client.City+", "+client.PostalCode+"\n"+client.Country, "", "", false)
 
 	// Add VAT ID for client
@@ -382,19 +647,53 @@ func (s *PDFService) GenerateInvoice(invoice *models.Invoice, business *models.B
 	totalHeight := math.Max(y, pdf.GetY()) // Get the maximum Y position from both columns
 	y = totalHeight + 30                   // Increased spacing before the date section from 20 to 30
 	pdf.SetY(y)
-	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetFont(fontFamily, "B", 10)
 	pdf.SetTextColor(80, 80, 80)
 	pdf.Cell(60, 6, "ISSUE DATE")
 	pdf.SetX(75)
 	pdf.Cell(60, 6, "DUE DATE")
+	if invoice.HasServicePeriod() {
+		pdf.SetX(135)
+		pdf.Cell(60, 6, "SERVICE PERIOD")
+	}
 
 	// Date values
 	pdf.SetY(y + 6)
-	pdf.SetFont("Helvetica", "", 10)
+	pdf.SetFont(fontFamily, "", 10)
 	pdf.SetTextColor(50, 50, 50)
-	pdf.Cell(60, 6, invoice.IssueDate.Format("Jan 02, 2006"))
+	pdf.Cell(60, 6, format.FormatDate(invoice.IssueDate))
 	pdf.SetX(75)
-	pdf.Cell(60, 6, invoice.DueDate.Format("Jan 02, 2006"))
+	pdf.Cell(60, 6, format.FormatDate(invoice.DueDate))
+	if invoice.HasServicePeriod() {
+		pdf.SetX(135)
+		pdf.Cell(60, 6, format.FormatDate(invoice.ServicePeriodStart)+" - "+format.FormatDate(invoice.ServicePeriodEnd))
+	}
+
+	// Purchase order and contract references, which many clients require to process an invoice
+	if invoice.PONumber != "" || invoice.ContractReference != "" {
+		y += 14
+		pdf.SetY(y)
+		pdf.SetFont(fontFamily, "B", 10)
+		pdf.SetTextColor(80, 80, 80)
+		if invoice.PONumber != "" {
+			pdf.Cell(60, 6, "PO NUMBER")
+		}
+		if invoice.ContractReference != "" {
+			pdf.SetX(75)
+			pdf.Cell(120, 6, "CONTRACT REFERENCE")
+		}
+
+		pdf.SetY(y + 6)
+		pdf.SetFont(fontFamily, "", 10)
+		pdf.SetTextColor(50, 50, 50)
+		if invoice.PONumber != "" {
+			pdf.Cell(60, 6, invoice.PONumber)
+		}
+		if invoice.ContractReference != "" {
+			pdf.SetX(75)
+			pdf.Cell(120, 6, invoice.ContractReference)
+		}
+	}
 
 	// Add a subtle divider line
 	pdf.SetDrawColor(230, 230, 230)
@@ -405,7 +704,7 @@ func (s *PDFService) GenerateInvoice(invoice *models.Invoice, business *models.B
 	pdf.SetY(y)
 
 	// Table headers with clean design
-	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetFont(fontFamily, "B", 10)
 	pdf.SetFillColor(245, 245, 245)
 	pdf.SetTextColor(80, 80, 80)
 
@@ -421,7 +720,7 @@ func (s *PDFService) GenerateInvoice(invoice *models.Invoice, business *models.B
 
 	// Table rows
 	y += 8
-	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetFont(fontFamily, "", 9)
 	pdf.SetTextColor(70, 70, 70)
 
 	// Alternating row colors for better readability
@@ -446,13 +745,39 @@ func (s *PDFService) GenerateInvoice(invoice *models.Invoice, business *models.B
 
 		pdf.SetY(y - 8) // Go back to the start of this row
 		pdf.SetX(105)
-		pdf.Cell(30, 8, fmt.Sprintf("%.2f", item.Quantity))
+		pdf.Cell(30, 8, strings.TrimSpace(format.FormatNumber(item.Quantity, 2)+" "+item.Unit))
 		pdf.SetX(135)
 		pdf.Cell(30, 8, formatCurrency(item.UnitPrice))
 		pdf.SetX(165)
 		pdf.Cell(30, 8, formatCurrency(item.Amount))
 
 		y += 8
+
+		// Discounted items show the discount below the description; the amount above is already net
+		if item.HasDiscount() {
+			pdf.SetY(y - 2)
+			pdf.SetX(15)
+			pdf.SetFont(fontFamily, "", 8)
+			pdf.SetTextColor(120, 120, 120)
+			pdf.Cell(90, 5, "  "+discountLabel(item.DiscountPercent, item.DiscountAmount)+" on "+formatCurrency(item.GrossAmount()))
+			pdf.SetX(165)
+			pdf.Cell(30, 5, formatCurrency(item.Discount().Neg()))
+			pdf.SetFont(fontFamily, "", 9)
+			pdf.SetTextColor(70, 70, 70)
+			y += 4
+		}
+
+		// Items outside the VAT base of an invoice with VAT say so
+		if item.VatExempt && invoice.HasMixedVat(items) {
+			pdf.SetY(y - 2)
+			pdf.SetX(15)
+			pdf.SetFont(fontFamily, "", 8)
+			pdf.SetTextColor(120, 120, 120)
+			pdf.Cell(90, 5, "  Not subject to VAT")
+			pdf.SetFont(fontFamily, "", 9)
+			pdf.SetTextColor(70, 70, 70)
+			y += 4
+		}
 	}
 
 	// Add a subtle divider line
@@ -462,32 +787,64 @@ func (s *PDFService) GenerateInvoice(invoice *models.Invoice, business *models.B
 	// Add totals with modern styling
 	y += 10
 	pdf.SetY(y)
-	pdf.SetFont("Helvetica", "", 10)
+	pdf.SetFont(fontFamily, "", 10)
 	pdf.SetTextColor(80, 80, 80)
-	pdf.SetX(135)
-	pdf.Cell(30, 6, "Subtotal:")
-	pdf.SetX(165)
-	pdf.Cell(30, 6, formatCurrency(invoice.TotalAmount-invoice.VatAmount))
+	if invoice.HasDiscount() {
+		totals := invoice.CalculateTotals(items)
+		pdf.SetX(135)
+		pdf.Cell(30, 6, "Items total:")
+		pdf.SetX(165)
+		pdf.Cell(30, 6, formatCurrency(totals.ItemsTotal))
 
-	y += 6
-	pdf.SetY(y)
-	pdf.SetX(135)
+		y += 6
+		pdf.SetY(y)
+		pdf.SetX(120)
+		pdf.CellFormat(45, 6, discountLabel(invoice.DiscountPercent, invoice.DiscountAmount)+":", "", 0, "R", false, 0, "")
+		pdf.SetX(165)
+		pdf.Cell(30, 6, formatCurrency(totals.Discount.Neg()))
 
-	// VAT line
-	if invoice.ReverseChargeVat {
-		pdf.Cell(30, 6, fmt.Sprintf("VAT (%.1f%%):", invoice.VatRate))
+		y += 6
+		pdf.SetY(y)
+	}
+	// Businesses exempt from VAT show neither the net amount nor a VAT line
+	if !business.VatExempt {
+		pdf.SetX(135)
+		pdf.Cell(30, 6, "Subtotal:")
 		pdf.SetX(165)
-		pdf.Cell(30, 6, "Reverse Charge")
+		pdf.Cell(30, 6, formatCurrency(invoice.TotalAmount-invoice.VatAmount))
+
+		if invoice.HasMixedVat(items) {
+			y += 6
+			pdf.SetY(y)
+			pdf.SetX(120)
+			pdf.CellFormat(45, 6, "Not subject to VAT:", "", 0, "R", false, 0, "")
+			pdf.SetX(165)
+			pdf.Cell(30, 6, formatCurrency(invoice.CalculateTotals(items).NonTaxable))
+		}
+
+		y += 6
+		pdf.SetY(y)
+		pdf.SetX(135)
+
+		// VAT line
+		if invoice.ReverseChargeVat {
+			pdf.Cell(30, 6, fmt.Sprintf("VAT (%.1f%%):", invoice.VatRate))
+			pdf.SetX(165)
+			pdf.Cell(30, 6, "Reverse Charge")
+		} else {
+			pdf.Cell(30, 6, fmt.Sprintf("VAT (%.1f%%):", invoice.VatRate))
+			pdf.SetX(165)
+			pdf.Cell(30, 6, formatCurrency(invoice.VatAmount))
+		}
 	} else {
-		pdf.Cell(30, 6, fmt.Sprintf("VAT (%.1f%%):", invoice.VatRate))
-		pdf.SetX(165)
-		pdf.Cell(30, 6, formatCurrency(invoice.VatAmount))
+		// The total takes the place of the subtotal
+		y -= 8
 	}
 
 	// Total with emphasis
 	y += 8
 	pdf.SetY(y)
-	pdf.SetFont("Helvetica", "B", 12)
+	pdf.SetFont(fontFamily, "B", 12)
 	if useColors {
 		pdf.SetTextColor(hexToR(primaryColor), hexToG(primaryColor), hexToB(primaryColor))
 	} else {
@@ -498,17 +855,132 @@ func (s *PDFService) GenerateInvoice(invoice *models.Invoice, business *models.B
 	pdf.SetX(165)
 	pdf.Cell(30, 8, formatCurrency(invoice.TotalAmount))
 
+	// Prepaid client credit is deducted from the total
+	if invoice.CreditApplied > 0 {
+		y += 8
+		pdf.SetY(y)
+		pdf.SetFont(fontFamily, "", 10)
+		pdf.SetTextColor(80, 80, 80)
+		pdf.SetX(135)
+		pdf.Cell(30, 6, "Credit applied:")
+		pdf.SetX(165)
+		pdf.Cell(30, 6, "-"+formatCurrency(invoice.CreditApplied))
+
+		y += 7
+		pdf.SetY(y)
+		pdf.SetFont(fontFamily, "B", 12)
+		if useColors {
+			pdf.SetTextColor(hexToR(primaryColor), hexToG(primaryColor), hexToB(primaryColor))
+		} else {
+			pdf.SetTextColor(50, 50, 50)
+		}
+		pdf.SetX(135)
+		pdf.Cell(30, 8, "AMOUNT DUE:")
+		pdf.SetX(165)
+		pdf.Cell(30, 8, formatCurrency(invoice.AmountDue()))
+	}
+
+	// Foreign currency invoices repeat the totals in the home currency, as tax
+	// authorities require the VAT base at the official rate of the issue date
+	if invoice.HasExchangeRate() {
+		formatHome := func(amount models.Money) string {
+			return format.FormatAmount(invoice.ToHomeCurrency(amount)) + " " + invoice.HomeCurrency
+		}
+		var rows [][2]string
+		if !business.VatExempt {
+			rows = append(rows, [2]string{"Subtotal:", formatHome(invoice.TotalAmount - invoice.VatAmount)})
+		}
+		if !invoice.ReverseChargeVat && !business.VatExempt {
+			rows = append(rows, [2]string{"VAT:", formatHome(invoice.VatAmount)})
+		}
+		rows = append(rows, [2]string{"Total:", formatHome(invoice.TotalAmount)})
+
+		y += 10
+		pdf.SetY(y)
+		pdf.SetX(95)
+		pdf.SetFont(fontFamily, "", 8)
+		pdf.SetTextColor(120, 120, 120)
+		pdf.CellFormat(100, 5, fmt.Sprintf("Exchange rate of %s: 1 %s = %s %s",
+			format.FormatDate(invoice.ExchangeRateDate), invoice.Currency,
+			format.FormatNumber(invoice.ExchangeRate, 5), invoice.HomeCurrency), "", 0, "R", false, 0, "")
+		for _, row := range rows {
+			y += 5
+			pdf.SetY(y)
+			pdf.SetX(135)
+			pdf.Cell(30, 5, row[0])
+			pdf.SetX(165)
+			pdf.Cell(30, 5, row[1])
+		}
+	}
+
+	// Pro-forma invoices are not valid for tax purposes, say so below the totals
+	if invoice.IsProforma() {
+		y += 12
+		pdf.SetY(y)
+		pdf.SetX(15)
+		pdf.SetFont(fontFamily, "B", 9)
+		pdf.SetTextColor(80, 80, 80)
+		pdf.MultiCell(180, 5, "This pro forma invoice is not a tax invoice and cannot be used to reclaim VAT. "+
+			"An invoice will be issued once the order is confirmed.", "", "", false)
+		y = pdf.GetY() - 8
+	}
+
+	// Cancellation invoices name the invoice they reverse
+	if invoice.IsStorno() {
+		y += 12
+		pdf.SetY(y)
+		pdf.SetX(15)
+		pdf.SetFont(fontFamily, "B", 9)
+		pdf.SetTextColor(80, 80, 80)
+		pdf.MultiCell(180, 5, "This invoice cancels invoice "+invoice.CancelsInvoiceNumber+" of "+
+			format.FormatDate(invoice.CancelsInvoiceDate)+" in full.", "", "", false)
+		y = pdf.GetY() - 8
+	}
+
+	// Reverse-charge invoices state the legal basis for charging no VAT
+	if invoice.ReverseChargeVat && invoice.ReverseChargeClause != "" {
+		y += 12
+		pdf.SetY(y)
+		pdf.SetX(15)
+		pdf.SetFont(fontFamily, "", 9)
+		pdf.SetTextColor(80, 80, 80)
+		pdf.MultiCell(180, 5, invoice.ReverseChargeClause, "", "", false)
+		y = pdf.GetY() - 8
+	}
+
+	// Businesses exempt from VAT must state why no VAT is charged
+	if business.VatExempt {
+		y += 12
+		pdf.SetY(y)
+		pdf.SetX(15)
+		pdf.SetFont(fontFamily, "", 9)
+		pdf.SetTextColor(80, 80, 80)
+		pdf.MultiCell(180, 5, business.ExemptionClause(), "", "", false)
+		y = pdf.GetY() - 8
+	}
+
+	// The compliance profile of the business may require further legal clauses
+	for _, clause := range invoice.ComplianceClauses {
+		y += 12
+		pdf.SetY(y)
+		pdf.SetX(15)
+		pdf.SetFont(fontFamily, "", 9)
+		pdf.SetTextColor(80, 80, 80)
+		pdf.MultiCell(180, 5, clause, "", "", false)
+		y = pdf.GetY() - 8
+	}
+
 	// Add notes section with subtle styling
 	if invoice.Notes != "" {
 		y += 20
 		pdf.SetY(y)
-		pdf.SetFont("Helvetica", "B", 10)
+		pdf.SetFont(fontFamily, "B", 10)
 		pdf.SetTextColor(80, 80, 80)
 		pdf.Cell(30, 6, "NOTES:")
 
 		y += 6
 		pdf.SetY(y)
-		pdf.SetFont("Helvetica", "", 9)
+		pdf.SetFont(fontFamily, "", 9)
 		pdf.SetTextColor(100, 100, 100)
 		pdf.MultiCell(180, 5, invoice.Notes, "", "", false)
 	}
@@ -517,31 +989,19 @@ func (s *PDFService) GenerateInvoice(invoice *models.Invoice, business *models.B
 	if business.BankName != "" || business.IBAN != "" || business.BIC != "" || business.Currency != "" ||
 		business.SecondBankName != "" || business.SecondIBAN != "" || business.SecondBIC != "" || business.SecondCurrency != "" {
 
-		// Determine which bank accounts to display based on invoice currency
-		displayPrimary := true
-		displaySecondary := false
-
-		// Check if any bank accounts match the invoice currency
-		primaryMatches := business.Currency == invoice.Currency && (business.BankName != "" || business.IBAN != "" || business.BIC != "")
-		secondaryMatches := business.SecondCurrency == invoice.Currency && (business.SecondBankName != "" || business.SecondIBAN != "" || business.SecondBIC != "")
-
-		// If either account matches the currency, only show matching accounts
-		if primaryMatches || secondaryMatches {
-			displayPrimary = primaryMatches
-			displaySecondary = secondaryMatches
-		}
+		displayPrimary, displaySecondary := bankAccountsToShow(business, invoice.Currency)
 
 		// Display primary account if it should be shown
 		if displayPrimary {
 			y = pdf.GetY() + 10
 			pdf.SetY(y)
-			pdf.SetFont("Helvetica", "B", 10)
+			pdf.SetFont(fontFamily, "B", 10)
 			pdf.SetTextColor(80, 80, 80)
 			pdf.Cell(90, 6, "PAYMENT INFORMATION")
 
 			y += 6
 			pdf.SetY(y)
-			pdf.SetFont("Helvetica", "", 9)
+			pdf.SetFont(fontFamily, "", 9)
 			pdf.SetTextColor(100, 100, 100)
 
 			// Only display fields that have values
@@ -593,13 +1053,13 @@ func (s *PDFService) GenerateInvoice(invoice *models.Invoice, business *models.B
 
 			y = pdf.GetY() + 10
 			pdf.SetY(y)
-			pdf.SetFont("Helvetica", "B", 10)
+			pdf.SetFont(fontFamily, "B", 10)
 			pdf.SetTextColor(80, 80, 80)
 			pdf.Cell(90, 6, title)
 
 			y += 6
 			pdf.SetY(y)
-			pdf.SetFont("Helvetica", "", 9)
+			pdf.SetFont(fontFamily, "", 9)
 			pdf.SetTextColor(100, 100, 100)
 
 			// Only display fields that have values
@@ -641,23 +1101,125 @@ func (s *PDFService) GenerateInvoice(invoice *models.Invoice, business *models.B
 		}
 	}
 
-	// Generate PDF file path
-	pdfFileName := fmt.Sprintf("invoice-%s.pdf", invoice.InvoiceNumber)
-	pdfPath := filepath.Join(s.dataDir, "pdfs", pdfFileName)
+	// Invoices payable through PayPal link to the payment
+	if invoice.PayPalLink != "" {
+		y = pdf.GetY() + 10
+		pdf.SetY(y)
+		pdf.SetFont(fontFamily, "B", 10)
+		pdf.SetTextColor(80, 80, 80)
+		pdf.Cell(90, 6, "PAY ONLINE")
 
-	// Ensure the pdfs directory exists
-	pdfsDir := filepath.Join(s.dataDir, "pdfs")
-	if err := os.MkdirAll(pdfsDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create pdfs directory: %w", err)
+		y += 6
+		pdf.SetY(y)
+		pdf.SetFont(fontFamily, "U", 9)
+		pdf.SetTextColor(0, 48, 135)
+		pdf.CellFormat(180, 5, fmt.Sprintf("Pay %s %s with PayPal", invoice.AmountDue(), invoice.Currency), "", 1, "L", false, 0, invoice.PayPalLink)
 	}
 
-	// Save PDF to file
-	err := pdf.OutputFileAndClose(pdfPath)
+	// Businesses that accept USDC print the address with a QR code to scan
+	if business.CryptoAddress != "" {
+		qr, err := cryptoQRCode(business.CryptoAddress)
+		if err != nil {
+			return nil, err
+		}
+		pdf.RegisterImageOptionsReader("crypto-qr", gofpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(qr))
+
+		// The block and its code stay together on one page
+		_, pageHeight := pdf.GetPageSize()
+		_, _, _, bottomMargin := pdf.GetMargins()
+		if pdf.GetY()+45 > pageHeight-bottomMargin {
+			pdf.AddPage()
+		}
+
+		y = pdf.GetY() + 10
+		top := y
+		pdf.SetY(y)
+		pdf.SetFont(fontFamily, "B", 10)
+		pdf.SetTextColor(80, 80, 80)
+		pdf.Cell(90, 6, "PAY IN "+models.CryptoCurrency)
+		pdf.ImageOptions("crypto-qr", 165, top, 30, 30, false, gofpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+
+		y += 6
+		pdf.SetY(y)
+		pdf.SetFont(fontFamily, "", 9)
+		pdf.SetTextColor(100, 100, 100)
+		for _, line := range cryptoPaymentLines(invoice, business) {
+			pdf.Cell(30, 5, line[0]+":")
+			pdf.SetX(45)
+			pdf.Cell(115, 5, line[1])
+			y += 5
+			pdf.SetY(y)
+		}
+		// Continue below the code
+		pdf.SetY(max(y, top+30))
+	}
+
+	// Clients that approve invoices by the hours worked get them on a separate page
+	if invoice.ShowHoursBreakdown && len(invoice.HoursBreakdown) > 0 {
+		addHoursBreakdownPage(pdf, invoice, format, fontFamily, theme.Primary)
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("failed to render PDF: %w", err)
+	}
+	return s.finishInvoicePDF(buf.Bytes(), invoice)
+}
+
+// finishInvoicePDF converts a rendered invoice to PDF/A-3 when that is enabled
+func (s *PDFService) finishInvoicePDF(data []byte, invoice *models.Invoice) ([]byte, error) {
+	if s.settingsService == nil || !s.settingsService.GetBool(SettingPDFA) {
+		return data, nil
+	}
+	data, err := ConvertToPDFA3(data, PDFAMetadata{
+		Title:    pdfDocumentTitle(invoice),
+		Author:   "Simple Invoice",
+		Creator:  "Simple Invoice",
+		Producer: "Simple Invoice",
+		Created:  s.now(),
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to save PDF file: %w", err)
+		return nil, fmt.Errorf("failed to convert PDF to PDF/A-3: %w", err)
 	}
+	return data, nil
+}
 
-	return pdfPath, nil
+// bankAccountsToShow reports which of the business's bank accounts an invoice
+// in currency lists: the accounts in that currency, or else the primary one
+func bankAccountsToShow(business *models.Business, currency string) (primary, secondary bool) {
+	primaryMatches := business.Currency == currency && (business.BankName != "" || business.IBAN != "" || business.BIC != "")
+	secondaryMatches := business.SecondCurrency == currency && (business.SecondBankName != "" || business.SecondIBAN != "" || business.SecondBIC != "")
+	if primaryMatches || secondaryMatches {
+		return primaryMatches, secondaryMatches
+	}
+	return true, false
+}
+
+// cryptoQRCode encodes a crypto payment address as a PNG QR code. It holds
+// the plain address, which the wallets of every network can scan.
+func cryptoQRCode(address string) ([]byte, error) {
+	qr, err := qrcode.Encode(address, qrcode.Medium, 256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode crypto payment address: %w", err)
+	}
+	return qr, nil
+}
+
+// cryptoPaymentLines returns the label and value of each line describing how
+// to pay an invoice in USDC. Invoices in US dollars are paid the amount due
+// in USDC; others the amount due at the rate of the payment date.
+func cryptoPaymentLines(invoice *models.Invoice, business *models.Business) [][2]string {
+	var lines [][2]string
+	if business.CryptoNetwork != "" {
+		lines = append(lines, [2]string{"Network", business.CryptoNetwork})
+	}
+	lines = append(lines, [2]string{"Address", business.CryptoAddress})
+	if invoice.Currency == "USD" {
+		lines = append(lines, [2]string{"Amount", invoice.AmountDue().String() + " " + models.CryptoCurrency})
+	} else {
+		lines = append(lines, [2]string{"Amount", fmt.Sprintf("%s %s in %s at the rate of the payment date", invoice.AmountDue(), invoice.Currency, models.CryptoCurrency)})
+	}
+	return lines
 }
 
 // Helper functions for color conversion
@@ -719,3 +1281,220 @@ func fileExists(filename string) bool {
 	fmt.Printf("File exists and is accessible: %s (size: %d bytes)\n", filename, info.Size())
 	return true
 }
+
+// addHoursBreakdownPage adds a page listing the hours worked per day, which
+// continues on further pages when the list is long
+func addHoursBreakdownPage(pdf *gofpdf.Fpdf, invoice *models.Invoice, format models.InvoiceFormat, fontFamily string, titleColor color.RGBA) {
+	entries := slices.Clone(invoice.HoursBreakdown)
+	slices.SortStableFunc(entries, func(a, b models.TimeEntry) int { return a.Date.Compare(b.Date) })
+
+	pdf.AddPage()
+	pdf.SetFont(fontFamily, "B", 16)
+	pdf.SetTextColor(int(titleColor.R), int(titleColor.G), int(titleColor.B))
+	pdf.CellFormat(0, 10, "HOURS WORKED", "", 1, "L", false, 0, "")
+	pdf.SetFont(fontFamily, "", 10)
+	pdf.SetTextColor(100, 100, 100)
+	subtitle := pdfDocumentTitle(invoice)
+	if invoice.HasServicePeriod() {
+		subtitle += ", " + format.FormatDate(invoice.ServicePeriodStart) + " - " + format.FormatDate(invoice.ServicePeriodEnd)
+	}
+	pdf.CellFormat(0, 6, subtitle, "", 1, "L", false, 0, "")
+	pdf.Ln(6)
+
+	header := func() {
+		pdf.SetFont(fontFamily, "B", 10)
+		pdf.SetFillColor(245, 245, 245)
+		pdf.SetTextColor(80, 80, 80)
+		pdf.CellFormat(30, 8, "  DATE", "", 0, "L", true, 0, "")
+		pdf.CellFormat(120, 8, "DESCRIPTION", "", 0, "L", true, 0, "")
+		pdf.CellFormat(30, 8, "HOURS  ", "", 1, "R", true, 0, "")
+		pdf.SetFont(fontFamily, "", 9)
+		pdf.SetTextColor(70, 70, 70)
+	}
+	header()
+
+	_, pageHeight := pdf.GetPageSize()
+	_, _, _, bottomMargin := pdf.GetMargins()
+	var total float64
+	for i, entry := range entries {
+		lines := pdf.SplitText(entry.Description, 118)
+		if len(lines) == 0 {
+			lines = []string{""}
+		}
+		height := 6 * float64(len(lines))
+		if pdf.GetY()+height > pageHeight-bottomMargin-10 {
+			pdf.AddPage()
+			header()
+		}
+
+		y := pdf.GetY()
+		if i%2 == 1 {
+			pdf.SetFillColor(250, 250, 250)
+			pdf.Rect(15, y, 180, height, "F")
+		}
+		pdf.CellFormat(30, 6, "  "+format.FormatDate(entry.Date), "", 0, "L", false, 0, "")
+		for n, line := range lines {
+			pdf.SetXY(45, y+6*float64(n))
+			pdf.CellFormat(120, 6, line, "", 0, "L", false, 0, "")
+		}
+		pdf.SetXY(165, y)
+		pdf.CellFormat(30, 6, format.FormatNumber(entry.Hours, 2)+"  ", "", 0, "R", false, 0, "")
+		pdf.SetXY(15, y+height)
+		total += entry.Hours
+	}
+
+	pdf.SetDrawColor(230, 230, 230)
+	pdf.Line(15, pdf.GetY()+1, 195, pdf.GetY()+1)
+	pdf.Ln(3)
+	pdf.SetFont(fontFamily, "B", 10)
+	pdf.CellFormat(150, 8, "  Total", "", 0, "L", false, 0, "")
+	pdf.CellFormat(30, 8, format.FormatNumber(total, 2)+"  ", "", 1, "R", false, 0, "")
+}
+
+// clientInvoiceFormat returns how the invoices of a client print dates and
+// numbers: the formats set for the client, or else the usual ones of its country
+func clientInvoiceFormat(client *models.Client) models.InvoiceFormat {
+	format := models.DefaultInvoiceFormat
+	country := clientCountryCode(client)
+	if date := refdata.DateFormat(country); date != "" {
+		format.Date = date
+	}
+	if number := refdata.NumberFormat(country); number != "" {
+		format.Number = number
+	}
+	if client.DateFormat != "" {
+		format.Date = client.DateFormat
+	}
+	if client.NumberFormat != "" {
+		format.Number = client.NumberFormat
+	}
+	return format
+}
+
+// pdfDocumentTitle returns the document title of an invoice PDF
+func pdfDocumentTitle(invoice *models.Invoice) string {
+	if invoice.IsProforma() {
+		return "Pro Forma Invoice " + invoice.InvoiceNumber
+	}
+	if invoice.IsStorno() {
+		return "Cancellation Invoice " + invoice.InvoiceNumber
+	}
+	return "Invoice " + invoice.InvoiceNumber
+}
+
+// GenerateYearClosing renders the closing summary of a fiscal year into the
+// pdfs directory and signs it when a certificate is configured. It lists the
+// invoices issued in the year with their totals per currency and the result
+// of the numbering check.
+func (s *PDFService) GenerateYearClosing(closing *models.YearClosing, invoices []models.Invoice, clientNames map[int]string) (string, error) {
+	pdf := s.newDocument()
+	pdf.SetTitle(fmt.Sprintf("Closing %d", closing.Year), true)
+	fontFamily := "Helvetica"
+
+	pdf.AddPage()
+	pdf.SetFont(fontFamily, "B", 16)
+	pdf.SetTextColor(0, 150, 136)
+	pdf.CellFormat(0, 10, fmt.Sprintf("YEAR-END CLOSING %d", closing.Year), "", 1, "L", false, 0, "")
+	pdf.SetFont(fontFamily, "", 10)
+	pdf.SetTextColor(100, 100, 100)
+	pdf.CellFormat(0, 6, "Closed on "+closing.ClosedAt.Local().Format("02.01.2006 15:04"), "", 1, "L", false, 0, "")
+	pdf.Ln(4)
+
+	// Numbering check
+	pdf.SetTextColor(70, 70, 70)
+	sequence := "No generated invoice numbers."
+	if closing.FirstNumber != "" {
+		sequence = fmt.Sprintf("%d invoices, numbered %s to %s.", closing.Invoices, closing.FirstNumber, closing.LastNumber)
+	}
+	pdf.MultiCell(180, 5, sequence, "", "L", false)
+	if len(closing.MissingNumbers) > 0 {
+		pdf.SetTextColor(200, 0, 0)
+		pdf.MultiCell(180, 5, "Missing numbers: "+strings.Join(closing.MissingNumbers, ", "), "", "L", false)
+		pdf.SetTextColor(70, 70, 70)
+	} else if closing.FirstNumber != "" {
+		pdf.MultiCell(180, 5, "The numbering has no gaps.", "", "L", false)
+	}
+	pdf.Ln(4)
+
+	header := func() {
+		pdf.SetFont(fontFamily, "B", 9)
+		pdf.SetFillColor(245, 245, 245)
+		pdf.SetTextColor(80, 80, 80)
+		pdf.CellFormat(32, 8, "  NUMBER", "", 0, "L", true, 0, "")
+		pdf.CellFormat(20, 8, "DATE", "", 0, "L", true, 0, "")
+		pdf.CellFormat(50, 8, "CLIENT", "", 0, "L", true, 0, "")
+		pdf.CellFormat(26, 8, "NET", "", 0, "R", true, 0, "")
+		pdf.CellFormat(24, 8, "VAT", "", 0, "R", true, 0, "")
+		pdf.CellFormat(28, 8, "TOTAL  ", "", 1, "R", true, 0, "")
+		pdf.SetFont(fontFamily, "", 8)
+		pdf.SetTextColor(70, 70, 70)
+	}
+	header()
+
+	_, pageHeight := pdf.GetPageSize()
+	_, _, _, bottomMargin := pdf.GetMargins()
+	type totals struct{ net, vat, total models.Money }
+	byCurrency := make(map[string]*totals)
+	for i, invoice := range invoices {
+		if pdf.GetY()+6 > pageHeight-bottomMargin-10 {
+			pdf.AddPage()
+			header()
+		}
+		if i%2 == 1 {
+			pdf.SetFillColor(250, 250, 250)
+			pdf.Rect(15, pdf.GetY(), 180, 6, "F")
+		}
+		client := clientNames[invoice.ClientID]
+		if lines := pdf.SplitText(client, 48); len(lines) > 1 {
+			client = lines[0] + "..."
+		}
+		net := invoice.TotalAmount - invoice.VatAmount
+		pdf.CellFormat(32, 6, "  "+invoice.InvoiceNumber, "", 0, "L", false, 0, "")
+		pdf.CellFormat(20, 6, invoice.IssueDate.Format("02.01.2006"), "", 0, "L", false, 0, "")
+		pdf.CellFormat(50, 6, client, "", 0, "L", false, 0, "")
+		pdf.CellFormat(26, 6, net.String(), "", 0, "R", false, 0, "")
+		pdf.CellFormat(24, 6, invoice.VatAmount.String(), "", 0, "R", false, 0, "")
+		pdf.CellFormat(28, 6, invoice.TotalAmount.String()+" "+invoice.Currency+"  ", "", 1, "R", false, 0, "")
+
+		t := byCurrency[invoice.Currency]
+		if t == nil {
+			t = &totals{}
+			byCurrency[invoice.Currency] = t
+		}
+		t.net += net
+		t.vat += invoice.VatAmount
+		t.total += invoice.TotalAmount
+	}
+
+	pdf.SetDrawColor(230, 230, 230)
+	pdf.Line(15, pdf.GetY()+1, 195, pdf.GetY()+1)
+	pdf.Ln(3)
+	pdf.SetFont(fontFamily, "B", 9)
+	currencies := make([]string, 0, len(byCurrency))
+	for currency := range byCurrency {
+		currencies = append(currencies, currency)
+	}
+	slices.Sort(currencies)
+	for _, currency := range currencies {
+		t := byCurrency[currency]
+		pdf.CellFormat(102, 7, "  Total "+currency, "", 0, "L", false, 0, "")
+		pdf.CellFormat(26, 7, t.net.String(), "", 0, "R", false, 0, "")
+		pdf.CellFormat(24, 7, t.vat.String(), "", 0, "R", false, 0, "")
+		pdf.CellFormat(28, 7, t.total.String()+" "+currency+"  ", "", 1, "R", false, 0, "")
+	}
+
+	pdfsDir := filepath.Join(s.dataDir, "pdfs")
+	if err := os.MkdirAll(pdfsDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create pdfs directory: %w", err)
+	}
+	pdfPath := filepath.Join(pdfsDir, yearClosingFilename(closing.Year))
+	if err := pdf.OutputFileAndClose(pdfPath); err != nil {
+		return "", fmt.Errorf("failed to save closing summary: %w", err)
+	}
+	if s.signer != nil && s.signer.Enabled() {
+		if err := s.signer.SignFile(pdfPath); err != nil {
+			return "", fmt.Errorf("failed to sign closing summary: %w", err)
+		}
+	}
+	return pdfPath, nil
+}
@@ -1,14 +1,23 @@
 package services
 
 import (
+	"bytes"
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
 	"os"
 	"path/filepath"
+	"regexp"
 	"testing"
 	"time"
 
 	"github.com/0dragosh/simple-invoice/internal/models"
 )
 
+// updateGolden rewrites the golden files in testdata instead of comparing with them
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
 func setupTestPDFService(t *testing.T) (*PDFService, string, func()) {
 	// Create a temporary directory for the test data
 	tempDir, err := os.MkdirTemp("", "simple-invoice-test")
@@ -84,11 +93,6 @@ func TestRGBToHex(t *testing.T) {
 	}
 }
 
-// Skip the TestGenerateInvoice test for now as it requires more setup
-func TestGenerateInvoiceSkip(t *testing.T) {
-	t.Skip("Skipping TestGenerateInvoice as it requires more setup")
-}
-
 func TestGenerateInvoice(t *testing.T) {
 	// Create a temporary directory for testing
 	tempDir := filepath.Join(os.TempDir(), "simple-invoice-test")
@@ -120,11 +124,11 @@ func TestGenerateInvoice(t *testing.T) {
 		ClientID:      1,
 		IssueDate:     time.Now(),
 		DueDate:       time.Now().AddDate(0, 0, 30),
-		TotalAmount:   120.0,
+		TotalAmount:   12000,
 		Currency:      "EUR",
 		Notes:         "Test invoice",
 		VatRate:       20.0,
-		VatAmount:     20.0,
+		VatAmount:     2000,
 	}
 
 	business := &models.Business{
@@ -157,16 +161,16 @@ func TestGenerateInvoice(t *testing.T) {
 			InvoiceID:   1,
 			Description: "Test Item 1",
 			Quantity:    1,
-			UnitPrice:   50.0,
-			Amount:      50.0,
+			UnitPrice:   5000,
+			Amount:      5000,
 		},
 		{
 			ID:          2,
 			InvoiceID:   1,
 			Description: "Test Item 2",
 			Quantity:    1,
-			UnitPrice:   50.0,
-			Amount:      50.0,
+			UnitPrice:   5000,
+			Amount:      5000,
 		},
 	}
 
@@ -191,3 +195,267 @@ func TestGenerateInvoice(t *testing.T) {
 		t.Error("PDF file is empty")
 	}
 }
+
+func TestHoursBreakdownPage(t *testing.T) {
+	pdfService := NewPDFService(t.TempDir())
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{
+		InvoiceNumber: "INV-2024-0001",
+		IssueDate:     day,
+		DueDate:       day.AddDate(0, 0, 14),
+		TotalAmount:   40000,
+		Currency:      "EUR",
+	}
+	items := []models.InvoiceItem{{Description: "Development", Quantity: 8, Unit: models.UnitHours, UnitPrice: 5000, Amount: 40000}}
+	business := &models.Business{Name: "Test Business"}
+	client := &models.Client{Name: "Test Client"}
+
+	// Enough days to continue on a third page
+	for i := 0; i < 60; i++ {
+		invoice.HoursBreakdown = append(invoice.HoursBreakdown, models.TimeEntry{Date: day.AddDate(0, 0, i), Hours: 8, Description: "Development"})
+	}
+
+	pageCount := func() int {
+		data, err := pdfService.RenderInvoice(invoice, business, client, items)
+		if err != nil {
+			t.Fatalf("Failed to render PDF: %v", err)
+		}
+		return len(regexp.MustCompile(`/Type /Page\b[^s]`).FindAll(data, -1))
+	}
+
+	if pages := pageCount(); pages != 1 {
+		t.Errorf("Expected 1 page without the option, got %d", pages)
+	}
+	invoice.ShowHoursBreakdown = true
+	if pages := pageCount(); pages != 3 {
+		t.Errorf("Expected 3 pages with the hours breakdown, got %d", pages)
+	}
+}
+
+func TestCryptoPaymentQRCode(t *testing.T) {
+	pdfService := NewPDFService(t.TempDir())
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{InvoiceNumber: "INV-2024-0001", IssueDate: day, DueDate: day.AddDate(0, 0, 14), TotalAmount: 40000, Currency: "EUR"}
+	items := []models.InvoiceItem{{Description: "Development", Quantity: 8, Unit: models.UnitHours, UnitPrice: 5000, Amount: 40000}}
+	business := &models.Business{Name: "Test Business", IBAN: "DE89370400440532013000"}
+	client := &models.Client{Name: "Test Client"}
+
+	images := func() int {
+		data, err := pdfService.RenderInvoice(invoice, business, client, items)
+		if err != nil {
+			t.Fatalf("Failed to render PDF: %v", err)
+		}
+		return bytes.Count(data, []byte("/Subtype /Image"))
+	}
+	if n := images(); n != 0 {
+		t.Errorf("Expected no QR code without a USDC address, got %d images", n)
+	}
+	business.CryptoAddress, business.CryptoNetwork = "0x71C7656EC7ab88b098defB751B7401B5f6d8976F", "Ethereum"
+	if n := images(); n != 1 {
+		t.Errorf("Expected the QR code of the USDC address, got %d images", n)
+	}
+
+	// Invoices in US dollars ask for the amount due in USDC
+	lines := cryptoPaymentLines(invoice, business)
+	if len(lines) != 3 || lines[0] != [2]string{"Network", "Ethereum"} || lines[2][1] != "400.00 EUR in USDC at the rate of the payment date" {
+		t.Errorf("Unexpected lines for a EUR invoice: %v", lines)
+	}
+	invoice.Currency = "USD"
+	if lines := cryptoPaymentLines(invoice, business); lines[2][1] != "400.00 USDC" {
+		t.Errorf("Unexpected amount for a USD invoice: %v", lines[2])
+	}
+}
+
+// goldenInvoice returns an invoice with most of what the PDF can show: a logo,
+// discounts, a service period and the hours worked per day
+func goldenInvoice(t *testing.T, dataDir string) (*models.Invoice, *models.Business, *models.Client, []models.InvoiceItem) {
+	t.Helper()
+
+	// A teal logo, which also sets the theme colors
+	logo := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 16; y++ {
+			logo.Set(x, y, color.RGBA{0, 150, 136, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, logo); err != nil {
+		t.Fatalf("Failed to encode logo: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "images", "logo.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write logo: %v", err)
+	}
+
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{
+		ID:                 42,
+		InvoiceNumber:      "INV-2024-0042",
+		IssueDate:          day.AddDate(0, 1, 0),
+		DueDate:            day.AddDate(0, 1, 14),
+		Currency:           "EUR",
+		VatRate:            19,
+		Notes:              "Thank you for your business.",
+		PONumber:           "PO-7781",
+		ServicePeriodStart: day,
+		ServicePeriodEnd:   day.AddDate(0, 1, -1),
+		DiscountPercent:    5,
+		ShowHoursBreakdown: true,
+	}
+	items := []models.InvoiceItem{
+		{Description: "Software development", Quantity: 16, Unit: models.UnitHours, UnitPrice: models.NewMoney(95)},
+		{Description: "Travel to the client's office", Quantity: 420, Unit: models.UnitKilometres, UnitPrice: models.NewMoney(0.30), DiscountPercent: 10},
+	}
+	for i := 0; i < 2; i++ {
+		invoice.HoursBreakdown = append(invoice.HoursBreakdown, models.TimeEntry{Date: day.AddDate(0, 0, i), Hours: 8, Description: "Software development"})
+	}
+
+	business := &models.Business{
+		Name:       "Müller Software GmbH",
+		Address:    "Hauptstraße 10",
+		City:       "Berlin",
+		PostalCode: "10115",
+		Country:    "DE",
+		VatID:      "DE811907980",
+		Email:      "billing@example.com",
+		BankName:   "Example Bank",
+		IBAN:       "DE89370400440532013000",
+		BIC:        "COBADEFFXXX",
+		Currency:   "EUR",
+		LogoPath:   "logo.png",
+	}
+	client := &models.Client{
+		Name:       "Acme Ltd",
+		Address:    "10 Downing Street",
+		City:       "London",
+		PostalCode: "SW1A 2AA",
+		Country:    "GB",
+		VatID:      "GB123456789",
+	}
+	return invoice, business, client, items
+}
+
+func TestInvoicePDFGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		pdfA   bool
+		modify func(invoice *models.Invoice, business *models.Business, client *models.Client)
+	}{
+		{name: "invoice"},
+		{name: "invoice-pdfa", pdfA: true},
+		{
+			name: "invoice-reverse-charge-usd",
+			modify: func(invoice *models.Invoice, business *models.Business, client *models.Client) {
+				invoice.Currency = "USD"
+				invoice.VatRate = 0
+				invoice.ReverseChargeVat = true
+				invoice.ReverseChargeClause = "Reverse charge: VAT to be accounted for by the recipient."
+				invoice.HomeCurrency = "EUR"
+				invoice.ExchangeRate = 0.92336
+				invoice.ExchangeRateDate = invoice.IssueDate
+				invoice.ShowHoursBreakdown = false
+				business.SecondCurrency = "USD"
+				business.SecondBankName = "Example Bank New York"
+				business.SecondIBAN = "DE02100100100006820101"
+				business.SecondBIC = "PBNKDEFFXXX"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pdfService, dataDir, cleanup := setupTestPDFService(t)
+			defer cleanup()
+			pdfService.SetReproducible(time.Date(2024, 4, 2, 10, 30, 0, 0, time.UTC))
+			if tt.pdfA {
+				dbService, _, dbCleanup := setupTestDB(t)
+				defer dbCleanup()
+				settings := NewSettingsService(dbService, NewLogger(ERROR))
+				if err := settings.Set(SettingPDFA, "true"); err != nil {
+					t.Fatalf("Failed to enable PDF/A: %v", err)
+				}
+				pdfService.SetSettingsService(settings)
+			}
+
+			invoice, business, client, items := goldenInvoice(t, dataDir)
+			if tt.modify != nil {
+				tt.modify(invoice, business, client)
+			}
+			invoice.ApplyTotals(items)
+
+			data, err := pdfService.RenderInvoice(invoice, business, client, items)
+			if err != nil {
+				t.Fatalf("Failed to render PDF: %v", err)
+			}
+			again, err := pdfService.RenderInvoice(invoice, business, client, items)
+			if err != nil {
+				t.Fatalf("Failed to render PDF: %v", err)
+			}
+			if !bytes.Equal(data, again) {
+				t.Fatal("Rendering the same invoice twice gave different PDFs")
+			}
+
+			golden := filepath.Join("testdata", "golden", tt.name+".pdf")
+			if *updateGolden {
+				os.MkdirAll(filepath.Dir(golden), 0755)
+				if err := os.WriteFile(golden, data, 0644); err != nil {
+					t.Fatalf("Failed to update %s: %v", golden, err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("Failed to read %s, run the test with -update to create it: %v", golden, err)
+			}
+			if !bytes.Equal(data, want) {
+				wantLines, gotLines := bytes.Split(want, []byte("\n")), bytes.Split(data, []byte("\n"))
+				for i := 0; i < min(len(wantLines), len(gotLines)); i++ {
+					if !bytes.Equal(wantLines[i], gotLines[i]) {
+						t.Fatalf("PDF differs from %s at line %d:\nwant %.200q\n got %.200q\nCheck the change and run the test with -update to accept it", golden, i+1, wantLines[i], gotLines[i])
+					}
+				}
+				t.Fatalf("PDF differs from %s in length: want %d lines, got %d; check the change and run the test with -update to accept it", golden, len(wantLines), len(gotLines))
+			}
+		})
+	}
+}
+
+func TestCleanupPreviews(t *testing.T) {
+	pdfService, tempDir, cleanup := setupTestPDFService(t)
+	defer cleanup()
+
+	previewsDir := filepath.Join(tempDir, PreviewsDir)
+	os.MkdirAll(previewsDir, 0755)
+	os.MkdirAll(filepath.Join(tempDir, "pdfs", "previews"), 0755)
+
+	old := time.Now().Add(-48 * time.Hour)
+	write := func(path string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte("%PDF-1.4"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+	write(filepath.Join(previewsDir, "invoice-preview-1.pdf"), old)
+	write(filepath.Join(previewsDir, "invoice-preview-2.pdf"), time.Now())
+	write(filepath.Join(tempDir, "pdfs", "invoice-preview-3.pdf"), time.Now())
+	write(filepath.Join(tempDir, "pdfs", "invoice-INV-001.pdf"), old)
+
+	removed, err := pdfService.CleanupPreviews(24 * time.Hour)
+	if err != nil {
+		t.Fatalf("CleanupPreviews failed: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("CleanupPreviews removed %d previews, want 2", removed)
+	}
+
+	for path, want := range map[string]bool{
+		filepath.Join(previewsDir, "invoice-preview-1.pdf"):     false,
+		filepath.Join(previewsDir, "invoice-preview-2.pdf"):     true,
+		filepath.Join(tempDir, "pdfs", "invoice-preview-3.pdf"): false,
+		filepath.Join(tempDir, "pdfs", "previews"):              false,
+		filepath.Join(tempDir, "pdfs", "invoice-INV-001.pdf"):   true,
+	} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s exists = %t, want %t", path, err == nil, want)
+		}
+	}
+}
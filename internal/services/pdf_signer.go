@@ -0,0 +1,326 @@
+package services
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// signatureReserve is the space reserved for the CMS signature on top of the
+// certificates it embeds
+const signatureReserve = 4096
+
+var (
+	oidDataContentType        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedDataContentType  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningCertV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidRSAEncryption          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256        = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidSHA256                 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+// PDFSigner adds a PAdES signature to generated PDFs using the PKCS#12
+// certificate configured on the settings page or via SIGNING_CERT_PATH.
+// Settings are read on every call so a new certificate is picked up without
+// a restart.
+type PDFSigner struct {
+	settingsService *SettingsService
+	logger          *Logger
+}
+
+// NewPDFSigner creates a new PDFSigner
+func NewPDFSigner(settingsService *SettingsService, logger *Logger) *PDFSigner {
+	return &PDFSigner{
+		settingsService: settingsService,
+		logger:          logger,
+	}
+}
+
+// Enabled reports whether a signing certificate is configured
+func (s *PDFSigner) Enabled() bool {
+	return s.settingsService.GetString(SettingSigningCertPath) != ""
+}
+
+// LoadCertificate reads the configured PKCS#12 file and returns its key and certificates
+func (s *PDFSigner) LoadCertificate() (crypto.Signer, *x509.Certificate, []*x509.Certificate, error) {
+	path := s.settingsService.GetString(SettingSigningCertPath)
+	if path == "" {
+		return nil, nil, nil, errors.New("no signing certificate configured")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read signing certificate: %w", err)
+	}
+
+	key, cert, chain, err := DecodePKCS12(data, s.settingsService.GetString(SettingSigningCertPassword))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to load signing certificate: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, nil, errors.New("signing certificate key cannot be used for signing")
+	}
+
+	if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		s.logger.Warn("Signing certificate %q is not valid at the current time (valid %s to %s)",
+			cert.Subject.CommonName, cert.NotBefore.Format("2006-01-02"), cert.NotAfter.Format("2006-01-02"))
+	}
+
+	return signer, cert, chain, nil
+}
+
+// SignFile signs the PDF at path in place
+func (s *PDFSigner) SignFile(path string) error {
+	key, cert, chain, err := s.LoadCertificate()
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read PDF: %w", err)
+	}
+
+	signed, err := SignPDF(data, key, cert, chain, s.settingsService.GetString(SettingSigningReason), time.Now())
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, signed, 0644); err != nil {
+		return fmt.Errorf("failed to write signed PDF: %w", err)
+	}
+
+	s.logger.Debug("Signed %s with certificate %q", path, cert.Subject.CommonName)
+	return nil
+}
+
+// SignPDF appends an incremental update to pdf containing an invisible
+// signature field on the first page and a detached CAdES signature
+// (PAdES baseline B) over the whole document.
+func SignPDF(pdf []byte, key crypto.Signer, cert *x509.Certificate, chain []*x509.Certificate, reason string, signingTime time.Time) ([]byte, error) {
+	doc, err := parsePDFStructure(pdf)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reserve room for the signature: hex encoding doubles the DER size
+	reserve := len(cert.Raw) + signatureReserve
+	for _, c := range chain {
+		reserve += len(c.Raw)
+	}
+
+	sigObj := doc.size
+	widgetObj := doc.size + 1
+
+	if strings.Contains(doc.catalog, "/AcroForm") {
+		return nil, errors.New("unsupported PDF: document already has a form")
+	}
+	catalog, err := pdfInsertEntry(doc.catalog, fmt.Sprintf("/AcroForm << /Fields [%d 0 R] /SigFlags 3 >>", widgetObj))
+	if err != nil {
+		return nil, err
+	}
+	page, err := pdfAddAnnotation(doc.page, widgetObj)
+	if err != nil {
+		return nil, err
+	}
+
+	name := cert.Subject.CommonName
+	out, offsets := appendPDFUpdate(pdf, doc, map[int]string{
+		sigObj: fmt.Sprintf("<< /Type /Sig /Filter /Adobe.PPKLite /SubFilter /ETSI.CAdES.detached "+
+			"/ByteRange [0 0000000000 0000000000 0000000000] /Contents <%s> /M %s /Name %s /Reason %s >>",
+			strings.Repeat("0", reserve*2), pdfString(pdfDate(signingTime)), pdfString(name), pdfString(reason)),
+		widgetObj: fmt.Sprintf("<< /Type /Annot /Subtype /Widget /FT /Sig /T (Signature1) /Rect [0 0 0 0] /F 132 /V %d 0 R /P %d 0 R >>",
+			sigObj, doc.pageObj),
+		doc.rootObj: catalog,
+		doc.pageObj: page,
+	})
+
+	// Fill in the byte range, which covers everything except the /Contents value
+	sigStart := offsets[sigObj]
+	contentsStart := sigStart + bytes.Index(out[sigStart:], []byte("/Contents <")) + len("/Contents ")
+	contentsEnd := contentsStart + reserve*2 + 2
+	byteRange := fmt.Sprintf("[0 %d %d %d]", contentsStart, contentsEnd, len(out)-contentsEnd)
+
+	rangeStart := sigStart + bytes.Index(out[sigStart:], []byte("/ByteRange [")) + len("/ByteRange ")
+	placeholder := len("[0 0000000000 0000000000 0000000000]")
+	if len(byteRange) > placeholder {
+		return nil, errors.New("PDF is too large to sign")
+	}
+	copy(out[rangeStart:], byteRange+strings.Repeat(" ", placeholder-len(byteRange)))
+
+	digest := sha256.New()
+	digest.Write(out[:contentsStart])
+	digest.Write(out[contentsEnd:])
+
+	signature, err := createCMSSignature(digest.Sum(nil), key, cert, chain)
+	if err != nil {
+		return nil, err
+	}
+	if len(signature) > reserve {
+		return nil, fmt.Errorf("signature of %d bytes exceeds the reserved %d bytes", len(signature), reserve)
+	}
+	copy(out[contentsStart+1:], hex.EncodeToString(signature))
+
+	return out, nil
+}
+
+// CMS (RFC 5652) structures for a detached SignedData signature
+
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo cmsEncapsulatedContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+type cmsEncapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+}
+
+type cmsSignerInfo struct {
+	Version            int
+	SID                cmsIssuerAndSerialNumber
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type cmsIssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// essCertIDv2 identifies the signing certificate (RFC 5035); the hash
+// algorithm defaults to SHA-256 and is therefore omitted
+type essCertIDv2 struct {
+	CertHash []byte
+}
+
+type signingCertificateV2 struct {
+	Certs []essCertIDv2
+}
+
+// createCMSSignature builds a detached CMS SignedData over a document digest
+// with the signed attributes required by PAdES: content type, message digest
+// and signing certificate
+func createCMSSignature(digest []byte, key crypto.Signer, cert *x509.Certificate, chain []*x509.Certificate) ([]byte, error) {
+	var signatureAlgorithm pkix.AlgorithmIdentifier
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		signatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		signatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", key.Public())
+	}
+	digestAlgorithm := pkix.AlgorithmIdentifier{Algorithm: oidSHA256}
+
+	certHash := sha256.Sum256(cert.Raw)
+	signingCert, err := asn1.Marshal(signingCertificateV2{Certs: []essCertIDv2{{CertHash: certHash[:]}}})
+	if err != nil {
+		return nil, err
+	}
+	contentType, err := asn1.Marshal(oidDataContentType)
+	if err != nil {
+		return nil, err
+	}
+	messageDigest, err := asn1.Marshal(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Signed attributes are a DER SET OF, so they must be sorted by their encoding
+	var attributes [][]byte
+	for _, attr := range []struct {
+		oid   asn1.ObjectIdentifier
+		value []byte
+	}{
+		{oidAttributeContentType, contentType},
+		{oidAttributeMessageDigest, messageDigest},
+		{oidAttributeSigningCertV2, signingCert},
+	} {
+		encoded, err := asn1.Marshal(cmsAttribute{
+			Type:   attr.oid,
+			Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attr.value},
+		})
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, encoded)
+	}
+	sort.Slice(attributes, func(i, j int) bool { return bytes.Compare(attributes[i], attributes[j]) < 0 })
+	attributeBytes := bytes.Join(attributes, nil)
+
+	// The signature covers the attributes encoded as a SET, not with the implicit [0] tag used in SignerInfo
+	signedAttrs, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attributeBytes})
+	if err != nil {
+		return nil, err
+	}
+	attrsDigest := sha256.Sum256(signedAttrs)
+	signature, err := key.Sign(rand.Reader, attrsDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	certificates := append([]byte{}, cert.Raw...)
+	for _, c := range chain {
+		certificates = append(certificates, c.Raw...)
+	}
+
+	signedData := cmsSignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlgorithm},
+		EncapContentInfo: cmsEncapsulatedContentInfo{EContentType: oidDataContentType},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certificates},
+		SignerInfos: []cmsSignerInfo{{
+			Version: 1,
+			SID: cmsIssuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm:    digestAlgorithm,
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attributeBytes},
+			SignatureAlgorithm: signatureAlgorithm,
+			Signature:          signature,
+		}},
+	}
+
+	content, err := asn1.Marshal(signedData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signature: %w", err)
+	}
+	return asn1.Marshal(cmsContentInfo{
+		ContentType: oidSignedDataContentType,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content},
+	})
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// The fixtures in testdata were created with OpenSSL 3 and use the password "secret":
+// signing.p12 (RSA, PBES2/AES-256), signing-legacy.p12 (RSA, 3DES) and signing-ec.p12 (ECDSA P-256)
+
+func TestDecodePKCS12(t *testing.T) {
+	for _, name := range []string{"signing.p12", "signing-legacy.p12", "signing-ec.p12"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatalf("Failed to read fixture: %v", err)
+			}
+
+			key, cert, _, err := DecodePKCS12(data, "secret")
+			if err != nil {
+				t.Fatalf("DecodePKCS12 failed: %v", err)
+			}
+			if !publicKeysEqual(cert.PublicKey, key.(crypto.Signer).Public()) {
+				t.Error("Certificate does not match the private key")
+			}
+
+			if _, _, _, err := DecodePKCS12(data, "wrong"); !errors.Is(err, ErrPKCS12Password) {
+				t.Errorf("Expected ErrPKCS12Password for a wrong password, got %v", err)
+			}
+		})
+	}
+}
+
+func TestGenerateInvoiceSigned(t *testing.T) {
+	dbService, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	settingsService := NewSettingsService(dbService, NewLogger(ERROR))
+	certPath, _ := filepath.Abs(filepath.Join("testdata", "signing.p12"))
+	if err := settingsService.SetMany(map[string]string{
+		SettingSigningCertPath:     certPath,
+		SettingSigningCertPassword: "secret",
+	}); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+
+	pdfService := NewPDFService(tempDir)
+	pdfService.SetSigner(NewPDFSigner(settingsService, NewLogger(ERROR)))
+
+	invoice := &models.Invoice{
+		InvoiceNumber: "INV-SIGNED",
+		IssueDate:     time.Now(),
+		DueDate:       time.Now().AddDate(0, 0, 30),
+		TotalAmount:   11900,
+		Currency:      "EUR",
+		VatRate:       19,
+		VatAmount:     1900,
+	}
+	business := &models.Business{Name: "Test Business", Country: "Germany", Email: "test@business.com"}
+	client := &models.Client{Name: "Test Client", Country: "France"}
+	items := []models.InvoiceItem{{Description: "Consulting", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+
+	pdfPath, err := pdfService.GenerateInvoice(invoice, business, client, items)
+	if err != nil {
+		t.Fatalf("Failed to generate signed PDF: %v", err)
+	}
+	pdf, err := os.ReadFile(pdfPath)
+	if err != nil {
+		t.Fatalf("Failed to read PDF: %v", err)
+	}
+
+	verifyPDFSignature(t, pdf)
+
+	// Changing a single byte of the signed content must be detected
+	tampered := append([]byte{}, pdf...)
+	tampered[len("%PDF-1.3\n")+1] ^= 1
+	if digestMatches(t, tampered) {
+		t.Error("Expected the digest check to fail for a modified PDF")
+	}
+}
+
+func TestPDFAddAnnotation(t *testing.T) {
+	page := "<</Type /Page\n/Annots [<</Type /Annot /Subtype /Link /A <</S /URI /URI (http://example.com/[a\\)])>>>>]\n>>"
+	got, err := pdfAddAnnotation(page, 12)
+	if err != nil {
+		t.Fatalf("pdfAddAnnotation failed: %v", err)
+	}
+	want := "<</Type /Page\n/Annots [<</Type /Annot /Subtype /Link /A <</S /URI /URI (http://example.com/[a\\)])>>>> 12 0 R]\n>>"
+	if got != want {
+		t.Errorf("Unexpected page dictionary:\n%s", got)
+	}
+
+	got, err = pdfAddAnnotation("<</Type /Page /Parent 1 0 R>>", 5)
+	if err != nil {
+		t.Fatalf("pdfAddAnnotation failed: %v", err)
+	}
+	if got != "<</Type /Page /Parent 1 0 R\n/Annots [5 0 R]\n>>" {
+		t.Errorf("Unexpected page dictionary:\n%s", got)
+	}
+}
+
+var byteRangePattern = regexp.MustCompile(`/ByteRange \[0 (\d+) (\d+) (\d+)\s*\]`)
+
+// signedRanges returns the signed bytes and the DER signature of a signed PDF
+func signedRanges(t *testing.T, pdf []byte) ([]byte, []byte) {
+	t.Helper()
+
+	match := byteRangePattern.FindSubmatch(pdf)
+	if match == nil {
+		t.Fatal("Signed PDF has no /ByteRange")
+	}
+	start, _ := strconv.Atoi(string(match[1]))
+	end, _ := strconv.Atoi(string(match[2]))
+	length, _ := strconv.Atoi(string(match[3]))
+	if end+length != len(pdf) {
+		t.Fatalf("Byte range does not cover the whole file: %s", match[0])
+	}
+
+	// The signature is zero-padded to the reserved size; the ASN.1 parser ignores the padding
+	signature, err := hex.DecodeString(string(pdf[start+1 : end-1]))
+	if err != nil {
+		t.Fatalf("Invalid /Contents: %v", err)
+	}
+
+	signed := append(append([]byte{}, pdf[:start]...), pdf[end:]...)
+	return signed, signature
+}
+
+type testSignerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    asn1.RawValue
+	SignedAttrs        asn1.RawValue `asn1:"tag:0"`
+	SignatureAlgorithm asn1.RawValue
+	Signature          []byte
+}
+
+type testSignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo asn1.RawValue
+	Certificates     asn1.RawValue    `asn1:"tag:0"`
+	SignerInfos      []testSignerInfo `asn1:"set"`
+}
+
+// parseTestSignature decodes the CMS signature and returns the signer info and certificate
+func parseTestSignature(t *testing.T, der []byte) (testSignerInfo, *x509.Certificate) {
+	t.Helper()
+
+	var ci struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"explicit,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		t.Fatalf("Invalid ContentInfo: %v", err)
+	}
+	if !ci.ContentType.Equal(oidSignedDataContentType) {
+		t.Fatalf("Expected SignedData, got %v", ci.ContentType)
+	}
+
+	var sd testSignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatalf("Invalid SignedData: %v", err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		t.Fatalf("Expected 1 signer, got %d", len(sd.SignerInfos))
+	}
+
+	cert, err := x509.ParseCertificate(sd.Certificates.Bytes)
+	if err != nil {
+		t.Fatalf("Invalid embedded certificate: %v", err)
+	}
+	return sd.SignerInfos[0], cert
+}
+
+// messageDigest returns the messageDigest signed attribute
+func messageDigest(t *testing.T, si testSignerInfo) []byte {
+	t.Helper()
+
+	rest := si.SignedAttrs.Bytes
+	for len(rest) > 0 {
+		var attr struct {
+			Type   asn1.ObjectIdentifier
+			Values asn1.RawValue `asn1:"set"`
+		}
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			t.Fatalf("Invalid signed attribute: %v", err)
+		}
+		if attr.Type.Equal(oidAttributeMessageDigest) {
+			var digest []byte
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &digest); err != nil {
+				t.Fatalf("Invalid message digest: %v", err)
+			}
+			return digest
+		}
+	}
+	t.Fatal("Signature has no messageDigest attribute")
+	return nil
+}
+
+func digestMatches(t *testing.T, pdf []byte) bool {
+	signed, signature := signedRanges(t, pdf)
+	si, _ := parseTestSignature(t, signature)
+	digest := sha256.Sum256(signed)
+	return bytes.Equal(digest[:], messageDigest(t, si))
+}
+
+// verifyPDFSignature checks the document digest and the signature over the signed attributes
+func verifyPDFSignature(t *testing.T, pdf []byte) {
+	t.Helper()
+
+	if !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Error("Signed PDF does not end with an EOF marker")
+	}
+	for _, want := range []string{"/SubFilter /ETSI.CAdES.detached", "/AcroForm << /Fields [", "/SigFlags 3"} {
+		if !bytes.Contains(pdf, []byte(want)) {
+			t.Errorf("Signed PDF does not contain %q", want)
+		}
+	}
+
+	if !digestMatches(t, pdf) {
+		t.Fatal("Message digest does not match the signed byte ranges")
+	}
+
+	_, signature := signedRanges(t, pdf)
+	si, cert := parseTestSignature(t, signature)
+
+	// The signature is computed over the attributes re-tagged as a SET
+	attrs := append([]byte{}, si.SignedAttrs.FullBytes...)
+	attrs[0] = 0x31
+	if err := cert.CheckSignature(x509.SHA256WithRSA, attrs, si.Signature); err != nil {
+		t.Errorf("Signature verification failed: %v", err)
+	}
+}
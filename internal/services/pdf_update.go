@@ -0,0 +1,345 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file contains the minimal PDF parsing and writing needed to post-process
+// the files gofpdf generates: they use a classic cross-reference table, so
+// objects can be located by offset and changed by appending an incremental update.
+
+// pdfStructure holds the parts of an existing PDF needed to update it
+type pdfStructure struct {
+	size       int         // Trailer /Size, the first free object number
+	rootObj    int         // Catalog object number
+	infoObj    int         // Document information object number, 0 if none
+	id         string      // Trailer /ID array, if any
+	xrefOffset int         // Offset of the last cross-reference section
+	offsets    map[int]int // Offset of each object in its latest revision
+	catalog    string      // Catalog dictionary
+	pageObj    int         // First page object number
+	page       string      // First page dictionary
+}
+
+var (
+	pdfStartXrefPattern = regexp.MustCompile(`startxref\s+(\d+)\s+%%EOF\s*$`)
+	pdfSizePattern      = regexp.MustCompile(`/Size\s+(\d+)`)
+	pdfRootPattern      = regexp.MustCompile(`/Root\s+(\d+)\s+0\s+R`)
+	pdfInfoPattern      = regexp.MustCompile(`/Info\s+(\d+)\s+0\s+R`)
+	pdfIDPattern        = regexp.MustCompile(`/ID\s*(\[\s*<[0-9A-Fa-f]*>\s*<[0-9A-Fa-f]*>\s*\])`)
+	pdfPrevPattern      = regexp.MustCompile(`/Prev\s+(\d+)`)
+	pdfPagesPattern     = regexp.MustCompile(`/Pages\s+(\d+)\s+0\s+R`)
+	pdfKidsPattern      = regexp.MustCompile(`/Kids\s*\[\s*(\d+)\s+0\s+R`)
+)
+
+// parsePDFStructure reads the trailer, cross-reference sections, catalog and
+// first page of a PDF with classic (non-stream) cross-reference tables
+func parsePDFStructure(pdf []byte) (*pdfStructure, error) {
+	match := pdfStartXrefPattern.FindSubmatch(pdf)
+	if match == nil {
+		return nil, errors.New("invalid PDF: startxref not found")
+	}
+	xrefOffset, _ := strconv.Atoi(string(match[1]))
+
+	doc := &pdfStructure{xrefOffset: xrefOffset, offsets: make(map[int]int)}
+
+	// Follow the /Prev chain from the newest section; newer entries take precedence
+	for offset, first := xrefOffset, true; ; first = false {
+		table, trailer, err := pdfXrefSection(pdf, offset)
+		if err != nil {
+			return nil, err
+		}
+		sectionOffsets, err := parsePDFXref(table)
+		if err != nil {
+			return nil, err
+		}
+		for n, o := range sectionOffsets {
+			if _, ok := doc.offsets[n]; !ok {
+				doc.offsets[n] = o
+			}
+		}
+
+		if first {
+			sizeMatch := pdfSizePattern.FindStringSubmatch(trailer)
+			rootMatch := pdfRootPattern.FindStringSubmatch(trailer)
+			if sizeMatch == nil || rootMatch == nil {
+				return nil, errors.New("invalid PDF: trailer is missing /Size or /Root")
+			}
+			doc.size, _ = strconv.Atoi(sizeMatch[1])
+			doc.rootObj, _ = strconv.Atoi(rootMatch[1])
+			if infoMatch := pdfInfoPattern.FindStringSubmatch(trailer); infoMatch != nil {
+				doc.infoObj, _ = strconv.Atoi(infoMatch[1])
+			}
+			if idMatch := pdfIDPattern.FindStringSubmatch(trailer); idMatch != nil {
+				doc.id = idMatch[1]
+			}
+		}
+
+		prevMatch := pdfPrevPattern.FindStringSubmatch(trailer)
+		if prevMatch == nil {
+			break
+		}
+		prev, _ := strconv.Atoi(prevMatch[1])
+		if prev >= offset {
+			return nil, errors.New("invalid PDF: cross-reference sections do not point backwards")
+		}
+		offset = prev
+	}
+
+	var err error
+	if doc.catalog, err = pdfObject(pdf, doc.offsets, doc.rootObj); err != nil {
+		return nil, err
+	}
+
+	pagesMatch := pdfPagesPattern.FindStringSubmatch(doc.catalog)
+	if pagesMatch == nil {
+		return nil, errors.New("invalid PDF: catalog has no /Pages")
+	}
+	pagesObj, _ := strconv.Atoi(pagesMatch[1])
+	pages, err := pdfObject(pdf, doc.offsets, pagesObj)
+	if err != nil {
+		return nil, err
+	}
+
+	kidsMatch := pdfKidsPattern.FindStringSubmatch(pages)
+	if kidsMatch == nil {
+		return nil, errors.New("invalid PDF: page tree has no pages")
+	}
+	doc.pageObj, _ = strconv.Atoi(kidsMatch[1])
+	if doc.page, err = pdfObject(pdf, doc.offsets, doc.pageObj); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// pdfXrefSection splits the cross-reference section at offset into its table and trailer dictionary
+func pdfXrefSection(pdf []byte, offset int) (string, string, error) {
+	if offset >= len(pdf) || !bytes.HasPrefix(pdf[offset:], []byte("xref")) {
+		return "", "", errors.New("unsupported PDF: cross-reference streams are not supported")
+	}
+
+	section := pdf[offset:]
+	trailerStart := bytes.Index(section, []byte("trailer"))
+	if trailerStart < 0 {
+		return "", "", errors.New("invalid PDF: trailer not found")
+	}
+	trailerEnd := bytes.Index(section[trailerStart:], []byte("startxref"))
+	if trailerEnd < 0 {
+		return "", "", errors.New("invalid PDF: startxref not found")
+	}
+
+	return string(section[:trailerStart]), string(section[trailerStart : trailerStart+trailerEnd]), nil
+}
+
+// parsePDFXref returns the byte offset of each in-use object in a cross-reference table
+func parsePDFXref(table string) (map[int]int, error) {
+	lines := strings.Fields(strings.TrimPrefix(strings.TrimSpace(table), "xref"))
+	offsets := make(map[int]int)
+
+	for i := 0; i < len(lines); {
+		if i+1 >= len(lines) {
+			return nil, errors.New("invalid PDF: malformed cross-reference table")
+		}
+		first, err1 := strconv.Atoi(lines[i])
+		count, err2 := strconv.Atoi(lines[i+1])
+		if err1 != nil || err2 != nil || i+2+count*3 > len(lines) {
+			return nil, errors.New("invalid PDF: malformed cross-reference table")
+		}
+		i += 2
+		for n := 0; n < count; n++ {
+			if lines[i+2] == "n" {
+				offset, _ := strconv.Atoi(lines[i])
+				offsets[first+n] = offset
+			}
+			i += 3
+		}
+	}
+
+	return offsets, nil
+}
+
+// pdfObject returns the dictionary of an object that has no stream
+func pdfObject(pdf []byte, offsets map[int]int, number int) (string, error) {
+	offset, ok := offsets[number]
+	if !ok || offset >= len(pdf) {
+		return "", fmt.Errorf("invalid PDF: object %d not found", number)
+	}
+
+	body := pdf[offset:]
+	header := fmt.Sprintf("%d 0 obj", number)
+	if !bytes.HasPrefix(body, []byte(header)) {
+		return "", fmt.Errorf("invalid PDF: object %d not at its cross-reference offset", number)
+	}
+	end := bytes.Index(body, []byte("endobj"))
+	if end < 0 {
+		return "", fmt.Errorf("invalid PDF: object %d is not terminated", number)
+	}
+
+	dict := strings.TrimSpace(string(body[len(header):end]))
+	if !strings.HasPrefix(dict, "<<") || !strings.HasSuffix(dict, ">>") {
+		return "", fmt.Errorf("unsupported PDF: object %d is not a dictionary", number)
+	}
+	return dict, nil
+}
+
+// appendPDFUpdate appends an incremental update with the given new and changed
+// objects to pdf. It returns the updated file and the offset of each written object.
+func appendPDFUpdate(pdf []byte, doc *pdfStructure, objects map[int]string) ([]byte, map[int]int) {
+	var buf bytes.Buffer
+	buf.Write(pdf)
+	if len(pdf) > 0 && pdf[len(pdf)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+
+	numbers := make([]int, 0, len(objects))
+	size := doc.size
+	for n := range objects {
+		numbers = append(numbers, n)
+		if n >= size {
+			size = n + 1
+		}
+	}
+	sort.Ints(numbers)
+
+	offsets := make(map[int]int, len(objects))
+	for _, n := range numbers {
+		offsets[n] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", n, objects[n])
+	}
+
+	xrefOffset := buf.Len()
+	buf.WriteString("xref\n0 1\n0000000000 65535 f \n")
+	for _, n := range numbers {
+		fmt.Fprintf(&buf, "%d 1\n%010d 00000 n \n", n, offsets[n])
+	}
+
+	trailer := fmt.Sprintf("/Size %d /Root %d 0 R /Prev %d", size, doc.rootObj, doc.xrefOffset)
+	if doc.infoObj != 0 {
+		trailer += fmt.Sprintf(" /Info %d 0 R", doc.infoObj)
+	}
+	if doc.id != "" {
+		trailer += " /ID " + doc.id
+	}
+	fmt.Fprintf(&buf, "trailer\n<< %s >>\nstartxref\n%d\n%%%%EOF\n", trailer, xrefOffset)
+
+	return buf.Bytes(), offsets
+}
+
+// pdfInsertEntry adds entries at the end of a dictionary
+func pdfInsertEntry(dict, entry string) (string, error) {
+	end := strings.LastIndex(dict, ">>")
+	if end < 0 {
+		return "", errors.New("invalid PDF: malformed dictionary")
+	}
+	return dict[:end] + "\n" + entry + "\n" + dict[end:], nil
+}
+
+// pdfAddAnnotation adds an annotation reference to a page dictionary, extending an existing /Annots array
+func pdfAddAnnotation(page string, annot int) (string, error) {
+	ref := fmt.Sprintf("%d 0 R", annot)
+
+	idx := strings.Index(page, "/Annots")
+	if idx < 0 {
+		return pdfInsertEntry(page, "/Annots ["+ref+"]")
+	}
+
+	open := idx + len("/Annots")
+	for open < len(page) && isPDFWhitespace(page[open]) {
+		open++
+	}
+	if open >= len(page) || page[open] != '[' {
+		return "", errors.New("unsupported PDF: page annotations are an indirect reference")
+	}
+
+	end := pdfMatchingBracket(page, open)
+	if end < 0 {
+		return "", errors.New("invalid PDF: unterminated /Annots array")
+	}
+	return page[:end] + " " + ref + page[end:], nil
+}
+
+// pdfMatchingBracket returns the index of the ] closing the array opened at
+// open, skipping over literal strings that may contain brackets
+func pdfMatchingBracket(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		case '(':
+			// Literal strings nest balanced parentheses and escape with a backslash
+			nesting := 0
+			for ; i < len(s); i++ {
+				switch s[i] {
+				case '\\':
+					i++
+				case '(':
+					nesting++
+				case ')':
+					nesting--
+				}
+				if nesting == 0 {
+					break
+				}
+			}
+		}
+	}
+	return -1
+}
+
+func isPDFWhitespace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+// pdfString encodes s as a PDF text string, using UTF-16 for non-ASCII text
+func pdfString(s string) string {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+
+	if !ascii {
+		var b strings.Builder
+		b.WriteString("<FEFF")
+		for _, r := range s {
+			if r > 0xFFFF {
+				r -= 0x10000
+				fmt.Fprintf(&b, "%04X%04X", 0xD800+(r>>10), 0xDC00+(r&0x3FF))
+				continue
+			}
+			fmt.Fprintf(&b, "%04X", r)
+		}
+		b.WriteString(">")
+		return b.String()
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`, "\r", `\r`, "\n", `\n`)
+	return "(" + replacer.Replace(s) + ")"
+}
+
+// pdfDate formats t as a PDF date string
+func pdfDate(t time.Time) string {
+	_, offset := t.Zone()
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("D:%s%s%02d'%02d'", t.Format("20060102150405"), sign, offset/3600, (offset%3600)/60)
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	_ "embed"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/jung-kurt/gofpdf/v2"
+)
+
+// PDF/A-3b requires every font to be embedded, so PDF/A output uses DejaVu Sans
+// (see fonts/LICENSE) instead of the standard Helvetica font
+var (
+	//go:embed fonts/DejaVuSansCondensed.ttf
+	pdfAFontRegular []byte
+
+	//go:embed fonts/DejaVuSansCondensed-Bold.ttf
+	pdfAFontBold []byte
+)
+
+// pdfAFontFamily is the font family registered by addPDFAFonts
+const pdfAFontFamily = "DejaVu"
+
+// pdfABinaryComment follows the header so file transfer tools treat the file as binary
+const pdfABinaryComment = "%\xe2\xe3\xcf\xd3\n"
+
+// PDFAMetadata is the document information written to the XMP metadata and
+// the information dictionary, which PDF/A requires to match
+type PDFAMetadata struct {
+	Title    string
+	Author   string
+	Creator  string
+	Producer string
+	Created  time.Time
+}
+
+// addPDFAFonts registers the embedded fonts used for PDF/A output
+func addPDFAFonts(pdf *gofpdf.Fpdf) {
+	pdf.AddUTF8FontFromBytes(pdfAFontFamily, "", pdfAFontRegular)
+	pdf.AddUTF8FontFromBytes(pdfAFontFamily, "B", pdfAFontBold)
+}
+
+// ConvertToPDFA3 turns a PDF generated by gofpdf with embedded fonts into a
+// PDF/A-3b file: it adds the binary header comment, an sRGB output intent,
+// XMP metadata with the PDF/A identification, a matching information
+// dictionary and a document ID
+func ConvertToPDFA3(pdf []byte, meta PDFAMetadata) ([]byte, error) {
+	pdf, err := insertPDFBinaryComment(pdf)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := parsePDFStructure(pdf)
+	if err != nil {
+		return nil, err
+	}
+
+	iccObj := doc.size
+	intentObj := doc.size + 1
+	metadataObj := doc.size + 2
+	infoObj := doc.infoObj
+	if infoObj == 0 {
+		infoObj = doc.size + 3
+		doc.infoObj = infoObj
+	}
+
+	var icc bytes.Buffer
+	zw := zlib.NewWriter(&icc)
+	zw.Write(srgbICCProfile())
+	zw.Close()
+
+	xmp, err := pdfAXMP(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog, err := pdfInsertEntry(doc.catalog, fmt.Sprintf("/Metadata %d 0 R\n/OutputIntents [%d 0 R]", metadataObj, intentObj))
+	if err != nil {
+		return nil, err
+	}
+
+	created := pdfString(pdfDate(meta.Created))
+	info := fmt.Sprintf("<< /Title %s /Author %s /Creator %s /Producer %s /CreationDate %s /ModDate %s >>",
+		pdfString(meta.Title), pdfString(meta.Author), pdfString(meta.Creator), pdfString(meta.Producer), created, created)
+
+	if doc.id == "" {
+		sum := md5.Sum(pdf)
+		id := hex.EncodeToString(sum[:])
+		doc.id = fmt.Sprintf("[<%s> <%s>]", id, id)
+	}
+
+	out, _ := appendPDFUpdate(pdf, doc, map[int]string{
+		iccObj: fmt.Sprintf("<< /N 3 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream", icc.Len(), icc.Bytes()),
+		intentObj: fmt.Sprintf("<< /Type /OutputIntent /S /GTS_PDFA1 /OutputConditionIdentifier (sRGB IEC61966-2.1) "+
+			"/Info (sRGB IEC61966-2.1) /DestOutputProfile %d 0 R >>", iccObj),
+		metadataObj: fmt.Sprintf("<< /Type /Metadata /Subtype /XML /Length %d >>\nstream\n%s\nendstream", len(xmp), xmp),
+		infoObj:     info,
+		doc.rootObj: catalog,
+	})
+	return out, nil
+}
+
+var (
+	pdfHeaderPattern    = regexp.MustCompile(`^%PDF-\d\.\d\r?\n`)
+	pdfXrefEntryPattern = regexp.MustCompile(`(?m)^(\d{10}) (\d{5}) n`)
+)
+
+// insertPDFBinaryComment adds a comment with bytes above 127 after the header.
+// This shifts every object, so the offsets in the (single) cross-reference
+// table and startxref are rewritten.
+func insertPDFBinaryComment(pdf []byte) ([]byte, error) {
+	header := pdfHeaderPattern.Find(pdf)
+	if header == nil {
+		return nil, errors.New("invalid PDF: missing header")
+	}
+	if bytes.HasPrefix(pdf[len(header):], []byte(pdfABinaryComment)) {
+		return pdf, nil
+	}
+
+	match := pdfStartXrefPattern.FindSubmatchIndex(pdf)
+	if match == nil {
+		return nil, errors.New("invalid PDF: startxref not found")
+	}
+	xrefOffset, _ := strconv.Atoi(string(pdf[match[2]:match[3]]))
+	table, trailer, err := pdfXrefSection(pdf, xrefOffset)
+	if err != nil {
+		return nil, err
+	}
+	if pdfPrevPattern.MatchString(trailer) {
+		return nil, errors.New("unsupported PDF: file already has incremental updates")
+	}
+
+	shift := len(pdfABinaryComment)
+	shifted := pdfXrefEntryPattern.ReplaceAllStringFunc(table, func(entry string) string {
+		offset, _ := strconv.Atoi(entry[:10])
+		return fmt.Sprintf("%010d", offset+shift) + entry[10:]
+	})
+
+	var out bytes.Buffer
+	out.Write(header)
+	out.WriteString(pdfABinaryComment)
+	out.Write(pdf[len(header):xrefOffset])
+	out.WriteString(shifted)
+	out.Write(pdf[xrefOffset+len(table) : match[2]])
+	out.WriteString(strconv.Itoa(xrefOffset + shift))
+	out.Write(pdf[match[3]:])
+	return out.Bytes(), nil
+}
+
+// pdfAXMP returns the XMP metadata packet identifying the file as PDF/A-3b
+func pdfAXMP(meta PDFAMetadata) ([]byte, error) {
+	escape := func(s string) (string, error) {
+		var buf bytes.Buffer
+		if err := xml.EscapeText(&buf, []byte(s)); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+
+	var values []any
+	for _, s := range []string{meta.Title, meta.Author, meta.Creator, meta.Producer} {
+		escaped, err := escape(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata: %w", err)
+		}
+		values = append(values, escaped)
+	}
+	created := meta.Created.Format("2006-01-02T15:04:05-07:00")
+	values = append(values, created, created)
+
+	return []byte(fmt.Sprintf(`<?xpacket begin="`+"\ufeff"+`" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+  <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+    <rdf:Description rdf:about=""
+        xmlns:pdfaid="http://www.aiim.org/pdfa/ns/id/"
+        xmlns:dc="http://purl.org/dc/elements/1.1/"
+        xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+        xmlns:pdf="http://ns.adobe.com/pdf/1.3/">
+      <pdfaid:part>3</pdfaid:part>
+      <pdfaid:conformance>B</pdfaid:conformance>
+      <dc:format>application/pdf</dc:format>
+      <dc:title><rdf:Alt><rdf:li xml:lang="x-default">%s</rdf:li></rdf:Alt></dc:title>
+      <dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>
+      <xmp:CreatorTool>%s</xmp:CreatorTool>
+      <pdf:Producer>%s</pdf:Producer>
+      <xmp:CreateDate>%s</xmp:CreateDate>
+      <xmp:ModifyDate>%s</xmp:ModifyDate>
+    </rdf:Description>
+  </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`, values...)), nil
+}
+
+// srgbICCProfile builds an ICC v2 display profile for the sRGB colour space,
+// used as the output intent for the DeviceRGB colours gofpdf writes
+func srgbICCProfile() []byte {
+	s15Fixed16 := func(v float64) []byte {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(int32(math.Round(v*65536))))
+		return b
+	}
+	xyz := func(x, y, z float64) []byte {
+		b := append([]byte("XYZ "), 0, 0, 0, 0)
+		b = append(b, s15Fixed16(x)...)
+		b = append(b, s15Fixed16(y)...)
+		return append(b, s15Fixed16(z)...)
+	}
+
+	description := "sRGB IEC61966-2.1"
+	desc := append([]byte("desc"), 0, 0, 0, 0)
+	desc = binary.BigEndian.AppendUint32(desc, uint32(len(description)+1))
+	desc = append(desc, description...)
+	desc = append(desc, 0)
+	desc = append(desc, make([]byte, 4+4+2+1+67)...) // Empty Unicode and ScriptCode descriptions
+
+	cprt := append([]byte("text"), 0, 0, 0, 0)
+	cprt = append(cprt, "No copyright, use freely"...)
+	cprt = append(cprt, 0)
+
+	// The sRGB transfer function, sampled
+	const samples = 1024
+	trc := append([]byte("curv"), 0, 0, 0, 0)
+	trc = binary.BigEndian.AppendUint32(trc, samples)
+	for i := 0; i < samples; i++ {
+		v := float64(i) / (samples - 1)
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		trc = binary.BigEndian.AppendUint16(trc, uint16(math.Round(v*65535)))
+	}
+
+	// Primaries adapted to the D50 profile connection space
+	tags := []struct {
+		signature string
+		data      []byte
+	}{
+		{"desc", desc},
+		{"cprt", cprt},
+		{"wtpt", xyz(0.9642, 1.0, 0.8249)},
+		{"rXYZ", xyz(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", xyz(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", xyz(0.1431, 0.0606, 0.7141)},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	}
+
+	const headerSize = 128
+	tableSize := 4 + 12*len(tags)
+	var data []byte
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	trcOffset := 0 // The three transfer curves share their data
+	for _, tag := range tags {
+		offset := headerSize + tableSize + len(data)
+		if tag.signature[1:] == "TRC" && trcOffset != 0 {
+			offset = trcOffset
+		} else {
+			if tag.signature[1:] == "TRC" {
+				trcOffset = offset
+			}
+			data = append(data, tag.data...)
+			for len(data)%4 != 0 {
+				data = append(data, 0)
+			}
+		}
+		table = append(table, tag.signature...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offset))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(tag.data)))
+	}
+
+	size := headerSize + tableSize + len(data)
+	header := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(header[0:], uint32(size))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000) // Version 2.1
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	for i, v := range []uint16{2024, 1, 1, 0, 0, 0} {
+		binary.BigEndian.PutUint16(header[24+2*i:], v)
+	}
+	copy(header[36:], "acsp")
+	copy(header[68:], s15Fixed16(0.9642))
+	copy(header[72:], s15Fixed16(1.0))
+	copy(header[76:], s15Fixed16(0.8249))
+
+	return append(append(header, table...), data...)
+}
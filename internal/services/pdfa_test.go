@@ -0,0 +1,100 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestGenerateInvoicePDFA(t *testing.T) {
+	dbService, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	settingsService := NewSettingsService(dbService, NewLogger(ERROR))
+	if err := settingsService.Set(SettingPDFA, "true"); err != nil {
+		t.Fatalf("Failed to save setting: %v", err)
+	}
+
+	pdfService := NewPDFService(tempDir)
+	pdfService.SetSettingsService(settingsService)
+
+	invoice := &models.Invoice{
+		InvoiceNumber: "INV-PDFA",
+		IssueDate:     time.Now(),
+		DueDate:       time.Now().AddDate(0, 0, 30),
+		TotalAmount:   11900,
+		Currency:      "EUR",
+		VatRate:       19,
+		VatAmount:     1900,
+	}
+	business := &models.Business{Name: "Müller & Söhne GmbH", Country: "Germany", Email: "test@business.com"}
+	client := &models.Client{Name: "Test Client", Country: "France"}
+	items := []models.InvoiceItem{{Description: "Beratung", Quantity: 1, UnitPrice: 10000, Amount: 10000}}
+
+	pdfPath, err := pdfService.GenerateInvoice(invoice, business, client, items)
+	if err != nil {
+		t.Fatalf("Failed to generate PDF/A: %v", err)
+	}
+	pdf, err := os.ReadFile(pdfPath)
+	if err != nil {
+		t.Fatalf("Failed to read PDF: %v", err)
+	}
+
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.3\n"+pdfABinaryComment)) {
+		t.Errorf("Expected the header to be followed by a binary comment, got %q", pdf[:16])
+	}
+	for _, want := range []string{
+		"/FontFile2",
+		"/OutputIntents [",
+		"/S /GTS_PDFA1",
+		"<pdfaid:part>3</pdfaid:part>",
+		"<pdfaid:conformance>B</pdfaid:conformance>",
+		"<rdf:li xml:lang=\"x-default\">Invoice INV-PDFA</rdf:li>",
+		"/Title (Invoice INV-PDFA)",
+		"/ID [<",
+	} {
+		if !bytes.Contains(pdf, []byte(want)) {
+			t.Errorf("PDF/A output does not contain %q", want)
+		}
+	}
+	if bytes.Contains(pdf, []byte("/BaseFont /Helvetica")) {
+		t.Error("PDF/A output must not use the non-embedded Helvetica font")
+	}
+
+	// Every object in every cross-reference section must be at its recorded offset
+	doc, err := parsePDFStructure(pdf)
+	if err != nil {
+		t.Fatalf("Failed to parse PDF/A output: %v", err)
+	}
+	for n, offset := range doc.offsets {
+		if !bytes.HasPrefix(pdf[offset:], []byte(fmt.Sprintf("%d 0 obj", n))) {
+			t.Errorf("Object %d is not at offset %d", n, offset)
+		}
+	}
+}
+
+func TestSRGBICCProfile(t *testing.T) {
+	profile := srgbICCProfile()
+
+	if size := binary.BigEndian.Uint32(profile); int(size) != len(profile) {
+		t.Errorf("Profile size field is %d, actual size %d", size, len(profile))
+	}
+	if string(profile[12:16]) != "mntr" || string(profile[16:20]) != "RGB " || string(profile[36:40]) != "acsp" {
+		t.Error("Invalid profile header")
+	}
+
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := 0; i < count; i++ {
+		entry := profile[132+12*i:]
+		offset := binary.BigEndian.Uint32(entry[4:])
+		size := binary.BigEndian.Uint32(entry[8:])
+		if offset%4 != 0 || int(offset+size) > len(profile) {
+			t.Errorf("Tag %s has invalid offset %d and size %d", entry[:4], offset, size)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// ErrPKCS12Password is returned when the PKCS#12 password is wrong
+var ErrPKCS12Password = errors.New("pkcs12: incorrect password")
+
+// DecodePKCS12 extracts the private key, its certificate and any additional
+// (chain) certificates from a PKCS#12 file. Files written by OpenSSL 3 (PBES2
+// with AES) and by older tools (3DES) are supported.
+func DecodePKCS12(data []byte, password string) (crypto.PrivateKey, *x509.Certificate, []*x509.Certificate, error) {
+	key, cert, chain, err := pkcs12.DecodeChain(data, password)
+	if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+		return nil, nil, nil, ErrPKCS12Password
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("pkcs12: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, nil, errors.New("pkcs12: unsupported private key type")
+	}
+	if !publicKeysEqual(cert.PublicKey, signer.Public()) {
+		return nil, nil, nil, errors.New("pkcs12: the certificate does not match the private key")
+	}
+	return key, cert, chain, nil
+}
+
+// publicKeysEqual compares two public keys of any supported type
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	ak, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && ak.Equal(b)
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// ErrProjectClientMismatch is returned when an invoice and its project belong to different clients
+var ErrProjectClientMismatch = errors.New("project belongs to a different client")
+
+// ProjectService manages projects, the time logged on them and their profitability figures
+type ProjectService struct {
+	dbService *DBService
+	logger    *Logger
+}
+
+// NewProjectService creates a new ProjectService
+func NewProjectService(dbService *DBService, logger *Logger) *ProjectService {
+	return &ProjectService{
+		dbService: dbService,
+		logger:    logger,
+	}
+}
+
+// Save creates a project or updates its name, budget and rate. The client and
+// currency of an existing project cannot change.
+func (s *ProjectService) Save(project *models.Project) error {
+	db := s.dbService.GetDB()
+	if project.ID == 0 {
+		project.CreatedAt = time.Now().UTC()
+		err := db.QueryRow(`
+			INSERT INTO projects (client_id, name, currency, budget, hourly_rate, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			RETURNING id
+		`, project.ClientID, project.Name, project.Currency, project.Budget, project.HourlyRate, project.CreatedAt).Scan(&project.ID)
+		if err != nil {
+			return fmt.Errorf("failed to create project: %w", err)
+		}
+		s.logger.Info("Created project %d (%s) for client %d", project.ID, project.Name, project.ClientID)
+		return nil
+	}
+
+	result, err := db.Exec(`UPDATE projects SET name = ?, budget = ?, hourly_rate = ? WHERE id = ?`,
+		project.Name, project.Budget, project.HourlyRate, project.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update project: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Get returns a project, or sql.ErrNoRows if it does not exist
+func (s *ProjectService) Get(id int) (*models.Project, error) {
+	var project models.Project
+	err := s.dbService.GetDB().QueryRow(`
+		SELECT id, client_id, name, currency, budget, hourly_rate, created_at FROM projects WHERE id = ?
+	`, id).Scan(&project.ID, &project.ClientID, &project.Name, &project.Currency, &project.Budget, &project.HourlyRate, &project.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+// List returns the projects ordered by name
+func (s *ProjectService) List() ([]models.Project, error) {
+	rows, err := s.dbService.GetDB().Query(`
+		SELECT id, client_id, name, currency, budget, hourly_rate, created_at FROM projects ORDER BY LOWER(name), id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query projects: %w", err)
+	}
+	defer rows.Close()
+
+	var projects []models.Project
+	for rows.Next() {
+		var project models.Project
+		if err := rows.Scan(&project.ID, &project.ClientID, &project.Name, &project.Currency, &project.Budget, &project.HourlyRate, &project.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan project: %w", err)
+		}
+		projects = append(projects, project)
+	}
+	return projects, rows.Err()
+}
+
+// Delete removes a project with its time entries. Its invoices are kept and
+// no longer belong to a project.
+func (s *ProjectService) Delete(id int) error {
+	tx, err := s.dbService.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM projects WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete project: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec(`DELETE FROM time_entries WHERE project_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete time entries: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE invoices SET project_id = 0 WHERE project_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to unlink invoices: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.logger.Info("Deleted project %d", id)
+	return nil
+}
+
+// CheckInvoiceProject returns ErrProjectClientMismatch unless the project
+// exists and belongs to the client; project 0 means no project
+func (s *ProjectService) CheckInvoiceProject(projectID, clientID int) error {
+	if projectID == 0 {
+		return nil
+	}
+	project, err := s.Get(projectID)
+	if err != nil {
+		return err
+	}
+	if project.ClientID != clientID {
+		return ErrProjectClientMismatch
+	}
+	return nil
+}
+
+// AddTimeEntry logs time on a project
+func (s *ProjectService) AddTimeEntry(entry *models.TimeEntry) error {
+	err := s.dbService.GetDB().QueryRow(`
+		INSERT INTO time_entries (project_id, date, hours, description) VALUES (?, ?, ?, ?)
+		RETURNING id
+	`, entry.ProjectID, entry.Date.Format("2006-01-02"), entry.Hours, entry.Description).Scan(&entry.ID)
+	if err != nil {
+		return fmt.Errorf("failed to add time entry: %w", err)
+	}
+	entry.InvoiceID = 0
+	return nil
+}
+
+// GetTimeEntries returns the time logged on a project, oldest first
+func (s *ProjectService) GetTimeEntries(projectID int) ([]models.TimeEntry, error) {
+	return s.queryTimeEntries(`WHERE project_id = ?`, projectID)
+}
+
+// GetInvoiceTimeEntries returns the time billed on an invoice, oldest first
+func (s *ProjectService) GetInvoiceTimeEntries(invoiceID int) ([]models.TimeEntry, error) {
+	return s.queryTimeEntries(`WHERE invoice_id = ?`, invoiceID)
+}
+
+func (s *ProjectService) queryTimeEntries(where string, args ...any) ([]models.TimeEntry, error) {
+	rows, err := s.dbService.GetDB().Query(`
+		SELECT id, project_id, date, hours, description, invoice_id FROM time_entries
+		`+where+` ORDER BY date, id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query time entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []models.TimeEntry
+	for rows.Next() {
+		var entry models.TimeEntry
+		var date string
+		if err := rows.Scan(&entry.ID, &entry.ProjectID, &date, &entry.Hours, &entry.Description, &entry.InvoiceID); err != nil {
+			return nil, fmt.Errorf("failed to scan time entry: %w", err)
+		}
+		entry.Date, _ = time.Parse("2006-01-02", date)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// DeleteTimeEntry removes time that was not billed yet
+func (s *ProjectService) DeleteTimeEntry(projectID, entryID int) error {
+	result, err := s.dbService.GetDB().Exec(`
+		DELETE FROM time_entries WHERE id = ? AND project_id = ? AND invoice_id = 0
+	`, entryID, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to delete time entry: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// BillTimeEntries marks the unbilled time of the invoice's project as billed
+// on the invoice and returns the number of hours. With a service period only
+// time logged up to its end is billed.
+func (s *ProjectService) BillTimeEntries(invoiceID int) (float64, error) {
+	invoice, _, err := s.dbService.GetInvoice(invoiceID)
+	if err != nil {
+		return 0, err
+	}
+	if invoice.ProjectID == 0 {
+		return 0, nil
+	}
+
+	query := `UPDATE time_entries SET invoice_id = ? WHERE project_id = ? AND invoice_id = 0`
+	args := []any{invoice.ID, invoice.ProjectID}
+	if invoice.HasServicePeriod() {
+		query += ` AND date <= ?`
+		args = append(args, invoice.ServicePeriodEnd.Format("2006-01-02"))
+	}
+	if _, err := s.dbService.GetDB().Exec(query, args...); err != nil {
+		return 0, fmt.Errorf("failed to bill time entries: %w", err)
+	}
+
+	var hours float64
+	err = s.dbService.GetDB().QueryRow(`SELECT COALESCE(SUM(hours), 0) FROM time_entries WHERE invoice_id = ?`, invoice.ID).Scan(&hours)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum billed time: %w", err)
+	}
+	s.logger.Info("Billed %.2f hours of project %d on invoice %s", hours, invoice.ProjectID, invoice.InvoiceNumber)
+	return hours, nil
+}
+
+// Summaries returns every project with its invoiced and paid amounts, net of
+// VAT, and the time logged on it. Pro-forma invoices are not counted.
+func (s *ProjectService) Summaries() ([]models.ProjectSummary, error) {
+	rows, err := s.dbService.GetDB().Query(`
+		SELECT p.id, p.client_id, p.name, p.currency, p.budget, p.hourly_rate, p.created_at,
+			COALESCE(c.name, ''),
+			COALESCE((SELECT SUM(i.total_amount - i.vat_amount) FROM invoices i
+				WHERE i.project_id = p.id AND i.type = ?), 0),
+			COALESCE((SELECT SUM(i.total_amount - i.vat_amount) FROM invoices i
+				WHERE i.project_id = p.id AND i.type = ? AND i.status = ?), 0),
+			COALESCE((SELECT SUM(t.hours) FROM time_entries t WHERE t.project_id = p.id), 0),
+			COALESCE((SELECT SUM(t.hours) FROM time_entries t WHERE t.project_id = p.id AND t.invoice_id = 0), 0)
+		FROM projects p
+		LEFT JOIN clients c ON c.id = p.client_id
+		ORDER BY LOWER(p.name), p.id
+	`, models.InvoiceTypeInvoice, models.InvoiceTypeInvoice, models.InvoiceStatusPaid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query project summaries: %w", err)
+	}
+	defer rows.Close()
+
+	var summaries []models.ProjectSummary
+	for rows.Next() {
+		var p models.ProjectSummary
+		if err := rows.Scan(&p.ID, &p.ClientID, &p.Name, &p.Currency, &p.Budget, &p.HourlyRate, &p.CreatedAt,
+			&p.ClientName, &p.Invoiced, &p.Paid, &p.HoursLogged, &p.UnbilledHours); err != nil {
+			return nil, fmt.Errorf("failed to scan project summary: %w", err)
+		}
+		summaries = append(summaries, p)
+	}
+	return summaries, rows.Err()
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestProjectSummaries(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+	projectService := NewProjectService(dbService, NewLogger(ERROR))
+
+	project := &models.Project{ClientID: 1, Name: "Website", Currency: "EUR", Budget: 100000, HourlyRate: 5000}
+	if err := projectService.Save(project); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := projectService.CheckInvoiceProject(project.ID, 2); !errors.Is(err, ErrProjectClientMismatch) {
+		t.Errorf("Expected ErrProjectClientMismatch, got %v", err)
+	}
+
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i, hours := range []float64{4, 6, 2} {
+		entry := &models.TimeEntry{ProjectID: project.ID, Date: day.AddDate(0, 0, i*10), Hours: hours, Description: "Development"}
+		if err := projectService.AddTimeEntry(entry); err != nil {
+			t.Fatalf("AddTimeEntry failed: %v", err)
+		}
+	}
+
+	// The invoice covers the first ten days of March, so the last entry stays unbilled
+	invoice := &models.Invoice{
+		BusinessID:         1,
+		ClientID:           1,
+		ProjectID:          project.ID,
+		IssueDate:          day.AddDate(0, 0, 15),
+		DueDate:            day.AddDate(0, 0, 29),
+		TotalAmount:        59500,
+		VatRate:            19,
+		VatAmount:          9500,
+		Currency:           "EUR",
+		Status:             "paid",
+		ServicePeriodStart: day,
+		ServicePeriodEnd:   day.AddDate(0, 0, 10),
+	}
+	items := []models.InvoiceItem{{Description: "Development", Quantity: 10, UnitPrice: 5000, Amount: 50000}}
+	if err := dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+
+	// Pro-forma invoices do not count as invoiced
+	proforma := &models.Invoice{
+		BusinessID:  1,
+		ClientID:    1,
+		ProjectID:   project.ID,
+		Type:        models.InvoiceTypeProforma,
+		IssueDate:   day,
+		DueDate:     day.AddDate(0, 0, 14),
+		TotalAmount: 10000,
+		Currency:    "EUR",
+		Status:      "draft",
+	}
+	if err := dbService.SaveInvoice(proforma, []models.InvoiceItem{{Description: "Deposit", Quantity: 1, UnitPrice: 10000, Amount: 10000}}); err != nil {
+		t.Fatalf("Failed to save pro-forma invoice: %v", err)
+	}
+
+	hours, err := projectService.BillTimeEntries(invoice.ID)
+	if err != nil {
+		t.Fatalf("BillTimeEntries failed: %v", err)
+	}
+	if hours != 10 {
+		t.Errorf("Expected 10 hours billed, got %v", hours)
+	}
+
+	summaries, err := projectService.Summaries()
+	if err != nil {
+		t.Fatalf("Summaries failed: %v", err)
+	}
+	if len(summaries) != 1 {
+		t.Fatalf("Expected 1 project summary, got %d", len(summaries))
+	}
+	summary := summaries[0]
+	if summary.Invoiced != 50000 || summary.Paid != 50000 {
+		t.Errorf("Expected 500.00 invoiced and paid, got %s and %s", summary.Invoiced, summary.Paid)
+	}
+	if summary.HoursLogged != 12 || summary.UnbilledHours != 2 {
+		t.Errorf("Expected 12 hours logged and 2 unbilled, got %v and %v", summary.HoursLogged, summary.UnbilledHours)
+	}
+	if summary.BudgetUsed() != 50 {
+		t.Errorf("Expected 50%% of the budget used, got %v", summary.BudgetUsed())
+	}
+	if summary.EffectiveRate() != 4167 || summary.UnbilledValue() != 10000 {
+		t.Errorf("Expected 41.67 effective rate and 100.00 unbilled, got %s and %s", summary.EffectiveRate(), summary.UnbilledValue())
+	}
+
+	// Billed time cannot be deleted, and deleting the invoice unbills it
+	entries, err := projectService.GetInvoiceTimeEntries(invoice.ID)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Expected 2 billed time entries, got %d (%v)", len(entries), err)
+	}
+	if err := projectService.DeleteTimeEntry(project.ID, entries[0].ID); err == nil {
+		t.Error("Expected deleting billed time to fail")
+	}
+	if err := dbService.DeleteInvoice(invoice.ID); err != nil {
+		t.Fatalf("DeleteInvoice failed: %v", err)
+	}
+	if entries, _ := projectService.GetInvoiceTimeEntries(invoice.ID); len(entries) != 0 {
+		t.Errorf("Expected time to be unbilled after deleting the invoice, got %d entries", len(entries))
+	}
+}
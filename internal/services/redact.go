@@ -0,0 +1,184 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Patterns of personal data that are masked in every log message
+var (
+	ibanPattern  = regexp.MustCompile(`\b[A-Z]{2}[0-9]{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`)
+	vatIDPattern = regexp.MustCompile(`\b(?:ATU|BE|BG|CHE|CY|CZ|DE|DK|EE|EL|ES|FI|FR|GB|HR|HU|IE|IT|LT|LU|LV|MT|NL|NO|PL|PT|RO|SE|SI|SK|XI)[ -]?[0-9A-Z]{2,13}\b`)
+	emailPattern = regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`)
+)
+
+// piiFields are the JSON fields holding names, addresses, bank and tax
+// details, compared in lower case without underscores and dashes
+var piiFields = map[string]func(string) string{
+	"name":           MaskPII,
+	"firstname":      MaskPII,
+	"lastname":       MaskPII,
+	"clientname":     MaskPII,
+	"companyname":    MaskPII,
+	"tradername":     MaskPII,
+	"title":          MaskPII, // Company names in Companies House results
+	"address":        MaskPII,
+	"addresssnippet": MaskPII,
+	"addressline1":   MaskPII,
+	"addressline2":   MaskPII,
+	"traderaddress":  MaskPII,
+	"street":         MaskPII,
+	"city":           MaskPII,
+	"locality":       MaskPII,
+	"postalcode":     MaskPII,
+	"postcode":       MaskPII,
+	"phone":          MaskPII,
+	"bankname":       MaskPII,
+	"secondbankname": MaskPII,
+	"bankaccount":    MaskPII,
+	"iban":           maskIBAN,
+	"secondiban":     maskIBAN,
+	"bic":            MaskPII,
+	"secondbic":      MaskPII,
+	"vatid":          maskVATID,
+	"vatnumber":      maskVATID,
+	"email":          maskEmail,
+	"recipient":      maskEmail,
+}
+
+// RedactPII masks IBANs, VAT IDs and email addresses in free text. The Logger
+// applies it to every message.
+func RedactPII(text string) string {
+	text = ibanPattern.ReplaceAllStringFunc(text, maskIBAN)
+	text = vatIDPattern.ReplaceAllStringFunc(text, func(match string) string {
+		// Words that happen to start like a country code have few digits
+		if countDigits(match) < 6 {
+			return match
+		}
+		return maskVATID(match)
+	})
+	return emailPattern.ReplaceAllStringFunc(text, maskEmail)
+}
+
+// RedactJSON returns a JSON document for the log with the names, addresses,
+// bank and tax details masked, keeping its structure, IDs, dates and amounts
+// for debugging. Anything that is not JSON is only described by its length.
+func RedactJSON(data []byte) string {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Sprintf("<%d bytes, not JSON>", len(data))
+	}
+	redacted, err := json.Marshal(redactValue(value, ""))
+	if err != nil {
+		return fmt.Sprintf("<%d bytes>", len(data))
+	}
+	return string(redacted)
+}
+
+// Redact returns a value, such as a client or an invoice, as JSON for the log
+// with personal data masked like RedactJSON
+func Redact(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("<%T>", v)
+	}
+	return RedactJSON(data)
+}
+
+// redactValue masks the strings of personal data fields in a decoded JSON
+// value. field is the name of the field holding value.
+func redactValue(value interface{}, field string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = redactValue(child, key)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactValue(child, field)
+		}
+		return v
+	case string:
+		if mask, ok := piiFields[normalizeFieldName(field)]; ok {
+			return mask(v)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// redactHeaders returns a copy of request headers for the log with the
+// credentials removed
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range []string{"Authorization", "Cookie"} {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, "REDACTED")
+		}
+	}
+	return redacted
+}
+
+func normalizeFieldName(name string) string {
+	return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(name))
+}
+
+// MaskPII masks a name or an address, keeping its first character and the
+// spacing between words, e.g. "Acme GmbH" becomes "A*** ****"
+func MaskPII(value string) string {
+	var b strings.Builder
+	for i, r := range []rune(value) {
+		switch {
+		case i == 0, unicode.IsSpace(r), unicode.IsPunct(r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune('*')
+		}
+	}
+	return b.String()
+}
+
+// maskIBAN keeps the country code and the last four characters of an IBAN
+func maskIBAN(iban string) string {
+	compact := strings.ReplaceAll(iban, " ", "")
+	if len(compact) <= 6 {
+		return MaskPII(compact)
+	}
+	return compact[:2] + strings.Repeat("*", len(compact)-6) + compact[len(compact)-4:]
+}
+
+// maskVATID keeps the country prefix of a VAT ID
+func maskVATID(vatID string) string {
+	compact := strings.NewReplacer(" ", "", "-", "").Replace(vatID)
+	prefix := len(compact) - len(strings.TrimLeftFunc(compact, unicode.IsLetter))
+	prefix = min(prefix, 3, len(compact))
+	return compact[:prefix] + strings.Repeat("*", len(compact)-prefix)
+}
+
+// maskEmail keeps the first character and the domain of an email address
+func maskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return MaskPII(email)
+	}
+	return local[:1] + "***@" + domain
+}
+
+func countDigits(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			n++
+		}
+	}
+	return n
+}
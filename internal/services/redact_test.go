@@ -0,0 +1,79 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestRedactPII(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Paid to DE89 3704 0044 0532 0130 00 today", "Paid to DE****************3000 today"},
+		{"iban=GB29NWBK60161331926819", "iban=GB****************6819"},
+		{"Validated VAT ID DE123456789", "Validated VAT ID DE*********"},
+		{"VAT ATU12345678 and NL 123456789B01", "VAT ATU******** and NL************"},
+		{"Sent invoice to jane.doe@example.com", "Sent invoice to j***@example.com"},
+		// Structural information is kept
+		{"Generated invoice INV-2026-0001 for client 42", "Generated invoice INV-2026-0001 for client 42"},
+		{"FRAMEWORK DESCRIPTION status=200", "FRAMEWORK DESCRIPTION status=200"},
+	}
+	for _, tt := range tests {
+		if got := RedactPII(tt.in); got != tt.want {
+			t.Errorf("RedactPII(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	client := models.Client{
+		ID:         7,
+		Name:       "Jane Doe",
+		Address:    "Hauptstraße 5",
+		City:       "Berlin",
+		PostalCode: "10115",
+		Country:    "Germany",
+		VatID:      "DE123456789",
+		Email:      "jane@example.com",
+	}
+	got := Redact(client)
+	for _, leaked := range []string{"Jane Doe", "Hauptstraße", "Berlin", "10115", "DE123456789", "jane@"} {
+		if strings.Contains(got, leaked) {
+			t.Errorf("Expected %q to be masked in %s", leaked, got)
+		}
+	}
+	for _, kept := range []string{`"id":7`, `"name":"J*** ***"`, `"country":"Germany"`, `"vat_id":"DE*********"`, `"email":"j***@example.com"`} {
+		if !strings.Contains(got, kept) {
+			t.Errorf("Expected %s in %s", kept, got)
+		}
+	}
+
+	body := RedactJSON([]byte(`{"invoice": {"id": 3, "total_amount": 1190.5, "client": {"name": "Acme GmbH"}}, "items": [{"description": "Consulting", "quantity": 2}]}`))
+	if !strings.Contains(body, `"name":"A*** ****"`) || !strings.Contains(body, `"total_amount":1190.5`) || !strings.Contains(body, `"description":"Consulting"`) {
+		t.Errorf("Unexpected redacted body: %s", body)
+	}
+	if got := RedactJSON([]byte("name=Jane")); got != "<9 bytes, not JSON>" {
+		t.Errorf("Expected a body that is not JSON to be described, got %q", got)
+	}
+}
+
+func TestLoggerRedactsPII(t *testing.T) {
+	var output bytes.Buffer
+	logger := NewLogger(DEBUG)
+	logger.SetOutput(&output)
+
+	logger.Debug("Saving business with IBAN DE89370400440532013000 and VAT ID FR40303265045")
+	for _, leaked := range []string{"DE89370400440532013000", "FR40303265045"} {
+		if strings.Contains(output.String(), leaked) {
+			t.Errorf("Expected %s to be masked in the log, got %s", leaked, output.String())
+		}
+		for _, entry := range logger.Recent(DEBUG, 0, 0) {
+			if strings.Contains(entry.Message, leaked) {
+				t.Errorf("Expected %s to be masked in the recent entries, got %s", leaked, entry.Message)
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// Report bases
+const (
+	ReportBasisAccrual = "accrual"
+	ReportBasisCash    = "cash"
+)
+
+// ReportBases lists the supported report bases
+var ReportBases = []string{ReportBasisAccrual, ReportBasisCash}
+
+// ReportService computes revenue reports from invoices and client credit
+type ReportService struct {
+	dbService       *DBService
+	settingsService *SettingsService
+	logger          *Logger
+}
+
+// NewReportService creates a new ReportService
+func NewReportService(dbService *DBService, settingsService *SettingsService, logger *Logger) *ReportService {
+	return &ReportService{
+		dbService:       dbService,
+		settingsService: settingsService,
+		logger:          logger,
+	}
+}
+
+// DefaultBasis returns the configured report basis
+func (s *ReportService) DefaultBasis() string {
+	return s.settingsService.GetString(SettingReportBasis)
+}
+
+// Report returns the revenue of a year on the given basis, the configured
+// basis if empty, with the amounts due on the invoices of the year that are
+// disputed or on hold. Pro-forma invoices are not counted.
+//
+// On the accrual basis every invoice counts in the month of its issue date.
+// On the cash basis only paid invoices count, in the month of their payment
+// date or of their issue date if the payment date is unknown. Credit applied
+// to an invoice was received when the client paid it in advance, so it is
+// counted as a prepayment in that month instead.
+func (s *ReportService) Report(basis string, year int) (*models.Report, error) {
+	basis = cmp.Or(basis, s.DefaultBasis())
+	if !slices.Contains(ReportBases, basis) {
+		return nil, fmt.Errorf("unknown report basis %q", basis)
+	}
+
+	invoices, err := s.dbService.GetInvoices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load invoices: %w", err)
+	}
+
+	rows := make(map[[2]string]*models.ReportRow)
+	row := func(date time.Time, currency string) *models.ReportRow {
+		key := [2]string{date.Format("2006-01"), currency}
+		if rows[key] == nil {
+			rows[key] = &models.ReportRow{Month: key[0], Currency: currency}
+		}
+		return rows[key]
+	}
+
+	held := make(map[[2]string]*models.HeldAmount)
+	for _, invoice := range invoices {
+		if invoice.IsProforma() {
+			continue
+		}
+		if invoice.IsHeld() && invoice.IssueDate.Year() == year {
+			key := [2]string{invoice.Status, invoice.Currency}
+			if held[key] == nil {
+				held[key] = &models.HeldAmount{Status: invoice.Status, Currency: invoice.Currency}
+			}
+			held[key].Invoices++
+			held[key].Amount += invoice.AmountDue()
+		}
+		date := invoice.IssueDate
+		if basis == ReportBasisCash {
+			// Cancelled invoices and their cancellations were never paid
+			if invoice.Status != models.InvoiceStatusPaid || invoice.IsSettledByCancellation() {
+				continue
+			}
+			if !invoice.PaidDate.IsZero() {
+				date = invoice.PaidDate
+			}
+		}
+		if date.Year() != year {
+			continue
+		}
+
+		r := row(date, invoice.Currency)
+		r.Invoices++
+		r.Net += invoice.TotalAmount - invoice.VatAmount
+		r.VAT += invoice.VatAmount
+		r.Total += invoice.TotalAmount
+		if basis == ReportBasisCash {
+			r.CreditApplied += invoice.CreditApplied
+		}
+	}
+
+	if basis == ReportBasisCash {
+		if err := s.addPrepayments(year, row); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &models.Report{Basis: basis, Year: year}
+	totals := make(map[string]*models.ReportRow)
+	for _, r := range rows {
+		report.Rows = append(report.Rows, *r)
+		if totals[r.Currency] == nil {
+			totals[r.Currency] = &models.ReportRow{Currency: r.Currency}
+		}
+		t := totals[r.Currency]
+		t.Invoices += r.Invoices
+		t.Net += r.Net
+		t.VAT += r.VAT
+		t.Total += r.Total
+		t.CreditApplied += r.CreditApplied
+		t.Prepayments += r.Prepayments
+	}
+	for _, t := range totals {
+		report.Totals = append(report.Totals, *t)
+	}
+	slices.SortFunc(report.Rows, func(a, b models.ReportRow) int {
+		return cmp.Or(cmp.Compare(a.Month, b.Month), cmp.Compare(a.Currency, b.Currency))
+	})
+	slices.SortFunc(report.Totals, func(a, b models.ReportRow) int {
+		return cmp.Compare(a.Currency, b.Currency)
+	})
+	for _, h := range held {
+		report.Held = append(report.Held, *h)
+	}
+	slices.SortFunc(report.Held, func(a, b models.HeldAmount) int {
+		return cmp.Or(cmp.Compare(a.Status, b.Status), cmp.Compare(a.Currency, b.Currency))
+	})
+	return report, nil
+}
+
+// addPrepayments adds the client credit received in the year, the ledger
+// entries not linked to an invoice, to the rows of their month
+func (s *ReportService) addPrepayments(year int, row func(time.Time, string) *models.ReportRow) error {
+	rows, err := s.dbService.GetDB().Query(`
+		SELECT amount, currency, created_at FROM client_credits WHERE invoice_id = 0
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query client credits: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var amount models.Money
+		var currency string
+		var createdAt time.Time
+		if err := rows.Scan(&amount, &currency, &createdAt); err != nil {
+			return fmt.Errorf("failed to scan client credit: %w", err)
+		}
+		if createdAt.Year() == year {
+			row(createdAt, currency).Prepayments += amount
+		}
+	}
+	return rows.Err()
+}
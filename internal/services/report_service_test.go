@@ -0,0 +1,172 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestReportBases(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	logger := NewLogger(ERROR)
+	reports := NewReportService(dbService, NewSettingsService(dbService, logger), logger)
+
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(2024, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	saveInvoice := func(number string, issued time.Time, net, vat models.Money, status string, paid time.Time, invoiceType string) *models.Invoice {
+		t.Helper()
+		invoice := &models.Invoice{
+			InvoiceNumber: number,
+			BusinessID:    1,
+			ClientID:      1,
+			IssueDate:     issued,
+			DueDate:       issued.AddDate(0, 0, 14),
+			TotalAmount:   net + vat,
+			VatRate:       float64(vat) / float64(net) * 100,
+			VatAmount:     vat,
+			Currency:      "EUR",
+			Status:        status,
+			PaidDate:      paid,
+			Type:          invoiceType,
+		}
+		items := []models.InvoiceItem{{Description: "Consulting", Quantity: 1, UnitPrice: net, Amount: net}}
+		if err := dbService.SaveImportedInvoice(invoice, items); err != nil {
+			t.Fatalf("Failed to save invoice %s: %v", number, err)
+		}
+		return invoice
+	}
+	addCredit := func(amount models.Money, createdAt time.Time) {
+		t.Helper()
+		_, err := dbService.GetDB().Exec(`
+			INSERT INTO client_credits (client_id, invoice_id, amount, currency, description, created_at) VALUES (1, 0, ?, 'EUR', 'Prepayment', ?)
+		`, amount, createdAt)
+		if err != nil {
+			t.Fatalf("Failed to add credit: %v", err)
+		}
+	}
+
+	// Issued in March, paid in April
+	march := saveInvoice("2024-001", date(time.March, 10), 10000, 1900, "sent", time.Time{}, models.InvoiceTypeInvoice)
+	if err := dbService.UpdateInvoiceStatus(march.ID, "paid", date(time.April, 5)); err != nil {
+		t.Fatalf("UpdateInvoiceStatus failed: %v", err)
+	}
+	// Issued in December, not paid yet
+	saveInvoice("2024-002", date(time.December, 20), 5000, 950, "sent", time.Time{}, models.InvoiceTypeInvoice)
+	// Issued in 2023, paid in January partly with credit received in 2023
+	addCredit(400, time.Date(2023, time.November, 3, 0, 0, 0, 0, time.UTC))
+	december := saveInvoice("2023-010", time.Date(2023, time.December, 15, 0, 0, 0, 0, time.UTC), 1000, 0, "paid", date(time.January, 10), models.InvoiceTypeInvoice)
+	if _, err := dbService.ApplyCredit(december.ID, 400); err != nil {
+		t.Fatalf("ApplyCredit failed: %v", err)
+	}
+	// Pro-forma invoices never count
+	saveInvoice("PF-001", date(time.February, 1), 3000, 570, "paid", date(time.February, 2), models.InvoiceTypeProforma)
+	// A prepayment received in February
+	addCredit(2000, date(time.February, 20))
+
+	accrual, err := reports.Report("", 2024)
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if accrual.Basis != ReportBasisAccrual {
+		t.Errorf("Expected the accrual basis by default, got %s", accrual.Basis)
+	}
+	if len(accrual.Rows) != 2 || accrual.Rows[0].Month != "2024-03" || accrual.Rows[1].Month != "2024-12" {
+		t.Fatalf("Expected rows for March and December, got %+v", accrual.Rows)
+	}
+	if total := accrual.Totals[0]; total.Invoices != 2 || total.Net != 15000 || total.VAT != 2850 || total.Total != 17850 || total.Received() != 17850 {
+		t.Errorf("Unexpected accrual totals %+v", total)
+	}
+
+	cash, err := reports.Report(ReportBasisCash, 2024)
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if len(cash.Rows) != 3 {
+		t.Fatalf("Expected rows for January, February and April, got %+v", cash.Rows)
+	}
+	if january := cash.Rows[0]; january.Month != "2024-01" || january.Total != 1000 || january.CreditApplied != 400 || january.Received() != 600 {
+		t.Errorf("Unexpected January row %+v", january)
+	}
+	if february := cash.Rows[1]; february.Month != "2024-02" || february.Invoices != 0 || february.Received() != 2000 {
+		t.Errorf("Unexpected February row %+v", february)
+	}
+	if april := cash.Rows[2]; april.Month != "2024-04" || april.Total != 11900 {
+		t.Errorf("Unexpected April row %+v", april)
+	}
+	if total := cash.Totals[0]; total.Invoices != 2 || total.Received() != 14500 {
+		t.Errorf("Unexpected cash totals %+v", total)
+	}
+
+	// Marking an invoice as sent again clears its payment date
+	if err := dbService.UpdateInvoiceStatus(march.ID, "sent", time.Time{}); err != nil {
+		t.Fatalf("UpdateInvoiceStatus failed: %v", err)
+	}
+	if invoice, _, _ := dbService.GetInvoice(march.ID); !invoice.PaidDate.IsZero() {
+		t.Errorf("Expected no payment date, got %v", invoice.PaidDate)
+	}
+
+	// Disputed invoices are reported with the amount due, and their reason
+	// is cleared once the dispute is resolved
+	if err := dbService.HoldInvoice(march.ID, models.InvoiceStatusDisputed, " Hours not agreed "); err != nil {
+		t.Fatalf("HoldInvoice failed: %v", err)
+	}
+	if invoice, _, _ := dbService.GetInvoice(march.ID); invoice.Status != models.InvoiceStatusDisputed || invoice.StatusReason != "Hours not agreed" {
+		t.Errorf("Expected the invoice to be disputed, got %s (%q)", invoice.Status, invoice.StatusReason)
+	}
+	accrual, err = reports.Report(ReportBasisAccrual, 2024)
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if len(accrual.Held) != 1 || accrual.Held[0] != (models.HeldAmount{Status: models.InvoiceStatusDisputed, Currency: "EUR", Invoices: 1, Amount: 11900}) {
+		t.Errorf("Unexpected disputed amounts %+v", accrual.Held)
+	}
+	if err := dbService.UpdateInvoiceStatus(march.ID, "sent", time.Time{}); err != nil {
+		t.Fatalf("UpdateInvoiceStatus failed: %v", err)
+	}
+	if invoice, _, _ := dbService.GetInvoice(march.ID); invoice.StatusReason != "" {
+		t.Errorf("Expected the reason to be cleared, got %q", invoice.StatusReason)
+	}
+
+	if _, err := reports.Report("monthly", 2024); err == nil {
+		t.Error("Expected an error for an unknown basis")
+	}
+}
+
+func TestReportCancelledInvoices(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	logger := NewLogger(ERROR)
+	reports := NewReportService(dbService, NewSettingsService(dbService, logger), logger)
+
+	issued := time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{BusinessID: 1, ClientID: 1, IssueDate: issued, DueDate: issued.AddDate(0, 0, 14), VatRate: 19, Currency: "EUR", Status: models.InvoiceStatusSent}
+	items := []models.InvoiceItem{{Description: "Consulting", Quantity: 1, UnitPrice: 10000}}
+	invoice.ApplyTotals(items)
+	if err := dbService.SaveInvoice(invoice, items); err != nil {
+		t.Fatalf("Failed to save invoice: %v", err)
+	}
+	if _, err := dbService.CancelInvoice(invoice.ID, issued.AddDate(0, 0, 5), nil); err != nil {
+		t.Fatalf("CancelInvoice failed: %v", err)
+	}
+
+	// The invoice and its cancellation net to zero, and neither was paid
+	accrual, err := reports.Report(ReportBasisAccrual, 2024)
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if len(accrual.Totals) != 1 || accrual.Totals[0].Invoices != 2 || accrual.Totals[0].Total != 0 {
+		t.Errorf("Expected the cancellation to offset the invoice, got %+v", accrual.Totals)
+	}
+	cash, err := reports.Report(ReportBasisCash, 2024)
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if len(cash.Rows) != 0 {
+		t.Errorf("Expected no payments, got %+v", cash.Rows)
+	}
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+//go:generate mockgen -source=repository.go -destination=mocks/repository.go -package=mocks
+
+// BusinessRepo stores the business details printed on invoices
+type BusinessRepo interface {
+	SaveBusiness(business *models.Business) error
+	GetBusiness(id int) (*models.Business, error)
+	GetBusinesses() ([]models.Business, error)
+}
+
+// ClientRepo stores clients, including deleted and anonymized ones
+type ClientRepo interface {
+	SaveClient(client *models.Client) error
+	GetClient(id int) (*models.Client, error)
+	GetClients() ([]models.Client, error)
+	DeleteClient(id int) error
+	GetDeletedClients() ([]models.Client, error)
+	RestoreClient(id int) error
+	CountOpenInvoicesForClient(clientID int) (int, error)
+	AnonymizeClient(id int) ([]string, error)
+}
+
+// InvoiceRepo stores invoices and their items
+type InvoiceRepo interface {
+	SaveInvoice(invoice *models.Invoice, items []models.InvoiceItem) error
+	SaveImportedInvoice(invoice *models.Invoice, items []models.InvoiceItem) error
+	SaveInvoices(invoices []*models.Invoice, items [][]models.InvoiceItem) error
+	GetInvoice(id int) (*models.Invoice, []models.InvoiceItem, error)
+	GetInvoices() ([]models.Invoice, error)
+	UpdateInvoiceStatus(id int, status string, paidDate time.Time) error
+	HoldInvoice(id int, status, reason string) error
+	ConvertProforma(id int, issueDate time.Time, prepare func(invoice *models.Invoice)) (*models.Invoice, error)
+	CancelInvoice(id int, issueDate time.Time, prepare func(invoice *models.Invoice, items []models.InvoiceItem) error) (*models.Invoice, error)
+	SetInvoiceExchangeRate(id int, homeCurrency string, rate float64, date time.Time) error
+	DeleteInvoice(id int) error
+}
+
+// Store is the database behind the application. DBService implements it on
+// SQLite, or on PostgreSQL when DATABASE_URL is set.
+type Store interface {
+	BusinessRepo
+	ClientRepo
+	InvoiceRepo
+}
+
+var _ Store = (*DBService)(nil)
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// defaultReverseChargeClauses are the built-in reverse-charge clauses per
+// language, citing the article of the EU VAT Directive that shifts the VAT
+// liability to the customer
+var defaultReverseChargeClauses = map[string]string{
+	"en": "Reverse charge: VAT to be accounted for by the recipient pursuant to Article 196 of Council Directive 2006/112/EC.",
+	"de": "Steuerschuldnerschaft des Leistungsempfängers (Reverse Charge) gemäß Artikel 196 der Richtlinie 2006/112/EG.",
+	"fr": "Autoliquidation : TVA due par le preneur conformément à l'article 196 de la directive 2006/112/CE.",
+	"es": "Inversión del sujeto pasivo: IVA a cargo del destinatario conforme al artículo 196 de la Directiva 2006/112/CE.",
+	"it": "Inversione contabile: IVA assolta dal committente ai sensi dell'articolo 196 della Direttiva 2006/112/CE.",
+	"nl": "Btw verlegd: btw te voldoen door de afnemer op grond van artikel 196 van Richtlijn 2006/112/EG.",
+	"pt": "Autoliquidação: IVA devido pelo adquirente nos termos do artigo 196.º da Diretiva 2006/112/CE.",
+	"pl": "Odwrotne obciążenie: VAT rozlicza nabywca zgodnie z art. 196 dyrektywy 2006/112/WE.",
+	"ro": "Taxare inversă: TVA datorată de beneficiar conform articolului 196 din Directiva 2006/112/CE.",
+}
+
+// defaultReverseChargeLanguage is used for clients in a language without a built-in clause
+const defaultReverseChargeLanguage = "en"
+
+// countryCodePattern matches a two-letter country code
+var countryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// ErrReverseChargeClauseNotFound is returned when deleting a clause that was never customized
+var ErrReverseChargeClauseNotFound = errors.New("reverse charge clause not found")
+
+// ReverseChargeService picks the legal clause printed on reverse-charge
+// invoices and manages the clauses customized per client country
+type ReverseChargeService struct {
+	dbService       *DBService
+	settingsService *SettingsService
+	logger          *Logger
+}
+
+// NewReverseChargeService creates a new ReverseChargeService
+func NewReverseChargeService(dbService *DBService, settingsService *SettingsService, logger *Logger) *ReverseChargeService {
+	return &ReverseChargeService{
+		dbService:       dbService,
+		settingsService: settingsService,
+		logger:          logger,
+	}
+}
+
+// List returns the customized clauses ordered by country, followed by the
+// built-in clauses ordered by language
+func (s *ReverseChargeService) List() ([]models.ReverseChargeClause, error) {
+	rows, err := s.dbService.GetDB().Query(`SELECT country, text, updated_at FROM reverse_charge_clauses ORDER BY country`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reverse charge clauses: %w", err)
+	}
+	defer rows.Close()
+
+	var clauses []models.ReverseChargeClause
+	for rows.Next() {
+		var c models.ReverseChargeClause
+		if err := rows.Scan(&c.Country, &c.Text, &c.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan reverse charge clause: %w", err)
+		}
+		clauses = append(clauses, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	languages := make([]string, 0, len(defaultReverseChargeClauses))
+	for language := range defaultReverseChargeClauses {
+		languages = append(languages, language)
+	}
+	slices.Sort(languages)
+	for _, language := range languages {
+		clauses = append(clauses, models.ReverseChargeClause{
+			Language: language,
+			Text:     defaultReverseChargeClauses[language],
+			BuiltIn:  true,
+		})
+	}
+	return clauses, nil
+}
+
+// Clause returns the clause for a client: the one customized for the client's
+// country, or else the built-in clause in the client's language, falling back
+// to the default locale and finally to English
+func (s *ReverseChargeService) Clause(client *models.Client) (string, error) {
+	if country := clientCountryCode(client); country != "" {
+		var text string
+		err := s.dbService.GetDB().QueryRow(`SELECT text FROM reverse_charge_clauses WHERE country = ?`, country).Scan(&text)
+		if err == nil {
+			return text, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("failed to read reverse charge clause: %w", err)
+		}
+	}
+
+	languages := []string{client.Language}
+	if s.settingsService != nil {
+		languages = append(languages, s.settingsService.GetString(SettingLocale))
+	}
+	for _, language := range languages {
+		base, _, _ := strings.Cut(strings.ToLower(language), "-")
+		if text, ok := defaultReverseChargeClauses[base]; ok {
+			return text, nil
+		}
+	}
+	return defaultReverseChargeClauses[defaultReverseChargeLanguage], nil
+}
+
+// clientCountryCode returns the two-letter country code of a client, taken
+// from the country or else the prefix of the VAT ID, or an empty string
+func clientCountryCode(client *models.Client) string {
+	if country := strings.ToUpper(strings.TrimSpace(client.Country)); countryCodePattern.MatchString(country) {
+		return country
+	}
+	if vatID := strings.ToUpper(strings.TrimSpace(client.VatID)); len(vatID) > 2 && countryCodePattern.MatchString(vatID[:2]) {
+		return vatID[:2]
+	}
+	return ""
+}
+
+// Save stores a customized clause for clients in a country, replacing the
+// previous one
+func (s *ReverseChargeService) Save(c *models.ReverseChargeClause) error {
+	c.Country = strings.ToUpper(strings.TrimSpace(c.Country))
+	if !countryCodePattern.MatchString(c.Country) {
+		return fmt.Errorf("%q is not a two-letter country code like DE", c.Country)
+	}
+	c.Text = strings.TrimSpace(c.Text)
+	if c.Text == "" {
+		return errors.New("text is required")
+	}
+
+	c.Language = ""
+	c.BuiltIn = false
+	c.UpdatedAt = time.Now().UTC()
+	_, err := s.dbService.GetDB().Exec(`
+		INSERT INTO reverse_charge_clauses (country, text, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (country) DO UPDATE SET text = excluded.text, updated_at = excluded.updated_at
+	`, c.Country, c.Text, c.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save reverse charge clause: %w", err)
+	}
+
+	s.logger.Info("Saved reverse charge clause for country %s", c.Country)
+	return nil
+}
+
+// Delete removes the customized clause of a country; its clients then get the
+// built-in clause in their language again
+func (s *ReverseChargeService) Delete(country string) error {
+	result, err := s.dbService.GetDB().Exec(`DELETE FROM reverse_charge_clauses WHERE country = ?`, strings.ToUpper(country))
+	if err != nil {
+		return fmt.Errorf("failed to delete reverse charge clause: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrReverseChargeClauseNotFound
+	}
+
+	s.logger.Info("Deleted reverse charge clause for country %s", country)
+	return nil
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestReverseChargeClause(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	logger := NewLogger(ERROR)
+	clauses := NewReverseChargeService(dbService, NewSettingsService(dbService, logger), logger)
+
+	// The built-in clause follows the client's language
+	clause, err := clauses.Clause(&models.Client{Country: "AT", Language: "de-AT"})
+	if err != nil {
+		t.Fatalf("Clause failed: %v", err)
+	}
+	if clause != defaultReverseChargeClauses["de"] {
+		t.Errorf("Expected the German clause, got %q", clause)
+	}
+	if clause, _ := clauses.Clause(&models.Client{Language: "ja"}); clause != defaultReverseChargeClauses["en"] {
+		t.Errorf("Expected the English clause for a language without a clause, got %q", clause)
+	}
+
+	// A clause customized for a country takes precedence over the language
+	if err := clauses.Save(&models.ReverseChargeClause{Country: "at", Text: "Übergang der Steuerschuld"}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if clause, _ := clauses.Clause(&models.Client{Country: "AT", Language: "de"}); clause != "Übergang der Steuerschuld" {
+		t.Errorf("Expected the customized clause, got %q", clause)
+	}
+	if clause, _ := clauses.Clause(&models.Client{Country: "Austria", VatID: "ATU12345678"}); clause != "Übergang der Steuerschuld" {
+		t.Errorf("Expected the country of the VAT ID to be used, got %q", clause)
+	}
+
+	if err := clauses.Save(&models.ReverseChargeClause{Country: "Austria", Text: "x"}); err == nil {
+		t.Error("Expected an error for a country name")
+	}
+
+	list, err := clauses.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != len(defaultReverseChargeClauses)+1 || list[0].Country != "AT" || list[0].BuiltIn {
+		t.Errorf("Expected the customized clause first, got %+v", list)
+	}
+
+	if err := clauses.Delete("AT"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := clauses.Delete("AT"); !errors.Is(err, ErrReverseChargeClauseNotFound) {
+		t.Errorf("Expected ErrReverseChargeClauseNotFound, got %v", err)
+	}
+}
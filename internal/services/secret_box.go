@@ -0,0 +1,196 @@
+package services
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// encryptedSecretPrefix marks setting values encrypted by a SecretBox. The
+// version allows changing the format later.
+const encryptedSecretPrefix = "enc:v1:"
+
+// Name of the OS keyring entry holding the secrets key
+const (
+	keyringService = "simple-invoice"
+	keyringAccount = "secrets-key"
+)
+
+// ErrSecretUndecryptable is returned for encrypted settings when no key is
+// configured or the key is not the one they were encrypted with
+var ErrSecretUndecryptable = errors.New("secret cannot be decrypted with the configured key")
+
+// SecretBox encrypts secret settings with AES-256-GCM before they are stored
+// in the settings table
+type SecretBox struct {
+	aead   cipher.AEAD
+	source string // "environment" or "keyring"
+}
+
+// NewSecretBox creates a SecretBox from a key of any length; the AES key is
+// its SHA-256 hash
+func NewSecretBox(key string) (*SecretBox, error) {
+	if strings.TrimSpace(key) == "" {
+		return nil, fmt.Errorf("secrets key is empty")
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return &SecretBox{aead: aead, source: "environment"}, nil
+}
+
+// NewSecretBoxFromEnvironment creates the SecretBox configured with
+// SECRETS_KEY, or with a key kept in the OS keyring when SECRETS_KEYRING is
+// true. The keyring key is generated on first use. It returns nil without an
+// error when neither is set, in which case secrets are stored in plain text.
+func NewSecretBoxFromEnvironment() (*SecretBox, error) {
+	if key := os.Getenv("SECRETS_KEY"); key != "" {
+		return NewSecretBox(key)
+	}
+	useKeyring, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("SECRETS_KEYRING")))
+	if !useKeyring {
+		return nil, nil
+	}
+
+	key, err := keyringGet()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the secrets key from the OS keyring: %w", err)
+	}
+	if key == "" {
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			return nil, fmt.Errorf("failed to generate secrets key: %w", err)
+		}
+		key = base64.RawStdEncoding.EncodeToString(random)
+		if err := keyringSet(key); err != nil {
+			return nil, fmt.Errorf("failed to store the secrets key in the OS keyring: %w", err)
+		}
+	}
+	box, err := NewSecretBox(key)
+	if err != nil {
+		return nil, err
+	}
+	box.source = "keyring"
+	return box, nil
+}
+
+// Source returns where the key came from: "environment" or "keyring"
+func (b *SecretBox) Source() string {
+	return b.source
+}
+
+// Encrypt encrypts a value for storage. Empty values stay empty.
+func (b *SecretBox) Encrypt(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(value), nil)
+	return encryptedSecretPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value returned by Encrypt. Values without the encrypted
+// prefix are returned unchanged.
+func (b *SecretBox) Decrypt(stored string) (string, error) {
+	if !IsEncryptedSecret(stored) {
+		return stored, nil
+	}
+	if b == nil {
+		return "", ErrSecretUndecryptable
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, encryptedSecretPrefix))
+	if err != nil || len(sealed) < b.aead.NonceSize() {
+		return "", ErrSecretUndecryptable
+	}
+	nonce, ciphertext := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	plain, err := b.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrSecretUndecryptable
+	}
+	return string(plain), nil
+}
+
+// IsEncryptedSecret reports whether a stored value was encrypted by a SecretBox
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, encryptedSecretPrefix)
+}
+
+// MaskSecret returns a secret with all but its last four characters hidden,
+// so the user can tell which one is configured. Short secrets are hidden
+// completely.
+func MaskSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+	runes := []rune(value)
+	if len(runes) < 12 {
+		return "********"
+	}
+	return "********" + string(runes[len(runes)-4:])
+}
+
+// keyringGet reads the secrets key from the OS keyring with secret-tool
+// (Linux, libsecret) or security (macOS). It returns an empty key when there
+// is no entry yet.
+func keyringGet() (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd":
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", keyringAccount)
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", keyringAccount, "-w")
+	default:
+		return "", fmt.Errorf("the OS keyring is not supported on %s, set SECRETS_KEY instead", runtime.GOOS)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// secret-tool fails without a message, security with exit code 44, when there is no entry
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && (exitErr.ExitCode() == 44 || strings.TrimSpace(stderr.String()) == "") {
+			return "", nil
+		}
+		return "", fmt.Errorf("%s: %w %s", cmd.Path, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// keyringSet stores the secrets key in the OS keyring
+func keyringSet(key string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd":
+		cmd = exec.Command("secret-tool", "store", "--label=Simple Invoice secrets key", "service", keyringService, "account", keyringAccount)
+		cmd.Stdin = strings.NewReader(key)
+	case "darwin":
+		cmd = exec.Command("security", "add-generic-password", "-s", keyringService, "-a", keyringAccount, "-w", key)
+	default:
+		return fmt.Errorf("the OS keyring is not supported on %s, set SECRETS_KEY instead", runtime.GOOS)
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w %s", cmd.Path, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,645 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/refdata"
+	"github.com/robfig/cron/v3"
+)
+
+// Setting keys
+const (
+	SettingInvoiceDueDays  = "invoice.due_days"
+	SettingInvoiceVatRate  = "invoice.vat_rate"
+	SettingInvoiceCurrency = "invoice.currency"
+	SettingInvoiceNotes    = "invoice.notes"
+	SettingHomeCurrency    = "invoice.home_currency"
+	SettingBackupCron      = "backup.cron"
+	SettingSMTPHost        = "smtp.host"
+	SettingSMTPPort        = "smtp.port"
+	SettingSMTPUsername    = "smtp.username"
+	SettingSMTPPassword    = "smtp.password"
+	SettingSMTPFrom        = "smtp.from"
+	SettingSMTPFromName    = "smtp.from_name"
+	SettingSMTPReplyTo     = "smtp.reply_to"
+	SettingSMTPBCCSelf     = "smtp.bcc_self"
+	SettingLocale          = "general.locale"
+
+	SettingIMAPHost         = "imap.host"
+	SettingIMAPPort         = "imap.port"
+	SettingIMAPUsername     = "imap.username"
+	SettingIMAPPassword     = "imap.password"
+	SettingIMAPMailbox      = "imap.mailbox"
+	SettingIMAPPollInterval = "imap.poll_minutes"
+
+	SettingNotifyEvents      = "notify.events"
+	SettingTelegramBotToken  = "notify.telegram_bot_token"
+	SettingTelegramChatID    = "notify.telegram_chat_id"
+	SettingSlackWebhookURL   = "notify.slack_webhook_url"
+	SettingDiscordWebhookURL = "notify.discord_webhook_url"
+	SettingGotifyURL         = "notify.gotify_url"
+	SettingGotifyToken       = "notify.gotify_token"
+	SettingNtfyServer        = "notify.ntfy_server"
+	SettingNtfyTopic         = "notify.ntfy_topic"
+	SettingNtfyToken         = "notify.ntfy_token"
+
+	SettingPDFA                = "pdf.pdfa"
+	SettingPDFPreviewRetention = "pdf.preview_retention_hours"
+	SettingPDFRenderer         = "pdf.renderer"
+	SettingPDFHTMLConverter    = "pdf.html_converter"
+
+	SettingSigningCertPath     = "signing.cert_path"
+	SettingSigningCertPassword = "signing.cert_password"
+	SettingSigningReason       = "signing.reason"
+
+	SettingReportBasis = "report.basis"
+
+	SettingNominatimURL = "address.nominatim_url"
+
+	SettingHolidaysURL = "holidays.api_url"
+
+	SettingCompaniesHouseAPIKey = "companies_house.api_key"
+
+	SettingBankSyncURL       = "bank_sync.api_url"
+	SettingBankSyncSecretID  = "bank_sync.secret_id"
+	SettingBankSyncSecretKey = "bank_sync.secret_key"
+	SettingBankSyncInterval  = "bank_sync.interval_hours"
+
+	SettingPayPalEmail      = "paypal.email"
+	SettingPayPalMeUsername = "paypal.me_username"
+	SettingPayPalURL        = "paypal.url"
+
+	SettingAccountingProvider     = "accounting.provider"
+	SettingAccountingClientID     = "accounting.client_id"
+	SettingAccountingClientSecret = "accounting.client_secret"
+	SettingAccountingInterval     = "accounting.interval_hours"
+	SettingXeroSalesAccount       = "accounting.xero_sales_account"
+	SettingXeroPaymentAccount     = "accounting.xero_payment_account"
+	SettingQuickBooksItemID       = "accounting.quickbooks_item_id"
+	SettingAccountingAPIURL       = "accounting.api_url"
+	SettingAccountingTokenURL     = "accounting.token_url"
+
+	SettingDATEVConsultantNumber     = "datev.consultant_number"
+	SettingDATEVClientNumber         = "datev.client_number"
+	SettingDATEVRevenueAccount       = "datev.revenue_account"
+	SettingDATEVReverseChargeAccount = "datev.reverse_charge_account"
+	SettingDATEVTaxFreeAccount       = "datev.tax_free_account"
+	SettingDATEVBankAccount          = "datev.bank_account"
+
+	SettingFatturaPARegime = "fatturapa.regime"
+	SettingPECHost         = "fatturapa.pec_host"
+	SettingPECPort         = "fatturapa.pec_port"
+	SettingPECUsername     = "fatturapa.pec_username"
+	SettingPECPassword     = "fatturapa.pec_password"
+	SettingPECAddress      = "fatturapa.pec_address"
+	SettingSDIAddress      = "fatturapa.sdi_address"
+
+	SettingNAVLogin        = "nav.login"
+	SettingNAVPassword     = "nav.password"
+	SettingNAVSignatureKey = "nav.signature_key"
+	SettingNAVExchangeKey  = "nav.exchange_key"
+	SettingNAVTaxNumber    = "nav.tax_number"
+	SettingNAVReportFrom   = "nav.report_from"
+	SettingNAVAPIURL       = "nav.api_url"
+
+	SettingKSeFToken         = "ksef.token"
+	SettingKSeFPublicKeyPath = "ksef.public_key_path"
+	SettingKSeFFrom          = "ksef.from"
+	SettingKSeFAPIURL        = "ksef.api_url"
+
+	SettingVerifactuEnabled      = "verifactu.enabled"
+	SettingVerifactuCertPath     = "verifactu.cert_path"
+	SettingVerifactuCertPassword = "verifactu.cert_password"
+	SettingVerifactuFrom         = "verifactu.from"
+	SettingVerifactuAPIURL       = "verifactu.api_url"
+	SettingVerifactuQRURL        = "verifactu.qr_url"
+
+	SettingHookInvoiceCreate = "hooks.invoice_create"
+	SettingHookClientSave    = "hooks.client_save"
+	SettingHookPDFRender     = "hooks.pdf_render"
+	SettingHookTimeout       = "hooks.timeout_seconds"
+
+	// The key predates the setting, when LOG_LEVEL was only read at startup
+	SettingLogLevel = "server.log_level"
+)
+
+// Setting value types
+const (
+	SettingTypeString = "string"
+	SettingTypeInt    = "int"
+	SettingTypeFloat  = "float"
+	SettingTypeBool   = "bool"
+	SettingTypeCron   = "cron"
+)
+
+// SettingDefinition describes a runtime setting
+type SettingDefinition struct {
+	Key          string
+	Group        string
+	Label        string
+	Help         string
+	Type         string
+	DefaultValue string
+	EnvVar       string // Used when the setting has not been saved yet
+	Secret       bool   // Never sent back to the browser
+}
+
+// settingDefinitions lists all known settings in display order
+var settingDefinitions = []SettingDefinition{
+	{Key: SettingInvoiceDueDays, Group: "Invoice Defaults", Label: "Payment term (days)", Help: "Days between issue date and due date for new invoices", Type: SettingTypeInt, DefaultValue: "30", EnvVar: "INVOICE_DUE_DAYS"},
+	{Key: SettingInvoiceVatRate, Group: "Invoice Defaults", Label: "VAT rate (%)", Type: SettingTypeFloat, DefaultValue: "19", EnvVar: "INVOICE_VAT_RATE"},
+	{Key: SettingInvoiceCurrency, Group: "Invoice Defaults", Label: "Currency", Help: "Used until a client is selected", Type: SettingTypeString, DefaultValue: "EUR", EnvVar: "INVOICE_CURRENCY"},
+	{Key: SettingInvoiceNotes, Group: "Invoice Defaults", Label: "Notes", Help: "Pre-filled notes for new invoices", Type: SettingTypeString, EnvVar: "INVOICE_NOTES"},
+	{Key: SettingHomeCurrency, Group: "Invoice Defaults", Label: "Home currency", Help: "Invoices in another currency also show their totals in this currency at the ECB reference rate of the issue date. Leave empty to disable.", Type: SettingTypeString, EnvVar: "HOME_CURRENCY"},
+	{Key: SettingBackupCron, Group: "Backups", Label: "Backup schedule", Help: "Cron expression, e.g. 0 2 * * * for daily at 2 AM. Leave empty to disable automatic backups.", Type: SettingTypeCron, EnvVar: "BACKUP_CRON"},
+	{Key: SettingSMTPHost, Group: "Email (SMTP)", Label: "Host", Type: SettingTypeString, EnvVar: "SMTP_HOST"},
+	{Key: SettingSMTPPort, Group: "Email (SMTP)", Label: "Port", Type: SettingTypeInt, DefaultValue: "587", EnvVar: "SMTP_PORT"},
+	{Key: SettingSMTPUsername, Group: "Email (SMTP)", Label: "Username", Type: SettingTypeString, EnvVar: "SMTP_USERNAME"},
+	{Key: SettingSMTPPassword, Group: "Email (SMTP)", Label: "Password", Type: SettingTypeString, EnvVar: "SMTP_PASSWORD", Secret: true},
+	{Key: SettingSMTPFrom, Group: "Email (SMTP)", Label: "From address", Help: "Defaults to the business email", Type: SettingTypeString, EnvVar: "SMTP_FROM"},
+	{Key: SettingSMTPFromName, Group: "Email (SMTP)", Label: "From name", Help: "Defaults to the business name", Type: SettingTypeString, EnvVar: "SMTP_FROM_NAME"},
+	{Key: SettingSMTPReplyTo, Group: "Email (SMTP)", Label: "Reply-To address", Help: "Where client replies go. Defaults to the business email when it differs from the from address.", Type: SettingTypeString, EnvVar: "SMTP_REPLY_TO"},
+	{Key: SettingSMTPBCCSelf, Group: "Email (SMTP)", Label: "Send me a copy", Help: "BCC every email to the business email (or the from address)", Type: SettingTypeBool, DefaultValue: "false", EnvVar: "SMTP_BCC_SELF"},
+	{Key: SettingIMAPHost, Group: "Bounce Detection (IMAP)", Label: "Host", Help: "Mailbox that receives bounces of sent invoices. Leave empty to disable bounce detection.", Type: SettingTypeString, EnvVar: "IMAP_HOST"},
+	{Key: SettingIMAPPort, Group: "Bounce Detection (IMAP)", Label: "Port", Help: "993 connects with TLS, other ports use STARTTLS", Type: SettingTypeInt, DefaultValue: "993", EnvVar: "IMAP_PORT"},
+	{Key: SettingIMAPUsername, Group: "Bounce Detection (IMAP)", Label: "Username", Type: SettingTypeString, EnvVar: "IMAP_USERNAME"},
+	{Key: SettingIMAPPassword, Group: "Bounce Detection (IMAP)", Label: "Password", Type: SettingTypeString, EnvVar: "IMAP_PASSWORD", Secret: true},
+	{Key: SettingIMAPMailbox, Group: "Bounce Detection (IMAP)", Label: "Mailbox", Type: SettingTypeString, DefaultValue: "INBOX", EnvVar: "IMAP_MAILBOX"},
+	{Key: SettingIMAPPollInterval, Group: "Bounce Detection (IMAP)", Label: "Check every (minutes)", Type: SettingTypeInt, DefaultValue: "15", EnvVar: "IMAP_POLL_MINUTES"},
+	{Key: SettingNotifyEvents, Group: "Notifications", Label: "Events", Help: "Comma-separated: invoice.viewed, invoice.paid, invoice.overdue, backup.failed, login.new_device", Type: SettingTypeString, DefaultValue: "invoice.viewed,invoice.paid,invoice.overdue,backup.failed,login.new_device", EnvVar: "NOTIFY_EVENTS"},
+	{Key: SettingTelegramBotToken, Group: "Notifications", Label: "Telegram bot token", Type: SettingTypeString, EnvVar: "TELEGRAM_BOT_TOKEN", Secret: true},
+	{Key: SettingTelegramChatID, Group: "Notifications", Label: "Telegram chat ID", Type: SettingTypeString, EnvVar: "TELEGRAM_CHAT_ID"},
+	{Key: SettingSlackWebhookURL, Group: "Notifications", Label: "Slack webhook URL", Type: SettingTypeString, EnvVar: "SLACK_WEBHOOK_URL", Secret: true},
+	{Key: SettingDiscordWebhookURL, Group: "Notifications", Label: "Discord webhook URL", Type: SettingTypeString, EnvVar: "DISCORD_WEBHOOK_URL", Secret: true},
+	{Key: SettingGotifyURL, Group: "Notifications", Label: "Gotify server URL", Help: "e.g. https://gotify.example.com", Type: SettingTypeString, EnvVar: "GOTIFY_URL"},
+	{Key: SettingGotifyToken, Group: "Notifications", Label: "Gotify application token", Type: SettingTypeString, EnvVar: "GOTIFY_TOKEN", Secret: true},
+	{Key: SettingNtfyServer, Group: "Notifications", Label: "ntfy server URL", Type: SettingTypeString, DefaultValue: "https://ntfy.sh", EnvVar: "NTFY_SERVER"},
+	{Key: SettingNtfyTopic, Group: "Notifications", Label: "ntfy topic", Type: SettingTypeString, EnvVar: "NTFY_TOPIC"},
+	{Key: SettingNtfyToken, Group: "Notifications", Label: "ntfy access token", Help: "Only needed for protected topics", Type: SettingTypeString, EnvVar: "NTFY_TOKEN", Secret: true},
+	{Key: SettingLocale, Group: "General", Label: "Locale", Help: "Language and region, e.g. en-US or de-DE", Type: SettingTypeString, DefaultValue: "en-US", EnvVar: "LOCALE"},
+	{Key: SettingPDFA, Group: "PDF Output", Label: "PDF/A-3 compliance", Help: "Embed fonts, a colour profile and XMP metadata so invoices are accepted by long-term archiving systems", Type: SettingTypeBool, DefaultValue: "false", EnvVar: "PDFA"},
+	{Key: SettingPDFPreviewRetention, Group: "PDF Output", Label: "Keep previews (hours)", Help: "Preview PDFs older than this are deleted", Type: SettingTypeInt, DefaultValue: "24", EnvVar: "PREVIEW_RETENTION_HOURS"},
+	{Key: SettingPDFRenderer, Group: "PDF Output", Label: "Renderer", Help: "builtin draws invoices with the built-in layout; html renders DATA_DIR/pdf-templates/invoice.html with headless Chromium or wkhtmltopdf", Type: SettingTypeString, DefaultValue: PDFRendererBuiltin, EnvVar: "PDF_RENDERER"},
+	{Key: SettingPDFHTMLConverter, Group: "PDF Output", Label: "HTML converter", Help: "chromium or wkhtmltopdf. Leave empty to use whichever is installed.", Type: SettingTypeString, EnvVar: "PDF_HTML_CONVERTER"},
+	{Key: SettingSigningCertPath, Group: "Digital Signature", Label: "Certificate file", Help: "Path to a PKCS#12 (.p12/.pfx) file on the server. Generated PDFs are signed when set.", Type: SettingTypeString, EnvVar: "SIGNING_CERT_PATH"},
+	{Key: SettingSigningCertPassword, Group: "Digital Signature", Label: "Certificate password", Type: SettingTypeString, EnvVar: "SIGNING_CERT_PASSWORD", Secret: true},
+	{Key: SettingSigningReason, Group: "Digital Signature", Label: "Reason", Help: "Shown in the signature details of PDF readers", Type: SettingTypeString, DefaultValue: "Invoice issued", EnvVar: "SIGNING_REASON"},
+	{Key: SettingReportBasis, Group: "Reports", Label: "Accounting basis", Help: "accrual counts invoices when issued, cash when paid", Type: SettingTypeString, DefaultValue: ReportBasisAccrual, EnvVar: "REPORT_BASIS"},
+	{Key: SettingNominatimURL, Group: "Address Lookup", Label: "Nominatim server", Help: "OpenStreetMap Nominatim server that suggests addresses while entering a client. Leave empty to disable address suggestions.", Type: SettingTypeString, DefaultValue: "https://nominatim.openstreetmap.org", EnvVar: "NOMINATIM_URL"},
+
+	{Key: SettingHolidaysURL, Group: "Public Holidays", Label: "Holiday server", Help: "Nager.Date server the public holidays taken off by a business are downloaded from. Leave empty to only use holidays downloaded before.", Type: SettingTypeString, DefaultValue: "https://date.nager.at", EnvVar: "HOLIDAYS_API_URL"},
+	{Key: SettingCompaniesHouseAPIKey, Group: "Company Lookup", Label: "Companies House API key", Help: "Needed to look up UK companies", Type: SettingTypeString, EnvVar: "COMPANIES_HOUSE_API_KEY", Secret: true},
+	{Key: SettingBankSyncSecretID, Group: "Bank Sync (GoCardless)", Label: "Secret ID", Help: "User secret of a GoCardless Bank Account Data account. Incoming payments of the banks connected on the Reconcile Payments page are synced when set.", Type: SettingTypeString, EnvVar: "GOCARDLESS_SECRET_ID"},
+	{Key: SettingBankSyncSecretKey, Group: "Bank Sync (GoCardless)", Label: "Secret key", Type: SettingTypeString, EnvVar: "GOCARDLESS_SECRET_KEY", Secret: true},
+	{Key: SettingBankSyncInterval, Group: "Bank Sync (GoCardless)", Label: "Sync every (hours)", Help: "Banks allow about four syncs a day", Type: SettingTypeInt, DefaultValue: "6", EnvVar: "BANK_SYNC_INTERVAL_HOURS"},
+	{Key: SettingBankSyncURL, Group: "Bank Sync (GoCardless)", Label: "API server", Type: SettingTypeString, DefaultValue: "https://bankaccountdata.gocardless.com", EnvVar: "GOCARDLESS_API_URL"},
+	{Key: SettingPayPalEmail, Group: "PayPal", Label: "PayPal account email", Help: "Account invoices with a PayPal checkout link are paid to. Set the IPN notification URL of the account to /api/paypal/ipn on this server, so paid invoices are marked paid.", Type: SettingTypeString, EnvVar: "PAYPAL_EMAIL"},
+	{Key: SettingPayPalMeUsername, Group: "PayPal", Label: "PayPal.Me name", Help: "Name in your paypal.me link, for invoices with a PayPal.Me link. Mark these invoices paid yourself.", Type: SettingTypeString, EnvVar: "PAYPAL_ME_USERNAME"},
+	{Key: SettingPayPalURL, Group: "PayPal", Label: "PayPal server", Help: "https://www.sandbox.paypal.com to test with the PayPal sandbox", Type: SettingTypeString, DefaultValue: "https://www.paypal.com", EnvVar: "PAYPAL_URL"},
+	{Key: SettingAccountingProvider, Group: "Accounting Sync", Label: "Accounting software", Help: "xero or quickbooks. Finalized invoices and their payments are pushed to the organization connected below. Leave empty to disable.", Type: SettingTypeString, EnvVar: "ACCOUNTING_PROVIDER"},
+	{Key: SettingAccountingClientID, Group: "Accounting Sync", Label: "OAuth client ID", Help: "Of an app registered with the provider, with /accounting/callback on this server as its redirect URI", Type: SettingTypeString, EnvVar: "ACCOUNTING_CLIENT_ID"},
+	{Key: SettingAccountingClientSecret, Group: "Accounting Sync", Label: "OAuth client secret", Type: SettingTypeString, EnvVar: "ACCOUNTING_CLIENT_SECRET", Secret: true},
+	{Key: SettingAccountingInterval, Group: "Accounting Sync", Label: "Sync every (hours)", Type: SettingTypeInt, DefaultValue: "1", EnvVar: "ACCOUNTING_SYNC_INTERVAL_HOURS"},
+	{Key: SettingXeroSalesAccount, Group: "Accounting Sync", Label: "Xero sales account", Help: "Code of the revenue account invoice lines are booked to; its default tax rate applies", Type: SettingTypeString, DefaultValue: "200", EnvVar: "XERO_SALES_ACCOUNT"},
+	{Key: SettingXeroPaymentAccount, Group: "Accounting Sync", Label: "Xero payment account", Help: "Code of the bank account payments are booked to", Type: SettingTypeString, DefaultValue: "090", EnvVar: "XERO_PAYMENT_ACCOUNT"},
+	{Key: SettingQuickBooksItemID, Group: "Accounting Sync", Label: "QuickBooks item ID", Help: "Product or service invoice lines are booked as; its income account and tax code apply", Type: SettingTypeString, DefaultValue: "1", EnvVar: "QUICKBOOKS_ITEM_ID"},
+	{Key: SettingAccountingAPIURL, Group: "Accounting Sync", Label: "API server", Help: "Leave empty for the provider's server; https://sandbox-quickbooks.api.intuit.com for a QuickBooks sandbox company", Type: SettingTypeString, EnvVar: "ACCOUNTING_API_URL"},
+	{Key: SettingAccountingTokenURL, Group: "Accounting Sync", Label: "OAuth token endpoint", Help: "Leave empty for the provider's endpoint", Type: SettingTypeString, EnvVar: "ACCOUNTING_TOKEN_URL"},
+	{Key: SettingDATEVConsultantNumber, Group: "DATEV Export", Label: "Consultant number", Help: "Beraternummer of your tax advisor, required by DATEV to import the export", Type: SettingTypeString, EnvVar: "DATEV_CONSULTANT_NUMBER"},
+	{Key: SettingDATEVClientNumber, Group: "DATEV Export", Label: "Client number", Help: "Your Mandantennummer at the tax advisor", Type: SettingTypeString, EnvVar: "DATEV_CLIENT_NUMBER"},
+	{Key: SettingDATEVRevenueAccount, Group: "DATEV Export", Label: "Revenue account", Help: "Invoices with VAT are booked to it; the default is the SKR03 account for 19% VAT", Type: SettingTypeString, DefaultValue: "8400", EnvVar: "DATEV_REVENUE_ACCOUNT"},
+	{Key: SettingDATEVReverseChargeAccount, Group: "DATEV Export", Label: "Reverse charge account", Help: "For reverse charge invoices to other EU countries", Type: SettingTypeString, DefaultValue: "8338", EnvVar: "DATEV_REVERSE_CHARGE_ACCOUNT"},
+	{Key: SettingDATEVTaxFreeAccount, Group: "DATEV Export", Label: "Tax-free revenue account", Help: "For other invoices without VAT, such as those of small businesses", Type: SettingTypeString, DefaultValue: "8195", EnvVar: "DATEV_TAX_FREE_ACCOUNT"},
+	{Key: SettingDATEVBankAccount, Group: "DATEV Export", Label: "Bank account", Help: "Payments received are booked to it", Type: SettingTypeString, DefaultValue: "1200", EnvVar: "DATEV_BANK_ACCOUNT"},
+	{Key: SettingFatturaPARegime, Group: "FatturaPA (Italy)", Label: "Tax regime", Help: "RegimeFiscale of the business, e.g. RF01 for the ordinary regime or RF19 for the flat-rate regime (forfettario). Leave empty to use RF19 for VAT exempt businesses and RF01 otherwise.", Type: SettingTypeString, EnvVar: "FATTURAPA_REGIME_FISCALE"},
+	{Key: SettingPECHost, Group: "FatturaPA (Italy)", Label: "PEC server", Help: "SMTP server of the certified email (PEC) mailbox FatturaPA files are sent to SDI from. Leave empty to only download the files, e.g. for an intermediary.", Type: SettingTypeString, EnvVar: "PEC_SMTP_HOST"},
+	{Key: SettingPECPort, Group: "FatturaPA (Italy)", Label: "PEC port", Type: SettingTypeInt, DefaultValue: "465", EnvVar: "PEC_SMTP_PORT"},
+	{Key: SettingPECUsername, Group: "FatturaPA (Italy)", Label: "PEC username", Type: SettingTypeString, EnvVar: "PEC_USERNAME"},
+	{Key: SettingPECPassword, Group: "FatturaPA (Italy)", Label: "PEC password", Type: SettingTypeString, EnvVar: "PEC_PASSWORD", Secret: true},
+	{Key: SettingPECAddress, Group: "FatturaPA (Italy)", Label: "PEC address", Help: "Defaults to the username", Type: SettingTypeString, EnvVar: "PEC_ADDRESS"},
+	{Key: SettingSDIAddress, Group: "FatturaPA (Italy)", Label: "SDI address", Help: "SDI answers the first file with the PEC address to send later files to", Type: SettingTypeString, DefaultValue: "sdi01@pec.fatturapa.it", EnvVar: "SDI_PEC_ADDRESS"},
+	{Key: SettingNAVLogin, Group: "NAV Online Számla (Hungary)", Label: "Technical user", Help: "Login of the technical user created in the NAV Online Számla portal. Invoices of businesses with a HU VAT ID are reported once it is set.", Type: SettingTypeString, EnvVar: "NAV_LOGIN"},
+	{Key: SettingNAVPassword, Group: "NAV Online Számla (Hungary)", Label: "Password", Type: SettingTypeString, EnvVar: "NAV_PASSWORD", Secret: true},
+	{Key: SettingNAVSignatureKey, Group: "NAV Online Számla (Hungary)", Label: "XML signing key", Type: SettingTypeString, EnvVar: "NAV_SIGNATURE_KEY", Secret: true},
+	{Key: SettingNAVExchangeKey, Group: "NAV Online Számla (Hungary)", Label: "XML exchange key", Type: SettingTypeString, EnvVar: "NAV_EXCHANGE_KEY", Secret: true},
+	{Key: SettingNAVTaxNumber, Group: "NAV Online Számla (Hungary)", Label: "Tax number", Help: "Hungarian tax number (adószám) like 12345678-2-42. Leave empty to use the digits of the HU VAT ID.", Type: SettingTypeString, EnvVar: "NAV_TAX_NUMBER"},
+	{Key: SettingNAVReportFrom, Group: "NAV Online Számla (Hungary)", Label: "Report invoices issued from", Help: "YYYY-MM-DD. Invoices issued earlier are not reported. Set to the day the technical user is first used when left empty.", Type: SettingTypeString, EnvVar: "NAV_REPORT_FROM"},
+	{Key: SettingNAVAPIURL, Group: "NAV Online Számla (Hungary)", Label: "API server", Help: "https://api-test.onlineszamla.nav.gov.hu/invoiceService/v3 for the test system", Type: SettingTypeString, DefaultValue: "https://api.onlineszamla.nav.gov.hu/invoiceService/v3", EnvVar: "NAV_API_URL"},
+	{Key: SettingKSeFToken, Group: "KSeF (Poland)", Label: "Authorization token", Help: "Token generated in the KSeF application for the NIP of the business. Invoices of businesses with a PL VAT ID are sent to KSeF once it and the public key are set.", Type: SettingTypeString, EnvVar: "KSEF_TOKEN", Secret: true},
+	{Key: SettingKSeFPublicKeyPath, Group: "KSeF (Poland)", Label: "KSeF public key", Help: "Path to the PEM file of the public key the Ministry of Finance publishes for the KSeF environment; the token is encrypted with it", Type: SettingTypeString, EnvVar: "KSEF_PUBLIC_KEY_PATH"},
+	{Key: SettingKSeFFrom, Group: "KSeF (Poland)", Label: "Send invoices issued from", Help: "YYYY-MM-DD. Invoices issued earlier are not sent. Set to the day the token is first used when left empty.", Type: SettingTypeString, EnvVar: "KSEF_FROM"},
+	{Key: SettingKSeFAPIURL, Group: "KSeF (Poland)", Label: "API server", Help: "https://ksef-test.mf.gov.pl/api for the test system", Type: SettingTypeString, DefaultValue: "https://ksef.mf.gov.pl/api", EnvVar: "KSEF_API_URL"},
+	{Key: SettingVerifactuEnabled, Group: "Verifactu (Spain)", Label: "Verifactu", Help: "Chain the invoices of businesses with an ES VAT ID into billing records, print their QR code and send the records to AEAT", Type: SettingTypeBool, DefaultValue: "false", EnvVar: "VERIFACTU_ENABLED"},
+	{Key: SettingVerifactuCertPath, Group: "Verifactu (Spain)", Label: "Certificate file", Help: "Path to the PKCS#12 (.p12/.pfx) electronic certificate of the business, or of its representative, the records are sent to AEAT with", Type: SettingTypeString, EnvVar: "VERIFACTU_CERT_PATH"},
+	{Key: SettingVerifactuCertPassword, Group: "Verifactu (Spain)", Label: "Certificate password", Type: SettingTypeString, EnvVar: "VERIFACTU_CERT_PASSWORD", Secret: true},
+	{Key: SettingVerifactuFrom, Group: "Verifactu (Spain)", Label: "Records for invoices issued from", Help: "YYYY-MM-DD. Invoices issued earlier get no record. Set to the day Verifactu is enabled when left empty.", Type: SettingTypeString, EnvVar: "VERIFACTU_FROM"},
+	{Key: SettingVerifactuAPIURL, Group: "Verifactu (Spain)", Label: "AEAT service", Help: "https://prewww1.aeat.es/wlpl/TIKE-CONT/ws/SistemaFacturacion/VerifactuSOAP for the test system", Type: SettingTypeString, DefaultValue: "https://www1.agenciatributaria.gob.es/wlpl/TIKE-CONT/ws/SistemaFacturacion/VerifactuSOAP", EnvVar: "VERIFACTU_API_URL"},
+	{Key: SettingVerifactuQRURL, Group: "Verifactu (Spain)", Label: "QR code URL", Help: "https://prewww2.aeat.es/wlpl/TIKE-CONT/ValidarQR for the test system", Type: SettingTypeString, DefaultValue: "https://www2.agenciatributaria.gob.es/wlpl/TIKE-CONT/ValidarQR", EnvVar: "VERIFACTU_QR_URL"},
+	{Key: SettingHookInvoiceCreate, Group: "Hooks", Label: "On invoice create", Help: "Script in DATA_DIR/hooks called before a new invoice is saved; it can set the invoice number or reject the invoice. http(s) URLs can only be set in the environment or config file.", Type: SettingTypeString, EnvVar: "HOOK_INVOICE_CREATE"},
+	{Key: SettingHookClientSave, Group: "Hooks", Label: "On client save", Help: "Script in DATA_DIR/hooks called before a client is saved; it can reject the client. http(s) URLs can only be set in the environment or config file.", Type: SettingTypeString, EnvVar: "HOOK_CLIENT_SAVE"},
+	{Key: SettingHookPDFRender, Group: "Hooks", Label: "On PDF render", Help: "Script in DATA_DIR/hooks called after an invoice PDF is generated. http(s) URLs can only be set in the environment or config file.", Type: SettingTypeString, EnvVar: "HOOK_PDF_RENDER"},
+	{Key: SettingHookTimeout, Group: "Hooks", Label: "Timeout (seconds)", Type: SettingTypeInt, DefaultValue: "10", EnvVar: "HOOK_TIMEOUT_SECONDS"},
+	{Key: SettingLogLevel, Group: "Logging", Label: "Log level", Help: "DEBUG, INFO, WARN, ERROR or FATAL. DEBUG logs request details and is meant for troubleshooting. Applied at once, without a restart.", Type: SettingTypeString, DefaultValue: "INFO", EnvVar: "LOG_LEVEL"},
+}
+
+var localePattern = regexp.MustCompile(`^[a-z]{2}(-[A-Z]{2})?$`)
+
+// SettingValue is a setting with its effective value, as shown on the settings page
+type SettingValue struct {
+	SettingDefinition
+	Value     string // Empty for secrets
+	Masked    string // Secrets with all but their last characters hidden
+	IsSet     bool   // Whether a value is configured (saved or from the environment)
+	Source    string // "database", "environment" or "default"
+	Encrypted bool   // Whether a saved secret is stored encrypted
+}
+
+// SettingsService stores runtime settings in the settings table. Settings that
+// have not been saved fall back to their environment variable and then to the
+// built-in default. Secrets are stored encrypted when a SecretBox is set.
+type SettingsService struct {
+	dbService *DBService
+	logger    *Logger
+	secrets   *SecretBox
+}
+
+// NewSettingsService creates a new SettingsService
+func NewSettingsService(dbService *DBService, logger *Logger) *SettingsService {
+	return &SettingsService{
+		dbService: dbService,
+		logger:    logger,
+	}
+}
+
+// LogLevel returns the configured log level, INFO when it is not valid, and
+// whether it was saved on the settings page rather than set in the environment
+func (s *SettingsService) LogLevel() (LogLevel, bool) {
+	value, source := s.lookup(SettingLogLevel)
+	level, ok := ParseLogLevel(value)
+	if !ok {
+		return INFO, false
+	}
+	return level, source == "database"
+}
+
+// SetSecretBox sets the key secret settings are encrypted with when saved
+func (s *SettingsService) SetSecretBox(box *SecretBox) {
+	s.secrets = box
+}
+
+// EncryptsSecrets reports whether secret settings are stored encrypted
+func (s *SettingsService) EncryptsSecrets() bool {
+	return s.secrets != nil
+}
+
+// EncryptStoredSecrets encrypts secret settings saved in plain text before a
+// key was configured. It returns the number of settings encrypted and fails
+// if saved secrets cannot be decrypted with the key.
+func (s *SettingsService) EncryptStoredSecrets() (int, error) {
+	if s.secrets == nil {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := s.dbService.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	encrypted := 0
+	for _, def := range settingDefinitions {
+		if !def.Secret {
+			continue
+		}
+		var value string
+		err := tx.QueryRowContext(ctx, `SELECT value FROM settings WHERE key = ?`, def.Key).Scan(&value)
+		if err == sql.ErrNoRows || value == "" {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read setting %s: %w", def.Key, err)
+		}
+		if IsEncryptedSecret(value) {
+			if _, err := s.secrets.Decrypt(value); err != nil {
+				return 0, fmt.Errorf("setting %s: %w", def.Key, err)
+			}
+			continue
+		}
+		sealed, err := s.secrets.Encrypt(value)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE settings SET value = ? WHERE key = ?`, sealed, def.Key); err != nil {
+			return 0, fmt.Errorf("failed to save setting %s: %w", def.Key, err)
+		}
+		encrypted++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit settings: %w", err)
+	}
+	return encrypted, nil
+}
+
+// seal encrypts a secret stored outside the settings, such as an OAuth token,
+// when secrets are stored encrypted
+func (s *SettingsService) seal(value string) (string, error) {
+	if s.secrets == nil {
+		return value, nil
+	}
+	return s.secrets.Encrypt(value)
+}
+
+// open decrypts a value returned by seal
+func (s *SettingsService) open(stored string) (string, error) {
+	return s.secrets.Decrypt(stored)
+}
+
+// Definitions returns all known settings in display order
+func (s *SettingsService) Definitions() []SettingDefinition {
+	return settingDefinitions
+}
+
+// lookup returns the effective value of a setting and where it came from
+func (s *SettingsService) lookup(key string) (string, string) {
+	def, ok := findSettingDefinition(key)
+	if !ok {
+		return "", "default"
+	}
+
+	var value string
+	err := s.dbService.GetDB().QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&value)
+	if err == nil {
+		plain, err := s.secrets.Decrypt(value)
+		if err != nil {
+			s.logger.Error("Failed to read setting %s: %v", key, err)
+		}
+		return plain, "database"
+	}
+	if err != sql.ErrNoRows {
+		s.logger.Error("Failed to read setting %s: %v", key, err)
+	}
+
+	if def.EnvVar != "" {
+		if env, ok := os.LookupEnv(def.EnvVar); ok {
+			return env, "environment"
+		}
+	}
+	return def.DefaultValue, "default"
+}
+
+// GetString returns the effective value of a setting
+func (s *SettingsService) GetString(key string) string {
+	value, _ := s.lookup(key)
+	return value
+}
+
+// GetInt returns a setting as an integer, falling back to the default if the stored value is invalid
+func (s *SettingsService) GetInt(key string) int {
+	value, _ := s.lookup(key)
+	if i, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		return i
+	}
+	def, _ := findSettingDefinition(key)
+	i, _ := strconv.Atoi(def.DefaultValue)
+	return i
+}
+
+// GetFloat returns a setting as a float, falling back to the default if the stored value is invalid
+func (s *SettingsService) GetFloat(key string) float64 {
+	value, _ := s.lookup(key)
+	if f, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+		return f
+	}
+	def, _ := findSettingDefinition(key)
+	f, _ := strconv.ParseFloat(def.DefaultValue, 64)
+	return f
+}
+
+// GetBool returns a setting as a boolean
+func (s *SettingsService) GetBool(key string) bool {
+	value, _ := s.lookup(key)
+	b, _ := strconv.ParseBool(strings.TrimSpace(value))
+	return b
+}
+
+// All returns every setting with its effective value. Secret values are never included.
+func (s *SettingsService) All() []SettingValue {
+	values := make([]SettingValue, 0, len(settingDefinitions))
+	for _, def := range settingDefinitions {
+		value, source := s.lookup(def.Key)
+		sv := SettingValue{SettingDefinition: def, Value: value, IsSet: value != "", Source: source}
+		if def.Secret {
+			sv.Value = ""
+			sv.Masked = MaskSecret(value)
+			if source == "database" {
+				sv.Encrypted = s.storedEncrypted(def.Key)
+				// A secret that cannot be decrypted is still configured
+				sv.IsSet = sv.IsSet || sv.Encrypted
+			}
+		}
+		values = append(values, sv)
+	}
+	return values
+}
+
+// storedEncrypted reports whether the saved value of a setting is encrypted
+func (s *SettingsService) storedEncrypted(key string) bool {
+	var value string
+	if err := s.dbService.GetDB().QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&value); err != nil {
+		return false
+	}
+	return IsEncryptedSecret(value)
+}
+
+// Set validates and saves a single setting
+func (s *SettingsService) Set(key, value string) error {
+	return s.SetMany(map[string]string{key: value})
+}
+
+// SetMany validates and saves several settings in one transaction. Nothing is saved if any value is invalid.
+func (s *SettingsService) SetMany(values map[string]string) error {
+	normalized := make(map[string]string, len(values))
+	for key, value := range values {
+		def, ok := findSettingDefinition(key)
+		if !ok {
+			return fmt.Errorf("unknown setting: %s", key)
+		}
+		value = strings.TrimSpace(value)
+		if err := validateSetting(def, value); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", def.Label, err)
+		}
+		if isHookURLSetting(key, value) {
+			return fmt.Errorf("invalid value for %s: URL hooks can only be set with %s in the environment or config file", def.Label, def.EnvVar)
+		}
+		if def.Secret && s.secrets != nil {
+			sealed, err := s.secrets.Encrypt(value)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt %s: %w", def.Label, err)
+			}
+			value = sealed
+		}
+		normalized[key] = value
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := s.dbService.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for key, value := range normalized {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO settings (key, value, updated_at) VALUES (?, ?, ?)
+			ON CONFLICT (key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at
+		`, key, value, now)
+		if err != nil {
+			return fmt.Errorf("failed to save setting %s: %w", key, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit settings: %w", err)
+	}
+
+	s.logger.Info("Saved %d settings", len(normalized))
+	return nil
+}
+
+// validateSetting checks that a value can be parsed as the setting's type
+func validateSetting(def SettingDefinition, value string) error {
+	if value == "" {
+		return nil
+	}
+
+	switch def.Type {
+	case SettingTypeInt:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", value)
+		}
+		if i < 0 {
+			return fmt.Errorf("must not be negative")
+		}
+	case SettingTypeFloat:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		if f < 0 {
+			return fmt.Errorf("must not be negative")
+		}
+	case SettingTypeBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+	case SettingTypeCron:
+		if _, err := cron.ParseStandard(value); err != nil {
+			return fmt.Errorf("invalid cron expression: %w", err)
+		}
+	}
+
+	if def.Key == SettingLocale && !localePattern.MatchString(value) {
+		return fmt.Errorf("%q is not a locale like en-US", value)
+	}
+	if (def.Key == SettingHomeCurrency || def.Key == SettingInvoiceCurrency) && (value != strings.ToUpper(value) || !refdata.IsCurrencyCode(value)) {
+		return fmt.Errorf("%q is not an ISO 4217 currency code like EUR", value)
+	}
+	if def.Key == SettingLogLevel {
+		if _, ok := ParseLogLevel(value); !ok {
+			return fmt.Errorf("%q is not one of %s", value, strings.Join(logLevelNames, ", "))
+		}
+	}
+	if def.Key == SettingReportBasis && !slices.Contains(ReportBases, value) {
+		return fmt.Errorf("%q is not accrual or cash", value)
+	}
+	if def.Key == SettingAccountingProvider && !slices.Contains(AccountingProviders, value) {
+		return fmt.Errorf("%q is not xero or quickbooks", value)
+	}
+	if strings.HasPrefix(def.Key, "datev.") && strings.Trim(value, "0123456789") != "" {
+		return fmt.Errorf("%q is not a number", value)
+	}
+	if def.Key == SettingPDFRenderer && !slices.Contains(PDFRenderers, value) {
+		return fmt.Errorf("%q is not builtin or html", value)
+	}
+	if def.Key == SettingPDFHTMLConverter && !slices.Contains(HTMLConverters, value) {
+		return fmt.Errorf("%q is not chromium or wkhtmltopdf", value)
+	}
+	if def.Key == SettingFatturaPARegime && !fatturaPARegimePattern.MatchString(value) {
+		return fmt.Errorf("%q is not a tax regime like RF01", value)
+	}
+	if def.Key == SettingNAVExchangeKey && len(value) != 16 {
+		return fmt.Errorf("the exchange key has 16 characters")
+	}
+	if def.Key == SettingNAVTaxNumber && !navTaxNumberPattern.MatchString(value) {
+		return fmt.Errorf("%q is not a tax number like 12345678-2-42", value)
+	}
+	if def.Key == SettingNAVReportFrom || def.Key == SettingKSeFFrom || def.Key == SettingVerifactuFrom {
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return fmt.Errorf("%q is not a date like 2024-01-31", value)
+		}
+	}
+	if def.Key == SettingSMTPFrom || def.Key == SettingSMTPReplyTo || def.Key == SettingPECAddress || def.Key == SettingSDIAddress {
+		if _, err := mail.ParseAddress(value); err != nil {
+			return fmt.Errorf("%q is not an email address", value)
+		}
+	}
+	if def.Key == SettingNotifyEvents {
+		for _, event := range splitList(value) {
+			if !slices.Contains(NotificationEvents, event) {
+				return fmt.Errorf("unknown event %q", event)
+			}
+		}
+	}
+	if def.Key == SettingSlackWebhookURL || def.Key == SettingDiscordWebhookURL {
+		if u, err := url.Parse(value); err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("%q is not an https URL", value)
+		}
+	}
+	if def.Key == SettingHookInvoiceCreate || def.Key == SettingHookClientSave || def.Key == SettingHookPDFRender {
+		if _, _, err := parseHookTarget(value); err != nil {
+			return err
+		}
+	}
+	// Self-hosted servers are often only reachable over plain HTTP on the local network
+	if def.Key == SettingGotifyURL || def.Key == SettingNtfyServer || def.Key == SettingNominatimURL || def.Key == SettingHolidaysURL {
+		if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%q is not an http or https URL", value)
+		}
+	}
+	if def.Key == SettingNtfyTopic && strings.ContainsAny(value, "/?# ") {
+		return fmt.Errorf("%q is not a topic name", value)
+	}
+	if def.Key == SettingSigningCertPath || def.Key == SettingKSeFPublicKeyPath || def.Key == SettingVerifactuCertPath {
+		if info, err := os.Stat(value); err != nil || info.IsDir() {
+			return fmt.Errorf("%q is not a readable file", value)
+		}
+	}
+	return nil
+}
+
+func findSettingDefinition(key string) (SettingDefinition, bool) {
+	for _, def := range settingDefinitions {
+		if def.Key == key {
+			return def, true
+		}
+	}
+	return SettingDefinition{}, false
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"testing"
+)
+
+func TestSettingsServiceFallbacks(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	settings := NewSettingsService(dbService, NewLogger(ERROR))
+
+	// Built-in default
+	if days := settings.GetInt(SettingInvoiceDueDays); days != 30 {
+		t.Errorf("Expected default due days 30, got %d", days)
+	}
+
+	// Environment variable is used until a value is saved
+	t.Setenv("BACKUP_CRON", "0 3 * * *")
+	if cron := settings.GetString(SettingBackupCron); cron != "0 3 * * *" {
+		t.Errorf("Expected BACKUP_CRON fallback, got %q", cron)
+	}
+
+	if err := settings.SetMany(map[string]string{
+		SettingBackupCron:     "0 2 * * *",
+		SettingInvoiceVatRate: " 21.5 ",
+	}); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+	if cron := settings.GetString(SettingBackupCron); cron != "0 2 * * *" {
+		t.Errorf("Expected saved value to override the environment, got %q", cron)
+	}
+	if rate := settings.GetFloat(SettingInvoiceVatRate); rate != 21.5 {
+		t.Errorf("Expected VAT rate 21.5, got %v", rate)
+	}
+}
+
+func TestSettingsServiceValidation(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	settings := NewSettingsService(dbService, NewLogger(ERROR))
+
+	invalid := []map[string]string{
+		{"unknown.key": "x"},
+		{SettingInvoiceDueDays: "soon"},
+		{SettingInvoiceDueDays: "-1"},
+		{SettingBackupCron: "every day"},
+		{SettingLocale: "english"},
+		// One bad value must prevent the whole batch from being saved
+		{SettingInvoiceCurrency: "USD", SettingSMTPPort: "abc"},
+	}
+	for _, values := range invalid {
+		if err := settings.SetMany(values); err == nil {
+			t.Errorf("Expected %v to be rejected", values)
+		}
+	}
+
+	if currency := settings.GetString(SettingInvoiceCurrency); currency != "EUR" {
+		t.Errorf("Expected rejected batch not to be saved, currency is %q", currency)
+	}
+
+	for _, sv := range settings.All() {
+		if sv.Secret && sv.Value != "" {
+			t.Errorf("Secret setting %s must not expose its value", sv.Key)
+		}
+	}
+}
+
+func TestSettingsServiceEncryptsSecrets(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	settings := NewSettingsService(dbService, NewLogger(ERROR))
+	storedValue := func(key string) string {
+		var value string
+		if err := dbService.GetDB().QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&value); err != nil {
+			t.Fatalf("Failed to read %s: %v", key, err)
+		}
+		return value
+	}
+
+	// Saved before a key is configured, so stored in plain text
+	if err := settings.Set(SettingSMTPPassword, "hunter2-plain-text"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if stored := storedValue(SettingSMTPPassword); stored != "hunter2-plain-text" {
+		t.Errorf("Expected plain text without a key, got %q", stored)
+	}
+
+	box, err := NewSecretBox("test secrets key")
+	if err != nil {
+		t.Fatalf("NewSecretBox failed: %v", err)
+	}
+	settings.SetSecretBox(box)
+	if n, err := settings.EncryptStoredSecrets(); err != nil || n != 1 {
+		t.Fatalf("Expected 1 secret to be encrypted, got %d, %v", n, err)
+	}
+
+	if err := settings.SetMany(map[string]string{
+		SettingCompaniesHouseAPIKey: "ch-live-0123456789abcd",
+		SettingSMTPHost:             "smtp.example.com",
+	}); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+	for _, key := range []string{SettingSMTPPassword, SettingCompaniesHouseAPIKey} {
+		if stored := storedValue(key); !IsEncryptedSecret(stored) {
+			t.Errorf("Expected %s to be stored encrypted, got %q", key, stored)
+		}
+	}
+	if stored := storedValue(SettingSMTPHost); stored != "smtp.example.com" {
+		t.Errorf("Expected settings that are not secret to stay readable, got %q", stored)
+	}
+	if key := settings.GetString(SettingCompaniesHouseAPIKey); key != "ch-live-0123456789abcd" {
+		t.Errorf("Expected decrypted API key, got %q", key)
+	}
+
+	for _, sv := range settings.All() {
+		if sv.Key != SettingCompaniesHouseAPIKey {
+			continue
+		}
+		if sv.Value != "" || sv.Masked != "********abcd" || !sv.Encrypted || !sv.IsSet {
+			t.Errorf("Unexpected API key setting: value %q, masked %q, encrypted %v, set %v", sv.Value, sv.Masked, sv.Encrypted, sv.IsSet)
+		}
+	}
+
+	// A different key cannot read the secrets and refuses to start
+	other, _ := NewSecretBox("another key")
+	settings.SetSecretBox(other)
+	if _, err := settings.EncryptStoredSecrets(); err == nil {
+		t.Error("Expected secrets encrypted with another key to be rejected")
+	}
+	if password := settings.GetString(SettingSMTPPassword); password != "" {
+		t.Errorf("Expected undecryptable secret to read as empty, got %q", password)
+	}
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+const (
+	// SimilarInvoiceWindow is how far apart the issue dates of two invoices
+	// may be for them to be reported as possible duplicates
+	SimilarInvoiceWindow = 45 * 24 * time.Hour
+	// similarInvoiceTolerance is the relative difference up to which two
+	// totals count as the same amount
+	similarInvoiceTolerance = 0.02
+)
+
+// SimilarInvoices returns the invoices that look like invoice billed twice:
+// the same client, document type and currency, a total within 2%, issued
+// within SimilarInvoiceWindow of each other and, when both have a service
+// period, overlapping periods. invoice itself is skipped when it has an ID.
+func SimilarInvoices(invoices []models.Invoice, invoice *models.Invoice) []models.Invoice {
+	similar := []models.Invoice{}
+	for _, other := range invoices {
+		if other.ID == invoice.ID && invoice.ID != 0 {
+			continue
+		}
+		if other.ClientID != invoice.ClientID || other.IsProforma() != invoice.IsProforma() || other.Currency != invoice.Currency {
+			continue
+		}
+		if !similarAmounts(other.TotalAmount, invoice.TotalAmount) {
+			continue
+		}
+		if gap := other.IssueDate.Sub(invoice.IssueDate).Abs(); gap > SimilarInvoiceWindow {
+			continue
+		}
+		if other.HasServicePeriod() && invoice.HasServicePeriod() &&
+			(other.ServicePeriodEnd.Before(invoice.ServicePeriodStart) || invoice.ServicePeriodEnd.Before(other.ServicePeriodStart)) {
+			continue
+		}
+		similar = append(similar, other)
+	}
+	return similar
+}
+
+// similarAmounts reports whether a and b differ by at most
+// similarInvoiceTolerance of the larger one
+func similarAmounts(a, b models.Money) bool {
+	diff := a - b
+	if diff < 0 {
+		diff = -diff
+	}
+	larger := max(a, b, -a, -b)
+	return float64(diff) <= float64(larger)*similarInvoiceTolerance
+}
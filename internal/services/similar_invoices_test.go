@@ -0,0 +1,60 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestSimilarInvoices(t *testing.T) {
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := func(id int, mutate func(*models.Invoice)) models.Invoice {
+		i := models.Invoice{ID: id, InvoiceNumber: fmt.Sprintf("INV-2024-%04d", id), ClientID: 1, IssueDate: march,
+			TotalAmount: 100000, Currency: "EUR", Type: models.InvoiceTypeInvoice}
+		if mutate != nil {
+			mutate(&i)
+		}
+		return i
+	}
+	existing := []models.Invoice{
+		invoice(1, nil),
+		invoice(2, func(i *models.Invoice) { i.TotalAmount = 101500 }),                 // 1.5% more
+		invoice(3, func(i *models.Invoice) { i.TotalAmount = 110000 }),                 // 10% more
+		invoice(4, func(i *models.Invoice) { i.ClientID = 2 }),                         // Another client
+		invoice(5, func(i *models.Invoice) { i.IssueDate = march.AddDate(0, 0, -60) }), // Too long ago
+		invoice(6, func(i *models.Invoice) { i.Type = models.InvoiceTypeProforma }),    // Pro forma
+		invoice(7, func(i *models.Invoice) { i.Currency = "USD" }),                     // Another currency
+		invoice(8, func(i *models.Invoice) { i.IssueDate = march.AddDate(0, 0, 40) }),  // Within the window
+		invoice(9, func(i *models.Invoice) {
+			i.ServicePeriodStart, i.ServicePeriodEnd = march.AddDate(0, -1, 0), march.AddDate(0, 0, -1)
+		}),
+	}
+
+	ids := func(invoices []models.Invoice) []int {
+		result := []int{}
+		for _, i := range invoices {
+			result = append(result, i.ID)
+		}
+		return result
+	}
+
+	created := invoice(0, nil)
+	if got := ids(SimilarInvoices(existing, &created)); !slices.Equal(got, []int{1, 2, 8, 9}) {
+		t.Errorf("Expected invoices 1, 2, 8 and 9, got %v", got)
+	}
+
+	// Invoices for different service periods are not duplicates
+	created.ServicePeriodStart, created.ServicePeriodEnd = march, march.AddDate(0, 1, -1)
+	if got := ids(SimilarInvoices(existing, &created)); !slices.Equal(got, []int{1, 2, 8}) {
+		t.Errorf("Expected invoices 1, 2 and 8, got %v", got)
+	}
+
+	// An edited invoice is not a duplicate of itself
+	edited := invoice(1, nil)
+	if got := ids(SimilarInvoices(existing[:1], &edited)); len(got) != 0 {
+		t.Errorf("Expected no similar invoices, got %v", got)
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// TimelineSources are the records the activity timeline of an invoice or a
+// client is built from
+type TimelineSources struct {
+	Client   *models.Client // Adds when the client was created
+	Invoices []models.Invoice
+	Notes    []models.Note
+	Emails   []models.InvoiceEmail
+	Credits  []models.ClientCredit
+	Audit    []models.AuditEntry
+}
+
+// BuildTimeline merges notes with the events recorded for invoices and
+// clients, newest first. Unpaid invoices whose due date is before the day of
+// now add an overdue event on the day after the due date, unless they are
+// disputed or on hold.
+func BuildTimeline(sources TimelineSources, now time.Time) []models.TimelineEvent {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	numbers := make(map[int]string, len(sources.Invoices))
+	for _, invoice := range sources.Invoices {
+		numbers[invoice.ID] = invoice.InvoiceNumber
+	}
+
+	events := []models.TimelineEvent{}
+	add := func(at time.Time, kind string, invoiceID int, summary string) *models.TimelineEvent {
+		events = append(events, models.TimelineEvent{Time: at, Kind: kind, Summary: summary, InvoiceID: invoiceID, InvoiceNumber: numbers[invoiceID]})
+		return &events[len(events)-1]
+	}
+
+	if client := sources.Client; client != nil && client.CreatedDate != nil {
+		add(*client.CreatedDate, models.TimelineCreated, 0, fmt.Sprintf("Client %s added", client.Name))
+	}
+
+	for _, invoice := range sources.Invoices {
+		if invoice.Status == models.InvoiceStatusDraft {
+			continue
+		}
+		kind := "Invoice"
+		if invoice.IsProforma() {
+			kind = "Pro forma invoice"
+		}
+		add(invoice.IssueDate, models.TimelineIssued, invoice.ID,
+			fmt.Sprintf("%s %s issued for %s %s, due %s", kind, invoice.InvoiceNumber, invoice.TotalAmount, invoice.Currency, invoice.DueDate.Format("2006-01-02")))
+
+		switch {
+		case invoice.Status == models.InvoiceStatusPaid && !invoice.PaidDate.IsZero():
+			add(invoice.PaidDate, models.TimelinePaid, invoice.ID, fmt.Sprintf("%s %s paid", kind, invoice.InvoiceNumber))
+		case invoice.Status != models.InvoiceStatusPaid && !invoice.IsProforma() && !invoice.IsHeld() && invoice.DueDate.Before(today):
+			add(invoice.DueDate.AddDate(0, 0, 1), models.TimelineOverdue, invoice.ID,
+				fmt.Sprintf("Invoice %s overdue with %s %s due", invoice.InvoiceNumber, invoice.AmountDue(), invoice.Currency))
+		}
+	}
+
+	for i := range sources.Notes {
+		note := &sources.Notes[i]
+		invoiceID := 0
+		if note.EntityType == models.NoteEntityInvoice {
+			invoiceID = note.EntityID
+		}
+		add(note.CreatedAt, models.TimelineNote, invoiceID, note.Body).Note = note
+	}
+
+	for _, email := range sources.Emails {
+		summary := fmt.Sprintf("%s email for %s sent", emailKindTitle(email.Kind), numbers[email.InvoiceID])
+		if email.Recipient != "" {
+			summary += " to " + email.Recipient
+		}
+		add(email.SentAt, models.TimelineEmail, email.InvoiceID, summary)
+		if email.BouncedAt != nil {
+			add(*email.BouncedAt, models.TimelineBounce, email.InvoiceID, fmt.Sprintf("%s email for %s bounced: %s", emailKindTitle(email.Kind), numbers[email.InvoiceID], email.BounceReason))
+		}
+	}
+
+	for _, credit := range sources.Credits {
+		if credit.InvoiceID != 0 {
+			add(credit.CreatedAt, models.TimelineCredit, credit.InvoiceID,
+				fmt.Sprintf("%s %s credit applied to %s", -credit.Amount, credit.Currency, numbers[credit.InvoiceID]))
+			continue
+		}
+		add(credit.CreatedAt, models.TimelineCredit, 0, fmt.Sprintf("%s %s credit recorded: %s", credit.Amount, credit.Currency, credit.Description))
+	}
+
+	for _, entry := range sources.Audit {
+		invoiceID := 0
+		if entry.EntityType == "invoice" {
+			invoiceID = entry.EntityID
+		}
+		add(entry.CreatedAt, models.TimelineAudit, invoiceID, entry.Details)
+	}
+
+	slices.SortStableFunc(events, func(a, b models.TimelineEvent) int { return b.Time.Compare(a.Time) })
+	return events
+}
+
+// emailKindTitle returns an email template kind as the start of a sentence
+func emailKindTitle(kind string) string {
+	if kind == "" {
+		return "Invoice"
+	}
+	return strings.ToUpper(kind[:1]) + kind[1:]
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+func TestBuildTimeline(t *testing.T) {
+	date := func(month, day, hour int) time.Time {
+		return time.Date(2024, time.Month(month), day, hour, 0, 0, 0, time.UTC)
+	}
+	created := date(1, 2, 9)
+	bounced := date(2, 2, 10)
+	sources := TimelineSources{
+		Client: &models.Client{ID: 1, Name: "Acme GmbH", CreatedDate: &created},
+		Invoices: []models.Invoice{
+			{ID: 10, InvoiceNumber: "INV-2024-0001", ClientID: 1, Status: "paid", IssueDate: date(1, 5, 0), DueDate: date(2, 4, 0), PaidDate: date(2, 20, 0), TotalAmount: 10000, Currency: "EUR"},
+			{ID: 11, InvoiceNumber: "INV-2024-0002", ClientID: 1, Status: "sent", IssueDate: date(2, 1, 0), DueDate: date(3, 2, 0), TotalAmount: 50000, CreditApplied: 20000, Currency: "EUR"},
+			{ID: 12, InvoiceNumber: "INV-2024-0003", ClientID: 1, Status: "draft", IssueDate: date(3, 1, 0), DueDate: date(3, 31, 0), TotalAmount: 10000, Currency: "EUR"},
+		},
+		Notes: []models.Note{
+			{ID: 1, EntityType: models.NoteEntityInvoice, EntityID: 11, Body: "Client promised payment Friday", CreatedAt: date(3, 5, 14)},
+			{ID: 2, EntityType: models.NoteEntityClient, EntityID: 1, Body: "New contact in accounts payable", CreatedAt: date(1, 3, 8)},
+		},
+		Emails: []models.InvoiceEmail{
+			{ID: 1, InvoiceID: 11, Kind: "invoice", Recipient: "ap@acme.example", SentAt: date(2, 1, 10), BouncedAt: &bounced, BounceReason: "mailbox full"},
+		},
+		Credits: []models.ClientCredit{
+			{ID: 1, ClientID: 1, Amount: 20000, Currency: "EUR", Description: "Retainer", CreatedAt: date(1, 20, 12)},
+			{ID: 2, ClientID: 1, InvoiceID: 11, Amount: -20000, Currency: "EUR", CreatedAt: date(2, 3, 12)},
+		},
+	}
+
+	events := BuildTimeline(sources, date(3, 10, 12))
+	expected := []struct {
+		kind    string
+		invoice int
+		summary string
+	}{
+		{models.TimelineNote, 11, "Client promised payment Friday"},
+		{models.TimelineOverdue, 11, "Invoice INV-2024-0002 overdue with 300.00 EUR due"},
+		{models.TimelinePaid, 10, "Invoice INV-2024-0001 paid"},
+		{models.TimelineCredit, 11, "200.00 EUR credit applied to INV-2024-0002"},
+		{models.TimelineBounce, 11, "Invoice email for INV-2024-0002 bounced: mailbox full"},
+		{models.TimelineEmail, 11, "Invoice email for INV-2024-0002 sent to ap@acme.example"},
+		{models.TimelineIssued, 11, "Invoice INV-2024-0002 issued for 500.00 EUR, due 2024-03-02"},
+		{models.TimelineCredit, 0, "200.00 EUR credit recorded: Retainer"},
+		{models.TimelineIssued, 10, "Invoice INV-2024-0001 issued for 100.00 EUR, due 2024-02-04"},
+		{models.TimelineNote, 0, "New contact in accounts payable"},
+		{models.TimelineCreated, 0, "Client Acme GmbH added"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %d: %+v", len(expected), len(events), events)
+	}
+	for i, want := range expected {
+		got := events[i]
+		if got.Kind != want.kind || got.InvoiceID != want.invoice || got.Summary != want.summary {
+			t.Errorf("Event %d: expected %s %d %q, got %s %d %q", i, want.kind, want.invoice, want.summary, got.Kind, got.InvoiceID, got.Summary)
+		}
+	}
+	if events[0].Note == nil || events[0].Note.ID != 1 || events[0].InvoiceNumber != "INV-2024-0002" {
+		t.Errorf("Expected the note on INV-2024-0002 to be attached, got %+v", events[0])
+	}
+}
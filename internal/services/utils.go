@@ -2,28 +2,16 @@ package services
 
 import (
 	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/refdata"
 )
 
 // CalculateWorkHoursForMonth calculates the total work hours for a given month
-// excluding weekends (8 hours per day, 5 days per week)
+// with the default working time (8 hours per day, Monday to Friday) and no
+// public holidays; HolidayService.WorkMonth uses a business's own calendar
 func CalculateWorkHoursForMonth(year int, month time.Month) float64 {
-	// Get the first day of the month
-	firstDay := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
-
-	// Get the last day of the month
-	lastDay := firstDay.AddDate(0, 1, -1)
-
-	var totalHours float64 = 0
-
-	// Iterate through each day of the month
-	for day := firstDay; day.Before(lastDay.AddDate(0, 0, 1)); day = day.AddDate(0, 0, 1) {
-		// Check if the day is a weekday (Monday to Friday)
-		if day.Weekday() != time.Saturday && day.Weekday() != time.Sunday {
-			totalHours += 8.0 // 8 hours per workday
-		}
-	}
-
-	return totalHours
+	return (&models.Business{}).WorkMonth(year, month, nil).TotalHours
 }
 
 // CalculateWorkHoursForCurrentMonth calculates the total work hours for the current month
@@ -33,77 +21,16 @@ func CalculateWorkHoursForCurrentMonth() float64 {
 	return CalculateWorkHoursForMonth(now.Year(), now.Month())
 }
 
-// GetCurrencyForCountry returns the currency code for a given country code
-// For EU countries, it returns the appropriate currency (EUR for Eurozone, or local currency for non-Eurozone)
+// GetCurrencyForCountry returns the currency code a country uses today, such
+// as EUR for the euro area, or EUR for unknown countries
 func GetCurrencyForCountry(countryCode string) string {
-	// Default currency
-	defaultCurrency := "EUR"
-
-	// Map of EU countries to their currencies
-	euCurrencies := map[string]string{
-		// Eurozone countries (using EUR)
-		"AT": "EUR", // Austria
-		"BE": "EUR", // Belgium
-		"CY": "EUR", // Cyprus
-		"EE": "EUR", // Estonia
-		"FI": "EUR", // Finland
-		"FR": "EUR", // France
-		"DE": "EUR", // Germany
-		"GR": "EUR", // Greece
-		"IE": "EUR", // Ireland
-		"IT": "EUR", // Italy
-		"LV": "EUR", // Latvia
-		"LT": "EUR", // Lithuania
-		"LU": "EUR", // Luxembourg
-		"MT": "EUR", // Malta
-		"NL": "EUR", // Netherlands
-		"PT": "EUR", // Portugal
-		"SK": "EUR", // Slovakia
-		"SI": "EUR", // Slovenia
-		"ES": "EUR", // Spain
-
-		// Non-Eurozone EU countries
-		"BG": "BGN", // Bulgaria - Bulgarian Lev
-		"HR": "HRK", // Croatia - Croatian Kuna (Note: Croatia adopted EUR in 2023, but keeping HRK for backward compatibility)
-		"CZ": "CZK", // Czech Republic - Czech Koruna
-		"DK": "DKK", // Denmark - Danish Krone
-		"HU": "HUF", // Hungary - Hungarian Forint
-		"PL": "PLN", // Poland - Polish Złoty
-		"RO": "RON", // Romania - Romanian Leu
-		"SE": "SEK", // Sweden - Swedish Krona
-
-		// Former EU member
-		"GB": "GBP", // United Kingdom - British Pound
-	}
-
-	// Return the currency for the country code, or the default if not found
-	if currency, exists := euCurrencies[countryCode]; exists {
+	if currency := refdata.CountryCurrency(countryCode, time.Now()); currency != "" {
 		return currency
 	}
-
-	return defaultCurrency
+	return "EUR"
 }
 
 // FormatCurrencySymbol returns the appropriate currency symbol for a given currency code
 func FormatCurrencySymbol(currencyCode string) string {
-	currencySymbols := map[string]string{
-		"EUR": "€",
-		"GBP": "£",
-		"BGN": "лв",
-		"HRK": "kn",
-		"CZK": "Kč",
-		"DKK": "kr",
-		"HUF": "Ft",
-		"PLN": "zł",
-		"RON": "lei",
-		"SEK": "kr",
-		"USD": "$",
-	}
-
-	if symbol, exists := currencySymbols[currencyCode]; exists {
-		return symbol
-	}
-
-	// Return the currency code if no symbol is found
-	return currencyCode
+	return refdata.CurrencySymbol(currencyCode)
 }
@@ -1,73 +1,212 @@
 package services
 
 import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 )
 
-// Mock implementation for testing
-func parseAddressForTest(rawAddress string, countryCode string) (address, city, postalCode string) {
-	switch countryCode {
-	case "GB":
-		return "123 TEST STREET", "LONDON", "SW1A 1AA"
-	case "DE":
-		return "TESTSTRASSE 123", "BERLIN", "10115"
-	case "FR":
-		return "123 RUE DE TEST", "PARIS", "75001"
-	default:
-		return rawAddress, "", ""
+// fixtureTransport answers every request with an HTTP response recorded in
+// testdata, such as testdata/vies/valid.http, and keeps the last request
+type fixtureTransport struct {
+	fixture string
+	request *http.Request
+	body    string
+}
+
+func (f *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.request = req
+	if req.Body != nil {
+		body, _ := io.ReadAll(req.Body)
+		f.body = string(body)
+	}
+	file, err := os.Open(filepath.Join("testdata", f.fixture))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	resp, err := http.ReadResponse(bufio.NewReader(file), req)
+	if err != nil {
+		return nil, err
+	}
+	// The body is read before the file is closed
+	body, err := io.ReadAll(resp.Body)
+	resp.Body = io.NopCloser(strings.NewReader(string(body)))
+	return resp, err
+}
+
+// stalledTransport never answers, until the request is canceled
+type stalledTransport struct{}
+
+func (stalledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+// newFixtureVatService returns a VatService whose requests are answered by
+// the transport
+func newFixtureVatService(t *testing.T, transport http.RoundTripper) *VatService {
+	t.Helper()
+	t.Setenv("COMPANIES_HOUSE_API_KEY", "test-key")
+	dbService, _, cleanup := setupTestDB(t)
+	t.Cleanup(cleanup)
+	logger := NewLogger(FATAL)
+	s := NewVatService(NewSettingsService(dbService, logger), logger)
+	s.client = &http.Client{Transport: transport, Timeout: 10 * time.Second}
+	return s
+}
+
+func TestValidateVatIDWithVIES(t *testing.T) {
+	transport := &fixtureTransport{fixture: "vies/valid.http"}
+	s := newFixtureVatService(t, transport)
+
+	client, err := s.ValidateVatID("nl 859048366 b01")
+	if err != nil {
+		t.Fatalf("ValidateVatID failed: %v", err)
+	}
+	if transport.request.URL.String() != viesURL || transport.request.Method != http.MethodPost {
+		t.Errorf("Expected a POST to VIES, got %s %s", transport.request.Method, transport.request.URL)
+	}
+	for _, want := range []string{"<urn:countryCode>NL</urn:countryCode>", "<urn:vatNumber>859048366B01</urn:vatNumber>"} {
+		if !strings.Contains(transport.body, want) {
+			t.Errorf("Expected the request to contain %s, got %s", want, transport.body)
+		}
+	}
+	if client.Name != "VOORBEELD & ZONEN B.V." || client.VatID != "NL859048366B01" || client.Country != "NL" {
+		t.Errorf("Unexpected company %q with VAT ID %s in %s", client.Name, client.VatID, client.Country)
+	}
+	if client.Address != "KEIZERSGRACHT 00123" || client.PostalCode != "1015 CJ" || client.City != "AMSTERDAM" {
+		t.Errorf("Expected KEIZERSGRACHT 00123, 1015 CJ AMSTERDAM, got %q, %q %q", client.Address, client.PostalCode, client.City)
+	}
+
+	// Germany confirms VAT IDs without disclosing the company
+	transport.fixture = "vies/undisclosed.http"
+	client, err = s.ValidateVatID("DE811907980")
+	if err != nil {
+		t.Fatalf("ValidateVatID failed: %v", err)
+	}
+	if client.Name != "" || client.Address != "" || client.City != "" || client.VatID != "DE811907980" {
+		t.Errorf("Expected only the VAT ID of an undisclosed company, got %+v", client)
 	}
 }
 
-func TestParseAddressForTest(t *testing.T) {
+func TestValidateVatIDFailures(t *testing.T) {
 	tests := []struct {
-		name           string
-		rawAddress     string
-		countryCode    string
-		wantAddress    string
-		wantCity       string
-		wantPostalCode string
+		fixture string
+		want    error
 	}{
-		{
-			name:           "UK Address",
-			rawAddress:     "123 TEST STREET\nTEST CITY\nLONDON\nSW1A 1AA\nUNITED KINGDOM",
-			countryCode:    "GB",
-			wantAddress:    "123 TEST STREET",
-			wantCity:       "LONDON",
-			wantPostalCode: "SW1A 1AA",
-		},
-		{
-			name:           "German Address",
-			rawAddress:     "TESTSTRASSE 123\n10115 BERLIN\nGERMANY",
-			countryCode:    "DE",
-			wantAddress:    "TESTSTRASSE 123",
-			wantCity:       "BERLIN",
-			wantPostalCode: "10115",
-		},
-		{
-			name:           "French Address",
-			rawAddress:     "123 RUE DE TEST\n75001 PARIS\nFRANCE",
-			countryCode:    "FR",
-			wantAddress:    "123 RUE DE TEST",
-			wantCity:       "PARIS",
-			wantPostalCode: "75001",
-		},
+		{"vies/not-registered.http", ErrInvalidVatID},
+		{"vies/fault-invalid-input.http", ErrInvalidVatID},
+		{"vies/fault-ms-unavailable.http", ErrLookupUnavailable},
+		{"vies/fault-ms-max-concurrent-req.http", ErrLookupRateLimited},
+		{"vies/gateway-unavailable.http", ErrLookupUnavailable},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			gotAddress, gotCity, gotPostalCode := parseAddressForTest(tt.rawAddress, tt.countryCode)
-
-			if gotAddress != tt.wantAddress {
-				t.Errorf("parseAddress() gotAddress = %v, want %v", gotAddress, tt.wantAddress)
-			}
-			if gotCity != tt.wantCity {
-				t.Errorf("parseAddress() gotCity = %v, want %v", gotCity, tt.wantCity)
+		t.Run(tt.fixture, func(t *testing.T) {
+			s := newFixtureVatService(t, &fixtureTransport{fixture: tt.fixture})
+			client, err := s.ValidateVatID("FR00000000000")
+			if !errors.Is(err, tt.want) || client != nil {
+				t.Errorf("Expected %v, got %v and %v", tt.want, client, err)
 			}
-			if gotPostalCode != tt.wantPostalCode {
-				t.Errorf("parseAddress() gotPostalCode = %v, want %v", gotPostalCode, tt.wantPostalCode)
+		})
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		s := newFixtureVatService(t, stalledTransport{})
+		s.client.Timeout = 10 * time.Millisecond
+		if _, err := s.ValidateVatID("FR00000000000"); !errors.Is(err, ErrLookupUnavailable) {
+			t.Errorf("Expected ErrLookupUnavailable after a timeout, got %v", err)
+		}
+	})
+}
+
+func TestLookupUKCompany(t *testing.T) {
+	transport := &fixtureTransport{fixture: "companies-house/search.http"}
+	s := newFixtureVatService(t, transport)
+
+	clients, err := s.LookupUKCompany("Example & Co")
+	if err != nil {
+		t.Fatalf("LookupUKCompany failed: %v", err)
+	}
+	if got := transport.request.URL.String(); got != companiesHouseURL+"/search/companies?q=Example+%26+Co" {
+		t.Errorf("Unexpected request URL %s", got)
+	}
+	if user, _, ok := transport.request.BasicAuth(); !ok || user != "test-key" {
+		t.Errorf("Expected the API key as the user name, got %q", user)
+	}
+	if len(clients) != 2 {
+		t.Fatalf("Expected 2 companies, got %d", len(clients))
+	}
+	if c := clients[0]; c.Name != "EXAMPLE TRADING LIMITED" || c.Address != "221B Baker Street" || c.City != "London" || c.PostalCode != "NW1 6XE" || c.Country != "GB" {
+		t.Errorf("Unexpected first company %+v", c)
+	}
+
+	transport.fixture = "companies-house/company.http"
+	client, err := s.LookupUKCompanyByNumber("09612345")
+	if err != nil {
+		t.Fatalf("LookupUKCompanyByNumber failed: %v", err)
+	}
+	if got := transport.request.URL.String(); got != companiesHouseURL+"/company/09612345" {
+		t.Errorf("Unexpected request URL %s", got)
+	}
+	if client.Name != "EXAMPLE TRADING LIMITED" || client.Address != "221B Baker Street, Marylebone" || client.City != "London" || client.PostalCode != "NW1 6XE" {
+		t.Errorf("Unexpected company %+v", client)
+	}
+	if client.Country != "GB" {
+		t.Errorf("Expected the country GB instead of the nation, got %q", client.Country)
+	}
+}
+
+func TestLookupUKCompanyFailures(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    error
+	}{
+		{"companies-house/not-found.http", ErrCompanyNotFound},
+		{"companies-house/rate-limited.http", ErrLookupRateLimited},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			s := newFixtureVatService(t, &fixtureTransport{fixture: tt.fixture})
+			if _, err := s.LookupUKCompanyByNumber("00000000"); !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
 			}
 		})
 	}
+
+	t.Run("rejected API key", func(t *testing.T) {
+		s := newFixtureVatService(t, &fixtureTransport{fixture: "companies-house/unauthorized.http"})
+		_, err := s.LookupUKCompany("Example")
+		if err == nil || !strings.Contains(err.Error(), "API key") || errors.Is(err, ErrLookupUnavailable) {
+			t.Errorf("Expected the API key to be reported, got %v", err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		s := newFixtureVatService(t, stalledTransport{})
+		s.client.Timeout = 10 * time.Millisecond
+		if _, err := s.LookupUKCompany("Example"); !errors.Is(err, ErrLookupUnavailable) {
+			t.Errorf("Expected ErrLookupUnavailable after a timeout, got %v", err)
+		}
+	})
+
+	t.Run("no API key", func(t *testing.T) {
+		transport := &fixtureTransport{fixture: "companies-house/search.http"}
+		s := newFixtureVatService(t, transport)
+		t.Setenv("COMPANIES_HOUSE_API_KEY", "")
+		if _, err := s.LookupUKCompany("Example"); err == nil || transport.request != nil {
+			t.Errorf("Expected the lookup to fail without a request, got %v", err)
+		}
+	})
 }
 
 func TestIsEUCountry(t *testing.T) {
@@ -91,6 +230,11 @@ func TestIsEUCountry(t *testing.T) {
 			code: "IT",
 			want: true,
 		},
+		{
+			name: "Greek VAT prefix is EU",
+			code: "EL",
+			want: true,
+		},
 		{
 			name: "UK is not EU",
 			code: "GB",
@@ -162,44 +306,39 @@ func TestIsNumericForTest(t *testing.T) {
 	}
 }
 
-func TestIsLikelyPostalCode(t *testing.T) {
-	tests := []struct {
-		name        string
-		word        string
-		countryCode string
-		want        bool
-	}{
-		{
-			name:        "UK Postal Code",
-			word:        "SW1A1AA",
-			countryCode: "GB",
-			want:        true,
-		},
-		{
-			name:        "UK Postal Code with space",
-			word:        "SW1A 1AA",
-			countryCode: "GB",
-			want:        true,
-		},
-		{
-			name:        "German Postal Code",
-			word:        "10115",
-			countryCode: "DE",
-			want:        true,
-		},
-		{
-			name:        "Not a Postal Code",
-			word:        "Hello",
-			countryCode: "GB",
-			want:        false,
-		},
+// FuzzParseVIESResponse checks that any response of VIES, or of something in
+// front of it, is decoded into a result or an error without panicking
+func FuzzParseVIESResponse(f *testing.F) {
+	for _, fixture := range []string{"valid", "undisclosed", "not-registered", "fault-ms-unavailable", "gateway-unavailable"} {
+		data, err := os.ReadFile(filepath.Join("testdata", "vies", fixture+".http"))
+		if err != nil {
+			f.Fatalf("Failed to read fixture: %v", err)
+		}
+		// Only the body of the recorded response
+		if _, body, ok := strings.Cut(string(data), "\n\n"); ok {
+			f.Add([]byte(body))
+		}
 	}
+	f.Add([]byte(`<Envelope><Body><checkVatResponse><valid>true</valid><name>&amp;&lt;</name></checkVatResponse></Body></Envelope>`))
+	f.Add([]byte(`<Envelope><Body><Fault></Fault></Body></Envelope>`))
+	f.Add([]byte(`<Envelope><Body>`))
+	f.Add([]byte{})
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := isLikelyPostalCode(tt.word, tt.countryCode); got != tt.want {
-				t.Errorf("isLikelyPostalCode() = %v, want %v", got, tt.want)
-			}
-		})
-	}
+	f.Fuzz(func(t *testing.T, body []byte) {
+		result, err := parseVIESResponse(body)
+		if (result == nil) == (err == nil) {
+			t.Fatalf("parseVIESResponse(%q) = %v, %v; want a result or an error", body, result, err)
+		}
+		if result == nil {
+			return
+		}
+		if result.Name != strings.TrimSpace(result.Name) || result.Address != strings.TrimSpace(result.Address) {
+			t.Fatalf("parseVIESResponse(%q) did not trim %+v", body, result)
+		}
+		if utf8.Valid(body) && !utf8.ValidString(result.Name+result.Address) {
+			t.Fatalf("parseVIESResponse(%q) returned invalid UTF-8 in %+v", body, result)
+		}
+		// The address goes through the parser like a real one
+		parseAddress(result.Address, result.CountryCode)
+	})
 }
@@ -0,0 +1,913 @@
+package services
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"crypto/tls"
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+	"github.com/0dragosh/simple-invoice/internal/refdata"
+	"github.com/skip2/go-qrcode"
+)
+
+// ErrVerifactuNotConfigured is returned when Verifactu is not enabled, or
+// records are sent to AEAT without a certificate
+var ErrVerifactuNotConfigured = errors.New("Verifactu is not configured")
+
+// ErrVerifactuInvalid is returned when an invoice gets no Verifactu record or
+// lacks data the record requires
+var ErrVerifactuInvalid = errors.New("invoice cannot be registered with Verifactu")
+
+// Namespaces of the Verifactu web service of AEAT
+const (
+	verifactuSoapNamespace   = "http://schemas.xmlsoap.org/soap/envelope/"
+	verifactuLRNamespace     = "https://www2.agenciatributaria.gob.es/static_files/common/internet/dep/aplicaciones/es/aeat/tike/cont/ws/SuministroLR.xsd"
+	verifactuRecordNamespace = "https://www2.agenciatributaria.gob.es/static_files/common/internet/dep/aplicaciones/es/aeat/tike/cont/ws/SuministroInformacion.xsd"
+)
+
+// verifactuInterval is how often new invoices get records and pending records
+// are sent; AEAT expects them as invoices are issued
+const verifactuInterval = time.Minute
+
+// verifactuMaxBackoff caps the wait before a failed attempt is retried
+const verifactuMaxBackoff = 6 * time.Hour
+
+// verifactuMaxBatch is the most records AEAT accepts in one request
+const verifactuMaxBatch = 1000
+
+// verifactuTimeLayout is how the time a record was generated is written and hashed
+const verifactuTimeLayout = "2006-01-02T15:04:05-07:00"
+
+// Statuses of the records of a request, as AEAT answers them
+var verifactuRecordStatuses = map[string]string{
+	"Correcto":           models.VerifactuAccepted,
+	"AceptadoConErrores": models.VerifactuAcceptedWithErrors,
+	"Incorrecto":         models.VerifactuRejected,
+}
+
+// IsVerifactuBusiness reports whether the invoices of a business can be
+// registered with Verifactu: those of businesses with an ES VAT ID
+func IsVerifactuBusiness(business *models.Business) bool {
+	return verifactuNIF(business) != ""
+}
+
+// verifactuNIF returns the Spanish tax ID (NIF) in the ES VAT ID of a
+// business, empty for other businesses
+func verifactuNIF(business *models.Business) string {
+	vatID := compactUpper(business.VatID)
+	if len(vatID) != 11 || !strings.HasPrefix(vatID, "ES") {
+		return ""
+	}
+	return vatID[2:]
+}
+
+// VerifactuService implements Verifactu, the Spanish regime for invoicing
+// software: every invoice of a Spanish business gets a billing record chained
+// to the previous one by its hash, carries a QR code to check it with AEAT,
+// and the records are sent to AEAT as they are created.
+type VerifactuService struct {
+	dbService       *DBService
+	settingsService *SettingsService
+	compliance      *ComplianceService
+	logger          *Logger
+	version         string     // Reported as the version of the invoicing software
+	mu              sync.Mutex // Serializes creating and sending records
+	nextSend        time.Time  // AEAT asks to wait between requests
+	warned          map[int]bool
+	stop            chan struct{}
+	done            chan struct{}
+}
+
+// NewVerifactuService creates a new VerifactuService
+func NewVerifactuService(dbService *DBService, settingsService *SettingsService, version string, logger *Logger) *VerifactuService {
+	return &VerifactuService{
+		dbService:       dbService,
+		settingsService: settingsService,
+		logger:          logger,
+		version:         version,
+		warned:          map[int]bool{},
+	}
+}
+
+// SetComplianceService sets the service whose profiles decide which
+// businesses register their invoices with Verifactu
+func (s *VerifactuService) SetComplianceService(compliance *ComplianceService) {
+	s.compliance = compliance
+}
+
+// Enabled reports whether invoices get Verifactu records
+func (s *VerifactuService) Enabled() bool {
+	return s.settingsService.GetBool(SettingVerifactuEnabled)
+}
+
+// Start creates and sends records periodically
+func (s *VerifactuService) Start() {
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(verifactuInterval):
+			}
+
+			RunProtected(s.logger, "Verifactu", func() {
+				if !s.Enabled() {
+					return
+				}
+				if err := s.Process(); err != nil {
+					s.logger.Error("Failed to process Verifactu records: %v", err)
+				}
+			})
+		}
+	}()
+}
+
+// Stop stops the processing started by Start
+func (s *VerifactuService) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
+
+const verifactuRecordColumns = `id, invoice_id, issuer_nif, issuer_name, invoice_number, issue_date, invoice_type, vat_total, total,
+	previous_hash, hash, generated_at, correction, status, csv, message, attempts, next_attempt_at, sent_at, xml`
+
+// scanVerifactuRecord scans a verifactu_records row
+func scanVerifactuRecord(row rowScanner) (*models.VerifactuRecord, error) {
+	var record models.VerifactuRecord
+	var sentAt sql.NullTime
+	if err := row.Scan(&record.ID, &record.InvoiceID, &record.IssuerNIF, &record.IssuerName, &record.InvoiceNumber, &record.IssueDate,
+		&record.InvoiceType, &record.VatTotal, &record.Total, &record.PreviousHash, &record.Hash, &record.GeneratedAt,
+		&record.Correction, &record.Status, &record.CSV, &record.Message, &record.Attempts, &record.NextAttemptAt, &sentAt, &record.XML); err != nil {
+		return nil, err
+	}
+	if sentAt.Valid {
+		record.SentAt = sentAt.Time
+	}
+	return &record, nil
+}
+
+// Latest returns the latest record of an invoice, or nil if it has none
+func (s *VerifactuService) Latest(invoiceID int) (*models.VerifactuRecord, error) {
+	record, err := scanVerifactuRecord(s.dbService.GetDB().QueryRow(`SELECT `+verifactuRecordColumns+`
+		FROM verifactu_records WHERE invoice_id = ? ORDER BY id DESC LIMIT 1`, invoiceID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Verifactu record: %w", err)
+	}
+	return record, nil
+}
+
+// pending returns the records due to be sent, oldest first
+func (s *VerifactuService) pending() ([]*models.VerifactuRecord, error) {
+	rows, err := s.dbService.GetDB().Query(`SELECT `+verifactuRecordColumns+`
+		FROM verifactu_records WHERE status = ? AND next_attempt_at <= ? ORDER BY id`, models.VerifactuPending, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Verifactu records: %w", err)
+	}
+	defer rows.Close()
+	var records []*models.VerifactuRecord
+	for rows.Next() {
+		record, err := scanVerifactuRecord(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan Verifactu record: %w", err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load Verifactu records: %w", err)
+	}
+	return records, nil
+}
+
+// Submit creates the record of an invoice now rather than with the next run,
+// or a correcting record when AEAT rejected or objected to the last one, and
+// sends the pending records to AEAT. A failed attempt leaves them pending.
+func (s *VerifactuService) Submit(invoiceID int) (*models.VerifactuRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.Enabled() {
+		return nil, fmt.Errorf("%w: enable Verifactu on the Settings page", ErrVerifactuNotConfigured)
+	}
+	if s.settingsService.GetString(SettingVerifactuCertPath) == "" {
+		return nil, fmt.Errorf("%w: set the certificate records are sent with on the Settings page", ErrVerifactuNotConfigured)
+	}
+	latest, err := s.Latest(invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case latest == nil:
+		err = s.register(invoiceID, "")
+	case latest.Status == models.VerifactuAccepted:
+		return nil, fmt.Errorf("%w: AEAT accepted the record of the invoice already", ErrVerifactuInvalid)
+	case latest.Status == models.VerifactuRejected || latest.Status == models.VerifactuAcceptedWithErrors:
+		err = s.register(invoiceID, latest.Status)
+	default:
+		// Send the pending record at once
+		_, err = s.dbService.GetDB().Exec(`UPDATE verifactu_records SET next_attempt_at = ? WHERE id = ?`, time.Now().UTC(), latest.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.send(); err != nil {
+		return nil, err
+	}
+	return s.Latest(invoiceID)
+}
+
+// Process creates the records of the invoices issued since the start date
+// and sends the pending records once a certificate is set. Invoices that
+// cannot get a record are logged; only database errors are returned.
+func (s *VerifactuService) Process() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.Enabled() {
+		return ErrVerifactuNotConfigured
+	}
+	if err := s.registerNew(); err != nil {
+		return err
+	}
+	if s.settingsService.GetString(SettingVerifactuCertPath) == "" {
+		return nil
+	}
+	return s.send()
+}
+
+// startDate returns the day invoices get records from. Without one, it is set
+// to today, so invoices issued before Verifactu was enabled are left out.
+func (s *VerifactuService) startDate() (time.Time, error) {
+	from := s.settingsService.GetString(SettingVerifactuFrom)
+	if from == "" {
+		from = time.Now().Format("2006-01-02")
+		if err := s.settingsService.Set(SettingVerifactuFrom, from); err != nil {
+			return time.Time{}, err
+		}
+	}
+	date, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid Verifactu start date %q: %w", from, err)
+	}
+	return date, nil
+}
+
+// registerNew creates the records of the finalized invoices of Spanish
+// businesses issued on or after the start date that have none, oldest first
+func (s *VerifactuService) registerNew() error {
+	from, err := s.startDate()
+	if err != nil {
+		return err
+	}
+
+	registered := map[int]bool{}
+	rows, err := s.dbService.GetDB().Query(`SELECT DISTINCT invoice_id FROM verifactu_records`)
+	if err != nil {
+		return fmt.Errorf("failed to load Verifactu records: %w", err)
+	}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan Verifactu record: %w", err)
+		}
+		registered[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load Verifactu records: %w", err)
+	}
+
+	invoices, err := s.dbService.GetInvoices()
+	if err != nil {
+		return err
+	}
+	// Records are chained in the order the invoices were issued
+	slices.SortFunc(invoices, func(a, b models.Invoice) int {
+		return cmp.Or(a.IssueDate.Compare(b.IssueDate), cmp.Compare(a.ID, b.ID))
+	})
+	spanish := map[int]bool{}
+	for _, invoice := range invoices {
+		if registered[invoice.ID] || !isBooked(&invoice) || invoice.IssueDate.Before(from) {
+			continue
+		}
+		isSpanish, ok := spanish[invoice.BusinessID]
+		if !ok {
+			business, err := s.dbService.GetBusiness(invoice.BusinessID)
+			if err != nil {
+				return fmt.Errorf("failed to load business: %w", err)
+			}
+			if isSpanish = IsVerifactuBusiness(business); isSpanish {
+				if isSpanish, err = complianceAllows(s.compliance, business, models.ComplianceVerifactu); err != nil {
+					return err
+				}
+			}
+			spanish[invoice.BusinessID] = isSpanish
+		}
+		if !isSpanish {
+			continue
+		}
+		err := s.register(invoice.ID, "")
+		if errors.Is(err, ErrVerifactuInvalid) {
+			if !s.warned[invoice.ID] {
+				s.logger.Warn("Invoice %d gets no Verifactu record: %v", invoice.ID, err)
+				s.warned[invoice.ID] = true
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// register creates a record of an invoice, chained to the latest record of
+// its issuer. A correction names the status of the record it corrects.
+func (s *VerifactuService) register(invoiceID int, corrects string) error {
+	invoice, items, err := s.dbService.GetInvoice(invoiceID)
+	if err != nil {
+		return err
+	}
+	business, err := s.dbService.GetBusiness(invoice.BusinessID)
+	if err != nil {
+		return fmt.Errorf("failed to load business: %w", err)
+	}
+	if allowed, err := complianceAllows(s.compliance, business, models.ComplianceVerifactu); err != nil {
+		return err
+	} else if !allowed {
+		return fmt.Errorf("%w: the compliance profile of the business does not register invoices with Verifactu", ErrVerifactuInvalid)
+	}
+	client, err := s.dbService.GetClient(invoice.ClientID)
+	if err != nil {
+		return fmt.Errorf("failed to load client: %w", err)
+	}
+	record, err := newVerifactuRecord(invoice, items, business, client, s.version)
+	if err != nil {
+		return err
+	}
+	record.Correction = corrects != ""
+	if corrects == models.VerifactuRejected {
+		record.Element.RechazoPrevio = "X"
+	}
+
+	// The previous record is read in the same transaction, so no other record
+	// chains to it in between
+	tx, err := s.dbService.GetDB().Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	previous, err := scanVerifactuRecord(tx.QueryRow(`SELECT `+verifactuRecordColumns+`
+		FROM verifactu_records WHERE issuer_nif = ? ORDER BY id DESC LIMIT 1`, record.IssuerNIF))
+	if errors.Is(err, sql.ErrNoRows) {
+		previous = nil
+	} else if err != nil {
+		return fmt.Errorf("failed to load the previous Verifactu record: %w", err)
+	}
+	data, err := record.chain(previous, time.Now())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	r := record.VerifactuRecord
+	if _, err := tx.Exec(`
+		INSERT INTO verifactu_records (invoice_id, issuer_nif, issuer_name, invoice_number, issue_date, invoice_type, vat_total, total,
+			previous_hash, hash, generated_at, correction, status, next_attempt_at, xml)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.InvoiceID, r.IssuerNIF, r.IssuerName, r.InvoiceNumber, r.IssueDate, r.InvoiceType, r.VatTotal, r.Total,
+		r.PreviousHash, r.Hash, r.GeneratedAt, boolToInt(r.Correction), models.VerifactuPending, now, data); err != nil {
+		return fmt.Errorf("failed to save Verifactu record: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit Verifactu record: %w", err)
+	}
+	s.logger.Info("Created Verifactu record of invoice %d", r.InvoiceID)
+	return nil
+}
+
+// send sends the pending records to AEAT, a request per issuer, unless AEAT
+// asked to wait. Failures are recorded on the records and retried later,
+// waiting longer after every attempt.
+func (s *VerifactuService) send() error {
+	if time.Now().Before(s.nextSend) {
+		return nil
+	}
+	records, err := s.pending()
+	if err != nil || len(records) == 0 {
+		return err
+	}
+	var issuers []string
+	batches := map[string][]*models.VerifactuRecord{}
+	for _, record := range records {
+		if _, ok := batches[record.IssuerNIF]; !ok {
+			issuers = append(issuers, record.IssuerNIF)
+		}
+		if len(batches[record.IssuerNIF]) < verifactuMaxBatch {
+			batches[record.IssuerNIF] = append(batches[record.IssuerNIF], record)
+		}
+	}
+
+	client, clientErr := s.httpClient()
+	for _, issuer := range issuers {
+		batch := batches[issuer]
+		err := clientErr
+		var response *verifactuResponse
+		if err == nil {
+			response, err = s.call(client, batch)
+		}
+		if err != nil {
+			s.logger.Warn("Failed to send Verifactu records to AEAT: %v", err)
+			if err := s.retryLater(batch, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.saveResponse(batch, response); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retryLater records a failed attempt to send records
+func (s *VerifactuService) retryLater(records []*models.VerifactuRecord, message string) error {
+	now := time.Now().UTC()
+	for _, record := range records {
+		backoff := min(time.Minute<<min(record.Attempts, 16), verifactuMaxBackoff)
+		if _, err := s.dbService.GetDB().Exec(`UPDATE verifactu_records SET attempts = attempts + 1, message = ?, next_attempt_at = ? WHERE id = ?`,
+			message, now.Add(backoff), record.ID); err != nil {
+			return fmt.Errorf("failed to update Verifactu record: %w", err)
+		}
+	}
+	return nil
+}
+
+// saveResponse records how AEAT answered the records of a request
+func (s *VerifactuService) saveResponse(records []*models.VerifactuRecord, response *verifactuResponse) error {
+	if response.Wait > 0 {
+		s.nextSend = time.Now().Add(time.Duration(response.Wait) * time.Second)
+	}
+	now := time.Now().UTC()
+	for _, record := range records {
+		status, message := models.VerifactuAccepted, ""
+		if response.Status != "Correcto" {
+			line := response.line(record)
+			if line == nil {
+				if err := s.retryLater([]*models.VerifactuRecord{record}, "AEAT did not answer the record"); err != nil {
+					return err
+				}
+				continue
+			}
+			if status = verifactuRecordStatuses[line.Status]; status == "" {
+				status = models.VerifactuRejected
+			}
+			if line.ErrorCode != "" {
+				message = line.ErrorCode + ": " + line.ErrorMessage
+			}
+		}
+		if status != models.VerifactuAccepted {
+			s.logger.Warn("AEAT did not fully accept the Verifactu record of invoice %d: %s", record.InvoiceID, message)
+		}
+		if _, err := s.dbService.GetDB().Exec(`UPDATE verifactu_records SET status = ?, csv = ?, message = ?, sent_at = ? WHERE id = ?`,
+			status, response.CSV, message, now, record.ID); err != nil {
+			return fmt.Errorf("failed to update Verifactu record: %w", err)
+		}
+	}
+	return nil
+}
+
+// httpClient returns a client authenticating with the certificate of the
+// settings, as AEAT requires
+func (s *VerifactuService) httpClient() (*http.Client, error) {
+	data, err := os.ReadFile(s.settingsService.GetString(SettingVerifactuCertPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Verifactu certificate: %w", err)
+	}
+	key, cert, chain, err := DecodePKCS12(data, s.settingsService.GetString(SettingVerifactuCertPassword))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Verifactu certificate: %w", err)
+	}
+	certificate := tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key, Leaf: cert}
+	for _, c := range chain {
+		certificate.Certificate = append(certificate.Certificate, c.Raw)
+	}
+	return &http.Client{
+		Timeout: 60 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12},
+		},
+	}, nil
+}
+
+// call posts the records of an issuer to the Verifactu web service
+func (s *VerifactuService) call(client *http.Client, records []*models.VerifactuRecord) (*verifactuResponse, error) {
+	request := verifactuEnvelope{
+		SoapNamespace:   verifactuSoapNamespace,
+		LRNamespace:     verifactuLRNamespace,
+		RecordNamespace: verifactuRecordNamespace,
+	}
+	request.Body.Request.IssuerName = records[0].IssuerName
+	request.Body.Request.IssuerNIF = records[0].IssuerNIF
+	for _, record := range records {
+		request.Body.Request.Records = append(request.Body.Request.Records, verifactuRawRecord{XML: record.XML})
+	}
+	body, err := xml.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write Verifactu request: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, s.settingsService.GetString(SettingVerifactuAPIURL), bytes.NewReader(append([]byte(xml.Header), body...)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", "")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("AEAT could not be reached: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read AEAT response: %w", err)
+	}
+
+	var response verifactuResponse
+	xml.Unmarshal(data, &response)
+	if response.Fault != nil {
+		return nil, fmt.Errorf("AEAT refused the request: %s", strings.TrimSpace(response.Fault.Message))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("AEAT answered %s", resp.Status)
+	}
+	if response.Status == "" {
+		return nil, errors.New("failed to parse AEAT response")
+	}
+	return &response, nil
+}
+
+// verifactuEnvelope is a request to register records with AEAT. The records
+// are sent as they were stored, since their hashes cover them.
+type verifactuEnvelope struct {
+	XMLName         xml.Name `xml:"soapenv:Envelope"`
+	SoapNamespace   string   `xml:"xmlns:soapenv,attr"`
+	LRNamespace     string   `xml:"xmlns:sum,attr"`
+	RecordNamespace string   `xml:"xmlns:sum1,attr"`
+	Header          struct{} `xml:"soapenv:Header"`
+	Body            struct {
+		Request struct {
+			IssuerName string               `xml:"sum:Cabecera>sum1:ObligadoEmision>sum1:NombreRazon"`
+			IssuerNIF  string               `xml:"sum:Cabecera>sum1:ObligadoEmision>sum1:NIF"`
+			Records    []verifactuRawRecord `xml:"sum:RegistroFactura"`
+		} `xml:"sum:RegFactuSistemaFacturacion"`
+	} `xml:"soapenv:Body"`
+}
+
+type verifactuRawRecord struct {
+	XML string `xml:",innerxml"`
+}
+
+// verifactuResponse is AEAT's answer to a request, or the fault it raised
+type verifactuResponse struct {
+	Fault *struct {
+		Message string `xml:"faultstring"`
+	} `xml:"Body>Fault"`
+	CSV    string                  `xml:"Body>RespuestaRegFactuSistemaFacturacion>CSV"`
+	Wait   int                     `xml:"Body>RespuestaRegFactuSistemaFacturacion>TiempoEsperaEnvio"` // Seconds to wait before the next request
+	Status string                  `xml:"Body>RespuestaRegFactuSistemaFacturacion>EstadoEnvio"`       // Correcto, ParcialmenteCorrecto or Incorrecto
+	Lines  []verifactuResponseLine `xml:"Body>RespuestaRegFactuSistemaFacturacion>RespuestaLinea"`
+}
+
+type verifactuResponseLine struct {
+	InvoiceNumber string `xml:"IDFactura>NumSerieFactura"`
+	IssueDate     string `xml:"IDFactura>FechaExpedicionFactura"`
+	Status        string `xml:"EstadoRegistro"` // Correcto, AceptadoConErrores or Incorrecto
+	ErrorCode     string `xml:"CodigoErrorRegistro"`
+	ErrorMessage  string `xml:"DescripcionErrorRegistro"`
+}
+
+// line returns the answer to a record, or nil if there is none
+func (r *verifactuResponse) line(record *models.VerifactuRecord) *verifactuResponseLine {
+	for i, line := range r.Lines {
+		if line.InvoiceNumber == record.InvoiceNumber && line.IssueDate == record.IssueDate {
+			return &r.Lines[i]
+		}
+	}
+	return nil
+}
+
+// verifactuRegistration is a record being created, with its element
+type verifactuRegistration struct {
+	models.VerifactuRecord
+	Element *verifactuRegistroAlta
+}
+
+// verifactuRegistroAlta is the record of an issued invoice as sent to AEAT
+type verifactuRegistroAlta struct {
+	XMLName       xml.Name                   `xml:"sum1:RegistroAlta"`
+	Version       string                     `xml:"sum1:IDVersion"`
+	IssuerNIF     string                     `xml:"sum1:IDFactura>sum1:IDEmisorFactura"`
+	InvoiceNumber string                     `xml:"sum1:IDFactura>sum1:NumSerieFactura"`
+	IssueDate     string                     `xml:"sum1:IDFactura>sum1:FechaExpedicionFactura"`
+	IssuerName    string                     `xml:"sum1:NombreRazonEmisor"`
+	Subsanacion   string                     `xml:"sum1:Subsanacion,omitempty"`   // S when the record corrects an earlier one
+	RechazoPrevio string                     `xml:"sum1:RechazoPrevio,omitempty"` // X when AEAT rejected the earlier one
+	InvoiceType   string                     `xml:"sum1:TipoFactura"`
+	Description   string                     `xml:"sum1:DescripcionOperacion"`
+	Recipient     *verifactuRecipient        `xml:"sum1:Destinatarios>sum1:IDDestinatario,omitempty"`
+	Breakdown     verifactuBreakdown         `xml:"sum1:Desglose>sum1:DetalleDesglose"`
+	VatTotal      string                     `xml:"sum1:CuotaTotal"`
+	Total         string                     `xml:"sum1:ImporteTotal"`
+	FirstRecord   string                     `xml:"sum1:Encadenamiento>sum1:PrimerRegistro,omitempty"`
+	Previous      *verifactuPreviousRecord   `xml:"sum1:Encadenamiento>sum1:RegistroAnterior,omitempty"`
+	System        verifactuInvoicingSoftware `xml:"sum1:SistemaInformatico"`
+	GeneratedAt   string                     `xml:"sum1:FechaHoraHusoGenRegistro"`
+	HashType      string                     `xml:"sum1:TipoHuella"`
+	Hash          string                     `xml:"sum1:Huella"`
+}
+
+type verifactuRecipient struct {
+	Name  string            `xml:"sum1:NombreRazon"`
+	NIF   string            `xml:"sum1:NIF,omitempty"`
+	Other *verifactuOtherID `xml:"sum1:IDOtro,omitempty"`
+}
+
+// verifactuOtherID identifies a recipient without a Spanish NIF
+type verifactuOtherID struct {
+	Country string `xml:"sum1:CodigoPais"`
+	Type    string `xml:"sum1:IDType"` // 02 for an EU VAT ID, 06 for another document
+	ID      string `xml:"sum1:ID"`
+}
+
+type verifactuBreakdown struct {
+	Tax       string `xml:"sum1:Impuesto"`                        // 01 for VAT
+	Regime    string `xml:"sum1:ClaveRegimen"`                    // 01 for the general regime
+	Qualifies string `xml:"sum1:CalificacionOperacion,omitempty"` // S1 with VAT, S2 reverse charge, N1/N2 outside its scope
+	Exemption string `xml:"sum1:OperacionExenta,omitempty"`       // E1 for exempt supplies
+	Rate      string `xml:"sum1:TipoImpositivo,omitempty"`
+	Base      string `xml:"sum1:BaseImponibleOimporteNoSujeto"`
+	Vat       string `xml:"sum1:CuotaRepercutida,omitempty"`
+}
+
+type verifactuPreviousRecord struct {
+	IssuerNIF     string `xml:"sum1:IDEmisorFactura"`
+	InvoiceNumber string `xml:"sum1:NumSerieFactura"`
+	IssueDate     string `xml:"sum1:FechaExpedicionFactura"`
+	Hash          string `xml:"sum1:Huella"`
+}
+
+type verifactuInvoicingSoftware struct {
+	Name              string `xml:"sum1:NombreRazon"`
+	NIF               string `xml:"sum1:NIF"`
+	SystemName        string `xml:"sum1:NombreSistemaInformatico"`
+	SystemID          string `xml:"sum1:IdSistemaInformatico"`
+	Version           string `xml:"sum1:Version"`
+	Installation      string `xml:"sum1:NumeroInstalacion"`
+	VerifactuOnly     string `xml:"sum1:TipoUsoPosibleSoloVerifactu"`
+	MultipleTaxpayers string `xml:"sum1:TipoUsoPosibleMultiOT"`
+	HasMultiple       string `xml:"sum1:IndicadorMultiplesOT"`
+}
+
+// newVerifactuRecord builds the record of an invoice, checking the data AEAT
+// requires. It is completed by chain. The invoice has a single VAT rate, so
+// the breakdown has one line.
+func newVerifactuRecord(invoice *models.Invoice, items []models.InvoiceItem, business *models.Business, client *models.Client, version string) (*verifactuRegistration, error) {
+	nif := verifactuNIF(business)
+	switch {
+	case nif == "":
+		return nil, fmt.Errorf("%w: only invoices of businesses with an ES VAT ID get records", ErrVerifactuInvalid)
+	case !isBooked(invoice):
+		return nil, fmt.Errorf("%w: drafts and pro-forma invoices get no record", ErrVerifactuInvalid)
+	case invoice.HasMixedVat(items):
+		return nil, fmt.Errorf("%w: items outside the VAT base cannot be combined with VAT", ErrVerifactuInvalid)
+	case invoice.IsStorno():
+		return nil, fmt.Errorf("%w: cancellation invoices get no record", ErrVerifactuInvalid)
+	case invoice.InvoiceNumber == "" || len([]rune(invoice.InvoiceNumber)) > 60:
+		return nil, fmt.Errorf("%w: the invoice number needs 1 to 60 characters", ErrVerifactuInvalid)
+	case len(items) == 0:
+		return nil, fmt.Errorf("%w: the invoice has no items", ErrVerifactuInvalid)
+	}
+	totals := invoice.CalculateTotals(items)
+	base, vat, err := verifactuEuros(invoice, totals.Subtotal, totals.VatAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	var descriptions []string
+	for _, item := range items {
+		descriptions = append(descriptions, item.Description)
+	}
+	element := &verifactuRegistroAlta{
+		Version:       "1.0",
+		IssuerNIF:     nif,
+		InvoiceNumber: invoice.InvoiceNumber,
+		IssueDate:     invoice.IssueDate.Format("02-01-2006"),
+		IssuerName:    fatturaPAText(business.Name, 120),
+		InvoiceType:   "F1",
+		Description:   fatturaPAText(strings.Join(descriptions, "; "), 500),
+		VatTotal:      vat.String(),
+		Total:         (base + vat).String(),
+		System: verifactuInvoicingSoftware{
+			Name:              fatturaPAText(business.Name, 120),
+			NIF:               nif,
+			SystemName:        "Simple Invoice",
+			SystemID:          "SI",
+			Version:           fatturaPAText(version, 50),
+			Installation:      "1",
+			VerifactuOnly:     "S",
+			MultipleTaxpayers: "S",
+			HasMultiple:       "N",
+		},
+		HashType: "01",
+	}
+	if element.IssuerName == "" {
+		return nil, fmt.Errorf("%w: the business needs a name", ErrVerifactuInvalid)
+	}
+	if element.Description == "" {
+		element.Description = "Factura"
+	}
+
+	// Invoices to clients without a tax ID are simplified invoices
+	country := refdata.NormalizeCountryCode(clientCountryCode(client))
+	vatID := compactUpper(client.VatID)
+	switch {
+	case vatID == "":
+		element.InvoiceType = "F2"
+	case country == "ES":
+		element.Recipient = &verifactuRecipient{Name: fatturaPAText(client.Name, 120), NIF: strings.TrimPrefix(vatID, "ES")}
+	case refdata.IsEUMember(country, invoice.IssueDate):
+		if vatID[0] >= '0' && vatID[0] <= '9' {
+			vatID = country + vatID
+		}
+		element.Recipient = &verifactuRecipient{Name: fatturaPAText(client.Name, 120), Other: &verifactuOtherID{Country: country, Type: "02", ID: fatturaPAText(vatID, 20)}}
+	case country != "":
+		element.Recipient = &verifactuRecipient{Name: fatturaPAText(client.Name, 120), Other: &verifactuOtherID{Country: country, Type: "06", ID: fatturaPAText(vatID, 20)}}
+	default:
+		return nil, fmt.Errorf("%w: the client needs a country", ErrVerifactuInvalid)
+	}
+	if element.Recipient != nil && element.Recipient.Name == "" {
+		return nil, fmt.Errorf("%w: the client needs a name", ErrVerifactuInvalid)
+	}
+
+	// Invoices without VAT name the reason
+	breakdown := verifactuBreakdown{Tax: "01", Regime: "01", Qualifies: "S1", Rate: fmt.Sprintf("%.2f", invoice.VatRate), Base: base.String(), Vat: vat.String()}
+	switch {
+	case invoice.ReverseChargeVat && country == "ES":
+		breakdown = verifactuBreakdown{Tax: "01", Regime: "01", Qualifies: "S2", Rate: "0.00", Base: base.String(), Vat: "0.00"}
+	case invoice.ReverseChargeVat || (vat == 0 && country != "" && country != "ES"):
+		breakdown = verifactuBreakdown{Tax: "01", Regime: "01", Qualifies: "N2", Base: base.String()}
+	case vat == 0 && business.VatExempt:
+		breakdown = verifactuBreakdown{Tax: "01", Regime: "01", Exemption: "E1", Base: base.String()}
+	case vat == 0:
+		breakdown = verifactuBreakdown{Tax: "01", Regime: "01", Qualifies: "N1", Base: base.String()}
+	}
+	element.Breakdown = breakdown
+
+	return &verifactuRegistration{
+		VerifactuRecord: models.VerifactuRecord{
+			InvoiceID:     invoice.ID,
+			IssuerNIF:     nif,
+			IssuerName:    element.IssuerName,
+			InvoiceNumber: element.InvoiceNumber,
+			IssueDate:     element.IssueDate,
+			InvoiceType:   element.InvoiceType,
+			VatTotal:      element.VatTotal,
+			Total:         element.Total,
+		},
+		Element: element,
+	}, nil
+}
+
+// chain links the record to the previous record of its issuer, nil for the
+// first, hashes it as generated at now and returns its element
+func (r *verifactuRegistration) chain(previous *models.VerifactuRecord, now time.Time) (string, error) {
+	r.GeneratedAt = now.Truncate(time.Second).Format(verifactuTimeLayout)
+	r.Element.GeneratedAt = r.GeneratedAt
+	if r.Correction {
+		r.Element.Subsanacion = "S"
+	}
+	if previous == nil {
+		r.Element.FirstRecord = "S"
+	} else {
+		r.PreviousHash = previous.Hash
+		r.Element.Previous = &verifactuPreviousRecord{
+			IssuerNIF:     previous.IssuerNIF,
+			InvoiceNumber: previous.InvoiceNumber,
+			IssueDate:     previous.IssueDate,
+			Hash:          previous.Hash,
+		}
+	}
+	r.Hash = verifactuHash(&r.VerifactuRecord)
+	r.Element.Hash = r.Hash
+
+	data, err := xml.Marshal(r.Element)
+	if err != nil {
+		return "", fmt.Errorf("failed to write Verifactu record: %w", err)
+	}
+	return string(data), nil
+}
+
+// verifactuHash returns the hash (huella) of a record: the upper-case SHA-256
+// of its fields and the hash of the previous record, as AEAT specifies
+func verifactuHash(record *models.VerifactuRecord) string {
+	fields := []string{
+		"IDEmisorFactura=" + record.IssuerNIF,
+		"NumSerieFactura=" + strings.TrimSpace(record.InvoiceNumber),
+		"FechaExpedicionFactura=" + record.IssueDate,
+		"TipoFactura=" + record.InvoiceType,
+		"CuotaTotal=" + record.VatTotal,
+		"ImporteTotal=" + record.Total,
+		"Huella=" + record.PreviousHash,
+		"FechaHoraHusoGenRegistro=" + record.GeneratedAt,
+	}
+	hash := sha256.Sum256([]byte(strings.Join(fields, "&")))
+	return strings.ToUpper(hex.EncodeToString(hash[:]))
+}
+
+// verifactuEuros converts the taxable amount and VAT of an invoice to euros,
+// which records are kept in, using the rate of the invoice
+func verifactuEuros(invoice *models.Invoice, base, vat models.Money) (models.Money, models.Money, error) {
+	switch {
+	case invoice.Currency == "EUR":
+		return base, vat, nil
+	case invoice.HasExchangeRate() && invoice.HomeCurrency == "EUR":
+		return invoice.ToHomeCurrency(base), invoice.ToHomeCurrency(vat), nil
+	}
+	return 0, 0, fmt.Errorf("%w: invoices in %s need a euro exchange rate", ErrVerifactuInvalid, invoice.Currency)
+}
+
+// verifactuQRURL returns the URL the QR code of an invoice holds, which
+// checks it with AEAT, or an empty string when it carries none: when
+// Verifactu is disabled, or the invoice gets no record
+func verifactuQRURL(settingsService *SettingsService, invoice *models.Invoice, business *models.Business) string {
+	if settingsService == nil || !settingsService.GetBool(SettingVerifactuEnabled) || !isBooked(invoice) {
+		return ""
+	}
+	nif := verifactuNIF(business)
+	if nif == "" {
+		return ""
+	}
+	from, err := time.Parse("2006-01-02", settingsService.GetString(SettingVerifactuFrom))
+	if err != nil {
+		from = time.Now().Truncate(24 * time.Hour)
+	}
+	if invoice.IssueDate.Before(from) {
+		return ""
+	}
+	base, vat, err := verifactuEuros(invoice, invoice.TotalAmount-invoice.VatAmount, invoice.VatAmount)
+	if err != nil {
+		return ""
+	}
+	// The parameters are in the order AEAT specifies
+	query := "nif=" + url.QueryEscape(nif) +
+		"&numserie=" + url.QueryEscape(invoice.InvoiceNumber) +
+		"&fecha=" + invoice.IssueDate.Format("02-01-2006") +
+		"&importe=" + (base + vat).String()
+	return settingsService.GetString(SettingVerifactuQRURL) + "?" + query
+}
+
+// verifactuQRCode encodes the QR code of an invoice as a PNG, nil when it
+// carries none
+func verifactuQRCode(settingsService *SettingsService, invoice *models.Invoice, business *models.Business) ([]byte, error) {
+	link := verifactuQRURL(settingsService, invoice, business)
+	if link == "" {
+		return nil, nil
+	}
+	qr, err := qrcode.Encode(link, qrcode.Medium, 256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode Verifactu QR code: %w", err)
+	}
+	return qr, nil
+}
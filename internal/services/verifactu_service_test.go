@@ -0,0 +1,219 @@
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0dragosh/simple-invoice/internal/models"
+)
+
+// verifactuTestRequest holds the parts of Verifactu requests the test server checks
+type verifactuTestRequest struct {
+	IssuerNIF string `xml:"Body>RegFactuSistemaFacturacion>Cabecera>ObligadoEmision>NIF"`
+	Records   []struct {
+		InvoiceNumber string `xml:"RegistroAlta>IDFactura>NumSerieFactura"`
+		IssueDate     string `xml:"RegistroAlta>IDFactura>FechaExpedicionFactura"`
+		Subsanacion   string `xml:"RegistroAlta>Subsanacion"`
+		RechazoPrevio string `xml:"RegistroAlta>RechazoPrevio"`
+		RecipientNIF  string `xml:"RegistroAlta>Destinatarios>IDDestinatario>NIF"`
+		Qualifies     string `xml:"RegistroAlta>Desglose>DetalleDesglose>CalificacionOperacion"`
+		Total         string `xml:"RegistroAlta>ImporteTotal"`
+		FirstRecord   string `xml:"RegistroAlta>Encadenamiento>PrimerRegistro"`
+		PreviousHash  string `xml:"RegistroAlta>Encadenamiento>RegistroAnterior>Huella"`
+		Hash          string `xml:"RegistroAlta>Huella"`
+	} `xml:"Body>RegFactuSistemaFacturacion>RegistroFactura"`
+}
+
+func TestVerifactu(t *testing.T) {
+	dbService, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	business := &models.Business{Name: "García S.L.", Address: "Calle Mayor 1", City: "Madrid", PostalCode: "28013", Country: "ES",
+		VatID: "ESB12345678", Currency: "EUR"}
+	if err := dbService.SaveBusiness(business); err != nil {
+		t.Fatalf("Failed to save business: %v", err)
+	}
+	clients := []*models.Client{
+		{Name: "López S.A.", Address: "Gran Vía 2", City: "Madrid", PostalCode: "28013", Country: "ES", VatID: "ESA87654321"},
+		{Name: "Acme GmbH", Address: "Hauptstr. 1", City: "Berlin", PostalCode: "10115", Country: "DE", VatID: "DE123456789"},
+	}
+	for _, client := range clients {
+		if err := dbService.SaveClient(client); err != nil {
+			t.Fatalf("Failed to save client: %v", err)
+		}
+	}
+	issued := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	invoices := []*models.Invoice{
+		{InvoiceNumber: "2024/1", ClientID: clients[0].ID, Currency: "EUR", VatRate: 21, Status: "sent"},
+		{InvoiceNumber: "2024/2", ClientID: clients[1].ID, Currency: "EUR", ReverseChargeVat: true, Status: "sent"},
+		{InvoiceNumber: "2024/3", ClientID: clients[0].ID, Currency: "EUR", VatRate: 21, Status: "draft"},
+		{InvoiceNumber: "2023/9", ClientID: clients[0].ID, Currency: "EUR", VatRate: 21, Status: "paid", IssueDate: issued.AddDate(-1, 0, 0)},
+	}
+	for _, invoice := range invoices {
+		if invoice.IssueDate.IsZero() {
+			invoice.IssueDate = issued
+		}
+		invoice.BusinessID, invoice.DueDate = business.ID, invoice.IssueDate.AddDate(0, 0, 15)
+		items := []models.InvoiceItem{
+			{Description: "Consultoría", Quantity: 10, Unit: models.UnitHours, UnitPrice: models.NewMoney(100)},
+			{Description: "Taller", Quantity: 1, Unit: models.UnitFlat, UnitPrice: models.NewMoney(500), DiscountPercent: 10},
+		}
+		invoice.ApplyTotals(items)
+		if err := dbService.SaveInvoice(invoice, items); err != nil {
+			t.Fatalf("Failed to save invoice: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	var requests []verifactuTestRequest
+	fault := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		if fault {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `<env:Envelope xmlns:env="http://schemas.xmlsoap.org/soap/envelope/"><env:Body><env:Fault><faultcode>env:Server</faultcode><faultstring>Codigo[103].Servicio no disponible</faultstring></env:Fault></env:Body></env:Envelope>`)
+			return
+		}
+		var request verifactuTestRequest
+		if err := xml.Unmarshal(body, &request); err != nil || request.IssuerNIF != "B12345678" {
+			t.Errorf("Unexpected request (%v):\n%s", err, body)
+		}
+		requests = append(requests, request)
+
+		// The reverse charge invoice is rejected until it is corrected
+		status, lines := "Correcto", ""
+		for _, record := range request.Records {
+			line := `<tikR:EstadoRegistro>Correcto</tikR:EstadoRegistro>`
+			if record.InvoiceNumber == "2024/2" && record.Subsanacion == "" {
+				status = "ParcialmenteCorrecto"
+				line = `<tikR:EstadoRegistro>Incorrecto</tikR:EstadoRegistro><tikR:CodigoErrorRegistro>1105</tikR:CodigoErrorRegistro><tikR:DescripcionErrorRegistro>Destinatario incorrecto</tikR:DescripcionErrorRegistro>`
+			}
+			lines += fmt.Sprintf(`<tikR:RespuestaLinea><tikR:IDFactura><tik:IDEmisorFactura>B12345678</tik:IDEmisorFactura><tik:NumSerieFactura>%s</tik:NumSerieFactura>
+				<tik:FechaExpedicionFactura>%s</tik:FechaExpedicionFactura></tikR:IDFactura>%s</tikR:RespuestaLinea>`, record.InvoiceNumber, record.IssueDate, line)
+		}
+		fmt.Fprintf(w, `<env:Envelope xmlns:env="http://schemas.xmlsoap.org/soap/envelope/"><env:Body>
+			<tikR:RespuestaRegFactuSistemaFacturacion xmlns:tikR="urn:respuesta" xmlns:tik="urn:informacion">
+			<tikR:CSV>CSV%d</tikR:CSV><tikR:TiempoEsperaEnvio>60</tikR:TiempoEsperaEnvio><tikR:EstadoEnvio>%s</tikR:EstadoEnvio>%s
+			</tikR:RespuestaRegFactuSistemaFacturacion></env:Body></env:Envelope>`, len(requests), status, lines)
+	}))
+	defer server.Close()
+
+	logger := NewLogger(ERROR)
+	settings := NewSettingsService(dbService, logger)
+	verifactu := NewVerifactuService(dbService, settings, "1.0", logger)
+	if _, err := verifactu.Submit(invoices[0].ID); !errors.Is(err, ErrVerifactuNotConfigured) {
+		t.Fatalf("Expected ErrVerifactuNotConfigured, got %v", err)
+	}
+	if err := settings.SetMany(map[string]string{SettingVerifactuEnabled: "true", SettingVerifactuFrom: "2024-01-01", SettingVerifactuAPIURL: server.URL}); err != nil {
+		t.Fatalf("Failed to configure Verifactu: %v", err)
+	}
+
+	// Records are created for finalized invoices issued since the start date,
+	// chained in the order the invoices were issued, and kept until a
+	// certificate is set
+	if err := verifactu.Process(); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(requests) != 0 {
+		t.Fatalf("Expected no request without a certificate, got %d", len(requests))
+	}
+	first, err := verifactu.Latest(invoices[0].ID)
+	if err != nil || first == nil || first.Status != models.VerifactuPending || first.PreviousHash != "" || first.InvoiceType != "F1" ||
+		first.IssueDate != "01-03-2024" || first.VatTotal != "304.50" || first.Total != "1754.50" {
+		t.Fatalf("Unexpected record of invoice 2024/1: %+v (%v)", first, err)
+	}
+	second, err := verifactu.Latest(invoices[1].ID)
+	if err != nil || second == nil || second.PreviousHash != first.Hash || second.VatTotal != "0.00" || second.Total != "1450.00" {
+		t.Fatalf("Expected the record of invoice 2024/2 to chain to 2024/1, got %+v (%v)", second, err)
+	}
+	for _, record := range []*models.VerifactuRecord{first, second} {
+		if hash := verifactuHash(record); record.Hash != hash || len(hash) != 64 {
+			t.Errorf("Expected hash %s, got %s", hash, record.Hash)
+		}
+	}
+	for _, invoice := range invoices[2:] {
+		if record, err := verifactu.Latest(invoice.ID); err != nil || record != nil {
+			t.Errorf("Expected invoice %s to get no record, got %+v (%v)", invoice.InvoiceNumber, record, err)
+		}
+	}
+
+	// Once a certificate is set, pending records are sent in one request
+	cert, err := filepath.Abs(filepath.Join("testdata", "signing.p12"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := settings.SetMany(map[string]string{SettingVerifactuCertPath: cert, SettingVerifactuCertPassword: "secret"}); err != nil {
+		t.Fatalf("Failed to set the certificate: %v", err)
+	}
+	if err := verifactu.Process(); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(requests) != 1 || len(requests[0].Records) != 2 {
+		t.Fatalf("Expected one request with 2 records, got %+v", requests)
+	}
+	sent := requests[0].Records
+	if sent[0].FirstRecord != "S" || sent[0].RecipientNIF != "A87654321" || sent[0].Qualifies != "S1" || sent[0].Hash != first.Hash ||
+		sent[1].PreviousHash != first.Hash || sent[1].Qualifies != "N2" || sent[1].Total != "1450.00" {
+		t.Errorf("Unexpected records sent: %+v", sent)
+	}
+	if record, err := verifactu.Latest(invoices[0].ID); err != nil || record.Status != models.VerifactuAccepted || record.CSV != "CSV1" || record.SentAt.IsZero() {
+		t.Errorf("Expected the record of invoice 2024/1 accepted, got %+v (%v)", record, err)
+	}
+	rejected, err := verifactu.Latest(invoices[1].ID)
+	if err != nil || rejected.Status != models.VerifactuRejected || rejected.Message != "1105: Destinatario incorrecto" {
+		t.Errorf("Expected the record of invoice 2024/2 rejected, got %+v (%v)", rejected, err)
+	}
+	if _, err := verifactu.Submit(invoices[0].ID); !errors.Is(err, ErrVerifactuInvalid) {
+		t.Errorf("Expected accepted invoices to be refused, got %v", err)
+	}
+
+	// A rejected invoice gets a correcting record, sent once AEAT's wait is over
+	correction, err := verifactu.Submit(invoices[1].ID)
+	if err != nil || correction.ID == rejected.ID || !correction.Correction || correction.Status != models.VerifactuPending || correction.PreviousHash != rejected.Hash {
+		t.Fatalf("Expected a pending correction chained to the rejected record, got %+v (%v)", correction, err)
+	}
+	if len(requests) != 1 {
+		t.Errorf("Expected AEAT's wait to be respected, got %d requests", len(requests))
+	}
+	verifactu.nextSend = time.Time{}
+	if err := verifactu.Process(); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(requests) != 2 || len(requests[1].Records) != 1 || requests[1].Records[0].Subsanacion != "S" || requests[1].Records[0].RechazoPrevio != "X" {
+		t.Fatalf("Expected the correction to be sent, got %+v", requests)
+	}
+	if record, err := verifactu.Latest(invoices[1].ID); err != nil || record.Status != models.VerifactuAccepted || record.CSV != "CSV2" {
+		t.Errorf("Expected the correction accepted, got %+v (%v)", record, err)
+	}
+
+	// Failed attempts stay pending and are retried later
+	if err := dbService.UpdateInvoiceStatus(invoices[2].ID, "sent", time.Time{}); err != nil {
+		t.Fatalf("Failed to finalize invoice: %v", err)
+	}
+	fault, verifactu.nextSend = true, time.Time{}
+	record, err := verifactu.Submit(invoices[2].ID)
+	if err != nil || record.Status != models.VerifactuPending || record.Attempts != 1 || !strings.Contains(record.Message, "Servicio no disponible") ||
+		!record.NextAttemptAt.After(time.Now()) || record.PreviousHash != correction.Hash {
+		t.Errorf("Expected a failed attempt to stay pending, got %+v (%v)", record, err)
+	}
+
+	// The QR code of an invoice checks it with AEAT
+	want := "https://www2.agenciatributaria.gob.es/wlpl/TIKE-CONT/ValidarQR?nif=B12345678&numserie=2024%2F1&fecha=01-03-2024&importe=1754.50"
+	if link := verifactuQRURL(settings, invoices[0], business); link != want {
+		t.Errorf("Expected QR code URL %s, got %s", want, link)
+	}
+	if link := verifactuQRURL(settings, invoices[3], business); link != "" {
+		t.Errorf("Expected no QR code for invoices issued before the start date, got %s", link)
+	}
+}